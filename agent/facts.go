@@ -0,0 +1,217 @@
+// Package agent gathers the facts of the host the zebra agent runs on, which
+// the agent reports to the server to register the host as a resource.
+//
+// Facts are read from the files Linux exposes under /etc, /proc and /sys. A
+// fact that can not be read is left empty, the server decides which facts
+// a registration needs.
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var ErrNoIdentity = errors.New("host facts have neither a machine ID nor a serial number")
+
+var ErrNoHostname = errors.New("host facts have no hostname")
+
+// Files the facts are read from, relative to the root of the host.
+const (
+	MachineIDFile = "etc/machine-id"
+	OSReleaseFile = "etc/os-release"
+	KernelFile    = "proc/sys/kernel/osrelease"
+	SerialFile    = "sys/class/dmi/id/product_serial"
+	ModelFile     = "sys/class/dmi/id/product_name"
+	VendorFile    = "sys/class/dmi/id/sys_vendor"
+)
+
+// NIC is a network interface of a host.
+type NIC struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// Facts describe a host. The machine ID identifies the host across reboots
+// and reinstalls of the agent, hosts without one are identified by their
+// serial number.
+type Facts struct {
+	Hostname  string `json:"hostname"`
+	MachineID string `json:"machineId,omitempty"`
+	Serial    string `json:"serial,omitempty"`
+	Vendor    string `json:"vendor,omitempty"`
+	Model     string `json:"model,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Kernel    string `json:"kernel,omitempty"`
+	Arch      string `json:"arch,omitempty"`
+	NICs      []NIC  `json:"nics,omitempty"`
+}
+
+// Validate returns an error if the facts can not identify the host.
+func (f *Facts) Validate() error {
+	switch {
+	case f.Hostname == "":
+		return ErrNoHostname
+	case f.MachineID == "" && f.Serial == "":
+		return ErrNoIdentity
+	}
+
+	return nil
+}
+
+// ID returns the identity of the host: its machine ID, else its serial number.
+func (f *Facts) ID() string {
+	if f.MachineID != "" {
+		return f.MachineID
+	}
+
+	return f.Serial
+}
+
+// MACs returns the MAC addresses of the NICs of the host.
+func (f *Facts) MACs() []string {
+	macs := []string{}
+
+	for _, nic := range f.NICs {
+		if nic.MAC != "" {
+			macs = append(macs, nic.MAC)
+		}
+	}
+
+	return macs
+}
+
+// IPs returns the addresses of the NICs of the host, IPv4 addresses first.
+func (f *Facts) IPs() []net.IP {
+	v4 := []net.IP{}
+	v6 := []net.IP{}
+
+	for _, nic := range f.NICs {
+		for _, addr := range nic.Addresses {
+			ip := net.ParseIP(addr)
+
+			switch {
+			case ip == nil:
+				continue
+			case ip.To4() != nil:
+				v4 = append(v4, ip)
+			default:
+				v6 = append(v6, ip)
+			}
+		}
+	}
+
+	return append(v4, v6...)
+}
+
+// Gather returns the facts of the host whose file system is mounted at root,
+// "/" for the host the agent runs on. The network interfaces are always
+// those of the running host.
+func Gather(root string) (*Facts, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	nics, err := Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimSpace(string(data))
+	}
+
+	osRelease, _ := os.ReadFile(filepath.Join(root, OSReleaseFile))
+
+	return &Facts{
+		Hostname:  hostname,
+		MachineID: read(MachineIDFile),
+		Serial:    cleanDMI(read(SerialFile)),
+		Vendor:    cleanDMI(read(VendorFile)),
+		Model:     cleanDMI(read(ModelFile)),
+		OS:        ParseOSRelease(osRelease),
+		Kernel:    read(KernelFile),
+		Arch:      runtime.GOARCH,
+		NICs:      nics,
+	}, nil
+}
+
+// Interfaces returns the NICs of the running host that are up, without the
+// loopback interfaces and link-local addresses.
+func Interfaces() ([]NIC, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	nics := []NIC{}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		nic := NIC{Name: iface.Name, MAC: iface.HardwareAddr.String(), Addresses: []string{}}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				nic.Addresses = append(nic.Addresses, ipNet.IP.String())
+			}
+		}
+
+		nics = append(nics, nic)
+	}
+
+	return nics, nil
+}
+
+// ParseOSRelease returns the name of the operating system of the os-release
+// file: its PRETTY_NAME, else its NAME and VERSION.
+func ParseOSRelease(data []byte) string {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+
+		values[key] = strings.Trim(value, `"'`)
+	}
+
+	if name := values["PRETTY_NAME"]; name != "" {
+		return name
+	}
+
+	return strings.TrimSpace(values["NAME"] + " " + values["VERSION"])
+}
+
+// cleanDMI drops the placeholders firmware vendors put into DMI fields they
+// did not fill in.
+func cleanDMI(value string) string {
+	switch strings.ToLower(value) {
+	case "", "none", "not specified", "default string", "to be filled by o.e.m.", "system serial number",
+		"0123456789", "n/a":
+		return ""
+	}
+
+	return value
+}
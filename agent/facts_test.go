@@ -0,0 +1,85 @@
+package agent_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/project-safari/zebra/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal("Ubuntu 22.04.1 LTS", agent.ParseOSRelease([]byte(
+		"NAME=\"Ubuntu\"\nVERSION=\"22.04.1 LTS (Jammy Jellyfish)\"\nPRETTY_NAME=\"Ubuntu 22.04.1 LTS\"\n")))
+	assert.Equal("Fedora 36", agent.ParseOSRelease([]byte("# release\nNAME=Fedora\nVERSION='36'\n")))
+	assert.Equal("", agent.ParseOSRelease(nil))
+}
+
+func TestGather(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+
+	for name, value := range map[string]string{
+		agent.MachineIDFile: "0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f\n",
+		agent.OSReleaseFile: "PRETTY_NAME=\"Debian GNU/Linux 11 (bullseye)\"\n",
+		agent.KernelFile:    "5.10.0-18-amd64\n",
+		agent.SerialFile:    "To be filled by O.E.M.\n",
+		agent.ModelFile:     "UCSC-C220-M5SX\n",
+		agent.VendorFile:    "Cisco Systems Inc\n",
+	} {
+		assert.Nil(os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		assert.Nil(os.WriteFile(filepath.Join(root, name), []byte(value), 0o600))
+	}
+
+	facts, err := agent.Gather(root)
+	assert.Nil(err)
+	assert.Nil(facts.Validate())
+	assert.NotEmpty(facts.Hostname)
+	assert.Equal("0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f", facts.ID())
+	assert.Equal("", facts.Serial)
+	assert.Equal("UCSC-C220-M5SX", facts.Model)
+	assert.Equal("Cisco Systems Inc", facts.Vendor)
+	assert.Equal("Debian GNU/Linux 11 (bullseye)", facts.OS)
+	assert.Equal("5.10.0-18-amd64", facts.Kernel)
+
+	// Missing files leave the facts empty
+	facts, err = agent.Gather(t.TempDir())
+	assert.Nil(err)
+	assert.Equal("", facts.MachineID)
+	assert.ErrorIs(facts.Validate(), agent.ErrNoIdentity)
+
+	facts.Serial = "FCH2201V0AB"
+	assert.Nil(facts.Validate())
+	assert.Equal("FCH2201V0AB", facts.ID())
+
+	facts.Hostname = ""
+	assert.ErrorIs(facts.Validate(), agent.ErrNoHostname)
+}
+
+func TestFactsAddresses(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	facts := &agent.Facts{
+		Hostname: "lab-host-1",
+		NICs: []agent.NIC{
+			{Name: "eno1", MAC: "00:25:b5:00:00:1f", Addresses: []string{"fd00::10", "10.1.2.3"}},
+			{Name: "tun0", MAC: "", Addresses: []string{"bogus", "192.168.7.1"}},
+		},
+	}
+
+	assert.Equal([]string{"00:25:b5:00:00:1f"}, facts.MACs())
+
+	ips := []string{}
+	for _, ip := range facts.IPs() {
+		ips = append(ips, ip.String())
+	}
+
+	assert.Equal([]string{"10.1.2.3", "192.168.7.1", "fd00::10"}, ips)
+}
@@ -0,0 +1,71 @@
+package zebra
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DateFormat is the format of the dates of the asset data.
+const DateFormat = "2006-01-02"
+
+var (
+	ErrPurchaseDate = errors.New("purchase date is incorrect, must be a date such as 2006-01-02 and not in the future")
+	ErrWarrantyEnd  = errors.New("warranty end is incorrect, must be a date such as 2006-01-02")
+	ErrWarrantyDate = errors.New("warranty end is before the purchase date")
+)
+
+// Asset is the asset management data of a piece of hardware: when it was
+// bought, from whom and on whose budget, and when its warranty ends. All
+// fields are optional.
+type Asset struct {
+	PurchaseDate string `json:"purchaseDate,omitempty"`
+	CostCenter   string `json:"costCenter,omitempty"`
+	WarrantyEnd  string `json:"warrantyEnd,omitempty"`
+	Vendor       string `json:"vendor,omitempty"`
+}
+
+// AssetHolder is implemented by the resources that keep asset data.
+type AssetHolder interface {
+	GetAsset() *Asset
+}
+
+// Validate returns an error if the dates of the asset are not dates, the
+// purchase date is in the future or the warranty ends before the purchase.
+func (a *Asset) Validate(ctx context.Context) error {
+	purchased, err := parseDate(a.PurchaseDate)
+	if err != nil || purchased.After(time.Now()) {
+		return ErrPurchaseDate
+	}
+
+	warranty, err := parseDate(a.WarrantyEnd)
+	if err != nil {
+		return ErrWarrantyEnd
+	}
+
+	if !purchased.IsZero() && !warranty.IsZero() && warranty.Before(purchased) {
+		return ErrWarrantyDate
+	}
+
+	return nil
+}
+
+// Warranty returns the end of the warranty, the end of its last day, and
+// false if the asset has no warranty end.
+func (a *Asset) Warranty() (time.Time, bool) {
+	end, err := parseDate(a.WarrantyEnd)
+	if err != nil || end.IsZero() {
+		return time.Time{}, false
+	}
+
+	return end.AddDate(0, 0, 1), true
+}
+
+// parseDate returns the zero time for the empty date.
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(DateFormat, date)
+}
@@ -0,0 +1,43 @@
+package zebra_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsset(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	asset := &zebra.Asset{PurchaseDate: "", CostCenter: "cc-42", WarrantyEnd: "", Vendor: "acme"}
+	assert.Nil(asset.Validate(ctx))
+
+	_, ok := asset.Warranty()
+	assert.False(ok)
+
+	asset.PurchaseDate = "01/02/2022"
+	assert.Equal(zebra.ErrPurchaseDate, asset.Validate(ctx))
+
+	asset.PurchaseDate = time.Now().AddDate(0, 0, 2).Format(zebra.DateFormat)
+	assert.Equal(zebra.ErrPurchaseDate, asset.Validate(ctx))
+
+	asset.PurchaseDate = "2022-02-01"
+	asset.WarrantyEnd = "2025-02-30"
+	assert.Equal(zebra.ErrWarrantyEnd, asset.Validate(ctx))
+
+	asset.WarrantyEnd = "2022-01-31"
+	assert.Equal(zebra.ErrWarrantyDate, asset.Validate(ctx))
+
+	asset.WarrantyEnd = "2025-02-01"
+	assert.Nil(asset.Validate(ctx))
+
+	// The warranty covers its last day
+	ends, ok := asset.Warranty()
+	assert.True(ok)
+	assert.Equal(time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC), ends)
+}
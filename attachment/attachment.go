@@ -0,0 +1,324 @@
+// Package attachment stores small files attached to resources, such as
+// configuration backups, rack photos and data sheets.
+//
+// The files are content addressed: a file is stored once under the SHA-256
+// digest of its content, however many resources it is attached to, and is
+// removed with the last attachment referring to it.
+package attachment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	RW = os.FileMode(0o600)
+
+	// DefaultMaxSize is the largest file accepted, in bytes.
+	DefaultMaxSize = 10 << 20
+
+	// DefaultMaxCount is the number of files a resource can have attached.
+	DefaultMaxCount = 20
+)
+
+var (
+	ErrNotFound = errors.New("attachment not found")
+	ErrTooLarge = errors.New("attachment is too large")
+	ErrTooMany  = errors.New("resource has too many attachments")
+	ErrName     = errors.New("attachment needs a file name")
+)
+
+// Attachment is a file attached to a resource. The digest identifies the
+// attachment among those of the resource.
+type Attachment struct {
+	Digest      string    `json:"digest"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	CreatedBy   string    `json:"createdBy"`
+}
+
+// Store is a thread safe store of attachments in a directory: the files are
+// in the blobs directory and the attachments of the resources are written
+// to index.json on every change.
+type Store struct {
+	lock     sync.RWMutex
+	root     string
+	maxSize  int64
+	maxCount int
+	index    map[string][]Attachment
+}
+
+// NewStore returns a store in the root directory, which accepts files of up
+// to maxSize bytes and maxCount files per resource.
+func NewStore(root string, maxSize int64, maxCount int) *Store {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	if maxCount <= 0 {
+		maxCount = DefaultMaxCount
+	}
+
+	return &Store{
+		lock:     sync.RWMutex{},
+		root:     root,
+		maxSize:  maxSize,
+		maxCount: maxCount,
+		index:    make(map[string][]Attachment),
+	}
+}
+
+// Initialize creates the directories of the store and loads the index.
+func (s *Store) Initialize() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := os.MkdirAll(path.Join(s.root, "blobs"), os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	index := make(map[string][]Attachment)
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+
+	s.index = index
+
+	return nil
+}
+
+// MaxSize returns the largest file accepted, in bytes.
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Add attaches the content read from r to the resource. Attaching the same
+// content again replaces the name and type of the attachment.
+func (s *Store) Add(resID string, a Attachment, r io.Reader) (Attachment, error) {
+	if a.Name == "" {
+		return a, ErrName
+	}
+
+	tmp, err := os.CreateTemp(s.root, "upload-")
+	if err != nil {
+		return a, err
+	}
+
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+
+	// One byte more than allowed tells a file of the largest size from a
+	// larger one
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return a, err
+	}
+
+	if size > s.maxSize {
+		return a, ErrTooLarge
+	}
+
+	if err := tmp.Close(); err != nil {
+		return a, err
+	}
+
+	a.Digest = hex.EncodeToString(hash.Sum(nil))
+	a.Size = size
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attachments := s.index[resID]
+	existing := find(attachments, a.Digest)
+
+	if existing < 0 && len(attachments) >= s.maxCount {
+		return a, ErrTooMany
+	}
+
+	if _, err := os.Stat(s.blobPath(a.Digest)); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(tmp.Name(), s.blobPath(a.Digest)); err != nil {
+			return a, err
+		}
+	}
+
+	if existing < 0 {
+		attachments = append(attachments, a)
+	} else {
+		attachments = append(append([]Attachment{}, attachments[:existing]...), attachments[existing+1:]...)
+		attachments = append(attachments, a)
+	}
+
+	s.index[resID] = attachments
+
+	return a, s.save()
+}
+
+// List returns the attachments of the resource, oldest first.
+func (s *Store) List(resID string) []Attachment {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]Attachment{}, s.index[resID]...)
+}
+
+// Open returns the attachment of the resource with the digest and its file,
+// which the caller must close.
+func (s *Store) Open(resID string, digest string) (Attachment, *os.File, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	i := find(s.index[resID], digest)
+	if i < 0 {
+		return Attachment{}, nil, ErrNotFound
+	}
+
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+
+	return s.index[resID][i], f, nil
+}
+
+// Delete removes the attachment of the resource with the digest.
+func (s *Store) Delete(resID string, digest string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	i := find(s.index[resID], digest)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	s.remove(resID, i)
+
+	if err := s.save(); err != nil {
+		return err
+	}
+
+	return s.collect(digest)
+}
+
+// Prune removes the attachments created before the given time, if it is
+// not zero, and all attachments of the resources that keep returns false
+// for. It returns the number of removed attachments.
+func (s *Store) Prune(before time.Time, keep func(resID string) bool) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pruned := 0
+	digests := []string{}
+
+	for resID, attachments := range s.index {
+		kept := keep(resID)
+
+		for i := len(attachments) - 1; i >= 0; i-- {
+			if kept && (before.IsZero() || !attachments[i].Created.Before(before)) {
+				continue
+			}
+
+			digests = append(digests, attachments[i].Digest)
+			s.remove(resID, i)
+			pruned++
+		}
+	}
+
+	if pruned == 0 {
+		return 0, nil
+	}
+
+	if err := s.save(); err != nil {
+		return pruned, err
+	}
+
+	for _, digest := range digests {
+		if err := s.collect(digest); err != nil {
+			return pruned, err
+		}
+	}
+
+	return pruned, nil
+}
+
+// remove drops the i-th attachment of the resource from the index. Must be
+// called with the lock held.
+func (s *Store) remove(resID string, i int) {
+	attachments := append(append([]Attachment{}, s.index[resID][:i]...), s.index[resID][i+1:]...)
+	if len(attachments) == 0 {
+		delete(s.index, resID)
+
+		return
+	}
+
+	s.index[resID] = attachments
+}
+
+// collect removes the file with the digest if no attachment refers to it
+// anymore. Must be called with the lock held.
+func (s *Store) collect(digest string) error {
+	for _, attachments := range s.index {
+		if find(attachments, digest) >= 0 {
+			return nil
+		}
+	}
+
+	if err := os.Remove(s.blobPath(digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// save writes the index to its file. The file is replaced, so that a crash
+// never leaves a partially written index. Must be called with the lock held.
+func (s *Store) save() error {
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, RW); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.indexPath())
+}
+
+func (s *Store) indexPath() string {
+	return path.Join(s.root, "index.json")
+}
+
+func (s *Store) blobPath(digest string) string {
+	return path.Join(s.root, "blobs", digest)
+}
+
+func find(attachments []Attachment, digest string) int {
+	for i, a := range attachments {
+		if a.Digest == digest {
+			return i
+		}
+	}
+
+	return -1
+}
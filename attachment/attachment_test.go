@@ -0,0 +1,134 @@
+package attachment_test
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/attachment"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAttachment(name string, created time.Time) attachment.Attachment {
+	return attachment.Attachment{
+		Digest: "", Name: name, ContentType: "text/plain", Size: 0, Created: created, CreatedBy: "user@zebra",
+	}
+}
+
+func blobs(assert *assert.Assertions, root string) int {
+	entries, err := os.ReadDir(path.Join(root, "blobs"))
+	assert.Nil(err)
+
+	return len(entries)
+}
+
+func TestStore(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	now := time.Now()
+
+	s := attachment.NewStore(root, 16, 2)
+	assert.Nil(s.Initialize())
+	assert.Equal(int64(16), s.MaxSize())
+
+	a, err := s.Add("r1", newAttachment("running.cfg", now), strings.NewReader("hostname sw1"))
+	assert.Nil(err)
+	assert.Equal("3fcbbbbd7e418cbcbc44662d3d6bd216f8613da29bc2cb6b02907b17f859b796", a.Digest)
+	assert.Equal(int64(12), a.Size)
+
+	// The same content is stored once and replaces the attachment of the
+	// same resource
+	b, err := s.Add("r2", newAttachment("backup.cfg", now), strings.NewReader("hostname sw1"))
+	assert.Nil(err)
+	assert.Equal(a.Digest, b.Digest)
+	assert.Equal(1, blobs(assert, root))
+
+	_, err = s.Add("r1", newAttachment("startup.cfg", now), strings.NewReader("hostname sw1"))
+	assert.Nil(err)
+	assert.Equal(1, len(s.List("r1")))
+	assert.Equal("startup.cfg", s.List("r1")[0].Name)
+
+	// Limits
+	_, err = s.Add("r1", newAttachment("big", now), strings.NewReader(strings.Repeat("x", 17)))
+	assert.ErrorIs(err, attachment.ErrTooLarge)
+
+	_, err = s.Add("r1", newAttachment("", now), strings.NewReader("x"))
+	assert.ErrorIs(err, attachment.ErrName)
+
+	old, err := s.Add("r1", newAttachment("photo.jpg", now.Add(-time.Hour)), strings.NewReader(strings.Repeat("x", 16)))
+	assert.Nil(err)
+
+	_, err = s.Add("r1", newAttachment("third", now), strings.NewReader("y"))
+	assert.ErrorIs(err, attachment.ErrTooMany)
+
+	got, f, err := s.Open("r1", old.Digest)
+	assert.Nil(err)
+	assert.Equal("photo.jpg", got.Name)
+
+	data, err := io.ReadAll(f)
+	assert.Nil(err)
+	assert.Equal(16, len(data))
+	f.Close()
+
+	_, _, err = s.Open("r2", old.Digest)
+	assert.ErrorIs(err, attachment.ErrNotFound)
+
+	// The index survives a restart
+	restarted := attachment.NewStore(root, 0, 0)
+	assert.Nil(restarted.Initialize())
+	assert.Equal(attachment.DefaultMaxSize, int(restarted.MaxSize()))
+	assert.Equal(2, len(restarted.List("r1")))
+	assert.Equal(old.Digest, restarted.List("r1")[1].Digest)
+
+	// Files are removed with the last attachment referring to them
+	assert.Nil(s.Delete("r1", a.Digest))
+	assert.ErrorIs(s.Delete("r1", a.Digest), attachment.ErrNotFound)
+	assert.Equal(2, blobs(assert, root))
+
+	assert.Nil(s.Delete("r2", a.Digest))
+	assert.Equal(1, blobs(assert, root))
+	assert.Empty(s.List("r2"))
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	now := time.Now()
+
+	s := attachment.NewStore(root, 0, 0)
+	assert.Nil(s.Initialize())
+
+	_, err := s.Add("r1", newAttachment("old", now.Add(-48*time.Hour)), strings.NewReader("old"))
+	assert.Nil(err)
+
+	_, err = s.Add("r1", newAttachment("new", now), strings.NewReader("new"))
+	assert.Nil(err)
+
+	_, err = s.Add("gone", newAttachment("new", now), strings.NewReader("new"))
+	assert.Nil(err)
+
+	exists := func(resID string) bool { return resID != "gone" }
+
+	pruned, err := s.Prune(time.Time{}, exists)
+	assert.Nil(err)
+	assert.Equal(1, pruned)
+	assert.Empty(s.List("gone"))
+	assert.Equal(2, blobs(assert, root))
+
+	pruned, err = s.Prune(now.Add(-24*time.Hour), exists)
+	assert.Nil(err)
+	assert.Equal(1, pruned)
+	assert.Equal("new", s.List("r1")[0].Name)
+	assert.Equal(1, blobs(assert, root))
+
+	pruned, err = s.Prune(now.Add(-24*time.Hour), exists)
+	assert.Nil(err)
+	assert.Equal(0, pruned)
+}
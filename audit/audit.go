@@ -0,0 +1,248 @@
+// Package audit provides an append-only record of who did what to which
+// resource in zebra.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const RWRR = os.FileMode(0o644)
+
+// Actor types of audit entries. Entries without an actor type were recorded
+// for users, entries of the server itself have the system actor.
+const (
+	ActorUser    = "user"
+	ActorService = "service"
+	ActorSystem  = "system"
+)
+
+// Entry is a single audit record. Actions an admin took as another user have
+// that user as the actor and the admin as the impersonator. The hash chains
+// the entry to the one before it, see Verify.
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Actor        string    `json:"actor"`
+	ActorType    string    `json:"actorType,omitempty"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	Action       string    `json:"action"`
+	Resource     string    `json:"resource,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+}
+
+// Log is a thread safe audit log. Entries are kept in memory and, if a path
+// is given, appended to that file as one JSON object per line. Each recorded
+// entry is chained to the previous one by its hash, and with a signer the log
+// records a signed checkpoint of the chain every few entries.
+type Log struct {
+	lock     sync.RWMutex
+	path     string
+	entries  []Entry
+	head     string
+	signer   Signer
+	every    int
+	unsigned int
+}
+
+// NewLog returns a new audit log backed by the file at path. An empty path
+// results in an in-memory only log.
+func NewLog(path string) *Log {
+	return &Log{
+		lock:     sync.RWMutex{},
+		path:     path,
+		entries:  []Entry{},
+		head:     "",
+		signer:   nil,
+		every:    0,
+		unsigned: 0,
+	}
+}
+
+// Initialize loads existing entries from the backing file, if any.
+func (l *Log) Initialize() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.path == "" {
+		return nil
+	}
+
+	entries, err := ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, entries...)
+
+	// Entries recorded before the log was chained have no hash
+	for _, e := range l.entries {
+		if e.Hash == "" {
+			continue
+		}
+
+		l.head = e.Hash
+		l.unsigned++
+
+		if e.Action == ActionCheckpoint {
+			l.unsigned = 0
+		}
+	}
+
+	return nil
+}
+
+// ReadFile returns the entries of the audit log file at path.
+func ReadFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Record adds the entry to the log. If the entry has no time set, the current
+// time is used.
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.append(entry); err != nil {
+		return err
+	}
+
+	if l.signer == nil || l.unsigned < l.every {
+		return nil
+	}
+
+	checkpoint, err := l.checkpoint(entry.Time)
+	if err != nil {
+		return err
+	}
+
+	return l.append(checkpoint)
+}
+
+// append chains the entry to the head of the log and adds it to the log.
+func (l *Log) append(entry Entry) error {
+	entry.Hash = hash(l.head, entry)
+	l.head = entry.Hash
+	l.entries = append(l.entries, entry)
+	l.unsigned++
+
+	if entry.Action == ActionCheckpoint {
+		l.unsigned = 0
+	}
+
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, RWRR)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// Entries returns a copy of all entries in the log.
+func (l *Log) Entries() []Entry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	entries := make([]Entry, len(l.entries))
+	copy(entries, l.entries)
+
+	return entries
+}
+
+// Since returns up to limit entries after the first cursor entries of the
+// log, and the cursor after them. Entries are never dropped from the log, so
+// a cursor stays valid.
+func (l *Log) Since(cursor uint64, limit int) ([]Entry, uint64) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if cursor >= uint64(len(l.entries)) {
+		return []Entry{}, cursor
+	}
+
+	end := uint64(len(l.entries))
+	if limit > 0 && cursor+uint64(limit) < end {
+		end = cursor + uint64(limit)
+	}
+
+	entries := make([]Entry, end-cursor)
+	copy(entries, l.entries[cursor:end])
+
+	return entries, end
+}
+
+// QueryActorType returns all entries of actors of the given type.
+func (l *Log) QueryActorType(actorType string) []Entry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	entries := []Entry{}
+
+	for _, e := range l.entries {
+		if e.ActorType == actorType || (e.ActorType == "" && actorType == ActorUser) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Query returns all entries for the given resource ID.
+func (l *Log) Query(resID string) []Entry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	entries := []Entry{}
+
+	for _, e := range l.entries {
+		if e.Resource == resID {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
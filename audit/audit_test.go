@@ -0,0 +1,83 @@
+package audit_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLog(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	log := audit.NewLog("")
+	assert.Nil(log.Initialize())
+	assert.Empty(log.Entries())
+
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "create", Resource: "r1"}))
+	assert.Nil(log.Record(audit.Entry{Actor: "b@zebra", Action: "delete", Resource: "r2"}))
+
+	entries := log.Entries()
+	assert.Equal(2, len(entries))
+	assert.False(entries[0].Time.IsZero())
+
+	assert.Equal(1, len(log.Query("r1")))
+	assert.Empty(log.Query("r3"))
+
+	page, cursor := log.Since(0, 1)
+	assert.Equal("r1", page[0].Resource)
+	assert.Equal(uint64(1), cursor)
+
+	page, cursor = log.Since(cursor, 0)
+	assert.Equal("r2", page[0].Resource)
+	assert.Equal(uint64(2), cursor)
+
+	page, cursor = log.Since(cursor, 0)
+	assert.Empty(page)
+	assert.Equal(uint64(2), cursor)
+}
+
+func TestFileLog(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	path := "test_audit.log"
+
+	t.Cleanup(func() { os.Remove(path) })
+
+	log := audit.NewLog(path)
+	assert.Nil(log.Initialize())
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "create", Resource: "r1"}))
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "update", Resource: "r1"}))
+
+	// Reload from the file
+	log = audit.NewLog(path)
+	assert.Nil(log.Initialize())
+	assert.Equal(2, len(log.Query("r1")))
+
+	// Corrupt file
+	assert.Nil(os.WriteFile(path, []byte("not json\n"), audit.RWRR))
+
+	log = audit.NewLog(path)
+	assert.NotNil(log.Initialize())
+}
+
+func TestQueryActorType(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	log := audit.NewLog("")
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "create", Resource: "r1"}))
+	assert.Nil(log.Record(audit.Entry{Actor: "b@zebra", ActorType: audit.ActorUser, Action: "update", Resource: "r1"}))
+	assert.Nil(log.Record(audit.Entry{
+		Actor: "system:serviceaccount:ci:deploy", ActorType: audit.ActorService, Action: "delete", Resource: "r1",
+	}))
+
+	assert.Equal(2, len(log.QueryActorType(audit.ActorUser)))
+
+	service := log.QueryActorType(audit.ActorService)
+	assert.Equal(1, len(service))
+	assert.Equal("system:serviceaccount:ci:deploy", service[0].Actor)
+}
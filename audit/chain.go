@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ActionCheckpoint is the action of the entries that sign the chain of the
+// log. Their detail is the signature of the hash of the entry before them.
+const ActionCheckpoint = "audit.checkpoint"
+
+var (
+	ErrBrokenChain  = errors.New("audit chain broken")
+	ErrBadSignature = errors.New("audit checkpoint signature invalid")
+	ErrUnsigned     = errors.New("audit log not signed")
+)
+
+// Signer signs the chain of the log, such as the rsa identity of the server.
+type Signer interface {
+	Sign(msg []byte) ([]byte, error)
+}
+
+// VerifyFunc checks the signature of a checkpoint.
+type VerifyFunc func(msg []byte, sig []byte) error
+
+// Report is the result of verifying the entries of a log. Entries recorded
+// before the log was chained have no hash, they are counted as unchained.
+// Unsigned are the entries after the last checkpoint.
+type Report struct {
+	Entries     int       `json:"entries"`
+	Unchained   int       `json:"unchained"`
+	Checkpoints int       `json:"checkpoints"`
+	Unsigned    int       `json:"unsigned"`
+	LastSigned  time.Time `json:"lastSigned,omitempty"`
+	Head        string    `json:"head"`
+}
+
+// SetSigner makes the log record a checkpoint signed by the signer after
+// every given number of entries.
+func (l *Log) SetSigner(signer Signer, every int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.signer = signer
+	l.every = every
+}
+
+// Head returns the hash of the last entry of the log.
+func (l *Log) Head() string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.head
+}
+
+// Checkpoint signs the entries recorded since the last checkpoint, if the
+// log has a signer, so that the log ends with a signature and a copy of it
+// can be verified.
+func (l *Log) Checkpoint() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.signer == nil || l.unsigned == 0 {
+		return nil
+	}
+
+	checkpoint, err := l.checkpoint(time.Now())
+	if err != nil {
+		return err
+	}
+
+	return l.append(checkpoint)
+}
+
+// checkpoint returns an entry that signs the head of the log.
+func (l *Log) checkpoint(now time.Time) (Entry, error) {
+	sig, err := l.signer.Sign([]byte(l.head))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Time:         now,
+		Actor:        ActorSystem,
+		ActorType:    ActorSystem,
+		Impersonator: "",
+		Action:       ActionCheckpoint,
+		Resource:     "",
+		Detail:       base64.StdEncoding.EncodeToString(sig),
+		Hash:         "",
+	}, nil
+}
+
+// hash returns the hash of the entry chained to the hash of the entry before.
+func hash(prev string, entry Entry) string {
+	entry.Hash = ""
+
+	// Entries only hold strings and a time, marshaling them can not fail
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(append([]byte(prev+"\n"), data...))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that no entry was changed, removed or inserted since it was
+// recorded. Only the first unchained entries of the log, those recorded
+// before it was chained, may have no hash.
+//
+// The hashes alone do not stop a rewrite of the whole log, if verify is not
+// nil the log must also be signed: it needs a checkpoint signed by the log,
+// all checkpoints must be, and it must end with one, as the entries after
+// the last checkpoint are not covered by a signature.
+func Verify(entries []Entry, verify VerifyFunc, unchained int) (Report, error) {
+	report := Report{
+		Entries:     len(entries),
+		Unchained:   0,
+		Checkpoints: 0,
+		Unsigned:    0,
+		LastSigned:  time.Time{},
+		Head:        "",
+	}
+
+	for i, e := range entries {
+		if e.Hash == "" {
+			// Only the entries recorded before chaining have no hash
+			if report.Head != "" || i >= unchained {
+				return report, fmt.Errorf("%w: entry %d has no hash", ErrBrokenChain, i)
+			}
+
+			report.Unchained++
+
+			continue
+		}
+
+		if hash(report.Head, e) != e.Hash {
+			return report, fmt.Errorf("%w: entry %d does not match its hash", ErrBrokenChain, i)
+		}
+
+		if e.Action == ActionCheckpoint {
+			if err := verifyCheckpoint(report.Head, e, verify); err != nil {
+				return report, fmt.Errorf("%w: entry %d: %s", ErrBadSignature, i, err.Error())
+			}
+
+			report.Checkpoints++
+			report.Unsigned = 0
+			report.LastSigned = e.Time
+		} else {
+			report.Unsigned++
+		}
+
+		report.Head = e.Hash
+	}
+
+	switch {
+	case verify == nil:
+	case report.Checkpoints == 0:
+		return report, fmt.Errorf("%w: no checkpoint", ErrUnsigned)
+	case report.Unsigned != 0:
+		return report, fmt.Errorf("%w: %d entries after the last checkpoint", ErrUnsigned, report.Unsigned)
+	}
+
+	return report, nil
+}
+
+func verifyCheckpoint(head string, e Entry, verify VerifyFunc) error {
+	if verify == nil {
+		return nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(e.Detail)
+	if err != nil {
+		return err
+	}
+
+	return verify([]byte(head), sig)
+}
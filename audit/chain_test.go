@@ -0,0 +1,115 @@
+package audit_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	path := "test_chain.log"
+
+	t.Cleanup(func() { os.Remove(path) })
+
+	// Entries recorded before the log was chained
+	assert.Nil(os.WriteFile(path, []byte(`{"actor":"a@zebra","action":"create"}`+"\n"), audit.RWRR))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	log := audit.NewLog(path)
+	assert.Nil(log.Initialize())
+	log.SetSigner(key, 2)
+
+	for _, action := range []string{"create", "update", "delete"} {
+		assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: action, Resource: "r1"}))
+	}
+
+	verify := func(msg []byte, sig []byte) error { return key.Verify(msg, sig, nil) }
+
+	// Checkpoints count towards the chain after a reload
+	log = audit.NewLog(path)
+	assert.Nil(log.Initialize())
+	log.SetSigner(key, 2)
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "create", Resource: "r2"}))
+
+	_, err = audit.Verify(log.Entries(), verify, 1)
+	assert.Nil(err)
+
+	entries, err := audit.ReadFile(path)
+	assert.Nil(err)
+
+	report, err := audit.Verify(entries, verify, 1)
+	assert.Nil(err)
+	assert.Equal(7, report.Entries)
+	assert.Equal(1, report.Unchained)
+	assert.Equal(2, report.Checkpoints)
+	assert.Equal(0, report.Unsigned)
+	assert.Equal(log.Head(), report.Head)
+
+	other, err := auth.Generate()
+	assert.Nil(err)
+
+	_, err = audit.Verify(entries, func(msg []byte, sig []byte) error { return other.Verify(msg, sig, nil) }, 1)
+	assert.ErrorIs(err, audit.ErrBadSignature)
+
+	// Changed, removed and unchained entries break the chain
+	changed := append([]audit.Entry{}, entries...)
+	changed[2].Actor = "b@zebra"
+	_, err = audit.Verify(changed, nil, 1)
+	assert.ErrorIs(err, audit.ErrBrokenChain)
+
+	_, err = audit.Verify(append(append([]audit.Entry{}, entries[:2]...), entries[3:]...), nil, 1)
+	assert.ErrorIs(err, audit.ErrBrokenChain)
+
+	_, err = audit.Verify(append(append([]audit.Entry{}, entries...), entries[0]), nil, 1)
+	assert.ErrorIs(err, audit.ErrBrokenChain)
+
+	_, err = audit.Verify(entries, verify, 0)
+	assert.ErrorIs(err, audit.ErrBrokenChain)
+
+	// Stripping all hashes does not pass the log off as unchained
+	stripped := append([]audit.Entry{}, entries...)
+	for i := range stripped {
+		stripped[i].Hash = ""
+	}
+
+	_, err = audit.Verify(stripped, nil, 1)
+	assert.ErrorIs(err, audit.ErrBrokenChain)
+
+	// A log rewritten and rehashed without its checkpoints is not signed
+	forged := audit.NewLog("")
+
+	for _, e := range entries[1:] {
+		if e.Action != audit.ActionCheckpoint {
+			e.Actor = "b@zebra"
+			assert.Nil(forged.Record(e))
+		}
+	}
+
+	_, err = audit.Verify(forged.Entries(), nil, 0)
+	assert.Nil(err)
+
+	_, err = audit.Verify(forged.Entries(), verify, 0)
+	assert.ErrorIs(err, audit.ErrUnsigned)
+
+	// Entries after the last checkpoint are not signed until the next one
+	_, err = audit.Verify(entries[:5], verify, 1)
+	assert.ErrorIs(err, audit.ErrUnsigned)
+
+	assert.Nil(log.Checkpoint())
+	assert.Equal(7, len(log.Entries()))
+	assert.Nil(log.Record(audit.Entry{Actor: "a@zebra", Action: "delete", Resource: "r2"}))
+	assert.Nil(log.Checkpoint())
+
+	report, err = audit.Verify(log.Entries(), verify, 1)
+	assert.Nil(err)
+	assert.Equal(3, report.Checkpoints)
+	assert.Equal(0, report.Unsigned)
+}
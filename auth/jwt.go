@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/project-safari/zebra"
 )
 
 var ErrInvalidToken = errors.New("invalid jwt token")
@@ -13,8 +14,9 @@ const TokenDuration = time.Minute * 10
 
 type Claims struct {
 	jwt.StandardClaims
-	Role  *Role  `json:"role"`
-	Email string `json:"email"`
+	Role           *Role  `json:"role"`
+	Email          string `json:"email"`
+	ServiceAccount bool   `json:"serviceAccount,omitempty"`
 }
 
 func NewClaims(issuer string, subject string, role *Role, email string) *Claims {
@@ -22,6 +24,7 @@ func NewClaims(issuer string, subject string, role *Role, email string) *Claims
 
 	claims.Issuer = issuer
 	claims.Subject = subject
+	claims.IssuedAt = time.Now().Unix()
 	claims.ExpiresAt = time.Now().Add(TokenDuration).Unix()
 	claims.Role = role
 	claims.Email = email
@@ -33,6 +36,12 @@ func (claims *Claims) Create(resource string) bool {
 	return claims.Role.Create(resource)
 }
 
+// Allows returns true if the claims grant the action on a resource of the
+// given type and labels.
+func (claims *Claims) Allows(action Action, resType string, labels zebra.Labels) bool {
+	return claims.Role.Allows(action, resType, labels)
+}
+
 func (claims *Claims) Read(resource string) bool {
 	return claims.Role.Read(resource)
 }
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/project-safari/zebra"
+)
+
+var ErrPolicyModule = errors.New("policy module is empty")
+
+func PolicyType() zebra.Type {
+	return zebra.Type{
+		Name:        "Policy",
+		Description: "zebra rego policy for the policy engine",
+		Constructor: func() zebra.Resource { return new(Policy) },
+		Migrations:  nil,
+	}
+}
+
+// Policy is a rego module evaluated by the policy engine, if the server has
+// one, on every resource mutation. Its deny rules in the package of the
+// engine reject the mutations they match.
+type Policy struct {
+	zebra.NamedResource
+	Module string `json:"module"`
+}
+
+// NewPolicy returns a policy with the given rego module.
+func NewPolicy(name string, module string, labels zebra.Labels) *Policy {
+	return &Policy{
+		NamedResource: zebra.NamedResource{
+			BaseResource: *zebra.NewBaseResource("Policy", labels),
+			Name:         name,
+		},
+		Module: module,
+	}
+}
+
+// Validate returns an error if the given Policy object has incorrect values.
+// Else, it returns nil. The module is compiled by the policy engine.
+func (p *Policy) Validate(ctx context.Context) error {
+	if p.Module == "" {
+		return ErrPolicyModule
+	}
+
+	if p.Type != "Policy" {
+		return zebra.ErrWrongType
+	}
+
+	return p.NamedResource.Validate(ctx)
+}
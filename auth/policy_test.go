@@ -0,0 +1,27 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	p := auth.NewPolicy("vlans", "package zebra", zebra.Labels{"system.group": "security"})
+	assert.Nil(p.Validate(ctx))
+	assert.Equal("Policy", auth.PolicyType().Name)
+
+	p.Module = ""
+	assert.Equal(auth.ErrPolicyModule, p.Validate(ctx))
+
+	p.Module = "package zebra"
+	p.Type = "Lab"
+	assert.Equal(zebra.ErrWrongType, p.Validate(ctx))
+}
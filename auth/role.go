@@ -4,20 +4,50 @@ import (
 	"bytes"
 	"errors"
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/project-safari/zebra"
 )
 
 var (
 	ErrResourceKeyEmpty  = errors.New("resource key is empty")
 	ErrInvalidPrivileges = errors.New("atleast one or atmost four privileges must be set")
+	ErrInvalidSelector   = errors.New("label selector must be a list of key=value")
+	ErrInvalidAction     = errors.New("action must be one of create, read, update or delete")
+)
+
+// Action is an operation on a resource that privileges grant.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
 )
 
+// ParseAction returns the action named by s.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case ActionCreate, ActionRead, ActionUpdate, ActionDelete:
+		return a, nil
+	default:
+		return "", ErrInvalidAction
+	}
+}
+
+// Priv grants create, read, update and delete on the resources whose key
+// matches the regular expression k. A privilege with a label selector only
+// applies to resources that carry all of the selector labels, written as
+// "Switch|VLAN:c,r,u,d:site=west".
 type Priv struct {
-	c bool
-	r bool
-	u bool
-	d bool
-	k *ResourceKey
+	c   bool
+	r   bool
+	u   bool
+	d   bool
+	k   *ResourceKey
+	sel zebra.Labels
 }
 
 func NewPriv(k string, c bool, r bool, u bool, d bool) (*Priv, error) {
@@ -31,11 +61,12 @@ func NewPriv(k string, c bool, r bool, u bool, d bool) (*Priv, error) {
 	}
 
 	return &Priv{
-		c: c,
-		r: r,
-		u: u,
-		d: d,
-		k: rk,
+		c:   c,
+		r:   r,
+		u:   u,
+		d:   d,
+		k:   rk,
+		sel: nil,
 	}, nil
 }
 
@@ -60,6 +91,11 @@ func (p *Priv) String() string {
 	prevWrite(p.u, "u")
 	prevWrite(p.d, "d")
 
+	if len(p.sel) != 0 {
+		buf.WriteString(":")
+		buf.WriteString(selectorString(p.sel))
+	}
+
 	return buf.String()
 }
 
@@ -76,7 +112,7 @@ func (p *Priv) UnmarshalText(text []byte) error {
 		return ErrResourceKeyEmpty
 	}
 
-	s := strings.Split(t, ":")
+	s := strings.SplitN(t, ":", 3)
 
 	privs := strings.Split(s[1], ",")
 	if len(privs) == 0 || len(privs) > 4 {
@@ -89,6 +125,16 @@ func (p *Priv) UnmarshalText(text []byte) error {
 	}
 
 	p.k = k
+	p.sel = nil
+
+	if len(s) == 3 {
+		sel, err := parseSelector(s[2])
+		if err != nil {
+			return err
+		}
+
+		p.sel = sel
+	}
 
 	for _, priv := range privs {
 		switch priv {
@@ -108,24 +154,102 @@ func (p *Priv) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// WithSelector scopes the privilege to resources with all of the labels.
+func (p *Priv) WithSelector(selector zebra.Labels) *Priv {
+	p.sel = zebra.Labels{}
+
+	for k, v := range selector {
+		p.sel.Add(k, v)
+	}
+
+	return p
+}
+
+// Selector returns the label selector of the privilege, nil if it is not
+// scoped.
+func (p *Priv) Selector() zebra.Labels {
+	return p.sel
+}
+
+// Selects returns true if the labels match the label selector.
+func (p *Priv) Selects(labels zebra.Labels) bool {
+	for k, v := range p.sel {
+		if !labels.MatchEqual(k, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Allows returns true if the privilege grants the action on a resource of
+// the given type and labels.
+func (p *Priv) Allows(action Action, resType string, labels zebra.Labels) bool {
+	if !p.k.Match(resType) || !p.Selects(labels) {
+		return false
+	}
+
+	switch action {
+	case ActionCreate:
+		return p.c
+	case ActionRead:
+		return p.r
+	case ActionUpdate:
+		return p.u
+	case ActionDelete:
+		return p.d
+	default:
+		return false
+	}
+}
+
+// The key checks below have no resource labels to match, so only privileges
+// without a label selector grant them.
+
 func (p *Priv) Read(key string) bool {
-	return p.k.Match(key) && p.r
+	return p.k.Match(key) && p.r && len(p.sel) == 0
 }
 
 func (p *Priv) Write(key string) bool {
-	return p.k.Match(key) && p.c && p.u && p.d
+	return p.k.Match(key) && p.c && p.u && p.d && len(p.sel) == 0
 }
 
 func (p *Priv) Update(key string) bool {
-	return p.k.Match(key) && p.u
+	return p.k.Match(key) && p.u && len(p.sel) == 0
 }
 
 func (p *Priv) Create(key string) bool {
-	return p.k.Match(key) && p.c
+	return p.k.Match(key) && p.c && len(p.sel) == 0
 }
 
 func (p *Priv) Delete(key string) bool {
-	return p.k.Match(key) && p.d
+	return p.k.Match(key) && p.d && len(p.sel) == 0
+}
+
+func selectorString(sel zebra.Labels) string {
+	pairs := make([]string, 0, len(sel))
+	for k, v := range sel {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func parseSelector(text string) (zebra.Labels, error) {
+	sel := zebra.Labels{}
+
+	for _, pair := range strings.Split(text, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, ErrInvalidSelector
+		}
+
+		sel.Add(k, v)
+	}
+
+	return sel, nil
 }
 
 type ResourceKey struct {
@@ -154,6 +278,43 @@ type Role struct {
 	Privileges []*Priv `json:"privileges"`
 }
 
+// Scoped returns a copy of the role with the selector added to the label
+// selector of every privilege.
+func (r *Role) Scoped(selector zebra.Labels) *Role {
+	scoped := &Role{Name: r.Name, Privileges: make([]*Priv, 0, len(r.Privileges))}
+
+	for _, p := range r.Privileges {
+		priv := *p
+		priv.WithSelector(p.sel)
+
+		for k, v := range selector {
+			priv.sel.Add(k, v)
+		}
+
+		scoped.Privileges = append(scoped.Privileges, &priv)
+	}
+
+	return scoped
+}
+
+// Allows returns true if any privilege of the role grants the action on a
+// resource of the given type and labels.
+func (r *Role) Allows(action Action, resType string, labels zebra.Labels) bool {
+	return r.Privilege(action, resType, labels) != nil
+}
+
+// Privilege returns the first privilege of the role that grants the action
+// on a resource of the given type and labels, nil if there is none.
+func (r *Role) Privilege(action Action, resType string, labels zebra.Labels) *Priv {
+	for _, priv := range r.Privileges {
+		if priv.Allows(action, resType, labels) {
+			return priv
+		}
+	}
+
+	return nil
+}
+
 func (r *Role) Read(key string) bool {
 	for _, priv := range r.Privileges {
 		if priv.Read(key) {
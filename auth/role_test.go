@@ -3,6 +3,7 @@ package auth_test
 import (
 	"testing"
 
+	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/auth"
 	"github.com/stretchr/testify/assert"
 )
@@ -98,3 +99,64 @@ func TestPriv(t *testing.T) {
 	assert.False(p.Delete("e/f/g"))
 	assert.False(p.Write("e/f/g"))
 }
+
+func TestScopedPriv(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	p, e := auth.NewPriv("^(Switch|VLANPool)$", true, true, true, true)
+	assert.Nil(e)
+
+	p.WithSelector(zebra.Labels{"site": "west", "env": "prod"})
+	assert.Equal("^(Switch|VLANPool)$:c,r,u,d:env=prod,site=west", p.String())
+
+	west := zebra.Labels{"site": "west", "env": "prod", "team": "network"}
+	east := zebra.Labels{"site": "east", "env": "prod"}
+
+	assert.True(p.Allows(auth.ActionUpdate, "Switch", west))
+	assert.False(p.Allows(auth.ActionUpdate, "Switch", east))
+	assert.False(p.Allows(auth.ActionUpdate, "Server", west))
+	assert.False(p.Allows(auth.Action("own"), "Switch", west))
+
+	// Key checks have no labels, scoped privileges do not grant them
+	assert.False(p.Write("Switch"))
+	assert.False(p.Read("Switch"))
+
+	q := new(auth.Priv)
+	assert.Nil(q.UnmarshalText([]byte(p.String())))
+	assert.Equal(p.String(), q.String())
+	assert.Equal(zebra.Labels{"site": "west", "env": "prod"}, q.Selector())
+
+	assert.Nil(q.UnmarshalText([]byte("Server:r")))
+	assert.Nil(q.Selector())
+	assert.ErrorIs(q.UnmarshalText([]byte("Server:r:site")), auth.ErrInvalidSelector)
+	assert.ErrorIs(q.UnmarshalText([]byte("Server:r:=west")), auth.ErrInvalidSelector)
+
+	read, e := auth.NewPriv("Server", false, true, false, false)
+	assert.Nil(e)
+
+	role := &auth.Role{Name: "team-network", Privileges: []*auth.Priv{p, read}}
+	assert.True(role.Allows(auth.ActionDelete, "VLANPool", west))
+	assert.True(role.Allows(auth.ActionRead, "Server", east))
+	assert.False(role.Allows(auth.ActionUpdate, "Server", west))
+	assert.Equal(read, role.Privilege(auth.ActionRead, "Server", west))
+	assert.Nil(role.Privilege(auth.ActionCreate, "Server", west))
+
+	claims := auth.NewClaims("zebra", "net", role, "net@zebra")
+	assert.True(claims.Allows(auth.ActionCreate, "Switch", west))
+}
+
+func TestParseAction(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	for _, a := range []string{"create", "read", "update", "delete"} {
+		action, err := auth.ParseAction(a)
+		assert.Nil(err)
+		assert.Equal(auth.Action(a), action)
+	}
+
+	_, err := auth.ParseAction("own")
+	assert.ErrorIs(err, auth.ErrInvalidAction)
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/project-safari/zebra"
+)
+
+// ServiceTokenBytes is the number of random bytes in a service token secret.
+const ServiceTokenBytes = 32
+
+var (
+	ErrNamespaceEmpty      = errors.New("service account namespace is empty")
+	ErrNegativeLimit       = errors.New("service account quota and rate limit must not be negative")
+	ErrServiceToken        = errors.New("invalid service account token")
+	ErrServiceTokenExpired = errors.New("service account token expired")
+)
+
+func ServiceAccountType() zebra.Type {
+	return zebra.Type{
+		Name:        "ServiceAccount",
+		Description: "zebra service account for automation",
+		Constructor: func() zebra.Resource { return new(ServiceAccount) },
+		Migrations:  nil,
+	}
+}
+
+// ServiceToken is a token of a service account. Only the hash of the token
+// secret is kept, the token itself is shown once when it is issued.
+type ServiceToken struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// ServiceAccount is a non-human identity for automation such as CI jobs. It
+// belongs to the namespace of its system.group label and its privileges are
+// confined to that namespace. Quota is the number of resource changes per
+// day and RateLimit the number of requests per minute, zero is unlimited.
+type ServiceAccount struct {
+	zebra.NamedResource
+	Role      *Role           `json:"role"`
+	Tokens    []*ServiceToken `json:"tokens"`
+	Quota     int             `json:"quota"`
+	RateLimit int             `json:"rateLimit"`
+	CreatedBy string          `json:"createdBy"`
+}
+
+// NewServiceAccount returns a service account in the namespace with the role
+// scoped to the namespace.
+func NewServiceAccount(name string, namespace string, role *Role, createdBy string) *ServiceAccount {
+	labels := zebra.Labels{}
+	labels.Add("system.group", namespace)
+
+	sa := new(ServiceAccount)
+	sa.BaseResource = *zebra.NewBaseResource("ServiceAccount", labels)
+	sa.Name = name
+	sa.Role = role.Scoped(zebra.Labels{"system.group": namespace})
+	sa.Tokens = []*ServiceToken{}
+	sa.CreatedBy = createdBy
+
+	return sa
+}
+
+// Validate returns an error if the service account has incorrect values.
+func (sa *ServiceAccount) Validate(ctx context.Context) error {
+	switch {
+	case sa.Role == nil:
+		return ErrRoleEmpty
+	case sa.Namespace() == "":
+		return ErrNamespaceEmpty
+	case sa.Quota < 0 || sa.RateLimit < 0:
+		return ErrNegativeLimit
+	}
+
+	return sa.NamedResource.Validate(ctx)
+}
+
+// Namespace returns the namespace of the service account.
+func (sa *ServiceAccount) Namespace() string {
+	return sa.Labels["system.group"]
+}
+
+// Principal returns the name the service account acts under, it can not be
+// mistaken for the email of a user.
+func (sa *ServiceAccount) Principal() string {
+	return "system:serviceaccount:" + sa.Namespace() + ":" + sa.Name
+}
+
+// Claims returns the claims of a request authenticated as the account.
+func (sa *ServiceAccount) Claims() *Claims {
+	claims := NewClaims("zebra", sa.Name, sa.Role, sa.Principal())
+	claims.ServiceAccount = true
+
+	return claims
+}
+
+// IssueToken adds a new token to the account and returns it. A zero ttl
+// issues a token that does not expire.
+func (sa *ServiceAccount) IssueToken(ttl time.Duration) (string, *ServiceToken, error) {
+	secret := make([]byte, ServiceTokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	token := &ServiceToken{
+		ID:      uuid.New().String(),
+		Hash:    hashSecret(encoded),
+		Created: time.Now(),
+		Expires: time.Time{},
+	}
+
+	if ttl > 0 {
+		token.Expires = token.Created.Add(ttl)
+	}
+
+	sa.Tokens = append(sa.Tokens, token)
+
+	return sa.ID + "." + token.ID + "." + encoded, token, nil
+}
+
+// RevokeToken removes the token with the id, it returns false if there is
+// no such token.
+func (sa *ServiceAccount) RevokeToken(id string) bool {
+	for i, t := range sa.Tokens {
+		if t.ID == id {
+			sa.Tokens = append(sa.Tokens[:i], sa.Tokens[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticate returns nil if the token is a valid token of the account.
+func (sa *ServiceAccount) Authenticate(token string, now time.Time) error {
+	accountID, tokenID, secret, err := ParseServiceToken(token)
+	if err != nil || accountID != sa.ID {
+		return ErrServiceToken
+	}
+
+	for _, t := range sa.Tokens {
+		if t.ID != tokenID {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(secret))) != 1 {
+			return ErrServiceToken
+		}
+
+		if !t.Expires.IsZero() && now.After(t.Expires) {
+			return ErrServiceTokenExpired
+		}
+
+		return nil
+	}
+
+	return ErrServiceToken
+}
+
+// Redacted returns a copy of the account without the token hashes.
+func (sa *ServiceAccount) Redacted() *ServiceAccount {
+	redacted := *sa
+	redacted.Tokens = make([]*ServiceToken, 0, len(sa.Tokens))
+
+	for _, t := range sa.Tokens {
+		token := *t
+		token.Hash = ""
+		redacted.Tokens = append(redacted.Tokens, &token)
+	}
+
+	return &redacted
+}
+
+// ParseServiceToken splits a service token into the account id, the token id
+// and the secret.
+func ParseServiceToken(token string) (string, string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" { //nolint:gomnd
+		return "", "", "", ErrServiceToken
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
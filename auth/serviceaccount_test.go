@@ -0,0 +1,83 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceAccount(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	crud, err := auth.NewPriv("^Lab$", true, true, true, true)
+	assert.Nil(err)
+
+	sa := auth.NewServiceAccount("deploy", "ci", &auth.Role{Name: "ci", Privileges: []*auth.Priv{crud}}, "a@zebra")
+	assert.Nil(sa.Validate(context.Background()))
+	assert.Equal("ci", sa.Namespace())
+	assert.Equal("system:serviceaccount:ci:deploy", sa.Principal())
+
+	// Privileges are confined to the namespace
+	assert.Equal("^Lab$:c,r,u,d:system.group=ci", sa.Role.Privileges[0].String())
+	assert.Equal("^Lab$:c,r,u,d", crud.String())
+	assert.True(sa.Role.Allows(auth.ActionCreate, "Lab", zebra.Labels{"system.group": "ci"}))
+	assert.False(sa.Role.Allows(auth.ActionCreate, "Lab", zebra.Labels{"system.group": "prod"}))
+
+	claims := sa.Claims()
+	assert.True(claims.ServiceAccount)
+	assert.Equal(sa.Principal(), claims.Email)
+
+	sa.Quota = -1
+	assert.ErrorIs(sa.Validate(context.Background()), auth.ErrNegativeLimit)
+	sa.Quota = 0
+
+	sa.Labels = zebra.Labels{}
+	assert.ErrorIs(sa.Validate(context.Background()), auth.ErrNamespaceEmpty)
+
+	sa.Role = nil
+	assert.ErrorIs(sa.Validate(context.Background()), auth.ErrRoleEmpty)
+}
+
+func TestServiceToken(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	read, err := auth.NewPriv("", false, true, false, false)
+	assert.Nil(err)
+
+	sa := auth.NewServiceAccount("deploy", "ci", &auth.Role{Name: "ci", Privileges: []*auth.Priv{read}}, "a@zebra")
+	now := time.Now()
+
+	token, issued, err := sa.IssueToken(time.Hour)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(token, sa.ID+"."+issued.ID+"."))
+	assert.NotContains(issued.Hash, strings.Split(token, ".")[2])
+
+	forever, _, err := sa.IssueToken(0)
+	assert.Nil(err)
+
+	assert.Nil(sa.Authenticate(token, now))
+	assert.Nil(sa.Authenticate(forever, now.Add(24*time.Hour)))
+	assert.ErrorIs(sa.Authenticate(token, now.Add(2*time.Hour)), auth.ErrServiceTokenExpired)
+	assert.ErrorIs(sa.Authenticate(token+"x", now), auth.ErrServiceToken)
+	assert.ErrorIs(sa.Authenticate("other"+token, now), auth.ErrServiceToken)
+	assert.ErrorIs(sa.Authenticate("a.b", now), auth.ErrServiceToken)
+
+	// Redacted accounts do not show token hashes, the account is unchanged
+	data, err := json.Marshal(sa.Redacted())
+	assert.Nil(err)
+	assert.NotContains(string(data), issued.Hash)
+	assert.NotEmpty(sa.Tokens[0].Hash)
+
+	assert.True(sa.RevokeToken(issued.ID))
+	assert.False(sa.RevokeToken(issued.ID))
+	assert.ErrorIs(sa.Authenticate(token, now), auth.ErrServiceToken)
+	assert.Nil(sa.Authenticate(forever, now))
+}
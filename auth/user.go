@@ -20,6 +20,7 @@ func UserType() zebra.Type {
 		Name:        "User",
 		Description: "zebra user",
 		Constructor: func() zebra.Resource { return new(User) },
+		Migrations:  nil,
 	}
 }
 
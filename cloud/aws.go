@@ -0,0 +1,275 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+// EC2 API of the AWS importer.
+const (
+	ec2Version  = "2016-11-15"
+	ec2Service  = "ec2"
+	awsAlgo     = "AWS4-HMAC-SHA256"
+	awsDateTime = "20060102T150405Z"
+	awsDate     = "20060102"
+)
+
+// AWS imports the EC2 instances and VPCs of the regions of an AWS account.
+// Requests are signed with signature version 4. Endpoint replaces the EC2
+// endpoint of the regions, for VPC endpoints and tests.
+type AWS struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Regions         []string
+	Endpoint        string
+	Client          *http.Client
+}
+
+// NewAWS returns an importer of the regions of the account of the access key.
+func NewAWS(accessKeyID string, secretAccessKey string, regions []string, client *http.Client) *AWS {
+	return &AWS{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    "",
+		Regions:         regions,
+		Endpoint:        "",
+		Client:          client,
+	}
+}
+
+type awsTag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type awsInstance struct {
+	InstanceID   string   `xml:"instanceId"`
+	InstanceType string   `xml:"instanceType"`
+	State        string   `xml:"instanceState>name"`
+	PrivateIP    string   `xml:"privateIpAddress"`
+	PublicIP     string   `xml:"ipAddress"`
+	VpcID        string   `xml:"vpcId"`
+	Zone         string   `xml:"placement>availabilityZone"`
+	Tags         []awsTag `xml:"tagSet>item"`
+}
+
+type awsInstances struct {
+	Reservations []struct {
+		Instances []awsInstance `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+type awsVpcs struct {
+	Vpcs []struct {
+		VpcID        string   `xml:"vpcId"`
+		CidrBlock    string   `xml:"cidrBlock"`
+		Associations []string `xml:"cidrBlockAssociationSet>item>cidrBlock"`
+		Tags         []awsTag `xml:"tagSet>item"`
+	} `xml:"vpcSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+func (a *AWS) Import(ctx context.Context) (*Inventory, error) {
+	inv := &Inventory{Instances: []*Instance{}, Networks: []*Network{}}
+
+	for _, region := range a.Regions {
+		if err := a.importRegion(ctx, region, inv); err != nil {
+			return nil, fmt.Errorf("%s: %w", region, err)
+		}
+	}
+
+	return inv, nil
+}
+
+func (a *AWS) importRegion(ctx context.Context, region string, inv *Inventory) error {
+	for token := ""; ; {
+		out := new(awsInstances)
+		if err := a.call(ctx, region, "DescribeInstances", token, out); err != nil {
+			return err
+		}
+
+		for _, r := range out.Reservations {
+			for _, i := range r.Instances {
+				inv.Instances = append(inv.Instances, awsToInstance(region, i))
+			}
+		}
+
+		if token = out.NextToken; token == "" {
+			break
+		}
+	}
+
+	for token := ""; ; {
+		out := new(awsVpcs)
+		if err := a.call(ctx, region, "DescribeVpcs", token, out); err != nil {
+			return err
+		}
+
+		for _, v := range out.Vpcs {
+			labels, name := awsLabels(v.Tags, v.VpcID)
+			labels.Add(LabelRegion, region)
+
+			n := NewNetwork(ProviderAWS, v.VpcID, name, labels)
+			n.Region = region
+			n.CIDRs = append(n.CIDRs, v.CidrBlock)
+
+			for _, cidr := range v.Associations {
+				if cidr != v.CidrBlock {
+					n.CIDRs = append(n.CIDRs, cidr)
+				}
+			}
+
+			inv.Networks = append(inv.Networks, n)
+		}
+
+		if token = out.NextToken; token == "" {
+			break
+		}
+	}
+
+	return nil
+}
+
+// awsLabels returns the labels of the tags, and the Name tag or, if there
+// is none, the ID as the name.
+func awsLabels(tags []awsTag, id string) (zebra.Labels, string) {
+	values := map[string]string{}
+	for _, t := range tags {
+		values[t.Key] = t.Value
+	}
+
+	name := values["Name"]
+	if name == "" {
+		name = id
+	}
+
+	return tagLabels(values), name
+}
+
+func awsToInstance(region string, i awsInstance) *Instance {
+	labels, name := awsLabels(i.Tags, i.InstanceID)
+	labels.Add(LabelRegion, region)
+	labels.Add(LabelZone, i.Zone)
+
+	if i.VpcID != "" {
+		labels.Add(LabelNetwork, i.VpcID)
+	}
+
+	instance := NewInstance(ProviderAWS, i.InstanceID, name, labels)
+	instance.Region = region
+	instance.Zone = i.Zone
+	instance.MachineType = i.InstanceType
+	instance.State = i.State
+	instance.PrivateIP = net.ParseIP(i.PrivateIP)
+	instance.PublicIP = net.ParseIP(i.PublicIP)
+	instance.NetworkID = i.VpcID
+
+	return instance
+}
+
+// call calls the EC2 action in the region and decodes the XML response.
+func (a *AWS) call(ctx context.Context, region string, action string, token string, out interface{}) error {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://ec2." + region + ".amazonaws.com"
+	}
+
+	query := url.Values{}
+	query.Set("Action", action)
+	query.Set("Version", ec2Version)
+
+	if token != "" {
+		query.Set("NextToken", token)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?"+awsQuery(query), nil)
+	if err != nil {
+		return err
+	}
+
+	a.Sign(req, region, ec2Service, time.Now().UTC())
+
+	body, err := send(a.Client, req)
+	if err != nil {
+		return err
+	}
+
+	return xml.Unmarshal(body, out)
+}
+
+// Sign signs the GET request to the service in the region with signature
+// version 4. The host, the content type and the x-amz headers are signed.
+func (a *AWS) Sign(req *http.Request, region string, service string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format(awsDateTime))
+
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-type" {
+			headers[k] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	canonical := new(strings.Builder)
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{
+		req.Method, "/", awsQuery(req.URL.Query()), canonical.String(), signed, sha256Hex(""),
+	}, "\n")
+	scope := now.Format(awsDate) + "/" + region + "/" + service + "/aws4_request"
+	toSign := strings.Join([]string{awsAlgo, now.Format(awsDateTime), scope, sha256Hex(request)}, "\n")
+
+	key := []byte("AWS4" + a.SecretAccessKey)
+	for _, part := range []string{now.Format(awsDate), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsAlgo, a.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// awsQuery returns the canonical query string of the values: sorted by key
+// and with spaces encoded as %20.
+func awsQuery(values url.Values) string {
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,255 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoints and API versions of the Azure importer.
+const (
+	AzureEndpoint      = "https://management.azure.com"
+	AzureLoginEndpoint = "https://login.microsoftonline.com"
+	azureScope         = "https://management.azure.com/.default"
+	azureComputeAPI    = "2022-08-01"
+	azureNetworkAPI    = "2022-07-01"
+	azurePowerState    = "PowerState/"
+)
+
+// Azure imports the virtual machines and virtual networks of a subscription.
+// It authenticates as the client of an app registration of the tenant.
+type Azure struct {
+	Subscription  string
+	Tenant        string
+	ClientID      string
+	ClientSecret  string
+	Endpoint      string
+	LoginEndpoint string
+	Client        *http.Client
+}
+
+// NewAzure returns an importer of the subscription with the client secret of
+// the app registration.
+func NewAzure(subscription string, tenant string, clientID string, clientSecret string, client *http.Client) *Azure {
+	return &Azure{
+		Subscription:  subscription,
+		Tenant:        tenant,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Endpoint:      AzureEndpoint,
+		LoginEndpoint: AzureLoginEndpoint,
+		Client:        client,
+	}
+}
+
+type azureVM struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Location   string            `json:"location"`
+	Zones      []string          `json:"zones"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		VMID     string `json:"vmId"`
+		Hardware struct {
+			VMSize string `json:"vmSize"`
+		} `json:"hardwareProfile"`
+		Network struct {
+			Interfaces []struct {
+				ID string `json:"id"`
+			} `json:"networkInterfaces"`
+		} `json:"networkProfile"`
+		InstanceView struct {
+			Statuses []struct {
+				Code string `json:"code"`
+			} `json:"statuses"`
+		} `json:"instanceView"`
+	} `json:"properties"`
+}
+
+type azureNIC struct {
+	ID         string `json:"id"`
+	Properties struct {
+		IPConfigurations []struct {
+			Properties struct {
+				PrivateIP string `json:"privateIPAddress"` //nolint:tagliatelle
+				Subnet    struct {
+					ID string `json:"id"`
+				} `json:"subnet"`
+				PublicIP struct {
+					ID string `json:"id"`
+				} `json:"publicIPAddress"` //nolint:tagliatelle
+			} `json:"properties"`
+		} `json:"ipConfigurations"`
+	} `json:"properties"`
+}
+
+type azureVNet struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Location   string            `json:"location"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		AddressSpace struct {
+			Prefixes []string `json:"addressPrefixes"`
+		} `json:"addressSpace"`
+	} `json:"properties"`
+}
+
+type azurePublicIP struct {
+	ID         string `json:"id"`
+	Properties struct {
+		IPAddress string `json:"ipAddress"`
+	} `json:"properties"`
+}
+
+func (a *Azure) Import(ctx context.Context) (*Inventory, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", a.ClientID)
+	form.Set("client_secret", a.ClientSecret)
+	form.Set("scope", azureScope)
+
+	tokenURL := strings.TrimSuffix(a.LoginEndpoint, "/") + "/" + url.PathEscape(a.Tenant) + "/oauth2/v2.0/token"
+
+	token, err := oauthToken(ctx, a.Client, tokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+
+	vms := []azureVM{}
+	if err := a.list(ctx, token, "Microsoft.Compute/virtualMachines", azureComputeAPI+"&statusOnly=true",
+		func(raw json.RawMessage) error {
+			v := azureVM{}
+			err := json.Unmarshal(raw, &v)
+			vms = append(vms, v)
+
+			return err
+		}); err != nil {
+		return nil, err
+	}
+
+	nics := map[string]azureNIC{}
+	if err := a.list(ctx, token, "Microsoft.Network/networkInterfaces", azureNetworkAPI,
+		func(raw json.RawMessage) error {
+			n := azureNIC{}
+			err := json.Unmarshal(raw, &n)
+			nics[strings.ToLower(n.ID)] = n
+
+			return err
+		}); err != nil {
+		return nil, err
+	}
+
+	publicIPs := map[string]string{}
+	if err := a.list(ctx, token, "Microsoft.Network/publicIPAddresses", azureNetworkAPI,
+		func(raw json.RawMessage) error {
+			p := azurePublicIP{}
+			err := json.Unmarshal(raw, &p)
+			publicIPs[strings.ToLower(p.ID)] = p.Properties.IPAddress
+
+			return err
+		}); err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{Instances: []*Instance{}, Networks: []*Network{}}
+
+	if err := a.list(ctx, token, "Microsoft.Network/virtualNetworks", azureNetworkAPI, func(raw json.RawMessage) error {
+		v := azureVNet{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+
+		labels := tagLabels(v.Tags)
+		labels.Add(LabelRegion, v.Location)
+
+		n := NewNetwork(ProviderAzure, v.ID, v.Name, labels)
+		n.Region = v.Location
+		n.CIDRs = append(n.CIDRs, v.Properties.AddressSpace.Prefixes...)
+		inv.Networks = append(inv.Networks, n)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, vm := range vms {
+		inv.Instances = append(inv.Instances, azureToInstance(vm, nics, publicIPs))
+	}
+
+	return inv, nil
+}
+
+func azureToInstance(vm azureVM, nics map[string]azureNIC, publicIPs map[string]string) *Instance {
+	labels := tagLabels(vm.Tags)
+	labels.Add(LabelRegion, vm.Location)
+
+	instance := NewInstance(ProviderAzure, vm.ID, vm.Name, labels)
+	instance.Region = vm.Location
+	instance.MachineType = vm.Properties.Hardware.VMSize
+
+	if len(vm.Zones) != 0 {
+		instance.Zone = vm.Zones[0]
+		instance.Labels.Add(LabelZone, instance.Zone)
+	}
+
+	for _, status := range vm.Properties.InstanceView.Statuses {
+		if strings.HasPrefix(status.Code, azurePowerState) {
+			instance.State = strings.TrimPrefix(status.Code, azurePowerState)
+		}
+	}
+
+	if len(vm.Properties.Network.Interfaces) == 0 {
+		return instance
+	}
+
+	nic, ok := nics[strings.ToLower(vm.Properties.Network.Interfaces[0].ID)]
+	if !ok || len(nic.Properties.IPConfigurations) == 0 {
+		return instance
+	}
+
+	ipConfig := nic.Properties.IPConfigurations[0].Properties
+	instance.PrivateIP = net.ParseIP(ipConfig.PrivateIP)
+	instance.PublicIP = net.ParseIP(publicIPs[strings.ToLower(ipConfig.PublicIP.ID)])
+
+	// Subnets are children of their virtual network
+	if subnet := strings.Index(strings.ToLower(ipConfig.Subnet.ID), "/subnets/"); subnet > 0 {
+		instance.NetworkID = ipConfig.Subnet.ID[:subnet]
+		instance.Labels.Add(LabelNetwork, lastSegment(instance.NetworkID))
+	}
+
+	return instance
+}
+
+// list calls add with each resource of the provider type in the subscription,
+// following the next links of the pages.
+func (a *Azure) list(ctx context.Context, token string, provider string, apiVersion string,
+	add func(json.RawMessage) error,
+) error {
+	next := strings.TrimSuffix(a.Endpoint, "/") + "/subscriptions/" + url.PathEscape(a.Subscription) +
+		"/providers/" + provider + "?api-version=" + apiVersion
+
+	for next != "" {
+		page := &struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}{Value: nil, NextLink: ""}
+
+		if err := getJSON(ctx, a.Client, next, token, page); err != nil {
+			return err
+		}
+
+		for _, raw := range page.Value {
+			if err := add(raw); err != nil {
+				return err
+			}
+		}
+
+		next = page.NextLink
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package cloud imports the virtual machines and networks of public cloud
+// accounts into zebra, so that labs that span on-prem and cloud have one
+// inventory.
+//
+// Importers enumerate the instances and VPC networks of an AWS, GCP or Azure
+// account through the REST API of the provider and return them as zebra
+// resources, labeled with the provider, the cloud ID, the region and the
+// tags of the resource. Reconciling them with the store is up to the caller.
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/project-safari/zebra"
+)
+
+// Providers of cloud accounts.
+const (
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+)
+
+// Labels of imported resources. The ID label is the ID of the resource in
+// the cloud, which is stable across imports unlike the name. Tags and
+// labels of the resource in the cloud are prefixed with TagPrefix.
+const (
+	LabelProvider = "cloud.provider"
+	LabelAccount  = "cloud.account"
+	LabelID       = "cloud.id"
+	LabelRegion   = "cloud.region"
+	LabelZone     = "cloud.zone"
+	LabelNetwork  = "cloud.network"
+	TagPrefix     = "cloud.tag."
+)
+
+// maxErrorBody limits how much of an error response is kept in the error.
+const maxErrorBody = 512
+
+var (
+	ErrProvider   = errors.New("unknown cloud provider")
+	ErrRequest    = errors.New("cloud API request failed")
+	ErrInstanceID = errors.New("cloud instance ID is empty")
+	ErrNetworkID  = errors.New("cloud network ID is empty")
+)
+
+func InstanceType() zebra.Type {
+	return zebra.Type{
+		Name:        "CloudInstance",
+		Description: "virtual machine of a public cloud",
+		Constructor: func() zebra.Resource { return new(Instance) },
+		Migrations:  nil,
+	}
+}
+
+func NetworkType() zebra.Type {
+	return zebra.Type{
+		Name:        "CloudNetwork",
+		Description: "VPC network of a public cloud",
+		Constructor: func() zebra.Resource { return new(Network) },
+		Migrations:  nil,
+	}
+}
+
+// Instance is a virtual machine of a cloud account.
+type Instance struct {
+	zebra.NamedResource
+	Provider    string `json:"provider"`
+	InstanceID  string `json:"instanceId"`
+	Region      string `json:"region,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	MachineType string `json:"machineType,omitempty"`
+	State       string `json:"state,omitempty"`
+	PrivateIP   net.IP `json:"privateIP,omitempty"` //nolint:tagliatelle
+	PublicIP    net.IP `json:"publicIP,omitempty"`  //nolint:tagliatelle
+	NetworkID   string `json:"networkId,omitempty"`
+}
+
+// NewInstance returns the instance of the provider with the cloud ID.
+func NewInstance(provider string, id string, name string, labels zebra.Labels) *Instance {
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	i := new(Instance)
+	i.BaseResource = *zebra.NewBaseResource("CloudInstance", labels)
+	i.Name = name
+	i.Provider = provider
+	i.InstanceID = id
+
+	i.Labels.Add(LabelProvider, provider)
+	i.Labels.Add(LabelID, id)
+
+	return i
+}
+
+func (i *Instance) Validate(ctx context.Context) error {
+	switch {
+	case i.Provider == "":
+		return ErrProvider
+	case i.InstanceID == "":
+		return ErrInstanceID
+	case i.Type != "CloudInstance":
+		return zebra.ErrWrongType
+	}
+
+	return i.NamedResource.Validate(ctx)
+}
+
+// Network is a VPC network of a cloud account and its address ranges.
+type Network struct {
+	zebra.NamedResource
+	Provider  string   `json:"provider"`
+	NetworkID string   `json:"networkId"`
+	Region    string   `json:"region,omitempty"`
+	CIDRs     []string `json:"cidrs,omitempty"`
+}
+
+// NewNetwork returns the network of the provider with the cloud ID.
+func NewNetwork(provider string, id string, name string, labels zebra.Labels) *Network {
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	n := new(Network)
+	n.BaseResource = *zebra.NewBaseResource("CloudNetwork", labels)
+	n.Name = name
+	n.Provider = provider
+	n.NetworkID = id
+	n.CIDRs = []string{}
+
+	n.Labels.Add(LabelProvider, provider)
+	n.Labels.Add(LabelID, id)
+
+	return n
+}
+
+func (n *Network) Validate(ctx context.Context) error {
+	switch {
+	case n.Provider == "":
+		return ErrProvider
+	case n.NetworkID == "":
+		return ErrNetworkID
+	case n.Type != "CloudNetwork":
+		return zebra.ErrWrongType
+	}
+
+	for _, cidr := range n.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+
+	return n.NamedResource.Validate(ctx)
+}
+
+// Inventory is what an importer found in a cloud account.
+type Inventory struct {
+	Instances []*Instance
+	Networks  []*Network
+}
+
+// Resources returns the instances and networks of the inventory.
+func (inv *Inventory) Resources() []zebra.Resource {
+	resources := make([]zebra.Resource, 0, len(inv.Instances)+len(inv.Networks))
+
+	for _, i := range inv.Instances {
+		resources = append(resources, i)
+	}
+
+	for _, n := range inv.Networks {
+		resources = append(resources, n)
+	}
+
+	return resources
+}
+
+// Importer enumerates the resources of a cloud account.
+type Importer interface {
+	Import(ctx context.Context) (*Inventory, error)
+}
+
+// tagLabels returns the labels of the cloud tags.
+func tagLabels(tags map[string]string) zebra.Labels {
+	labels := zebra.Labels{}
+
+	for k, v := range tags {
+		labels.Add(TagPrefix+k, v)
+	}
+
+	return labels
+}
+
+// send sends the request and returns the body of the response.
+func send(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+		return nil, fmt.Errorf("%w: %s %s: %s %s", ErrRequest, req.Method, req.URL.Path, resp.Status,
+			strings.TrimSpace(string(body)))
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// do sends the request and decodes the JSON response into out.
+func do(client *http.Client, req *http.Request, out interface{}) error {
+	body, err := send(client, req)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// getJSON gets the URL with the bearer token and decodes the JSON response
+// into out.
+func getJSON(ctx context.Context, client *http.Client, url string, token string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	return do(client, req, out)
+}
+
+// lastSegment returns the last segment of a resource URL or path.
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,282 @@
+package cloud_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/cloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// Example of the signature version 4 documentation
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	assert.Nil(err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	a := cloud.NewAWS("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", nil, http.DefaultClient)
+	a.Sign(req, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+const ec2Instances = `<DescribeInstancesResponse>
+<reservationSet><item><instancesSet><item>
+<instanceId>i-0abc</instanceId><instanceType>t3.micro</instanceType>
+<instanceState><name>running</name></instanceState>
+<privateIpAddress>10.0.0.5</privateIpAddress><ipAddress>3.4.5.6</ipAddress>
+<vpcId>vpc-1</vpcId><placement><availabilityZone>us-west-2a</availabilityZone></placement>
+<tagSet><item><key>Name</key><value>web</value></item><item><key>team</key><value>lab</value></item></tagSet>
+</item></instancesSet></item></reservationSet>
+</DescribeInstancesResponse>`
+
+const ec2Vpcs = `<DescribeVpcsResponse>
+<vpcSet><item><vpcId>vpc-1</vpcId><cidrBlock>10.0.0.0/16</cidrBlock>
+<cidrBlockAssociationSet><item><cidrBlock>10.0.0.0/16</cidrBlock></item>
+<item><cidrBlock>10.1.0.0/16</cidrBlock></item></cidrBlockAssociationSet>
+</item></vpcSet>
+</DescribeVpcsResponse>`
+
+func TestAWS(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		switch r.URL.Query().Get("Action") {
+		case "DescribeInstances":
+			fmt.Fprint(w, ec2Instances)
+		case "DescribeVpcs":
+			fmt.Fprint(w, ec2Vpcs)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	a := cloud.NewAWS("AKID", "secret", []string{"us-west-2"}, srv.Client())
+	a.Endpoint = srv.URL
+
+	inv, err := a.Import(context.Background())
+	assert.Nil(err)
+	assert.Equal(1, len(inv.Instances))
+	assert.Equal(1, len(inv.Networks))
+
+	i := inv.Instances[0]
+	assert.Equal("web", i.Name)
+	assert.Equal("i-0abc", i.Labels[cloud.LabelID])
+	assert.Equal("us-west-2", i.Labels[cloud.LabelRegion])
+	assert.Equal("lab", i.Labels[cloud.TagPrefix+"team"])
+	assert.Equal("10.0.0.5", i.PrivateIP.String())
+	assert.Equal("vpc-1", i.NetworkID)
+	assert.Equal("running", i.State)
+
+	assert.Equal([]string{"10.0.0.0/16", "10.1.0.0/16"}, inv.Networks[0].CIDRs)
+	assert.Equal("vpc-1", inv.Networks[0].Name)
+	assert.Equal(2, len(inv.Resources()))
+
+	a.AccessKeyID = "other"
+	_, err = a.Import(context.Background())
+	assert.ErrorIs(err, cloud.ErrRequest)
+}
+
+func gcpCredentials(assert *assert.Assertions, tokenURL string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type:    "RSA PRIVATE KEY",
+		Headers: nil,
+		Bytes:   x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	credentials, err := json.Marshal(map[string]string{
+		"client_email": "importer@lab.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+		"token_uri":    tokenURL,
+	})
+	assert.Nil(err)
+
+	return credentials
+}
+
+func TestGCP(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		fmt.Fprint(w, `{"access_token":"gcp-token"}`)
+	})
+	mux.HandleFunc("/compute/v1/projects/lab/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gcp-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/aggregated/subnetworks"):
+			fmt.Fprint(w, `{"items":{"regions/us-central1":{"subnetworks":[
+				{"network":"https://net/default","ipCidrRange":"10.128.0.0/20"}]}}}`)
+		case strings.HasSuffix(r.URL.Path, "/global/networks"):
+			fmt.Fprint(w, `{"items":[{"id":"42","name":"default","selfLink":"https://net/default"}]}`)
+		case r.URL.Query().Get("pageToken") == "":
+			fmt.Fprint(w, `{"items":{"zones/us-central1-a":{"instances":[{"id":"7","name":"vm-1",
+				"machineType":"zones/us-central1-a/machineTypes/e2-small","status":"RUNNING",
+				"zone":"https://zones/us-central1-a","labels":{"env":"dev"},
+				"networkInterfaces":[{"network":"https://net/default","networkIP":"10.128.0.2"}]}]}},
+				"nextPageToken":"p2"}`)
+		default:
+			fmt.Fprint(w, `{"items":{"zones/us-east1-b":{"instances":[{"id":"8","name":"vm-2",
+				"zone":"https://zones/us-east1-b","status":"TERMINATED"}]}}}`)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := cloud.NewGCP("lab", gcpCredentials(assert, srv.URL+"/token"), srv.Client())
+	g.Endpoint = srv.URL
+
+	inv, err := g.Import(context.Background())
+	assert.Nil(err)
+	assert.Equal(2, len(inv.Instances))
+	assert.Equal(1, len(inv.Networks))
+
+	i := inv.Instances[0]
+	assert.Equal("vm-1", i.Name)
+	assert.Equal("us-central1", i.Region)
+	assert.Equal("us-central1-a", i.Zone)
+	assert.Equal("e2-small", i.MachineType)
+	assert.Equal("default", i.NetworkID)
+	assert.Equal("dev", i.Labels[cloud.TagPrefix+"env"])
+	assert.Equal("terminated", inv.Instances[1].State)
+	assert.Equal([]string{"10.128.0.0/20"}, inv.Networks[0].CIDRs)
+
+	g.Credentials = []byte(`{"client_email":"importer@lab.iam.gserviceaccount.com"}`)
+	_, err = g.Import(context.Background())
+	assert.ErrorIs(err, cloud.ErrGCPCredentials)
+}
+
+func TestAzure(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	const sub = "/subscriptions/s1"
+
+	vnet := sub + "/resourceGroups/lab/providers/Microsoft.Network/virtualNetworks/lab-vnet"
+	nic := sub + "/resourceGroups/lab/providers/Microsoft.Network/networkInterfaces/vm1-nic"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/t1/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		fmt.Fprint(w, `{"access_token":"azure-token"}`)
+	})
+	mux.HandleFunc(sub+"/providers/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer azure-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/virtualMachines"):
+			fmt.Fprintf(w, `{"value":[{"id":"%s/vm1","name":"vm1","location":"westeurope","zones":["2"],
+				"tags":{"owner":"lab"},"properties":{"hardwareProfile":{"vmSize":"Standard_B2s"},
+				"networkProfile":{"networkInterfaces":[{"id":"%s"}]},
+				"instanceView":{"statuses":[{"code":"ProvisioningState/succeeded"},
+				{"code":"PowerState/running"}]}}}]}`, sub, strings.ToUpper(nic))
+		case strings.HasSuffix(r.URL.Path, "/networkInterfaces"):
+			fmt.Fprintf(w, `{"value":[{"id":"%s","properties":{"ipConfigurations":[{"properties":{
+				"privateIPAddress":"10.2.0.4","subnet":{"id":"%s/subnets/default"}}}]}}]}`, nic, vnet)
+		case strings.HasSuffix(r.URL.Path, "/publicIPAddresses"):
+			fmt.Fprint(w, `{"value":[]}`)
+		case r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value":[],"nextLink":"http://%s%s?page=2"}`, r.Host, r.URL.Path)
+		default:
+			fmt.Fprintf(w, `{"value":[{"id":"%s","name":"lab-vnet","location":"westeurope",
+				"properties":{"addressSpace":{"addressPrefixes":["10.2.0.0/16"]}}}]}`, vnet)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	a := cloud.NewAzure("s1", "t1", "app", "secret", srv.Client())
+	a.Endpoint = srv.URL
+	a.LoginEndpoint = srv.URL
+
+	inv, err := a.Import(context.Background())
+	assert.Nil(err)
+	assert.Equal(1, len(inv.Instances))
+	assert.Equal(1, len(inv.Networks))
+
+	i := inv.Instances[0]
+	assert.Equal("vm1", i.Name)
+	assert.Equal("running", i.State)
+	assert.Equal("Standard_B2s", i.MachineType)
+	assert.Equal("2", i.Zone)
+	assert.Equal("10.2.0.4", i.PrivateIP.String())
+	assert.Nil(i.PublicIP)
+	assert.Equal(vnet, i.NetworkID)
+	assert.Equal("lab-vnet", i.Labels[cloud.LabelNetwork])
+	assert.Equal([]string{"10.2.0.0/16"}, inv.Networks[0].CIDRs)
+
+	a.ClientSecret = "wrong"
+	_, err = a.Import(context.Background())
+	assert.ErrorIs(err, cloud.ErrRequest)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+
+	i := cloud.NewInstance(cloud.ProviderAWS, "i-1", "web", nil)
+	i.Labels.Add("system.group", "lab")
+	assert.Nil(i.Validate(ctx))
+
+	i.InstanceID = ""
+	assert.ErrorIs(i.Validate(ctx), cloud.ErrInstanceID)
+
+	n := cloud.NewNetwork(cloud.ProviderGCP, "42", "default", nil)
+	n.Labels.Add("system.group", "lab")
+	assert.Nil(n.Validate(ctx))
+
+	n.CIDRs = []string{"10.0.0.0/33"}
+	assert.NotNil(n.Validate(ctx))
+
+	n.CIDRs = nil
+	n.Provider = ""
+	assert.ErrorIs(n.Validate(ctx), cloud.ErrProvider)
+}
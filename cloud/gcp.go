@@ -0,0 +1,286 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Endpoints and scope of the GCP importer.
+const (
+	GCPEndpoint  = "https://compute.googleapis.com"
+	GCPTokenURL  = "https://oauth2.googleapis.com/token" //nolint:gosec
+	gcpScope     = "https://www.googleapis.com/auth/compute.readonly"
+	gcpGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+var ErrGCPCredentials = errors.New("GCP credentials need a client email and a private key")
+
+// GCP imports the Compute Engine instances and VPC networks of a project.
+// It authenticates with the JSON key of a service account or, if there are
+// no credentials, with a given access token.
+type GCP struct {
+	Project     string
+	Credentials []byte
+	Token       string
+	Endpoint    string
+	Client      *http.Client
+}
+
+// NewGCP returns an importer of the project with the service account key.
+func NewGCP(project string, credentials []byte, client *http.Client) *GCP {
+	return &GCP{
+		Project:     project,
+		Credentials: credentials,
+		Token:       "",
+		Endpoint:    GCPEndpoint,
+		Client:      client,
+	}
+}
+
+type gcpKey struct {
+	ClientEmail string `json:"client_email"` //nolint:tagliatelle
+	PrivateKey  string `json:"private_key"`  //nolint:tagliatelle
+	TokenURI    string `json:"token_uri"`    //nolint:tagliatelle
+}
+
+type gcpInstance struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	MachineType string            `json:"machineType"`
+	Status      string            `json:"status"`
+	Zone        string            `json:"zone"`
+	Labels      map[string]string `json:"labels"`
+	Interfaces  []struct {
+		Network   string `json:"network"`
+		NetworkIP string `json:"networkIP"` //nolint:tagliatelle
+		Access    []struct {
+			NatIP string `json:"natIP"` //nolint:tagliatelle
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+type gcpInstances struct {
+	Items map[string]struct {
+		Instances []gcpInstance `json:"instances"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+type gcpNetworks struct {
+	Items []struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		SelfLink  string `json:"selfLink"`
+		IPv4Range string `json:"IPv4Range"` //nolint:tagliatelle
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+type gcpSubnetworks struct {
+	Items map[string]struct {
+		Subnetworks []struct {
+			Network     string `json:"network"`
+			IPCidrRange string `json:"ipCidrRange"`
+		} `json:"subnetworks"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (g *GCP) Import(ctx context.Context) (*Inventory, error) {
+	token, err := g.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{Instances: []*Instance{}, Networks: []*Network{}}
+	project := strings.TrimSuffix(g.Endpoint, "/") + "/compute/v1/projects/" + url.PathEscape(g.Project)
+
+	cidrs := map[string][]string{}
+
+	err = g.pages(ctx, project+"/aggregated/subnetworks", token, func(get func(interface{}) error) (string, error) {
+		page := new(gcpSubnetworks)
+		if err := get(page); err != nil {
+			return "", err
+		}
+
+		for _, scope := range page.Items {
+			for _, s := range scope.Subnetworks {
+				cidrs[s.Network] = append(cidrs[s.Network], s.IPCidrRange)
+			}
+		}
+
+		return page.NextPageToken, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.pages(ctx, project+"/global/networks", token, func(get func(interface{}) error) (string, error) {
+		page := new(gcpNetworks)
+		if err := get(page); err != nil {
+			return "", err
+		}
+
+		for _, item := range page.Items {
+			n := NewNetwork(ProviderGCP, item.ID, item.Name, nil)
+			if item.IPv4Range != "" {
+				n.CIDRs = append(n.CIDRs, item.IPv4Range)
+			}
+
+			n.CIDRs = append(n.CIDRs, cidrs[item.SelfLink]...)
+			inv.Networks = append(inv.Networks, n)
+		}
+
+		return page.NextPageToken, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.pages(ctx, project+"/aggregated/instances", token, func(get func(interface{}) error) (string, error) {
+		page := new(gcpInstances)
+		if err := get(page); err != nil {
+			return "", err
+		}
+
+		for _, scope := range page.Items {
+			for _, i := range scope.Instances {
+				inv.Instances = append(inv.Instances, gcpToInstance(i))
+			}
+		}
+
+		return page.NextPageToken, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func gcpToInstance(i gcpInstance) *Instance {
+	zone := lastSegment(i.Zone)
+	region := zone
+
+	if dash := strings.LastIndex(zone, "-"); dash > 0 {
+		region = zone[:dash]
+	}
+
+	labels := tagLabels(i.Labels)
+	labels.Add(LabelRegion, region)
+	labels.Add(LabelZone, zone)
+
+	instance := NewInstance(ProviderGCP, i.ID, i.Name, labels)
+	instance.Region = region
+	instance.Zone = zone
+	instance.MachineType = lastSegment(i.MachineType)
+	instance.State = strings.ToLower(i.Status)
+
+	if len(i.Interfaces) != 0 {
+		nic := i.Interfaces[0]
+		instance.PrivateIP = net.ParseIP(nic.NetworkIP)
+		instance.NetworkID = lastSegment(nic.Network)
+		instance.Labels.Add(LabelNetwork, instance.NetworkID)
+
+		if len(nic.Access) != 0 {
+			instance.PublicIP = net.ParseIP(nic.Access[0].NatIP)
+		}
+	}
+
+	return instance
+}
+
+// pages gets all pages of the list at the URL. The page function gets a page
+// and returns the token of the next one.
+func (g *GCP) pages(ctx context.Context, list string, token string,
+	page func(get func(interface{}) error) (string, error),
+) error {
+	for next := ""; ; {
+		u := list
+		if next != "" {
+			u += "?pageToken=" + url.QueryEscape(next)
+		}
+
+		var err error
+
+		next, err = page(func(out interface{}) error { return getJSON(ctx, g.Client, u, token, out) })
+		if err != nil {
+			return err
+		}
+
+		if next == "" {
+			return nil
+		}
+	}
+}
+
+// token returns an access token of the service account of the credentials.
+func (g *GCP) token(ctx context.Context) (string, error) {
+	if len(g.Credentials) == 0 && g.Token != "" {
+		return g.Token, nil
+	}
+
+	key := new(gcpKey)
+	if err := json.Unmarshal(g.Credentials, key); err != nil {
+		return "", err
+	}
+
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return "", ErrGCPCredentials
+	}
+
+	if key.TokenURI == "" {
+		key.TokenURI = GCPTokenURL
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   key.ClientEmail,
+		"scope": gcpScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", gcpGrantType)
+	form.Set("assertion", assertion)
+
+	return oauthToken(ctx, g.Client, key.TokenURI, form)
+}
+
+// oauthToken requests an OAuth2 access token with the form.
+func oauthToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	out := &struct {
+		AccessToken string `json:"access_token"` //nolint:tagliatelle
+	}{AccessToken: ""}
+
+	if err := do(client, req, out); err != nil {
+		return "", err
+	}
+
+	return out.AccessToken, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/project-safari/zebra/agent"
+	"github.com/spf13/cobra"
+)
+
+var version = "unknown"
+
+// DefaultInterval is how often the agent reports the facts of its host.
+const DefaultInterval = 15 * time.Minute
+
+// RegisterPath is the API endpoint agents register their host at.
+const RegisterPath = "/api/v1/agents/register"
+
+// TokenEnv is the environment variable with the service account token of
+// the agent, if neither the token nor the token file flag is set.
+const TokenEnv = "ZEBRA_AGENT_TOKEN" //nolint:gosec
+
+var (
+	ErrNoServer = errors.New("zebra server address is not configured")
+	ErrNoToken  = errors.New("agent token is not configured")
+	ErrInterval = errors.New("registration interval must be positive")
+)
+
+// StatusError is returned if the server refused the registration.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("registration failed: %s %s", http.StatusText(e.Code), e.Message)
+}
+
+// Agent registers the host it runs on with the zebra server.
+type Agent struct {
+	Server    string
+	Token     string
+	TokenFile string
+	Root      string
+	client    *http.Client
+}
+
+// NewAgent returns an agent for the server at the address. Servers with a
+// certificate that is not signed by a system CA need the CA certificate.
+func NewAgent(server string, caCert string) (*Agent, error) {
+	if server == "" {
+		return nil, ErrNoServer
+	}
+
+	client := new(http.Client)
+	client.Timeout = time.Minute
+
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pem)
+
+		transport := new(http.Transport)
+		transport.TLSClientConfig = new(tls.Config)
+		transport.TLSClientConfig.RootCAs = pool
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS13
+		client.Transport = transport
+	}
+
+	return &Agent{
+		Server:    strings.TrimSuffix(server, "/"),
+		Token:     "",
+		TokenFile: "",
+		Root:      "/",
+		client:    client,
+	}, nil
+}
+
+// token returns the service account token of the agent. The token file is
+// read for each registration, so that rotated tokens are picked up.
+func (a *Agent) token() (string, error) {
+	if a.TokenFile != "" {
+		data, err := os.ReadFile(a.TokenFile)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	if a.Token == "" {
+		return "", ErrNoToken
+	}
+
+	return a.Token, nil
+}
+
+// Register gathers the facts of the host and registers or updates the host
+// with the server.
+func (a *Agent) Register(ctx context.Context) (*agent.Facts, error) {
+	token, err := a.token()
+	if err != nil {
+		return nil, err
+	}
+
+	facts, err := agent.Gather(a.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := facts.Validate(); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(facts)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Server+RegisterPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "zebra-agent/"+version)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg := new(bytes.Buffer)
+		_, _ = msg.ReadFrom(resp.Body)
+
+		return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(msg.String())}
+	}
+
+	return facts, nil
+}
+
+// Run registers the host now and then every interval until the context is
+// done. Failed registrations are retried at the next interval.
+func (a *Agent) Run(ctx context.Context, interval time.Duration, log func(string, ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if facts, err := a.Register(ctx); err != nil {
+			log("registration failed: %v\n", err)
+		} else {
+			log("registered %s (%s)\n", facts.Hostname, facts.ID())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// New returns the root command of the agent.
+func New() *cobra.Command {
+	name := filepath.Base(os.Args[0])
+	rootCmd := &cobra.Command{
+		Use:          name,
+		Short:        "zebra agent, registers the host it runs on with the zebra server",
+		Version:      version + "\n",
+		RunE:         run,
+		SilenceUsage: true,
+	}
+
+	rootCmd.SetVersionTemplate(version + "\n")
+	rootCmd.Flags().StringP("server", "s", "", "address of the zebra server")
+	rootCmd.Flags().String("ca-cert", "", "CA certificate of the zebra server")
+	rootCmd.Flags().String("token", "", "service account token of the agent (default $"+TokenEnv+")")
+	rootCmd.Flags().String("token-file", "", "file with the service account token of the agent")
+	rootCmd.Flags().Duration("interval", DefaultInterval, "interval between registrations")
+	rootCmd.Flags().Bool("once", false, "register once and exit")
+	rootCmd.Flags().String("root", "/", "root of the file system the host facts are read from")
+
+	return rootCmd
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	server, _ := cmd.Flags().GetString("server")
+	caCert, _ := cmd.Flags().GetString("ca-cert")
+
+	a, err := NewAgent(server, caCert)
+	if err != nil {
+		return err
+	}
+
+	a.Token, _ = cmd.Flags().GetString("token")
+	a.TokenFile, _ = cmd.Flags().GetString("token-file")
+	a.Root, _ = cmd.Flags().GetString("root")
+
+	if a.Token == "" {
+		a.Token = os.Getenv(TokenEnv)
+	}
+
+	log := func(format string, args ...interface{}) {
+		fmt.Fprintf(cmd.OutOrStdout(), format, args...)
+	}
+
+	if once, _ := cmd.Flags().GetBool("once"); once {
+		facts, err := a.Register(cmd.Context())
+		if err == nil {
+			log("registered %s (%s)\n", facts.Hostname, facts.ID())
+		}
+
+		return err
+	}
+
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if interval <= 0 {
+		return ErrInterval
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a.Run(ctx, interval, log)
+
+	return nil
+}
+
+func main() {
+	if err := New().Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
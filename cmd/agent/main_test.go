@@ -0,0 +1,145 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer accepts registrations with the token.
+type fakeServer struct {
+	lock  sync.Mutex
+	token string
+	facts []agent.Facts
+}
+
+func (s *fakeServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if req.URL.Path != RegisterPath || req.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(res, "bad token", http.StatusUnauthorized)
+
+		return
+	}
+
+	facts := agent.Facts{}
+	if err := json.NewDecoder(req.Body).Decode(&facts); err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	s.facts = append(s.facts, facts)
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (s *fakeServer) registered() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.facts)
+}
+
+func testRoot(assert *assert.Assertions, t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	machineID := filepath.Join(root, agent.MachineIDFile)
+
+	assert.Nil(os.MkdirAll(filepath.Dir(machineID), 0o755))
+	assert.Nil(os.WriteFile(machineID, []byte("0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f\n"), 0o600))
+
+	return root
+}
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	fake := &fakeServer{lock: sync.Mutex{}, token: "zsa_token", facts: nil}
+	server := httptest.NewServer(fake)
+
+	defer server.Close()
+
+	_, err := NewAgent("", "")
+	assert.ErrorIs(err, ErrNoServer)
+
+	_, err = NewAgent(server.URL, "missing.crt")
+	assert.NotNil(err)
+
+	a, err := NewAgent(server.URL+"/", "")
+	assert.Nil(err)
+
+	a.Root = testRoot(assert, t)
+
+	_, err = a.Register(context.Background())
+	assert.ErrorIs(err, ErrNoToken)
+
+	a.Token = "wrong"
+	_, err = a.Register(context.Background())
+
+	statusErr := new(StatusError)
+	assert.ErrorAs(err, &statusErr)
+	assert.Equal(http.StatusUnauthorized, statusErr.Code)
+	assert.Equal("bad token", statusErr.Message)
+
+	// The token file takes precedence and is read on each registration
+	a.TokenFile = filepath.Join(t.TempDir(), "token")
+	assert.Nil(os.WriteFile(a.TokenFile, []byte("zsa_token\n"), 0o600))
+
+	facts, err := a.Register(context.Background())
+	assert.Nil(err)
+	assert.Equal("0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f", facts.ID())
+	assert.Equal(1, fake.registered())
+	assert.Equal(facts.Hostname, fake.facts[0].Hostname)
+
+	// Hosts without an identity are not registered
+	a.Root = t.TempDir()
+	_, err = a.Register(context.Background())
+	assert.ErrorIs(err, agent.ErrNoIdentity)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	fake := &fakeServer{lock: sync.Mutex{}, token: "zsa_token", facts: nil}
+	server := httptest.NewServer(fake)
+
+	defer server.Close()
+
+	cmd := New()
+	out := new(bytes.Buffer)
+	cmd.SetOut(out)
+	cmd.SetArgs([]string{"--server", server.URL, "--token", "zsa_token", "--root", testRoot(assert, t), "--once"})
+	assert.Nil(cmd.Execute())
+	assert.Contains(out.String(), "registered")
+	assert.Equal(1, fake.registered())
+
+	cmd = New()
+	cmd.SetArgs([]string{"--server", server.URL, "--interval", "0s"})
+	assert.ErrorIs(cmd.Execute(), ErrInterval)
+
+	a, err := NewAgent(server.URL, "")
+	assert.Nil(err)
+
+	a.Token = "zsa_token"
+	a.Root = testRoot(assert, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	a.Run(ctx, 10*time.Millisecond, func(string, ...interface{}) {})
+	assert.LessOrEqual(2, fake.registered())
+}
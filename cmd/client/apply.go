@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoFiles       = errors.New("no resource files given, use -f")
+	ErrApplyFormat   = errors.New("resource file must contain resources or a map of resource lists")
+	ErrApplyNoID     = errors.New("applied resources must have an id")
+	ErrPruneSelector = errors.New("prune requires at least one label selector")
+	ErrSelector      = errors.New("label selector must be of the form key=value")
+)
+
+// Apply results of a single resource.
+const (
+	Created    = "created"
+	Configured = "configured"
+	Unchanged  = "unchanged"
+	Pruned     = "pruned"
+)
+
+// appliedState holds the configuration of each resource as it was last
+// applied, by resource ID. It is kept next to the client config and is used
+// to find fields that were removed from the files since the last apply.
+type appliedState map[string]map[string]interface{}
+
+// applyObject is a resource read from a file, with the fields as given by the
+// user and the resource to be posted to the server after merging with the
+// live state.
+type applyObject struct {
+	desired map[string]interface{}
+	live    zebra.Resource
+	merged  zebra.Resource
+	changes []zebra.Change
+}
+
+// queryRequest is the body of a resource query.
+type queryRequest struct {
+	IDs    []string      `json:"ids,omitempty"`
+	Types  []string      `json:"types,omitempty"`
+	Labels []zebra.Query `json:"labels,omitempty"`
+}
+
+func NewApply() *cobra.Command {
+	applyCmd := &cobra.Command{
+		Use:          "apply",
+		Short:        "create or update resources from YAML or JSON files",
+		RunE:         applyResources,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+
+	applyCmd.Flags().StringArrayP("filename", "f", nil, "resource file, - for stdin")
+	applyCmd.Flags().Bool("dry-run", false, "only show the changes, do not apply them")
+	applyCmd.Flags().Bool("prune", false, "delete applied resources matching the selector that are no longer in the files")
+	applyCmd.Flags().StringArrayP("selector", "l", nil, "label selector key=value for prune")
+	_ = applyCmd.RegisterFlagCompletionFunc("selector", completeLabels)
+
+	return applyCmd
+}
+
+func applyResources(cmd *cobra.Command, args []string) error {
+	cfgFile := cmd.Flag("config").Value.String()
+	files, _ := cmd.Flags().GetStringArray("filename")
+	selectors, _ := cmd.Flags().GetStringArray("selector")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	prune, _ := cmd.Flags().GetBool("prune")
+
+	if len(files) == 0 {
+		return ErrNoFiles
+	}
+
+	queries, err := parseSelectors(selectors)
+	if err != nil {
+		return err
+	}
+
+	if prune && len(queries) == 0 {
+		return ErrPruneSelector
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	desired, err := readResourceFiles(cmd.InOrStdin(), files)
+	if err != nil {
+		return err
+	}
+
+	stateFile := cfgFile + ".applied"
+
+	state, err := loadApplied(stateFile)
+	if err != nil {
+		return err
+	}
+
+	a := &applier{client: client, state: state, out: cmd.OutOrStdout(), dryRun: dryRun}
+
+	err = a.apply(desired)
+	if err == nil && prune {
+		err = a.prune(desired, queries)
+	}
+
+	if dryRun {
+		return err
+	}
+
+	// Keep the state of the resources posted before a failure, so that they
+	// are still known to the next apply and prune.
+	if saveErr := state.save(stateFile); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	return err
+}
+
+// applier applies resources to the server in dependency order.
+type applier struct {
+	client *Client
+	state  appliedState
+	out    io.Writer
+	dryRun bool
+}
+
+func (a *applier) apply(desired []map[string]interface{}) error {
+	ids := make([]string, 0, len(desired))
+	for _, d := range desired {
+		ids = append(ids, fmt.Sprint(d["id"]))
+	}
+
+	live, err := a.query(&queryRequest{IDs: ids})
+	if err != nil {
+		return err
+	}
+
+	objects := make(map[string]*applyObject, len(desired))
+	merged := make([]zebra.Resource, 0, len(desired))
+
+	for _, d := range desired {
+		obj, err := newApplyObject(d, live[fmt.Sprint(d["id"])], a.state)
+		if err != nil {
+			return err
+		}
+
+		objects[obj.merged.GetID()] = obj
+		merged = append(merged, obj.merged)
+	}
+
+	sorted, err := zebra.SortByReferences(merged)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range sorted {
+		obj := objects[res.GetID()]
+		result := obj.result()
+
+		printResult(a.out, res, result, a.dryRun)
+		printChanges(a.out, obj.changes)
+
+		if a.dryRun || result == Unchanged {
+			continue
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+		resMap.Add(res, res.GetType())
+
+		if _, err := a.client.Post("api/v1/resources", resMap, nil); err != nil {
+			return err
+		}
+
+		a.state[res.GetID()] = obj.desired
+	}
+
+	return nil
+}
+
+// prune deletes the resources that were applied before and match the label
+// queries but are no longer part of the applied resources. Resources that
+// were never applied by this client are left alone, even if they match.
+// Resources are deleted before the resources they refer to.
+func (a *applier) prune(desired []map[string]interface{}, queries []zebra.Query) error {
+	keep := make(map[string]bool, len(desired))
+	for _, d := range desired {
+		keep[fmt.Sprint(d["id"])] = true
+	}
+
+	ids := make([]string, 0, len(a.state))
+
+	for id := range a.state {
+		if !keep[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	matched, err := a.query(&queryRequest{IDs: ids, Labels: queries})
+	if err != nil {
+		return err
+	}
+
+	victims := make([]zebra.Resource, 0, len(matched))
+
+	for id, res := range matched {
+		if !keep[id] {
+			victims = append(victims, res)
+		}
+	}
+
+	sort.Slice(victims, func(i, j int) bool { return victims[i].GetID() < victims[j].GetID() })
+
+	victims, err = zebra.SortByReferences(victims)
+	if err != nil {
+		return err
+	}
+
+	for i := len(victims) - 1; i >= 0; i-- {
+		res := victims[i]
+
+		printResult(a.out, res, Pruned, a.dryRun)
+
+		if a.dryRun {
+			continue
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+		resMap.Add(res, res.GetType())
+
+		if _, err := a.client.Delete("api/v1/resources", resMap, nil); err != nil {
+			return err
+		}
+
+		delete(a.state, res.GetID())
+	}
+
+	return nil
+}
+
+// query returns the resources matching the query, by resource ID.
+func (a *applier) query(qr *queryRequest) (map[string]zebra.Resource, error) {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	if _, err := a.client.Get("api/v1/resources", qr, resMap); err != nil {
+		return nil, err
+	}
+
+	resources := map[string]zebra.Resource{}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			resources[res.GetID()] = res
+		}
+	}
+
+	return resources, nil
+}
+
+// newApplyObject computes the resource to apply with a three-way merge of the
+// desired fields, the live resource and the fields last applied.
+func newApplyObject(desired map[string]interface{}, live zebra.Resource,
+	state appliedState,
+) (*applyObject, error) {
+	values := desired
+
+	if live != nil {
+		liveValues, err := toValues(live)
+		if err != nil {
+			return nil, err
+		}
+
+		values = merge(liveValues, state[live.GetID()], desired)
+	}
+
+	merged, err := toResource(values)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := zebra.Diff(live, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	return &applyObject{desired: desired, live: live, merged: merged, changes: changes}, nil
+}
+
+func (obj *applyObject) result() string {
+	switch {
+	case obj.live == nil:
+		return Created
+	case len(obj.changes) == 0:
+		return Unchanged
+	default:
+		return Configured
+	}
+}
+
+// merge returns the desired values applied on top of the live values. Values
+// that were applied last time but are no longer desired are removed, values
+// that were never applied (set by other users or by the server) are kept.
+func merge(live, last, desired map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(live))
+
+	for key, val := range live {
+		merged[key] = val
+	}
+
+	for key := range last {
+		if _, ok := desired[key]; !ok {
+			delete(merged, key)
+		}
+	}
+
+	for key, val := range desired {
+		desiredMap, ok := val.(map[string]interface{})
+		liveMap, liveOk := merged[key].(map[string]interface{})
+
+		if ok && liveOk {
+			lastMap, _ := last[key].(map[string]interface{})
+			merged[key] = merge(liveMap, lastMap, desiredMap)
+
+			continue
+		}
+
+		merged[key] = val
+	}
+
+	return merged
+}
+
+func toValues(res zebra.Resource) (map[string]interface{}, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	err = json.Unmarshal(data, &values)
+
+	return values, err
+}
+
+func toResource(values map[string]interface{}) (zebra.Resource, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := zebra.DecodeResource(store.DefaultFactory(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.GetID() == "" {
+		return nil, ErrApplyNoID
+	}
+
+	return res, nil
+}
+
+// readResourceFiles reads the resources in the given files. Each file is YAML
+// (or JSON) and may hold several documents. A document is either a single
+// resource, a list of resources or a map of resource type to list of
+// resources, as returned by the server.
+func readResourceFiles(stdin io.Reader, files []string) ([]map[string]interface{}, error) {
+	resources := []map[string]interface{}{}
+
+	for _, file := range files {
+		var (
+			data []byte
+			err  error
+		)
+
+		if file == "-" {
+			data, err = ioutil.ReadAll(stdin)
+		} else {
+			data, err = ioutil.ReadFile(file)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+		for {
+			var doc interface{}
+
+			err := decoder.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+
+			docResources, err := documentResources(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+
+			resources = append(resources, docResources...)
+		}
+	}
+
+	for _, res := range resources {
+		if id, ok := res["id"].(string); !ok || id == "" {
+			return nil, ErrApplyNoID
+		}
+	}
+
+	return resources, nil
+}
+
+func documentResources(doc interface{}) ([]map[string]interface{}, error) {
+	resources := []map[string]interface{}{}
+
+	switch d := doc.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range d {
+			res, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, ErrApplyFormat
+			}
+
+			resources = append(resources, res)
+		}
+	case map[string]interface{}:
+		if _, ok := d["type"]; ok {
+			return append(resources, d), nil
+		}
+
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			list, err := documentResources(d[key])
+			if err != nil {
+				return nil, err
+			}
+
+			resources = append(resources, list...)
+		}
+	default:
+		return nil, ErrApplyFormat
+	}
+
+	return resources, nil
+}
+
+func parseSelectors(selectors []string) ([]zebra.Query, error) {
+	queries := make([]zebra.Query, 0, len(selectors))
+
+	for _, s := range selectors {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return nil, ErrSelector
+		}
+
+		queries = append(queries, zebra.Query{Key: key, Op: zebra.MatchEqual, Values: []string{value}})
+	}
+
+	return queries, nil
+}
+
+func loadApplied(file string) (appliedState, error) {
+	state := appliedState{}
+
+	data, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return state, json.Unmarshal(data, &state)
+}
+
+func (s appliedState) save(file string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, data, ReadOnly)
+}
+
+func printResult(out io.Writer, res zebra.Resource, result string, dryRun bool) {
+	if dryRun {
+		result += " (dry run)"
+	}
+
+	fmt.Fprintf(out, "%s/%s %s\n", res.GetType(), res.GetID(), result)
+}
+
+func printChanges(out io.Writer, changes []zebra.Change) {
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(out, "  + %s: %s\n", c.Path, jsonValue(c.New))
+		case c.New == nil:
+			fmt.Fprintf(out, "  - %s: %s\n", c.Path, jsonValue(c.Old))
+		default:
+			fmt.Fprintf(out, "  ~ %s: %s -> %s\n", c.Path, jsonValue(c.Old), jsonValue(c.New))
+		}
+	}
+}
+
+func jsonValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,300 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// applyServer is a minimal in memory resource server.
+type applyServer struct {
+	lock      sync.Mutex
+	resources map[string]zebra.Resource
+}
+
+func (s *applyServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	switch {
+	case req.URL.Path == "/api/v1/types":
+		data, _ := json.Marshal(map[string]interface{}{"types": store.DefaultFactory().Types()})
+		_, _ = rw.Write(data)
+	case req.URL.Path == "/api/v1/labels":
+		labels := map[string][]string{}
+		for _, res := range s.resources {
+			for key, value := range res.GetLabels() {
+				labels[key] = append(labels[key], value)
+			}
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{"labels": labels})
+		_, _ = rw.Write(data)
+	case strings.HasSuffix(req.URL.Path, "/history"):
+		_, _ = rw.Write([]byte(`[{"version": 1, "actor": "loki@asgard.io"}]`))
+	case req.Method == "GET":
+		qr := new(queryRequest)
+		if json.NewDecoder(req.Body).Decode(qr) != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		for _, res := range s.resources {
+			if (len(qr.IDs) != 0 && !zebra.IsIn(res.GetID(), qr.IDs)) ||
+				(len(qr.Types) != 0 && !zebra.IsIn(res.GetType(), qr.Types)) {
+				continue
+			}
+
+			matched := true
+			for _, q := range qr.Labels {
+				matched = matched && res.GetLabels().MatchEqual(q.Key, q.Values[0])
+			}
+
+			if matched {
+				resMap.Add(res, res.GetType())
+			}
+		}
+
+		data, _ := json.Marshal(resMap)
+		_, _ = rw.Write(data)
+	default:
+		if json.NewDecoder(req.Body).Decode(resMap) != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		for _, l := range resMap.Resources {
+			for _, res := range l.Resources {
+				if req.Method == "POST" && res.GetID() == "fail" {
+					rw.WriteHeader(http.StatusInternalServerError)
+
+					return
+				} else if req.Method == "POST" {
+					s.resources[res.GetID()] = res
+				} else {
+					delete(s.resources, res.GetID())
+				}
+			}
+		}
+	}
+}
+
+func makeApplier(assert *assert.Assertions, url string, out *bytes.Buffer) *applier {
+	key, err := auth.Load(testUserKeyFile)
+	assert.Nil(err)
+
+	client, err := NewClient(&Config{
+		ServerAddress: url,
+		Key:           key,
+		Email:         "loki@asgard.io",
+		CACert:        testCACertFile,
+	})
+	assert.Nil(err)
+
+	return &applier{client: client, state: appliedState{}, out: out, dryRun: false}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := &applyServer{resources: map[string]zebra.Resource{}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	out := new(bytes.Buffer)
+	a := makeApplier(assert, server.URL, out)
+
+	// The rack is listed first but must be created after its lab
+	files := `
+- {id: rack1, type: Rack, name: rack1, row: r1, labels: {system.group: g, system.parent: lab1, color: red}}
+- {id: lab1, type: Lab, name: lab1, labels: {system.group: g, managed: "yes"}}
+`
+	desired, err := readResourceFiles(strings.NewReader(files), []string{"-"})
+	assert.Nil(err)
+	assert.Nil(a.apply(desired))
+	assert.Equal(2, len(s.resources))
+	assert.True(strings.Index(out.String(), "Lab/lab1 created") < strings.Index(out.String(), "Rack/rack1 created"))
+
+	// Someone else sets the rack owner, which is not managed by apply
+	rack, ok := s.resources["rack1"].(*dc.Rack)
+	assert.True(ok)
+
+	rack.Status = zebra.DefaultStatus()
+	rack.Status.UsedBy = "thor@asgard.io"
+
+	// The color label is no longer applied, the row is changed
+	files = `
+- {id: rack1, type: Rack, name: rack1, row: r2, labels: {system.group: g, system.parent: lab1}}
+- {id: lab1, type: Lab, name: lab1, labels: {system.group: g, managed: "yes"}}
+`
+	out.Reset()
+	desired, err = readResourceFiles(strings.NewReader(files), []string{"-"})
+	assert.Nil(err)
+	assert.Nil(a.apply(desired))
+	assert.Contains(out.String(), "Lab/lab1 unchanged")
+	assert.Contains(out.String(), "Rack/rack1 configured")
+	assert.Contains(out.String(), `~ row: "r1" -> "r2"`)
+	assert.Contains(out.String(), `- labels.color: "red"`)
+
+	rack, ok = s.resources["rack1"].(*dc.Rack)
+	assert.True(ok)
+	assert.Equal("r2", rack.Row)
+	assert.False(rack.Labels.HasKey("color"))
+	assert.Equal("thor@asgard.io", rack.Status.UsedBy)
+
+	// Resources matching the selector that were never applied are not pruned
+	other := dc.NewLab("other", zebra.Labels{"system.group": "g", "managed": "yes"})
+	s.resources[other.ID] = other
+
+	queries, err := parseSelectors([]string{"managed=yes"})
+	assert.Nil(err)
+
+	assert.Nil(a.prune(desired, queries))
+	assert.Equal(3, len(s.resources))
+
+	// The lab is no longer in the files, dry run prunes nothing
+	desired = desired[:1]
+
+	out.Reset()
+	a.dryRun = true
+	assert.Nil(a.prune(desired, queries))
+	assert.Contains(out.String(), "Lab/lab1 pruned (dry run)")
+	assert.NotContains(out.String(), other.ID)
+	assert.Equal(3, len(s.resources))
+
+	a.dryRun = false
+	assert.Nil(a.prune(desired, queries))
+	assert.Equal(2, len(s.resources))
+	assert.NotNil(s.resources[other.ID])
+	assert.NotContains(a.state, "lab1")
+}
+
+func TestApplyPartial(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := &applyServer{resources: map[string]zebra.Resource{}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	a := makeApplier(assert, server.URL, new(bytes.Buffer))
+
+	// The rack is posted after its lab and fails, the lab stays applied
+	files := `
+- {id: fail, type: Rack, name: fail, labels: {system.group: g, system.parent: lab1}}
+- {id: lab1, type: Lab, name: lab1, labels: {system.group: g}}
+`
+	desired, err := readResourceFiles(strings.NewReader(files), []string{"-"})
+	assert.Nil(err)
+	assert.NotNil(a.apply(desired))
+	assert.Equal(1, len(s.resources))
+	assert.Contains(a.state, "lab1")
+	assert.NotContains(a.state, "fail")
+
+	file := "test_apply.applied"
+
+	t.Cleanup(func() { os.Remove(file) })
+
+	assert.Nil(a.state.save(file))
+
+	state, err := loadApplied(file)
+	assert.Nil(err)
+	assert.Contains(state, "lab1")
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	live := map[string]interface{}{
+		"a": "1", "b": "2", "c": "3",
+		"m": map[string]interface{}{"x": "1", "y": "2"},
+	}
+	last := map[string]interface{}{
+		"a": "1", "b": "2",
+		"m": map[string]interface{}{"x": "1"},
+	}
+	desired := map[string]interface{}{
+		"a": "9",
+		"m": map[string]interface{}{"z": "3"},
+	}
+
+	assert.Equal(map[string]interface{}{
+		"a": "9", "c": "3",
+		"m": map[string]interface{}{"y": "2", "z": "3"},
+	}, merge(live, last, desired))
+}
+
+func TestReadResourceFiles(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := "test_apply.yaml"
+
+	t.Cleanup(func() { os.Remove(file) })
+
+	// A server style map and a single resource in two documents
+	data := `{"Lab": [{"id": "lab1", "type": "Lab"}, {"id": "lab2", "type": "Lab"}]}
+---
+id: rack1
+type: Rack
+`
+	assert.Nil(os.WriteFile(file, []byte(data), ReadOnly))
+
+	resources, err := readResourceFiles(nil, []string{file})
+	assert.Nil(err)
+	assert.Equal(3, len(resources))
+
+	_, err = readResourceFiles(strings.NewReader("[1, 2]"), []string{"-"})
+	assert.ErrorIs(err, ErrApplyFormat)
+
+	_, err = readResourceFiles(strings.NewReader("{type: Lab}"), []string{"-"})
+	assert.Equal(ErrApplyNoID, err)
+
+	_, err = readResourceFiles(strings.NewReader("{bad"), []string{"-"})
+	assert.NotNil(err)
+
+	_, err = readResourceFiles(nil, []string{"doesnotexist.yaml"})
+	assert.NotNil(err)
+
+	_, err = parseSelectors([]string{"novalue"})
+	assert.Equal(ErrSelector, err)
+}
+
+func TestApplyCmd(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	argLock.Lock()
+	defer argLock.Unlock()
+
+	os.Args = append([]string{"zebra"}, "apply")
+	assert.Equal(ErrNoFiles, execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "apply", "-f", "x.yaml", "--prune")
+	assert.Equal(ErrPruneSelector, execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "apply", "-f", "x.yaml", "-l", "bad")
+	assert.Equal(ErrSelector, execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "-c", "junk.yaml", "apply", "-f", "x.yaml")
+	assert.NotNil(execRootCmd())
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrBrowseCommand = errors.New("unknown command, type help for a list of commands")
+	ErrBrowseArgs    = errors.New("wrong number of arguments, type help for usage")
+	ErrNotLease      = errors.New("resource is not a lease owned by the user")
+)
+
+const browseHelp = `commands:
+  types                         list resource types
+  ls [type]                     list resources of the type, using the filters
+  filter [key=value]            add a label filter, without argument clear all filters
+  show <id>                     show resource details
+  history <id>                  show the versions of a resource
+  lease <type> [count] [group]  request a lease
+  release <id>                  release a lease
+  help                          show this help
+  quit                          exit the browser`
+
+func NewBrowse() *cobra.Command {
+	browseCmd := &cobra.Command{
+		Use:          "browse",
+		Short:        "interactive resource browser",
+		RunE:         browse,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+
+	browseCmd.Flags().Bool("plain", false, "line oriented prompt instead of the full screen view")
+
+	return browseCmd
+}
+
+func browse(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	b := &browser{cfg: cfg, client: client, out: cmd.OutOrStdout(), filters: []zebra.Query{}, resType: ""}
+
+	// The full screen view needs a terminal, scripts and pipes get the prompt
+	plain, _ := cmd.Flags().GetBool("plain")
+	if in, ok := cmd.InOrStdin().(*os.File); ok && !plain && isTerminal(in) {
+		return browseScreen(b, in, cmd.OutOrStdout())
+	}
+
+	return b.run(cmd.InOrStdin())
+}
+
+// browser is an interactive session. It keeps the current type and label
+// filters between commands so that listings can be narrowed down step by
+// step. It runs the commands of the line oriented prompt, which the full
+// screen view is built on.
+type browser struct {
+	cfg     *Config
+	client  *Client
+	out     io.Writer
+	filters []zebra.Query
+	resType string
+}
+
+func (b *browser) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+
+	for b.prompt(); scanner.Scan(); b.prompt() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return nil
+		}
+
+		if err := b.exec(fields[0], fields[1:]); err != nil {
+			fmt.Fprintln(b.out, "error:", err)
+		}
+	}
+
+	fmt.Fprintln(b.out)
+
+	return scanner.Err()
+}
+
+func (b *browser) prompt() {
+	p := "zebra"
+	if b.resType != "" {
+		p += "/" + b.resType
+	}
+
+	for _, f := range b.filters {
+		p += " " + f.Key + "=" + f.Values[0]
+	}
+
+	fmt.Fprint(b.out, p+"> ")
+}
+
+func (b *browser) exec(command string, args []string) error {
+	switch command {
+	case "help":
+		fmt.Fprintln(b.out, browseHelp)
+
+		return nil
+	case "types":
+		return b.types()
+	case "ls":
+		if len(args) > 1 {
+			return ErrBrowseArgs
+		}
+
+		if len(args) == 1 {
+			b.resType = args[0]
+		}
+
+		return b.list()
+	case "filter":
+		return b.filter(args)
+	case "show":
+		return b.withID(args, b.show)
+	case "history":
+		return b.withID(args, b.history)
+	case "release":
+		return b.withID(args, b.release)
+	case "lease":
+		return b.lease(args)
+	}
+
+	return ErrBrowseCommand
+}
+
+// withID calls f with the single resource ID argument.
+func (b *browser) withID(args []string, f func(string) error) error {
+	if len(args) != 1 {
+		return ErrBrowseArgs
+	}
+
+	return f(args[0])
+}
+
+// typeList returns the resource types, by name.
+func (b *browser) typeList() ([]zebra.Type, error) {
+	typeRes := &struct {
+		Types []zebra.Type `json:"types"`
+	}{Types: []zebra.Type{}}
+
+	if _, err := b.client.Get("api/v1/types", struct{}{}, typeRes); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(typeRes.Types, func(i, j int) bool { return typeRes.Types[i].Name < typeRes.Types[j].Name })
+
+	return typeRes.Types, nil
+}
+
+func (b *browser) types() error {
+	types, err := b.typeList()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0) //nolint:gomnd
+
+	for _, t := range types {
+		fmt.Fprintf(w, "%s\t%s\n", t.Name, t.Description)
+	}
+
+	return w.Flush()
+}
+
+// query returns the resources of the current type and filters, by type and
+// ID.
+func (b *browser) query() ([]zebra.Resource, error) {
+	qr := &queryRequest{Labels: b.filters}
+	if b.resType != "" {
+		qr.Types = []string{b.resType}
+	}
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	if _, err := b.client.Get("api/v1/resources", qr, resMap); err != nil {
+		return nil, err
+	}
+
+	resources := []zebra.Resource{}
+	for _, l := range resMap.Resources {
+		resources = append(resources, l.Resources...)
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].GetType() != resources[j].GetType() {
+			return resources[i].GetType() < resources[j].GetType()
+		}
+
+		return resources[i].GetID() < resources[j].GetID()
+	})
+
+	return resources, nil
+}
+
+func (b *browser) list() error {
+	resources, err := b.query()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "TYPE\tID\tNAME\tOWNER\tSTATE")
+
+	for _, res := range resources {
+		name, owner, state := resourceSummary(res)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", res.GetType(), res.GetID(), name, owner, state)
+	}
+
+	return w.Flush()
+}
+
+// resourceSummary returns the name, owner and state of the resource.
+func resourceSummary(res zebra.Resource) (string, string, string) {
+	name := ""
+	if values, err := toValues(res); err == nil {
+		name, _ = values["name"].(string)
+	}
+
+	owner, state := "", ""
+	if status := res.GetStatus(); status != nil {
+		owner, state = status.UsedBy, status.State.String()
+	}
+
+	return name, owner, state
+}
+
+func (b *browser) filter(args []string) error {
+	if len(args) == 0 {
+		b.filters = []zebra.Query{}
+
+		return nil
+	}
+
+	queries, err := parseSelectors(args)
+	if err != nil {
+		return err
+	}
+
+	b.filters = append(b.filters, queries...)
+
+	return nil
+}
+
+func (b *browser) find(resID string) (zebra.Resource, error) {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	if _, err := b.client.Get("api/v1/resources", &queryRequest{IDs: []string{resID}}, resMap); err != nil {
+		return nil, err
+	}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			return res, nil
+		}
+	}
+
+	return nil, zebra.ErrNotFound
+}
+
+func (b *browser) show(resID string) error {
+	res, err := b.find(resID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(b.out, string(data))
+
+	return nil
+}
+
+func (b *browser) history(resID string) error {
+	versions := []struct {
+		Version int       `json:"version"`
+		Time    time.Time `json:"time"`
+		Actor   string    `json:"actor"`
+		Deleted bool      `json:"deleted"`
+	}{}
+
+	if _, err := b.client.Get("api/v1/resources/"+resID+"/history", nil, &versions); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "VERSION\tTIME\tACTOR\tDELETED")
+
+	for _, v := range versions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", v.Version, v.Time.Format(time.RFC3339), v.Actor, v.Deleted)
+	}
+
+	return w.Flush()
+}
+
+func (b *browser) lease(args []string) error {
+	if len(args) == 0 || len(args) > 3 { //nolint:gomnd
+		return ErrBrowseArgs
+	}
+
+	req := &lease.ResourceReq{Type: args[0], Group: "global", Count: DefaultResourceCount}
+
+	if len(args) > 1 {
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+
+		req.Count = count
+	}
+
+	if len(args) > 2 { //nolint:gomnd
+		req.Group = args[2]
+	}
+
+	resMap := leaseMap(b.cfg, req)
+	if _, err := b.client.Post("api/v1/resources", resMap, nil); err != nil {
+		return err
+	}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			fmt.Fprintln(b.out, "lease requested:", res.GetID())
+		}
+	}
+
+	return nil
+}
+
+func (b *browser) release(resID string) error {
+	res, err := b.find(resID)
+	if err != nil {
+		return err
+	}
+
+	if l, ok := res.(*lease.Lease); !ok || l.Owner() != b.cfg.Email {
+		return ErrNotLease
+	}
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	resMap.Add(res, res.GetType())
+
+	if _, err := b.client.Delete("api/v1/resources", resMap, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(b.out, "lease released:", resID)
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrowse(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+	other := lease.NewLease("thor@asgard.io", 0, nil)
+
+	s := &applyServer{resources: map[string]zebra.Resource{
+		lab.ID:   lab,
+		rack.ID:  rack,
+		other.ID: other,
+	}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	out := new(bytes.Buffer)
+	a := makeApplier(assert, server.URL, out)
+	b := &browser{cfg: a.client.cfg, client: a.client, out: out, filters: []zebra.Query{}, resType: ""}
+
+	assert.Nil(b.run(strings.NewReader("help\n\ntypes\nfilter color=red\nls\nquit\nls\n")))
+	assert.Contains(out.String(), "request a lease")
+	assert.Contains(out.String(), "VLANPool")
+	assert.Contains(out.String(), "zebra color=red> ")
+	assert.Contains(out.String(), lab.ID)
+	assert.NotContains(out.String(), rack.ID)
+
+	out.Reset()
+	assert.Nil(b.run(strings.NewReader("filter\nls Rack\nshow " + rack.ID + "\nhistory " + rack.ID + "\n")))
+	assert.Contains(out.String(), "zebra/Rack> ")
+	assert.Contains(out.String(), `"row": "r1"`)
+	assert.Contains(out.String(), "loki@asgard.io")
+	assert.NotContains(out.String(), "error:")
+
+	// Lease and release
+	out.Reset()
+	assert.Nil(b.run(strings.NewReader("lease Server 2 lab\n")))
+	assert.Contains(out.String(), "lease requested:")
+	assert.Equal(4, len(s.resources))
+
+	leaseID := strings.Fields(out.String()[strings.Index(out.String(), "lease requested:"):])[2]
+
+	out.Reset()
+	assert.Nil(b.run(strings.NewReader("release " + other.ID + "\nrelease " + leaseID + "\n")))
+	assert.Contains(out.String(), ErrNotLease.Error())
+	assert.Contains(out.String(), "lease released: "+leaseID)
+	assert.Equal(3, len(s.resources))
+
+	// Errors are reported and the session continues
+	out.Reset()
+	assert.Nil(b.run(strings.NewReader("bogus\nshow\nls a b\nfilter bad\nlease\nlease Server x\nshow nope\n")))
+	assert.Equal(7, strings.Count(out.String(), "error:"))
+}
+
+func TestBrowseCmd(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	argLock.Lock()
+	defer argLock.Unlock()
+
+	os.Args = append([]string{"zebra"}, "-c", "junk.yaml", "browse")
+	assert.NotNil(execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "browse", "extra")
+	assert.NotNil(execRootCmd())
+}
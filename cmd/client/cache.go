@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+var ErrNoCache = errors.New("no local cache, run zebra cache sync first")
+
+// Cache is a local copy of the server resources, used to answer read
+// commands when the server can not be reached. The cache is opt-in, it is
+// only kept up to date once it has been created with zebra cache sync.
+type Cache struct {
+	Synced    time.Time          `json:"synced"`
+	Resources *zebra.ResourceMap `json:"resources"`
+}
+
+func NewCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "manage the local resource cache used when the server is unreachable",
+	}
+
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:          "sync",
+		Short:        "copy all resources from the server into the local cache",
+		RunE:         syncCache,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	})
+
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:          "clear",
+		Short:        "remove the local cache",
+		RunE:         clearCache,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	})
+
+	return cacheCmd
+}
+
+// cacheFile returns the cache file of the config, there is one cache per
+// server profile.
+func cacheFile(cfgFile string, cfg *Config) string {
+	if cfg.context != "" {
+		return cfgFile + "." + cfg.context + ".cache"
+	}
+
+	return cfgFile + ".cache"
+}
+
+func loadCache(file string) (*Cache, error) {
+	data, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCache
+	} else if err != nil {
+		return nil, err
+	}
+
+	cache := &Cache{Synced: time.Time{}, Resources: zebra.NewResourceMap(store.DefaultFactory())}
+
+	return cache, json.Unmarshal(data, cache)
+}
+
+func (c *Cache) Save(file string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, data, ReadOnly)
+}
+
+// Update replaces the cached resources with the resources in resMap. If the
+// resources are the result of a query for all resources, the cache is
+// replaced and marked as synced now.
+func (c *Cache) Update(resMap *zebra.ResourceMap, all bool) {
+	if all {
+		c.Resources = resMap
+		c.Synced = time.Now()
+
+		return
+	}
+
+	for t, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			if cached, ok := c.Resources.Resources[t]; ok {
+				cached.Delete(res)
+			}
+
+			c.Resources.Add(res, t)
+		}
+	}
+}
+
+// Query answers the query from the cached resources.
+func (c *Cache) Query(qr *queryRequest) *zebra.ResourceMap {
+	resMap := c.Resources
+
+	if len(qr.IDs) != 0 {
+		resMap, _ = store.FilterUUID(qr.IDs, resMap)
+	}
+
+	if len(qr.Types) != 0 {
+		resMap, _ = store.FilterType(qr.Types, resMap)
+	}
+
+	for _, q := range qr.Labels {
+		if q.Op == zebra.MatchUnder {
+			// The parents are looked up among all the cached resources
+			under, _ := store.FilterLabel(q, c.Resources)
+			resMap, _ = store.FilterUUID(resourceIDs(under), resMap)
+
+			continue
+		}
+
+		resMap, _ = store.FilterLabel(q, resMap)
+	}
+
+	return resMap
+}
+
+// resourceIDs returns the IDs of the resources of the map.
+func resourceIDs(resMap *zebra.ResourceMap) []string {
+	ids := []string{}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			ids = append(ids, res.GetID())
+		}
+	}
+
+	return ids
+}
+
+// queryWithCache runs the query against the server, keeping the local cache
+// up to date if there is one. If the server is unreachable, the query is
+// answered from the cache with a warning about its age.
+func queryWithCache(cmd *cobra.Command, cfg *Config, qr *queryRequest) (*zebra.ResourceMap, error) {
+	file := cacheFile(cmd.Flag("config").Value.String(), cfg)
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := client.Get("api/v1/resources", qr, resMap)
+
+	cache, cacheErr := loadCache(file)
+	if cacheErr != nil {
+		return resMap, err
+	}
+
+	// Only network errors have no status code
+	if err != nil && code == 0 {
+		warnStale(cmd.ErrOrStderr(), cache)
+
+		return cache.Query(qr), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cache.Update(resMap, len(qr.IDs) == 0 && len(qr.Types) == 0 && len(qr.Labels) == 0)
+
+	return resMap, cache.Save(file)
+}
+
+func warnStale(w io.Writer, cache *Cache) {
+	fmt.Fprintf(w, "warning: server unreachable, showing cached resources synced %s ago\n",
+		time.Since(cache.Synced).Round(time.Second))
+}
+
+func syncCache(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	if _, err := client.Get("api/v1/resources", &queryRequest{}, resMap); err != nil {
+		return err
+	}
+
+	cache := &Cache{Synced: time.Time{}, Resources: nil}
+	cache.Update(resMap, true)
+
+	return cache.Save(cacheFile(cmd.Flag("config").Value.String(), cfg))
+}
+
+func clearCache(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(cacheFile(cmd.Flag("config").Value.String(), cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,77 @@
+package main //nolint:testpackage
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+
+	s := &applyServer{resources: map[string]zebra.Resource{lab.ID: lab, rack.ID: rack}}
+	server := httptest.NewServer(s)
+
+	cfgFile := "test_cache.yaml"
+
+	t.Cleanup(func() {
+		os.Remove(cfgFile)
+		os.Remove(cfgFile + ".cache")
+	})
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	// Without a cache nothing is cached
+	_, err := runCmd("-c", cfgFile, "get")
+	assert.Nil(err)
+
+	_, err = os.Stat(cfgFile + ".cache")
+	assert.True(os.IsNotExist(err))
+
+	_, err = runCmd("-c", cfgFile, "cache", "sync")
+	assert.Nil(err)
+
+	cache, err := loadCache(cfgFile + ".cache")
+	assert.Nil(err)
+	assert.Equal(2, len(cache.Resources.Resources))
+
+	// Queries keep the cache up to date
+	rack.Row = "r2"
+
+	_, err = runCmd("-c", cfgFile, "get", "--id", rack.ID)
+	assert.Nil(err)
+
+	server.Close()
+
+	out, err := runCmd("-c", cfgFile, "get", "Rack", "-o", "jsonpath={.Rack[0].row}")
+	assert.Nil(err)
+	assert.Contains(out, "warning: server unreachable")
+	assert.Contains(out, "r2")
+
+	out, err = runCmd("-c", cfgFile, "get", "-l", "color=red", "-o", "jsonpath={.*[*].id}")
+	assert.Nil(err)
+	assert.Contains(out, lab.ID)
+	assert.NotContains(out, rack.ID)
+
+	_, err = runCmd("-c", cfgFile, "cache", "sync")
+	assert.NotNil(err)
+
+	_, err = runCmd("-c", cfgFile, "cache", "clear")
+	assert.Nil(err)
+
+	_, err = runCmd("-c", cfgFile, "cache", "clear")
+	assert.Nil(err)
+
+	_, err = runCmd("-c", cfgFile, "get")
+	assert.NotNil(err)
+
+	_, err = loadCache(cfgFile + ".cache")
+	assert.Equal(ErrNoCache, err)
+}
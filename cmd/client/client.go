@@ -10,7 +10,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,12 +24,64 @@ var (
 	ErrNoConfig     = errors.New("zebra config file is not specified")
 	ErrNoEmail      = errors.New("user email is not configured")
 	ErrNoPrivateKey = errors.New("user private key is not configured")
+	ErrTokenExpired = errors.New("login token has expired, please login again")
 )
 
+// TokenRefreshWindow is how long before its expiry a login token is renewed.
+const TokenRefreshWindow = 2 * time.Minute
+
+// Retries of requests that failed with a retryable error. The wait before a
+// retry doubles from DefaultBackoff up to MaxBackoff, with jitter so that
+// clients do not retry in lock step.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 250 * time.Millisecond
+	MaxBackoff     = 10 * time.Second
+)
+
+// StatusError is returned for responses other than 2xx.
+type StatusError struct {
+	URL        string
+	Code       int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// Retryable returns true if the server did not process the request because
+// it was overloaded or unavailable, so that the request can be sent again.
+func (e *StatusError) Retryable() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code == http.StatusServiceUnavailable
+}
+
+// IsRetryable returns true if the request failed with an error that may not
+// happen again: the server was overloaded, unavailable or unreachable. All
+// other errors are fatal.
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+
+	// The request never reached the server
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 type Client struct {
-	cfg *Config
-	c   *http.Client
-	h   http.Header
+	cfg     *Config
+	c       *http.Client
+	h       http.Header
+	retries int
+	backoff time.Duration
+
+	// current is the index of the endpoint that last answered
+	lock    sync.Mutex
+	current int
 }
 
 func NewClient(cfg *Config) (*Client, error) {
@@ -32,24 +89,29 @@ func NewClient(cfg *Config) (*Client, error) {
 		return nil, ErrNoConfig
 	}
 
-	if cfg.Email == "" {
-		return nil, ErrNoEmail
-	}
+	h := http.Header{}
 
-	if cfg.Key == nil {
+	switch {
+	case cfg.ServiceToken != "":
+		// Service accounts have no email or key
+		h.Add("Authorization", "Bearer "+cfg.ServiceToken)
+	case cfg.Email == "":
+		return nil, ErrNoEmail
+	case cfg.Key == nil && cfg.Token == "":
 		return nil, ErrNoPrivateKey
 	}
 
-	t, err := cfg.Key.Sign([]byte(cfg.Email))
-	token := base64.StdEncoding.EncodeToString(t)
+	// Users that logged in with a password may not have a key
+	if cfg.Key != nil && cfg.ServiceToken == "" {
+		t, err := cfg.Key.Sign([]byte(cfg.Email))
+		if err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return nil, err
+		h.Add("Zebra-Auth-User", cfg.Email)
+		h.Add("Zebra-Auth-Token", base64.StdEncoding.EncodeToString(t))
 	}
 
-	h := http.Header{}
-	h.Add("Zebra-Auth-User", cfg.Email)
-	h.Add("Zebra-Auth-Token", token)
 	h.Add("User-Agent", "zebra-client")
 	h.Add("Accept-Encoding", "application/json")
 	h.Add("Content-Type", "application/json")
@@ -59,13 +121,79 @@ func NewClient(cfg *Config) (*Client, error) {
 		return nil, err
 	}
 
+	retries := cfg.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
 	return &Client{
-		cfg: cfg,
-		c:   c,
-		h:   h,
+		cfg:     cfg,
+		c:       c,
+		h:       h,
+		retries: retries,
+		backoff: DefaultBackoff,
+		lock:    sync.Mutex{},
+		current: 0,
 	}, nil
 }
 
+// endpoints returns the server addresses in the order they are tried: the
+// endpoint that last answered first, then the ones after it.
+func (c *Client) endpoints() []string {
+	all := append([]string{c.cfg.ServerAddress}, c.cfg.Endpoints...)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	current := c.current % len(all)
+	ordered := make([]string, 0, len(all))
+
+	return append(append(ordered, all[current:]...), all[:current]...)
+}
+
+// answered moves the endpoint that answered to the front of the endpoints.
+func (c *Client) answered(endpoint string) {
+	all := append([]string{c.cfg.ServerAddress}, c.cfg.Endpoints...)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, e := range all {
+		if e == endpoint {
+			c.current = i
+
+			return
+		}
+	}
+}
+
+// wait returns the jittered wait before the given retry, or the wait the
+// server asked for.
+func (c *Client) wait(retry int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > MaxBackoff {
+			return MaxBackoff
+		}
+
+		return statusErr.RetryAfter
+	}
+
+	wait := c.backoff
+	for i := 0; i < retry && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > MaxBackoff {
+		wait = MaxBackoff
+	}
+
+	// Wait between half and all of the backoff
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)) //nolint:gosec,gomnd
+}
+
 func (c *Client) Get(path string, in, out interface{}) (int, error) {
 	return c.do(context.Background(), "GET", path, in, out)
 }
@@ -78,9 +206,11 @@ func (c *Client) Post(path string, in, out interface{}) (int, error) {
 	return c.do(context.Background(), "POST", path, in, out)
 }
 
+// do sends the request to the endpoints in turn until one of them answers.
+// If all endpoints fail with a retryable error, the request is retried after
+// a backoff, up to the configured number of retries.
 func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) (int, error) {
-	url := fmt.Sprintf("%s/%s", c.cfg.ServerAddress, path)
-	buf := bytes.NewBuffer([]byte{})
+	var body []byte
 
 	if in != nil {
 		b, e := json.Marshal(in)
@@ -88,23 +218,65 @@ func (c *Client) do(ctx context.Context, method, path string, in, out interface{
 			return 0, e
 		}
 
-		buf = bytes.NewBuffer(b)
+		body = b
+	}
+
+	for retry := 0; ; retry++ {
+		code, err := 0, error(nil)
+
+		for _, endpoint := range c.endpoints() {
+			code, err = c.send(ctx, method, endpoint, path, body, out)
+			if !IsRetryable(err) {
+				return code, err
+			}
+
+			// The server is overloaded, the other endpoints may not be
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests {
+				break
+			}
+		}
+
+		if retry >= c.retries {
+			return code, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return code, err
+		case <-time.After(c.wait(retry, err)):
+		}
 	}
+}
 
-	r, err := http.NewRequestWithContext(ctx, method, url, buf)
+func (c *Client) send(ctx context.Context, method, endpoint, path string, body []byte, out interface{}) (int, error) {
+	url := fmt.Sprintf("%s/%s", endpoint, path)
+
+	r, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
 	if err != nil {
 		return 0, err
 	}
 
-	r.Header = c.h
+	r.Header = c.h.Clone()
+
+	if err := c.addToken(ctx, r); err != nil {
+		return 0, err
+	}
 
 	resp, err := c.c.Do(r)
 	if err != nil {
 		return 0, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, fmt.Errorf("%s: %s", url, resp.Status) //nolint:goerr113
+	defer resp.Body.Close()
+
+	c.answered(endpoint)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, &StatusError{
+			URL: url, Code: resp.StatusCode, Status: resp.Status,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	b, e := ioutil.ReadAll(resp.Body)
@@ -112,6 +284,13 @@ func (c *Client) do(ctx context.Context, method, path string, in, out interface{
 		return resp.StatusCode, e
 	}
 
+	// A byte slice receives the body as it is, such as a download
+	if raw, ok := out.(*[]byte); ok {
+		*raw = b
+
+		return resp.StatusCode, nil
+	}
+
 	if out != nil {
 		if e := json.Unmarshal(b, out); e != nil {
 			return resp.StatusCode, e
@@ -121,6 +300,109 @@ func (c *Client) do(ctx context.Context, method, path string, in, out interface{
 	return resp.StatusCode, nil
 }
 
+// retryAfter returns the wait of a Retry-After header in seconds, zero if
+// the header is not set or is a date.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// addToken adds the login token to the request, renewing it first if it is
+// about to expire. An expired token is only an error if there is no key to
+// authenticate with instead.
+func (c *Client) addToken(ctx context.Context, r *http.Request) error {
+	if c.cfg.Token == "" || c.cfg.ServiceToken != "" {
+		return nil
+	}
+
+	expiry := tokenExpiry(c.cfg.Token)
+
+	switch {
+	case expiry.IsZero():
+	case time.Now().After(expiry):
+		if c.cfg.Key != nil {
+			return nil
+		}
+
+		return ErrTokenExpired
+	case time.Until(expiry) < TokenRefreshWindow:
+		if err := c.refresh(ctx); err != nil && c.cfg.Key == nil {
+			return err
+		}
+	}
+
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: c.cfg.Token})
+
+	return nil
+}
+
+// refresh renews the login token and saves it in the config file the config
+// was loaded from.
+func (c *Client) refresh(ctx context.Context) error {
+	r, err := http.NewRequestWithContext(ctx, "POST", c.endpoints()[0]+"/refresh", nil)
+	if err != nil {
+		return err
+	}
+
+	r.Header = c.h.Clone()
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: c.cfg.Token})
+
+	resp, err := c.c.Do(r)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ErrTokenExpired
+	}
+
+	tokenRes := &struct {
+		JWT string `json:"jwt"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(tokenRes); err != nil {
+		return err
+	}
+
+	c.cfg.Token = tokenRes.JWT
+
+	if c.cfg.file != "" {
+		return c.cfg.Save(c.cfg.file)
+	}
+
+	return nil
+}
+
+// tokenExpiry returns the expiry time of the jwt, without verifying it. The
+// zero time is returned if the token can not be parsed.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { //nolint:gomnd
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	claims := &struct {
+		ExpiresAt int64 `json:"exp"`
+	}{}
+
+	if err := json.Unmarshal(payload, claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
 func tlsClient(cfg *Config) (*http.Client, error) {
 	if cfg == nil {
 		return nil, ErrNoConfig
@@ -3,9 +3,12 @@ package main //nolint:testpackage
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/auth"
@@ -156,3 +159,152 @@ func makeServer(assert *assert.Assertions) *httptest.Server {
 		assert.Nil(e)
 	}))
 }
+
+func TestServiceTokenClient(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	authorization, cookies := "", 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		cookies = len(req.Cookies())
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	defer server.Close()
+
+	// Service accounts need neither email nor key
+	cfg := &Config{
+		ServerAddress: server.URL,
+		CACert:        testCACertFile,
+		Token:         "stale-login-token",
+		ServiceToken:  "account.token.secret",
+	}
+
+	client, err := NewClient(cfg)
+	assert.Nil(err)
+
+	code, err := client.Get("api/v1/types", nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("Bearer account.token.secret", authorization)
+	assert.Equal(0, cookies)
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.True(IsRetryable(&StatusError{Code: http.StatusTooManyRequests}))
+	assert.True(IsRetryable(&StatusError{Code: http.StatusServiceUnavailable}))
+	assert.False(IsRetryable(&StatusError{Code: http.StatusBadRequest}))
+	assert.False(IsRetryable(&StatusError{Code: http.StatusInternalServerError}))
+	assert.True(IsRetryable(&net.OpError{Op: "dial", Err: ErrNoConfig}))
+	assert.False(IsRetryable(&net.OpError{Op: "read", Err: ErrNoConfig}))
+	assert.False(IsRetryable(ErrNoConfig))
+	assert.False(IsRetryable(nil))
+
+	assert.Equal(2*time.Second, retryAfter("2"))
+	assert.Equal(time.Duration(0), retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+}
+
+func TestClientRetry(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	lock := sync.Mutex{}
+	calls := map[string]int{}
+	unavailable := map[string]int{}
+
+	handler := func(name string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			calls[name]++
+
+			switch {
+			case req.URL.Path == "/busy":
+				rw.Header().Set("Retry-After", "0")
+				rw.WriteHeader(http.StatusTooManyRequests)
+			case req.URL.Path == "/bad":
+				rw.WriteHeader(http.StatusBadRequest)
+			case unavailable[name] > 0:
+				unavailable[name]--
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				rw.WriteHeader(http.StatusOK)
+			}
+		}
+	}
+
+	primary := httptest.NewServer(handler("primary"))
+	secondary := httptest.NewServer(handler("secondary"))
+
+	defer secondary.Close()
+
+	// Nothing listens on the address of the closed server
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := &Config{
+		ServerAddress: primary.URL,
+		Endpoints:     []string{down.URL, secondary.URL},
+		CACert:        testCACertFile,
+		ServiceToken:  "account.token.secret",
+	}
+
+	client, err := NewClient(cfg)
+	assert.Nil(err)
+	assert.Equal(DefaultRetries, client.retries)
+
+	client.backoff = time.Millisecond
+
+	// The primary is unavailable once, the request fails over
+	unavailable["primary"] = 1
+
+	code, err := client.Get("ok", nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(1, calls["secondary"])
+
+	// The endpoint that answered is tried first from now on
+	_, err = client.Get("ok", nil, nil)
+	assert.Nil(err)
+	assert.Equal(2, calls["secondary"])
+	assert.Equal(1, calls["primary"])
+
+	// Fatal errors are not retried
+	code, err = client.Get("bad", nil, nil)
+	assert.Equal(http.StatusBadRequest, code)
+	assert.False(IsRetryable(err))
+	assert.Equal(3, calls["secondary"])
+
+	// An overloaded server is retried without failing over
+	code, err = client.Get("busy", nil, nil)
+	assert.Equal(http.StatusTooManyRequests, code)
+	assert.True(IsRetryable(err))
+	assert.Equal(3+1+DefaultRetries, calls["secondary"])
+	assert.Equal(1, calls["primary"])
+
+	// All endpoints are unavailable for a while
+	primary.Close()
+
+	unavailable["secondary"] = 2
+
+	code, err = client.Get("ok", nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+
+	// Without retries the error is returned right away
+	cfg.Retries = -1
+	client, err = NewClient(cfg)
+	assert.Nil(err)
+
+	unavailable["secondary"] = 1
+
+	_, err = client.Get("ok", nil, nil)
+	assert.True(IsRetryable(err))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// comment is a note of a user on a resource.
+type comment struct {
+	ID       string    `json:"id"`
+	Resource string    `json:"resource"`
+	Parent   string    `json:"parent,omitempty"`
+	Author   string    `json:"author"`
+	Text     string    `json:"text"`
+	Created  time.Time `json:"created"`
+}
+
+// activity is an entry of the activity feed of a resource, a comment or an
+// action taken on the resource.
+type activity struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Comment *comment  `json:"comment,omitempty"`
+}
+
+func NewComment() *cobra.Command {
+	commentCmd := &cobra.Command{
+		Use:          "comment <resource-id> <text>",
+		Short:        "comment on a resource",
+		RunE:         addComment,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+	}
+
+	commentCmd.Flags().String("reply-to", "", "id of the comment to reply to")
+	commentCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string,
+	) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return completeIDs(cmd, args, toComplete)
+	}
+
+	return commentCmd
+}
+
+func NewActivity() *cobra.Command {
+	activityCmd := &cobra.Command{
+		Use:          "activity <resource-id>",
+		Short:        "show the comments on a resource and the actions taken on it, newest first",
+		RunE:         showActivity,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	activityCmd.Flags().Int("limit", 0, "show the newest entries only")
+	activityCmd.ValidArgsFunction = completeIDs
+	addOutputFlag(activityCmd)
+
+	return activityCmd
+}
+
+func addComment(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := cmd.Flags().GetString("reply-to")
+	req := map[string]string{"text": args[1], "parent": parent}
+	c := new(comment)
+
+	if _, err := client.Post("api/v1/resources/"+url.PathEscape(args[0])+"/comments", req, c); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "comment %s added to %s\n", c.ID, c.Resource)
+
+	return nil
+}
+
+func showActivity(cmd *cobra.Command, args []string) error {
+	printOut, err := outputPrinter(cmd)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	path := "api/v1/resources/" + url.PathEscape(args[0]) + "/activity"
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+
+	feed := []activity{}
+	if _, err := client.Get(path, nil, &feed); err != nil {
+		return err
+	}
+
+	return printOut(cmd.OutOrStdout(), feed)
+}
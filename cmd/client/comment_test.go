@@ -0,0 +1,61 @@
+package main //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComment(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_comment.yaml"
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	created := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/resources/server1/comments":
+			req := map[string]string{}
+			assert.Nil(json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal("flaky NIC", req["text"])
+			assert.Equal("c0", req["parent"])
+
+			w.WriteHeader(http.StatusCreated)
+			assert.Nil(json.NewEncoder(w).Encode(comment{
+				ID: "c1", Resource: "server1", Parent: "c0", Author: "loki@asgard.io", Text: "flaky NIC", Created: created,
+			}))
+		case "/api/v1/resources/server1/activity":
+			assert.Equal("1", r.URL.Query().Get("limit"))
+			assert.Nil(json.NewEncoder(w).Encode([]activity{{
+				Time: created, Kind: "audit", Actor: "loki@asgard.io", Action: "transfer", Detail: "", Comment: nil,
+			}}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	out, err := runCmd("comment", "-c", cfgFile, "server1", "flaky NIC", "--reply-to", "c0")
+	assert.Nil(err)
+	assert.Contains(out, "comment c1 added to server1")
+
+	out, err = runCmd("activity", "-c", cfgFile, "server1", "--limit", "1", "-o", "template={{range .}}{{.action}}{{end}}")
+	assert.Nil(err)
+	assert.Equal("transfer", out)
+
+	_, err = runCmd("activity", "-c", cfgFile, "server2")
+	assert.NotNil(err)
+
+	_, err = runCmd("comment", "-c", cfgFile, "server1")
+	assert.NotNil(err)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+func NewCompletion() *cobra.Command {
+	completionCmd := &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "generate shell completion script",
+		Long: `Generate the completion script for the given shell. For example, to load
+completions in the current bash session run:
+
+  source <(zebra completion bash)
+
+Resource types, label selectors and resource ids are completed from the
+zebra server in the client configuration.`,
+		ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+		Args:                  cobra.ExactValidArgs(1),
+		DisableFlagsInUseLine: true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root, out := cmd.Root(), cmd.OutOrStdout()
+
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(out, true)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return root.GenFishCompletion(out, true)
+			default:
+				return root.GenPowerShellCompletionWithDesc(out)
+			}
+		},
+	}
+
+	return completionCmd
+}
+
+// completionClient returns a client for the configuration of the command, or
+// nil if there is no usable configuration. Completions fail silently.
+func completionClient(cmd *cobra.Command) *Client {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil
+	}
+
+	return client
+}
+
+// matchPrefix returns the candidates that start with the prefix, ignoring
+// case so that "ser" completes to "Server".
+func matchPrefix(candidates []string, prefix string) []string {
+	matches := []string{}
+
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(prefix)) {
+			matches = append(matches, c)
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches
+}
+
+// completeTypes completes resource type names.
+func completeTypes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	client := completionClient(cmd)
+	if client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	typeRes := &struct {
+		Types []zebra.Type `json:"types"`
+	}{Types: []zebra.Type{}}
+
+	if _, err := client.Get("api/v1/types", struct{}{}, typeRes); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	names := make([]string, 0, len(typeRes.Types))
+
+	for _, t := range typeRes.Types {
+		if !zebra.IsIn(t.Name, args) {
+			names = append(names, t.Name)
+		}
+	}
+
+	return matchPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTypeArg completes a single resource type argument.
+func completeTypeArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return completeTypes(cmd, args, toComplete)
+}
+
+// completeIDs completes resource ids, of the types given as arguments if any.
+// Each id is described by the resource name.
+func completeIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	client := completionClient(cmd)
+	if client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	if _, err := client.Get("api/v1/resources", &queryRequest{Types: args}, resMap); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ids := []string{}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			if !strings.HasPrefix(res.GetID(), toComplete) {
+				continue
+			}
+
+			id := res.GetID()
+			if values, err := toValues(res); err == nil && values["name"] != nil {
+				id += "\t" + formatValue(values["name"])
+			}
+
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeLabels completes label selectors, first the key and then the value
+// of the label.
+func completeLabels(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	client := completionClient(cmd)
+	if client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	labelRes := &struct {
+		Labels map[string][]string `json:"labels"`
+	}{}
+
+	if _, err := client.Get("api/v1/labels", struct{}{}, labelRes); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	if key, _, ok := strings.Cut(toComplete, "="); ok {
+		selectors := make([]string, 0, len(labelRes.Labels[key]))
+		for _, value := range labelRes.Labels[key] {
+			selectors = append(selectors, key+"="+value)
+		}
+
+		return matchPrefix(selectors, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	keys := make([]string, 0, len(labelRes.Labels))
+	for key := range labelRes.Labels {
+		keys = append(keys, key+"=")
+	}
+
+	return matchPrefix(keys, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
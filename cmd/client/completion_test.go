@@ -0,0 +1,71 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletion(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		out, err := runCmd("completion", shell)
+		assert.Nil(err)
+		assert.NotEmpty(out)
+	}
+
+	_, err := runCmd("completion", "csh")
+	assert.NotNil(err)
+}
+
+func TestDynamicCompletion(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+
+	s := &applyServer{resources: map[string]zebra.Resource{lab.ID: lab, rack.ID: rack}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	cfgFile := "test_completion.yaml"
+
+	t.Cleanup(func() { os.Remove(cfgFile) })
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	complete := func(args ...string) []string {
+		out := new(bytes.Buffer)
+		cmd := New()
+		cmd.SetOut(out)
+		cmd.SetErr(new(bytes.Buffer))
+		cmd.SetArgs(append([]string{"__complete", "-c", cfgFile}, args...))
+		assert.Nil(cmd.Execute())
+
+		// the last line is the completion directive
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+		return lines[:len(lines)-1]
+	}
+
+	assert.Equal([]string{"Server"}, complete("get", "serve"))
+	assert.Equal([]string{"Rack"}, complete("lease", "Ra"))
+	assert.Empty(complete("lease", "Rack", ""))
+	assert.Equal([]string{rack.ID + "\track1"}, complete("get", "Rack", "--id", ""))
+	assert.Equal([]string{"color="}, complete("get", "-l", "co"))
+	assert.Equal([]string{"color=blue", "color=red"}, complete("apply", "-l", "color="))
+
+	// Without a server nothing is completed
+	assert.Empty(complete("-c", "junk.yaml", "get", "ser"))
+	assert.Empty(complete("-c", "junk.yaml", "get", "--id", ""))
+	assert.Empty(complete("-c", "junk.yaml", "get", "-l", ""))
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"strconv"
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/auth"
@@ -27,6 +29,14 @@ func NewConfigure() *cobra.Command {
 	// add config command
 	addConfigCommands(configCmd)
 
+	configCmd.AddCommand(&cobra.Command{
+		Use:          "use-context",
+		Short:        "set the current server profile",
+		RunE:         useContext,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	})
+
 	configCmd.AddCommand(&cobra.Command{
 		Use:          "public-key",
 		Short:        "show current user public key",
@@ -78,6 +88,21 @@ func addConfigCommands(configCmd *cobra.Command) {
 		SilenceUsage: true,
 	})
 
+	configCmd.AddCommand(&cobra.Command{
+		Use:          "endpoints [address...]",
+		Short:        "zebra server addresses to fail over to, in order",
+		RunE:         configEndpoints,
+		SilenceUsage: true,
+	})
+
+	configCmd.AddCommand(&cobra.Command{
+		Use:          "retries",
+		Short:        "number of retries of requests to an unavailable server, -1 to never retry",
+		RunE:         configRetries,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	})
+
 	configCmd.AddCommand(&cobra.Command{
 		Use:          "email",
 		Short:        "zebra user email address",
@@ -97,7 +122,21 @@ type Config struct {
 	ServerAddress string            `yaml:"zebraServer"`
 	Key           *auth.RsaIdentity `yaml:"key"`
 	CACert        string            `yaml:"caCert"`
+	Token         string            `yaml:"token,omitempty"`
 	Defaults      ConfigDefaults    `yaml:"defaults,omitempty"`
+
+	// ServiceToken authenticates as a service account instead of a user
+	ServiceToken string `yaml:"serviceToken,omitempty"`
+
+	// Endpoints are tried in order if the server is unavailable, Retries is
+	// the number of times requests are retried if all endpoints fail. Zero
+	// retries is DefaultRetries, use a negative number to never retry.
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	Retries   int      `yaml:"retries,omitempty"`
+
+	// file and context the config was loaded from, if any
+	file    string
+	context string
 }
 
 func NewConfig() *Config {
@@ -107,26 +146,28 @@ func NewConfig() *Config {
 		ServerAddress: "",
 		Key:           nil,
 		CACert:        "",
+		Token:         "",
 		Defaults: ConfigDefaults{
 			Duration: zebra.DefaultMaxDuration,
 		},
+		ServiceToken: "",
+		Endpoints:    nil,
+		Retries:      0,
+		file:         "",
+		context:      "",
 	}
 }
 
+// Load returns the configuration in the file, for files with several server
+// profiles the configuration of the current context is returned.
 func Load(cfgFile string) (*Config, error) {
-	data, e := ioutil.ReadFile(cfgFile)
-	if e != nil {
-		return nil, e
-	}
-
-	c := NewConfig()
-	e = yaml.Unmarshal(data, c)
-
-	return c, e
+	return LoadContext(cfgFile, "")
 }
 
+// Save writes the configuration to the file. A configuration loaded from a
+// profile is saved into that profile, leaving the other profiles as is.
 func (cfg *Config) Save(cfgFile string) error {
-	if cfg.Key == nil {
+	if cfg.Key == nil && cfg.Token == "" {
 		k, e := auth.Generate()
 		if e != nil {
 			return e
@@ -135,11 +176,29 @@ func (cfg *Config) Save(cfgFile string) error {
 		cfg.Key = k
 	}
 
+	if cfg.context != "" {
+		profiles, err := loadProfiles(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		profiles.Profiles[cfg.context] = cfg
+		if profiles.Current == "" {
+			profiles.Current = cfg.context
+		}
+
+		return profiles.Save(cfgFile)
+	}
+
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
 		return err
 	}
 
+	if err := os.MkdirAll(path.Dir(cfgFile), os.ModePerm); err != nil {
+		return err
+	}
+
 	return ioutil.WriteFile(cfgFile, data, ReadOnly)
 }
 
@@ -178,7 +237,7 @@ func configUser(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
 	user := args[0]
 
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 	if e != nil {
 		return e
 	}
@@ -195,7 +254,7 @@ func configCACert(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
 	caCert := args[0]
 
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 	if e != nil {
 		return e
 	}
@@ -212,7 +271,7 @@ func configEmail(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
 	email := args[0]
 
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 	if e != nil {
 		return e
 	}
@@ -229,7 +288,7 @@ func configServer(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
 	server := args[0]
 
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 	if e != nil {
 		return e
 	}
@@ -242,6 +301,43 @@ func configServer(cmd *cobra.Command, args []string) error {
 	return show(cfgFile)
 }
 
+func configEndpoints(cmd *cobra.Command, args []string) error {
+	cfgFile := cmd.Flag("config").Value.String()
+
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
+	if e != nil {
+		return e
+	}
+
+	cfg.Endpoints = args
+	if e := cfg.Save(cfgFile); e != nil {
+		return e
+	}
+
+	return show(cfgFile)
+}
+
+func configRetries(cmd *cobra.Command, args []string) error {
+	cfgFile := cmd.Flag("config").Value.String()
+
+	retries, e := strconv.Atoi(args[0])
+	if e != nil {
+		return e
+	}
+
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
+	if e != nil {
+		return e
+	}
+
+	cfg.Retries = retries
+	if e := cfg.Save(cfgFile); e != nil {
+		return e
+	}
+
+	return show(cfgFile)
+}
+
 func configDefaults(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
 
@@ -254,7 +350,7 @@ func configDefaults(cmd *cobra.Command, args []string) error {
 		return ErrLeaseDuration
 	}
 
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 	if e != nil {
 		return e
 	}
@@ -269,7 +365,7 @@ func configDefaults(cmd *cobra.Command, args []string) error {
 
 func showLocalKey(cmd *cobra.Command, args []string) error {
 	cfgFile := cmd.Flag("config").Value.String()
-	cfg, e := Load(cfgFile)
+	cfg, e := LoadContext(cfgFile, contextName(cmd))
 
 	if e != nil {
 		return e
@@ -47,6 +47,18 @@ func TestConfig(t *testing.T) {
 
 	assert.Nil(execRootCmd())
 
+	os.Args = append([]string{"zebra"}, "-c", testCfgFile, "config", "endpoints", "https://zebra2.safari.io")
+
+	assert.Nil(execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "-c", testCfgFile, "config", "retries", "many")
+
+	assert.NotNil(execRootCmd())
+
+	os.Args = append([]string{"zebra"}, "-c", testCfgFile, "config", "retries", "5")
+
+	assert.Nil(execRootCmd())
+
 	os.Args = append([]string{"zebra"}, "-c", testCfgFile, "config", "defaults", "--duration", "10")
 
 	assert.NotNil(execRootCmd())
@@ -70,4 +82,6 @@ func TestConfig(t *testing.T) {
 	assert.Equal("tester@zebra.safari.io", cfg.Email)
 	assert.Equal(2, cfg.Defaults.Duration)
 	assert.Equal("https://zebra.safari.io", cfg.ServerAddress)
+	assert.Equal([]string{"https://zebra2.safari.io"}, cfg.Endpoints)
+	assert.Equal(5, cfg.Retries)
 }
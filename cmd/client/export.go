@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func NewExport() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:          "export [type...]",
+		Short:        "export resources to an Excel workbook with a sheet per type, default: all types",
+		RunE:         exportResources,
+		SilenceUsage: true,
+	}
+
+	exportCmd.Flags().StringP("file", "f", "inventory.xlsx", "workbook to write")
+	exportCmd.ValidArgsFunction = completeTypes
+
+	return exportCmd
+}
+
+func exportResources(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	path := "api/v1/export"
+	if len(args) != 0 {
+		path += "?types=" + url.QueryEscape(strings.Join(args, ","))
+	}
+
+	workbook := []byte{}
+	if _, err := client.Get(path, nil, &workbook); err != nil {
+		return err
+	}
+
+	file, _ := cmd.Flags().GetString("file")
+	if err := ioutil.WriteFile(file, workbook, ReadOnly); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "exported to %s\n", file)
+
+	return nil
+}
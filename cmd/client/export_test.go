@@ -0,0 +1,50 @@
+package main //nolint:testpackage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_export.yaml"
+	file := "test_export.xlsx"
+
+	t.Cleanup(func() {
+		os.Remove(cfgFile)
+		os.Remove(file)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/export" || r.URL.Query().Get("types") == "Gossip" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		assert.Equal("Lab,VCenter", r.URL.Query().Get("types"))
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		_, _ = w.Write([]byte("PK workbook"))
+	}))
+
+	t.Cleanup(server.Close)
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	out, err := runCmd("export", "-c", cfgFile, "Lab", "VCenter", "-f", file)
+	assert.Nil(err)
+	assert.Contains(out, "exported to "+file)
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(err)
+	assert.Equal("PK workbook", string(data))
+
+	_, err = runCmd("export", "-c", cfgFile, "Gossip", "-f", file)
+	assert.NotNil(err)
+}
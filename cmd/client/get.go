@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var ErrGetQuery = errors.New("resources can be selected by id or by type, not both")
+
+func NewGet() *cobra.Command {
+	getCmd := &cobra.Command{
+		Use:          "get [type...]",
+		Short:        "show resources, optionally of the given types",
+		RunE:         getResources,
+		SilenceUsage: true,
+	}
+
+	getCmd.Flags().StringArray("id", nil, "resource id")
+	getCmd.Flags().StringArrayP("selector", "l", nil, "label selector key=value")
+	getCmd.Flags().String("view", "", "saved view to show, mine for the starred resources")
+	addOutputFlag(getCmd)
+
+	getCmd.ValidArgsFunction = completeTypes
+	_ = getCmd.RegisterFlagCompletionFunc("id", completeIDs)
+	_ = getCmd.RegisterFlagCompletionFunc("selector", completeLabels)
+
+	return getCmd
+}
+
+func getResources(cmd *cobra.Command, args []string) error {
+	ids, _ := cmd.Flags().GetStringArray("id")
+	selectors, _ := cmd.Flags().GetStringArray("selector")
+	viewName, _ := cmd.Flags().GetString("view")
+
+	if len(ids) != 0 && len(args) != 0 {
+		return ErrGetQuery
+	}
+
+	if viewName != "" && (len(ids) != 0 || len(args) != 0 || len(selectors) != 0) {
+		return ErrGetView
+	}
+
+	queries, err := parseSelectors(selectors)
+	if err != nil {
+		return err
+	}
+
+	printOut, err := outputPrinter(cmd)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if viewName != "" {
+		return getView(cmd, cfg, viewName, printOut)
+	}
+
+	resMap, err := queryWithCache(cmd, cfg, &queryRequest{IDs: ids, Types: args, Labels: queries})
+	if err != nil {
+		return err
+	}
+
+	return printOut(cmd.OutOrStdout(), resMap)
+}
@@ -0,0 +1,83 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/stretchr/testify/assert"
+)
+
+// saveTestConfig saves a client config for the server at url.
+func saveTestConfig(assert *assert.Assertions, url string, cfgFile string) {
+	key, err := auth.Load(testUserKeyFile)
+	assert.Nil(err)
+
+	cfg := &Config{ServerAddress: url, Key: key, Email: "loki@asgard.io", CACert: testCACertFile}
+	assert.Nil(cfg.Save(cfgFile))
+}
+
+// runCmd runs the root command with the arguments and returns its output,
+// including errors and warnings.
+func runCmd(args ...string) (string, error) {
+	out := new(bytes.Buffer)
+	cmd := New()
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+
+	return out.String(), err
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+
+	s := &applyServer{resources: map[string]zebra.Resource{lab.ID: lab, rack.ID: rack}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	cfgFile := "test_get.yaml"
+
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	get := func(args ...string) (string, error) {
+		return runCmd(append([]string{"-c", cfgFile, "get"}, args...)...)
+	}
+
+	out, err := get("-o", "jsonpath={range .*[*]}{.name}{\"\\n\"}{end}")
+	assert.Nil(err)
+	assert.Equal("lab1\nrack1\n", out)
+
+	out, err = get("Rack", "-o", "template={{range .Rack}}{{.row}}{{end}}")
+	assert.Nil(err)
+	assert.Equal("r1", out)
+
+	out, err = get("-l", "color=red", "-o", "jsonpath={.*[0].id}")
+	assert.Nil(err)
+	assert.Equal(lab.ID, out)
+
+	out, err = get("--id", rack.ID)
+	assert.Nil(err)
+	assert.Contains(out, `"row": "r1"`)
+
+	_, err = get("Rack", "--id", rack.ID)
+	assert.Equal(ErrGetQuery, err)
+
+	_, err = get("-l", "bad")
+	assert.Equal(ErrSelector, err)
+
+	_, err = get("-o", "xml")
+	assert.Equal(ErrOutputFormat, err)
+}
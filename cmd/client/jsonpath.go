@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrJSONPath = errors.New("invalid jsonpath expression")
+
+// JSONPath is a parsed JSONPath template, in the style of kubectl. A template
+// is text with expressions in braces, such as "{.Server[0].id}". Supported
+// expressions are:
+//
+//	.name or ['name']     child field
+//	..name                recursive descent
+//	[n]                   list index, negative from the end
+//	[*] or .*             all children
+//	[?(@.path op value)]  children matching the filter, op is == or !=, or
+//	                      children that have the path if no op is given
+//	range path ... end    repeat the enclosed template for each result
+//	"text"                quoted text, with escapes such as "\n"
+type JSONPath struct {
+	nodes []jpNode
+}
+
+type jpNodeKind int
+
+const (
+	jpText jpNodeKind = iota
+	jpPath
+	jpRange
+)
+
+type jpNode struct {
+	kind  jpNodeKind
+	text  string
+	steps []jpStep
+	body  []jpNode
+}
+
+type jpStepKind int
+
+const (
+	jpField jpStepKind = iota
+	jpRecursive
+	jpIndex
+	jpWildcard
+	jpFilter
+)
+
+type jpStep struct {
+	kind   jpStepKind
+	name   string
+	index  int
+	filter *jpFilterExpr
+}
+
+type jpFilterExpr struct {
+	steps []jpStep
+	op    string
+	value string
+}
+
+// ParseJSONPath parses the JSONPath template.
+func ParseJSONPath(template string) (*JSONPath, error) {
+	nodes, rest, err := parseNodes(template, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != "" {
+		return nil, ErrJSONPath
+	}
+
+	return &JSONPath{nodes: nodes}, nil
+}
+
+// Execute writes the template applied to the data, which is expected to be
+// the generic form of decoded JSON.
+func (jp *JSONPath) Execute(w io.Writer, data interface{}) error {
+	return executeNodes(w, jp.nodes, data)
+}
+
+// parseNodes parses the template up to its end, or up to the matching end
+// expression if inRange is set. It returns the unparsed rest of the template.
+func parseNodes(template string, inRange bool) ([]jpNode, string, error) {
+	nodes := []jpNode{}
+
+	for template != "" {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			nodes = append(nodes, jpNode{kind: jpText, text: template})
+			template = ""
+
+			break
+		}
+
+		if start > 0 {
+			nodes = append(nodes, jpNode{kind: jpText, text: template[:start]})
+		}
+
+		end := matching(template, start, '{', '}')
+		if end < 0 {
+			return nil, "", ErrJSONPath
+		}
+
+		expr := strings.TrimSpace(template[start+1 : end])
+		template = template[end+1:]
+
+		switch {
+		case expr == "end":
+			if !inRange {
+				return nil, "", ErrJSONPath
+			}
+
+			return nodes, template, nil
+		case strings.HasPrefix(expr, "range "):
+			steps, err := parseSteps(strings.TrimSpace(strings.TrimPrefix(expr, "range ")))
+			if err != nil {
+				return nil, "", err
+			}
+
+			body, rest, err := parseNodes(template, true)
+			if err != nil {
+				return nil, "", err
+			}
+
+			template = rest
+			nodes = append(nodes, jpNode{kind: jpRange, steps: steps, body: body})
+		case strings.HasPrefix(expr, `"`):
+			text, err := strconv.Unquote(expr)
+			if err != nil {
+				return nil, "", ErrJSONPath
+			}
+
+			nodes = append(nodes, jpNode{kind: jpText, text: text})
+		default:
+			steps, err := parseSteps(expr)
+			if err != nil {
+				return nil, "", err
+			}
+
+			nodes = append(nodes, jpNode{kind: jpPath, steps: steps})
+		}
+	}
+
+	if inRange {
+		return nil, "", ErrJSONPath
+	}
+
+	return nodes, "", nil
+}
+
+// matching returns the index of the bracket closing the one at start,
+// skipping over quoted text, or -1 if there is none.
+func matching(s string, start int, open, closing byte) int {
+	depth := 0
+
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return -1
+			}
+
+			i += end + 1
+		case open:
+			depth++
+		case closing:
+			depth--
+
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func parseSteps(path string) ([]jpStep, error) {
+	steps := []jpStep{}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), "@")
+
+	for path != "" {
+		switch {
+		case strings.HasPrefix(path, ".."):
+			name, rest := splitName(path[2:])
+			if name == "" {
+				return nil, ErrJSONPath
+			}
+
+			steps = append(steps, jpStep{kind: jpRecursive, name: name})
+			path = rest
+		case path[0] == '.':
+			name, rest := splitName(path[1:])
+
+			switch name {
+			case "":
+				// a lone "." refers to the current value
+			case "*":
+				steps = append(steps, jpStep{kind: jpWildcard})
+			default:
+				steps = append(steps, jpStep{kind: jpField, name: name})
+			}
+
+			path = rest
+		case path[0] == '[':
+			end := matching(path, 0, '[', ']')
+			if end < 0 {
+				return nil, ErrJSONPath
+			}
+
+			step, err := parseBracket(path[1:end])
+			if err != nil {
+				return nil, err
+			}
+
+			steps = append(steps, step)
+			path = path[end+1:]
+		default:
+			return nil, ErrJSONPath
+		}
+	}
+
+	return steps, nil
+}
+
+func splitName(path string) (string, string) {
+	end := strings.IndexAny(path, ".[")
+	if end < 0 {
+		return path, ""
+	}
+
+	return path[:end], path[end:]
+}
+
+func parseBracket(expr string) (jpStep, error) {
+	expr = strings.TrimSpace(expr)
+
+	switch {
+	case expr == "*":
+		return jpStep{kind: jpWildcard}, nil
+	case strings.HasPrefix(expr, "'") || strings.HasPrefix(expr, `"`):
+		if len(expr) < 2 || expr[len(expr)-1] != expr[0] { //nolint:gomnd
+			return jpStep{}, ErrJSONPath
+		}
+
+		return jpStep{kind: jpField, name: expr[1 : len(expr)-1]}, nil
+	case strings.HasPrefix(expr, "?(") && strings.HasSuffix(expr, ")"):
+		filter, err := parseFilter(expr[2 : len(expr)-1])
+
+		return jpStep{kind: jpFilter, filter: filter}, err
+	}
+
+	index, err := strconv.Atoi(expr)
+	if err != nil {
+		return jpStep{}, ErrJSONPath
+	}
+
+	return jpStep{kind: jpIndex, index: index}, nil
+}
+
+func parseFilter(expr string) (*jpFilterExpr, error) {
+	filter := new(jpFilterExpr)
+	path := expr
+
+	for _, op := range []string{"==", "!="} {
+		if left, right, ok := strings.Cut(expr, op); ok {
+			path, filter.op = strings.TrimSpace(left), op
+			filter.value = strings.TrimSpace(right)
+
+			if unquoted, err := strconv.Unquote(strings.ReplaceAll(filter.value, "'", `"`)); err == nil {
+				filter.value = unquoted
+			}
+
+			break
+		}
+	}
+
+	if !strings.HasPrefix(path, "@") {
+		return nil, ErrJSONPath
+	}
+
+	steps, err := parseSteps(path)
+	filter.steps = steps
+
+	return filter, err
+}
+
+func executeNodes(w io.Writer, nodes []jpNode, data interface{}) error {
+	for _, node := range nodes {
+		switch node.kind {
+		case jpText:
+			if _, err := io.WriteString(w, node.text); err != nil {
+				return err
+			}
+		case jpPath:
+			values := evalSteps(node.steps, data)
+			texts := make([]string, 0, len(values))
+
+			for _, v := range values {
+				texts = append(texts, formatValue(v))
+			}
+
+			if _, err := io.WriteString(w, strings.Join(texts, " ")); err != nil {
+				return err
+			}
+		case jpRange:
+			for _, v := range evalSteps(node.steps, data) {
+				if err := executeNodes(w, node.body, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func evalSteps(steps []jpStep, data interface{}) []interface{} {
+	values := []interface{}{data}
+
+	for _, step := range steps {
+		next := []interface{}{}
+
+		for _, v := range values {
+			next = append(next, evalStep(step, v)...)
+		}
+
+		values = next
+	}
+
+	return values
+}
+
+func evalStep(step jpStep, data interface{}) []interface{} {
+	switch step.kind {
+	case jpField:
+		if m, ok := data.(map[string]interface{}); ok {
+			if v, ok := m[step.name]; ok {
+				return []interface{}{v}
+			}
+		}
+	case jpIndex:
+		if l, ok := data.([]interface{}); ok {
+			i := step.index
+			if i < 0 {
+				i += len(l)
+			}
+
+			if i >= 0 && i < len(l) {
+				return []interface{}{l[i]}
+			}
+		}
+	case jpWildcard:
+		return children(data)
+	case jpFilter:
+		matched := []interface{}{}
+
+		for _, child := range children(data) {
+			if step.filter.match(child) {
+				matched = append(matched, child)
+			}
+		}
+
+		return matched
+	case jpRecursive:
+		found := evalStep(jpStep{kind: jpField, name: step.name}, data)
+
+		for _, child := range children(data) {
+			found = append(found, evalStep(step, child)...)
+		}
+
+		return found
+	}
+
+	return nil
+}
+
+// children returns the values of a list, or of a map ordered by key.
+func children(data interface{}) []interface{} {
+	switch d := data.(type) {
+	case []interface{}:
+		return d
+	case map[string]interface{}:
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		values := make([]interface{}, 0, len(d))
+		for _, key := range keys {
+			values = append(values, d[key])
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+func (f *jpFilterExpr) match(data interface{}) bool {
+	values := evalSteps(f.steps, data)
+
+	switch f.op {
+	case "==":
+		for _, v := range values {
+			if formatValue(v) == f.value {
+				return true
+			}
+		}
+
+		return false
+	case "!=":
+		for _, v := range values {
+			if formatValue(v) == f.value {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return len(values) != 0
+}
+
+// formatValue returns strings as they are and other values as JSON.
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,56 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPath(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var data interface{}
+
+	assert.Nil(json.Unmarshal([]byte(`{
+		"Server": [
+			{"id": "s1", "ip": "10.0.0.1", "labels": {"color": "red", "system.group": "a"}, "cpu": 4},
+			{"id": "s2", "ip": "10.0.0.2", "labels": {"color": "blue", "system.group": "b"}, "cpu": 8}
+		],
+		"Lab": [{"id": "l1", "labels": {"color": "red"}}]
+	}`), &data))
+
+	tests := map[string]string{
+		`{.Server[0].id}`:                                "s1",
+		`{.Server[-1].id}`:                               "s2",
+		`{.Server[*].id}`:                                "s1 s2",
+		`{$.Server[*].cpu}`:                              "4 8",
+		`{.Server[?(@.labels.color=="red")].ip}`:         "10.0.0.1",
+		`{.Server[?(@.labels.color!='red')].ip}`:         "10.0.0.2",
+		`{.Server[?(@.cpu==8)].id}`:                      "s2",
+		`{.*[?(@.labels.color=="red")].id}`:              "l1 s1",
+		`{.Server[?(@.ip)].id}`:                          "s1 s2",
+		`{.Server[0].labels['system.group']}`:            "a",
+		`{..ip}`:                                         "10.0.0.1 10.0.0.2",
+		`{.Server[0].labels}`:                            `{"color":"red","system.group":"a"}`,
+		`{range .Server[*]}{.id}={.ip}{"\n"}{end}`:       "s1=10.0.0.1\ns2=10.0.0.2\n",
+		`ids: {range .Lab[*]}[{.id}]{end} {.missing}`:    "ids: [l1] ",
+		`{.Server[5].id}{.Server.id}{.Lab[0].id[0]}done`: "done",
+	}
+
+	for template, expected := range tests {
+		jp, err := ParseJSONPath(template)
+		assert.Nil(err, template)
+
+		out := new(bytes.Buffer)
+		assert.Nil(jp.Execute(out, data))
+		assert.Equal(expected, out.String(), template)
+	}
+
+	for _, bad := range []string{`{.id`, `{end}`, `{range .x}`, `{.a[x]}`, `{.a[?(b==1)]}`, `{"x}`, `{a}`, `{..}`, `{.a['x]}`} {
+		_, err := ParseJSONPath(bad)
+		assert.NotNil(err, bad)
+	}
+}
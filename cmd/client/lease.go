@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -25,9 +26,19 @@ func NewLease() *cobra.Command {
 		Args:         cobra.ExactArgs(1),
 	}
 
+	leaseCmd.ValidArgsFunction = completeTypeArg
+
 	leaseCmd.Flags().StringP("group", "g", "global", "resource group")
 	leaseCmd.Flags().IntP("count", "k", DefaultResourceCount, "number of resources")
 
+	leaseCmd.AddCommand(&cobra.Command{
+		Use:          "extend <lease-id> <duration>",
+		Short:        "extend an active lease, past the maximum duration once an admin approves",
+		RunE:         extendLease,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+	})
+
 	return leaseCmd
 }
 
@@ -55,9 +66,7 @@ func leaseRequest(cmd *cobra.Command, args []string) error {
 }
 
 func makeLeaseReq(cmd *cobra.Command, args []string) (*Config, *zebra.ResourceMap, *lease.ResourceReq, error) {
-	cfgFile := cmd.Flag("config").Value.String()
-
-	cfg, err := Load(cfgFile)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -73,13 +82,66 @@ func makeLeaseReq(cmd *cobra.Command, args []string) (*Config, *zebra.ResourceMa
 		Count: resCount,
 	}
 
-	lease := lease.NewLease(
+	return cfg, leaseMap(cfg, req), req, nil
+}
+
+// leaseMap returns a resource map with a new lease for the request, using
+// the default lease duration of the user.
+func leaseMap(cfg *Config, req *lease.ResourceReq) *zebra.ResourceMap {
+	l := lease.NewLease(
 		cfg.Email,
 		time.Duration(cfg.Defaults.Duration)*time.Hour,
 		[]*lease.ResourceReq{req})
 
 	resMap := zebra.NewResourceMap(store.DefaultFactory())
-	resMap.Add(lease, lease.GetType())
+	resMap.Add(l, l.GetType())
+
+	return resMap
+}
+
+// leaseExtension is the answer to a lease extension request: the extended
+// lease, or the approval request if the extension waits for an admin.
+type leaseExtension struct {
+	ID             string        `json:"id"`
+	ActivationTime time.Time     `json:"activationTime"`
+	Duration       time.Duration `json:"duration"`
+	Extension      time.Duration `json:"extension"`
+	Expires        time.Time     `json:"expires"`
+}
+
+func extendLease(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	body := json.RawMessage{}
+	req := map[string]string{"duration": args[1]}
+
+	code, err := client.Post("api/v1/leases/extend/"+args[0], req, &body)
+	if err != nil {
+		return err
+	}
+
+	ext := new(leaseExtension)
+	if err := json.Unmarshal(body, ext); err != nil {
+		return err
+	}
+
+	if code == http.StatusAccepted {
+		fmt.Fprintf(cmd.OutOrStdout(), "extension of lease %s waits for approval, request %s expires at %s\n",
+			args[0], ext.ID, ext.Expires.Format(time.RFC3339))
+
+		return nil
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "lease %s extended until %s\n", ext.ID,
+		ext.ActivationTime.Add(ext.Duration+ext.Extension).Format(time.RFC3339))
 
-	return cfg, resMap, req, nil
+	return nil
 }
@@ -1,9 +1,15 @@
 package main //nolint:testpackage
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/project-safari/zebra/lease"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,3 +35,49 @@ func TestLease(t *testing.T) {
 
 	assert.NotNil(execRootCmd())
 }
+
+func TestLeaseExtend(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_lease_extend.yaml"
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	activated := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	expires := time.Date(2022, 6, 2, 10, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		assert.Nil(json.NewDecoder(r.Body).Decode(&body))
+
+		switch body["duration"] {
+		case "1h":
+			l := lease.NewLease("loki@asgard.io", 3*time.Hour, nil)
+			l.ActivationTime = activated
+			l.Extend(time.Hour)
+			assert.Nil(json.NewEncoder(w).Encode(l))
+		case "8h":
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"id":"approval","status":"pending","expires":"%s"}`, expires.Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	out, err := runCmd("lease", "extend", "-c", cfgFile, "lease", "1h")
+	assert.Nil(err)
+	assert.Contains(out, "extended until 2022-06-01T14:00:00Z")
+
+	out, err = runCmd("lease", "extend", "-c", cfgFile, "lease", "8h")
+	assert.Nil(err)
+	assert.Contains(out, "extension of lease lease waits for approval, request approval expires at 2022-06-02T10:00:00Z")
+
+	_, err = runCmd("lease", "extend", "-c", cfgFile, "lease", "48h")
+	assert.NotNil(err)
+
+	_, err = runCmd("lease", "extend", "-c", cfgFile, "lease")
+	assert.NotNil(err)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrNoServer    = errors.New("zebra server address is not configured")
+	ErrLoginFailed = errors.New("login failed")
+	ErrNoToken     = errors.New("profile has no login token")
+)
+
+func NewLogin() *cobra.Command {
+	loginCmd := &cobra.Command{
+		Use:   "login [server]",
+		Short: "login to a zebra server with email and password",
+		Long: `Login to a zebra server and save the token in a server profile of the
+config file. The profile is named after the server host, unless --context is
+given, and becomes the current profile. Without a server the current (or
+given) profile is logged in again. The password is read from stdin if it is
+not given.`,
+		RunE:         login,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	loginCmd.Flags().StringP("email", "e", "", "user email address")
+	loginCmd.Flags().StringP("password", "p", "", "user password")
+	loginCmd.Flags().String("ca-cert", "", "zebra CA cert file")
+
+	return loginCmd
+}
+
+func login(cmd *cobra.Command, args []string) error {
+	cfgFile := cmd.Flag("config").Value.String()
+	context := contextName(cmd)
+
+	profiles, err := loadProfiles(cfgFile)
+	if err != nil {
+		return err
+	}
+
+	if context == "" && len(args) == 0 {
+		context = profiles.Current
+	}
+
+	if context == "" && len(args) != 0 {
+		u, err := url.Parse(args[0])
+		if err != nil {
+			return err
+		}
+
+		context = u.Host
+	}
+
+	cfg, ok := profiles.Profiles[context]
+	if !ok || context == "" {
+		cfg = NewConfig()
+	}
+
+	if len(args) != 0 {
+		cfg.ServerAddress = args[0]
+	}
+
+	if email := cmd.Flag("email").Value.String(); email != "" {
+		cfg.Email = email
+	}
+
+	if caCert := cmd.Flag("ca-cert").Value.String(); caCert != "" {
+		cfg.CACert = caCert
+	}
+
+	switch {
+	case context == "" || cfg.ServerAddress == "":
+		return ErrNoServer
+	case cfg.Email == "":
+		return ErrNoEmail
+	}
+
+	password := cmd.Flag("password").Value.String()
+	if password == "" {
+		fmt.Fprint(cmd.OutOrStdout(), "password: ")
+
+		line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+
+		password = strings.TrimSpace(line)
+	}
+
+	token, err := loginToken(cfg, password)
+	if err != nil {
+		return err
+	}
+
+	cfg.Token = token
+	cfg.context = context
+
+	profiles.Profiles[context] = cfg
+	profiles.Current = context
+
+	if err := profiles.Save(cfgFile); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "logged in to %s as %s, context %s\n", cfg.ServerAddress, cfg.Email, context)
+
+	return nil
+}
+
+func NewLogout() *cobra.Command {
+	logoutCmd := &cobra.Command{
+		Use:   "logout",
+		Short: "logout of the zebra server of the current profile",
+		Long: `Logout ends the session of the login token of the current (or given)
+profile on the server, so that the token is rejected from then on. Login again
+to use the profile.`,
+		RunE:         logout,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	return logoutCmd
+}
+
+func logout(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Token == "" {
+		return ErrNoToken
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.Post("logout", nil, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "logged out of %s as %s\n", cfg.ServerAddress, cfg.Email)
+
+	return nil
+}
+
+// loginToken logs in to the server and returns the login token.
+func loginToken(cfg *Config, password string) (string, error) {
+	c, err := tlsClient(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(map[string]string{"email": cfg.Email, "password": password})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Post(cfg.ServerAddress+"/login", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s", ErrLoginFailed, resp.Status)
+	}
+
+	tokenRes := &struct {
+		JWT string `json:"jwt"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(tokenRes); err != nil {
+		return "", err
+	}
+
+	return tokenRes.JWT, nil
+}
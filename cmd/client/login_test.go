@@ -0,0 +1,156 @@
+package main //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthKey = "test-auth-key"
+
+// makeLoginServer returns a server that hands out tokens valid for the given
+// duration and counts the refreshes.
+func makeLoginServer(valid time.Duration, refreshes *int32) *httptest.Server {
+	token := func() string {
+		claims := auth.NewClaims("zebra", "loki", nil, "loki@asgard.io")
+		claims.ExpiresAt = time.Now().Add(valid).Unix()
+
+		data, _ := json.Marshal(map[string]string{"jwt": claims.JWT(testAuthKey)})
+
+		return string(data)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			body := map[string]string{}
+			if json.NewDecoder(req.Body).Decode(&body) != nil || body["password"] != "secret" {
+				rw.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			_, _ = rw.Write([]byte(token()))
+		case "/refresh":
+			atomic.AddInt32(refreshes, 1)
+
+			valid = time.Hour
+			_, _ = rw.Write([]byte(token()))
+		case "/logout":
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			cookie, err := req.Cookie("jwt")
+			if err != nil {
+				rw.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			if _, err := auth.FromJWT(cookie.Value, testAuthKey); err != nil {
+				rw.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			_, _ = rw.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	refreshes := int32(0)
+	server := makeLoginServer(time.Minute, &refreshes)
+
+	defer server.Close()
+
+	cfgFile := "test_login.yaml"
+
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	u, err := url.Parse(server.URL)
+	assert.Nil(err)
+
+	_, err = runCmd("-c", cfgFile, "login", server.URL, "-e", "loki@asgard.io", "-p", "wrong",
+		"--ca-cert", testCACertFile)
+	assert.ErrorIs(err, ErrLoginFailed)
+
+	out, err := runCmd("-c", cfgFile, "login", server.URL, "-e", "loki@asgard.io", "-p", "secret",
+		"--ca-cert", testCACertFile)
+	assert.Nil(err)
+	assert.Contains(out, "context "+u.Host)
+
+	cfg, err := Load(cfgFile)
+	assert.Nil(err)
+	assert.Nil(cfg.Key)
+	assert.NotEmpty(cfg.Token)
+
+	// The token is about to expire, so it is refreshed before use
+	token := cfg.Token
+	_, err = runCmd("-c", cfgFile, "get")
+	assert.Nil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&refreshes))
+
+	cfg, err = Load(cfgFile)
+	assert.Nil(err)
+	assert.NotEqual(token, cfg.Token)
+
+	_, err = runCmd("-c", cfgFile, "get")
+	assert.Nil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&refreshes))
+
+	out, err = runCmd("-c", cfgFile, "logout")
+	assert.Nil(err)
+	assert.Equal("logged out of "+server.URL+" as loki@asgard.io\n", out)
+
+	// Login again to the current profile, reading the password
+	cmd := New()
+	cmd.SetIn(strings.NewReader("secret\n"))
+	cmd.SetOut(new(strings.Builder))
+	cmd.SetArgs([]string{"-c", cfgFile, "login"})
+	assert.Nil(cmd.Execute())
+
+	_, err = runCmd("-c", cfgFile, "--context", "other", "login")
+	assert.Equal(ErrNoServer, err)
+
+	_, err = runCmd("-c", cfgFile, "login", server.URL+"/other", "--context", "other", "-p", "secret")
+	assert.Equal(ErrNoEmail, err)
+}
+
+func TestExpiredToken(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	refreshes := int32(0)
+	server := makeLoginServer(-time.Minute, &refreshes)
+
+	defer server.Close()
+
+	cfg := &Config{ServerAddress: server.URL, Email: "loki@asgard.io", CACert: testCACertFile}
+
+	token, err := loginToken(cfg, "secret")
+	assert.Nil(err)
+	assert.True(tokenExpiry(token).Before(time.Now()))
+
+	cfg.Token = token
+	client, err := NewClient(cfg)
+	assert.Nil(err)
+
+	_, err = client.Get("api/v1/resources", nil, nil)
+	assert.Equal(ErrTokenExpired, err)
+
+	assert.True(tokenExpiry("junk").IsZero())
+	assert.True(tokenExpiry("a.b.c").IsZero())
+	assert.True(tokenExpiry("a.e30.c").IsZero())
+}
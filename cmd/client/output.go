@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrOutputFormat = errors.New("output format must be json, yaml, template=... or jsonpath=...")
+
+const DefaultOutput = "json"
+
+// addOutputFlag adds the output format flag to a read command.
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", DefaultOutput,
+		"output format: json, yaml, template=<go template> or jsonpath=<jsonpath>")
+}
+
+// printer writes a value in the output format selected by the user. Templates
+// and JSONPath expressions are applied to the value as it appears in the JSON
+// output, so field names are the JSON field names.
+type printer func(w io.Writer, value interface{}) error
+
+func newPrinter(format string) (printer, error) {
+	kind, arg, _ := strings.Cut(format, "=")
+
+	switch kind {
+	case "json":
+		return printJSON, nil
+	case "yaml":
+		return printYAML, nil
+	case "template", "go-template":
+		tmpl, err := template.New("output").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(w io.Writer, value interface{}) error {
+			data, err := genericValue(value)
+			if err != nil {
+				return err
+			}
+
+			return tmpl.Execute(w, data)
+		}, nil
+	case "jsonpath":
+		jp, err := ParseJSONPath(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(w io.Writer, value interface{}) error {
+			data, err := genericValue(value)
+			if err != nil {
+				return err
+			}
+
+			return jp.Execute(w, data)
+		}, nil
+	}
+
+	return nil, ErrOutputFormat
+}
+
+// outputPrinter returns the printer for the output flag of the command.
+func outputPrinter(cmd *cobra.Command) (printer, error) {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, err
+	}
+
+	return newPrinter(format)
+}
+
+func printJSON(w io.Writer, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+
+	return err
+}
+
+func printYAML(w io.Writer, value interface{}) error {
+	data, err := genericValue(value)
+	if err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+
+	return err
+}
+
+// genericValue returns the value decoded from its JSON representation.
+func genericValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+
+	return generic, err
+}
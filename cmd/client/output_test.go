@@ -0,0 +1,47 @@
+package main //nolint:testpackage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrinter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	value := map[string]interface{}{"name": "zebra", "legs": 4}
+
+	tests := map[string]string{
+		"json":                       "{\n  \"legs\": 4,\n  \"name\": \"zebra\"\n}\n",
+		"yaml":                       "legs: 4\nname: zebra\n",
+		"template={{.name}}":         "zebra",
+		"go-template={{.legs}} legs": "4 legs",
+		"jsonpath={.name}":           "zebra",
+	}
+
+	for format, expected := range tests {
+		p, err := newPrinter(format)
+		assert.Nil(err, format)
+
+		out := new(bytes.Buffer)
+		assert.Nil(p(out, value))
+		assert.Equal(expected, out.String(), format)
+	}
+
+	for _, bad := range []string{"xml", "template={{", "jsonpath={.x"} {
+		_, err := newPrinter(bad)
+		assert.NotNil(err, bad)
+	}
+
+	p, err := newPrinter("template={{.name}}")
+	assert.Nil(err)
+	assert.NotNil(p(new(bytes.Buffer), make(chan int)))
+
+	p, err = newPrinter("jsonpath={.name}")
+	assert.Nil(err)
+	assert.NotNil(p(new(bytes.Buffer), make(chan int)))
+	assert.NotNil(printJSON(new(bytes.Buffer), make(chan int)))
+	assert.NotNil(printYAML(new(bytes.Buffer), make(chan int)))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoContext = errors.New("context not found in zebra config")
+
+// DefaultContext is the name given to a single configuration when it is
+// turned into a profile.
+const DefaultContext = "default"
+
+// Environment variables overriding the client configuration.
+const (
+	EnvConfig  = "ZEBRA_CONFIG"
+	EnvContext = "ZEBRA_CONTEXT"
+	EnvServer  = "ZEBRA_SERVER"
+	EnvEmail   = "ZEBRA_EMAIL"
+	EnvCACert  = "ZEBRA_CA_CERT"
+	EnvToken   = "ZEBRA_TOKEN"
+
+	EnvServiceToken = "ZEBRA_SERVICE_TOKEN"
+)
+
+// Profiles is a config file holding the configuration of several servers,
+// each under a context name, along with the context in use.
+type Profiles struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]*Config `yaml:"profiles"`
+	single   bool
+}
+
+// defaultConfigFile returns the config file to use when none is given. The
+// profiles file in the user config directory is preferred over the older
+// ~/.zebra.yaml, which is only used if it already exists.
+func defaultConfigFile() string {
+	if file := os.Getenv(EnvConfig); file != "" {
+		return file
+	}
+
+	home := os.Getenv("HOME")
+	file := path.Join(home, ".config", "zebra", "config.yaml")
+
+	if _, err := os.Stat(file); err != nil {
+		if _, err := os.Stat(path.Join(home, ".zebra.yaml")); err == nil {
+			return path.Join(home, ".zebra.yaml")
+		}
+	}
+
+	return file
+}
+
+// loadProfiles reads the profiles in the file. A file with a single
+// configuration is returned as the default context, a missing file has no
+// profiles.
+func loadProfiles(cfgFile string) (*Profiles, error) {
+	profiles := &Profiles{Current: "", Profiles: map[string]*Config{}}
+
+	data, err := ioutil.ReadFile(cfgFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, profiles); err != nil {
+		return nil, err
+	}
+
+	if len(profiles.Profiles) == 0 {
+		cfg := NewConfig()
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+
+		profiles.Current = DefaultContext
+		profiles.Profiles = map[string]*Config{DefaultContext: cfg}
+		profiles.single = true
+	}
+
+	for name, cfg := range profiles.Profiles {
+		if cfg.Defaults.Duration == 0 {
+			cfg.Defaults = NewConfig().Defaults
+		}
+
+		// A single configuration is saved as it is, without a context
+		cfg.file, cfg.context = cfgFile, name
+		if profiles.single {
+			cfg.context = ""
+		}
+	}
+
+	return profiles, nil
+}
+
+func (p *Profiles) Save(cfgFile string) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(cfgFile), os.ModePerm); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(cfgFile, data, ReadOnly)
+}
+
+// LoadContext returns the configuration of the named context in the file, or
+// of the current context if no name is given.
+func LoadContext(cfgFile string, context string) (*Config, error) {
+	if _, err := os.Stat(cfgFile); err != nil {
+		return nil, err
+	}
+
+	profiles, err := loadProfiles(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if context == "" {
+		context = profiles.Current
+	}
+
+	cfg, ok := profiles.Profiles[context]
+	if !ok {
+		return nil, ErrNoContext
+	}
+
+	return cfg, nil
+}
+
+// loadConfig returns the configuration for a command talking to the server,
+// from the config file and context flags, with environment overrides. A
+// configuration changed by the environment is not saved back to the file.
+func loadConfig(cmd *cobra.Command) (*Config, error) {
+	cfg, err := LoadContext(cmd.Flag("config").Value.String(), contextName(cmd))
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]*string{
+		EnvServer: &cfg.ServerAddress,
+		EnvEmail:  &cfg.Email,
+		EnvCACert: &cfg.CACert,
+		EnvToken:  &cfg.Token,
+
+		EnvServiceToken: &cfg.ServiceToken,
+	}
+
+	for env, field := range overrides {
+		if val := os.Getenv(env); val != "" {
+			*field = val
+			cfg.file = ""
+		}
+	}
+
+	return cfg, nil
+}
+
+// contextName returns the context selected by flag or environment, or an
+// empty string for the current context.
+func contextName(cmd *cobra.Command) string {
+	if flag := cmd.Flag("context"); flag != nil && flag.Value.String() != "" {
+		return flag.Value.String()
+	}
+
+	return os.Getenv(EnvContext)
+}
+
+func useContext(cmd *cobra.Command, args []string) error {
+	cfgFile := cmd.Flag("config").Value.String()
+
+	profiles, err := loadProfiles(cfgFile)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := profiles.Profiles[args[0]]; !ok {
+		return ErrNoContext
+	}
+
+	profiles.Current = args[0]
+
+	return profiles.Save(cfgFile)
+}
@@ -0,0 +1,103 @@
+package main //nolint:testpackage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_profiles/config.yaml"
+
+	t.Cleanup(func() { os.RemoveAll("test_profiles") })
+
+	// A single configuration is kept as it is
+	cfg := NewConfig()
+	cfg.Email = "loki@asgard.io"
+	assert.Nil(cfg.Save(cfgFile))
+
+	cfg, err := Load(cfgFile)
+	assert.Nil(err)
+	assert.Equal("loki@asgard.io", cfg.Email)
+	assert.Nil(cfg.Save(cfgFile))
+
+	profiles, err := loadProfiles(cfgFile)
+	assert.Nil(err)
+	assert.True(profiles.single)
+
+	_, err = LoadContext(cfgFile, "prod")
+	assert.Equal(ErrNoContext, err)
+
+	// Add a second profile
+	prod := NewConfig()
+	prod.Email = "thor@asgard.io"
+	prod.context = "prod"
+	assert.Nil(prod.Save(cfgFile))
+
+	profiles, err = loadProfiles(cfgFile)
+	assert.Nil(err)
+	assert.False(profiles.single)
+	assert.Equal(DefaultContext, profiles.Current)
+	assert.Equal(2, len(profiles.Profiles))
+
+	cfg, err = LoadContext(cfgFile, "prod")
+	assert.Nil(err)
+	assert.Equal("thor@asgard.io", cfg.Email)
+
+	argLock.Lock()
+	defer argLock.Unlock()
+
+	_, err = runCmd("-c", cfgFile, "config", "use-context", "test")
+	assert.Equal(ErrNoContext, err)
+
+	_, err = runCmd("-c", cfgFile, "config", "use-context", "prod")
+	assert.Nil(err)
+
+	_, err = runCmd("-c", cfgFile, "--context", DefaultContext, "config", "email", "odin@asgard.io")
+	assert.Nil(err)
+
+	cfg, err = Load(cfgFile)
+	assert.Nil(err)
+	assert.Equal("thor@asgard.io", cfg.Email)
+
+	cfg, err = LoadContext(cfgFile, DefaultContext)
+	assert.Nil(err)
+	assert.Equal("odin@asgard.io", cfg.Email)
+}
+
+func TestEnvOverrides(t *testing.T) { //nolint:paralleltest
+	assert := assert.New(t)
+
+	cfgFile := "test_env.yaml"
+
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	cfg := NewConfig()
+	cfg.Email = "loki@asgard.io"
+	cfg.context = "dev"
+	assert.Nil(cfg.Save(cfgFile))
+
+	t.Setenv(EnvServer, "https://zebra.asgard.io")
+	t.Setenv(EnvContext, "dev")
+
+	cmd := New()
+	assert.Nil(cmd.ParseFlags([]string{"-c", cfgFile}))
+
+	cfg, err := loadConfig(cmd)
+	assert.Nil(err)
+	assert.Equal("https://zebra.asgard.io", cfg.ServerAddress)
+	assert.Equal("loki@asgard.io", cfg.Email)
+	assert.Empty(cfg.file)
+
+	t.Setenv(EnvContext, "prod")
+
+	_, err = loadConfig(cmd)
+	assert.Equal(ErrNoContext, err)
+
+	t.Setenv(EnvConfig, "env.yaml")
+	assert.Equal("env.yaml", defaultConfigFile())
+}
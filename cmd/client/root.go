@@ -2,7 +2,6 @@ package main
 
 import (
 	"os"
-	"path"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
@@ -19,14 +18,25 @@ func New() *cobra.Command {
 		Version: version + "\n",
 	}
 	rootCmd.SetVersionTemplate(version + "\n")
-	rootCmd.PersistentFlags().StringP("config", "c",
-		path.Join(os.Getenv("HOME"), ".zebra.yaml"),
-		"config file",
-	)
+	rootCmd.PersistentFlags().StringP("config", "c", defaultConfigFile(), "config file")
+	rootCmd.PersistentFlags().String("context", "", "server profile in the config file, default: current")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
 
 	rootCmd.AddCommand(NewConfigure())
 	rootCmd.AddCommand(NewLease())
+	rootCmd.AddCommand(NewApply())
+	rootCmd.AddCommand(NewBrowse())
+	rootCmd.AddCommand(NewGet())
+	rootCmd.AddCommand(NewView())
+	rootCmd.AddCommand(NewStar())
+	rootCmd.AddCommand(NewComment())
+	rootCmd.AddCommand(NewActivity())
+	rootCmd.AddCommand(NewCompletion())
+	rootCmd.AddCommand(NewLogin())
+	rootCmd.AddCommand(NewLogout())
+	rootCmd.AddCommand(NewCacheCmd())
+	rootCmd.AddCommand(NewSeed())
+	rootCmd.AddCommand(NewExport())
 
 	return rootCmd
 }
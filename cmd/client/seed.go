@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+var ErrSeedCount = errors.New("seed count and batch size must be positive")
+
+// Shape of the generated inventory.
+const (
+	DefaultSeedCount = 1000
+	DefaultSeedBatch = 500
+	LabsPerDC        = 4
+	RacksPerLab      = 8
+	VLANPoolsPerLab  = 2
+	SwitchesPerRack  = 2
+	ServersPerRack   = 16
+	VLANPoolSize     = 100
+)
+
+func NewSeed() *cobra.Command {
+	seedCmd := &cobra.Command{
+		Use:   "seed",
+		Short: "load generated fake resources into the server for testing",
+		Long: `Generate fake datacenters, labs, racks, VLAN pools, switches and servers,
+related by the system.parent label, and load them into the server in batches.
+The same seed always generates the same resources, ids included, so that
+load tests run against a reproducible corpus.`,
+		RunE:         seedResources,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	seedCmd.Flags().IntP("count", "n", DefaultSeedCount, "number of resources to generate")
+	seedCmd.Flags().Int64("seed", 1, "random number generator seed")
+	seedCmd.Flags().Int("batch", DefaultSeedBatch, "number of resources per request")
+	seedCmd.Flags().Bool("dry-run", false, "print the generated resources instead of loading them")
+	addOutputFlag(seedCmd)
+
+	return seedCmd
+}
+
+func seedResources(cmd *cobra.Command, args []string) error {
+	count, _ := cmd.Flags().GetInt("count")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	batch, _ := cmd.Flags().GetInt("batch")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	if count <= 0 || batch <= 0 {
+		return ErrSeedCount
+	}
+
+	resources := newSeeder(seed).generate(count)
+
+	if dryRun {
+		printOut, err := outputPrinter(cmd)
+		if err != nil {
+			return err
+		}
+
+		return printOut(cmd.OutOrStdout(), seedMap(resources))
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Parents are generated before their children, so the batches can be
+	// loaded in order
+	for start := 0; start < len(resources); start += batch {
+		end := start + batch
+		if end > len(resources) {
+			end = len(resources)
+		}
+
+		if _, err := client.Post("api/v1/resources", seedMap(resources[start:end]), nil); err != nil {
+			return err
+		}
+	}
+
+	printSeedSummary(cmd, resources, seed)
+
+	return nil
+}
+
+func seedMap(resources []zebra.Resource) *zebra.ResourceMap {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	for _, res := range resources {
+		resMap.Add(res, res.GetType())
+	}
+
+	return resMap
+}
+
+func printSeedSummary(cmd *cobra.Command, resources []zebra.Resource, seed int64) {
+	counts := map[string]int{}
+	for _, res := range resources {
+		counts[res.GetType()]++
+	}
+
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+
+	fmt.Fprintf(cmd.OutOrStdout(), "seeded %d resources with seed %d\n", len(resources), seed)
+
+	for _, t := range types {
+		fmt.Fprintf(cmd.OutOrStdout(), "  %s: %d\n", t, counts[t])
+	}
+}
+
+// seeder generates a plausible inventory from a seeded random number
+// generator. Every random value, ids included, comes from the generator.
+type seeder struct {
+	rng       *rand.Rand
+	resources []zebra.Resource
+	count     int
+}
+
+func newSeeder(seed int64) *seeder {
+	return &seeder{
+		rng:       rand.New(rand.NewSource(seed)), //nolint:gosec
+		resources: nil,
+		count:     0,
+	}
+}
+
+var (
+	seedRegions = []string{"Americas", "Europe", "Asia", "Oceania"}
+	seedCities  = []string{"San Jose", "Austin", "Raleigh", "London", "Berlin", "Bangalore", "Tokyo", "Sydney"}
+	seedEnvs    = []string{"production", "staging", "development", "qa"}
+	seedTeams   = []string{"compute", "network", "storage", "platform", "security", "release"}
+	seedModels  = []string{"UCS C220 M6", "UCS C240 M6", "UCS B200 M5", "UCS X210c M6"}
+	seedSwitch  = []string{"Nexus 9336C", "Nexus 93180YC", "Catalyst 9300", "Nexus 3172"}
+	seedPorts   = []uint32{24, 32, 48, 96}
+)
+
+// generate returns count resources, parents before their children.
+func (s *seeder) generate(count int) []zebra.Resource {
+	s.resources = make([]zebra.Resource, 0, count)
+	s.count = count
+
+	for d := 0; !s.full(); d++ {
+		region := s.pick(seedRegions)
+		datacenter := dc.NewDatacenter(
+			fmt.Sprintf("%d Innovation Drive, %s", 100+s.rng.Intn(9900), s.pick(seedCities)), //nolint:gomnd
+			fmt.Sprintf("dc-%02d", d), s.labels(region, ""))
+		s.add(datacenter, &datacenter.BaseResource)
+
+		for l := 0; l < LabsPerDC && !s.full(); l++ {
+			s.addLab(region, datacenter.ID, d, l)
+		}
+	}
+
+	return s.resources
+}
+
+func (s *seeder) addLab(region string, parent string, d int, l int) {
+	lab := dc.NewLab(fmt.Sprintf("lab-%02d-%d", d, l), s.labels(region, parent))
+	s.add(lab, &lab.BaseResource)
+
+	for v := 0; v < VLANPoolsPerLab && !s.full(); v++ {
+		start := uint16((l*VLANPoolsPerLab + v) * VLANPoolSize)
+		pool := network.NewVlanPool(start, start+VLANPoolSize-1, s.labels(region, lab.ID))
+		s.add(pool, &pool.BaseResource)
+	}
+
+	for r := 0; r < RacksPerLab && !s.full(); r++ {
+		rack := dc.NewRack(fmt.Sprintf("%s-r%02d", lab.Name, r),
+			fmt.Sprintf("%c%02d", 'A'+r/4, r%4), s.labels(region, lab.ID))
+		s.add(rack, &rack.BaseResource)
+
+		for w := 0; w < SwitchesPerRack && !s.full(); w++ {
+			sw := network.NewSwitch(
+				[]string{s.serial("SW"), s.pick(seedSwitch), fmt.Sprintf("%s-sw%d", rack.Name, w)},
+				seedPorts[s.rng.Intn(len(seedPorts))], s.ip(d, l, r, w+1), s.labels(region, rack.ID))
+			s.add(sw, &sw.BaseResource)
+			sw.Credentials.ID = sw.ID
+		}
+
+		for h := 0; h < ServersPerRack && !s.full(); h++ {
+			srv := compute.NewServer(
+				[]string{s.serial("SRV"), s.pick(seedModels), fmt.Sprintf("%s-s%02d", rack.Name, h)},
+				s.ip(d, l, r, SwitchesPerRack+h+1), s.labels(region, rack.ID))
+			s.add(srv, &srv.BaseResource)
+			srv.Credentials.ID = srv.ID
+		}
+	}
+}
+
+// add gives the resource an id from the generator and adds it.
+func (s *seeder) add(res zebra.Resource, base *zebra.BaseResource) {
+	id, err := uuid.NewRandomFromReader(s.rng)
+	if err != nil {
+		// Reading from math/rand never fails
+		panic(err)
+	}
+
+	base.ID = id.String()
+	s.resources = append(s.resources, res)
+}
+
+func (s *seeder) full() bool {
+	return len(s.resources) >= s.count
+}
+
+func (s *seeder) pick(values []string) string {
+	return values[s.rng.Intn(len(values))]
+}
+
+func (s *seeder) serial(prefix string) string {
+	return fmt.Sprintf("%s%08d", prefix, s.rng.Intn(100000000)) //nolint:gomnd
+}
+
+func (s *seeder) ip(d, l, r, h int) net.IP {
+	return net.IPv4(10, byte(d), byte(l*RacksPerLab+r), byte(h)) //nolint:gomnd
+}
+
+// labels returns the labels of a resource in the region with the given
+// parent, the environment and owning team are random.
+func (s *seeder) labels(region string, parent string) zebra.Labels {
+	labels := zebra.Labels{
+		"system.group": region,
+		"environment":  s.pick(seedEnvs),
+		"team":         s.pick(seedTeams),
+	}
+
+	if parent != "" {
+		labels[zebra.ParentLabel] = parent
+	}
+
+	return labels
+}
@@ -0,0 +1,84 @@
+package main //nolint:testpackage
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeeder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := newSeeder(42).generate(300)
+	assert.Len(resources, 300)
+
+	ids := map[string]bool{}
+	types := map[string]bool{}
+
+	for _, res := range resources {
+		assert.Nil(res.Validate(context.Background()))
+		assert.False(ids[res.GetID()])
+
+		// Parents come first
+		if parent, ok := res.GetLabels()[zebra.ParentLabel]; ok {
+			assert.True(ids[parent])
+		}
+
+		ids[res.GetID()] = true
+		types[res.GetType()] = true
+	}
+
+	for _, t := range []string{"Datacenter", "Lab", "VLANPool", "Rack", "Switch", "Server"} {
+		assert.True(types[t], t)
+	}
+
+	sorted, err := zebra.SortByReferences(resources)
+	assert.Nil(err)
+	assert.Len(sorted, 300)
+
+	other := newSeeder(43).generate(300)
+	assert.NotEqual(resources[0].GetID(), other[0].GetID())
+
+	// The same seed generates the same resources
+	for i, res := range newSeeder(42).generate(300) {
+		assert.Equal(resources[i].GetID(), res.GetID())
+		assert.Equal(resources[i].GetLabels(), res.GetLabels())
+	}
+}
+
+func TestSeedCmd(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_seed.yaml"
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	srv := &applyServer{resources: map[string]zebra.Resource{}}
+	server := httptest.NewServer(srv)
+
+	t.Cleanup(server.Close)
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	out, err := runCmd("seed", "-c", cfgFile, "-n", "75", "--batch", "20", "--seed", "7")
+	assert.Nil(err)
+	assert.Contains(out, "seeded 75 resources with seed 7")
+	assert.Contains(out, "Server: ")
+	assert.Len(srv.resources, 75)
+
+	for _, res := range newSeeder(7).generate(75) {
+		assert.Contains(srv.resources, res.GetID())
+	}
+
+	out, err = runCmd("seed", "-c", cfgFile, "-n", "5", "--dry-run", "-o", "jsonpath={.Lab[*].name}")
+	assert.Nil(err)
+	assert.Equal("lab-00-0", strings.TrimSpace(out))
+
+	_, err = runCmd("seed", "-c", cfgFile, "-n", "0")
+	assert.ErrorIs(err, ErrSeedCount)
+}
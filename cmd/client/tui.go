@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/project-safari/zebra"
+)
+
+// Escape sequences of the full screen view: the alternate screen, the cursor
+// and reverse video for the selected resource.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+	reverse     = "\x1b[7m"
+	bold        = "\x1b[1m"
+	normal      = "\x1b[0m"
+)
+
+// Size of terminals that do not report one.
+const (
+	defaultRows = 24
+	defaultCols = 80
+)
+
+const screenHelp = "↑↓ move  enter details  t type  / filter  c clear  h history  l lease  d release  " +
+	"r reload  q quit"
+
+// keyCode is a key the full screen view reacts to, printable keys are runes.
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyTab
+	keyEscape
+	keyBackspace
+	keyInterrupt
+)
+
+type keyPress struct {
+	code keyCode
+	r    rune
+}
+
+// readKey reads a key press from the raw terminal, where the arrow and page
+// keys arrive as escape sequences. Unknown sequences are a zero rune.
+func readKey(in *bufio.Reader) (keyPress, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return keyPress{code: keyRune, r: 0}, err
+	}
+
+	switch r {
+	case '\r', '\n':
+		return keyPress{code: keyEnter, r: 0}, nil
+	case '\t':
+		return keyPress{code: keyTab, r: 0}, nil
+	case 0x7f, '\b':
+		return keyPress{code: keyBackspace, r: 0}, nil
+	case 0x03:
+		return keyPress{code: keyInterrupt, r: 0}, nil
+	case 0x1b:
+		// A sequence arrives at once, a lone escape is the escape key
+		if in.Buffered() == 0 {
+			return keyPress{code: keyEscape, r: 0}, nil
+		}
+
+		return readEscape(in)
+	}
+
+	return keyPress{code: keyRune, r: r}, nil
+}
+
+// readEscape reads the rest of an escape sequence, such as "[A" for the up
+// arrow or "[5~" for page up.
+func readEscape(in *bufio.Reader) (keyPress, error) {
+	c, err := in.ReadByte()
+	if err != nil {
+		return keyPress{code: keyEscape, r: 0}, err
+	}
+
+	// Escape typed before another key
+	if c != '[' && c != 'O' {
+		return keyPress{code: keyEscape, r: 0}, in.UnreadByte()
+	}
+
+	seq := []byte{}
+
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			return keyPress{code: keyEscape, r: 0}, err
+		}
+
+		seq = append(seq, c)
+
+		// The final byte of the sequence
+		if c >= '@' && c <= '~' {
+			break
+		}
+	}
+
+	codes := map[string]keyCode{
+		"A": keyUp, "B": keyDown, "5~": keyPageUp, "6~": keyPageDown,
+		"H": keyHome, "1~": keyHome, "7~": keyHome, "F": keyEnd, "4~": keyEnd, "8~": keyEnd,
+	}
+
+	if code, ok := codes[string(seq)]; ok {
+		return keyPress{code: code, r: 0}, nil
+	}
+
+	return keyPress{code: keyRune, r: 0}, nil
+}
+
+type pane int
+
+const (
+	listPane pane = iota
+	detailPane
+)
+
+// screen is the full screen view of the browser: the resources of the
+// current type and filters on the left, the details of the selected one on
+// the right, and a status line for the results of the commands and for the
+// input they need.
+type screen struct {
+	b         *browser
+	size      func() (int, int)
+	resources []zebra.Resource
+	types     []string
+	cursor    int
+	offset    int
+	focus     pane
+	detail    []string
+	scroll    int
+	status    string
+	prompt    string
+	input     []rune
+	submit    func(string)
+}
+
+func newScreen(b *browser, size func() (int, int)) *screen {
+	return &screen{
+		b:         b,
+		size:      size,
+		resources: []zebra.Resource{},
+		types:     nil,
+		cursor:    0,
+		offset:    0,
+		focus:     listPane,
+		detail:    []string{},
+		scroll:    0,
+		status:    "",
+		prompt:    "",
+		input:     nil,
+		submit:    nil,
+	}
+}
+
+// browseScreen runs the full screen view on the terminal until it is quit.
+func browseScreen(b *browser, in *os.File, out io.Writer) error {
+	restore, err := rawTerminal(in)
+	if err != nil {
+		return err
+	}
+
+	defer restore()
+
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	return newScreen(b, func() (int, int) { return terminalSize(in) }).loop(in, out)
+}
+
+// loop draws the view and handles the keys until the view is quit or the
+// input ends.
+func (s *screen) loop(in io.Reader, out io.Writer) error {
+	keys := bufio.NewReader(in)
+
+	s.load()
+
+	for {
+		s.draw(out)
+
+		k, err := readKey(keys)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !s.handle(k) {
+			return nil
+		}
+	}
+}
+
+// load lists the resources again, keeping the selection where it was.
+func (s *screen) load() {
+	resources, err := s.b.query()
+	if err != nil {
+		s.status = "error: " + err.Error()
+
+		return
+	}
+
+	s.resources = resources
+	s.move(0)
+}
+
+func (s *screen) selected() zebra.Resource {
+	if s.cursor < len(s.resources) {
+		return s.resources[s.cursor]
+	}
+
+	return nil
+}
+
+// move moves the selection and shows the details of the selected resource.
+func (s *screen) move(delta int) {
+	s.cursor += delta
+
+	if s.cursor >= len(s.resources) {
+		s.cursor = len(s.resources) - 1
+	}
+
+	if s.cursor < 0 {
+		s.cursor = 0
+	}
+
+	s.detail, s.scroll = []string{}, 0
+
+	if res := s.selected(); res != nil {
+		data, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			s.status = "error: " + err.Error()
+
+			return
+		}
+
+		s.detail = strings.Split(string(data), "\n")
+	}
+}
+
+// scrollBy scrolls the focused pane.
+func (s *screen) scrollBy(delta int) {
+	if s.focus == listPane {
+		s.move(delta)
+
+		return
+	}
+
+	s.scroll += delta
+
+	if s.scroll > len(s.detail)-1 {
+		s.scroll = len(s.detail) - 1
+	}
+
+	if s.scroll < 0 {
+		s.scroll = 0
+	}
+}
+
+// page is the number of resources the list shows at once.
+func (s *screen) page() int {
+	_, rows := s.size()
+	if rows > 4 { //nolint:gomnd
+		return rows - 3 //nolint:gomnd
+	}
+
+	return 1
+}
+
+// handle handles the key, it returns false if the view is quit.
+func (s *screen) handle(k keyPress) bool {
+	if s.submit != nil {
+		s.edit(k)
+
+		return true
+	}
+
+	switch k.code {
+	case keyInterrupt:
+		return false
+	case keyUp:
+		s.scrollBy(-1)
+	case keyDown:
+		s.scrollBy(1)
+	case keyPageUp:
+		s.scrollBy(-s.page())
+	case keyPageDown:
+		s.scrollBy(s.page())
+	case keyHome:
+		s.scrollBy(-len(s.resources) - len(s.detail))
+	case keyEnd:
+		s.scrollBy(len(s.resources) + len(s.detail))
+	case keyEnter, keyTab:
+		s.focus = 1 - s.focus
+	case keyEscape:
+		s.focus = listPane
+	case keyBackspace:
+	case keyRune:
+		return s.command(k.r)
+	}
+
+	return true
+}
+
+// command runs the command of the key, it returns false if the view is quit.
+func (s *screen) command(r rune) bool {
+	switch r {
+	case 'q':
+		return false
+	case 'k':
+		s.scrollBy(-1)
+	case 'j':
+		s.scrollBy(1)
+	case 'r':
+		s.status = ""
+		s.load()
+	case 't':
+		s.nextType()
+	case 'c':
+		s.status = ""
+		s.b.filters = []zebra.Query{}
+		s.load()
+	case '/':
+		s.ask("filter key=value: ", func(line string) {
+			s.report("", s.b.filter(strings.Fields(line)))
+			s.cursor = 0
+			s.load()
+		})
+	case 'l':
+		s.ask("lease type [count] [group]: ", func(line string) {
+			s.report(s.run("lease", strings.Fields(line)...))
+			s.load()
+		})
+	case 'h':
+		if res := s.selected(); res != nil {
+			out, err := s.run("history", res.GetID())
+			s.report("history of "+res.GetID(), err)
+
+			if err == nil {
+				s.detail, s.scroll = strings.Split(strings.TrimRight(out, "\n"), "\n"), 0
+			}
+		}
+	case 'd':
+		if res := s.selected(); res != nil {
+			s.report(s.run("release", res.GetID()))
+			s.load()
+		}
+	}
+
+	return true
+}
+
+// nextType shows the resources of the next type, after the last one all the
+// types are shown again.
+func (s *screen) nextType() {
+	if s.types == nil {
+		types, err := s.b.typeList()
+		if err != nil {
+			s.report("", err)
+
+			return
+		}
+
+		s.types = []string{""}
+		for _, t := range types {
+			s.types = append(s.types, t.Name)
+		}
+	}
+
+	for i, t := range s.types {
+		if t == s.b.resType {
+			s.b.resType = s.types[(i+1)%len(s.types)]
+
+			break
+		}
+	}
+
+	s.status, s.cursor = "", 0
+	s.load()
+}
+
+// ask reads a line on the status line and calls submit with it, unless the
+// input is cancelled with escape.
+func (s *screen) ask(prompt string, submit func(string)) {
+	s.prompt, s.input, s.submit = prompt, []rune{}, submit
+}
+
+func (s *screen) edit(k keyPress) {
+	switch k.code {
+	case keyEnter:
+		submit, line := s.submit, string(s.input)
+		s.prompt, s.input, s.submit = "", nil, nil
+		submit(line)
+	case keyEscape, keyInterrupt:
+		s.prompt, s.input, s.submit = "", nil, nil
+	case keyBackspace:
+		if len(s.input) != 0 {
+			s.input = s.input[:len(s.input)-1]
+		}
+	case keyRune:
+		if k.r >= ' ' {
+			s.input = append(s.input, k.r)
+		}
+	case keyUp, keyDown, keyPageUp, keyPageDown, keyHome, keyEnd, keyTab:
+	}
+}
+
+// run runs the browser command and returns its output.
+func (s *screen) run(command string, args ...string) (string, error) {
+	out := s.b.out
+	buf := new(bytes.Buffer)
+	s.b.out = buf
+
+	defer func() { s.b.out = out }()
+
+	err := s.b.exec(command, args)
+
+	return buf.String(), err
+}
+
+// report shows the error on the status line, or the last line of the output.
+func (s *screen) report(out string, err error) {
+	if err != nil {
+		s.status = "error: " + err.Error()
+
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	s.status = lines[len(lines)-1]
+}
+
+// draw draws the whole view.
+func (s *screen) draw(out io.Writer) {
+	cols, rows := s.size()
+	body := s.page()
+	left := cols * 2 / 5 //nolint:gomnd
+	right := cols - left - 1
+
+	// Keep the selection in view
+	if s.cursor < s.offset {
+		s.offset = s.cursor
+	}
+
+	if s.cursor >= s.offset+body {
+		s.offset = s.cursor - body + 1
+	}
+
+	title := " zebra  type: all"
+	if s.b.resType != "" {
+		title = " zebra  type: " + s.b.resType
+	}
+
+	for _, f := range s.b.filters {
+		title += "  " + f.Key + "=" + strings.Join(f.Values, ",")
+	}
+
+	title += fmt.Sprintf("  (%d resources)", len(s.resources))
+	lines := []string{reverse + fit(title, cols) + normal}
+
+	for i := 0; i < body; i++ {
+		lines = append(lines, s.listLine(s.offset+i, left)+"│"+s.detailLine(s.scroll+i, right))
+	}
+
+	status := s.status
+	if s.submit != nil {
+		status = s.prompt + string(s.input) + "_"
+	}
+
+	lines = append(lines, reverse+fit(screenHelp, cols)+normal, fit(status, cols))
+
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+
+	fmt.Fprint(out, clearScreen+strings.Join(lines, "\r\n"))
+}
+
+// listLine returns the line of the list showing the i-th resource.
+func (s *screen) listLine(i int, width int) string {
+	if len(s.resources) == 0 && i == 0 {
+		return fit(" no resources", width)
+	}
+
+	if i >= len(s.resources) {
+		return fit("", width)
+	}
+
+	res := s.resources[i]
+	name, owner, _ := resourceSummary(res)
+
+	if name == "" {
+		name = res.GetID()
+	}
+
+	line := fit(" "+res.GetType()+"  "+name+"  "+owner, width)
+
+	switch {
+	case i == s.cursor && s.focus == listPane:
+		return reverse + line + normal
+	case i == s.cursor:
+		return bold + line + normal
+	}
+
+	return line
+}
+
+func (s *screen) detailLine(i int, width int) string {
+	if i >= len(s.detail) {
+		return fit("", width)
+	}
+
+	line := fit(" "+s.detail[i], width)
+	if s.focus == detailPane && i == s.scroll {
+		return bold + line + normal
+	}
+
+	return line
+}
+
+// fit pads or cuts the text to the width.
+func fit(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	runes := []rune(strings.ReplaceAll(text, "\t", " "))
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+
+	return string(runes) + strings.Repeat(" ", width-len(runes))
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// stty runs stty on the terminal.
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+
+	out, err := cmd.Output()
+
+	return strings.TrimSpace(string(out)), err
+}
+
+// rawTerminal puts the terminal in raw mode, without echo, and returns the
+// function restoring its previous mode.
+func rawTerminal(f *os.File) (func(), error) {
+	state, err := stty(f, "-g")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := stty(f, "raw", "-echo"); err != nil {
+		return nil, err
+	}
+
+	return func() { _, _ = stty(f, state) }, nil
+}
+
+// terminalSize returns the columns and rows of the terminal.
+func terminalSize(f *os.File) (int, int) {
+	out, err := stty(f, "size")
+	if err != nil {
+		return defaultCols, defaultRows
+	}
+
+	rows, cols, _ := strings.Cut(out, " ")
+
+	r, errRows := strconv.Atoi(rows)
+	c, errCols := strconv.Atoi(cols)
+
+	if errRows != nil || errCols != nil || r <= 0 || c <= 0 {
+		return defaultCols, defaultRows
+	}
+
+	return c, r
+}
@@ -0,0 +1,158 @@
+package main //nolint:testpackage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadKey(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	keys := bufio.NewReader(strings.NewReader("\x1b[A\x1b[B\x1b[5~\x1b[6~\x1b[H\x1bOF\x1b[Z\rx\t\x7f\x03\x1bq\x1b"))
+	codes := []keyCode{}
+
+	for {
+		k, err := readKey(keys)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		assert.Nil(err)
+
+		codes = append(codes, k.code)
+	}
+
+	assert.Equal([]keyCode{
+		keyUp, keyDown, keyPageUp, keyPageDown, keyHome, keyEnd, keyRune, keyEnter, keyRune, keyTab, keyBackspace,
+		keyInterrupt, keyEscape, keyRune, keyEscape,
+	}, codes)
+}
+
+func TestScreen(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+	mine := lease.NewLease("loki@asgard.io", 0, nil)
+
+	s := &applyServer{resources: map[string]zebra.Resource{lab.ID: lab, rack.ID: rack, mine.ID: mine}}
+	server := httptest.NewServer(s)
+
+	defer server.Close()
+
+	out := new(bytes.Buffer)
+	a := makeApplier(assert, server.URL, out)
+	b := &browser{cfg: a.client.cfg, client: a.client, out: out, filters: []zebra.Query{}, resType: ""}
+	v := newScreen(b, func() (int, int) { return 120, 20 })
+
+	press := func(keys string) {
+		in := bufio.NewReader(strings.NewReader(keys))
+
+		for k, err := readKey(in); err == nil; k, err = readKey(in) {
+			assert.True(v.handle(k))
+		}
+	}
+
+	frame := func() string {
+		screen := new(bytes.Buffer)
+		v.draw(screen)
+
+		return screen.String()
+	}
+
+	// The list on the left, the details of the selected resource on the right
+	v.load()
+	assert.Len(v.resources, 3)
+	assert.Equal(lab.ID, v.selected().GetID())
+	assert.Contains(frame(), "(3 resources)")
+	assert.Contains(frame(), `"name": "lab1"`)
+	assert.Equal(20, strings.Count(frame(), "\r\n")+1)
+
+	press("j\x1b[B")
+	assert.Equal(rack.ID, v.selected().GetID())
+	assert.Contains(frame(), `"row": "r1"`)
+
+	press("\x1b[5~")
+	assert.Equal(lab.ID, v.selected().GetID())
+
+	// The detail pane scrolls once focused, escape goes back to the list
+	press("\r\x1b[B\x1b[B")
+	assert.Equal(detailPane, v.focus)
+	assert.Equal(2, v.scroll)
+	assert.Equal(lab.ID, v.selected().GetID())
+
+	press("\x1b")
+	assert.Equal(listPane, v.focus)
+
+	// Filters are typed on the status line
+	press("/color=blu\x7fue")
+	assert.Contains(frame(), "filter key=value: color=blue_")
+
+	press("\r")
+	assert.Len(v.resources, 1)
+	assert.Contains(frame(), "color=blue  (1 resources)")
+
+	press("/color=\x1bc")
+	assert.Len(v.resources, 3)
+
+	// Types are cycled through
+	press("t")
+	assert.NotEqual("", b.resType)
+
+	b.resType = "Lease"
+
+	press("r")
+	assert.Len(v.resources, 1)
+	assert.Contains(frame(), "type: Lease")
+
+	press("h")
+	assert.Contains(frame(), "loki@asgard.io")
+	assert.Contains(frame(), "history of "+mine.ID)
+
+	// Leases are requested and released
+	press("lServer 2 lab\r")
+	assert.Contains(v.status, "lease requested:")
+	assert.Len(v.resources, 2)
+	assert.Equal(4, len(s.resources))
+
+	press("d")
+	assert.Contains(v.status, "lease released:")
+	assert.Equal(3, len(s.resources))
+
+	b.resType = ""
+
+	press("r")
+	v.cursor = 0
+
+	press("d")
+	assert.Equal("error: "+ErrNotLease.Error(), v.status)
+	assert.Empty(out.String())
+
+	// The loop draws until the view is quit
+	screen := new(bytes.Buffer)
+	assert.Nil(v.loop(strings.NewReader("jq"), screen))
+	assert.Equal(2, strings.Count(screen.String(), clearScreen))
+	assert.False(v.handle(keyPress{code: keyInterrupt, r: 0}))
+}
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal("ab  ", fit("ab", 4))
+	assert.Equal("ab", fit("abcd", 2))
+	assert.Equal("│ é", fit("│\té", 3))
+	assert.Equal("", fit("ab", 0))
+}
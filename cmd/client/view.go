@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+var ErrGetView = errors.New("resources can be selected by a view or by a query, not both")
+
+// view is a filtered view of the resources saved on the server. Columns and
+// sort are JSONPath expressions of the resources.
+type view struct {
+	Name    string        `json:"name"`
+	Types   []string      `json:"types,omitempty"`
+	Labels  []zebra.Query `json:"labels,omitempty"`
+	Starred bool          `json:"starred,omitempty"`
+	Columns []string      `json:"columns,omitempty"`
+	Sort    string        `json:"sort,omitempty"`
+}
+
+func NewView() *cobra.Command {
+	viewCmd := &cobra.Command{
+		Use:          "view",
+		Short:        "list the saved views, show them with get --view <name>",
+		RunE:         listViews,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	addOutputFlag(viewCmd)
+
+	saveCmd := &cobra.Command{
+		Use:          "save <name> [type...]",
+		Short:        "save a view of the resources of the types matching the selectors",
+		RunE:         saveView,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	saveCmd.Flags().StringArrayP("selector", "l", nil, "label selector key=value")
+	saveCmd.Flags().Bool("starred", false, "only show starred resources")
+	saveCmd.Flags().StringArray("column", nil, "column to show, a jsonpath such as .name")
+	saveCmd.Flags().String("sort", "", "column to sort by, descending if it starts with -")
+	_ = saveCmd.RegisterFlagCompletionFunc("selector", completeLabels)
+
+	viewCmd.AddCommand(saveCmd)
+	viewCmd.AddCommand(&cobra.Command{
+		Use:          "delete <name>",
+		Short:        "delete a saved view",
+		RunE:         deleteView,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	})
+
+	return viewCmd
+}
+
+func NewStar() *cobra.Command {
+	starCmd := &cobra.Command{
+		Use:          "star <resource-id>...",
+		Short:        "star resources, get --view mine shows them",
+		RunE:         starResources,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	starCmd.Flags().Bool("remove", false, "remove the stars instead")
+	starCmd.ValidArgsFunction = completeIDs
+
+	return starCmd
+}
+
+func starResources(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	remove, _ := cmd.Flags().GetBool("remove")
+
+	for _, id := range args {
+		path := "api/v1/stars/" + url.PathEscape(id)
+
+		if remove {
+			_, err = client.Delete(path, nil, nil)
+		} else {
+			_, err = client.Post(path, nil, nil)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listViews(cmd *cobra.Command, args []string) error {
+	printOut, err := outputPrinter(cmd)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	views := []view{}
+	if _, err := client.Get("api/v1/views", nil, &views); err != nil {
+		return err
+	}
+
+	return printOut(cmd.OutOrStdout(), views)
+}
+
+func saveView(cmd *cobra.Command, args []string) error {
+	selectors, _ := cmd.Flags().GetStringArray("selector")
+	starred, _ := cmd.Flags().GetBool("starred")
+	columns, _ := cmd.Flags().GetStringArray("column")
+	sortBy, _ := cmd.Flags().GetString("sort")
+
+	queries, err := parseSelectors(selectors)
+	if err != nil {
+		return err
+	}
+
+	// Check the columns before saving them
+	if err := printTable(io.Discard, zebra.NewResourceMap(nil), columns, sortBy); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	v := &view{Name: args[0], Types: args[1:], Labels: queries, Starred: starred, Columns: columns, Sort: sortBy}
+
+	_, err = client.Post("api/v1/views/"+url.PathEscape(args[0]), v, nil)
+
+	return err
+}
+
+func deleteView(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Delete("api/v1/views/"+url.PathEscape(args[0]), nil, nil)
+
+	return err
+}
+
+// getView prints the resources of the view. Views with columns are printed
+// as a table, unless an output format is given.
+func getView(cmd *cobra.Command, cfg *Config, name string, printOut printer) error {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	path := "api/v1/views/" + url.PathEscape(name)
+	v := new(view)
+
+	if _, err := client.Get(path, nil, v); err != nil {
+		return err
+	}
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	if _, err := client.Get(path+"/resources", nil, resMap); err != nil {
+		return err
+	}
+
+	if len(v.Columns) == 0 || cmd.Flags().Changed("output") {
+		return printOut(cmd.OutOrStdout(), resMap)
+	}
+
+	return printTable(cmd.OutOrStdout(), resMap, v.Columns, v.Sort)
+}
+
+// printTable prints a row of the columns for each resource, sorted by the
+// sort column.
+func printTable(w io.Writer, resMap *zebra.ResourceMap, columns []string, sortBy string) error {
+	paths := make([]*JSONPath, 0, len(columns))
+	header := make([]string, 0, len(columns))
+
+	for _, c := range columns {
+		jp, err := ParseJSONPath("{" + c + "}")
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, jp)
+		header = append(header, strings.ToUpper(strings.TrimPrefix(c, ".")))
+	}
+
+	rows := [][]string{}
+	keys := []string{}
+
+	var sortPath *JSONPath
+
+	if sortBy != "" {
+		jp, err := ParseJSONPath("{" + strings.TrimPrefix(sortBy, "-") + "}")
+		if err != nil {
+			return err
+		}
+
+		sortPath = jp
+	}
+
+	types := make([]string, 0, len(resMap.Resources))
+	for t := range resMap.Resources {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+
+	for _, t := range types {
+		for _, r := range resMap.Resources[t].Resources {
+			data, err := genericValue(r)
+			if err != nil {
+				return err
+			}
+
+			row := make([]string, 0, len(paths))
+
+			for _, jp := range paths {
+				row = append(row, executeString(jp, data))
+			}
+
+			rows = append(rows, row)
+			keys = append(keys, executeString(sortPath, data))
+		}
+	}
+
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if strings.HasPrefix(sortBy, "-") {
+			return keys[order[i]] > keys[order[j]]
+		}
+
+		return keys[order[i]] < keys[order[j]]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, i := range order {
+		fmt.Fprintln(tw, strings.Join(rows[i], "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// executeString returns the output of the JSONPath for the data, empty for
+// no JSONPath.
+func executeString(jp *JSONPath, data interface{}) string {
+	if jp == nil {
+		return ""
+	}
+
+	out := new(strings.Builder)
+	_ = jp.Execute(out, data)
+
+	return out.String()
+}
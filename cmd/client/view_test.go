@@ -0,0 +1,96 @@
+package main //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestView(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfgFile := "test_view.yaml"
+	t.Cleanup(func() { os.Remove(cfgFile) })
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g"})
+	stars := map[string]bool{}
+	saved := new(view)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v1/stars/" + lab.ID:
+			stars[lab.ID] = true
+		case "DELETE /api/v1/stars/" + lab.ID:
+			delete(stars, lab.ID)
+		case "POST /api/v1/views/racks":
+			assert.Nil(json.NewDecoder(r.Body).Decode(saved))
+		case "GET /api/v1/views":
+			assert.Nil(json.NewEncoder(w).Encode([]view{*saved}))
+		case "GET /api/v1/views/mine":
+			assert.Nil(json.NewEncoder(w).Encode(view{Name: "mine", Starred: true}))
+		case "GET /api/v1/views/racks":
+			assert.Nil(json.NewEncoder(w).Encode(saved))
+		case "GET /api/v1/views/mine/resources", "GET /api/v1/views/racks/resources":
+			resMap := zebra.NewResourceMap(store.DefaultFactory())
+			resMap.Add(rack, rack.GetType())
+			resMap.Add(lab, lab.GetType())
+			assert.Nil(json.NewEncoder(w).Encode(resMap))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	saveTestConfig(assert, server.URL, cfgFile)
+
+	_, err := runCmd("star", "-c", cfgFile, lab.ID)
+	assert.Nil(err)
+	assert.True(stars[lab.ID])
+
+	_, err = runCmd("star", "-c", cfgFile, "--remove", lab.ID)
+	assert.Nil(err)
+	assert.False(stars[lab.ID])
+
+	_, err = runCmd("star", "-c", cfgFile, "missing")
+	assert.NotNil(err)
+
+	_, err = runCmd("view", "save", "-c", cfgFile, "racks", "--column", ".bad[", "Rack")
+	assert.NotNil(err)
+
+	_, err = runCmd("view", "save", "-c", cfgFile, "racks", "Rack", "Lab", "-l", "system.group=g",
+		"--column", ".name", "--column", ".type", "--sort", "-.name")
+	assert.Nil(err)
+	assert.Equal([]string{"Rack", "Lab"}, saved.Types)
+	assert.Equal("-.name", saved.Sort)
+
+	out, err := runCmd("view", "-c", cfgFile, "-o", "jsonpath={[0].name}")
+	assert.Nil(err)
+	assert.Equal("racks", out)
+
+	// Views with columns are tables, sorted by the sort column
+	out, err = runCmd("get", "-c", cfgFile, "--view", "racks")
+	assert.Nil(err)
+	assert.Equal("NAME   TYPE\nrack1  Rack\nlab1   Lab\n", out)
+
+	out, err = runCmd("get", "-c", cfgFile, "--view", "mine", "-o", "jsonpath={.Lab[0].name}")
+	assert.Nil(err)
+	assert.Equal("lab1", out)
+
+	_, err = runCmd("get", "-c", cfgFile, "--view", "mine", "Rack")
+	assert.Equal(ErrGetView, err)
+
+	_, err = runCmd("get", "-c", cfgFile, "--view", "other")
+	assert.NotNil(err)
+
+	_, err = runCmd("view", "delete", "-c", cfgFile, "other")
+	assert.NotNil(err)
+}
@@ -26,6 +26,7 @@ func ServerType() zebra.Type {
 		Name:        "Server",
 		Description: "compute server",
 		Constructor: func() zebra.Resource { return new(Server) },
+		Migrations:  nil,
 	}
 }
 
@@ -37,6 +38,13 @@ type Server struct {
 	SerialNumber string            `json:"serialNumber"`
 	BoardIP      net.IP            `json:"boardIP"` //nolint:tagliatelle
 	Model        string            `json:"model"`
+	Asset        *zebra.Asset      `json:"asset,omitempty"`
+	RackUnits    uint32            `json:"rackUnits,omitempty"`
+}
+
+// GetAsset returns the asset data of the server, nil if it has none.
+func (s *Server) GetAsset() *zebra.Asset {
+	return s.Asset
 }
 
 func (s *Server) Validate(ctx context.Context) error {
@@ -53,6 +61,12 @@ func (s *Server) Validate(ctx context.Context) error {
 		return zebra.ErrWrongType
 	}
 
+	if s.Asset != nil {
+		if err := s.Asset.Validate(ctx); err != nil {
+			return err
+		}
+	}
+
 	if err := s.Credentials.Validate(ctx); err != nil {
 		return err
 	}
@@ -65,6 +79,7 @@ func ESXType() zebra.Type {
 		Name:        "ESX",
 		Description: "VMWare ESX server",
 		Constructor: func() zebra.Resource { return new(ESX) },
+		Migrations:  nil,
 	}
 }
 
@@ -101,6 +116,7 @@ func VCenterType() zebra.Type {
 		Name:        "VCenter",
 		Description: "VMWare vcenter",
 		Constructor: func() zebra.Resource { return new(VCenter) },
+		Migrations:  nil,
 	}
 }
 
@@ -132,6 +148,7 @@ func VMType() zebra.Type {
 		Name:        "VM",
 		Description: "virtual machine",
 		Constructor: func() zebra.Resource { return new(VM) },
+		Migrations:  nil,
 	}
 }
 
@@ -210,6 +227,7 @@ func NewServer(arr []string, ip net.IP, labels zebra.Labels) *Server {
 		SerialNumber:  arr[0],
 		BoardIP:       ip,
 		Model:         arr[1],
+		Asset:         nil,
 	}
 
 	return ret
@@ -7,6 +7,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/cmd/herd/pkg"
 	"github.com/project-safari/zebra/compute"
 	"github.com/stretchr/testify/assert"
@@ -59,6 +60,19 @@ func TestServer(t *testing.T) {
 	assert.NotNil(server.Validate(ctx))
 }
 
+func TestServerAsset(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.0"), pkg.CreateLabels())
+	assert.Nil(server.GetAsset())
+
+	server.Asset = &zebra.Asset{PurchaseDate: "2022-01-01", CostCenter: "", WarrantyEnd: "2021-01-01", Vendor: ""}
+	assert.Equal(server.Asset, server.GetAsset())
+	assert.Equal(zebra.ErrWarrantyDate, server.Validate(ctx))
+}
+
 func TestESX(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
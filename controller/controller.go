@@ -0,0 +1,189 @@
+// Package controller runs reconcilers of zebra resources, such as one that
+// makes sure every Server labeled dhcp=true has a DHCP reservation, as
+// binaries of their own. A controller follows the resources it reconciles
+// with an informer and queues the IDs of the resources that changed, its
+// workers reconcile them and retry failures with a backoff. Replicas of a
+// controller elect a leader through the store, only the leader reconciles.
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/informer"
+)
+
+// Defaults of a controller.
+const (
+	DefaultWorkers    = 1
+	DefaultMaxRetries = 10
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// Reconciler brings the world in line with a resource. Reconcilers are
+// called again for resources that did not change, so they must be safe to
+// run more than once.
+type Reconciler interface {
+	// Reconcile reconciles the resource with the ID. The resource is nil if
+	// it was deleted or no longer passes the filter of the controller.
+	Reconcile(ctx context.Context, id string, res zebra.Resource) error
+}
+
+// ReconcilerFunc is a Reconciler of a function.
+type ReconcilerFunc func(ctx context.Context, id string, res zebra.Resource) error
+
+func (f ReconcilerFunc) Reconcile(ctx context.Context, id string, res zebra.Resource) error {
+	return f(ctx, id, res)
+}
+
+// Controller reconciles the resources of an informer that pass the filter,
+// all of them if there is none. Failed reconciles are retried up to
+// MaxRetries times, then they are passed to OnError, if it is set, and
+// given up until the resource changes or is resynced.
+type Controller struct {
+	Name       string
+	Informer   *informer.Informer
+	Reconciler Reconciler
+	Filter     func(res zebra.Resource) bool
+	Workers    int
+	MaxRetries int
+	Queue      *Queue
+	Elector    *Elector
+	OnError    func(id string, err error)
+}
+
+// NewController returns a controller of the resources of the informer.
+func NewController(name string, inf *informer.Informer, reconciler Reconciler) *Controller {
+	c := &Controller{
+		Name:       name,
+		Informer:   inf,
+		Reconciler: reconciler,
+		Filter:     nil,
+		Workers:    DefaultWorkers,
+		MaxRetries: DefaultMaxRetries,
+		Queue:      NewQueue(DefaultBackoff, DefaultMaxBackoff),
+		Elector:    nil,
+		OnError:    nil,
+	}
+
+	inf.AddHandler(informer.HandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old zebra.Resource, res zebra.Resource) {
+			// Resources that leave the filter are reconciled once more
+			if c.passes(old) && !c.passes(res) {
+				c.Queue.Add(res.GetID())
+			} else {
+				c.enqueue(res)
+			}
+		},
+		DeleteFunc: c.enqueue,
+	})
+
+	return c
+}
+
+// Run runs the informer of the controller and reconciles the queued
+// resources until the context ends. With an elector, the resources are only
+// reconciled while the controller leads.
+func (c *Controller) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	informed := make(chan error, 1)
+
+	go func() { informed <- c.Informer.Run(ctx) }()
+
+	if !c.Informer.WaitForSync(ctx) {
+		return <-informed
+	}
+
+	if c.Elector == nil {
+		c.work(ctx)
+	} else {
+		_ = c.Elector.Run(ctx, c.work)
+	}
+
+	cancel()
+
+	return <-informed
+}
+
+// work runs the workers until the context ends. All cached resources are
+// queued first, as changes may have been missed while another replica led.
+func (c *Controller) work(ctx context.Context) {
+	for _, res := range c.Informer.List() {
+		c.enqueue(res)
+	}
+
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				if !c.process(ctx) {
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// process reconciles the next queued resource, it returns false once there
+// are no more.
+func (c *Controller) process(ctx context.Context) bool {
+	id, ok := c.Queue.Get(ctx)
+	if !ok {
+		return false
+	}
+
+	defer c.Queue.Done(id)
+
+	res, ok := c.Informer.Get(id)
+	if !ok || !c.passes(res) {
+		res = nil
+	}
+
+	err := c.Reconciler.Reconcile(ctx, id, res)
+	if err == nil || ctx.Err() != nil {
+		c.Queue.Forget(id)
+
+		return true
+	}
+
+	if c.Queue.Failures(id) < c.MaxRetries {
+		c.Queue.AddRateLimited(id)
+
+		return true
+	}
+
+	c.Queue.Forget(id)
+
+	if c.OnError != nil {
+		c.OnError(id, err)
+	}
+
+	return true
+}
+
+func (c *Controller) enqueue(res zebra.Resource) {
+	if c.passes(res) {
+		c.Queue.Add(res.GetID())
+	}
+}
+
+func (c *Controller) passes(res zebra.Resource) bool {
+	return c.Filter == nil || c.Filter(res)
+}
@@ -0,0 +1,93 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/controller"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/informer"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var errReconcile = errors.New("reconcile failed")
+
+// staticSource lists its resources and has no events.
+type staticSource struct {
+	resources []zebra.Resource
+}
+
+func (s staticSource) List(ctx context.Context, types []string) (*zebra.ResourceMap, string, error) {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	for _, res := range s.resources {
+		resMap.Add(res, res.GetType())
+	}
+
+	return resMap, "0", nil
+}
+
+func (s staticSource) Watch(ctx context.Context, cursor string, wait time.Duration,
+) ([]events.Event, string, error) {
+	<-ctx.Done()
+
+	return nil, cursor, ctx.Err()
+}
+
+func TestController(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	dhcp := dc.NewLab("a", zebra.Labels{"dhcp": "true"})
+	failing := dc.NewLab("b", zebra.Labels{"dhcp": "true"})
+	other := dc.NewLab("c", nil)
+
+	source := staticSource{resources: []zebra.Resource{dhcp, failing, other}}
+	inf := informer.NewInformer(source, store.DefaultFactory(), []string{"Lab"}, 0)
+
+	lock := sync.Mutex{}
+	reconciled := map[string]int{}
+	failed := make(chan string, 1)
+
+	c := controller.NewController("dhcp", inf, controller.ReconcilerFunc(
+		func(ctx context.Context, id string, res zebra.Resource) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			reconciled[id]++
+
+			if id == failing.ID {
+				return errReconcile
+			}
+
+			return nil
+		}))
+	c.Filter = func(res zebra.Resource) bool { return res.GetLabels()["dhcp"] == "true" }
+	c.Workers = 2
+	c.MaxRetries = 2
+	c.Queue.Backoff = time.Millisecond
+	c.OnError = func(id string, err error) { failed <- id }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() { done <- c.Run(ctx) }()
+
+	assert.Equal(failing.ID, <-failed)
+
+	cancel()
+	assert.ErrorIs(<-done, context.Canceled)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(1, reconciled[dhcp.ID])
+	assert.Equal(3, reconciled[failing.ID])
+	assert.Equal(0, reconciled[other.ID])
+}
@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/informer"
+)
+
+// Defaults of leader election.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultLeaderGroup   = "controllers"
+)
+
+var (
+	ErrLeaderHolder   = errors.New("leader must have a holder")
+	ErrLeaderDuration = errors.New("leader duration must not be negative")
+)
+
+func LeaderType() zebra.Type {
+	return zebra.Type{
+		Name:        "Leader",
+		Description: "leader of a replicated controller",
+		Constructor: func() zebra.Resource { return new(Leader) },
+		Migrations:  nil,
+	}
+}
+
+// Leader records which replica of a controller leads, until the lease of
+// the leader runs out Duration after it was last renewed. The name of a
+// leader is the name of the controller. Token is the fencing token of the
+// lease, it grows every time the lease changes hands so that writes of a
+// replica that lost the lead can be told apart.
+type Leader struct {
+	zebra.NamedResource
+	Holder   string        `json:"holder"`
+	Renewed  time.Time     `json:"renewed"`
+	Duration time.Duration `json:"duration"`
+	Token    uint64        `json:"token,omitempty"`
+}
+
+// LeaderID returns the ID of the leader of the controller with the name, the
+// same for all replicas.
+func LeaderID(name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("zebra:leader:"+name)).String()
+}
+
+// NewLeader returns the leader of the controller with the name, renewed now,
+// in the default group.
+func NewLeader(name string, holder string, duration time.Duration) *Leader {
+	namedR := new(zebra.NamedResource)
+
+	namedR.BaseResource = *zebra.NewBaseResource("Leader", zebra.Labels{"system.group": DefaultLeaderGroup})
+	namedR.ID = LeaderID(name)
+	namedR.Name = name
+
+	return &Leader{
+		NamedResource: *namedR,
+		Holder:        holder,
+		Renewed:       time.Now(),
+		Duration:      duration,
+		Token:         0,
+	}
+}
+
+func (l *Leader) Validate(ctx context.Context) error {
+	switch {
+	case l.Holder == "":
+		return ErrLeaderHolder
+	case l.Duration < 0:
+		return ErrLeaderDuration
+	}
+
+	return l.NamedResource.Validate(ctx)
+}
+
+// Expired returns true if the lease of the leader has run out.
+func (l *Leader) Expired(now time.Time) bool {
+	return !now.Before(l.Renewed.Add(l.Duration))
+}
+
+// NextToken returns the fencing token of a lease of the holder that
+// follows the leader: the same token while the holder keeps the lease, the
+// next one once it changes hands or ran out.
+func (l *Leader) NextToken(holder string, now time.Time) uint64 {
+	if l == nil {
+		return 1
+	}
+
+	if l.Holder == holder && !l.Expired(now) {
+		return l.Token
+	}
+
+	return l.Token + 1
+}
+
+// Lock keeps the leaders of controllers.
+type Lock interface {
+	// Get returns the leader with the ID, nil if there is none.
+	Get(ctx context.Context, id string) (*Leader, error)
+	// Put writes the leader.
+	Put(ctx context.Context, leader *Leader) error
+}
+
+// StoreLock keeps the leaders in a store, for controllers that run along
+// with the store.
+type StoreLock struct {
+	Store zebra.Store
+}
+
+func (s StoreLock) Get(ctx context.Context, id string) (*Leader, error) {
+	return findLeader(s.Store.QueryUUID([]string{id})), nil
+}
+
+func (s StoreLock) Put(ctx context.Context, leader *Leader) error {
+	return s.Store.Create(leader)
+}
+
+// HTTPLock keeps the leaders in the store of a zebra server.
+type HTTPLock struct {
+	Source *informer.HTTPSource
+}
+
+func (h HTTPLock) Get(ctx context.Context, id string) (*Leader, error) {
+	query, err := json.Marshal(map[string][]string{"ids": {id}})
+	if err != nil {
+		return nil, err
+	}
+
+	resMap := zebra.NewResourceMap(zebra.Factory().Add(LeaderType()))
+	if err := h.Source.Do(ctx, http.MethodGet, informer.ResourcesPath, query, resMap); err != nil {
+		return nil, err
+	}
+
+	return findLeader(resMap), nil
+}
+
+func (h HTTPLock) Put(ctx context.Context, leader *Leader) error {
+	resMap := zebra.NewResourceMap(zebra.Factory().Add(LeaderType()))
+	resMap.Add(leader, leader.Type)
+
+	body, err := json.Marshal(resMap)
+	if err != nil {
+		return err
+	}
+
+	return h.Source.Do(ctx, http.MethodPost, informer.ResourcesPath, body, nil)
+}
+
+func findLeader(resMap *zebra.ResourceMap) *Leader {
+	if l, ok := resMap.Resources["Leader"]; ok {
+		for _, res := range l.Resources {
+			if leader, ok := res.(*Leader); ok {
+				return leader
+			}
+		}
+	}
+
+	return nil
+}
+
+// Elector elects one of the replicas of a controller as the leader. A
+// replica becomes the leader once the lease of the last one has run out,
+// and renews its lease a third of the lease duration after it was last
+// renewed. Locks have no compare and swap, so a replica reads the leader
+// back after it wrote itself as the leader; two replicas that write at the
+// same time may both lead until the next renewal, reconcilers must be safe
+// to run twice. The leader is kept in the group, which the service account
+// of the controller must be allowed to write to.
+type Elector struct {
+	Name     string
+	Holder   string
+	Group    string
+	Lock     Lock
+	Duration time.Duration
+
+	lock    sync.RWMutex
+	leading bool
+}
+
+// NewElector returns the elector of the replica named holder of the
+// controller with the name.
+func NewElector(name string, holder string, lock Lock) *Elector {
+	return &Elector{
+		Name:     name,
+		Holder:   holder,
+		Group:    DefaultLeaderGroup,
+		Lock:     lock,
+		Duration: DefaultLeaseDuration,
+		lock:     sync.RWMutex{},
+		leading:  false,
+	}
+}
+
+// IsLeader returns true while the replica leads.
+func (e *Elector) IsLeader() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.leading
+}
+
+// Run runs lead while the replica leads, with a context that ends once the
+// replica no longer does, until the context ends. The lease is given up
+// when Run returns, so that another replica can take over at once.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	var (
+		cancel  context.CancelFunc
+		stopped chan struct{}
+		renewed time.Time
+	)
+
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			<-stopped
+
+			cancel = nil
+		}
+
+		e.setLeading(false)
+	}
+
+	ticker := time.NewTicker(e.Duration / 3) //nolint:gomnd
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		acquired, err := e.acquire(ctx, now)
+
+		switch {
+		case acquired:
+			renewed = now
+		case err == nil || now.Sub(renewed) >= e.Duration:
+			// Errors keep the lead until the lease runs out
+			stop()
+		}
+
+		if acquired && cancel == nil {
+			var leadCtx context.Context
+
+			leadCtx, cancel = context.WithCancel(ctx)
+			stopped = make(chan struct{})
+
+			e.setLeading(true)
+
+			go func() {
+				defer close(stopped)
+				lead(leadCtx)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			leading := cancel != nil
+
+			stop()
+
+			if leading {
+				e.release()
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire writes the replica as the leader unless another replica leads,
+// and returns true if the replica leads.
+func (e *Elector) acquire(ctx context.Context, now time.Time) (bool, error) {
+	id := LeaderID(e.Name)
+
+	current, err := e.Lock.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if current != nil && current.Holder != e.Holder && !current.Expired(now) {
+		return false, nil
+	}
+
+	leader := NewLeader(e.Name, e.Holder, e.Duration)
+	leader.Renewed = now
+	leader.Token = current.NextToken(e.Holder, now)
+	leader.Labels.Add("system.group", e.Group)
+
+	if err := e.Lock.Put(ctx, leader); err != nil {
+		return false, err
+	}
+
+	// The last write wins
+	current, err = e.Lock.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return current != nil && current.Holder == e.Holder, nil
+}
+
+// release ends the lease of the replica.
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Duration)
+	defer cancel()
+
+	current, err := e.Lock.Get(ctx, LeaderID(e.Name))
+	if err != nil || current == nil || current.Holder != e.Holder {
+		return
+	}
+
+	// Leaders read from a lock may be shared, the lease ends with a new one
+	leader := NewLeader(e.Name, e.Holder, 0)
+	leader.Token = current.Token
+	leader.Labels.Add("system.group", e.Group)
+
+	_ = e.Lock.Put(ctx, leader)
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.leading = leading
+}
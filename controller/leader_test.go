@@ -0,0 +1,150 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/controller"
+	"github.com/project-safari/zebra/informer"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeader(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	leader := controller.NewLeader("dhcp", "", time.Second)
+	assert.ErrorIs(leader.Validate(context.Background()), controller.ErrLeaderHolder)
+
+	leader.Holder = "a"
+	leader.Duration = -time.Second
+	assert.ErrorIs(leader.Validate(context.Background()), controller.ErrLeaderDuration)
+
+	leader.Duration = time.Second
+	assert.Nil(leader.Validate(context.Background()))
+	assert.Equal(controller.LeaderID("dhcp"), leader.ID)
+	assert.NotEqual(controller.LeaderID("dns"), leader.ID)
+	assert.False(leader.Expired(leader.Renewed))
+	assert.True(leader.Expired(leader.Renewed.Add(time.Second)))
+
+	// Tokens only grow once the lease changes hands or runs out
+	var none *controller.Leader
+
+	leader.Token = 3
+	assert.Equal(uint64(1), none.NextToken("a", leader.Renewed))
+	assert.Equal(uint64(3), leader.NextToken("a", leader.Renewed))
+	assert.Equal(uint64(4), leader.NextToken("b", leader.Renewed))
+	assert.Equal(uint64(4), leader.NextToken("a", leader.Renewed.Add(time.Second)))
+}
+
+func TestElector(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "testelector"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	rs := store.NewResourceStore(root, store.DefaultFactory())
+	assert.Nil(rs.Initialize())
+
+	lock := controller.StoreLock{Store: rs}
+	a := controller.NewElector("dhcp", "a", lock)
+	b := controller.NewElector("dhcp", "b", lock)
+
+	for _, e := range []*controller.Elector{a, b} {
+		e.Duration = 30 * time.Millisecond //nolint:gomnd
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	leadA := make(chan struct{})
+	doneA := make(chan error)
+
+	go func() {
+		doneA <- a.Run(ctxA, func(ctx context.Context) {
+			close(leadA)
+			<-ctx.Done()
+		})
+	}()
+
+	<-leadA
+	assert.True(a.IsLeader())
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	leadB := make(chan struct{})
+	doneB := make(chan error)
+
+	go func() {
+		doneB <- b.Run(ctxB, func(ctx context.Context) {
+			close(leadB)
+			<-ctx.Done()
+		})
+	}()
+
+	// b waits while a renews its lease
+	time.Sleep(50 * time.Millisecond) //nolint:gomnd
+	assert.False(b.IsLeader())
+
+	held, err := lock.Get(context.Background(), controller.LeaderID("dhcp"))
+	assert.Nil(err)
+	assert.Equal("a", held.Holder)
+	assert.Equal(uint64(1), held.Token)
+
+	// a gives up the lease as it stops, b takes over
+	cancelA()
+	assert.ErrorIs(<-doneA, context.Canceled)
+	assert.False(a.IsLeader())
+
+	select {
+	case <-leadB:
+	case <-time.After(time.Second):
+		assert.Fail("b did not take over")
+	}
+
+	assert.True(b.IsLeader())
+
+	cancelB()
+	assert.ErrorIs(<-doneB, context.Canceled)
+}
+
+func TestHTTPLock(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	stored := zebra.NewResourceMap(store.DefaultFactory())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(informer.ResourcesPath, req.URL.Path)
+
+		if req.Method == http.MethodPost {
+			assert.Nil(json.NewDecoder(req.Body).Decode(stored))
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(stored)
+	}))
+	defer server.Close()
+
+	lock := controller.HTTPLock{Source: informer.NewHTTPSource(server.URL, "", store.DefaultFactory())}
+
+	held, err := lock.Get(context.Background(), controller.LeaderID("dhcp"))
+	assert.Nil(err)
+	assert.Nil(held)
+
+	assert.Nil(lock.Put(context.Background(), controller.NewLeader("dhcp", "a", time.Second)))
+
+	held, err = lock.Get(context.Background(), controller.LeaderID("dhcp"))
+	assert.Nil(err)
+	assert.Equal("a", held.Holder)
+	assert.Equal(time.Second, held.Duration)
+}
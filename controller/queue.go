@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra/outbox"
+)
+
+// Queue is a work queue of resource IDs. An ID is queued at most once, and
+// an ID that is queued again while it is being worked on is only handed out
+// again once the work is done, so that no two workers work on the same ID.
+// IDs that failed are queued again after a backoff that doubles with every
+// failure until the ID is forgotten.
+type Queue struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	ids        []string
+	queued     map[string]bool
+	processing map[string]bool
+	failures   map[string]int
+	shutdown   bool
+}
+
+// NewQueue returns an empty queue with the backoff of failed IDs.
+func NewQueue(backoff time.Duration, maxBackoff time.Duration) *Queue {
+	q := &Queue{
+		Backoff:    backoff,
+		MaxBackoff: maxBackoff,
+		lock:       sync.Mutex{},
+		cond:       nil,
+		ids:        []string{},
+		queued:     map[string]bool{},
+		processing: map[string]bool{},
+		failures:   map[string]int{},
+		shutdown:   false,
+	}
+
+	q.cond = sync.NewCond(&q.lock)
+
+	return q
+}
+
+// Add queues the ID, unless it is queued already.
+func (q *Queue) Add(id string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.shutdown || q.queued[id] {
+		return
+	}
+
+	q.queued[id] = true
+
+	// The ID is handed out once it is done
+	if q.processing[id] {
+		return
+	}
+
+	q.ids = append(q.ids, id)
+	q.cond.Signal()
+}
+
+// AddAfter queues the ID once the delay is over.
+func (q *Queue) AddAfter(id string, delay time.Duration) {
+	if delay <= 0 {
+		q.Add(id)
+
+		return
+	}
+
+	time.AfterFunc(delay, func() { q.Add(id) })
+}
+
+// AddRateLimited counts a failure of the ID and queues it after the backoff
+// of its failures.
+func (q *Queue) AddRateLimited(id string) {
+	q.lock.Lock()
+	q.failures[id]++
+	failures := q.failures[id]
+	q.lock.Unlock()
+
+	q.AddAfter(id, outbox.Backoff(q.Backoff, q.MaxBackoff, failures))
+}
+
+// Forget resets the failures of the ID.
+func (q *Queue) Forget(id string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.failures, id)
+}
+
+// Failures returns the number of failures of the ID since it was last
+// forgotten.
+func (q *Queue) Failures(id string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.failures[id]
+}
+
+// Len returns the number of IDs waiting to be handed out.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.ids)
+}
+
+// Get waits for the next ID, which must be marked done once the work on it
+// is over. Get returns false if the queue is shut down or the context ends.
+func (q *Queue) Get(ctx context.Context) (string, bool) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Waiters check the context with the lock held, so the
+			// broadcast cannot slip in between the check and the wait
+			q.lock.Lock()
+			q.cond.Broadcast()
+			q.lock.Unlock()
+		case <-done:
+		}
+	}()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.ids) == 0 && !q.shutdown && ctx.Err() == nil {
+		q.cond.Wait()
+	}
+
+	if len(q.ids) == 0 || q.shutdown || ctx.Err() != nil {
+		return "", false
+	}
+
+	id := q.ids[0]
+	q.ids = q.ids[1:]
+
+	delete(q.queued, id)
+	q.processing[id] = true
+
+	return id, true
+}
+
+// Done marks the work on the ID done, it is handed out again if it was
+// queued in the meantime.
+func (q *Queue) Done(id string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.processing, id)
+
+	if q.queued[id] && !q.shutdown {
+		q.ids = append(q.ids, id)
+		q.cond.Signal()
+	}
+}
+
+// ShutDown stops the queue, waiting workers get no more IDs.
+func (q *Queue) ShutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.shutdown = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,64 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/controller"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	q := controller.NewQueue(time.Millisecond, 4*time.Millisecond) //nolint:gomnd
+
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+	assert.Equal(2, q.Len())
+
+	id, ok := q.Get(ctx)
+	assert.True(ok)
+	assert.Equal("a", id)
+
+	// IDs being worked on are handed out again once they are done
+	q.Add("a")
+
+	id, _ = q.Get(ctx)
+	assert.Equal("b", id)
+	assert.Equal(0, q.Len())
+
+	q.Done("a")
+	assert.Equal(1, q.Len())
+
+	id, _ = q.Get(ctx)
+	assert.Equal("a", id)
+
+	q.AddRateLimited("a")
+	q.AddRateLimited("a")
+	assert.Equal(2, q.Failures("a"))
+
+	q.Done("a")
+
+	id, _ = q.Get(ctx)
+	assert.Equal("a", id)
+
+	q.Forget("a")
+	assert.Equal(0, q.Failures("a"))
+
+	// Get gives up once the context ends or the queue is shut down
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+
+	_, ok = q.Get(timeout)
+	assert.False(ok)
+
+	go q.ShutDown()
+
+	_, ok = q.Get(ctx)
+	assert.False(ok)
+}
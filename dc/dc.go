@@ -17,6 +17,7 @@ func DataCenterType() zebra.Type {
 		Name:        "Datacenter",
 		Description: "data center",
 		Constructor: func() zebra.Resource { return new(Datacenter) },
+		Migrations:  nil,
 	}
 }
 
@@ -46,6 +47,7 @@ func LabType() zebra.Type {
 		Name:        "Lab",
 		Description: "data center lab",
 		Constructor: func() zebra.Resource { return new(Lab) },
+		Migrations:  nil,
 	}
 }
 
@@ -67,14 +69,16 @@ func RackType() zebra.Type {
 		Name:        "Rack",
 		Description: "server rack",
 		Constructor: func() zebra.Resource { return new(Rack) },
+		Migrations:  nil,
 	}
 }
 
 // A Rack represents a datacenter rack. It consists of a name, ID, and associated
-// row.
+// row, and its height in rack units, if known.
 type Rack struct {
 	zebra.NamedResource
-	Row string `json:"row"`
+	Row   string `json:"row"`
+	Units uint32 `json:"units,omitempty"`
 }
 
 // Validate returns an error if the given Rack object has incorrect values.
@@ -0,0 +1,234 @@
+package zebra
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Default limits of the resource decoder.
+const (
+	DefaultMaxResourceSize = 1 << 20
+	DefaultMaxDepth        = 32
+)
+
+var (
+	ErrResourceSize  = errors.New("resource exceeds the maximum size")
+	ErrDecodeDepth   = errors.New("resource exceeds the maximum nesting depth")
+	ErrUnknownType   = errors.New("resource type is not known")
+	ErrUnknownField  = errors.New("resource has an unknown field")
+	ErrTypeMismatch  = errors.New("resource type does not match")
+	ErrMalformed     = errors.New("resource is malformed")
+	ErrSchemaVersion = errors.New("resource schema version is not known")
+	ErrMigration     = errors.New("resource schema migration failed")
+)
+
+// DecodeError is returned when a resource can not be decoded. It wraps one
+// of the decode errors above, so callers can use errors.Is.
+type DecodeError struct {
+	Type   string
+	Err    error
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	msg := e.Err.Error()
+
+	if e.Type != "" {
+		msg = e.Type + ": " + msg
+	}
+
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+
+	return msg
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder turns untrusted JSON into resources. The JSON is checked against
+// size and nesting limits before it is decoded, and it is decoded straight
+// into the resource of the type it names, rejecting fields that are not in
+// the schema of that type unless AllowUnknownFields is set. Resources of an
+// older schema version of their type are migrated to the current version
+// first. Stored resources without a schema version are of the first version
+// of their type, other resources without one are of the current version.
+type Decoder struct {
+	Factory            ResourceFactory
+	MaxSize            int
+	MaxDepth           int
+	AllowUnknownFields bool
+	Stored             bool
+}
+
+// NewDecoder returns a strict decoder with the default limits.
+func NewDecoder(factory ResourceFactory) *Decoder {
+	return &Decoder{
+		Factory:            factory,
+		MaxSize:            DefaultMaxResourceSize,
+		MaxDepth:           DefaultMaxDepth,
+		AllowUnknownFields: false,
+		Stored:             false,
+	}
+}
+
+// DecodeResource decodes a single resource with a strict decoder.
+func DecodeResource(factory ResourceFactory, data []byte) (Resource, error) {
+	return NewDecoder(factory).Decode(data)
+}
+
+// Decode decodes a single resource object.
+func (d *Decoder) Decode(data []byte) (Resource, error) {
+	if err := d.check(data); err != nil {
+		return nil, err
+	}
+
+	header := struct {
+		Type          *string `json:"type"`
+		SchemaVersion *int    `json:"schemaVersion"`
+	}{}
+
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, &DecodeError{Type: "", Err: ErrMalformed, Detail: err.Error()}
+	}
+
+	if header.Type == nil || *header.Type == "" {
+		return nil, &DecodeError{Type: "", Err: ErrTypeEmpty, Detail: ""}
+	}
+
+	resType := *header.Type
+
+	if d.Factory == nil {
+		return nil, &DecodeError{Type: resType, Err: ErrUnknownType, Detail: "no resource factory"}
+	}
+
+	aType, ok := d.Factory.Type(resType)
+	if !ok {
+		return nil, &DecodeError{Type: resType, Err: ErrUnknownType, Detail: ""}
+	}
+
+	version := aType.SchemaVersion()
+
+	switch {
+	case header.SchemaVersion != nil:
+		version = *header.SchemaVersion
+	case d.Stored:
+		version = 0
+	}
+
+	data, err := aType.Migrate(data, version)
+	if err != nil {
+		return nil, err
+	}
+
+	res := aType.New()
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !d.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(res); err != nil {
+		return nil, d.decodeError(resType, err)
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, &DecodeError{Type: resType, Err: ErrMalformed, Detail: "data after resource"}
+	}
+
+	// A constructor must make resources of its own type
+	if res.GetType() != resType {
+		return nil, &DecodeError{Type: resType, Err: ErrTypeMismatch, Detail: res.GetType()}
+	}
+
+	StampSchemaVersion(d.Factory, res)
+
+	return res, nil
+}
+
+// DecodeList decodes a JSON list of resources.
+func (d *Decoder) DecodeList(data []byte) ([]Resource, error) {
+	values := []json.RawMessage{}
+
+	if err := d.checkDepth(data, 1); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, &DecodeError{Type: "", Err: ErrMalformed, Detail: err.Error()}
+	}
+
+	resources := make([]Resource, 0, len(values))
+
+	for _, value := range values {
+		res, err := d.Decode(value)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, res)
+	}
+
+	return resources, nil
+}
+
+func (d *Decoder) check(data []byte) error {
+	if d.MaxSize > 0 && len(data) > d.MaxSize {
+		return &DecodeError{Type: "", Err: ErrResourceSize, Detail: fmt.Sprintf("%d bytes", len(data))}
+	}
+
+	return d.checkDepth(data, 0)
+}
+
+// checkDepth scans the data for objects and arrays nested deeper than the
+// limit, allowing for extra levels of enclosing lists and maps, without
+// decoding it.
+func (d *Decoder) checkDepth(data []byte, extra int) error {
+	if d.MaxDepth <= 0 {
+		return nil
+	}
+
+	depth, inString, escaped := 0, false, false
+
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+
+			if depth > d.MaxDepth+extra {
+				return &DecodeError{Type: "", Err: ErrDecodeDepth, Detail: fmt.Sprintf("limit %d", d.MaxDepth)}
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeError(resType string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &typeErr):
+		return &DecodeError{Type: resType, Err: ErrMalformed, Detail: typeErr.Error()}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		return &DecodeError{Type: resType, Err: ErrUnknownField, Detail: strings.TrimPrefix(err.Error(), "json: unknown field ")}
+	default:
+		return &DecodeError{Type: resType, Err: ErrMalformed, Detail: err.Error()}
+	}
+}
@@ -0,0 +1,155 @@
+package zebra_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeFactory() zebra.ResourceFactory {
+	return zebra.Factory().Add(dc.LabType()).Add(network.VLANPoolType())
+}
+
+func TestDecodeResource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"Valid", `{"id": "lab1", "type": "Lab", "name": "lab", "labels": {"system.group": "g"}}`, nil},
+		{"NoType", `{"id": "lab1", "name": "lab"}`, zebra.ErrTypeEmpty},
+		{"EmptyType", `{"id": "lab1", "type": ""}`, zebra.ErrTypeEmpty},
+		{"NullType", `{"id": "lab1", "type": null}`, zebra.ErrTypeEmpty},
+		{"NumberType", `{"id": "lab1", "type": 7}`, zebra.ErrMalformed},
+		{"UnknownType", `{"id": "lab1", "type": "Spaceship"}`, zebra.ErrUnknownType},
+		{"UnknownField", `{"id": "lab1", "type": "Lab", "color": "red"}`, zebra.ErrUnknownField},
+		{"WrongFieldType", `{"id": "lab1", "type": "VLANPool", "rangeStart": "low"}`, zebra.ErrMalformed},
+		{"Overflow", `{"id": "lab1", "type": "VLANPool", "rangeStart": 70000}`, zebra.ErrMalformed},
+		{"Trailing", `{"id": "lab1", "type": "Lab"} {}`, zebra.ErrMalformed},
+		{"Truncated", `{"id": "lab1", "type": "Lab"`, zebra.ErrMalformed},
+		{"NotObject", `["Lab"]`, zebra.ErrMalformed},
+		{"Deep", `{"type": "Lab", "labels": ` + strings.Repeat("[", 40) + strings.Repeat("]", 40) + `}`, zebra.ErrDecodeDepth},
+		{"DeepInString", `{"type": "Lab", "name": "` + strings.Repeat("[", 40) + `\"{"}`, nil},
+		{"Large", `{"type": "Lab", "name": "` + strings.Repeat("a", zebra.DefaultMaxResourceSize) + `"}`, zebra.ErrResourceSize},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+
+			res, err := zebra.DecodeResource(decodeFactory(), []byte(test.data))
+			if test.err == nil {
+				assert.Nil(err)
+				assert.NotNil(res)
+
+				return
+			}
+
+			assert.Nil(res)
+			assert.ErrorIs(err, test.err)
+
+			var decodeErr *zebra.DecodeError
+			assert.True(errors.As(err, &decodeErr))
+		})
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	data := []byte(`{"id": "lab1", "type": "Lab", "name": "lab", "color": "red"}`)
+
+	decoder := zebra.NewDecoder(decodeFactory())
+	decoder.AllowUnknownFields = true
+
+	res, err := decoder.Decode(data)
+	assert.Nil(err)
+	assert.Equal("lab", res.(*dc.Lab).Name)
+
+	decoder.MaxSize = 10
+	_, err = decoder.Decode(data)
+	assert.ErrorIs(err, zebra.ErrResourceSize)
+
+	_, err = zebra.DecodeResource(nil, data)
+	assert.ErrorIs(err, zebra.ErrUnknownType)
+	assert.Contains(err.Error(), "Lab: ")
+
+	resources, err := zebra.NewDecoder(decodeFactory()).DecodeList(
+		[]byte(`[{"id": "lab1", "type": "Lab"}, {"id": "pool1", "type": "VLANPool"}]`))
+	assert.Nil(err)
+	assert.Len(resources, 2)
+
+	_, err = zebra.NewDecoder(decodeFactory()).DecodeList([]byte(`{"type": "Lab"}`))
+	assert.ErrorIs(err, zebra.ErrMalformed)
+}
+
+func TestResourceMapTypeMismatch(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resMap := zebra.NewResourceMap(decodeFactory())
+	err := json.Unmarshal([]byte(`{"VLANPool": [{"id": "lab1", "type": "Lab"}]}`), resMap)
+	assert.ErrorIs(err, zebra.ErrTypeMismatch)
+
+	resMap = zebra.NewResourceMap(nil)
+	err = json.Unmarshal([]byte(`{"Lab": [{"id": "lab1", "type": "Lab"}]}`), resMap)
+	assert.ErrorIs(err, zebra.ErrUnknownType)
+}
+
+func FuzzDecodeResource(f *testing.F) {
+	f.Add([]byte(`{"id": "lab1", "type": "Lab", "name": "lab", "labels": {"system.group": "g"}}`))
+	f.Add([]byte(`{"id": "pool1", "type": "VLANPool", "rangeStart": 1, "rangeEnd": 10}`))
+	f.Add([]byte(`{"type": "Lab", "status": {"state": "active"}}`))
+	f.Add([]byte(`{"type": "Lab", "labels": [[[[]]]]}`))
+	f.Add([]byte(`{"type": "Lab", "type": "VLANPool"}`))
+	f.Add([]byte(`{"type": "Lab", "name": "\"}{"}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		res, err := zebra.DecodeResource(decodeFactory(), data)
+		if err != nil {
+			var decodeErr *zebra.DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("untyped error %v", err)
+			}
+
+			if res != nil {
+				t.Fatal("resource returned with error")
+			}
+
+			return
+		}
+
+		// A decoded resource encodes to JSON that decodes to the same resource
+		encoded, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := zebra.DecodeResource(decodeFactory(), encoded)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", encoded, err)
+		}
+
+		reencoded, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(encoded) != string(reencoded) {
+			t.Fatalf("%s != %s", encoded, reencoded)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package zebra
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Change describes a single field that differs between two versions of a
+// resource. Path is the dot separated JSON path of the field, a nil Old value
+// means the field was added and a nil New value means it was removed.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Diff returns the list of changed fields between the old and the new
+// resource, sorted by path. Either resource may be nil.
+func Diff(oldRes, newRes Resource) ([]Change, error) {
+	oldVals, err := flatten(oldRes)
+	if err != nil {
+		return nil, err
+	}
+
+	newVals, err := flatten(newRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return DiffValues(oldVals, newVals), nil
+}
+
+// DiffValues returns the list of changes between two flattened value maps,
+// sorted by path.
+func DiffValues(oldVals, newVals map[string]interface{}) []Change {
+	changes := []Change{}
+
+	for path, oldVal := range oldVals {
+		newVal, ok := newVals[path]
+		if !ok {
+			changes = append(changes, Change{Path: path, Old: oldVal, New: nil})
+		} else if !reflect.DeepEqual(oldVal, newVal) {
+			changes = append(changes, Change{Path: path, Old: oldVal, New: newVal})
+		}
+	}
+
+	for path, newVal := range newVals {
+		if _, ok := oldVals[path]; !ok {
+			changes = append(changes, Change{Path: path, Old: nil, New: newVal})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes
+}
+
+// Flatten returns the JSON representation of the value as a map of dot
+// separated paths to leaf values.
+func Flatten(value interface{}) (map[string]interface{}, error) {
+	return flatten(value)
+}
+
+func flatten(value interface{}) (map[string]interface{}, error) {
+	vals := make(map[string]interface{})
+
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return vals, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	flattenInto(vals, "", generic)
+
+	return vals, nil
+}
+
+func flattenInto(vals map[string]interface{}, prefix string, value interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && prefix != "" {
+			vals[prefix] = v
+		}
+
+		for key, val := range v {
+			flattenInto(vals, join(key), val)
+		}
+	case []interface{}:
+		if len(v) == 0 && prefix != "" {
+			vals[prefix] = v
+		}
+
+		for i, val := range v {
+			flattenInto(vals, join(strconv.Itoa(i)), val)
+		}
+	default:
+		vals[prefix] = v
+	}
+}
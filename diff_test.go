@@ -0,0 +1,67 @@
+package zebra_test
+
+import (
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	oldRes := zebra.NewBaseResource("BaseResource", zebra.Labels{"a": "1", "b": "2"})
+	newRes := *oldRes
+	newRes.Labels = zebra.Labels{"a": "1", "b": "3", "c": "4"}
+	newRes.Status = nil
+
+	changes, err := zebra.Diff(oldRes, &newRes)
+	assert.Nil(err)
+	assert.Equal("labels.b", changes[0].Path)
+	assert.Equal("2", changes[0].Old)
+	assert.Equal("3", changes[0].New)
+	assert.Equal("labels.c", changes[1].Path)
+	assert.Nil(changes[1].Old)
+
+	// All remaining changes are the removed status fields
+	for _, c := range changes[2:] {
+		assert.Contains(c.Path, "status.")
+		assert.Nil(c.New)
+	}
+
+	// No changes against itself
+	changes, err = zebra.Diff(oldRes, oldRes)
+	assert.Nil(err)
+	assert.Empty(changes)
+
+	// Everything is new against nil
+	var nilRes *zebra.BaseResource
+
+	changes, err = zebra.Diff(nilRes, oldRes)
+	assert.Nil(err)
+	assert.NotEmpty(changes)
+
+	changes, err = zebra.Diff(nil, nil)
+	assert.Nil(err)
+	assert.Empty(changes)
+}
+
+func TestFlatten(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	vals, err := zebra.Flatten(map[string]interface{}{
+		"a": []interface{}{"x", map[string]interface{}{"y": 1}},
+		"b": map[string]interface{}{},
+		"c": []interface{}{},
+	})
+	assert.Nil(err)
+	assert.Equal("x", vals["a.0"])
+	assert.Equal(float64(1), vals["a.1.y"])
+	assert.Contains(vals, "b")
+	assert.Contains(vals, "c")
+
+	_, err = zebra.Flatten(func() {})
+	assert.NotNil(err)
+}
@@ -0,0 +1,347 @@
+// Package events keeps the stream of changes to zebra resources so that
+// clients can catch up on the changes they missed.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+const (
+	RWRR = os.FileMode(0o644)
+
+	// DefaultRetention is the number of events kept.
+	DefaultRetention = 10000
+
+	// DefaultMaxAge is how long events are kept.
+	DefaultMaxAge = 7 * 24 * time.Hour
+
+	// maxLineSize limits the size of a single stored event.
+	maxLineSize = 1 << 20
+)
+
+// Event types. Archived resources are moved out of the store into the
+// archive, restored ones back into the store.
+const (
+	Created  = "created"
+	Updated  = "updated"
+	Deleted  = "deleted"
+	Archived = "archived"
+	Restored = "restored"
+)
+
+// Event types of the hosts registered by agents: stale or offline once their
+// agent stopped sending heartbeats, online again when it is back.
+const (
+	HostOnline  = "host.online"
+	HostStale   = "host.stale"
+	HostOffline = "host.offline"
+)
+
+var ErrCursorExpired = errors.New("events since the cursor are no longer retained")
+
+// Event is a change to a resource. Seq orders the events and serves as the
+// cursor of the event stream.
+type Event struct {
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Type     string          `json:"type"`
+	Resource string          `json:"resource"`
+	Kind     string          `json:"kind"`
+	Labels   zebra.Labels    `json:"labels,omitempty"`
+	Actor    string          `json:"actor,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEvent returns an event of the type for the resource, without a
+// sequence number.
+func NewEvent(eventType string, res zebra.Resource, actor string) (Event, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Seq:      0,
+		Time:     time.Now(),
+		Type:     eventType,
+		Resource: res.GetID(),
+		Kind:     res.GetType(),
+		Labels:   res.GetLabels(),
+		Actor:    actor,
+		Data:     data,
+	}, nil
+}
+
+// Log is a thread safe, bounded event log. Events beyond the retention or
+// older than the maximum age are dropped. If a path is given, events are
+// appended to that file as one JSON object per line and the file is
+// compacted once it holds twice the retained events.
+type Log struct {
+	lock      sync.RWMutex
+	path      string
+	retention int
+	maxAge    time.Duration
+	events    []Event
+	seq       uint64
+	lines     int
+	changed   chan struct{}
+	modified  time.Time
+}
+
+// NewLog returns an event log backed by the file at path. An empty path
+// results in an in-memory log.
+func NewLog(path string, retention int, maxAge time.Duration) *Log {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	return &Log{
+		lock:      sync.RWMutex{},
+		path:      path,
+		retention: retention,
+		maxAge:    maxAge,
+		events:    []Event{},
+		seq:       0,
+		lines:     0,
+		changed:   make(chan struct{}),
+		modified:  time.Now(),
+	}
+}
+
+// Initialize loads the retained events from the backing file, if any.
+func (l *Log) Initialize() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+
+	for scanner.Scan() {
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+
+		l.events = append(l.events, e)
+		l.lines++
+
+		if e.Seq > l.seq {
+			l.seq = e.Seq
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l.trim(time.Now())
+
+	return nil
+}
+
+// SetRetention changes the retention of the log and drops the events beyond
+// it.
+func (l *Log) SetRetention(retention int, maxAge time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if retention > 0 {
+		l.retention = retention
+	}
+
+	if maxAge > 0 {
+		l.maxAge = maxAge
+	}
+
+	l.trim(time.Now())
+}
+
+// Append adds the event with the next sequence number and returns it.
+func (l *Log) Append(e Event) (Event, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.seq++
+	e.Seq = l.seq
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.events = append(l.events, e)
+	l.modified = time.Now()
+	l.trim(e.Time)
+
+	// Wake up the waiting readers
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	return e, l.persist(e)
+}
+
+// Since returns up to limit events after the cursor, oldest first, and the
+// cursor to continue from. A zero cursor starts at the oldest retained
+// event. ErrCursorExpired is returned if events after the cursor were
+// dropped, the client must then list the resources again.
+func (l *Log) Since(cursor uint64, limit int) ([]Event, uint64, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if cursor > l.seq {
+		cursor = l.seq
+	}
+
+	if cursor != 0 && cursor < l.seq && (len(l.events) == 0 || l.events[0].Seq > cursor+1) {
+		return nil, cursor, ErrCursorExpired
+	}
+
+	events := []Event{}
+	next := cursor
+
+	for _, e := range l.events {
+		if limit > 0 && len(events) == limit {
+			break
+		}
+
+		if e.Seq > cursor {
+			events = append(events, e)
+			next = e.Seq
+		}
+	}
+
+	return events, next, nil
+}
+
+// Latest returns the cursor of the most recent event.
+func (l *Log) Latest() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.seq
+}
+
+// Modified returns the time the last event was appended, or the time the log
+// was created if none was since.
+func (l *Log) Modified() time.Time {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.modified
+}
+
+// Changed returns a channel that is closed when the next event is appended.
+func (l *Log) Changed() <-chan struct{} {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.changed
+}
+
+// trim drops the events beyond the retention. Must be called with the write
+// lock held.
+func (l *Log) trim(now time.Time) {
+	drop := 0
+	if len(l.events) > l.retention {
+		drop = len(l.events) - l.retention
+	}
+
+	for drop < len(l.events) && now.Sub(l.events[drop].Time) > l.maxAge {
+		drop++
+	}
+
+	if drop > 0 {
+		l.events = append([]Event{}, l.events[drop:]...)
+	}
+}
+
+// persist appends the event to the backing file, compacting the file if it
+// grew too large. Must be called with the write lock held.
+func (l *Log) persist(e Event) error {
+	if l.path == "" {
+		return nil
+	}
+
+	if l.lines >= 2*l.retention {
+		return l.compact()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, RWRR)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	l.lines++
+
+	return file.Close()
+}
+
+// compact rewrites the backing file with the retained events only. Must be
+// called with the write lock held.
+func (l *Log) compact() error {
+	tmp := l.path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, RWRR)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	for _, e := range l.events {
+		if err := encoder.Encode(e); err != nil {
+			file.Close()
+
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	l.lines = len(l.events)
+
+	return os.Rename(tmp, l.path)
+}
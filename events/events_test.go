@@ -0,0 +1,147 @@
+package events_test
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func labEvent(assert *assert.Assertions, eventType string) events.Event {
+	e, err := events.NewEvent(eventType, dc.NewLab("lab", zebra.Labels{"system.group": "labs"}), "user@zebra")
+	assert.Nil(err)
+
+	return e
+}
+
+func TestSince(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	log := events.NewLog("", 3, time.Hour)
+	created := log.Modified()
+	assert.False(created.IsZero())
+
+	page, cursor, err := log.Since(0, 10)
+	assert.Nil(err)
+	assert.Empty(page)
+	assert.Equal(uint64(0), cursor)
+
+	changed := log.Changed()
+
+	for i := 0; i < 5; i++ {
+		e, err := log.Append(labEvent(assert, events.Created))
+		assert.Nil(err)
+		assert.Equal(uint64(i+1), e.Seq)
+	}
+
+	select {
+	case <-changed:
+	default:
+		assert.Fail("appending did not signal the change")
+	}
+
+	assert.Equal(uint64(5), log.Latest())
+	assert.False(log.Modified().Before(created))
+
+	// Only the last three are retained
+	page, cursor, err = log.Since(0, 10)
+	assert.Nil(err)
+	assert.Equal(3, len(page))
+	assert.Equal(uint64(3), page[0].Seq)
+	assert.Equal(uint64(5), cursor)
+
+	page, cursor, err = log.Since(2, 2)
+	assert.Nil(err)
+	assert.Equal(2, len(page))
+	assert.Equal(uint64(4), cursor)
+
+	page, cursor, err = log.Since(5, 10)
+	assert.Nil(err)
+	assert.Empty(page)
+	assert.Equal(uint64(5), cursor)
+
+	_, _, err = log.Since(1, 10)
+	assert.ErrorIs(err, events.ErrCursorExpired)
+
+	// Cursors from the future are caught up
+	_, cursor, err = log.Since(100, 10)
+	assert.Nil(err)
+	assert.Equal(uint64(5), cursor)
+}
+
+func TestMaxAge(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	log := events.NewLog("", 100, time.Hour)
+
+	old := labEvent(assert, events.Created)
+	old.Time = time.Now().Add(-2 * time.Hour)
+
+	_, err := log.Append(old)
+	assert.Nil(err)
+
+	_, err = log.Append(labEvent(assert, events.Updated))
+	assert.Nil(err)
+
+	page, _, err := log.Since(0, 0)
+	assert.Nil(err)
+	assert.Equal(1, len(page))
+	assert.Equal(events.Updated, page[0].Type)
+
+	// Lowering the maximum age drops the remaining event
+	log.SetRetention(0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	log.SetRetention(0, 0)
+
+	_, _, err = log.Since(1, 0)
+	assert.ErrorIs(err, events.ErrCursorExpired)
+}
+
+func TestPersistence(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "events_testpersistence"
+	assert.Nil(os.MkdirAll(root, os.ModePerm))
+
+	defer func() { os.RemoveAll(root) }()
+
+	file := path.Join(root, "events.log")
+	log := events.NewLog(file, 2, time.Hour)
+	assert.Nil(log.Initialize())
+
+	for i := 0; i < 7; i++ {
+		_, err := log.Append(labEvent(assert, events.Created))
+		assert.Nil(err)
+	}
+
+	// The file is compacted to the retained events
+	data, err := os.ReadFile(file)
+	assert.Nil(err)
+	assert.True(strings.Count(string(data), "\n") <= 4)
+
+	reloaded := events.NewLog(file, 2, time.Hour)
+	assert.Nil(reloaded.Initialize())
+	assert.Equal(uint64(7), reloaded.Latest())
+
+	page, _, err := reloaded.Since(5, 0)
+	assert.Nil(err)
+	assert.Equal(2, len(page))
+	assert.Equal("labs", page[0].Labels["system.group"])
+	assert.Equal("user@zebra", page[0].Actor)
+
+	e, err := reloaded.Append(labEvent(assert, events.Deleted))
+	assert.Nil(err)
+	assert.Equal(uint64(8), e.Seq)
+
+	assert.Nil(os.WriteFile(file, []byte("{oops\n"), 0o600))
+	assert.NotNil(events.NewLog(file, 2, time.Hour).Initialize())
+}
@@ -0,0 +1,193 @@
+// Package faults injects faults into the writes and reads of the store for
+// resilience testing: latency, errors on a share of the writes and dropped
+// change events, so that the retries of clients and the catch up of replicas
+// can be exercised against a running server.
+package faults
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// MaxLatency limits the injected latency, so that faults can not hang the
+// server.
+const MaxLatency = time.Minute
+
+var (
+	ErrInjected = errors.New("injected fault")
+	ErrConfig   = errors.New("faults are latencies of up to 1m and percentages of 0 to 100")
+)
+
+// Config is the faults to inject. Latency is added to every write and read,
+// plus a random share of Jitter. WriteErrors and DroppedEvents are the
+// percentages of the writes that fail and of the events that are dropped.
+type Config struct {
+	Latency       string `json:"latency,omitempty"`
+	Jitter        string `json:"jitter,omitempty"`
+	WriteErrors   int    `json:"writeErrors,omitempty"`
+	DroppedEvents int    `json:"droppedEvents,omitempty"`
+}
+
+// Stats counts the faults injected.
+type Stats struct {
+	Delays        uint64 `json:"delays"`
+	WriteErrors   uint64 `json:"writeErrors"`
+	DroppedEvents uint64 `json:"droppedEvents"`
+}
+
+// Injector injects the faults of its configuration. A nil injector injects
+// none, so that callers need not check if fault injection is enabled.
+type Injector struct {
+	lock    sync.Mutex
+	rng     *rand.Rand
+	cfg     Config
+	latency time.Duration
+	jitter  time.Duration
+	stats   Stats
+}
+
+// New returns an injector that injects no faults until configured. The seed
+// makes the faults injected repeatable.
+func New(seed int64) *Injector {
+	return &Injector{
+		lock:    sync.Mutex{},
+		rng:     rand.New(rand.NewSource(seed)), //nolint:gosec
+		cfg:     Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0},
+		latency: 0,
+		jitter:  0,
+		stats:   Stats{Delays: 0, WriteErrors: 0, DroppedEvents: 0},
+	}
+}
+
+func parseLatency(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 || d > MaxLatency {
+		return 0, ErrConfig
+	}
+
+	return d, nil
+}
+
+func validPercentage(p int) bool {
+	return p >= 0 && p <= 100
+}
+
+// Set replaces the faults to inject. The faults are left as they are if the
+// configuration is not valid.
+func (i *Injector) Set(cfg Config) error {
+	latency, err := parseLatency(cfg.Latency)
+	if err != nil {
+		return err
+	}
+
+	jitter, err := parseLatency(cfg.Jitter)
+	if err != nil {
+		return err
+	}
+
+	if !validPercentage(cfg.WriteErrors) || !validPercentage(cfg.DroppedEvents) {
+		return ErrConfig
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.cfg = cfg
+	i.latency = latency
+	i.jitter = jitter
+
+	return nil
+}
+
+// Config returns the faults injected.
+func (i *Injector) Config() Config {
+	if i == nil {
+		return Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.cfg
+}
+
+// Stats returns the counts of the faults injected so far.
+func (i *Injector) Stats() Stats {
+	if i == nil {
+		return Stats{Delays: 0, WriteErrors: 0, DroppedEvents: 0}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.stats
+}
+
+// chance tells if an event of the percentage happens, and counts it.
+func (i *Injector) chance(percentage int, count *uint64) bool {
+	if percentage == 0 || i.rng.Intn(100) >= percentage { //nolint:gomnd
+		return false
+	}
+
+	*count++
+
+	return true
+}
+
+// Delay waits for the latency of the faults.
+func (i *Injector) Delay() {
+	if i == nil {
+		return
+	}
+
+	i.lock.Lock()
+
+	d := i.latency
+	if i.jitter > 0 {
+		d += time.Duration(i.rng.Int63n(int64(i.jitter) + 1))
+	}
+
+	if d > 0 {
+		i.stats.Delays++
+	}
+
+	i.lock.Unlock()
+
+	time.Sleep(d)
+}
+
+// Write delays a write and returns ErrInjected if it is to fail.
+func (i *Injector) Write() error {
+	if i == nil {
+		return nil
+	}
+
+	i.Delay()
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.chance(i.cfg.WriteErrors, &i.stats.WriteErrors) {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// DropEvent tells if an event is to be dropped.
+func (i *Injector) DropEvent() bool {
+	if i == nil {
+		return false
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.chance(i.cfg.DroppedEvents, &i.stats.DroppedEvents)
+}
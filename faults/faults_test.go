@@ -0,0 +1,85 @@
+package faults_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/faults"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNil(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var i *faults.Injector
+
+	i.Delay()
+	assert.Nil(i.Write())
+	assert.False(i.DropEvent())
+	assert.Equal(faults.Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0}, i.Config())
+	assert.Equal(faults.Stats{Delays: 0, WriteErrors: 0, DroppedEvents: 0}, i.Stats())
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	i := faults.New(1)
+	cfg := faults.Config{Latency: "1ms", Jitter: "1ms", WriteErrors: 50, DroppedEvents: 10}
+
+	assert.Nil(i.Set(cfg))
+	assert.Equal(cfg, i.Config())
+
+	for _, bad := range []faults.Config{
+		{Latency: "soon", Jitter: "", WriteErrors: 0, DroppedEvents: 0},
+		{Latency: "", Jitter: "2m", WriteErrors: 0, DroppedEvents: 0},
+		{Latency: "-1s", Jitter: "", WriteErrors: 0, DroppedEvents: 0},
+		{Latency: "", Jitter: "", WriteErrors: 101, DroppedEvents: 0},
+		{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: -1},
+	} {
+		assert.ErrorIs(i.Set(bad), faults.ErrConfig)
+	}
+
+	// Bad configurations leave the faults as they are
+	assert.Equal(cfg, i.Config())
+}
+
+func TestInject(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	i := faults.New(1)
+
+	for n := 0; n < 100; n++ {
+		assert.Nil(i.Write())
+		assert.False(i.DropEvent())
+	}
+
+	assert.Equal(faults.Stats{Delays: 0, WriteErrors: 0, DroppedEvents: 0}, i.Stats())
+
+	assert.Nil(i.Set(faults.Config{Latency: "", Jitter: "", WriteErrors: 100, DroppedEvents: 100}))
+	assert.ErrorIs(i.Write(), faults.ErrInjected)
+	assert.True(i.DropEvent())
+
+	assert.Nil(i.Set(faults.Config{Latency: "", Jitter: "", WriteErrors: 50, DroppedEvents: 0}))
+
+	failed := 0
+
+	for n := 0; n < 1000; n++ {
+		if i.Write() != nil {
+			failed++
+		}
+	}
+
+	assert.InDelta(500, failed, 100)
+	assert.Equal(uint64(failed+1), i.Stats().WriteErrors)
+	assert.Equal(uint64(1), i.Stats().DroppedEvents)
+
+	assert.Nil(i.Set(faults.Config{Latency: "5ms", Jitter: "", WriteErrors: 0, DroppedEvents: 0}))
+
+	start := time.Now()
+	i.Delay()
+	assert.GreaterOrEqual(time.Since(start), 5*time.Millisecond)
+	assert.Equal(uint64(1), i.Stats().Delays)
+}
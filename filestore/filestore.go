@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"runtime"
+	"sync"
 	"syscall"
 
 	"github.com/hashicorp/go-multierror"
@@ -16,6 +18,9 @@ import (
 
 const RWRR = os.FileMode(0o644)
 
+// loadWorkers is the number of folders loaded in parallel.
+var loadWorkers = runtime.NumCPU()
+
 // FileStore implements Store.
 type FileStore struct {
 	storageRoot string
@@ -88,59 +93,106 @@ func (f *FileStore) Clear() error {
 }
 
 // Load objects from filestore storageRoot.
-// Return resources as ResourceMap where keys are types.
+// Return resources as ResourceMap where keys are types. The folders are read
+// and parsed by a worker per CPU in parallel.
 func (f *FileStore) Load() (*zebra.ResourceMap, error) {
-	var retErr error
-
 	rootDir := f.filestoreResourcesPath()
 
-	resources := zebra.NewResourceMap(f.factory)
-
 	dirs, err := os.ReadDir(rootDir)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, subdir := range dirs {
-		files, err := os.ReadDir(path.Join(rootDir, subdir.Name()))
-		if err != nil {
-			return nil, err
-		}
+	work := make(chan string)
+	results := make(chan loadResult)
+	wg := sync.WaitGroup{}
 
-		for _, file := range files {
-			contents, err := os.ReadFile(path.Join(rootDir, subdir.Name(), file.Name()))
-			if err != nil {
-				return nil, err
-			}
+	for i := 0; i < loadWorkers && i < len(dirs); i++ {
+		wg.Add(1)
 
-			object := make(map[string]interface{})
+		go func() {
+			defer wg.Done()
 
-			err = json.Unmarshal(contents, &object)
-			if err != nil {
-				return nil, err
+			for dir := range work {
+				results <- f.loadFolder(dir)
 			}
+		}()
+	}
 
-			resType, ok := object["type"].(string)
-			if !ok {
-				retErr = ErrNoType
+	go func() {
+		for _, subdir := range dirs {
+			work <- path.Join(rootDir, subdir.Name())
+		}
 
-				continue
-			}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
 
-			newRes, err := f.unpackResource(contents, resType)
-			if err != nil {
-				retErr = err
+	var retErr, readErr error
 
-				continue
-			}
+	resources := zebra.NewResourceMap(f.factory)
+
+	for result := range results {
+		for _, res := range result.resources {
+			resources.Add(res, res.GetType())
+		}
+
+		if result.readErr != nil {
+			readErr = result.readErr
+		}
 
-			resources.Add(newRes, resType)
+		if result.unpackErr != nil {
+			retErr = result.unpackErr
 		}
 	}
 
+	if readErr != nil {
+		return nil, readErr
+	}
+
 	return resources, retErr
 }
 
+// loadResult holds the resources of a folder. Resources that fail to unpack
+// are skipped, failing to read the folder fails the whole load.
+type loadResult struct {
+	resources []zebra.Resource
+	readErr   error
+	unpackErr error
+}
+
+func (f *FileStore) loadFolder(dir string) loadResult {
+	result := loadResult{resources: nil, readErr: nil, unpackErr: nil}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		result.readErr = err
+
+		return result
+	}
+
+	for _, file := range files {
+		contents, err := os.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			result.readErr = err
+
+			return result
+		}
+
+		newRes, err := f.unpackResource(contents)
+		if err != nil {
+			result.unpackErr = err
+
+			continue
+		}
+
+		result.resources = append(result.resources, newRes)
+	}
+
+	return result
+}
+
 // Store new object given storage root path and resource pointer.
 // If object already exists, update.
 func (f *FileStore) Create(res zebra.Resource) error {
@@ -215,19 +267,25 @@ func (f *FileStore) Delete(res zebra.Resource) error {
 	return nil
 }
 
-// Unpack storedRes.Resource into correct type of resource and return zebra.Resource
-// along with error if occurred.
-func (f *FileStore) unpackResource(contents []byte, resType string) (zebra.Resource, error) {
+// Unpack the stored contents into the resource of the stored type and return
+// zebra.Resource along with error if occurred. Stored resources of older
+// schema versions of their type are migrated, fields the migrations leave
+// behind are ignored.
+func (f *FileStore) unpackResource(contents []byte) (zebra.Resource, error) {
 	if f.factory == nil {
 		return nil, ErrFactoryNil
 	}
 
-	res := f.factory.New(resType)
-	if res == nil {
-		return nil, ErrTypeUnpack
-	}
+	decoder := zebra.NewDecoder(f.factory)
+	decoder.AllowUnknownFields = true
+	decoder.Stored = true
 
-	if err := json.Unmarshal(contents, res); err != nil {
+	res, err := decoder.Decode(contents)
+	if errors.Is(err, zebra.ErrTypeEmpty) {
+		return nil, ErrNoType
+	} else if errors.Is(err, zebra.ErrUnknownType) {
+		return nil, ErrTypeUnpack
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -8,6 +8,7 @@ import (
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/filestore"
 	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -283,3 +284,16 @@ func getPath(root string, res zebra.Resource) string {
 
 	return root + "/resources/" + resID[:2] + "/" + resID[2:]
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		fs := filestore.NewFileStore(t.TempDir(), storetest.Factory())
+		assert.Nil(t, fs.Initialize())
+
+		return fs
+	})
+}
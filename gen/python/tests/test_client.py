@@ -0,0 +1,56 @@
+"""Tests the generated client against a running zebra server.
+
+The server is given by ZEBRA_URL and its CA certificate by ZEBRA_CA, the
+user to log in as by ZEBRA_EMAIL and ZEBRA_PASSWORD.
+"""
+import json
+import os
+
+import httpx
+import pytest
+
+from zebra_client import Client
+from zebra_client.api.openapi import get_openapi_json
+from zebra_client.api.schemas import get_schemas
+from zebra_client.api.types import get_types_type_schema
+
+
+@pytest.fixture(scope="module")
+def client():
+    url = os.environ["ZEBRA_URL"]
+    verify = os.environ.get("ZEBRA_CA", True)
+
+    login = httpx.post(
+        url + "/login",
+        json={"email": os.environ["ZEBRA_EMAIL"], "password": os.environ["ZEBRA_PASSWORD"]},
+        verify=verify,
+    )
+    login.raise_for_status()
+
+    return Client(base_url=url + "/api/v1", cookies={"jwt": login.json()["jwt"]}, verify_ssl=verify)
+
+
+def test_type_schema(client):
+    response = get_types_type_schema.sync_detailed("Lab", client=client)
+    assert response.status_code == 200
+
+    schema = json.loads(response.content)
+    assert schema["title"] == "Lab"
+    assert "id" in schema["required"]
+
+
+def test_unknown_type_schema(client):
+    response = get_types_type_schema.sync_detailed("Spaceship", client=client)
+    assert response.status_code == 404
+
+
+def test_schemas(client):
+    response = get_schemas.sync_detailed(client=client)
+    assert response.status_code == 200
+    assert "Lab" in json.loads(response.content)["$defs"]
+
+
+def test_openapi(client):
+    response = get_openapi_json.sync_detailed(client=client)
+    assert response.status_code == 200
+    assert "/types/{type}/schema" in json.loads(response.content)["paths"]
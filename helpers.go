@@ -14,10 +14,11 @@ func NewBaseResource(resType string, labels Labels) *BaseResource {
 	}
 
 	return &BaseResource{
-		ID:     id,
-		Type:   resType,
-		Labels: labels,
-		Status: DefaultStatus(),
+		ID:            id,
+		Type:          resType,
+		Labels:        labels,
+		Status:        DefaultStatus(),
+		SchemaVersion: 0,
 	}
 }
 
@@ -0,0 +1,236 @@
+// Package history keeps prior versions of zebra resources so that changes
+// to a resource can be inspected after the fact.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+const (
+	RWRR = os.FileMode(0o644)
+
+	// DefaultMaxVersions is the number of versions kept per resource.
+	DefaultMaxVersions = 20
+
+	// maxLineSize limits the size of a single stored version.
+	maxLineSize = 1 << 20
+)
+
+var ErrVersionNotFound = errors.New("resource version not found")
+
+// Version is a stored version of a resource. Deleted is set if this version
+// records the deletion of the resource.
+type Version struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Time    time.Time       `json:"time"`
+	Actor   string          `json:"actor,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// History is a thread safe, bounded, per resource version history. If a path
+// is given, versions are appended to that file as one JSON object per line.
+type History struct {
+	lock     sync.RWMutex
+	path     string
+	max      int
+	versions map[string][]Version
+}
+
+// NewHistory returns a history that keeps at most max versions per resource,
+// backed by the file at path. An empty path results in an in-memory history.
+func NewHistory(path string, max int) *History {
+	if max <= 0 {
+		max = DefaultMaxVersions
+	}
+
+	return &History{
+		lock:     sync.RWMutex{},
+		path:     path,
+		max:      max,
+		versions: make(map[string][]Version),
+	}
+}
+
+// Initialize loads existing versions from the backing file, if any.
+func (h *History) Initialize() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+
+	for scanner.Scan() {
+		v := Version{}
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return err
+		}
+
+		h.add(v)
+	}
+
+	return scanner.Err()
+}
+
+// Record stores the current state of the resource as a new version and
+// returns the version number.
+func (h *History) Record(res zebra.Resource, actor string) (int, error) {
+	return h.record(res, actor, false)
+}
+
+// RecordDelete stores a version marking the deletion of the resource.
+func (h *History) RecordDelete(res zebra.Resource, actor string) (int, error) {
+	return h.record(res, actor, true)
+}
+
+func (h *History) record(res zebra.Resource, actor string, deleted bool) (int, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return 0, err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	v := Version{
+		ID:      res.GetID(),
+		Type:    res.GetType(),
+		Version: 1,
+		Time:    time.Now(),
+		Actor:   actor,
+		Deleted: deleted,
+		Data:    data,
+	}
+
+	if versions := h.versions[v.ID]; len(versions) > 0 {
+		v.Version = versions[len(versions)-1].Version + 1
+	}
+
+	h.add(v)
+
+	return v.Version, h.persist(v)
+}
+
+// add appends the version, dropping the oldest if over the limit. Must be
+// called with the write lock held.
+func (h *History) add(v Version) {
+	versions := append(h.versions[v.ID], v)
+	if len(versions) > h.max {
+		versions = versions[len(versions)-h.max:]
+	}
+
+	h.versions[v.ID] = versions
+}
+
+// persist appends the version to the backing file. Must be called with the
+// write lock held.
+func (h *History) persist(v Version) error {
+	if h.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, RWRR)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// Versions returns all known versions of the resource, oldest first.
+func (h *History) Versions(resID string) []Version {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	versions := make([]Version, len(h.versions[resID]))
+	copy(versions, h.versions[resID])
+
+	return versions
+}
+
+// IDs returns the IDs of the resources of the type with known versions,
+// deleted ones included, sorted.
+func (h *History) IDs(resType string) []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ids := []string{}
+
+	for id, versions := range h.versions {
+		if len(versions) != 0 && versions[0].Type == resType {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Get returns the given version of the resource. A version of 0 returns the
+// latest version.
+func (h *History) Get(resID string, version int) (Version, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	versions := h.versions[resID]
+	if len(versions) == 0 {
+		return Version{}, ErrVersionNotFound
+	}
+
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+
+	return Version{}, ErrVersionNotFound
+}
+
+// Resource returns the version as a resource object made by the factory.
+func (v Version) Resource(factory zebra.ResourceFactory) (zebra.Resource, error) {
+	// Old versions may hold fields their type no longer has
+	decoder := zebra.NewDecoder(factory)
+	decoder.AllowUnknownFields = true
+	decoder.Stored = true
+
+	return decoder.Decode(v.Data)
+}
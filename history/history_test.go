@@ -0,0 +1,89 @@
+package history_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/history"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := history.NewHistory("", 2)
+	assert.Nil(h.Initialize())
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "labs"})
+
+	_, err := h.Get(lab.ID, 0)
+	assert.Equal(history.ErrVersionNotFound, err)
+
+	v, err := h.Record(lab, "a@zebra")
+	assert.Nil(err)
+	assert.Equal(1, v)
+
+	lab.Name = "lab2"
+	v, err = h.Record(lab, "a@zebra")
+	assert.Nil(err)
+	assert.Equal(2, v)
+
+	v, err = h.RecordDelete(lab, "b@zebra")
+	assert.Nil(err)
+	assert.Equal(3, v)
+
+	// Only two versions are kept
+	versions := h.Versions(lab.ID)
+	assert.Equal(2, len(versions))
+	assert.Equal(2, versions[0].Version)
+	assert.True(versions[1].Deleted)
+
+	_, err = h.Get(lab.ID, 1)
+	assert.Equal(history.ErrVersionNotFound, err)
+
+	latest, err := h.Get(lab.ID, 0)
+	assert.Nil(err)
+	assert.Equal(3, latest.Version)
+
+	factory := zebra.Factory().Add(dc.LabType())
+
+	res, err := latest.Resource(factory)
+	assert.Nil(err)
+	assert.Equal("lab2", res.(*dc.Lab).Name)
+
+	_, err = latest.Resource(zebra.Factory())
+	assert.NotNil(err)
+
+	assert.Equal([]string{lab.ID}, h.IDs("Lab"))
+	assert.Empty(h.IDs("Lease"))
+}
+
+func TestFileHistory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	path := "test_history.log"
+
+	t.Cleanup(func() { os.Remove(path) })
+
+	h := history.NewHistory(path, 0)
+	assert.Nil(h.Initialize())
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "labs"})
+	_, err := h.Record(lab, "")
+	assert.Nil(err)
+	_, err = h.Record(lab, "")
+	assert.Nil(err)
+
+	h = history.NewHistory(path, 0)
+	assert.Nil(h.Initialize())
+	assert.Equal(2, len(h.Versions(lab.ID)))
+
+	assert.Nil(os.WriteFile(path, []byte("{bad\n"), history.RWRR))
+
+	h = history.NewHistory(path, 0)
+	assert.NotNil(h.Initialize())
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/idstore"
 	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -179,3 +180,16 @@ func getVLAN() *network.VLANPool {
 		RangeEnd:     1,
 	}
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		s := idstore.NewIDStore(zebra.NewResourceMap(storetest.Factory()))
+		assert.Nil(t, s.Initialize())
+
+		return s
+	})
+}
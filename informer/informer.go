@@ -0,0 +1,455 @@
+// Package informer keeps a local cache of zebra resources up to date, the
+// building block of controllers that act on changes to resources. An
+// informer lists the resources of the types it caches, then follows the
+// event stream of the server from the cursor of the list. Handlers are
+// called for each change to the cache, and again for every cached resource
+// each resync period so that controllers can correct what they missed. If
+// the server no longer retains the events after the cursor, the informer
+// lists the resources again.
+package informer
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/events"
+)
+
+// Defaults of an informer.
+const (
+	DefaultWait    = 20 * time.Second
+	DefaultBackoff = time.Second
+	MaxBackoff     = time.Minute
+)
+
+var (
+	ErrIndexExists  = errors.New("index already exists")
+	ErrIndexUnknown = errors.New("index does not exist")
+)
+
+// Source lists resources and watches for changes to them, usually a zebra
+// server, see HTTPSource.
+type Source interface {
+	// List returns the resources of the types and the cursor of the event
+	// stream from which on changes are not part of the list.
+	List(ctx context.Context, types []string) (*zebra.ResourceMap, string, error)
+	// Watch returns the events after the cursor, waiting up to wait for
+	// one, and the cursor of the next watch. Watch returns an error that is
+	// events.ErrCursorExpired if the events after the cursor are gone.
+	Watch(ctx context.Context, cursor string, wait time.Duration) ([]events.Event, string, error)
+}
+
+// Handler is told about the changes to the cache. Resyncs update resources
+// to themselves. Handlers are called with the cache locked, so they must not
+// read the cache: controllers queue the ID and read the cache later.
+type Handler interface {
+	OnAdd(res zebra.Resource)
+	OnUpdate(old zebra.Resource, res zebra.Resource)
+	OnDelete(res zebra.Resource)
+}
+
+// HandlerFuncs is a Handler of the functions that are set.
+type HandlerFuncs struct {
+	AddFunc    func(res zebra.Resource)
+	UpdateFunc func(old zebra.Resource, res zebra.Resource)
+	DeleteFunc func(res zebra.Resource)
+}
+
+func (h HandlerFuncs) OnAdd(res zebra.Resource) {
+	if h.AddFunc != nil {
+		h.AddFunc(res)
+	}
+}
+
+func (h HandlerFuncs) OnUpdate(old zebra.Resource, res zebra.Resource) {
+	if h.UpdateFunc != nil {
+		h.UpdateFunc(old, res)
+	}
+}
+
+func (h HandlerFuncs) OnDelete(res zebra.Resource) {
+	if h.DeleteFunc != nil {
+		h.DeleteFunc(res)
+	}
+}
+
+// IndexFunc returns the values a resource is indexed by.
+type IndexFunc func(res zebra.Resource) []string
+
+// LabelIndex indexes resources by the value of a label.
+func LabelIndex(key string) IndexFunc {
+	return func(res zebra.Resource) []string {
+		if value, ok := res.GetLabels()[key]; ok {
+			return []string{value}
+		}
+
+		return nil
+	}
+}
+
+// Lister reads the cache of an informer.
+type Lister interface {
+	// Get returns the cached resource with the ID.
+	Get(id string) (zebra.Resource, bool)
+	// List returns the cached resources in ID order.
+	List() []zebra.Resource
+	// ByIndex returns the cached resources with the value in the index, in
+	// ID order.
+	ByIndex(index string, value string) ([]zebra.Resource, error)
+}
+
+// index maps the values of an index to the IDs of the resources.
+type index struct {
+	fn     IndexFunc
+	values map[string]map[string]bool
+}
+
+func (i *index) add(res zebra.Resource) {
+	for _, v := range i.fn(res) {
+		if i.values[v] == nil {
+			i.values[v] = map[string]bool{}
+		}
+
+		i.values[v][res.GetID()] = true
+	}
+}
+
+func (i *index) remove(res zebra.Resource) {
+	for _, v := range i.fn(res) {
+		delete(i.values[v], res.GetID())
+
+		if len(i.values[v]) == 0 {
+			delete(i.values, v)
+		}
+	}
+}
+
+// Informer caches the resources of some types of a source.
+type Informer struct {
+	Source  Source
+	Factory zebra.ResourceFactory
+	Types   []string
+	Resync  time.Duration
+	Wait    time.Duration
+
+	lock     sync.RWMutex
+	items    map[string]zebra.Resource
+	indices  map[string]*index
+	handlers []Handler
+	cursor   string
+	synced   chan struct{}
+	once     sync.Once
+}
+
+// NewInformer returns an informer of the resources of the types, all types
+// if there are none. A zero resync period disables resyncs.
+func NewInformer(source Source, factory zebra.ResourceFactory, types []string, resync time.Duration) *Informer {
+	return &Informer{
+		Source:   source,
+		Factory:  factory,
+		Types:    types,
+		Resync:   resync,
+		Wait:     DefaultWait,
+		lock:     sync.RWMutex{},
+		items:    map[string]zebra.Resource{},
+		indices:  map[string]*index{},
+		handlers: []Handler{},
+		cursor:   "",
+		synced:   make(chan struct{}),
+		once:     sync.Once{},
+	}
+}
+
+// AddHandler adds a handler, which is told about the resources already in
+// the cache as added.
+func (inf *Informer) AddHandler(h Handler) {
+	inf.lock.Lock()
+	defer inf.lock.Unlock()
+
+	inf.handlers = append(inf.handlers, h)
+
+	for _, res := range inf.sorted() {
+		h.OnAdd(res)
+	}
+}
+
+// AddIndex adds an index of the cached resources.
+func (inf *Informer) AddIndex(name string, fn IndexFunc) error {
+	inf.lock.Lock()
+	defer inf.lock.Unlock()
+
+	if _, ok := inf.indices[name]; ok {
+		return ErrIndexExists
+	}
+
+	i := &index{fn: fn, values: map[string]map[string]bool{}}
+	for _, res := range inf.items {
+		i.add(res)
+	}
+
+	inf.indices[name] = i
+
+	return nil
+}
+
+// Lister returns the lister of the cache.
+func (inf *Informer) Lister() Lister {
+	return inf
+}
+
+func (inf *Informer) Get(id string) (zebra.Resource, bool) {
+	inf.lock.RLock()
+	defer inf.lock.RUnlock()
+
+	res, ok := inf.items[id]
+
+	return res, ok
+}
+
+func (inf *Informer) List() []zebra.Resource {
+	inf.lock.RLock()
+	defer inf.lock.RUnlock()
+
+	return inf.sorted()
+}
+
+func (inf *Informer) ByIndex(name string, value string) ([]zebra.Resource, error) {
+	inf.lock.RLock()
+	defer inf.lock.RUnlock()
+
+	i, ok := inf.indices[name]
+	if !ok {
+		return nil, ErrIndexUnknown
+	}
+
+	resources := make([]zebra.Resource, 0, len(i.values[value]))
+	for id := range i.values[value] {
+		resources = append(resources, inf.items[id])
+	}
+
+	sortResources(resources)
+
+	return resources, nil
+}
+
+// HasSynced returns true once the first list is in the cache.
+func (inf *Informer) HasSynced() bool {
+	select {
+	case <-inf.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForSync waits until the first list is in the cache, false if the
+// context ends first.
+func (inf *Informer) WaitForSync(ctx context.Context) bool {
+	select {
+	case <-inf.synced:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run keeps the cache up to date until the context ends. Failed lists and
+// watches are retried with a backoff.
+func (inf *Informer) Run(ctx context.Context) error {
+	backoff := DefaultBackoff
+	listed := false
+	resync := time.Now().Add(inf.Resync)
+
+	for ctx.Err() == nil {
+		var err error
+
+		if !listed {
+			err = inf.list(ctx)
+			listed = err == nil
+		} else {
+			err = inf.watch(ctx)
+			if errors.Is(err, events.ErrCursorExpired) {
+				listed = false
+			}
+		}
+
+		if inf.Resync > 0 && time.Now().After(resync) {
+			inf.resync()
+			resync = time.Now().Add(inf.Resync)
+		}
+
+		if err == nil || errors.Is(err, events.ErrCursorExpired) {
+			backoff = DefaultBackoff
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+
+	return ctx.Err()
+}
+
+// list replaces the cache with the resources of the source.
+func (inf *Informer) list(ctx context.Context) error {
+	resMap, cursor, err := inf.Source.List(ctx, inf.Types)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]zebra.Resource{}
+
+	for t, l := range resMap.Resources {
+		if !inf.selected(t) {
+			continue
+		}
+
+		for _, res := range l.Resources {
+			listed[res.GetID()] = res
+		}
+	}
+
+	inf.lock.Lock()
+	defer inf.lock.Unlock()
+
+	for id, old := range inf.items {
+		if _, ok := listed[id]; !ok {
+			inf.remove(old)
+		}
+	}
+
+	ids := make([]string, 0, len(listed))
+	for id := range listed {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		inf.put(listed[id])
+	}
+
+	inf.cursor = cursor
+	inf.once.Do(func() { close(inf.synced) })
+
+	return nil
+}
+
+// watch applies the events after the cursor to the cache.
+func (inf *Informer) watch(ctx context.Context) error {
+	inf.lock.RLock()
+	cursor := inf.cursor
+	inf.lock.RUnlock()
+
+	page, next, err := inf.Source.Watch(ctx, cursor, inf.Wait)
+	if err != nil {
+		return err
+	}
+
+	decoder := zebra.NewDecoder(inf.Factory)
+	decoder.AllowUnknownFields = true
+
+	inf.lock.Lock()
+	defer inf.lock.Unlock()
+
+	for _, e := range page {
+		if !inf.selected(e.Kind) {
+			continue
+		}
+
+		switch e.Type {
+		case events.Deleted, events.Archived:
+			if old, ok := inf.items[e.Resource]; ok {
+				inf.remove(old)
+			}
+		default:
+			// Events without the resource change nothing in the cache
+			if res, err := decoder.Decode(e.Data); err == nil {
+				inf.put(res)
+			}
+		}
+	}
+
+	inf.cursor = next
+
+	return nil
+}
+
+// resync tells the handlers about every cached resource again.
+func (inf *Informer) resync() {
+	inf.lock.RLock()
+	defer inf.lock.RUnlock()
+
+	for _, res := range inf.sorted() {
+		for _, h := range inf.handlers {
+			h.OnUpdate(res, res)
+		}
+	}
+}
+
+func (inf *Informer) selected(resType string) bool {
+	return len(inf.Types) == 0 || zebra.IsIn(resType, inf.Types)
+}
+
+// put adds or replaces a resource of the cache, with the lock held.
+func (inf *Informer) put(res zebra.Resource) {
+	old, ok := inf.items[res.GetID()]
+	if ok {
+		for _, i := range inf.indices {
+			i.remove(old)
+		}
+	}
+
+	inf.items[res.GetID()] = res
+
+	for _, i := range inf.indices {
+		i.add(res)
+	}
+
+	for _, h := range inf.handlers {
+		if ok {
+			h.OnUpdate(old, res)
+		} else {
+			h.OnAdd(res)
+		}
+	}
+}
+
+// remove removes a resource from the cache, with the lock held.
+func (inf *Informer) remove(res zebra.Resource) {
+	delete(inf.items, res.GetID())
+
+	for _, i := range inf.indices {
+		i.remove(res)
+	}
+
+	for _, h := range inf.handlers {
+		h.OnDelete(res)
+	}
+}
+
+func (inf *Informer) sorted() []zebra.Resource {
+	resources := make([]zebra.Resource, 0, len(inf.items))
+	for _, res := range inf.items {
+		resources = append(resources, res)
+	}
+
+	sortResources(resources)
+
+	return resources
+}
+
+func sortResources(resources []zebra.Resource) {
+	sort.Slice(resources, func(i, j int) bool { return resources[i].GetID() < resources[j].GetID() })
+}
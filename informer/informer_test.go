@@ -0,0 +1,190 @@
+package informer_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/informer"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSource is a server that keeps its resources and events in memory.
+type fakeSource struct {
+	lock      sync.Mutex
+	resources map[string]zebra.Resource
+	events    []events.Event
+	expired   bool
+	lists     int
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		lock: sync.Mutex{}, resources: map[string]zebra.Resource{}, events: []events.Event{}, expired: false, lists: 0,
+	}
+}
+
+func (f *fakeSource) change(eventType string, res zebra.Resource) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if eventType == events.Deleted {
+		delete(f.resources, res.GetID())
+	} else {
+		f.resources[res.GetID()] = res
+	}
+
+	e, _ := events.NewEvent(eventType, res, "")
+	e.Seq = uint64(len(f.events) + 1)
+	f.events = append(f.events, e)
+}
+
+func (f *fakeSource) List(ctx context.Context, types []string) (*zebra.ResourceMap, string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lists++
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+	for _, res := range f.resources {
+		resMap.Add(res, res.GetType())
+	}
+
+	return resMap, strconv.Itoa(len(f.events)), nil
+}
+
+func (f *fakeSource) Watch(ctx context.Context, cursor string, wait time.Duration,
+) ([]events.Event, string, error) {
+	time.Sleep(time.Millisecond)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.expired {
+		f.expired = false
+
+		return nil, cursor, events.ErrCursorExpired
+	}
+
+	since, _ := strconv.Atoi(cursor)
+
+	return f.events[since:], strconv.Itoa(len(f.events)), nil
+}
+
+func TestInformer(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	source := newFakeSource()
+	a := dc.NewLab("a", zebra.Labels{"system.group": "x"})
+	b := dc.NewLab("b", zebra.Labels{"system.group": "y"})
+	rack := dc.NewRack("r", "row", zebra.Labels{"system.group": "x"})
+
+	for _, res := range []zebra.Resource{a, b, rack} {
+		source.change(events.Created, res)
+	}
+
+	inf := informer.NewInformer(source, store.DefaultFactory(), []string{"Lab"}, 0)
+	assert.Nil(inf.AddIndex("group", informer.LabelIndex("system.group")))
+	assert.ErrorIs(inf.AddIndex("group", informer.LabelIndex("system.group")), informer.ErrIndexExists)
+
+	lock := sync.Mutex{}
+	counts := map[string]int{}
+	count := func(kind string) func(zebra.Resource) {
+		return func(zebra.Resource) {
+			lock.Lock()
+			defer lock.Unlock()
+			counts[kind]++
+		}
+	}
+	counted := func(kind string) int {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return counts[kind]
+	}
+
+	inf.AddHandler(informer.HandlerFuncs{
+		AddFunc:    count("add"),
+		UpdateFunc: func(old zebra.Resource, res zebra.Resource) { count("update")(res) },
+		DeleteFunc: count("delete"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	assert.False(inf.HasSynced())
+
+	go func() { done <- inf.Run(ctx) }()
+
+	assert.True(inf.WaitForSync(ctx))
+	assert.Equal(2, len(inf.List()))
+	assert.Equal(2, counted("add"))
+
+	res, ok := inf.Get(a.ID)
+	assert.True(ok)
+	assert.Equal("a", res.(*dc.Lab).Name)
+
+	// Only the cached types are followed
+	c := dc.NewLab("c", zebra.Labels{"system.group": "x"})
+	source.change(events.Created, c)
+	source.change(events.Deleted, a)
+	source.change(events.Created, dc.NewRack("s", "row", zebra.Labels{"system.group": "x"}))
+
+	assert.Eventually(func() bool { _, ok := inf.Get(a.ID); return !ok }, time.Second, time.Millisecond)
+
+	inGroup, err := inf.ByIndex("group", "x")
+	assert.Nil(err)
+	assert.Equal(1, len(inGroup))
+	assert.Equal(c.ID, inGroup[0].GetID())
+
+	_, err = inf.ByIndex("color", "x")
+	assert.ErrorIs(err, informer.ErrIndexUnknown)
+
+	// Expired cursors list again, dropping what the events missed
+	source.lock.Lock()
+	delete(source.resources, b.ID)
+	source.expired = true
+	source.lock.Unlock()
+
+	assert.Eventually(func() bool { _, ok := inf.Get(b.ID); return !ok }, time.Second, time.Millisecond)
+	assert.Equal(2, counted("delete"))
+
+	cancel()
+	assert.ErrorIs(<-done, context.Canceled)
+}
+
+func TestResync(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	source := newFakeSource()
+	source.change(events.Created, dc.NewLab("a", zebra.Labels{"system.group": "x"}))
+
+	inf := informer.NewInformer(source, store.DefaultFactory(), nil, time.Millisecond)
+	updates := make(chan zebra.Resource, 100)
+
+	inf.AddHandler(informer.HandlerFuncs{
+		AddFunc:    nil,
+		UpdateFunc: func(old zebra.Resource, res zebra.Resource) { updates <- res },
+		DeleteFunc: nil,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() { _ = inf.Run(ctx) }()
+
+	select {
+	case res := <-updates:
+		assert.Equal("Lab", res.GetType())
+	case <-time.After(time.Second):
+		assert.Fail("no resync")
+	}
+}
@@ -0,0 +1,141 @@
+package informer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/events"
+)
+
+// API routes an HTTPSource reads.
+const (
+	ResourcesPath = "/api/v1/resources"
+	EventsPath    = "/api/v1/events"
+)
+
+// StatusError is returned for responses other than 2xx.
+type StatusError struct {
+	URL    string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// HTTPSource is the Source of a zebra server, read with a service account
+// token. The client must not time out before the wait of a watch is over.
+type HTTPSource struct {
+	Server  string
+	Token   string
+	Factory zebra.ResourceFactory
+	Client  *http.Client
+}
+
+// NewHTTPSource returns the source of the server at the address.
+func NewHTTPSource(server string, token string, factory zebra.ResourceFactory) *HTTPSource {
+	return &HTTPSource{
+		Server:  strings.TrimSuffix(server, "/"),
+		Token:   token,
+		Factory: factory,
+		Client:  http.DefaultClient,
+	}
+}
+
+// List reads the cursor of the event stream before it lists the resources,
+// so that changes made during the list are watched again rather than lost.
+func (s *HTTPSource) List(ctx context.Context, types []string) (*zebra.ResourceMap, string, error) {
+	// A cursor after the last event is the last event
+	_, cursor, err := s.Watch(ctx, strconv.FormatUint(math.MaxUint64, 10), 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query, err := json.Marshal(map[string][]string{"types": types})
+	if err != nil {
+		return nil, "", err
+	}
+
+	resMap := zebra.NewResourceMap(s.Factory)
+	if err := s.Do(ctx, http.MethodGet, ResourcesPath, query, resMap); err != nil {
+		return nil, "", err
+	}
+
+	return resMap, cursor, nil
+}
+
+// Watch long-polls the events of the server.
+func (s *HTTPSource) Watch(ctx context.Context, cursor string, wait time.Duration,
+) ([]events.Event, string, error) {
+	params := url.Values{}
+	params.Set("since", cursor)
+
+	if wait > 0 {
+		params.Set("wait", wait.String())
+	}
+
+	page := &struct {
+		Events []events.Event `json:"events"`
+		Cursor string         `json:"cursor"`
+	}{Events: nil, Cursor: ""}
+
+	var statusErr *StatusError
+
+	err := s.Do(ctx, http.MethodGet, EventsPath+"?"+params.Encode(), nil, page)
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusGone {
+		return nil, cursor, events.ErrCursorExpired
+	} else if err != nil {
+		return nil, cursor, err
+	}
+
+	return page.Events, page.Cursor, nil
+}
+
+// Do sends a request with the JSON body to the path of the server and
+// decodes the JSON response into out, if it is not nil.
+func (s *HTTPSource) Do(ctx context.Context, method string, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.Server+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		return &StatusError{URL: req.URL.String(), Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,95 @@
+package informer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/informer"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSource(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("a", nil)
+	created, err := events.NewEvent(events.Created, lab, "")
+	assert.Nil(err)
+
+	created.Seq = 7
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		switch req.URL.Path {
+		case informer.ResourcesPath:
+			query := struct {
+				Types []string `json:"types"`
+			}{Types: nil}
+
+			assert.Nil(json.NewDecoder(req.Body).Decode(&query))
+			assert.Equal([]string{"Lab"}, query.Types)
+
+			resMap := zebra.NewResourceMap(store.DefaultFactory())
+			resMap.Add(lab, lab.Type)
+			_ = json.NewEncoder(w).Encode(resMap)
+		case informer.EventsPath:
+			page := map[string]interface{}{"events": []events.Event{}, "cursor": "7"}
+
+			switch req.URL.Query().Get("since") {
+			case "1":
+				w.WriteHeader(http.StatusGone)
+
+				return
+			case "6":
+				assert.Equal("1s", req.URL.Query().Get("wait"))
+
+				page["events"] = []events.Event{created}
+			}
+
+			_ = json.NewEncoder(w).Encode(page)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	source := informer.NewHTTPSource(server.URL+"/", "token", store.DefaultFactory())
+
+	resMap, cursor, err := source.List(ctx, []string{"Lab"})
+	assert.Nil(err)
+	assert.Equal("7", cursor)
+	assert.Equal(lab.ID, resMap.Resources["Lab"].Resources[0].GetID())
+
+	page, cursor, err := source.Watch(ctx, "6", time.Second)
+	assert.Nil(err)
+	assert.Equal("7", cursor)
+	assert.Equal(1, len(page))
+	assert.Equal(lab.ID, page[0].Resource)
+
+	_, cursor, err = source.Watch(ctx, "1", 0)
+	assert.ErrorIs(err, events.ErrCursorExpired)
+	assert.Equal("1", cursor)
+
+	source.Token = "expired"
+	_, _, err = source.Watch(ctx, "6", 0)
+
+	var statusErr *informer.StatusError
+
+	assert.True(errors.As(err, &statusErr))
+	assert.Equal(http.StatusUnauthorized, statusErr.Code)
+}
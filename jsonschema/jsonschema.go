@@ -0,0 +1,249 @@
+// Package jsonschema describes the JSON of Go values as JSON Schemas, so that
+// clients and validators in other languages can be generated from the types
+// a server uses.
+package jsonschema
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema version of the schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Enumerated is implemented by types whose JSON is one of a set of strings,
+// such as enums marshalled by name.
+type Enumerated interface {
+	EnumValues() []string
+}
+
+//nolint:gochecknoglobals
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	enumeratedType = reflect.TypeOf((*Enumerated)(nil)).Elem()
+	jsonMarshaler  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Schema is a JSON Schema. The empty schema allows any value.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+func newSchema(typ string) *Schema {
+	return &Schema{
+		Schema:               "",
+		Ref:                  "",
+		Title:                "",
+		Description:          "",
+		Type:                 typ,
+		Format:               "",
+		Enum:                 nil,
+		Properties:           nil,
+		Required:             nil,
+		AdditionalProperties: nil,
+		Items:                nil,
+		AnyOf:                nil,
+		Defs:                 nil,
+	}
+}
+
+// For returns the schema of the JSON of the value, titled with the title.
+func For(title string, v interface{}) *Schema {
+	s := newSchema("")
+
+	if v != nil {
+		g := &generator{inProgress: map[reflect.Type]bool{}}
+		s = g.schema(reflect.TypeOf(v))
+	}
+
+	s.Schema = Draft
+	s.Title = title
+
+	return s
+}
+
+// Bundle returns a schema of any of the schemas, which it defines under
+// their titles, so that all of them can be generated in one go.
+func Bundle(title string, schemas ...*Schema) *Schema {
+	b := newSchema("")
+	b.Schema = Draft
+	b.Title = title
+	b.Defs = make(map[string]*Schema, len(schemas))
+	b.AnyOf = make([]*Schema, 0, len(schemas))
+
+	for _, s := range schemas {
+		def := *s
+		def.Schema = ""
+		b.Defs[s.Title] = &def
+
+		ref := newSchema("")
+		ref.Ref = "#/$defs/" + s.Title
+		b.AnyOf = append(b.AnyOf, ref)
+	}
+
+	return b
+}
+
+type generator struct {
+	// inProgress are the structs being described, a struct that contains
+	// itself is allowed to be anything where it recurs
+	inProgress map[reflect.Type]bool
+}
+
+func implements(t reflect.Type, i reflect.Type) bool {
+	return t.Implements(i) || reflect.PtrTo(t).Implements(i)
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case implements(t, enumeratedType):
+		e, _ := reflect.New(t).Interface().(Enumerated)
+		s := newSchema("string")
+		s.Enum = e.EnumValues()
+
+		return s
+	case t == timeType:
+		s := newSchema("string")
+		s.Format = "date-time"
+
+		return s
+	case implements(t, jsonMarshaler):
+		return newSchema("")
+	case implements(t, textMarshaler):
+		return newSchema("string")
+	}
+
+	return g.kindSchema(t)
+}
+
+func (g *generator) kindSchema(t reflect.Type) *Schema {
+	switch t.Kind() { //nolint:exhaustive
+	case reflect.Bool:
+		return newSchema("boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return newSchema("integer")
+	case reflect.Float32, reflect.Float64:
+		return newSchema("number")
+	case reflect.String:
+		return newSchema("string")
+	case reflect.Slice, reflect.Array:
+		// Byte slices are base64 encoded strings
+		if t.Elem().Kind() == reflect.Uint8 {
+			s := newSchema("string")
+			s.Format = "byte"
+
+			return s
+		}
+
+		s := newSchema("array")
+		s.Items = g.schema(t.Elem())
+
+		return s
+	case reflect.Map:
+		s := newSchema("object")
+		s.AdditionalProperties = g.schema(t.Elem())
+
+		return s
+	case reflect.Struct:
+		return g.object(t)
+	default:
+		return newSchema("")
+	}
+}
+
+func (g *generator) object(t reflect.Type) *Schema {
+	if g.inProgress[t] {
+		return newSchema("")
+	}
+
+	g.inProgress[t] = true
+	defer delete(g.inProgress, t)
+
+	o := &object{schema: newSchema("object"), depths: map[string]int{}, required: map[string]bool{}, names: nil}
+	o.schema.Properties = map[string]*Schema{}
+
+	g.fields(t, o, 0)
+
+	for _, name := range o.names {
+		if o.required[name] {
+			o.schema.Required = append(o.schema.Required, name)
+		}
+	}
+
+	return o.schema
+}
+
+// object is a struct schema being described.
+type object struct {
+	schema *Schema
+	// depths are the depths of embedding of the fields described, as with
+	// encoding/json the shallowest of fields of the same name wins
+	depths   map[string]int
+	required map[string]bool
+	names    []string
+}
+
+func (g *generator) fields(t reflect.Type, o *object, depth int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, o, depth+1)
+
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if d, ok := o.depths[name]; ok && d <= depth {
+			continue
+		} else if !ok {
+			o.names = append(o.names, name)
+		}
+
+		o.depths[name] = depth
+		o.schema.Properties[name] = g.schema(f.Type)
+		o.required[name] = !strings.Contains(","+opts+",", ",omitempty,")
+	}
+}
@@ -0,0 +1,88 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/jsonschema"
+	"github.com/stretchr/testify/assert"
+)
+
+type color int
+
+func (c color) EnumValues() []string {
+	return []string{"red", "green"}
+}
+
+type base struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type node struct {
+	base
+	Name     int               `json:"name,omitempty"`
+	IP       net.IP            `json:"ip"`
+	Color    color             `json:"color"`
+	Created  time.Time         `json:"created"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Children []*node           `json:"children,omitempty"`
+	Data     []byte            `json:"data,omitempty"`
+	Any      interface{}       `json:"any,omitempty"`
+	Raw      json.RawMessage   `json:"raw,omitempty"`
+	Skipped  string            `json:"-"`
+	Untagged float64
+	hidden   bool
+}
+
+func TestFor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := jsonschema.For("Node", &node{})
+	assert.Equal(jsonschema.Draft, s.Schema)
+	assert.Equal("Node", s.Title)
+	assert.Equal("object", s.Type)
+
+	// Embedded fields are promoted, and shadowed by fields of the same name
+	assert.Equal("string", s.Properties["id"].Type)
+	assert.Equal("integer", s.Properties["name"].Type)
+	assert.Equal([]string{"id", "ip", "color", "created", "Untagged"}, s.Required)
+
+	assert.Equal("string", s.Properties["ip"].Type)
+	assert.Equal([]string{"red", "green"}, s.Properties["color"].Enum)
+	assert.Equal("date-time", s.Properties["created"].Format)
+	assert.Equal("string", s.Properties["labels"].AdditionalProperties.Type)
+	assert.Equal("byte", s.Properties["data"].Format)
+	assert.Equal("number", s.Properties["Untagged"].Type)
+	assert.Empty(s.Properties["any"].Type)
+	assert.Empty(s.Properties["raw"].Type)
+	assert.NotContains(s.Properties, "Skipped")
+	assert.NotContains(s.Properties, "hidden")
+
+	// Recursive structs are allowed to be anything where they recur
+	children := s.Properties["children"]
+	assert.Equal("array", children.Type)
+	assert.Empty(children.Items.Type)
+
+	assert.Equal(jsonschema.Draft, jsonschema.For("Nothing", nil).Schema)
+}
+
+func TestBundle(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := jsonschema.Bundle("All", jsonschema.For("Base", base{}), jsonschema.For("Node", node{}))
+	assert.Equal(jsonschema.Draft, b.Schema)
+	assert.Equal("All", b.Title)
+	assert.Len(b.Defs, 2)
+	assert.Empty(b.Defs["Node"].Schema)
+	assert.Equal("#/$defs/Base", b.AnyOf[0].Ref)
+	assert.Equal("#/$defs/Node", b.AnyOf[1].Ref)
+
+	data, err := json.Marshal(b)
+	assert.Nil(err)
+	assert.Contains(string(data), `"$defs":{"Base":{"title":"Base","type":"object"`)
+}
@@ -0,0 +1,332 @@
+// Package kv keeps small values of lab tooling, such as the current test
+// campaign or the topology variant in use, by namespace and key next to the
+// inventory. Every change gets the next revision of the store, so that
+// writers can update a value only if it did not change since they read it,
+// and watchers can ask for the changes after the last revision they saw.
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RW = os.FileMode(0o600)
+
+	// MaxValueSize is the size limit of a value, values are small bits of
+	// state, not documents.
+	MaxValueSize = 64 << 10
+
+	// MaxKeyLength limits namespaces and keys.
+	MaxKeyLength = 128
+
+	// DefaultRetention is the number of changes kept for watchers.
+	DefaultRetention = 1000
+)
+
+var (
+	ErrNotFound  = errors.New("key not found")
+	ErrKey       = errors.New("namespaces and keys must be 1 to 128 letters, digits, '.', '-' or '_'")
+	ErrValue     = errors.New("values must be JSON of at most 64KiB")
+	ErrRevision  = errors.New("key was changed since the expected revision")
+	ErrCompacted = errors.New("changes since the revision are no longer retained")
+)
+
+// Entry is the value of a key. Revision is the revision of the store at the
+// last change of the key, Created the one at which it was created. Changes
+// of deleted keys are entries without a value.
+type Entry struct {
+	Namespace  string          `json:"namespace"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Revision   uint64          `json:"revision"`
+	Created    uint64          `json:"created"`
+	Modified   time.Time       `json:"modified"`
+	ModifiedBy string          `json:"modifiedBy,omitempty"`
+	Deleted    bool            `json:"deleted,omitempty"`
+}
+
+// ValidName returns an error if the namespace or key is not valid.
+func ValidName(name string) error {
+	if name == "" || len(name) > MaxKeyLength {
+		return ErrKey
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_", c)) {
+			return ErrKey
+		}
+	}
+
+	return nil
+}
+
+// state is what is persisted.
+type state struct {
+	Revision uint64            `json:"revision"`
+	Entries  map[string]*Entry `json:"entries"`
+	Changes  []Entry           `json:"changes"`
+}
+
+// Store is a thread safe key-value store. If a path is given, the store is
+// written to that file on every change.
+type Store struct {
+	lock      sync.RWMutex
+	path      string
+	retention int
+	state     state
+	changed   chan struct{}
+}
+
+// NewStore returns a store backed by the file at path, keeping the given
+// number of changes for watchers. An empty path results in an in-memory
+// store.
+func NewStore(path string, retention int) *Store {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	return &Store{
+		lock:      sync.RWMutex{},
+		path:      path,
+		retention: retention,
+		state:     state{Revision: 0, Entries: map[string]*Entry{}, Changes: []Entry{}},
+		changed:   make(chan struct{}),
+	}
+}
+
+func entryKey(namespace string, key string) string {
+	return namespace + "/" + key
+}
+
+// Initialize loads the store from the backing file, if any.
+func (s *Store) Initialize() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	loaded := state{Revision: 0, Entries: map[string]*Entry{}, Changes: []Entry{}}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+
+	s.state = loaded
+
+	return nil
+}
+
+// Revision returns the revision of the last change.
+func (s *Store) Revision() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.state.Revision
+}
+
+// Get returns the entry of the key.
+func (s *Store) Get(namespace string, key string) (Entry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	e, ok := s.state.Entries[entryKey(namespace, key)]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	return *e, nil
+}
+
+// List returns the entries of the namespace in key order, and the revision
+// they are at.
+func (s *Store) List(namespace string) ([]Entry, uint64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entries := []Entry{}
+
+	for _, e := range s.state.Entries {
+		if e.Namespace == namespace {
+			entries = append(entries, *e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries, s.state.Revision
+}
+
+// Put sets the value of the key. If expected is not nil, the value is only
+// set if the key is at that revision, 0 for a key that must not exist yet.
+func (s *Store) Put(namespace string, key string, value json.RawMessage, actor string, expected *uint64,
+) (Entry, error) {
+	if err := validEntry(namespace, key); err != nil {
+		return Entry{}, err
+	}
+
+	if len(value) == 0 || len(value) > MaxValueSize || !json.Valid(value) {
+		return Entry{}, ErrValue
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, ok := s.state.Entries[entryKey(namespace, key)]
+	if err := checkRevision(current, expected); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		Namespace: namespace, Key: key, Value: append(json.RawMessage{}, value...), Revision: s.state.Revision + 1,
+		Created: s.state.Revision + 1, Modified: time.Now(), ModifiedBy: actor, Deleted: false,
+	}
+
+	if ok {
+		e.Created = current.Created
+	}
+
+	return e, s.apply(e)
+}
+
+// Delete removes the key. If expected is not nil, the key is only removed if
+// it is at that revision.
+func (s *Store) Delete(namespace string, key string, actor string, expected *uint64) (Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, ok := s.state.Entries[entryKey(namespace, key)]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	if err := checkRevision(current, expected); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		Namespace: namespace, Key: key, Value: nil, Revision: s.state.Revision + 1, Created: current.Created,
+		Modified: time.Now(), ModifiedBy: actor, Deleted: true,
+	}
+
+	return e, s.apply(e)
+}
+
+// Changes returns the changes of the namespace after the revision, and the
+// revision to ask for the next changes from. ErrCompacted is returned if
+// changes after the revision are no longer retained.
+func (s *Store) Changes(namespace string, since uint64) ([]Entry, uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if since > s.state.Revision {
+		since = s.state.Revision
+	}
+
+	changes := s.state.Changes
+	if since < s.state.Revision && (len(changes) == 0 || changes[0].Revision > since+1) {
+		return nil, since, ErrCompacted
+	}
+
+	page := []Entry{}
+
+	for _, e := range changes {
+		if e.Revision > since && e.Namespace == namespace {
+			page = append(page, e)
+		}
+	}
+
+	return page, s.state.Revision, nil
+}
+
+// Changed returns a channel that is closed on the next change.
+func (s *Store) Changed() <-chan struct{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.changed
+}
+
+// apply records the change, with the write lock held. The store is only
+// changed once the change is saved.
+func (s *Store) apply(e Entry) error {
+	entries := make(map[string]*Entry, len(s.state.Entries)+1)
+	for k, v := range s.state.Entries {
+		entries[k] = v
+	}
+
+	if e.Deleted {
+		delete(entries, entryKey(e.Namespace, e.Key))
+	} else {
+		entry := e
+		entries[entryKey(e.Namespace, e.Key)] = &entry
+	}
+
+	changes := s.state.Changes
+	if drop := len(changes) + 1 - s.retention; drop > 0 {
+		changes = changes[drop:]
+	}
+
+	next := state{Revision: e.Revision, Entries: entries, Changes: append(append([]Entry{}, changes...), e)}
+
+	if err := s.save(next); err != nil {
+		return err
+	}
+
+	s.state = next
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	return nil
+}
+
+func (s *Store) save(st state) error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, RW); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func validEntry(namespace string, key string) error {
+	if err := ValidName(namespace); err != nil {
+		return err
+	}
+
+	return ValidName(key)
+}
+
+func checkRevision(current *Entry, expected *uint64) error {
+	switch {
+	case expected == nil:
+		return nil
+	case current == nil && *expected != 0, current != nil && current.Revision != *expected:
+		return ErrRevision
+	}
+
+	return nil
+}
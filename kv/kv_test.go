@@ -0,0 +1,112 @@
+package kv_test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func revision(r uint64) *uint64 {
+	return &r
+}
+
+func TestValidName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Nil(kv.ValidName("campaign-2.1_a"))
+	assert.ErrorIs(kv.ValidName(""), kv.ErrKey)
+	assert.ErrorIs(kv.ValidName("a/b"), kv.ErrKey)
+	assert.ErrorIs(kv.ValidName(strings.Repeat("a", kv.MaxKeyLength+1)), kv.ErrKey)
+}
+
+func TestStore(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	path := "test_kv.json"
+
+	t.Cleanup(func() { os.Remove(path) })
+
+	s := kv.NewStore(path, 3)
+	assert.Nil(s.Initialize())
+
+	_, err := s.Put("lab", "campaign", json.RawMessage(`{`), "alice", nil)
+	assert.ErrorIs(err, kv.ErrValue)
+
+	_, err = s.Put("lab", "a/b", json.RawMessage(`1`), "alice", nil)
+	assert.ErrorIs(err, kv.ErrKey)
+
+	changed := s.Changed()
+
+	e, err := s.Put("lab", "campaign", json.RawMessage(`"spring"`), "alice", revision(0))
+	assert.Nil(err)
+	assert.Equal(uint64(1), e.Revision)
+	assert.Equal(uint64(1), e.Created)
+	assert.Equal("alice", e.ModifiedBy)
+
+	select {
+	case <-changed:
+	default:
+		assert.Fail("change not signalled")
+	}
+
+	// Keys that exist are not created again, updates need the revision
+	_, err = s.Put("lab", "campaign", json.RawMessage(`"summer"`), "bob", revision(0))
+	assert.ErrorIs(err, kv.ErrRevision)
+
+	e, err = s.Put("lab", "campaign", json.RawMessage(`"summer"`), "bob", revision(1))
+	assert.Nil(err)
+	assert.Equal(uint64(2), e.Revision)
+	assert.Equal(uint64(1), e.Created)
+
+	_, err = s.Put("other", "topology", json.RawMessage(`{"variant":"b"}`), "bob", nil)
+	assert.Nil(err)
+
+	entries, rev := s.List("lab")
+	assert.Equal(uint64(3), rev)
+	assert.Equal(1, len(entries))
+	assert.JSONEq(`"summer"`, string(entries[0].Value))
+
+	_, err = s.Delete("lab", "campaign", "bob", revision(1))
+	assert.ErrorIs(err, kv.ErrRevision)
+
+	_, err = s.Delete("lab", "missing", "bob", nil)
+	assert.ErrorIs(err, kv.ErrNotFound)
+
+	e, err = s.Delete("lab", "campaign", "bob", nil)
+	assert.Nil(err)
+	assert.True(e.Deleted)
+
+	_, err = s.Get("lab", "campaign")
+	assert.ErrorIs(err, kv.ErrNotFound)
+
+	// Three changes are kept
+	changes, rev, err := s.Changes("lab", 1)
+	assert.Nil(err)
+	assert.Equal(uint64(4), rev)
+	assert.Equal(2, len(changes))
+	assert.Equal(uint64(2), changes[0].Revision)
+	assert.True(changes[1].Deleted)
+
+	_, _, err = s.Changes("lab", 0)
+	assert.ErrorIs(err, kv.ErrCompacted)
+
+	changes, rev, err = s.Changes("lab", 10)
+	assert.Nil(err)
+	assert.Equal(uint64(4), rev)
+	assert.Empty(changes)
+
+	// The store is loaded from its file
+	loaded := kv.NewStore(path, 3)
+	assert.Nil(loaded.Initialize())
+	assert.Equal(uint64(4), loaded.Revision())
+
+	e, err = loaded.Get("other", "topology")
+	assert.Nil(err)
+	assert.JSONEq(`{"variant":"b"}`, string(e.Value))
+}
@@ -4,10 +4,28 @@ import (
 	"github.com/project-safari/zebra"
 )
 
+// DeleteGCBudget is the number of label keys whose buckets are collected
+// with every delete, so that the index is collected incrementally.
+const DeleteGCBudget = 4
+
+// minSlots is the capacity of a bucket below which it is never shrunk.
+const minSlots = 8
+
 type LabelStore struct {
 	factory   zebra.ResourceFactory
 	uuids     map[string]zebra.Resource
 	resources map[string]*zebra.ResourceMap
+	gcKeys    []string
+}
+
+// Stats is the size of the label index: the label keys, the values of all
+// keys, the resources indexed under all values and the slots allocated for
+// them.
+type Stats struct {
+	Keys    int `json:"keys"`
+	Values  int `json:"values"`
+	Entries int `json:"entries"`
+	Slots   int `json:"slots"`
 }
 
 // Return new label store pointer given resource map.
@@ -26,6 +44,7 @@ func NewLabelStore(resources *zebra.ResourceMap) *LabelStore {
 			return ret
 		}(),
 		resources: makeLabelMap(resources),
+		gcKeys:    nil,
 	}
 
 	return labelstore
@@ -56,6 +75,7 @@ func (ls *LabelStore) Initialize() error {
 func (ls *LabelStore) Wipe() error {
 	ls.resources = nil
 	ls.uuids = nil
+	ls.gcKeys = nil
 
 	return nil
 }
@@ -63,6 +83,7 @@ func (ls *LabelStore) Wipe() error {
 func (ls *LabelStore) Clear() error {
 	ls.resources = make(map[string]*zebra.ResourceMap)
 	ls.uuids = make(map[string]zebra.Resource)
+	ls.gcKeys = nil
 
 	return nil
 }
@@ -130,9 +151,101 @@ func (ls *LabelStore) Delete(res zebra.Resource) error {
 
 	delete(ls.uuids, res.GetID())
 
+	ls.GC(DeleteGCBudget)
+
 	return nil
 }
 
+// Stats returns the size of the index.
+func (ls *LabelStore) Stats() Stats {
+	stats := Stats{Keys: len(ls.resources), Values: 0, Entries: 0, Slots: 0}
+
+	for _, valMap := range ls.resources {
+		stats.Values += len(valMap.Resources)
+
+		for _, l := range valMap.Resources {
+			if l != nil {
+				stats.Entries += len(l.Resources)
+				stats.Slots += cap(l.Resources)
+			}
+		}
+	}
+
+	return stats
+}
+
+// Values returns the values of every label key in the index.
+func (ls *LabelStore) Values() map[string][]string {
+	values := make(map[string][]string, len(ls.resources))
+
+	for key, valMap := range ls.resources {
+		for val := range valMap.Resources {
+			values[key] = append(values[key], val)
+		}
+	}
+
+	return values
+}
+
+// GC collects the buckets of at most budget label keys, continuing with the
+// keys after those of the previous call. Empty value buckets and label keys
+// without values are removed, buckets that shrank to less than half of their
+// capacity are reallocated. The number of buckets collected is returned.
+func (ls *LabelStore) GC(budget int) int {
+	if len(ls.gcKeys) == 0 {
+		ls.gcKeys = make([]string, 0, len(ls.resources))
+		for key := range ls.resources {
+			ls.gcKeys = append(ls.gcKeys, key)
+		}
+	}
+
+	collected := 0
+
+	for ; budget > 0 && len(ls.gcKeys) != 0; budget-- {
+		collected += ls.collect(ls.gcKeys[0])
+		ls.gcKeys = ls.gcKeys[1:]
+	}
+
+	return collected
+}
+
+// Compact collects the buckets of all label keys.
+func (ls *LabelStore) Compact() int {
+	ls.gcKeys = nil
+
+	return ls.GC(len(ls.resources))
+}
+
+func (ls *LabelStore) collect(key string) int {
+	valMap := ls.resources[key]
+	if valMap == nil {
+		return 0
+	}
+
+	collected := 0
+
+	for val, l := range valMap.Resources {
+		switch {
+		case l == nil || len(l.Resources) == 0:
+			delete(valMap.Resources, val)
+
+			collected++
+		case cap(l.Resources) > minSlots && cap(l.Resources) > 2*len(l.Resources):
+			l.Resources = append(make([]zebra.Resource, 0, len(l.Resources)), l.Resources...)
+
+			collected++
+		}
+	}
+
+	if len(valMap.Resources) == 0 {
+		delete(ls.resources, key)
+
+		collected++
+	}
+
+	return collected
+}
+
 // Return all resources of given label - label value pairs in a ResourceMap.
 func (ls *LabelStore) Query(query zebra.Query) *zebra.ResourceMap {
 	if query.Op == zebra.MatchEqual || query.Op == zebra.MatchIn {
@@ -142,6 +255,32 @@ func (ls *LabelStore) Query(query zebra.Query) *zebra.ResourceMap {
 	return ls.labelMatch(query, false)
 }
 
+// Cardinality returns the number of resources the query matches, without
+// collecting them.
+func (ls *LabelStore) Cardinality(query zebra.Query) int {
+	valMap := ls.resources[query.Key]
+	if valMap == nil {
+		return 0
+	}
+
+	count := 0
+	total := 0
+
+	for val, l := range valMap.Resources {
+		if zebra.IsIn(val, query.Values) {
+			count += len(l.Resources)
+		}
+
+		total += len(l.Resources)
+	}
+
+	if query.Op == zebra.MatchEqual || query.Op == zebra.MatchIn {
+		return count
+	}
+
+	return total - count
+}
+
 func (ls *LabelStore) labelMatch(query zebra.Query, inVals bool) *zebra.ResourceMap {
 	results := zebra.NewResourceMap(ls.factory)
 
@@ -159,6 +298,10 @@ func (ls *LabelStore) labelMatch(query zebra.Query, inVals bool) *zebra.Resource
 		return results
 	}
 
+	if ls.resources[query.Key] == nil {
+		return results
+	}
+
 	for val, valMap := range ls.resources[query.Key].Resources {
 		if !zebra.IsIn(val, query.Values) {
 			for _, res := range valMap.Resources {
@@ -1,11 +1,13 @@
 package labelstore_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/labelstore"
 	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -216,3 +218,82 @@ func getVLAN() *network.VLANPool {
 		RangeEnd:     1,
 	}
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		s := labelstore.NewLabelStore(zebra.NewResourceMap(storetest.Factory()))
+		assert.Nil(t, s.Initialize())
+
+		return s
+	})
+}
+
+func TestCardinality(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resMap := zebra.NewResourceMap(nil)
+
+	for _, color := range []string{"red", "red", "blue"} {
+		vlan := getVLAN()
+		vlan.Labels = zebra.Labels{"color": color}
+		resMap.Add(vlan, "VLANPool")
+	}
+
+	ls := labelstore.NewLabelStore(resMap)
+
+	for count, query := range map[int]zebra.Query{
+		2: {Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}},
+		3: {Key: "color", Op: zebra.MatchIn, Values: []string{"red", "blue"}},
+		1: {Key: "color", Op: zebra.MatchNotEqual, Values: []string{"red"}},
+		0: {Key: "shape", Op: zebra.MatchNotIn, Values: []string{"round"}},
+	} {
+		assert.Equal(count, ls.Cardinality(query))
+
+		matched := 0
+		for _, l := range ls.Query(query).Resources {
+			matched += len(l.Resources)
+		}
+
+		assert.Equal(count, matched)
+	}
+}
+
+func TestGC(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ls := labelstore.NewLabelStore(zebra.NewResourceMap(nil))
+	vlans := []*network.VLANPool{}
+
+	for i := 0; i < 64; i++ {
+		vlan := getVLAN()
+		vlan.ID = fmt.Sprintf("vlan%d", i)
+		vlan.Labels = zebra.Labels{"color": "red"}
+		vlans = append(vlans, vlan)
+
+		assert.Nil(ls.Create(vlan))
+	}
+
+	stats := ls.Stats()
+	assert.Equal(labelstore.Stats{Keys: 1, Values: 1, Entries: 64, Slots: stats.Slots}, stats)
+	assert.GreaterOrEqual(stats.Slots, 64)
+
+	// Deletes collect the buckets as they go
+	for _, vlan := range vlans[1:] {
+		assert.Nil(ls.Delete(vlan))
+	}
+
+	stats = ls.Stats()
+	assert.Equal(1, stats.Entries)
+	assert.Less(stats.Slots, 64)
+
+	assert.Nil(ls.Delete(vlans[0]))
+	assert.Equal(labelstore.Stats{Keys: 0, Values: 0, Entries: 0, Slots: 0}, ls.Stats())
+	assert.Equal(0, ls.Compact())
+	assert.Equal(0, ls.GC(1))
+}
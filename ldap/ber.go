@@ -0,0 +1,162 @@
+package ldap
+
+import (
+	"bufio"
+	"io"
+)
+
+// BER tags of the universal types LDAP messages are made of.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+const (
+	highBit        = 0x80
+	lowBits        = 0x7f
+	bitsPerByte    = 8
+	maxLengthBytes = 4
+)
+
+// next splits the first TLV off the data, returning its tag, content and
+// the rest of the data.
+func next(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 { //nolint:gomnd
+		return 0, nil, nil, ErrProtocol
+	}
+
+	tag, length, data := data[0], int(data[1]), data[2:]
+
+	if length&highBit != 0 {
+		n := length & lowBits
+		if n == 0 || n > maxLengthBytes || len(data) < n {
+			return 0, nil, nil, ErrProtocol
+		}
+
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<bitsPerByte | int(b)
+		}
+
+		data = data[n:]
+	}
+
+	if length < 0 || length > len(data) {
+		return 0, nil, nil, ErrProtocol
+	}
+
+	return tag, data[:length], data[length:], nil
+}
+
+// nextTagged splits the first TLV off the data if it has the tag.
+func nextTagged(data []byte, tag byte) ([]byte, []byte, error) {
+	t, content, rest, err := next(data)
+	if err != nil || t != tag {
+		return nil, nil, ErrProtocol
+	}
+
+	return content, rest, nil
+}
+
+// readTLV reads a whole TLV from the reader.
+func readTLV(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2) //nolint:gomnd
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+
+	if length&highBit != 0 {
+		n := length & lowBits
+		if n == 0 || n > maxLengthBytes {
+			return nil, ErrProtocol
+		}
+
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+
+		header = append(header, lengthBytes...)
+
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<bitsPerByte | int(b)
+		}
+	}
+
+	if length < 0 {
+		return nil, ErrProtocol
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+
+	return append(header, content...), nil
+}
+
+func decodeInt(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > bitsPerByte {
+		return 0, ErrProtocol
+	}
+
+	var v int64
+	if content[0]&highBit != 0 {
+		v = -1
+	}
+
+	for _, b := range content {
+		v = v<<bitsPerByte | int64(b)
+	}
+
+	return v, nil
+}
+
+func tlv(tag byte, content ...[]byte) []byte {
+	body := []byte{}
+	for _, c := range content {
+		body = append(body, c...)
+	}
+
+	out := []byte{tag}
+
+	if len(body) < highBit {
+		out = append(out, byte(len(body)))
+	} else {
+		length := []byte{}
+		for n := len(body); n > 0; n >>= bitsPerByte {
+			length = append([]byte{byte(n)}, length...)
+		}
+
+		out = append(out, highBit|byte(len(length)))
+		out = append(out, length...)
+	}
+
+	return append(out, body...)
+}
+
+func encodeInt(v int64) []byte {
+	out := []byte{byte(v)}
+
+	for v >>= bitsPerByte; v != 0 && v != -1; v >>= bitsPerByte {
+		out = append([]byte{byte(v)}, out...)
+	}
+
+	// The sign bit must match the sign
+	if (v == 0) != (out[0]&highBit == 0) {
+		out = append([]byte{byte(v)}, out...)
+	}
+
+	return out
+}
+
+func octetString(s string) []byte {
+	return tlv(tagOctetString, []byte(s))
+}
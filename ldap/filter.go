@@ -0,0 +1,188 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Context specific tags of search filters.
+const (
+	filterAnd       = 0xa0
+	filterOr        = 0xa1
+	filterNot       = 0xa2
+	filterEquality  = 0xa3
+	filterSubstring = 0xa4
+	filterPresent   = 0x87
+
+	substringInitial = 0x80
+	substringAny     = 0x81
+	substringFinal   = 0x82
+)
+
+// EscapeFilter escapes the special characters of a filter value, so that
+// values such as user input match literally.
+func EscapeFilter(value string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// encodeFilter encodes a filter in the string form of RFC 4515, such as
+// (&(objectClass=person)(mail=*@example.com)). Equality, presence,
+// substring, and, or and not filters are supported.
+func encodeFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != "" {
+		return nil, fmt.Errorf("%w: trailing %q", ErrFilter, rest)
+	}
+
+	return encoded, nil
+}
+
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("%w: expected ( at %q", ErrFilter, s)
+	}
+
+	s = s[1:]
+
+	if s == "" {
+		return nil, "", fmt.Errorf("%w: unterminated filter", ErrFilter)
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+
+		s = s[1:]
+		filters := [][]byte{}
+
+		for strings.HasPrefix(s, "(") {
+			f, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+
+			filters = append(filters, f)
+			s = rest
+		}
+
+		if len(filters) == 0 || !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("%w: bad filter list at %q", ErrFilter, s)
+		}
+
+		return tlv(tag, filters...), s[1:], nil
+	case '!':
+		f, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("%w: unterminated not filter", ErrFilter)
+		}
+
+		return tlv(filterNot, f), rest[1:], nil
+	}
+
+	item, rest, ok := strings.Cut(s, ")")
+	if !ok {
+		return nil, "", fmt.Errorf("%w: unterminated filter", ErrFilter)
+	}
+
+	encoded, err := encodeItem(item)
+
+	return encoded, rest, err
+}
+
+// encodeItem encodes an attribute assertion such as mail=*@example.com.
+func encodeItem(item string) ([]byte, error) {
+	attr, value, ok := strings.Cut(item, "=")
+	if !ok || attr == "" || strings.ContainsAny(attr, "<>~:") {
+		return nil, fmt.Errorf("%w: unsupported assertion %q", ErrFilter, item)
+	}
+
+	if value == "*" {
+		return tlv(filterPresent, []byte(attr)), nil
+	}
+
+	parts := strings.Split(value, "*")
+	for i, p := range parts {
+		unescaped, err := unescapeValue(p)
+		if err != nil {
+			return nil, err
+		}
+
+		parts[i] = unescaped
+	}
+
+	if len(parts) == 1 {
+		return tlv(filterEquality, octetString(attr), octetString(parts[0])), nil
+	}
+
+	substrings := [][]byte{}
+
+	for i, p := range parts {
+		tag := byte(substringAny)
+
+		switch {
+		case p == "":
+			continue
+		case i == 0:
+			tag = substringInitial
+		case i == len(parts)-1:
+			tag = substringFinal
+		}
+
+		substrings = append(substrings, tlv(tag, []byte(p)))
+	}
+
+	return tlv(filterSubstring, octetString(attr), tlv(tagSequence, substrings...)), nil
+}
+
+func unescapeValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+
+			continue
+		}
+
+		if i+3 > len(value) {
+			return "", fmt.Errorf("%w: bad escape in %q", ErrFilter, value)
+		}
+
+		c, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("%w: bad escape in %q", ErrFilter, value)
+		}
+
+		b.Write(c)
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,361 @@
+// Package ldap is a minimal LDAP client to look up the users and groups of a
+// directory server.
+//
+// Only what directory lookups need is implemented: simple binds and searches
+// of LDAPv3, in the clear or over TLS, with the equality, presence,
+// substring, and, or and not filters of RFC 4515. Referrals are ignored.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default ports of ldap:// and ldaps:// URLs.
+const (
+	DefaultPort    = "389"
+	DefaultTLSPort = "636"
+)
+
+// Version is the LDAP protocol version of the client.
+const Version = 3
+
+// Tags of the protocol operations.
+const (
+	opBindRequest     = 0x60
+	opBindResponse    = 0x61
+	opUnbindRequest   = 0x42
+	opSearchRequest   = 0x63
+	opSearchEntry     = 0x64
+	opSearchDone      = 0x65
+	opSearchReference = 0x73
+	authSimple        = 0x80
+)
+
+// Search scopes and alias dereferencing.
+const (
+	scopeWholeSubtree = 2
+	derefNever        = 0
+)
+
+// ResultSuccess is the result code of successful operations.
+const ResultSuccess = 0
+
+var (
+	ErrURL      = errors.New("invalid LDAP URL")
+	ErrProtocol = errors.New("malformed LDAP message")
+	ErrFilter   = errors.New("invalid LDAP filter")
+)
+
+// Error is an operation that did not succeed, with the result code and the
+// diagnostic message of the server.
+type Error struct {
+	Code    int64
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap result %d: %s", e.Code, e.Message)
+}
+
+// Entry is an entry found by a search. Attribute names are lower case.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of the attribute.
+func (e *Entry) Values(attr string) []string {
+	return e.Attributes[strings.ToLower(attr)]
+}
+
+// Value returns the first value of the attribute, or "".
+func (e *Entry) Value(attr string) string {
+	if v := e.Values(attr); len(v) != 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// Conn is a connection to a directory server. Operations are sent one at a
+// time.
+type Conn struct {
+	lock    sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	id      int64
+	timeout time.Duration
+}
+
+// Dial connects to the server of the URL, ldap://host:port or
+// ldaps://host:port. Operations time out after the timeout, if it is not
+// zero.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrURL, rawURL)
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostPort(u, DefaultPort))
+	case "ldaps":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, DefaultTLSPort), tlsConfig)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrURL, rawURL)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, timeout), nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// NewConn returns an LDAP connection over the network connection.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{lock: sync.Mutex{}, conn: conn, reader: bufio.NewReader(conn), id: 0, timeout: timeout}
+}
+
+// Close unbinds and closes the connection.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_ = c.send(tlv(opUnbindRequest))
+
+	return c.conn.Close()
+}
+
+// Bind authenticates the connection as the DN with the password.
+func (c *Conn) Bind(dn string, password string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	req := tlv(opBindRequest, tlv(tagInteger, encodeInt(Version)), octetString(dn), tlv(authSimple, []byte(password)))
+	if err := c.send(req); err != nil {
+		return err
+	}
+
+	tag, content, err := c.receive()
+	if err != nil {
+		return err
+	}
+
+	if tag != opBindResponse {
+		return ErrProtocol
+	}
+
+	return decodeResult(content)
+}
+
+// Search returns the entries under the base DN that match the filter, with
+// the attributes, all of them if none are given.
+func (c *Conn) Search(base string, filter string, attributes []string) ([]*Entry, error) {
+	f, err := encodeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([][]byte, 0, len(attributes))
+	for _, a := range attributes {
+		attrs = append(attrs, octetString(a))
+	}
+
+	req := tlv(opSearchRequest,
+		octetString(base),
+		tlv(tagEnumerated, encodeInt(scopeWholeSubtree)),
+		tlv(tagEnumerated, encodeInt(derefNever)),
+		tlv(tagInteger, encodeInt(0)),
+		tlv(tagInteger, encodeInt(0)),
+		tlv(tagBoolean, []byte{0}),
+		f,
+		tlv(tagSequence, attrs...))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+
+	for {
+		tag, content, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case opSearchEntry:
+			entry, err := decodeEntry(content)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry)
+		case opSearchReference:
+			continue
+		case opSearchDone:
+			return entries, decodeResult(content)
+		default:
+			return nil, ErrProtocol
+		}
+	}
+}
+
+// send sends the protocol operation in a message of the next ID.
+func (c *Conn) send(op []byte) error {
+	c.id++
+
+	if c.timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.conn.Write(tlv(tagSequence, tlv(tagInteger, encodeInt(c.id)), op))
+
+	return err
+}
+
+// receive reads the next message of the current ID and returns the tag and
+// the content of its protocol operation.
+func (c *Conn) receive() (byte, []byte, error) {
+	for {
+		msg, err := readTLV(c.reader)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		content, _, err := nextTagged(msg, tagSequence)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		idContent, rest, err := nextTagged(content, tagInteger)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		id, err := decodeInt(idContent)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		tag, op, _, err := next(rest)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Unsolicited notifications have ID 0, they are ignored
+		if id == c.id {
+			return tag, op, nil
+		}
+	}
+}
+
+// decodeResult returns the error of an LDAP result, nil if it succeeded.
+func decodeResult(content []byte) error {
+	code, rest, err := nextTagged(content, tagEnumerated)
+	if err != nil {
+		return err
+	}
+
+	result, err := decodeInt(code)
+	if err != nil {
+		return err
+	}
+
+	if result == ResultSuccess {
+		return nil
+	}
+
+	_, rest, err = nextTagged(rest, tagOctetString)
+	if err != nil {
+		return err
+	}
+
+	message, _, err := nextTagged(rest, tagOctetString)
+	if err != nil {
+		return err
+	}
+
+	return &Error{Code: result, Message: string(message)}
+}
+
+func decodeEntry(content []byte) (*Entry, error) {
+	dn, rest, err := nextTagged(content, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, _, err := nextTagged(rest, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{DN: string(dn), Attributes: map[string][]string{}}
+
+	for len(attrs) != 0 {
+		var attr []byte
+
+		if attr, attrs, err = nextTagged(attrs, tagSequence); err != nil {
+			return nil, err
+		}
+
+		name, rest, err := nextTagged(attr, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+
+		vals, _, err := nextTagged(rest, tagSet)
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.ToLower(string(name))
+
+		for len(vals) != 0 {
+			var v []byte
+
+			if v, vals, err = nextTagged(vals, tagOctetString); err != nil {
+				return nil, err
+			}
+
+			entry.Attributes[key] = append(entry.Attributes[key], string(v))
+		}
+	}
+
+	return entry, nil
+}
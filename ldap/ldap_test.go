@@ -0,0 +1,144 @@
+package ldap //nolint:testpackage
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for filter, encoded := range map[string]string{
+		"(cn=Babs)":             "a30a0402636e040442616273",
+		"(mail=*)":              "87046d61696c",
+		"(!(cn=Babs))":          "a20ca30a0402636e040442616273",
+		"(&(cn=Babs)(mail=*))":  "a012a30a0402636e04044261627387046d61696c",
+		"(|(cn=a*b*c))":         "a111a40f0402636e3009800161810162820163",
+		"(cn=\\2a\\28)":         "a3080402636e04022a28",
+		"(cn=b*)":               "a4090402636e3003800162",
+		"(&(objectClass=user))": "a015a313040b6f626a656374436c617373040475736572",
+	} {
+		f, err := encodeFilter(filter)
+		assert.Nil(err, filter)
+		assert.Equal(encoded, hex.EncodeToString(f), filter)
+	}
+
+	for _, bad := range []string{"", "cn=a", "(cn=a", "(cn>=a)", "(&)", "(cn=a))", "(cn=\\2)", "(=a)", "(!(cn=a)"} {
+		_, err := encodeFilter(bad)
+		assert.ErrorIs(err, ErrFilter, bad)
+	}
+
+	assert.Equal("a\\2a\\28\\29\\5c", EscapeFilter("a*()\\"))
+}
+
+// fakeServer answers binds with the password "secret" and searches with
+// the entries.
+func fakeServer(conn net.Conn, entries []*Entry, filters chan<- []byte) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(id []byte, op []byte) {
+		_, _ = conn.Write(tlv(tagSequence, tlv(tagInteger, id), op))
+	}
+	result := func(code int64) []byte {
+		return append(append(tlv(tagEnumerated, encodeInt(code)), octetString("")...), octetString("denied")...)
+	}
+
+	for {
+		msg, err := readTLV(reader)
+		if err != nil {
+			return
+		}
+
+		content, _, _ := nextTagged(msg, tagSequence)
+		id, rest, _ := nextTagged(content, tagInteger)
+		tag, op, _, _ := next(rest)
+
+		switch tag {
+		case opBindRequest:
+			_, rest, _ := nextTagged(op, tagInteger)
+			_, rest, _ = nextTagged(rest, tagOctetString)
+			password, _, _ := nextTagged(rest, authSimple)
+
+			code := int64(49)
+			if string(password) == "secret" {
+				code = ResultSuccess
+			}
+
+			reply(id, tlv(opBindResponse, result(code)))
+		case opSearchRequest:
+			rest := op
+			for i := 0; i < 6; i++ {
+				_, _, rest, _ = next(rest)
+			}
+
+			tag, filter, _, _ := next(rest)
+			filters <- tlv(tag, filter)
+
+			// Replies to other messages are skipped
+			reply(encodeInt(99), tlv(opSearchDone, result(ResultSuccess)))
+
+			for _, e := range entries {
+				attrs := [][]byte{}
+				for name, values := range e.Attributes {
+					vals := [][]byte{}
+					for _, v := range values {
+						vals = append(vals, octetString(v))
+					}
+
+					attrs = append(attrs, tlv(tagSequence, octetString(name), tlv(tagSet, vals...)))
+				}
+
+				reply(id, tlv(opSearchEntry, octetString(e.DN), tlv(tagSequence, attrs...)))
+			}
+
+			reply(id, tlv(opSearchReference, octetString("ldap://elsewhere")))
+			reply(id, tlv(opSearchDone, result(ResultSuccess)))
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	client, server := net.Pipe()
+	filters := make(chan []byte, 1)
+	entries := []*Entry{
+		{DN: "uid=ann,dc=zebra", Attributes: map[string][]string{"mail": {"ann@zebra"}, "cn": {"Ann"}}},
+		{DN: "uid=bob,dc=zebra", Attributes: map[string][]string{"mail": {"bob@zebra", "robert@zebra"}}},
+	}
+
+	go fakeServer(server, entries, filters)
+
+	conn := NewConn(client, time.Second)
+
+	err := conn.Bind("cn=admin,dc=zebra", "wrong")
+	assert.Equal(&Error{Code: 49, Message: "denied"}, err)
+	assert.Nil(conn.Bind("cn=admin,dc=zebra", "secret"))
+
+	found, err := conn.Search("dc=zebra", "(mail=*)", []string{"mail", "cn"})
+	assert.Nil(err)
+	assert.Equal("87046d61696c", hex.EncodeToString(<-filters))
+	assert.Equal(2, len(found))
+	assert.Equal("ann@zebra", found[0].Value("MAIL"))
+	assert.Equal("Ann", found[0].Value("cn"))
+	assert.Equal([]string{"bob@zebra", "robert@zebra"}, found[1].Values("mail"))
+	assert.Empty(found[1].Value("cn"))
+
+	_, err = conn.Search("dc=zebra", "(mail=", nil)
+	assert.ErrorIs(err, ErrFilter)
+
+	assert.Nil(conn.Close())
+
+	_, err = Dial("http://zebra", nil, time.Second)
+	assert.ErrorIs(err, ErrURL)
+}
@@ -0,0 +1,38 @@
+package lease
+
+import "time"
+
+// Length returns the duration of the lease with its extensions.
+func (l *Lease) Length() time.Duration {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.Duration + l.Extension
+}
+
+// Expiry returns the time the lease ends, extensions included.
+func (l *Lease) Expiry() time.Time {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.ActivationTime.Add(l.Duration + l.Extension)
+}
+
+// Extend adds the duration to the extensions of the lease. Unlike the
+// duration, extensions are not bound by the maximum lease duration, they
+// are granted by the server within its own limits.
+func (l *Lease) Extend(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.Extension += d
+}
+
+// Renew extends the lease by its duration and counts the renewal.
+func (l *Lease) Renew() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.Extension += l.Duration
+	l.Renewals++
+}
@@ -0,0 +1,44 @@
+package lease //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtend(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	l := getLease()
+	l.ActivationTime = time.Now().Add(-5 * time.Hour)
+	assert.Equal(4*time.Hour, l.Length())
+	assert.True(l.IsExpired())
+
+	// Extensions are not bound by the maximum duration
+	l.Extend(2 * time.Hour)
+	assert.Equal(6*time.Hour, l.Length())
+	assert.Equal(l.ActivationTime.Add(6*time.Hour), l.Expiry())
+	assert.Nil(l.Validate(context.Background()))
+
+	l.Extension = -time.Hour
+	assert.ErrorIs(l.Validate(context.Background()), ErrExtension)
+}
+
+func TestRenew(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	l := getLease()
+	assert.Equal(DefaultNamespace, l.Namespace())
+
+	l.Renew()
+	l.Renew()
+	assert.Equal(2, l.Renewals)
+	assert.Equal(12*time.Hour, l.Length())
+
+	l.Renewals = -1
+	assert.ErrorIs(l.Validate(context.Background()), ErrExtension)
+}
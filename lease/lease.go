@@ -2,6 +2,7 @@ package lease
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ func Type() zebra.Type {
 		Name:        "Lease",
 		Description: "lease request from user",
 		Constructor: func() zebra.Resource { return new(Lease) },
+		Migrations:  nil,
 	}
 }
 
@@ -26,17 +28,34 @@ type ResourceReq struct {
 	Resources []zebra.Resource `json:"resources,omitempty"`
 }
 
+// Spread asks for the resources of a lease to be spread across at least Min
+// places of the scope, such as two racks.
+type Spread struct {
+	Scope string `json:"scope"`
+	Min   int    `json:"min"`
+}
+
 type Lease struct {
 	zebra.BaseResource
 	lock           sync.RWMutex
 	Duration       time.Duration  `json:"duration"`
 	Request        []*ResourceReq `json:"request"`
 	ActivationTime time.Time      `json:"activationTime"`
+	Priority       Priority       `json:"priority,omitempty"`
+	Preemption     *Preemption    `json:"preemption,omitempty"`
+	Placement      []string       `json:"placement,omitempty"`
+	Spread         []Spread       `json:"spread,omitempty"`
+	Extension      time.Duration  `json:"extension,omitempty"`
+	Renewals       int            `json:"renewals,omitempty"`
+	Provisioning   []ProvisionRun `json:"provisioning,omitempty"`
 }
 
 var (
 	ErrLeaseActivate = errors.New("tried to activate lease but request has not been satisfied entirely")
 	ErrLeaseValid    = errors.New("lease is not valid")
+	ErrPlacement     = errors.New("lease placement scopes must not be empty")
+	ErrSpread        = errors.New("lease spread needs a scope and a positive minimum of places")
+	ErrExtension     = errors.New("lease extension and renewals must not be negative")
 )
 
 func (r *ResourceReq) Assign(res zebra.Resource) error {
@@ -49,6 +68,28 @@ func (r *ResourceReq) Assign(res zebra.Resource) error {
 	return nil
 }
 
+// UnmarshalJSON decodes the request. The resources assigned to it can be of
+// any type, they are decoded as the base resources they are.
+func (r *ResourceReq) UnmarshalJSON(data []byte) error {
+	type request ResourceReq
+
+	v := struct {
+		*request
+		Resources []*zebra.BaseResource `json:"resources,omitempty"`
+	}{request: (*request)(r), Resources: nil}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	r.Resources = nil
+	for _, res := range v.Resources {
+		r.Resources = append(r.Resources, res)
+	}
+
+	return nil
+}
+
 func (r *ResourceReq) IsSatisfied() bool {
 	return len(r.Resources) == r.Count
 }
@@ -58,7 +99,7 @@ func NewLease(userEmail string, dur time.Duration, req []*ResourceReq) *Lease {
 	// Set default values, don't set activation time yet
 	l := &Lease{
 		lock:           sync.RWMutex{},
-		BaseResource:   *zebra.NewBaseResource("Lease", map[string]string{"system.group": "leases"}),
+		BaseResource:   *zebra.NewBaseResource("Lease", map[string]string{"system.group": DefaultNamespace}),
 		Duration:       dur,
 		Request:        req,
 		ActivationTime: time.Time{},
@@ -69,6 +110,14 @@ func NewLease(userEmail string, dur time.Duration, req []*ResourceReq) *Lease {
 	return l
 }
 
+// Namespace returns the namespace of the lease, its system.group label.
+func (l *Lease) Namespace() string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.Labels["system.group"]
+}
+
 // Returns email of user associated with lease.
 func (l *Lease) Owner() string {
 	l.lock.RLock()
@@ -120,7 +169,7 @@ func (l *Lease) IsValid() bool {
 	defer l.lock.RUnlock()
 
 	// Return if lease has not expired yet
-	return time.Now().Before(l.ActivationTime.Add(l.Duration)) && l.Status.State == zebra.Active
+	return time.Now().Before(l.ActivationTime.Add(l.Duration+l.Extension)) && l.Status.State == zebra.Active
 }
 
 func (l *Lease) IsExpired() bool {
@@ -128,7 +177,7 @@ func (l *Lease) IsExpired() bool {
 	defer l.lock.RUnlock()
 
 	// Return if lease is expired
-	return time.Now().After(l.ActivationTime.Add(l.Duration)) || l.Status.State == zebra.Inactive
+	return time.Now().After(l.ActivationTime.Add(l.Duration+l.Extension)) || l.Status.State == zebra.Inactive
 }
 
 func (l *Lease) RequestList() []*ResourceReq {
@@ -147,9 +196,29 @@ func (l *Lease) Validate(ctx context.Context) error {
 		return ErrLeaseValid
 	}
 
+	if l.Priority < Low || l.Priority > High {
+		return ErrPriority
+	}
+
+	if l.Extension < 0 || l.Renewals < 0 {
+		return ErrExtension
+	}
+
 	if l.ActivationTime.After(time.Now()) {
 		return ErrLeaseValid
 	}
 
+	for _, scope := range l.Placement {
+		if scope == "" {
+			return ErrPlacement
+		}
+	}
+
+	for _, s := range l.Spread {
+		if s.Scope == "" || s.Min <= 0 {
+			return ErrSpread
+		}
+	}
+
 	return l.BaseResource.Validate(ctx)
 }
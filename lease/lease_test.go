@@ -94,6 +94,18 @@ func TestValidate(t *testing.T) {
 	l := getEmptyLease()
 	assert.Nil(l.Validate(context.Background()))
 
+	l.Placement = []string{"Rack", ""}
+	assert.ErrorIs(l.Validate(context.Background()), ErrPlacement)
+
+	l.Placement = []string{"Rack"}
+	assert.Nil(l.Validate(context.Background()))
+
+	l.Spread = []Spread{{Scope: "Rack", Min: 0}}
+	assert.ErrorIs(l.Validate(context.Background()), ErrSpread)
+
+	l.Spread = []Spread{{Scope: "Rack", Min: 2}}
+	assert.Nil(l.Validate(context.Background()))
+
 	dur, err := time.ParseDuration("5h")
 	assert.Nil(err)
 
@@ -0,0 +1,136 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+// DefaultNamespace is the namespace of leases that do not ask for one.
+const DefaultNamespace = "leases"
+
+var (
+	ErrPolicyDuration = errors.New("lease policy durations must be within the maximum lease duration")
+	ErrPolicyRenew    = errors.New("lease policy auto-renewals must not be negative")
+	ErrPolicyType     = errors.New("lease policy does not allow the resource type")
+	ErrPolicyMax      = errors.New("lease duration exceeds the maximum of the lease policy")
+)
+
+func PolicyType() zebra.Type {
+	return zebra.Type{
+		Name:        "LeasePolicy",
+		Description: "lease policy of a namespace",
+		Constructor: func() zebra.Resource { return new(Policy) },
+		Migrations:  nil,
+	}
+}
+
+// Policy is the lease policy of the namespace of its system.group label. It
+// sets the duration of the leases that do not ask for one, the maximum
+// duration, how many times an expiring lease is renewed for its duration if
+// no other lease waits for its resources, and the resource types leases may
+// request, all types if none are given. Policies can only tighten the
+// maximum lease duration of the server.
+type Policy struct {
+	zebra.NamedResource
+	DefaultDuration time.Duration `json:"defaultDuration,omitempty"`
+	MaxDuration     time.Duration `json:"maxDuration,omitempty"`
+	AutoRenew       int           `json:"autoRenew,omitempty"`
+	AllowedTypes    []string      `json:"allowedTypes,omitempty"`
+}
+
+// NewPolicy returns an empty lease policy of the namespace.
+func NewPolicy(name string, namespace string) *Policy {
+	return &Policy{
+		NamedResource: zebra.NamedResource{
+			BaseResource: *zebra.NewBaseResource("LeasePolicy", zebra.Labels{"system.group": namespace}),
+			Name:         name,
+		},
+		DefaultDuration: 0,
+		MaxDuration:     0,
+		AutoRenew:       0,
+		AllowedTypes:    nil,
+	}
+}
+
+// Validate returns an error if the given Policy object has incorrect values.
+func (p *Policy) Validate(ctx context.Context) error {
+	switch {
+	case p.Type != "LeasePolicy":
+		return zebra.ErrWrongType
+	case p.DefaultDuration < 0 || p.MaxDuration < 0:
+		return ErrPolicyDuration
+	case p.MaxDuration > zebra.DefaultMaxDuration*time.Hour || p.DefaultDuration > p.Max():
+		return ErrPolicyDuration
+	case p.AutoRenew < 0:
+		return ErrPolicyRenew
+	}
+
+	return p.NamedResource.Validate(ctx)
+}
+
+// Namespace returns the namespace of the policy.
+func (p *Policy) Namespace() string {
+	return p.Labels["system.group"]
+}
+
+// Max returns the maximum lease duration of the policy, which is the maximum
+// of the server if the policy has none or there is no policy.
+func (p *Policy) Max() time.Duration {
+	if p == nil || p.MaxDuration == 0 {
+		return zebra.DefaultMaxDuration * time.Hour
+	}
+
+	return p.MaxDuration
+}
+
+// Default returns the duration of leases that do not ask for one, which is
+// the maximum if the policy has none.
+func (p *Policy) Default() time.Duration {
+	if p == nil || p.DefaultDuration == 0 {
+		return p.Max()
+	}
+
+	return p.DefaultDuration
+}
+
+// Renewals returns how many times an expiring lease is renewed.
+func (p *Policy) Renewals() int {
+	if p == nil {
+		return 0
+	}
+
+	return p.AutoRenew
+}
+
+// Allows returns true if leases may request resources of the type.
+func (p *Policy) Allows(resType string) bool {
+	if p == nil || len(p.AllowedTypes) == 0 {
+		return true
+	}
+
+	for _, t := range p.AllowedTypes {
+		if t == resType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns an error if the lease breaks the policy.
+func (p *Policy) Check(l *Lease) error {
+	if l.Duration > p.Max() {
+		return ErrPolicyMax
+	}
+
+	for _, req := range l.RequestList() {
+		if !p.Allows(req.Type) {
+			return ErrPolicyType
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package lease //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var none *Policy
+
+	assert.Equal(4*time.Hour, none.Max())
+	assert.Equal(4*time.Hour, none.Default())
+	assert.Equal(0, none.Renewals())
+	assert.True(none.Allows("Server"))
+	assert.Nil(none.Check(getLease()))
+
+	p := NewPolicy("ci", "ci")
+	assert.Equal("ci", p.Namespace())
+	assert.Nil(p.Validate(context.Background()))
+
+	p.DefaultDuration = time.Hour
+	p.MaxDuration = 2 * time.Hour
+	p.AutoRenew = 3
+	p.AllowedTypes = []string{"Lab"}
+	assert.Nil(p.Validate(context.Background()))
+	assert.Equal(time.Hour, p.Default())
+	assert.Equal(3, p.Renewals())
+	assert.False(p.Allows("Server"))
+
+	// The lease asks for four hours of servers and VMs
+	l := getLease()
+	assert.ErrorIs(p.Check(l), ErrPolicyMax)
+
+	l.Duration = time.Hour
+	assert.ErrorIs(p.Check(l), ErrPolicyType)
+
+	p.AllowedTypes = append(p.AllowedTypes, "Server", "VM")
+	assert.Nil(p.Check(l))
+
+	for _, bad := range []func(p *Policy){
+		func(p *Policy) { p.MaxDuration = 5 * time.Hour },
+		func(p *Policy) { p.DefaultDuration = 3 * time.Hour },
+		func(p *Policy) { p.DefaultDuration = -time.Hour },
+	} {
+		p := NewPolicy("ci", "ci")
+		p.MaxDuration = 2 * time.Hour
+		bad(p)
+		assert.ErrorIs(p.Validate(context.Background()), ErrPolicyDuration)
+	}
+
+	p.AutoRenew = -1
+	assert.ErrorIs(p.Validate(context.Background()), ErrPolicyRenew)
+
+	p.AutoRenew = 0
+	p.Type = "Policy"
+	assert.ErrorIs(p.Validate(context.Background()), zebra.ErrWrongType)
+}
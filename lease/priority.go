@@ -0,0 +1,74 @@
+package lease
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Priority orders the requests for contended resources: leases of a higher
+// priority are satisfied first and may preempt leases of a lower priority.
+type Priority int8
+
+const (
+	Low    Priority = -1
+	Normal Priority = 0
+	High   Priority = 1
+)
+
+var ErrPriority = errors.New(`priority is incorrect, must be in ["low", "normal", "high"]`)
+
+func (p *Priority) String() string {
+	switch *p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	case High:
+		return "high"
+	}
+
+	return "unknown"
+}
+
+func (p *Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// EnumValues returns the names of the priorities, for the JSON schemas of
+// types.
+func (p Priority) EnumValues() []string {
+	return []string{"low", "normal", "high"}
+}
+
+func (p *Priority) UnmarshalText(data []byte) error {
+	pmap := map[string]Priority{
+		"low":    Low,
+		"normal": Normal,
+		"high":   High,
+	}
+
+	pval, ok := pmap[strings.ToLower(string(data))]
+	if !ok {
+		return ErrPriority
+	}
+
+	*p = pval
+
+	return nil
+}
+
+// Preemption records that a lease is preempted by another one: it ends at
+// the deadline, which gives its owner a grace period to wrap up.
+type Preemption struct {
+	By       string    `json:"by"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Preempt schedules the end of the lease for the lease with the given ID.
+func (l *Lease) Preempt(by string, deadline time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.Preemption = &Preemption{By: by, Deadline: deadline}
+}
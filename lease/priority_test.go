@@ -0,0 +1,64 @@
+package lease //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for _, p := range []Priority{Low, Normal, High} {
+		text, err := p.MarshalText()
+		assert.Nil(err)
+
+		parsed := new(Priority)
+		assert.Nil(parsed.UnmarshalText(text))
+		assert.Equal(p, *parsed)
+	}
+
+	p := Priority(7)
+	assert.Equal("unknown", p.String())
+	assert.ErrorIs(p.UnmarshalText([]byte("urgent")), ErrPriority)
+
+	l := getEmptyLease()
+	l.Priority = p
+	assert.ErrorIs(l.Validate(context.Background()), ErrPriority)
+}
+
+func TestPreempt(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	l := getLease()
+	l.Priority = High
+	deadline := time.Now().Add(time.Hour)
+	l.Preempt("other", deadline)
+	assert.Equal("other", l.Preemption.By)
+
+	assert.Nil(l.Request[0].Assign(getRes()))
+
+	data, err := json.Marshal(l)
+	assert.Nil(err)
+	assert.Contains(string(data), `"priority":"high"`)
+
+	// Leases are decoded with the resources they hold
+	decoded := new(Lease)
+	assert.Nil(json.Unmarshal(data, decoded))
+	assert.Equal(High, decoded.Priority)
+	assert.True(deadline.Equal(decoded.Preemption.Deadline))
+	assert.Equal(1, len(decoded.Request[0].Resources))
+	assert.Equal(l.Request[0].Resources[0].GetID(), decoded.Request[0].Resources[0].GetID())
+	assert.Equal("VLANPool", decoded.Request[0].Resources[0].GetType())
+	assert.Equal("Server", decoded.Request[0].Type)
+
+	// Normal leases do not mention their priority
+	data, err = json.Marshal(getEmptyLease())
+	assert.Nil(err)
+	assert.NotContains(string(data), "priority")
+}
@@ -0,0 +1,63 @@
+package lease
+
+import "time"
+
+// Events of a lease that provisioning hooks run on.
+const (
+	ProvisionGrant   = "grant"
+	ProvisionRelease = "release"
+)
+
+// States of a provisioning run.
+const (
+	ProvisionPending   = "pending"
+	ProvisionRunning   = "running"
+	ProvisionSucceeded = "succeeded"
+	ProvisionFailed    = "failed"
+)
+
+// ProvisionRun is the run of a provisioning hook for a resource of the lease
+// when the lease was granted or released, such as re-imaging a server.
+type ProvisionRun struct {
+	Hook     string    `json:"hook"`
+	Event    string    `json:"event"`
+	Resource string    `json:"resource"`
+	State    string    `json:"state"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SetProvision records the run, replacing the run of the same hook for the
+// same event and resource.
+func (l *Lease) SetProvision(run ProvisionRun) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, r := range l.Provisioning {
+		if r.Hook == run.Hook && r.Event == run.Event && r.Resource == run.Resource {
+			l.Provisioning[i] = run
+
+			return
+		}
+	}
+
+	l.Provisioning = append(l.Provisioning, run)
+}
+
+// ProvisionRuns returns the provisioning runs of the lease for the event.
+func (l *Lease) ProvisionRuns(event string) []ProvisionRun {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	runs := []ProvisionRun{}
+
+	for _, r := range l.Provisioning {
+		if r.Event == event {
+			runs = append(runs, r)
+		}
+	}
+
+	return runs
+}
@@ -0,0 +1,40 @@
+package lease //nolint:testpackage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvision(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	l := getLease()
+	run := ProvisionRun{
+		Hook: "reimage", Event: ProvisionGrant, Resource: "a", State: ProvisionPending,
+		Started: time.Time{}, Finished: time.Time{}, Output: "", Error: "",
+	}
+	l.SetProvision(run)
+
+	run.State = ProvisionRunning
+	run.Started = time.Now()
+	l.SetProvision(run)
+
+	run.Event = ProvisionRelease
+	run.State = ProvisionPending
+	l.SetProvision(run)
+
+	assert.Equal(2, len(l.Provisioning))
+	assert.Equal(ProvisionRunning, l.ProvisionRuns(ProvisionGrant)[0].State)
+	assert.Equal(1, len(l.ProvisionRuns(ProvisionRelease)))
+
+	data, err := json.Marshal(l)
+	assert.Nil(err)
+
+	decoded := new(Lease)
+	assert.Nil(json.Unmarshal(data, decoded))
+	assert.Equal(ProvisionRunning, decoded.ProvisionRuns(ProvisionGrant)[0].State)
+}
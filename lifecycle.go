@@ -0,0 +1,158 @@
+package zebra
+
+import (
+	"errors"
+)
+
+// Lifecycle is the stage of an asset, from being planned to being
+// decommissioned. Resources created before lifecycles were tracked have no
+// lifecycle, they may move to any stage.
+type Lifecycle string
+
+const (
+	LifecyclePlanned        Lifecycle = "planned"
+	LifecycleProvisioning   Lifecycle = "provisioning"
+	LifecycleActive         Lifecycle = "active"
+	LifecycleMaintenance    Lifecycle = "maintenance"
+	LifecycleDecommissioned Lifecycle = "decommissioned"
+)
+
+var (
+	ErrLifecycle = errors.New(
+		`lifecycle is incorrect, must be in ["planned", "provisioning", "active", "maintenance", "decommissioned"]`)
+	ErrLifecycleTransition = errors.New("lifecycle transition is not allowed")
+)
+
+// Lifecycles returns all lifecycle stages, in order.
+func Lifecycles() []Lifecycle {
+	return []Lifecycle{
+		LifecyclePlanned, LifecycleProvisioning, LifecycleActive, LifecycleMaintenance, LifecycleDecommissioned,
+	}
+}
+
+// EnumValues returns the names of all lifecycle stages, for the JSON schemas
+// of types. The empty lifecycle is allowed too.
+func (l Lifecycle) EnumValues() []string {
+	values := []string{""}
+	for _, known := range Lifecycles() {
+		values = append(values, string(known))
+	}
+
+	return values
+}
+
+// Validate returns an error if the lifecycle is not a known stage. The empty
+// lifecycle is valid.
+func (l Lifecycle) Validate() error {
+	if l == "" {
+		return nil
+	}
+
+	for _, known := range Lifecycles() {
+		if l == known {
+			return nil
+		}
+	}
+
+	return ErrLifecycle
+}
+
+// Transitions maps each lifecycle stage to the stages it may move to.
+type Transitions map[Lifecycle][]Lifecycle
+
+// DefaultTransitions returns the standard lifecycle: planned assets are
+// provisioned, or dropped, provisioned ones go into service, and assets in
+// service go back and forth between active and maintenance until they are
+// decommissioned for good.
+func DefaultTransitions() Transitions {
+	return Transitions{
+		LifecyclePlanned:        {LifecycleProvisioning, LifecycleDecommissioned},
+		LifecycleProvisioning:   {LifecyclePlanned, LifecycleActive, LifecycleDecommissioned},
+		LifecycleActive:         {LifecycleMaintenance, LifecycleDecommissioned},
+		LifecycleMaintenance:    {LifecycleActive, LifecycleDecommissioned},
+		LifecycleDecommissioned: {},
+	}
+}
+
+// Validate returns an error if the transitions name unknown stages.
+func (t Transitions) Validate() error {
+	for from, to := range t {
+		if from == "" {
+			return ErrLifecycle
+		}
+
+		if err := from.Validate(); err != nil {
+			return err
+		}
+
+		for _, l := range to {
+			if l == "" {
+				return ErrLifecycle
+			}
+
+			if err := l.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Allows returns true if a resource may move from one stage to the other.
+// Staying in a stage and leaving no stage are always allowed, falling back
+// to no stage never is.
+func (t Transitions) Allows(from, to Lifecycle) bool {
+	switch {
+	case from == to, from == "":
+		return true
+	case to == "":
+		return false
+	}
+
+	for _, l := range t[from] {
+		if l == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LifecyclePolicy holds the allowed transitions of each resource type, the
+// types without their own transitions follow the default ones.
+type LifecyclePolicy struct {
+	Default Transitions
+	Types   map[string]Transitions
+}
+
+// NewLifecyclePolicy returns a policy of the default transitions for all
+// types.
+func NewLifecyclePolicy() *LifecyclePolicy {
+	return &LifecyclePolicy{Default: DefaultTransitions(), Types: map[string]Transitions{}}
+}
+
+// Validate returns an error if any of the transitions name unknown stages.
+func (p *LifecyclePolicy) Validate() error {
+	if err := p.Default.Validate(); err != nil {
+		return err
+	}
+
+	for _, t := range p.Types {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Allows returns true if a resource of the type may move from one stage to
+// the other.
+func (p *LifecyclePolicy) Allows(resType string, from, to Lifecycle) bool {
+	if t, ok := p.Types[resType]; ok {
+		return t.Allows(from, to)
+	}
+
+	return p.Default.Allows(from, to)
+}
@@ -0,0 +1,48 @@
+package zebra_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for _, l := range zebra.Lifecycles() {
+		assert.Nil(l.Validate())
+	}
+
+	assert.Nil(zebra.Lifecycle("").Validate())
+	assert.Equal(zebra.ErrLifecycle, zebra.Lifecycle("retired").Validate())
+
+	transitions := zebra.DefaultTransitions()
+	assert.Nil(transitions.Validate())
+	assert.True(transitions.Allows("", zebra.LifecyclePlanned))
+	assert.True(transitions.Allows(zebra.LifecycleActive, zebra.LifecycleActive))
+	assert.True(transitions.Allows(zebra.LifecycleActive, zebra.LifecycleMaintenance))
+	assert.True(transitions.Allows(zebra.LifecycleMaintenance, zebra.LifecycleActive))
+	assert.False(transitions.Allows(zebra.LifecycleActive, zebra.LifecyclePlanned))
+	assert.False(transitions.Allows(zebra.LifecycleActive, ""))
+	assert.False(transitions.Allows(zebra.LifecycleDecommissioned, zebra.LifecycleActive))
+
+	assert.NotNil(zebra.Transitions{"retired": nil}.Validate())
+	assert.NotNil(zebra.Transitions{zebra.LifecycleActive: {""}}.Validate())
+
+	policy := zebra.NewLifecyclePolicy()
+	policy.Types["Server"] = zebra.Transitions{zebra.LifecyclePlanned: {zebra.LifecycleActive}}
+	assert.Nil(policy.Validate())
+	assert.True(policy.Allows("Server", zebra.LifecyclePlanned, zebra.LifecycleActive))
+	assert.False(policy.Allows("Server", zebra.LifecyclePlanned, zebra.LifecycleProvisioning))
+	assert.True(policy.Allows("Switch", zebra.LifecyclePlanned, zebra.LifecycleProvisioning))
+
+	policy.Types["Switch"] = zebra.Transitions{"retired": nil}
+	assert.NotNil(policy.Validate())
+
+	status := zebra.DefaultStatus()
+	status.Lifecycle = "retired"
+	assert.Equal(zebra.ErrLifecycle, status.Validate(context.Background()))
+}
@@ -0,0 +1,78 @@
+// Package memstore keeps resources in memory only, for stores that need not
+// outlive the process, such as those of demos and tests.
+package memstore
+
+import (
+	"sync"
+
+	"github.com/project-safari/zebra"
+)
+
+// MemStore keeps the resources in a map by ID.
+type MemStore struct {
+	lock      sync.RWMutex
+	factory   zebra.ResourceFactory
+	resources map[string]zebra.Resource
+}
+
+// NewMemStore returns an empty store of resources of the factory types.
+func NewMemStore(factory zebra.ResourceFactory) *MemStore {
+	return &MemStore{
+		lock:      sync.RWMutex{},
+		factory:   factory,
+		resources: map[string]zebra.Resource{},
+	}
+}
+
+// Initialize does nothing, the store is ready once made.
+func (m *MemStore) Initialize() error {
+	return nil
+}
+
+// Wipe deletes all resources.
+func (m *MemStore) Wipe() error {
+	return m.Clear()
+}
+
+// Clear deletes all resources.
+func (m *MemStore) Clear() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.resources = map[string]zebra.Resource{}
+
+	return nil
+}
+
+// Load returns all resources by type.
+func (m *MemStore) Load() (*zebra.ResourceMap, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	resources := zebra.NewResourceMap(m.factory)
+	for _, res := range m.resources {
+		resources.Add(res, res.GetType())
+	}
+
+	return resources, nil
+}
+
+// Create adds the resource, or replaces the resource of the same ID.
+func (m *MemStore) Create(res zebra.Resource) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.resources[res.GetID()] = res
+
+	return nil
+}
+
+// Delete deletes the resource, if it is in the store.
+func (m *MemStore) Delete(res zebra.Resource) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.resources, res.GetID())
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/project-safari/zebra/memstore"
+	"github.com/project-safari/zebra/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		m := memstore.NewMemStore(storetest.Factory())
+		assert.Nil(t, m.Initialize())
+
+		return m
+	})
+}
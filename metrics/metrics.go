@@ -0,0 +1,442 @@
+// Package metrics keeps counters, gauges and histograms of the zebra server
+// and exposes them in the Prometheus text format, or in the OpenMetrics
+// format with the exemplars of the histograms.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram buckets for request durations in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30} //nolint:gomnd
+
+// Default is the registry of the server metrics.
+var Default = NewRegistry() //nolint:gochecknoglobals
+
+// Content types of the formats.
+const (
+	TextContentType        = "text/plain; version=0.0.4"
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+const (
+	counterKind   = "counter"
+	gaugeKind     = "gauge"
+	histogramKind = "histogram"
+)
+
+// Registry holds the metrics by name.
+type Registry struct {
+	lock    sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:    sync.Mutex{},
+		metrics: map[string]*metric{},
+	}
+}
+
+// metric is a named metric with one series per set of label values.
+type metric struct {
+	lock    sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values    []string
+	value     float64
+	counts    []uint64
+	count     uint64
+	exemplars []*exemplar
+}
+
+// exemplar is the last value observed in a histogram bucket with the labels
+// linking it to its origin, such as a trace ID.
+type exemplar struct {
+	labels map[string]string
+	value  float64
+	time   time.Time
+}
+
+// LabelLimit bounds the number of values of a label, so that a label of
+// values the server does not control, such as namespaces, can not grow the
+// number of series without bound. Values beyond the first max ones are
+// replaced by the overflow value.
+type LabelLimit struct {
+	lock     sync.Mutex
+	max      int
+	overflow string
+	values   map[string]struct{}
+}
+
+// NewLabelLimit returns a limit of max values of a label.
+func NewLabelLimit(max int, overflow string) *LabelLimit {
+	return &LabelLimit{
+		lock:     sync.Mutex{},
+		max:      max,
+		overflow: overflow,
+		values:   map[string]struct{}{},
+	}
+}
+
+// Value returns the value if it is one of the first max values seen, the
+// overflow value otherwise.
+func (l *LabelLimit) Value(v string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.values[v]; ok {
+		return v
+	}
+
+	if len(l.values) >= l.max {
+		return l.overflow
+	}
+
+	l.values[v] = struct{}{}
+
+	return v
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ m *metric }
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ m *metric }
+
+// Histogram counts observed values in buckets.
+type Histogram struct{ m *metric }
+
+// Counter returns the named counter, registering it if it is new.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterKind, labels, nil)}
+}
+
+// Gauge returns the named gauge, registering it if it is new.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeKind, labels, nil)}
+}
+
+// Histogram returns the named histogram, registering it if it is new.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &Histogram{r.register(name, help, histogramKind, labels, sorted)}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		return m
+	}
+
+	m := &metric{
+		lock:    sync.Mutex{},
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+
+	r.metrics[name] = m
+
+	return m
+}
+
+// get returns the series of the label values, missing values are empty.
+func (m *metric) get(values []string) *series {
+	full := make([]string, len(m.labels))
+	copy(full, values)
+
+	key := strings.Join(full, "\xff")
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{
+			values:    full,
+			value:     0,
+			counts:    make([]uint64, len(m.buckets)),
+			count:     0,
+			exemplars: make([]*exemplar, len(m.buckets)+1),
+		}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+func (m *metric) value(values []string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.get(values).value
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+
+	c.m.lock.Lock()
+	defer c.m.lock.Unlock()
+
+	c.m.get(values).value += v
+}
+
+// Value returns the counter of the label values.
+func (c *Counter) Value(values ...string) float64 {
+	return c.m.value(values)
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.lock.Lock()
+	defer g.m.lock.Unlock()
+
+	g.m.get(values).value = v
+}
+
+// Add adds v to the gauge of the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.lock.Lock()
+	defer g.m.lock.Unlock()
+
+	g.m.get(values).value += v
+}
+
+// Value returns the gauge of the label values.
+func (g *Gauge) Value(values ...string) float64 {
+	return g.m.value(values)
+}
+
+// Observe records v in the histogram of the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.ObserveWithExemplar(v, nil, values...)
+}
+
+// ObserveWithExemplar records v in the histogram of the label values, and
+// keeps it as the exemplar of its bucket with the exemplar labels, such as
+// {"trace_id": "..."}. No exemplar is kept if there are no labels.
+func (h *Histogram) ObserveWithExemplar(v float64, labels map[string]string, values ...string) {
+	h.m.lock.Lock()
+	defer h.m.lock.Unlock()
+
+	s := h.m.get(values)
+	s.value += v
+	s.count++
+
+	bucket := len(h.m.buckets)
+
+	for i, le := range h.m.buckets {
+		if v <= le {
+			s.counts[i]++
+
+			if i < bucket {
+				bucket = i
+			}
+		}
+	}
+
+	if len(labels) != 0 {
+		s.exemplars[bucket] = &exemplar{labels: labels, value: v, time: time.Now()}
+	}
+}
+
+// Count returns the number of values observed for the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.m.lock.Lock()
+	defer h.m.lock.Unlock()
+
+	return h.m.get(values).count
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	return r.write(w, false)
+}
+
+// WriteOpenMetrics writes all metrics in the OpenMetrics text format, sorted
+// by name, with the exemplars of the histograms.
+func (r *Registry) WriteOpenMetrics(w io.Writer) error {
+	if err := r.write(w, true); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "# EOF\n")
+
+	return err
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) error {
+	r.lock.Lock()
+
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+
+	r.lock.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	for _, m := range metrics {
+		if err := m.write(w, openMetrics); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler serves the metrics of the registry, in the OpenMetrics format if
+// the scraper accepts it.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+			res.Header().Set("Content-Type", OpenMetricsContentType)
+			_ = r.WriteOpenMetrics(res)
+
+			return
+		}
+
+		res.Header().Set("Content-Type", TextContentType)
+		_ = r.Write(res)
+	})
+}
+
+func (m *metric) write(w io.Writer, openMetrics bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	// Counter families of OpenMetrics are named without the _total suffix
+	family := m.name
+	if openMetrics && m.kind == counterKind {
+		family = strings.TrimSuffix(m.name, "_total")
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", family, escape(m.help, openMetrics), family, m.kind)
+
+	for _, key := range keys {
+		s := m.series[key]
+
+		if m.kind != histogramKind {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, labelString(m.labels, s.values, "", ""), formatFloat(s.value))
+
+			continue
+		}
+
+		for i, le := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d%s\n", m.name, labelString(m.labels, s.values, "le", formatFloat(le)),
+				s.counts[i], s.exemplar(i, openMetrics))
+		}
+
+		fmt.Fprintf(b, "%s_bucket%s %d%s\n", m.name, labelString(m.labels, s.values, "le", "+Inf"), s.count,
+			s.exemplar(len(m.buckets), openMetrics))
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, labelString(m.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, labelString(m.labels, s.values, "", ""), s.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// exemplar returns the exemplar of the bucket as appended to its sample in
+// the OpenMetrics format, or "".
+func (s *series) exemplar(bucket int, openMetrics bool) string {
+	e := s.exemplars[bucket]
+	if !openMetrics || e == nil {
+		return ""
+	}
+
+	keys := make([]string, 0, len(e.labels))
+	for key := range e.labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = e.labels[key]
+	}
+
+	seconds := float64(e.time.UnixNano()) / float64(time.Second)
+
+	return fmt.Sprintf(" # %s %s %s", labelString(keys, values, "", ""), formatFloat(e.value),
+		strconv.FormatFloat(seconds, 'f', 3, 64))
+}
+
+func labelString(labels, values []string, extraLabel, extraValue string) string {
+	pairs := make([]string, 0, len(labels)+1)
+
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escape(values[i], true)))
+	}
+
+	if extraLabel != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraLabel, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	if quote {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return fmt.Sprint(v)
+	}
+}
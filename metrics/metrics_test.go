@@ -0,0 +1,160 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/project-safari/zebra/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := metrics.NewRegistry()
+	c := r.Counter("requests_total", "Requests.", "code")
+
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Add(-1, "200")
+	c.Inc("500")
+
+	assert.Equal(float64(3), c.Value("200"))
+	assert.Equal(float64(1), c.Value("500"))
+	assert.Equal(float64(0), c.Value("404"))
+
+	// Registering again returns the same counter
+	assert.Equal(float64(3), r.Counter("requests_total", "Requests.", "code").Value("200"))
+}
+
+func TestGauge(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	g := metrics.NewRegistry().Gauge("leases", "Active leases.")
+	g.Set(5)
+	g.Add(-2)
+
+	assert.Equal(float64(3), g.Value())
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := metrics.NewRegistry()
+	h := r.Histogram("duration_seconds", "Duration.", []float64{1, 0.1}, "route")
+
+	h.Observe(0.05, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(5, "/a")
+
+	assert.Equal(uint64(3), h.Count("/a"))
+
+	out := new(bytes.Buffer)
+	assert.Nil(r.Write(out))
+	assert.Equal(`# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 5.55
+duration_seconds_count{route="/a"} 3
+`, out.String())
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := metrics.NewRegistry()
+	r.Counter("b_total", "Second\nmetric.", "path").Inc("/a\"b\\c")
+	r.Gauge("a", "First metric.").Set(1.5)
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(rr.Header().Get("Content-Type"), "text/plain")
+	assert.Equal(`# HELP a First metric.
+# TYPE a gauge
+a 1.5
+# HELP b_total Second\nmetric.
+# TYPE b_total counter
+b_total{path="/a\"b\\c"} 1
+`, rr.Body.String())
+}
+
+func TestOpenMetrics(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := metrics.NewRegistry()
+	r.Counter("requests_total", "Requests.").Inc()
+
+	h := r.Histogram("duration_seconds", "Duration.", []float64{0.1, 1})
+	h.ObserveWithExemplar(0.05, map[string]string{"trace_id": "abc"})
+	h.Observe(0.07)
+	h.ObserveWithExemplar(5, map[string]string{"trace_id": "def"})
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, req)
+
+	assert.Equal(metrics.OpenMetricsContentType, rr.Header().Get("Content-Type"))
+
+	lines := strings.Split(rr.Body.String(), "\n")
+	assert.Equal("# TYPE duration_seconds histogram", lines[1])
+	assert.Regexp(`^duration_seconds_bucket\{le="0.1"\} 2 # \{trace_id="abc"\} 0.05 [0-9]+\.[0-9]{3}$`, lines[2])
+	assert.Equal(`duration_seconds_bucket{le="1"} 2`, lines[3])
+	assert.Regexp(`^duration_seconds_bucket\{le="\+Inf"\} 3 # \{trace_id="def"\} 5 `, lines[4])
+	assert.Equal("# TYPE requests counter", lines[8])
+	assert.Equal("requests_total 1", lines[9])
+	assert.Equal("# EOF", lines[10])
+
+	// Exemplars are only written in the OpenMetrics format
+	out := new(bytes.Buffer)
+	assert.Nil(r.Write(out))
+	assert.NotContains(out.String(), "trace_id")
+}
+
+func TestLabelLimit(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	limit := metrics.NewLabelLimit(2, "other")
+
+	assert.Equal("a", limit.Value("a"))
+	assert.Equal("b", limit.Value("b"))
+	assert.Equal("other", limit.Value("c"))
+	assert.Equal("a", limit.Value("a"))
+}
+
+func TestConcurrent(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := metrics.NewRegistry()
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				r.Counter("c", "C.").Inc()
+				_ = r.Write(new(bytes.Buffer))
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(float64(1000), r.Counter("c", "C.").Value())
+}
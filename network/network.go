@@ -26,6 +26,7 @@ func SwitchType() zebra.Type {
 		Name:        "Switch",
 		Description: "network server",
 		Constructor: func() zebra.Resource { return new(Switch) },
+		Migrations:  nil,
 	}
 }
 
@@ -38,6 +39,13 @@ type Switch struct {
 	SerialNumber string            `json:"serialNumber"`
 	Model        string            `json:"model"`
 	NumPorts     uint32            `json:"numPorts"`
+	Asset        *zebra.Asset      `json:"asset,omitempty"`
+	RackUnits    uint32            `json:"rackUnits,omitempty"`
+}
+
+// GetAsset returns the asset data of the switch, nil if it has none.
+func (s *Switch) GetAsset() *zebra.Asset {
+	return s.Asset
 }
 
 // Validate returns an error if the given Switch object has incorrect values.
@@ -58,6 +66,12 @@ func (s *Switch) Validate(ctx context.Context) error {
 		return zebra.ErrWrongType
 	}
 
+	if s.Asset != nil {
+		if err := s.Asset.Validate(ctx); err != nil {
+			return err
+		}
+	}
+
 	if err := s.Credentials.Validate(ctx); err != nil {
 		return err
 	}
@@ -70,6 +84,7 @@ func IPAddressPoolType() zebra.Type {
 		Name:        "IPAddressPool",
 		Description: "ip address pool",
 		Constructor: func() zebra.Resource { return new(IPAddressPool) },
+		Migrations:  nil,
 	}
 }
 
@@ -103,6 +118,7 @@ func VLANPoolType() zebra.Type {
 		Name:        "VLANPool",
 		Description: "vlan pool",
 		Constructor: func() zebra.Resource { return new(VLANPool) },
+		Migrations:  nil,
 	}
 }
 
@@ -162,6 +178,7 @@ func NewSwitch(arr []string, port uint32, ip net.IP, labels zebra.Labels) *Switc
 		Model:        arr[1],
 		NumPorts:     port,
 		Credentials:  *cred,
+		Asset:        nil,
 	}
 
 	return ret
@@ -63,6 +63,19 @@ func TestSwitch(t *testing.T) {
 	assert.NotNil(switch1.Validate(ctx))
 }
 
+func TestSwitchAsset(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	switch1 := network.NewSwitch([]string{"serial", "model", "switch"}, 48, net.ParseIP("10.1.0.0"), nil)
+	assert.Nil(switch1.GetAsset())
+
+	switch1.Asset = &zebra.Asset{PurchaseDate: "", CostCenter: "", WarrantyEnd: "soon", Vendor: ""}
+	assert.Equal(switch1.Asset, switch1.GetAsset())
+	assert.Equal(zebra.ErrWarrantyEnd, switch1.Validate(ctx))
+}
+
 // TestIPAddressPool tests the *IPAddressPool Validate function with a pass and a fail case.
 func TestIPAddressPool(t *testing.T) {
 	t.Parallel()
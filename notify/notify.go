@@ -0,0 +1,87 @@
+// Package notify delivers notifications about resource events to zebra users.
+package notify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultInboxSize is the number of notifications kept per user.
+const DefaultInboxSize = 100
+
+// Notification is a message sent to a user.
+type Notification struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	To       string    `json:"to"`
+	Subject  string    `json:"subject"`
+	Message  string    `json:"message,omitempty"`
+	Resource string    `json:"resource,omitempty"`
+}
+
+// Notifier is implemented by all notification channels.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NewNotification returns a notification with a new ID and the current time.
+func NewNotification(to, subject, message, resID string) Notification {
+	return Notification{
+		ID:       uuid.New().String(),
+		Time:     time.Now(),
+		To:       to,
+		Subject:  subject,
+		Message:  message,
+		Resource: resID,
+	}
+}
+
+// Inbox is an in-memory Notifier that keeps the most recent notifications
+// for each user.
+type Inbox struct {
+	lock  sync.RWMutex
+	size  int
+	boxes map[string][]Notification
+}
+
+// NewInbox returns an inbox that keeps at most size notifications per user.
+func NewInbox(size int) *Inbox {
+	if size <= 0 {
+		size = DefaultInboxSize
+	}
+
+	return &Inbox{
+		lock:  sync.RWMutex{},
+		size:  size,
+		boxes: make(map[string][]Notification),
+	}
+}
+
+// Notify adds the notification to the recipient's inbox, dropping the oldest
+// notification if the inbox is full.
+func (i *Inbox) Notify(n Notification) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	box := append(i.boxes[n.To], n)
+	if len(box) > i.size {
+		box = box[len(box)-i.size:]
+	}
+
+	i.boxes[n.To] = box
+
+	return nil
+}
+
+// List returns a copy of the notifications for the given user, oldest first.
+func (i *Inbox) List(user string) []Notification {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	box := make([]Notification, len(i.boxes[user]))
+	copy(box, i.boxes[user])
+
+	return box
+}
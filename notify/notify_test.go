@@ -0,0 +1,45 @@
+package notify_test
+
+import (
+	"testing"
+
+	"github.com/project-safari/zebra/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInbox(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	inbox := notify.NewInbox(2)
+	assert.NotNil(inbox)
+	assert.Empty(inbox.List("a@zebra"))
+
+	var n notify.Notifier = inbox
+
+	assert.Nil(n.Notify(notify.NewNotification("a@zebra", "one", "", "r1")))
+	assert.Nil(n.Notify(notify.NewNotification("a@zebra", "two", "", "r1")))
+	assert.Nil(n.Notify(notify.NewNotification("a@zebra", "three", "", "r1")))
+	assert.Nil(n.Notify(notify.NewNotification("b@zebra", "four", "", "r2")))
+
+	box := inbox.List("a@zebra")
+	assert.Equal(2, len(box))
+	assert.Equal("two", box[0].Subject)
+	assert.Equal("three", box[1].Subject)
+	assert.NotEmpty(box[0].ID)
+
+	assert.Equal(1, len(inbox.List("b@zebra")))
+}
+
+func TestDefaultInboxSize(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	inbox := notify.NewInbox(0)
+
+	for i := 0; i < notify.DefaultInboxSize+1; i++ {
+		assert.Nil(inbox.Notify(notify.NewNotification("a@zebra", "msg", "", "")))
+	}
+
+	assert.Equal(notify.DefaultInboxSize, len(inbox.List("a@zebra")))
+}
@@ -0,0 +1,392 @@
+// Package outbox keeps the deliveries of events to external integrations
+// until they have been acknowledged, so that no event is lost when a
+// receiver is down or the server restarts.
+//
+// Deliveries are retried with exponential backoff and dead-lettered after
+// too many attempts. Each delivery has a stable ID which is sent along with
+// every attempt, receivers use it to discard duplicates so that each event
+// is processed exactly once.
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RW = os.FileMode(0o600)
+
+	// DefaultMaxAttempts is the number of attempts before a delivery is
+	// dead-lettered.
+	DefaultMaxAttempts = 10
+
+	// DefaultBackoff is the wait after the first failed attempt, it doubles
+	// with every further attempt up to DefaultMaxBackoff.
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = time.Hour
+
+	// DefaultKeep is the number of delivered deliveries kept for inspection.
+	DefaultKeep = 1000
+)
+
+// Status is the state of a delivery.
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Delivered Status = "delivered"
+	Dead      Status = "dead"
+)
+
+var (
+	ErrNotFound  = errors.New("delivery not found")
+	ErrDelivered = errors.New("delivery has already been delivered")
+)
+
+// Delivery is a payload to be delivered to a target. Key identifies the
+// event for the target, a key is only ever enqueued once.
+type Delivery struct {
+	ID          string          `json:"id"`
+	Key         string          `json:"key"`
+	Target      string          `json:"target"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      Status          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	Created     time.Time       `json:"created"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	Finished    time.Time       `json:"finished,omitempty"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// NewDelivery returns a pending delivery with a new ID.
+func NewDelivery(key, target string, payload json.RawMessage, now time.Time) Delivery {
+	return Delivery{
+		ID:          uuid.New().String(),
+		Key:         key,
+		Target:      target,
+		Payload:     payload,
+		Status:      Pending,
+		Attempts:    0,
+		Created:     now,
+		NextAttempt: now,
+		Finished:    time.Time{},
+		LastError:   "",
+	}
+}
+
+// Backoff returns the wait after the given number of failed attempts.
+func Backoff(base, max time.Duration, attempts int) time.Duration {
+	wait := base
+
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+
+	if wait > max {
+		wait = max
+	}
+
+	return wait
+}
+
+// state is what is persisted: the deliveries and the cursor of the source
+// the deliveries were enqueued from.
+type state struct {
+	Cursor     uint64      `json:"cursor"`
+	Deliveries []*Delivery `json:"deliveries"`
+}
+
+// Outbox is a thread safe store of deliveries. If a path is given, the
+// outbox is written to that file on every change.
+type Outbox struct {
+	lock        sync.Mutex
+	path        string
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	keep        int
+	state       state
+	keys        map[string]*Delivery
+}
+
+// NewOutbox returns an outbox backed by the file at path. An empty path
+// results in an in-memory outbox.
+func NewOutbox(path string, maxAttempts int, backoff, maxBackoff time.Duration) *Outbox {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	if maxBackoff < backoff {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	return &Outbox{
+		lock:        sync.Mutex{},
+		path:        path,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		keep:        DefaultKeep,
+		state:       state{Cursor: 0, Deliveries: []*Delivery{}},
+		keys:        make(map[string]*Delivery),
+	}
+}
+
+// Initialize loads the outbox from the backing file, if any.
+func (o *Outbox) Initialize() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s := state{Cursor: 0, Deliveries: []*Delivery{}}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	o.state = s
+	o.keys = make(map[string]*Delivery, len(s.Deliveries))
+
+	for _, d := range s.Deliveries {
+		o.keys[d.Key] = d
+	}
+
+	return nil
+}
+
+// Cursor returns the cursor of the source up to which deliveries have been
+// enqueued.
+func (o *Outbox) Cursor() uint64 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.state.Cursor
+}
+
+// Enqueue adds the deliveries and moves the cursor in a single write, so
+// that after a restart the source is read again from where the enqueued
+// deliveries end. Deliveries with a key already in the outbox are skipped.
+// The added deliveries are returned.
+func (o *Outbox) Enqueue(cursor uint64, deliveries []Delivery) ([]Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	added := make([]Delivery, 0, len(deliveries))
+
+	for _, d := range deliveries {
+		if _, ok := o.keys[d.Key]; ok {
+			continue
+		}
+
+		d := d
+		o.state.Deliveries = append(o.state.Deliveries, &d)
+		o.keys[d.Key] = &d
+
+		added = append(added, d)
+	}
+
+	if cursor > o.state.Cursor {
+		o.state.Cursor = cursor
+	}
+
+	return added, o.save()
+}
+
+// Due returns the pending deliveries whose next attempt is due, oldest
+// first.
+func (o *Outbox) Due(now time.Time) []Delivery {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	due := []Delivery{}
+
+	for _, d := range o.state.Deliveries {
+		if d.Status == Pending && !d.NextAttempt.After(now) {
+			due = append(due, *d)
+		}
+	}
+
+	return due
+}
+
+// Ack marks the delivery as delivered.
+func (o *Outbox) Ack(id string, now time.Time) (Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	d := o.find(id)
+	if d == nil {
+		return Delivery{}, ErrNotFound
+	}
+
+	d.Attempts++
+	d.Status = Delivered
+	d.Finished = now
+	d.LastError = ""
+
+	o.prune()
+
+	return *d, o.save()
+}
+
+// Fail records a failed attempt of the delivery. The next attempt is backed
+// off, once the attempts are used up the delivery is dead-lettered.
+func (o *Outbox) Fail(id string, cause error, now time.Time) (Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	d := o.find(id)
+	if d == nil {
+		return Delivery{}, ErrNotFound
+	}
+
+	d.Attempts++
+	d.LastError = cause.Error()
+
+	if d.Attempts >= o.maxAttempts {
+		d.Status = Dead
+		d.Finished = now
+	} else {
+		d.NextAttempt = now.Add(Backoff(o.backoff, o.maxBackoff, d.Attempts))
+	}
+
+	return *d, o.save()
+}
+
+// Requeue makes a dead or pending delivery due immediately with all of its
+// attempts available again.
+func (o *Outbox) Requeue(id string, now time.Time) (Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	d := o.find(id)
+	if d == nil {
+		return Delivery{}, ErrNotFound
+	}
+
+	if d.Status == Delivered {
+		return *d, ErrDelivered
+	}
+
+	d.Status = Pending
+	d.Attempts = 0
+	d.NextAttempt = now
+	d.Finished = time.Time{}
+
+	return *d, o.save()
+}
+
+// Get returns the delivery with the given ID.
+func (o *Outbox) Get(id string) (Delivery, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	d := o.find(id)
+	if d == nil {
+		return Delivery{}, ErrNotFound
+	}
+
+	return *d, nil
+}
+
+// List returns the deliveries with the given status, or all deliveries if
+// the status is empty, oldest first.
+func (o *Outbox) List(status Status) []Delivery {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	list := []Delivery{}
+
+	for _, d := range o.state.Deliveries {
+		if status == "" || d.Status == status {
+			list = append(list, *d)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+
+	return list
+}
+
+func (o *Outbox) find(id string) *Delivery {
+	for _, d := range o.state.Deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// prune drops the oldest delivered deliveries beyond the ones kept. Must be
+// called with the lock held.
+func (o *Outbox) prune() {
+	delivered := 0
+
+	for _, d := range o.state.Deliveries {
+		if d.Status == Delivered {
+			delivered++
+		}
+	}
+
+	if delivered <= o.keep {
+		return
+	}
+
+	drop := delivered - o.keep
+	kept := make([]*Delivery, 0, len(o.state.Deliveries)-drop)
+
+	for _, d := range o.state.Deliveries {
+		if drop > 0 && d.Status == Delivered {
+			delete(o.keys, d.Key)
+
+			drop--
+
+			continue
+		}
+
+		kept = append(kept, d)
+	}
+
+	o.state.Deliveries = kept
+}
+
+// save writes the outbox to the backing file. The file is replaced, so that
+// a crash never leaves a partially written outbox behind. Must be called
+// with the lock held.
+func (o *Outbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(o.state)
+	if err != nil {
+		return err
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, RW); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, o.path)
+}
@@ -0,0 +1,163 @@
+package outbox_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/outbox"
+	"github.com/stretchr/testify/assert"
+)
+
+var errDown = errors.New("receiver is down")
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal(time.Second, outbox.Backoff(time.Second, time.Minute, 1))
+	assert.Equal(2*time.Second, outbox.Backoff(time.Second, time.Minute, 2))
+	assert.Equal(16*time.Second, outbox.Backoff(time.Second, time.Minute, 5))
+	assert.Equal(time.Minute, outbox.Backoff(time.Second, time.Minute, 7))
+	assert.Equal(time.Minute, outbox.Backoff(time.Second, time.Minute, 1000))
+}
+
+func TestOutbox(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	box := outbox.NewOutbox("", 3, time.Second, time.Minute)
+
+	added, err := box.Enqueue(2, []outbox.Delivery{
+		outbox.NewDelivery("hook/1", "hook", []byte(`{"seq":1}`), now),
+		outbox.NewDelivery("hook/2", "hook", []byte(`{"seq":2}`), now),
+	})
+	assert.Nil(err)
+	assert.Equal(2, len(added))
+	assert.Equal(uint64(2), box.Cursor())
+
+	// Keys are only enqueued once and the cursor never moves back
+	added, err = box.Enqueue(1, []outbox.Delivery{outbox.NewDelivery("hook/2", "hook", nil, now)})
+	assert.Nil(err)
+	assert.Empty(added)
+	assert.Equal(uint64(2), box.Cursor())
+
+	due := box.Due(now)
+	assert.Equal(2, len(due))
+	assert.Equal("hook/1", due[0].Key)
+
+	delivered, err := box.Ack(due[0].ID, now)
+	assert.Nil(err)
+	assert.Equal(outbox.Delivered, delivered.Status)
+	assert.Equal(1, delivered.Attempts)
+
+	failed, err := box.Fail(due[1].ID, errDown, now)
+	assert.Nil(err)
+	assert.Equal(outbox.Pending, failed.Status)
+	assert.Equal(errDown.Error(), failed.LastError)
+	assert.Equal(now.Add(time.Second), failed.NextAttempt)
+
+	assert.Empty(box.Due(now))
+	assert.Equal(1, len(box.Due(now.Add(time.Second))))
+
+	failed, err = box.Fail(failed.ID, errDown, now)
+	assert.Nil(err)
+	assert.Equal(now.Add(2*time.Second), failed.NextAttempt)
+
+	failed, err = box.Fail(failed.ID, errDown, now)
+	assert.Nil(err)
+	assert.Equal(outbox.Dead, failed.Status)
+	assert.Empty(box.Due(now.Add(time.Hour)))
+
+	assert.Equal(1, len(box.List(outbox.Dead)))
+	assert.Equal(2, len(box.List("")))
+
+	requeued, err := box.Requeue(failed.ID, now)
+	assert.Nil(err)
+	assert.Equal(outbox.Pending, requeued.Status)
+	assert.Equal(0, requeued.Attempts)
+	assert.Equal(1, len(box.Due(now)))
+
+	_, err = box.Requeue(delivered.ID, now)
+	assert.ErrorIs(err, outbox.ErrDelivered)
+
+	for _, err := range []error{
+		func() error { _, err := box.Ack("x", now); return err }(),
+		func() error { _, err := box.Fail("x", errDown, now); return err }(),
+		func() error { _, err := box.Requeue("x", now); return err }(),
+		func() error { _, err := box.Get("x"); return err }(),
+	} {
+		assert.ErrorIs(err, outbox.ErrNotFound)
+	}
+
+	got, err := box.Get(requeued.ID)
+	assert.Nil(err)
+	assert.Equal("hook/2", got.Key)
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Now()
+	box := outbox.NewOutbox("", 0, 0, 0)
+	deliveries := []outbox.Delivery{}
+
+	for i := 0; i < outbox.DefaultKeep+2; i++ {
+		deliveries = append(deliveries, outbox.NewDelivery(time.Duration(i).String(), "hook", nil, now))
+	}
+
+	_, err := box.Enqueue(1, deliveries)
+	assert.Nil(err)
+
+	for _, d := range box.Due(now) {
+		_, err := box.Ack(d.ID, now)
+		assert.Nil(err)
+	}
+
+	list := box.List(outbox.Delivered)
+	assert.Equal(outbox.DefaultKeep, len(list))
+	assert.Equal(deliveries[2].ID, list[0].ID)
+}
+
+func TestPersistence(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "outbox_testpersistence"
+	assert.Nil(os.MkdirAll(root, os.ModePerm))
+
+	defer func() { os.RemoveAll(root) }()
+
+	file := path.Join(root, "outbox.json")
+	now := time.Now()
+
+	box := outbox.NewOutbox(file, 0, 0, 0)
+	assert.Nil(box.Initialize())
+
+	added, err := box.Enqueue(7, []outbox.Delivery{outbox.NewDelivery("hook/7", "hook", []byte(`{}`), now)})
+	assert.Nil(err)
+
+	_, err = box.Fail(added[0].ID, errDown, now)
+	assert.Nil(err)
+
+	// The pending delivery survives a restart
+	reloaded := outbox.NewOutbox(file, 0, 0, 0)
+	assert.Nil(reloaded.Initialize())
+	assert.Equal(uint64(7), reloaded.Cursor())
+
+	pending := reloaded.List(outbox.Pending)
+	assert.Equal(1, len(pending))
+	assert.Equal(1, pending[0].Attempts)
+	assert.Equal(errDown.Error(), pending[0].LastError)
+
+	added, err = reloaded.Enqueue(7, []outbox.Delivery{outbox.NewDelivery("hook/7", "hook", nil, now)})
+	assert.Nil(err)
+	assert.Empty(added)
+
+	assert.Nil(os.WriteFile(file, []byte("{oops"), outbox.RW))
+	assert.NotNil(outbox.NewOutbox(file, 0, 0, 0).Initialize())
+}
@@ -0,0 +1,113 @@
+package protoenc
+
+import (
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/events"
+)
+
+// Field numbers of the EventPage message.
+const (
+	eventPageEvents = 1
+	eventPageCursor = 2
+)
+
+// Field numbers of the Event message.
+const (
+	eventSeq      = 1
+	eventTime     = 2
+	eventType     = 3
+	eventResource = 4
+	eventKind     = 5
+	eventLabels   = 6
+	eventActor    = 7
+	eventData     = 8
+)
+
+// MarshalEvents encodes a page of events and the cursor of the next page as
+// an EventPage. The data of the events is kept as the JSON it was recorded
+// as.
+func MarshalEvents(page []events.Event, cursor string) []byte {
+	b := []byte{}
+
+	for _, e := range page {
+		e := e
+		b = appendMessage(b, eventPageEvents, func(b []byte) []byte { return appendEvent(b, e) })
+	}
+
+	return appendString(b, eventPageCursor, cursor)
+}
+
+func appendEvent(b []byte, e events.Event) []byte {
+	b = appendVarint(b, eventSeq, e.Seq)
+
+	if !e.Time.IsZero() {
+		b = appendSint(b, eventTime, e.Time.UnixNano())
+	}
+
+	b = appendString(b, eventType, e.Type)
+	b = appendString(b, eventResource, e.Resource)
+	b = appendString(b, eventKind, e.Kind)
+	b = appendMap(b, eventLabels, e.Labels)
+	b = appendString(b, eventActor, e.Actor)
+
+	return appendBytes(b, eventData, e.Data)
+}
+
+// UnmarshalEvents decodes an EventPage into its events and cursor.
+func UnmarshalEvents(data []byte) ([]events.Event, string, error) {
+	page := []events.Event{}
+	cursor := ""
+
+	err := readFields(data, func(f field) error {
+		switch f.num {
+		case eventPageEvents:
+			e, err := readEvent(f.data)
+			page = append(page, e)
+
+			return err
+		case eventPageCursor:
+			cursor = string(f.data)
+		}
+
+		return nil
+	})
+
+	return page, cursor, err
+}
+
+func readEvent(data []byte) (events.Event, error) {
+	e := events.Event{
+		Seq: 0, Time: time.Time{}, Type: "", Resource: "", Kind: "", Labels: nil, Actor: "", Data: nil,
+	}
+
+	err := readFields(data, func(f field) error {
+		switch f.num {
+		case eventSeq:
+			e.Seq = f.value
+		case eventTime:
+			e.Time = time.Unix(0, f.sint()).UTC()
+		case eventType:
+			e.Type = string(f.data)
+		case eventResource:
+			e.Resource = string(f.data)
+		case eventKind:
+			e.Kind = string(f.data)
+		case eventLabels:
+			if e.Labels == nil {
+				e.Labels = zebra.Labels{}
+			}
+
+			return readMap(f.data, e.Labels)
+		case eventActor:
+			e.Actor = string(f.data)
+		case eventData:
+			e.Data = append([]byte{}, f.data...)
+		}
+
+		return nil
+	})
+
+	return e, err
+}
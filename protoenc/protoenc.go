@@ -0,0 +1,393 @@
+// Package protoenc encodes zebra resources and events in the Protocol Buffers
+// wire format, for consumers such as sync agents and replicas that read many
+// resources and would rather not parse JSON. The messages are described by
+// zebra.proto in this package. The common fields of a resource have fields
+// of their own, the fields of its type are encoded by their JSON name with
+// scalar values as protobuf scalars and all other values as JSON.
+package protoenc
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+// MediaType is the media type of protobuf encoded responses.
+const MediaType = "application/x-protobuf"
+
+// Field numbers of the Resource message.
+const (
+	resourceID            = 1
+	resourceType          = 2
+	resourceLabels        = 3
+	resourceStatus        = 4
+	resourceSchemaVersion = 5
+	resourceFields        = 6
+)
+
+// Field numbers of the Status message.
+const (
+	statusFault       = 1
+	statusLease       = 2
+	statusUsedBy      = 3
+	statusState       = 4
+	statusLifecycle   = 5
+	statusCreatedTime = 6
+)
+
+// Field numbers of the Field message, one per kind of value.
+const (
+	fieldName   = 1
+	fieldString = 2
+	fieldInt    = 3
+	fieldUint   = 4
+	fieldDouble = 5
+	fieldBool   = 6
+	fieldJSON   = 7
+)
+
+// Field numbers of the Page message. A page is a ResourceList with the
+// paging fields after the resources.
+const (
+	pageResources = 1
+	pageTotal     = 2
+	pageOffset    = 3
+	pageLimit     = 4
+	pageNext      = 5
+)
+
+// commonFields are the JSON names of the fields with a field of their own in
+// the Resource message.
+var commonFields = map[string]bool{ //nolint:gochecknoglobals
+	"id": true, "type": true, "labels": true, "status": true, zebra.SchemaVersionField: true,
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// MarshalResources encodes the resources as a ResourceList, in type and ID
+// order.
+func MarshalResources(resMap *zebra.ResourceMap) ([]byte, error) {
+	return appendResources(nil, resMap)
+}
+
+// MarshalPage encodes a page of query results as a Page. Next is left out if
+// it is nil, there are no more results then.
+func MarshalPage(resMap *zebra.ResourceMap, total int, offset int, limit int, next *int) ([]byte, error) {
+	b, err := appendResources(nil, resMap)
+	if err != nil {
+		return nil, err
+	}
+
+	b = appendVarint(b, pageTotal, uint64(total))
+	b = appendVarint(b, pageOffset, uint64(offset))
+	b = appendVarint(b, pageLimit, uint64(limit))
+
+	if next != nil {
+		// Next is optional, a next of 0 is kept
+		b = appendUvarint(appendTag(b, pageNext, wireVarint), uint64(*next))
+	}
+
+	return b, nil
+}
+
+func appendResources(b []byte, resMap *zebra.ResourceMap) ([]byte, error) {
+	types := make([]string, 0, len(resMap.Resources))
+	for t := range resMap.Resources {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+
+	for _, t := range types {
+		resources := append([]zebra.Resource{}, resMap.Resources[t].Resources...)
+		sort.Slice(resources, func(i, j int) bool { return resources[i].GetID() < resources[j].GetID() })
+
+		for _, res := range resources {
+			msg, err := MarshalResource(res)
+			if err != nil {
+				return nil, err
+			}
+
+			b = appendMessage(b, pageResources, func(b []byte) []byte { return append(b, msg...) })
+		}
+	}
+
+	return b, nil
+}
+
+// MarshalResource encodes a resource as a Resource message.
+func MarshalResource(res zebra.Resource) ([]byte, error) {
+	b := appendString(nil, resourceID, res.GetID())
+	b = appendString(b, resourceType, res.GetType())
+	b = appendMap(b, resourceLabels, res.GetLabels())
+
+	if status := res.GetStatus(); status != nil {
+		b = appendMessage(b, resourceStatus, func(b []byte) []byte { return appendStatus(b, status) })
+	}
+
+	if versioned, ok := res.(interface{ GetSchemaVersion() int }); ok {
+		b = appendVarint(b, resourceSchemaVersion, uint64(versioned.GetSchemaVersion()))
+	}
+
+	value := reflect.ValueOf(res)
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return b, nil
+	}
+
+	return appendFields(b, value)
+}
+
+func appendStatus(b []byte, status *zebra.Status) []byte {
+	b = appendString(b, statusFault, status.Fault.String())
+	b = appendString(b, statusLease, status.Lease.String())
+	b = appendString(b, statusUsedBy, status.UsedBy)
+	b = appendString(b, statusState, status.State.String())
+	b = appendString(b, statusLifecycle, string(status.Lifecycle))
+
+	if !status.CreatedTime.IsZero() {
+		b = appendSint(b, statusCreatedTime, status.CreatedTime.UnixNano())
+	}
+
+	return b
+}
+
+// appendFields appends the fields of the struct that are not common fields,
+// with the fields of embedded structs as fields of the struct, as JSON does.
+// Fields with zero values are left out.
+func appendFields(b []byte, value reflect.Value) ([]byte, error) {
+	for i := 0; i < value.NumField(); i++ {
+		f := value.Type().Field(i)
+		name, embedded := jsonName(f)
+
+		if embedded {
+			var err error
+			if b, err = appendFields(b, value.Field(i)); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if name == "" || commonFields[name] || value.Field(i).IsZero() {
+			continue
+		}
+
+		fieldMsg, err := appendValue(appendString(nil, fieldName, name), value.Field(i))
+		if err != nil {
+			return nil, err
+		}
+
+		b = appendMessage(b, resourceFields, func(b []byte) []byte { return append(b, fieldMsg...) })
+	}
+
+	return b, nil
+}
+
+// jsonName returns the JSON name of the struct field, empty if the field is
+// not encoded, and whether its fields are fields of the struct instead.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	name := strings.Split(tag, ",")[0]
+
+	switch {
+	case !f.IsExported() && !f.Anonymous, tag == "-":
+		return "", false
+	case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+		return "", true
+	case name == "":
+		return f.Name, false
+	}
+
+	return name, false
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	// Values with a form of their own are encoded as JSON does
+	if v.Type().Implements(jsonMarshaler) || v.Type().Implements(textMarshaler) ||
+		reflect.PtrTo(v.Type()).Implements(jsonMarshaler) || reflect.PtrTo(v.Type()).Implements(textMarshaler) {
+		return appendJSON(b, v)
+	}
+
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.String:
+		return appendString(b, fieldString, v.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendSint(b, fieldInt, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendVarint(b, fieldUint, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return appendDouble(b, fieldDouble, v.Float()), nil
+	case reflect.Bool:
+		return appendBool(b, fieldBool, v.Bool()), nil
+	default:
+		return appendJSON(b, v)
+	}
+}
+
+func appendJSON(b []byte, v reflect.Value) ([]byte, error) {
+	value := v.Interface()
+	if v.CanAddr() {
+		value = v.Addr().Interface()
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendBytes(b, fieldJSON, data), nil
+}
+
+// UnmarshalResources decodes a ResourceList, or a Page, into resources made
+// by the factory.
+func UnmarshalResources(data []byte, factory zebra.ResourceFactory) (*zebra.ResourceMap, error) {
+	resMap := zebra.NewResourceMap(factory)
+
+	err := readFields(data, func(f field) error {
+		if f.num != pageResources || f.wireType != wireBytes {
+			return nil
+		}
+
+		res, err := UnmarshalResource(f.data, factory)
+		if err != nil {
+			return err
+		}
+
+		resMap.Add(res, res.GetType())
+
+		return nil
+	})
+
+	return resMap, err
+}
+
+// UnmarshalResource decodes a Resource message into a resource made by the
+// factory. The resource is decoded as its JSON would be.
+func UnmarshalResource(data []byte, factory zebra.ResourceFactory) (zebra.Resource, error) {
+	fields := map[string]interface{}{}
+	labels := map[string]string{}
+
+	err := readFields(data, func(f field) error {
+		switch f.num {
+		case resourceID:
+			fields["id"] = string(f.data)
+		case resourceType:
+			fields["type"] = string(f.data)
+		case resourceLabels:
+			return readMap(f.data, labels)
+		case resourceStatus:
+			status, err := readStatus(f.data)
+			fields["status"] = status
+
+			return err
+		case resourceSchemaVersion:
+			fields[zebra.SchemaVersionField] = f.value
+		case resourceFields:
+			return readField(f.data, fields)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(labels) != 0 {
+		fields["labels"] = labels
+	}
+
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return zebra.NewDecoder(factory).Decode(data)
+}
+
+func readStatus(data []byte) (map[string]interface{}, error) {
+	// Fields left out are zero, and the zero fault, lease and state are
+	// named as their zero values are
+	zero := new(zebra.Status)
+	status := map[string]interface{}{
+		"fault": zero.Fault.String(), "lease": zero.Lease.String(), "state": zero.State.String(),
+		"createdTime": time.Time{},
+	}
+
+	err := readFields(data, func(f field) error {
+		switch f.num {
+		case statusFault:
+			status["fault"] = string(f.data)
+		case statusLease:
+			status["lease"] = string(f.data)
+		case statusUsedBy:
+			status["usedBy"] = string(f.data)
+		case statusState:
+			status["state"] = string(f.data)
+		case statusLifecycle:
+			status["lifecycle"] = string(f.data)
+		case statusCreatedTime:
+			status["createdTime"] = time.Unix(0, f.sint()).UTC()
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+func readField(data []byte, fields map[string]interface{}) error {
+	name := ""
+
+	var value interface{}
+
+	err := readFields(data, func(f field) error {
+		switch f.num {
+		case fieldName:
+			name = string(f.data)
+		case fieldString:
+			value = string(f.data)
+		case fieldInt:
+			value = f.sint()
+		case fieldUint:
+			value = f.value
+		case fieldDouble:
+			value = f.double()
+		case fieldBool:
+			value = f.value != 0
+		case fieldJSON:
+			value = json.RawMessage(f.data)
+		}
+
+		return nil
+	})
+
+	// Zero values are left out, a field without one is a zero value
+	if name != "" && value != nil {
+		fields[name] = value
+	}
+
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,104 @@
+package protoenc_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/protoenc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalResource(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab", nil)
+	lab.ID = "abc"
+
+	data, err := protoenc.MarshalResource(lab)
+	assert.Nil(err)
+
+	// id is field 1, a length delimited string
+	assert.Equal([]byte{0x0a, 0x03, 'a', 'b', 'c', 0x12, 0x03, 'L', 'a', 'b'}, data[:10])
+
+	_, err = protoenc.UnmarshalResource(data[:len(data)-1], store.DefaultFactory())
+	assert.ErrorIs(err, protoenc.ErrMalformed)
+}
+
+func TestResources(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	labels := zebra.Labels{"system.group": "labs", "owner": "alice"}
+
+	lab := dc.NewLab("lab", labels)
+	lab.Status.CreatedTime = created
+	lab.Status.Lifecycle = zebra.LifecycleActive
+
+	server := compute.NewServer([]string{"sn-1", "ucs", "server"}, net.ParseIP("10.0.0.1"), labels)
+	server.Status.CreatedTime = created
+	server.RackUnits = 2
+
+	pool := network.NewVlanPool(10, 20, labels)
+	pool.Status = nil
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	for _, res := range []zebra.Resource{lab, server, pool} {
+		resMap.Add(res, res.GetType())
+	}
+
+	data, err := protoenc.MarshalResources(resMap)
+	assert.Nil(err)
+
+	decoded, err := protoenc.UnmarshalResources(data, store.DefaultFactory())
+	assert.Nil(err)
+
+	for _, res := range []zebra.Resource{lab, server, pool} {
+		want, _ := json.Marshal(res)
+		got, _ := json.Marshal(decoded.Resources[res.GetType()].Resources[0])
+		assert.JSONEq(string(want), string(got))
+	}
+
+	jsonData, _ := json.Marshal(resMap)
+	assert.Less(len(data), len(jsonData))
+
+	// A page is a list of resources with paging fields
+	next := 0
+	page, err := protoenc.MarshalPage(resMap, 3, 0, 3, &next)
+	assert.Nil(err)
+	assert.Equal(data, page[:len(data)])
+
+	decoded, err = protoenc.UnmarshalResources(page, store.DefaultFactory())
+	assert.Nil(err)
+	assert.Equal(3, len(decoded.Resources))
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	e, err := events.NewEvent(events.Created, lab, "alice@zebra")
+	assert.Nil(err)
+
+	e.Seq = 7
+	e.Time = e.Time.UTC()
+
+	page, cursor, err := protoenc.UnmarshalEvents(protoenc.MarshalEvents([]events.Event{e}, "7"))
+	assert.Nil(err)
+	assert.Equal("7", cursor)
+	assert.Equal(1, len(page))
+	assert.True(e.Time.Equal(page[0].Time))
+
+	page[0].Time = e.Time
+	assert.Equal(e, page[0])
+}
@@ -0,0 +1,198 @@
+package protoenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Wire types of the Protocol Buffers encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+
+	// wireTypeBits are the bits of a tag that hold the wire type, the
+	// field number is in the bits above.
+	wireTypeBits = 3
+)
+
+// Field numbers of the entries of map fields.
+const (
+	mapKey   = 1
+	mapValue = 2
+)
+
+var ErrMalformed = errors.New("protobuf message is malformed")
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte //nolint:gomnd
+
+	binary.LittleEndian.PutUint64(buf[:], v)
+
+	return append(b, buf[:]...)
+}
+
+func appendTag(b []byte, num int, wireType int) []byte {
+	return appendUvarint(b, uint64(num)<<wireTypeBits|uint64(wireType))
+}
+
+func appendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendSint(b []byte, num int, v int64) []byte {
+	return appendVarint(b, num, uint64(v<<1)^uint64(v>>63))
+}
+
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+
+	return appendVarint(b, num, 1)
+}
+
+func appendDouble(b []byte, num int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendFixed64(appendTag(b, num, wireFixed64), math.Float64bits(v))
+}
+
+func appendBytes(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	return append(appendUvarint(appendTag(b, num, wireBytes), uint64(len(v))), v...)
+}
+
+func appendString(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+
+	return append(appendUvarint(appendTag(b, num, wireBytes), uint64(len(v))), v...)
+}
+
+// appendMessage appends the message made by fill as a field. Unlike scalar
+// fields, messages are appended even if they are empty, so that the entries
+// of repeated fields are kept.
+func appendMessage(b []byte, num int, fill func(b []byte) []byte) []byte {
+	msg := fill(nil)
+
+	return append(appendUvarint(appendTag(b, num, wireBytes), uint64(len(msg))), msg...)
+}
+
+// appendMap appends a map<string, string> field, as entries with the key in
+// field 1 and the value in field 2.
+func appendMap(b []byte, num int, m map[string]string) []byte {
+	for _, k := range sortedKeys(m) {
+		v := m[k]
+		b = appendMessage(b, num, func(b []byte) []byte {
+			return appendString(appendString(b, mapKey, k), mapValue, v)
+		})
+	}
+
+	return b
+}
+
+// field is a field read from a message. Varint and fixed values are in
+// value, length delimited ones in data.
+type field struct {
+	num      int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+func (f field) sint() int64 {
+	return int64(f.value>>1) ^ -int64(f.value&1)
+}
+
+func (f field) double() float64 {
+	return math.Float64frombits(f.value)
+}
+
+// readFields calls read with each field of the message in order.
+func readFields(msg []byte, read func(f field) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return ErrMalformed
+		}
+
+		msg = msg[n:]
+		f := field{num: int(tag >> wireTypeBits), wireType: int(tag & (1<<wireTypeBits - 1)), value: 0, data: nil}
+
+		switch f.wireType {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(msg); n <= 0 {
+				return ErrMalformed
+			}
+		case wireFixed64:
+			if n = 8; len(msg) < n { //nolint:gomnd
+				return ErrMalformed
+			}
+
+			f.value = binary.LittleEndian.Uint64(msg)
+		case wireFixed32:
+			if n = 4; len(msg) < n { //nolint:gomnd
+				return ErrMalformed
+			}
+
+			f.value = uint64(binary.LittleEndian.Uint32(msg))
+		case wireBytes:
+			size, m := binary.Uvarint(msg)
+			if m <= 0 || uint64(len(msg)-m) < size {
+				return ErrMalformed
+			}
+
+			f.data = msg[m : m+int(size)]
+			n = m + int(size)
+		default:
+			return ErrMalformed
+		}
+
+		msg = msg[n:]
+
+		if err := read(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readMap reads an entry of a map<string, string> field into m.
+func readMap(entry []byte, m map[string]string) error {
+	key, value := "", ""
+
+	err := readFields(entry, func(f field) error {
+		switch f.num {
+		case mapKey:
+			key = string(f.data)
+		case mapValue:
+			value = string(f.data)
+		}
+
+		return nil
+	})
+
+	m[key] = value
+
+	return err
+}
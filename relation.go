@@ -0,0 +1,188 @@
+package zebra
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ParentLabel is the label holding the ID of the resource this resource
+// belongs to, for example the lab of a rack.
+const ParentLabel = "system.parent"
+
+// OrphanLabel is the label holding the ID of the deleted resource that a
+// resource referred to, set on the resources orphaned by the delete.
+const OrphanLabel = "system.orphaned"
+
+// Delete policies of the references to a resource. DeleteBlock refuses to
+// delete a resource while it is referred to, DeleteCascade deletes the
+// referring resources with it and DeleteOrphan keeps them, with the
+// OrphanLabel set.
+const (
+	DeleteBlock   = "block"
+	DeleteCascade = "cascade"
+	DeleteOrphan  = "orphan"
+)
+
+// AnyType stands for the types without a delete policy of their own.
+const AnyType = "*"
+
+var (
+	ErrReferenceCycle = errors.New("resources have cyclic references")
+	ErrDeletePolicy   = errors.New(`delete policy is incorrect, must be in ["block", "cascade", "orphan"]`)
+	ErrReferenced     = errors.New("resource is referred to by other resources")
+)
+
+// References returns the IDs of the resources the given resource refers to.
+// A resource refers to its parent (see ParentLabel) and to every resource
+// whose ID is held in a top level field with a JSON name ending in "ID", such
+// as the serverID of a VM.
+func References(res Resource) []string {
+	refs := []string{}
+	seen := map[string]bool{}
+
+	add := func(id string) {
+		if id != "" && id != res.GetID() && !seen[id] {
+			seen[id] = true
+
+			refs = append(refs, id)
+		}
+	}
+
+	if labels := res.GetLabels(); labels != nil {
+		add(labels[ParentLabel])
+	}
+
+	vals, err := Flatten(res)
+	if err != nil {
+		return refs
+	}
+
+	keys := make([]string, 0, len(vals))
+
+	for key := range vals {
+		if !strings.Contains(key, ".") && strings.HasSuffix(key, "ID") {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if id, ok := vals[key].(string); ok {
+			add(id)
+		}
+	}
+
+	return refs
+}
+
+// SortByReferences orders the resources such that every resource comes after
+// the resources it refers to. References to resources not in the list are
+// ignored. Resources without an order between them keep their relative order.
+func SortByReferences(resources []Resource) ([]Resource, error) {
+	index := make(map[string]int, len(resources))
+	for i, res := range resources {
+		index[res.GetID()] = i
+	}
+
+	// deps counts the unsorted references of each resource, users lists the
+	// resources referring to each resource.
+	deps := make([]int, len(resources))
+	users := make([][]int, len(resources))
+
+	for i, res := range resources {
+		for _, ref := range References(res) {
+			if j, ok := index[ref]; ok {
+				deps[i]++
+				users[j] = append(users[j], i)
+			}
+		}
+	}
+
+	sorted := make([]Resource, 0, len(resources))
+	done := make([]bool, len(resources))
+
+	for len(sorted) < len(resources) {
+		next := -1
+
+		for i := range resources {
+			if !done[i] && deps[i] == 0 {
+				next = i
+
+				break
+			}
+		}
+
+		if next < 0 {
+			return nil, ErrReferenceCycle
+		}
+
+		done[next] = true
+		sorted = append(sorted, resources[next])
+
+		for _, u := range users[next] {
+			deps[u]--
+		}
+	}
+
+	return sorted, nil
+}
+
+// DeletePolicy holds the delete policies of the references between resource
+// types. Types maps the type of a referred resource to the policies of the
+// types referring to it, AnyType standing for the others, such as
+// {"Rack": {"Server": "block", "*": "cascade"}}. References without a policy
+// of their own follow the default.
+type DeletePolicy struct {
+	Default string
+	Types   map[string]map[string]string
+}
+
+// NewDeletePolicy returns a policy orphaning the resources that refer to a
+// deleted resource.
+func NewDeletePolicy() *DeletePolicy {
+	return &DeletePolicy{Default: DeleteOrphan, Types: map[string]map[string]string{}}
+}
+
+// Validate returns an error if any of the policies is not known.
+func (p *DeletePolicy) Validate() error {
+	if err := validateDeletePolicy(p.Default); err != nil {
+		return err
+	}
+
+	for _, referrers := range p.Types {
+		for _, policy := range referrers {
+			if err := validateDeletePolicy(policy); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateDeletePolicy(policy string) error {
+	switch policy {
+	case DeleteBlock, DeleteCascade, DeleteOrphan:
+		return nil
+	}
+
+	return ErrDeletePolicy
+}
+
+// Policy returns the policy of deleting a resource of the referred type that
+// a resource of the referrer type refers to.
+func (p *DeletePolicy) Policy(referred string, referrer string) string {
+	referrers := p.Types[referred]
+
+	if policy, ok := referrers[referrer]; ok {
+		return policy
+	}
+
+	if policy, ok := referrers[AnyType]; ok {
+		return policy
+	}
+
+	return p.Default
+}
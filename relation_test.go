@@ -0,0 +1,73 @@
+package zebra_test
+
+import (
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/stretchr/testify/assert"
+)
+
+type hosted struct {
+	zebra.BaseResource
+	HostID   string `json:"hostID"`   //nolint:tagliatelle
+	SwitchID string `json:"switchID"` //nolint:tagliatelle
+}
+
+func TestReferences(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	base := zebra.NewBaseResource("BaseResource", nil)
+	assert.Empty(zebra.References(base))
+
+	child := zebra.NewBaseResource("BaseResource", zebra.Labels{zebra.ParentLabel: base.ID})
+	assert.Equal([]string{base.ID}, zebra.References(child))
+
+	vm := &hosted{
+		BaseResource: *zebra.NewBaseResource("VM", zebra.Labels{zebra.ParentLabel: "host"}),
+		HostID:       "host",
+		SwitchID:     "switch",
+	}
+	assert.Equal([]string{"host", "switch"}, zebra.References(vm))
+}
+
+func TestSortByReferences(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	lab := zebra.NewBaseResource("Lab", nil)
+	rack := zebra.NewBaseResource("Rack", zebra.Labels{zebra.ParentLabel: lab.ID})
+	server := zebra.NewBaseResource("Server", zebra.Labels{zebra.ParentLabel: rack.ID})
+	other := zebra.NewBaseResource("Server", zebra.Labels{zebra.ParentLabel: "elsewhere"})
+
+	sorted, err := zebra.SortByReferences([]zebra.Resource{server, other, rack, lab})
+	assert.Nil(err)
+	assert.Equal([]zebra.Resource{other, lab, rack, server}, sorted)
+
+	lab.Labels = zebra.Labels{zebra.ParentLabel: server.ID}
+	sorted, err = zebra.SortByReferences([]zebra.Resource{server, rack, lab})
+	assert.Equal(zebra.ErrReferenceCycle, err)
+	assert.Nil(sorted)
+}
+
+func TestDeletePolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p := zebra.NewDeletePolicy()
+	assert.Nil(p.Validate())
+	assert.Equal(zebra.DeleteOrphan, p.Policy("Rack", "Server"))
+
+	p.Types["Rack"] = map[string]string{"Server": zebra.DeleteBlock, zebra.AnyType: zebra.DeleteCascade}
+	assert.Nil(p.Validate())
+	assert.Equal(zebra.DeleteBlock, p.Policy("Rack", "Server"))
+	assert.Equal(zebra.DeleteCascade, p.Policy("Rack", "Switch"))
+	assert.Equal(zebra.DeleteOrphan, p.Policy("Lab", "Rack"))
+
+	p.Types["Lab"] = map[string]string{"Rack": "keep"}
+	assert.ErrorIs(p.Validate(), zebra.ErrDeletePolicy)
+
+	p = zebra.NewDeletePolicy()
+	p.Default = ""
+	assert.ErrorIs(p.Validate(), zebra.ErrDeletePolicy)
+}
@@ -8,6 +8,9 @@ type Type struct {
 	Name        string          `json:"name"`
 	Description string          `json:"description"`
 	Constructor func() Resource `json:"-"`
+	// Migrations up-convert stored resources of older schema versions of
+	// the type, the migration at index i from version i to version i+1.
+	Migrations []Migration `json:"-"`
 }
 
 func (t *Type) New() Resource {
@@ -96,52 +99,15 @@ func (r *ResourceList) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.Resources)
 }
 
+// UnmarshalJSON decodes the resources in the list with a strict Decoder, the
+// type field of each resource selects the resource made by the factory.
 func (r *ResourceList) UnmarshalJSON(data []byte) error {
-	// unmarshal the data as a list of maps with string as key so that
-	// we can look up the type value of the resource.
-	values := []map[string]interface{}{}
-	if e := json.Unmarshal(data, &values); e != nil {
-		return e
+	resources, err := NewDecoder(r.factory).DecodeList(data)
+	if err != nil {
+		return err
 	}
 
-	// For each value find out the type, the convert the value back into a
-	// byte array. Use the type to create the actual resource object and the
-	// unmarshal the byte array into that resource object.
-	for _, value := range values {
-		// all resources must have type
-		vAny, ok := value["type"]
-		if !ok {
-			return ErrTypeEmpty
-		}
-
-		// Make a new resource for this value based on the embedded type field
-		vType, ok := vAny.(string)
-		if !ok {
-			return ErrTypeEmpty
-		}
-
-		resource := r.factory.New(vType)
-
-		if resource == nil {
-			// Type factory doesnt know this type return error
-			return ErrTypeEmpty
-		}
-
-		// Capture the []byte of just this value
-		resData, err := json.Marshal(value)
-		if err != nil {
-			return err
-		}
-
-		// We have the []byte representation and we have the target object
-		// so now can do the final unmarshal and add this object into the
-		// resource list
-		if e := json.Unmarshal(resData, resource); e != nil {
-			return e
-		}
-
-		r.Resources = append(r.Resources, resource)
-	}
+	r.Resources = append(r.Resources, resources...)
 
 	return nil
 }
@@ -215,6 +181,13 @@ func (r *ResourceMap) UnmarshalJSON(data []byte) error {
 			return e
 		}
 
+		// Resources must be listed under their own type
+		for _, res := range rList.Resources {
+			if res.GetType() != vType {
+				return &DecodeError{Type: vType, Err: ErrTypeMismatch, Detail: res.GetType()}
+			}
+		}
+
 		// Add this list to the Resource map
 		r.Resources[vType] = rList
 	}
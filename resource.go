@@ -14,6 +14,7 @@ type Resource interface {
 	GetID() string
 	GetType() string
 	GetLabels() Labels
+	GetStatus() *Status
 }
 
 var (
@@ -37,6 +38,9 @@ type BaseResource struct {
 	Type   string  `json:"type"`
 	Labels Labels  `json:"labels,omitempty"`
 	Status *Status `json:"status,omitempty"`
+	// SchemaVersion is the schema version of the type the resource was
+	// written with, see Type.Migrations.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
 }
 
 // Validate returns an error if the given BaseResource object has incorrect values.
@@ -82,6 +86,21 @@ func (r *BaseResource) GetLabels() Labels {
 	return dest
 }
 
+// Return status of BaseResource r.
+func (r *BaseResource) GetStatus() *Status {
+	return r.Status
+}
+
+// Return the schema version BaseResource r was written with.
+func (r *BaseResource) GetSchemaVersion() int {
+	return r.SchemaVersion
+}
+
+// Set the schema version of BaseResource r.
+func (r *BaseResource) SetSchemaVersion(version int) {
+	r.SchemaVersion = version
+}
+
 // Special label validation to ensure all resources have group label.
 func (r *BaseResource) LabelsValidate() error {
 	if _, ok := r.Labels["system.group"]; !ok {
@@ -36,6 +36,7 @@ func TestBaseResource(t *testing.T) {
 	assert.Equal(res.ID, res.GetID())
 	assert.Equal(res.Type, res.GetType())
 	assert.True(res.GetLabels().HasKey("key"))
+	assert.Equal(res.Status, res.GetStatus())
 }
 
 // TestBaseResource tests the *NamedResource Validate function with a pass case
@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLookahead bounds the search for the next time of a cron expression,
+// expressions such as "0 0 30 2 *" never match.
+const maxLookahead = 5 * 366 * 24 * time.Hour
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule returns the next time a job runs after the given time. A zero
+// time means the job does not run again.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// every runs a job at a fixed interval.
+type every struct {
+	interval time.Duration
+}
+
+func (e every) Next(after time.Time) time.Time {
+	return after.Add(e.interval)
+}
+
+// field is the set of values a cron field matches, as a bit mask.
+type field uint64
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// cron runs a job at the times matching a five field cron expression.
+type cron struct {
+	minute field
+	hour   field
+	dom    field
+	month  field
+	dow    field
+	// Day of month and day of week match either one if both are restricted,
+	// as in the classic cron.
+	domStar bool
+	dowStar bool
+}
+
+type bounds struct {
+	min int
+	max int
+}
+
+var (
+	minutes  = bounds{0, 59}
+	hours    = bounds{0, 23}
+	days     = bounds{1, 31}
+	months   = bounds{1, 12}
+	weekdays = bounds{0, 7}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse returns the schedule of a cron expression. Expressions have the five
+// fields minute, hour, day of month, month and day of week, each a "*", a
+// value, a range "a-b" or a list of those, optionally with a step "/n". The
+// descriptors @hourly, @daily, @weekly, @monthly, @yearly and "@every <d>"
+// with a Go duration are accepted as well.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if d, ok := descriptors[expr]; ok {
+		expr = d
+	}
+
+	if strings.HasPrefix(expr, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || interval < time.Second {
+			return nil, ErrInvalidSchedule
+		}
+
+		return every{interval: interval}, nil
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 { //nolint:gomnd
+		return nil, ErrInvalidSchedule
+	}
+
+	all := []bounds{minutes, hours, days, months, weekdays}
+	parsed := make([]field, len(fields))
+
+	for i, f := range fields {
+		p, err := parseField(f, all[i])
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[i] = p
+	}
+
+	// Sunday is both 0 and 7
+	if parsed[4].has(7) {
+		parsed[4] |= 1
+	}
+
+	return &cron{
+		minute:  parsed[0],
+		hour:    parsed[1],
+		dom:     parsed[2],
+		month:   parsed[3],
+		dow:     parsed[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseField(expr string, b bounds) (field, error) {
+	var f field
+
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+
+		if hasStep {
+			s, err := strconv.Atoi(stepStr)
+			if err != nil || s < 1 {
+				return 0, ErrInvalidSchedule
+			}
+
+			step = s
+		}
+
+		lo, hi := b.min, b.max
+
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+
+			v, err := strconv.Atoi(loStr)
+			if err != nil {
+				return 0, ErrInvalidSchedule
+			}
+
+			lo, hi = v, v
+
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, ErrInvalidSchedule
+				}
+			case hasStep:
+				hi = b.max
+			}
+		}
+
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, ErrInvalidSchedule
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom.has(t.Day())
+	dow := c.dow.has(int(t.Weekday()))
+
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Next returns the first matching minute after the given time, in the
+// location of that time.
+func (c *cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(maxLookahead)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
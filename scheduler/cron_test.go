@@ -0,0 +1,69 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	valid := []string{
+		"* * * * *", "*/5 * * * *", "0 2 * * 1-5", "0,30 8-18/2 1 */3 7",
+		"@daily", "@hourly", "@every 90s", " @weekly ",
+	}
+
+	for _, expr := range valid {
+		_, err := scheduler.Parse(expr)
+		assert.Nil(err, expr)
+	}
+
+	invalid := []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *",
+		"1-b * * * *", "@every", "@every 1ms", "@every soon", "@often",
+	}
+
+	for _, expr := range invalid {
+		_, err := scheduler.Parse(expr)
+		assert.ErrorIs(err, scheduler.ErrInvalidSchedule, expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// Wednesday
+	start := time.Date(2022, 6, 1, 10, 17, 30, 0, time.UTC)
+
+	next := func(expr string) time.Time {
+		s, err := scheduler.Parse(expr)
+		assert.Nil(err)
+
+		return s.Next(start)
+	}
+
+	assert.Equal(time.Date(2022, 6, 1, 10, 18, 0, 0, time.UTC), next("* * * * *"))
+	assert.Equal(time.Date(2022, 6, 1, 10, 20, 0, 0, time.UTC), next("*/5 * * * *"))
+	assert.Equal(time.Date(2022, 6, 2, 0, 0, 0, 0, time.UTC), next("@daily"))
+	assert.Equal(time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC), next("@weekly"))
+	assert.Equal(time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC), next("0 0 * * 7"))
+	assert.Equal(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC), next("@monthly"))
+	assert.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), next("@yearly"))
+	assert.Equal(time.Date(2022, 6, 2, 2, 0, 0, 0, time.UTC), next("0 2 * * 1-5"))
+	assert.Equal(start.Add(90*time.Second), next("@every 90s"))
+
+	// Day of month or day of week if both are restricted
+	assert.Equal(time.Date(2022, 6, 3, 0, 0, 0, 0, time.UTC), next("0 0 15 * 5"))
+
+	// Leap days
+	assert.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), next("0 0 29 2 *"))
+
+	// Never
+	assert.True(next("0 0 30 2 *").IsZero())
+}
@@ -0,0 +1,272 @@
+// Package scheduler runs recurring tasks of the zebra server, such as lease
+// reaping and backups, on cron schedules.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobExists   = errors.New("job already exists")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobRunning  = errors.New("job is already running")
+	ErrNoTask      = errors.New("job has no task")
+	ErrJobPanic    = errors.New("job panicked")
+)
+
+// Task is the work of a job. It returns a short description of the result.
+type Task func(ctx context.Context) (string, error)
+
+// Status is the state of a job and the outcome of its last run.
+type Status struct {
+	Name         string        `json:"name"`
+	Schedule     string        `json:"schedule"`
+	Running      bool          `json:"running"`
+	Next         time.Time     `json:"next"`
+	LastRun      time.Time     `json:"lastRun,omitempty"`
+	LastDuration time.Duration `json:"lastDuration,omitempty"`
+	LastResult   string        `json:"lastResult,omitempty"`
+	LastError    string        `json:"lastError,omitempty"`
+	Runs         int           `json:"runs"`
+	Failures     int           `json:"failures"`
+}
+
+type job struct {
+	schedule Schedule
+	task     Task
+	status   Status
+}
+
+// Scheduler runs jobs when they are due, a job never runs concurrently with
+// itself. Runs that are missed while a job is still running are skipped.
+type Scheduler struct {
+	lock sync.Mutex
+	jobs map[string]*job
+	now  func() time.Time
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		lock: sync.Mutex{},
+		jobs: make(map[string]*job),
+		now:  time.Now,
+		wake: make(chan struct{}, 1),
+		wg:   sync.WaitGroup{},
+	}
+}
+
+// Add adds a job running the task on the schedule of the cron expression.
+func (s *Scheduler) Add(name string, expr string, task Task) error {
+	if task == nil {
+		return ErrNoTask
+	}
+
+	schedule, err := Parse(expr)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.jobs[name]; ok {
+		return ErrJobExists
+	}
+
+	s.jobs[name] = &job{
+		schedule: schedule,
+		task:     task,
+		status:   Status{Name: name, Schedule: expr, Next: schedule.Next(s.now())},
+	}
+
+	s.notify()
+
+	return nil
+}
+
+// Statuses returns the status of all jobs, sorted by name.
+func (s *Scheduler) Statuses() []Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses
+}
+
+// Status returns the status of the named job.
+func (s *Scheduler) Status(name string) (Status, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return Status{}, false
+	}
+
+	return j.status, true
+}
+
+// Trigger starts a run of the named job now, outside of its schedule, and
+// returns without waiting for it.
+func (s *Scheduler) Trigger(ctx context.Context, name string) error {
+	j, err := s.claim(name)
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, j)
+	}()
+
+	return nil
+}
+
+// Run runs the named job now and returns its status once it is done.
+func (s *Scheduler) Run(ctx context.Context, name string) (Status, error) {
+	j, err := s.claim(name)
+	if err != nil {
+		return Status{}, err
+	}
+
+	s.run(ctx, j)
+
+	status, _ := s.Status(name)
+
+	return status, nil
+}
+
+// Start runs the due jobs until the context is done, it does not block.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			timer := time.NewTimer(s.runDue(ctx))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-s.wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Wait waits for the scheduler and the running jobs to stop.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// claim marks the named job as running.
+func (s *Scheduler) claim(name string) (*job, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	j, ok := s.jobs[name]
+
+	switch {
+	case !ok:
+		return nil, ErrJobNotFound
+	case j.status.Running:
+		return nil, ErrJobRunning
+	}
+
+	j.status.Running = true
+
+	return j, nil
+}
+
+// runDue starts the jobs that are due and returns how long to wait until the
+// next job is due.
+func (s *Scheduler) runDue(ctx context.Context) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	wait := time.Hour
+
+	for _, j := range s.jobs {
+		if !j.status.Next.IsZero() && !j.status.Next.After(now) {
+			j.status.Next = j.schedule.Next(now)
+
+			if !j.status.Running {
+				j.status.Running = true
+				s.wg.Add(1)
+
+				go func(j *job) {
+					defer s.wg.Done()
+					s.run(ctx, j)
+				}(j)
+			}
+		}
+
+		if !j.status.Next.IsZero() && j.status.Next.Sub(now) < wait {
+			wait = j.status.Next.Sub(now)
+		}
+	}
+
+	return wait
+}
+
+// run runs the task of a claimed job and records the outcome.
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	start := s.now()
+	result, err := runTask(ctx, j.task)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	j.status.Running = false
+	j.status.LastRun = start
+	j.status.LastDuration = s.now().Sub(start)
+	j.status.LastResult = result
+	j.status.LastError = ""
+	j.status.Runs++
+
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.status.Failures++
+	}
+}
+
+// runTask runs the task, a panic of the task fails the run instead of the
+// server.
+func runTask(ctx context.Context, task Task) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrJobPanic, r)
+		}
+	}()
+
+	return task(ctx)
+}
+
+// notify wakes up the scheduler loop to pick up a changed job list.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
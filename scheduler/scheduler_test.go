@@ -0,0 +1,110 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTask = errors.New("task failed")
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := scheduler.New()
+	ok := func(ctx context.Context) (string, error) { return "done", nil }
+	fail := func(ctx context.Context) (string, error) { return "", errTask }
+	panics := func(ctx context.Context) (string, error) { panic("oops") }
+
+	assert.Nil(s.Add("ok", "@daily", ok))
+	assert.Nil(s.Add("fail", "@daily", fail))
+	assert.Nil(s.Add("panics", "@daily", panics))
+	assert.ErrorIs(s.Add("ok", "@daily", ok), scheduler.ErrJobExists)
+	assert.ErrorIs(s.Add("bad", "@sometimes", ok), scheduler.ErrInvalidSchedule)
+	assert.ErrorIs(s.Add("none", "@daily", nil), scheduler.ErrNoTask)
+
+	status, err := s.Run(context.Background(), "ok")
+	assert.Nil(err)
+	assert.Equal("done", status.LastResult)
+	assert.Equal(1, status.Runs)
+	assert.False(status.Running)
+	assert.False(status.Next.IsZero())
+
+	status, err = s.Run(context.Background(), "fail")
+	assert.Nil(err)
+	assert.Equal(errTask.Error(), status.LastError)
+	assert.Equal(1, status.Failures)
+
+	status, err = s.Run(context.Background(), "panics")
+	assert.Nil(err)
+	assert.Contains(status.LastError, scheduler.ErrJobPanic.Error())
+
+	_, err = s.Run(context.Background(), "missing")
+	assert.ErrorIs(err, scheduler.ErrJobNotFound)
+
+	names := []string{}
+	for _, st := range s.Statuses() {
+		names = append(names, st.Name)
+	}
+
+	assert.Equal([]string{"fail", "ok", "panics"}, names)
+
+	_, found := s.Status("missing")
+	assert.False(found)
+}
+
+func TestTrigger(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := scheduler.New()
+	release := make(chan struct{})
+	slow := func(ctx context.Context) (string, error) {
+		<-release
+
+		return "slow", nil
+	}
+
+	assert.Nil(s.Add("slow", "@daily", slow))
+	assert.Nil(s.Trigger(context.Background(), "slow"))
+	assert.ErrorIs(s.Trigger(context.Background(), "slow"), scheduler.ErrJobRunning)
+	assert.ErrorIs(s.Trigger(context.Background(), "missing"), scheduler.ErrJobNotFound)
+
+	status, _ := s.Status("slow")
+	assert.True(status.Running)
+
+	close(release)
+	s.Wait()
+
+	status, _ = s.Status("slow")
+	assert.False(status.Running)
+	assert.Equal("slow", status.LastResult)
+}
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := scheduler.New()
+	runs := int32(0)
+
+	assert.Nil(s.Add("tick", "@every 1s", func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&runs, 1)
+
+		return "", nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&runs) >= 2 }, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	s.Wait()
+}
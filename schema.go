@@ -0,0 +1,113 @@
+package zebra
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersionField is the field of the JSON of a resource which holds the
+// schema version of its type the resource was written with.
+const SchemaVersionField = "schemaVersion"
+
+// Migration up-converts the JSON fields of a resource from one schema version
+// of its type to the next one. Fields are keyed by their JSON name.
+type Migration func(fields map[string]json.RawMessage) error
+
+// SchemaVersion returns the current schema version of the type, the number
+// of migrations it has. Types without migrations are at version 0.
+func (t *Type) SchemaVersion() int {
+	return len(t.Migrations)
+}
+
+// Migrate up-converts the JSON of a resource of the type written with the
+// given schema version to the current version, and sets its schema version.
+func (t *Type) Migrate(data []byte, version int) ([]byte, error) {
+	current := t.SchemaVersion()
+
+	if version < 0 || version > current {
+		return nil, &DecodeError{Type: t.Name, Err: ErrSchemaVersion, Detail: fmt.Sprintf("%d", version)}
+	}
+
+	if version == current {
+		return data, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, &DecodeError{Type: t.Name, Err: ErrMalformed, Detail: err.Error()}
+	}
+
+	for v := version; v < current; v++ {
+		if err := t.Migrations[v](fields); err != nil {
+			return nil, &DecodeError{Type: t.Name, Err: ErrMigration, Detail: fmt.Sprintf("to %d: %s", v+1, err)}
+		}
+	}
+
+	fields[SchemaVersionField] = json.RawMessage(fmt.Sprintf("%d", current))
+
+	return json.Marshal(fields)
+}
+
+// RenameField returns a migration that moves a field to a new name. Resources
+// without the field are left as they are.
+func RenameField(from string, to string) Migration {
+	return func(fields map[string]json.RawMessage) error {
+		value, ok := fields[from]
+		if !ok {
+			return nil
+		}
+
+		delete(fields, from)
+		fields[to] = value
+
+		return nil
+	}
+}
+
+// LabelToField returns a migration that moves the value of a label into a
+// string field. Resources without the label are left as they are.
+func LabelToField(label string, field string) Migration {
+	return func(fields map[string]json.RawMessage) error {
+		raw, ok := fields["labels"]
+		if !ok {
+			return nil
+		}
+
+		labels := Labels{}
+		if err := json.Unmarshal(raw, &labels); err != nil {
+			return err
+		}
+
+		value, ok := labels[label]
+		if !ok {
+			return nil
+		}
+
+		delete(labels, label)
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		fields[field] = data
+
+		fields["labels"], err = json.Marshal(labels)
+
+		return err
+	}
+}
+
+// StampSchemaVersion sets the schema version of a resource to the current
+// version of its type, so that it is stored with the version it was made
+// with. Resources which do not embed a BaseResource are left as they are.
+func StampSchemaVersion(factory ResourceFactory, res Resource) {
+	versioned, ok := res.(interface{ SetSchemaVersion(version int) })
+	if !ok || factory == nil {
+		return
+	}
+
+	if t, ok := factory.Type(res.GetType()); ok {
+		versioned.SetSchemaVersion(t.SchemaVersion())
+	}
+}
@@ -0,0 +1,77 @@
+package zebra_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/stretchr/testify/assert"
+)
+
+var errNoRoom = errors.New("no room")
+
+// migratedFactory has a Lab type whose first version kept the name in the
+// title field, and whose second version kept the name in a label.
+func migratedFactory() zebra.ResourceFactory {
+	labType := dc.LabType()
+	labType.Migrations = []zebra.Migration{
+		zebra.RenameField("title", "name"),
+		zebra.LabelToField("lab.name", "name"),
+	}
+
+	return zebra.Factory().Add(labType)
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	labType, _ := migratedFactory().Type("Lab")
+	assert.Equal(2, labType.SchemaVersion())
+
+	decoder := zebra.NewDecoder(migratedFactory())
+	decoder.Stored = true
+
+	// Stored resources without a version are of the first version
+	res, err := decoder.Decode([]byte(`{"id": "lab1", "type": "Lab", "title": "first"}`))
+	assert.Nil(err)
+	assert.Equal("first", res.(*dc.Lab).Name)
+	assert.Equal(2, res.(*dc.Lab).SchemaVersion)
+
+	res, err = decoder.Decode([]byte(
+		`{"id": "lab1", "type": "Lab", "schemaVersion": 1, "labels": {"lab.name": "second", "system.group": "g"}}`))
+	assert.Nil(err)
+	assert.Equal("second", res.(*dc.Lab).Name)
+	assert.Equal(zebra.Labels{"system.group": "g"}, res.GetLabels())
+
+	// Other resources without a version are of the current version
+	_, err = zebra.DecodeResource(migratedFactory(), []byte(`{"id": "lab1", "type": "Lab", "title": "first"}`))
+	assert.ErrorIs(err, zebra.ErrUnknownField)
+
+	res, err = zebra.DecodeResource(migratedFactory(), []byte(`{"id": "lab1", "type": "Lab", "name": "third"}`))
+	assert.Nil(err)
+	assert.Equal(2, res.(*dc.Lab).SchemaVersion)
+
+	data, err := json.Marshal(res)
+	assert.Nil(err)
+	assert.Contains(string(data), `"schemaVersion":2`)
+
+	_, err = decoder.Decode([]byte(`{"id": "lab1", "type": "Lab", "schemaVersion": 3}`))
+	assert.ErrorIs(err, zebra.ErrSchemaVersion)
+
+	// Failed migrations name the version they failed to reach
+	labType.Migrations = append(labType.Migrations, func(map[string]json.RawMessage) error { return errNoRoom })
+	_, err = zebra.NewDecoder(zebra.Factory().Add(labType)).Decode(
+		[]byte(`{"id": "lab1", "type": "Lab", "schemaVersion": 2}`))
+	assert.ErrorIs(err, zebra.ErrMigration)
+	assert.Contains(err.Error(), "to 3")
+
+	// Types without migrations are at version 0, which is left out
+	res, err = zebra.DecodeResource(decodeFactory(), []byte(`{"id": "lab1", "type": "Lab", "name": "lab"}`))
+	assert.Nil(err)
+
+	data, _ = json.Marshal(res)
+	assert.NotContains(string(data), "schemaVersion")
+}
@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SealedPrefix marks the values sealed by a Cipher.
+const SealedPrefix = "sealed:"
+
+var ErrSealed = errors.New("sealed value can not be opened")
+
+// Cipher seals values with AES-GCM under the current key of a keyring. The
+// sealed value names the fingerprint of its key, so that values sealed
+// before a rotation are opened with the previous key of the keyring, or with
+// one of the retired keys, until they are sealed again with the current key.
+type Cipher struct {
+	keys    *Keyring
+	retired []string
+}
+
+// NewCipher returns a cipher of the keys of the keyring and of the retired
+// keys, which are only used to open values.
+func NewCipher(keys *Keyring, retired []string) *Cipher {
+	return &Cipher{keys: keys, retired: retired}
+}
+
+// Keyring returns the keyring of the cipher.
+func (c *Cipher) Keyring() *Keyring {
+	return c.keys
+}
+
+// Seal returns the value sealed with the current key.
+func (c *Cipher) Seal(value string) (string, error) {
+	key := c.keys.Current()
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+
+	return SealedPrefix + fingerprint(key) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the value that was sealed, and whether it should be sealed
+// again, because it was sealed with a key other than the current key. Values
+// that are not sealed, stored before sealing was set up, are returned as they
+// are and should be sealed.
+func (c *Cipher) Open(value string) (string, bool, error) {
+	if !strings.HasPrefix(value, SealedPrefix) {
+		return value, true, nil
+	}
+
+	fp, data, ok := strings.Cut(strings.TrimPrefix(value, SealedPrefix), ":")
+	if !ok {
+		return "", false, ErrSealed
+	}
+
+	key, current := c.key(fp)
+	if key == "" {
+		return "", false, fmt.Errorf("%w: no key of fingerprint %s", ErrSealed, fp)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return "", false, ErrSealed
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", false, ErrSealed
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %s", ErrSealed, err.Error())
+	}
+
+	return string(plain), !current, nil
+}
+
+// key returns the key of the fingerprint and whether it is the current key,
+// or "" if the cipher has no such key.
+func (c *Cipher) key(fp string) (string, bool) {
+	if current := c.keys.Current(); fingerprint(current) == fp {
+		return current, true
+	}
+
+	keys := append([]string{c.keys.Previous(time.Now())}, c.retired...)
+
+	for _, key := range keys {
+		if key != "" && fingerprint(key) == fp {
+			return key, false
+		}
+	}
+
+	return "", false
+}
+
+// newAEAD returns the AES-256-GCM cipher of the key, which may be of any
+// length, it is hashed to the size of an AES-256 key.
+func newAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// fingerprint identifies the key of sealed values without revealing it.
+func fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	sum = sha256.Sum256(sum[:])
+
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Exec is a secret printed by a command, such as
+//
+//	gcloud secrets versions access latest --secret zebra-auth-key
+//
+// The version of the secret is a digest of its value.
+type Exec struct {
+	Command []string
+	Timeout time.Duration
+}
+
+func (e *Exec) Fetch(ctx context.Context) (*Secret, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...).Output() //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Command[0], err)
+	}
+
+	value := strings.TrimSpace(string(out))
+	if value == "" {
+		return nil, fmt.Errorf("%w: %s printed nothing", ErrMissing, e.Command[0])
+	}
+
+	return &Secret{Value: value, Version: digest(value)}, nil
+}
+
+// digest returns a short digest of the value, the version of secrets of
+// backends without versions.
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Keyring is a key fetched from a source. Refreshing the keyring fetches the
+// key again, and if its version changed, the new key becomes the current key
+// and the old key stays valid for the grace period.
+type Keyring struct {
+	lock     sync.RWMutex
+	source   Source
+	grace    time.Duration
+	current  *Secret
+	previous *Secret
+	fetched  time.Time
+	rotated  time.Time
+}
+
+// Status describes the keys of a keyring, without their values.
+type Status struct {
+	Version         string    `json:"version"`
+	PreviousVersion string    `json:"previousVersion,omitempty"`
+	Fetched         time.Time `json:"fetched"`
+	Rotated         time.Time `json:"rotated,omitempty"`
+	PreviousValid   time.Time `json:"previousValidUntil,omitempty"`
+}
+
+// NewKeyring fetches the key from the source and returns a keyring of it.
+func NewKeyring(ctx context.Context, source Source, grace time.Duration, now time.Time) (*Keyring, error) {
+	secret, err := source.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keyring{
+		lock:     sync.RWMutex{},
+		source:   source,
+		grace:    grace,
+		current:  secret,
+		previous: nil,
+		fetched:  now,
+		rotated:  time.Time{},
+	}, nil
+}
+
+// Refresh fetches the key again and returns true if it was rotated.
+func (k *Keyring) Refresh(ctx context.Context, now time.Time) (bool, error) {
+	secret, err := k.source.Fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.fetched = now
+
+	if secret.Version == k.current.Version && secret.Value == k.current.Value {
+		return false, nil
+	}
+
+	k.previous, k.current, k.rotated = k.current, secret, now
+
+	return true, nil
+}
+
+// Current returns the current key.
+func (k *Keyring) Current() string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	return k.current.Value
+}
+
+// Previous returns the key before the last rotation while it is in its grace
+// period, or "".
+func (k *Keyring) Previous(now time.Time) string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	if k.previous == nil || now.After(k.rotated.Add(k.grace)) {
+		return ""
+	}
+
+	return k.previous.Value
+}
+
+// Status returns the versions of the keys and when they were fetched and
+// rotated.
+func (k *Keyring) Status() Status {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	status := Status{
+		Version:         k.current.Version,
+		PreviousVersion: "",
+		Fetched:         k.fetched,
+		Rotated:         k.rotated,
+		PreviousValid:   time.Time{},
+	}
+
+	if k.previous != nil {
+		status.PreviousVersion = k.previous.Version
+		status.PreviousValid = k.rotated.Add(k.grace)
+	}
+
+	return status
+}
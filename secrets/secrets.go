@@ -0,0 +1,110 @@
+// Package secrets fetches keys from secret backends rather than from the
+// configuration file.
+//
+// Keys are read from HashiCorp Vault, with the KV secrets engine, or from the
+// output of a command, such as the CLI of a cloud KMS or secret manager. A
+// Keyring fetches the key again to pick up rotations and keeps the previous
+// key for a grace period, so that what was signed with it still verifies. A
+// Cipher seals values, such as the credentials of resources, with the key of
+// a Keyring.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Backends of secrets.
+const (
+	BackendStatic = "static"
+	BackendVault  = "vault"
+	BackendExec   = "exec"
+)
+
+// DefaultTimeout is the timeout of fetching a secret if none is configured.
+const DefaultTimeout = 10 * time.Second
+
+var (
+	ErrBackend = errors.New("unknown secret backend")
+	ErrConfig  = errors.New("invalid secret configuration")
+	ErrMissing = errors.New("secret not found")
+	ErrVault   = errors.New("vault request failed")
+)
+
+// Secret is a value of a backend and its version. Versions are compared to
+// tell whether a secret was rotated.
+type Secret struct {
+	Value   string
+	Version string
+}
+
+// Source is a backend a secret is fetched from.
+type Source interface {
+	Fetch(ctx context.Context) (*Secret, error)
+}
+
+// Config selects the backend of a secret and where the secret is kept. For
+// Vault, Path is the API path of the secret, such as secret/data/zebra for
+// the KV engine mounted at secret/, and Field the key of the value in it.
+// The Vault token is read from the VAULT_TOKEN environment variable if it is
+// not given. For exec, Command is run and its output, without surrounding
+// white space, is the secret. A static secret is just the Value.
+type Config struct {
+	Backend string   `json:"backend"`
+	Value   string   `json:"value,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Field   string   `json:"field,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// New returns the source of the configured backend.
+func New(cfg *Config) (Source, error) {
+	timeout := DefaultTimeout
+
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: timeout %s", ErrConfig, cfg.Timeout)
+		}
+
+		timeout = d
+	}
+
+	switch cfg.Backend {
+	case BackendStatic:
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("%w: static secret has no value", ErrConfig)
+		}
+
+		return &Static{Value: cfg.Value}, nil
+	case BackendVault:
+		if cfg.Address == "" || cfg.Path == "" || cfg.Field == "" {
+			return nil, fmt.Errorf("%w: vault address, path and field are required", ErrConfig)
+		}
+
+		return NewVault(cfg.Address, cfg.Token, cfg.Path, cfg.Field, &http.Client{Timeout: timeout}), nil
+	case BackendExec:
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("%w: exec secret has no command", ErrConfig)
+		}
+
+		return &Exec{Command: cfg.Command, Timeout: timeout}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrBackend, cfg.Backend)
+}
+
+// Static is a secret given in the configuration.
+type Static struct {
+	Value string
+}
+
+func (s *Static) Fetch(ctx context.Context) (*Secret, error) {
+	return &Secret{Value: s.Value, Version: digest(s.Value)}, nil
+}
@@ -0,0 +1,179 @@
+package secrets_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for _, cfg := range []*secrets.Config{
+		{Backend: "file"},
+		{Backend: secrets.BackendStatic},
+		{Backend: secrets.BackendVault, Address: "http://vault:8200"},
+		{Backend: secrets.BackendExec},
+		{Backend: secrets.BackendStatic, Value: "key", Timeout: "never"},
+	} {
+		_, err := secrets.New(cfg)
+		assert.NotNil(err)
+	}
+
+	source, err := secrets.New(&secrets.Config{Backend: secrets.BackendExec, Command: []string{"echo", " key "}})
+	assert.Nil(err)
+
+	secret, err := source.Fetch(context.Background())
+	assert.Nil(err)
+	assert.Equal("key", secret.Value)
+	assert.NotEmpty(secret.Version)
+}
+
+func TestVault(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	version := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("X-Vault-Token") != "root":
+			rw.WriteHeader(http.StatusForbidden)
+		case req.URL.Path == "/v1/secret/data/zebra":
+			if atomic.LoadInt32(&version) == 1 {
+				_, _ = rw.Write([]byte(`{"data":{"data":{"authKey":"one"},"metadata":{"version":1}}}`))
+			} else {
+				_, _ = rw.Write([]byte(`{"data":{"data":{"authKey":"two"},"metadata":{"version":2}}}`))
+			}
+		case req.URL.Path == "/v1/kv/zebra":
+			_, _ = rw.Write([]byte(`{"data":{"authKey":"old"}}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer server.Close()
+
+	ctx := context.Background()
+	secret, err := secrets.NewVault(server.URL, "root", "kv/zebra", "authKey", server.Client()).Fetch(ctx)
+	assert.Nil(err)
+	assert.Equal("old", secret.Value)
+
+	_, err = secrets.NewVault(server.URL, "root", "kv/other", "authKey", server.Client()).Fetch(ctx)
+	assert.ErrorIs(err, secrets.ErrMissing)
+
+	_, err = secrets.NewVault(server.URL, "root", "kv/zebra", "jwt", server.Client()).Fetch(ctx)
+	assert.ErrorIs(err, secrets.ErrMissing)
+
+	_, err = secrets.NewVault(server.URL, "bad", "kv/zebra", "authKey", server.Client()).Fetch(ctx)
+	assert.ErrorIs(err, secrets.ErrVault)
+
+	// The keyring keeps the previous key for the grace period after rotation
+	now := time.Now()
+	source := secrets.NewVault(server.URL+"/", "root", "/secret/data/zebra", "authKey", server.Client())
+	keyring, err := secrets.NewKeyring(ctx, source, time.Minute, now)
+	assert.Nil(err)
+	assert.Equal("one", keyring.Current())
+	assert.Equal("1", keyring.Status().Version)
+
+	rotated, err := keyring.Refresh(ctx, now)
+	assert.Nil(err)
+	assert.False(rotated)
+
+	atomic.StoreInt32(&version, 2)
+
+	rotated, err = keyring.Refresh(ctx, now)
+	assert.Nil(err)
+	assert.True(rotated)
+	assert.Equal("two", keyring.Current())
+	assert.Equal("one", keyring.Previous(now))
+	assert.Equal("", keyring.Previous(now.Add(2*time.Minute)))
+	assert.Equal("1", keyring.Status().PreviousVersion)
+}
+
+// keySource is a source whose key is rotated by the test.
+type keySource struct {
+	key string
+}
+
+func (s *keySource) Fetch(ctx context.Context) (*secrets.Secret, error) {
+	return &secrets.Secret{Value: s.key, Version: s.key}, nil
+}
+
+func staticKeyring(assert *assert.Assertions, key string) *secrets.Keyring {
+	keyring, err := secrets.NewKeyring(context.Background(), &keySource{key: key}, time.Hour, time.Now())
+	assert.Nil(err)
+
+	return keyring
+}
+
+func TestCipher(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	source := &keySource{key: "one"}
+	keyring, err := secrets.NewKeyring(ctx, source, time.Hour, time.Now())
+	assert.Nil(err)
+
+	cipher := secrets.NewCipher(keyring, []string{"zero"})
+
+	sealed, err := cipher.Seal("Passw0rd!Passw0rd")
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(sealed, secrets.SealedPrefix))
+	assert.NotContains(sealed, "Passw0rd")
+
+	again, err := cipher.Seal("Passw0rd!Passw0rd")
+	assert.Nil(err)
+	assert.NotEqual(sealed, again)
+
+	opened, reseal, err := cipher.Open(sealed)
+	assert.Nil(err)
+	assert.False(reseal)
+	assert.Equal("Passw0rd!Passw0rd", opened)
+
+	// Values stored before sealing are returned as they are, to be sealed
+	opened, reseal, err = cipher.Open("plain")
+	assert.Nil(err)
+	assert.True(reseal)
+	assert.Equal("plain", opened)
+
+	// Values of the previous key are opened after rotation, to be sealed again
+	source.key = "two"
+
+	rotated, err := keyring.Refresh(ctx, time.Now())
+	assert.Nil(err)
+	assert.True(rotated)
+
+	opened, reseal, err = cipher.Open(sealed)
+	assert.Nil(err)
+	assert.True(reseal)
+	assert.Equal("Passw0rd!Passw0rd", opened)
+
+	// And so are those of retired keys
+	retired, err := secrets.NewCipher(staticKeyring(assert, "zero"), nil).Seal("old")
+	assert.Nil(err)
+
+	opened, reseal, err = cipher.Open(retired)
+	assert.Nil(err)
+	assert.True(reseal)
+	assert.Equal("old", opened)
+
+	unknown, err := secrets.NewCipher(staticKeyring(assert, "other"), nil).Seal("lost")
+	assert.Nil(err)
+
+	_, _, err = cipher.Open(unknown)
+	assert.ErrorIs(err, secrets.ErrSealed)
+
+	for _, bad := range []string{"sealed:nope", sealed[:len(sealed)-4], sealed + "!"} {
+		_, _, err = cipher.Open(bad)
+		assert.ErrorIs(err, secrets.ErrSealed)
+	}
+}
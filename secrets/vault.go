@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// VaultTokenEnv is the environment variable of the Vault token.
+const VaultTokenEnv = "VAULT_TOKEN"
+
+// Vault is a secret of the KV secrets engine of HashiCorp Vault. Both
+// versions of the engine are read, the version of secrets of version 1 is a
+// digest of the value.
+type Vault struct {
+	address string
+	token   string
+	path    string
+	field   string
+	client  *http.Client
+}
+
+// NewVault returns the secret at the path of the Vault server with the field
+// as its value.
+func NewVault(address string, token string, path string, field string, client *http.Client) *Vault {
+	return &Vault{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		path:    strings.Trim(path, "/"),
+		field:   field,
+		client:  client,
+	}
+}
+
+// vaultResponse is a read of the KV engine. Version 2 nests the secret and
+// its metadata in data.
+type vaultResponse struct {
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+func (v *Vault) Fetch(ctx context.Context) (*Secret, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := v.token
+	if token == "" {
+		token = os.Getenv(VaultTokenEnv)
+	}
+
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrMissing, v.path)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s %s", ErrVault, v.path, resp.Status)
+	}
+
+	body := &vaultResponse{Data: nil}
+	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return nil, err
+	}
+
+	data := body.Data
+
+	// Secrets of version 2 have their data and metadata nested
+	if nested, ok := body.Data["data"]; ok {
+		if _, isV2 := body.Data["metadata"]; isV2 {
+			return v.secretV2(nested, body.Data["metadata"])
+		}
+	}
+
+	value, err := field(data, v.field)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Secret{Value: value, Version: digest(value)}, nil
+}
+
+func (v *Vault) secretV2(data json.RawMessage, metadata json.RawMessage) (*Secret, error) {
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	value, err := field(values, v.field)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &struct {
+		Version int `json:"version"`
+	}{Version: 0}
+
+	if err := json.Unmarshal(metadata, meta); err != nil {
+		return nil, err
+	}
+
+	return &Secret{Value: value, Version: strconv.Itoa(meta.Version)}, nil
+}
+
+func field(values map[string]json.RawMessage, name string) (string, error) {
+	raw, ok := values[name]
+	if !ok {
+		return "", fmt.Errorf("%w: field %s", ErrMissing, name)
+	}
+
+	value := ""
+	if err := json.Unmarshal(raw, &value); err != nil || value == "" {
+		return "", fmt.Errorf("%w: field %s is not a string", ErrMissing, name)
+	}
+
+	return value, nil
+}
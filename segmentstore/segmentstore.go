@@ -0,0 +1,757 @@
+// Package segmentstore stores zebra resources in packed, append-only segment
+// files instead of one file per resource.
+//
+// Every create or delete appends a record to the active segment. Once the
+// active segment reaches the segment size it is sealed and a new one is
+// started. An in-memory index maps each resource to its latest record, it is
+// rebuilt from the segments on initialization. Records that are superseded
+// or deleted are garbage, compaction copies the live records out of sealed
+// segments with too much garbage and removes them.
+//
+// Each record is a line of the CRC-32 of the record, in hex, followed by the
+// record as JSON. A torn record at the end of the last segment, left by a
+// crash during a write, is truncated on initialization.
+package segmentstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/filestore"
+)
+
+const (
+	RWRR = os.FileMode(0o644)
+
+	// DefaultSegmentSize is the size at which the active segment is sealed.
+	DefaultSegmentSize = 64 << 20
+
+	// DefaultGarbageRatio is the fraction of garbage at which a sealed
+	// segment is compacted.
+	DefaultGarbageRatio = 0.5
+
+	// SegmentsDir holds the segments in the storage root. MigratedDir is
+	// where the per-file layout is moved to once migrated to segments.
+	SegmentsDir = "segments"
+	MigratedDir = "resources.migrated"
+
+	segmentPrefix = "segment-"
+	segmentSuffix = ".log"
+	crcSize       = 8
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+var (
+	ErrFactoryNil = errors.New("resource factory is nil for segmentstore")
+	ErrCorrupt    = errors.New("segment record is corrupt")
+)
+
+type record struct {
+	Op   string          `json:"op"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// location is where the latest record of a resource is stored.
+type location struct {
+	segment int
+	offset  int64
+	size    int64
+}
+
+type segment struct {
+	id   int
+	size int64
+	live int64
+}
+
+// SegmentStore implements the store backend with segment files.
+type SegmentStore struct {
+	lock         sync.Mutex
+	storageRoot  string
+	factory      zebra.ResourceFactory
+	segmentSize  int64
+	garbageRatio float64
+	segments     []*segment
+	index        map[string]location
+	active       *os.File
+}
+
+// NewSegmentStore returns a segment store in the storage root which seals
+// segments at the given size, or DefaultSegmentSize if not positive.
+func NewSegmentStore(root string, resourceFactory zebra.ResourceFactory, segmentSize int64) *SegmentStore {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	return &SegmentStore{
+		lock:         sync.Mutex{},
+		storageRoot:  root,
+		factory:      resourceFactory,
+		segmentSize:  segmentSize,
+		garbageRatio: DefaultGarbageRatio,
+		segments:     []*segment{},
+		index:        make(map[string]location),
+		active:       nil,
+	}
+}
+
+// Initialize creates the store if it does not exist, migrates resources of
+// the per-file layout into segments and builds the index.
+func (s *SegmentStore) Initialize() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.migrate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.segmentsPath(), os.ModePerm); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// Wipe removes the store.
+func (s *SegmentStore) Wipe() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.close()
+
+	return os.RemoveAll(s.segmentsPath())
+}
+
+// Clear deletes all resources, leaving an empty store.
+func (s *SegmentStore) Clear() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.close()
+
+	if err := os.RemoveAll(s.segmentsPath()); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.segmentsPath(), os.ModePerm); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// Load returns the stored resources as a ResourceMap where keys are types.
+// Resources that fail to unpack are skipped and the last such error is
+// returned along with the other resources. The records are unpacked by a
+// worker per CPU in parallel.
+func (s *SegmentStore) Load() (*zebra.ResourceMap, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.factory == nil {
+		return nil, ErrFactoryNil
+	}
+
+	records := make(chan record)
+	results := make(chan unpacked)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for rec := range records {
+				res, err := s.unpackResource(rec.Data)
+				results <- unpacked{res: res, err: err}
+			}
+		}()
+	}
+
+	var readErr error
+
+	go func() {
+		readErr = s.forEachLive(func(rec record) { records <- rec })
+
+		close(records)
+		wg.Wait()
+		close(results)
+	}()
+
+	var retErr error
+
+	resources := zebra.NewResourceMap(s.factory)
+
+	for result := range results {
+		if result.err != nil {
+			retErr = result.err
+
+			continue
+		}
+
+		resources.Add(result.res, result.res.GetType())
+	}
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	return resources, retErr
+}
+
+type unpacked struct {
+	res zebra.Resource
+	err error
+}
+
+// Create stores the resource, replacing a stored resource with the same ID.
+func (s *SegmentStore) Create(res zebra.Resource) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.put(record{Op: opPut, ID: res.GetID(), Data: data})
+}
+
+// Delete removes the resource. Deleting a resource that is not stored does
+// nothing.
+func (s *SegmentStore) Delete(res zebra.Resource) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, ok := s.index[res.GetID()]
+	if !ok {
+		return nil
+	}
+
+	if _, _, err := s.append(record{Op: opDelete, ID: res.GetID(), Data: nil}); err != nil {
+		return err
+	}
+
+	s.segment(old.segment).live -= old.size
+	delete(s.index, res.GetID())
+
+	return nil
+}
+
+// Compact rewrites the sealed segments with too much garbage and returns the
+// number of bytes reclaimed.
+func (s *SegmentStore) Compact() (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	reclaimed := int64(0)
+
+	for _, seg := range append([]*segment{}, s.segments...) {
+		if seg == s.activeSegment() || float64(seg.size-seg.live) < s.garbageRatio*float64(seg.size) {
+			continue
+		}
+
+		before := s.size()
+
+		if err := s.compact(seg); err != nil {
+			return reclaimed, err
+		}
+
+		reclaimed += before - s.size()
+	}
+
+	return reclaimed, nil
+}
+
+// Stats returns the number of segments, their total size and the size of
+// the live records in bytes.
+func (s *SegmentStore) Stats() (int, int64, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	live := int64(0)
+
+	for _, seg := range s.segments {
+		live += seg.live
+	}
+
+	return len(s.segments), s.size(), live
+}
+
+// compact copies the live records and the needed deletes of the segment to
+// the active segment and removes it. A delete is needed unless the resource
+// was created again, or this is the oldest segment so that there is no older
+// record of the resource left to delete.
+func (s *SegmentStore) compact(seg *segment) error {
+	oldest := seg == s.segments[0]
+	records := []record{}
+
+	err := s.scan(seg.id, func(rec record, offset int64, size int64) {
+		if rec.Op == opPut {
+			if loc, ok := s.index[rec.ID]; ok && loc.segment == seg.id && loc.offset == offset {
+				records = append(records, rec)
+			}
+
+			return
+		}
+
+		if _, ok := s.index[rec.ID]; !ok && !oldest {
+			records = append(records, rec)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if rec.Op == opPut {
+			if err := s.put(rec); err != nil {
+				return err
+			}
+		} else if _, _, err := s.append(rec); err != nil {
+			return err
+		}
+	}
+
+	for i, other := range s.segments {
+		if other == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+
+			break
+		}
+	}
+
+	return os.Remove(s.segmentPath(seg.id))
+}
+
+// put appends the record of a resource and points the index to it. Must be
+// called with the lock held.
+func (s *SegmentStore) put(rec record) error {
+	offset, size, err := s.append(rec)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := s.index[rec.ID]; ok {
+		s.segment(old.segment).live -= old.size
+	}
+
+	active := s.activeSegment()
+	active.live += size
+
+	s.index[rec.ID] = location{segment: active.id, offset: offset, size: size}
+
+	return nil
+}
+
+// append writes the record to the active segment, sealing it first if it is
+// full, and returns the offset and size of the record. Must be called with
+// the lock held.
+func (s *SegmentStore) append(rec record) (int64, int64, error) {
+	if s.activeSegment().size >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	line, err := encode(rec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	active := s.activeSegment()
+	offset := active.size
+
+	if _, err := s.active.Write(line); err != nil {
+		return 0, 0, err
+	}
+
+	active.size += int64(len(line))
+
+	return offset, int64(len(line)), nil
+}
+
+// rotate seals the active segment and starts a new one. Must be called with
+// the lock held.
+func (s *SegmentStore) rotate() error {
+	id := 1
+	if len(s.segments) != 0 {
+		id = s.activeSegment().id + 1
+	}
+
+	file, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, RWRR)
+	if err != nil {
+		return err
+	}
+
+	if s.active != nil {
+		if err := s.active.Close(); err != nil {
+			file.Close()
+
+			return err
+		}
+	}
+
+	s.active = file
+	s.segments = append(s.segments, &segment{id: id, size: 0, live: 0})
+
+	return nil
+}
+
+// open builds the index from the segments and opens the last segment for
+// appending. Must be called with the lock held.
+func (s *SegmentStore) open() error {
+	s.segments = []*segment{}
+	s.index = make(map[string]location)
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		seg := &segment{id: id, size: 0, live: 0}
+		s.segments = append(s.segments, seg)
+
+		err := s.scan(id, func(rec record, offset int64, size int64) {
+			if old, ok := s.index[rec.ID]; ok {
+				s.segment(old.segment).live -= old.size
+				delete(s.index, rec.ID)
+			}
+
+			if rec.Op == opPut {
+				s.index[rec.ID] = location{segment: id, offset: offset, size: size}
+				seg.live += size
+			}
+
+			seg.size = offset + size
+		})
+
+		// Only the last segment can have been torn by a crash
+		if errors.Is(err, ErrCorrupt) && i == len(ids)-1 {
+			err = os.Truncate(s.segmentPath(id), seg.size)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.segments) == 0 {
+		return s.rotate()
+	}
+
+	s.active, err = os.OpenFile(s.segmentPath(s.activeSegment().id), os.O_WRONLY|os.O_APPEND, RWRR)
+
+	return err
+}
+
+func (s *SegmentStore) close() {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+
+	s.segments = []*segment{}
+	s.index = make(map[string]location)
+}
+
+// scan calls f with each record of the segment, its offset and its size. If
+// a record is corrupt, ErrCorrupt is returned after the records before it.
+func (s *SegmentStore) scan(id int, f func(rec record, offset int64, size int64)) error {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		} else if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: segment %d offset %d", ErrCorrupt, id, offset)
+		} else if err != nil {
+			return err
+		}
+
+		rec, err := decode(line)
+		if err != nil {
+			return fmt.Errorf("%w: segment %d offset %d", err, id, offset)
+		}
+
+		f(rec, offset, int64(len(line)))
+
+		offset += int64(len(line))
+	}
+}
+
+// forEachLive calls f with the live records, reading each segment once.
+// Must be called with the lock held.
+func (s *SegmentStore) forEachLive(f func(rec record)) error {
+	bySegment := make(map[int][]location)
+
+	for _, loc := range s.index {
+		bySegment[loc.segment] = append(bySegment[loc.segment], loc)
+	}
+
+	for id, locs := range bySegment {
+		sort.Slice(locs, func(i, j int) bool { return locs[i].offset < locs[j].offset })
+
+		if err := s.readRecords(id, locs, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SegmentStore) readRecords(id int, locs []location, f func(rec record)) error {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	for _, loc := range locs {
+		line := make([]byte, loc.size)
+		if _, err := file.ReadAt(line, loc.offset); err != nil {
+			return err
+		}
+
+		rec, err := decode(line)
+		if err != nil {
+			return err
+		}
+
+		f(rec)
+	}
+
+	return nil
+}
+
+// migrate moves the resources of the per-file layout into segments, if the
+// store has no segments yet. The segments are written to a temporary
+// directory first, so that a failed migration can simply be run again. The
+// per-file layout is kept in MigratedDir. Must be called with the lock held.
+func (s *SegmentStore) migrate() error {
+	filesPath := path.Join(s.storageRoot, "resources")
+
+	if _, err := os.Stat(filesPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.segmentsPath()); err == nil {
+		// Migrated, but the per-file layout was not moved yet
+		return os.Rename(filesPath, path.Join(s.storageRoot, MigratedDir))
+	}
+
+	if s.factory == nil {
+		return ErrFactoryNil
+	}
+
+	resources, err := filestore.NewFileStore(s.storageRoot, s.factory).Load()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.segmentsPath() + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := writeSegment(path.Join(tmp, segmentName(1)), resources); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.segmentsPath()); err != nil {
+		return err
+	}
+
+	return os.Rename(filesPath, path.Join(s.storageRoot, MigratedDir))
+}
+
+// writeSegment writes the resources to a new segment file.
+func writeSegment(name string, resources *zebra.ResourceMap) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, RWRR)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+
+	for _, l := range resources.Resources {
+		for _, res := range l.Resources {
+			data, err := json.Marshal(res)
+			if err != nil {
+				file.Close()
+
+				return err
+			}
+
+			line, err := encode(record{Op: opPut, ID: res.GetID(), Data: data})
+			if err != nil {
+				file.Close()
+
+				return err
+			}
+
+			if _, err := writer.Write(line); err != nil {
+				file.Close()
+
+				return err
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s *SegmentStore) unpackResource(contents []byte) (zebra.Resource, error) {
+	decoder := zebra.NewDecoder(s.factory)
+	decoder.AllowUnknownFields = true
+	decoder.Stored = true
+
+	res, err := decoder.Decode(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := res.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s *SegmentStore) segmentIDs() ([]int, error) {
+	entries, err := os.ReadDir(s.segmentsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix))
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+func (s *SegmentStore) segment(id int) *segment {
+	for _, seg := range s.segments {
+		if seg.id == id {
+			return seg
+		}
+	}
+
+	// Records only ever point to existing segments
+	panic(fmt.Sprintf("segment %d not found", id))
+}
+
+func (s *SegmentStore) activeSegment() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *SegmentStore) size() int64 {
+	size := int64(0)
+
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+
+	return size
+}
+
+func (s *SegmentStore) segmentsPath() string {
+	return path.Join(s.storageRoot, SegmentsDir)
+}
+
+func (s *SegmentStore) segmentPath(id int) string {
+	return path.Join(s.segmentsPath(), segmentName(id))
+}
+
+func segmentName(id int) string {
+	return fmt.Sprintf("%s%06d%s", segmentPrefix, id, segmentSuffix)
+}
+
+func encode(rec record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	line := fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))
+
+	return append(append([]byte(line), data...), '\n'), nil
+}
+
+func decode(line []byte) (record, error) {
+	rec := record{Op: "", ID: "", Data: nil}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+
+	if len(line) < crcSize+1 || line[crcSize] != ' ' {
+		return rec, ErrCorrupt
+	}
+
+	crc, err := strconv.ParseUint(string(line[:crcSize]), 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE(line[crcSize+1:]) {
+		return rec, ErrCorrupt
+	}
+
+	if err := json.Unmarshal(line[crcSize+1:], &rec); err != nil {
+		return rec, ErrCorrupt
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,200 @@
+package segmentstore_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/filestore"
+	"github.com/project-safari/zebra/segmentstore"
+	"github.com/project-safari/zebra/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		s := segmentstore.NewSegmentStore(t.TempDir(), storetest.Factory(), 0)
+		assert.Nil(t, s.Initialize())
+
+		return s
+	})
+}
+
+func loadIDs(assert *assert.Assertions, s *segmentstore.SegmentStore) []string {
+	resources, err := s.Load()
+	assert.Nil(err)
+
+	ids := []string{}
+
+	for _, l := range resources.Resources {
+		for _, res := range l.Resources {
+			ids = append(ids, res.GetID())
+		}
+	}
+
+	return ids
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+
+	// Tiny segments so that every record seals a segment
+	s := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.Nil(s.Initialize())
+
+	kept := storetest.NewLab("kept", nil)
+	replaced := storetest.NewLab("replaced", nil)
+	deleted := storetest.NewLab("deleted", nil)
+
+	for _, res := range []zebra.Resource{kept, replaced, deleted, replaced} {
+		assert.Nil(s.Create(res))
+	}
+
+	assert.Nil(s.Delete(deleted))
+
+	segments, size, live := s.Stats()
+	assert.Equal(5, segments)
+
+	reclaimed, err := s.Compact()
+	assert.Nil(err)
+	assert.True(reclaimed > 0)
+
+	// Only the garbage of the active segment is left
+	compacted, compactedSize, compactedLive := s.Stats()
+	assert.True(compacted < segments)
+	assert.True(compactedSize-compactedLive < size-live)
+	assert.Equal(live, compactedLive)
+	assert.ElementsMatch([]string{kept.ID, replaced.ID}, loadIDs(assert, s))
+
+	// The compacted segments replay to the same resources
+	reopened := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.Nil(reopened.Initialize())
+	assert.ElementsMatch([]string{kept.ID, replaced.ID}, loadIDs(assert, reopened))
+
+	// Nothing left to reclaim
+	reclaimed, err = reopened.Compact()
+	assert.Nil(err)
+	assert.Equal(int64(0), reclaimed)
+}
+
+func TestCompactKeepsDeletes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	s := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.Nil(s.Initialize())
+
+	deleted := storetest.NewLab("deleted", nil)
+	filler := storetest.NewLab("filler", nil)
+
+	// The delete is in a later segment than the create it deletes
+	assert.Nil(s.Create(deleted))
+	assert.Nil(s.Create(filler))
+	assert.Nil(s.Delete(deleted))
+	assert.Nil(s.Create(filler))
+
+	_, err := s.Compact()
+	assert.Nil(err)
+
+	reopened := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.Nil(reopened.Initialize())
+	assert.Equal([]string{filler.ID}, loadIDs(assert, reopened))
+}
+
+func TestTornRecord(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	s := segmentstore.NewSegmentStore(root, storetest.Factory(), 0)
+	assert.Nil(s.Initialize())
+
+	lab := storetest.NewLab("lab", nil)
+	assert.Nil(s.Create(lab))
+
+	file := path.Join(root, segmentstore.SegmentsDir, "segment-000001.log")
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, segmentstore.RWRR)
+	assert.Nil(err)
+
+	_, err = f.WriteString(`0000abcd {"op":"put","id":"x"`)
+	assert.Nil(err)
+	assert.Nil(f.Close())
+
+	// The torn record is dropped and writes continue after the last record
+	reopened := segmentstore.NewSegmentStore(root, storetest.Factory(), 0)
+	assert.Nil(reopened.Initialize())
+	assert.Equal([]string{lab.ID}, loadIDs(assert, reopened))
+
+	rack := storetest.NewRack("rack", "row")
+	assert.Nil(reopened.Create(rack))
+
+	again := segmentstore.NewSegmentStore(root, storetest.Factory(), 0)
+	assert.Nil(again.Initialize())
+	assert.ElementsMatch([]string{lab.ID, rack.ID}, loadIDs(assert, again))
+}
+
+func TestCorruptSegment(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	s := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.Nil(s.Initialize())
+
+	assert.Nil(s.Create(storetest.NewLab("one", nil)))
+	assert.Nil(s.Create(storetest.NewLab("two", nil)))
+
+	// Only the last segment may be torn, a sealed segment must be intact
+	file := path.Join(root, segmentstore.SegmentsDir, "segment-000001.log")
+	assert.Nil(os.WriteFile(file, []byte("garbage\n"), segmentstore.RWRR))
+
+	reopened := segmentstore.NewSegmentStore(root, storetest.Factory(), 1)
+	assert.ErrorIs(reopened.Initialize(), segmentstore.ErrCorrupt)
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	fs := filestore.NewFileStore(root, storetest.Factory())
+	assert.Nil(fs.Initialize())
+
+	labs := []string{}
+
+	for _, name := range []string{"a", "b", "c"} {
+		lab := storetest.NewLab(name, nil)
+		labs = append(labs, lab.ID)
+		assert.Nil(fs.Create(lab))
+	}
+
+	s := segmentstore.NewSegmentStore(root, storetest.Factory(), 0)
+	assert.Nil(s.Initialize())
+	assert.ElementsMatch(labs, loadIDs(assert, s))
+
+	// The per-file layout is kept aside
+	_, err := os.Stat(path.Join(root, "resources"))
+	assert.True(os.IsNotExist(err))
+
+	_, err = os.Stat(path.Join(root, segmentstore.MigratedDir))
+	assert.Nil(err)
+
+	// A migration interrupted after writing the segments completes
+	assert.Nil(os.Rename(path.Join(root, segmentstore.MigratedDir), path.Join(root, "resources")))
+
+	again := segmentstore.NewSegmentStore(root, storetest.Factory(), 0)
+	assert.Nil(again.Initialize())
+	assert.ElementsMatch(labs, loadIDs(assert, again))
+
+	_, err = os.Stat(path.Join(root, "resources"))
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/metrics"
+)
+
+// Operations of the resource writes sent to admission webhooks.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// FailurePolicy decides what happens to a write if an admission webhook can
+// not be reached or answers with an error.
+type FailurePolicy string
+
+const (
+	// FailClosed rejects the write, it is the default.
+	FailClosed FailurePolicy = "fail"
+	// FailOpen lets the write through as if the webhook allowed it.
+	FailOpen FailurePolicy = "ignore"
+)
+
+const DefaultAdmissionTimeout = 5 * time.Second
+
+// admissionResponseLimit bounds the responses read from admission webhooks.
+const admissionResponseLimit = 1 << 20
+
+var (
+	ErrAdmissionPolicy = errors.New("admission webhook failure policy must be fail or ignore")
+	ErrAdmissionOp     = errors.New("admission webhook operations must be create, update or delete")
+	ErrAdmissionPatch  = errors.New("admission webhooks can not change system labels")
+)
+
+var admissionDecisions = metrics.Default.Counter("zebra_admission_decisions_total",
+	"Admission webhook decisions, by webhook and decision.", "webhook", "decision")
+
+// AdmissionWebhookConfig is an admission webhook of the server
+// configuration. Writes of resources of the given types with the given
+// operations are posted to the URL, empty lists match everything. Timeout
+// is a duration such as "2s".
+type AdmissionWebhookConfig struct {
+	Name          string        `json:"name"`
+	URL           string        `json:"url"`
+	Secret        string        `json:"secret,omitempty"`
+	Types         []string      `json:"types,omitempty"`
+	Operations    []string      `json:"operations,omitempty"`
+	Timeout       string        `json:"timeout,omitempty"`
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// AdmissionConfig is the admission webhooks of the server configuration,
+// which are called in order for every write.
+type AdmissionConfig struct {
+	Webhooks []AdmissionWebhookConfig `json:"webhooks"`
+}
+
+// AdmissionReview is posted to admission webhooks for each written
+// resource. Previous is the stored version of an updated resource.
+type AdmissionReview struct {
+	Operation string         `json:"operation"`
+	User      string         `json:"user"`
+	DryRun    bool           `json:"dryRun"`
+	Resource  zebra.Resource `json:"resource"`
+	Previous  zebra.Resource `json:"previous,omitempty"`
+}
+
+// AdmissionResponse is the decision of an admission webhook. An allowed
+// create or update gets the labels added to the resource, or changed.
+type AdmissionResponse struct {
+	Allowed bool              `json:"allowed"`
+	Message string            `json:"message,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// AdmissionDenial is a write an admission webhook rejected, or failed to
+// decide on.
+type AdmissionDenial struct {
+	Webhook string `json:"webhook"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type admissionWebhook struct {
+	AdmissionWebhookConfig
+	client *http.Client
+}
+
+// admissionController calls the admission webhooks for resource writes.
+type admissionController struct {
+	hooks []admissionWebhook
+}
+
+// newAdmissionController returns a controller for the configured webhooks,
+// or nil if there are none.
+func newAdmissionController(cfg *AdmissionConfig) (*admissionController, error) {
+	if len(cfg.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	names := map[string]bool{}
+	hooks := make([]admissionWebhook, 0, len(cfg.Webhooks))
+
+	for _, hook := range cfg.Webhooks {
+		if names[hook.Name] || hook.Name == "" {
+			return nil, ErrWebhookName
+		}
+
+		names[hook.Name] = true
+
+		u, err := url.Parse(hook.URL)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("webhook %s: %w", hook.Name, ErrWebhookURL)
+		}
+
+		switch hook.FailurePolicy {
+		case "":
+			hook.FailurePolicy = FailClosed
+		case FailClosed, FailOpen:
+		default:
+			return nil, fmt.Errorf("webhook %s: %w", hook.Name, ErrAdmissionPolicy)
+		}
+
+		for _, op := range hook.Operations {
+			if op != OpCreate && op != OpUpdate && op != OpDelete {
+				return nil, fmt.Errorf("webhook %s: %w", hook.Name, ErrAdmissionOp)
+			}
+		}
+
+		timeout := DefaultAdmissionTimeout
+
+		if hook.Timeout != "" {
+			if timeout, err = time.ParseDuration(hook.Timeout); err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", hook.Name, err)
+			}
+		}
+
+		hooks = append(hooks, admissionWebhook{AdmissionWebhookConfig: hook, client: &http.Client{Timeout: timeout}})
+	}
+
+	return &admissionController{hooks: hooks}, nil
+}
+
+func (h *admissionWebhook) matches(op string, res zebra.Resource) bool {
+	return (len(h.Types) == 0 || zebra.IsIn(res.GetType(), h.Types)) &&
+		(len(h.Operations) == 0 || zebra.IsIn(op, h.Operations))
+}
+
+// review posts the review to the webhook and returns its decision.
+func (h *admissionWebhook) review(ctx context.Context, review *AdmissionReview) (*AdmissionResponse, error) {
+	payload, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(h.Secret, payload))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookStatus, resp.Status)
+	}
+
+	decision := new(AdmissionResponse)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, admissionResponseLimit)).Decode(decision); err != nil {
+		return nil, err
+	}
+
+	for k := range decision.Labels {
+		if strings.HasPrefix(k, SystemLabelPrefix) {
+			return nil, ErrAdmissionPatch
+		}
+	}
+
+	return decision, nil
+}
+
+// admit has the admission webhooks review the writes of the resources with
+// the operation, deletes if delete is set, creates or updates otherwise.
+// Resources are replaced in resMap by the copies with the labels the
+// webhooks added. The decisions are recorded in the audit log, unless the
+// write is a dry run.
+func (api *ResourceAPI) admit(ctx context.Context, resMap *zebra.ResourceMap, del bool,
+	dryRun bool,
+) ([]AdmissionDenial, error) {
+	denials := []AdmissionDenial{}
+
+	if api.admission == nil {
+		return denials, nil
+	}
+
+	decoder := zebra.NewDecoder(api.factory)
+
+	for _, t := range sortedTypes(resMap) {
+		l := resMap.Resources[t]
+
+		for i, r := range l.Resources {
+			previous := findResource(api.Store, r.GetID())
+			op := admissionOp(del, previous)
+
+			for _, hook := range api.admission.hooks {
+				if !hook.matches(op, r) {
+					continue
+				}
+
+				review := &AdmissionReview{Operation: op, User: actor(ctx), DryRun: dryRun, Resource: r, Previous: previous}
+				if del {
+					review.Previous = nil
+				}
+
+				decision, err := hook.review(ctx, review)
+				if err != nil {
+					decision = &AdmissionResponse{Allowed: hook.FailurePolicy == FailOpen, Message: err.Error(), Labels: nil}
+				}
+
+				api.auditAdmission(ctx, hook.Name, r.GetID(), decision, err, dryRun)
+
+				if !decision.Allowed {
+					denials = append(denials, AdmissionDenial{
+						Webhook: hook.Name, ID: r.GetID(), Type: r.GetType(), Message: decision.Message,
+					})
+
+					break
+				}
+
+				if len(decision.Labels) != 0 && !del {
+					if r, err = patchLabels(decoder, r, decision.Labels); err != nil {
+						return nil, err
+					}
+
+					l.Resources[i] = r
+				}
+			}
+		}
+	}
+
+	return denials, nil
+}
+
+func admissionOp(del bool, previous zebra.Resource) string {
+	switch {
+	case del:
+		return OpDelete
+	case previous != nil:
+		return OpUpdate
+	default:
+		return OpCreate
+	}
+}
+
+func (api *ResourceAPI) auditAdmission(ctx context.Context, hook string, resID string, decision *AdmissionResponse,
+	err error, dryRun bool,
+) {
+	action := "admission.allow"
+
+	switch {
+	case err != nil:
+		action = "admission.error"
+	case !decision.Allowed:
+		action = "admission.deny"
+	case len(decision.Labels) != 0:
+		action = "admission.mutate"
+	}
+
+	admissionDecisions.Inc(hook, strings.TrimPrefix(action, "admission."))
+
+	if dryRun {
+		return
+	}
+
+	detail := hook
+	if decision.Message != "" {
+		detail += ": " + decision.Message
+	}
+
+	if action == "admission.mutate" {
+		keys := make([]string, 0, len(decision.Labels))
+		for k, v := range decision.Labels {
+			keys = append(keys, k+"="+v)
+		}
+
+		sort.Strings(keys)
+		detail += " (" + strings.Join(keys, ", ") + ")"
+	}
+
+	api.recordAudit(ctx, action, resID, detail)
+}
+
+// patchLabels returns a copy of the resource with the labels set.
+func patchLabels(decoder *zebra.Decoder, res zebra.Resource, patch map[string]string) (zebra.Resource, error) {
+	fields, err := resourceFields(res)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := res.GetLabels()
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	for k, v := range patch {
+		labels[k] = v
+	}
+
+	if fields["labels"], err = json.Marshal(labels); err != nil {
+		return nil, err
+	}
+
+	return decodeFields(decoder, fields)
+}
+
+// guardAdmission refuses the writes the admission webhooks reject, it
+// writes the denials and returns false if there are any.
+func (api *ResourceAPI) guardAdmission(res http.ResponseWriter, req *http.Request, resMap *zebra.ResourceMap,
+	del bool,
+) bool {
+	ctx := req.Context()
+
+	denials, err := api.admit(ctx, resMap, del, isDryRun(req))
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return false
+	}
+
+	if len(denials) == 0 {
+		return true
+	}
+
+	writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+	return false
+}
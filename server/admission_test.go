@@ -0,0 +1,149 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAdmissionController(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c, err := newAdmissionController(&AdmissionConfig{Webhooks: nil})
+	assert.Nil(err)
+	assert.Nil(c)
+
+	for _, hook := range []AdmissionWebhookConfig{
+		{Name: "", URL: "http://localhost"},
+		{Name: "a", URL: "localhost"},
+		{Name: "a", URL: "http://localhost", FailurePolicy: "retry"},
+		{Name: "a", URL: "http://localhost", Operations: []string{"read"}},
+		{Name: "a", URL: "http://localhost", Timeout: "soon"},
+	} {
+		_, err := newAdmissionController(&AdmissionConfig{Webhooks: []AdmissionWebhookConfig{hook}})
+		assert.NotNil(err)
+	}
+
+	c, err = newAdmissionController(&AdmissionConfig{Webhooks: []AdmissionWebhookConfig{
+		{Name: "a", URL: "http://localhost", Timeout: "1s"},
+	}})
+	assert.Nil(err)
+	assert.Equal(FailClosed, c.hooks[0].FailurePolicy)
+}
+
+// cmdb adds the cost center of labs and rejects the ones labeled frozen.
+func cmdb(res http.ResponseWriter, req *http.Request) {
+	review := new(struct {
+		Operation string `json:"operation"`
+		Resource  struct {
+			Labels zebra.Labels `json:"labels"`
+		} `json:"resource"`
+	})
+
+	if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	decision := AdmissionResponse{Allowed: true, Message: "", Labels: nil}
+
+	switch {
+	case review.Resource.Labels.HasKey("frozen"):
+		decision.Allowed = false
+		decision.Message = "lab is frozen"
+	case review.Operation == OpCreate:
+		decision.Labels = map[string]string{"cost-center": "cc-42"}
+	}
+
+	_ = json.NewEncoder(res).Encode(decision)
+}
+
+func TestAdmission(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(cmdb))
+	defer server.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admission, err := newAdmissionController(&AdmissionConfig{Webhooks: []AdmissionWebhookConfig{
+		{Name: "cmdb", URL: server.URL, Types: []string{"Lab"}},
+		{Name: "optional", URL: broken.URL, FailurePolicy: FailOpen, Operations: []string{OpCreate}},
+		{Name: "required", URL: broken.URL, Operations: []string{OpDelete}, Types: []string{"Rack"}},
+	}})
+	assert.Nil(err)
+
+	api.admission = admission
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	serve := func(method, url string, resources ...zebra.Resource) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(resMapJSON(assert, resources...))).WithContext(ctx)
+
+		if method == "DELETE" {
+			handleDelete()(rr, req, nil)
+		} else {
+			handlePost()(rr, req, nil)
+		}
+
+		return rr
+	}
+
+	// The webhook labels new labs, the broken one is ignored
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	assert.Equal(http.StatusOK, serve("POST", "/api/v1/resources", lab).Code)
+	assert.Equal("cc-42", findResource(api.Store, lab.ID).GetLabels()["cost-center"])
+
+	actions := []string{}
+	for _, e := range api.Audit.Query(lab.ID) {
+		actions = append(actions, e.Action)
+	}
+
+	assert.Contains(actions, "admission.mutate")
+	assert.Contains(actions, "admission.error")
+
+	// Rejected writes are listed with the reason
+	frozen := dc.NewLab("frozen", zebra.Labels{"system.group": "labs", "frozen": "true"})
+	before := len(api.Audit.Entries())
+	assert.Equal(http.StatusForbidden, serve("POST", "/api/v1/resources?dryRun=true", frozen).Code)
+	assert.Equal(before, len(api.Audit.Entries()))
+
+	rr := serve("POST", "/api/v1/resources", frozen)
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.Nil(findResource(api.Store, frozen.ID))
+
+	denials := []AdmissionDenial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal([]AdmissionDenial{{Webhook: "cmdb", ID: frozen.ID, Type: "Lab", Message: "lab is frozen"}}, denials)
+
+	entries := api.Audit.Entries()
+	assert.Equal("admission.deny", entries[len(entries)-1].Action)
+
+	// Deletes fail closed if the webhook is broken
+	rack := dc.NewRack("rack", "row", zebra.Labels{"system.group": "racks"})
+	assert.Nil(api.Store.Create(rack))
+	assert.Equal(http.StatusForbidden, serve("DELETE", "/api/v1/resources", rack).Code)
+	assert.NotNil(findResource(api.Store, rack.ID))
+
+	assert.Equal(http.StatusOK, serve("DELETE", "/api/v1/resources", findResource(api.Store, lab.ID)).Code)
+	assert.Nil(findResource(api.Store, lab.ID))
+}
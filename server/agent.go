@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/agent"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/events"
+)
+
+// UnknownModel is the model of hosts whose agent could not read the model
+// from the firmware.
+const UnknownModel = "unknown"
+
+// Labels of the servers registered by agents. The agent ID label identifies
+// the host, the principal label is the service account of its agent.
+const (
+	AgentIDLabel        = "agent.id"
+	AgentPrincipalLabel = "agent.principal"
+)
+
+var (
+	ErrAgentIdentity = errors.New("agents must authenticate with a service account token")
+	ErrAgentOwner    = errors.New("host is registered by the agent of another service account")
+	ErrAgentAddress  = errors.New("host facts have no IP address")
+)
+
+// agentLabels returns the labels of a server registered by the agent of the
+// service account with the facts.
+func agentLabels(sa *auth.ServiceAccount, facts *agent.Facts) zebra.Labels {
+	ips := []string{}
+	for _, ip := range facts.IPs() {
+		ips = append(ips, ip.String())
+	}
+
+	labels := zebra.Labels{}
+	labels.Add("system.group", sa.Namespace())
+	labels.Add(AgentIDLabel, facts.ID())
+	labels.Add(AgentPrincipalLabel, sa.Principal())
+	labels.Add(AgentStatusLabel, HostOnline)
+	labels.Add("agent.hostname", facts.Hostname)
+	labels.Add("agent.os", facts.OS)
+	labels.Add("agent.kernel", facts.Kernel)
+	labels.Add("agent.arch", facts.Arch)
+	labels.Add("agent.vendor", facts.Vendor)
+	labels.Add("agent.macs", strings.Join(facts.MACs(), ","))
+	labels.Add("agent.ips", strings.Join(ips, ","))
+
+	for k, v := range labels {
+		if v == "" {
+			delete(labels, k)
+		}
+	}
+
+	return labels
+}
+
+// findAgentServer returns the server registered for the host with the agent
+// ID, nil if there is none.
+func findAgentServer(s zebra.Store, id string) *compute.Server {
+	var found *compute.Server
+
+	_ = applyFunc(s.QueryType([]string{"Server"}), func(r zebra.Resource) error {
+		if server, ok := r.(*compute.Server); ok && server.Labels[AgentIDLabel] == id {
+			found = server
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// agentServer returns the server of the host with the facts: the registered
+// server updated with the facts, or a new server. Labels of the registered
+// server that the agent does not manage are kept.
+func agentServer(prev *compute.Server, sa *auth.ServiceAccount, facts *agent.Facts) (*compute.Server, error) {
+	ips := facts.IPs()
+	if len(ips) == 0 {
+		return nil, ErrAgentAddress
+	}
+
+	serial := facts.Serial
+	if serial == "" {
+		serial = facts.MachineID
+	}
+
+	model := facts.Model
+	if model == "" {
+		model = UnknownModel
+	}
+
+	labels := agentLabels(sa, facts)
+
+	if prev == nil {
+		return compute.NewServer([]string{serial, model, facts.Hostname}, ips[0], labels), nil
+	}
+
+	server := *prev
+	server.Name = facts.Hostname
+	server.SerialNumber = serial
+	server.Model = model
+	server.BoardIP = ips[0]
+	server.Labels = zebra.Labels{}
+
+	for k, v := range prev.Labels {
+		if !strings.HasPrefix(k, "agent.") {
+			server.Labels.Add(k, v)
+		}
+	}
+
+	for k, v := range labels {
+		server.Labels.Add(k, v)
+	}
+
+	return &server, nil
+}
+
+// agentUnchanged returns true if the registration would not change the
+// registered server, so that agents reporting on a timer do not add a new
+// version to the history of their host each time.
+func agentUnchanged(prev, server *compute.Server) bool {
+	return prev.Name == server.Name && prev.SerialNumber == server.SerialNumber && prev.Model == server.Model &&
+		prev.BoardIP.Equal(server.BoardIP) && reflect.DeepEqual(prev.Labels, server.Labels)
+}
+
+// handleAgentRegister registers or updates the server of the host whose
+// agent reports its facts. Agents authenticate with a service account token
+// and their hosts join the namespace of the account. A host registered by
+// the agent of another account can not be taken over.
+func handleAgentRegister() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		sa, ok := ctx.Value(ServiceAccountCtxKey).(*auth.ServiceAccount)
+		if !ok {
+			http.Error(res, ErrAgentIdentity.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		facts := new(agent.Facts)
+		if err := readJSON(ctx, req, facts); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := facts.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		prev := findAgentServer(api.Store, facts.ID())
+		if prev != nil && prev.Labels[AgentPrincipalLabel] != sa.Principal() {
+			log.Info("agent registration refused", "host", facts.ID(), "account", sa.Principal(),
+				"owner", prev.Labels[AgentPrincipalLabel])
+			http.Error(res, ErrAgentOwner.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		server, err := agentServer(prev, sa, facts)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		action := auth.ActionCreate
+		if prev != nil {
+			action = auth.ActionUpdate
+		}
+
+		if !claims.Allows(action, server.GetType(), server.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		if err := server.Validate(ctx); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		// Every registration is a heartbeat of the host
+		if err := api.heartbeats.beat(server.ID, time.Now()); err != nil {
+			log.Error(err, "heartbeat not recorded", "host", facts.ID())
+		}
+
+		if prev != nil && agentUnchanged(prev, server) {
+			writeJSON(ctx, res, prev)
+
+			return
+		}
+
+		if err := api.create(ctx, server); err != nil {
+			log.Error(err, "agent registration failed", "host", facts.ID())
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if prev != nil && prev.Labels[AgentStatusLabel] != HostOnline {
+			_ = api.recordEvent(ctx, events.HostOnline, server)
+		}
+
+		api.recordAudit(ctx, "agent.register", server.ID, facts.Hostname)
+		log.Info("agent registered host", "host", facts.Hostname, "account", sa.Principal())
+
+		code := http.StatusOK
+		if prev == nil {
+			code = http.StatusCreated
+		}
+
+		writeJSONCode(ctx, res, code, server)
+	}
+}
@@ -0,0 +1,122 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra/agent"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// agentAccount returns a service account that may manage the servers of the
+// namespace.
+func agentAccount(assert *assert.Assertions, name string, namespace string) *auth.ServiceAccount {
+	servers, err := auth.NewPriv("^Server$", true, true, true, true)
+	assert.Nil(err)
+
+	return auth.NewServiceAccount(name, namespace, &auth.Role{Name: "agent", Privileges: []*auth.Priv{servers}},
+		"admin@zebra")
+}
+
+func registerAgent(api *ResourceAPI, sa *auth.ServiceAccount, facts *agent.Facts) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(facts)
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+
+	if sa != nil {
+		ctx = context.WithValue(ctx, ClaimsCtxKey, sa.Claims())
+		ctx = context.WithValue(ctx, ServiceAccountCtxKey, sa)
+	} else {
+		ctx = context.WithValue(ctx, ClaimsCtxKey, auth.NewClaims("zebra", "admin", DefaultRole(), "admin@zebra"))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/register", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	handleAgentRegister()(rr, req.WithContext(ctx), nil)
+
+	return rr
+}
+
+func TestAgentRegister(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_agent_register"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	sa := agentAccount(assert, "lab-agents", "lab-west")
+	facts := &agent.Facts{
+		Hostname:  "lab-host-1",
+		MachineID: "0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f",
+		Serial:    "",
+		Vendor:    "Cisco Systems Inc",
+		Model:     "",
+		OS:        "Ubuntu 22.04.1 LTS",
+		Kernel:    "5.15.0-48-generic",
+		Arch:      "amd64",
+		NICs:      []agent.NIC{{Name: "eno1", MAC: "00:25:b5:00:00:1f", Addresses: []string{"10.1.2.3"}}},
+	}
+
+	// Users can not register hosts, agents need an identity
+	assert.Equal(http.StatusForbidden, registerAgent(api, nil, facts).Code)
+
+	rr := registerAgent(api, sa, facts)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	server := findAgentServer(api.Store, facts.MachineID)
+	assert.NotNil(server)
+	assert.Equal("lab-host-1", server.Name)
+	assert.Equal(facts.MachineID, server.SerialNumber)
+	assert.Equal(UnknownModel, server.Model)
+	assert.Equal("10.1.2.3", server.BoardIP.String())
+	assert.Equal("lab-west", server.Labels["system.group"])
+	assert.Equal(sa.Principal(), server.Labels[AgentPrincipalLabel])
+	assert.Equal("00:25:b5:00:00:1f", server.Labels["agent.macs"])
+	assert.Equal("agent.register", api.Audit.Query(server.ID)[0].Action)
+
+	// Reporting the same facts does not write the server again
+	versions := len(api.History.Versions(server.ID))
+	assert.Equal(http.StatusOK, registerAgent(api, sa, facts).Code)
+	assert.Equal(versions, len(api.History.Versions(server.ID)))
+
+	// Changed facts update the server, labels the agent does not manage stay
+	server.Labels.Add("owner", "lab-team")
+	assert.Nil(api.Store.Create(server))
+
+	facts.Model = "UCSC-C220-M5SX"
+	facts.NICs[0].Addresses = []string{"fd00::10", "10.1.2.4"}
+
+	rr = registerAgent(api, sa, facts)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	updated := new(compute.Server)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), updated))
+	assert.Equal(server.ID, updated.ID)
+	assert.Equal("UCSC-C220-M5SX", updated.Model)
+	assert.Equal("10.1.2.4", updated.BoardIP.String())
+	assert.Equal("lab-team", updated.Labels["owner"])
+	assert.Equal("10.1.2.4,fd00::10", updated.Labels["agent.ips"])
+
+	// The agent of another account can not take the host over
+	assert.Equal(http.StatusForbidden, registerAgent(api, agentAccount(assert, "rogue", "lab-east"), facts).Code)
+
+	// Hosts need an identity and an address
+	assert.Equal(http.StatusBadRequest, registerAgent(api, sa, &agent.Facts{Hostname: "lab-host-2"}).Code)
+	assert.Equal(http.StatusBadRequest, registerAgent(api, sa, &agent.Facts{Hostname: "lab-host-2", Serial: "X"}).Code)
+
+	// Accounts without privileges on servers can not register hosts
+	readOnly := auth.NewServiceAccount("viewer", "lab-west", DefaultRole(), "admin@zebra")
+	facts = &agent.Facts{Hostname: "lab-host-3", Serial: "FCH2201V0AB", NICs: facts.NICs}
+	assert.Equal(http.StatusForbidden, registerAgent(api, readOnly, facts).Code)
+}
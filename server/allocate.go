@@ -0,0 +1,527 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+)
+
+// AnyGroup is the group of lease requests for resources of any group.
+const AnyGroup = "global"
+
+// Reasons why resources matching a request can not be allocated.
+const (
+	BlockedLeased    = "leased"
+	BlockedSetup     = "setup"
+	BlockedUnhealthy = "unhealthy"
+	BlockedLifecycle = "lifecycle"
+	BlockedAllocated = "allocated"
+	BlockedSpread    = "spread"
+)
+
+var ErrSimulateRequest = errors.New("simulated leases need requests with a type and a positive count")
+
+// AllocationRequest asks for Count resources of the type in the group, with
+// the name if given, whose labels match the filters.
+type AllocationRequest struct {
+	Type    string        `json:"type"`
+	Group   string        `json:"group,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Count   int           `json:"count"`
+	Filters []zebra.Query `json:"filters,omitempty"`
+}
+
+// SimulatedLease is a lease whose requests are allocated by a simulation,
+// under the placement constraints of leases if it has any.
+type SimulatedLease struct {
+	Name      string              `json:"name,omitempty"`
+	Request   []AllocationRequest `json:"request"`
+	Placement []string            `json:"placement,omitempty"`
+	Spread    []lease.Spread      `json:"spread,omitempty"`
+}
+
+// SimulationRequest asks whether the leases could all be satisfied at once
+// at the given time, now if not given. Active leases that expire by then do
+// not hold their resources anymore.
+type SimulationRequest struct {
+	At     time.Time        `json:"at,omitempty"`
+	Leases []SimulatedLease `json:"leases"`
+}
+
+func (s *SimulationRequest) Validate() error {
+	if len(s.Leases) == 0 {
+		return ErrSimulateRequest
+	}
+
+	for _, l := range s.Leases {
+		if len(l.Request) == 0 {
+			return ErrSimulateRequest
+		}
+
+		for _, scope := range l.Placement {
+			if scope == "" {
+				return lease.ErrPlacement
+			}
+		}
+
+		for _, sp := range l.Spread {
+			if sp.Scope == "" || sp.Min <= 0 {
+				return lease.ErrSpread
+			}
+		}
+
+		for _, r := range l.Request {
+			if r.Type == "" || r.Count <= 0 {
+				return ErrSimulateRequest
+			}
+
+			if err := validateQueries(r.Filters); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Blocker is a reason why resources matching a request could not be
+// allocated, with the leases holding them, if any.
+type Blocker struct {
+	Reason string   `json:"reason"`
+	Count  int      `json:"count"`
+	Leases []string `json:"leases,omitempty"`
+}
+
+// Allocation is the outcome of a request of a simulated lease: the resources
+// allocated to it, in the place of the lease if it has placement scopes, and,
+// if there were not enough, what blocked the others. The scoring policies
+// that ranked the resources are recorded with the scores of the allocated
+// ones.
+type Allocation struct {
+	Lease string `json:"lease"`
+	AllocationRequest
+	Placement string              `json:"placement,omitempty"`
+	Scoring   []string            `json:"scoring,omitempty"`
+	Scores    map[string][]string `json:"scores,omitempty"`
+	Allocated []string            `json:"allocated"`
+	Missing   int                 `json:"missing"`
+	Blockers  []Blocker           `json:"blockers,omitempty"`
+}
+
+// SimulationResult tells whether all simulated leases could be satisfied.
+type SimulationResult struct {
+	Satisfiable bool         `json:"satisfiable"`
+	At          time.Time    `json:"at"`
+	Allocations []Allocation `json:"allocations"`
+}
+
+// allocator allocates free resources to requests. Resources are taken by
+// the active leases holding them, until the leases expire, and by the
+// requests allocated before. The free resources are ranked by the scoring
+// policy of their type. With heartbeats, hosts whose agents are not online
+// are unhealthy.
+type allocator struct {
+	resources  *zebra.ResourceMap
+	holders    map[string]*lease.Lease
+	freed      map[string]bool
+	taken      map[string]string
+	lastUsed   map[string]time.Time
+	scoring    scoringPolicy
+	byID       map[string]zebra.Resource
+	related    map[[2]string]string
+	heartbeats bool
+}
+
+// newAllocator returns an allocator of the resources at the given time.
+func newAllocator(resources *zebra.ResourceMap, at time.Time, scoring scoringPolicy) *allocator {
+	a := &allocator{
+		resources:  resources,
+		holders:    map[string]*lease.Lease{},
+		freed:      map[string]bool{},
+		taken:      map[string]string{},
+		lastUsed:   map[string]time.Time{},
+		scoring:    scoring,
+		byID:       nil,
+		related:    map[[2]string]string{},
+		heartbeats: false,
+	}
+
+	if list, ok := resources.Resources["Lease"]; ok {
+		for _, r := range list.Resources {
+			l, ok := r.(*lease.Lease)
+			if !ok || l.ActivationTime.IsZero() {
+				continue
+			}
+
+			end := l.Expiry()
+			active := l.Status.State == zebra.Active
+			expired := !at.Before(end)
+
+			for _, req := range l.RequestList() {
+				for _, held := range req.Resources {
+					if end.After(a.lastUsed[held.GetID()]) {
+						a.lastUsed[held.GetID()] = end
+					}
+
+					switch {
+					case active && expired:
+						a.freed[held.GetID()] = true
+					case active:
+						a.holders[held.GetID()] = l
+					}
+				}
+			}
+		}
+	}
+
+	return a
+}
+
+// replace makes the changed lease the holder of the resources its previous
+// version holds.
+func (a *allocator) replace(l *lease.Lease) {
+	for id, holder := range a.holders {
+		if holder.ID == l.ID {
+			a.holders[id] = l
+		}
+	}
+}
+
+// candidates returns the resources that match the request, by ID.
+func (a *allocator) candidates(r AllocationRequest) []zebra.Resource {
+	found := []zebra.Resource{}
+
+	list, ok := a.resources.Resources[r.Type]
+	if !ok {
+		return found
+	}
+
+	under := a.under(r.Filters)
+
+	for _, res := range list.Resources {
+		labels := res.GetLabels()
+
+		if under != nil && !under[res.GetID()] {
+			continue
+		}
+
+		if r.Group != "" && r.Group != AnyGroup && labels["system.group"] != r.Group {
+			continue
+		}
+
+		if name, _ := fieldValue(res, "name"); r.Name != "" && name != strings.ToLower(r.Name) {
+			continue
+		}
+
+		if matchesQueries(labels, r.Filters) {
+			found = append(found, res)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].GetID() < found[j].GetID() })
+
+	return found
+}
+
+// under returns the IDs of the resources under the parents of all the
+// subtree queries, nil without subtree queries.
+func (a *allocator) under(queries []zebra.Query) map[string]bool {
+	var ids map[string]bool
+
+	for _, q := range queries {
+		if q.Op != zebra.MatchUnder {
+			continue
+		}
+
+		found, _ := store.FilterLabel(q, a.resources)
+		matched := map[string]bool{}
+
+		_ = applyFunc(found, func(res zebra.Resource) error {
+			if ids == nil || ids[res.GetID()] {
+				matched[res.GetID()] = true
+			}
+
+			return nil
+		})
+
+		ids = matched
+	}
+
+	return ids
+}
+
+// matchesQueries returns true if the labels match the queries, but for the
+// subtree queries.
+func matchesQueries(labels zebra.Labels, queries []zebra.Query) bool {
+	for _, q := range queries {
+		if q.Op == zebra.MatchUnder {
+			continue
+		}
+
+		in := labels.MatchIn(q.Key, q.Values...)
+		if selectsValues(q) != in {
+			return false
+		}
+	}
+
+	return true
+}
+
+// blocker returns why the resource can not be allocated, or "" if it can.
+func (a *allocator) blocker(res zebra.Resource) string {
+	id := res.GetID()
+
+	switch {
+	case a.taken[id] != "":
+		return BlockedAllocated
+	case a.holders[id] != nil:
+		return BlockedLeased
+	}
+
+	if agent := res.GetLabels()[AgentStatusLabel]; a.heartbeats && agent != "" && agent != HostOnline {
+		return BlockedUnhealthy
+	}
+
+	status := res.GetStatus()
+	if status == nil {
+		return ""
+	}
+
+	switch {
+	case status.Lifecycle != "" && status.Lifecycle != zebra.LifecycleActive:
+		return BlockedLifecycle
+	case status.State == zebra.Inactive || status.Fault == zebra.Critical:
+		return BlockedUnhealthy
+	case status.Lease == zebra.Leased && !a.freed[id]:
+		return BlockedLeased
+	case status.Lease == zebra.Setup:
+		return BlockedSetup
+	}
+
+	return ""
+}
+
+// available returns the number of resources the request could be allocated.
+func (a *allocator) available(r AllocationRequest) int {
+	n := 0
+
+	for _, res := range a.candidates(r) {
+		if a.blocker(res) == "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// allocate takes resources for the request of the named lease. If there are
+// not enough, it takes what there is and returns the blockers of the rest.
+func (a *allocator) allocate(name string, r AllocationRequest) Allocation {
+	return a.allocateIn(name, r, selection{place: "", within: nil, spread: nil, used: nil})
+}
+
+// allocateIn allocates the request from the candidates the selection allows.
+func (a *allocator) allocateIn(name string, r AllocationRequest, s selection) Allocation {
+	alloc := Allocation{
+		Lease: name, AllocationRequest: r, Placement: s.place, Scoring: nil, Scores: nil,
+		Allocated: []string{}, Missing: 0, Blockers: nil,
+	}
+	blocked := map[string]*Blocker{}
+	free := []zebra.Resource{}
+
+	for _, res := range a.candidates(r) {
+		if s.within != nil && !s.within(res) {
+			continue
+		}
+
+		reason := a.blocker(res)
+
+		if reason == "" {
+			free = append(free, res)
+
+			continue
+		}
+
+		b, ok := blocked[reason]
+		if !ok {
+			b = &Blocker{Reason: reason, Count: 0, Leases: nil}
+			blocked[reason] = b
+		}
+
+		b.Count++
+
+		if holder := a.holders[res.GetID()]; reason == BlockedLeased && holder != nil {
+			b.Leases = appendUnique(b.Leases, holder.ID)
+		} else if reason == BlockedAllocated {
+			b.Leases = appendUnique(b.Leases, a.taken[res.GetID()])
+		}
+	}
+
+	scoring, scores := a.rank(r.Type, free)
+
+	for _, res := range s.pick(free, r.Count) {
+		a.taken[res.GetID()] = name
+		alloc.Allocated = append(alloc.Allocated, res.GetID())
+
+		if scores != nil {
+			if alloc.Scores == nil {
+				alloc.Scores = map[string][]string{}
+			}
+
+			alloc.Scores[res.GetID()] = scores[res.GetID()]
+		}
+	}
+
+	alloc.Scoring = scoring
+
+	alloc.Missing = r.Count - len(alloc.Allocated)
+	if alloc.Missing == 0 {
+		return alloc
+	}
+
+	for _, b := range blocked {
+		alloc.Blockers = append(alloc.Blockers, *b)
+	}
+
+	sort.Slice(alloc.Blockers, func(i, j int) bool { return alloc.Blockers[i].Reason < alloc.Blockers[j].Reason })
+
+	return alloc
+}
+
+// release returns the resources taken for the named lease.
+func (a *allocator) release(name string) {
+	for id, taken := range a.taken {
+		if taken == name {
+			delete(a.taken, id)
+		}
+	}
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+
+	return append(list, s)
+}
+
+// simulateLeases allocates the requests of the leases, the ones with the
+// fewest spare resources first so that they are not taken by requests which
+// could do with others. Leases with placement constraints are allocated as
+// a whole, before the others. The allocations are returned in request order.
+func simulateLeases(resources *zebra.ResourceMap, sr *SimulationRequest, scoring scoringPolicy) *SimulationResult {
+	type pending struct {
+		lease string
+		req   AllocationRequest
+		index int
+		avail int
+	}
+
+	type constrained struct {
+		lease string
+		reqs  []AllocationRequest
+		c     constraints
+		index int
+	}
+
+	a := newAllocator(resources, sr.At, scoring)
+	requests := []pending{}
+	units := []constrained{}
+	count := 0
+
+	for i, l := range sr.Leases {
+		name := l.Name
+		if name == "" {
+			name = fmt.Sprintf("lease-%d", i+1)
+		}
+
+		if len(l.Placement) != 0 || len(l.Spread) != 0 {
+			units = append(units, constrained{
+				lease: name, reqs: l.Request, c: constraints{together: l.Placement, spread: l.Spread}, index: count,
+			})
+			count += len(l.Request)
+
+			continue
+		}
+
+		for _, r := range l.Request {
+			requests = append(requests, pending{lease: name, req: r, index: count, avail: a.available(r)})
+			count++
+		}
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].avail-requests[i].req.Count < requests[j].avail-requests[j].req.Count
+	})
+
+	result := &SimulationResult{Satisfiable: true, At: sr.At, Allocations: make([]Allocation, count)}
+
+	for _, u := range units {
+		allocs, missing := a.reserveTogether(u.lease, u.reqs, u.c)
+		if missing != 0 {
+			result.Satisfiable = false
+		}
+
+		copy(result.Allocations[u.index:], allocs)
+	}
+
+	for _, p := range requests {
+		alloc := a.allocate(p.lease, p.req)
+		if alloc.Missing != 0 {
+			result.Satisfiable = false
+		}
+
+		result.Allocations[p.index] = alloc
+	}
+
+	return result
+}
+
+// handleSimulateLeases tells whether a set of leases could all be satisfied
+// from the resources the user may read, without leasing anything.
+func handleSimulateLeases() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		sr := new(SimulationRequest)
+		if err := readJSON(ctx, req, sr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := sr.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if sr.At.IsZero() {
+			sr.At = time.Now()
+		}
+
+		result := simulateLeases(readableResources(ctx, api.view()), sr, api.scoring)
+		log.Info("leases simulated", "leases", len(sr.Leases), "satisfiable", result.Satisfiable)
+
+		writeJSON(ctx, res, result)
+	}
+}
@@ -0,0 +1,157 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func simulationResources(assert *assert.Assertions, now time.Time) (*zebra.ResourceMap, *lease.Lease) {
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+
+	labs := make([]*dc.Lab, 0, 6)
+
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		lab := dc.NewLab(name, zebra.Labels{"system.group": "labs", "gpu": "no"})
+		labs = append(labs, lab)
+		resources.Add(lab, "Lab")
+	}
+
+	// a and b have GPUs, c is leased, d is broken and e is retired
+	labs[0].Labels["gpu"] = "yes"
+	labs[1].Labels["gpu"] = "yes"
+	labs[2].Status.Lease = zebra.Leased
+	labs[3].Status.Fault = zebra.Critical
+	labs[4].Status.Lifecycle = zebra.LifecycleDecommissioned
+
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}
+	assert.Nil(req.Assign(labs[2]))
+
+	held := lease.NewLease("alice@zebra", time.Hour, []*lease.ResourceReq{req})
+	assert.Nil(held.Activate())
+	held.ActivationTime = now
+	resources.Add(held, "Lease")
+
+	return resources, held
+}
+
+func TestSimulateLeases(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Now()
+	resources, held := simulationResources(assert, now)
+
+	gpu := []zebra.Query{{Op: zebra.MatchEqual, Key: "gpu", Values: []string{"yes"}}}
+
+	// The request for GPU labs goes first even though it comes last, so that
+	// the request for any lab does not take them
+	result := simulateLeases(resources, &SimulationRequest{At: now, Leases: []SimulatedLease{
+		{Name: "", Request: []AllocationRequest{{Type: "Lab", Group: AnyGroup, Name: "", Count: 1, Filters: nil}}},
+		{Name: "gpu", Request: []AllocationRequest{{Type: "Lab", Group: "labs", Name: "", Count: 2, Filters: gpu}}},
+	}}, nil)
+	assert.True(result.Satisfiable)
+	assert.Equal("lease-1", result.Allocations[0].Lease)
+	assert.Equal([]string{labs(resources, "f")}, result.Allocations[0].Allocated)
+	assert.Equal(2, len(result.Allocations[1].Allocated))
+
+	// Not enough labs, and why
+	result = simulateLeases(resources, &SimulationRequest{At: now, Leases: []SimulatedLease{
+		{Name: "gpu", Request: []AllocationRequest{{Type: "Lab", Group: "labs", Name: "", Count: 2, Filters: gpu}}},
+		{Name: "big", Request: []AllocationRequest{{Type: "Lab", Group: "labs", Name: "", Count: 3, Filters: nil}}},
+	}}, nil)
+	assert.False(result.Satisfiable)
+	assert.Equal(0, result.Allocations[0].Missing)
+
+	big := result.Allocations[1]
+	assert.Equal(2, big.Missing)
+	assert.Equal([]Blocker{
+		{Reason: BlockedAllocated, Count: 2, Leases: []string{"gpu"}},
+		{Reason: BlockedLeased, Count: 1, Leases: []string{held.ID}},
+		{Reason: BlockedLifecycle, Count: 1, Leases: nil},
+		{Reason: BlockedUnhealthy, Count: 1, Leases: nil},
+	}, big.Blockers)
+
+	// Once the lease expires its lab is free again
+	result = simulateLeases(resources, &SimulationRequest{At: now.Add(2 * time.Hour), Leases: []SimulatedLease{
+		{Name: "", Request: []AllocationRequest{{Type: "Lab", Group: "labs", Name: "", Count: 4, Filters: nil}}},
+	}}, nil)
+	assert.True(result.Satisfiable)
+
+	// By name, and of unknown types
+	result = simulateLeases(resources, &SimulationRequest{At: now, Leases: []SimulatedLease{
+		{Name: "", Request: []AllocationRequest{{Type: "Lab", Group: "", Name: "A", Count: 1, Filters: nil}}},
+		{Name: "", Request: []AllocationRequest{{Type: "Rack", Group: "", Name: "", Count: 1, Filters: nil}}},
+	}}, nil)
+	assert.False(result.Satisfiable)
+	assert.Equal([]string{labs(resources, "a")}, result.Allocations[0].Allocated)
+	assert.Equal(1, result.Allocations[1].Missing)
+	assert.Empty(result.Allocations[1].Blockers)
+}
+
+// labs returns the ID of the named lab.
+func labs(resources *zebra.ResourceMap, name string) string {
+	for _, r := range resources.Resources["Lab"].Resources {
+		if r.(*dc.Lab).Name == name {
+			return r.GetID()
+		}
+	}
+
+	return ""
+}
+
+func TestHandleSimulateLeases(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+	assert.Nil(api.Store.Create(dc.NewLab("a", zebra.Labels{"system.group": "labs"})))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "user@zebra", false))
+		rr := httptest.NewRecorder()
+
+		handleSimulateLeases()(rr, httptest.NewRequest("POST", "/api/v1/leases/simulate", strings.NewReader(body)).
+			WithContext(ctx), httprouter.Params{})
+
+		return rr
+	}
+
+	rr := post(`{"leases":[{"request":[{"type":"Lab","group":"labs","count":1}]}]}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	result := new(SimulationResult)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.True(result.Satisfiable)
+	assert.False(result.At.IsZero())
+
+	// Nothing was leased
+	assert.Equal(zebra.Free, api.Store.QueryType([]string{"Lab"}).Resources["Lab"].Resources[0].GetStatus().Lease)
+
+	rr = post(`{"leases":[{"request":[{"type":"Lab","group":"labs","count":2}]}]}`)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.False(result.Satisfiable)
+
+	for _, body := range []string{
+		`{"leases":[]}`,
+		`{"leases":[{"request":[]}]}`,
+		`{"leases":[{"request":[{"type":"Lab","count":0}]}]}`,
+		`{"leases":[{"request":[{"type":"Lab","count":1,"filters":[{"op":"==","key":"a","values":[]}]}]}]}`,
+		`leases`,
+	} {
+		assert.Equal(http.StatusBadRequest, post(body).Code, body)
+	}
+}
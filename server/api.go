@@ -0,0 +1,844 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/attachment"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/faults"
+	"github.com/project-safari/zebra/history"
+	"github.com/project-safari/zebra/kv"
+	"github.com/project-safari/zebra/notify"
+	"github.com/project-safari/zebra/protoenc"
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/project-safari/zebra/secrets"
+	"github.com/project-safari/zebra/store"
+	"github.com/project-safari/zebra/timeseries"
+)
+
+type ResourceAPI struct {
+	factory     zebra.ResourceFactory
+	format      string
+	lazyIndex   bool
+	lifecycle   *zebra.LifecyclePolicy
+	root        string
+	replayed    bool
+	Store       zebra.Store
+	archive     zebra.Store
+	Audit       *audit.Log
+	History     *history.History
+	Events      *events.Log
+	Inbox       *notify.Inbox
+	transfers   *transferList
+	limits      *accountLimits
+	approvals   *approvalList
+	extensions  *approvalList
+	webhooks    *webhookDispatcher
+	siem        *siemExporter
+	aliases     *labelAliases
+	duplicates  duplicateRules
+	propagation propagationRules
+	hooks       []ValidationHook
+	admission   *admissionController
+	policy      *policyEngine
+	syslog      *syslogReceiver
+	attachments *attachment.Store
+	leasePolicy *leasePolicy
+	scoring     scoringPolicy
+	directory   *directory
+	sessions    *sessionList
+	heartbeats  *heartbeats
+	drift       *driftAlerts
+	sync        *siteSync
+	names       *nameRegistry
+	comments    *commentList
+	views       *viewList
+	suggestions *suggestIndex
+	recordings  *recorder
+	faults      *faults.Injector
+	deletes     *zebra.DeletePolicy
+	readOnly    *readOnlyMode
+	quotas      quotas
+	naming      *naming
+	locks       sync.Mutex
+	kv          *kv.Store
+	utilization *timeseries.DB
+	cloud       *cloudAccounts
+	provisioner *provisioner
+	federation  *federation
+	logins      *loginGuard
+	authKeys    *secrets.Keyring
+	credentials *secrets.Cipher
+	auditKey    *auth.RsaIdentity
+	auditLegacy int
+	jobs        *scheduler.Scheduler
+}
+
+type QueryRequest struct {
+	IDs        []string          `json:"ids,omitempty"`
+	Types      []string          `json:"types,omitempty"`
+	Labels     []zebra.Query     `json:"labels,omitempty"`
+	Properties []zebra.Query     `json:"properties,omitempty"`
+	Lifecycle  []zebra.Lifecycle `json:"lifecycle,omitempty"`
+	Heartbeat  []string          `json:"heartbeat,omitempty"`
+}
+
+var ErrQueryRequest = errors.New("invalid GET query request body")
+
+func (qr *QueryRequest) Validate(ctx context.Context) error {
+	id := len(qr.IDs) != 0
+	t := len(qr.Types) != 0
+	l := len(qr.Labels) != 0 || len(qr.Heartbeat) != 0
+	p := len(qr.Properties) != 0
+
+	// Make sure only id (and labels), types (and labels), or labels are present
+	if (id && (t || p)) || (p && (t || l)) {
+		return ErrQueryRequest
+	}
+
+	// Check Labels queries are valid
+	if err := validateQueries(qr.Labels); err != nil {
+		return err
+	}
+
+	// Check lifecycle stages are valid
+	for _, l := range qr.Lifecycle {
+		if l == "" || l.Validate() != nil {
+			return zebra.ErrLifecycle
+		}
+	}
+
+	// Check host statuses are valid
+	if err := validHostStatuses(qr.Heartbeat); err != nil {
+		return err
+	}
+
+	// Check Properties queries are valid
+	return validateQueries(qr.Properties)
+}
+
+func NewResourceAPI(factory zebra.ResourceFactory) *ResourceAPI {
+	return &ResourceAPI{
+		factory:     factory,
+		format:      store.FormatFiles,
+		lazyIndex:   false,
+		lifecycle:   zebra.NewLifecyclePolicy(),
+		root:        "",
+		replayed:    false,
+		Store:       nil,
+		archive:     nil,
+		Audit:       audit.NewLog(""),
+		History:     history.NewHistory("", history.DefaultMaxVersions),
+		Events:      events.NewLog("", events.DefaultRetention, events.DefaultMaxAge),
+		Inbox:       notify.NewInbox(notify.DefaultInboxSize),
+		transfers:   newTransferList(),
+		limits:      newAccountLimits(),
+		approvals:   nil,
+		extensions:  newApprovalList(DefaultApprovalTTL),
+		webhooks:    nil,
+		siem:        nil,
+		aliases:     newLabelAliases(""),
+		duplicates:  duplicateRules{},
+		propagation: propagationRules{},
+		hooks:       nil,
+		admission:   nil,
+		policy:      nil,
+		syslog:      nil,
+		attachments: nil,
+		leasePolicy: defaultLeasePolicy(),
+		scoring:     scoringPolicy{},
+		directory:   defaultDirectory(),
+		sessions:    newSessionList(""),
+		heartbeats:  newHeartbeats(""),
+		drift:       newDriftAlerts(),
+		sync:        newSiteSync(""),
+		names:       newNameRegistry(""),
+		comments:    newCommentList(""),
+		views:       newViewList(""),
+		suggestions: newSuggestIndex(),
+		recordings:  newRecorder(),
+		faults:      nil,
+		deletes:     zebra.NewDeletePolicy(),
+		readOnly:    newReadOnlyMode(&ReadOnlyConfig{Enabled: false, Reason: ""}),
+		quotas:      quotas{},
+		naming:      nil,
+		locks:       sync.Mutex{},
+		kv:          kv.NewStore("", kv.DefaultRetention),
+		utilization: timeseries.NewDB("", timeseries.DefaultTiers()),
+		cloud:       nil,
+		provisioner: nil,
+		federation:  nil,
+		logins:      defaultLoginGuard(),
+		authKeys:    nil,
+		credentials: nil,
+		auditKey:    nil,
+		auditLegacy: 0,
+		jobs:        scheduler.New(),
+	}
+}
+
+// Set up store and query store given storage root. The audit log, the
+// resource history, the events and the archive are kept alongside the
+// resources in the storage root.
+func (api *ResourceAPI) Initialize(storageRoot string) error {
+	resStore := store.NewResourceStore(storageRoot, api.factory)
+	resStore.Format = api.format
+	resStore.LazyIndex = api.lazyIndex
+	resStore.Lifecycle = api.lifecycle
+	resStore.Deletes = api.deletes
+	api.Store = resStore
+
+	if api.credentials != nil {
+		resStore.Sealer = api.credentials
+	}
+	api.root = storageRoot
+
+	if err := api.Store.Initialize(); err != nil {
+		return err
+	}
+
+	// Archived resources are rarely queried, their labels are indexed lazily
+	archive := store.NewResourceStore(path.Join(storageRoot, "archive"), api.factory)
+	archive.Format = api.format
+	archive.LazyIndex = true
+	api.archive = archive
+
+	if api.credentials != nil {
+		archive.Sealer = api.credentials
+	}
+
+	if err := api.archive.Initialize(); err != nil {
+		return err
+	}
+
+	api.Audit = audit.NewLog(path.Join(storageRoot, "audit.log"))
+	if err := api.Audit.Initialize(); err != nil {
+		return err
+	}
+
+	api.History = history.NewHistory(path.Join(storageRoot, "history.log"), history.DefaultMaxVersions)
+	if err := api.History.Initialize(); err != nil {
+		return err
+	}
+
+	api.Events = events.NewLog(path.Join(storageRoot, "events.log"), events.DefaultRetention, events.DefaultMaxAge)
+	if err := api.Events.Initialize(); err != nil {
+		return err
+	}
+
+	api.aliases = newLabelAliases(path.Join(storageRoot, "label-aliases.json"))
+	if err := api.aliases.load(); err != nil {
+		return err
+	}
+
+	api.sessions = newSessionList(path.Join(storageRoot, "sessions.json"))
+	if err := api.sessions.load(); err != nil {
+		return err
+	}
+
+	api.heartbeats = newHeartbeats(path.Join(storageRoot, "heartbeats.json"))
+	if err := api.heartbeats.load(); err != nil {
+		return err
+	}
+
+	api.sync = newSiteSync(path.Join(storageRoot, "sync.log"))
+	if err := api.sync.load(); err != nil {
+		return err
+	}
+
+	api.names = newNameRegistry(path.Join(storageRoot, "names.json"))
+	if err := api.names.load(); err != nil {
+		return err
+	}
+
+	api.comments = newCommentList(path.Join(storageRoot, "comments.json"))
+	if err := api.comments.load(); err != nil {
+		return err
+	}
+
+	api.views = newViewList(path.Join(storageRoot, "views.json"))
+	if err := api.views.load(); err != nil {
+		return err
+	}
+
+	api.kv = kv.NewStore(path.Join(storageRoot, "kv.json"), kv.DefaultRetention)
+	if err := api.kv.Initialize(); err != nil {
+		return err
+	}
+
+	api.utilization = timeseries.NewDB(path.Join(storageRoot, "utilization.json"), timeseries.DefaultTiers())
+	if err := api.utilization.Initialize(); err != nil {
+		return err
+	}
+
+	api.replayed = true
+
+	return nil
+}
+
+// create adds or updates the resource in the store, with the labels it
+// inherits from its parent if the user may write them, records the new
+// version of it in the history, appends the change to the events and updates
+// the labels of its children.
+func (api *ResourceAPI) create(ctx context.Context, res zebra.Resource) error {
+	eventType := events.Updated
+	if prev, err := api.History.Get(res.GetID(), 0); err != nil || prev.Deleted {
+		eventType = events.Created
+	}
+
+	if err := api.faults.Write(); err != nil {
+		return err
+	}
+
+	inherited, err := api.inherit(res)
+	if err != nil {
+		return err
+	}
+
+	if inherited != res && !api.mayWrite(ctx, inherited) {
+		return ErrInheritDenied
+	}
+
+	res = inherited
+
+	var previous zebra.Resource
+	if len(api.propagation) != 0 {
+		previous = findResource(api.Store, res.GetID())
+	}
+
+	release, err := api.admitQuotas(res)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = api.Store.Create(res)
+
+	release()
+
+	if err != nil {
+		return err
+	}
+
+	observeWrite(ctx, "create", res, start)
+
+	if _, err := api.History.Record(res, actor(ctx)); err != nil {
+		return err
+	}
+
+	if err := api.track(ctx, res, false); err != nil {
+		return err
+	}
+
+	if err := api.recordEvent(ctx, eventType, res); err != nil {
+		return err
+	}
+
+	return api.syncChildren(ctx, res, previous)
+}
+
+// delete removes the resource from the store, records the deletion in the
+// history and appends it to the events.
+func (api *ResourceAPI) delete(ctx context.Context, res zebra.Resource) error {
+	if err := api.faults.Write(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := api.Store.Delete(res); err != nil {
+		return err
+	}
+
+	observeWrite(ctx, "delete", res, start)
+
+	if _, err := api.History.RecordDelete(res, actor(ctx)); err != nil {
+		return err
+	}
+
+	if err := api.track(ctx, res, true); err != nil {
+		return err
+	}
+
+	return api.recordEvent(ctx, events.Deleted, res)
+}
+
+func (api *ResourceAPI) recordEvent(ctx context.Context, eventType string, res zebra.Resource) error {
+	if api.faults.DropEvent() {
+		return nil
+	}
+
+	event, err := events.NewEvent(eventType, res, actor(ctx))
+	if err != nil {
+		return err
+	}
+
+	_, err = api.Events.Append(event)
+
+	return err
+}
+
+// actor returns the email of the user making the request, if known.
+func actor(ctx context.Context) string {
+	if claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims); ok {
+		return claims.Email
+	}
+
+	return ""
+}
+
+// actorType returns the audit actor type of the claims.
+func actorType(claims *auth.Claims) string {
+	if claims != nil && claims.ServiceAccount {
+		return audit.ActorService
+	}
+
+	return audit.ActorUser
+}
+
+// recordAudit adds an entry for an action of the user making the request to
+// the audit log.
+func (api *ResourceAPI) recordAudit(ctx context.Context, action string, resID string, detail string) {
+	claims, _ := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	_ = api.Audit.Record(audit.Entry{
+		Time:         time.Now(),
+		Actor:        actor(ctx),
+		ActorType:    actorType(claims),
+		Impersonator: impersonator(ctx),
+		Action:       action,
+		Resource:     resID,
+		Detail:       detail,
+	})
+}
+
+// recordSystemAudit adds an entry for an action the server took on its own,
+// such as a scheduled job, to the audit log.
+func (api *ResourceAPI) recordSystemAudit(action string, resID string, detail string) {
+	_ = api.Audit.Record(audit.Entry{
+		Time:         time.Now(),
+		Actor:        audit.ActorSystem,
+		ActorType:    audit.ActorSystem,
+		Impersonator: "",
+		Action:       action,
+		Resource:     resID,
+		Detail:       detail,
+	})
+}
+
+// Apply given function f to each resource in resMap.
+// Return error if it occurrs or nil if successful.
+func applyFunc(resMap *zebra.ResourceMap, f func(zebra.Resource) error) error {
+	for _, l := range resMap.Resources {
+		for _, r := range l.Resources {
+			if err := f(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isDryRun returns true if the request asks for the mutation to be validated
+// and evaluated without being persisted, via the dryRun query parameter.
+func isDryRun(req *http.Request) bool {
+	dryRun, err := strconv.ParseBool(req.URL.Query().Get("dryRun"))
+
+	return err == nil && dryRun
+}
+
+// Validate all queries in given slice.
+func validateQueries(queries []zebra.Query) error {
+	for _, q := range queries {
+		if err := q.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// existingResources returns the resources in resMap that are present in the
+// store.
+func existingResources(s zebra.Store, resMap *zebra.ResourceMap) *zebra.ResourceMap {
+	ids := make([]string, 0)
+
+	for _, l := range resMap.Resources {
+		for _, r := range l.Resources {
+			ids = append(ids, r.GetID())
+		}
+	}
+
+	return s.QueryUUID(ids)
+}
+
+// Validate all resources in a resource map.
+func validateResources(ctx context.Context, resMap *zebra.ResourceMap) error {
+	// Check all resources to make sure they are valid
+	for _, l := range resMap.Resources {
+		for _, r := range l.Resources {
+			if err := r.Validate(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// query returns the resources matching the query request, which must have
+// been validated. Label queries for renamed keys match the new keys.
+func (api *ResourceAPI) query(qr *QueryRequest) *zebra.ResourceMap {
+	api.faults.Delay()
+
+	return api.queryFrom(api.Store, qr)
+}
+
+// queryFrom returns the resources of the store or snapshot matching the
+// query request.
+func (api *ResourceAPI) queryFrom(s querier, qr *QueryRequest) *zebra.ResourceMap {
+	resolved := *qr
+	resolved.Labels = api.resolveAliases(qr.Labels)
+
+	// Hosts are filtered by the status their heartbeats left in their labels
+	if len(qr.Heartbeat) != 0 {
+		resolved.Labels = append(resolved.Labels, zebra.Query{Key: AgentStatusLabel, Op: zebra.MatchIn, Values: qr.Heartbeat})
+	}
+
+	return api.plan(&resolved).run(s)
+}
+
+// snapshotter is implemented by stores that can take a snapshot of their
+// resources.
+type snapshotter interface {
+	Snapshot() *store.Snapshot
+}
+
+// view returns all resources as of a single point in time, for reads that
+// take long, without blocking changes to the store while they run.
+func (api *ResourceAPI) view() *zebra.ResourceMap {
+	api.faults.Delay()
+
+	if s, ok := api.Store.(snapshotter); ok {
+		return s.Snapshot().Resources()
+	}
+
+	return api.Store.Query()
+}
+
+func handleQuery() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		qr := new(QueryRequest)
+
+		// Read request, return error if applicable
+		if err := readJSON(ctx, req, qr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be queried, could not read request")
+
+			return
+		}
+
+		// Validate query request and label/property queries
+		if err := qr.Validate(ctx); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be queried, found invalid quer(y/ies)")
+
+			return
+		}
+
+		modified := api.Events.Modified()
+		resources := readableResources(ctx, api.queryTiers(qr, includeArchived(req)))
+
+		log.Info("successfully queried resources")
+
+		if responseFormat(ctx) == protoenc.MediaType {
+			data, err := protoenc.MarshalResources(resources)
+			writeCachedProtobuf(ctx, res, req, modified, data, err)
+
+			return
+		}
+
+		// Write response body
+		writeCachedJSON(ctx, res, req, modified, resources)
+	}
+}
+
+func handlePost() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+		// Read request, return error if applicable
+		if err := readJSON(ctx, req, resMap); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be created, could not read request")
+
+			return
+		}
+
+		if validateResources(ctx, resMap) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be created, found invalid resource(s)")
+
+			return
+		}
+
+		if !api.guardAdmission(res, req, resMap, false) {
+			log.Info("resources could not be created, rejected by admission webhook(s)")
+
+			return
+		}
+
+		if err := api.inheritLabels(resMap); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Info("internal server error while propagating labels")
+
+			return
+		}
+
+		// The labels inherited must be allowed as much as those requested
+		if claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims); ok && len(api.propagation) != 0 {
+			if denials := mutationDenials(claims, api.Store, req.Method, resMap); len(denials) != 0 {
+				log.Info("resources could not be created, inherited labels not authorized", "denied", len(denials))
+				writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+				return
+			}
+		}
+
+		if !api.guardProtected(res, req, req.Method, resMap) {
+			return
+		}
+
+		if !api.guardDuplicates(res, req, resMap) {
+			log.Info("resources could not be created, found duplicate resource(s)")
+
+			return
+		}
+
+		if !api.guardHooks(res, req, resMap) {
+			log.Info("resources could not be created, validation hooks failed")
+
+			return
+		}
+
+		if !api.guardLeasePolicies(res, req, resMap) {
+			log.Info("resources could not be created, leases break their lease policies")
+
+			return
+		}
+
+		if !api.guardPolicies(res, req, resMap, false) {
+			log.Info("resources could not be created, policies could not be loaded")
+
+			return
+		}
+
+		evictions, ok := api.guardQuotas(res, req, resMap)
+		if !ok {
+			log.Info("resources could not be created, quotas exceeded")
+
+			return
+		}
+
+		// Names are bound after all other checks, a dry run only checks them
+		names, ok := api.guardNames(res, req, resMap)
+		if !ok {
+			log.Info("resources could not be created, names are taken")
+
+			return
+		}
+
+		// Return the would-be result without changing the store
+		if isDryRun(req) {
+			log.Info("dry run, resources not created")
+			writeJSON(ctx, res, resMap)
+
+			return
+		}
+
+		created := map[string]bool{}
+
+		if err := api.evict(ctx, evictions); err != nil {
+			api.rollbackNames(ctx, names, created)
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Info("internal server error while evicting expired resources")
+
+			return
+		}
+
+		// Add all resources to store
+		if err := applyFunc(resMap, func(r zebra.Resource) error {
+			if err := api.create(ctx, r); err != nil {
+				return err
+			}
+
+			created[r.GetID()] = true
+
+			return nil
+		}); err != nil {
+			api.rollbackNames(ctx, names, created)
+
+			if errors.Is(err, zebra.ErrLifecycleTransition) || errors.Is(err, ErrQuotaExceeded) {
+				http.Error(res, err.Error(), http.StatusConflict)
+				log.Info("resources could not be created, lifecycle transition not allowed or quota exceeded")
+
+				return
+			}
+
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Info("internal server error while creating resources")
+
+			return
+		}
+
+		_ = applyFunc(resMap, func(r zebra.Resource) error {
+			api.recordAudit(ctx, "resource.apply", r.GetID(), r.GetType())
+
+			return nil
+		})
+
+		log.Info("successfully created resources")
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleDelete() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+		// Read request, return error if applicable
+		if err := readJSON(ctx, req, resMap); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be deleted, could not read request")
+
+			return
+		}
+
+		if validateResources(ctx, resMap) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be deleted, found invalid resource(s)")
+
+			return
+		}
+
+		if !api.guardProtected(res, req, req.Method, resMap) {
+			return
+		}
+
+		if !api.guardAdmission(res, req, resMap, true) {
+			log.Info("resources could not be deleted, rejected by admission webhook(s)")
+
+			return
+		}
+
+		if !api.guardPolicies(res, req, resMap, true) {
+			log.Info("resources could not be deleted, policies could not be unloaded")
+
+			return
+		}
+
+		// Plan the whole delete, under the delete policies of the resources
+		// referring to those requested, before deleting any
+		plans, err := api.planDeletes(resMap)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Info("internal server error while planning deletes")
+
+			return
+		}
+
+		if err := blockedDelete(plans); err != nil {
+			http.Error(res, err.Error(), http.StatusConflict)
+			log.Info("resources could not be deleted, still referred to")
+
+			return
+		}
+
+		if !api.guardPlans(res, req, plans) {
+			return
+		}
+
+		deleted := plannedDeletes(api.factory, plans)
+
+		// Return the resources that would be deleted without changing the store
+		if isDryRun(req) {
+			log.Info("dry run, resources not deleted")
+			writeJSON(ctx, res, deleted)
+
+			return
+		}
+
+		// Protected resources are deleted once the request is approved
+		if approval := api.gateDelete(ctx, deleted); approval != nil {
+			log.Info("delete waits for approval", "approval", approval.ID)
+			writeJSONCode(ctx, res, http.StatusAccepted, approval)
+
+			return
+		}
+
+		// The names are bound to the stored resources, which have them all
+		var names []NameClaim
+		if api.naming != nil {
+			names = api.naming.claims(deleted, actor(ctx))
+		}
+
+		for _, plan := range plans {
+			if err := api.carryOut(ctx, plan); err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				log.Info("internal server error while deleting resources")
+
+				return
+			}
+		}
+
+		if err := api.unbindNames(ctx, names); err != nil {
+			log.Error(err, "names of deleted resources could not be released")
+		}
+
+		log.Info("successfully deleted resources")
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
@@ -1,4 +1,4 @@
-package main //nolint:testpackage
+package server //nolint:testpackage
 
 import (
 	"bytes"
@@ -370,3 +370,48 @@ func createRequest(assert *assert.Assertions, method string, url string,
 
 	return req
 }
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "api_teststore_dryrun"
+
+	defer func() { os.RemoveAll(root) }()
+
+	myAPI := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(myAPI.Initialize(root))
+
+	post := handlePost()
+	del := handleDelete()
+
+	body := `{"Lab":[{"id":"0100000003","type":"Lab","labels":{"system.group":"labs"},"name":"lab"}]}`
+
+	// Dry run create does not change the store
+	req := createRequest(assert, "POST", "/resources?dryRun=true", body, myAPI)
+	rr := httptest.NewRecorder()
+	post(rr, req, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), "0100000003")
+	assert.Empty(myAPI.Store.Query().Resources)
+
+	// Invalid resources still fail in a dry run
+	req = createRequest(assert, "POST", "/resources?dryRun=true", `{"Lab":[{"id":"","type":"Lab"}]}`, myAPI)
+	rr = httptest.NewRecorder()
+	post(rr, req, nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	req = createRequest(assert, "POST", "/resources?dryRun=false", body, myAPI)
+	rr = httptest.NewRecorder()
+	post(rr, req, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(1, len(myAPI.Store.Query().Resources))
+
+	// Dry run delete returns the resources that would be deleted
+	req = createRequest(assert, "DELETE", "/resources?dryRun=true", body, myAPI)
+	rr = httptest.NewRecorder()
+	del(rr, req, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), "0100000003")
+	assert.Equal(1, len(myAPI.Store.Query().Resources))
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/protoenc"
+	"github.com/project-safari/zebra/store"
+)
+
+// DefaultPageLimit and MaxPageLimit bound the number of resources in a page
+// of /api/v2 query results.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var (
+	ErrPageLimit  = errors.New("page limit must be between 1 and 1000")
+	ErrPageOffset = errors.New("page offset must not be negative")
+)
+
+// Page is a page of query results. Resources are ordered by type and id, so
+// that a client can walk all results by following Next until it is unset.
+type Page struct {
+	Resources *zebra.ResourceMap `json:"resources"`
+	Total     int                `json:"total"`
+	Offset    int                `json:"offset"`
+	Limit     int                `json:"limit"`
+	Next      *int               `json:"next,omitempty"`
+}
+
+// DeleteResponse reports the outcome of a delete request for each resource.
+// Resources that are not in the store are not found rather than an error.
+// The resources referring to the deleted ones were cascaded or orphaned by
+// the delete policy in Policies, or blocked the delete of the resources in
+// Blocked.
+type DeleteResponse struct {
+	DryRun   bool                `json:"dryRun,omitempty"`
+	Deleted  []string            `json:"deleted"`
+	NotFound []string            `json:"notFound"`
+	Failed   map[string]string   `json:"failed,omitempty"`
+	Cascaded []string            `json:"cascaded,omitempty"`
+	Orphaned []string            `json:"orphaned,omitempty"`
+	Policies map[string]string   `json:"policies,omitempty"`
+	Blocked  map[string][]string `json:"blocked,omitempty"`
+}
+
+// report adds the resources cascaded and orphaned by the plan.
+func (resp *DeleteResponse) report(plan *store.DeletePlan) {
+	resp.Cascaded = append(resp.Cascaded, planIDs(plan.Cascaded)...)
+	resp.Orphaned = append(resp.Orphaned, planIDs(plan.Orphaned)...)
+
+	for id, policy := range plan.Policies {
+		resp.Policies[id] = policy
+	}
+}
+
+// pageParams returns the limit and offset query parameters of the request.
+func pageParams(req *http.Request) (int, int, error) {
+	values := req.URL.Query()
+	limit, offset := DefaultPageLimit, 0
+
+	if l := values.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return 0, 0, ErrPageLimit
+		}
+
+		limit = n
+	}
+
+	if o := values.Get("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			return 0, 0, ErrPageOffset
+		}
+
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// paginate returns the page of resources at offset.
+func paginate(resources *zebra.ResourceMap, limit, offset int) *Page {
+	all := make([]zebra.Resource, 0)
+
+	for _, l := range resources.Resources {
+		all = append(all, l.Resources...)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].GetType() != all[j].GetType() {
+			return all[i].GetType() < all[j].GetType()
+		}
+
+		return all[i].GetID() < all[j].GetID()
+	})
+
+	page := &Page{
+		Resources: zebra.NewResourceMap(resources.GetFactory()),
+		Total:     len(all),
+		Offset:    offset,
+		Limit:     limit,
+		Next:      nil,
+	}
+
+	for i := offset; i < len(all) && i < offset+limit; i++ {
+		page.Resources.Add(all[i], all[i].GetType())
+	}
+
+	if next := offset + limit; next < len(all) {
+		page.Next = &next
+	}
+
+	return page
+}
+
+// handleQueryV2 answers queries with a page of the results. Unlike v1, the
+// query body is optional, an empty body queries all resources.
+func handleQueryV2() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		limit, offset, err := pageParams(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		qr := new(QueryRequest)
+
+		if err := readJSON(ctx, req, qr); err != nil && !errors.Is(err, ErrEmptyBody) {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be queried, could not read request")
+
+			return
+		}
+
+		if err := qr.Validate(ctx); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be queried, found invalid quer(y/ies)")
+
+			return
+		}
+
+		log.Info("successfully queried resources")
+
+		modified := api.Events.Modified()
+		resources := readableResources(ctx, api.queryTiers(qr, includeArchived(req)))
+		page := paginate(resources, limit, offset)
+
+		if responseFormat(ctx) == protoenc.MediaType {
+			data, err := protoenc.MarshalPage(page.Resources, page.Total, page.Offset, page.Limit, page.Next)
+			writeCachedProtobuf(ctx, res, req, modified, data, err)
+
+			return
+		}
+
+		writeCachedJSON(ctx, res, req, modified, page)
+	}
+}
+
+// handleDeleteV2 deletes the resources that are in the store and reports
+// which resources were deleted, not found, or failed to be deleted.
+func handleDeleteV2() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+		if err := readJSON(ctx, req, resMap); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be deleted, could not read request")
+
+			return
+		}
+
+		if validateResources(ctx, resMap) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("resources could not be deleted, found invalid resource(s)")
+
+			return
+		}
+
+		if !api.guardProtected(res, req, req.Method, resMap) {
+			return
+		}
+
+		if !api.guardAdmission(res, req, resMap, true) {
+			log.Info("resources could not be deleted, rejected by admission webhook(s)")
+
+			return
+		}
+
+		if !api.guardPolicies(res, req, resMap, true) {
+			log.Info("resources could not be deleted, policies could not be unloaded")
+
+			return
+		}
+
+		plans, err := api.planDeletes(resMap)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Info("internal server error while planning deletes")
+
+			return
+		}
+
+		if !api.guardPlans(res, req, plans) {
+			return
+		}
+
+		if !isDryRun(req) {
+			if approval := api.gateDelete(ctx, plannedDeletes(api.factory, plans)); approval != nil {
+				log.Info("delete waits for approval", "approval", approval.ID)
+				writeJSONCode(ctx, res, http.StatusAccepted, approval)
+
+				return
+			}
+		}
+
+		planned := map[string]*store.DeletePlan{}
+		for _, plan := range plans {
+			planned[plan.Resource.GetID()] = plan
+		}
+
+		resp := &DeleteResponse{
+			DryRun:   isDryRun(req),
+			Deleted:  []string{},
+			NotFound: []string{},
+			Failed:   map[string]string{},
+			Cascaded: []string{},
+			Orphaned: []string{},
+			Policies: map[string]string{},
+			Blocked:  map[string][]string{},
+		}
+
+		for _, r := range deleteOrder(api.Store, resMap) {
+			api.deleteV2(ctx, resp, planned[r.GetID()], r.GetID())
+		}
+
+		switch {
+		case len(resp.Failed) != 0:
+			log.Info("internal server error while deleting resources", "failed", len(resp.Failed))
+			writeJSONCode(ctx, res, http.StatusInternalServerError, resp)
+
+			return
+		case len(resp.Blocked) != 0:
+			log.Info("resources could not be deleted, still referred to", "blocked", len(resp.Blocked))
+			writeJSONCode(ctx, res, http.StatusConflict, resp)
+
+			return
+		}
+
+		log.Info("successfully deleted resources", "deleted", len(resp.Deleted), "notFound", len(resp.NotFound))
+
+		writeJSON(ctx, res, resp)
+	}
+}
+
+// deleteV2 carries out the plan of deleting the resource of the ID, if it is
+// stored, and reports the outcome in the response. A dry run only reports
+// the plan.
+func (api *ResourceAPI) deleteV2(ctx context.Context, resp *DeleteResponse, plan *store.DeletePlan, id string) {
+	switch {
+	case zebra.IsIn(id, resp.Cascaded):
+		// Deleted with a resource deleted before it
+		resp.Deleted = append(resp.Deleted, id)
+
+		return
+	case plan == nil:
+		resp.NotFound = append(resp.NotFound, id)
+
+		return
+	case len(plan.Blocked) != 0:
+		resp.Blocked[id] = plan.Blocked
+
+		return
+	}
+
+	if !resp.DryRun {
+		if err := api.carryOut(ctx, plan); err != nil {
+			resp.Failed[id] = err.Error()
+
+			return
+		}
+	}
+
+	resp.Deleted = append(resp.Deleted, id)
+	resp.report(plan)
+}
@@ -0,0 +1,191 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newV2API(assert *assert.Assertions, root string, labs int) *ResourceAPI {
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	for i := 0; i < labs; i++ {
+		lab := dc.NewLab(fmt.Sprintf("lab%d", i), zebra.Labels{"system.group": "labs"})
+		assert.Nil(api.Store.Create(lab))
+	}
+
+	return api
+}
+
+func TestPageParams(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	params := func(query string) (int, int, error) {
+		return pageParams(httptest.NewRequest("GET", "/api/v2/resources"+query, nil))
+	}
+
+	limit, offset, err := params("")
+	assert.Nil(err)
+	assert.Equal(DefaultPageLimit, limit)
+	assert.Equal(0, offset)
+
+	limit, offset, err = params("?limit=10&offset=20")
+	assert.Nil(err)
+	assert.Equal(10, limit)
+	assert.Equal(20, offset)
+
+	for _, q := range []string{"?limit=0", "?limit=1001", "?limit=ten"} {
+		_, _, err = params(q)
+		assert.ErrorIs(err, ErrPageLimit)
+	}
+
+	_, _, err = params("?offset=-1")
+	assert.ErrorIs(err, ErrPageOffset)
+}
+
+func TestQueryV2(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "apiv2_testquery"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := newV2API(assert, root, 5)
+	h := handleQueryV2()
+
+	query := func(url, body string) (*httptest.ResponseRecorder, *Page) {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest("GET", url, strings.NewReader(body)).WithContext(ctx), nil)
+
+		page := &Page{Resources: zebra.NewResourceMap(store.DefaultFactory())}
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), page))
+		}
+
+		return rr, page
+	}
+
+	// Walk all pages
+	seen := map[string]bool{}
+	url := "/api/v2/resources?limit=2"
+
+	for pages := 0; pages < 3; pages++ {
+		rr, page := query(url, "")
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal(5, page.Total)
+
+		for _, r := range page.Resources.Resources["Lab"].Resources {
+			seen[r.GetID()] = true
+		}
+
+		if page.Next == nil {
+			assert.Equal(2, pages)
+
+			break
+		}
+
+		url = fmt.Sprintf("/api/v2/resources?limit=2&offset=%d", *page.Next)
+	}
+
+	assert.Equal(5, len(seen))
+
+	// Queries are the same as in v1
+	rr, page := query("/api/v2/resources", `{"types":["Rack"]}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(0, page.Total)
+	assert.Nil(page.Next)
+
+	// Offsets past the end return an empty page
+	rr, page = query("/api/v2/resources?offset=10", "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Empty(page.Resources.Resources)
+
+	rr, _ = query("/api/v2/resources?limit=0", "")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr, _ = query("/api/v2/resources", "{...}")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr, _ = query("/api/v2/resources", `{"ids":["a"],"types":["Lab"]}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/api/v2/resources", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func TestDeleteV2(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "apiv2_testdelete"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := newV2API(assert, root, 2)
+	h := handleDeleteV2()
+
+	labs := api.Store.QueryType([]string{"Lab"})
+	missing := dc.NewLab("missing", zebra.Labels{"system.group": "labs"})
+	labs.Add(missing, "Lab")
+
+	body, err := json.Marshal(labs)
+	assert.Nil(err)
+
+	deleteLabs := func(url string) (*httptest.ResponseRecorder, *DeleteResponse) {
+		rr := httptest.NewRecorder()
+		h(rr, createRequest(assert, "DELETE", url, string(body), api), nil)
+
+		resp := new(DeleteResponse)
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), resp))
+		}
+
+		return rr, resp
+	}
+
+	rr, resp := deleteLabs("/api/v2/resources?dryRun=true")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.True(resp.DryRun)
+	assert.Equal(2, len(resp.Deleted))
+	assert.Equal([]string{missing.ID}, resp.NotFound)
+	assert.Equal(2, len(api.Store.Query().Resources["Lab"].Resources))
+
+	rr, resp = deleteLabs("/api/v2/resources")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.False(resp.DryRun)
+	assert.Equal(2, len(resp.Deleted))
+	assert.Equal([]string{missing.ID}, resp.NotFound)
+	assert.Empty(api.Store.Query().Resources)
+
+	rr, resp = deleteLabs("/api/v2/resources")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Empty(resp.Deleted)
+	assert.Equal(3, len(resp.NotFound))
+
+	rr = httptest.NewRecorder()
+	h(rr, createRequest(assert, "DELETE", "/api/v2/resources", `{"Lab":[{"id":"","type":"Lab"}]}`, api), nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h(rr, createRequest(assert, "DELETE", "/api/v2/resources", "[]", api), nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest("DELETE", "/api/v2/resources", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
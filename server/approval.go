@@ -0,0 +1,506 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// DefaultApprovalTTL is how long a request waits for approval before it
+// expires.
+const DefaultApprovalTTL = 24 * time.Hour
+
+// Operations that may require approval.
+const (
+	OperationDelete = "delete"
+	OperationWipe   = "wipe"
+	OperationExtend = "extend"
+)
+
+// ApprovalStatus is the state of an approval request.
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalExpired  ApprovalStatus = "expired"
+)
+
+var (
+	ErrApprovalNotFound = errors.New("approval request not found")
+	ErrApprovalDecided  = errors.New("approval request is no longer pending")
+	ErrSelfApproval     = errors.New("approval requests must be approved by another admin")
+	ErrNotApprover      = errors.New("approval requests can only be decided by admins")
+	ErrWipeTypes        = errors.New("wipe requires at least one resource type")
+	ErrWipeNotAdmin     = errors.New("wipe requires admin privileges")
+)
+
+// ApprovalConfig is the approval gate configuration of the server. The gate
+// is off unless enabled, TTL is a duration such as "24h".
+type ApprovalConfig struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl,omitempty"`
+}
+
+// Approval is a sensitive operation waiting for, or decided by, a second
+// admin. Deletes name the resources to delete, wipes the resource types,
+// lease extensions the lease and the extension. Requests made by an admin as
+// another user name the admin, who may not approve them either.
+type Approval struct {
+	ID             string         `json:"id"`
+	Operation      string         `json:"operation"`
+	Resources      []string       `json:"resources,omitempty"`
+	Types          []string       `json:"types,omitempty"`
+	Extension      string         `json:"extension,omitempty"`
+	Status         ApprovalStatus `json:"status"`
+	RequestedBy    string         `json:"requestedBy"`
+	ImpersonatedBy string         `json:"impersonatedBy,omitempty"`
+	Requested      time.Time      `json:"requested"`
+	Expires        time.Time      `json:"expires"`
+	DecidedBy      string         `json:"decidedBy,omitempty"`
+	Decided        time.Time      `json:"decided,omitempty"`
+	Deleted        []string       `json:"deleted,omitempty"`
+}
+
+// WipeRequest asks to delete all resources of the given types.
+type WipeRequest struct {
+	Types []string `json:"types"`
+}
+
+func (wr *WipeRequest) Validate(ctx context.Context) error {
+	if len(wr.Types) == 0 {
+		return ErrWipeTypes
+	}
+
+	return nil
+}
+
+// approvalList keeps the approval requests of the server. Pending requests
+// expire once they are older than the ttl.
+type approvalList struct {
+	lock      sync.Mutex
+	ttl       time.Duration
+	approvals map[string]*Approval
+}
+
+func newApprovalList(ttl time.Duration) *approvalList {
+	if ttl <= 0 {
+		ttl = DefaultApprovalTTL
+	}
+
+	return &approvalList{
+		lock:      sync.Mutex{},
+		ttl:       ttl,
+		approvals: make(map[string]*Approval),
+	}
+}
+
+// newApprovalGate returns the approval list for the configuration, or nil if
+// the gate is not enabled.
+func newApprovalGate(cfg *ApprovalConfig) (*approvalList, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	ttl := DefaultApprovalTTL
+
+	if cfg.TTL != "" {
+		d, err := time.ParseDuration(cfg.TTL)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl = d
+	}
+
+	return newApprovalList(ttl), nil
+}
+
+func (a *approvalList) request(op string, requestedBy string, impersonatedBy string, resources []string,
+	types []string, now time.Time,
+) *Approval {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.add(op, requestedBy, impersonatedBy, resources, types, now)
+}
+
+// add adds a pending request, the caller holds the lock.
+func (a *approvalList) add(op string, requestedBy string, impersonatedBy string, resources []string,
+	types []string, now time.Time,
+) *Approval {
+	approval := &Approval{
+		ID:             uuid.New().String(),
+		Operation:      op,
+		Resources:      resources,
+		Types:          types,
+		Extension:      "",
+		Status:         ApprovalPending,
+		RequestedBy:    requestedBy,
+		ImpersonatedBy: impersonatedBy,
+		Requested:      now,
+		Expires:        now.Add(a.ttl),
+		DecidedBy:      "",
+		Decided:        time.Time{},
+		Deleted:        nil,
+	}
+
+	a.approvals[approval.ID] = approval
+
+	return approval
+}
+
+// expire marks the pending requests past their expiry as expired and
+// returns them.
+func (a *approvalList) expire(now time.Time) []*Approval {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	expired := []*Approval{}
+
+	for _, approval := range a.approvals {
+		if approval.Status == ApprovalPending && now.After(approval.Expires) {
+			approval.Status = ApprovalExpired
+			approval.Decided = now
+			expired = append(expired, approval)
+		}
+	}
+
+	return expired
+}
+
+// list returns copies of the requests with the status, or all requests if
+// the status is empty, oldest first.
+func (a *approvalList) list(status ApprovalStatus) []Approval {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	approvals := []Approval{}
+
+	for _, approval := range a.approvals {
+		if status == "" || approval.Status == status {
+			approvals = append(approvals, *approval)
+		}
+	}
+
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].Requested.Before(approvals[j].Requested)
+	})
+
+	return approvals
+}
+
+// decide moves a pending request to the status on behalf of decidedBy. The
+// requester may withdraw a request, only another user may approve it, nor
+// may the admin who made the request as the requester.
+func (a *approvalList) decide(id string, status ApprovalStatus, decidedBy string, now time.Time) (*Approval, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	approval, ok := a.approvals[id]
+
+	switch {
+	case !ok:
+		return nil, ErrApprovalNotFound
+	case approval.Status != ApprovalPending:
+		return nil, ErrApprovalDecided
+	case status == ApprovalApproved && approval.RequestedBy == decidedBy,
+		status == ApprovalApproved && approval.ImpersonatedBy == decidedBy:
+		return nil, ErrSelfApproval
+	}
+
+	approval.Status = status
+	approval.DecidedBy = decidedBy
+	approval.Decided = now
+
+	return approval, nil
+}
+
+func (a *approvalList) setDeleted(approval *Approval, deleted []string) Approval {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	approval.Deleted = deleted
+
+	return *approval
+}
+
+// expireApprovals expires stale approval requests, records them in the audit
+// log and returns how many expired.
+func (api *ResourceAPI) expireApprovals(now time.Time) int {
+	expired := api.approvals.expire(now)
+
+	for _, approval := range expired {
+		api.recordSystemAudit("approval.expire", approval.ID, approval.Operation)
+	}
+
+	return len(expired)
+}
+
+// gateDelete returns a pending approval request if the delete of resMap
+// touches protected resources and the approval gate is enabled, otherwise it
+// returns nil and the delete may go ahead.
+func (api *ResourceAPI) gateDelete(ctx context.Context, resMap *zebra.ResourceMap) *Approval {
+	if api.approvals == nil {
+		return nil
+	}
+
+	ids := []string{}
+	protected := false
+
+	_ = applyFunc(existingResources(api.Store, resMap), func(r zebra.Resource) error {
+		ids = append(ids, r.GetID())
+		protected = protected || isProtected(r)
+
+		return nil
+	})
+
+	if !protected {
+		return nil
+	}
+
+	sort.Strings(ids)
+
+	approval := api.approvals.request(OperationDelete, actor(ctx), impersonator(ctx), ids, nil, time.Now())
+	api.recordAudit(ctx, "approval.request", approval.ID, OperationDelete+" "+strings.Join(ids, ","))
+
+	return approval
+}
+
+// execute performs an approved operation and returns the ids of the deleted
+// resources. Resources deleted in the meantime are skipped, nothing is
+// deleted if the delete policy blocks the delete of any.
+func (api *ResourceAPI) execute(ctx context.Context, approval *Approval) ([]string, error) {
+	var resources *zebra.ResourceMap
+
+	if approval.Operation == OperationWipe {
+		resources = api.Store.QueryType(approval.Types)
+	} else {
+		resources = api.Store.QueryUUID(approval.Resources)
+	}
+
+	plans, err := api.planDeletes(resources)
+	if err == nil {
+		err = blockedDelete(plans)
+	}
+
+	if err != nil {
+		return []string{}, err
+	}
+
+	deleted := []string{}
+
+	for _, plan := range plans {
+		if err := api.carryOut(ctx, plan); err != nil {
+			sort.Strings(deleted)
+
+			return deleted, err
+		}
+
+		deleted = append(deleted, plan.Resource.GetID())
+		deleted = append(deleted, planIDs(plan.Cascaded)...)
+	}
+
+	sort.Strings(deleted)
+
+	return deleted, nil
+}
+
+// approvalContext returns the api and the claims of an approval request, it
+// writes the error response if either is missing or the gate is disabled.
+func approvalContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	if api.approvals == nil {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, false
+	}
+
+	return api, claims, true
+}
+
+// handleApprovals lists the approval requests, optionally filtered by the
+// status query parameter.
+func handleApprovals() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, _, ok := approvalContext(res, req)
+		if !ok {
+			return
+		}
+
+		api.expireApprovals(time.Now())
+
+		status := ApprovalStatus(req.URL.Query().Get("status"))
+
+		writeJSON(req.Context(), res, api.approvals.list(status))
+	}
+}
+
+func handleApprove() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := approvalContext(res, req)
+		if !ok || refuseImpersonated(res, req) {
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			http.Error(res, ErrNotApprover.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		api.expireApprovals(time.Now())
+
+		approval, err := api.approvals.decide(params.ByName("id"), ApprovalApproved, claims.Email, time.Now())
+		if err != nil {
+			writeApprovalError(res, err)
+
+			return
+		}
+
+		api.recordAudit(ctx, "approval.approve", approval.ID, approval.Operation)
+
+		deleted, err := api.execute(ctx, approval)
+		result := api.approvals.setDeleted(approval, deleted)
+
+		if err != nil {
+			log.Error(err, "approved operation failed", "approval", approval.ID)
+			writeJSONCode(ctx, res, http.StatusInternalServerError, result)
+
+			return
+		}
+
+		log.Info("approved operation executed", "approval", approval.ID, "approver", claims.Email,
+			"deleted", len(deleted))
+
+		writeJSON(ctx, res, result)
+	}
+}
+
+func handleReject() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, claims, ok := approvalContext(res, req)
+		if !ok || refuseImpersonated(res, req) {
+			return
+		}
+
+		api.expireApprovals(time.Now())
+
+		id := params.ByName("id")
+		requester := false
+
+		for _, a := range api.approvals.list(ApprovalPending) {
+			requester = requester || (a.ID == id && a.RequestedBy == claims.Email)
+		}
+
+		if !requester && !claims.Write(AdminKey) {
+			http.Error(res, ErrNotApprover.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		approval, err := api.approvals.decide(id, ApprovalRejected, claims.Email, time.Now())
+		if err != nil {
+			writeApprovalError(res, err)
+
+			return
+		}
+
+		api.recordAudit(ctx, "approval.reject", approval.ID, approval.Operation)
+
+		writeJSON(ctx, res, approval)
+	}
+}
+
+// handleWipe deletes all resources of the requested types. Admins only, and
+// with the approval gate enabled only once a second admin approved it.
+func handleWipe() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			http.Error(res, ErrWipeNotAdmin.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		wipe := new(WipeRequest)
+		if err := readJSON(ctx, req, wipe); err != nil || wipe.Validate(ctx) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("wipe failed, invalid request")
+
+			return
+		}
+
+		sort.Strings(wipe.Types)
+
+		if api.approvals != nil {
+			approval := api.approvals.request(OperationWipe, claims.Email, impersonator(ctx), nil, wipe.Types,
+				time.Now())
+			api.recordAudit(ctx, "approval.request", approval.ID, OperationWipe+" "+strings.Join(wipe.Types, ","))
+			log.Info("wipe waits for approval", "approval", approval.ID)
+			writeJSONCode(ctx, res, http.StatusAccepted, approval)
+
+			return
+		}
+
+		deleted, err := api.execute(ctx, &Approval{Operation: OperationWipe, Types: wipe.Types})
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			log.Error(err, "wipe failed")
+
+			return
+		}
+
+		log.Info("wipe completed", "types", wipe.Types, "deleted", len(deleted))
+
+		writeJSON(ctx, res, deleted)
+	}
+}
+
+func writeApprovalError(res http.ResponseWriter, err error) {
+	status := http.StatusConflict
+
+	switch {
+	case errors.Is(err, ErrApprovalNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrSelfApproval):
+		status = http.StatusForbidden
+	}
+
+	http.Error(res, err.Error(), status)
+}
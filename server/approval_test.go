@@ -0,0 +1,230 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalList(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Now()
+	approvals := newApprovalList(time.Hour)
+
+	a := approvals.request(OperationDelete, "a@zebra", "", []string{"1"}, nil, now)
+	b := approvals.request(OperationWipe, "a@zebra", "", nil, []string{"Lab"}, now.Add(time.Minute))
+
+	_, err := approvals.decide(a.ID, ApprovalApproved, "a@zebra", now)
+	assert.ErrorIs(err, ErrSelfApproval)
+
+	// Nor may the admin who requested it as another user
+	c := approvals.request(OperationDelete, "c@zebra", "b@zebra", []string{"2"}, nil, now)
+	_, err = approvals.decide(c.ID, ApprovalApproved, "b@zebra", now)
+	assert.ErrorIs(err, ErrSelfApproval)
+
+	_, err = approvals.decide(c.ID, ApprovalRejected, "b@zebra", now)
+	assert.Nil(err)
+
+	_, err = approvals.decide("nope", ApprovalApproved, "b@zebra", now)
+	assert.ErrorIs(err, ErrApprovalNotFound)
+
+	decided, err := approvals.decide(a.ID, ApprovalApproved, "b@zebra", now)
+	assert.Nil(err)
+	assert.Equal("b@zebra", decided.DecidedBy)
+
+	_, err = approvals.decide(a.ID, ApprovalRejected, "b@zebra", now)
+	assert.ErrorIs(err, ErrApprovalDecided)
+
+	assert.Empty(approvals.expire(now.Add(time.Hour)))
+	expired := approvals.expire(now.Add(2 * time.Hour))
+	assert.Equal(1, len(expired))
+	assert.Equal(b.ID, expired[0].ID)
+	assert.Empty(approvals.list(ApprovalPending))
+	assert.Equal(3, len(approvals.list("")))
+
+	gate, err := newApprovalGate(&ApprovalConfig{Enabled: false, TTL: ""})
+	assert.Nil(err)
+	assert.Nil(gate)
+
+	gate, err = newApprovalGate(&ApprovalConfig{Enabled: true, TTL: "2h"})
+	assert.Nil(err)
+	assert.Equal(2*time.Hour, gate.ttl)
+
+	_, err = newApprovalGate(&ApprovalConfig{Enabled: true, TTL: "soon"})
+	assert.NotNil(err)
+}
+
+func TestProtectedDelete(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "approval_testdelete"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	api.approvals = newApprovalList(time.Hour)
+
+	alice := makeClaims(assert, "alice@zebra", true)
+	bob := makeClaims(assert, "bob@zebra", true)
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	plain := dc.NewLab("plain", zebra.Labels{"system.group": "labs"})
+	vault := dc.NewLab("vault", zebra.Labels{"system.group": "labs", ProtectedLabel: ProtectedValue})
+
+	assert.Nil(api.Store.Create(plain))
+	assert.Nil(api.Store.Create(vault))
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, method, body string,
+		params httprouter.Params,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		// Protected resources are only deleted if forced
+		url := "/api/v1/resources?force=true"
+		h(rr, httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx), params)
+
+		return rr
+	}
+
+	// Unprotected resources are deleted right away
+	assert.Equal(http.StatusOK, serve(alice, handleDelete(), "DELETE", resMapJSON(assert, plain), nil).Code)
+
+	rr := serve(alice, handleDeleteV2(), "DELETE", resMapJSON(assert, vault), nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	pending := new(Approval)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), pending))
+	assert.Equal(ApprovalPending, pending.Status)
+	assert.Equal([]string{vault.ID}, pending.Resources)
+	assert.NotNil(findResource(api.Store, vault.ID))
+
+	id := httprouter.Params{{Key: "id", Value: pending.ID}}
+
+	assert.Equal(http.StatusForbidden, serve(alice, handleApprove(), "POST", "", id).Code)
+	assert.Equal(http.StatusForbidden, serve(user, handleApprove(), "POST", "", id).Code)
+	assert.Equal(http.StatusForbidden, serve(user, handleReject(), "POST", "", id).Code)
+
+	rr = serve(user, handleApprovals(), "GET", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), pending.ID)
+
+	rr = serve(bob, handleApprove(), "POST", "", id)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	approved := new(Approval)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), approved))
+	assert.Equal(ApprovalApproved, approved.Status)
+	assert.Equal([]string{vault.ID}, approved.Deleted)
+	assert.Nil(findResource(api.Store, vault.ID))
+
+	assert.Equal(http.StatusConflict, serve(bob, handleApprove(), "POST", "", id).Code)
+	assert.Equal(http.StatusNotFound, serve(bob, handleApprove(), "POST", "",
+		httprouter.Params{{Key: "id", Value: "nope"}}).Code)
+
+	// The requester may withdraw the request
+	assert.Nil(api.Store.Create(vault))
+
+	rr = serve(alice, handleDelete(), "DELETE", resMapJSON(assert, vault), nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), pending))
+
+	id = httprouter.Params{{Key: "id", Value: pending.ID}}
+	assert.Equal(http.StatusOK, serve(alice, handleReject(), "POST", "", id).Code)
+	assert.NotNil(findResource(api.Store, vault.ID))
+
+	// Stale requests expire
+	rr = serve(alice, handleDelete(), "DELETE", resMapJSON(assert, vault), nil)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), pending))
+	api.expireApprovals(time.Now().Add(2 * time.Hour))
+
+	id = httprouter.Params{{Key: "id", Value: pending.ID}}
+	assert.Equal(http.StatusConflict, serve(bob, handleApprove(), "POST", "", id).Code)
+	assert.NotNil(findResource(api.Store, vault.ID))
+
+	actions := []string{}
+	for _, e := range api.Audit.Entries() {
+		if strings.HasPrefix(e.Action, "approval.") {
+			actions = append(actions, e.Action)
+		}
+	}
+
+	assert.Equal([]string{
+		"approval.request", "approval.approve", "approval.request", "approval.reject",
+		"approval.request", "approval.expire",
+	}, actions)
+	assert.Equal(1, len(api.Audit.QueryActorType(audit.ActorSystem)))
+}
+
+func TestWipe(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "approval_testwipe"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	alice := makeClaims(assert, "alice@zebra", true)
+	bob := makeClaims(assert, "bob@zebra", true)
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	assert.Nil(api.Store.Create(dc.NewLab("lab1", zebra.Labels{"system.group": "labs"})))
+	assert.Nil(api.Store.Create(dc.NewLab("lab2", zebra.Labels{"system.group": "labs"})))
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, body string, params httprouter.Params) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", "/api/v1/wipe", strings.NewReader(body)).WithContext(ctx), params)
+
+		return rr
+	}
+
+	assert.Equal(http.StatusForbidden, serve(user, handleWipe(), `{"types": ["Lab"]}`, nil).Code)
+	assert.Equal(http.StatusBadRequest, serve(alice, handleWipe(), `{"types": []}`, nil).Code)
+
+	// Without the gate there is nothing to approve
+	assert.Equal(http.StatusNotFound, serve(bob, handleApprovals(), "", nil).Code)
+
+	api.approvals = newApprovalList(time.Hour)
+
+	rr := serve(alice, handleWipe(), `{"types": ["Lab"]}`, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	pending := new(Approval)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), pending))
+	assert.Equal(2, len(api.Store.QueryType([]string{"Lab"}).Resources["Lab"].Resources))
+
+	rr = serve(bob, handleApprove(), "", httprouter.Params{{Key: "id", Value: pending.ID}})
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Empty(api.Store.QueryType([]string{"Lab"}).Resources)
+
+	api.approvals = nil
+
+	assert.Nil(api.Store.Create(dc.NewLab("lab3", zebra.Labels{"system.group": "labs"})))
+	assert.Equal(http.StatusOK, serve(alice, handleWipe(), `{"types": ["Lab"]}`, nil).Code)
+	assert.Empty(api.Store.QueryType([]string{"Lab"}).Resources)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+)
+
+// includeArchived returns true if the query asks for archived resources too.
+func includeArchived(req *http.Request) bool {
+	include, err := strconv.ParseBool(req.URL.Query().Get("includeArchived"))
+
+	return err == nil && include
+}
+
+// queryTiers returns the resources matching the query request, and the
+// matching archived resources if asked for.
+func (api *ResourceAPI) queryTiers(qr *QueryRequest, archived bool) *zebra.ResourceMap {
+	resources := api.query(qr)
+
+	if !archived || api.archive == nil {
+		return resources
+	}
+
+	_ = applyFunc(api.queryFrom(api.archive, qr), func(r zebra.Resource) error {
+		resources.Add(r, r.GetType())
+
+		return nil
+	})
+
+	return resources
+}
+
+// archiveResource moves the resource from the store into the archive. Unlike
+// a delete, the history of the resource goes on and resources referring to
+// it keep doing so.
+func (api *ResourceAPI) archiveResource(ctx context.Context, res zebra.Resource) error {
+	if err := api.faults.Write(); err != nil {
+		return err
+	}
+
+	if err := api.archive.Create(res); err != nil {
+		return err
+	}
+
+	if err := api.Store.Delete(res); err != nil {
+		_ = api.archive.Delete(res)
+
+		return err
+	}
+
+	return api.recordEvent(ctx, events.Archived, res)
+}
+
+// restoreResource moves the resource from the archive back into the store.
+func (api *ResourceAPI) restoreResource(ctx context.Context, res zebra.Resource) error {
+	if err := api.faults.Write(); err != nil {
+		return err
+	}
+
+	release, err := api.admitQuotas(res)
+	if err != nil {
+		return err
+	}
+
+	err = api.Store.Create(res)
+
+	release()
+
+	if err != nil {
+		return err
+	}
+
+	if err := api.archive.Delete(res); err != nil {
+		_ = api.Store.Delete(res)
+
+		return err
+	}
+
+	return api.recordEvent(ctx, events.Restored, res)
+}
+
+// handleArchive moves a retired resource into the archive, where only
+// queries with includeArchived=true find it. Archiving takes the privilege
+// to delete the resource, and protected resources must be forced.
+func handleArchive() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := archiveContext(res, req)
+		if !ok {
+			return
+		}
+
+		resource := findResource(api.Store, params.ByName("id"))
+		if resource == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionDelete, resource.GetType(), resource.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(api.factory)
+		resMap.Add(resource, resource.GetType())
+
+		if !api.guardProtected(res, req, http.MethodDelete, resMap) {
+			return
+		}
+
+		if err := api.archiveResource(ctx, resource); err != nil {
+			log.Error(err, "resource could not be archived", "resource", resource.GetID())
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "resource.archive", resource.GetID(), resource.GetType())
+		log.Info("resource archived", "resource", resource.GetID())
+
+		writeJSON(ctx, res, resource)
+	}
+}
+
+// handleRestore moves an archived resource back into the store. Restoring
+// takes the privilege to create the resource.
+func handleRestore() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := archiveContext(res, req)
+		if !ok {
+			return
+		}
+
+		id := params.ByName("id")
+
+		resource := findResource(api.archive, id)
+		if resource == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionCreate, resource.GetType(), resource.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		// A resource with the same ID was created since it was archived
+		if findResource(api.Store, id) != nil {
+			res.WriteHeader(http.StatusConflict)
+
+			return
+		}
+
+		if err := api.restoreResource(ctx, resource); errors.Is(err, ErrQuotaExceeded) {
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		} else if err != nil {
+			log.Error(err, "resource could not be restored", "resource", id)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "resource.restore", id, resource.GetType())
+		log.Info("resource restored", "resource", id)
+
+		writeJSON(ctx, res, resource)
+	}
+}
+
+func archiveContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+	if !ok || api.archive == nil {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+
+		return nil, nil, false
+	}
+
+	return api, claims, true
+}
@@ -0,0 +1,94 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	old := dc.NewLab("old", zebra.Labels{"system.group": "labs", "site": "sjc"})
+	core := dc.NewLab("core", zebra.Labels{"system.group": "labs", ProtectedLabel: ProtectedValue})
+
+	for _, lab := range []*dc.Lab{old, core} {
+		assert.Nil(api.create(context.Background(), lab))
+	}
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, method, url, body, id string) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		if claims != nil {
+			ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		}
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx)
+		h(rr, req, httprouter.Params{{Key: "id", Value: id}})
+
+		return rr.Code
+	}
+
+	assert.Equal(http.StatusUnauthorized, serve(nil, handleArchive(), "POST", "/", "", old.ID))
+	assert.Equal(http.StatusForbidden, serve(user, handleArchive(), "POST", "/", "", old.ID))
+	assert.Equal(http.StatusNotFound, serve(admin, handleArchive(), "POST", "/", "", "nope"))
+	assert.Equal(http.StatusConflict, serve(admin, handleArchive(), "POST", "/", "", core.ID))
+	assert.Equal(http.StatusOK, serve(admin, handleArchive(), "POST", "/", "", old.ID))
+	assert.Nil(findResource(api.Store, old.ID))
+
+	// Archived resources are only found when asked for
+	query := `{"labels": [{"key": "site", "op": "==", "values": ["sjc"]}]}`
+	count := func(url string) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		rr := httptest.NewRecorder()
+		handleQueryV2()(rr, httptest.NewRequest("GET", url, strings.NewReader(query)).WithContext(ctx), nil)
+		assert.Equal(http.StatusOK, rr.Code)
+
+		return strings.Count(rr.Body.String(), old.ID)
+	}
+
+	assert.Equal(0, count("/api/v2/resources"))
+	assert.Equal(1, count("/api/v2/resources?includeArchived=true"))
+
+	// The history goes on and the archive survives restarts
+	_, err := api.History.Get(old.ID, 0)
+	assert.Nil(err)
+
+	restarted := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(restarted.Initialize(root))
+	assert.NotNil(findResource(restarted.archive, old.ID))
+
+	assert.Equal(http.StatusNotFound, serve(admin, handleRestore(), "POST", "/", "", core.ID))
+	assert.Equal(http.StatusOK, serve(admin, handleRestore(), "POST", "/", "", old.ID))
+	assert.NotNil(findResource(api.Store, old.ID))
+	assert.Nil(findResource(api.archive, old.ID))
+	assert.Equal(http.StatusNotFound, serve(admin, handleRestore(), "POST", "/", "", old.ID))
+
+	changes, _, err := api.Events.Since(0, 0)
+	assert.Nil(err)
+
+	types := []string{}
+	for _, e := range changes {
+		types = append(types, e.Type)
+	}
+
+	assert.Equal([]string{events.Created, events.Created, events.Archived, events.Restored}, types)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/attachment"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/scheduler"
+)
+
+// DefaultContentType is the type of attachments uploaded without one.
+const DefaultContentType = "application/octet-stream"
+
+// AttachmentConfig limits the attachments of the server configuration: the
+// largest file in bytes and the number of files per resource.
+type AttachmentConfig struct {
+	MaxSize  int64 `json:"maxSize,omitempty"`
+	MaxCount int   `json:"maxCount,omitempty"`
+}
+
+// attachmentTask returns a task that removes the attachments of resources
+// which are neither in the store nor in the archive, and the attachments
+// older than the "maxAge" argument, if given, such as 90d.
+func attachmentTask(api *ResourceAPI, args map[string]string) (scheduler.Task, error) {
+	var maxAge time.Duration
+
+	if args["maxAge"] != "" {
+		d, err := parseWithin(args["maxAge"])
+		if err != nil || d <= 0 {
+			return nil, ErrJobArg
+		}
+
+		maxAge = d
+	}
+
+	return func(ctx context.Context) (string, error) {
+		return pruneAttachments(api, maxAge, time.Now())
+	}, nil
+}
+
+func pruneAttachments(api *ResourceAPI, maxAge time.Duration, now time.Time) (string, error) {
+	if api.attachments == nil {
+		return "attachments disabled", nil
+	}
+
+	before := time.Time{}
+	if maxAge != 0 {
+		before = now.Add(-maxAge)
+	}
+
+	pruned, err := api.attachments.Prune(before, func(resID string) bool {
+		return findResource(api.Store, resID) != nil || (api.archive != nil && findResource(api.archive, resID) != nil)
+	})
+
+	return fmt.Sprintf("removed %d attachments", pruned), err
+}
+
+// attachmentContext returns the resource of the request if the user is
+// allowed the action on it, it writes the error response otherwise.
+func attachmentContext(res http.ResponseWriter, req *http.Request, params httprouter.Params,
+	action auth.Action,
+) (*ResourceAPI, zebra.Resource, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	resource := findResource(api.Store, params.ByName("id"))
+	if api.attachments == nil || resource == nil {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, false
+	}
+
+	if !claims.Allows(action, resource.GetType(), resource.GetLabels()) {
+		res.WriteHeader(http.StatusForbidden)
+
+		return nil, nil, false
+	}
+
+	return api, resource, true
+}
+
+// handleAttach attaches the request body to the resource. The file name is
+// given by the name query parameter or the Content-Disposition header, the
+// type by the Content-Type header. Attaching takes the privilege to update
+// the resource.
+func handleAttach() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, resource, ok := attachmentContext(res, req, params, auth.ActionUpdate)
+		if !ok {
+			return
+		}
+
+		a := attachment.Attachment{
+			Digest:      "",
+			Name:        attachmentName(req),
+			ContentType: req.Header.Get("Content-Type"),
+			Size:        0,
+			Created:     time.Now(),
+			CreatedBy:   actor(ctx),
+		}
+
+		if a.ContentType == "" {
+			a.ContentType = DefaultContentType
+		}
+
+		a, err := api.attachments.Add(resource.GetID(), a, req.Body)
+
+		switch {
+		case errors.Is(err, attachment.ErrName):
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		case errors.Is(err, attachment.ErrTooLarge):
+			http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+
+			return
+		case errors.Is(err, attachment.ErrTooMany):
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		case err != nil:
+			log.Error(err, "attachment could not be stored", "resource", resource.GetID())
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "attachment.add", resource.GetID(), a.Name+" "+a.Digest)
+		log.Info("attachment added", "resource", resource.GetID(), "name", a.Name, "size", a.Size)
+
+		writeJSONCode(ctx, res, http.StatusCreated, a)
+	}
+}
+
+func attachmentName(req *http.Request) string {
+	if name := req.URL.Query().Get("name"); name != "" {
+		return name
+	}
+
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+
+	return params["filename"]
+}
+
+// handleAttachments lists the attachments of the resource, oldest first.
+func handleAttachments() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, resource, ok := attachmentContext(res, req, params, auth.ActionRead)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.attachments.List(resource.GetID()))
+	}
+}
+
+// handleAttachment downloads an attachment of the resource by its digest.
+func handleAttachment() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, resource, ok := attachmentContext(res, req, params, auth.ActionRead)
+		if !ok {
+			return
+		}
+
+		a, f, err := api.attachments.Open(resource.GetID(), params.ByName("digest"))
+		if errors.Is(err, attachment.ErrNotFound) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		} else if err != nil {
+			logr.FromContextOrDiscard(req.Context()).Error(err, "attachment could not be read",
+				"resource", resource.GetID(), "digest", params.ByName("digest"))
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		defer f.Close()
+
+		res.Header().Set("Content-Type", a.ContentType)
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		res.Header().Set("ETag", `"`+a.Digest+`"`)
+
+		http.ServeContent(res, req, a.Name, a.Created, f)
+	}
+}
+
+// handleDeleteAttachment removes an attachment of the resource, which takes
+// the privilege to update the resource.
+func handleDeleteAttachment() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, resource, ok := attachmentContext(res, req, params, auth.ActionUpdate)
+		if !ok {
+			return
+		}
+
+		digest := params.ByName("digest")
+
+		err := api.attachments.Delete(resource.GetID(), digest)
+		if errors.Is(err, attachment.ErrNotFound) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		} else if err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "attachment could not be deleted",
+				"resource", resource.GetID(), "digest", digest)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "attachment.delete", resource.GetID(), digest)
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,163 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/attachment"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachments(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	serve := func(h httprouter.Handle, claims *auth.Claims, method string, url string, body string,
+		params httprouter.Params,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "text/plain")
+		rr := httptest.NewRecorder()
+
+		h(rr, req, params)
+
+		return rr
+	}
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(server))
+
+	byID := httprouter.Params{{Key: "id", Value: server.ID}}
+
+	// Without an attachment store there are no attachments
+	assert.Equal(http.StatusNotFound, serve(handleAttachments(), admin, "GET", "/", "", byID).Code)
+
+	api.attachments = attachment.NewStore(path.Join(t.TempDir(), "attachments"), 32, 2)
+	assert.Nil(api.attachments.Initialize())
+
+	rr := serve(handleAttach(), admin, "POST", "/?name=running.cfg", "hostname server", byID)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	added := attachment.Attachment{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &added))
+	assert.Equal("running.cfg", added.Name)
+	assert.Equal("text/plain", added.ContentType)
+	assert.Equal("admin@zebra", added.CreatedBy)
+
+	entries := api.Audit.Entries()
+	assert.Equal("attachment.add", entries[len(entries)-1].Action)
+
+	// Read only users can list and download, but not attach
+	assert.Equal(http.StatusForbidden, serve(handleAttach(), user, "POST", "/?name=x", "x", byID).Code)
+
+	rr = serve(handleAttachments(), user, "GET", "/", "", byID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	list := []attachment.Attachment{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Equal(1, len(list))
+
+	byDigest := append(byID, httprouter.Param{Key: "digest", Value: added.Digest})
+
+	rr = serve(handleAttachment(), user, "GET", "/", "", byDigest)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("hostname server", rr.Body.String())
+	assert.Equal("text/plain", rr.Header().Get("Content-Type"))
+	assert.Equal(`attachment; filename=running.cfg`, rr.Header().Get("Content-Disposition"))
+
+	// The name may come from the Content-Disposition header
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+	req := httptest.NewRequest("POST", "/", strings.NewReader("photo")).WithContext(ctx)
+	req.Header.Set("Content-Disposition", `attachment; filename="rack 12.jpg"`)
+
+	rr = httptest.NewRecorder()
+	handleAttach()(rr, req, byID)
+	assert.Equal(http.StatusCreated, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &added))
+	assert.Equal("rack 12.jpg", added.Name)
+	assert.Equal(DefaultContentType, added.ContentType)
+
+	// Limits
+	assert.Equal(http.StatusConflict, serve(handleAttach(), admin, "POST", "/?name=x", "x", byID).Code)
+	assert.Equal(http.StatusRequestEntityTooLarge,
+		serve(handleAttach(), admin, "POST", "/?name=x", strings.Repeat("x", 33), byID).Code)
+	assert.Equal(http.StatusBadRequest, serve(handleAttach(), admin, "POST", "/", "x", byID).Code)
+
+	// Deleting
+	assert.Equal(http.StatusForbidden, serve(handleDeleteAttachment(), user, "DELETE", "/", "", byDigest).Code)
+	assert.Equal(http.StatusOK, serve(handleDeleteAttachment(), admin, "DELETE", "/", "", byDigest).Code)
+	assert.Equal(http.StatusNotFound, serve(handleDeleteAttachment(), admin, "DELETE", "/", "", byDigest).Code)
+	assert.Equal(http.StatusNotFound, serve(handleAttachment(), admin, "GET", "/", "", byDigest).Code)
+
+	assert.Equal(http.StatusNotFound,
+		serve(handleAttachments(), admin, "GET", "/", "", httprouter.Params{{Key: "id", Value: "nope"}}).Code)
+
+	rr = httptest.NewRecorder()
+	handleAttachments()(rr, httptest.NewRequest("GET", "/", nil), byID)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func TestAttachmentRetention(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	result, err := pruneAttachments(api, 0, time.Now())
+	assert.Nil(err)
+	assert.Equal("attachments disabled", result)
+
+	api.attachments = attachment.NewStore(t.TempDir(), 0, 0)
+	assert.Nil(api.attachments.Initialize())
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(server))
+
+	now := time.Now()
+
+	for _, resID := range []string{server.ID, "deleted"} {
+		_, err := api.attachments.Add(resID, attachment.Attachment{
+			Digest: "", Name: "a.cfg", ContentType: "text/plain", Size: 0, Created: now.Add(-time.Hour), CreatedBy: "",
+		}, strings.NewReader(resID))
+		assert.Nil(err)
+	}
+
+	result, err = pruneAttachments(api, 0, now)
+	assert.Nil(err)
+	assert.Equal("removed 1 attachments", result)
+	assert.Equal(1, len(api.attachments.List(server.ID)))
+
+	result, err = pruneAttachments(api, time.Minute, now)
+	assert.Nil(err)
+	assert.Equal("removed 1 attachments", result)
+
+	_, err = attachmentTask(api, map[string]string{"maxAge": "90d"})
+	assert.Nil(err)
+
+	_, err = attachmentTask(api, map[string]string{"maxAge": "soon"})
+	assert.ErrorIs(err, ErrJobArg)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/spf13/cobra"
+)
+
+// DefaultCheckpointEvery is the number of audit entries between signed
+// checkpoints if not configured.
+const DefaultCheckpointEvery = 100
+
+var ErrAuditConfig = errors.New("invalid audit configuration")
+
+// AuditConfig signs the chain of the audit log with the rsa key at
+// SigningKey, such as the key of the server, every CheckpointEvery entries.
+// The audit log is chained by hashes even without a signing key. Unchained
+// is the number of entries at the start of a log recorded before it was
+// chained, the only entries verification accepts without a hash.
+type AuditConfig struct {
+	SigningKey      string `json:"signingKey,omitempty"`
+	CheckpointEvery int    `json:"checkpointEvery,omitempty"`
+	Unchained       int    `json:"unchained,omitempty"`
+}
+
+// newAuditKey returns the key that signs the audit log and the number of
+// entries between checkpoints, nil if the audit log is not signed.
+func newAuditKey(cfg *AuditConfig) (*auth.RsaIdentity, int, error) {
+	if cfg.Unchained < 0 {
+		return nil, 0, ErrAuditConfig
+	}
+
+	if cfg.SigningKey == "" {
+		return nil, 0, nil
+	}
+
+	every := DefaultCheckpointEvery
+
+	if cfg.CheckpointEvery < 0 {
+		return nil, 0, ErrAuditConfig
+	} else if cfg.CheckpointEvery > 0 {
+		every = cfg.CheckpointEvery
+	}
+
+	key, err := auth.Load(cfg.SigningKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return key, every, nil
+}
+
+// verifyFunc returns the function that checks checkpoints signed by the key,
+// nil if there is no key.
+func verifyFunc(key *auth.RsaIdentity) audit.VerifyFunc {
+	if key == nil {
+		return nil
+	}
+
+	return func(msg []byte, sig []byte) error {
+		return key.Verify(msg, sig, nil)
+	}
+}
+
+// AuditVerification is the result of verifying the audit log.
+type AuditVerification struct {
+	Valid  bool         `json:"valid"`
+	Error  string       `json:"error,omitempty"`
+	Report audit.Report `json:"report"`
+}
+
+func verifyAudit(entries []audit.Entry, key *auth.RsaIdentity, unchained int) (AuditVerification, error) {
+	report, err := audit.Verify(entries, verifyFunc(key), unchained)
+	verification := AuditVerification{Valid: err == nil, Error: "", Report: report}
+
+	if err != nil {
+		verification.Error = err.Error()
+	}
+
+	return verification, err
+}
+
+// handleVerifyAudit checks the chain and the signed checkpoints of the audit
+// log, admins only. A broken chain is reported, it is not an error of the
+// request. The log is signed before it is checked and again after the check
+// is audited, so that it ends with a checkpoint and copies of it can be
+// verified offline.
+func handleVerifyAudit() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		log := logr.FromContextOrDiscard(ctx)
+
+		if err := api.Audit.Checkpoint(); err != nil {
+			log.Error(err, "audit checkpoint failed")
+		}
+
+		verification, _ := verifyAudit(api.Audit.Entries(), api.auditKey, api.auditLegacy)
+
+		detail := "valid"
+		if !verification.Valid {
+			detail = verification.Error
+		}
+
+		api.recordAudit(ctx, "audit.verify", "", detail)
+
+		if err := api.Audit.Checkpoint(); err != nil {
+			log.Error(err, "audit checkpoint failed")
+		}
+
+		writeJSON(ctx, res, verification)
+	}
+}
+
+// NewVerifyAuditCmd returns the command that verifies an audit log file
+// offline, such as a copy kept as compliance evidence.
+func NewVerifyAuditCmd() *cobra.Command {
+	verifyCmd := new(cobra.Command)
+
+	verifyCmd.Use = "verify-audit"
+	verifyCmd.Short = "verify the chain and signatures of an audit log"
+	verifyCmd.RunE = verifyAuditLog
+	verifyCmd.SilenceUsage = true
+
+	verifyCmd.Flags().StringP("log", "l", cwd("zebra-store/audit.log"),
+		"audit log file (default: $PWD/zebra-store/audit.log)")
+	verifyCmd.Flags().StringP("key", "k", "", "rsa key that signed the audit log, public or private")
+	verifyCmd.Flags().IntP("unchained", "u", 0, "number of entries recorded before the audit log was chained")
+
+	return verifyCmd
+}
+
+func verifyAuditLog(cmd *cobra.Command, args []string) error {
+	entries, err := audit.ReadFile(cmd.Flag("log").Value.String())
+	if err != nil {
+		return err
+	}
+
+	var key *auth.RsaIdentity
+
+	if keyFile := cmd.Flag("key").Value.String(); keyFile != "" {
+		if key, err = auth.Load(keyFile); err != nil {
+			return err
+		}
+	}
+
+	unchained, err := cmd.Flags().GetInt("unchained")
+	if err != nil {
+		return err
+	}
+
+	verification, verifyErr := verifyAudit(entries, key, unchained)
+
+	data, err := json.MarshalIndent(verification, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), string(data))
+
+	return verifyErr
+}
@@ -0,0 +1,142 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditKey(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_new_audit_key"
+	keyFile := path.Join(root, "audit.key")
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+	assert.Nil(os.MkdirAll(root, 0o755))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+	assert.Nil(key.Save(keyFile))
+
+	signer, _, err := newAuditKey(&AuditConfig{SigningKey: "", CheckpointEvery: 0, Unchained: 0})
+	assert.Nil(err)
+	assert.Nil(signer)
+
+	_, _, err = newAuditKey(&AuditConfig{SigningKey: keyFile, CheckpointEvery: -1, Unchained: 0})
+	assert.ErrorIs(err, ErrAuditConfig)
+
+	_, _, err = newAuditKey(&AuditConfig{SigningKey: keyFile, CheckpointEvery: 0, Unchained: -1})
+	assert.ErrorIs(err, ErrAuditConfig)
+
+	_, _, err = newAuditKey(&AuditConfig{SigningKey: path.Join(root, "missing.key"), CheckpointEvery: 0, Unchained: 0})
+	assert.NotNil(err)
+
+	signer, every, err := newAuditKey(&AuditConfig{SigningKey: keyFile, CheckpointEvery: 0, Unchained: 0})
+	assert.Nil(err)
+	assert.NotNil(signer)
+	assert.Equal(DefaultCheckpointEvery, every)
+}
+
+func TestVerifyAudit(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_verify_audit"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	api.auditKey = key
+	api.Audit.SetSigner(key, 2)
+
+	serve := func(admin bool) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "admin@zebra", admin))
+		rr := httptest.NewRecorder()
+
+		handleVerifyAudit()(rr, httptest.NewRequest("GET", "/admin/audit/verify", nil).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	assert.Equal(http.StatusForbidden, serve(false).Code)
+
+	for _, id := range []string{"r1", "r2", "r3"} {
+		api.recordSystemAudit("resource.apply", id, "Lab")
+	}
+
+	rr := serve(true)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	verification := &AuditVerification{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), verification))
+	assert.True(verification.Valid)
+	assert.Equal(2, verification.Report.Checkpoints)
+	assert.Equal(0, verification.Report.Unsigned)
+
+	// The verification itself is audited, and signed
+	entries := api.Audit.Entries()
+	assert.Equal("valid", entries[5].Detail)
+	assert.Equal(audit.ActionCheckpoint, entries[len(entries)-1].Action)
+
+	// The command verifies the file with the public key
+	logFile := path.Join(root, "audit.log")
+	pubFile := path.Join(root, "audit.pub")
+	assert.Nil(key.Public().Save(pubFile))
+
+	out := &bytes.Buffer{}
+	cmd := NewVerifyAuditCmd()
+	cmd.SetOut(out)
+	cmd.SetArgs([]string{"--log", logFile, "--key", pubFile})
+	assert.Nil(cmd.Execute())
+	assert.Contains(out.String(), `"valid": true`)
+
+	verifyFile := func(entries []audit.Entry) error {
+		data := []byte{}
+
+		for _, e := range entries {
+			line, err := json.Marshal(e)
+			assert.Nil(err)
+
+			data = append(append(data, line...), '\n')
+		}
+
+		assert.Nil(os.WriteFile(logFile, data, audit.RWRR))
+
+		out.Reset()
+		cmd = NewVerifyAuditCmd()
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+		cmd.SetArgs([]string{"--log", logFile, "--key", pubFile})
+
+		return cmd.Execute()
+	}
+
+	// Tampering with the file is detected
+	entries, err = audit.ReadFile(logFile)
+	assert.Nil(err)
+
+	entries[1].Resource = "r4"
+	assert.ErrorIs(verifyFile(entries), audit.ErrBrokenChain)
+	assert.Contains(out.String(), `"valid": false`)
+
+	// A log cut short ends with entries no checkpoint signs
+	assert.ErrorIs(verifyFile(api.Audit.Entries()[:4]), audit.ErrUnsigned)
+}
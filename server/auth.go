@@ -1,9 +1,10 @@
-package main
+package server
 
 import (
 	"context"
 	"encoding/base64"
 	"net/http"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/project-safari/zebra/auth"
@@ -14,9 +15,11 @@ func authAdapter() web.Adapter {
 	return func(nextHandler http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 			if nextReq := rsaKey(res, req); nextReq != nil {
-				callNext(nextHandler, res, req)
+				callNext(nextHandler, res, withNamespace(impersonate(res, nextReq)))
 			} else if nextReq := jwtClaims(res, req); nextReq != nil {
-				callNext(nextHandler, res, req)
+				callNext(nextHandler, res, withNamespace(impersonate(res, nextReq)))
+			} else if nextReq := serviceToken(res, req); nextReq != nil {
+				callNext(nextHandler, res, withNamespace(impersonate(res, nextReq)))
 			} else {
 				// No auth token so return unautorized status
 				res.WriteHeader(http.StatusUnauthorized)
@@ -50,10 +53,21 @@ func rsaKey(res http.ResponseWriter, req *http.Request) *http.Request {
 		return nil
 	}
 
+	// Locked out accounts are refused before the token is checked
+	if wait := api.logins.lockedOut(userEmail, api.logins.now()); wait > 0 {
+		log.Info("user locked out", "user", userEmail)
+		api.recordAuthFailure("lockedout", userEmail, req, "key token refused")
+		retryAfter(res, wait)
+		res.WriteHeader(http.StatusTooManyRequests)
+
+		return nil
+	}
+
 	// Make sure the user still exists
 	user := findUser(api.Store, userEmail)
 	if user == nil {
 		log.Error(nil, "user not found", "user", userEmail)
+		loginFailed(api, req, "token", userEmail, "unknown user")
 		res.WriteHeader(http.StatusUnauthorized)
 
 		return nil
@@ -62,11 +76,14 @@ func rsaKey(res http.ResponseWriter, req *http.Request) *http.Request {
 	// Verify that token is valid
 	if e := user.Authenticate(userToken); e != nil {
 		log.Error(e, "user token invalid")
+		loginFailed(api, req, "token", user.Email, "invalid key token")
 		res.WriteHeader(http.StatusUnauthorized)
 
 		return nil
 	}
 
+	api.logins.succeed(user.Email)
+
 	// Set the claims into request
 	claims := auth.NewClaims("zebra", user.Name, user.Role, user.Email)
 	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
@@ -104,7 +121,7 @@ func jwtClaims(res http.ResponseWriter, req *http.Request) *http.Request {
 	}
 
 	// Parse the claims
-	jwtClaims, err := auth.FromJWT(jwtCookie.Value, authKey)
+	jwtClaims, err := api.parseJWT(jwtCookie.Value, authKey)
 	if err != nil {
 		log.Error(err, "bad jwt token")
 		res.WriteHeader(http.StatusUnauthorized)
@@ -120,6 +137,14 @@ func jwtClaims(res http.ResponseWriter, req *http.Request) *http.Request {
 		return nil
 	}
 
+	// Make sure the session was not revoked
+	if err := api.sessions.check(jwtClaims, time.Now()); err != nil {
+		log.Error(err, "revoked jwt token", "user", jwtClaims.Email)
+		res.WriteHeader(http.StatusUnauthorized)
+
+		return nil
+	}
+
 	// Make sure the user still exists
 	user := findUser(api.Store, jwtClaims.Email)
 	if user == nil {
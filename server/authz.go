@@ -0,0 +1,346 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+	"gojini.dev/web"
+)
+
+// resourcesRoute is the route of resource mutations in all API versions.
+const resourcesRoute = "/api/:version/resources"
+
+var (
+	ErrSimulateUser  = errors.New("simulating the policy of another user requires admin privileges")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrCheckResource = errors.New("resource to check not found")
+	ErrCredentials   = errors.New("users and service accounts are managed through their own endpoints")
+)
+
+// credentialTypes carry a role and the credentials to act under it. Only
+// admins may write them as plain resources, anyone else could grant a role
+// beyond their own privileges together with a password or token they know.
+var credentialTypes = map[string]bool{"User": true, "ServiceAccount": true}
+
+// Denial is an action on a resource that the role of the user does not allow,
+// or that a policy of the policy engine denies for the reason.
+type Denial struct {
+	ID     string      `json:"id"`
+	Type   string      `json:"type"`
+	Action auth.Action `json:"action"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// PolicyCheck is an action on a resource to simulate. The resource is either
+// described by its type and labels or, if the id is set, read from the store.
+type PolicyCheck struct {
+	Action auth.Action  `json:"action"`
+	ID     string       `json:"id,omitempty"`
+	Type   string       `json:"type,omitempty"`
+	Labels zebra.Labels `json:"labels,omitempty"`
+}
+
+// PolicyDecision is the outcome of a PolicyCheck and the privilege that
+// allowed it.
+type PolicyDecision struct {
+	PolicyCheck
+	Allowed   bool   `json:"allowed"`
+	Privilege string `json:"privilege,omitempty"`
+}
+
+// SimulateRequest asks for the decisions of the policy on the checks. The
+// role of the requesting user is simulated unless another user, admins only,
+// or a role is given.
+type SimulateRequest struct {
+	User   string        `json:"user,omitempty"`
+	Role   *auth.Role    `json:"role,omitempty"`
+	Checks []PolicyCheck `json:"checks"`
+}
+
+type SimulateResponse struct {
+	Role      string           `json:"role"`
+	Decisions []PolicyDecision `json:"decisions"`
+}
+
+// mutationDenials returns the resource mutations in resMap that the claims
+// do not allow. Updates and deletes must be allowed on the stored resource,
+// so that a resource can not be moved in or out of the scope of a privilege
+// by changing its labels.
+func mutationDenials(claims *auth.Claims, s zebra.Store, method string, resMap *zebra.ResourceMap) []Denial {
+	stored := map[string]zebra.Resource{}
+
+	_ = applyFunc(existingResources(s, resMap), func(r zebra.Resource) error {
+		stored[r.GetID()] = r
+
+		return nil
+	})
+
+	return storedDenials(claims, stored, method, resMap)
+}
+
+// storedDenials returns the resource mutations in resMap that the claims do
+// not allow, given the stored versions of the resources by ID.
+func storedDenials(claims *auth.Claims, stored map[string]zebra.Resource, method string,
+	resMap *zebra.ResourceMap,
+) []Denial {
+	denials := []Denial{}
+	deny := func(r zebra.Resource, action auth.Action) {
+		denials = append(denials, Denial{ID: r.GetID(), Type: r.GetType(), Action: action})
+	}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		old, exists := stored[r.GetID()]
+
+		if (credentialTypes[r.GetType()] || exists && credentialTypes[old.GetType()]) && !claims.Write(AdminKey) {
+			denials = append(denials, Denial{
+				ID: r.GetID(), Type: r.GetType(), Action: mutationAction(method, exists), Reason: ErrCredentials.Error(),
+			})
+
+			return nil
+		}
+
+		switch {
+		case method == http.MethodDelete && exists:
+			if !claims.Allows(auth.ActionDelete, old.GetType(), old.GetLabels()) {
+				deny(r, auth.ActionDelete)
+			}
+		case method == http.MethodDelete:
+			if !claims.Allows(auth.ActionDelete, r.GetType(), r.GetLabels()) {
+				deny(r, auth.ActionDelete)
+			}
+		case exists:
+			if !claims.Allows(auth.ActionUpdate, old.GetType(), old.GetLabels()) ||
+				!claims.Allows(auth.ActionUpdate, r.GetType(), r.GetLabels()) {
+				deny(r, auth.ActionUpdate)
+			}
+		default:
+			if !claims.Allows(auth.ActionCreate, r.GetType(), r.GetLabels()) {
+				deny(r, auth.ActionCreate)
+			}
+		}
+
+		return nil
+	})
+
+	return denials
+}
+
+// authzAdapter authorizes resource mutations against the type and label
+// scoped privileges of the user before the handlers run. Requests that can
+// not be read are passed on for the handlers to reject.
+func authzAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			isMutation := req.Method == http.MethodPost || req.Method == http.MethodDelete
+			if !isMutation || !matchRoute(resourcesRoute, req.URL.Path) {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			ctx := req.Context()
+			log := logr.FromContextOrDiscard(ctx)
+			api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+			claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+			if !apiOK || !claimsOK {
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				res.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			// The handler reads the body again
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			resMap := zebra.NewResourceMap(store.DefaultFactory())
+			if resMap.UnmarshalJSON(body) != nil {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			if denials := mutationDenials(claims, api.Store, req.Method, resMap); len(denials) != 0 {
+				log.Info("resource mutation not authorized", "user", claims.Email, "denied", len(denials))
+				writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+				return
+			}
+
+			if api.policy != nil {
+				if denials := api.policy.denials(ctx, claims, api.Store, req.Method, resMap); len(denials) != 0 {
+					log.Info("resource mutation denied by policy", "user", claims.Email, "denied", len(denials))
+					writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+					return
+				}
+			}
+
+			// Changes by service accounts count against their daily quota
+			if sa, ok := ctx.Value(ServiceAccountCtxKey).(*auth.ServiceAccount); ok && !isDryRun(req) {
+				if !api.limits.chargeQuota(sa, resourceCount(resMap), time.Now()) {
+					serviceAccountThrottles.Inc(sa.Namespace(), "quota")
+					log.Info("service account quota exceeded", "account", sa.Principal())
+					res.WriteHeader(http.StatusTooManyRequests)
+
+					return
+				}
+			}
+
+			callNext(nextHandler, res, req)
+		})
+	}
+}
+
+// mutationAction returns the action of a resource mutation.
+func mutationAction(method string, exists bool) auth.Action {
+	switch {
+	case method == http.MethodDelete:
+		return auth.ActionDelete
+	case exists:
+		return auth.ActionUpdate
+	default:
+		return auth.ActionCreate
+	}
+}
+
+func resourceCount(resMap *zebra.ResourceMap) int {
+	count := 0
+	for _, l := range resMap.Resources {
+		count += len(l.Resources)
+	}
+
+	return count
+}
+
+// readableResources returns the resources the user may read. Handlers are
+// only reached through the auth adapter, without claims in the context the
+// resources are returned as they are.
+func readableResources(ctx context.Context, resources *zebra.ResourceMap) *zebra.ResourceMap {
+	claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+	if !ok {
+		return resources
+	}
+
+	readable := zebra.NewResourceMap(resources.GetFactory())
+
+	_ = applyFunc(resources, func(r zebra.Resource) error {
+		if claims.Allows(auth.ActionRead, r.GetType(), r.GetLabels()) {
+			readable.Add(r, r.GetType())
+		}
+
+		return nil
+	})
+
+	return readable
+}
+
+// simulate returns the decisions of the role on the checks.
+func simulate(s zebra.Store, role *auth.Role, checks []PolicyCheck) ([]PolicyDecision, error) {
+	decisions := make([]PolicyDecision, 0, len(checks))
+
+	for _, check := range checks {
+		if _, err := auth.ParseAction(string(check.Action)); err != nil {
+			return nil, err
+		}
+
+		if check.ID != "" {
+			resources := s.QueryUUID([]string{check.ID})
+			found := false
+
+			_ = applyFunc(resources, func(r zebra.Resource) error {
+				check.Type, check.Labels, found = r.GetType(), r.GetLabels(), true
+
+				return nil
+			})
+
+			if !found {
+				return nil, ErrCheckResource
+			}
+		}
+
+		decision := PolicyDecision{PolicyCheck: check, Allowed: false, Privilege: ""}
+
+		if priv := role.Privilege(check.Action, check.Type, check.Labels); priv != nil {
+			decision.Allowed = true
+			decision.Privilege = priv.String()
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+// handleSimulate answers what the policy decides on a list of actions,
+// without performing them.
+func handleSimulate() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		simReq := new(SimulateRequest)
+		if err := readJSON(ctx, req, simReq); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("policy could not be simulated, could not read request")
+
+			return
+		}
+
+		role := claims.Role
+
+		switch {
+		case simReq.Role != nil:
+			role = simReq.Role
+		case simReq.User != "" && simReq.User != claims.Email:
+			if !claims.Write(AdminKey) {
+				http.Error(res, ErrSimulateUser.Error(), http.StatusForbidden)
+
+				return
+			}
+
+			user := findUser(api.Store, simReq.User)
+			if user == nil {
+				http.Error(res, ErrUserNotFound.Error(), http.StatusNotFound)
+
+				return
+			}
+
+			role = user.Role
+		}
+
+		decisions, err := simulate(api.Store, role, simReq.Checks)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		log.Info("policy simulated", "user", claims.Email, "role", role.Name, "checks", len(decisions))
+
+		writeJSON(ctx, res, &SimulateResponse{Role: role.Name, Decisions: decisions})
+	}
+}
@@ -0,0 +1,302 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// networkRole may change labs at site west and only read racks.
+func networkRole(assert *assert.Assertions) *auth.Role {
+	labs, err := auth.NewPriv("^Lab$", true, true, true, true)
+	assert.Nil(err)
+
+	racks, err := auth.NewPriv("^Rack$", false, true, false, false)
+	assert.Nil(err)
+
+	return &auth.Role{
+		Name:       "team-network",
+		Privileges: []*auth.Priv{labs.WithSelector(zebra.Labels{"site": "west"}), racks},
+	}
+}
+
+func siteLab(name, site string) *dc.Lab {
+	return dc.NewLab(name, zebra.Labels{"system.group": "labs", "site": site})
+}
+
+func resMapJSON(assert *assert.Assertions, resources ...zebra.Resource) string {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	for _, r := range resources {
+		resMap.Add(r, r.GetType())
+	}
+
+	body, err := json.Marshal(resMap)
+	assert.Nil(err)
+
+	return string(body)
+}
+
+func TestAuthzAdapter(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "authz_testadapter"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	west := siteLab("west", "west")
+	east := siteLab("east", "east")
+	rack := dc.NewRack("rack", "row1", zebra.Labels{"system.group": "labs", "site": "west"})
+
+	assert.Nil(api.Store.Create(west))
+	assert.Nil(api.Store.Create(east))
+	assert.Nil(api.Store.Create(rack))
+
+	claims := auth.NewClaims("zebra", "net", networkRole(assert), "net@zebra")
+	called := false
+	body := []byte{}
+	handler := authzAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		called = true
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		called = false
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, "/api/v1/resources", strings.NewReader(body)).WithContext(ctx))
+
+		return rr
+	}
+
+	created := resMapJSON(assert, siteLab("new", "west"))
+	assert.Equal(http.StatusOK, serve("POST", created).Code)
+	assert.True(called)
+	assert.Equal(created, string(body))
+
+	assert.Equal(http.StatusOK, serve("DELETE", resMapJSON(assert, west)).Code)
+	assert.True(called)
+
+	rr := serve("POST", resMapJSON(assert, siteLab("new", "east"), rack))
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.False(called)
+
+	denials := []Denial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal(2, len(denials))
+
+	assert.Equal(http.StatusForbidden, serve("DELETE", resMapJSON(assert, east)).Code)
+
+	// Moving a lab out of scope, or into scope, is not allowed
+	moved := siteLab("west", "east")
+	moved.ID = west.ID
+	assert.Equal(http.StatusForbidden, serve("POST", resMapJSON(assert, moved)).Code)
+
+	moved = siteLab("east", "west")
+	moved.ID = east.ID
+	assert.Equal(http.StatusForbidden, serve("POST", resMapJSON(assert, moved)).Code)
+
+	// Unreadable requests are left to the handler
+	serve("POST", "{...}")
+	assert.True(called)
+
+	// Other routes are not checked here
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/diff", nil))
+	assert.True(called)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/resources", strings.NewReader("{}")))
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+
+	testForward(assert, authzAdapter())
+}
+
+func TestAuthzCredentials(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "authz_testcredentials"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	called := false
+	handler := authzAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+
+	serve := func(claims *auth.Claims, method, body string) *httptest.ResponseRecorder {
+		called = false
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, "/api/v1/resources", strings.NewReader(body)).WithContext(ctx))
+
+		return rr
+	}
+
+	admin, err := auth.NewPriv(AdminKey, true, true, true, true)
+	assert.Nil(err)
+
+	// A service account with an admin role and a token of its creator's choosing
+	sa := auth.NewServiceAccount("deploy", "ci", DefaultRole(), "ci-admin@zebra")
+	sa.Role = &auth.Role{Name: "admin", Privileges: []*auth.Priv{admin}}
+	_, _, err = sa.IssueToken(0)
+	assert.Nil(err)
+
+	rr := serve(namespaceAdmin(assert), "POST", resMapJSON(assert, sa))
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.False(called)
+
+	denials := []Denial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal([]Denial{{ID: sa.ID, Type: "ServiceAccount", Action: auth.ActionCreate, Reason: ErrCredentials.Error()}},
+		denials)
+
+	user := auth.NewUser("eve", "eve@zebra", "secret", nil, namespaceLabels("ci"))
+	assert.Equal(http.StatusForbidden, serve(namespaceAdmin(assert), "POST", resMapJSON(assert, user)).Code)
+	assert.Equal(http.StatusForbidden, serve(namespaceAdmin(assert), "DELETE", resMapJSON(assert, sa)).Code)
+
+	// Admins already hold every privilege
+	all, err := auth.NewPriv("", true, true, true, true)
+	assert.Nil(err)
+
+	claims := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+	assert.Equal(http.StatusOK, serve(claims, "POST", resMapJSON(assert, sa, user)).Code)
+	assert.True(called)
+}
+
+func TestReadableResources(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	resources.Add(siteLab("west", "west"), "Lab")
+	resources.Add(siteLab("east", "east"), "Lab")
+	resources.Add(dc.NewRack("rack", "row1", zebra.Labels{"system.group": "labs"}), "Rack")
+
+	assert.Equal(resources, readableResources(context.Background(), resources))
+
+	claims := auth.NewClaims("zebra", "net", networkRole(assert), "net@zebra")
+	readable := readableResources(context.WithValue(context.Background(), ClaimsCtxKey, claims), resources)
+
+	assert.Equal(1, len(readable.Resources["Lab"].Resources))
+	assert.Equal(1, len(readable.Resources["Rack"].Resources))
+}
+
+func TestHandleSimulate(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "authz_testsimulate"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	east := siteLab("east", "east")
+	assert.Nil(api.Store.Create(east))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	netUser := createNewUser("net", "net@zebra", "hash", key.Public())
+	netUser.Role = networkRole(assert)
+	assert.Nil(api.Store.Create(netUser))
+
+	adminPriv, err := auth.NewPriv("", true, true, true, true)
+	assert.Nil(err)
+
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{adminPriv}}, "admin@zebra")
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	simulateAs := func(claims *auth.Claims, body string) (*httptest.ResponseRecorder, *SimulateResponse) {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest("POST", "/api/v1/policy/simulate", strings.NewReader(body)).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handleSimulate()(rr, req, nil)
+
+		resp := new(SimulateResponse)
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), resp))
+		}
+
+		return rr, resp
+	}
+
+	checks := `"checks": [
+		{"action": "update", "type": "Lab", "labels": {"site": "west"}},
+		{"action": "update", "id": "` + east.ID + `"},
+		{"action": "read", "type": "Rack"}
+	]`
+
+	// Own role
+	rr, resp := simulateAs(user, `{`+checks+`}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("user", resp.Role)
+	assert.Equal([]bool{false, false, true}, decisions(resp))
+
+	// Other user, admins only
+	rr, _ = simulateAs(user, `{"user": "net@zebra", `+checks+`}`)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	rr, resp = simulateAs(admin, `{"user": "net@zebra", `+checks+`}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("team-network", resp.Role)
+	assert.Equal([]bool{true, false, true}, decisions(resp))
+	assert.Equal("^Lab$:c,r,u,d:site=west", resp.Decisions[0].Privilege)
+	assert.Equal("Lab", resp.Decisions[1].Type)
+
+	rr, _ = simulateAs(admin, `{"user": "nobody@zebra", `+checks+`}`)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	// Proposed role
+	rr, resp = simulateAs(user, `{"role": {"name": "labs", "privileges": ["Lab:u"]}, `+checks+`}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal([]bool{true, true, false}, decisions(resp))
+
+	rr, _ = simulateAs(user, `{"checks": [{"action": "own", "type": "Lab"}]}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr, _ = simulateAs(user, `{"checks": [{"action": "read", "id": "missing"}]}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr, _ = simulateAs(user, `{...}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handleSimulate()(rr, httptest.NewRequest("POST", "/api/v1/policy/simulate", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func decisions(resp *SimulateResponse) []bool {
+	allowed := make([]bool, 0, len(resp.Decisions))
+	for _, d := range resp.Decisions {
+		allowed = append(allowed, d.Allowed)
+	}
+
+	return allowed
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+)
+
+// MaxBatchQueries is the number of queries a batch may hold.
+const MaxBatchQueries = 50
+
+// SnapshotHeader is the version of the snapshot a batch was answered from.
+const SnapshotHeader = "Zebra-Snapshot-Version"
+
+var ErrBatchSize = fmt.Errorf("a batch holds between 1 and %d queries", MaxBatchQueries)
+
+// BatchError is an invalid query of a batch.
+type BatchError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// handleQueryBatch answers a list of query requests with the list of their
+// results, in the same order. The queries run concurrently against a single
+// snapshot of the store, so the results are consistent with each other.
+func handleQueryBatch() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		batch := []QueryRequest{}
+		if err := readJSON(ctx, req, &batch); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("batch could not be queried, could not read request")
+
+			return
+		}
+
+		if len(batch) == 0 || len(batch) > MaxBatchQueries {
+			http.Error(res, ErrBatchSize.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		for i := range batch {
+			if err := batch[i].Validate(ctx); err != nil {
+				writeJSONCode(ctx, res, http.StatusBadRequest, BatchError{Index: i, Error: err.Error()})
+				log.Info("batch could not be queried, found invalid query", "index", i)
+
+				return
+			}
+		}
+
+		var source querier = api.Store
+
+		if s, ok := api.Store.(snapshotter); ok {
+			snap := s.Snapshot()
+			source = snap
+
+			res.Header().Set(SnapshotHeader, strconv.FormatUint(snap.Version(), 10))
+		}
+
+		results := make([]*zebra.ResourceMap, len(batch))
+		wg := sync.WaitGroup{}
+
+		for i := range batch {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				results[i] = readableResources(ctx, api.queryFrom(source, &batch[i]))
+			}(i)
+		}
+
+		wg.Wait()
+
+		log.Info("successfully queried batch", "queries", len(batch))
+
+		writeJSON(ctx, res, results)
+	}
+}
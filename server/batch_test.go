@@ -0,0 +1,69 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryBatch(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	for i, color := range []string{"red", "red", "blue"} {
+		lab := dc.NewLab(fmt.Sprintf("lab%d", i), zebra.Labels{"system.group": "labs", "color": color})
+		assert.Nil(api.Store.Create(lab))
+	}
+
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	serve := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/query/batch", strings.NewReader(body))
+		handleQueryBatch()(rr, req.WithContext(ctx), nil)
+
+		return rr
+	}
+
+	rr := serve(`[
+		{"labels": [{"key": "color", "op": "==", "values": ["red"]}]},
+		{"types": ["Lab"]},
+		{"labels": [{"key": "color", "op": "==", "values": ["green"]}]}
+	]`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotEmpty(rr.Header().Get(SnapshotHeader))
+
+	results := []map[string][]json.RawMessage{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Equal(3, len(results))
+	assert.Equal(2, len(results[0]["Lab"]))
+	assert.Equal(3, len(results[1]["Lab"]))
+	assert.Equal(0, len(results[2]["Lab"]))
+
+	// An invalid query fails the whole batch and is pointed out
+	rr = serve(`[{"types": ["Lab"]}, {"labels": [{"key": "color", "op": "==", "values": ["red", "blue"]}]}]`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	batchErr := BatchError{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &batchErr))
+	assert.Equal(1, batchErr.Index)
+
+	assert.Equal(http.StatusBadRequest, serve(`[]`).Code)
+	assert.Equal(http.StatusBadRequest, serve(`[`+strings.Repeat(`{},`, MaxBatchQueries)+`{}]`).Code)
+	assert.Equal(http.StatusBadRequest, serve(``).Code)
+
+	rr = httptest.NewRecorder()
+	handleQueryBatch()(rr, httptest.NewRequest("POST", "/api/v1/query/batch", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
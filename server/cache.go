@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra/protoenc"
+)
+
+// CacheControl lets clients and proxies keep read responses, which depend on
+// the user, as long as they revalidate them before use.
+const CacheControl = "private, no-cache"
+
+// etag returns the strong entity tag of a response body.
+func etag(contentType string, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(contentType))
+	sum.Write(body)
+
+	return `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+}
+
+// notModified tells if the client has the response already, by the entity
+// tags of If-None-Match or else by the time of If-Modified-Since.
+func notModified(req *http.Request, tag string, modified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		for _, t := range strings.Split(match, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == tag || t == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// writeCached writes the body of a read response with the headers that let
+// clients revalidate it, or just 304 Not Modified if the conditions of the
+// request show that the client has it already. modified is the time of the
+// last change of the data of the response.
+func writeCached(ctx context.Context, res http.ResponseWriter, req *http.Request, modified time.Time,
+	contentType string, body []byte,
+) {
+	tag := etag(contentType, body)
+
+	res.Header().Set("ETag", tag)
+	res.Header().Set("Cache-Control", CacheControl)
+	res.Header().Add("Vary", "Accept")
+
+	if !modified.IsZero() {
+		res.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(req, tag, modified) {
+		res.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	res.WriteHeader(http.StatusOK)
+
+	if _, err := res.Write(body); err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "error writing response")
+	}
+}
+
+// writeCachedJSON writes data as the JSON, or YAML, body of a read response
+// like writeCached.
+func writeCachedJSON(ctx context.Context, res http.ResponseWriter, req *http.Request, modified time.Time,
+	data interface{},
+) {
+	format, body, err := encodeJSON(ctx, data)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	writeCached(ctx, res, req, modified, format, body)
+}
+
+// writeCachedProtobuf writes the protobuf body of a read response like
+// writeCached.
+func writeCachedProtobuf(ctx context.Context, res http.ResponseWriter, req *http.Request, modified time.Time,
+	data []byte, err error,
+) {
+	if err != nil {
+		writeProtobuf(ctx, res, data, err)
+
+		return
+	}
+
+	writeCached(ctx, res, req, modified, protoenc.MediaType, data)
+}
@@ -0,0 +1,112 @@
+package server //nolint:testpackage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	modified := time.Date(2022, time.June, 1, 12, 0, 0, 500, time.UTC)
+	tag := etag(MediaJSON, []byte(`{}`))
+
+	request := func(method string, header string, value string) *http.Request {
+		req := httptest.NewRequest(method, "/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		return req
+	}
+
+	assert.NotEqual(tag, etag(MediaYAML, []byte(`{}`)))
+	assert.False(notModified(request(http.MethodGet, "", ""), tag, modified))
+	assert.True(notModified(request(http.MethodGet, "If-None-Match", tag), tag, modified))
+	assert.True(notModified(request(http.MethodGet, "If-None-Match", `"other", W/`+tag), tag, modified))
+	assert.True(notModified(request(http.MethodGet, "If-None-Match", "*"), tag, modified))
+	assert.False(notModified(request(http.MethodGet, "If-None-Match", `"other"`), tag, modified))
+	assert.False(notModified(request(http.MethodPost, "If-None-Match", tag), tag, modified))
+
+	since := modified.Format(http.TimeFormat)
+	assert.True(notModified(request(http.MethodGet, "If-Modified-Since", since), tag, modified))
+	assert.True(notModified(request(http.MethodHead, "If-Modified-Since", since), tag, modified))
+	assert.False(notModified(request(http.MethodGet, "If-Modified-Since", since), tag, modified.Add(time.Second)))
+	assert.False(notModified(request(http.MethodGet, "If-Modified-Since", "yesterday"), tag, modified))
+	assert.False(notModified(request(http.MethodGet, "If-Modified-Since", since), tag, time.Time{}))
+}
+
+func TestCachedTypes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := handleTypes()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil)
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, makeTypeRequest(assert))
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(CacheControl, rr.Header().Get("Cache-Control"))
+	assert.NotEmpty(rr.Header().Get("Last-Modified"))
+
+	tag := rr.Header().Get("ETag")
+	assert.NotEmpty(tag)
+
+	req := makeTypeRequest(assert)
+	req.Header.Set("If-None-Match", tag)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(http.StatusNotModified, rr.Code)
+	assert.Empty(rr.Body.String())
+	assert.Equal(tag, rr.Header().Get("ETag"))
+
+	// Other types are another response
+	req = makeTypeRequest(assert, "Server")
+	req.Header.Set("If-None-Match", tag)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotEqual(tag, rr.Header().Get("ETag"))
+
+	req = makeTypeRequest(assert)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(http.StatusNotModified, rr.Code)
+}
+
+func TestCachedLabels(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.Store = makeStore(assert, t.TempDir())
+
+	h := handleLabels()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil)
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, makeLabelRequest(assert, api, "label1"))
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(api.Events.Modified().UTC().Format(http.TimeFormat), rr.Header().Get("Last-Modified"))
+
+	req := makeLabelRequest(assert, api, "label1")
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(http.StatusNotModified, rr.Code)
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/history"
+	"github.com/project-safari/zebra/lease"
+)
+
+// Labels the chargeback report allocates device hours by: the team that
+// owns a lease, its lessee if not set, and the cost center of the lease or
+// else of the leased device.
+const (
+	OwnerLabel      = "owner"
+	CostCenterLabel = "cost-center"
+)
+
+// DefaultChargebackWithin is how far back the chargeback report looks if the
+// request does not say.
+const DefaultChargebackWithin = 90 * day
+
+const unassigned = "unassigned"
+
+// leaseSpan is a period in which a lease was active.
+type leaseSpan struct {
+	lease *lease.Lease
+	start time.Time
+	end   time.Time
+}
+
+// leaseState is a lease at a point in time, nil once deleted.
+type leaseState struct {
+	at    time.Time
+	lease *lease.Lease
+}
+
+// leaseSpans returns the periods in which the leases were active until now,
+// from the versions of the leases in the history and the current leases. A
+// lease is active from its activation until it is deactivated, deleted or
+// expires, whichever comes first.
+func leaseSpans(resources *zebra.ResourceMap, h *history.History, now time.Time) []leaseSpan {
+	current := map[string]*lease.Lease{}
+
+	if list, ok := resources.Resources["Lease"]; ok {
+		for _, r := range list.Resources {
+			if l, ok := r.(*lease.Lease); ok {
+				current[l.ID] = l
+			}
+		}
+	}
+
+	ids := []string{}
+	if h != nil {
+		ids = h.IDs("Lease")
+	}
+
+	for id := range current {
+		if h == nil || len(h.Versions(id)) == 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	spans := []leaseSpan{}
+
+	for _, id := range ids {
+		states := leaseStates(resources.GetFactory(), h, id)
+		if l, ok := current[id]; ok {
+			states = append(states, leaseState{at: now, lease: l})
+		}
+
+		spans = append(spans, statesToSpans(states, now)...)
+	}
+
+	return spans
+}
+
+func leaseStates(factory zebra.ResourceFactory, h *history.History, id string) []leaseState {
+	if h == nil {
+		return nil
+	}
+
+	states := []leaseState{}
+
+	for _, v := range h.Versions(id) {
+		if v.Deleted {
+			states = append(states, leaseState{at: v.Time, lease: nil})
+
+			continue
+		}
+
+		if l, err := decodeLease(factory, v.Data); err == nil {
+			states = append(states, leaseState{at: v.Time, lease: l})
+		}
+	}
+
+	return states
+}
+
+// leaseJSON is the JSON of a lease. A lease holds resources of any type,
+// which it can not decode by itself.
+type leaseJSON struct {
+	zebra.BaseResource
+	Duration       time.Duration `json:"duration"`
+	ActivationTime time.Time     `json:"activationTime"`
+	Extension      time.Duration `json:"extension,omitempty"`
+	Request        []struct {
+		Type      string            `json:"type"`
+		Group     string            `json:"group"`
+		Name      string            `json:"name"`
+		Count     int               `json:"count"`
+		Resources []json.RawMessage `json:"resources,omitempty"`
+	} `json:"request"`
+}
+
+// decodeLease decodes a lease version and the resources it holds.
+func decodeLease(factory zebra.ResourceFactory, data []byte) (*lease.Lease, error) {
+	v := new(leaseJSON)
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+
+	// Old versions may hold fields their type no longer has
+	decoder := zebra.NewDecoder(factory)
+	decoder.AllowUnknownFields = true
+
+	reqs := make([]*lease.ResourceReq, 0, len(v.Request))
+
+	for _, r := range v.Request {
+		req := &lease.ResourceReq{
+			Type: r.Type, Group: r.Group, Name: r.Name, Count: r.Count, Filters: nil, Resources: nil,
+		}
+
+		for _, data := range r.Resources {
+			res, err := decoder.Decode(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := req.Assign(res); err != nil {
+				return nil, err
+			}
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	l := lease.NewLease("", v.Duration, reqs)
+	l.ActivationTime = v.ActivationTime
+	l.Extension = v.Extension
+
+	status := l.Status
+	l.BaseResource = v.BaseResource
+
+	if l.Status == nil {
+		l.Status = status
+	}
+
+	return l, nil
+}
+
+func statesToSpans(states []leaseState, now time.Time) []leaseSpan {
+	spans := []leaseSpan{}
+
+	var open *leaseSpan
+
+	end := func(at time.Time) {
+		if expires := open.start.Add(open.lease.Length()); expires.Before(at) {
+			at = expires
+		}
+
+		open.end = at
+		spans = append(spans, *open)
+		open = nil
+	}
+
+	for _, s := range states {
+		active := s.lease != nil && s.lease.Status.State == zebra.Active && !s.lease.ActivationTime.IsZero()
+
+		if open != nil && (!active || !s.lease.ActivationTime.Equal(open.start)) {
+			end(s.at)
+		}
+
+		switch {
+		case active && open == nil:
+			open = &leaseSpan{lease: s.lease, start: s.lease.ActivationTime, end: time.Time{}}
+		case active:
+			// The resources of the lease may have changed
+			open.lease = s.lease
+		}
+	}
+
+	if open != nil {
+		end(now)
+	}
+
+	return spans
+}
+
+// monthHours is the part of a period within a calendar month.
+type monthHours struct {
+	month string
+	hours float64
+}
+
+// byMonth splits the period at the start of every calendar month, in UTC.
+func byMonth(start, end time.Time) []monthHours {
+	months := []monthHours{}
+
+	for t := start.UTC(); t.Before(end); {
+		next := time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if next.After(end) {
+			next = end
+		}
+
+		months = append(months, monthHours{month: t.Format("2006-01"), hours: next.Sub(t).Hours()})
+		t = next
+	}
+
+	return months
+}
+
+// leaseTeam returns the team that owns the lease.
+func leaseTeam(l *lease.Lease) string {
+	if team := l.GetLabels()[OwnerLabel]; team != "" {
+		return team
+	}
+
+	if owner := l.Owner(); owner != "" {
+		return owner
+	}
+
+	return unowned
+}
+
+// costCenter returns the cost center the device hours of the lease are
+// charged to.
+func costCenter(l *lease.Lease, res zebra.Resource) string {
+	if cc := l.GetLabels()[CostCenterLabel]; cc != "" {
+		return cc
+	}
+
+	if cc := res.GetLabels()[CostCenterLabel]; cc != "" {
+		return cc
+	}
+
+	if holder, ok := res.(zebra.AssetHolder); ok && holder.GetAsset() != nil && holder.GetAsset().CostCenter != "" {
+		return holder.GetAsset().CostCenter
+	}
+
+	return unassigned
+}
+
+// chargeback allocates the hours devices were leased within the window to
+// the teams and cost centers, per month and device type.
+func chargeback(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	type key struct{ month, team, costCenter, typ string }
+
+	from := now.Add(-opts.within)
+	hours := map[key]float64{}
+	leases := map[key]map[string]bool{}
+
+	for _, s := range leaseSpans(resources, opts.history, now) {
+		if opts.readable != nil && !opts.readable(s.lease) {
+			continue
+		}
+
+		start, end := s.start, s.end
+		if start.Before(from) {
+			start = from
+		}
+
+		if !end.After(start) {
+			continue
+		}
+
+		team := leaseTeam(s.lease)
+
+		for _, req := range s.lease.RequestList() {
+			for _, res := range req.Resources {
+				if opts.readable != nil && !opts.readable(res) {
+					continue
+				}
+
+				for _, m := range byMonth(start, end) {
+					k := key{m.month, team, costCenter(s.lease, res), res.GetType()}
+					hours[k] += m.hours
+
+					if leases[k] == nil {
+						leases[k] = map[string]bool{}
+					}
+
+					leases[k][s.lease.ID] = true
+				}
+			}
+		}
+	}
+
+	keys := make([]key, 0, len(hours))
+	for k := range hours {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.month != b.month {
+			return a.month < b.month
+		}
+
+		if a.team != b.team {
+			return a.team < b.team
+		}
+
+		if a.costCenter != b.costCenter {
+			return a.costCenter < b.costCenter
+		}
+
+		return a.typ < b.typ
+	})
+
+	rows := make([][]string, 0, len(keys))
+
+	for _, k := range keys {
+		rows = append(rows, []string{
+			k.month, k.team, k.costCenter, k.typ, strconv.Itoa(len(leases[k])),
+			strconv.FormatFloat(math.Round(hours[k]*10)/10, 'f', 1, 64), //nolint:gomnd
+		})
+	}
+
+	return rows
+}
@@ -0,0 +1,152 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByMonth(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	start := time.Date(2022, 5, 31, 22, 0, 0, 0, time.UTC)
+
+	assert.Equal([]monthHours{{"2022-05", 2}, {"2022-06", 3}}, byMonth(start, start.Add(5*time.Hour)))
+	assert.Equal([]monthHours{{"2022-05", 1}}, byMonth(start, start.Add(time.Hour)))
+	assert.Empty(byMonth(start, start))
+}
+
+func TestStatesToSpans(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	activated := func(at time.Time, dur time.Duration) *lease.Lease {
+		l := lease.NewLease("user@zebra", dur, nil)
+		l.Status.State = zebra.Active
+		l.ActivationTime = at
+
+		return l
+	}
+
+	l := activated(now.Add(-10*time.Hour), 24*time.Hour)
+	inactive := activated(now.Add(-10*time.Hour), 24*time.Hour)
+	inactive.Deactivate()
+
+	// Deactivated, then activated again until it expired
+	again := activated(now.Add(-4*time.Hour), time.Hour)
+
+	spans := statesToSpans([]leaseState{
+		{now.Add(-11 * time.Hour), lease.NewLease("user@zebra", time.Hour, nil)},
+		{now.Add(-10 * time.Hour), l},
+		{now.Add(-9 * time.Hour), l},
+		{now.Add(-8 * time.Hour), inactive},
+		{now.Add(-4 * time.Hour), again},
+	}, now)
+	assert.Equal(2, len(spans))
+	assert.Equal(2*time.Hour, spans[0].end.Sub(spans[0].start))
+	assert.Equal(now.Add(-3*time.Hour), spans[1].end)
+
+	// Deleted while active, or still active
+	spans = statesToSpans([]leaseState{{now.Add(-10 * time.Hour), l}, {now.Add(-5 * time.Hour), nil}}, now)
+	assert.Equal(now.Add(-5*time.Hour), spans[0].end)
+
+	spans = statesToSpans([]leaseState{{now.Add(-10 * time.Hour), l}}, now)
+	assert.Equal(now, spans[0].end)
+}
+
+func TestChargeback(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	ctx := context.Background()
+	now := time.Now()
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs", CostCenterLabel: "cc-lab"})
+	other := dc.NewLab("other", zebra.Labels{"system.group": "labs"})
+
+	leased := func(owner string, res zebra.Resource, activated time.Duration, dur time.Duration) *lease.Lease {
+		req := &lease.ResourceReq{
+			Type: res.GetType(), Group: res.GetLabels()["system.group"], Name: "", Count: 1, Filters: nil, Resources: nil,
+		}
+		assert.Nil(req.Assign(res))
+
+		l := lease.NewLease(owner, dur, []*lease.ResourceReq{req})
+		assert.Nil(l.Activate())
+		l.ActivationTime = now.Add(-activated)
+
+		return l
+	}
+
+	// Expired 6 hours ago, after 4 hours, charged to the team of the lease
+	expired := leased("alice@zebra", server, 10*time.Hour, 4*time.Hour)
+	expired.Labels = expired.Labels.Add(OwnerLabel, "team-a").Add(CostCenterLabel, "cc-1")
+	assert.Nil(api.create(ctx, expired))
+
+	// Released after 3 hours, charged to the lessee and the device cost center
+	released := leased("bob@zebra", lab, 3*time.Hour, 4*time.Hour)
+	assert.Nil(api.create(ctx, released))
+
+	released.Deactivate()
+	assert.Nil(api.create(ctx, released))
+
+	// Deleted after 2 hours, still charged
+	deleted := leased("carol@zebra", other, 2*time.Hour, 4*time.Hour)
+	assert.Nil(api.create(ctx, deleted))
+	_, err := api.History.RecordDelete(deleted, "carol@zebra")
+	assert.Nil(err)
+	assert.Nil(api.Store.Delete(deleted))
+
+	hours := func(opts reportOptions) map[string]float64 {
+		report, err := generateReport("chargeback", api.view(), opts, time.Now())
+		assert.Nil(err)
+
+		sums := map[string]float64{}
+
+		for _, row := range report.Rows {
+			h, err := strconv.ParseFloat(row[5], 64)
+			assert.Nil(err)
+			assert.Equal("1", row[4])
+
+			sums[row[1]+"/"+row[2]+"/"+row[3]] += h
+		}
+
+		return sums
+	}
+
+	opts, err := parseReportOptions(FormatJSON, "")
+	assert.Nil(err)
+
+	opts.history = api.History
+
+	assert.Equal(map[string]float64{
+		"team-a/cc-1/Server":         4,
+		"bob@zebra/cc-lab/Lab":       3,
+		"carol@zebra/unassigned/Lab": 2,
+	}, hours(opts))
+
+	// Only within the window
+	opts.within = time.Hour
+	assert.Equal(map[string]float64{"bob@zebra/cc-lab/Lab": 1, "carol@zebra/unassigned/Lab": 1}, hours(opts))
+
+	// Only the leases the user may read
+	opts.within = 0
+	opts.readable = func(r zebra.Resource) bool { return r.GetLabels()[OwnerLabel] != "team-a" }
+	assert.NotContains(hours(opts), "team-a/cc-1/Server")
+}
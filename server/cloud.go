@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/cloud"
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/project-safari/zebra/secrets"
+)
+
+// DefaultCloudTimeout is the timeout of the API requests of cloud imports.
+const DefaultCloudTimeout = time.Minute
+
+var (
+	ErrCloudConfig  = errors.New("invalid cloud account configuration")
+	ErrCloudAccount = errors.New("unknown cloud account")
+)
+
+// CloudConfig is the cloud accounts whose instances and networks are
+// imported by the cloud-import job.
+type CloudConfig struct {
+	Accounts []CloudAccount `json:"accounts,omitempty"`
+}
+
+// CloudAccount is a cloud account and the namespace its resources are
+// imported into. The secret is the secret access key for AWS, the JSON key
+// of the service account for GCP and the client secret of the app
+// registration for Azure; it is fetched again on every import. Endpoint
+// replaces the API endpoint of the provider.
+type CloudAccount struct {
+	Name         string          `json:"name"`
+	Provider     string          `json:"provider"`
+	Namespace    string          `json:"namespace"`
+	Regions      []string        `json:"regions,omitempty"`
+	AccessKeyID  string          `json:"accessKeyId,omitempty"`
+	Project      string          `json:"project,omitempty"`
+	Subscription string          `json:"subscription,omitempty"`
+	Tenant       string          `json:"tenant,omitempty"`
+	ClientID     string          `json:"clientId,omitempty"`
+	Secret       *secrets.Config `json:"secret,omitempty"`
+	Endpoint     string          `json:"endpoint,omitempty"`
+}
+
+// cloudAccounts are the configured cloud accounts and their secrets.
+type cloudAccounts struct {
+	accounts []CloudAccount
+	secrets  map[string]secrets.Source
+	client   *http.Client
+}
+
+func newCloudAccounts(cfg *CloudConfig) (*cloudAccounts, error) {
+	c := &cloudAccounts{
+		accounts: []CloudAccount{},
+		secrets:  map[string]secrets.Source{},
+		client:   &http.Client{Timeout: DefaultCloudTimeout},
+	}
+
+	for _, account := range cfg.Accounts {
+		if err := validCloudAccount(account); err != nil {
+			return nil, err
+		}
+
+		if _, ok := c.secrets[account.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate account %s", ErrCloudConfig, account.Name)
+		}
+
+		source, err := secrets.New(account.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("cloud account %s: %w", account.Name, err)
+		}
+
+		c.accounts = append(c.accounts, account)
+		c.secrets[account.Name] = source
+	}
+
+	return c, nil
+}
+
+func validCloudAccount(account CloudAccount) error {
+	missing := ""
+
+	switch {
+	case account.Name == "":
+		missing = "name"
+	case account.Namespace == "":
+		missing = "namespace"
+	case account.Secret == nil:
+		missing = "secret"
+	}
+
+	switch account.Provider {
+	case cloud.ProviderAWS:
+		if account.AccessKeyID == "" || len(account.Regions) == 0 {
+			missing = "access key ID or regions"
+		}
+	case cloud.ProviderGCP:
+		if account.Project == "" {
+			missing = "project"
+		}
+	case cloud.ProviderAzure:
+		if account.Subscription == "" || account.Tenant == "" || account.ClientID == "" {
+			missing = "subscription, tenant or client ID"
+		}
+	default:
+		return fmt.Errorf("%w: %s: %s", ErrCloudConfig, account.Name, cloud.ErrProvider)
+	}
+
+	if missing != "" {
+		return fmt.Errorf("%w: %s: no %s", ErrCloudConfig, account.Name, missing)
+	}
+
+	return nil
+}
+
+// find returns the account with the name.
+func (c *cloudAccounts) find(name string) *CloudAccount {
+	for i := range c.accounts {
+		if c.accounts[i].Name == name {
+			return &c.accounts[i]
+		}
+	}
+
+	return nil
+}
+
+// importer returns the importer of the account with its current secret.
+func (c *cloudAccounts) importer(ctx context.Context, account CloudAccount) (cloud.Importer, error) {
+	secret, err := c.secrets[account.Name].Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch account.Provider {
+	case cloud.ProviderAWS:
+		a := cloud.NewAWS(account.AccessKeyID, secret.Value, account.Regions, c.client)
+		a.Endpoint = account.Endpoint
+
+		return a, nil
+	case cloud.ProviderGCP:
+		g := cloud.NewGCP(account.Project, []byte(secret.Value), c.client)
+		if account.Endpoint != "" {
+			g.Endpoint = account.Endpoint
+		}
+
+		return g, nil
+	case cloud.ProviderAzure:
+		a := cloud.NewAzure(account.Subscription, account.Tenant, account.ClientID, secret.Value, c.client)
+		if account.Endpoint != "" {
+			a.Endpoint = account.Endpoint
+		}
+
+		return a, nil
+	}
+
+	return nil, cloud.ErrProvider
+}
+
+// cloudImportTask returns the task that imports the configured cloud
+// accounts, or only the one of the account argument.
+func cloudImportTask(api *ResourceAPI, args map[string]string) (scheduler.Task, error) {
+	if api.cloud == nil || len(api.cloud.accounts) == 0 {
+		return nil, fmt.Errorf("%w: no cloud accounts are configured", ErrJobArg)
+	}
+
+	accounts := api.cloud.accounts
+
+	if name := args["account"]; name != "" {
+		account := api.cloud.find(name)
+		if account == nil {
+			return nil, fmt.Errorf("%w: %s", ErrCloudAccount, name)
+		}
+
+		accounts = []CloudAccount{*account}
+	}
+
+	return func(ctx context.Context) (string, error) {
+		results := make([]string, 0, len(accounts))
+
+		for _, account := range accounts {
+			result, err := api.importCloud(ctx, account)
+			if err != nil {
+				return strings.Join(results, "; "), fmt.Errorf("cloud account %s: %w", account.Name, err)
+			}
+
+			results = append(results, result)
+		}
+
+		return strings.Join(results, "; "), nil
+	}, nil
+}
+
+// importCloud imports the instances and networks of the cloud account and
+// reconciles them with the store.
+func (api *ResourceAPI) importCloud(ctx context.Context, account CloudAccount) (string, error) {
+	importer, err := api.cloud.importer(ctx, account)
+	if err != nil {
+		return "", err
+	}
+
+	inv, err := importer.Import(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return api.reconcileCloud(ctx, account, inv)
+}
+
+// reconcileCloud creates the resources of the inventory that are not in the
+// store, updates those that changed and deletes those of the account that
+// are gone. Resources are matched by their cloud ID, and keep their zebra ID
+// and the labels users added to them.
+func (api *ResourceAPI) reconcileCloud(ctx context.Context, account CloudAccount,
+	inv *cloud.Inventory,
+) (string, error) {
+	existing := map[string]zebra.Resource{}
+
+	_ = applyFunc(api.Store.QueryType([]string{cloud.InstanceType().Name, cloud.NetworkType().Name}),
+		func(r zebra.Resource) error {
+			if labels := r.GetLabels(); labels[cloud.LabelAccount] == account.Name {
+				existing[r.GetType()+"/"+labels[cloud.LabelID]] = r
+			}
+
+			return nil
+		})
+
+	created, updated, deleted := 0, 0, 0
+
+	for _, res := range inv.Resources() {
+		base := cloudBase(res)
+		if base == nil {
+			continue
+		}
+
+		base.Labels.Add("system.group", account.Namespace)
+		base.Labels.Add(cloud.LabelAccount, account.Name)
+
+		key := res.GetType() + "/" + base.Labels[cloud.LabelID]
+		prev, ok := existing[key]
+		delete(existing, key)
+
+		if ok {
+			if !adoptCloudResource(res, base, prev) {
+				continue
+			}
+
+			updated++
+		} else {
+			created++
+		}
+
+		if err := res.Validate(ctx); err != nil {
+			return "", err
+		}
+
+		if err := api.create(ctx, res); err != nil {
+			return "", err
+		}
+	}
+
+	for _, res := range existing {
+		if err := api.delete(ctx, res); err != nil {
+			return "", err
+		}
+
+		deleted++
+	}
+
+	result := fmt.Sprintf("%s: %d created, %d updated, %d deleted", account.Name, created, updated, deleted)
+	api.recordSystemAudit("cloud.import", account.Name, result)
+
+	return result, nil
+}
+
+// cloudBase returns the base of the imported resource.
+func cloudBase(res zebra.Resource) *zebra.BaseResource {
+	switch r := res.(type) {
+	case *cloud.Instance:
+		return &r.BaseResource
+	case *cloud.Network:
+		return &r.BaseResource
+	}
+
+	return nil
+}
+
+// adoptCloudResource gives the imported resource the ID, the status and the
+// labels, other than the cloud ones, of the stored resource it replaces, and
+// returns whether it differs from it.
+func adoptCloudResource(res zebra.Resource, base *zebra.BaseResource, prev zebra.Resource) bool {
+	base.ID = prev.GetID()
+	base.Status = prev.GetStatus()
+
+	for k, v := range prev.GetLabels() {
+		if !strings.HasPrefix(k, "cloud.") && !base.Labels.HasKey(k) {
+			base.Labels.Add(k, v)
+		}
+	}
+
+	before, err := json.Marshal(prev)
+	if err != nil {
+		return true
+	}
+
+	after, err := json.Marshal(res)
+
+	return err != nil || string(before) != string(after)
+}
@@ -0,0 +1,170 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/cloud"
+	"github.com/project-safari/zebra/secrets"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudConfig(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	secret := &secrets.Config{Backend: secrets.BackendStatic, Value: "secret"}
+
+	c, err := newCloudAccounts(&CloudConfig{Accounts: nil})
+	assert.Nil(err)
+	assert.Nil(c.find("aws-lab"))
+
+	for _, account := range []CloudAccount{
+		{Name: "aws-lab", Provider: "oci", Namespace: "lab", Secret: secret},
+		{Name: "aws-lab", Provider: cloud.ProviderAWS, Namespace: "lab", Secret: secret},
+		{Name: "gcp-lab", Provider: cloud.ProviderGCP, Namespace: "", Project: "lab", Secret: secret},
+		{Name: "azure-lab", Provider: cloud.ProviderAzure, Namespace: "lab", Subscription: "s", Secret: secret},
+		{Name: "gcp-lab", Provider: cloud.ProviderGCP, Namespace: "lab", Project: "lab", Secret: nil},
+	} {
+		_, err := newCloudAccounts(&CloudConfig{Accounts: []CloudAccount{account}})
+		assert.ErrorIs(err, ErrCloudConfig, account.Name)
+	}
+
+	gcp := CloudAccount{Name: "gcp-lab", Provider: cloud.ProviderGCP, Namespace: "lab", Project: "lab", Secret: secret}
+	_, err = newCloudAccounts(&CloudConfig{Accounts: []CloudAccount{gcp, gcp}})
+	assert.ErrorIs(err, ErrCloudConfig)
+
+	gcp.Secret = &secrets.Config{Backend: "file"}
+	_, err = newCloudAccounts(&CloudConfig{Accounts: []CloudAccount{gcp}})
+	assert.ErrorIs(err, secrets.ErrBackend)
+}
+
+// fakeEC2 serves the instances of a region, by ID and name, and one VPC.
+type fakeEC2 struct {
+	lock      sync.Mutex
+	instances map[string]string
+}
+
+func (f *fakeEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Query().Get("Action") == "DescribeVpcs" {
+		fmt.Fprint(w, `<DescribeVpcsResponse><vpcSet><item><vpcId>vpc-1</vpcId><cidrBlock>10.0.0.0/16</cidrBlock>`+
+			`</item></vpcSet></DescribeVpcsResponse>`)
+
+		return
+	}
+
+	items := new(strings.Builder)
+	for id, name := range f.instances {
+		fmt.Fprintf(items, `<item><instanceId>%s</instanceId><instanceState><name>running</name></instanceState>`+
+			`<vpcId>vpc-1</vpcId><tagSet><item><key>Name</key><value>%s</value></item></tagSet></item>`, id, name)
+	}
+
+	fmt.Fprintf(w, `<DescribeInstancesResponse><reservationSet><item><instancesSet>%s</instancesSet></item>`+
+		`</reservationSet></DescribeInstancesResponse>`, items)
+}
+
+func (f *fakeEC2) set(instances map[string]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.instances = instances
+}
+
+func cloudInstance(api *ResourceAPI, id string) *cloud.Instance {
+	var found *cloud.Instance
+
+	_ = applyFunc(api.Store.QueryType([]string{"CloudInstance"}), func(r zebra.Resource) error {
+		if i, ok := r.(*cloud.Instance); ok && i.InstanceID == id {
+			found = i
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+func TestCloudImport(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_cloud_import"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	ec2 := &fakeEC2{lock: sync.Mutex{}, instances: map[string]string{"i-1": "web", "i-2": "db"}}
+	srv := httptest.NewServer(ec2)
+
+	defer srv.Close()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	// Without accounts there is nothing to import
+	_, err := newTask(api, JobConfig{Name: "cloud", Schedule: "@hourly", Task: TaskCloudImport, Args: nil})
+	assert.ErrorIs(err, ErrJobArg)
+
+	api.cloud, err = newCloudAccounts(&CloudConfig{Accounts: []CloudAccount{{
+		Name:        "aws-lab",
+		Provider:    cloud.ProviderAWS,
+		Namespace:   "lab-cloud",
+		Regions:     []string{"us-west-2"},
+		AccessKeyID: "AKID",
+		Secret:      &secrets.Config{Backend: secrets.BackendStatic, Value: "secret"},
+		Endpoint:    srv.URL,
+	}}})
+	assert.Nil(err)
+
+	_, err = newTask(api, JobConfig{
+		Name: "cloud", Schedule: "@hourly", Task: TaskCloudImport, Args: map[string]string{"account": "gcp-lab"},
+	})
+	assert.ErrorIs(err, ErrCloudAccount)
+
+	task, err := newTask(api, JobConfig{Name: "cloud", Schedule: "@hourly", Task: TaskCloudImport, Args: nil})
+	assert.Nil(err)
+
+	ctx := context.Background()
+
+	result, err := task(ctx)
+	assert.Nil(err)
+	assert.Equal("aws-lab: 3 created, 0 updated, 0 deleted", result)
+
+	web := cloudInstance(api, "i-1")
+	assert.NotNil(web)
+	assert.Equal("lab-cloud", web.Labels["system.group"])
+	assert.Equal("aws-lab", web.Labels[cloud.LabelAccount])
+	assert.Equal("vpc-1", web.Labels[cloud.LabelNetwork])
+
+	// Unchanged resources are left alone
+	result, err = task(ctx)
+	assert.Nil(err)
+	assert.Equal("aws-lab: 0 created, 0 updated, 0 deleted", result)
+
+	// Renamed instances keep their ID and the labels users gave them
+	web.Labels.Add("owner", "alice")
+	assert.Nil(api.Store.Create(web))
+	ec2.set(map[string]string{"i-1": "www"})
+
+	result, err = task(ctx)
+	assert.Nil(err)
+	assert.Equal("aws-lab: 0 created, 1 updated, 1 deleted", result)
+
+	www := cloudInstance(api, "i-1")
+	assert.Equal(web.ID, www.ID)
+	assert.Equal("www", www.Name)
+	assert.Equal("alice", www.Labels["owner"])
+	assert.Nil(cloudInstance(api, "i-2"))
+
+	assert.Equal(3, len(api.Audit.QueryActorType("system")))
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// MaxCommentLength is the longest comment in bytes, comments are notes, not
+// documents.
+const MaxCommentLength = 4096
+
+// Kinds of activity of a resource.
+const (
+	ActivityComment = "comment"
+	ActivityAudit   = "audit"
+)
+
+var (
+	ErrComment         = errors.New("comments need a text of up to 4096 bytes")
+	ErrCommentParent   = errors.New("comments reply to comments of the same resource")
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrCommentAuthor   = errors.New("only the author of a comment or users who may update the resource may remove it")
+	ErrActivityLimit   = errors.New("limit must be a positive number")
+)
+
+// Comment is a note of a user on a resource, such as "PSU replaced" or
+// "flaky NIC". Replies have the ID of the comment they answer as parent.
+type Comment struct {
+	ID       string    `json:"id"`
+	Resource string    `json:"resource"`
+	Parent   string    `json:"parent,omitempty"`
+	Author   string    `json:"author"`
+	Text     string    `json:"text"`
+	Created  time.Time `json:"created"`
+}
+
+// CommentRequest adds a comment, a reply if the parent is set.
+type CommentRequest struct {
+	Text   string `json:"text"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// Activity is an entry of the activity feed of a resource: a comment, or an
+// audit entry of an action taken on the resource.
+type Activity struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Comment *Comment  `json:"comment,omitempty"`
+}
+
+// commentList keeps the comments by resource, oldest first. If a path is
+// given, the comments are written to that file on every change.
+type commentList struct {
+	lock     sync.RWMutex
+	path     string
+	Comments map[string][]Comment `json:"comments"`
+}
+
+func newCommentList(path string) *commentList {
+	return &commentList{lock: sync.RWMutex{}, path: path, Comments: map[string][]Comment{}}
+}
+
+// load reads the comments from the backing file, if any.
+func (l *commentList) load() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, l)
+}
+
+func (l *commentList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.path)
+}
+
+// list returns the comments of the resource, oldest first.
+func (l *commentList) list(resID string) []Comment {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return append([]Comment{}, l.Comments[resID]...)
+}
+
+// add adds the comment to the comments of its resource.
+func (l *commentList) add(c Comment) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if c.Parent != "" && indexOfComment(l.Comments[c.Resource], c.Parent) < 0 {
+		return ErrCommentParent
+	}
+
+	prev := l.Comments[c.Resource]
+	l.Comments[c.Resource] = append(append([]Comment{}, prev...), c)
+
+	if err := l.save(); err != nil {
+		l.Comments[c.Resource] = prev
+
+		return err
+	}
+
+	return nil
+}
+
+// remove removes the comment and the replies to it, if allowed returns true
+// for the comment. It returns the removed comment.
+func (l *commentList) remove(resID string, id string, allowed func(Comment) bool) (Comment, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	prev := l.Comments[resID]
+
+	i := indexOfComment(prev, id)
+	if i < 0 {
+		return Comment{}, ErrCommentNotFound
+	}
+
+	if !allowed(prev[i]) {
+		return prev[i], ErrCommentAuthor
+	}
+
+	removed := map[string]bool{id: true}
+	kept := []Comment{}
+
+	// Replies come after the comments they answer
+	for _, c := range prev {
+		if removed[c.ID] || removed[c.Parent] {
+			removed[c.ID] = true
+
+			continue
+		}
+
+		kept = append(kept, c)
+	}
+
+	if len(kept) == 0 {
+		delete(l.Comments, resID)
+	} else {
+		l.Comments[resID] = kept
+	}
+
+	if err := l.save(); err != nil {
+		l.Comments[resID] = prev
+
+		return Comment{}, err
+	}
+
+	return prev[i], nil
+}
+
+func indexOfComment(comments []Comment, id string) int {
+	for i, c := range comments {
+		if c.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// commentContext returns the resource of the request if the user is allowed
+// to read it, it writes the error response otherwise.
+func commentContext(res http.ResponseWriter, req *http.Request, params httprouter.Params,
+) (*ResourceAPI, *auth.Claims, zebra.Resource, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, nil, false
+	}
+
+	resource := findResource(api.Store, params.ByName("id"))
+	if resource == nil || !claims.Allows(auth.ActionRead, resource.GetType(), resource.GetLabels()) {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, nil, false
+	}
+
+	return api, claims, resource, true
+}
+
+// handleComments lists the comments of the resource, oldest first.
+func handleComments() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, _, resource, ok := commentContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.comments.list(resource.GetID()))
+	}
+}
+
+// handleComment adds a comment to the resource. Users who may read a
+// resource may comment on it.
+func handleComment() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, _, resource, ok := commentContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		cr := new(CommentRequest)
+		if err := readJSON(ctx, req, cr); err != nil || strings.TrimSpace(cr.Text) == "" ||
+			len(cr.Text) > MaxCommentLength {
+			http.Error(res, ErrComment.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		c := Comment{
+			ID:       uuid.New().String(),
+			Resource: resource.GetID(),
+			Parent:   cr.Parent,
+			Author:   actor(ctx),
+			Text:     cr.Text,
+			Created:  time.Now(),
+		}
+
+		if err := api.comments.add(c); errors.Is(err, ErrCommentParent) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		} else if err != nil {
+			log.Error(err, "comment could not be added", "resource", resource.GetID())
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "comment.add", resource.GetID(), c.ID)
+
+		writeJSONCode(ctx, res, http.StatusCreated, c)
+	}
+}
+
+// handleDeleteComment removes a comment of the resource and the replies to
+// it. Authors remove their own comments, other comments take the privilege
+// to update the resource.
+func handleDeleteComment() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, resource, ok := commentContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		c, err := api.comments.remove(resource.GetID(), params.ByName("comment"), func(c Comment) bool {
+			return c.Author == claims.Email ||
+				claims.Allows(auth.ActionUpdate, resource.GetType(), resource.GetLabels())
+		})
+
+		switch {
+		case errors.Is(err, ErrCommentNotFound):
+			res.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, ErrCommentAuthor):
+			http.Error(res, err.Error(), http.StatusForbidden)
+		case err != nil:
+			log.Error(err, "comment could not be removed", "resource", resource.GetID())
+			res.WriteHeader(http.StatusInternalServerError)
+		default:
+			api.recordAudit(ctx, "comment.delete", resource.GetID(), c.ID)
+			writeJSON(ctx, res, c)
+		}
+	}
+}
+
+// handleActivity returns the activity feed of the resource, newest first:
+// its comments and the audit entries of the actions taken on it. Comments
+// are not repeated by the audit entries that record them. The limit query
+// parameter returns the newest entries only.
+func handleActivity() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, _, resource, ok := commentContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		limit := 0
+
+		if l := req.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				http.Error(res, ErrActivityLimit.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			limit = n
+		}
+
+		feed := []Activity{}
+
+		for _, c := range api.comments.list(resource.GetID()) {
+			c := c
+			feed = append(feed, Activity{
+				Time: c.Created, Kind: ActivityComment, Actor: c.Author, Action: "", Detail: "", Comment: &c,
+			})
+		}
+
+		for _, e := range api.Audit.Query(resource.GetID()) {
+			if e.Action == "comment.add" {
+				continue
+			}
+
+			feed = append(feed, Activity{
+				Time: e.Time, Kind: ActivityAudit, Actor: e.Actor, Action: e.Action, Detail: e.Detail, Comment: nil,
+			})
+		}
+
+		sort.SliceStable(feed, func(i, j int) bool { return feed[i].Time.After(feed[j].Time) })
+
+		if limit > 0 && len(feed) > limit {
+			feed = feed[:limit]
+		}
+
+		writeJSON(req.Context(), res, feed)
+	}
+}
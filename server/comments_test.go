@@ -0,0 +1,113 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComments(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+	other := makeClaims(assert, "other@zebra", false)
+
+	serve := func(h httprouter.Handle, claims *auth.Claims, method string, url string, body string,
+		params httprouter.Params,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		h(rr, req, params)
+
+		return rr
+	}
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(server))
+
+	byID := httprouter.Params{{Key: "id", Value: server.ID}}
+	missing := httprouter.Params{{Key: "id", Value: "missing"}}
+
+	assert.Equal(http.StatusNotFound, serve(handleComment(), user, "POST", "/", `{"text":"x"}`, missing).Code)
+	assert.Equal(http.StatusBadRequest, serve(handleComment(), user, "POST", "/", `{"text":" "}`, byID).Code)
+	assert.Equal(http.StatusBadRequest,
+		serve(handleComment(), user, "POST", "/", `{"text":"x","parent":"missing"}`, byID).Code)
+
+	// Users who may read a resource comment on it
+	rr := serve(handleComment(), user, "POST", "/", `{"text":"flaky NIC"}`, byID)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	first := Comment{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &first))
+	assert.Equal("user@zebra", first.Author)
+	assert.Equal(server.ID, first.Resource)
+
+	rr = serve(handleComment(), other, "POST", "/", `{"text":"PSU replaced","parent":"`+first.ID+`"}`, byID)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	reply := Comment{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &reply))
+	assert.Equal(first.ID, reply.Parent)
+
+	api.recordAudit(context.WithValue(context.Background(), ClaimsCtxKey, admin), "transfer", server.ID, "")
+
+	rr = serve(handleComments(), user, "GET", "/", "", byID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	comments := []Comment{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &comments))
+	assert.Equal([]string{first.ID, reply.ID}, []string{comments[0].ID, comments[1].ID})
+
+	// The feed has the comments and the other actions, newest first
+	rr = serve(handleActivity(), user, "GET", "/", "", byID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	feed := []Activity{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Equal(3, len(feed))
+	assert.Equal(ActivityAudit, feed[0].Kind)
+	assert.Equal("transfer", feed[0].Action)
+	assert.Equal(reply.ID, feed[1].Comment.ID)
+
+	rr = serve(handleActivity(), user, "GET", "/?limit=1", "", byID)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Equal(1, len(feed))
+
+	assert.Equal(http.StatusBadRequest, serve(handleActivity(), user, "GET", "/?limit=x", "", byID).Code)
+
+	// Other users' comments are removed by those who may update the resource
+	byComment := httprouter.Params{byID[0], {Key: "comment", Value: first.ID}}
+
+	assert.Equal(http.StatusForbidden, serve(handleDeleteComment(), other, "DELETE", "/", "", byComment).Code)
+	assert.Equal(http.StatusOK, serve(handleDeleteComment(), admin, "DELETE", "/", "", byComment).Code)
+	assert.Equal(http.StatusNotFound, serve(handleDeleteComment(), admin, "DELETE", "/", "", byComment).Code)
+
+	// Replies go with the comment, and the comments are kept on disk
+	loaded := newCommentList(root + "/comments.json")
+	assert.Nil(loaded.load())
+	assert.Empty(loaded.list(server.ID))
+
+	entries := api.Audit.Query(server.ID)
+	assert.Equal("comment.delete", entries[len(entries)-1].Action)
+}
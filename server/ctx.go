@@ -0,0 +1,14 @@
+package server
+
+type CtxKey string
+
+const (
+	ResourcesCtxKey      = CtxKey("resources")
+	AuthCtxKey           = CtxKey("authKey")
+	ClaimsCtxKey         = CtxKey("claims")
+	ReloaderCtxKey       = CtxKey("reloader")
+	ServiceAccountCtxKey = CtxKey("serviceAccount")
+	ImpersonatorCtxKey   = CtxKey("impersonator")
+	RequestInfoCtxKey    = CtxKey("requestInfo")
+	FormatCtxKey         = CtxKey("format")
+)
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+	"gojini.dev/web"
+)
+
+// The demo server listens on DemoAddress by default, and serves all requests
+// as the admin user DemoUser.
+const (
+	DemoAddress = "tcp://127.0.0.1:8000"
+	DemoUser    = "demo"
+	DemoEmail   = "demo@zebra.local"
+)
+
+// Shape of the sample lab of the demo server.
+const (
+	demoRacks           = 4
+	demoServersPerRack  = 4
+	demoVMsPerESX       = 2
+	demoKeyLength       = 32
+	demoVLANPoolSize    = 100
+	demoSubnetMaskBits  = 24
+	demoSubnetAddrBytes = 32
+)
+
+// runDemo runs a server with an in-memory store seeded with a sample lab,
+// which does not authenticate requests. The store is gone once the server
+// stops.
+func runDemo(cmd *cobra.Command) error {
+	root, err := os.MkdirTemp("", "zebra-demo")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(root)
+
+	appCtx := setupLogger(nil)
+	log := logr.FromContextOrDiscard(appCtx)
+
+	srv, err := New(appCtx, Options{
+		Root: root, Format: store.FormatMemory, LazyIndex: false, AuthKey: "", NoAuth: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	resources := demoLab()
+	for _, res := range resources {
+		if err := srv.API.Store.Create(res); err != nil {
+			return err
+		}
+	}
+
+	serverCfg := new(web.Config)
+	serverCfg.Address = cmd.Flag("demo-address").Value.String()
+
+	fmt.Fprintf(cmd.OutOrStdout(), "zebra demo at %s with %d sample resources, all requests are served as %s\n",
+		serverCfg.Address, len(resources), DemoEmail)
+
+	log.Info("starting zebra demo server")
+
+	return web.NewServer(serverCfg, srv).Start(appCtx)
+}
+
+// newDemoUser returns the admin user that the requests to a server without
+// authentication are served as.
+func newDemoUser() (*auth.User, error) {
+	key, err := auth.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := auth.NewPriv("", true, true, true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	user := auth.NewUser(DemoUser, DemoEmail, "", key.Public(), zebra.Labels{})
+	user.Role = &auth.Role{Name: "admin", Privileges: []*auth.Priv{priv}}
+	user.Status = nil
+
+	return user, nil
+}
+
+// newDemoAuthKey returns a random auth key, the tokens of a server without
+// authentication need not outlive it.
+func newDemoAuthKey() (string, error) {
+	key := make([]byte, demoKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// noAuthAdapter serves all requests as the demo user, whatever credentials
+// they carry.
+func noAuthAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			log := logr.FromContextOrDiscard(ctx)
+
+			api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+			if !ok {
+				log.Error(nil, "resources not in context")
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			user := findUser(api.Store, DemoEmail)
+			if user == nil {
+				log.Error(nil, "demo user not found", "user", DemoEmail)
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			claims := auth.NewClaims("zebra", user.Name, user.Role, user.Email)
+			ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+
+			callNext(nextHandler, res, withNamespace(impersonate(res, req.Clone(ctx))))
+		})
+	}
+}
+
+// demoLab returns a sample lab of a datacenter, parents before their
+// children: racks of a switch and servers, an ESX host on the first server of
+// each rack running VMs managed by a vCenter, and the VLAN and IP address
+// pools of the lab.
+func demoLab() []zebra.Resource {
+	resources := []zebra.Resource{}
+
+	datacenter := dc.NewDatacenter("170 West Tasman Drive, San Jose", "sjc-dc1", demoLabels(""))
+	lab := dc.NewLab("sjc-lab1", demoLabels(datacenter.ID))
+	vcenter := compute.NewVCenter("sjc-vc1", net.IPv4(10, 1, 0, 10), demoLabels(lab.ID)) //nolint:gomnd
+	vcenter.Credentials.ID = vcenter.ID
+
+	resources = append(resources, datacenter, lab, vcenter,
+		network.NewVlanPool(100, 100+demoVLANPoolSize-1, demoLabels(lab.ID)), //nolint:gomnd
+		network.NewIPAddressPool([]net.IPNet{{
+			IP:   net.IPv4(10, 1, 0, 0), //nolint:gomnd
+			Mask: net.CIDRMask(demoSubnetMaskBits, demoSubnetAddrBytes),
+		}}, demoLabels(lab.ID)))
+
+	for r := 0; r < demoRacks; r++ {
+		rack := dc.NewRack(fmt.Sprintf("sjc-lab1-r%02d", r), fmt.Sprintf("A%02d", r), demoLabels(lab.ID))
+		sw := network.NewSwitch([]string{fmt.Sprintf("FDO%08d", r), "Nexus 93180YC", rack.Name + "-sw"},
+			48, net.IPv4(10, 1, byte(r+1), 1), demoLabels(rack.ID)) //nolint:gomnd
+		sw.Credentials.ID = sw.ID
+
+		resources = append(resources, rack, sw)
+
+		for s := 0; s < demoServersPerRack; s++ {
+			srv := compute.NewServer(
+				[]string{fmt.Sprintf("WZP%04d%04d", r, s), "UCS C220 M6", fmt.Sprintf("%s-s%02d", rack.Name, s)},
+				net.IPv4(10, 1, byte(r+1), byte(s+10)), demoLabels(rack.ID)) //nolint:gomnd
+			srv.Credentials.ID = srv.ID
+
+			resources = append(resources, srv)
+
+			if s == 0 {
+				resources = append(resources, demoESX(srv, vcenter, r)...)
+			}
+		}
+	}
+
+	return resources
+}
+
+// demoESX returns an ESX host on the server and the VMs it runs.
+func demoESX(srv *compute.Server, vcenter *compute.VCenter, r int) []zebra.Resource {
+	esx := compute.NewESX(srv.Name+"-esx", srv.ID, net.IPv4(10, 1, byte(r+1), 100), //nolint:gomnd
+		demoLabels(srv.ID))
+	esx.Credentials.ID = esx.ID
+
+	resources := []zebra.Resource{esx}
+
+	for v := 0; v < demoVMsPerESX; v++ {
+		vm := compute.NewVM([]string{fmt.Sprintf("%s-vm%d", esx.Name, v), esx.ID, vcenter.ID},
+			net.IPv4(10, 1, byte(r+1), byte(v+101)), demoLabels(esx.ID)) //nolint:gomnd
+		vm.Credentials.ID = vm.ID
+
+		resources = append(resources, vm)
+	}
+
+	return resources
+}
+
+func demoLabels(parent string) zebra.Labels {
+	labels := zebra.Labels{
+		"system.group": "demo",
+		"environment":  "staging",
+		"team":         "platform",
+	}
+
+	if parent != "" {
+		labels[zebra.ParentLabel] = parent
+	}
+
+	return labels
+}
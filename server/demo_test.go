@@ -0,0 +1,76 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemoLab(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := demoLab()
+	seen := map[string]bool{}
+	counts := map[string]int{}
+
+	for _, res := range resources {
+		assert.Nil(res.Validate(context.Background()), res.GetType())
+
+		// Parents come before their children
+		if parent := res.GetLabels()[zebra.ParentLabel]; parent != "" {
+			assert.True(seen[parent], res.GetType())
+		}
+
+		seen[res.GetID()] = true
+		counts[res.GetType()]++
+	}
+
+	assert.Equal(1, counts["Lab"])
+	assert.Equal(demoRacks, counts["Rack"])
+	assert.Equal(demoRacks*demoServersPerRack, counts["Server"])
+	assert.Equal(demoRacks*demoVMsPerESX, counts["VM"])
+}
+
+func TestNoAuth(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_noauth"
+
+	defer func() { os.RemoveAll(root) }()
+
+	srv, err := New(context.Background(), Options{
+		Root: root, Format: store.FormatMemory, LazyIndex: false, AuthKey: "", NoAuth: true,
+	})
+	assert.Nil(err)
+	assert.NotNil(findUser(srv.API.Store, DemoEmail))
+
+	for _, res := range demoLab() {
+		assert.Nil(srv.API.Store.Create(res))
+	}
+
+	// Requests without credentials are served as the demo user
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/resources", strings.NewReader(`{"types":["Server"]}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), resMap))
+	assert.Len(resMap.Resources["Server"].Resources, demoRacks*demoServersPerRack)
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/resources", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	assert.NotEqual(http.StatusUnauthorized, rr.Code)
+	assert.NotEqual(http.StatusForbidden, rr.Code)
+}
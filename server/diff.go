@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/history"
+	"github.com/project-safari/zebra/store"
+)
+
+// Diff states of a resource in a payload compared to the store.
+const (
+	DiffCreate    = "create"
+	DiffUpdate    = "update"
+	DiffUnchanged = "unchanged"
+)
+
+// ResourceDiff is the structured difference of a single resource.
+type ResourceDiff struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	State   string         `json:"state"`
+	Changes []zebra.Change `json:"changes"`
+}
+
+// diffResource returns the diff between the stored version of the resource
+// (if any) and the given resource.
+func diffResource(stored zebra.Resource, res zebra.Resource) (*ResourceDiff, error) {
+	changes, err := zebra.Diff(stored, res)
+	if err != nil {
+		return nil, err
+	}
+
+	state := DiffUpdate
+
+	switch {
+	case stored == nil:
+		state = DiffCreate
+	case len(changes) == 0:
+		state = DiffUnchanged
+	}
+
+	return &ResourceDiff{
+		ID:      res.GetID(),
+		Type:    res.GetType(),
+		State:   state,
+		Changes: changes,
+	}, nil
+}
+
+// handleDiff returns the diff between each resource in the request payload
+// and its currently stored state.
+func handleDiff() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+
+		if err := readJSON(ctx, req, resMap); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("diff failed, could not read request")
+
+			return
+		}
+
+		diffs := []*ResourceDiff{}
+
+		for _, l := range resMap.Resources {
+			for _, r := range l.Resources {
+				// The diff shows the stored resource, the user must be able to read it
+				stored := findResource(api.Store, r.GetID())
+				if stored != nil && !claims.Allows(auth.ActionRead, stored.GetType(), stored.GetLabels()) {
+					res.WriteHeader(http.StatusForbidden)
+					log.Info("diff failed, stored resource not readable", "resource", r.GetID())
+
+					return
+				}
+
+				d, err := diffResource(stored, r)
+				if err != nil {
+					res.WriteHeader(http.StatusInternalServerError)
+
+					return
+				}
+
+				diffs = append(diffs, d)
+			}
+		}
+
+		writeJSON(ctx, res, diffs)
+	}
+}
+
+// readableHistory reports whether the user may read the history of the
+// resource, which is decided on the stored resource or, once deleted, on its
+// last version.
+func readableHistory(api *ResourceAPI, claims *auth.Claims, resID string) bool {
+	res := findResource(api.Store, resID)
+	if res == nil {
+		last, err := api.History.Get(resID, 0)
+		if err != nil {
+			return false
+		}
+
+		if res, err = last.Resource(api.factory); err != nil || res == nil {
+			return false
+		}
+	}
+
+	return claims.Allows(auth.ActionRead, res.GetType(), res.GetLabels())
+}
+
+// handleHistory returns the stored versions of a resource. Resources the
+// user may not read are not found.
+func handleHistory() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		versions := api.History.Versions(params.ByName("id"))
+		if len(versions) == 0 || !readableHistory(api, claims, params.ByName("id")) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(ctx, res, versions)
+	}
+}
+
+// handleVersionDiff returns the diff between two stored versions of a
+// resource, given by the from and to query parameters. If to is not given,
+// the latest version is used. If from is not given, the version before to is
+// used. Resources the user may not read are not found.
+func handleVersionDiff() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resID := params.ByName("id")
+		from, fromErr := versionParam(req, "from")
+		to, toErr := versionParam(req, "to")
+
+		if fromErr != nil || toErr != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if !readableHistory(api, claims, resID) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		toVersion, err := api.History.Get(resID, to)
+		if err != nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if from == 0 {
+			from = toVersion.Version - 1
+		}
+
+		fromRes, err := versionResource(api.History, resID, from)
+		if err != nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		toRes, err := toVersion.Resource(store.DefaultFactory())
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		d, err := diffResource(fromRes, toRes)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, d)
+	}
+}
+
+// versionResource returns the resource at the given version, version 0 has
+// no resource and returns nil.
+func versionResource(h *history.History, resID string, version int) (zebra.Resource, error) {
+	if version <= 0 {
+		return nil, nil
+	}
+
+	v, err := h.Get(resID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Resource(store.DefaultFactory())
+}
+
+func versionParam(req *http.Request, name string) (int, error) {
+	val := req.URL.Query().Get(name)
+	if val == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(val)
+}
@@ -0,0 +1,220 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/history"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func diffRequest(assert *assert.Assertions, api *ResourceAPI, method string, url string,
+	body []byte, h httprouter.Handle, params httprouter.Params,
+) *httptest.ResponseRecorder {
+	return diffRequestAs(assert, api, makeClaims(assert, "admin@zebra", true), method, url, body, h, params)
+}
+
+func diffRequestAs(assert *assert.Assertions, api *ResourceAPI, claims *auth.Claims, method string, url string,
+	body []byte, h httprouter.Handle, params httprouter.Params,
+) *httptest.ResponseRecorder {
+	ctx := context.Background()
+	if claims != nil {
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	}
+
+	if api != nil {
+		ctx = context.WithValue(ctx, ResourcesCtxKey, api)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	assert.Nil(err)
+
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+
+	rr := httptest.NewRecorder()
+	h(rr, req, params)
+
+	return rr
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_diff"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.create(context.Background(), lab))
+
+	other := makeOwnedLab("bob@zebra")
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	resMap.Add(lab, lab.GetType())
+	resMap.Add(other, other.GetType())
+
+	body, err := json.Marshal(resMap)
+	assert.Nil(err)
+
+	rr := diffRequest(assert, api, "POST", "/api/v1/diff", body, handleDiff(), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	diffs := []*ResourceDiff{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &diffs))
+	assert.Equal(2, len(diffs))
+
+	for _, d := range diffs {
+		if d.ID == lab.ID {
+			assert.Equal(DiffUnchanged, d.State)
+		} else {
+			assert.Equal(DiffCreate, d.State)
+			assert.NotEmpty(d.Changes)
+		}
+	}
+
+	// Change the payload, but not the stored lab
+	changed := makeOwnedLab("bob@zebra")
+	changed.ID = lab.ID
+	changed.Status.CreatedTime = lab.Status.CreatedTime
+	resMap = zebra.NewResourceMap(store.DefaultFactory())
+	resMap.Add(changed, changed.GetType())
+
+	body, err = json.Marshal(resMap)
+	assert.Nil(err)
+
+	rr = diffRequest(assert, api, "POST", "/api/v1/diff", body, handleDiff(), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &diffs))
+	assert.Equal(1, len(diffs))
+	assert.Equal(DiffUpdate, diffs[0].State)
+	assert.Equal([]zebra.Change{{Path: "status.usedBy", Old: "alice@zebra", New: "bob@zebra"}}, diffs[0].Changes)
+
+	rr = diffRequest(assert, api, "POST", "/api/v1/diff", []byte("{bad"), handleDiff(), nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = diffRequest(assert, nil, "POST", "/api/v1/diff", body, handleDiff(), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func TestVersionDiff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_version_diff"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.create(context.Background(), lab))
+
+	lab.Status.UsedBy = "bob@zebra"
+	assert.Nil(api.create(context.Background(), lab))
+
+	params := httprouter.Params{{Key: "id", Value: lab.ID}}
+	url := "/api/v1/resources/" + lab.ID
+
+	rr := diffRequest(assert, api, "GET", url+"/history", nil, handleHistory(), params)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	versions := []history.Version{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &versions))
+	assert.Equal(2, len(versions))
+
+	// Latest against the previous version
+	rr = diffRequest(assert, api, "GET", url+"/diff", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	d := new(ResourceDiff)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), d))
+	assert.Equal(DiffUpdate, d.State)
+	assert.Equal([]zebra.Change{{Path: "status.usedBy", Old: "alice@zebra", New: "bob@zebra"}}, d.Changes)
+
+	// First version is a creation
+	rr = diffRequest(assert, api, "GET", url+"/diff?to=1", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), d))
+	assert.Equal(DiffCreate, d.State)
+
+	rr = diffRequest(assert, api, "GET", url+"/diff?from=2&to=2", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), d))
+	assert.Equal(DiffUnchanged, d.State)
+
+	rr = diffRequest(assert, api, "GET", url+"/diff?from=x", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = diffRequest(assert, api, "GET", url+"/diff?from=7", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	params = httprouter.Params{{Key: "id", Value: "doesnotexist"}}
+	rr = diffRequest(assert, api, "GET", "/api/v1/resources/doesnotexist/diff", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = diffRequest(assert, api, "GET", "/api/v1/resources/doesnotexist/history", nil, handleHistory(), params)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = diffRequest(assert, nil, "GET", url+"/history", nil, handleHistory(), params)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+
+	rr = diffRequest(assert, nil, "GET", url+"/diff", nil, handleVersionDiff(), params)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func TestScopedHistory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	west, east := siteLab("west", "west"), siteLab("east", "east")
+	assert.Nil(api.create(context.Background(), west))
+	assert.Nil(api.create(context.Background(), east))
+
+	network := auth.NewClaims("zebra", "net@zebra", networkRole(assert), "net@zebra")
+	request := func(h httprouter.Handle, res zebra.Resource, suffix string, body []byte) int {
+		params := httprouter.Params{{Key: "id", Value: res.GetID()}}
+		rr := diffRequestAs(assert, api, network, "GET", "/api/v1/resources/"+res.GetID()+suffix, body, h, params)
+
+		return rr.Code
+	}
+
+	assert.Equal(http.StatusOK, request(handleHistory(), west, "/history", nil))
+	assert.Equal(http.StatusOK, request(handleVersionDiff(), west, "/diff", nil))
+
+	// The lab at site east is hidden from the network team, in any version
+	assert.Equal(http.StatusNotFound, request(handleHistory(), east, "/history", nil))
+	assert.Equal(http.StatusNotFound, request(handleVersionDiff(), east, "/diff", nil))
+
+	assert.Nil(api.delete(context.Background(), east))
+	assert.Equal(http.StatusNotFound, request(handleHistory(), east, "/history", nil))
+
+	// Diffs against the store do not show unreadable resources either
+	assert.Nil(api.create(context.Background(), east))
+	assert.Equal(http.StatusForbidden, request(handleDiff(), east, "", []byte(resMapJSON(assert, east))))
+	assert.Equal(http.StatusOK, request(handleDiff(), west, "", []byte(resMapJSON(assert, west))))
+
+	// Without claims there is no user to authorize
+	rr := diffRequestAs(assert, api, nil, "GET", "/api/v1/resources/"+west.ID+"/history", nil, handleHistory(),
+		httprouter.Params{{Key: "id", Value: west.ID}})
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,520 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/ldap"
+)
+
+// Sources of directory entries.
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+)
+
+// Defaults of the LDAP directory.
+const (
+	DefaultLDAPUserFilter  = "(objectClass=person)"
+	DefaultLDAPGroupFilter = "(|(objectClass=groupOfNames)(objectClass=groupOfUniqueNames))"
+	DefaultLDAPCacheTTL    = 5 * time.Minute
+	DefaultLDAPTimeout     = 10 * time.Second
+)
+
+var (
+	ErrDirectoryConfig = errors.New("invalid directory configuration")
+	ErrNoDirectory     = errors.New("no directory source is enabled")
+)
+
+// DirectoryConfig sets where users and groups are looked up: the users of
+// the store, whose groups are their roles, unless disabled, and the users and
+// groups of an LDAP server, if one is set.
+type DirectoryConfig struct {
+	DisableLocal bool        `json:"disableLocal,omitempty"`
+	LDAP         *LDAPConfig `json:"ldap,omitempty"`
+}
+
+// LDAPConfig is the LDAP server of the directory. Users and groups are
+// searched under the base DN, the members of groups are the DNs of users.
+// Lookups are cached for the cache TTL, such as "5m".
+type LDAPConfig struct {
+	URL                string `json:"url"`
+	BindDN             string `json:"bindDN,omitempty"`
+	BindPassword       string `json:"bindPassword,omitempty"`
+	BaseDN             string `json:"baseDN"`
+	UserFilter         string `json:"userFilter,omitempty"`
+	GroupFilter        string `json:"groupFilter,omitempty"`
+	NameAttribute      string `json:"nameAttribute,omitempty"`
+	EmailAttribute     string `json:"emailAttribute,omitempty"`
+	MemberAttribute    string `json:"memberAttribute,omitempty"`
+	CacheTTL           string `json:"cacheTTL,omitempty"`
+	Timeout            string `json:"timeout,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// DirectoryUser is a user of the directory, with the names of their groups.
+type DirectoryUser struct {
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Groups  []string `json:"groups"`
+	Sources []string `json:"sources"`
+}
+
+// DirectoryGroup is a group of the directory, with the emails of its
+// members.
+type DirectoryGroup struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	Sources []string `json:"sources"`
+}
+
+// directoryEntries are the users and groups of a directory source.
+type directoryEntries struct {
+	users  []DirectoryUser
+	groups []DirectoryGroup
+}
+
+type directory struct {
+	local bool
+	ldap  *ldapDirectory
+}
+
+func defaultDirectory() *directory {
+	return &directory{local: true, ldap: nil}
+}
+
+func newDirectory(cfg *DirectoryConfig) (*directory, error) {
+	d := &directory{local: !cfg.DisableLocal, ldap: nil}
+
+	if cfg.LDAP != nil {
+		l, err := newLDAPDirectory(cfg.LDAP)
+		if err != nil {
+			return nil, err
+		}
+
+		d.ldap = l
+	}
+
+	if !d.local && d.ldap == nil {
+		return nil, ErrNoDirectory
+	}
+
+	return d, nil
+}
+
+// lookup returns the users and groups of all sources of the directory.
+// Users of several sources are merged by email, groups by name.
+func (d *directory) lookup(store zebra.Store) ([]DirectoryUser, []DirectoryGroup, error) {
+	sources := []directoryEntries{}
+
+	if d.local {
+		sources = append(sources, localEntries(store))
+	}
+
+	if d.ldap != nil {
+		entries, err := d.ldap.entries(time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sources = append(sources, entries)
+	}
+
+	users, groups := mergeEntries(sources)
+
+	return users, groups, nil
+}
+
+// localEntries returns the users of the store, whose groups are their roles.
+func localEntries(store zebra.Store) directoryEntries {
+	entries := directoryEntries{users: []DirectoryUser{}, groups: []DirectoryGroup{}}
+	members := map[string][]string{}
+
+	_ = applyFunc(store.QueryType([]string{"User"}), func(r zebra.Resource) error {
+		u, ok := r.(*auth.User)
+		if !ok {
+			return nil
+		}
+
+		groups := []string{}
+		if u.Role != nil && u.Role.Name != "" {
+			groups = append(groups, u.Role.Name)
+			members[u.Role.Name] = append(members[u.Role.Name], u.Email)
+		}
+
+		entries.users = append(entries.users,
+			DirectoryUser{Name: u.Name, Email: u.Email, Groups: groups, Sources: []string{SourceLocal}})
+
+		return nil
+	})
+
+	for name, emails := range members {
+		entries.groups = append(entries.groups,
+			DirectoryGroup{Name: name, Members: emails, Sources: []string{SourceLocal}})
+	}
+
+	return entries
+}
+
+// mergeEntries merges the entries of the sources, sorted by email and name.
+func mergeEntries(sources []directoryEntries) ([]DirectoryUser, []DirectoryGroup) {
+	users := map[string]*DirectoryUser{}
+	groups := map[string]*DirectoryGroup{}
+
+	for _, s := range sources {
+		for _, u := range s.users {
+			key := strings.ToLower(u.Email)
+			if merged, ok := users[key]; ok {
+				merged.Groups = union(merged.Groups, u.Groups)
+				merged.Sources = union(merged.Sources, u.Sources)
+
+				continue
+			}
+
+			u := u
+			u.Groups = union(nil, u.Groups)
+			users[key] = &u
+		}
+
+		for _, g := range s.groups {
+			if merged, ok := groups[g.Name]; ok {
+				merged.Members = union(merged.Members, g.Members)
+				merged.Sources = union(merged.Sources, g.Sources)
+
+				continue
+			}
+
+			g := g
+			g.Members = union(nil, g.Members)
+			groups[g.Name] = &g
+		}
+	}
+
+	userList := make([]DirectoryUser, 0, len(users))
+	for _, u := range users {
+		userList = append(userList, *u)
+	}
+
+	sort.Slice(userList, func(i, j int) bool { return userList[i].Email < userList[j].Email })
+
+	groupList := make([]DirectoryGroup, 0, len(groups))
+	for _, g := range groups {
+		groupList = append(groupList, *g)
+	}
+
+	sort.Slice(groupList, func(i, j int) bool { return groupList[i].Name < groupList[j].Name })
+
+	return userList, groupList
+}
+
+// union returns the sorted values of both lists, without duplicates.
+func union(a []string, b []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+
+	for _, v := range append(append([]string{}, a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// ldapDirectory looks up users and groups on an LDAP server and caches them
+// for the ttl.
+type ldapDirectory struct {
+	cfg     LDAPConfig
+	ttl     time.Duration
+	dial    func() (*ldap.Conn, error)
+	lock    sync.Mutex
+	cached  directoryEntries
+	fetched time.Time
+}
+
+func newLDAPDirectory(cfg *LDAPConfig) (*ldapDirectory, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, fmt.Errorf("%w: ldap url and baseDN are required", ErrDirectoryConfig)
+	}
+
+	c := *cfg
+	defaults := map[*string]string{
+		&c.UserFilter:      DefaultLDAPUserFilter,
+		&c.GroupFilter:     DefaultLDAPGroupFilter,
+		&c.NameAttribute:   "cn",
+		&c.EmailAttribute:  "mail",
+		&c.MemberAttribute: "member",
+	}
+
+	for field, value := range defaults {
+		if *field == "" {
+			*field = value
+		}
+	}
+
+	ttl, err := parseDefault(c.CacheTTL, DefaultLDAPCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cacheTTL %s", ErrDirectoryConfig, c.CacheTTL)
+	}
+
+	timeout, err := parseDefault(c.Timeout, DefaultLDAPTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: timeout %s", ErrDirectoryConfig, c.Timeout)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.InsecureSkipVerify} //nolint:gosec
+
+	return &ldapDirectory{
+		cfg:     c,
+		ttl:     ttl,
+		dial:    func() (*ldap.Conn, error) { return ldap.Dial(c.URL, tlsConfig, timeout) },
+		lock:    sync.Mutex{},
+		cached:  directoryEntries{users: nil, groups: nil},
+		fetched: time.Time{},
+	}, nil
+}
+
+func parseDefault(d string, def time.Duration) (time.Duration, error) {
+	if d == "" {
+		return def, nil
+	}
+
+	parsed, err := parseWithin(d)
+	if err == nil && parsed < 0 {
+		err = ErrDirectoryConfig
+	}
+
+	return parsed, err
+}
+
+// entries returns the cached entries, or fetches them if they are older
+// than the ttl.
+func (l *ldapDirectory) entries(now time.Time) (directoryEntries, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.fetched.IsZero() && now.Sub(l.fetched) < l.ttl {
+		return l.cached, nil
+	}
+
+	entries, err := l.fetch()
+	if err != nil {
+		return directoryEntries{users: nil, groups: nil}, err
+	}
+
+	l.cached, l.fetched = entries, now
+
+	return entries, nil
+}
+
+func (l *ldapDirectory) fetch() (directoryEntries, error) {
+	entries := directoryEntries{users: []DirectoryUser{}, groups: []DirectoryGroup{}}
+
+	conn, err := l.dial()
+	if err != nil {
+		return entries, err
+	}
+
+	defer conn.Close()
+
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			return entries, err
+		}
+	}
+
+	people, err := conn.Search(l.cfg.BaseDN, l.cfg.UserFilter, []string{l.cfg.NameAttribute, l.cfg.EmailAttribute})
+	if err != nil {
+		return entries, err
+	}
+
+	groups, err := conn.Search(l.cfg.BaseDN, l.cfg.GroupFilter, []string{l.cfg.NameAttribute, l.cfg.MemberAttribute})
+	if err != nil {
+		return entries, err
+	}
+
+	// Members are DNs, which are not case sensitive
+	emails := map[string]string{}
+	memberOf := map[string][]string{}
+
+	for _, p := range people {
+		if email := p.Value(l.cfg.EmailAttribute); email != "" {
+			emails[strings.ToLower(p.DN)] = email
+		}
+	}
+
+	for _, g := range groups {
+		name := g.Value(l.cfg.NameAttribute)
+		members := []string{}
+
+		for _, dn := range g.Values(l.cfg.MemberAttribute) {
+			if email, ok := emails[strings.ToLower(dn)]; ok {
+				members = append(members, email)
+				memberOf[email] = append(memberOf[email], name)
+			}
+		}
+
+		entries.groups = append(entries.groups, DirectoryGroup{Name: name, Members: members, Sources: []string{SourceLDAP}})
+	}
+
+	for _, p := range people {
+		email := p.Value(l.cfg.EmailAttribute)
+		if email == "" {
+			continue
+		}
+
+		entries.users = append(entries.users, DirectoryUser{
+			Name: p.Value(l.cfg.NameAttribute), Email: email, Groups: memberOf[email], Sources: []string{SourceLDAP},
+		})
+	}
+
+	return entries, nil
+}
+
+// directoryContext returns the users and groups of the directory, it writes
+// the error response if they can not be looked up.
+func directoryContext(res http.ResponseWriter, req *http.Request) ([]DirectoryUser, []DirectoryGroup, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	_, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	users, groups, err := api.directory.lookup(api.Store)
+	if err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "directory lookup failed")
+		res.WriteHeader(http.StatusBadGateway)
+
+		return nil, nil, false
+	}
+
+	return users, groups, true
+}
+
+// handleUsers lists the users of the directory, those in the group query
+// parameter, and those whose name or email contains the q query parameter.
+func handleUsers() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		users, _, ok := directoryContext(res, req)
+		if !ok {
+			return
+		}
+
+		group := req.URL.Query().Get("group")
+		q := strings.ToLower(req.URL.Query().Get("q"))
+		found := []DirectoryUser{}
+
+		for _, u := range users {
+			if group != "" && !contains(u.Groups, group) {
+				continue
+			}
+
+			if q != "" && !strings.Contains(strings.ToLower(u.Name), q) && !strings.Contains(strings.ToLower(u.Email), q) {
+				continue
+			}
+
+			found = append(found, u)
+		}
+
+		writeJSON(req.Context(), res, found)
+	}
+}
+
+// handleUser returns the user of the directory with the email.
+func handleUser() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		users, _, ok := directoryContext(res, req)
+		if !ok {
+			return
+		}
+
+		for _, u := range users {
+			if strings.EqualFold(u.Email, params.ByName("email")) {
+				writeJSON(req.Context(), res, u)
+
+				return
+			}
+		}
+
+		res.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleGroups lists the groups of the directory, those of the member query
+// parameter if it is given.
+func handleGroups() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		_, groups, ok := directoryContext(res, req)
+		if !ok {
+			return
+		}
+
+		member := req.URL.Query().Get("member")
+		found := []DirectoryGroup{}
+
+		for _, g := range groups {
+			if member == "" || containsFold(g.Members, member) {
+				found = append(found, g)
+			}
+		}
+
+		writeJSON(req.Context(), res, found)
+	}
+}
+
+// handleGroup returns the group of the directory with the name.
+func handleGroup() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		_, groups, ok := directoryContext(res, req)
+		if !ok {
+			return
+		}
+
+		for _, g := range groups {
+			if g.Name == params.ByName("name") {
+				writeJSON(req.Context(), res, g)
+
+				return
+			}
+		}
+
+		res.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/ldap"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDirectory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	_, err := newDirectory(&DirectoryConfig{DisableLocal: true, LDAP: nil})
+	assert.ErrorIs(err, ErrNoDirectory)
+
+	_, err = newDirectory(&DirectoryConfig{DisableLocal: false, LDAP: &LDAPConfig{URL: "ldap://localhost"}})
+	assert.ErrorIs(err, ErrDirectoryConfig)
+
+	d, err := newDirectory(&DirectoryConfig{
+		DisableLocal: true,
+		LDAP:         &LDAPConfig{URL: "ldap://localhost", BaseDN: "dc=zebra", CacheTTL: "1m"},
+	})
+	assert.Nil(err)
+	assert.False(d.local)
+	assert.Equal(time.Minute, d.ldap.ttl)
+	assert.Equal(DefaultLDAPUserFilter, d.ldap.cfg.UserFilter)
+	assert.Equal("member", d.ldap.cfg.MemberAttribute)
+}
+
+func TestDirectory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "directory_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	assert.Nil(api.Store.Create(createNewUser("Ann", "ann@zebra", "hash", key.Public())))
+	assert.Nil(api.Store.Create(createNewUser("Bob", "bob@zebra", "hash", key.Public())))
+
+	// Cached LDAP entries are merged with the users of the store
+	api.directory.ldap = &ldapDirectory{
+		cfg:  LDAPConfig{},
+		ttl:  time.Hour,
+		dial: nil,
+		lock: sync.Mutex{},
+		cached: directoryEntries{
+			users: []DirectoryUser{
+				{Name: "Ann", Email: "ann@zebra", Groups: []string{"ops"}, Sources: []string{SourceLDAP}},
+				{Name: "Cid", Email: "cid@zebra", Groups: []string{"ops"}, Sources: []string{SourceLDAP}},
+			},
+			groups: []DirectoryGroup{
+				{Name: "ops", Members: []string{"cid@zebra", "ann@zebra"}, Sources: []string{SourceLDAP}},
+			},
+		},
+		fetched: time.Now(),
+	}
+
+	serve := func(h httprouter.Handle, path string, params httprouter.Params, out interface{}) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "ann@zebra", false))
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("GET", path, nil).WithContext(ctx), params)
+
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), out))
+		}
+
+		return rr.Code
+	}
+
+	users := []DirectoryUser{}
+	assert.Equal(http.StatusOK, serve(handleUsers(), "/api/v1/users", nil, &users))
+	assert.Equal(3, len(users))
+	assert.Equal([]string{"ops", "user"}, users[0].Groups)
+	assert.Equal([]string{SourceLDAP, SourceLocal}, users[0].Sources)
+
+	assert.Equal(http.StatusOK, serve(handleUsers(), "/api/v1/users?group=ops&q=CI", nil, &users))
+	assert.Equal(1, len(users))
+	assert.Equal("cid@zebra", users[0].Email)
+
+	user := DirectoryUser{}
+	assert.Equal(http.StatusOK, serve(handleUser(), "/api/v1/users/bob@zebra",
+		httprouter.Params{{Key: "email", Value: "BOB@zebra"}}, &user))
+	assert.Equal([]string{"user"}, user.Groups)
+	assert.Equal(http.StatusNotFound, serve(handleUser(), "/api/v1/users/dan@zebra",
+		httprouter.Params{{Key: "email", Value: "dan@zebra"}}, &user))
+
+	groups := []DirectoryGroup{}
+	assert.Equal(http.StatusOK, serve(handleGroups(), "/api/v1/groups?member=cid@zebra", nil, &groups))
+	assert.Equal(1, len(groups))
+	assert.Equal([]string{"ann@zebra", "cid@zebra"}, groups[0].Members)
+
+	group := DirectoryGroup{}
+	assert.Equal(http.StatusOK, serve(handleGroup(), "/api/v1/groups/user",
+		httprouter.Params{{Key: "name", Value: "user"}}, &group))
+	assert.Equal([]string{"ann@zebra", "bob@zebra"}, group.Members)
+
+	// Lookups the LDAP server fails are bad gateways
+	api.directory.ldap.fetched = time.Time{}
+	api.directory.ldap.dial = func() (*ldap.Conn, error) { return nil, ErrDirectoryConfig }
+	assert.Equal(http.StatusBadGateway, serve(handleGroups(), "/api/v1/groups", nil, &groups))
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/notify"
+)
+
+// Kinds of drift rules: the share of the resources of a type that went
+// unreachable, their agents having stopped sending heartbeats, and the drop
+// of the number of resources of a type, both over the window of the rule.
+const (
+	DriftUnreachable = "unreachable"
+	DriftCountDrop   = "count-drop"
+)
+
+// DefaultDriftWindow is the window of the drift rules that do not set one.
+const DefaultDriftWindow = time.Hour
+
+var ErrDriftRule = errors.New("drift rules need a unique name, a kind of unreachable or count-drop, a type, " +
+	"a positive threshold and window")
+
+// DriftRule fires when the drift of its kind for the resources of the type
+// is over the threshold, in percent, within the window, a duration such as
+// "1h". The alerts are sent to the users of Notify, and to the users of the
+// drift configuration.
+type DriftRule struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Threshold float64  `json:"threshold"`
+	Window    string   `json:"window,omitempty"`
+	Notify    []string `json:"notify,omitempty"`
+}
+
+// DriftConfig is the drift section of the server configuration: the drift
+// rules, evaluated by the drift-alerts job, and the users notified of all
+// the alerts.
+type DriftConfig struct {
+	Rules  []DriftRule `json:"rules"`
+	Notify []string    `json:"notify,omitempty"`
+}
+
+type driftRule struct {
+	DriftRule
+	window time.Duration
+}
+
+// driftAlerts holds the drift rules and those firing. An alert is sent when
+// a rule starts firing and when it stops, not on every evaluation.
+type driftAlerts struct {
+	lock   sync.Mutex
+	rules  []driftRule
+	notify []string
+	firing map[string]bool
+}
+
+func newDriftAlerts() *driftAlerts {
+	return &driftAlerts{lock: sync.Mutex{}, rules: []driftRule{}, notify: nil, firing: map[string]bool{}}
+}
+
+// configure sets the rules of the configuration.
+func (d *driftAlerts) configure(cfg *DriftConfig) error {
+	rules := make([]driftRule, 0, len(cfg.Rules))
+	names := map[string]bool{}
+
+	for _, r := range cfg.Rules {
+		window := DefaultDriftWindow
+
+		if r.Window != "" {
+			parsed, err := time.ParseDuration(r.Window)
+			if err != nil || parsed <= 0 {
+				return fmt.Errorf("%w: %s", ErrDriftRule, r.Name)
+			}
+
+			window = parsed
+		}
+
+		if r.Name == "" || names[r.Name] || r.Type == "" || r.Threshold <= 0 ||
+			(r.Kind != DriftUnreachable && r.Kind != DriftCountDrop) {
+			return fmt.Errorf("%w: %s", ErrDriftRule, r.Name)
+		}
+
+		names[r.Name] = true
+		rules = append(rules, driftRule{DriftRule: r, window: window})
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.rules = rules
+	d.notify = cfg.Notify
+	d.firing = map[string]bool{}
+
+	return nil
+}
+
+// measure returns the drift of the rule at the time, in percent, from the
+// current resources and the events of the window.
+func (r *driftRule) measure(resources *zebra.ResourceMap, changes []events.Event, now time.Time) float64 {
+	current := 0
+	if list, ok := resources.Resources[r.Type]; ok {
+		current = len(list.Resources)
+	}
+
+	since := now.Add(-r.window)
+	hosts := map[string]string{}
+	dropped := 0
+
+	for _, e := range changes {
+		if e.Kind != r.Type || !e.Time.After(since) || e.Time.After(now) {
+			continue
+		}
+
+		switch e.Type {
+		case events.HostOnline, events.HostStale, events.HostOffline:
+			hosts[e.Resource] = e.Type
+		case events.Deleted, events.Archived:
+			dropped++
+		case events.Created, events.Restored:
+			dropped--
+		}
+	}
+
+	if r.Kind == DriftUnreachable {
+		unreachable := 0
+
+		for _, status := range hosts {
+			if status != events.HostOnline {
+				unreachable++
+			}
+		}
+
+		if current == 0 {
+			return 0
+		}
+
+		return float64(unreachable) * 100 / float64(current) //nolint:gomnd
+	}
+
+	// The resources dropped are no longer counted, they were before
+	if dropped <= 0 {
+		return 0
+	}
+
+	return float64(dropped) * 100 / float64(current+dropped) //nolint:gomnd
+}
+
+// message returns the alert of the rule at the drift.
+func (r *driftRule) message(drift float64) string {
+	if r.Kind == DriftUnreachable {
+		return fmt.Sprintf("%.1f%% of the %s resources went unreachable in the last %s, over the %g%% threshold",
+			drift, r.Type, r.window, r.Threshold)
+	}
+
+	return fmt.Sprintf("the number of %s resources dropped by %.1f%% in the last %s, over the %g%% threshold",
+		r.Type, drift, r.window, r.Threshold)
+}
+
+// evaluate evaluates the rules at the time, sends the alerts of the rules
+// that started or stopped firing through the inbox and the audit log, and
+// returns the task result.
+func (d *driftAlerts) evaluate(api *ResourceAPI, now time.Time) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	changes, _, err := api.Events.Since(0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	resources := api.view()
+	firing := []string{}
+
+	for i := range d.rules {
+		r := &d.rules[i]
+		drift := r.measure(resources, changes, now)
+		fires := drift > r.Threshold
+
+		switch {
+		case fires && !d.firing[r.Name]:
+			message := r.message(drift)
+			d.alert(api, r, "drift alert: "+r.Name, message)
+			api.recordSystemAudit("drift.alert", r.Name, message)
+		case !fires && d.firing[r.Name]:
+			message := fmt.Sprintf("the %s drift of the %s resources is back under the %g%% threshold",
+				r.Kind, r.Type, r.Threshold)
+			d.alert(api, r, "drift resolved: "+r.Name, message)
+			api.recordSystemAudit("drift.resolved", r.Name, message)
+		}
+
+		d.firing[r.Name] = fires
+
+		if fires {
+			firing = append(firing, r.Name)
+		}
+	}
+
+	sort.Strings(firing)
+
+	if len(firing) == 0 {
+		return fmt.Sprintf("evaluated %d drift rules, none firing", len(d.rules)), nil
+	}
+
+	return fmt.Sprintf("evaluated %d drift rules, firing: %s", len(d.rules), strings.Join(firing, ", ")), nil
+}
+
+// alert notifies the users of the rule and of the configuration.
+func (d *driftAlerts) alert(api *ResourceAPI, r *driftRule, subject string, message string) {
+	sent := map[string]bool{}
+
+	for _, user := range append(append([]string{}, r.Notify...), d.notify...) {
+		if user != "" && !sent[user] {
+			sent[user] = true
+			_ = api.Inbox.Notify(notify.NewNotification(user, subject, message, ""))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureDrift(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rule := func(name string, kind string, threshold float64, window string) DriftRule {
+		return DriftRule{Name: name, Kind: kind, Type: "Server", Threshold: threshold, Window: window, Notify: nil}
+	}
+
+	d := newDriftAlerts()
+	assert.Nil(d.configure(&DriftConfig{Rules: []DriftRule{
+		rule("unreachable", DriftUnreachable, 5, ""),
+		rule("drop", DriftCountDrop, 10, "24h"),
+	}, Notify: nil}))
+	assert.Equal(DefaultDriftWindow, d.rules[0].window)
+	assert.Equal(24*time.Hour, d.rules[1].window)
+
+	for _, bad := range [][]DriftRule{
+		{rule("", DriftUnreachable, 5, "")},
+		{rule("x", "flood", 5, "")},
+		{rule("x", DriftUnreachable, 0, "")},
+		{rule("x", DriftUnreachable, 5, "soon")},
+		{rule("x", DriftUnreachable, 5, ""), rule("x", DriftCountDrop, 5, "")},
+	} {
+		assert.ErrorIs(d.configure(&DriftConfig{Rules: bad, Notify: nil}), ErrDriftRule)
+	}
+}
+
+func TestDriftAlerts(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+	assert.Nil(api.drift.configure(&DriftConfig{Rules: []DriftRule{
+		{Name: "labs-unreachable", Kind: DriftUnreachable, Type: "Lab", Threshold: 5, Window: "", Notify: nil},
+		{Name: "labs-drop", Kind: DriftCountDrop, Type: "Lab", Threshold: 20, Window: "", Notify: []string{"lab@zebra"}},
+	}, Notify: []string{"ops@zebra"}}))
+
+	labs := []*dc.Lab{}
+
+	for i := 0; i < 10; i++ {
+		lab := dc.NewLab(fmt.Sprintf("lab%d", i), zebra.Labels{"system.group": "labs"})
+		labs = append(labs, lab)
+		assert.Nil(api.Store.Create(lab))
+	}
+
+	now := time.Now()
+	record := func(eventType string, res zebra.Resource, at time.Time) {
+		e, err := events.NewEvent(eventType, res, "")
+		assert.Nil(err)
+
+		e.Time = at
+		_, err = api.Events.Append(e)
+		assert.Nil(err)
+	}
+
+	result, err := api.drift.evaluate(api, now)
+	assert.Nil(err)
+	assert.Equal("evaluated 2 drift rules, none firing", result)
+
+	// 3 labs were deleted in the last hour, 23% of the 13 there were
+	for i := 0; i < 3; i++ {
+		record(events.Deleted, dc.NewLab("gone", zebra.Labels{"system.group": "labs"}), now.Add(-10*time.Minute))
+	}
+
+	// A lab went offline 2 hours ago, it does not count
+	record(events.HostOffline, labs[0], now.Add(-2*time.Hour))
+
+	result, err = api.drift.evaluate(api, now)
+	assert.Nil(err)
+	assert.Equal("evaluated 2 drift rules, firing: labs-drop", result)
+
+	inbox := api.Inbox.List("ops@zebra")
+	assert.Len(inbox, 1)
+	assert.Equal("drift alert: labs-drop", inbox[0].Subject)
+	assert.Contains(inbox[0].Message, "dropped by 23.1%")
+	assert.Len(api.Inbox.List("lab@zebra"), 1)
+
+	// Alerts are not sent again while the rule keeps firing
+	record(events.HostOffline, labs[1], now.Add(-time.Minute))
+
+	result, err = api.drift.evaluate(api, now)
+	assert.Nil(err)
+	assert.Equal("evaluated 2 drift rules, firing: labs-drop, labs-unreachable", result)
+	assert.Len(api.Inbox.List("ops@zebra"), 2)
+	assert.Len(api.Inbox.List("lab@zebra"), 1)
+
+	// Back online, and the deletes out of the window
+	record(events.HostOnline, labs[1], now)
+
+	result, err = api.drift.evaluate(api, now.Add(time.Hour))
+	assert.Nil(err)
+	assert.Equal("evaluated 2 drift rules, none firing", result)
+
+	inbox = api.Inbox.List("ops@zebra")
+	assert.Len(inbox, 4)
+	assert.Equal("drift resolved: labs-unreachable", inbox[2].Subject)
+	assert.Equal("drift resolved: labs-drop", inbox[3].Subject)
+
+	// The rules are evaluated by a job, now, when the deletes are in the window
+	sched, err := newScheduler(api, []JobConfig{{Name: "drift", Schedule: "@hourly", Task: TaskDrift, Args: nil}})
+	assert.Nil(err)
+
+	status, err := sched.Run(context.Background(), "drift")
+	assert.Nil(err)
+	assert.Equal("evaluated 2 drift rules, firing: labs-drop", status.LastResult)
+}
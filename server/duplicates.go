@@ -0,0 +1,225 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+)
+
+var ErrDuplicateRule = errors.New("duplicate rules must name string fields of known resource types")
+
+// DuplicateConfig names the fields of each resource type that identify a
+// resource, such as {"Server": ["serialNumber"]}. A resource is created or
+// updated only if no other resource of its type has the same value of any
+// of these fields. Values are compared without case and surrounding space.
+type DuplicateConfig struct {
+	Rules map[string][]string `json:"rules,omitempty"`
+}
+
+// Duplicate is a resource of a request with the same value of an identifying
+// field as another resource, in the store or earlier in the request. The
+// other resource is included if the user may read it.
+type Duplicate struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Field      string         `json:"field"`
+	Value      string         `json:"value"`
+	ExistingID string         `json:"existingId"`
+	Existing   zebra.Resource `json:"existing,omitempty"`
+}
+
+// DuplicateGroup is a set of resources of a type with the same value of an
+// identifying field.
+type DuplicateGroup struct {
+	Type      string   `json:"type"`
+	Field     string   `json:"field"`
+	Value     string   `json:"value"`
+	Resources []string `json:"resources"`
+}
+
+// duplicateRules are the identifying fields of each resource type.
+type duplicateRules map[string][]string
+
+// newDuplicateRules returns the rules of the configuration, every field must
+// be a string field of its type.
+func newDuplicateRules(factory zebra.ResourceFactory, cfg *DuplicateConfig) (duplicateRules, error) {
+	rules := duplicateRules{}
+
+	for t, fields := range cfg.Rules {
+		if _, ok := factory.Type(t); !ok {
+			return nil, fmt.Errorf("%w: unknown type %s", ErrDuplicateRule, t)
+		}
+
+		for _, field := range fields {
+			if _, ok := fieldValue(factory.New(t), field); !ok {
+				return nil, fmt.Errorf("%w: %s has no field %s", ErrDuplicateRule, t, field)
+			}
+		}
+
+		rules[t] = fields
+	}
+
+	return rules, nil
+}
+
+// fieldValue returns the normalized value of the string field of the
+// resource, fields are named as in property queries.
+func fieldValue(res zebra.Resource, field string) (string, bool) {
+	v := reflect.ValueOf(res)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return "", false
+	}
+
+	f := store.FieldByName(v.Elem(), field)
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return "", false
+	}
+
+	return strings.ToLower(strings.TrimSpace(f.String())), true
+}
+
+type duplicateKey struct {
+	typ   string
+	field string
+	value string
+}
+
+// find returns the resources of resMap which duplicate a resource of the
+// store or one before them in resMap. Updates of a resource do not
+// duplicate the resource itself.
+func (d duplicateRules) find(s zebra.Store, resMap *zebra.ResourceMap) []Duplicate {
+	types := []string{}
+
+	for t := range resMap.Resources {
+		if len(d[t]) != 0 {
+			types = append(types, t)
+		}
+	}
+
+	if len(types) == 0 {
+		return nil
+	}
+
+	seen := map[duplicateKey]zebra.Resource{}
+	d.index(s.QueryType(types), func(k duplicateKey, r zebra.Resource) { seen[k] = r })
+
+	duplicates := []Duplicate{}
+
+	d.index(resMap, func(k duplicateKey, r zebra.Resource) {
+		if other, ok := seen[k]; ok && other.GetID() != r.GetID() {
+			duplicates = append(duplicates, Duplicate{
+				ID: r.GetID(), Type: k.typ, Field: k.field, Value: k.value,
+				ExistingID: other.GetID(), Existing: other,
+			})
+		}
+
+		seen[k] = r
+	})
+
+	return duplicates
+}
+
+// groups returns the sets of resources sharing an identifying value.
+func (d duplicateRules) groups(resources *zebra.ResourceMap) []DuplicateGroup {
+	ids := map[duplicateKey][]string{}
+	keys := []duplicateKey{}
+
+	d.index(resources, func(k duplicateKey, r zebra.Resource) {
+		if _, ok := ids[k]; !ok {
+			keys = append(keys, k)
+		}
+
+		ids[k] = append(ids[k], r.GetID())
+	})
+
+	groups := []DuplicateGroup{}
+
+	for _, k := range keys {
+		if len(ids[k]) < 2 { //nolint:gomnd
+			continue
+		}
+
+		sort.Strings(ids[k])
+		groups = append(groups, DuplicateGroup{Type: k.typ, Field: k.field, Value: k.value, Resources: ids[k]})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+
+		return a.Value < b.Value
+	})
+
+	return groups
+}
+
+// index calls add with the key of every non-empty identifying value of the
+// resources, in order.
+func (d duplicateRules) index(resources *zebra.ResourceMap, add func(k duplicateKey, r zebra.Resource)) {
+	for _, t := range sortedTypes(resources) {
+		for _, r := range resources.Resources[t].Resources {
+			for _, field := range d[t] {
+				if v, ok := fieldValue(r, field); ok && v != "" {
+					add(duplicateKey{typ: t, field: field, value: v}, r)
+				}
+			}
+		}
+	}
+}
+
+// guardDuplicates refuses the resources of resMap which duplicate others,
+// it writes the conflict and returns false if there are any.
+func (api *ResourceAPI) guardDuplicates(res http.ResponseWriter, req *http.Request,
+	resMap *zebra.ResourceMap,
+) bool {
+	ctx := req.Context()
+
+	duplicates := api.duplicates.find(api.Store, resMap)
+	if len(duplicates) == 0 {
+		return true
+	}
+
+	// The other resources are only shown to users who may read them
+	if claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims); ok {
+		for i, dup := range duplicates {
+			if !claims.Allows(auth.ActionRead, dup.Existing.GetType(), dup.Existing.GetLabels()) {
+				duplicates[i].Existing = nil
+			}
+		}
+	}
+
+	writeJSONCode(ctx, res, http.StatusConflict, duplicates)
+
+	return false
+}
+
+// handleDuplicates reports the resources the user may read which share an
+// identifying value, to clean up data from before the rules were set.
+func handleDuplicates() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.duplicates.groups(readableResources(ctx, api.view())))
+	}
+}
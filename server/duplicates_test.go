@@ -0,0 +1,116 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDuplicateRules(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	factory := store.DefaultFactory()
+
+	rules, err := newDuplicateRules(factory, &DuplicateConfig{Rules: nil})
+	assert.Nil(err)
+	assert.Empty(rules)
+
+	rules, err = newDuplicateRules(factory, &DuplicateConfig{Rules: map[string][]string{"Server": {"serialnumber", "name"}}})
+	assert.Nil(err)
+	assert.Equal([]string{"serialnumber", "name"}, rules["Server"])
+
+	for _, cfg := range []map[string][]string{
+		{"Toaster": {"name"}},
+		{"Server": {"color"}},
+		{"Server": {"credentials"}},
+	} {
+		_, err := newDuplicateRules(factory, &DuplicateConfig{Rules: cfg})
+		assert.ErrorIs(err, ErrDuplicateRule)
+	}
+}
+
+func TestDuplicates(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	rules, err := newDuplicateRules(api.factory, &DuplicateConfig{
+		Rules: map[string][]string{"Server": {"serialNumber"}},
+	})
+	assert.Nil(err)
+
+	api.duplicates = rules
+
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	serve := func(claims *auth.Claims, method, url, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h := handlePost()
+		if method == "GET" {
+			h = handleDuplicates()
+		}
+
+		h(rr, httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	server := func(serial, name string) *compute.Server {
+		return compute.NewServer([]string{serial, "model", name}, net.ParseIP("10.1.0.1"),
+			zebra.Labels{"system.group": "servers"})
+	}
+
+	first := server("SN-1", "first")
+	assert.Equal(http.StatusOK, serve(admin, "POST", "/api/v1/resources", resMapJSON(assert, first)).Code)
+
+	// Updates do not duplicate the resource itself
+	first.Name = "renamed"
+	assert.Equal(http.StatusOK, serve(admin, "POST", "/api/v1/resources", resMapJSON(assert, first)).Code)
+
+	// Serials are compared without case and surrounding space
+	rr := serve(admin, "POST", "/api/v1/resources?dryRun=true", resMapJSON(assert, server(" sn-1", "second")))
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	duplicates := []map[string]interface{}{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &duplicates))
+	assert.Equal(1, len(duplicates))
+	assert.Equal("serialNumber", duplicates[0]["field"])
+	assert.Equal("sn-1", duplicates[0]["value"])
+	assert.Equal(first.ID, duplicates[0]["existingId"])
+	assert.NotNil(duplicates[0]["existing"])
+
+	// Resources of a request also duplicate each other
+	body := resMapJSON(assert, server("SN-2", "second"), server("SN-2", "third"))
+	assert.Equal(http.StatusConflict, serve(admin, "POST", "/api/v1/resources", body).Code)
+	assert.Equal(1, len(api.Store.QueryType([]string{"Server"}).Resources["Server"].Resources))
+
+	// Data from before the rules is reported
+	dup := server("sn-1", "imported")
+	assert.Nil(api.Store.Create(dup))
+
+	rr = serve(admin, "GET", "/api/v1/duplicates", "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	groups := []DuplicateGroup{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &groups))
+	assert.Equal(1, len(groups))
+	assert.Equal("Server", groups[0].Type)
+	assert.Equal("sn-1", groups[0].Value)
+	assert.ElementsMatch([]string{first.ID, dup.ID}, groups[0].Resources)
+}
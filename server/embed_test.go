@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/server"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbed(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "embed_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	_, err := server.New(context.Background(), server.Options{
+		Root: root, Format: store.FormatFiles, LazyIndex: false, AuthKey: "", NoAuth: false,
+	})
+	assert.ErrorIs(err, server.ErrNoAuthKey)
+
+	srv, err := server.New(context.Background(), server.Options{
+		Root: root, Format: "", LazyIndex: false, AuthKey: "embedded", NoAuth: false,
+	})
+	assert.Nil(err)
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	// The store is changed directly
+	assert.Nil(srv.API.Store.Create(auth.NewUser("tester", "tester@zebra", "secret", key.Public(), zebra.Labels{})))
+	assert.Nil(srv.API.Store.Create(dc.NewLab("lab1", zebra.Labels{"system.group": "users"})))
+
+	// The API is mounted on a mux of the program
+	mux := http.NewServeMux()
+	mux.Handle("/zebra/", http.StripPrefix("/zebra", srv))
+
+	web := httptest.NewServer(mux)
+	defer web.Close()
+
+	resp, err := http.Get(web.URL + "/zebra/api/v1/schemas")
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	login, err := json.Marshal(map[string]string{"email": "tester@zebra", "password": "secret"})
+	assert.Nil(err)
+
+	resp, err = http.Post(web.URL+"/zebra/login", "application/json", bytes.NewReader(login))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	token := new(struct {
+		JWT string `json:"jwt"`
+	})
+	assert.Nil(json.NewDecoder(resp.Body).Decode(token))
+	resp.Body.Close()
+
+	req, err := http.NewRequest(http.MethodGet, web.URL+"/zebra/api/v1/types/Lab/schema", nil)
+	assert.Nil(err)
+
+	cookie := new(http.Cookie)
+	cookie.Name = "jwt"
+	cookie.Value = token.JWT
+	req.AddCookie(cookie)
+
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
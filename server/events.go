@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/protoenc"
+)
+
+// Limits of an events request. A request waits for new events up to
+// MaxEventWait, below the default request timeout, and returns
+// eventWaitMargin before the timeout of its route.
+const (
+	DefaultEventLimit = 500
+	MaxEventLimit     = 5000
+	MaxEventWait      = 25 * time.Second
+	eventWaitMargin   = time.Second
+)
+
+var (
+	ErrEventCursor = errors.New("since must be an event cursor")
+	ErrEventLimit  = errors.New("limit must be between 1 and 5000")
+	ErrEventWait   = errors.New("wait must be a duration of at most 25s")
+	ErrEventWaits  = errors.New("wait and waitSeconds can not both be given")
+)
+
+// EventConfig is the event retention of the server configuration, MaxAge is
+// a duration such as "168h".
+type EventConfig struct {
+	Retention int    `json:"retention"`
+	MaxAge    string `json:"maxAge,omitempty"`
+}
+
+// EventPage is a page of events and the cursor to ask for the next page.
+type EventPage struct {
+	Events []events.Event `json:"events"`
+	Cursor string         `json:"cursor"`
+}
+
+// configureEvents applies the configured retention to the event log.
+func configureEvents(log *events.Log, cfg *EventConfig) error {
+	maxAge := time.Duration(0)
+
+	if cfg.MaxAge != "" {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return err
+		}
+
+		maxAge = d
+	}
+
+	log.SetRetention(cfg.Retention, maxAge)
+
+	return nil
+}
+
+type eventParams struct {
+	since uint64
+	limit int
+	wait  time.Duration
+}
+
+func parseEventParams(req *http.Request) (eventParams, error) {
+	query := req.URL.Query()
+	params := eventParams{since: 0, limit: DefaultEventLimit, wait: 0}
+
+	if since := query.Get("since"); since != "" {
+		cursor, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return params, ErrEventCursor
+		}
+
+		params.since = cursor
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxEventLimit {
+			return params, ErrEventLimit
+		}
+
+		params.limit = n
+	}
+
+	wait, waitSeconds := query.Get("wait"), query.Get("waitSeconds")
+	if wait != "" && waitSeconds != "" {
+		return params, ErrEventWaits
+	}
+
+	// waitSeconds is the wait of clients that long-poll with whole seconds
+	if waitSeconds != "" {
+		wait = waitSeconds + "s"
+		if _, err := strconv.ParseUint(waitSeconds, 10, 64); err != nil {
+			return params, ErrEventWait
+		}
+	}
+
+	if wait != "" {
+		d, err := time.ParseDuration(wait)
+		if err != nil || d < 0 || d > MaxEventWait {
+			return params, ErrEventWait
+		}
+
+		params.wait = d
+	}
+
+	return params, nil
+}
+
+// readableEvents returns the events of resources the claims may read.
+func readableEvents(claims *auth.Claims, all []events.Event) []events.Event {
+	if claims == nil {
+		return all
+	}
+
+	readable := make([]events.Event, 0, len(all))
+
+	for _, e := range all {
+		if claims.Allows(auth.ActionRead, e.Kind, e.Labels) {
+			readable = append(readable, e)
+		}
+	}
+
+	return readable
+}
+
+// handleEvents returns the events after the since cursor, so that clients
+// can catch up on the changes they missed. With wait, the request blocks
+// until there are new events or the wait is over, so that watchers which can
+// not hold a stream open long-poll instead: each response carries the cursor
+// of the next request. If the events after the cursor are no longer retained
+// the client gets 410 Gone and must list the resources again.
+func handleEvents() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, _ := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		params, err := parseEventParams(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		// An empty page before the route times out beats a timeout
+		if deadline, ok := ctx.Deadline(); ok && params.wait > time.Until(deadline)-eventWaitMargin {
+			params.wait = time.Until(deadline) - eventWaitMargin
+			if params.wait < 0 {
+				params.wait = 0
+			}
+		}
+
+		// Proxies must not answer a poll with an older page
+		res.Header().Set("Cache-Control", "no-store")
+
+		timer := time.NewTimer(params.wait)
+		defer timer.Stop()
+
+		for {
+			changed := api.Events.Changed()
+
+			page, cursor, err := api.Events.Since(params.since, params.limit)
+			if errors.Is(err, events.ErrCursorExpired) {
+				log.Info("event cursor expired", "since", params.since)
+				http.Error(res, err.Error(), http.StatusGone)
+
+				return
+			}
+
+			page = readableEvents(claims, page)
+			if len(page) != 0 || params.wait == 0 {
+				writeEvents(ctx, res, page, strconv.FormatUint(cursor, 10))
+
+				return
+			}
+
+			// Events the user may not read still move the cursor on
+			params.since = cursor
+
+			select {
+			case <-changed:
+			case <-timer.C:
+				params.wait = 0
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func writeEvents(ctx context.Context, res http.ResponseWriter, page []events.Event, cursor string) {
+	if responseFormat(ctx) == protoenc.MediaType {
+		writeProtobuf(ctx, res, protoenc.MarshalEvents(page, cursor), nil)
+
+		return
+	}
+
+	writeJSON(ctx, res, &EventPage{Events: page, Cursor: cursor})
+}
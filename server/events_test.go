@@ -0,0 +1,130 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleEvents(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "events_testhandle"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	claims := makeClaims(assert, "admin@zebra", true)
+	reader := makeClaims(assert, "user@zebra", false)
+	reader.Role.Privileges[0] = reader.Role.Privileges[0].WithSelector(zebra.Labels{"system.group": "labs"})
+
+	ctx := context.WithValue(context.Background(), ClaimsCtxKey, claims)
+	lab := makeOwnedLab("user@zebra")
+	secret := dc.NewLab("secret", zebra.Labels{"system.group": "secret"})
+
+	assert.Nil(api.create(ctx, lab))
+	assert.Nil(api.create(ctx, lab))
+	assert.Nil(api.create(ctx, secret))
+	assert.Nil(api.delete(ctx, lab))
+	assert.Nil(api.create(ctx, lab))
+
+	get := func(claims *auth.Claims, query string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		handleEvents()(rr, httptest.NewRequest("GET", "/api/v1/events"+query, nil).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	page := func(rr *httptest.ResponseRecorder) *EventPage {
+		p := new(EventPage)
+		assert.Nil(json.Unmarshal(rr.Body.Bytes(), p))
+
+		return p
+	}
+
+	rr := get(claims, "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	all := page(rr)
+	types := []string{}
+
+	for _, e := range all.Events {
+		types = append(types, e.Type)
+	}
+
+	assert.Equal([]string{events.Created, events.Updated, events.Created, events.Deleted, events.Created}, types)
+	assert.Equal("5", all.Cursor)
+	assert.Equal("admin@zebra", all.Events[0].Actor)
+
+	// Users only see the events of resources they may read
+	rr = get(reader, "?since=2")
+	assert.Equal(2, len(page(rr).Events))
+	assert.Equal("5", page(rr).Cursor)
+
+	rr = get(claims, "?since=1&limit=2")
+	assert.Equal(2, len(page(rr).Events))
+	assert.Equal("3", page(rr).Cursor)
+
+	for _, query := range []string{"?since=x", "?limit=0", "?limit=9999", "?wait=1h", "?wait=x",
+		"?waitSeconds=26", "?waitSeconds=-1", "?waitSeconds=1s", "?wait=1s&waitSeconds=1",
+	} {
+		assert.Equal(http.StatusBadRequest, get(claims, query).Code, query)
+	}
+
+	// Waiting returns as soon as there is a new event
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.Nil(api.create(ctx, secret))
+	}()
+
+	rr = get(claims, "?since=5&wait=10s")
+	assert.Equal(1, len(page(rr).Events))
+	assert.Equal("6", page(rr).Cursor)
+
+	assert.Equal("no-store", rr.Header().Get("Cache-Control"))
+
+	// Long-polls in whole seconds end before the timeout of the route
+	timeout, cancel := context.WithTimeout(ctx, eventWaitMargin+100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/events?since=6&waitSeconds=20", nil)
+	handleEvents()(rr, req.WithContext(context.WithValue(timeout, ResourcesCtxKey, api)), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Empty(page(rr).Events)
+	assert.Less(time.Since(start), eventWaitMargin)
+
+	// Events that can not be read do not end the wait but move the cursor
+	rr = get(reader, "?since=5&wait=100ms")
+	assert.Empty(page(rr).Events)
+	assert.Equal("6", page(rr).Cursor)
+
+	// Expired cursors must list again
+	api.Events.SetRetention(1, 0)
+	assert.Equal(http.StatusGone, get(claims, "?since=2").Code)
+
+	assert.Nil(configureEvents(api.Events, &EventConfig{Retention: 10, MaxAge: "1h"}))
+	assert.NotNil(configureEvents(api.Events, &EventConfig{Retention: 10, MaxAge: "soon"}))
+
+	rr = httptest.NewRecorder()
+	handleEvents()(rr, httptest.NewRequest("GET", "/api/v1/events", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
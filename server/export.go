@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/xlsx"
+)
+
+var ErrExportType = errors.New("export types must be known resource types")
+
+// flattenJSON returns the fields of a JSON object in order, with the fields
+// of nested objects named by their path, such as "status.lease". Arrays are
+// kept as JSON, null fields are empty.
+func flattenJSON(data []byte, prefix string, keys []string, values map[string]string) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if _, err := dec.Token(); err != nil {
+		return keys, err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return keys, err
+		}
+
+		key, _ := token.(string)
+		raw := json.RawMessage{}
+
+		if err := dec.Decode(&raw); err != nil {
+			return keys, err
+		}
+
+		name := prefix + key
+
+		switch {
+		case bytes.HasPrefix(raw, []byte("{")):
+			if keys, err = flattenJSON(raw, name+".", keys, values); err != nil {
+				return keys, err
+			}
+
+			continue
+		case bytes.HasPrefix(raw, []byte(`"`)):
+			s := ""
+			_ = json.Unmarshal(raw, &s)
+			values[name] = s
+		case string(raw) == "null":
+			values[name] = ""
+		default:
+			values[name] = string(raw)
+		}
+
+		keys = append(keys, name)
+	}
+
+	return keys, nil
+}
+
+// exportSheet returns the sheet of the resources of a type. The columns are
+// the fields of the type, in the order of its schema, then the fields only
+// some resources have and their labels, by name.
+func exportSheet(t zebra.Type, resources []zebra.Resource) (xlsx.Sheet, error) {
+	columns := []string{}
+	seen := map[string]bool{}
+	labels := []string{}
+	rows := make([]map[string]string, 0, len(resources))
+
+	addColumns := func(keys []string) {
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+
+				if strings.HasPrefix(k, "labels.") {
+					labels = append(labels, k)
+				} else {
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+
+	// A new resource of the type has all fields of the schema
+	if data, err := json.Marshal(t.New()); err == nil {
+		keys, _ := flattenJSON(data, "", nil, map[string]string{})
+		addColumns(keys)
+	}
+
+	for _, r := range resources {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return xlsx.Sheet{Name: t.Name, Rows: nil}, err
+		}
+
+		values := map[string]string{}
+
+		keys, err := flattenJSON(data, "", nil, values)
+		if err != nil {
+			return xlsx.Sheet{Name: t.Name, Rows: nil}, err
+		}
+
+		addColumns(keys)
+
+		rows = append(rows, values)
+	}
+
+	sort.Strings(labels)
+	columns = append(columns, labels...)
+
+	sheet := xlsx.Sheet{Name: t.Name, Rows: [][]string{columns}}
+
+	for _, values := range rows {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = values[c]
+		}
+
+		sheet.Rows = append(sheet.Rows, row)
+	}
+
+	return sheet, nil
+}
+
+// exportTypes returns the types to export: those of the types parameter, a
+// comma separated list, or else all types with resources the user may read.
+func exportTypes(factory zebra.ResourceFactory, param string, resources *zebra.ResourceMap) ([]zebra.Type, error) {
+	names := strings.FieldsFunc(param, func(r rune) bool { return r == ',' })
+	if len(names) == 0 {
+		names = sortedTypes(resources)
+	}
+
+	// All types, if there is nothing to read, so that the workbook has sheets
+	if len(names) == 0 {
+		for _, t := range factory.Types() {
+			names = append(names, t.Name)
+		}
+
+		sort.Strings(names)
+	}
+
+	types := make([]zebra.Type, 0, len(names))
+
+	for _, name := range names {
+		t, ok := factory.Type(name)
+		if !ok {
+			return nil, ErrExportType
+		}
+
+		types = append(types, t)
+	}
+
+	return types, nil
+}
+
+// handleExport returns the resources the user may read as an Excel
+// workbook with a sheet per type, of the types of the types parameter or
+// all types.
+func handleExport() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		resources := readableResources(ctx, api.view())
+
+		types, err := exportTypes(api.factory, req.URL.Query().Get("types"), resources)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		sheets := make([]xlsx.Sheet, 0, len(types))
+
+		for _, t := range types {
+			list := []zebra.Resource{}
+			if l, ok := resources.Resources[t.Name]; ok {
+				list = l.Resources
+			}
+
+			sheet, err := exportSheet(t, list)
+			if err != nil {
+				log.Error(err, "failed to export resources", "type", t.Name)
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			sheets = append(sheets, sheet)
+		}
+
+		name := "inventory-" + time.Now().UTC().Format("20060102T150405Z") + "." + FormatXLSX
+
+		res.Header().Set("Content-Type", reportContentType(FormatXLSX))
+		res.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+		if err := xlsx.Write(res, sheets...); err != nil {
+			log.Error(err, "failed to write export")
+
+			return
+		}
+
+		log.Info("resources exported", "types", len(sheets))
+	}
+}
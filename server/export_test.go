@@ -0,0 +1,120 @@
+package server //nolint:testpackage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportSheet(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	labType, ok := store.DefaultFactory().Type("Lab")
+	assert.True(ok)
+
+	lab1 := dc.NewLab("lab1", zebra.Labels{"system.group": "labs", "owner": "alice@zebra"})
+	lab2 := dc.NewLab("lab2", zebra.Labels{"system.group": "labs"})
+
+	sheet, err := exportSheet(labType, []zebra.Resource{lab1, lab2})
+	assert.Nil(err)
+	assert.Equal("Lab", sheet.Name)
+	assert.Len(sheet.Rows, 3)
+
+	// Fields in schema order, then the labels by name
+	header := sheet.Rows[0]
+	assert.Equal([]string{"id", "type", "name"}, header[:3])
+	assert.Contains(header, "status.lease")
+	assert.Equal([]string{"labels.owner", "labels.system.group"}, header[len(header)-2:])
+
+	assert.Equal(lab1.ID, sheet.Rows[1][0])
+	assert.Equal("lab1", sheet.Rows[1][2])
+	assert.Equal([]string{"alice@zebra", "labs"}, sheet.Rows[1][len(header)-2:])
+	assert.Equal([]string{"", "labs"}, sheet.Rows[2][len(header)-2:])
+
+	// Empty types still have the columns of their schema
+	sheet, err = exportSheet(labType, nil)
+	assert.Nil(err)
+	assert.Len(sheet.Rows, 1)
+	assert.Contains(sheet.Rows[0], "name")
+}
+
+func TestHandleExport(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "export_testhandle"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+	assert.Nil(api.Store.Create(makeOwnedLab("alice@zebra")))
+	assert.Nil(api.Store.Create(dc.NewLab("hidden", zebra.Labels{"system.group": "secret"})))
+	assert.Nil(api.Store.Create(dc.NewDatacenter("San Jose", "dc1", zebra.Labels{"system.group": "labs"})))
+
+	// The user may only read the labs group
+	reader := makeClaims(assert, "user@zebra", false)
+	reader.Role.Privileges[0] = reader.Role.Privileges[0].WithSelector(zebra.Labels{"system.group": "labs"})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, reader)
+		rr := httptest.NewRecorder()
+
+		handleExport()(rr, httptest.NewRequest("GET", url, nil).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	part := func(data []byte, name string) string {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		assert.Nil(err)
+
+		f, err := zr.Open(name)
+		if !assert.Nil(err) {
+			return ""
+		}
+
+		defer f.Close()
+
+		content, err := ioutil.ReadAll(f)
+		assert.Nil(err)
+
+		return string(content)
+	}
+
+	rr := get("/api/v1/export")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(reportContentType(FormatXLSX), rr.Header().Get("Content-Type"))
+	assert.Contains(rr.Header().Get("Content-Disposition"), `filename="inventory-`)
+
+	workbook := part(rr.Body.Bytes(), "xl/workbook.xml")
+	assert.Contains(workbook, `name="Datacenter"`)
+	assert.Contains(workbook, `name="Lab"`)
+	assert.Contains(part(rr.Body.Bytes(), "xl/worksheets/sheet2.xml"), "alice@zebra")
+	assert.NotContains(part(rr.Body.Bytes(), "xl/worksheets/sheet2.xml"), "hidden")
+
+	rr = get("/api/v1/export?types=Lab,VCenter")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	workbook = part(rr.Body.Bytes(), "xl/workbook.xml")
+	assert.Contains(workbook, `name="VCenter"`)
+	assert.NotContains(workbook, `name="Datacenter"`)
+
+	assert.Equal(http.StatusBadRequest, get("/api/v1/export?types=Gossip").Code)
+
+	rr = httptest.NewRecorder()
+	handleExport()(rr, httptest.NewRequest("GET", "/api/v1/export", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
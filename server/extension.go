@@ -0,0 +1,334 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/notify"
+)
+
+// maxLeaseDuration is the longest a lease lasts without an approved
+// extension.
+const maxLeaseDuration = zebra.DefaultMaxDuration * time.Hour
+
+var (
+	ErrExtensionRequest = errors.New("invalid lease extension request")
+	ErrExtensionCeiling = errors.New("lease extension exceeds the ceiling")
+	ErrExtensionPending = errors.New("lease already has a pending extension request")
+)
+
+// ExtensionRequest asks to extend an active lease by the duration, such as
+// "2h". Leases extended up to the maximum lease duration of their namespace
+// are extended right away, longer extensions wait for the approval of an
+// admin.
+type ExtensionRequest struct {
+	Duration string `json:"duration"`
+}
+
+func (er *ExtensionRequest) extension() (time.Duration, error) {
+	d, err := parseWithin(er.Duration)
+	if err != nil || d <= 0 {
+		return 0, ErrExtensionRequest
+	}
+
+	return d, nil
+}
+
+// requestExtension adds a pending request to extend the lease by d.
+func (a *approvalList) requestExtension(requestedBy string, impersonatedBy string, id string, d time.Duration,
+	now time.Time,
+) *Approval {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	approval := a.add(OperationExtend, requestedBy, impersonatedBy, []string{id}, nil, now)
+	approval.Extension = d.String()
+
+	return approval
+}
+
+// pendingExtension returns true if the lease has a pending extension
+// request.
+func (a *approvalList) pendingExtension(id string) bool {
+	for _, approval := range a.list(ApprovalPending) {
+		if len(approval.Resources) == 1 && approval.Resources[0] == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expireExtensions expires stale extension requests, records them in the
+// audit log and returns how many expired.
+func (api *ResourceAPI) expireExtensions(now time.Time) int {
+	expired := api.extensions.expire(now)
+
+	for _, approval := range expired {
+		api.recordSystemAudit("lease.extend.expire", approval.ID, approval.Resources[0])
+	}
+
+	return len(expired)
+}
+
+// extensible returns the active lease if it may be extended by d without
+// going past the extension ceiling.
+func (api *ResourceAPI) extensible(id string, d time.Duration) (*lease.Lease, error) {
+	l, ok := findResource(api.Store, id).(*lease.Lease)
+	if !ok {
+		return nil, ErrLeaseResource
+	}
+
+	if l.Status.State != zebra.Active {
+		return nil, ErrLeaseInactive
+	}
+
+	if l.Length()+d > api.leasePolicy.ceiling {
+		return nil, fmt.Errorf("%w of %s", ErrExtensionCeiling, api.leasePolicy.ceiling)
+	}
+
+	return l, nil
+}
+
+// handleExtendLease extends an active lease of the user. Extensions within
+// the maximum lease duration apply right away, longer ones are routed to the
+// admins for approval, none go past the extension ceiling.
+func handleExtendLease() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		er := new(ExtensionRequest)
+		if err := readJSON(ctx, req, er); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		d, err := er.extension()
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		l, ok := findResource(api.Store, params.ByName("id")).(*lease.Lease)
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if l.Owner() != claims.Email && !claims.Allows(auth.ActionUpdate, l.GetType(), l.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		if _, err := api.extensible(l.ID, d); err != nil {
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		}
+
+		api.expireExtensions(time.Now())
+
+		if api.extensions.pendingExtension(l.ID) {
+			http.Error(res, ErrExtensionPending.Error(), http.StatusConflict)
+
+			return
+		}
+
+		if l.Length()+d > namespacePolicy(api.view(), l.Namespace()).Max() {
+			approval := api.extensions.requestExtension(claims.Email, impersonator(ctx), l.ID, d, time.Now())
+			api.recordAudit(ctx, "lease.extend.request", approval.ID, l.ID+" "+approval.Extension)
+			log.Info("lease extension waits for approval", "lease", l.ID, "approval", approval.ID)
+			writeJSONCode(ctx, res, http.StatusAccepted, approval)
+
+			return
+		}
+
+		l.Extend(d)
+
+		if err := api.create(ctx, l); err != nil {
+			log.Error(err, "lease could not be extended", "lease", l.ID)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.extend", l.ID, d.String())
+
+		writeJSON(ctx, res, l)
+	}
+}
+
+// handleLeaseExtensions lists the extension requests, optionally filtered by
+// the status query parameter. Admins see all of them, other users their own.
+func handleLeaseExtensions() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.expireExtensions(time.Now())
+
+		approvals := []Approval{}
+
+		for _, approval := range api.extensions.list(ApprovalStatus(req.URL.Query().Get("status"))) {
+			if claims.Write(AdminKey) || approval.RequestedBy == claims.Email {
+				approvals = append(approvals, approval)
+			}
+		}
+
+		writeJSON(ctx, res, approvals)
+	}
+}
+
+// handleApproveExtension extends the lease of a pending extension request,
+// if it is still active and the extension stays within the ceiling. Only
+// another admin may approve a request.
+func handleApproveExtension() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if refuseImpersonated(res, req) {
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			http.Error(res, ErrNotApprover.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		api.expireExtensions(time.Now())
+
+		id := params.ByName("id")
+
+		for _, pending := range api.extensions.list(ApprovalPending) {
+			if pending.ID != id {
+				continue
+			}
+
+			d, _ := time.ParseDuration(pending.Extension)
+			if _, err := api.extensible(pending.Resources[0], d); err != nil {
+				http.Error(res, err.Error(), http.StatusConflict)
+
+				return
+			}
+		}
+
+		approval, err := api.extensions.decide(id, ApprovalApproved, claims.Email, time.Now())
+		if err != nil {
+			writeApprovalError(res, err)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.extend.approve", approval.ID, approval.Resources[0]+" "+approval.Extension)
+
+		d, _ := time.ParseDuration(approval.Extension)
+
+		l, err := api.extensible(approval.Resources[0], d)
+		if err == nil {
+			l.Extend(d)
+			err = api.create(ctx, l)
+		}
+
+		if err != nil {
+			log.Error(err, "lease could not be extended", "lease", approval.Resources[0], "approval", approval.ID)
+			writeJSONCode(ctx, res, http.StatusInternalServerError, approval)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.extend", l.ID, approval.Extension)
+		_ = api.Inbox.Notify(notify.NewNotification(l.Owner(), "lease extension approved",
+			fmt.Sprintf("lease %s is extended until %s", l.ID, l.Expiry().UTC().Format(time.RFC3339)), l.ID))
+
+		writeJSON(ctx, res, approval)
+	}
+}
+
+// handleRejectExtension rejects a pending extension request. Admins reject
+// requests, the requester may withdraw their own.
+func handleRejectExtension() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if refuseImpersonated(res, req) {
+			return
+		}
+
+		api.expireExtensions(time.Now())
+
+		id := params.ByName("id")
+		requester := false
+
+		for _, a := range api.extensions.list(ApprovalPending) {
+			requester = requester || (a.ID == id && a.RequestedBy == claims.Email)
+		}
+
+		if !requester && !claims.Write(AdminKey) {
+			http.Error(res, ErrNotApprover.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		approval, err := api.extensions.decide(id, ApprovalRejected, claims.Email, time.Now())
+		if err != nil {
+			writeApprovalError(res, err)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.extend.reject", approval.ID, approval.Resources[0]+" "+approval.Extension)
+
+		if !requester {
+			_ = api.Inbox.Notify(notify.NewNotification(approval.RequestedBy, "lease extension rejected",
+				fmt.Sprintf("the extension of lease %s by %s was rejected by %s", approval.Resources[0],
+					approval.Extension, claims.Email), approval.Resources[0]))
+		}
+
+		writeJSON(ctx, res, approval)
+	}
+}
@@ -0,0 +1,144 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionCeiling(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p, err := newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: nil, ExtensionCeiling: ""})
+	assert.Nil(err)
+	assert.Equal(DefaultExtensionCeiling, p.ceiling)
+
+	p, err = newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: nil, ExtensionCeiling: "2d"})
+	assert.Nil(err)
+	assert.Equal(48*time.Hour, p.ceiling)
+
+	// The ceiling is never below the maximum lease duration
+	for _, ceiling := range []string{"1h", "never"} {
+		_, err := newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: nil, ExtensionCeiling: ceiling})
+		assert.ErrorIs(err, ErrCeiling)
+	}
+}
+
+func TestExtendLease(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "extension_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}
+	assert.Nil(req.Assign(makeOwnedLab("user@zebra")))
+
+	l := lease.NewLease("user@zebra", 3*time.Hour, []*lease.ResourceReq{req})
+	l.ActivationTime = time.Now()
+	l.Status.State = zebra.Active
+	assert.Nil(api.create(context.Background(), l))
+
+	serve := func(email string, admin bool, h httprouter.Handle, path string, id string, body string,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, email, admin))
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", path, strings.NewReader(body)).WithContext(ctx),
+			httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	extend := func(email string, d string) *httptest.ResponseRecorder {
+		return serve(email, false, handleExtendLease(), "/api/v1/leases/extend/"+l.ID, l.ID, `{"duration":"`+d+`"}`)
+	}
+
+	assert.Equal(http.StatusForbidden, extend("other@zebra", "1h").Code)
+	assert.Equal(http.StatusBadRequest, extend("user@zebra", "-1h").Code)
+	assert.Equal(http.StatusConflict, extend("user@zebra", "30h").Code)
+
+	// Up to the maximum lease duration the lease is extended right away
+	rr := extend("user@zebra", "1h")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(4*time.Hour, l.Length())
+
+	// Past it the extension waits for an admin
+	rr = extend("user@zebra", "2h")
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	approval := new(Approval)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), approval))
+	assert.Equal(OperationExtend, approval.Operation)
+	assert.Equal([]string{l.ID}, approval.Resources)
+	assert.Equal("2h0m0s", approval.Extension)
+	assert.Equal(4*time.Hour, l.Length())
+
+	assert.Equal(http.StatusConflict, extend("user@zebra", "1h").Code)
+
+	decide := func(email string, admin bool, h httprouter.Handle, id string) *httptest.ResponseRecorder {
+		return serve(email, admin, h, "/api/v1/leases/extensions/"+id, id, "")
+	}
+
+	assert.Equal(http.StatusForbidden, decide("user@zebra", false, handleApproveExtension(), approval.ID).Code)
+	assert.Equal(http.StatusNotFound, decide("admin@zebra", true, handleApproveExtension(), "x").Code)
+
+	rr = decide("admin@zebra", true, handleApproveExtension(), approval.ID)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(6*time.Hour, l.Length())
+	assert.Equal("lease extension approved", api.Inbox.List("user@zebra")[0].Subject)
+
+	assert.Equal(http.StatusConflict, decide("admin@zebra", true, handleApproveExtension(), approval.ID).Code)
+
+	// Rejected extensions leave the lease as it is
+	rr = extend("user@zebra", "4h")
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), approval))
+
+	assert.Equal(http.StatusForbidden, decide("other@zebra", false, handleRejectExtension(), approval.ID).Code)
+	assert.Equal(http.StatusOK, decide("admin@zebra", true, handleRejectExtension(), approval.ID).Code)
+	assert.Equal(6*time.Hour, l.Length())
+	assert.Equal(2, len(api.Inbox.List("user@zebra")))
+
+	// Users list their own requests, admins all of them
+	list := func(email string, admin bool) []Approval {
+		rr := serve(email, admin, handleLeaseExtensions(), "/api/v1/leases/extensions", "", "")
+		assert.Equal(http.StatusOK, rr.Code)
+
+		approvals := []Approval{}
+		assert.Nil(json.Unmarshal(rr.Body.Bytes(), &approvals))
+
+		return approvals
+	}
+
+	assert.Equal(2, len(list("user@zebra", false)))
+	assert.Empty(list("other@zebra", false))
+	assert.Equal(2, len(list("admin@zebra", true)))
+
+	actions := map[string]int{}
+	for _, e := range api.Audit.Entries() {
+		actions[e.Action]++
+	}
+
+	assert.Equal(2, actions["lease.extend"])
+	assert.Equal(2, actions["lease.extend.request"])
+	assert.Equal(1, actions["lease.extend.approve"])
+	assert.Equal(1, actions["lease.extend.reject"])
+}
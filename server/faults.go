@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/faults"
+)
+
+// FaultConfig enables fault injection, for test deployments only, and sets
+// the faults injected from the start. Admins change the faults at runtime.
+type FaultConfig struct {
+	Enabled bool          `json:"enabled"`
+	Faults  faults.Config `json:"faults"`
+}
+
+// FaultStatus is the faults injected and the counts of those injected so far.
+type FaultStatus struct {
+	Faults faults.Config `json:"faults"`
+	Stats  faults.Stats  `json:"stats"`
+}
+
+// newFaultInjector returns the fault injector of the configuration, nil if
+// fault injection is not enabled.
+func newFaultInjector(cfg *FaultConfig) (*faults.Injector, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	injector := faults.New(time.Now().UnixNano())
+	if err := injector.Set(cfg.Faults); err != nil {
+		return nil, err
+	}
+
+	return injector, nil
+}
+
+func faultContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	switch {
+	case !apiOK || !claimsOK:
+		res.WriteHeader(http.StatusInternalServerError)
+	case !claims.Write(AdminKey):
+		res.WriteHeader(http.StatusForbidden)
+	case api.faults == nil:
+		// Faults are only injected into servers configured for it
+		res.WriteHeader(http.StatusNotFound)
+	default:
+		return api, true
+	}
+
+	return nil, false
+}
+
+func faultStatus(api *ResourceAPI) FaultStatus {
+	return FaultStatus{Faults: api.faults.Config(), Stats: api.faults.Stats()}
+}
+
+// handleFaults returns the faults injected into the store, admins only.
+func handleFaults() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, ok := faultContext(res, req)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, faultStatus(api))
+	}
+}
+
+// handleSetFaults replaces the faults injected into the store, admins only.
+func handleSetFaults() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := faultContext(res, req)
+		if !ok {
+			return
+		}
+
+		cfg := faults.Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0}
+		if err := readJSON(ctx, req, &cfg); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := api.faults.Set(cfg); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		api.recordAudit(ctx, "faults.set", "", "")
+
+		writeJSON(ctx, res, faultStatus(api))
+	}
+}
+
+// handleClearFaults stops injecting faults, admins only.
+func handleClearFaults() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := faultContext(res, req)
+		if !ok {
+			return
+		}
+
+		_ = api.faults.Set(faults.Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0})
+		api.recordAudit(ctx, "faults.clear", "", "")
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,101 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/faults"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFaultInjector(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	none := faults.Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0}
+
+	injector, err := newFaultInjector(&FaultConfig{Enabled: false, Faults: none})
+	assert.Nil(err)
+	assert.Nil(injector)
+
+	injector, err = newFaultInjector(&FaultConfig{
+		Enabled: true,
+		Faults:  faults.Config{Latency: "", Jitter: "", WriteErrors: 10, DroppedEvents: 0},
+	})
+	assert.Nil(err)
+	assert.Equal(10, injector.Config().WriteErrors)
+
+	_, err = newFaultInjector(&FaultConfig{
+		Enabled: true,
+		Faults:  faults.Config{Latency: "1h", Jitter: "", WriteErrors: 0, DroppedEvents: 0},
+	})
+	assert.ErrorIs(err, faults.ErrConfig)
+}
+
+func TestFaults(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	serve := func(admin bool, h httprouter.Handle, method string, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "admin@zebra", admin))
+
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(method, "/admin/faults", strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	// Faults are not injected unless enabled
+	assert.Equal(http.StatusNotFound, serve(true, handleFaults(), http.MethodGet, "").Code)
+
+	api.faults = faults.New(1)
+
+	assert.Equal(http.StatusForbidden, serve(false, handleFaults(), http.MethodGet, "").Code)
+	assert.Equal(http.StatusForbidden, serve(false, handleSetFaults(), http.MethodPost, `{"writeErrors":100}`).Code)
+	assert.Equal(http.StatusBadRequest, serve(true, handleSetFaults(), http.MethodPost, `{"writeErrors":200}`).Code)
+	assert.Equal(http.StatusBadRequest, serve(true, handleSetFaults(), http.MethodPost, `{`).Code)
+
+	rr := serve(true, handleSetFaults(), http.MethodPost, `{"writeErrors":100,"droppedEvents":0}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	assert.ErrorIs(api.create(context.Background(), lab), faults.ErrInjected)
+	assert.ErrorIs(api.delete(context.Background(), lab), faults.ErrInjected)
+	assert.ErrorIs(api.archiveResource(context.Background(), lab), faults.ErrInjected)
+	assert.Nil(findResource(api.Store, lab.ID))
+
+	// Dropped events leave replicas behind
+	rr = serve(true, handleSetFaults(), http.MethodPost, `{"droppedEvents":100}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	latest := api.Events.Latest()
+	assert.Nil(api.create(context.Background(), lab))
+	assert.NotNil(findResource(api.Store, lab.ID))
+	assert.Equal(latest, api.Events.Latest())
+
+	rr = serve(true, handleFaults(), http.MethodGet, "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	status := new(FaultStatus)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), status))
+	assert.Equal(100, status.Faults.DroppedEvents)
+	assert.Equal(uint64(3), status.Stats.WriteErrors)
+	assert.Equal(uint64(1), status.Stats.DroppedEvents)
+
+	assert.Equal(http.StatusNoContent, serve(true, handleClearFaults(), http.MethodDelete, "").Code)
+
+	assert.Nil(api.delete(context.Background(), lab))
+	assert.Equal(latest+1, api.Events.Latest())
+}
@@ -0,0 +1,751 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/secrets"
+)
+
+// FederationSiteLabel is the label of the site a federated resource belongs
+// to. Writes are routed to the site of the label, if it is set.
+const FederationSiteLabel = "federation.site"
+
+// SiteUnreachable is the health status of a site that did not answer.
+const SiteUnreachable = "unreachable"
+
+// Defaults of the federation.
+const (
+	DefaultFederationTimeout = 10 * time.Second
+	DefaultSiteCheck         = 30 * time.Second
+	siteTokenTTL             = 5 * time.Minute
+)
+
+// siteResponseLimit bounds the responses read from sites.
+const siteResponseLimit = 64 << 20
+
+var (
+	ErrFederationConfig = errors.New("invalid federation configuration")
+	ErrNoFederation     = errors.New("federation is not configured")
+	ErrNoOwningSite     = errors.New("no site owns the resource")
+	ErrSiteStatus       = errors.New("site responded with an error status")
+)
+
+// FederationConfig turns the server into the front end of the site servers:
+// queries are sent to all sites and their results merged, writes are sent
+// to the site that owns the resources. Sites are checked for health every
+// check interval, requests to them time out after the timeout.
+type FederationConfig struct {
+	Sites         []SiteConfig `json:"sites,omitempty"`
+	Timeout       string       `json:"timeout,omitempty"`
+	CheckInterval string       `json:"checkInterval,omitempty"`
+}
+
+// SiteConfig is a site server of the federation. The front end calls it
+// with the token of a service account of the site, and checks on its own
+// what the users of the front end may read and write. The resources of the
+// namespaces are owned by the site.
+type SiteConfig struct {
+	Name       string          `json:"name"`
+	URL        string          `json:"url"`
+	Token      *secrets.Config `json:"token,omitempty"`
+	CACert     string          `json:"caCert,omitempty"`
+	Namespaces []string        `json:"namespaces,omitempty"`
+}
+
+// SiteStatus is the health of a site, as of its last check or request.
+type SiteStatus struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Latency   string    `json:"latency,omitempty"`
+	Checked   time.Time `json:"checked,omitempty"`
+	Resources int       `json:"resources,omitempty"`
+}
+
+// FederatedResult is the merged result of a query of all sites. It is
+// partial if a site failed to answer, the status of each site tells which.
+type FederatedResult struct {
+	Resources *zebra.ResourceMap `json:"resources"`
+	Partial   bool               `json:"partial"`
+	Sites     []SiteStatus       `json:"sites"`
+}
+
+// SiteWrite is the outcome of the writes routed to a site.
+type SiteWrite struct {
+	Site   string   `json:"site"`
+	IDs    []string `json:"ids"`
+	Status int      `json:"status"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// FederatedWrite is the outcome of a write routed to the sites. Resources
+// without an owning site are not written.
+type FederatedWrite struct {
+	Sites   []SiteWrite `json:"sites"`
+	Unowned []string    `json:"unowned,omitempty"`
+}
+
+type site struct {
+	SiteConfig
+	api    string
+	client *http.Client
+	source secrets.Source
+
+	lock    sync.Mutex
+	token   string
+	fetched time.Time
+	status  SiteStatus
+}
+
+type federation struct {
+	sites    []*site
+	interval time.Duration
+}
+
+// newFederation returns the federation of the configured sites, or nil if
+// there are none.
+func newFederation(cfg *FederationConfig) (*federation, error) {
+	if len(cfg.Sites) == 0 {
+		return nil, nil
+	}
+
+	timeout, err := parseFederationDuration(cfg.Timeout, DefaultFederationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := parseFederationDuration(cfg.CheckInterval, DefaultSiteCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &federation{sites: make([]*site, 0, len(cfg.Sites)), interval: interval}
+	names := map[string]bool{}
+
+	for _, sc := range cfg.Sites {
+		if sc.Name == "" || names[sc.Name] {
+			return nil, fmt.Errorf("%w: site names must be set and unique", ErrFederationConfig)
+		}
+
+		names[sc.Name] = true
+
+		s, err := newSite(sc, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", sc.Name, err)
+		}
+
+		f.sites = append(f.sites, s)
+	}
+
+	return f, nil
+}
+
+func parseFederationDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: duration %s", ErrFederationConfig, value)
+	}
+
+	return d, nil
+}
+
+func newSite(cfg SiteConfig, timeout time.Duration) (*site, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, ErrWebhookURL
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pem)
+
+		transport := new(http.Transport)
+		transport.TLSClientConfig = new(tls.Config)
+		transport.TLSClientConfig.RootCAs = pool
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+		client.Transport = transport
+	}
+
+	var source secrets.Source
+
+	if cfg.Token != nil {
+		if source, err = secrets.New(cfg.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &site{
+		SiteConfig: cfg,
+		api:        strings.TrimSuffix(cfg.URL, "/"),
+		client:     client,
+		source:     source,
+		lock:       sync.Mutex{},
+		token:      "",
+		fetched:    time.Time{},
+		status:     SiteStatus{Name: cfg.Name, URL: cfg.URL, Status: StatusWarming},
+	}
+
+	return s, nil
+}
+
+// bearer returns the token of the site, fetched again once it is old.
+func (s *site) bearer(ctx context.Context) (string, error) {
+	if s.source == nil {
+		return "", nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.token != "" && time.Since(s.fetched) < siteTokenTTL {
+		return s.token, nil
+	}
+
+	secret, err := s.source.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.token, s.fetched = secret.Value, time.Now()
+
+	return s.token, nil
+}
+
+// call sends the body as JSON to the path of the site and returns the
+// status and the body of the response. Responses other than 2xx are
+// returned with an error.
+func (s *site) call(ctx context.Context, method string, path string, body interface{}) (int, []byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.api+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	token, err := s.bearer(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", BearerPrefix+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, siteResponseLimit))
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, data, fmt.Errorf("%w: %s %s", ErrSiteStatus, resp.Status,
+			strings.TrimSpace(string(data)))
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+// observe records the outcome of a request to the site as its health.
+func (s *site) observe(status string, err error, latency time.Duration, now time.Time, resources int) SiteStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.status = SiteStatus{
+		Name:      s.Name,
+		URL:       s.URL,
+		Status:    status,
+		Error:     "",
+		Latency:   latency.Round(time.Millisecond).String(),
+		Checked:   now,
+		Resources: resources,
+	}
+
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+
+	return s.status
+}
+
+// check checks the readiness of the site.
+func (s *site) check(ctx context.Context) SiteStatus {
+	start := time.Now()
+	health := new(Health)
+
+	_, data, err := s.call(ctx, http.MethodGet, "/readyz", nil)
+	if data != nil && json.Unmarshal(data, health) == nil && health.Status != "" {
+		return s.observe(health.Status, err, time.Since(start), start, 0)
+	}
+
+	return s.observe(SiteUnreachable, err, time.Since(start), start, 0)
+}
+
+// statuses returns the health of the sites.
+func (f *federation) statuses() []SiteStatus {
+	statuses := make([]SiteStatus, 0, len(f.sites))
+
+	for _, s := range f.sites {
+		s.lock.Lock()
+		statuses = append(statuses, s.status)
+		s.lock.Unlock()
+	}
+
+	return statuses
+}
+
+// checkSites checks the health of all sites.
+func (f *federation) checkSites(ctx context.Context) []SiteStatus {
+	statuses := make([]SiteStatus, len(f.sites))
+	wg := sync.WaitGroup{}
+
+	for i, s := range f.sites {
+		wg.Add(1)
+
+		go func(i int, s *site) {
+			defer wg.Done()
+
+			statuses[i] = s.check(ctx)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	return statuses
+}
+
+// run checks the health of the sites every interval until the context is
+// done.
+func (f *federation) run(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx)
+	ticker := time.NewTicker(f.interval)
+
+	defer ticker.Stop()
+
+	for {
+		for _, status := range f.checkSites(ctx) {
+			if status.Status != StatusReady {
+				log.Info("federated site not ready", "site", status.Name, "status", status.Status,
+					"error", status.Error)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// query sends the query to all sites and merges their results. The
+// resources are labeled with the site they come from.
+func (f *federation) query(ctx context.Context, factory zebra.ResourceFactory, qr *QueryRequest) *FederatedResult {
+	results := make([]*zebra.ResourceMap, len(f.sites))
+	statuses := make([]SiteStatus, len(f.sites))
+	wg := sync.WaitGroup{}
+
+	for i, s := range f.sites {
+		wg.Add(1)
+
+		go func(i int, s *site) {
+			defer wg.Done()
+
+			start := time.Now()
+			resMap, err := s.query(ctx, factory, qr)
+			status := StatusOK
+
+			if err != nil {
+				status = SiteUnreachable
+				if errors.Is(err, ErrSiteStatus) {
+					status = StatusFailing
+				}
+			}
+
+			count := 0
+			if resMap != nil {
+				count = resourceCount(resMap)
+			}
+
+			results[i] = resMap
+			statuses[i] = s.observe(status, err, time.Since(start), start, count)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	merged := zebra.NewResourceMap(factory)
+	result := &FederatedResult{Resources: merged, Partial: false, Sites: statuses}
+
+	for _, resMap := range results {
+		if resMap == nil {
+			result.Partial = true
+
+			continue
+		}
+
+		_ = applyFunc(resMap, func(r zebra.Resource) error {
+			merged.Add(r, r.GetType())
+
+			return nil
+		})
+	}
+
+	return result
+}
+
+// query returns the resources of the site that match the query, labeled
+// with the site.
+func (s *site) query(ctx context.Context, factory zebra.ResourceFactory, qr *QueryRequest) (*zebra.ResourceMap,
+	error,
+) {
+	_, data, err := s.call(ctx, http.MethodGet, "/api/v1/resources", qr)
+	if err != nil {
+		return nil, err
+	}
+
+	resMap := zebra.NewResourceMap(factory)
+	if err := json.Unmarshal(data, resMap); err != nil {
+		return nil, err
+	}
+
+	decoder := zebra.NewDecoder(factory)
+	labeled := zebra.NewResourceMap(factory)
+
+	err = applyFunc(resMap, func(r zebra.Resource) error {
+		r, err := patchLabels(decoder, r, map[string]string{FederationSiteLabel: s.Name})
+		if err == nil {
+			labeled.Add(r, r.GetType())
+		}
+
+		return err
+	})
+
+	return labeled, err
+}
+
+// find returns the site with the name, or nil.
+func (f *federation) find(name string) *site {
+	for _, s := range f.sites {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// route splits the resources by the site that owns them: the site of their
+// site label, else the site of their namespace, else the site that has a
+// resource with their ID. It returns the resources of each site, the stored
+// versions of the resources that exist and the IDs of the resources no site
+// owns.
+func (f *federation) route(ctx context.Context, factory zebra.ResourceFactory,
+	resMap *zebra.ResourceMap,
+) (map[*site]*zebra.ResourceMap, map[string]zebra.Resource, []string) {
+	owners := map[string]*site{}
+	ids := []string{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		labels := r.GetLabels()
+
+		if s := f.find(labels[FederationSiteLabel]); s != nil {
+			owners[r.GetID()] = s
+		} else {
+			for _, s := range f.sites {
+				if zebra.IsIn(labels["system.group"], s.Namespaces) {
+					owners[r.GetID()] = s
+				}
+			}
+		}
+
+		ids = append(ids, r.GetID())
+
+		return nil
+	})
+
+	stored := map[string]zebra.Resource{}
+	qr := &QueryRequest{IDs: ids, Types: nil, Labels: nil, Properties: nil, Lifecycle: nil, Heartbeat: nil}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for _, s := range f.sites {
+		wg.Add(1)
+
+		go func(s *site) {
+			defer wg.Done()
+
+			existing, err := s.query(ctx, factory, qr)
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			_ = applyFunc(existing, func(r zebra.Resource) error {
+				// The owner of a resource is where it is, unless it moves
+				if owner, ok := owners[r.GetID()]; !ok || owner == s {
+					owners[r.GetID()] = s
+					stored[r.GetID()] = r
+				}
+
+				return nil
+			})
+		}(s)
+	}
+
+	wg.Wait()
+
+	routed := map[*site]*zebra.ResourceMap{}
+	unowned := []string{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		s, ok := owners[r.GetID()]
+		if !ok {
+			unowned = append(unowned, r.GetID())
+
+			return nil
+		}
+
+		if routed[s] == nil {
+			routed[s] = zebra.NewResourceMap(factory)
+		}
+
+		routed[s].Add(r, r.GetType())
+
+		return nil
+	})
+
+	sort.Strings(unowned)
+
+	return routed, stored, unowned
+}
+
+// write sends the resources of each site to it with the method.
+func (f *federation) write(ctx context.Context, method string, path string,
+	routed map[*site]*zebra.ResourceMap,
+) []SiteWrite {
+	writes := []SiteWrite{}
+
+	for _, s := range f.sites {
+		resMap, ok := routed[s]
+		if !ok {
+			continue
+		}
+
+		w := SiteWrite{Site: s.Name, IDs: []string{}, Status: 0, Error: ""}
+
+		_ = applyFunc(resMap, func(r zebra.Resource) error {
+			w.IDs = append(w.IDs, r.GetID())
+
+			return nil
+		})
+
+		sort.Strings(w.IDs)
+
+		code, _, err := s.call(ctx, method, path, resMap)
+		w.Status = code
+
+		if err != nil {
+			w.Error = err.Error()
+		}
+
+		writes = append(writes, w)
+	}
+
+	return writes
+}
+
+func handleFederationSites() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if api.federation == nil {
+			http.Error(res, ErrNoFederation.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		statuses := api.federation.statuses()
+		if req.URL.Query().Get("check") == "true" {
+			statuses = api.federation.checkSites(ctx)
+		}
+
+		writeJSON(ctx, res, statuses)
+	}
+}
+
+// handleFederatedQuery queries all sites. Sites that fail make the result
+// partial, if all of them fail the response is a bad gateway.
+func handleFederatedQuery() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if api.federation == nil {
+			http.Error(res, ErrNoFederation.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		qr := new(QueryRequest)
+		if err := readJSON(ctx, req, qr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := qr.Validate(ctx); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		result := api.federation.query(ctx, api.factory, qr)
+		result.Resources = readableResources(ctx, result.Resources)
+
+		code := http.StatusOK
+
+		if result.Partial {
+			code = http.StatusBadGateway
+
+			for _, s := range result.Sites {
+				if s.Status == StatusOK {
+					code = http.StatusOK
+				}
+			}
+		}
+
+		writeJSONCode(ctx, res, code, result)
+	}
+}
+
+// handleFederatedWrite routes the created, updated or deleted resources to
+// the sites that own them. The user must be allowed to write them on the
+// front end, as the sites only see the service account of the front end.
+func handleFederatedWrite() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if api.federation == nil {
+			http.Error(res, ErrNoFederation.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		resMap := zebra.NewResourceMap(api.factory)
+		if err := readJSON(ctx, req, resMap); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		routed, stored, unowned := api.federation.route(ctx, api.factory, resMap)
+		if len(unowned) != 0 {
+			writeJSONCode(ctx, res, http.StatusUnprocessableEntity,
+				&FederatedWrite{Sites: []SiteWrite{}, Unowned: unowned})
+
+			return
+		}
+
+		if denials := storedDenials(claims, stored, req.Method, resMap); len(denials) != 0 {
+			writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+			return
+		}
+
+		path := "/api/v1/resources"
+		if req.URL.RawQuery != "" {
+			path += "?" + req.URL.RawQuery
+		}
+
+		result := &FederatedWrite{Sites: api.federation.write(ctx, req.Method, path, routed), Unowned: nil}
+		code := http.StatusOK
+
+		for _, w := range result.Sites {
+			action := "federation.write"
+			if req.Method == http.MethodDelete {
+				action = "federation.delete"
+			}
+
+			api.recordAudit(ctx, action, w.Site, fmt.Sprintf("%s: %d", strings.Join(w.IDs, ","), w.Status))
+
+			if w.Error == "" {
+				continue
+			}
+
+			log.Info("federated write failed", "site", w.Site, "error", w.Error)
+
+			if w.Status >= 400 && w.Status < 500 {
+				code = w.Status
+			} else {
+				code = http.StatusBadGateway
+			}
+		}
+
+		writeJSONCode(ctx, res, code, result)
+	}
+}
@@ -0,0 +1,216 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/secrets"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeSite(api *ResourceAPI, token string) *httptest.Server {
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "front", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}},
+		"front@zebra")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != BearerPrefix+token {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		r = r.WithContext(ctx)
+
+		switch {
+		case r.URL.Path == "/readyz":
+			writeJSON(ctx, w, &Health{Status: StatusReady, Checks: nil})
+		case r.URL.Path != "/api/v1/resources":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			handleQuery()(w, r, nil)
+		case r.Method == http.MethodPost:
+			handlePost()(w, r, nil)
+		case r.Method == http.MethodDelete:
+			handleDelete()(w, r, nil)
+		}
+	}))
+}
+
+func federationRequest(api *ResourceAPI, claims *auth.Claims, method string, body interface{},
+) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	req := httptest.NewRequest(method, "/api/v1/federation/resources", bytes.NewReader(payload))
+	rr := httptest.NewRecorder()
+
+	if method == http.MethodGet {
+		handleFederatedQuery()(rr, req.WithContext(ctx), nil)
+	} else {
+		handleFederatedWrite()(rr, req.WithContext(ctx), nil)
+	}
+
+	return rr
+}
+
+func TestNewFederation(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	f, err := newFederation(&FederationConfig{Sites: nil, Timeout: "", CheckInterval: ""})
+	assert.Nil(err)
+	assert.Nil(f)
+
+	east := SiteConfig{Name: "east", URL: "https://east.example.com/", Token: nil, CACert: "", Namespaces: nil}
+	f, err = newFederation(&FederationConfig{Sites: []SiteConfig{east}, Timeout: "", CheckInterval: ""})
+	assert.Nil(err)
+	assert.Equal(DefaultSiteCheck, f.interval)
+	assert.Equal("https://east.example.com", f.sites[0].api)
+	assert.Equal(StatusWarming, f.statuses()[0].Status)
+
+	_, err = newFederation(&FederationConfig{Sites: []SiteConfig{east, east}, Timeout: "", CheckInterval: ""})
+	assert.ErrorIs(err, ErrFederationConfig)
+
+	_, err = newFederation(&FederationConfig{Sites: []SiteConfig{east}, Timeout: "soon", CheckInterval: ""})
+	assert.ErrorIs(err, ErrFederationConfig)
+
+	east.URL = "east.example.com"
+	_, err = newFederation(&FederationConfig{Sites: []SiteConfig{east}, Timeout: "", CheckInterval: ""})
+	assert.ErrorIs(err, ErrWebhookURL)
+}
+
+func TestFederation(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	roots := []string{"test_federation_front", "test_federation_east", "test_federation_west"}
+
+	t.Cleanup(func() {
+		for _, root := range roots {
+			os.RemoveAll(root)
+		}
+	})
+
+	apis := make([]*ResourceAPI, len(roots))
+	for i, root := range roots {
+		apis[i] = NewResourceAPI(store.DefaultFactory())
+		assert.Nil(apis[i].Initialize(root))
+	}
+
+	front, east, west := apis[0], apis[1], apis[2]
+	ctx := context.Background()
+	eastLab := dc.NewLab("east-lab", zebra.Labels{"system.group": "east"})
+	westLab := dc.NewLab("west-lab", zebra.Labels{"system.group": "west"})
+
+	assert.Nil(east.create(ctx, eastLab))
+	assert.Nil(west.create(ctx, westLab))
+
+	eastSrv := fakeSite(east, "east-token")
+	westSrv := fakeSite(west, "west-token")
+
+	defer eastSrv.Close()
+
+	var err error
+
+	front.federation, err = newFederation(&FederationConfig{Sites: []SiteConfig{
+		{
+			Name: "east", URL: eastSrv.URL, CACert: "", Namespaces: []string{"east"},
+			Token: &secrets.Config{Backend: secrets.BackendStatic, Value: "east-token"},
+		},
+		{
+			Name: "west", URL: westSrv.URL, CACert: "", Namespaces: []string{"west"},
+			Token: &secrets.Config{Backend: secrets.BackendStatic, Value: "west-token"},
+		},
+	}, Timeout: "", CheckInterval: ""})
+	assert.Nil(err)
+
+	for _, status := range front.federation.checkSites(ctx) {
+		assert.Equal(StatusReady, status.Status, status.Error)
+	}
+
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	// Queries are answered by all sites, labeled with their site
+	query := &QueryRequest{IDs: nil, Types: []string{"Lab"}, Labels: nil, Properties: nil, Lifecycle: nil, Heartbeat: nil}
+	rr := federationRequest(front, user, http.MethodGet, query)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	result := &FederatedResult{Resources: zebra.NewResourceMap(front.factory), Partial: false, Sites: nil}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.False(result.Partial)
+	assert.Equal(2, resourceCount(result.Resources))
+
+	sites := map[string]string{}
+	_ = applyFunc(result.Resources, func(r zebra.Resource) error {
+		sites[r.GetID()] = r.GetLabels()[FederationSiteLabel]
+
+		return nil
+	})
+	assert.Equal(map[string]string{eastLab.ID: "east", westLab.ID: "west"}, sites)
+
+	// New resources go to the site of their namespace
+	newLab := dc.NewLab("new-lab", zebra.Labels{"system.group": "west"})
+	resMap := zebra.NewResourceMap(front.factory)
+	resMap.Add(newLab, newLab.Type)
+
+	assert.Equal(http.StatusForbidden, federationRequest(front, user, http.MethodPost, resMap).Code)
+
+	rr = federationRequest(front, admin, http.MethodPost, resMap)
+	assert.Equal(http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotNil(findResource(west.Store, newLab.ID))
+	assert.Nil(findResource(east.Store, newLab.ID))
+
+	// Existing resources go to the site that has them
+	eastLab.Labels["owner"] = "alice"
+	resMap = zebra.NewResourceMap(front.factory)
+	resMap.Add(eastLab, eastLab.Type)
+
+	rr = federationRequest(front, admin, http.MethodPost, resMap)
+	assert.Equal(http.StatusOK, rr.Code, rr.Body.String())
+
+	updated := findResource(east.Store, eastLab.ID)
+	assert.Equal("alice", updated.GetLabels()["owner"])
+
+	// Resources no site owns are not written
+	lost := dc.NewLab("lost-lab", zebra.Labels{"system.group": "north"})
+	resMap = zebra.NewResourceMap(front.factory)
+	resMap.Add(lost, lost.Type)
+
+	rr = federationRequest(front, admin, http.MethodPost, resMap)
+	assert.Equal(http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(rr.Body.String(), lost.ID)
+
+	resMap = zebra.NewResourceMap(front.factory)
+	resMap.Add(newLab, newLab.Type)
+
+	rr = federationRequest(front, admin, http.MethodDelete, resMap)
+	assert.Equal(http.StatusOK, rr.Code, rr.Body.String())
+	assert.Nil(findResource(west.Store, newLab.ID))
+
+	// A site that is down makes the result partial
+	westSrv.Close()
+
+	rr = federationRequest(front, user, http.MethodGet, query)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	result = &FederatedResult{Resources: zebra.NewResourceMap(front.factory), Partial: false, Sites: nil}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.True(result.Partial)
+	assert.Equal(1, resourceCount(result.Resources))
+	assert.Equal(SiteUnreachable, result.Sites[1].Status)
+	assert.Equal(StatusOK, front.federation.statuses()[0].Status)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// findFreeLease is the name the resources found free are taken under.
+const findFreeLease = "find-free"
+
+var ErrFindFree = errors.New("find-free requests need a type and a positive count")
+
+// FreeResources are the resources found free for a request, up to its count,
+// ranked by the scoring policy of their type, and, if there were not enough,
+// what blocked the others. Resources set aside for the leases waiting in the
+// queue, which the lease allocator satisfies first, are blocked as allocated
+// to these leases.
+type FreeResources struct {
+	AllocationRequest
+	Free      []string           `json:"free"`
+	Missing   int                `json:"missing"`
+	Blockers  []Blocker          `json:"blockers,omitempty"`
+	Resources *zebra.ResourceMap `json:"resources"`
+}
+
+// findFree returns the resources free now for the request among those the
+// readable function allows: not leased, not set aside for the queued leases,
+// in service, not in maintenance, and healthy, hosts whose agents stopped
+// sending heartbeats included.
+func findFree(resources *zebra.ResourceMap, r AllocationRequest, now time.Time, scoring scoringPolicy,
+	readable func(zebra.Resource) bool,
+) *FreeResources {
+	a := newAllocator(resources, now, scoring)
+	a.heartbeats = true
+
+	// The queued leases have the first pick, those which could be satisfied
+	// now will be by the lease allocator
+	for _, p := range pendingLeases(resources) {
+		if _, missing := reserve(a, p); missing != 0 {
+			a.release(p.ID)
+		}
+	}
+
+	alloc := a.allocateIn(findFreeLease, r, selection{place: "", within: readable, spread: nil, used: nil})
+	found := &FreeResources{
+		AllocationRequest: r,
+		Free:              alloc.Allocated,
+		Missing:           alloc.Missing,
+		Blockers:          alloc.Blockers,
+		Resources:         zebra.NewResourceMap(resources.GetFactory()),
+	}
+
+	for _, id := range alloc.Allocated {
+		if res := a.find(id); res != nil {
+			found.Resources.Add(res, res.GetType())
+		}
+	}
+
+	return found
+}
+
+// handleFindFree returns the resources the user may read that match the
+// request and are free now, without leasing them.
+func handleFindFree() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		r := new(AllocationRequest)
+		if err := readJSON(ctx, req, r); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if r.Type == "" || r.Count <= 0 {
+			http.Error(res, ErrFindFree.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := validateQueries(r.Filters); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		found := findFree(api.view(), *r, time.Now(), api.scoring, func(r zebra.Resource) bool {
+			return claims.Allows(auth.ActionRead, r.GetType(), r.GetLabels())
+		})
+		log.Info("free resources found", "type", r.Type, "count", r.Count, "free", len(found.Free))
+
+		writeJSON(ctx, res, found)
+	}
+}
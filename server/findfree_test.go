@@ -0,0 +1,98 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindFree(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Now()
+	resources, held := simulationResources(assert, now)
+
+	// f's agent stopped sending heartbeats, and a lease waiting in the queue
+	// is next to get one of the GPU labs
+	for _, r := range resources.Resources["Lab"].Resources {
+		if lab, ok := r.(*dc.Lab); ok && lab.Name == "f" {
+			lab.Labels[AgentStatusLabel] = HostOffline
+		}
+	}
+
+	gpu := []zebra.Query{{Op: zebra.MatchEqual, Key: "gpu", Values: []string{"yes"}}}
+	queued := lease.NewLease("bob@zebra", time.Hour, []*lease.ResourceReq{
+		{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: gpu, Resources: nil},
+	})
+	resources.Add(queued, "Lease")
+
+	all := func(zebra.Resource) bool { return true }
+	r := AllocationRequest{Type: "Lab", Group: "labs", Name: "", Count: 3, Filters: nil}
+
+	found := findFree(resources, r, now, nil, all)
+	assert.Len(found.Free, 1)
+	assert.Equal(2, found.Missing)
+	assert.Equal([]Blocker{
+		{Reason: BlockedAllocated, Count: 1, Leases: []string{queued.ID}},
+		{Reason: BlockedLeased, Count: 1, Leases: []string{held.ID}},
+		{Reason: BlockedLifecycle, Count: 1, Leases: nil},
+		{Reason: BlockedUnhealthy, Count: 2, Leases: nil},
+	}, found.Blockers)
+	assert.Equal(found.Free[0], found.Resources.Resources["Lab"].Resources[0].GetID())
+
+	// The leases are not changed, finding again finds the same
+	assert.Equal(found.Free, findFree(resources, r, now, nil, all).Free)
+
+	// Unreadable resources are left out
+	r.Count = 1
+	found = findFree(resources, r, now, nil, func(zebra.Resource) bool { return false })
+	assert.Empty(found.Free)
+	assert.Empty(found.Blockers)
+}
+
+func TestHandleFindFree(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+	assert.Nil(api.Store.Create(dc.NewLab("a", zebra.Labels{"system.group": "labs"})))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "user@zebra", false))
+		rr := httptest.NewRecorder()
+
+		handleFindFree()(rr, httptest.NewRequest("POST", "/api/v1/find-free", strings.NewReader(body)).
+			WithContext(ctx), httprouter.Params{})
+
+		return rr
+	}
+
+	rr := post(`{"type":"Lab","count":2}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	found := new(FreeResources)
+	found.Resources = zebra.NewResourceMap(store.DefaultFactory())
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), found))
+	assert.Len(found.Free, 1)
+	assert.Equal(1, found.Missing)
+	assert.Len(found.Resources.Resources["Lab"].Resources, 1)
+
+	assert.Equal(http.StatusBadRequest, post(`{"type":"Lab"}`).Code)
+	assert.Equal(http.StatusBadRequest, post(`{"count":1}`).Code)
+	assert.Equal(http.StatusBadRequest, post(`{"type":"Lab","count":1,"filters":[{"key":"a","op":"=="}]}`).Code)
+	assert.Equal(http.StatusBadRequest, post(`{`).Code)
+}
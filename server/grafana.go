@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+)
+
+// Metric prefixes of the Grafana datasource. Counts of audit entries and of
+// created resources are bucketed over time, resource counts and utilization
+// are the current values of the store.
+const (
+	MetricAudit       = "audit"
+	MetricCreated     = "created"
+	MetricResources   = "resources"
+	MetricUtilization = "utilization"
+)
+
+// Bucket limits of a Grafana query.
+const (
+	DefaultMaxDataPoints = 1000
+	MaxDataPoints        = 10000
+)
+
+var (
+	ErrGrafanaRange  = errors.New("query range is invalid")
+	ErrGrafanaMetric = errors.New("unknown metric")
+)
+
+// GrafanaRange is the time range of a Grafana query.
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaTarget is a metric requested by Grafana, as a time series or as a
+// table.
+type GrafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+// GrafanaQuery is the body of a SimpleJSON query request.
+type GrafanaQuery struct {
+	Range         GrafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int             `json:"maxDataPoints"`
+	Targets       []GrafanaTarget `json:"targets"`
+}
+
+// GrafanaSeries is a time series with datapoints of [value, unix millis].
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// GrafanaTable is a table response, the rows hold the time in unix millis
+// and the value.
+type GrafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []GrafanaColumn `json:"columns"`
+	Rows    [][2]float64    `json:"rows"`
+}
+
+// GrafanaAnnotationQuery asks for the audit entries of an action, or all
+// entries, in the range.
+type GrafanaAnnotationQuery struct {
+	Range      GrafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type GrafanaAnnotation struct {
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+func (q *GrafanaQuery) Validate(ctx context.Context) error {
+	if q.Range.From.IsZero() || !q.Range.To.After(q.Range.From) {
+		return ErrGrafanaRange
+	}
+
+	return nil
+}
+
+// interval returns the bucket size of the query, it is widened if the range
+// would have more buckets than allowed.
+func (q *GrafanaQuery) interval() time.Duration {
+	points := q.MaxDataPoints
+	if points <= 0 || points > MaxDataPoints {
+		points = DefaultMaxDataPoints
+	}
+
+	span := q.Range.To.Sub(q.Range.From)
+	interval := time.Duration(q.IntervalMs) * time.Millisecond
+
+	if minimum := span / time.Duration(points); interval < minimum {
+		interval = minimum
+	}
+
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	return interval
+}
+
+// grafanaMetrics returns the metric names available for the resources.
+func grafanaMetrics(resources *zebra.ResourceMap, entries []audit.Entry) []string {
+	names := map[string]bool{MetricAudit: true, MetricResources: true}
+
+	for _, typ := range sortedTypes(resources) {
+		names[MetricResources+"."+typ] = true
+		names[MetricCreated+"."+typ] = true
+	}
+
+	for typ := range leaseCounts(resources) {
+		names[MetricUtilization+"."+typ] = true
+	}
+
+	for _, e := range entries {
+		names[MetricAudit+"."+e.Action] = true
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// bucketTimes counts the times in the buckets of the query range.
+func bucketTimes(q *GrafanaQuery, times []time.Time) [][2]float64 {
+	interval := q.interval()
+	buckets := int(q.Range.To.Sub(q.Range.From)/interval) + 1
+	counts := make([]float64, buckets)
+
+	for _, t := range times {
+		if t.Before(q.Range.From) || t.After(q.Range.To) {
+			continue
+		}
+
+		counts[int(t.Sub(q.Range.From)/interval)]++
+	}
+
+	points := make([][2]float64, 0, buckets)
+	for i, c := range counts {
+		points = append(points, [2]float64{c, float64(q.Range.From.Add(time.Duration(i) * interval).UnixMilli())})
+	}
+
+	return points
+}
+
+// grafanaSeries returns the datapoints of the metric for the query.
+func grafanaSeries(q *GrafanaQuery, metric string, resources *zebra.ResourceMap,
+	entries []audit.Entry,
+) ([][2]float64, error) {
+	prefix, arg, _ := strings.Cut(metric, ".")
+	now := float64(q.Range.To.UnixMilli())
+
+	switch prefix {
+	case MetricAudit:
+		times := []time.Time{}
+
+		for _, e := range entries {
+			if arg == "" || e.Action == arg {
+				times = append(times, e.Time)
+			}
+		}
+
+		return bucketTimes(q, times), nil
+	case MetricCreated:
+		times := []time.Time{}
+
+		if list, ok := resources.Resources[arg]; ok {
+			for _, r := range list.Resources {
+				if status := r.GetStatus(); status != nil {
+					times = append(times, status.CreatedTime)
+				}
+			}
+		}
+
+		return bucketTimes(q, times), nil
+	case MetricResources:
+		count := 0
+
+		for typ, list := range resources.Resources {
+			if arg == "" || typ == arg {
+				count += len(list.Resources)
+			}
+		}
+
+		return [][2]float64{{float64(count), now}}, nil
+	case MetricUtilization:
+		utilization := 0.0
+
+		if c, ok := leaseCounts(resources)[arg]; ok {
+			utilization = c.utilization()
+		}
+
+		return [][2]float64{{utilization, now}}, nil
+	}
+
+	return nil, ErrGrafanaMetric
+}
+
+// handleGrafana answers the connection test of the datasource.
+func handleGrafana() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleGrafanaSearch returns the metrics that can be queried.
+func handleGrafanaSearch() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, grafanaMetrics(readableResources(ctx, api.view()), api.Audit.Entries()))
+	}
+}
+
+// handleGrafanaQuery returns the time series or tables of the targets.
+func handleGrafanaQuery() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		q := new(GrafanaQuery)
+		if err := readJSON(ctx, req, q); err != nil || q.Validate(ctx) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("grafana query failed, invalid request")
+
+			return
+		}
+
+		resources := readableResources(ctx, api.view())
+		entries := api.Audit.Entries()
+		results := make([]interface{}, 0, len(q.Targets))
+
+		for _, target := range q.Targets {
+			points, err := grafanaSeries(q, target.Target, resources, entries)
+			if err != nil {
+				http.Error(res, err.Error()+": "+target.Target, http.StatusBadRequest)
+
+				return
+			}
+
+			if target.Type == "table" {
+				results = append(results, &GrafanaTable{
+					Type:    "table",
+					Columns: []GrafanaColumn{{Text: "Time", Type: "time"}, {Text: target.Target, Type: "number"}},
+					Rows:    swapPoints(points),
+				})
+
+				continue
+			}
+
+			results = append(results, &GrafanaSeries{Target: target.Target, Datapoints: points})
+		}
+
+		writeJSON(ctx, res, results)
+	}
+}
+
+// handleGrafanaAnnotations returns the audit entries in the range as
+// annotations.
+func handleGrafanaAnnotations() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		q := new(GrafanaAnnotationQuery)
+		if err := readJSON(ctx, req, q); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		annotations := []GrafanaAnnotation{}
+
+		for _, e := range api.Audit.Entries() {
+			if e.Time.Before(q.Range.From) || e.Time.After(q.Range.To) {
+				continue
+			}
+
+			if q.Annotation.Query != "" && e.Action != q.Annotation.Query {
+				continue
+			}
+
+			tag := e.ActorType
+			if tag == "" {
+				tag = audit.ActorUser
+			}
+
+			annotations = append(annotations, GrafanaAnnotation{
+				Time:  e.Time.UnixMilli(),
+				Title: e.Action,
+				Text:  strings.TrimSpace(e.Actor + " " + e.Resource + " " + e.Detail),
+				Tags:  []string{tag},
+			})
+		}
+
+		writeJSON(ctx, res, annotations)
+	}
+}
+
+// swapPoints turns [value, time] datapoints into [time, value] table rows.
+func swapPoints(points [][2]float64) [][2]float64 {
+	rows := make([][2]float64, 0, len(points))
+	for _, p := range points {
+		rows = append(rows, [2]float64{p[1], p[0]})
+	}
+
+	return rows
+}
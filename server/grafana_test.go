@@ -0,0 +1,157 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrafanaInterval(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	q := &GrafanaQuery{Range: GrafanaRange{From: from, To: from.Add(time.Hour)}, IntervalMs: 60000}
+
+	assert.Equal(time.Minute, q.interval())
+
+	// Too many buckets for the range
+	q.IntervalMs = 1
+	assert.Equal(time.Hour/DefaultMaxDataPoints, q.interval())
+
+	q.MaxDataPoints = 6
+	assert.Equal(10*time.Minute, q.interval())
+
+	assert.Nil(q.Validate(context.Background()))
+
+	q.Range.To = from
+	assert.ErrorIs(q.Validate(context.Background()), ErrGrafanaRange)
+}
+
+func TestGrafanaHandlers(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "grafana_testhandlers"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.Store = store.NewResourceStore(root, store.DefaultFactory())
+
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	leased := makeOwnedLab("user@zebra")
+	leased.Status.Lease = zebra.Leased
+	leased.Status.CreatedTime = from.Add(5 * time.Minute)
+
+	free := makeOwnedLab("")
+	free.Status.CreatedTime = from.Add(25 * time.Minute)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	resources.Add(leased, "Lab")
+	resources.Add(free, "Lab")
+
+	for _, minutes := range []int{1, 2, 15, 59} {
+		assert.Nil(api.Audit.Record(audit.Entry{
+			Time: from.Add(time.Duration(minutes) * time.Minute), Actor: "user@zebra", ActorType: "",
+			Action: "resource.apply", Resource: "r1", Detail: "Lab",
+		}))
+	}
+
+	assert.Nil(api.Audit.Record(audit.Entry{
+		Time: from.Add(time.Minute), Actor: "system", ActorType: audit.ActorSystem,
+		Action: "lease.reap", Resource: "l1", Detail: "",
+	}))
+
+	// The store is not initialized, the metrics are derived from the map
+	assert.Equal([]string{
+		"audit", "audit.lease.reap", "audit.resource.apply", "created.Lab", "resources", "resources.Lab",
+		"utilization.Lab",
+	}, grafanaMetrics(resources, api.Audit.Entries()))
+
+	q := &GrafanaQuery{
+		Range: GrafanaRange{From: from, To: from.Add(time.Hour)}, IntervalMs: int64(20 * time.Minute / time.Millisecond),
+		MaxDataPoints: 100, Targets: nil,
+	}
+
+	points, err := grafanaSeries(q, "audit.resource.apply", resources, api.Audit.Entries())
+	assert.Nil(err)
+	assert.Equal([][2]float64{
+		{3, float64(from.UnixMilli())},
+		{0, float64(from.Add(20 * time.Minute).UnixMilli())},
+		{1, float64(from.Add(40 * time.Minute).UnixMilli())},
+		{0, float64(from.Add(time.Hour).UnixMilli())},
+	}, points)
+
+	points, err = grafanaSeries(q, "audit", resources, api.Audit.Entries())
+	assert.Nil(err)
+	assert.Equal(float64(4), points[0][0])
+
+	points, err = grafanaSeries(q, "created.Lab", resources, nil)
+	assert.Nil(err)
+	assert.Equal(float64(1), points[0][0])
+	assert.Equal(float64(1), points[1][0])
+
+	points, err = grafanaSeries(q, "resources", resources, nil)
+	assert.Nil(err)
+	assert.Equal([][2]float64{{2, float64(from.Add(time.Hour).UnixMilli())}}, points)
+
+	points, err = grafanaSeries(q, "utilization.Lab", resources, nil)
+	assert.Nil(err)
+	assert.Equal(float64(50), points[0][0])
+
+	_, err = grafanaSeries(q, "temperature", resources, nil)
+	assert.ErrorIs(err, ErrGrafanaMetric)
+
+	// The handlers read the store
+	assert.Nil(api.Store.Initialize())
+	assert.Nil(api.Store.Create(leased))
+
+	serve := func(h httprouter.Handle, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", "/api/v1/grafana", strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	assert.Equal(http.StatusOK, serve(handleGrafana(), "").Code)
+	assert.Contains(serve(handleGrafanaSearch(), `{"target": ""}`).Body.String(), `"utilization.Lab"`)
+
+	query := `{"range": {"from": "2022-06-01T00:00:00Z", "to": "2022-06-01T01:00:00Z"}, "intervalMs": 1200000,
+		"targets": [{"target": "audit.resource.apply", "refId": "A"}, {"target": "resources.Lab", "type": "table"}]}`
+
+	rr := serve(handleGrafanaQuery(), query)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	results := []map[string]interface{}{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Equal("audit.resource.apply", results[0]["target"])
+	assert.Equal("table", results[1]["type"])
+
+	assert.Equal(http.StatusBadRequest, serve(handleGrafanaQuery(), strings.Replace(query, "resources.Lab", "x", 1)).Code)
+	assert.Equal(http.StatusBadRequest, serve(handleGrafanaQuery(), `{"range": {}}`).Code)
+
+	rr = serve(handleGrafanaAnnotations(), `{"range": {"from": "2022-06-01T00:00:00Z", "to": "2022-06-01T00:10:00Z"},
+		"annotation": {"name": "reaps", "query": "lease.reap"}}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	annotations := []GrafanaAnnotation{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &annotations))
+	assert.Equal(1, len(annotations))
+	assert.Equal([]string{audit.ActorSystem}, annotations[0].Tags)
+	assert.Equal("system l1", annotations[0].Text)
+}
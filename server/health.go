@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"gojini.dev/web"
+)
+
+// Health states of the server and of its checks. The server is failing if
+// any check fails and warming if any check is still warming up.
+const (
+	StatusOK      = "ok"
+	StatusReady   = "ready"
+	StatusWarming = "warming"
+	StatusFailing = "failing"
+)
+
+// Readiness checks.
+const (
+	CheckStore   = "store"
+	CheckBackend = "backend"
+	CheckIndex   = "index"
+	CheckReplay  = "replay"
+)
+
+// HealthCheck is the result of a single check.
+type HealthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Health is the response of the health endpoints.
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// warmer is implemented by stores that can serve requests before their
+// indexes are built.
+type warmer interface {
+	Warming() bool
+}
+
+// readiness runs the readiness checks: the store is initialized, its
+// storage can be written, its indexes are warm and the logs kept alongside
+// the store have been replayed. The server is ready if all checks are ok.
+func (api *ResourceAPI) readiness() (*Health, bool) {
+	checks := map[string]HealthCheck{
+		CheckStore:   {Status: StatusOK, Detail: ""},
+		CheckBackend: {Status: StatusOK, Detail: ""},
+		CheckIndex:   {Status: StatusOK, Detail: ""},
+		CheckReplay:  {Status: StatusOK, Detail: ""},
+	}
+
+	if api.Store == nil {
+		checks[CheckStore] = HealthCheck{Status: StatusFailing, Detail: "store not initialized"}
+		checks[CheckIndex] = HealthCheck{Status: StatusFailing, Detail: "store not initialized"}
+	} else if w, ok := api.Store.(warmer); ok && w.Warming() {
+		checks[CheckIndex] = HealthCheck{Status: StatusWarming, Detail: "label index is being built"}
+	}
+
+	if err := probe(api.root); err != nil {
+		checks[CheckBackend] = HealthCheck{Status: StatusFailing, Detail: err.Error()}
+	}
+
+	if !api.replayed {
+		checks[CheckReplay] = HealthCheck{Status: StatusFailing, Detail: "logs not replayed"}
+	}
+
+	status := StatusReady
+
+	for _, check := range checks {
+		if check.Status == StatusFailing {
+			status = StatusFailing
+		} else if check.Status == StatusWarming && status == StatusReady {
+			status = StatusWarming
+		}
+	}
+
+	return &Health{Status: status, Checks: checks}, status == StatusReady
+}
+
+// probe checks that a file can be written to the storage root.
+func probe(root string) error {
+	if root == "" {
+		root = "."
+	}
+
+	file, err := os.CreateTemp(root, ".healthz_")
+	if err != nil {
+		return err
+	}
+
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// healthAdapter serves the health of the server, without authentication so
+// that load balancers and Kubernetes can poll it. /healthz reports that the
+// process is alive. /readyz, also served as /healthz/ready, reports the
+// readiness checks and is 503 until the server is ready to take traffic.
+// While the store indexes are warming, reads are served but may be slower
+// and changes wait.
+func healthAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			ctx := req.Context()
+
+			switch req.URL.Path {
+			case "/healthz":
+				writeJSON(ctx, res, &Health{Status: StatusOK, Checks: nil})
+			case "/readyz", "/healthz/ready":
+				writeReadiness(ctx, res)
+			default:
+				callNext(nextHandler, res, req)
+			}
+		})
+	}
+}
+
+func writeReadiness(ctx context.Context, res http.ResponseWriter) {
+	api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	if !ok {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	health, ready := api.readiness()
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeJSONCode(ctx, res, code, health)
+}
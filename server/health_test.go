@@ -0,0 +1,80 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+
+	get := func(ctx context.Context, url string) (*httptest.ResponseRecorder, *Health) {
+		rr := httptest.NewRecorder()
+		healthAdapter()(nil).ServeHTTP(rr, httptest.NewRequest("GET", url, nil).WithContext(ctx))
+
+		health := new(Health)
+		_ = json.Unmarshal(rr.Body.Bytes(), health)
+
+		return rr, health
+	}
+
+	// The process is alive even if the server is not ready
+	rr, health := get(ctx, "/healthz")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(StatusOK, health.Status)
+
+	rr, health = get(ctx, "/readyz")
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(StatusFailing, health.Status)
+	assert.Equal(StatusFailing, health.Checks[CheckStore].Status)
+	assert.Equal(StatusFailing, health.Checks[CheckReplay].Status)
+
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	for _, url := range []string{"/readyz", "/healthz/ready"} {
+		rr, health = get(ctx, url)
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal(StatusReady, health.Status)
+		assert.Equal(4, len(health.Checks))
+	}
+
+	// The storage went away
+	api.root = path.Join(api.root, "gone")
+
+	rr, health = get(ctx, "/readyz")
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(StatusFailing, health.Checks[CheckBackend].Status)
+	assert.NotEmpty(health.Checks[CheckBackend].Detail)
+
+	rr, _ = get(context.Background(), "/readyz")
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+
+	testForward(assert, healthAdapter())
+}
+
+func TestReadinessWarming(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.lazyIndex = true
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	// Once the index is warm, the server is ready
+	assert.Nil(api.Store.Create(makeOwnedLab("user@zebra")))
+
+	health, ready := api.readiness()
+	assert.True(ready)
+	assert.Equal(StatusOK, health.Checks[CheckIndex].Status)
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/events"
+)
+
+// Statuses of the hosts registered by agents, kept in their AgentStatusLabel.
+// A host whose agent stopped sending heartbeats is stale after the stale
+// window and offline after the offline window.
+const (
+	AgentStatusLabel = "agent.status"
+	HostOnline       = "online"
+	HostStale        = "stale"
+	HostOffline      = "offline"
+)
+
+// Default heartbeat windows. Agents register every 15 minutes by default, a
+// host is stale after three missed registrations.
+const (
+	DefaultStaleAfter     = 45 * time.Minute
+	DefaultOfflineAfter   = 4 * time.Hour
+	DefaultHeartbeatCheck = time.Minute
+)
+
+var (
+	ErrHeartbeatConfig = errors.New("heartbeat windows must be positive and the offline window longer than the stale")
+	ErrHostStatus      = errors.New("host status must be online, stale or offline")
+)
+
+// HeartbeatConfig is the heartbeat section of the server configuration: the
+// stale and offline windows and how often hosts are checked, as durations.
+type HeartbeatConfig struct {
+	StaleAfter   string `json:"staleAfter"`
+	OfflineAfter string `json:"offlineAfter"`
+	Interval     string `json:"interval"`
+}
+
+// heartbeats keeps the time of the last heartbeat of each host registered by
+// an agent, by resource ID. If a path is given, the heartbeats are written to
+// that file on every heartbeat so that hosts are not marked stale when the
+// server restarts. The heartbeats are paused while agents can not reach the
+// server, such as while it is read-only.
+type heartbeats struct {
+	lock         sync.Mutex
+	path         string
+	Seen         map[string]time.Time `json:"seen"`
+	staleAfter   time.Duration
+	offlineAfter time.Duration
+	interval     time.Duration
+	paused       time.Time
+}
+
+func newHeartbeats(path string) *heartbeats {
+	return &heartbeats{
+		lock:         sync.Mutex{},
+		path:         path,
+		Seen:         map[string]time.Time{},
+		staleAfter:   DefaultStaleAfter,
+		offlineAfter: DefaultOfflineAfter,
+		interval:     DefaultHeartbeatCheck,
+		paused:       time.Time{},
+	}
+}
+
+// configure sets the windows of the configuration, unset ones keep their
+// defaults.
+func (h *heartbeats) configure(cfg *HeartbeatConfig) error {
+	durations := []*time.Duration{&h.staleAfter, &h.offlineAfter, &h.interval}
+
+	for i, value := range []string{cfg.StaleAfter, cfg.OfflineAfter, cfg.Interval} {
+		if value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return ErrHeartbeatConfig
+		}
+
+		*durations[i] = d
+	}
+
+	if h.offlineAfter <= h.staleAfter {
+		return ErrHeartbeatConfig
+	}
+
+	return nil
+}
+
+// load reads the heartbeats from the backing file, if any.
+func (h *heartbeats) load() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, h)
+}
+
+func (h *heartbeats) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, h.path)
+}
+
+// beat records a heartbeat of the host.
+func (h *heartbeats) beat(id string, now time.Time) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.Seen[id] = now
+
+	return h.save()
+}
+
+// status returns the status of the host at now. Hosts without a heartbeat,
+// such as hosts registered before heartbeats were kept, are given a full
+// window from now.
+func (h *heartbeats) status(id string, now time.Time) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	seen, ok := h.Seen[id]
+	if !ok {
+		h.Seen[id] = now
+		_ = h.save()
+
+		return HostOnline
+	}
+
+	switch since := now.Sub(seen); {
+	case since >= h.offlineAfter:
+		return HostOffline
+	case since >= h.staleAfter:
+		return HostStale
+	}
+
+	return HostOnline
+}
+
+// pause stops the windows of the hosts at now, if they are not stopped
+// already.
+func (h *heartbeats) pause(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.paused.IsZero() {
+		h.paused = now
+	}
+}
+
+// resume restarts the windows of the hosts at now, the heartbeats are moved
+// on by the time they were paused.
+func (h *heartbeats) resume(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.paused.IsZero() {
+		return
+	}
+
+	for id, seen := range h.Seen {
+		h.Seen[id] = seen.Add(now.Sub(h.paused))
+	}
+
+	h.paused = time.Time{}
+	_ = h.save()
+}
+
+// prune forgets the heartbeats of the hosts that are not in the store.
+func (h *heartbeats) prune(hosts map[string]bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	pruned := false
+
+	for id := range h.Seen {
+		if !hosts[id] {
+			delete(h.Seen, id)
+
+			pruned = true
+		}
+	}
+
+	if pruned {
+		_ = h.save()
+	}
+}
+
+// hostEvent returns the event type of a host changing to the status.
+func hostEvent(status string) string {
+	switch status {
+	case HostStale:
+		return events.HostStale
+	case HostOffline:
+		return events.HostOffline
+	}
+
+	return events.HostOnline
+}
+
+// checkHeartbeats marks the hosts registered by agents whose status changed
+// since the last check, records an event for each and returns the number of
+// hosts marked. Hosts are not checked while the server is read-only, which
+// rejects the heartbeats, and their windows do not run meanwhile.
+func (api *ResourceAPI) checkHeartbeats(ctx context.Context, now time.Time) (int, error) {
+	if api.readOnly.enabled() {
+		api.heartbeats.pause(now)
+
+		return 0, nil
+	}
+
+	api.heartbeats.resume(now)
+
+	hosts := map[string]bool{}
+	changed := []*compute.Server{}
+
+	_ = applyFunc(api.Store.QueryType([]string{"Server"}), func(r zebra.Resource) error {
+		server, ok := r.(*compute.Server)
+		if !ok || server.Labels[AgentIDLabel] == "" {
+			return nil
+		}
+
+		hosts[server.ID] = true
+
+		if status := api.heartbeats.status(server.ID, now); server.Labels[AgentStatusLabel] != status {
+			marked := *server
+			marked.Labels = zebra.Labels{}
+
+			for k, v := range server.Labels {
+				marked.Labels.Add(k, v)
+			}
+
+			marked.Labels.Add(AgentStatusLabel, status)
+			changed = append(changed, &marked)
+		}
+
+		return nil
+	})
+
+	api.heartbeats.prune(hosts)
+
+	for i, server := range changed {
+		status := server.Labels[AgentStatusLabel]
+
+		if err := api.create(ctx, server); err != nil {
+			return i, err
+		}
+
+		if err := api.recordEvent(ctx, hostEvent(status), server); err != nil {
+			return i, err
+		}
+
+		api.recordSystemAudit("agent."+status, server.ID, server.Name)
+	}
+
+	return len(changed), nil
+}
+
+// runHeartbeats checks the heartbeats of the hosts every interval until the
+// context is done.
+func (api *ResourceAPI) runHeartbeats(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx)
+	ticker := time.NewTicker(api.heartbeats.interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if marked, err := api.checkHeartbeats(ctx, now); err != nil {
+				log.Error(err, "heartbeat check failed")
+			} else if marked != 0 {
+				log.Info("host statuses changed", "hosts", marked)
+			}
+		}
+	}
+}
+
+// validHostStatuses returns an error if a status is not a host status.
+func validHostStatuses(statuses []string) error {
+	for _, s := range statuses {
+		if s != HostOnline && s != HostStale && s != HostOffline {
+			return ErrHostStatus
+		}
+	}
+
+	return nil
+}
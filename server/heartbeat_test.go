@@ -0,0 +1,198 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/agent"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatConfig(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := newHeartbeats("")
+	assert.Nil(h.configure(&HeartbeatConfig{StaleAfter: "", OfflineAfter: "", Interval: ""}))
+	assert.Equal(DefaultStaleAfter, h.staleAfter)
+
+	assert.Nil(h.configure(&HeartbeatConfig{StaleAfter: "10m", OfflineAfter: "1h", Interval: "30s"}))
+	assert.Equal(10*time.Minute, h.staleAfter)
+	assert.Equal(time.Hour, h.offlineAfter)
+	assert.Equal(30*time.Second, h.interval)
+
+	for _, cfg := range []HeartbeatConfig{
+		{StaleAfter: "soon", OfflineAfter: "", Interval: ""},
+		{StaleAfter: "", OfflineAfter: "", Interval: "-1m"},
+		{StaleAfter: "2h", OfflineAfter: "1h", Interval: ""},
+	} {
+		cfg := cfg
+		assert.ErrorIs(newHeartbeats("").configure(&cfg), ErrHeartbeatConfig)
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	file := path.Join(root, "heartbeats.json")
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	h := newHeartbeats(file)
+	assert.Nil(h.beat("a", now))
+
+	// Heartbeats survive a restart
+	h = newHeartbeats(file)
+	assert.Nil(h.load())
+	assert.Equal(HostOnline, h.status("a", now.Add(time.Minute)))
+	assert.Equal(HostStale, h.status("a", now.Add(DefaultStaleAfter)))
+	assert.Equal(HostOffline, h.status("a", now.Add(DefaultOfflineAfter)))
+
+	// Hosts without heartbeats get a full window
+	assert.Equal(HostOnline, h.status("b", now))
+	assert.Equal(HostStale, h.status("b", now.Add(DefaultStaleAfter)))
+
+	h.prune(map[string]bool{"b": true})
+	assert.Equal(HostOnline, h.status("a", now.Add(DefaultOfflineAfter)))
+
+	assert.Nil(validHostStatuses([]string{HostOnline, HostStale, HostOffline}))
+	assert.ErrorIs(validHostStatuses([]string{"gone"}), ErrHostStatus)
+}
+
+func TestCheckHeartbeats(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_check_heartbeats"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	sa := agentAccount(assert, "lab-agents", "lab-west")
+	facts := &agent.Facts{
+		Hostname:  "lab-host-1",
+		MachineID: "0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f",
+		NICs:      []agent.NIC{{Name: "eno1", MAC: "00:25:b5:00:00:1f", Addresses: []string{"10.1.2.3"}}},
+	}
+
+	assert.Equal(http.StatusCreated, registerAgent(api, sa, facts).Code)
+
+	server := findAgentServer(api.Store, facts.MachineID)
+	assert.Equal(HostOnline, server.Labels[AgentStatusLabel])
+
+	ctx := context.Background()
+	now := time.Now()
+
+	marked, err := api.checkHeartbeats(ctx, now)
+	assert.Nil(err)
+	assert.Equal(0, marked)
+
+	// Servers not registered by agents have no heartbeats
+	manual := compute.NewServer([]string{"FCH2201V0AB", "UCSC-C220-M5SX", "lab-host-2"}, net.ParseIP("10.1.2.9"),
+		zebra.Labels{"system.group": "lab-west"})
+	assert.Nil(api.Store.Create(manual))
+
+	marked, err = api.checkHeartbeats(ctx, now.Add(DefaultStaleAfter))
+	assert.Nil(err)
+	assert.Equal(1, marked)
+	assert.Equal(HostStale, findAgentServer(api.Store, facts.MachineID).Labels[AgentStatusLabel])
+
+	marked, err = api.checkHeartbeats(ctx, now.Add(DefaultStaleAfter+time.Minute))
+	assert.Nil(err)
+	assert.Equal(0, marked)
+
+	marked, err = api.checkHeartbeats(ctx, now.Add(DefaultOfflineAfter))
+	assert.Nil(err)
+	assert.Equal(1, marked)
+
+	// Stale and offline hosts can be queried
+	qr := &QueryRequest{Heartbeat: []string{HostStale, HostOffline}}
+	assert.Nil(qr.Validate(ctx))
+	assert.Equal(1, len(api.query(qr).Resources["Server"].Resources))
+
+	qr = &QueryRequest{Heartbeat: []string{HostOnline}}
+	assert.Nil(api.query(qr).Resources["Server"])
+
+	qr = &QueryRequest{Heartbeat: []string{HostOnline}, Properties: []zebra.Query{{Key: "name", Op: zebra.MatchEqual}}}
+	assert.NotNil(qr.Validate(ctx))
+
+	// The host is back once its agent registers again
+	assert.Equal(http.StatusOK, registerAgent(api, sa, facts).Code)
+	assert.Equal(HostOnline, findAgentServer(api.Store, facts.MachineID).Labels[AgentStatusLabel])
+
+	types := []string{}
+
+	all, _, err := api.Events.Since(0, 0)
+	assert.Nil(err)
+
+	for _, e := range all {
+		if e.Resource == server.ID {
+			types = append(types, e.Type)
+		}
+	}
+
+	assert.Equal([]string{
+		events.Created, events.Updated, events.HostStale, events.Updated, events.HostOffline,
+		events.Updated, events.HostOnline,
+	}, types)
+	assert.Equal(2, len(api.Audit.QueryActorType("system")))
+}
+
+func TestHeartbeatsReadOnly(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	sa := agentAccount(assert, "lab-agents", "lab-west")
+	facts := &agent.Facts{
+		Hostname:  "lab-host-1",
+		MachineID: "0f3c5b7e1d2a4c6b8e9f0a1b2c3d4e5f",
+		NICs:      []agent.NIC{{Name: "eno1", MAC: "00:25:b5:00:00:1f", Addresses: []string{"10.1.2.3"}}},
+	}
+
+	assert.Equal(http.StatusCreated, registerAgent(api, sa, facts).Code)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	marked, err := api.checkHeartbeats(ctx, now)
+	assert.Nil(err)
+	assert.Equal(0, marked)
+
+	// Agents can not reach a read-only server, their hosts are not marked
+	api.readOnly.set(true, "migration", "admin@zebra", now)
+
+	for _, at := range []time.Duration{10 * time.Minute, DefaultStaleAfter, DefaultOfflineAfter} {
+		marked, err = api.checkHeartbeats(ctx, now.Add(at))
+		assert.Nil(err)
+		assert.Equal(0, marked)
+	}
+
+	assert.Equal(HostOnline, findAgentServer(api.Store, facts.MachineID).Labels[AgentStatusLabel])
+
+	// Nor once it is writable again, until they missed the window since
+	api.readOnly.set(false, "", "admin@zebra", now.Add(DefaultOfflineAfter))
+
+	marked, err = api.checkHeartbeats(ctx, now.Add(DefaultOfflineAfter+time.Minute))
+	assert.Nil(err)
+	assert.Equal(0, marked)
+
+	marked, err = api.checkHeartbeats(ctx, now.Add(DefaultOfflineAfter+DefaultStaleAfter))
+	assert.Nil(err)
+	assert.Equal(1, marked)
+	assert.Equal(HostStale, findAgentServer(api.Store, facts.MachineID).Labels[AgentStatusLabel])
+}
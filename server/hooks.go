@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+)
+
+// Names of the built-in validation hooks, which are enabled in the
+// "validation" section of the configuration.
+const (
+	HookReferences = "references"
+	HookIPPool     = "ip-pool"
+	HookRackSpace  = "rack-space"
+)
+
+var (
+	ErrHookName    = errors.New("validation hooks need a unique name and a check")
+	ErrHookUnknown = errors.New("unknown validation hook")
+)
+
+// ValidationConfig names the built-in validation hooks to enable.
+type ValidationConfig struct {
+	Hooks []string `json:"hooks,omitempty"`
+}
+
+// ValidationHook checks an invariant spanning several resources, such as
+// the rack of a server existing, when resources are created or updated. The
+// check is called for every written resource of the types, or of all types
+// if none are given, and returns the violations of the invariant.
+type ValidationHook struct {
+	Name  string
+	Types []string
+	Check func(ctx context.Context, res zebra.Resource, view *HookView) []Violation
+}
+
+// Violation is an invariant a written resource breaks. Hooks set the field
+// and message, the hook and resource are filled in when the hook is run.
+type Violation struct {
+	Hook    string `json:"hook"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// HookView is the store as it would be after a write: the resources of the
+// request replace the stored ones of the same ID.
+type HookView struct {
+	store   zebra.Store
+	request *zebra.ResourceMap
+}
+
+// Find returns the resource with the ID, or nil.
+func (v *HookView) Find(id string) zebra.Resource {
+	for _, l := range v.request.Resources {
+		for _, r := range l.Resources {
+			if r.GetID() == id {
+				return r
+			}
+		}
+	}
+
+	return findResource(v.store, id)
+}
+
+// OfType returns the resources of the type.
+func (v *HookView) OfType(resType string) []zebra.Resource {
+	written := map[string]bool{}
+	resources := []zebra.Resource{}
+
+	if l, ok := v.request.Resources[resType]; ok {
+		for _, r := range l.Resources {
+			written[r.GetID()] = true
+			resources = append(resources, r)
+		}
+	}
+
+	if l, ok := v.store.QueryType([]string{resType}).Resources[resType]; ok {
+		for _, r := range l.Resources {
+			if !written[r.GetID()] {
+				resources = append(resources, r)
+			}
+		}
+	}
+
+	return resources
+}
+
+// RegisterHook adds a validation hook run on every create and update.
+func (api *ResourceAPI) RegisterHook(hook ValidationHook) error {
+	if hook.Name == "" || hook.Check == nil {
+		return ErrHookName
+	}
+
+	for _, h := range api.hooks {
+		if h.Name == hook.Name {
+			return fmt.Errorf("%w: %s", ErrHookName, hook.Name)
+		}
+	}
+
+	api.hooks = append(api.hooks, hook)
+
+	return nil
+}
+
+// registerHooks registers the built-in hooks enabled in the configuration.
+func (api *ResourceAPI) registerHooks(cfg *ValidationConfig) error {
+	builtin := builtinHooks()
+
+	for _, name := range cfg.Hooks {
+		hook, ok := builtin[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrHookUnknown, name)
+		}
+
+		if err := api.RegisterHook(hook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runHooks returns the violations of the resources of the request, ordered
+// by resource and hook.
+func (api *ResourceAPI) runHooks(ctx context.Context, resMap *zebra.ResourceMap) []Violation {
+	view := &HookView{store: api.Store, request: resMap}
+	violations := []Violation{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		for _, hook := range api.hooks {
+			if len(hook.Types) != 0 && !zebra.IsIn(r.GetType(), hook.Types) {
+				continue
+			}
+
+			for _, v := range hook.Check(ctx, r, view) {
+				v.Hook, v.ID, v.Type = hook.Name, r.GetID(), r.GetType()
+				violations = append(violations, v)
+			}
+		}
+
+		return nil
+	})
+
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].ID < violations[j].ID })
+
+	return violations
+}
+
+// guardHooks refuses writes that break the invariants of the hooks, it
+// writes the violations and returns false if there are any.
+func (api *ResourceAPI) guardHooks(res http.ResponseWriter, req *http.Request, resMap *zebra.ResourceMap) bool {
+	ctx := req.Context()
+
+	violations := api.runHooks(ctx, resMap)
+	if len(violations) == 0 {
+		return true
+	}
+
+	writeJSONCode(ctx, res, http.StatusBadRequest, violations)
+
+	return false
+}
+
+func builtinHooks() map[string]ValidationHook {
+	return map[string]ValidationHook{
+		HookReferences: {Name: HookReferences, Types: nil, Check: checkReferences},
+		HookIPPool:     {Name: HookIPPool, Types: nil, Check: checkIPPool},
+		HookRackSpace:  {Name: HookRackSpace, Types: nil, Check: checkRackSpace},
+	}
+}
+
+// checkReferences requires the resources a resource refers to, such as the
+// server of an ESX or the rack of a server, to exist.
+func checkReferences(ctx context.Context, res zebra.Resource, view *HookView) []Violation {
+	violations := []Violation{}
+
+	for _, id := range zebra.References(res) {
+		if view.Find(id) == nil {
+			violations = append(violations, violation("", "refers to missing resource "+id))
+		}
+	}
+
+	return violations
+}
+
+// checkIPPool requires the IP addresses of a resource to belong to the
+// subnets of an IP address pool.
+func checkIPPool(ctx context.Context, res zebra.Resource, view *HookView) []Violation {
+	violations := []Violation{}
+	pools := view.OfType("IPAddressPool")
+
+	for field, ip := range resourceIPs(res) {
+		if !inPool(ip, pools) {
+			violations = append(violations, violation(field, ip.String()+" is not in an IP address pool"))
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+
+	return violations
+}
+
+// resourceIPs returns the IP address fields of the resource, by JSON name.
+func resourceIPs(res zebra.Resource) map[string]net.IP {
+	ips := map[string]net.IP{}
+
+	v := reflect.Indirect(reflect.ValueOf(res))
+	if v.Kind() != reflect.Struct {
+		return ips
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if ip, ok := v.Field(i).Interface().(net.IP); ok && ip != nil {
+			ips[jsonName(v.Type().Field(i))] = ip
+		}
+	}
+
+	return ips
+}
+
+func jsonName(f reflect.StructField) string {
+	name := f.Tag.Get("json")
+	for i, c := range name {
+		if c == ',' {
+			name = name[:i]
+
+			break
+		}
+	}
+
+	if name == "" {
+		return f.Name
+	}
+
+	return name
+}
+
+func inPool(ip net.IP, pools []zebra.Resource) bool {
+	for _, r := range pools {
+		if pool, ok := r.(*network.IPAddressPool); ok {
+			for _, subnet := range pool.Subnets {
+				if subnet.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// checkRackSpace requires the rack of a resource to have the rack units it
+// takes free. Resources take their rackUnits, or one unit if not given, and
+// racks of unknown height have room for all.
+func checkRackSpace(ctx context.Context, res zebra.Resource, view *HookView) []Violation {
+	height, mounted := rackUnits(res)
+	if !mounted {
+		return nil
+	}
+
+	rack, ok := view.Find(res.GetLabels()[zebra.ParentLabel]).(*dc.Rack)
+	if !ok || rack.Units == 0 {
+		return nil
+	}
+
+	used := height
+
+	for _, t := range []string{"Server", "Switch"} {
+		for _, r := range view.OfType(t) {
+			if r.GetID() != res.GetID() && r.GetLabels()[zebra.ParentLabel] == rack.ID {
+				units, _ := rackUnits(r)
+				used += units
+			}
+		}
+	}
+
+	if used <= rack.Units {
+		return nil
+	}
+
+	return []Violation{violation("rackUnits",
+		fmt.Sprintf("rack %s has %d units, %d would be used", rack.ID, rack.Units, used))}
+}
+
+// rackUnits returns the height of a rack mounted resource.
+func rackUnits(res zebra.Resource) (uint32, bool) {
+	v := reflect.Indirect(reflect.ValueOf(res))
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	f := store.FieldByName(v, "rackUnits")
+	if !f.IsValid() || f.Kind() != reflect.Uint32 {
+		return 0, false
+	}
+
+	if units := uint32(f.Uint()); units != 0 {
+		return units, true
+	}
+
+	return 1, true
+}
+
+func violation(field, message string) Violation {
+	return Violation{Hook: "", ID: "", Type: "", Field: field, Message: message}
+}
@@ -0,0 +1,117 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterHook(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+
+	assert.ErrorIs(api.RegisterHook(ValidationHook{Name: "", Types: nil, Check: checkReferences}), ErrHookName)
+	assert.ErrorIs(api.RegisterHook(ValidationHook{Name: "custom", Types: nil, Check: nil}), ErrHookName)
+	assert.Nil(api.RegisterHook(ValidationHook{Name: "custom", Types: nil, Check: checkReferences}))
+	assert.ErrorIs(api.RegisterHook(ValidationHook{Name: "custom", Types: nil, Check: checkReferences}), ErrHookName)
+
+	assert.ErrorIs(api.registerHooks(&ValidationConfig{Hooks: []string{"astrology"}}), ErrHookUnknown)
+	assert.Nil(api.registerHooks(&ValidationConfig{Hooks: []string{HookReferences, HookRackSpace}}))
+	assert.Equal(3, len(api.hooks))
+}
+
+func TestValidationHooks(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+	assert.Nil(api.registerHooks(&ValidationConfig{Hooks: []string{HookReferences, HookIPPool, HookRackSpace}}))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	post := func(resources ...zebra.Resource) (int, []Violation) {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		rr := httptest.NewRecorder()
+		body := resMapJSON(assert, resources...)
+
+		handlePost()(rr, httptest.NewRequest("POST", "/api/v1/resources", strings.NewReader(body)).WithContext(ctx), nil)
+
+		violations := []Violation{}
+		if rr.Code == http.StatusBadRequest {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), &violations))
+		}
+
+		return rr.Code, violations
+	}
+
+	_, subnet, err := net.ParseCIDR("10.1.0.0/24")
+	assert.Nil(err)
+
+	pool := &network.IPAddressPool{
+		BaseResource: *zebra.NewBaseResource("IPAddressPool", zebra.Labels{"system.group": "pools"}),
+		Subnets:      []net.IPNet{*subnet},
+	}
+
+	rack := dc.NewRack("rack", "row", zebra.Labels{"system.group": "racks"})
+	rack.Units = 2
+
+	server := func(ip string, units uint32) *compute.Server {
+		s := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP(ip),
+			zebra.Labels{"system.group": "servers", zebra.ParentLabel: rack.ID})
+		s.RackUnits = units
+
+		return s
+	}
+
+	// The rack and the pool may come in the same request as the server
+	first := server("10.1.0.1", 0)
+	code, violations := post(first)
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal([]string{HookReferences, HookIPPool}, []string{violations[0].Hook, violations[1].Hook})
+	assert.Equal("boardIP", violations[1].Field)
+	assert.Equal(first.ID, violations[0].ID)
+
+	code, _ = post(pool, rack, first)
+	assert.Equal(http.StatusOK, code)
+
+	code, violations = post(server("10.2.0.1", 1))
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal(1, len(violations))
+	assert.Equal(HookIPPool, violations[0].Hook)
+
+	// The rack has one of its two units free
+	code, violations = post(server("10.1.0.2", 2))
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal(HookRackSpace, violations[0].Hook)
+	assert.Equal("rackUnits", violations[0].Field)
+
+	code, _ = post(server("10.1.0.2", 1))
+	assert.Equal(http.StatusOK, code)
+
+	// Updates do not count the old version of the resource
+	first.RackUnits = 1
+	code, _ = post(first)
+	assert.Equal(http.StatusOK, code)
+
+	esx := compute.NewESX("esx", "missing", net.ParseIP("10.1.0.3"), zebra.Labels{"system.group": "servers"})
+	code, violations = post(esx)
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal(Violation{
+		Hook: HookReferences, ID: esx.ID, Type: "ESX", Field: "", Message: "refers to missing resource missing",
+	}, violations[0])
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra/auth"
+)
+
+// ImpersonateHeader names the user an admin makes a request as.
+const ImpersonateHeader = "Impersonate-User"
+
+// impersonate returns the request as made by the user of the Impersonate-User
+// header, so that admins can reproduce what the user sees. Only admins that
+// are users themselves may impersonate, the claims of the admin are kept in
+// the context so that audit entries have both. It writes the response and
+// returns nil if the impersonation is not allowed.
+func impersonate(res http.ResponseWriter, req *http.Request) *http.Request {
+	email := req.Header.Get(ImpersonateHeader)
+	if email == "" {
+		return req
+	}
+
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil
+	}
+
+	if claims.ServiceAccount || !claims.Write(AdminKey) {
+		log.Info("impersonation denied", "user", claims.Email, "as", email)
+		res.WriteHeader(http.StatusForbidden)
+
+		return nil
+	}
+
+	user := findUser(api.Store, email)
+	if user == nil {
+		log.Info("impersonated user not found", "user", claims.Email, "as", email)
+		res.WriteHeader(http.StatusBadRequest)
+
+		return nil
+	}
+
+	log.Info("impersonating user", "user", claims.Email, "as", user.Email)
+
+	ctx = context.WithValue(ctx, ImpersonatorCtxKey, claims)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, auth.NewClaims("zebra", user.Name, user.Role, user.Email))
+
+	return req.Clone(ctx)
+}
+
+// refuseImpersonated writes a forbidden response and returns true if the
+// request is made as another user. The session of the user is not the
+// admin's to refresh or end, and a token refreshed for the user would not
+// name the admin.
+func refuseImpersonated(res http.ResponseWriter, req *http.Request) bool {
+	by := impersonator(req.Context())
+	if by == "" {
+		return false
+	}
+
+	logr.FromContextOrDiscard(req.Context()).Info("impersonated session change denied", "user", by,
+		"path", req.URL.Path)
+	res.WriteHeader(http.StatusForbidden)
+
+	return true
+}
+
+// impersonator returns the email of the admin making the request as another
+// user, or "".
+func impersonator(ctx context.Context) string {
+	if claims, ok := ctx.Value(ImpersonatorCtxKey).(*auth.Claims); ok {
+		return claims.Email
+	}
+
+	return ""
+}
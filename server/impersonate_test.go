@@ -0,0 +1,96 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+	"gojini.dev/web"
+)
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "impersonate_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+	assert.Nil(api.Store.Create(createNewUser("user", "user@zebra", "hash", key.Public())))
+
+	request := func(claims *auth.Claims, as string) (*http.Request, int) {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest("GET", "/api/v1/resources", nil).WithContext(ctx)
+		req.Header.Set(ImpersonateHeader, as)
+		rr := httptest.NewRecorder()
+
+		return impersonate(rr, req), rr.Code
+	}
+
+	// Requests without the header are unchanged
+	admin := makeClaims(assert, "admin@zebra", true)
+	req, _ := request(admin, "")
+	assert.Equal(admin, req.Context().Value(ClaimsCtxKey))
+
+	req, code := request(makeClaims(assert, "other@zebra", false), "user@zebra")
+	assert.Nil(req)
+	assert.Equal(http.StatusForbidden, code)
+
+	req, code = request(admin, "nobody@zebra")
+	assert.Nil(req)
+	assert.Equal(http.StatusBadRequest, code)
+
+	// Admins act as the user and are audited with them
+	req, _ = request(admin, "user@zebra")
+	ctx := req.Context()
+	claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+	assert.True(ok)
+	assert.Equal("user@zebra", claims.Email)
+	assert.False(claims.Write(AdminKey))
+
+	api.recordAudit(ctx, "delete", "r1", "")
+	entries := api.Audit.Query("r1")
+	assert.Equal(1, len(entries))
+	assert.Equal("user@zebra", entries[0].Actor)
+	assert.Equal("admin@zebra", entries[0].Impersonator)
+
+	// Admins can not refresh or end the session of the user
+	for path, adapter := range map[string]web.Adapter{"/refresh": refreshAdapter(), "/logout": logoutAdapter()} {
+		ctx := context.WithValue(ctx, AuthCtxKey, authKey)
+		rr := httptest.NewRecorder()
+
+		adapter(nil).ServeHTTP(rr, httptest.NewRequest("POST", path, nil).WithContext(ctx))
+		assert.Equal(http.StatusForbidden, rr.Code)
+		assert.Empty(rr.Result().Cookies())
+	}
+
+	// Nor decide approval requests as the user
+	api.approvals = newApprovalList(time.Hour)
+	api.extensions = newApprovalList(time.Hour)
+	pending := api.approvals.request(OperationDelete, "admin@zebra", "", []string{"r1"}, nil, time.Now())
+	id := httprouter.Params{{Key: "id", Value: pending.ID}}
+
+	for _, h := range []httprouter.Handle{
+		handleApprove(), handleReject(), handleApproveExtension(), handleRejectExtension(),
+	} {
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", "/api/v1/approvals/"+pending.ID, nil).WithContext(ctx), id)
+		assert.Equal(http.StatusForbidden, rr.Code)
+	}
+
+	assert.Equal(1, len(api.approvals.list(ApprovalPending)))
+}
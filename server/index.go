@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/labelstore"
+	"github.com/project-safari/zebra/metrics"
+)
+
+var ErrIndexAdmin = errors.New("compacting the index requires admin privileges")
+
+var (
+	indexKeys = metrics.Default.Gauge("zebra_label_index_keys",
+		"Label keys in the label index.")
+	indexValues = metrics.Default.Gauge("zebra_label_index_values",
+		"Label values of all keys in the label index.")
+	indexEntries = metrics.Default.Gauge("zebra_label_index_entries",
+		"Resources indexed under all label values.")
+	indexSlots = metrics.Default.Gauge("zebra_label_index_slots",
+		"Slots allocated for the resources indexed under all label values.")
+)
+
+// indexer is implemented by stores with a label index that can be measured
+// and compacted.
+type indexer interface {
+	IndexStats() (labelstore.Stats, bool)
+	CompactIndex() int
+}
+
+// IndexStatus is the size of the label index, before and after a compaction
+// if one was run.
+type IndexStatus struct {
+	Warm      bool              `json:"warm"`
+	Stats     labelstore.Stats  `json:"stats"`
+	Before    *labelstore.Stats `json:"before,omitempty"`
+	Collected int               `json:"collected,omitempty"`
+}
+
+// observeIndex sets the index gauges to the current size of the index.
+func (api *ResourceAPI) observeIndex() {
+	idx, ok := api.Store.(indexer)
+	if !ok {
+		return
+	}
+
+	stats, warm := idx.IndexStats()
+	if !warm {
+		return
+	}
+
+	indexKeys.Set(float64(stats.Keys))
+	indexValues.Set(float64(stats.Values))
+	indexEntries.Set(float64(stats.Entries))
+	indexSlots.Set(float64(stats.Slots))
+}
+
+func indexContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, indexer, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, nil, false
+	}
+
+	idx, ok := api.Store.(indexer)
+	if !ok {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, nil, false
+	}
+
+	return api, claims, idx, true
+}
+
+func handleIndex() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		_, _, idx, ok := indexContext(res, req)
+		if !ok {
+			return
+		}
+
+		stats, warm := idx.IndexStats()
+
+		writeJSON(req.Context(), res, IndexStatus{Warm: warm, Stats: stats})
+	}
+}
+
+// handleCompactIndex collects all empty and oversized buckets of the label
+// index at once, rather than a few with every delete.
+func handleCompactIndex() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, idx, ok := indexContext(res, req)
+		if !ok {
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			http.Error(res, ErrIndexAdmin.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		before, _ := idx.IndexStats()
+		collected := idx.CompactIndex()
+		stats, warm := idx.IndexStats()
+
+		api.observeIndex()
+		api.recordAudit(ctx, "index.compact", "", "")
+		log.Info("label index compacted", "collected", collected, "user", claims.Email)
+
+		writeJSON(ctx, res, IndexStatus{Warm: warm, Stats: stats, Before: &before, Collected: collected})
+	}
+}
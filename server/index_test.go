@@ -0,0 +1,65 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexHandlers(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	for _, color := range []string{"red", "blue"} {
+		assert.Nil(api.Store.Create(dc.NewLab(color, zebra.Labels{"system.group": "labs", "color": color})))
+	}
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/index", nil)
+	handleIndex()(rr, req.WithContext(context.WithValue(ctx, ClaimsCtxKey, user)), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	status := IndexStatus{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.True(status.Warm)
+	assert.Equal(2, status.Stats.Keys)
+	assert.Equal(3, status.Stats.Values)
+	assert.Equal(4, status.Stats.Entries)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/index/compact", nil)
+	handleCompactIndex()(rr, req.WithContext(context.WithValue(ctx, ClaimsCtxKey, user)), nil)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handleCompactIndex()(rr, req.WithContext(context.WithValue(ctx, ClaimsCtxKey, admin)), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(&status.Stats, status.Before)
+
+	entries := api.Audit.Entries()
+	assert.Equal("index.compact", entries[len(entries)-1].Action)
+
+	rr = httptest.NewRecorder()
+	metricsAdapter()(nil).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil).WithContext(ctx))
+	assert.True(strings.Contains(rr.Body.String(), "zebra_label_index_entries"))
+
+	rr = httptest.NewRecorder()
+	handleIndex()(rr, httptest.NewRequest("GET", "/api/v1/index", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -1,4 +1,4 @@
-package main //nolint:testpackage
+package server //nolint:testpackage
 
 import (
 	"os"
@@ -13,15 +13,15 @@ func TestInitCmd(t *testing.T) {
 	assert := assert.New(t)
 
 	os.Args = append([]string{"zebra-server"}, "-c",
-		"../../simulator/zebra-simulator.json",
+		"../simulator/zebra-simulator.json",
 		"init")
 
-	assert.NotNil(execRootCmd())
+	assert.NotNil(Execute())
 
 	os.Args = append([]string{"zebra-server"}, "-c",
-		"../../simulator/zebra-simulator.json",
-		"init", "--user", "../../simulator/admin.yaml", "--password",
+		"../simulator/zebra-simulator.json",
+		"init", "--user", "../simulator/admin.yaml", "--password",
 		"blah", "--auth-key", "blee")
 
-	assert.Nil(execRootCmd())
+	assert.Nil(Execute())
 }
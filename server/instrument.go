@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/metrics"
+	"gojini.dev/web"
+)
+
+// TraceHeader is the response header with the trace ID of a request, which
+// links it to the exemplars of the latency histograms. The trace ID of a W3C
+// traceparent header is kept, requests without one get a new trace ID.
+const (
+	TraceHeader       = "Zebra-Trace-Id"
+	TraceparentHeader = "traceparent"
+)
+
+// MaxNamespaceLabels bounds the namespace label of the request and store
+// metrics, further namespaces are counted as OtherNamespace. Requests
+// without a caller and resources without a namespace have NoNamespace.
+const (
+	MaxNamespaceLabels = 100
+	OtherNamespace     = "other"
+	NoNamespace        = "none"
+)
+
+var (
+	namespaceLimit = metrics.NewLabelLimit(MaxNamespaceLabels, OtherNamespace) //nolint:gochecknoglobals
+
+	requestCount = metrics.Default.Counter("zebra_http_requests_total",
+		"Requests, by route, status code and namespace of the caller.", "method", "route", "code", "namespace")
+	requestDurations = metrics.Default.Histogram("zebra_http_request_duration_seconds",
+		"Request durations, by route and namespace of the caller.", metrics.DefaultBuckets,
+		"method", "route", "namespace")
+	storeWrites = metrics.Default.Counter("zebra_store_writes_total",
+		"Store writes, by operation, resource type and namespace.", "op", "type", "namespace")
+	storeWriteDurations = metrics.Default.Histogram("zebra_store_write_duration_seconds",
+		"Store write durations, by operation and namespace.", metrics.DefaultBuckets, "op", "namespace")
+)
+
+// requestInfo is what the instrumentation learns about a request while it
+// is handled.
+type requestInfo struct {
+	lock      sync.Mutex
+	traceID   string
+	namespace string
+}
+
+func (i *requestInfo) setNamespace(namespace string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.namespace = namespace
+}
+
+func (i *requestInfo) getNamespace() string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.namespace
+}
+
+// traceID returns the trace ID of the traceparent header of the request, or
+// a new trace ID.
+func traceID(req *http.Request) string {
+	parts := strings.Split(req.Header.Get(TraceparentHeader), "-")
+
+	const traceIDLen = 32
+
+	if len(parts) == 4 && len(parts[1]) == traceIDLen && parts[1] != strings.Repeat("0", traceIDLen) {
+		if _, err := hex.DecodeString(parts[1]); err == nil {
+			return strings.ToLower(parts[1])
+		}
+	}
+
+	id := make([]byte, traceIDLen/2) //nolint:gomnd
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// requestTrace returns the trace ID of the request of the context, or "".
+func requestTrace(ctx context.Context) string {
+	if info, ok := ctx.Value(RequestInfoCtxKey).(*requestInfo); ok {
+		return info.traceID
+	}
+
+	return ""
+}
+
+// exemplar returns the exemplar labels linking an observation to the trace
+// of the request of the context, nil if there is none.
+func exemplar(ctx context.Context) map[string]string {
+	if id := requestTrace(ctx); id != "" {
+		return map[string]string{"trace_id": id}
+	}
+
+	return nil
+}
+
+// namespaceLabel returns the bounded metrics label of the namespace.
+func namespaceLabel(namespace string) string {
+	if namespace == "" {
+		return NoNamespace
+	}
+
+	return namespaceLimit.Value(namespace)
+}
+
+// instrumentAdapter counts the requests and observes their durations, by
+// route and the namespace of the caller, with the trace ID of each request
+// as exemplar. The trace ID is returned in the TraceHeader.
+func instrumentAdapter(router *httprouter.Router) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			info := &requestInfo{lock: sync.Mutex{}, traceID: traceID(req), namespace: ""}
+			writer := &statusWriter{ResponseWriter: res, wrote: false, code: 0}
+
+			res.Header().Set(TraceHeader, info.traceID)
+
+			callNext(nextHandler, writer, req.WithContext(context.WithValue(req.Context(), RequestInfoCtxKey, info)))
+
+			code := writer.code
+			if code == 0 {
+				code = http.StatusOK
+			}
+
+			route := routeLabel(router, req)
+			namespace := namespaceLabel(info.getNamespace())
+
+			requestCount.Inc(req.Method, route, strconv.Itoa(code), namespace)
+			requestDurations.ObserveWithExemplar(time.Since(start).Seconds(),
+				map[string]string{"trace_id": info.traceID}, req.Method, route, namespace)
+		})
+	}
+}
+
+// withNamespace notes the namespace of the caller of the authenticated
+// request for its metrics: the namespace of its service account, or the
+// group of its user.
+func withNamespace(req *http.Request) *http.Request {
+	if req == nil {
+		return nil
+	}
+
+	ctx := req.Context()
+
+	info, ok := ctx.Value(RequestInfoCtxKey).(*requestInfo)
+	if !ok {
+		return req
+	}
+
+	if sa, ok := ctx.Value(ServiceAccountCtxKey).(*auth.ServiceAccount); ok {
+		info.setNamespace(sa.Namespace())
+
+		return req
+	}
+
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if apiOK && claimsOK {
+		if user := findUser(api.Store, claims.Email); user != nil {
+			info.setNamespace(user.Labels["system.group"])
+		}
+	}
+
+	return req
+}
+
+// observeWrite records a store write of the resource that started at start.
+func observeWrite(ctx context.Context, op string, res zebra.Resource, start time.Time) {
+	namespace := namespaceLabel(res.GetLabels()["system.group"])
+
+	storeWrites.Inc(op, res.GetType(), namespace)
+	storeWriteDurations.ObserveWithExemplar(time.Since(start).Seconds(), exemplar(ctx), op, namespace)
+}
@@ -0,0 +1,82 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceID(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TraceparentHeader, "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01")
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", traceID(req))
+
+	for _, header := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-xyz-00-01"} {
+		req.Header.Set(TraceparentHeader, header)
+
+		id := traceID(req)
+		assert.Len(id, 32)
+		assert.NotEqual("00000000000000000000000000000000", id)
+	}
+}
+
+func TestInstrumentAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_instrument_adapter"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	key, err := auth.Generate()
+	assert.Nil(err)
+
+	user := createNewUser("instrumented", "instrumented@zebra", "hash", key.Public())
+	user.Labels.Add("system.group", "instrument-tenant")
+	assert.Nil(api.Store.Create(user))
+
+	lab := makeOwnedLab("instrumented@zebra")
+	lab.Labels.Add("system.group", "instrument-lab")
+
+	traces := []string{}
+	next := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		ctx = context.WithValue(ctx, ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "instrumented@zebra", false))
+
+		withNamespace(req.WithContext(ctx))
+		assert.Nil(api.create(ctx, lab))
+
+		traces = append(traces, requestTrace(ctx))
+
+		res.WriteHeader(http.StatusCreated)
+	})
+
+	handler := instrumentAdapter(nil)(next)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/labs", nil))
+
+	assert.Equal(http.StatusCreated, rr.Code)
+	assert.Equal(traces[0], rr.Header().Get(TraceHeader))
+	assert.Equal(float64(1), requestCount.Value("POST", OtherRoute, "201", "instrument-tenant"))
+	assert.Equal(uint64(1), requestDurations.Count("POST", OtherRoute, "instrument-tenant"))
+	assert.Equal(float64(1), storeWrites.Value("create", "Lab", "instrument-lab"))
+
+	// Requests without a caller have no namespace
+	handler = instrumentAdapter(nil)(http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/instrumented", nil))
+	assert.Less(float64(0), requestCount.Value("GET", OtherRoute, "404", NoNamespace))
+}
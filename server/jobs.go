@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/scheduler"
+)
+
+// Tasks that can be scheduled as jobs.
+const (
+	TaskLeaseReaper    = "lease-reaper"
+	TaskLeaseAllocator = "lease-allocator"
+	TaskBackup         = "backup"
+	TaskApprovalExpiry = "approval-expiry"
+	TaskReport         = "report"
+	TaskCompaction     = "store-compaction"
+	TaskWarrantyExpiry = "warranty-expiry"
+	TaskAttachments    = "attachment-retention"
+	TaskCloudImport    = "cloud-import"
+	TaskUtilization    = "utilization-history"
+	TaskDrift          = "drift-alerts"
+)
+
+// DefaultBackupKeep is the number of backups kept if not configured.
+const DefaultBackupKeep = 7
+
+var (
+	ErrUnknownTask = errors.New("unknown job task")
+	ErrJobArg      = errors.New("invalid job argument")
+	ErrJobAdmin    = errors.New("running jobs requires admin privileges")
+)
+
+var (
+	jobRuns = metrics.Default.Counter("zebra_job_runs_total",
+		"Scheduled job runs, by job and result.", "job", "result")
+	jobDurations = metrics.Default.Histogram("zebra_job_duration_seconds",
+		"Scheduled job durations in seconds, by job.", metrics.DefaultBuckets, "job")
+)
+
+// JobConfig is a job of the server configuration: the task to run, a cron
+// expression for when to run it and the arguments of the task.
+type JobConfig struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`
+	Task     string            `json:"task"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// newScheduler returns a scheduler with the configured jobs.
+func newScheduler(api *ResourceAPI, jobs []JobConfig) (*scheduler.Scheduler, error) {
+	sched := scheduler.New()
+
+	for _, cfg := range jobs {
+		task, err := newTask(api, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", cfg.Name, err)
+		}
+
+		if err := sched.Add(cfg.Name, cfg.Schedule, instrument(cfg.Name, task)); err != nil {
+			return nil, fmt.Errorf("job %s: %w", cfg.Name, err)
+		}
+	}
+
+	return sched, nil
+}
+
+func newTask(api *ResourceAPI, cfg JobConfig) (scheduler.Task, error) {
+	switch cfg.Task {
+	case TaskLeaseReaper:
+		return unlessReadOnly(api, func(ctx context.Context) (string, error) {
+			return reapLeases(ctx, api, time.Now())
+		}), nil
+	case TaskLeaseAllocator:
+		return unlessReadOnly(api, func(ctx context.Context) (string, error) {
+			return allocateLeases(ctx, api, time.Now())
+		}), nil
+	case TaskBackup:
+		return backupTask(api, cfg.Args)
+	case TaskReport:
+		return reportTask(api, cfg.Args)
+	case TaskCompaction:
+		return unlessReadOnly(api, func(ctx context.Context) (string, error) { return compactStore(api) }), nil
+	case TaskWarrantyExpiry:
+		return warrantyTask(api, cfg.Args)
+	case TaskAttachments:
+		return attachmentTask(api, cfg.Args)
+	case TaskCloudImport:
+		task, err := cloudImportTask(api, cfg.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		return unlessReadOnly(api, task), nil
+	case TaskUtilization:
+		return func(ctx context.Context) (string, error) { return recordUtilization(api, time.Now()) }, nil
+	case TaskDrift:
+		return func(ctx context.Context) (string, error) { return api.drift.evaluate(api, time.Now()) }, nil
+	case TaskApprovalExpiry:
+		return func(ctx context.Context) (string, error) {
+			expired := api.expireExtensions(time.Now())
+			if api.approvals == nil {
+				return fmt.Sprintf("expired %d lease extension requests, approval gate disabled", expired), nil
+			}
+
+			expired += api.expireApprovals(time.Now())
+
+			return fmt.Sprintf("expired %d approval requests", expired), nil
+		}, nil
+	}
+
+	return nil, ErrUnknownTask
+}
+
+// instrument counts the runs of the task and records their durations.
+func instrument(name string, task scheduler.Task) scheduler.Task {
+	return func(ctx context.Context) (string, error) {
+		start := time.Now()
+		result, err := task(ctx)
+
+		jobDurations.Observe(time.Since(start).Seconds(), name)
+
+		if err != nil {
+			jobRuns.Inc(name, "failure")
+		} else {
+			jobRuns.Inc(name, "success")
+		}
+
+		return result, err
+	}
+}
+
+// reapLeases deactivates the active leases that expired and frees the
+// resources they held, unless the policy of their namespace renews them.
+func reapLeases(ctx context.Context, api *ResourceAPI, now time.Time) (string, error) {
+	reaped, renewed := 0, 0
+	pending := pendingLeases(api.view())
+
+	err := applyFunc(api.Store.QueryType([]string{"Lease"}), func(r zebra.Resource) error {
+		l, ok := r.(*lease.Lease)
+		if !ok || l.Status.State != zebra.Active || now.Before(l.Expiry()) {
+			return nil
+		}
+
+		if ok, err := renewLease(ctx, api, l, pending); ok || err != nil {
+			if ok {
+				renewed++
+			}
+
+			return err
+		}
+
+		if _, err := endLease(ctx, api, l); err != nil {
+			return err
+		}
+
+		api.recordSystemAudit("lease.reap", l.ID, l.Owner())
+		reaped++
+
+		return nil
+	})
+
+	return fmt.Sprintf("reaped %d leases, renewed %d leases", reaped, renewed), err
+}
+
+// releaseResource frees the resource if it is still leased by the owner.
+func releaseResource(ctx context.Context, api *ResourceAPI, resID string, owner string) error {
+	stored := findResource(api.Store, resID)
+	if stored == nil {
+		return nil
+	}
+
+	if status := stored.GetStatus(); status == nil || status.Lease != zebra.Leased || status.UsedBy != owner {
+		return nil
+	}
+
+	res, err := copyResource(api.factory, stored)
+	if err != nil {
+		return err
+	}
+
+	status := res.GetStatus()
+
+	status.Lease = zebra.Free
+	status.UsedBy = ""
+
+	return api.create(ctx, res)
+}
+
+// compactStore reclaims the space of deleted and replaced resources in the
+// store, if its format supports it, and collects the label index.
+func compactStore(api *ResourceAPI) (string, error) {
+	result := "store does not support compaction"
+
+	if c, ok := api.Store.(interface{ Compact() (int64, error) }); ok {
+		reclaimed, err := c.Compact()
+		if err != nil {
+			return "", err
+		}
+
+		result = fmt.Sprintf("reclaimed %d bytes", reclaimed)
+	}
+
+	if idx, ok := api.Store.(indexer); ok {
+		result += fmt.Sprintf(", collected %d index buckets", idx.CompactIndex())
+	}
+
+	return result, nil
+}
+
+// backupTask returns a task that writes a snapshot of all resources to the
+// directory of the "dir" argument, keeping the "keep" newest snapshots.
+func backupTask(api *ResourceAPI, args map[string]string) (scheduler.Task, error) {
+	dir := args["dir"]
+	if dir == "" {
+		return nil, ErrJobArg
+	}
+
+	keep := DefaultBackupKeep
+
+	if k, ok := args["keep"]; ok {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 1 {
+			return nil, ErrJobArg
+		}
+
+		keep = n
+	}
+
+	return func(ctx context.Context) (string, error) {
+		return backup(api, dir, keep, time.Now())
+	}, nil
+}
+
+func backup(api *ResourceAPI, dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(api.view())
+	if err != nil {
+		return "", err
+	}
+
+	file := path.Join(dir, "zebra-backup-"+now.UTC().Format("20060102T150405Z")+".json")
+	if err := os.WriteFile(file, data, 0o600); err != nil { //nolint:gomnd
+		return "", err
+	}
+
+	// The timestamps sort the snapshots from oldest to newest
+	snapshots, err := filepath.Glob(path.Join(dir, "zebra-backup-*.json"))
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(snapshots)
+
+	for len(snapshots) > keep {
+		if err := os.Remove(snapshots[0]); err != nil {
+			return "", err
+		}
+
+		snapshots = snapshots[1:]
+	}
+
+	return "wrote " + path.Base(file), nil
+}
+
+// jobScheduler returns the scheduler of the request, it writes the error
+// response if there is none.
+func jobScheduler(res http.ResponseWriter, req *http.Request) (*scheduler.Scheduler, bool) {
+	api, ok := req.Context().Value(ResourcesCtxKey).(*ResourceAPI)
+	if !ok || api.jobs == nil {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	return api.jobs, true
+}
+
+func handleJobs() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if sched, ok := jobScheduler(res, req); ok {
+			writeJSON(req.Context(), res, sched.Statuses())
+		}
+	}
+}
+
+func handleJob() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		sched, ok := jobScheduler(res, req)
+		if !ok {
+			return
+		}
+
+		status, found := sched.Status(params.ByName("name"))
+		if !found {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(req.Context(), res, status)
+	}
+}
+
+// handleRunJob triggers a run of a job outside of its schedule. The run
+// continues after the response, its outcome shows in the job status.
+func handleRunJob() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		sched, ok := jobScheduler(res, req)
+		if !ok {
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok || !claims.Write(AdminKey) {
+			http.Error(res, ErrJobAdmin.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		name := params.ByName("name")
+
+		// The run must not be cancelled with the request
+		err := sched.Trigger(logr.NewContext(context.Background(), log), name)
+
+		switch {
+		case errors.Is(err, scheduler.ErrJobNotFound):
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		case errors.Is(err, scheduler.ErrJobRunning):
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		}
+
+		api, _ := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		api.recordAudit(ctx, "job.run", name, "")
+		log.Info("job triggered", "job", name, "user", claims.Email)
+
+		status, _ := sched.Status(name)
+		writeJSONCode(ctx, res, http.StatusAccepted, status)
+	}
+}
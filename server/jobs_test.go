@@ -0,0 +1,207 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScheduler(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+
+	sched, err := newScheduler(api, []JobConfig{
+		{Name: "reaper", Schedule: "*/5 * * * *", Task: TaskLeaseReaper, Args: nil},
+		{Name: "nightly", Schedule: "@daily", Task: TaskBackup, Args: map[string]string{"dir": "backups", "keep": "3"}},
+		{Name: "approvals", Schedule: "@hourly", Task: TaskApprovalExpiry, Args: nil},
+	})
+	assert.Nil(err)
+	assert.Equal(3, len(sched.Statuses()))
+
+	status, err := sched.Run(context.Background(), "approvals")
+	assert.Nil(err)
+	assert.Equal("expired 0 lease extension requests, approval gate disabled", status.LastResult)
+	assert.Equal(float64(1), jobRuns.Value("approvals", "success"))
+
+	bad := []JobConfig{
+		{Name: "x", Schedule: "@daily", Task: "defrag", Args: nil},
+		{Name: "x", Schedule: "@sometimes", Task: TaskLeaseReaper, Args: nil},
+		{Name: "x", Schedule: "@daily", Task: TaskBackup, Args: nil},
+		{Name: "x", Schedule: "@daily", Task: TaskBackup, Args: map[string]string{"dir": "b", "keep": "0"}},
+	}
+
+	for _, cfg := range bad {
+		_, err := newScheduler(api, []JobConfig{cfg})
+		assert.NotNil(err)
+	}
+}
+
+func TestCompactStore(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.format = store.FormatSegments
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	sched, err := newScheduler(api, []JobConfig{
+		{Name: "compaction", Schedule: "@daily", Task: TaskCompaction, Args: nil},
+	})
+	assert.Nil(err)
+
+	status, err := sched.Run(context.Background(), "compaction")
+	assert.Nil(err)
+	assert.Equal("reclaimed 0 bytes, collected 0 index buckets", status.LastResult)
+}
+
+func TestReapLeases(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "jobs_testreap"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("user@zebra")
+	lab.Status.Lease = zebra.Leased
+	assert.Nil(api.Store.Create(lab))
+
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "lab", Count: 1, Filters: nil, Resources: nil}
+	assert.Nil(req.Assign(lab))
+
+	l := lease.NewLease("user@zebra", time.Hour, []*lease.ResourceReq{req})
+	assert.Nil(l.Activate())
+	assert.Nil(api.Store.Create(l))
+
+	result, err := reapLeases(context.Background(), api, time.Now())
+	assert.Nil(err)
+	assert.Equal("reaped 0 leases, renewed 0 leases", result)
+
+	result, err = reapLeases(context.Background(), api, time.Now().Add(2*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 1 leases, renewed 0 leases", result)
+
+	reaped, ok := findResource(api.Store, l.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(zebra.Inactive, reaped.Status.State)
+
+	freed := findResource(api.Store, lab.ID)
+	assert.Equal(zebra.Free, freed.GetStatus().Lease)
+	assert.Empty(freed.GetStatus().UsedBy)
+
+	entries := api.Audit.QueryActorType(audit.ActorSystem)
+	assert.Equal(1, len(entries))
+	assert.Equal("lease.reap", entries[0].Action)
+
+	result, err = reapLeases(context.Background(), api, time.Now().Add(2*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 0 leases, renewed 0 leases", result)
+}
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "jobs_testbackup"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(path.Join(root, "store")))
+	assert.Nil(api.Store.Create(makeOwnedLab("user@zebra")))
+
+	dir := path.Join(root, "backups")
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		result, err := backup(api, dir, 3, now.Add(time.Duration(i)*time.Hour))
+		assert.Nil(err)
+		assert.Equal(fmt.Sprintf("wrote zebra-backup-20220601T0%d0000Z.json", i), result)
+	}
+
+	snapshots, err := filepath.Glob(path.Join(dir, "*.json"))
+	assert.Nil(err)
+	assert.Equal(3, len(snapshots))
+	assert.Equal("zebra-backup-20220601T010000Z.json", path.Base(snapshots[0]))
+
+	data, err := os.ReadFile(snapshots[2])
+	assert.Nil(err)
+
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	assert.Nil(json.Unmarshal(data, resMap))
+	assert.Equal(1, len(resMap.Resources["Lab"].Resources))
+}
+
+func TestJobHandlers(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	release := make(chan struct{})
+
+	assert.Nil(api.jobs.Add("report", "@daily", func(ctx context.Context) (string, error) {
+		<-release
+
+		return "done", nil
+	}))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	serve := func(claims interface{}, h httprouter.Handle, method, name string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest(method, "/api/v1/jobs", nil).WithContext(ctx),
+			httprouter.Params{{Key: "name", Value: name}})
+
+		return rr
+	}
+
+	rr := serve(user, handleJobs(), "GET", "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	statuses := []scheduler.Status{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &statuses))
+	assert.Equal("report", statuses[0].Name)
+
+	assert.Equal(http.StatusOK, serve(user, handleJob(), "GET", "report").Code)
+	assert.Equal(http.StatusNotFound, serve(user, handleJob(), "GET", "missing").Code)
+
+	assert.Equal(http.StatusForbidden, serve(user, handleRunJob(), "POST", "report").Code)
+	assert.Equal(http.StatusNotFound, serve(admin, handleRunJob(), "POST", "missing").Code)
+	assert.Equal(http.StatusAccepted, serve(admin, handleRunJob(), "POST", "report").Code)
+	assert.Equal(http.StatusConflict, serve(admin, handleRunJob(), "POST", "report").Code)
+
+	close(release)
+	api.jobs.Wait()
+
+	status, _ := api.jobs.Status("report")
+	assert.Equal("done", status.LastResult)
+	assert.Equal("job.run", api.Audit.Query("report")[0].Action)
+
+	rr = httptest.NewRecorder()
+	handleJobs()(rr, httptest.NewRequest("GET", "/api/v1/jobs", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -32,19 +32,38 @@ func readJSON(ctx context.Context, req *http.Request, data interface{}) error {
 }
 
 func writeJSON(ctx context.Context, res http.ResponseWriter, data interface{}) {
+	writeJSONCode(ctx, res, http.StatusOK, data)
+}
+
+// writeJSONCode writes data as the JSON body of a response with the given
+// status code, or as YAML if the request prefers it.
+func writeJSONCode(ctx context.Context, res http.ResponseWriter, code int, data interface{}) {
 	log := logr.FromContextOrDiscard(ctx)
 
-	bytes, err := json.Marshal(data)
+	format, bytes, err := encodeJSON(ctx, data)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
-	res.Header().Set("Content-Type", "application/json")
-	res.WriteHeader(http.StatusOK)
+	res.Header().Set("Content-Type", format)
+	res.WriteHeader(code)
 
 	if _, err := res.Write(bytes); err != nil {
 		log.Error(err, "error writing response")
 	}
 }
+
+// encodeJSON returns data as JSON, or as YAML if the request prefers it, and
+// the media type of the encoding.
+func encodeJSON(ctx context.Context, data interface{}) (string, []byte, error) {
+	bytes, err := json.Marshal(data)
+	if err == nil && responseFormat(ctx) == MediaYAML {
+		bytes, err = jsonToYAML(bytes)
+
+		return MediaYAML, bytes, err
+	}
+
+	return MediaJSON, bytes, err
+}
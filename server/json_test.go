@@ -1,4 +1,4 @@
-package main //nolint:testpackage
+package server //nolint:testpackage
 
 import (
 	"context"
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/secrets"
+)
+
+// DefaultSecretRefresh is how often keys are fetched again from their
+// backend if not configured.
+const DefaultSecretRefresh = 5 * time.Minute
+
+// CredentialsKeyGrace is how long the previous credentials key still opens
+// credentials after a rotation. The stored credentials are sealed again with
+// the new key as soon as it is fetched.
+const CredentialsKeyGrace = 24 * time.Hour
+
+var ErrSecretsConfig = errors.New("invalid secrets configuration")
+
+// SecretsConfig fetches the auth key, which signs the jwts, from a secret
+// backend such as Vault instead of the authKey of the configuration. The
+// credentials key seals the credentials of resources in the store. Keys are
+// fetched again every RefreshInterval, such as "5m", to pick up rotations.
+// The retired credentials keys only open credentials sealed before a
+// rotation the server missed, such as one while it was down, they are
+// sealed again with the credentials key on startup.
+type SecretsConfig struct {
+	AuthKey                *secrets.Config   `json:"authKey,omitempty"`
+	CredentialsKey         *secrets.Config   `json:"credentialsKey,omitempty"`
+	RetiredCredentialsKeys []*secrets.Config `json:"retiredCredentialsKeys,omitempty"`
+	RefreshInterval        string            `json:"refreshInterval,omitempty"`
+}
+
+// refreshInterval returns how often the keys are fetched again.
+func (cfg *SecretsConfig) refreshInterval() (time.Duration, error) {
+	if cfg.RefreshInterval == "" {
+		return DefaultSecretRefresh, nil
+	}
+
+	d, err := time.ParseDuration(cfg.RefreshInterval)
+	if err != nil || d <= 0 {
+		return 0, ErrSecretsConfig
+	}
+
+	return d, nil
+}
+
+// newAuthKeyring returns the keyring of the auth key, nil if the auth key is
+// not kept in a secret backend. Jwts signed with the previous key are
+// accepted until they expire.
+func newAuthKeyring(ctx context.Context, cfg *SecretsConfig) (*secrets.Keyring, error) {
+	if cfg.AuthKey == nil {
+		return nil, nil
+	}
+
+	source, err := secrets.New(cfg.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets.NewKeyring(ctx, source, auth.TokenDuration, time.Now())
+}
+
+// newCredentialsCipher returns the cipher of the credentials key, nil if
+// credentials are not sealed.
+func newCredentialsCipher(ctx context.Context, cfg *SecretsConfig) (*secrets.Cipher, error) {
+	if cfg.CredentialsKey == nil {
+		return nil, nil
+	}
+
+	source, err := secrets.New(cfg.CredentialsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := secrets.NewKeyring(ctx, source, CredentialsKeyGrace, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	retired := make([]string, 0, len(cfg.RetiredCredentialsKeys))
+
+	for _, c := range cfg.RetiredCredentialsKeys {
+		source, err := secrets.New(c)
+		if err != nil {
+			return nil, err
+		}
+
+		secret, err := source.Fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		retired = append(retired, secret.Value)
+	}
+
+	return secrets.NewCipher(keyring, retired), nil
+}
+
+// refreshAuthKey fetches the auth key again and audits its rotation.
+func (api *ResourceAPI) refreshAuthKey(ctx context.Context) (bool, error) {
+	rotated, err := api.authKeys.Refresh(ctx, time.Now())
+	if err != nil || !rotated {
+		return false, err
+	}
+
+	status := api.authKeys.Status()
+	api.recordSystemAudit("secrets.rotate", "authKey", status.PreviousVersion+" -> "+status.Version)
+	logr.FromContextOrDiscard(ctx).Info("auth key rotated", "version", status.Version)
+
+	return true, nil
+}
+
+// refreshCredentialsKey fetches the credentials key again, audits its
+// rotation and seals the stored credentials with the new key. It returns the
+// number of resources sealed again.
+func (api *ResourceAPI) refreshCredentialsKey(ctx context.Context) (bool, int, error) {
+	keyring := api.credentials.Keyring()
+
+	rotated, err := keyring.Refresh(ctx, time.Now())
+	if err != nil || !rotated {
+		return false, 0, err
+	}
+
+	status := keyring.Status()
+	api.recordSystemAudit("secrets.rotate", "credentialsKey", status.PreviousVersion+" -> "+status.Version)
+
+	resealed := 0
+
+	for _, s := range []zebra.Store{api.Store, api.archive} {
+		if r, ok := s.(interface{ Reseal() (int, error) }); ok {
+			n, err := r.Reseal()
+			resealed += n
+
+			if err != nil {
+				return true, resealed, err
+			}
+		}
+	}
+
+	api.recordSystemAudit("secrets.reseal", "credentialsKey", strconv.Itoa(resealed))
+	logr.FromContextOrDiscard(ctx).Info("credentials key rotated", "version", status.Version, "resealed", resealed)
+
+	return true, resealed, nil
+}
+
+// watchKeys refreshes the keys of secret backends every interval until the
+// context is done. Failed fetches keep the current key.
+func (api *ResourceAPI) watchKeys(ctx context.Context, interval time.Duration) {
+	log := logr.FromContextOrDiscard(ctx)
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if api.authKeys != nil {
+				if _, err := api.refreshAuthKey(ctx); err != nil {
+					log.Error(err, "auth key refresh failed, keeping the current key")
+				}
+			}
+
+			if api.credentials != nil {
+				if _, _, err := api.refreshCredentialsKey(ctx); err != nil {
+					log.Error(err, "credentials key refresh failed")
+				}
+			}
+		}
+	}
+}
+
+// parseJWT returns the claims of the jwt signed with the auth key, or with
+// the previous auth key while it is still accepted after a rotation.
+func (api *ResourceAPI) parseJWT(token string, authKey string) (*auth.Claims, error) {
+	claims, err := auth.FromJWT(token, authKey)
+	if err == nil || api.authKeys == nil {
+		return claims, err
+	}
+
+	if previous := api.authKeys.Previous(time.Now()); previous != "" {
+		if claims, prevErr := auth.FromJWT(token, previous); prevErr == nil {
+			return claims, nil
+		}
+	}
+
+	return nil, err
+}
+
+// handleKeys returns the versions of the auth key, never the key itself,
+// admins only.
+func handleKeys() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, ok := keysContext(res, req, authKeyring)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.authKeys.Status())
+	}
+}
+
+// handleRotateKey fetches the auth key from its backend now rather than at
+// the next refresh, admins only. Rotating the auth key takes these steps:
+//
+//  1. Write a new version of the key to the secret backend.
+//  2. Rotate the key with POST /admin/keys/rotate, or wait for the refresh.
+//  3. Jwts signed with the previous key are accepted until the previousValidUntil
+//     time of GET /admin/keys, refreshing them signs them with the new key.
+//  4. Once that time has passed, destroy the previous version in the backend.
+func handleRotateKey() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := keysContext(res, req, authKeyring)
+		if !ok {
+			return
+		}
+
+		rotated, err := api.refreshAuthKey(ctx)
+		if err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "auth key rotation failed")
+			res.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		api.recordAudit(ctx, "secrets.rotate.request", "authKey", rotationDetail(rotated))
+
+		writeJSON(ctx, res, &struct {
+			Rotated bool           `json:"rotated"`
+			Status  secrets.Status `json:"status"`
+		}{Rotated: rotated, Status: api.authKeys.Status()})
+	}
+}
+
+// handleCredentialsKey returns the versions of the credentials key, never
+// the key itself, admins only.
+func handleCredentialsKey() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, ok := keysContext(res, req, credentialsKeyring)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.credentials.Keyring().Status())
+	}
+}
+
+// handleRotateCredentialsKey fetches the credentials key from its backend
+// now rather than at the next refresh and seals the stored credentials with
+// it, admins only. Rotating the credentials key takes these steps:
+//
+//  1. Write a new version of the key to the secret backend.
+//  2. Rotate the key with POST /admin/keys/credentials/rotate, or wait for
+//     the refresh. The credentials are sealed again with the new key.
+//  3. If the server was down during the rotation, add the previous version
+//     to retiredCredentialsKeys, the credentials are sealed again on startup.
+//  4. Once resealed, destroy the previous version in the backend and remove
+//     it from retiredCredentialsKeys.
+func handleRotateCredentialsKey() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := keysContext(res, req, credentialsKeyring)
+		if !ok {
+			return
+		}
+
+		rotated, resealed, err := api.refreshCredentialsKey(ctx)
+		if err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "credentials key rotation failed")
+			res.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		api.recordAudit(ctx, "secrets.rotate.request", "credentialsKey", rotationDetail(rotated))
+
+		writeJSON(ctx, res, &struct {
+			Rotated  bool           `json:"rotated"`
+			Resealed int            `json:"resealed"`
+			Status   secrets.Status `json:"status"`
+		}{Rotated: rotated, Resealed: resealed, Status: api.credentials.Keyring().Status()})
+	}
+}
+
+func rotationDetail(rotated bool) string {
+	if rotated {
+		return "rotated"
+	}
+
+	return "unchanged"
+}
+
+func authKeyring(api *ResourceAPI) *secrets.Keyring {
+	return api.authKeys
+}
+
+func credentialsKeyring(api *ResourceAPI) *secrets.Keyring {
+	if api.credentials == nil {
+		return nil
+	}
+
+	return api.credentials.Keyring()
+}
+
+// keysContext returns the api of an admin request for the key of the
+// keyring, it writes the error response if there is none.
+func keysContext(res http.ResponseWriter, req *http.Request,
+	keyring func(api *ResourceAPI) *secrets.Keyring,
+) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	switch {
+	case !apiOK || !claimsOK:
+		res.WriteHeader(http.StatusInternalServerError)
+	case !claims.Write(AdminKey):
+		res.WriteHeader(http.StatusForbidden)
+	case keyring(api) == nil:
+		// The key is not kept in a secret backend, the auth key of the
+		// configuration is rotated by a reload
+		res.WriteHeader(http.StatusNotFound)
+	default:
+		return api, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,235 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/secrets"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotatingSource is a secret source whose key is rotated by the test.
+type rotatingSource struct {
+	secret secrets.Secret
+}
+
+func (s *rotatingSource) Fetch(ctx context.Context) (*secrets.Secret, error) {
+	secret := s.secret
+
+	return &secret, nil
+}
+
+func TestNewAuthKeyring(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	cfg := func(key *secrets.Config, interval string) *SecretsConfig {
+		return &SecretsConfig{AuthKey: key, CredentialsKey: nil, RetiredCredentialsKeys: nil, RefreshInterval: interval}
+	}
+
+	keyring, err := newAuthKeyring(ctx, cfg(nil, ""))
+	assert.Nil(err)
+	assert.Nil(keyring)
+
+	_, err = newAuthKeyring(ctx, cfg(&secrets.Config{Backend: "file"}, ""))
+	assert.ErrorIs(err, secrets.ErrBackend)
+
+	_, err = cfg(nil, "0s").refreshInterval()
+	assert.ErrorIs(err, ErrSecretsConfig)
+
+	keyring, err = newAuthKeyring(ctx, cfg(&secrets.Config{Backend: "static", Value: "k"}, ""))
+	assert.Nil(err)
+	assert.Equal("k", keyring.Current())
+
+	interval, err := cfg(nil, "").refreshInterval()
+	assert.Nil(err)
+	assert.Equal(DefaultSecretRefresh, interval)
+}
+
+func TestNewCredentialsCipher(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	cfg := func(key *secrets.Config, retired ...*secrets.Config) *SecretsConfig {
+		return &SecretsConfig{AuthKey: nil, CredentialsKey: key, RetiredCredentialsKeys: retired, RefreshInterval: ""}
+	}
+
+	cipher, err := newCredentialsCipher(ctx, cfg(nil))
+	assert.Nil(err)
+	assert.Nil(cipher)
+
+	_, err = newCredentialsCipher(ctx, cfg(&secrets.Config{Backend: "file"}))
+	assert.ErrorIs(err, secrets.ErrBackend)
+
+	_, err = newCredentialsCipher(ctx, cfg(&secrets.Config{Backend: "static", Value: "k"},
+		&secrets.Config{Backend: "static"}))
+	assert.ErrorIs(err, secrets.ErrConfig)
+
+	old, err := newCredentialsCipher(ctx, cfg(&secrets.Config{Backend: "static", Value: "old"}))
+	assert.Nil(err)
+
+	sealed, err := old.Seal("secret")
+	assert.Nil(err)
+
+	// Credentials of a retired key are opened, to be sealed again
+	cipher, err = newCredentialsCipher(ctx, cfg(&secrets.Config{Backend: "static", Value: "k"},
+		&secrets.Config{Backend: "static", Value: "old"}))
+	assert.Nil(err)
+
+	opened, reseal, err := cipher.Open(sealed)
+	assert.Nil(err)
+	assert.True(reseal)
+	assert.Equal("secret", opened)
+}
+
+func TestRotateKey(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	source := &rotatingSource{secret: secrets.Secret{Value: "one", Version: "1"}}
+	api := NewResourceAPI(store.DefaultFactory())
+
+	serve := func(admin bool, h httprouter.Handle) *httptest.ResponseRecorder {
+		ctx := context.WithValue(ctx, ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "admin@zebra", admin))
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", "/admin/keys/rotate", nil).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	// Auth keys of the configuration are not rotated here
+	assert.Equal(http.StatusNotFound, serve(true, handleKeys()).Code)
+
+	keyring, err := secrets.NewKeyring(ctx, source, auth.TokenDuration, time.Now())
+	assert.Nil(err)
+
+	api.authKeys = keyring
+	assert.Equal(http.StatusForbidden, serve(false, handleRotateKey()).Code)
+
+	claims := auth.NewClaims("zebra", "user", nil, "user@zebra")
+	token := claims.JWT("one")
+
+	source.secret = secrets.Secret{Value: "two", Version: "2"}
+	rr := serve(true, handleRotateKey())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rotation := &struct {
+		Rotated bool           `json:"rotated"`
+		Status  secrets.Status `json:"status"`
+	}{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), rotation))
+	assert.True(rotation.Rotated)
+	assert.Equal("2", rotation.Status.Version)
+	assert.Equal("1", rotation.Status.PreviousVersion)
+
+	// Jwts of the previous key are accepted during the grace period
+	parsed, err := api.parseJWT(token, keyring.Current())
+	assert.Nil(err)
+	assert.Equal("user@zebra", parsed.Email)
+
+	_, err = api.parseJWT(claims.JWT("three"), keyring.Current())
+	assert.NotNil(err)
+
+	entries := api.Audit.Query("authKey")
+	assert.Equal(2, len(entries))
+	assert.Equal("1 -> 2", entries[0].Detail)
+	assert.Equal("secrets.rotate.request", entries[1].Action)
+}
+
+func TestRotateCredentialsKey(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "keys_testcredentials"
+
+	defer func() { os.RemoveAll(root) }()
+
+	ctx := context.Background()
+	source := &rotatingSource{secret: secrets.Secret{Value: "one", Version: "1"}}
+	api := NewResourceAPI(store.DefaultFactory())
+
+	serve := func(admin bool, h httprouter.Handle) *httptest.ResponseRecorder {
+		ctx := context.WithValue(ctx, ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "admin@zebra", admin))
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("POST", "/admin/keys/credentials/rotate", nil).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	// Credentials are not sealed unless there is a credentials key
+	assert.Nil(api.Initialize(root))
+	assert.Equal(http.StatusNotFound, serve(true, handleCredentialsKey()).Code)
+	assert.Equal(http.StatusNotFound, serve(true, handleRotateCredentialsKey()).Code)
+
+	keyring, err := secrets.NewKeyring(ctx, source, CredentialsKeyGrace, time.Now())
+	assert.Nil(err)
+
+	api.credentials = secrets.NewCipher(keyring, nil)
+	assert.Nil(api.Initialize(root))
+
+	srv := compute.NewServer([]string{"SN1", "model", "srv"}, net.IPv4(10, 0, 0, 1),
+		zebra.Labels{"system.group": "servers"})
+	srv.Credentials.ID = srv.ID
+	srv.Credentials.Keys = map[string]string{"password": "Zebra!Passw0rd"}
+	assert.Nil(api.Store.Create(srv))
+
+	assert.Equal(http.StatusForbidden, serve(false, handleRotateCredentialsKey()).Code)
+
+	rr := serve(true, handleRotateCredentialsKey())
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), `"rotated":false`)
+
+	source.secret = secrets.Secret{Value: "two", Version: "2"}
+	rr = serve(true, handleRotateCredentialsKey())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rotation := &struct {
+		Rotated  bool           `json:"rotated"`
+		Resealed int            `json:"resealed"`
+		Status   secrets.Status `json:"status"`
+	}{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), rotation))
+	assert.True(rotation.Rotated)
+	assert.Equal(1, rotation.Resealed)
+	assert.Equal("2", rotation.Status.Version)
+
+	rr = serve(true, handleCredentialsKey())
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), `"previousVersion":"1"`)
+
+	// The credentials are sealed with the new key only
+	next, err := secrets.NewKeyring(ctx, source, CredentialsKeyGrace, time.Now())
+	assert.Nil(err)
+
+	reloaded := NewResourceAPI(store.DefaultFactory())
+	reloaded.credentials = secrets.NewCipher(next, nil)
+	assert.Nil(reloaded.Initialize(root))
+
+	loaded, ok := findResource(reloaded.Store, srv.ID).(*compute.Server)
+	assert.True(ok)
+	assert.Equal("Zebra!Passw0rd", loaded.Credentials.Keys["password"])
+
+	entries := api.Audit.Query("credentialsKey")
+	assert.Equal(4, len(entries))
+	assert.Equal("1 -> 2", entries[1].Detail)
+	assert.Equal("secrets.reseal", entries[2].Action)
+	assert.Equal("1", entries[2].Detail)
+}
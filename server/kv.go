@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/kv"
+)
+
+// KVKey is the privilege key of the key-value store. Privileges scoped to
+// the system.group label of a namespace grant the keys of that namespace.
+const KVKey = "system.kv"
+
+var ErrKVRevision = errors.New("revision must be the revision of the key")
+
+// KVList is the keys of a namespace at a revision of the store.
+type KVList struct {
+	Entries  []kv.Entry `json:"entries"`
+	Revision uint64     `json:"revision"`
+}
+
+// KVChanges is a page of changes of a namespace and the revision to watch
+// for the next page from.
+type KVChanges struct {
+	Changes  []kv.Entry `json:"changes"`
+	Revision uint64     `json:"revision"`
+}
+
+// kvContext returns the API of the request if the claims allow the action
+// on the namespace of the route, or writes the error and returns false.
+// Updates of keys that do not exist yet need the create privilege.
+func kvContext(res http.ResponseWriter, req *http.Request, params httprouter.Params, action auth.Action,
+) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	namespace := params.ByName("namespace")
+	if err := kv.ValidName(namespace); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	if action == auth.ActionUpdate {
+		if _, err := api.kv.Get(namespace, params.ByName("key")); errors.Is(err, kv.ErrNotFound) {
+			action = auth.ActionCreate
+		}
+	}
+
+	if !claims.Allows(action, KVKey, zebra.Labels{"system.group": namespace}) {
+		res.WriteHeader(http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return api, true
+}
+
+// expectedRevision returns the revision parameter of the request, nil if it
+// has none.
+func expectedRevision(req *http.Request) (*uint64, error) {
+	revision := req.URL.Query().Get("revision")
+	if revision == "" {
+		return nil, nil
+	}
+
+	r, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return nil, ErrKVRevision
+	}
+
+	return &r, nil
+}
+
+// handleKVList lists the keys of a namespace. With watch=true, it returns
+// the changes after the since revision instead, waiting for one as events
+// requests do, so that tools can follow a namespace: each response carries
+// the revision of the next request. If the changes after the revision are
+// no longer retained, the client gets 410 Gone and must list the keys again.
+func handleKVList() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := kvContext(res, req, params, auth.ActionRead)
+		if !ok {
+			return
+		}
+
+		namespace := params.ByName("namespace")
+
+		if req.URL.Query().Get("watch") != "true" {
+			entries, revision := api.kv.List(namespace)
+			writeJSON(ctx, res, &KVList{Entries: entries, Revision: revision})
+
+			return
+		}
+
+		watch, err := parseEventParams(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && watch.wait > time.Until(deadline)-eventWaitMargin {
+			watch.wait = time.Until(deadline) - eventWaitMargin
+			if watch.wait < 0 {
+				watch.wait = 0
+			}
+		}
+
+		res.Header().Set("Cache-Control", "no-store")
+
+		timer := time.NewTimer(watch.wait)
+		defer timer.Stop()
+
+		for {
+			changed := api.kv.Changed()
+
+			changes, revision, err := api.kv.Changes(namespace, watch.since)
+			if errors.Is(err, kv.ErrCompacted) {
+				http.Error(res, err.Error(), http.StatusGone)
+
+				return
+			}
+
+			if len(changes) > watch.limit {
+				changes = changes[:watch.limit]
+				revision = changes[len(changes)-1].Revision
+			}
+
+			if len(changes) != 0 || watch.wait == 0 {
+				writeJSON(ctx, res, &KVChanges{Changes: changes, Revision: revision})
+
+				return
+			}
+
+			// Changes of other namespaces move the revision on
+			watch.since = revision
+
+			select {
+			case <-changed:
+			case <-timer.C:
+				watch.wait = 0
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// handleKVGet returns the value of a key.
+func handleKVGet() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, ok := kvContext(res, req, params, auth.ActionRead)
+		if !ok {
+			return
+		}
+
+		e, err := api.kv.Get(params.ByName("namespace"), params.ByName("key"))
+		if err != nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(req.Context(), res, e)
+	}
+}
+
+// handleKVPut sets a key to the JSON value of the body. With a revision
+// parameter, the key is only set if it is at that revision, 0 if it must not
+// exist yet; otherwise the client gets 409 Conflict and the current entry.
+func handleKVPut() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		namespace, key := params.ByName("namespace"), params.ByName("key")
+
+		api, ok := kvContext(res, req, params, auth.ActionUpdate)
+		if !ok {
+			return
+		}
+
+		expected, err := expectedRevision(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		value := json.RawMessage{}
+		if err := readJSON(ctx, req, &value); err != nil {
+			http.Error(res, kv.ErrValue.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		e, err := api.kv.Put(namespace, key, value, actor(ctx), expected)
+		writeKVChange(res, req, api, "kv.put", namespace, key, e, err)
+	}
+}
+
+// handleKVDelete removes a key, only if it is at the revision parameter if
+// there is one.
+func handleKVDelete() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, ok := kvContext(res, req, params, auth.ActionDelete)
+		if !ok {
+			return
+		}
+
+		expected, err := expectedRevision(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		namespace, key := params.ByName("namespace"), params.ByName("key")
+
+		e, err := api.kv.Delete(namespace, key, actor(req.Context()), expected)
+		writeKVChange(res, req, api, "kv.delete", namespace, key, e, err)
+	}
+}
+
+// writeKVChange writes the changed entry, or the error of the change.
+func writeKVChange(res http.ResponseWriter, req *http.Request, api *ResourceAPI, action string,
+	namespace string, key string, e kv.Entry, err error,
+) {
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+
+	switch {
+	case errors.Is(err, kv.ErrKey), errors.Is(err, kv.ErrValue):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, kv.ErrNotFound):
+		res.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, kv.ErrRevision):
+		current, _ := api.kv.Get(namespace, key)
+		writeJSONCode(ctx, res, http.StatusConflict, current)
+	case err != nil:
+		log.Error(err, "key could not be changed", "namespace", namespace, "key", key)
+		res.WriteHeader(http.StatusInternalServerError)
+	default:
+		api.recordAudit(ctx, action, e.Namespace+"/"+e.Key, strconv.FormatUint(e.Revision, 10))
+		writeJSON(ctx, res, e)
+	}
+}
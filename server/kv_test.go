@@ -0,0 +1,107 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/kv"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func kvRequest(assert *assert.Assertions, api *ResourceAPI, claims *auth.Claims, handle httprouter.Handle,
+	method string, target string, body string, params httprouter.Params,
+) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	assert.Nil(err)
+
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+
+	rr := httptest.NewRecorder()
+	handle(rr, req, params)
+
+	return rr
+}
+
+func TestKV(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	key := httprouter.Params{{Key: "namespace", Value: "lab"}, {Key: "key", Value: "campaign"}}
+	ns := key[:1]
+
+	rr := kvRequest(assert, api, user, handleKVPut(), "POST", "/api/v1/kv/lab/campaign", `"spring"`, key)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	rr = kvRequest(assert, api, admin, handleKVPut(), "POST", "/api/v1/kv/lab/campaign", `{`, key)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = kvRequest(assert, api, admin, handleKVPut(), "POST", "/api/v1/kv/lab/campaign?revision=0", `"spring"`, key)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = kvRequest(assert, api, admin, handleKVPut(), "POST", "/api/v1/kv/lab/campaign?revision=0", `"summer"`, key)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	current := kv.Entry{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &current))
+	assert.JSONEq(`"spring"`, string(current.Value))
+	assert.Equal("admin@zebra", current.ModifiedBy)
+
+	rr = kvRequest(assert, api, user, handleKVGet(), "GET", "/api/v1/kv/lab/campaign", "", key)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = kvRequest(assert, api, user, handleKVList(), "GET", "/api/v1/kv/lab", "", ns)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	list := KVList{Entries: nil, Revision: 0}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Equal(1, len(list.Entries))
+	assert.Equal(uint64(1), list.Revision)
+
+	// Watches wait for the next change
+	done := make(chan *httptest.ResponseRecorder)
+
+	go func() {
+		done <- kvRequest(assert, api, user, handleKVList(), "GET", "/api/v1/kv/lab?watch=true&since=1&wait=5s", "", ns)
+	}()
+
+	time.Sleep(10 * time.Millisecond) //nolint:gomnd
+
+	rr = kvRequest(assert, api, admin, handleKVDelete(), "DELETE", "/api/v1/kv/lab/campaign?revision=1", "", key)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = <-done
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("no-store", rr.Header().Get("Cache-Control"))
+
+	changes := KVChanges{Changes: nil, Revision: 0}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &changes))
+	assert.Equal(1, len(changes.Changes))
+	assert.True(changes.Changes[0].Deleted)
+	assert.Equal(uint64(2), changes.Revision)
+
+	rr = kvRequest(assert, api, admin, handleKVGet(), "GET", "/api/v1/kv/lab/campaign", "", key)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = kvRequest(assert, api, admin, handleKVDelete(), "DELETE", "/api/v1/kv/lab/campaign", "", key)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = kvRequest(assert, api, admin, handleKVList(), "GET", "/api/v1/kv/a:b", "",
+		httprouter.Params{{Key: "namespace", Value: "a:b"}})
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"net/http"
@@ -40,10 +40,11 @@ func handleLabels() httprouter.Handle {
 		// added and removed to the resources. For now it a naive
 		// o(n)*o(m) implementation, where n is number of resources
 		// and m is amortized number of labels per resource
+		modified := api.Events.Modified()
 		rMap := api.Store.Query()
 		labelRes.Labels = matchLabels(matchSet, rMap)
 
-		writeJSON(ctx, res, labelRes)
+		writeCachedJSON(ctx, res, req, modified, labelRes)
 	}
 }
 
@@ -0,0 +1,510 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/notify"
+)
+
+// DefaultGracePeriod is the time the owner of a preempted lease has before
+// the lease ends.
+const DefaultGracePeriod = 15 * time.Minute
+
+// DefaultExtensionCeiling is the longest a lease lasts with its extensions.
+const DefaultExtensionCeiling = 24 * time.Hour
+
+// AllTypes among the types that are never preempted disables preemption.
+const AllTypes = "*"
+
+var (
+	ErrGracePeriod   = errors.New("invalid lease grace period")
+	ErrCeiling       = errors.New("invalid lease extension ceiling")
+	ErrLeaseResource = errors.New("allocated resource not found")
+)
+
+// LeaseConfig is the lease policy of the server configuration. Leases of a
+// higher priority preempt leases of a lower priority which hold resources
+// they need, unless those resources are of a type that is never preempted.
+// Preempted leases end after the grace period. Extensions past the maximum
+// lease duration need the approval of an admin, no lease lasts longer than
+// the extension ceiling.
+type LeaseConfig struct {
+	GracePeriod      string   `json:"gracePeriod,omitempty"`
+	NoPreemption     []string `json:"noPreemption,omitempty"`
+	ExtensionCeiling string   `json:"extensionCeiling,omitempty"`
+}
+
+type leasePolicy struct {
+	grace        time.Duration
+	noPreemption map[string]bool
+	ceiling      time.Duration
+
+	// allocating serializes the allocation passes of the job and of lease
+	// requests
+	allocating sync.Mutex
+}
+
+func defaultLeasePolicy() *leasePolicy {
+	return &leasePolicy{
+		grace:        DefaultGracePeriod,
+		noPreemption: map[string]bool{},
+		ceiling:      DefaultExtensionCeiling,
+		allocating:   sync.Mutex{},
+	}
+}
+
+func newLeasePolicy(cfg *LeaseConfig) (*leasePolicy, error) {
+	p := defaultLeasePolicy()
+
+	if cfg.GracePeriod != "" {
+		d, err := parseWithin(cfg.GracePeriod)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrGracePeriod, cfg.GracePeriod)
+		}
+
+		p.grace = d
+	}
+
+	for _, t := range cfg.NoPreemption {
+		p.noPreemption[t] = true
+	}
+
+	if cfg.ExtensionCeiling != "" {
+		d, err := parseWithin(cfg.ExtensionCeiling)
+		if err != nil || d < maxLeaseDuration {
+			return nil, fmt.Errorf("%w: %s", ErrCeiling, cfg.ExtensionCeiling)
+		}
+
+		p.ceiling = d
+	}
+
+	return p, nil
+}
+
+// preemptible returns true if the lease holds no resources of a type that is
+// never preempted.
+func (p *leasePolicy) preemptible(l *lease.Lease) bool {
+	if p.noPreemption[AllTypes] {
+		return false
+	}
+
+	for _, req := range l.RequestList() {
+		for _, res := range req.Resources {
+			if p.noPreemption[res.GetType()] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// pendingLeases returns the leases waiting for resources in the order they
+// are satisfied: by priority, then first come first served.
+func pendingLeases(resources *zebra.ResourceMap) []*lease.Lease {
+	pending := []*lease.Lease{}
+
+	if list, ok := resources.Resources["Lease"]; ok {
+		for _, r := range list.Resources {
+			l, ok := r.(*lease.Lease)
+			if ok && l.Status.State == zebra.Inactive && l.ActivationTime.IsZero() {
+				pending = append(pending, l)
+			}
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return queuedBefore(pending[i], pending[j]) })
+
+	return pending
+}
+
+func queuedBefore(a, b *lease.Lease) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	if !a.Status.CreatedTime.Equal(b.Status.CreatedTime) {
+		return a.Status.CreatedTime.Before(b.Status.CreatedTime)
+	}
+
+	return a.ID < b.ID
+}
+
+func allocationRequest(req *lease.ResourceReq) AllocationRequest {
+	return AllocationRequest{Type: req.Type, Group: req.Group, Name: req.Name, Count: req.Count, Filters: req.Filters}
+}
+
+// allocateLeases ends the preempted leases whose grace period is over and
+// then satisfies the pending leases in order. A pending lease that can not
+// be satisfied preempts the leases of a lower priority that hold what it is
+// missing, and keeps what it could get for when they end.
+func allocateLeases(ctx context.Context, api *ResourceAPI, now time.Time) (string, error) {
+	api.leasePolicy.allocating.Lock()
+	defer api.leasePolicy.allocating.Unlock()
+
+	ended, err := endPreempted(ctx, api, now)
+	if err != nil {
+		return "", err
+	}
+
+	view := api.view()
+	a := newAllocator(view, now, api.scoring)
+	activated, preempted := 0, 0
+
+	for _, p := range pendingLeases(view) {
+		allocs, missing := reserve(a, p)
+
+		if missing == 0 {
+			if err := activateLease(ctx, api, p, allocs); err != nil {
+				return "", err
+			}
+
+			activated++
+
+			continue
+		}
+
+		victims := preemptionVictims(api, a, p, allocs)
+		if victims == nil {
+			a.release(p.ID)
+
+			continue
+		}
+
+		for _, v := range victims {
+			if v.Preemption != nil {
+				continue
+			}
+
+			preemptedLease, err := preemptLease(ctx, api, v, p, now)
+			if err != nil {
+				return "", err
+			}
+
+			a.replace(preemptedLease)
+
+			preempted++
+		}
+	}
+
+	return fmt.Sprintf("activated %d leases, preempted %d leases, ended %d preempted leases",
+		activated, preempted, ended), nil
+}
+
+// activateLease leases the allocated resources to the owner of the lease
+// and activates it.
+func activateLease(ctx context.Context, api *ResourceAPI, l *lease.Lease, allocs []Allocation) error {
+	l, err := copyLease(api.factory, l)
+	if err != nil {
+		return err
+	}
+
+	for i, req := range l.RequestList() {
+		for _, id := range allocs[i].Allocated {
+			stored := findResource(api.Store, id)
+			if stored == nil {
+				return fmt.Errorf("%w: %s", ErrLeaseResource, id)
+			}
+
+			res, err := copyResource(api.factory, stored)
+			if err != nil {
+				return err
+			}
+
+			status := res.GetStatus()
+			status.Lease = zebra.Leased
+			status.UsedBy = l.Owner()
+
+			if err := api.create(ctx, res); err != nil {
+				return err
+			}
+
+			if err := req.Assign(res); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := l.Activate(); err != nil {
+		return err
+	}
+
+	jobs := api.provisionJobs(l, lease.ProvisionGrant)
+
+	if err := api.create(ctx, l); err != nil {
+		return err
+	}
+
+	api.provision(l, lease.ProvisionGrant, jobs)
+	api.recordSystemAudit("lease.activate", l.ID, l.Owner())
+	_ = api.Inbox.Notify(notify.NewNotification(l.Owner(), "lease activated",
+		fmt.Sprintf("lease %s is active until %s", l.ID, l.Expiry().UTC().Format(time.RFC3339)),
+		l.ID))
+
+	return nil
+}
+
+// preemptionVictims returns the active leases of a lower priority that the
+// pending lease preempts to get the resources it is missing, those of the
+// lowest priority and ending last first, or nil if preempting them would not
+// satisfy it. Leases already preempted for the lease count towards it. A
+// lease with placement scopes only preempts leases in the place it has been
+// allocated.
+func preemptionVictims(api *ResourceAPI, a *allocator, p *lease.Lease, allocs []Allocation) []*lease.Lease {
+	victims := []*lease.Lease{}
+	chosen := map[string]bool{}
+
+	for _, alloc := range allocs {
+		if alloc.Missing == 0 {
+			continue
+		}
+
+		if len(p.Placement) != 0 && alloc.Placement == "" {
+			return nil
+		}
+
+		held := map[string][]string{}
+		candidates := []*lease.Lease{}
+
+		for _, res := range a.candidates(alloc.AllocationRequest) {
+			if alloc.Placement != "" && a.placement(res, p.Placement) != alloc.Placement {
+				continue
+			}
+
+			holder := a.holders[res.GetID()]
+			if holder == nil || holder.Priority >= p.Priority || !api.leasePolicy.preemptible(holder) {
+				continue
+			}
+
+			if holder.Preemption != nil && holder.Preemption.By != p.ID {
+				continue
+			}
+
+			if _, ok := held[holder.ID]; !ok {
+				candidates = append(candidates, holder)
+			}
+
+			held[holder.ID] = append(held[holder.ID], res.GetID())
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if (a.Preemption != nil) != (b.Preemption != nil) {
+				return a.Preemption != nil
+			}
+
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+
+			return a.Expiry().After(b.Expiry())
+		})
+
+		freed := 0
+
+		for _, c := range candidates {
+			if freed >= alloc.Missing {
+				break
+			}
+
+			freed += len(held[c.ID])
+
+			if !chosen[c.ID] {
+				chosen[c.ID] = true
+				victims = append(victims, c)
+			}
+		}
+
+		if freed < alloc.Missing {
+			return nil
+		}
+	}
+
+	if len(victims) == 0 {
+		// What blocks the lease is not held by other leases
+		return nil
+	}
+
+	return victims
+}
+
+// preemptLease schedules the end of the lease for the preempting lease,
+// notifies its owner and returns the preempted lease.
+func preemptLease(ctx context.Context, api *ResourceAPI, victim *lease.Lease, by *lease.Lease,
+	now time.Time,
+) (*lease.Lease, error) {
+	victim, err := copyLease(api.factory, victim)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := now.Add(api.leasePolicy.grace)
+	victim.Preempt(by.ID, deadline)
+
+	if err := api.create(ctx, victim); err != nil {
+		return nil, err
+	}
+
+	api.recordSystemAudit("lease.preempt", victim.ID,
+		fmt.Sprintf("by %s until %s", by.ID, deadline.UTC().Format(time.RFC3339)))
+	_ = api.Inbox.Notify(notify.NewNotification(victim.Owner(), "lease preempted",
+		fmt.Sprintf("lease %s is preempted by a lease of %s priority and ends at %s",
+			victim.ID, by.Priority.String(), deadline.UTC().Format(time.RFC3339)), victim.ID))
+
+	return victim, nil
+}
+
+// endPreempted ends the preempted leases whose grace period is over and
+// frees their resources. A preemption stands only while the lease it is for
+// waits, if that lease got its resources otherwise or left the queue the
+// preempted lease goes on.
+func endPreempted(ctx context.Context, api *ResourceAPI, now time.Time) (int, error) {
+	ended := 0
+	waiting := map[string]bool{}
+
+	for _, p := range pendingLeases(api.view()) {
+		waiting[p.ID] = true
+	}
+
+	err := applyFunc(api.Store.QueryType([]string{"Lease"}), func(r zebra.Resource) error {
+		l, ok := r.(*lease.Lease)
+		if !ok || l.Status.State != zebra.Active || l.Preemption == nil {
+			return nil
+		}
+
+		if !waiting[l.Preemption.By] {
+			return cancelPreemption(ctx, api, l)
+		}
+
+		if now.Before(l.Preemption.Deadline) {
+			return nil
+		}
+
+		if _, err := endLease(ctx, api, l); err != nil {
+			return err
+		}
+
+		api.recordSystemAudit("lease.end", l.ID, "preempted by "+l.Preemption.By)
+		_ = api.Inbox.Notify(notify.NewNotification(l.Owner(), "lease ended",
+			fmt.Sprintf("lease %s ended, it was preempted by lease %s", l.ID, l.Preemption.By), l.ID))
+		ended++
+
+		return nil
+	})
+
+	return ended, err
+}
+
+// endLease frees all the resources the lease holds, deactivates it and
+// returns the ended lease.
+func endLease(ctx context.Context, api *ResourceAPI, l *lease.Lease) (*lease.Lease, error) {
+	l, err := copyLease(api.factory, l)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, req := range l.RequestList() {
+		for _, held := range req.Resources {
+			if err := releaseResource(ctx, api, held.GetID(), l.Owner()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	l.Deactivate()
+	jobs := api.provisionJobs(l, lease.ProvisionRelease)
+
+	if err := api.create(ctx, l); err != nil {
+		return nil, err
+	}
+
+	api.provision(l, lease.ProvisionRelease, jobs)
+
+	return l, nil
+}
+
+func cancelPreemption(ctx context.Context, api *ResourceAPI, l *lease.Lease) error {
+	by := l.Preemption.By
+
+	l, err := copyLease(api.factory, l)
+	if err != nil {
+		return err
+	}
+
+	l.Preemption = nil
+
+	if err := api.create(ctx, l); err != nil {
+		return err
+	}
+
+	api.recordSystemAudit("lease.preempt.cancel", l.ID, "lease "+by+" no longer waits")
+	_ = api.Inbox.Notify(notify.NewNotification(l.Owner(), "lease preemption cancelled",
+		fmt.Sprintf("lease %s is no longer preempted and ends as planned", l.ID), l.ID))
+
+	return nil
+}
+
+// QueuedLease is a pending lease and its place in the queue.
+type QueuedLease struct {
+	Position int            `json:"position"`
+	ID       string         `json:"id"`
+	Owner    string         `json:"owner"`
+	Priority lease.Priority `json:"priority"`
+	Created  time.Time      `json:"created"`
+}
+
+// copyLease returns a copy of the lease to change, stored leases are shared
+// with readers.
+func copyLease(factory zebra.ResourceFactory, l *lease.Lease) (*lease.Lease, error) {
+	res, err := copyResource(factory, l)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := res.(*lease.Lease)
+	if !ok {
+		return nil, zebra.ErrTypeMismatch
+	}
+
+	return c, nil
+}
+
+func newQueuedLease(position int, l *lease.Lease) QueuedLease {
+	return QueuedLease{Position: position, ID: l.ID, Owner: l.Owner(), Priority: l.Priority, Created: l.Status.CreatedTime}
+}
+
+// handleLeaseQueue lists the pending leases the user may read, in the order
+// they are satisfied.
+func handleLeaseQueue() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		queue := []QueuedLease{}
+
+		for i, l := range pendingLeases(api.view()) {
+			if claims.Allows(auth.ActionRead, l.GetType(), l.GetLabels()) {
+				queue = append(queue, newQueuedLease(i+1, l))
+			}
+		}
+
+		writeJSON(ctx, res, queue)
+	}
+}
@@ -0,0 +1,264 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLeasePolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p, err := newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: nil, ExtensionCeiling: ""})
+	assert.Nil(err)
+	assert.Equal(DefaultGracePeriod, p.grace)
+
+	p, err = newLeasePolicy(&LeaseConfig{GracePeriod: "1d", NoPreemption: []string{"Lab"}, ExtensionCeiling: ""})
+	assert.Nil(err)
+	assert.Equal(24*time.Hour, p.grace)
+
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}
+	assert.Nil(req.Assign(makeOwnedLab("user@zebra")))
+
+	l := lease.NewLease("user@zebra", time.Hour, []*lease.ResourceReq{req})
+	assert.False(p.preemptible(l))
+
+	p, err = newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: []string{"Server"}, ExtensionCeiling: ""})
+	assert.Nil(err)
+	assert.True(p.preemptible(l))
+
+	p, err = newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: []string{AllTypes}, ExtensionCeiling: ""})
+	assert.Nil(err)
+	assert.False(p.preemptible(l))
+
+	for _, grace := range []string{"soon", "-1h"} {
+		_, err := newLeasePolicy(&LeaseConfig{GracePeriod: grace, NoPreemption: nil, ExtensionCeiling: ""})
+		assert.ErrorIs(err, ErrGracePeriod)
+	}
+}
+
+func pendingLease(owner string, priority lease.Priority, count int) *lease.Lease {
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: count, Filters: nil, Resources: nil}
+	l := lease.NewLease(owner, time.Hour, []*lease.ResourceReq{req})
+	l.Priority = priority
+
+	return l
+}
+
+func TestAllocateLeases(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "leases_testallocate"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	ctx := context.Background()
+	a := dc.NewLab("a", zebra.Labels{"system.group": "labs"})
+	b := dc.NewLab("b", zebra.Labels{"system.group": "labs"})
+
+	// Alice holds a on a low priority lease
+	held := pendingLease("alice@zebra", lease.Low, 1)
+	assert.Nil(held.Request[0].Assign(a))
+	assert.Nil(held.Activate())
+
+	a.Status.Lease = zebra.Leased
+	a.Status.UsedBy = "alice@zebra"
+
+	normal := pendingLease("carol@zebra", lease.Normal, 1)
+	high := pendingLease("bob@zebra", lease.High, 2)
+
+	for _, r := range []zebra.Resource{a, b, held, normal, high} {
+		assert.Nil(api.create(ctx, r))
+	}
+
+	// Bob comes first, takes b and preempts alice for a, carol waits
+	now := time.Now()
+	result, err := allocateLeases(ctx, api, now)
+	assert.Nil(err)
+	assert.Equal("activated 0 leases, preempted 1 leases, ended 0 preempted leases", result)
+
+	preempted, ok := findResource(api.Store, held.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(zebra.Active, preempted.Status.State)
+	assert.Equal(high.ID, preempted.Preemption.By)
+	assert.Equal(now.Add(DefaultGracePeriod), preempted.Preemption.Deadline)
+
+	inbox := api.Inbox.List("alice@zebra")
+	assert.Equal(1, len(inbox))
+	assert.Equal("lease preempted", inbox[0].Subject)
+
+	// Alice is not preempted twice
+	result, err = allocateLeases(ctx, api, now.Add(time.Minute))
+	assert.Nil(err)
+	assert.Equal("activated 0 leases, preempted 0 leases, ended 0 preempted leases", result)
+
+	// After the grace period alice's lease ends and bob gets both labs
+	result, err = allocateLeases(ctx, api, now.Add(DefaultGracePeriod+time.Minute))
+	assert.Nil(err)
+	assert.Equal("activated 1 leases, preempted 0 leases, ended 1 preempted leases", result)
+
+	activated, ok := findResource(api.Store, high.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(zebra.Active, activated.Status.State)
+	assert.True(activated.IsSatisfied())
+
+	for _, id := range []string{a.ID, b.ID} {
+		status := findResource(api.Store, id).GetStatus()
+		assert.Equal(zebra.Leased, status.Lease)
+		assert.Equal("bob@zebra", status.UsedBy)
+	}
+
+	ended, ok := findResource(api.Store, held.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(zebra.Inactive, ended.Status.State)
+
+	// Carol does not preempt a lease of a higher priority
+	assert.Equal([]*lease.Lease{normal}, pendingLeases(api.view()))
+
+	result, err = allocateLeases(ctx, api, now.Add(DefaultGracePeriod+2*time.Minute))
+	assert.Nil(err)
+	assert.Equal("activated 0 leases, preempted 0 leases, ended 0 preempted leases", result)
+}
+
+func TestAllocateLeasesNoPreemption(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "leases_testnopreemption"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	policy, err := newLeasePolicy(&LeaseConfig{GracePeriod: "", NoPreemption: []string{"Lab"}, ExtensionCeiling: ""})
+	assert.Nil(err)
+
+	api.leasePolicy = policy
+
+	ctx := context.Background()
+	lab := makeOwnedLab("alice@zebra")
+	lab.Status.Lease = zebra.Leased
+
+	held := pendingLease("alice@zebra", lease.Low, 1)
+	assert.Nil(held.Request[0].Assign(lab))
+	assert.Nil(held.Activate())
+
+	for _, r := range []zebra.Resource{lab, held, pendingLease("bob@zebra", lease.High, 1)} {
+		assert.Nil(api.create(ctx, r))
+	}
+
+	result, err := allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+	assert.Equal("activated 0 leases, preempted 0 leases, ended 0 preempted leases", result)
+	assert.Empty(api.Inbox.List("alice@zebra"))
+}
+
+func TestLeaseQueue(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize("leases_testqueue"))
+
+	defer func() { os.RemoveAll("leases_testqueue") }()
+
+	low := pendingLease("alice@zebra", lease.Low, 1)
+	first := pendingLease("bob@zebra", lease.Normal, 1)
+	second := pendingLease("carol@zebra", lease.Normal, 1)
+	first.Status.CreatedTime = second.Status.CreatedTime.Add(-time.Second)
+
+	for _, l := range []*lease.Lease{low, second, first} {
+		assert.Nil(api.Store.Create(l))
+	}
+
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "user@zebra", false))
+	rr := httptest.NewRecorder()
+
+	handleLeaseQueue()(rr, httptest.NewRequest("GET", "/api/v1/leases/queue", nil).WithContext(ctx), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	queue := []QueuedLease{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &queue))
+	assert.Equal(3, len(queue))
+	assert.Equal([]string{first.ID, second.ID, low.ID}, []string{queue[0].ID, queue[1].ID, queue[2].ID})
+	assert.Equal(3, queue[2].Position)
+	assert.Equal(lease.Low, queue[2].Priority)
+
+	rr = httptest.NewRecorder()
+	handleLeaseQueue()(rr, httptest.NewRequest("GET", "/api/v1/leases/queue", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+// TestAllocateLeasesConcurrentReads activates and ends leases while the
+// resources are read, for the race detector to catch changes to stored
+// resources.
+func TestAllocateLeasesConcurrentReads(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		assert.Nil(api.create(ctx, dc.NewLab(fmt.Sprint("lab", i), zebra.Labels{"system.group": "labs"})))
+		assert.Nil(api.create(ctx, pendingLease("alice@zebra", lease.Normal, 1)))
+	}
+
+	done := make(chan struct{})
+	reads := sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		reads.Add(1)
+
+		go func() {
+			defer reads.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				_, err := json.Marshal(api.view())
+				assert.Nil(err)
+			}
+		}()
+	}
+
+	result, err := allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+	assert.Equal("activated 8 leases, preempted 0 leases, ended 0 preempted leases", result)
+
+	result, err = reapLeases(ctx, api, time.Now().Add(2*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 8 leases, renewed 0 leases", result)
+
+	close(done)
+	reads.Wait()
+
+	for _, l := range api.Store.QueryType([]string{"Lab"}).Resources["Lab"].Resources {
+		assert.Equal(zebra.Free, l.GetStatus().Lease)
+	}
+}
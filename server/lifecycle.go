@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+var (
+	ErrTransitionRequest = errors.New("lifecycle transition requires a lifecycle stage and a reason")
+	ErrNoStatus          = errors.New("resource has no status to hold a lifecycle")
+)
+
+// LifecycleConfig overrides the allowed lifecycle transitions of resource
+// types, such as {"Server": {"planned": ["active"], ...}}. The types not
+// listed follow the default transitions.
+type LifecycleConfig struct {
+	Types map[string]zebra.Transitions `json:"types,omitempty"`
+}
+
+// newLifecyclePolicy returns the lifecycle policy of the configuration.
+func newLifecyclePolicy(cfg *LifecycleConfig) (*zebra.LifecyclePolicy, error) {
+	policy := zebra.NewLifecyclePolicy()
+
+	for t, transitions := range cfg.Types {
+		policy.Types[t] = transitions
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// TransitionRequest moves a resource to another lifecycle stage, the reason
+// is kept in the audit log with the user who made the change.
+type TransitionRequest struct {
+	Lifecycle zebra.Lifecycle `json:"lifecycle"`
+	Reason    string          `json:"reason"`
+}
+
+func (tr *TransitionRequest) Validate() error {
+	if tr.Lifecycle == "" || strings.TrimSpace(tr.Reason) == "" {
+		return ErrTransitionRequest
+	}
+
+	return tr.Lifecycle.Validate()
+}
+
+// Transition is a change of the lifecycle stage of a resource.
+type Transition struct {
+	Resource string          `json:"resource"`
+	From     zebra.Lifecycle `json:"from,omitempty"`
+	To       zebra.Lifecycle `json:"to"`
+	Reason   string          `json:"reason"`
+	Actor    string          `json:"actor,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// transitionDetail is the audit detail of a transition, from which the
+// transition is read back.
+func transitionDetail(t Transition) string {
+	data, _ := json.Marshal(Transition{
+		Resource: "", From: t.From, To: t.To, Reason: t.Reason, Actor: "", Time: time.Time{},
+	})
+
+	return string(data)
+}
+
+// transitions returns the lifecycle transitions of the resource, oldest
+// first.
+func (api *ResourceAPI) transitions(resID string) []Transition {
+	transitions := []Transition{}
+
+	for _, e := range api.Audit.Query(resID) {
+		if e.Action != "resource.lifecycle" {
+			continue
+		}
+
+		t := Transition{}
+		if json.Unmarshal([]byte(e.Detail), &t) != nil {
+			continue
+		}
+
+		t.Resource = resID
+		t.Actor = e.Actor
+		t.Time = e.Time
+		transitions = append(transitions, t)
+	}
+
+	return transitions
+}
+
+// transition moves a copy of the resource to the lifecycle stage and stores
+// it, the store refuses transitions its policy does not allow.
+func (api *ResourceAPI) transition(req *http.Request, res zebra.Resource, tr *TransitionRequest) (Transition, error) {
+	ctx := req.Context()
+	t := Transition{Resource: res.GetID(), From: "", To: tr.Lifecycle, Reason: tr.Reason, Actor: actor(ctx),
+		Time: time.Now()}
+
+	if res.GetStatus() == nil {
+		return t, ErrNoStatus
+	}
+
+	t.From = res.GetStatus().Lifecycle
+
+	moved, err := copyResource(api.factory, res)
+	if err != nil {
+		return t, err
+	}
+
+	moved.GetStatus().Lifecycle = tr.Lifecycle
+
+	if err := api.create(ctx, moved); err != nil {
+		return t, err
+	}
+
+	api.recordAudit(ctx, "resource.lifecycle", res.GetID(), transitionDetail(t))
+
+	return t, nil
+}
+
+// handleTransition moves a resource to another lifecycle stage. It takes
+// the privilege to update the resource.
+func handleTransition() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, resource, ok := lifecycleContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionUpdate, resource.GetType(), resource.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		tr := new(TransitionRequest)
+		if err := readJSON(ctx, req, tr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := tr.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		t, err := api.transition(req, resource, tr)
+
+		switch {
+		case errors.Is(err, ErrNoStatus), errors.Is(err, zebra.ErrLifecycleTransition):
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		case err != nil:
+			log.Error(err, "lifecycle transition failed", "resource", resource.GetID())
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		log.Info("lifecycle transition", "resource", resource.GetID(), "from", t.From, "to", t.To)
+
+		writeJSON(ctx, res, t)
+	}
+}
+
+// handleTransitions lists the lifecycle transitions of a resource.
+func handleTransitions() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, resource, ok := lifecycleContext(res, req, params)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.transitions(resource.GetID()))
+	}
+}
+
+func lifecycleContext(res http.ResponseWriter, req *http.Request,
+	params httprouter.Params,
+) (*ResourceAPI, zebra.Resource, bool) {
+	api, ok := req.Context().Value(ResourcesCtxKey).(*ResourceAPI)
+	if !ok {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	resource := findResource(api.Store, params.ByName("id"))
+	if resource == nil {
+		http.Error(res, fmt.Sprintf("resource %s not found", params.ByName("id")), http.StatusNotFound)
+
+		return nil, nil, false
+	}
+
+	return api, resource, true
+}
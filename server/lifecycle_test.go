@@ -0,0 +1,104 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLifecyclePolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	policy, err := newLifecyclePolicy(&LifecycleConfig{Types: nil})
+	assert.Nil(err)
+	assert.True(policy.Allows("Lab", zebra.LifecyclePlanned, zebra.LifecycleProvisioning))
+
+	policy, err = newLifecyclePolicy(&LifecycleConfig{Types: map[string]zebra.Transitions{
+		"Lab": {zebra.LifecyclePlanned: {zebra.LifecycleActive}},
+	}})
+	assert.Nil(err)
+	assert.True(policy.Allows("Lab", zebra.LifecyclePlanned, zebra.LifecycleActive))
+	assert.False(policy.Allows("Lab", zebra.LifecyclePlanned, zebra.LifecycleProvisioning))
+
+	_, err = newLifecyclePolicy(&LifecycleConfig{Types: map[string]zebra.Transitions{"Lab": {"retired": nil}}})
+	assert.NotNil(err)
+}
+
+func TestTransition(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	lab.Status.Lifecycle = zebra.LifecyclePlanned
+	assert.Nil(api.Store.Create(lab))
+
+	user := makeClaims(assert, "user@zebra", true)
+	reader := makeClaims(assert, "reader@zebra", false)
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, method, body, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/", strings.NewReader(body)).WithContext(ctx)
+		h(rr, req, httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	move := func(claims *auth.Claims, to string) int {
+		body := `{"lifecycle": "` + to + `", "reason": "rollout"}`
+
+		return serve(claims, handleTransition(), "POST", body, lab.ID).Code
+	}
+
+	assert.Equal(http.StatusNotFound, serve(user, handleTransition(), "POST", "{}", "nope").Code)
+	assert.Equal(http.StatusForbidden, move(reader, "provisioning"))
+	assert.Equal(http.StatusBadRequest, serve(user, handleTransition(), "POST", `{"lifecycle": "active"}`, lab.ID).Code)
+	assert.Equal(http.StatusBadRequest, move(user, "retired"))
+	assert.Equal(http.StatusConflict, move(user, "active"))
+	assert.Equal(http.StatusOK, move(user, "provisioning"))
+	assert.Equal(http.StatusOK, move(user, "active"))
+	assert.Equal(zebra.LifecycleActive, findResource(api.Store, lab.ID).GetStatus().Lifecycle)
+
+	rr := serve(user, handleTransitions(), "GET", "", lab.ID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	transitions := []Transition{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &transitions))
+	assert.Equal(2, len(transitions))
+	assert.Equal(zebra.LifecyclePlanned, transitions[0].From)
+	assert.Equal(zebra.LifecycleActive, transitions[1].To)
+	assert.Equal("rollout", transitions[1].Reason)
+	assert.Equal("user@zebra", transitions[1].Actor)
+
+	// Updates can not skip the lifecycle either
+	planned := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	planned.ID = lab.ID
+	planned.Status.Lifecycle = zebra.LifecyclePlanned
+	assert.Equal(http.StatusConflict, serve(user, handlePost(), "POST", resMapJSON(assert, planned), "").Code)
+
+	// Resources are queried by lifecycle stage
+	qr := &QueryRequest{Lifecycle: []zebra.Lifecycle{zebra.LifecycleActive}}
+	assert.Nil(qr.Validate(context.Background()))
+	assert.Equal(1, count(api.query(qr)))
+
+	qr.Lifecycle = []zebra.Lifecycle{zebra.LifecycleMaintenance}
+	assert.Equal(0, count(api.query(qr)))
+
+	qr.Lifecycle = []zebra.Lifecycle{""}
+	assert.NotNil(qr.Validate(context.Background()))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gojini.dev/web"
+)
+
+var ErrLocale = errors.New("message catalogs are keyed by language tags such as de or pt-BR")
+
+// LocaleConfig is the message catalog of the server configuration: the
+// translations of the error messages of the API by language tag, such as
+// "de" or "pt-BR", given inline or as the <tag>.json files of a directory.
+// Messages are keyed by their English text, which clients get if there is
+// no translation. Status codes do not change with the language.
+type LocaleConfig struct {
+	Dir      string                       `json:"dir,omitempty"`
+	Messages map[string]map[string]string `json:"messages,omitempty"`
+}
+
+// messageCatalog holds the translations by lower case language tag.
+type messageCatalog struct {
+	languages map[string]map[string]string
+}
+
+func newMessageCatalog(cfg *LocaleConfig) (*messageCatalog, error) {
+	c := &messageCatalog{languages: map[string]map[string]string{}}
+
+	if cfg == nil {
+		return c, nil
+	}
+
+	if cfg.Dir != "" {
+		files, err := os.ReadDir(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			tag := strings.TrimSuffix(f.Name(), ".json")
+			if f.IsDir() || tag == f.Name() {
+				continue
+			}
+
+			data, err := os.ReadFile(path.Join(cfg.Dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			messages := map[string]string{}
+			if err := json.Unmarshal(data, &messages); err != nil {
+				return nil, err
+			}
+
+			if err := c.add(tag, messages); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for tag, messages := range cfg.Messages {
+		if err := c.add(tag, messages); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *messageCatalog) add(tag string, messages map[string]string) error {
+	if tag == "" || strings.ContainsAny(tag, " ,;") {
+		return ErrLocale
+	}
+
+	tag = strings.ToLower(tag)
+	if c.languages[tag] == nil {
+		c.languages[tag] = map[string]string{}
+	}
+
+	for msg, translated := range messages {
+		c.languages[tag][msg] = translated
+	}
+
+	return nil
+}
+
+// translate returns the message in the first language of the Accept-Language
+// header that it is translated to, and the tag of the language. Languages
+// such as "de-AT" fall back to "de". Messages without a translation are
+// returned as they are, with no tag.
+func (c *messageCatalog) translate(acceptLanguage string, msg string) (string, string) {
+	if c == nil || len(c.languages) == 0 {
+		return msg, ""
+	}
+
+	for _, tag := range acceptedLanguages(acceptLanguage) {
+		for t := tag; t != ""; {
+			if translated, ok := c.languages[t][msg]; ok {
+				return translated, t
+			}
+
+			i := strings.LastIndex(t, "-")
+			if i < 0 {
+				break
+			}
+
+			t = t[:i]
+		}
+	}
+
+	return msg, ""
+}
+
+// acceptedLanguages returns the lower case language tags of an
+// Accept-Language header, most preferred first.
+func acceptedLanguages(header string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	languages := []language{}
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+
+			quality = parsed
+		}
+
+		if tag != "" && tag != "*" && quality > 0 {
+			languages = append(languages, language{tag: strings.ToLower(tag), quality: quality})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+
+	tags := make([]string, 0, len(languages))
+	for _, l := range languages {
+		tags = append(tags, l.tag)
+	}
+
+	return tags
+}
+
+// translator translates messages to the languages of an Accept-Language
+// header.
+type translator interface {
+	translate(acceptLanguage string, msg string) (string, string)
+}
+
+func (r *Reloader) translate(acceptLanguage string, msg string) (string, string) {
+	return r.Config().Messages.translate(acceptLanguage, msg)
+}
+
+// localizeAdapter translates the messages of error responses to the
+// language the client asks for in its Accept-Language header.
+func localizeAdapter(messages translator) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			acceptLanguage := req.Header.Get("Accept-Language")
+			if acceptLanguage == "" {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			writer := newErrorWriter(res)
+			callNext(nextHandler, writer, req)
+
+			if writer.code == 0 {
+				return
+			}
+
+			body := writer.body.Bytes()
+			if msg, tag := messages.translate(acceptLanguage, writer.message()); tag != "" {
+				res.Header().Set("Content-Language", tag)
+				res.Header().Del("Content-Length")
+
+				body = []byte(msg + "\n")
+			}
+
+			res.WriteHeader(writer.code)
+			_, _ = res.Write(body)
+		})
+	}
+}
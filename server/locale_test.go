@@ -0,0 +1,123 @@
+package server //nolint:testpackage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptedLanguages(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Empty(acceptedLanguages(""))
+	assert.Equal([]string{"de"}, acceptedLanguages("de"))
+	assert.Equal([]string{"fr", "pt-br", "en"}, acceptedLanguages("en;q=0.5, pt-BR;q=0.8, fr, *;q=0.1"))
+	assert.Equal([]string{"de"}, acceptedLanguages("de, fr;q=0, es;q=lots"))
+}
+
+func TestMessageCatalog(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(os.WriteFile(path.Join(dir, "de.json"), []byte(`{"forbidden": "verboten"}`), ReadWriteOnly))
+	assert.Nil(os.WriteFile(path.Join(dir, "README"), []byte("not a catalog"), ReadWriteOnly))
+
+	c, err := newMessageCatalog(&LocaleConfig{
+		Dir:      dir,
+		Messages: map[string]map[string]string{"pt-BR": {"forbidden": "proibido"}},
+	})
+	assert.Nil(err)
+
+	msg, tag := c.translate("de-AT", "forbidden")
+	assert.Equal("verboten", msg)
+	assert.Equal("de", tag)
+
+	msg, tag = c.translate("fr, pt-br;q=0.9", "forbidden")
+	assert.Equal("proibido", msg)
+	assert.Equal("pt-br", tag)
+
+	msg, tag = c.translate("fr", "forbidden")
+	assert.Equal("forbidden", msg)
+	assert.Equal("", tag)
+
+	msg, _ = c.translate("de", "not found")
+	assert.Equal("not found", msg)
+
+	var none *messageCatalog
+
+	msg, tag = none.translate("de", "forbidden")
+	assert.Equal("forbidden", msg)
+	assert.Equal("", tag)
+
+	assert.Nil(os.WriteFile(path.Join(dir, "fr.json"), []byte(`not json`), ReadWriteOnly))
+	_, err = newMessageCatalog(&LocaleConfig{Dir: dir, Messages: nil})
+	assert.NotNil(err)
+
+	_, err = newMessageCatalog(&LocaleConfig{Dir: path.Join(dir, "missing"), Messages: nil})
+	assert.NotNil(err)
+}
+
+func TestLocalizeAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c, err := newMessageCatalog(&LocaleConfig{
+		Dir:      "",
+		Messages: map[string]map[string]string{"de": {"bad request": "ungültige Anfrage"}},
+	})
+	assert.Nil(err)
+
+	handler := localizeAdapter(c)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/bad":
+			http.Error(res, "bad request", http.StatusBadRequest)
+		case "/other":
+			http.Error(res, "other", http.StatusConflict)
+		case "/empty":
+			res.WriteHeader(http.StatusForbidden)
+		default:
+			_, _ = res.Write([]byte("ok"))
+		}
+	}))
+
+	serve := func(path string, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := serve("/bad", "de-CH, en;q=0.5")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal("ungültige Anfrage\n", rr.Body.String())
+	assert.Equal("de", rr.Header().Get("Content-Language"))
+
+	rr = serve("/bad", "")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal("bad request\n", rr.Body.String())
+	assert.Equal("", rr.Header().Get("Content-Language"))
+
+	rr = serve("/other", "de")
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Equal("other\n", rr.Body.String())
+	assert.Equal("", rr.Header().Get("Content-Language"))
+
+	rr = serve("/empty", "de")
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.Empty(rr.Body.String())
+
+	rr = serve("/", "de")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("ok", rr.Body.String())
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/controller"
+)
+
+// MaxLockTTL is the longest a lock is held without being renewed.
+const MaxLockTTL = 24 * time.Hour
+
+var (
+	ErrLockRequest = errors.New("locks need a holder and a ttl of up to a day")
+	ErrLockHeld    = errors.New("lock is held by another holder")
+	ErrLockLost    = errors.New("lock is not held by the holder with the token")
+)
+
+// LockRequest acquires, renews or releases a named lock. TTL is a duration
+// such as "30s", renewals without one keep the TTL of the lock. Renewals and
+// releases must give the token the lock was acquired with.
+type LockRequest struct {
+	Holder string `json:"holder"`
+	TTL    string `json:"ttl,omitempty"`
+	Token  uint64 `json:"token,omitempty"`
+	Group  string `json:"group,omitempty"`
+}
+
+// LockStatus is the state of a named lock. The holder and the expiry are
+// only set while the lock is held. The token is the fencing token of the
+// last holder, it grows every time the lock changes hands, so that tools
+// can refuse the writes of a holder that lost the lock.
+type LockStatus struct {
+	Name    string    `json:"name"`
+	Group   string    `json:"group"`
+	Holder  string    `json:"holder,omitempty"`
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func newLockStatus(leader *controller.Leader, now time.Time) LockStatus {
+	status := LockStatus{
+		Name: leader.Name, Group: leader.Labels["system.group"], Holder: "", Token: leader.Token, Expires: time.Time{},
+	}
+
+	if !leader.Expired(now) {
+		status.Holder = leader.Holder
+		status.Expires = leader.Renewed.Add(leader.Duration)
+	}
+
+	return status
+}
+
+// lockTTL returns the TTL of the request, the fallback if it has none.
+func (lr *LockRequest) lockTTL(fallback time.Duration) (time.Duration, error) {
+	if lr.TTL == "" && fallback > 0 {
+		return fallback, nil
+	}
+
+	ttl, err := time.ParseDuration(lr.TTL)
+	if err != nil || ttl <= 0 || ttl > MaxLockTTL {
+		return 0, ErrLockRequest
+	}
+
+	return ttl, nil
+}
+
+// findLock returns the lock with the name, nil if there is none.
+func (api *ResourceAPI) findLock(name string) *controller.Leader {
+	leader, _ := findResource(api.Store, controller.LeaderID(name)).(*controller.Leader)
+
+	return leader
+}
+
+// changeLock applies the change to the lock with the name under the lock of
+// the locks, so that locks are changed by one request at a time, and writes
+// the lock the change returns.
+func (api *ResourceAPI) changeLock(ctx context.Context, name string,
+	change func(current *controller.Leader, now time.Time) (*controller.Leader, error),
+) (*controller.Leader, error) {
+	api.locks.Lock()
+	defer api.locks.Unlock()
+
+	current := api.findLock(name)
+
+	leader, err := change(current, time.Now())
+	if err != nil {
+		return current, err
+	}
+
+	return leader, api.create(ctx, leader)
+}
+
+// acquireLock acquires the lock for the holder, unless another holder holds
+// it. Holders that hold the lock already renew it.
+func (api *ResourceAPI) acquireLock(ctx context.Context, name string, lr *LockRequest,
+) (*controller.Leader, error) {
+	ttl, err := lr.lockTTL(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.changeLock(ctx, name, func(current *controller.Leader, now time.Time) (*controller.Leader, error) {
+		if current != nil && current.Holder != lr.Holder && !current.Expired(now) {
+			return nil, ErrLockHeld
+		}
+
+		group := lr.Group
+		if group == "" {
+			group = controller.DefaultLeaderGroup
+		}
+
+		leader := controller.NewLeader(name, lr.Holder, ttl)
+		leader.Renewed = now
+		leader.Token = current.NextToken(lr.Holder, now)
+		leader.Labels.Add("system.group", group)
+
+		return leader, nil
+	})
+}
+
+// renewLock extends the lock of the holder with the token. A lock that ran
+// out is renewed too, as long as no one else acquired it since.
+func (api *ResourceAPI) renewLock(ctx context.Context, name string, lr *LockRequest,
+) (*controller.Leader, error) {
+	return api.changeLock(ctx, name, func(current *controller.Leader, now time.Time) (*controller.Leader, error) {
+		if current == nil || current.Holder != lr.Holder || current.Token != lr.Token {
+			return nil, ErrLockLost
+		}
+
+		ttl, err := lr.lockTTL(current.Duration)
+		if err != nil {
+			return nil, err
+		}
+
+		leader := controller.NewLeader(name, lr.Holder, ttl)
+		leader.Renewed = now
+		leader.Token = current.Token
+		leader.Labels = current.Labels
+
+		return leader, nil
+	})
+}
+
+// releaseLock ends the lock of the holder with the token. The lock is kept
+// with its token, so that the tokens of later holders are greater.
+func (api *ResourceAPI) releaseLock(ctx context.Context, name string, lr *LockRequest,
+) (*controller.Leader, error) {
+	return api.changeLock(ctx, name, func(current *controller.Leader, now time.Time) (*controller.Leader, error) {
+		if current == nil || current.Holder != lr.Holder || current.Token != lr.Token {
+			return nil, ErrLockLost
+		}
+
+		leader := controller.NewLeader(name, lr.Holder, 0)
+		leader.Renewed = now
+		leader.Token = current.Token
+		leader.Labels = current.Labels
+
+		return leader, nil
+	})
+}
+
+// handleLocks lists the locks the user may read.
+func handleLocks() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		now := time.Now()
+		locks := []LockStatus{}
+
+		if l, ok := api.Store.QueryType([]string{"Leader"}).Resources["Leader"]; ok {
+			for _, r := range l.Resources {
+				leader, ok := r.(*controller.Leader)
+				if ok && claims.Allows(auth.ActionRead, leader.Type, leader.Labels) {
+					locks = append(locks, newLockStatus(leader, now))
+				}
+			}
+		}
+
+		sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+
+		writeJSON(ctx, res, locks)
+	}
+}
+
+// handleLock returns the lock with the name.
+func handleLock() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		leader := api.findLock(params.ByName("name"))
+		if leader == nil || !claims.Allows(auth.ActionRead, leader.Type, leader.Labels) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(ctx, res, newLockStatus(leader, time.Now()))
+	}
+}
+
+// handleAcquireLock, handleRenewLock and handleReleaseLock change the lock
+// with the name. Requests that lose to the current holder are answered with
+// a conflict and the state of the lock.
+func handleAcquireLock() httprouter.Handle {
+	return handleChangeLock("lock.acquire", (*ResourceAPI).acquireLock)
+}
+
+func handleRenewLock() httprouter.Handle {
+	return handleChangeLock("lock.renew", (*ResourceAPI).renewLock)
+}
+
+func handleReleaseLock() httprouter.Handle {
+	return handleChangeLock("lock.release", (*ResourceAPI).releaseLock)
+}
+
+func handleChangeLock(action string,
+	change func(api *ResourceAPI, ctx context.Context, name string, lr *LockRequest) (*controller.Leader, error),
+) httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		name := params.ByName("name")
+
+		lr := new(LockRequest)
+		if err := readJSON(ctx, req, lr); err != nil || lr.Holder == "" {
+			http.Error(res, ErrLockRequest.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		// Locks stay in the group they were first acquired in
+		labels := zebra.Labels{"system.group": controller.DefaultLeaderGroup}
+		if lr.Group != "" {
+			labels = zebra.Labels{"system.group": lr.Group}
+		}
+
+		if current := api.findLock(name); current != nil {
+			labels = current.Labels
+		}
+
+		if !claims.Allows(auth.ActionUpdate, "Leader", labels) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		lr.Group = labels["system.group"]
+
+		leader, err := change(api, ctx, name, lr)
+
+		switch {
+		case errors.Is(err, ErrLockRequest):
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrLockHeld), errors.Is(err, ErrLockLost):
+			if leader == nil {
+				http.Error(res, err.Error(), http.StatusConflict)
+
+				return
+			}
+
+			writeJSONCode(ctx, res, http.StatusConflict, newLockStatus(leader, time.Now()))
+		case err != nil:
+			log.Error(err, "lock could not be changed", "lock", name)
+			res.WriteHeader(http.StatusInternalServerError)
+		default:
+			api.recordAudit(ctx, action, leader.ID, name)
+			writeJSON(ctx, res, newLockStatus(leader, time.Now()))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/controller"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func lockRequest(assert *assert.Assertions, api *ResourceAPI, claims *auth.Claims, handle httprouter.Handle,
+	name string, body string,
+) (int, LockStatus) {
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/locks/"+name, nil)
+	assert.Nil(err)
+
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+
+	rr := httptest.NewRecorder()
+	handle(rr, req, httprouter.Params{{Key: "name", Value: name}})
+
+	status := LockStatus{Name: "", Group: "", Holder: "", Token: 0, Expires: time.Time{}}
+	_ = json.Unmarshal(rr.Body.Bytes(), &status)
+
+	return rr.Code, status
+}
+
+func TestLocks(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_locks"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	code, _ := lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"a"}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	code, _ = lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"a","ttl":"48h"}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	code, _ = lockRequest(assert, api, user, handleAcquireLock(), "rack", `{"holder":"a","ttl":"1m"}`)
+	assert.Equal(http.StatusForbidden, code)
+
+	code, held := lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"a","ttl":"1m"}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("a", held.Holder)
+	assert.Equal(controller.DefaultLeaderGroup, held.Group)
+	assert.Equal(uint64(1), held.Token)
+
+	// Another holder loses, the holder acquires again with the same token
+	code, lost := lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"b","ttl":"1m"}`)
+	assert.Equal(http.StatusConflict, code)
+	assert.Equal("a", lost.Holder)
+
+	code, held = lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"a","ttl":"1m"}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(uint64(1), held.Token)
+
+	// Renewals and releases need the token
+	code, _ = lockRequest(assert, api, admin, handleRenewLock(), "rack", `{"holder":"a","token":2}`)
+	assert.Equal(http.StatusConflict, code)
+
+	code, renewed := lockRequest(assert, api, admin, handleRenewLock(), "rack", `{"holder":"a","token":1}`)
+	assert.Equal(http.StatusOK, code)
+	assert.False(renewed.Expires.Before(held.Expires))
+
+	code, _ = lockRequest(assert, api, admin, handleReleaseLock(), "rack", `{"holder":"b","token":1}`)
+	assert.Equal(http.StatusConflict, code)
+
+	code, released := lockRequest(assert, api, admin, handleReleaseLock(), "rack", `{"holder":"a","token":1}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("", released.Holder)
+
+	// The next holder gets the next token
+	code, held = lockRequest(assert, api, admin, handleAcquireLock(), "rack", `{"holder":"b","ttl":"1m"}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(uint64(2), held.Token)
+
+	code, _ = lockRequest(assert, api, admin, handleRenewLock(), "rack", `{"holder":"a","token":1}`)
+	assert.Equal(http.StatusConflict, code)
+
+	code, _ = lockRequest(assert, api, admin, handleAcquireLock(), "switch", `{"holder":"a","ttl":"1m","group":"labs"}`)
+	assert.Equal(http.StatusOK, code)
+
+	// Locks are read by those who may read their group
+	code, held = lockRequest(assert, api, user, handleLock(), "rack", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("b", held.Holder)
+
+	code, _ = lockRequest(assert, api, user, handleLock(), "door", "")
+	assert.Equal(http.StatusNotFound, code)
+
+	ctx := context.WithValue(context.WithValue(context.Background(), ResourcesCtxKey, api), ClaimsCtxKey, user)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/locks", nil)
+	assert.Nil(err)
+
+	rr := httptest.NewRecorder()
+	handleLocks()(rr, req, nil)
+
+	locks := []LockStatus{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &locks))
+	assert.Equal(2, len(locks))
+	assert.Equal("rack", locks[0].Name)
+	assert.Equal("labs", locks[1].Group)
+	assert.Equal(5, len(api.Audit.Query(controller.LeaderID("rack"))))
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -48,9 +48,20 @@ func loginAdapter() web.Adapter {
 				return
 			}
 
+			// Locked out accounts are refused before the password is checked
+			if wait := api.logins.lockedOut(userData.Email, api.logins.now()); wait > 0 {
+				log.Info("user locked out", "user", userData.Email)
+				api.recordAuthFailure("lockedout", userData.Email, req, "login refused")
+				retryAfter(res, wait)
+				res.WriteHeader(http.StatusTooManyRequests)
+
+				return
+			}
+
 			user := findUser(api.Store, userData.Email)
 			if user == nil {
 				log.Error(nil, "user not found", "user", userData.Email)
+				loginFailed(api, req, "login", userData.Email, "unknown user")
 				res.WriteHeader(http.StatusUnauthorized)
 
 				return
@@ -58,12 +69,22 @@ func loginAdapter() web.Adapter {
 
 			if err := user.AuthenticatePassword(userData.Password); err != nil {
 				log.Error(err, "user auth failed", "user", user.Email)
+				loginFailed(api, req, "login", user.Email, "bad password")
 				res.WriteHeader(http.StatusUnauthorized)
 
 				return
 			}
 
+			api.logins.succeed(user.Email)
+
 			claims := auth.NewClaims("zebra", user.Name, user.Role, user.Email)
+			if _, err := api.sessions.start(claims, time.Now()); err != nil {
+				log.Error(err, "session not started", "user", user.Email)
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
 			respondWithClaims(ctx, res, claims, authKey)
 
 			log.Info("login succeeded", "user", user.Email)
@@ -71,6 +92,16 @@ func loginAdapter() web.Adapter {
 	}
 }
 
+// loginFailed counts and audits a failed login of the account, by password
+// or key token, locking it out after too many.
+func loginFailed(api *ResourceAPI, req *http.Request, action string, email string, reason string) {
+	api.recordAuthFailure(action, email, req, reason)
+
+	if lockout := api.logins.fail(email, api.logins.now()); lockout > 0 {
+		api.recordAuthFailure("lockout", email, req, "locked out for "+lockout.String())
+	}
+}
+
 func makeCookie(jwt string) *http.Cookie {
 	cookie := new(http.Cookie)
 	cookie.Name = "jwt"
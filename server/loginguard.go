@@ -0,0 +1,292 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/metrics"
+	"gojini.dev/web"
+)
+
+// Defaults of the login guard.
+const (
+	DefaultMaxLoginFailures = 5
+	DefaultLockout          = time.Minute
+	DefaultMaxLockout       = time.Hour
+	DefaultAuthRateLimit    = 20
+)
+
+var ErrLoginConfig = errors.New("invalid login configuration")
+
+var authFailures = metrics.Default.Counter("zebra_auth_failures_total",
+	"Failed and throttled authentication requests, by reason.", "reason")
+
+// LoginConfig sets the brute force protection of the auth endpoints. After
+// MaxFailures failed logins in a row an account is locked out for Lockout,
+// such as "1m", doubled with every further failure up to MaxLockout.
+// RateLimit is the number of login and register requests per minute of one
+// client address. Zero values take the defaults, a negative MaxFailures or
+// RateLimit turns that protection off.
+type LoginConfig struct {
+	MaxFailures int    `json:"maxFailures"`
+	Lockout     string `json:"lockout,omitempty"`
+	MaxLockout  string `json:"maxLockout,omitempty"`
+	RateLimit   int    `json:"rateLimit"`
+}
+
+// loginFailures are the failed logins of an account since its last login.
+type loginFailures struct {
+	count  int
+	last   time.Time
+	locked time.Time
+}
+
+// addressBucket is the rate limit bucket of a client address.
+type addressBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+// loginGuard locks out accounts after repeated failed logins and limits the
+// rate of auth requests of client addresses. State is kept in memory, a
+// restart resets it.
+type loginGuard struct {
+	lock        sync.Mutex
+	now         func() time.Time
+	maxFailures int
+	lockout     time.Duration
+	maxLockout  time.Duration
+	rateLimit   int
+	failures    map[string]*loginFailures
+	buckets     map[string]*addressBucket
+	pruned      time.Time
+}
+
+func defaultLoginGuard() *loginGuard {
+	return &loginGuard{
+		lock:        sync.Mutex{},
+		now:         time.Now,
+		maxFailures: DefaultMaxLoginFailures,
+		lockout:     DefaultLockout,
+		maxLockout:  DefaultMaxLockout,
+		rateLimit:   DefaultAuthRateLimit,
+		failures:    map[string]*loginFailures{},
+		buckets:     map[string]*addressBucket{},
+		pruned:      time.Time{},
+	}
+}
+
+func newLoginGuard(cfg *LoginConfig) (*loginGuard, error) {
+	g := defaultLoginGuard()
+
+	if cfg.MaxFailures != 0 {
+		g.maxFailures = cfg.MaxFailures
+	}
+
+	if cfg.RateLimit != 0 {
+		g.rateLimit = cfg.RateLimit
+	}
+
+	for field, value := range map[*time.Duration]string{&g.lockout: cfg.Lockout, &g.maxLockout: cfg.MaxLockout} {
+		if value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, ErrLoginConfig
+		}
+
+		*field = d
+	}
+
+	if g.maxLockout < g.lockout {
+		return nil, ErrLoginConfig
+	}
+
+	return g, nil
+}
+
+// allowAddress takes a request from the per minute rate limit of the client
+// address.
+func (g *loginGuard) allowAddress(addr string, now time.Time) bool {
+	if g.rateLimit < 0 {
+		return true
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.prune(now)
+
+	limit := float64(g.rateLimit)
+
+	b, ok := g.buckets[addr]
+	if !ok {
+		b = &addressBucket{tokens: limit, refilled: now}
+		g.buckets[addr] = b
+	}
+
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.refilled).Minutes()*limit)
+	b.refilled = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// lockedOut returns how long the account is still locked out, zero if it is
+// not.
+func (g *loginGuard) lockedOut(email string, now time.Time) time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if f, ok := g.failures[email]; ok && now.Before(f.locked) {
+		return f.locked.Sub(now)
+	}
+
+	return 0
+}
+
+// fail counts a failed login of the account and returns how long it is
+// locked out for, zero if it is not.
+func (g *loginGuard) fail(email string, now time.Time) time.Duration {
+	if g.maxFailures < 0 {
+		return 0
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	f, ok := g.failures[email]
+	if !ok {
+		f = &loginFailures{count: 0, last: now, locked: time.Time{}}
+		g.failures[email] = f
+	}
+
+	f.count++
+	f.last = now
+
+	if f.count < g.maxFailures {
+		return 0
+	}
+
+	lockout := g.lockout
+	for i := g.maxFailures; i < f.count && lockout < g.maxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > g.maxLockout {
+		lockout = g.maxLockout
+	}
+
+	f.locked = now.Add(lockout)
+
+	return lockout
+}
+
+// succeed forgets the failed logins of the account.
+func (g *loginGuard) succeed(email string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.failures, email)
+}
+
+// prune drops, at most once a minute, full buckets and the failures of
+// accounts that were not locked out for the longest lockout.
+func (g *loginGuard) prune(now time.Time) {
+	if now.Sub(g.pruned) < time.Minute {
+		return
+	}
+
+	g.pruned = now
+
+	for addr, b := range g.buckets {
+		if now.Sub(b.refilled) > time.Minute {
+			delete(g.buckets, addr)
+		}
+	}
+
+	for email, f := range g.failures {
+		if now.Sub(f.last) > g.maxLockout && now.After(f.locked) {
+			delete(g.failures, email)
+		}
+	}
+}
+
+// clientAddress returns the host of the remote address of the request.
+func clientAddress(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// retryAfter sets the Retry-After header to the wait in whole seconds.
+func retryAfter(res http.ResponseWriter, wait time.Duration) {
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// recordAuthFailure adds an entry for a failed authentication to the audit
+// log. The actor is the account the request claimed to be.
+func (api *ResourceAPI) recordAuthFailure(action string, email string, req *http.Request, detail string) {
+	authFailures.Inc(action)
+
+	_ = api.Audit.Record(audit.Entry{
+		Time:         time.Now(),
+		Actor:        email,
+		ActorType:    audit.ActorUser,
+		Impersonator: "",
+		Action:       "auth." + action,
+		Resource:     "",
+		Detail:       clientAddress(req) + " " + detail,
+	})
+}
+
+// authLimitAdapter limits the rate of login and register requests of each
+// client address.
+func authLimitAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/login" && req.URL.Path != "/register" {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			ctx := req.Context()
+			api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+			if !ok {
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			if !api.logins.allowAddress(clientAddress(req), api.logins.now()) {
+				logr.FromContextOrDiscard(ctx).Info("auth request rate limited", "address", clientAddress(req))
+				api.recordAuthFailure("ratelimit", "", req, req.URL.Path)
+				retryAfter(res, time.Duration(float64(time.Minute)/float64(api.logins.rateLimit)))
+				res.WriteHeader(http.StatusTooManyRequests)
+
+				return
+			}
+
+			callNext(nextHandler, res, req)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoginGuard(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	g, err := newLoginGuard(&LoginConfig{MaxFailures: 0, Lockout: "", MaxLockout: "", RateLimit: 0})
+	assert.Nil(err)
+	assert.Equal(DefaultMaxLoginFailures, g.maxFailures)
+	assert.Equal(DefaultLockout, g.lockout)
+
+	_, err = newLoginGuard(&LoginConfig{MaxFailures: 3, Lockout: "soon", MaxLockout: "", RateLimit: 0})
+	assert.ErrorIs(err, ErrLoginConfig)
+
+	_, err = newLoginGuard(&LoginConfig{MaxFailures: 3, Lockout: "2h", MaxLockout: "1h", RateLimit: 0})
+	assert.ErrorIs(err, ErrLoginConfig)
+
+	// Lockouts double with every failure up to the longest lockout
+	g, err = newLoginGuard(&LoginConfig{MaxFailures: 2, Lockout: "1m", MaxLockout: "3m", RateLimit: 2})
+	assert.Nil(err)
+
+	now := time.Now()
+	assert.Equal(time.Duration(0), g.fail("user@zebra", now))
+	assert.Equal(time.Minute, g.fail("user@zebra", now))
+	assert.Equal(time.Minute, g.lockedOut("user@zebra", now))
+	assert.Equal(2*time.Minute, g.fail("user@zebra", now))
+	assert.Equal(3*time.Minute, g.fail("user@zebra", now))
+	assert.Equal(time.Duration(0), g.lockedOut("user@zebra", now.Add(4*time.Minute)))
+
+	g.succeed("user@zebra")
+	assert.Equal(time.Duration(0), g.fail("user@zebra", now))
+
+	assert.True(g.allowAddress("10.0.0.1", now))
+	assert.True(g.allowAddress("10.0.0.1", now))
+	assert.False(g.allowAddress("10.0.0.1", now))
+	assert.True(g.allowAddress("10.0.0.2", now))
+	assert.True(g.allowAddress("10.0.0.1", now.Add(30*time.Second)))
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_login_lockout"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.Store = makeQueryStore(root, assert, makeUser(assert))
+	api.logins.maxFailures = 2
+
+	now := time.Now()
+	api.logins.now = func() time.Time { return now }
+
+	login := func(password string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler := authLimitAdapter()(loginAdapter()(nil))
+		handler.ServeHTTP(rr, makeLoginRequest(assert, "jini", password, "email@domain", api))
+
+		return rr
+	}
+
+	assert.Equal(http.StatusUnauthorized, login("wrong").Code)
+	assert.Equal(http.StatusUnauthorized, login("wrong").Code)
+
+	// The right password is refused while the account is locked out
+	rr := login(jiniWords)
+	assert.Equal(http.StatusTooManyRequests, rr.Code)
+	assert.Equal("60", rr.Header().Get("Retry-After"))
+
+	actions := []string{}
+	for _, e := range api.Audit.Entries() {
+		actions = append(actions, e.Action)
+	}
+
+	assert.Equal([]string{"auth.login", "auth.login", "auth.lockout", "auth.lockedout"}, actions)
+
+	// The lockout ends and the rate limit is refilled after a minute
+	now = now.Add(time.Minute)
+	assert.Equal(http.StatusOK, login(jiniWords).Code)
+
+	// Client addresses are throttled after the rate limit
+	for i := 1; i < DefaultAuthRateLimit; i++ {
+		assert.Equal(http.StatusOK, login(jiniWords).Code)
+	}
+
+	rr = login(jiniWords)
+	assert.Equal(http.StatusTooManyRequests, rr.Code)
+	assert.Equal("3", rr.Header().Get("Retry-After"))
+}
+
+func TestKeyTokenLockout(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_key_token_lockout"
+
+	defer func() { os.RemoveAll(root) }()
+
+	user := makeUser(assert)
+	priKey := user.Key
+	user.Key = priKey.Public()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.Store = makeQueryStore(root, assert, user)
+	api.logins.maxFailures = 2
+
+	now := time.Now()
+	api.logins.now = func() time.Time { return now }
+
+	signed, err := priKey.Sign([]byte(user.Email))
+	assert.Nil(err)
+
+	authenticate := func(token string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		req := httptest.NewRequest("GET", "/api/v1/resources", nil).WithContext(ctx)
+		req.Header.Set("Zebra-Auth-User", user.Email)
+		req.Header.Set("Zebra-Auth-Token", token)
+
+		rr := httptest.NewRecorder()
+		rsaKey(rr, req)
+
+		return rr
+	}
+
+	assert.Equal(http.StatusUnauthorized, authenticate("badtoken").Code)
+	assert.Equal(http.StatusUnauthorized, authenticate("badtoken").Code)
+
+	// The right token is refused while the account is locked out
+	rr := authenticate(base64.StdEncoding.EncodeToString(signed))
+	assert.Equal(http.StatusTooManyRequests, rr.Code)
+	assert.Equal("60", rr.Header().Get("Retry-After"))
+
+	actions := []string{}
+	for _, e := range api.Audit.Entries() {
+		actions = append(actions, e.Action)
+	}
+
+	assert.Equal([]string{"auth.token", "auth.token", "auth.lockout", "auth.lockedout"}, actions)
+
+	now = now.Add(time.Minute)
+	assert.Equal(http.StatusOK, authenticate(base64.StdEncoding.EncodeToString(signed)).Code)
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/syslog"
+)
+
+// Defaults of the syslog receiver: the label holding the hostname a device
+// logs with, if it differs from the resource name, and the number of log
+// messages kept per resource.
+const (
+	DefaultHostnameLabel = "hostname"
+	DefaultLogBufferSize = 200
+)
+
+// maxSyslogSize is the largest syslog message read, UDP datagrams are
+// smaller.
+const maxSyslogSize = 65535
+
+var (
+	ErrLogBufferSize = errors.New("syslog buffer size must not be negative")
+	ErrLogSeverity   = errors.New("unknown syslog severity")
+)
+
+var syslogMessages = metrics.Default.Counter("zebra_syslog_messages_total",
+	"Syslog messages received, by result.", "result")
+
+// SyslogConfig is the syslog listener of the server configuration. Messages
+// are received on the UDP address Listen, such as ":514", and the last
+// Buffer messages of every resource are kept in memory for triage.
+type SyslogConfig struct {
+	Listen        string `json:"listen"`
+	Buffer        int    `json:"buffer,omitempty"`
+	HostnameLabel string `json:"hostnameLabel,omitempty"`
+}
+
+// DeviceLog is a syslog message from a resource.
+type DeviceLog struct {
+	Time     time.Time `json:"time"`
+	Received time.Time `json:"received"`
+	Source   string    `json:"source,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	Facility int       `json:"facility"`
+	Severity string    `json:"severity"`
+	App      string    `json:"app,omitempty"`
+	Text     string    `json:"text"`
+	level    int
+}
+
+func newDeviceLog(msg *syslog.Message, src net.IP, received time.Time) DeviceLog {
+	l := DeviceLog{
+		Time:     msg.Time,
+		Received: received,
+		Source:   "",
+		Hostname: msg.Hostname,
+		Facility: msg.Facility,
+		Severity: msg.SeverityName(),
+		App:      msg.App,
+		Text:     msg.Text,
+		level:    msg.Severity,
+	}
+
+	if src != nil {
+		l.Source = src.String()
+	}
+
+	return l
+}
+
+// logBuffer keeps the last messages of every resource, oldest first.
+type logBuffer struct {
+	lock sync.RWMutex
+	size int
+	logs map[string][]DeviceLog
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{lock: sync.RWMutex{}, size: size, logs: make(map[string][]DeviceLog)}
+}
+
+func (b *logBuffer) add(id string, l DeviceLog) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	logs := append(b.logs[id], l)
+	if len(logs) > b.size {
+		logs = append(make([]DeviceLog, 0, b.size), logs[len(logs)-b.size:]...)
+	}
+
+	b.logs[id] = logs
+}
+
+// list returns the last limit messages of the resource that are at least as
+// severe as the severity, all of them if limit is 0.
+func (b *logBuffer) list(id string, severity int, limit int) []DeviceLog {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	logs := []DeviceLog{}
+
+	for _, l := range b.logs[id] {
+		if l.level <= severity {
+			logs = append(logs, l)
+		}
+	}
+
+	if limit > 0 && len(logs) > limit {
+		logs = logs[len(logs)-limit:]
+	}
+
+	return logs
+}
+
+// syslogReceiver files the syslog messages of devices under the resources
+// they come from.
+type syslogReceiver struct {
+	api           *ResourceAPI
+	listen        string
+	hostnameLabel string
+	buffer        *logBuffer
+}
+
+// newSyslogReceiver returns a receiver for the configuration, or nil if
+// syslog is not listened for.
+func newSyslogReceiver(api *ResourceAPI, cfg *SyslogConfig) (*syslogReceiver, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+
+	if cfg.Buffer < 0 {
+		return nil, ErrLogBufferSize
+	}
+
+	size := cfg.Buffer
+	if size == 0 {
+		size = DefaultLogBufferSize
+	}
+
+	hostnameLabel := cfg.HostnameLabel
+	if hostnameLabel == "" {
+		hostnameLabel = DefaultHostnameLabel
+	}
+
+	return &syslogReceiver{
+		api:           api,
+		listen:        cfg.Listen,
+		hostnameLabel: hostnameLabel,
+		buffer:        newLogBuffer(size),
+	}, nil
+}
+
+// run receives syslog messages until the context is done.
+func (r *syslogReceiver) run(ctx context.Context, conn net.PacketConn) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxSyslogSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error(err, "syslog listener failed")
+			}
+
+			return
+		}
+
+		var src net.IP
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			src = udp.IP
+		}
+
+		if _, err := r.receive(buf[:n], src, time.Now()); err != nil {
+			log.V(1).Info("syslog message dropped", "from", addr.String(), "error", err.Error())
+		}
+	}
+}
+
+// receive keeps the message for the resources it comes from, by its hostname
+// or else the source address. It returns the IDs of the resources.
+func (r *syslogReceiver) receive(data []byte, src net.IP, now time.Time) ([]string, error) {
+	msg, err := syslog.Parse(data, now)
+	if err != nil {
+		syslogMessages.Inc("invalid")
+
+		return nil, err
+	}
+
+	resources := r.sources(msg.Hostname, src)
+	if len(resources) == 0 {
+		syslogMessages.Inc("unmatched")
+
+		return nil, nil
+	}
+
+	l := newDeviceLog(msg, src, now)
+	ids := make([]string, 0, len(resources))
+
+	for _, res := range resources {
+		r.buffer.add(res.GetID(), l)
+		ids = append(ids, res.GetID())
+	}
+
+	syslogMessages.Inc("matched")
+
+	return ids, nil
+}
+
+// sources returns the resources the message comes from. A hostname matches
+// the hostname label or the name of a resource, with or without its domain,
+// and is looked up as an IP address if it is one. Without a match by the
+// hostname, the resources with the source address are returned.
+func (r *syslogReceiver) sources(hostname string, src net.IP) []zebra.Resource {
+	hostname = strings.ToLower(hostname)
+	short, _, _ := strings.Cut(hostname, ".")
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		hostname, short, src = "", "", ip
+	}
+
+	byName, byIP := []zebra.Resource{}, []zebra.Resource{}
+
+	_ = applyFunc(r.api.view(), func(res zebra.Resource) error {
+		if hostname != "" {
+			name := strings.ToLower(res.GetLabels()[r.hostnameLabel])
+			if name == "" {
+				name, _ = fieldValue(res, "name")
+			}
+
+			if name != "" && (name == hostname || name == short) {
+				byName = append(byName, res)
+
+				return nil
+			}
+		}
+
+		if src == nil {
+			return nil
+		}
+
+		for _, resIP := range resourceIPs(res) {
+			if resIP.Equal(src) {
+				byIP = append(byIP, res)
+
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	if len(byName) != 0 {
+		return byName
+	}
+
+	return byIP
+}
+
+// handleLogs returns the recent syslog messages of a resource, oldest first.
+// The severity query parameter leaves out the less severe messages and the
+// limit parameter returns only the last ones.
+func handleLogs() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if api.syslog == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		resource := findResource(api.Store, params.ByName("id"))
+		if resource == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionRead, resource.GetType(), resource.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		severity, limit, err := logParams(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.syslog.buffer.list(resource.GetID(), severity, limit))
+	}
+}
+
+func logParams(req *http.Request) (int, int, error) {
+	severity, limit := syslog.Debug, 0
+	values := req.URL.Query()
+
+	if s := values.Get("severity"); s != "" {
+		var ok bool
+		if severity, ok = syslog.Severity(s); !ok {
+			return 0, 0, ErrLogSeverity
+		}
+	}
+
+	if l := values.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return 0, 0, ErrQueryRequest
+		}
+	}
+
+	return severity, limit, nil
+}
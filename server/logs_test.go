@@ -0,0 +1,185 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSyslogReceiver(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+
+	r, err := newSyslogReceiver(api, &SyslogConfig{Listen: "", Buffer: 0, HostnameLabel: ""})
+	assert.Nil(err)
+	assert.Nil(r)
+
+	r, err = newSyslogReceiver(api, &SyslogConfig{Listen: ":0", Buffer: 0, HostnameLabel: ""})
+	assert.Nil(err)
+	assert.Equal(DefaultHostnameLabel, r.hostnameLabel)
+	assert.Equal(DefaultLogBufferSize, r.buffer.size)
+
+	_, err = newSyslogReceiver(api, &SyslogConfig{Listen: ":0", Buffer: -1, HostnameLabel: ""})
+	assert.ErrorIs(err, ErrLogBufferSize)
+}
+
+func TestSyslogReceiver(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	r, err := newSyslogReceiver(api, &SyslogConfig{Listen: ":0", Buffer: 2, HostnameLabel: ""})
+	assert.Nil(err)
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs", "hostname": "core-sw"})
+
+	assert.Nil(api.Store.Create(server))
+	assert.Nil(api.Store.Create(lab))
+
+	now := time.Now()
+
+	// By the name, with or without domain, or the hostname label
+	ids, err := r.receive([]byte("<11>1 - server.lab.example app - - - disk failure"), nil, now)
+	assert.Nil(err)
+	assert.Equal([]string{server.ID}, ids)
+
+	ids, err = r.receive([]byte("<14>1 - CORE-SW app - - - hello"), net.ParseIP("10.1.0.1"), now)
+	assert.Nil(err)
+	assert.Equal([]string{lab.ID}, ids)
+
+	// By the hostname if it is an address, or else the source address
+	ids, err = r.receive([]byte("<14>1 - 10.1.0.1 app - - - one"), net.ParseIP("10.9.9.9"), now)
+	assert.Nil(err)
+	assert.Equal([]string{server.ID}, ids)
+
+	ids, err = r.receive([]byte("<15>kernel: two"), net.ParseIP("10.1.0.1"), now)
+	assert.Nil(err)
+	assert.Equal([]string{server.ID}, ids)
+
+	ids, err = r.receive([]byte("<14>1 - other app - - - hello"), net.ParseIP("10.9.9.9"), now)
+	assert.Nil(err)
+	assert.Empty(ids)
+
+	_, err = r.receive([]byte("hello"), nil, now)
+	assert.NotNil(err)
+
+	// Only the last messages are kept
+	logs := r.buffer.list(server.ID, 7, 0)
+	assert.Equal(2, len(logs))
+	assert.Equal("one", logs[0].Text)
+	assert.Equal("two", logs[1].Text)
+	assert.Equal("10.1.0.1", logs[1].Source)
+	assert.Equal("debug", logs[1].Severity)
+
+	// The listener receives messages until it is stopped
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	local := compute.NewServer([]string{"serial", "model", "local"}, net.ParseIP("127.0.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(local))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		r.run(ctx, conn)
+		close(done)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	assert.Nil(err)
+
+	_, err = fmt.Fprint(client, "<12>sshd[1]: hello")
+	assert.Nil(err)
+
+	assert.Eventually(func() bool { return len(r.buffer.list(local.ID, 7, 0)) == 1 },
+		5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	client.Close()
+}
+
+func TestHandleLogs(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	serve := func(claims *auth.Claims, url string, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		handleLogs()(rr, httptest.NewRequest("GET", url, nil).WithContext(ctx), httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(server))
+
+	// Without a syslog listener there are no logs
+	assert.Equal(http.StatusNotFound, serve(admin, "/", server.ID).Code)
+
+	r, err := newSyslogReceiver(api, &SyslogConfig{Listen: ":0", Buffer: 0, HostnameLabel: ""})
+	assert.Nil(err)
+
+	api.syslog = r
+
+	for _, msg := range []string{
+		"<11>1 - server app - - - error", "<12>1 - server app - - - warning", "<14>1 - server app - - - info",
+	} {
+		_, err := r.receive([]byte(msg), nil, time.Now())
+		assert.Nil(err)
+	}
+
+	rr := serve(admin, "/", server.ID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	logs := []DeviceLog{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &logs))
+	assert.Equal(3, len(logs))
+
+	rr = serve(admin, "/?severity=warning&limit=1", server.ID)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &logs))
+	assert.Equal(1, len(logs))
+	assert.Equal("warning", logs[0].Text)
+
+	assert.Equal(http.StatusBadRequest, serve(admin, "/?severity=loud", server.ID).Code)
+	assert.Equal(http.StatusBadRequest, serve(admin, "/?limit=-1", server.ID).Code)
+	assert.Equal(http.StatusNotFound, serve(admin, "/", "nope").Code)
+
+	// Logs are only shown to users who can read the resource
+	other := auth.NewClaims("zebra", "other@zebra", &auth.Role{Name: "none", Privileges: nil}, "other@zebra")
+	assert.Equal(http.StatusForbidden, serve(other, "/", server.ID).Code)
+
+	rr = httptest.NewRecorder()
+	handleLogs()(rr, httptest.NewRequest("GET", "/", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/lease"
+)
+
+// MergeStrategy decides the value of a property or label both merged
+// resources have, with different values. Empty properties never win.
+type MergeStrategy string
+
+const (
+	// MergeKeep keeps the values of the resource merged into.
+	MergeKeep MergeStrategy = "keep"
+	// MergeTake takes the values of the resource merged from.
+	MergeTake MergeStrategy = "take"
+	// MergeStrict refuses the merge if any values differ.
+	MergeStrict MergeStrategy = "strict"
+)
+
+var (
+	ErrMergeRequest  = errors.New("merge requires distinct from and into resources")
+	ErrMergeStrategy = errors.New("merge strategy must be one of keep, take or strict")
+	ErrMergeType     = errors.New("only resources of the same type can be merged")
+	ErrMergeConflict = errors.New("merged resources have different values")
+)
+
+// MergeRequest asks to merge the resource From into the resource Into. From
+// is archived and everything that referred to it refers to Into instead.
+type MergeRequest struct {
+	From     string        `json:"from"`
+	Into     string        `json:"into"`
+	Strategy MergeStrategy `json:"strategy,omitempty"`
+}
+
+func (m *MergeRequest) Validate() error {
+	if m.From == "" || m.Into == "" || m.From == m.Into {
+		return ErrMergeRequest
+	}
+
+	switch m.Strategy {
+	case "", MergeKeep, MergeTake, MergeStrict:
+		return nil
+	default:
+		return ErrMergeStrategy
+	}
+}
+
+// MergeResult is the merged resource and the IDs of the resources and
+// leases that were changed to refer to it. If the merge was refused for
+// conflicts, they list the differing properties and labels.
+type MergeResult struct {
+	Resource  zebra.Resource `json:"resource,omitempty"`
+	Archived  string         `json:"archived,omitempty"`
+	Relinked  []string       `json:"relinked"`
+	Leases    []string       `json:"leases"`
+	Conflicts []string       `json:"conflicts,omitempty"`
+}
+
+// merge is the set of changes merging a resource makes, originals holds the
+// stored version of each update, for rollback.
+type merge struct {
+	from      zebra.Resource
+	updates   []zebra.Resource
+	originals []zebra.Resource
+	result    MergeResult
+}
+
+// planMerge computes the merge of the resource from into the resource into,
+// without changing the store.
+func (api *ResourceAPI) planMerge(from, into zebra.Resource, strategy MergeStrategy) (*merge, error) {
+	if from.GetType() != into.GetType() {
+		return nil, ErrMergeType
+	}
+
+	decoder := zebra.NewDecoder(api.factory)
+
+	merged, conflicts, err := mergeResources(decoder, from, into, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &merge{
+		from:      from,
+		updates:   nil,
+		originals: nil,
+		result:    MergeResult{Resource: nil, Archived: "", Relinked: []string{}, Leases: []string{}, Conflicts: conflicts},
+	}
+
+	if len(conflicts) != 0 {
+		return m, ErrMergeConflict
+	}
+
+	if zebra.IsIn(from.GetID(), zebra.References(merged)) {
+		if merged, err = relinked(decoder, merged, from.GetID(), into.GetID()); err != nil {
+			return nil, err
+		}
+	}
+
+	m.add(into, merged)
+	m.result.Resource = merged
+	m.result.Archived = from.GetID()
+
+	err = applyFunc(api.Store.Query(), func(r zebra.Resource) error {
+		id := r.GetID()
+		if id == from.GetID() || id == into.GetID() || !zebra.IsIn(from.GetID(), zebra.References(r)) {
+			return nil
+		}
+
+		c, err := relinked(decoder, r, from.GetID(), into.GetID())
+		if err != nil {
+			return err
+		}
+
+		m.add(r, c)
+		m.result.Relinked = append(m.result.Relinked, id)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_ = applyFunc(api.Store.QueryType([]string{"Lease"}), func(r zebra.Resource) error {
+		if l, ok := r.(*lease.Lease); ok {
+			if c := relinkedLease(l, from.GetID(), merged); c != nil {
+				m.add(l, c)
+				m.result.Leases = append(m.result.Leases, l.GetID())
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(m.result.Relinked)
+	sort.Strings(m.result.Leases)
+
+	return m, nil
+}
+
+func (m *merge) add(original, update zebra.Resource) {
+	m.originals = append(m.originals, original)
+	m.updates = append(m.updates, update)
+}
+
+// applyMerge makes the changes of the merge and archives the resource merged
+// from. Either all changes are made or, if one fails, the changed resources
+// are restored.
+func (api *ResourceAPI) applyMerge(ctx context.Context, m *merge) error {
+	for i, r := range m.updates {
+		if err := api.create(ctx, r); err != nil {
+			api.rollbackMerge(ctx, m.originals[:i])
+
+			return err
+		}
+	}
+
+	if err := api.archiveResource(ctx, m.from); err != nil {
+		api.rollbackMerge(ctx, m.originals)
+
+		return err
+	}
+
+	return nil
+}
+
+func (api *ResourceAPI) rollbackMerge(ctx context.Context, originals []zebra.Resource) {
+	for _, orig := range originals {
+		_ = api.create(ctx, orig)
+	}
+}
+
+// mergeResources returns a copy of into with the labels and properties of
+// from it lacks, and the ones that differ reconciled by the strategy. The
+// status of into is kept, but a free resource takes over the lease of from.
+func mergeResources(decoder *zebra.Decoder, from, into zebra.Resource,
+	strategy MergeStrategy,
+) (zebra.Resource, []string, error) {
+	fields, err := resourceFields(into)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	others, err := resourceFields(from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conflicts := []string{}
+
+	for k, v := range others {
+		switch k {
+		case "id", "type", "labels", "status":
+			continue
+		}
+
+		cur, ok := fields[k]
+
+		switch {
+		case emptyJSON(v) || string(cur) == string(v):
+		case !ok || emptyJSON(cur):
+			fields[k] = v
+		case strategy == MergeTake:
+			fields[k] = v
+		case strategy == MergeStrict:
+			conflicts = append(conflicts, k)
+		}
+	}
+
+	labels := into.GetLabels()
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	for k, v := range from.GetLabels() {
+		cur, ok := labels[k]
+
+		switch {
+		case k == zebra.ParentLabel && v == into.GetID(), ok && cur == v:
+		case !ok:
+			labels[k] = v
+		case strategy == MergeTake:
+			labels[k] = v
+		case strategy == MergeStrict:
+			conflicts = append(conflicts, "labels."+k)
+		}
+	}
+
+	if len(conflicts) != 0 {
+		sort.Strings(conflicts)
+
+		return nil, conflicts, nil
+	}
+
+	if fields["labels"], err = json.Marshal(labels); err != nil {
+		return nil, nil, err
+	}
+
+	merged, err := decodeFields(decoder, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, held := merged.GetStatus(), from.GetStatus()
+	if status != nil && held != nil && status.Lease == zebra.Free && held.Lease != zebra.Free {
+		status.Lease = held.Lease
+		status.UsedBy = held.UsedBy
+	}
+
+	return merged, nil, nil
+}
+
+// emptyJSON returns true for the zero values of JSON.
+func emptyJSON(v json.RawMessage) bool {
+	switch string(v) {
+	case "null", `""`, "0", "false", "[]", "{}":
+		return true
+	default:
+		return false
+	}
+}
+
+// relinked returns a copy of the resource referring to the resource to
+// instead of the resource from, see zebra.References.
+func relinked(decoder *zebra.Decoder, res zebra.Resource, from, to string) (zebra.Resource, error) {
+	fields, err := resourceFields(res)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := json.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range fields {
+		if strings.HasSuffix(k, "ID") && string(v) == string(old) {
+			if fields[k], err = json.Marshal(to); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if labels := res.GetLabels(); labels[zebra.ParentLabel] == from {
+		labels[zebra.ParentLabel] = to
+
+		if fields["labels"], err = json.Marshal(labels); err != nil {
+			return nil, err
+		}
+	}
+
+	return decodeFields(decoder, fields)
+}
+
+// relinkedLease returns a copy of the lease holding the resource to instead
+// of the resource with the ID from, or nil if the lease does not hold it.
+func relinkedLease(l *lease.Lease, from string, to zebra.Resource) *lease.Lease {
+	requests := make([]*lease.ResourceReq, 0, len(l.RequestList()))
+	found := false
+
+	for _, r := range l.RequestList() {
+		c := *r
+		c.Resources = make([]zebra.Resource, 0, len(r.Resources))
+
+		for _, held := range r.Resources {
+			if held.GetID() == from {
+				held = to
+				found = true
+			}
+
+			c.Resources = append(c.Resources, held)
+		}
+
+		requests = append(requests, &c)
+	}
+
+	if !found {
+		return nil
+	}
+
+	c := lease.NewLease(l.Owner(), l.Duration, requests)
+	c.BaseResource = l.BaseResource
+	c.ActivationTime = l.ActivationTime
+
+	return c
+}
+
+// handleMerge merges a duplicate resource into another. It takes the
+// privilege to update the resource merged into and to delete the one merged
+// from, which is archived. With dryRun the merge is returned but not made.
+func handleMerge() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := archiveContext(res, req)
+		if !ok {
+			return
+		}
+
+		mr := new(MergeRequest)
+		if err := readJSON(ctx, req, mr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := mr.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		from, into := findResource(api.Store, mr.From), findResource(api.Store, mr.Into)
+		if from == nil || into == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionDelete, from.GetType(), from.GetLabels()) ||
+			!claims.Allows(auth.ActionUpdate, into.GetType(), into.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		m, err := api.planMerge(from, into, mr.Strategy)
+
+		switch {
+		case errors.Is(err, ErrMergeConflict):
+			writeJSONCode(ctx, res, http.StatusConflict, m.result)
+
+			return
+		case errors.Is(err, ErrMergeType):
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		case err != nil:
+			log.Error(err, "merge could not be planned", "from", mr.From, "into", mr.Into)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if err := m.result.Resource.Validate(ctx); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if !api.guardMerge(res, req, m) {
+			return
+		}
+
+		if isDryRun(req) {
+			writeJSON(ctx, res, m.result)
+
+			return
+		}
+
+		if err := api.applyMerge(ctx, m); err != nil {
+			log.Error(err, "resources could not be merged", "from", mr.From, "into", mr.Into)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "resource.merge", mr.Into, fmt.Sprintf("%s (%s)", mr.From, mergeStrategy(mr.Strategy)))
+		log.Info("resources merged", "from", mr.From, "into", mr.Into, "user", claims.Email)
+
+		writeJSON(ctx, res, m.result)
+	}
+}
+
+// guardMerge applies the protection of resources to the archival of the
+// resource merged from and the relabeling of the one merged into.
+func (api *ResourceAPI) guardMerge(res http.ResponseWriter, req *http.Request, m *merge) bool {
+	archived := zebra.NewResourceMap(api.factory)
+	archived.Add(m.from, m.from.GetType())
+
+	merged := zebra.NewResourceMap(api.factory)
+	merged.Add(m.result.Resource, m.result.Resource.GetType())
+
+	return api.guardProtected(res, req, http.MethodDelete, archived) &&
+		api.guardProtected(res, req, http.MethodPost, merged)
+}
+
+func mergeStrategy(s MergeStrategy) MergeStrategy {
+	if s == "" {
+		return MergeKeep
+	}
+
+	return s
+}
@@ -0,0 +1,132 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRequest(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Nil((&MergeRequest{From: "a", Into: "b", Strategy: ""}).Validate())
+	assert.Nil((&MergeRequest{From: "a", Into: "b", Strategy: MergeStrict}).Validate())
+	assert.Equal(ErrMergeRequest, (&MergeRequest{From: "a", Into: "a", Strategy: ""}).Validate())
+	assert.Equal(ErrMergeRequest, (&MergeRequest{From: "", Into: "a", Strategy: ""}).Validate())
+	assert.Equal(ErrMergeStrategy, (&MergeRequest{From: "a", Into: "b", Strategy: "newest"}).Validate())
+}
+
+func TestMerge(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	serve := func(claims *auth.Claims, url, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, strings.NewReader(body)).WithContext(ctx)
+
+		handleMerge()(rr, req, nil)
+
+		return rr
+	}
+
+	into := compute.NewServer([]string{"SN-1", "m0", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers", "rack": "r1"})
+	from := compute.NewServer([]string{"SN-1", "m1", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers", "rack": "r2", "row": "a"})
+	from.Asset = &zebra.Asset{PurchaseDate: "", CostCenter: "", WarrantyEnd: "", Vendor: "acme"}
+	from.Status.Lease = zebra.Leased
+	from.Status.UsedBy = "user@zebra"
+
+	esx := compute.NewESX("esx", from.ID, net.ParseIP("10.1.0.2"), zebra.Labels{"system.group": "servers"})
+	held := lease.NewLease("user@zebra", time.Hour, []*lease.ResourceReq{{
+		Type: "Server", Group: "servers", Name: "server", Count: 1, Filters: nil,
+		Resources: []zebra.Resource{from},
+	}})
+
+	for _, r := range []zebra.Resource{into, from, esx, held} {
+		assert.Nil(api.Store.Create(r))
+	}
+
+	body := func(strategy MergeStrategy) string {
+		return `{"from": "` + from.ID + `", "into": "` + into.ID + `", "strategy": "` + string(strategy) + `"}`
+	}
+
+	assert.Equal(http.StatusBadRequest, serve(admin, "/api/v1/merge", body("newest")).Code)
+	assert.Equal(http.StatusForbidden, serve(user, "/api/v1/merge", body("")).Code)
+
+	// Strict merges refuse differing values
+	rr := serve(admin, "/api/v1/merge", body(MergeStrict))
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	// Resources are decoded by type, the result without them
+	result := new(struct {
+		Archived  string   `json:"archived"`
+		Relinked  []string `json:"relinked"`
+		Leases    []string `json:"leases"`
+		Conflicts []string `json:"conflicts"`
+	})
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.Contains(result.Conflicts, "labels.rack")
+	assert.Contains(result.Conflicts, "model")
+
+	// Dry runs leave the store alone
+	rr = serve(admin, "/api/v1/merge?dryRun=true", body(""))
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotNil(findResource(api.Store, from.ID))
+
+	rr = serve(admin, "/api/v1/merge", body(MergeKeep))
+	assert.Equal(http.StatusOK, rr.Code)
+
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), result))
+	assert.Equal(from.ID, result.Archived)
+	assert.Equal([]string{esx.ID}, result.Relinked)
+	assert.Equal([]string{held.ID}, result.Leases)
+
+	merged, ok := findResource(api.Store, into.ID).(*compute.Server)
+	assert.True(ok)
+	assert.Equal("r1", merged.Labels["rack"])
+	assert.Equal("a", merged.Labels["row"])
+	assert.Equal("m0", merged.Model)
+	assert.Equal("acme", merged.Asset.Vendor)
+	assert.Equal(zebra.Leased, merged.Status.Lease)
+	assert.Equal("user@zebra", merged.Status.UsedBy)
+
+	relinked, ok := findResource(api.Store, esx.ID).(*compute.ESX)
+	assert.True(ok)
+	assert.Equal(into.ID, relinked.ServerID)
+
+	l, ok := findResource(api.Store, held.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(into.ID, l.RequestList()[0].Resources[0].GetID())
+
+	assert.Nil(findResource(api.Store, from.ID))
+	assert.NotNil(findResource(api.archive, from.ID))
+
+	entries := api.Audit.Entries()
+	assert.Equal("resource.merge", entries[len(entries)-1].Action)
+	assert.Equal(into.ID, entries[len(entries)-1].Resource)
+
+	// The merged resource is gone
+	assert.Equal(http.StatusNotFound, serve(admin, "/api/v1/merge", body("")).Code)
+}
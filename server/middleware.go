@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/metrics"
+	"gojini.dev/web"
+)
+
+// DefaultRequestTimeout is the timeout of routes without a configured timeout.
+const DefaultRequestTimeout = 30 * time.Second
+
+// OtherRoute is the metrics route label of requests outside the API routes.
+const OtherRoute = "other"
+
+var (
+	requestPanics = metrics.Default.Counter("zebra_http_panics_total",
+		"Requests that panicked, by route.", "method", "route")
+	requestTimeouts = metrics.Default.Counter("zebra_http_timeouts_total",
+		"Requests cancelled by the route timeout, by route.", "method", "route")
+)
+
+// Timeouts is the request timeout configuration of the server. Routes are
+// keyed by "METHOD /path" or "/path", with path parameters as in the router,
+// for example "POST /api/v1/resources/:id/transfer". Timeouts are durations
+// such as "10s", a zero timeout disables the timeout of the route.
+type Timeouts struct {
+	Default string            `json:"default"`
+	Routes  map[string]string `json:"routes"`
+}
+
+// routeTimeouts are the parsed Timeouts.
+type routeTimeouts struct {
+	def    time.Duration
+	keys   []string
+	routes map[string]time.Duration
+}
+
+func newRouteTimeouts(cfg *Timeouts) (*routeTimeouts, error) {
+	rt := &routeTimeouts{def: DefaultRequestTimeout, keys: []string{}, routes: map[string]time.Duration{}}
+
+	if cfg == nil {
+		return rt, nil
+	}
+
+	if cfg.Default != "" {
+		d, err := time.ParseDuration(cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+
+		rt.def = d
+	}
+
+	for key, value := range cfg.Routes {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", key, err)
+		}
+
+		rt.routes[key] = d
+		rt.keys = append(rt.keys, key)
+	}
+
+	// Routes with a method are more specific than the same route without
+	sort.Slice(rt.keys, func(i, j int) bool {
+		iMethod, jMethod := strings.Contains(rt.keys[i], " "), strings.Contains(rt.keys[j], " ")
+		if iMethod != jMethod {
+			return iMethod
+		}
+
+		return rt.keys[i] < rt.keys[j]
+	})
+
+	return rt, nil
+}
+
+// timeouter returns the timeout of a request.
+type timeouter interface {
+	timeout(req *http.Request) time.Duration
+}
+
+// timeout returns the timeout of the request.
+func (rt *routeTimeouts) timeout(req *http.Request) time.Duration {
+	for _, key := range rt.keys {
+		method, pattern, ok := strings.Cut(key, " ")
+		if !ok {
+			method, pattern = "", key
+		}
+
+		if (method == "" || method == req.Method) && matchRoute(pattern, req.URL.Path) {
+			return rt.routes[key]
+		}
+	}
+
+	return rt.def
+}
+
+// matchRoute returns true if the path matches the router pattern.
+func matchRoute(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+
+		if i >= len(pathParts) || (!strings.HasPrefix(part, ":") && part != pathParts[i]) {
+			return false
+		}
+	}
+
+	return len(patternParts) == len(pathParts)
+}
+
+// routeLabel returns the router pattern of the request, so that metrics are
+// not labeled with resource ids.
+func routeLabel(router *httprouter.Router, req *http.Request) string {
+	if router == nil {
+		return OtherRoute
+	}
+
+	handle, params, _ := router.Lookup(req.Method, req.URL.Path)
+	if handle == nil {
+		return OtherRoute
+	}
+
+	parts := strings.Split(req.URL.Path, "/")
+
+	for _, p := range params {
+		for i, part := range parts {
+			if part == p.Value {
+				parts[i] = ":" + p.Key
+
+				break
+			}
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// statusWriter remembers if the response was started and its status code.
+type statusWriter struct {
+	http.ResponseWriter
+	wrote bool
+	code  int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.code = code
+	}
+
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.code = http.StatusOK
+	}
+
+	w.wrote = true
+
+	return w.ResponseWriter.Write(data)
+}
+
+// recoverAdapter recovers from panics in the handlers it wraps, so that one
+// bad request can not take down the server. The panic is logged with its
+// stack trace and answered with a 500 if the response was not started.
+func recoverAdapter(router *httprouter.Router) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			writer := &statusWriter{ResponseWriter: res, wrote: false, code: 0}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+
+				// The server handles aborts itself
+				if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(p)
+				}
+
+				route := routeLabel(router, req)
+				requestPanics.Inc(req.Method, route)
+
+				log := logr.FromContextOrDiscard(req.Context())
+				log.Error(fmt.Errorf("%v", p), "request panicked",
+					"method", req.Method, "path", req.URL.Path, "route", route, "stack", string(debug.Stack()))
+
+				if !writer.wrote {
+					writer.WriteHeader(http.StatusInternalServerError)
+				}
+			}()
+
+			callNext(nextHandler, writer, req)
+		})
+	}
+}
+
+// timeoutAdapter cancels the request context once the timeout of the route
+// has passed and answers the request with a 503.
+func timeoutAdapter(router *httprouter.Router, timeouts timeouter) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			timeout := timeouts.timeout(req)
+			if timeout <= 0 || nextHandler == nil {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+
+			http.TimeoutHandler(nextHandler, timeout, "request timed out\n").ServeHTTP(res, req.WithContext(ctx))
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				route := routeLabel(router, req)
+				requestTimeouts.Inc(req.Method, route)
+
+				log := logr.FromContextOrDiscard(ctx)
+				log.Info("request timed out", "method", req.Method, "path", req.URL.Path,
+					"route", route, "timeout", timeout.String())
+			}
+		})
+	}
+}
+
+// metricsAdapter serves the server metrics on /metrics, without
+// authentication so that they can be scraped. Gauges of the store are
+// brought up to date before.
+func metricsAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/metrics" || req.Method != http.MethodGet {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			if api, ok := req.Context().Value(ResourcesCtxKey).(*ResourceAPI); ok {
+				api.observeIndex()
+			}
+
+			metrics.Default.Handler().ServeHTTP(res, req)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package server //nolint:testpackage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"gojini.dev/web"
+)
+
+func TestRouteTimeouts(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rt, err := newRouteTimeouts(&Timeouts{
+		Default: "5s",
+		Routes: map[string]string{
+			"/api/v1/resources":                    "10s",
+			"POST /api/v1/resources":               "1m",
+			"/api/v1/resources/:id/history":        "2s",
+			"/api/v1/files/*path":                  "0s",
+			"DELETE /api/v1/resources/:id/history": "1s",
+		},
+	})
+	assert.Nil(err)
+
+	timeout := func(method, path string) time.Duration {
+		return rt.timeout(httptest.NewRequest(method, path, nil))
+	}
+
+	assert.Equal(10*time.Second, timeout("GET", "/api/v1/resources"))
+	assert.Equal(time.Minute, timeout("POST", "/api/v1/resources"))
+	assert.Equal(2*time.Second, timeout("GET", "/api/v1/resources/abc/history"))
+	assert.Equal(time.Second, timeout("DELETE", "/api/v1/resources/abc/history"))
+	assert.Equal(time.Duration(0), timeout("GET", "/api/v1/files/a/b"))
+	assert.Equal(5*time.Second, timeout("GET", "/api/v1/types"))
+	assert.Equal(5*time.Second, timeout("GET", "/api/v1/resources/abc"))
+
+	rt, err = newRouteTimeouts(nil)
+	assert.Nil(err)
+	assert.Equal(DefaultRequestTimeout, rt.timeout(httptest.NewRequest("GET", "/", nil)))
+
+	_, err = newRouteTimeouts(&Timeouts{Default: "soon", Routes: nil})
+	assert.NotNil(err)
+
+	_, err = newRouteTimeouts(&Timeouts{Default: "", Routes: map[string]string{"/": "later"}})
+	assert.NotNil(err)
+}
+
+func TestRouteLabel(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	router, ok := routeHandler().(*httprouter.Router)
+	assert.True(ok)
+
+	label := func(method, path string) string {
+		return routeLabel(router, httptest.NewRequest(method, path, nil))
+	}
+
+	assert.Equal("/api/v1/resources", label("GET", "/api/v1/resources"))
+	assert.Equal("/api/v1/resources/:id/history", label("GET", "/api/v1/resources/abc/history"))
+	assert.Equal(OtherRoute, label("GET", "/login"))
+	assert.Equal(OtherRoute, routeLabel(nil, httptest.NewRequest("GET", "/api/v1/resources", nil)))
+}
+
+func TestRecoverAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	router, _ := routeHandler().(*httprouter.Router)
+	before := requestPanics.Value("GET", "/api/v1/types")
+
+	handler := web.Wrap(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("bad handler")
+	}), recoverAdapter(router))
+
+	rr := httptest.NewRecorder()
+	assert.NotPanics(func() { handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/types", nil)) })
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	assert.Equal(before+1, requestPanics.Value("GET", "/api/v1/types"))
+
+	// A started response is left alone
+	handler = web.Wrap(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusAccepted)
+		panic("bad handler")
+	}), recoverAdapter(router))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/types", nil))
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	// Aborts are passed on to the server
+	handler = web.Wrap(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), recoverAdapter(router))
+
+	assert.Panics(func() { handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)) })
+
+	testForward(assert, recoverAdapter(router))
+}
+
+func TestTimeoutAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	router, _ := routeHandler().(*httprouter.Router)
+	timeouts, err := newRouteTimeouts(&Timeouts{
+		Default: "1s",
+		Routes:  map[string]string{"/api/v1/labels": "20ms"},
+	})
+	assert.Nil(err)
+
+	before := requestTimeouts.Value("GET", "/api/v1/labels")
+	cancelled := make(chan bool, 1)
+
+	slow := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- true
+		case <-time.After(time.Second):
+			cancelled <- false
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	web.Wrap(slow, timeoutAdapter(router, timeouts)).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/labels", nil))
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.True(<-cancelled)
+	assert.Equal(before+1, requestTimeouts.Value("GET", "/api/v1/labels"))
+
+	// Panics in the handler reach the recover adapter
+	panicky := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("bad handler")
+	})
+
+	rr = httptest.NewRecorder()
+	web.Wrap(panicky, recoverAdapter(router), timeoutAdapter(router, timeouts)).ServeHTTP(
+		rr, httptest.NewRequest("GET", "/api/v1/types", nil))
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+
+	testForward(assert, timeoutAdapter(router, timeouts))
+}
+
+func TestMetricsAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	requestPanics.Add(0, "GET", "/api/v1/types")
+
+	rr := httptest.NewRecorder()
+	metricsAdapter()(nil).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.True(strings.Contains(rr.Body.String(), "# TYPE zebra_http_panics_total counter"))
+
+	testForward(assert, metricsAdapter())
+}
@@ -0,0 +1,519 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/segmentstore"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+// MigrateCheckpointFile is kept in the destination of a migration until it
+// is verified, so that an interrupted migration resumes where it stopped.
+const MigrateCheckpointFile = "migrate.checkpoint"
+
+// DefaultMigrateBatch is the number of resources copied between checkpoints.
+const DefaultMigrateBatch = 1000
+
+var (
+	ErrMigrateRoots      = errors.New("migration source and destination must be different directories")
+	ErrMigrateCheckpoint = errors.New("checkpoint in the destination is of another migration")
+	ErrMigrateVerify     = errors.New("migrated store does not match its source")
+)
+
+// migratedStores are the resource stores of a storage root, by their
+// directory in the root. The root itself holds the resources, the archive
+// the archived ones.
+var migratedStores = []string{"", "archive"} //nolint:gochecknoglobals
+
+// MigrateOptions are the stores to migrate from and to.
+type MigrateOptions struct {
+	From   string
+	To     string
+	Source string
+	Dest   string
+	Batch  int
+}
+
+// MigrateCheckpoint is the progress of a migration: the last resource ID
+// copied of each store, in ID order, and whether the other files of the
+// storage root were copied.
+type MigrateCheckpoint struct {
+	From   string            `json:"from"`
+	To     string            `json:"to"`
+	Source string            `json:"source"`
+	Stores map[string]string `json:"stores"`
+	Files  bool              `json:"files"`
+}
+
+// StoreVerification compares the count and checksum of the resources of a
+// store with those of its copy.
+type StoreVerification struct {
+	Store        string `json:"store"`
+	Count        int    `json:"count"`
+	DestCount    int    `json:"destCount"`
+	Checksum     string `json:"checksum"`
+	DestChecksum string `json:"destChecksum"`
+	Resumed      int    `json:"resumed,omitempty"`
+}
+
+// FileVerification compares the checksum of a file of the storage root,
+// such as the audit log or the history, with that of its copy.
+type FileVerification struct {
+	File         string `json:"file"`
+	Checksum     string `json:"checksum"`
+	DestChecksum string `json:"destChecksum"`
+}
+
+// MigrateReport is the outcome of a migration.
+type MigrateReport struct {
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Stores   []StoreVerification `json:"stores"`
+	Files    []FileVerification  `json:"files"`
+	Verified bool                `json:"verified"`
+}
+
+// SchemaMigration is the number of resources of each type of a store that
+// were rewritten at the current schema version of their type.
+type SchemaMigration struct {
+	Store    string         `json:"store"`
+	Migrated map[string]int `json:"migrated"`
+}
+
+func NewMigrateCmd() *cobra.Command {
+	migrateCmd := new(cobra.Command)
+
+	migrateCmd.Use = "migrate"
+	migrateCmd.Short = "copy a store to another storage root and format, and verify the copy"
+	migrateCmd.RunE = runMigrate
+	migrateCmd.SilenceUsage = true
+
+	migrateCmd.Flags().String("from", store.FormatFiles, "format of the source store, files or segments")
+	migrateCmd.Flags().String("to", store.FormatSegments, "format of the destination store, files or segments")
+	migrateCmd.Flags().String("source", cwd("zebra-store"), "source storage root (default: $PWD/zebra-store)")
+	migrateCmd.Flags().String("dest", "", "destination storage root")
+	migrateCmd.Flags().Int("batch", DefaultMigrateBatch, "resources copied between checkpoints")
+
+	_ = migrateCmd.MarkFlagRequired("dest")
+
+	return migrateCmd
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	batch, err := cmd.Flags().GetInt("batch")
+	if err != nil {
+		return err
+	}
+
+	report, migrateErr := migrateStore(MigrateOptions{
+		From:   cmd.Flag("from").Value.String(),
+		To:     cmd.Flag("to").Value.String(),
+		Source: cmd.Flag("source").Value.String(),
+		Dest:   cmd.Flag("dest").Value.String(),
+		Batch:  batch,
+	})
+
+	if report != nil {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), string(data))
+	}
+
+	return migrateErr
+}
+
+// migrateStore copies the resources of the stores of the source storage
+// root to the destination in the destination format, and the other files of
+// the root as they are. The copy is verified by the count and checksum of
+// the resources of each store and the checksum of each file. The checkpoint
+// in the destination lets a migration that was interrupted resume, it is
+// removed once the copy is verified.
+func migrateStore(opts MigrateOptions) (*MigrateReport, error) {
+	for _, format := range []string{opts.From, opts.To} {
+		if format != store.FormatFiles && format != store.FormatSegments {
+			return nil, fmt.Errorf("%w: %s", store.ErrFormat, format)
+		}
+	}
+
+	source, err := filepath.Abs(opts.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := filepath.Abs(opts.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if source == dest {
+		return nil, ErrMigrateRoots
+	}
+
+	opts.Source, opts.Dest = source, dest
+
+	if opts.Batch <= 0 {
+		opts.Batch = DefaultMigrateBatch
+	}
+
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	checkpoint, err := loadCheckpoint(dest, opts.From, opts.To, source)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrateReport{
+		From: opts.From, To: opts.To, Stores: []StoreVerification{}, Files: []FileVerification{}, Verified: false,
+	}
+
+	for _, name := range migratedStores {
+		v, err := migrateResources(path.Join(source, name), path.Join(dest, name), opts, checkpoint, name)
+		if err != nil {
+			return report, err
+		}
+
+		report.Stores = append(report.Stores, v)
+	}
+
+	files, err := rootFiles(source)
+	if err != nil {
+		return report, err
+	}
+
+	if !checkpoint.Files {
+		for _, f := range files {
+			if err := copyFile(path.Join(source, f), path.Join(dest, f)); err != nil {
+				return report, err
+			}
+		}
+
+		checkpoint.Files = true
+		if err := checkpoint.save(dest); err != nil {
+			return report, err
+		}
+	}
+
+	report.Verified = true
+
+	for _, f := range files {
+		v := FileVerification{File: f, Checksum: "", DestChecksum: ""}
+
+		if v.Checksum, err = fileChecksum(path.Join(source, f)); err != nil {
+			return report, err
+		}
+
+		v.DestChecksum, _ = fileChecksum(path.Join(dest, f))
+		report.Verified = report.Verified && v.Checksum == v.DestChecksum
+		report.Files = append(report.Files, v)
+	}
+
+	for _, v := range report.Stores {
+		report.Verified = report.Verified && v.Count == v.DestCount && v.Checksum == v.DestChecksum
+	}
+
+	if !report.Verified {
+		return report, ErrMigrateVerify
+	}
+
+	return report, os.Remove(path.Join(dest, MigrateCheckpointFile))
+}
+
+// migrateResources copies the resources of the store that were not copied
+// before, in ID order, and verifies the copy with a fresh view of the
+// destination.
+func migrateResources(source string, dest string, opts MigrateOptions, checkpoint *MigrateCheckpoint,
+	name string,
+) (StoreVerification, error) {
+	v := StoreVerification{Store: name, Count: 0, DestCount: 0, Checksum: "", DestChecksum: "", Resumed: 0}
+
+	src, err := openStore(source, opts.From)
+	if err != nil {
+		return v, err
+	}
+
+	dst, err := openStore(dest, opts.To)
+	if err != nil {
+		return v, err
+	}
+
+	resources := sortedResources(src.Query())
+	last := checkpoint.Stores[name]
+
+	for i, res := range resources {
+		if res.GetID() <= last {
+			v.Resumed++
+
+			continue
+		}
+
+		if err := dst.Create(res); err != nil {
+			return v, err
+		}
+
+		if (i+1)%opts.Batch == 0 || i == len(resources)-1 {
+			checkpoint.Stores[name] = res.GetID()
+			if err := checkpoint.save(opts.Dest); err != nil {
+				return v, err
+			}
+		}
+	}
+
+	if dst, err = openStore(dest, opts.To); err != nil {
+		return v, err
+	}
+
+	if v.Count, v.Checksum, err = storeChecksum(src); err != nil {
+		return v, err
+	}
+
+	v.DestCount, v.DestChecksum, err = storeChecksum(dst)
+
+	return v, err
+}
+
+func openStore(root string, format string) (*store.ResourceStore, error) {
+	s := store.NewResourceStore(root, store.DefaultFactory())
+	s.Format = format
+
+	return s, s.Initialize()
+}
+
+func sortedResources(resMap *zebra.ResourceMap) []zebra.Resource {
+	resources := []zebra.Resource{}
+
+	_ = applyFunc(resMap, func(res zebra.Resource) error {
+		resources = append(resources, res)
+
+		return nil
+	})
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].GetID() < resources[j].GetID() })
+
+	return resources
+}
+
+// storeChecksum returns the number of resources of the store and the
+// checksum of their JSON in ID order.
+func storeChecksum(s zebra.Store) (int, string, error) {
+	resources := sortedResources(s.Query())
+	hash := sha256.New()
+
+	for _, res := range resources {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return 0, "", err
+		}
+
+		hash.Write(data)
+	}
+
+	return len(resources), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// rootFiles returns the files of the storage root that are not kept by the
+// resource stores, such as the audit log, the history and the attachments,
+// relative to the root.
+func rootFiles(root string) ([]string, error) {
+	skip := map[string]bool{
+		"resources": true, segmentstore.SegmentsDir: true, segmentstore.MigratedDir: true,
+		MigrateCheckpointFile: true,
+	}
+
+	for _, name := range migratedStores {
+		if name != "" {
+			skip[name] = true
+		}
+	}
+
+	files := []string{}
+
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if skip[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			files = append(files, rel)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func copyFile(source string, dest string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
+
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// loadCheckpoint returns the checkpoint of the migration in the destination,
+// a new one if there is none.
+func loadCheckpoint(dest string, from string, to string, source string) (*MigrateCheckpoint, error) {
+	checkpoint := &MigrateCheckpoint{From: from, To: to, Source: source, Stores: map[string]string{}, Files: false}
+
+	data, err := os.ReadFile(path.Join(dest, MigrateCheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	saved := new(MigrateCheckpoint)
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, err
+	}
+
+	if saved.From != from || saved.To != to || saved.Source != source {
+		return nil, ErrMigrateCheckpoint
+	}
+
+	if saved.Stores == nil {
+		saved.Stores = map[string]string{}
+	}
+
+	return saved, nil
+}
+
+func (c *MigrateCheckpoint) save(dest string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	file := path.Join(dest, MigrateCheckpointFile)
+	tmp := file + ".tmp"
+
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+func NewMigrateSchemaCmd() *cobra.Command {
+	schemaCmd := new(cobra.Command)
+
+	schemaCmd.Use = "migrate-schema"
+	schemaCmd.Short = "rewrite the stored resources at the current schema versions of their types"
+	schemaCmd.RunE = runMigrateSchema
+	schemaCmd.SilenceUsage = true
+
+	schemaCmd.Flags().String("store", cwd("zebra-store"), "storage root (default: $PWD/zebra-store)")
+	schemaCmd.Flags().String("format", store.FormatFiles, "format of the store, files or segments")
+	schemaCmd.Flags().StringSlice("type", nil, "resource types to migrate (default: all)")
+
+	return schemaCmd
+}
+
+func runMigrateSchema(cmd *cobra.Command, args []string) error {
+	types, err := cmd.Flags().GetStringSlice("type")
+	if err != nil {
+		return err
+	}
+
+	migrations, migrateErr := migrateSchemas(cmd.Flag("store").Value.String(), cmd.Flag("format").Value.String(), types)
+
+	data, err := json.MarshalIndent(migrations, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), string(data))
+
+	return migrateErr
+}
+
+// migrateSchemas rewrites the resources of the stores of the storage root
+// that are of types with schema migrations. Stored resources are migrated
+// each time they are loaded until they are rewritten.
+func migrateSchemas(root string, format string, types []string) ([]SchemaMigration, error) {
+	migrations := []SchemaMigration{}
+
+	for _, name := range migratedStores {
+		s, err := openStore(path.Join(root, name), format)
+		if err != nil {
+			return migrations, err
+		}
+
+		migrated, err := s.MigrateSchemas(types)
+		migrations = append(migrations, SchemaMigration{Store: name, Migrated: migrated})
+
+		if err != nil {
+			return migrations, err
+		}
+	}
+
+	return migrations, nil
+}
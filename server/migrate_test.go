@@ -0,0 +1,143 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	source := path.Join(dir, "source")
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(source))
+
+	ctx := context.Background()
+	labs := []*dc.Lab{}
+
+	for _, name := range []string{"a", "b", "c"} {
+		l := dc.NewLab(name, zebra.Labels{"system.group": "labs"})
+		labs = append(labs, l)
+		assert.Nil(api.create(ctx, l))
+	}
+
+	assert.Nil(api.archive.Create(dc.NewLab("old", zebra.Labels{"system.group": "labs"})))
+	api.recordSystemAudit("resource.apply", labs[0].ID, "Lab")
+	assert.Nil(os.MkdirAll(path.Join(source, "attachments", "ab"), os.ModePerm))
+	assert.Nil(os.WriteFile(path.Join(source, "attachments", "ab", "cdef"), []byte("manual"), ReadWriteOnly))
+
+	// Formats must be known and the roots different
+	_, err := migrateStore(MigrateOptions{From: "files", To: "postgres", Source: source, Dest: source, Batch: 0})
+	assert.ErrorIs(err, store.ErrFormat)
+
+	_, err = migrateStore(MigrateOptions{From: "files", To: "segments", Source: source, Dest: source + "/", Batch: 0})
+	assert.ErrorIs(err, ErrMigrateRoots)
+
+	dest := path.Join(dir, "dest")
+	cmd := NewMigrateCmd()
+	out := new(bytes.Buffer)
+
+	cmd.SetOut(out)
+	cmd.SetArgs([]string{"--source", source, "--dest", dest, "--batch", "2"})
+	assert.Nil(cmd.Execute())
+
+	report := new(MigrateReport)
+	assert.Nil(json.Unmarshal(out.Bytes(), report))
+	assert.True(report.Verified)
+	assert.Equal(3, report.Stores[0].DestCount)
+	assert.Equal(1, report.Stores[1].DestCount)
+
+	files := map[string]bool{}
+	for _, f := range report.Files {
+		files[f.File] = true
+	}
+
+	assert.True(files["audit.log"])
+	assert.True(files["history.log"])
+	assert.True(files["attachments/ab/cdef"])
+
+	_, err = os.Stat(path.Join(dest, MigrateCheckpointFile))
+	assert.True(os.IsNotExist(err))
+
+	migrated, err := openStore(dest, store.FormatSegments)
+	assert.Nil(err)
+	assert.NotNil(findResource(migrated, labs[2].ID))
+
+	// An interrupted migration resumes after the last resource copied
+	resumed := path.Join(dir, "resumed")
+	first := sortedResources(api.Store.Query())[0]
+
+	partial, err := openStore(resumed, store.FormatSegments)
+	assert.Nil(err)
+	assert.Nil(partial.Create(first))
+
+	checkpoint := &MigrateCheckpoint{
+		From: store.FormatFiles, To: store.FormatSegments, Source: source,
+		Stores: map[string]string{"": first.GetID()}, Files: false,
+	}
+	assert.Nil(checkpoint.save(resumed))
+
+	report, err = migrateStore(MigrateOptions{
+		From: store.FormatFiles, To: store.FormatSegments, Source: source, Dest: resumed, Batch: 0,
+	})
+	assert.Nil(err)
+	assert.True(report.Verified)
+	assert.Equal(1, report.Stores[0].Resumed)
+
+	// Resources the checkpoint claims but the destination lacks fail the
+	// verification, and the checkpoint is kept
+	broken := path.Join(dir, "broken")
+	assert.Nil(os.MkdirAll(broken, os.ModePerm))
+	assert.Nil(checkpoint.save(broken))
+
+	report, err = migrateStore(MigrateOptions{
+		From: store.FormatFiles, To: store.FormatSegments, Source: source, Dest: broken, Batch: 0,
+	})
+	assert.ErrorIs(err, ErrMigrateVerify)
+	assert.False(report.Verified)
+	assert.Equal(2, report.Stores[0].DestCount)
+
+	_, err = migrateStore(MigrateOptions{
+		From: store.FormatSegments, To: store.FormatFiles, Source: source, Dest: broken, Batch: 0,
+	})
+	assert.ErrorIs(err, ErrMigrateCheckpoint)
+}
+
+func TestMigrateSchema(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+	assert.Nil(api.create(context.Background(), dc.NewLab("a", zebra.Labels{"system.group": "labs"})))
+
+	cmd := NewMigrateSchemaCmd()
+	out := new(bytes.Buffer)
+
+	cmd.SetOut(out)
+	cmd.SetArgs([]string{"--store", root})
+	assert.Nil(cmd.Execute())
+
+	// None of the types have migrations yet
+	migrations := []SchemaMigration{}
+	assert.Nil(json.Unmarshal(out.Bytes(), &migrations))
+	assert.Equal(len(migratedStores), len(migrations))
+	assert.Empty(migrations[0].Migrated)
+
+	cmd.SetArgs([]string{"--store", root, "--type", "Spaceship"})
+	assert.ErrorIs(cmd.Execute(), zebra.ErrUnknownType)
+}
@@ -0,0 +1,619 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+var (
+	ErrNameRule     = errors.New("naming rules need a kind and either a string field of known types or a label")
+	ErrNameClaim    = errors.New("names need a kind and a name")
+	ErrNameAdmin    = errors.New("binding and releasing names requires admin privileges")
+	ErrNameNotFound = errors.New("name is not claimed")
+	ErrNameOwner    = errors.New("only the owner of a reservation or an admin may release it")
+)
+
+// NameRule makes the values of a field, or of a label, of resources of the
+// types names of the kind; label rules without types apply to all types.
+// Names of a kind are unique across all types, namespaces and sites, such as
+// the hostnames of servers and VMs, or the asset tags of all hardware. Names
+// are compared without case and surrounding space.
+type NameRule struct {
+	Kind  string   `json:"kind"`
+	Types []string `json:"types,omitempty"`
+	Field string   `json:"field,omitempty"`
+	Label string   `json:"label,omitempty"`
+}
+
+// NamingConfig is the naming section of the server configuration. Without a
+// registry the server keeps the names itself, with one the names are bound
+// at the registry, another zebra server that all sites share, as the
+// resources of the site.
+type NamingConfig struct {
+	Rules    []NameRule  `json:"rules,omitempty"`
+	Site     string      `json:"site,omitempty"`
+	Registry *SiteConfig `json:"registry,omitempty"`
+}
+
+// NameClaim is a name bound to a resource of a site, or reserved by its
+// owner before the resource exists, such as the hostname of a server that
+// is yet to be delivered. A reservation lapses once it expires, if it does.
+type NameClaim struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Owner    string    `json:"owner,omitempty"`
+	Resource string    `json:"resource,omitempty"`
+	Site     string    `json:"site,omitempty"`
+	Claimed  time.Time `json:"claimed,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// NameConflict is a claim refused because another one holds the name.
+type NameConflict struct {
+	Claim  NameClaim `json:"claim"`
+	Holder NameClaim `json:"holder"`
+}
+
+func nameKey(kind string, name string) string {
+	return kind + "/" + strings.ToLower(strings.TrimSpace(name))
+}
+
+func (c NameClaim) expired(now time.Time) bool {
+	return c.Resource == "" && !c.Expires.IsZero() && !now.Before(c.Expires)
+}
+
+// holds returns whether the claim may take the name held by the other
+// claim: names of a resource go to the same resource only, reservations to
+// their owner.
+func (c NameClaim) holds(other NameClaim, now time.Time) bool {
+	if other.expired(now) {
+		return true
+	}
+
+	if other.Resource != "" {
+		return c.Resource == other.Resource && c.Site == other.Site
+	}
+
+	return c.Owner == other.Owner
+}
+
+// nameRegistry keeps the claimed names by kind and name. If a path is given,
+// the names are written to that file on every change.
+type nameRegistry struct {
+	lock   sync.Mutex
+	path   string
+	Claims map[string]NameClaim `json:"claims"`
+}
+
+func newNameRegistry(path string) *nameRegistry {
+	return &nameRegistry{lock: sync.Mutex{}, path: path, Claims: map[string]NameClaim{}}
+}
+
+// load reads the names from the backing file, if any.
+func (r *nameRegistry) load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, r)
+}
+
+func (r *nameRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
+
+// bind claims the names, all of them or none if any is held by another
+// claim. Binding a resource to a name releases the other names of the kind
+// it was bound to, as the resource was renamed. A dry run only checks.
+func (r *nameRegistry) bind(claims []NameClaim, now time.Time, dryRun bool) ([]NameConflict, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	conflicts := []NameConflict{}
+	pending := map[string]NameClaim{}
+
+	for _, c := range claims {
+		key := nameKey(c.Kind, c.Name)
+
+		holder, ok := pending[key]
+		if !ok {
+			holder, ok = r.Claims[key]
+		}
+
+		if ok && !c.holds(holder, now) {
+			conflicts = append(conflicts, NameConflict{Claim: c, Holder: holder})
+
+			continue
+		}
+
+		pending[key] = c
+	}
+
+	if len(conflicts) != 0 || dryRun {
+		return conflicts, nil
+	}
+
+	for _, c := range claims {
+		if c.Resource != "" {
+			for key, other := range r.Claims {
+				if other.Kind == c.Kind && other.Resource == c.Resource && other.Site == c.Site {
+					delete(r.Claims, key)
+				}
+			}
+		}
+	}
+
+	for _, c := range claims {
+		c.Claimed = now
+		r.Claims[nameKey(c.Kind, c.Name)] = c
+	}
+
+	return conflicts, r.save()
+}
+
+// release releases the name and returns its claim.
+func (r *nameRegistry) release(kind string, name string, allowed func(NameClaim) bool) (NameClaim, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := nameKey(kind, name)
+
+	c, ok := r.Claims[key]
+	if !ok {
+		return NameClaim{}, ErrNameNotFound
+	}
+
+	if !allowed(c) {
+		return c, ErrNameOwner
+	}
+
+	delete(r.Claims, key)
+
+	return c, r.save()
+}
+
+// unbind releases the names bound to the resources of the claims.
+func (r *nameRegistry) unbind(claims []NameClaim) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, c := range claims {
+		key := nameKey(c.Kind, c.Name)
+		if other, ok := r.Claims[key]; ok && other.Resource == c.Resource && other.Site == c.Site {
+			delete(r.Claims, key)
+		}
+	}
+
+	return r.save()
+}
+
+// list returns the claims of the kind, of all kinds if it is empty, that
+// have not expired, sorted by kind and name.
+func (r *nameRegistry) list(kind string, now time.Time) []NameClaim {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	claims := []NameClaim{}
+
+	for _, c := range r.Claims {
+		if (kind == "" || c.Kind == kind) && !c.expired(now) {
+			claims = append(claims, c)
+		}
+	}
+
+	sort.Slice(claims, func(i, j int) bool {
+		return nameKey(claims[i].Kind, claims[i].Name) < nameKey(claims[j].Kind, claims[j].Name)
+	})
+
+	return claims
+}
+
+// naming enforces the naming rules on the resources written to the server,
+// with the names kept by the server itself or by a remote registry.
+type naming struct {
+	rules  []NameRule
+	site   string
+	remote *site
+}
+
+// newNaming returns the naming of the configuration, or nil if it has no
+// rules.
+func newNaming(factory zebra.ResourceFactory, cfg *NamingConfig) (*naming, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.Kind == "" || (rule.Field == "") == (rule.Label == "") || (rule.Field != "" && len(rule.Types) == 0) {
+			return nil, ErrNameRule
+		}
+
+		for _, t := range rule.Types {
+			if _, ok := factory.Type(t); !ok {
+				return nil, fmt.Errorf("%w: unknown type %s", ErrNameRule, t)
+			}
+
+			if rule.Field == "" {
+				continue
+			}
+
+			if _, ok := fieldValue(factory.New(t), rule.Field); !ok {
+				return nil, fmt.Errorf("%w: %s has no field %s", ErrNameRule, t, rule.Field)
+			}
+		}
+	}
+
+	n := &naming{rules: cfg.Rules, site: cfg.Site, remote: nil}
+
+	if cfg.Registry != nil {
+		remote, err := newSite(*cfg.Registry, DefaultFederationTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("naming registry: %w", err)
+		}
+
+		n.remote = remote
+	}
+
+	return n, nil
+}
+
+// claims returns the names of the resources, as bound by the owner.
+func (n *naming) claims(resMap *zebra.ResourceMap, owner string) []NameClaim {
+	claims := []NameClaim{}
+
+	_ = applyFunc(resMap, func(res zebra.Resource) error {
+		for _, rule := range n.rules {
+			if len(rule.Types) != 0 && !zebra.IsIn(res.GetType(), rule.Types) {
+				continue
+			}
+
+			name := strings.TrimSpace(res.GetLabels()[rule.Label])
+			if rule.Field != "" {
+				name, _ = fieldValue(res, rule.Field)
+			}
+
+			if name != "" {
+				claims = append(claims, NameClaim{
+					Kind: rule.Kind, Name: strings.ToLower(name), Owner: owner, Resource: res.GetID(), Site: n.site,
+					Claimed: time.Time{}, Expires: time.Time{}, Note: "",
+				})
+			}
+		}
+
+		return nil
+	})
+
+	return claims
+}
+
+// bind binds the claims at the registry, of the server or remote.
+func (api *ResourceAPI) bindNames(ctx context.Context, claims []NameClaim, dryRun bool) ([]NameConflict, error) {
+	if api.naming == nil || api.naming.remote == nil {
+		return api.names.bind(claims, time.Now(), dryRun)
+	}
+
+	path := "/api/v1/names/bind"
+	if dryRun {
+		path += "?dryRun=true"
+	}
+
+	conflicts := []NameConflict{}
+
+	code, data, err := api.naming.remote.call(ctx, http.MethodPost, path, claims)
+	if code == http.StatusConflict && json.Unmarshal(data, &conflicts) == nil {
+		return conflicts, nil
+	}
+
+	return conflicts, err
+}
+
+// guardNames binds the names of the resources of resMap to them and returns
+// the claims bound, it writes the conflicts and returns false if any of the
+// names is held by another resource or reservation. A dry run only checks
+// the names.
+func (api *ResourceAPI) guardNames(res http.ResponseWriter, req *http.Request,
+	resMap *zebra.ResourceMap,
+) ([]NameClaim, bool) {
+	if api.naming == nil {
+		return nil, true
+	}
+
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+
+	claims := api.naming.claims(resMap, actor(ctx))
+	if len(claims) == 0 {
+		return nil, true
+	}
+
+	conflicts, err := api.bindNames(ctx, claims, isDryRun(req))
+	if err != nil {
+		log.Error(err, "names could not be bound")
+		res.WriteHeader(http.StatusBadGateway)
+
+		return nil, false
+	}
+
+	if len(conflicts) != 0 {
+		writeJSONCode(ctx, res, http.StatusConflict, conflicts)
+
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// rollbackNames releases the names bound to the resources that were not
+// created and binds their stored versions to the names they had before.
+func (api *ResourceAPI) rollbackNames(ctx context.Context, claims []NameClaim, created map[string]bool) {
+	failed := []NameClaim{}
+	ids := []string{}
+
+	for _, c := range claims {
+		if !created[c.Resource] {
+			failed = append(failed, c)
+			ids = append(ids, c.Resource)
+		}
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+
+	log := logr.FromContextOrDiscard(ctx)
+
+	if err := api.unbindNames(ctx, failed); err != nil {
+		log.Error(err, "names of resources not created could not be released")
+
+		return
+	}
+
+	previous := api.naming.claims(api.Store.QueryUUID(ids), actor(ctx))
+	if len(previous) == 0 {
+		return
+	}
+
+	if _, err := api.bindNames(ctx, previous, false); err != nil {
+		log.Error(err, "names of stored resources could not be bound again")
+	}
+}
+
+// unbindNames releases the names the deleted resources were bound to.
+func (api *ResourceAPI) unbindNames(ctx context.Context, claims []NameClaim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+
+	if api.naming.remote == nil {
+		return api.names.unbind(claims)
+	}
+
+	_, _, err := api.naming.remote.call(ctx, http.MethodPost, "/api/v1/names/release", claims)
+
+	return err
+}
+
+// handleNames lists the claimed names, of the kind parameter if it is set.
+func handleNames() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.names.list(req.URL.Query().Get("kind"), time.Now()))
+	}
+}
+
+// handleReserveName reserves a name for the user, at the registry if the
+// server has a remote one.
+func handleReserveName() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		c := new(NameClaim)
+		if err := readJSON(ctx, req, c); err != nil || c.Kind == "" || strings.TrimSpace(c.Name) == "" {
+			http.Error(res, ErrNameClaim.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		reservation := NameClaim{
+			Kind: c.Kind, Name: strings.ToLower(strings.TrimSpace(c.Name)), Owner: claims.Email, Resource: "",
+			Site: "", Claimed: time.Time{}, Expires: c.Expires, Note: c.Note,
+		}
+
+		conflicts, err := api.bindNames(ctx, []NameClaim{reservation}, isDryRun(req))
+		if err != nil {
+			res.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		if len(conflicts) != 0 {
+			writeJSONCode(ctx, res, http.StatusConflict, conflicts)
+
+			return
+		}
+
+		if !isDryRun(req) {
+			api.recordAudit(ctx, "name.reserve", nameKey(reservation.Kind, reservation.Name), reservation.Note)
+		}
+
+		writeJSON(ctx, res, reservation)
+	}
+}
+
+// handleReleaseName releases a name of the registry of the server. Only
+// admins may release the names of resources.
+func handleReleaseName() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		c, err := api.names.release(params.ByName("kind"), params.ByName("name"), func(c NameClaim) bool {
+			return claims.Write(AdminKey) || (c.Resource == "" && c.Owner == claims.Email)
+		})
+
+		switch {
+		case errors.Is(err, ErrNameNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrNameOwner):
+			http.Error(res, err.Error(), http.StatusForbidden)
+		case err != nil:
+			res.WriteHeader(http.StatusInternalServerError)
+		default:
+			api.recordAudit(ctx, "name.release", nameKey(c.Kind, c.Name), c.Resource)
+			writeJSON(ctx, res, c)
+		}
+	}
+}
+
+// handleBindNames binds the names of the resources of a site, or the
+// reservations of its users, for the sites that share the registry.
+func handleBindNames() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, claims, names, ok := nameClaimsRequest(res, req)
+
+		if !ok {
+			return
+		}
+
+		conflicts, err := api.names.bind(names, time.Now(), isDryRun(req))
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if len(conflicts) != 0 {
+			writeJSONCode(ctx, res, http.StatusConflict, conflicts)
+
+			return
+		}
+
+		if !isDryRun(req) {
+			api.recordAudit(ctx, "name.bind", claims.Email, fmt.Sprintf("%d names", len(names)))
+		}
+
+		writeJSON(ctx, res, names)
+	}
+}
+
+// handleUnbindNames releases the names of deleted resources of a site.
+func handleUnbindNames() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, _, names, ok := nameClaimsRequest(res, req)
+
+		if !ok {
+			return
+		}
+
+		if err := api.names.unbind(names); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// nameClaimsRequest returns the api, the claims of the admin and the names
+// of the request.
+func nameClaimsRequest(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, []NameClaim,
+	bool,
+) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, nil, false
+	}
+
+	if !claims.Write(AdminKey) {
+		http.Error(res, ErrNameAdmin.Error(), http.StatusForbidden)
+
+		return nil, nil, nil, false
+	}
+
+	names := []NameClaim{}
+	if err := readJSON(ctx, req, &names); err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+
+		return nil, nil, nil, false
+	}
+
+	for i, c := range names {
+		if c.Kind == "" || strings.TrimSpace(c.Name) == "" {
+			http.Error(res, ErrNameClaim.Error(), http.StatusBadRequest)
+
+			return nil, nil, nil, false
+		}
+
+		names[i].Name = strings.ToLower(strings.TrimSpace(c.Name))
+	}
+
+	return api, claims, names, true
+}
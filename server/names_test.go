@@ -0,0 +1,292 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/faults"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func namesRequest(api *ResourceAPI, claims *auth.Claims, method string, target string, body interface{},
+	handle httprouter.Handle,
+) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	req := httptest.NewRequest(method, target, bytes.NewReader(payload))
+	rr := httptest.NewRecorder()
+	handle(rr, req.WithContext(ctx), nil)
+
+	return rr
+}
+
+func labMap(labs ...*dc.Lab) *zebra.ResourceMap {
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	for _, l := range labs {
+		resMap.Add(l, l.Type)
+	}
+
+	return resMap
+}
+
+func TestNewNaming(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	factory := store.DefaultFactory()
+
+	n, err := newNaming(factory, &NamingConfig{Rules: nil, Site: "", Registry: nil})
+	assert.Nil(err)
+	assert.Nil(n)
+
+	for _, rule := range []NameRule{
+		{Kind: "", Types: []string{"Lab"}, Field: "name", Label: ""},
+		{Kind: "hostname", Types: nil, Field: "name", Label: ""},
+		{Kind: "hostname", Types: []string{"Lab"}, Field: "name", Label: "host"},
+		{Kind: "hostname", Types: []string{"Lab"}, Field: "color", Label: ""},
+		{Kind: "hostname", Types: []string{"Spaceship"}, Field: "name", Label: ""},
+	} {
+		_, err := newNaming(factory, &NamingConfig{Rules: []NameRule{rule}, Site: "", Registry: nil})
+		assert.ErrorIs(err, ErrNameRule)
+	}
+
+	n, err = newNaming(factory, &NamingConfig{Rules: []NameRule{
+		{Kind: "hostname", Types: []string{"Lab"}, Field: "name", Label: ""},
+		{Kind: "assetTag", Types: nil, Field: "", Label: "asset.tag"},
+	}, Site: "east", Registry: nil})
+	assert.Nil(err)
+
+	claims := n.claims(labMap(dc.NewLab(" Lab-1 ", zebra.Labels{"asset.tag": "A-7"})), "alice@zebra")
+	assert.Equal(2, len(claims))
+	assert.Equal("lab-1", claims[0].Name)
+	assert.Equal("a-7", claims[1].Name)
+	assert.Equal("east", claims[1].Site)
+}
+
+func TestNameRegistry(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := path.Join(t.TempDir(), "names.json")
+	r := newNameRegistry(file)
+	now := time.Now()
+	claim := func(name string, owner string, resource string) NameClaim {
+		return NameClaim{
+			Kind: "hostname", Name: name, Owner: owner, Resource: resource, Site: "east",
+			Claimed: time.Time{}, Expires: time.Time{}, Note: "",
+		}
+	}
+
+	// A reservation is bound by its owner only
+	conflicts, err := r.bind([]NameClaim{claim("web-1", "alice@zebra", "")}, now, false)
+	assert.Nil(err)
+	assert.Empty(conflicts)
+
+	conflicts, _ = r.bind([]NameClaim{claim("WEB-1", "bob@zebra", "b")}, now, false)
+	assert.Equal(1, len(conflicts))
+	assert.Equal("alice@zebra", conflicts[0].Holder.Owner)
+
+	conflicts, _ = r.bind([]NameClaim{claim("web-1", "alice@zebra", "a")}, now, false)
+	assert.Empty(conflicts)
+
+	// Renaming the resource releases its old name
+	conflicts, _ = r.bind([]NameClaim{claim("web-2", "bob@zebra", "a")}, now, false)
+	assert.Empty(conflicts)
+	assert.Equal(1, len(r.list("hostname", now)))
+
+	// Claims of the same request conflict too, and none are bound then
+	conflicts, _ = r.bind([]NameClaim{claim("web-3", "bob@zebra", "b"), claim("web-3", "bob@zebra", "c")}, now, false)
+	assert.Equal(1, len(conflicts))
+	assert.Equal(1, len(r.list("", now)))
+
+	// Expired reservations lapse
+	expiring := claim("web-4", "carol@zebra", "")
+	expiring.Expires = now.Add(time.Hour)
+	conflicts, _ = r.bind([]NameClaim{expiring}, now, false)
+	assert.Empty(conflicts)
+
+	conflicts, _ = r.bind([]NameClaim{claim("web-4", "bob@zebra", "")}, now, true)
+	assert.Equal(1, len(conflicts))
+
+	conflicts, _ = r.bind([]NameClaim{claim("web-4", "bob@zebra", "")}, now.Add(2*time.Hour), true)
+	assert.Empty(conflicts)
+
+	loaded := newNameRegistry(file)
+	assert.Nil(loaded.load())
+	assert.Equal(2, len(loaded.list("", now)))
+
+	assert.Nil(loaded.unbind([]NameClaim{claim("web-2", "", "a")}))
+	assert.Equal(1, len(loaded.list("", now)))
+
+	_, err = loaded.release("hostname", "web-4", func(c NameClaim) bool { return c.Owner == "bob@zebra" })
+	assert.ErrorIs(err, ErrNameOwner)
+
+	_, err = loaded.release("hostname", "web-9", func(NameClaim) bool { return true })
+	assert.ErrorIs(err, ErrNameNotFound)
+}
+
+func TestGuardNames(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	roots := []string{"test_names_registry", "test_names_site"}
+
+	t.Cleanup(func() {
+		for _, root := range roots {
+			os.RemoveAll(root)
+		}
+	})
+
+	registry := NewResourceAPI(store.DefaultFactory())
+	site := NewResourceAPI(store.DefaultFactory())
+
+	for i, api := range []*ResourceAPI{registry, site} {
+		assert.Nil(api.Initialize(roots[i]))
+	}
+
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+	alice := auth.NewClaims("zebra", "alice", DefaultRole(), "alice@zebra")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), ResourcesCtxKey, registry)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+
+		switch r.URL.Path {
+		case "/api/v1/names/bind":
+			handleBindNames()(w, r.WithContext(ctx), nil)
+		case "/api/v1/names/release":
+			handleUnbindNames()(w, r.WithContext(ctx), nil)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer srv.Close()
+
+	var err error
+
+	rules := []NameRule{{Kind: "hostname", Types: []string{"Lab"}, Field: "name", Label: ""}}
+	registry.naming, err = newNaming(registry.factory, &NamingConfig{Rules: rules, Site: "central", Registry: nil})
+	assert.Nil(err)
+
+	site.naming, err = newNaming(site.factory, &NamingConfig{Rules: rules, Site: "east", Registry: &SiteConfig{
+		Name: "registry", URL: srv.URL, Token: nil, CACert: "", Namespaces: nil,
+	}})
+	assert.Nil(err)
+
+	// Names are unique across namespaces
+	a := dc.NewLab("lab-1", zebra.Labels{"system.group": "a"})
+	b := dc.NewLab("LAB-1", zebra.Labels{"system.group": "b"})
+
+	rr := namesRequest(registry, admin, http.MethodPost, "/api/v1/resources", labMap(a), handlePost())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = namesRequest(registry, admin, http.MethodPost, "/api/v1/resources", labMap(b), handlePost())
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	// and across the sites of the registry
+	rr = namesRequest(site, admin, http.MethodPost, "/api/v1/resources", labMap(b), handlePost())
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Contains(rr.Body.String(), a.ID)
+
+	// Names can be reserved before the resource exists
+	reservation := map[string]string{"kind": "hostname", "name": "lab-2", "note": "arrives in May"}
+	rr = namesRequest(site, alice, http.MethodPost, "/api/v1/names", reservation, handleReserveName())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = namesRequest(registry, alice, http.MethodPost, "/api/v1/names", reservation, handleReserveName())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	b.Name = "lab-2"
+	rr = namesRequest(site, admin, http.MethodPost, "/api/v1/resources", labMap(b), handlePost())
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	rr = namesRequest(site, alice, http.MethodPost, "/api/v1/resources", labMap(b), handlePost())
+	assert.Equal(http.StatusOK, rr.Code)
+
+	bound := registry.names.list("hostname", time.Now())
+	assert.Equal(2, len(bound))
+	assert.Equal(b.ID, bound[1].Resource)
+	assert.Equal("east", bound[1].Site)
+
+	// Deleting the resource releases its name
+	rr = namesRequest(site, alice, http.MethodDelete, "/api/v1/resources", labMap(b), handleDelete())
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(1, len(registry.names.list("hostname", time.Now())))
+
+	rr = namesRequest(registry, alice, http.MethodGet, "/api/v1/names", nil, handleNames())
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), a.ID)
+
+	rr = namesRequest(registry, alice, http.MethodPost, "/api/v1/names/bind", []NameClaim{}, handleBindNames())
+	assert.Equal(http.StatusForbidden, rr.Code)
+}
+
+func TestGuardNamesRollback(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	var err error
+
+	rules := []NameRule{{Kind: "hostname", Types: []string{"Lab"}, Field: "name", Label: ""}}
+	api.naming, err = newNaming(api.factory, &NamingConfig{Rules: rules, Site: "", Registry: nil})
+	assert.Nil(err)
+
+	api.quotas, err = newQuotas(api.factory, &QuotaConfig{Quotas: []Quota{
+		{Type: "Lab", Namespace: "", Max: 1, Overflow: "", ExpireAfter: ""},
+	}})
+	assert.Nil(err)
+
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+	post := func(lab *dc.Lab) int {
+		return namesRequest(api, admin, http.MethodPost, "/api/v1/resources", labMap(lab), handlePost()).Code
+	}
+
+	names := func() []string {
+		bound := []string{}
+		for _, c := range api.names.list("hostname", time.Now()) {
+			bound = append(bound, c.Name)
+		}
+
+		return bound
+	}
+
+	a := dc.NewLab("lab-1", zebra.Labels{"system.group": "labs"})
+	assert.Equal(http.StatusOK, post(a))
+
+	// Names are not bound to resources a quota refuses
+	assert.Equal(http.StatusConflict, post(dc.NewLab("lab-2", zebra.Labels{"system.group": "labs"})))
+	assert.Equal([]string{"lab-1"}, names())
+
+	// nor to resources that could not be written
+	api.faults = faults.New(1)
+	assert.Nil(api.faults.Set(faults.Config{Latency: "", Jitter: "", WriteErrors: 100, DroppedEvents: 0}))
+
+	renamed := dc.NewLab("lab-3", zebra.Labels{"system.group": "labs"})
+	renamed.ID = a.ID
+	assert.Equal(http.StatusInternalServerError, post(renamed))
+	assert.Equal([]string{"lab-1"}, names())
+	assert.Equal(a.ID, api.names.list("hostname", time.Now())[0].Resource)
+
+	api.faults = nil
+	assert.Equal(http.StatusOK, post(renamed))
+	assert.Equal([]string{"lab-3"}, names())
+}
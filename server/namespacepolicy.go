@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/notify"
+)
+
+// HookLeasePolicy names the violations of lease policies.
+const HookLeasePolicy = "lease-policy"
+
+// namespacePolicy returns the lease policy of the namespace, the first by
+// name if there are several, or nil if the namespace has none.
+func namespacePolicy(resources *zebra.ResourceMap, namespace string) *lease.Policy {
+	policies := []*lease.Policy{}
+
+	if l, ok := resources.Resources["LeasePolicy"]; ok {
+		for _, r := range l.Resources {
+			if p, ok := r.(*lease.Policy); ok && p.Namespace() == namespace {
+				policies = append(policies, p)
+			}
+		}
+	}
+
+	if len(policies) == 0 {
+		return nil
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Name != policies[j].Name {
+			return policies[i].Name < policies[j].Name
+		}
+
+		return policies[i].ID < policies[j].ID
+	})
+
+	return policies[0]
+}
+
+// leasePolicyViolations returns the violations of the lease policies of
+// their namespaces by the leases of resMap.
+func leasePolicyViolations(resources *zebra.ResourceMap, resMap *zebra.ResourceMap) []Violation {
+	violations := []Violation{}
+
+	l, ok := resMap.Resources["Lease"]
+	if !ok {
+		return violations
+	}
+
+	for _, r := range l.Resources {
+		l, ok := r.(*lease.Lease)
+		if !ok {
+			continue
+		}
+
+		if err := namespacePolicy(resources, l.Namespace()).Check(l); err != nil {
+			violations = append(violations, Violation{
+				Hook: HookLeasePolicy, ID: l.ID, Type: l.GetType(), Field: "", Message: err.Error(),
+			})
+		}
+	}
+
+	return violations
+}
+
+// guardLeasePolicies refuses leases that break the policy of their
+// namespace, it writes the violations and returns false if there are any.
+func (api *ResourceAPI) guardLeasePolicies(res http.ResponseWriter, req *http.Request,
+	resMap *zebra.ResourceMap,
+) bool {
+	violations := leasePolicyViolations(api.view(), resMap)
+	if len(violations) == 0 {
+		return true
+	}
+
+	writeJSONCode(req.Context(), res, http.StatusBadRequest, violations)
+
+	return false
+}
+
+// waitedFor returns true if one of the pending leases requests resources of
+// a type the lease holds.
+func waitedFor(pending []*lease.Lease, l *lease.Lease) bool {
+	held := map[string]bool{}
+
+	for _, req := range l.RequestList() {
+		held[req.Type] = true
+	}
+
+	for _, p := range pending {
+		for _, req := range p.RequestList() {
+			if held[req.Type] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// renewLease renews an expiring lease for its duration, if the policy of its
+// namespace renews it again and no pending lease waits for its resources.
+func renewLease(ctx context.Context, api *ResourceAPI, l *lease.Lease, pending []*lease.Lease) (bool, error) {
+	policy := namespacePolicy(api.view(), l.Namespace())
+	if l.Renewals >= policy.Renewals() || waitedFor(pending, l) {
+		return false, nil
+	}
+
+	l.Renew()
+
+	if err := api.create(ctx, l); err != nil {
+		return false, err
+	}
+
+	api.recordSystemAudit("lease.renew", l.ID, l.Owner())
+	_ = api.Inbox.Notify(notify.NewNotification(l.Owner(), "lease renewed",
+		fmt.Sprintf("lease %s is renewed until %s, renewal %d of %d", l.ID,
+			l.Expiry().UTC().Format(time.RFC3339), l.Renewals, policy.Renewals()), l.ID))
+
+	return true, nil
+}
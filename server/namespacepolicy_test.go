@@ -0,0 +1,123 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespacePolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	assert.Nil(namespacePolicy(resources, "ci"))
+
+	strict := lease.NewPolicy("b-strict", "ci")
+	strict.DefaultDuration = time.Hour
+	strict.MaxDuration = 2 * time.Hour
+	strict.AllowedTypes = []string{"Lab"}
+
+	for _, p := range []*lease.Policy{strict, lease.NewPolicy("c-lax", "ci"), lease.NewPolicy("a-other", "qa")} {
+		resources.Add(p, "LeasePolicy")
+	}
+
+	assert.Equal(strict, namespacePolicy(resources, "ci"))
+	assert.Nil(namespacePolicy(resources, lease.DefaultNamespace))
+
+	// Requests in the namespace get its default and are bound by it
+	ctx := context.Background()
+	req := func(t string) []*lease.ResourceReq {
+		return []*lease.ResourceReq{{Type: t, Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}}
+	}
+
+	lr := &LeaseRequest{Namespace: "ci", Duration: "", Priority: lease.Normal, Request: req("Lab"), Wait: false}
+	l, err := lr.lease(ctx, "user@zebra", namespacePolicy(resources, lr.namespace()))
+	assert.Nil(err)
+	assert.Equal(time.Hour, l.Duration)
+	assert.Equal("ci", l.Namespace())
+
+	lr.Duration = "3h"
+	_, err = lr.lease(ctx, "user@zebra", namespacePolicy(resources, lr.namespace()))
+	assert.ErrorIs(err, lease.ErrPolicyMax)
+
+	lr.Duration = ""
+	lr.Request = req("Server")
+	_, err = lr.lease(ctx, "user@zebra", namespacePolicy(resources, lr.namespace()))
+	assert.ErrorIs(err, lease.ErrPolicyType)
+
+	// Leases written as resources are held to the same policy
+	resMap := zebra.NewResourceMap(store.DefaultFactory())
+	fine := lease.NewLease("user@zebra", 3*time.Hour, req("Server"))
+	long := lease.NewLease("user@zebra", 3*time.Hour, req("Lab"))
+	long.Labels.Add("system.group", "ci")
+	resMap.Add(fine, "Lease")
+	resMap.Add(long, "Lease")
+
+	violations := leasePolicyViolations(resources, resMap)
+	assert.Equal(1, len(violations))
+	assert.Equal(long.ID, violations[0].ID)
+	assert.Equal(HookLeasePolicy, violations[0].Hook)
+	assert.Equal(lease.ErrPolicyMax.Error(), violations[0].Message)
+}
+
+func TestAutoRenew(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "namespacepolicy_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	ctx := context.Background()
+	policy := lease.NewPolicy("ci", "ci")
+	policy.AutoRenew = 1
+	assert.Nil(api.create(ctx, policy))
+
+	lab := makeOwnedLab("user@zebra")
+	lab.Status.Lease = zebra.Leased
+	assert.Nil(api.create(ctx, lab))
+
+	req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}
+	assert.Nil(req.Assign(lab))
+
+	l := lease.NewLease("user@zebra", time.Hour, []*lease.ResourceReq{req})
+	l.Labels.Add("system.group", "ci")
+	l.ActivationTime = time.Now()
+	l.Status.State = zebra.Active
+	assert.Nil(api.create(ctx, l))
+
+	// The expired lease is renewed once for its duration
+	result, err := reapLeases(ctx, api, time.Now().Add(2*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 0 leases, renewed 1 leases", result)
+	assert.Equal(2*time.Hour, l.Length())
+	assert.Equal("lease renewed", api.Inbox.List("user@zebra")[0].Subject)
+
+	result, err = reapLeases(ctx, api, time.Now().Add(3*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 1 leases, renewed 0 leases", result)
+
+	// Leases others wait for are not renewed
+	l.Renewals = 0
+	l.ActivationTime = time.Now()
+	l.Status.State = zebra.Active
+	assert.Nil(api.create(ctx, l))
+
+	waiting := lease.NewLease("other@zebra", time.Hour,
+		[]*lease.ResourceReq{{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}})
+	assert.Nil(api.create(ctx, waiting))
+
+	result, err = reapLeases(ctx, api, time.Now().Add(5*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 1 leases, renewed 0 leases", result)
+}
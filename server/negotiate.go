@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/protoenc"
+	"gojini.dev/web"
+	"gopkg.in/yaml.v3"
+)
+
+// Media types of request and response bodies. Bodies are JSON unless the
+// request has a YAML Content-Type, responses are JSON unless the request
+// prefers YAML in its Accept header. The query and events routes also answer
+// in protobuf, see the protoenc package.
+const (
+	MediaJSON = "application/json"
+	MediaYAML = "application/yaml"
+)
+
+var ErrYAMLBody = errors.New("request body is not a YAML document")
+
+// yamlMediaTypes are the media types YAML is sent and asked for as.
+var yamlMediaTypes = []string{MediaYAML, "application/x-yaml", "text/yaml", "text/x-yaml"} //nolint:gochecknoglobals
+
+// protobufMediaTypes are the media types protobuf is asked for as.
+var protobufMediaTypes = []string{ //nolint:gochecknoglobals
+	protoenc.MediaType, "application/protobuf", "application/vnd.google.protobuf",
+}
+
+func isYAML(mediaType string) bool {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+
+	return err == nil && zebra.IsIn(parsed, yamlMediaTypes)
+}
+
+// acceptedRange is a media range of an Accept header and its quality.
+type acceptedRange struct {
+	mediaType string
+	quality   float64
+}
+
+// acceptedFormat returns the media type of the format the Accept header
+// ranks first of JSON, YAML and protobuf. Ranges of the same quality are
+// ranked in the order they are listed, wildcards leave the choice to the
+// server which answers in JSON.
+func acceptedFormat(accept string) string {
+	ranges := []acceptedRange{}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		if quality > 0 && !strings.Contains(mediaType, "*") {
+			ranges = append(ranges, acceptedRange{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	for _, r := range ranges {
+		switch {
+		case r.mediaType == MediaJSON:
+			return MediaJSON
+		case zebra.IsIn(r.mediaType, yamlMediaTypes):
+			return MediaYAML
+		case zebra.IsIn(r.mediaType, protobufMediaTypes):
+			return protoenc.MediaType
+		}
+	}
+
+	return MediaJSON
+}
+
+// formatAdapter negotiates the format of request and response bodies. YAML
+// request bodies are turned into JSON before any handler reads them, and
+// the response format is put in the request context for writeJSON.
+func formatAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+
+			if format := acceptedFormat(req.Header.Get("Accept")); format != MediaJSON {
+				ctx = context.WithValue(ctx, FormatCtxKey, format)
+				req = req.WithContext(ctx)
+			}
+
+			if req.Body != nil && isYAML(req.Header.Get("Content-Type")) {
+				body, err := ioutil.ReadAll(req.Body)
+				if err == nil {
+					body, err = yamlToJSON(body)
+				}
+
+				if err != nil {
+					logr.FromContextOrDiscard(ctx).Info("bad yaml body", "error", err.Error())
+					res.WriteHeader(http.StatusBadRequest)
+
+					return
+				}
+
+				req = req.Clone(ctx)
+				req.Body = ioutil.NopCloser(bytes.NewReader(body))
+				req.ContentLength = int64(len(body))
+				req.Header.Set("Content-Type", MediaJSON)
+			}
+
+			callNext(nextHandler, res, req)
+		})
+	}
+}
+
+// yamlToJSON converts a single YAML document to JSON. An empty document is
+// an empty body.
+func yamlToJSON(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []byte{}, nil
+	}
+
+	var doc interface{}
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		// JSON has no form for some YAML values, such as infinite numbers
+		return nil, ErrYAMLBody
+	}
+
+	return body, nil
+}
+
+// jsonToYAML converts JSON to a YAML document, keeping the order of the
+// fields. JSON is YAML in flow style, the nodes are reset to block style.
+func jsonToYAML(data []byte) ([]byte, error) {
+	node := new(yaml.Node)
+
+	if err := yaml.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+
+	blockStyle(node)
+
+	return yaml.Marshal(node)
+}
+
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// responseFormat returns the media type the request asked responses in.
+// Routes without a protobuf form answer such requests in JSON.
+func responseFormat(ctx context.Context) string {
+	if format, ok := ctx.Value(FormatCtxKey).(string); ok {
+		return format
+	}
+
+	return MediaJSON
+}
+
+// writeProtobuf writes the protobuf encoded data as the response.
+func writeProtobuf(ctx context.Context, res http.ResponseWriter, data []byte, err error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if err != nil {
+		log.Error(err, "error encoding response")
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	res.Header().Set("Content-Type", protoenc.MediaType)
+	res.WriteHeader(http.StatusOK)
+
+	if _, err := res.Write(data); err != nil {
+		log.Error(err, "error writing response")
+	}
+}
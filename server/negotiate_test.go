@@ -0,0 +1,147 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/protoenc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAcceptedFormat(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for accept, want := range map[string]string{
+		"":                                               MediaJSON,
+		"*/*":                                            MediaJSON,
+		"application/yaml":                               MediaYAML,
+		"text/yaml; charset=utf-8":                       MediaYAML,
+		"application/json, application/yaml":             MediaJSON,
+		"application/yaml, application/json":             MediaYAML,
+		"application/json;q=0.5, text/x-yaml":            MediaYAML,
+		"application/yaml;q=0, application/xml":          MediaJSON,
+		"application/yaml;q=high":                        MediaJSON,
+		"application/protobuf, application/json":         protoenc.MediaType,
+		"application/json;q=0.9, application/x-protobuf": protoenc.MediaType,
+	} {
+		assert.Equal(want, acceptedFormat(accept), accept)
+	}
+}
+
+func TestYAMLConversion(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	body, err := yamlToJSON([]byte("Lab:\n  - id: lab-1\n    labels:\n      system.group: labs\n"))
+	assert.Nil(err)
+	assert.JSONEq(`{"Lab": [{"id": "lab-1", "labels": {"system.group": "labs"}}]}`, string(body))
+
+	body, err = yamlToJSON([]byte("  \n"))
+	assert.Nil(err)
+	assert.Empty(body)
+
+	_, err = yamlToJSON([]byte("a: .nan\n"))
+	assert.ErrorIs(err, ErrYAMLBody)
+
+	_, err = yamlToJSON([]byte("a: [b\n"))
+	assert.NotNil(err)
+
+	// Fields keep their order and strings stay strings
+	out, err := jsonToYAML([]byte(`{"name": "lab", "id": "true", "count": 2, "tags": ["a"]}`))
+	assert.Nil(err)
+	assert.Equal("name: lab\nid: \"true\"\ncount: 2\ntags:\n    - a\n", string(out))
+}
+
+func TestFormatAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_format_adapter"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+
+	serve := func(handle httprouter.Handle, method string, body string, header http.Header,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		req := httptest.NewRequest(method, "/api/v1/resources", strings.NewReader(body)).WithContext(ctx)
+		req.Header = header
+		rr := httptest.NewRecorder()
+
+		formatAdapter()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, nil)
+		})).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	lab := dc.NewLab("yaml lab", zebra.Labels{"system.group": "labs"})
+	data, _ := json.Marshal(labMap(lab))
+	body, err := jsonToYAML(data)
+	assert.Nil(err)
+
+	rr := serve(handlePost(), http.MethodPost, string(body), http.Header{"Content-Type": {"application/yaml"}})
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotNil(findResource(api.Store, lab.ID))
+
+	rr = serve(handlePost(), http.MethodPost, "Lab: [", http.Header{"Content-Type": {"application/x-yaml"}})
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = serve(handleQuery(), http.MethodGet, "types: [Lab]\n", http.Header{
+		"Content-Type": {"text/yaml"}, "Accept": {"application/yaml"},
+	})
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(MediaYAML, rr.Header().Get("Content-Type"))
+
+	resources := map[string][]map[string]interface{}{}
+	assert.Nil(yaml.Unmarshal(rr.Body.Bytes(), &resources))
+	assert.Equal("yaml lab", resources["Lab"][0]["name"])
+
+	rr = serve(handleQuery(), http.MethodGet, "{}", http.Header{})
+	assert.Equal(MediaJSON, rr.Header().Get("Content-Type"))
+
+	// Queries and events are also served in protobuf, other routes in JSON
+	protobuf := http.Header{"Accept": {protoenc.MediaType}}
+
+	rr = serve(handleQuery(), http.MethodGet, "{}", protobuf)
+	assert.Equal(protoenc.MediaType, rr.Header().Get("Content-Type"))
+
+	resMap, err := protoenc.UnmarshalResources(rr.Body.Bytes(), store.DefaultFactory())
+	assert.Nil(err)
+	assert.Equal("yaml lab", resMap.Resources["Lab"].Resources[0].(*dc.Lab).Name)
+
+	rr = serve(handleQueryV2(), http.MethodGet, "", protobuf)
+	assert.Equal(protoenc.MediaType, rr.Header().Get("Content-Type"))
+
+	resMap, err = protoenc.UnmarshalResources(rr.Body.Bytes(), store.DefaultFactory())
+	assert.Nil(err)
+	assert.NotNil(resMap.Resources["Lab"])
+
+	rr = serve(handleEvents(), http.MethodGet, "", protobuf)
+	assert.Equal(protoenc.MediaType, rr.Header().Get("Content-Type"))
+
+	page, _, err := protoenc.UnmarshalEvents(rr.Body.Bytes())
+	assert.Nil(err)
+	assert.Equal(lab.ID, page[0].Resource)
+
+	rr = serve(handleNames(), http.MethodGet, "", protobuf)
+	assert.Equal(MediaJSON, rr.Header().Get("Content-Type"))
+}
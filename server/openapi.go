@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/jsonschema"
+	"github.com/project-safari/zebra/store"
+	"github.com/spf13/cobra"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the documents
+// follow, the first to use the JSON Schemas of the types as they are.
+const OpenAPIVersion = "3.1.0"
+
+// DefaultClientVersion is the version of the clients generated from the
+// OpenAPI document, unless given.
+const DefaultClientVersion = "0.1.0"
+
+// OpenAPI is an OpenAPI document: the operations of an API version and the
+// schemas of the resource types, from which clients are generated.
+type OpenAPI struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Servers    []OpenAPIServer                 `json:"servers"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components OpenAPIComponents               `json:"components"`
+	Security   []map[string][]string           `json:"security"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*jsonschema.Schema `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme     `json:"securitySchemes"`
+}
+
+// SecurityScheme is a way of authenticating requests.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Operation is a route of the API. The bodies of requests and responses are
+// JSON, the resources in them are of the schemas of the components.
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Tags        []string            `json:"tags"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string             `json:"name"`
+	In       string             `json:"in"`
+	Required bool               `json:"required"`
+	Schema   *jsonschema.Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *jsonschema.Schema `json:"schema"`
+}
+
+// inlineSchema returns the schema of the JSON of the value, to be used
+// within the document.
+func inlineSchema(v interface{}) *jsonschema.Schema {
+	s := jsonschema.For("", v)
+	s.Schema = ""
+
+	return s
+}
+
+func jsonContent() map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: inlineSchema(nil)}}
+}
+
+// openAPIPath returns the OpenAPI path of a route and its path parameters.
+func openAPIPath(path string) (string, []string) {
+	params := []string{}
+	segments := strings.Split(path, "/")
+
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// operationID names the operation of a route by its method and path, such
+// as getResourcesIdComments for GET /resources/:id/comments.
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+
+	for _, s := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		s = strings.TrimLeft(s, ":*")
+		if s != "" {
+			id += strings.ToUpper(s[:1]) + s[1:]
+		}
+	}
+
+	return id
+}
+
+func operation(route apiRoute, params []string) Operation {
+	// Operations are grouped by the first segment of their path
+	tag := strings.FieldsFunc(route.path, func(r rune) bool { return r == '/' || r == '.' })[0]
+	op := Operation{
+		OperationID: operationID(route.method, route.path),
+		Tags:        []string{tag},
+		Parameters:  nil,
+		RequestBody: nil,
+		Responses: map[string]Response{
+			"default": {Description: "JSON response, or the error message", Content: jsonContent()},
+		},
+	}
+
+	for _, p := range params {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name: p, In: "path", Required: true, Schema: inlineSchema(""),
+		})
+	}
+
+	if route.method == http.MethodPost || route.method == http.MethodPut || route.method == http.MethodPatch {
+		op.RequestBody = &RequestBody{Required: false, Content: jsonContent()}
+	}
+
+	return op
+}
+
+// openAPI returns the OpenAPI document of the routes of an API version.
+func openAPI(version string, clientVersion string, routes []apiRoute) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{Title: SchemaBundle, Version: clientVersion},
+		Servers: []OpenAPIServer{{URL: "/api/" + version}},
+		Paths:   map[string]map[string]Operation{},
+		Components: OpenAPIComponents{
+			Schemas: map[string]*jsonschema.Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", In: "", Name: ""},
+				"cookie": {Type: "apiKey", Scheme: "", In: "cookie", Name: "jwt"},
+			},
+		},
+		Security: []map[string][]string{{"bearer": {}}, {"cookie": {}}},
+	}
+
+	for _, route := range routes {
+		path, params := openAPIPath(route.path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]Operation{}
+		}
+
+		doc.Paths[path][strings.ToLower(route.method)] = operation(route, params)
+	}
+
+	types := store.DefaultFactory().Types()
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	for _, t := range types {
+		s := typeSchema(t)
+		s.Schema = ""
+		doc.Components.Schemas[t.Name] = s
+	}
+
+	return doc
+}
+
+// handleOpenAPI returns the OpenAPI document of the v1 API.
+func handleOpenAPI() httprouter.Handle {
+	var (
+		once sync.Once
+		doc  *OpenAPI
+	)
+
+	modified := time.Now()
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		// The routes include this one, they are listed once it is served
+		once.Do(func() { doc = openAPI(APIv1, DefaultClientVersion, v1Routes()) })
+
+		writeCachedJSON(req.Context(), res, req, modified, doc)
+	}
+}
+
+func NewOpenAPICmd() *cobra.Command {
+	openAPICmd := new(cobra.Command)
+
+	openAPICmd.Use = "openapi"
+	openAPICmd.Short = "print the OpenAPI document of the v1 API, to generate clients from"
+	openAPICmd.RunE = runOpenAPI
+	openAPICmd.SilenceUsage = true
+
+	openAPICmd.Flags().String("client-version", DefaultClientVersion, "version of the clients generated")
+
+	return openAPICmd
+}
+
+func runOpenAPI(cmd *cobra.Command, args []string) error {
+	clientVersion, err := cmd.Flags().GetString("client-version")
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(openAPI(APIv1, clientVersion, v1Routes()), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), string(data))
+
+	return nil
+}
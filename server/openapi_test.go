@@ -0,0 +1,87 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIPath(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	path, params := openAPIPath("/resources/:id/comments/:cid")
+	assert.Equal("/resources/{id}/comments/{cid}", path)
+	assert.Equal([]string{"id", "cid"}, params)
+
+	assert.Equal("getResourcesIdComments", operationID(http.MethodGet, "/resources/:id/comments"))
+	assert.Equal("postQueryBatch", operationID(http.MethodPost, "/query/batch"))
+	assert.Equal("getOpenapiJson", operationID(http.MethodGet, "/openapi.json"))
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	routes := v1Routes()
+	doc := openAPI(APIv1, "1.2.3", routes)
+
+	assert.Equal(OpenAPIVersion, doc.OpenAPI)
+	assert.Equal("1.2.3", doc.Info.Version)
+	assert.Equal("/api/v1", doc.Servers[0].URL)
+
+	// Every route is an operation, of its own name
+	ids := map[string]bool{}
+	operations := 0
+
+	for _, ops := range doc.Paths {
+		for _, op := range ops {
+			assert.False(ids[op.OperationID], op.OperationID)
+			ids[op.OperationID] = true
+			operations++
+		}
+	}
+
+	assert.Equal(len(routes), operations)
+
+	op := doc.Paths["/types/{type}/schema"]["get"]
+	assert.Equal("getTypesTypeSchema", op.OperationID)
+	assert.Equal([]string{"types"}, op.Tags)
+	assert.Equal("type", op.Parameters[0].Name)
+	assert.True(op.Parameters[0].Required)
+	assert.Nil(op.RequestBody)
+	assert.NotNil(doc.Paths["/resources"]["post"].RequestBody)
+
+	assert.Equal("Lab", doc.Components.Schemas["Lab"].Title)
+	assert.Empty(doc.Components.Schemas["Lab"].Schema)
+
+	rr := httptest.NewRecorder()
+	handleOpenAPI()(rr, httptest.NewRequest("GET", "/api/v1/openapi.json", nil), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	served := new(OpenAPI)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), served))
+	assert.Equal(DefaultClientVersion, served.Info.Version)
+	assert.Equal([]string{"openapi"}, served.Paths["/openapi.json"]["get"].Tags)
+}
+
+func TestOpenAPICmd(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cmd := NewOpenAPICmd()
+	out := new(bytes.Buffer)
+
+	cmd.SetOut(out)
+	cmd.SetArgs([]string{"--client-version", "2.0.0"})
+	assert.Nil(cmd.Execute())
+
+	doc := new(OpenAPI)
+	assert.Nil(json.Unmarshal(out.Bytes(), doc))
+	assert.Equal("2.0.0", doc.Info.Version)
+	assert.Contains(doc.Paths, "/schemas")
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+)
+
+// maxPlacementDepth bounds the walk of the relationship graph from a
+// resource to the resource of a scope.
+const maxPlacementDepth = 16
+
+// constraints are the placement constraints of a lease: the scopes all of
+// its resources share a place of, such as the same rack or switch, and the
+// scopes they are spread across, such as at least two racks.
+type constraints struct {
+	together []string
+	spread   []lease.Spread
+}
+
+func leaseConstraints(l *lease.Lease) constraints {
+	return constraints{together: l.Placement, spread: l.Spread}
+}
+
+// selection narrows down the candidates of a request to those within the
+// place and, with a spread, orders them by how much their spread place is
+// already used by the lease.
+type selection struct {
+	place  string
+	within func(zebra.Resource) bool
+	spread func(zebra.Resource) string
+	used   map[string]int
+}
+
+// pick returns count of the free resources, or all of them if there are not
+// enough. With a spread, each one is taken from the least used place.
+func (s selection) pick(free []zebra.Resource, count int) []zebra.Resource {
+	if s.spread == nil {
+		if len(free) > count {
+			return free[:count]
+		}
+
+		return free
+	}
+
+	picked := make([]zebra.Resource, 0, count)
+	taken := make([]bool, len(free))
+
+	for len(picked) < count && len(picked) < len(free) {
+		best := -1
+
+		for i, res := range free {
+			if !taken[i] && (best < 0 || s.used[s.spread(res)] < s.used[s.spread(free[best])]) {
+				best = i
+			}
+		}
+
+		taken[best] = true
+		s.used[s.spread(free[best])]++
+		picked = append(picked, free[best])
+	}
+
+	return picked
+}
+
+// find returns the resource of the allocator with the ID, or nil.
+func (a *allocator) find(id string) zebra.Resource {
+	if a.byID == nil {
+		a.byID = map[string]zebra.Resource{}
+
+		_ = applyFunc(a.resources, func(r zebra.Resource) error {
+			a.byID[r.GetID()] = r
+
+			return nil
+		})
+	}
+
+	return a.byID[id]
+}
+
+// relatedOf returns the place of the resource in the scope. A scope is
+// either a resource type, the place is the nearest resource of that type in
+// the relationship graph of the resource, such as its rack or the switch it
+// is connected to, or a label key, the place is the value of the label.
+func (a *allocator) relatedOf(res zebra.Resource, scope string) string {
+	key := [2]string{res.GetID(), scope}
+	if place, ok := a.related[key]; ok {
+		return place
+	}
+
+	place := ""
+	seen := map[string]bool{res.GetID(): true}
+	level := []zebra.Resource{res}
+
+	for depth := 0; place == "" && len(level) != 0 && depth < maxPlacementDepth; depth++ {
+		next := []zebra.Resource{}
+
+		for _, r := range level {
+			if r.GetType() == scope {
+				place = r.GetID()
+
+				break
+			}
+
+			for _, ref := range zebra.References(r) {
+				if found := a.find(ref); found != nil && !seen[ref] {
+					seen[ref] = true
+					next = append(next, found)
+				}
+			}
+		}
+
+		level = next
+	}
+
+	if place == "" {
+		place = res.GetLabels()[scope]
+	}
+
+	a.related[key] = place
+
+	return place
+}
+
+// placement returns the place of the resource for the scopes, or "" if it
+// has none in one of them.
+func (a *allocator) placement(res zebra.Resource, scopes []string) string {
+	places := make([]string, 0, len(scopes))
+
+	for _, scope := range scopes {
+		place := a.relatedOf(res, scope)
+		if place == "" {
+			return ""
+		}
+
+		places = append(places, place)
+	}
+
+	return strings.Join(places, "/")
+}
+
+// reserveTogether allocates the requests of the named lease under its
+// constraints. With placement scopes the requests are all allocated in the
+// same place: of the places that satisfy all of them it takes the one with
+// the fewest free resources, to leave larger places to larger leases. If
+// none does, it takes what it can in the place missing the fewest.
+func (a *allocator) reserveTogether(name string, reqs []AllocationRequest, c constraints) ([]Allocation, int) {
+	if len(c.together) == 0 {
+		return a.reserveIn(name, reqs, "", c)
+	}
+
+	free := map[string]int{}
+
+	for _, r := range reqs {
+		for _, res := range a.candidates(r) {
+			place := a.placement(res, c.together)
+			if place == "" {
+				continue
+			}
+
+			if _, seen := free[place]; !seen {
+				free[place] = 0
+			}
+
+			if a.blocker(res) == "" {
+				free[place]++
+			}
+		}
+	}
+
+	places := make([]string, 0, len(free))
+	for place := range free {
+		places = append(places, place)
+	}
+
+	sort.Slice(places, func(i, j int) bool {
+		if free[places[i]] != free[places[j]] {
+			return free[places[i]] < free[places[j]]
+		}
+
+		return places[i] < places[j]
+	})
+
+	best, fewest := "", -1
+
+	for _, place := range places {
+		allocs, missing := a.reserveIn(name, reqs, place, c)
+		if missing == 0 {
+			return allocs, 0
+		}
+
+		a.release(name)
+
+		if fewest < 0 || missing < fewest {
+			best, fewest = place, missing
+		}
+	}
+
+	return a.reserveIn(name, reqs, best, c)
+}
+
+// reserveIn allocates the requests from the resources in the place, or from
+// all resources if the place is "", spread as the constraints ask. The places
+// the spread falls short of count as missing.
+func (a *allocator) reserveIn(name string, reqs []AllocationRequest, place string, c constraints,
+) ([]Allocation, int) {
+	s := selection{place: place, within: nil, spread: nil, used: map[string]int{}}
+
+	if place != "" {
+		s.within = func(res zebra.Resource) bool { return a.placement(res, c.together) == place }
+	} else if len(c.together) != 0 {
+		// No resource has a place, none can be allocated
+		s.within = func(zebra.Resource) bool { return false }
+	}
+
+	if len(c.spread) != 0 {
+		scopes := make([]string, 0, len(c.spread))
+		for _, sp := range c.spread {
+			scopes = append(scopes, sp.Scope)
+		}
+
+		s.spread = func(res zebra.Resource) string { return a.placement(res, scopes) }
+	}
+
+	allocs := make([]Allocation, 0, len(reqs))
+	missing := 0
+
+	for _, r := range reqs {
+		alloc := a.allocateIn(name, r, s)
+		missing += alloc.Missing
+		allocs = append(allocs, alloc)
+	}
+
+	return allocs, missing + a.spreadShortfall(allocs, c.spread)
+}
+
+// spreadShortfall returns the number of places the allocated resources fall
+// short of spreading across, and blames the spread for it in the last
+// allocation.
+func (a *allocator) spreadShortfall(allocs []Allocation, spread []lease.Spread) int {
+	short := 0
+
+	for _, sp := range spread {
+		places := map[string]bool{}
+
+		for _, alloc := range allocs {
+			for _, id := range alloc.Allocated {
+				if place := a.relatedOf(a.find(id), sp.Scope); place != "" {
+					places[place] = true
+				}
+			}
+		}
+
+		if len(places) < sp.Min && len(allocs) != 0 {
+			last := &allocs[len(allocs)-1]
+			last.Blockers = append(last.Blockers, Blocker{Reason: BlockedSpread, Count: sp.Min - len(places), Leases: nil})
+			short += sp.Min - len(places)
+		}
+	}
+
+	return short
+}
+
+// reserve takes resources for the requests of the lease, all or none of
+// which are satisfied together, and returns the allocations and the number
+// of resources missing.
+func reserve(a *allocator, l *lease.Lease) ([]Allocation, int) {
+	reqs := make([]AllocationRequest, 0, len(l.RequestList()))
+	for _, req := range l.RequestList() {
+		reqs = append(reqs, allocationRequest(req))
+	}
+
+	return a.reserveTogether(l.ID, reqs, leaseConstraints(l))
+}
@@ -0,0 +1,289 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// rackResources returns two racks, the first with two servers and a leased
+// switch, the second with three servers and a switch.
+func rackResources() (*zebra.ResourceMap, []*dc.Rack, *network.Switch) {
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	racks := []*dc.Rack{}
+
+	var leased *network.Switch
+
+	for i, servers := range []int{2, 3} {
+		site := map[int]string{0: "east", 1: "west"}[i]
+		rack := dc.NewRack("rack", "row", zebra.Labels{"system.group": "racks"})
+		racks = append(racks, rack)
+		resources.Add(rack, "Rack")
+
+		for j := 0; j < servers; j++ {
+			server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+				zebra.Labels{"system.group": "servers", zebra.ParentLabel: rack.ID, "site": site})
+			server.Credentials.Keys = map[string]string{"password": "actualPassw0rd%9"}
+			resources.Add(server, "Server")
+		}
+
+		sw := network.NewSwitch([]string{"serial", "model", "switch"}, 48, net.ParseIP("10.1.0.2"),
+			zebra.Labels{"system.group": "switches", zebra.ParentLabel: rack.ID, "site": site})
+		sw.Credentials.Keys = map[string]string{"password": "actualPassw0rd%9"}
+		resources.Add(sw, "Switch")
+
+		if leased == nil {
+			leased = sw
+			sw.Status.Lease = zebra.Leased
+		}
+	}
+
+	return resources, racks, leased
+}
+
+func groupRequests() []AllocationRequest {
+	return []AllocationRequest{
+		{Type: "Server", Group: AnyGroup, Name: "", Count: 2, Filters: nil},
+		{Type: "Switch", Group: AnyGroup, Name: "", Count: 1, Filters: nil},
+	}
+}
+
+func together(scopes ...string) constraints {
+	return constraints{together: scopes, spread: nil}
+}
+
+func TestPlacement(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources, racks, leased := rackResources()
+	a := newAllocator(resources, time.Now(), nil)
+
+	assert.Equal(racks[0].ID, a.placement(leased, []string{"Rack"}))
+	assert.Equal(racks[0].ID+"/east", a.placement(leased, []string{"Rack", "site"}))
+	assert.Equal(racks[0].ID, a.placement(racks[0], []string{"Rack"}))
+	assert.Empty(a.placement(leased, []string{"Datacenter"}))
+
+	// The first rack has no free switch
+	allocs, missing := a.reserveTogether("group", groupRequests(), together("Rack"))
+	assert.Equal(0, missing)
+	assert.Equal(racks[1].ID, allocs[0].Placement)
+	assert.Equal(2, len(allocs[0].Allocated))
+	assert.Equal(1, len(allocs[1].Allocated))
+
+	for _, alloc := range allocs {
+		for _, id := range alloc.Allocated {
+			assert.Equal(racks[1].ID, a.find(id).GetLabels()[zebra.ParentLabel])
+		}
+	}
+
+	// Nothing is left for a second group, it gets what there is in the rack
+	// missing the least
+	allocs, missing = a.reserveTogether("other", groupRequests(), together("site"))
+	assert.Equal(1, missing)
+	assert.Equal("east", allocs[0].Placement)
+
+	// Once the switch is free the smaller rack is the better fit
+	leased.Status.Lease = zebra.Free
+	a = newAllocator(resources, time.Now(), nil)
+
+	allocs, missing = a.reserveTogether("group", groupRequests(), together("Rack"))
+	assert.Equal(0, missing)
+	assert.Equal(racks[0].ID, allocs[1].Placement)
+
+	// Resources without a place are never allocated
+	allocs, missing = newAllocator(resources, time.Now(), nil).reserveTogether("dc", groupRequests(), together("Datacenter"))
+	assert.Equal(3, missing)
+	assert.Empty(allocs[0].Allocated)
+}
+
+func TestSpread(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources, racks, leased := rackResources()
+	leased.Status.Lease = zebra.Free
+
+	servers := []AllocationRequest{{Type: "Server", Group: AnyGroup, Name: "", Count: 3, Filters: nil}}
+	spread := func(min int) constraints {
+		return constraints{together: nil, spread: []lease.Spread{{Scope: "Rack", Min: min}}}
+	}
+
+	a := newAllocator(resources, time.Now(), nil)
+
+	allocs, missing := a.reserveTogether("spread", servers, spread(2))
+	assert.Equal(0, missing)
+
+	inRack := map[string]int{}
+	for _, id := range allocs[0].Allocated {
+		inRack[a.find(id).GetLabels()[zebra.ParentLabel]]++
+	}
+
+	assert.Equal(2, len(inRack))
+	assert.NotZero(inRack[racks[0].ID])
+
+	// There are only two racks
+	allocs, missing = newAllocator(resources, time.Now(), nil).reserveTogether("spread", servers, spread(3))
+	assert.Equal(1, missing)
+	assert.Equal(3, len(allocs[0].Allocated))
+	assert.Equal([]Blocker{{Reason: BlockedSpread, Count: 1, Leases: nil}}, allocs[0].Blockers)
+
+	// Each site has a single rack, the one with enough servers misses least
+	allocs, missing = newAllocator(resources, time.Now(), nil).reserveTogether("spread", servers,
+		constraints{together: []string{"site"}, spread: []lease.Spread{{Scope: "Rack", Min: 2}}})
+	assert.Equal(1, missing)
+	assert.Equal("west", allocs[0].Placement)
+}
+
+func TestAffinity(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	servers := []*compute.Server{}
+
+	for i := 0; i < 2; i++ {
+		server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+			zebra.Labels{"system.group": "servers"})
+		servers = append(servers, server)
+		resources.Add(server, "Server")
+	}
+
+	// Hosts refer to the server they run on, the first server has two
+	for _, server := range []*compute.Server{servers[1], servers[0], servers[0]} {
+		resources.Add(compute.NewESX("esx", server.ID, net.ParseIP("10.1.0.2"), zebra.Labels{"system.group": "esx"}), "ESX")
+	}
+
+	hosts := []AllocationRequest{{Type: "ESX", Group: AnyGroup, Name: "", Count: 2, Filters: nil}}
+	a := newAllocator(resources, time.Now(), nil)
+
+	allocs, missing := a.reserveTogether("same-server", hosts, together("Server"))
+	assert.Equal(0, missing)
+	assert.Equal(servers[0].ID, allocs[0].Placement)
+
+	// The same goes for simulated leases
+	result := simulateLeases(resources, &SimulationRequest{At: time.Now(), Leases: []SimulatedLease{
+		{Name: "any", Request: hosts, Placement: nil, Spread: nil},
+		{Name: "same", Request: hosts, Placement: []string{"Server"}, Spread: nil},
+	}}, nil)
+	assert.False(result.Satisfiable)
+	assert.Equal(servers[0].ID, result.Allocations[1].Placement)
+	assert.Equal(0, result.Allocations[1].Missing)
+	assert.Equal(1, result.Allocations[0].Missing)
+
+	sr := &SimulationRequest{At: time.Now(), Leases: []SimulatedLease{
+		{Name: "", Request: hosts, Placement: nil, Spread: []lease.Spread{{Scope: "", Min: 2}}},
+	}}
+	assert.ErrorIs(sr.Validate(), lease.ErrSpread)
+}
+
+func TestGroupLease(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "placement_testgroup"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	ctx := context.Background()
+	resources, _, leased := rackResources()
+
+	assert.Nil(applyFunc(resources, func(r zebra.Resource) error { return api.create(ctx, r) }))
+
+	// Four servers and a switch fit in no rack, nothing is leased
+	req := func(count int) []*lease.ResourceReq {
+		return []*lease.ResourceReq{
+			{Type: "Server", Group: AnyGroup, Name: "", Count: count, Filters: nil, Resources: nil},
+			{Type: "Switch", Group: AnyGroup, Name: "", Count: 1, Filters: nil, Resources: nil},
+		}
+	}
+
+	big := lease.NewLease("user@zebra", time.Hour, req(4))
+	big.Placement = []string{"Rack"}
+	assert.Nil(api.create(ctx, big))
+
+	result, err := allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+	assert.Equal("activated 0 leases, preempted 0 leases, ended 0 preempted leases", result)
+
+	_ = applyFunc(api.Store.QueryType([]string{"Server"}), func(r zebra.Resource) error {
+		assert.Equal(zebra.Free, r.GetStatus().Lease)
+
+		return nil
+	})
+
+	assert.Nil(api.delete(ctx, big))
+
+	// Two servers and a switch do, all in the same rack
+	group := lease.NewLease("user@zebra", time.Hour, req(2))
+	group.Placement = []string{"Rack"}
+	assert.Nil(api.create(ctx, group))
+
+	result, err = allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+	assert.Equal("activated 1 leases, preempted 0 leases, ended 0 preempted leases", result)
+
+	rack := ""
+
+	for _, r := range group.RequestList() {
+		for _, held := range r.Resources {
+			res := findResource(api.Store, held.GetID())
+			assert.Equal(zebra.Leased, res.GetStatus().Lease)
+
+			if rack == "" {
+				rack = res.GetLabels()[zebra.ParentLabel]
+			}
+
+			assert.Equal(rack, res.GetLabels()[zebra.ParentLabel])
+		}
+	}
+
+	// Releasing the lease frees them all together
+	serve := func(claimsEmail string, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, claimsEmail, false))
+		rr := httptest.NewRecorder()
+
+		handleReleaseLease()(rr, httptest.NewRequest("POST", "/api/v1/leases/release/"+id, nil).WithContext(ctx),
+			httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	assert.Equal(http.StatusForbidden, serve("other@zebra", group.ID).Code)
+	assert.Equal(http.StatusNotFound, serve("user@zebra", "x").Code)
+
+	rr := serve("user@zebra", group.ID)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	released := new(lease.Lease)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), released))
+	assert.Equal(zebra.Inactive, released.Status.State)
+
+	_ = applyFunc(api.Store.Query(), func(r zebra.Resource) error {
+		if r.GetID() != leased.ID && r.GetType() != "Lease" {
+			assert.NotEqual(zebra.Leased, r.GetStatus().Lease)
+		}
+
+		return nil
+	})
+
+	assert.Equal(http.StatusConflict, serve("user@zebra", group.ID).Code)
+}
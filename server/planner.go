@@ -0,0 +1,187 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/store"
+)
+
+// estimator is implemented by stores that keep statistics of their indexes,
+// the planner uses them to start a query with its most selective predicate.
+type estimator interface {
+	LabelCardinality(query zebra.Query) (int, bool)
+	TypeCardinality(types []string) int
+}
+
+// queryPlan is the order in which the predicates of a query are evaluated.
+// The resources are looked up by the IDs, the primary label query or the
+// types, in that order of preference, and then narrowed down by the types,
+// if not looked up by them, the filters, in order, and the lifecycle stages.
+type queryPlan struct {
+	IDs       []string
+	Types     []string
+	Primary   *zebra.Query
+	Filters   []zebra.Query
+	Lifecycle []zebra.Lifecycle
+}
+
+// plan orders the predicates of the query request by selectivity, estimated
+// from the cardinality of the store indexes. Only a label query that selects
+// values can be looked up in the label index, the others must scan what the
+// lookup returns. Without statistics the predicates keep the request order.
+func (api *ResourceAPI) plan(qr *QueryRequest) queryPlan {
+	p := queryPlan{IDs: qr.IDs, Types: qr.Types, Primary: nil, Filters: nil, Lifecycle: qr.Lifecycle}
+	labels := append([]zebra.Query{}, qr.Labels...)
+
+	est, ok := api.Store.(estimator)
+	if !ok || len(labels) == 0 {
+		p.Filters, p.Primary = planWithoutStats(p, labels)
+
+		return p
+	}
+
+	counts := make([]int, len(labels))
+
+	for i, q := range labels {
+		count, warm := est.LabelCardinality(q)
+		if !warm {
+			p.Filters, p.Primary = planWithoutStats(p, labels)
+
+			return p
+		}
+
+		counts[i] = count
+	}
+
+	// The most selective filter runs first, leaving less for the others
+	order := make([]int, len(labels))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] < counts[order[j]] })
+
+	sorted := make([]zebra.Query, 0, len(labels))
+	for _, i := range order {
+		sorted = append(sorted, labels[i])
+	}
+
+	if len(p.IDs) != 0 {
+		p.Filters = sorted
+
+		return p
+	}
+
+	for i, q := range sorted {
+		if !selectsValues(q) {
+			continue
+		}
+
+		if len(p.Types) != 0 && est.TypeCardinality(p.Types) <= counts[order[i]] {
+			break
+		}
+
+		q := q
+		p.Primary = &q
+		p.Filters = append(sorted[:i:i], sorted[i+1:]...)
+
+		return p
+	}
+
+	if len(p.Types) == 0 {
+		// Nothing but label queries which do not select values, the first
+		// one is still cheaper to look up than all resources are to scan
+		p.Primary = &sorted[0]
+		sorted = sorted[1:]
+	}
+
+	p.Filters = sorted
+
+	return p
+}
+
+// planWithoutStats returns the filters and the primary label query of the
+// request order.
+func planWithoutStats(p queryPlan, labels []zebra.Query) ([]zebra.Query, *zebra.Query) {
+	if len(p.IDs) != 0 || len(p.Types) != 0 || len(labels) == 0 {
+		return labels, nil
+	}
+
+	return labels[1:], &labels[0]
+}
+
+func selectsValues(q zebra.Query) bool {
+	return q.Op == zebra.MatchEqual || q.Op == zebra.MatchIn || q.Op == zebra.MatchUnder
+}
+
+// querier answers queries: the store or a snapshot of it.
+type querier interface {
+	Query() *zebra.ResourceMap
+	QueryUUID(uuids []string) *zebra.ResourceMap
+	QueryType(types []string) *zebra.ResourceMap
+	QueryLabel(query zebra.Query) (*zebra.ResourceMap, error)
+}
+
+// run evaluates the plan against the store or snapshot.
+func (p queryPlan) run(s querier) *zebra.ResourceMap {
+	var resources *zebra.ResourceMap
+
+	// Errors can safely be ignored because the query has been validated
+	switch {
+	case len(p.IDs) != 0:
+		resources = s.QueryUUID(p.IDs)
+	case p.Primary != nil:
+		resources, _ = s.QueryLabel(*p.Primary)
+
+		if len(p.Types) != 0 {
+			resources, _ = store.FilterType(p.Types, resources)
+		}
+	case len(p.Types) != 0:
+		resources = s.QueryType(p.Types)
+	default:
+		resources = s.Query()
+	}
+
+	for _, q := range p.Filters {
+		resources = filterLabel(s, q, resources)
+	}
+
+	if len(p.Lifecycle) != 0 {
+		resources = store.FilterLifecycle(p.Lifecycle, resources)
+	}
+
+	return resources
+}
+
+// filterLabel narrows the resources down to those matching the label query.
+// Subtree queries are answered by the querier, the resources in between the
+// parents and the resources may have been filtered out.
+func filterLabel(s querier, q zebra.Query, resources *zebra.ResourceMap) *zebra.ResourceMap {
+	if q.Op != zebra.MatchUnder {
+		filtered, _ := store.FilterLabel(q, resources)
+
+		return filtered
+	}
+
+	under, _ := s.QueryLabel(q)
+	ids := map[string]bool{}
+
+	_ = applyFunc(under, func(r zebra.Resource) error {
+		ids[r.GetID()] = true
+
+		return nil
+	})
+
+	filtered := zebra.NewResourceMap(resources.GetFactory())
+
+	_ = applyFunc(resources, func(r zebra.Resource) error {
+		if ids[r.GetID()] {
+			filtered.Add(r, r.GetType())
+		}
+
+		return nil
+	})
+
+	return filtered
+}
@@ -0,0 +1,130 @@
+package server //nolint:testpackage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func count(resources *zebra.ResourceMap) int {
+	n := 0
+
+	for _, l := range resources.Resources {
+		n += len(l.Resources)
+	}
+
+	return n
+}
+
+func TestPlan(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	for i := 0; i < 10; i++ {
+		labels := zebra.Labels{"system.group": "labs", "color": "red"}
+		if i == 0 {
+			labels["owner"] = "user@zebra"
+			labels["color"] = "blue"
+		}
+
+		assert.Nil(api.Store.Create(dc.NewLab(fmt.Sprintf("lab%d", i), labels)))
+	}
+
+	group := zebra.Query{Key: "system.group", Op: zebra.MatchEqual, Values: []string{"labs"}}
+	owner := zebra.Query{Key: "owner", Op: zebra.MatchEqual, Values: []string{"user@zebra"}}
+	notRed := zebra.Query{Key: "color", Op: zebra.MatchNotEqual, Values: []string{"red"}}
+
+	// The most selective label query is looked up, whatever its position
+	qr := &QueryRequest{Labels: []zebra.Query{group, owner}}
+	p := api.plan(qr)
+	assert.Equal(&owner, p.Primary)
+	assert.Equal([]zebra.Query{group}, p.Filters)
+	assert.Equal(1, count(api.query(qr)))
+
+	// Queries which do not select values are never looked up but filter
+	// first when they are the most selective
+	qr = &QueryRequest{Labels: []zebra.Query{group, notRed}}
+	p = api.plan(qr)
+	assert.Equal(&group, p.Primary)
+	assert.Equal([]zebra.Query{notRed}, p.Filters)
+	assert.Equal(1, count(api.query(qr)))
+
+	qr = &QueryRequest{Labels: []zebra.Query{notRed}}
+	p = api.plan(qr)
+	assert.Equal(&notRed, p.Primary)
+	assert.Empty(p.Filters)
+
+	// Types are looked up if they select fewer resources than any label
+	qr = &QueryRequest{Types: []string{"Rack"}, Labels: []zebra.Query{group}}
+	p = api.plan(qr)
+	assert.Nil(p.Primary)
+	assert.Equal([]zebra.Query{group}, p.Filters)
+	assert.Equal(0, count(api.query(qr)))
+
+	qr = &QueryRequest{Types: []string{"Lab"}, Labels: []zebra.Query{group, owner}}
+	p = api.plan(qr)
+	assert.Equal(&owner, p.Primary)
+	assert.Equal(1, count(api.query(qr)))
+
+	// IDs are always looked up
+	qr = &QueryRequest{IDs: []string{"x"}, Labels: []zebra.Query{group, owner}}
+	p = api.plan(qr)
+	assert.Nil(p.Primary)
+	assert.Equal([]zebra.Query{owner, group}, p.Filters)
+
+	// Without statistics the request order is kept
+	api.Store = struct{ zebra.Store }{api.Store}
+
+	qr = &QueryRequest{Labels: []zebra.Query{group, owner}}
+	p = api.plan(qr)
+	assert.Equal(&group, p.Primary)
+	assert.Equal([]zebra.Query{owner}, p.Filters)
+	assert.Equal(1, count(api.query(qr)))
+}
+
+func TestPlanUnder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	labels := func(parent string) zebra.Labels {
+		return zebra.Labels{"system.group": "labs", zebra.ParentLabel: parent}
+	}
+
+	west := dc.NewLab("west", zebra.Labels{"system.group": "labs"})
+	room := dc.NewLab("room", labels(west.ID))
+	rack := dc.NewRack("rack", "A01", labels(room.ID))
+	other := dc.NewRack("other", "A02", zebra.Labels{"system.group": "labs"})
+
+	for _, res := range []zebra.Resource{west, room, rack, other} {
+		assert.Nil(api.Store.Create(res))
+	}
+
+	under := zebra.Query{Key: zebra.ParentLabel, Op: zebra.MatchUnder, Values: []string{west.ID}}
+	group := zebra.Query{Key: "system.group", Op: zebra.MatchEqual, Values: []string{"labs"}}
+
+	// The subtree is looked up as it is the most selective
+	qr := &QueryRequest{Labels: []zebra.Query{group, under}}
+	p := api.plan(qr)
+	assert.Equal(&under, p.Primary)
+	assert.Equal(2, count(api.query(qr)))
+
+	// The rooms in between are filtered out by type, the racks under them
+	// are still found
+	qr = &QueryRequest{IDs: []string{rack.ID, other.ID}, Types: []string{"Rack"}, Labels: []zebra.Query{under}}
+	p = api.plan(qr)
+	assert.Equal([]zebra.Query{under}, p.Filters)
+
+	found := api.query(qr)
+	assert.Equal(1, count(found))
+	assert.Equal(rack.ID, found.Resources["Rack"].Resources[0].GetID())
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// DefaultPolicyPackage is the rego package whose deny rules decide on
+// resource mutations.
+const (
+	DefaultPolicyPackage = "zebra"
+	DefaultPolicyTimeout = 2 * time.Second
+)
+
+// Policy modules are kept in the policy engine under these prefixes, by
+// name for the modules of the configuration and by ID for the resources.
+const (
+	configPolicyPrefix   = "zebra/config/"
+	resourcePolicyPrefix = "zebra/resources/"
+)
+
+// policyResponseLimit bounds the responses read from the policy engine.
+const policyResponseLimit = 1 << 20
+
+var (
+	ErrPolicyURL     = errors.New("policy engine url must be an absolute http or https url")
+	ErrPolicyPackage = errors.New("policy package must be a dotted rego package name")
+	ErrPolicyStatus  = errors.New("policy engine responded with an error status")
+	ErrPolicyModule  = errors.New("policy engine rejected the policy module")
+)
+
+// PolicyConfig is the policy engine of the server configuration, an Open
+// Policy Agent server at URL. The deny rules of the package, a set of
+// messages, reject the mutations for which they are not empty. Modules are
+// rego files, by name, loaded into the engine on start next to the Policy
+// resources. Timeout is a duration such as "1s".
+type PolicyConfig struct {
+	URL           string            `json:"url"`
+	Package       string            `json:"package,omitempty"`
+	Modules       map[string]string `json:"modules,omitempty"`
+	Timeout       string            `json:"timeout,omitempty"`
+	FailurePolicy FailurePolicy     `json:"failurePolicy,omitempty"`
+}
+
+// PolicyUser is the user making a mutation, as seen by policies.
+type PolicyUser struct {
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+	ServiceAccount bool   `json:"serviceAccount"`
+}
+
+// PolicyInput is the input of the deny rules for a resource mutation, the
+// previous resource is the stored version of an updated resource.
+type PolicyInput struct {
+	Action   auth.Action    `json:"action"`
+	User     PolicyUser     `json:"user"`
+	Resource zebra.Resource `json:"resource"`
+	Previous zebra.Resource `json:"previous,omitempty"`
+}
+
+// policyEngine evaluates the policies of an Open Policy Agent server.
+type policyEngine struct {
+	base    *url.URL
+	pkg     string
+	failure FailurePolicy
+	modules map[string]string
+	client  *http.Client
+}
+
+// newPolicyEngine returns the configured policy engine with the modules of
+// the configuration read, or nil if there is none.
+func newPolicyEngine(cfg *PolicyConfig) (*policyEngine, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+
+	base, err := url.Parse(cfg.URL)
+	if err != nil || !base.IsAbs() || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, ErrPolicyURL
+	}
+
+	pkg := cfg.Package
+	if pkg == "" {
+		pkg = DefaultPolicyPackage
+	}
+
+	for _, part := range strings.Split(pkg, ".") {
+		if part == "" || strings.ContainsAny(part, "/ ") {
+			return nil, ErrPolicyPackage
+		}
+	}
+
+	failure := cfg.FailurePolicy
+
+	switch failure {
+	case "":
+		failure = FailClosed
+	case FailClosed, FailOpen:
+	default:
+		return nil, ErrAdmissionPolicy
+	}
+
+	timeout := DefaultPolicyTimeout
+
+	if cfg.Timeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	modules := make(map[string]string, len(cfg.Modules))
+
+	for name, file := range cfg.Modules {
+		module, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		modules[configPolicyPrefix+name] = string(module)
+	}
+
+	return &policyEngine{
+		base:    base,
+		pkg:     pkg,
+		failure: failure,
+		modules: modules,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// load puts the modules of the configuration and the policy resources into
+// the engine.
+func (e *policyEngine) load(ctx context.Context, s zebra.Store) error {
+	ids := make([]string, 0, len(e.modules))
+	for id := range e.modules {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := e.putModule(ctx, id, e.modules[id]); err != nil {
+			return fmt.Errorf("policy %s: %w", id, err)
+		}
+	}
+
+	return applyFunc(s.QueryType([]string{"Policy"}), func(r zebra.Resource) error {
+		if p, ok := r.(*auth.Policy); ok {
+			if err := e.putModule(ctx, resourcePolicyPrefix+p.ID, p.Module); err != nil {
+				return fmt.Errorf("policy %s: %w", p.ID, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (e *policyEngine) url(elem ...string) string {
+	u := *e.base
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+
+	return u.String()
+}
+
+func (e *policyEngine) do(ctx context.Context, method, target, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, policyResponseLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest && method == http.MethodPut:
+		return nil, fmt.Errorf("%w: %s", ErrPolicyModule, bytes.TrimSpace(data))
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return nil, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("%w: %s", ErrPolicyStatus, resp.Status)
+	}
+
+	return data, nil
+}
+
+// putModule adds or replaces the module in the engine, which compiles it.
+func (e *policyEngine) putModule(ctx context.Context, id, module string) error {
+	_, err := e.do(ctx, http.MethodPut, e.url("v1", "policies", id), "text/plain", []byte(module))
+
+	return err
+}
+
+func (e *policyEngine) deleteModule(ctx context.Context, id string) error {
+	_, err := e.do(ctx, http.MethodDelete, e.url("v1", "policies", id), "", nil)
+
+	return err
+}
+
+// deny returns the messages of the deny rules for the input.
+func (e *policyEngine) deny(ctx context.Context, input *PolicyInput) ([]string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	elem := append([]string{"v1", "data"}, strings.Split(e.pkg, ".")...)
+
+	data, err := e.do(ctx, http.MethodPost, e.url(append(elem, "deny")...), "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without deny rules the result is undefined, and nothing is denied
+	result := new(struct {
+		Result []string `json:"result"`
+	})
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result.Result)
+
+	return result.Result, nil
+}
+
+// denials returns the resource mutations in resMap that the policies deny.
+func (e *policyEngine) denials(ctx context.Context, claims *auth.Claims, s zebra.Store, method string,
+	resMap *zebra.ResourceMap,
+) []Denial {
+	log := logr.FromContextOrDiscard(ctx)
+	user := PolicyUser{Email: claims.Email, Role: "", ServiceAccount: claims.ServiceAccount}
+
+	if claims.Role != nil {
+		user.Role = claims.Role.Name
+	}
+
+	denials := []Denial{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		input := &PolicyInput{Action: auth.ActionCreate, User: user, Resource: r, Previous: nil}
+
+		previous := findResource(s, r.GetID())
+
+		switch {
+		case method == http.MethodDelete:
+			input.Action = auth.ActionDelete
+		case previous != nil:
+			input.Action = auth.ActionUpdate
+			input.Previous = previous
+		}
+
+		reasons, err := e.deny(ctx, input)
+		if err != nil {
+			log.Error(err, "policy engine failed", "resource", r.GetID())
+
+			if e.failure == FailOpen {
+				return nil
+			}
+
+			reasons = []string{"policy engine failed: " + err.Error()}
+		}
+
+		for _, reason := range reasons {
+			denials = append(denials, Denial{ID: r.GetID(), Type: r.GetType(), Action: input.Action, Reason: reason})
+		}
+
+		return nil
+	})
+
+	return denials
+}
+
+// guardPolicies puts the policy resources of resMap into the policy engine,
+// or removes them for a delete, before they are stored. Modules that do not
+// compile are rejected. Dry runs leave the engine alone.
+func (api *ResourceAPI) guardPolicies(res http.ResponseWriter, req *http.Request, resMap *zebra.ResourceMap,
+	del bool,
+) bool {
+	if api.policy == nil || isDryRun(req) {
+		return true
+	}
+
+	ctx := req.Context()
+
+	var err error
+
+	if l, ok := resMap.Resources["Policy"]; ok {
+		for _, r := range l.Resources {
+			p, ok := r.(*auth.Policy)
+			if !ok {
+				continue
+			}
+
+			if del {
+				err = api.policy.deleteModule(ctx, resourcePolicyPrefix+p.ID)
+			} else {
+				err = api.policy.putModule(ctx, resourcePolicyPrefix+p.ID, p.Module)
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrPolicyModule):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+
+		return false
+	case err != nil:
+		logr.FromContextOrDiscard(ctx).Error(err, "policies could not be loaded")
+		res.WriteHeader(http.StatusBadGateway)
+
+		return false
+	}
+
+	return true
+}
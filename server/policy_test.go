@@ -0,0 +1,209 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOPA stands in for an Open Policy Agent server with one policy: only
+// network-admins may modify VLAN pools in prod, once a module is loaded.
+type fakeOPA struct {
+	lock    sync.Mutex
+	modules map[string]string
+	down    bool
+}
+
+func (o *fakeOPA) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.down {
+		res.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	id := strings.TrimPrefix(req.URL.Path, "/v1/policies/")
+
+	switch {
+	case req.Method == http.MethodPut:
+		module, _ := io.ReadAll(req.Body)
+		if !strings.HasPrefix(string(module), "package zebra") {
+			http.Error(res, `{"code": "invalid_parameter"}`, http.StatusBadRequest)
+
+			return
+		}
+
+		o.modules[id] = string(module)
+	case req.Method == http.MethodDelete:
+		delete(o.modules, id)
+	case req.URL.Path == "/v1/data/zebra/deny":
+		input := new(struct {
+			Input struct {
+				User     PolicyUser `json:"user"`
+				Resource struct {
+					Type   string       `json:"type"`
+					Labels zebra.Labels `json:"labels"`
+				} `json:"resource"`
+			} `json:"input"`
+		})
+		_ = json.NewDecoder(req.Body).Decode(input)
+
+		deny := []string{}
+		r := input.Input.Resource
+
+		if len(o.modules) != 0 && r.Type == "VLANPool" && r.Labels["env"] == "prod" &&
+			input.Input.User.Role != "network-admin" {
+			deny = append(deny, "only network-admins may modify VLAN pools in prod")
+		}
+
+		_ = json.NewEncoder(res).Encode(map[string]interface{}{"result": deny})
+	default:
+		res.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (o *fakeOPA) has(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	_, ok := o.modules[id]
+
+	return ok
+}
+
+func (o *fakeOPA) setDown(down bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.down = down
+}
+
+func TestNewPolicyEngine(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	e, err := newPolicyEngine(&PolicyConfig{URL: "", Package: "", Modules: nil, Timeout: "", FailurePolicy: ""})
+	assert.Nil(err)
+	assert.Nil(e)
+
+	for _, cfg := range []PolicyConfig{
+		{URL: "localhost"},
+		{URL: "http://localhost", Package: "zebra..authz"},
+		{URL: "http://localhost", FailurePolicy: "retry"},
+		{URL: "http://localhost", Timeout: "soon"},
+		{URL: "http://localhost", Modules: map[string]string{"missing": "policy_test_missing.rego"}},
+	} {
+		cfg := cfg
+		_, err := newPolicyEngine(&cfg)
+		assert.NotNil(err)
+	}
+
+	e, err = newPolicyEngine(&PolicyConfig{URL: "http://localhost:8181", Package: "zebra.authz"})
+	assert.Nil(err)
+	assert.Equal(FailClosed, e.failure)
+	assert.Equal("http://localhost:8181/v1/data/zebra/authz/deny", e.url("v1", "data", "zebra", "authz", "deny"))
+}
+
+func TestPolicyEngine(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	opa := &fakeOPA{lock: sync.Mutex{}, modules: map[string]string{}, down: false}
+	server := httptest.NewServer(opa)
+
+	defer server.Close()
+
+	file := path.Join(t.TempDir(), "vlans.rego")
+	assert.Nil(os.WriteFile(file, []byte("package zebra"), ReadWriteOnly))
+
+	engine, err := newPolicyEngine(&PolicyConfig{
+		URL: server.URL, Package: "", Modules: map[string]string{"vlans": file}, Timeout: "1s", FailurePolicy: "",
+	})
+	assert.Nil(err)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	stored := auth.NewPolicy("stored", "package zebra", zebra.Labels{"system.group": "security"})
+	assert.Nil(api.Store.Create(stored))
+
+	assert.Nil(engine.load(context.Background(), api.Store))
+	assert.True(opa.has(configPolicyPrefix + "vlans"))
+	assert.True(opa.has(resourcePolicyPrefix + stored.ID))
+
+	api.policy = engine
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	netAdmin := makeClaims(assert, "net@zebra", true)
+	netAdmin.Role.Name = "network-admin"
+
+	authorized := authzAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		handlePost()(res, req, nil)
+	}))
+	serve := func(claims *auth.Claims, method string, resources ...zebra.Resource) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/api/v1/resources", strings.NewReader(resMapJSON(assert, resources...)))
+
+		if method == "DELETE" {
+			handleDelete()(rr, req.WithContext(ctx), nil)
+		} else {
+			authorized.ServeHTTP(rr, req.WithContext(ctx))
+		}
+
+		return rr
+	}
+
+	pool := &network.VLANPool{
+		BaseResource: *zebra.NewBaseResource("VLANPool", zebra.Labels{"system.group": "net", "env": "prod"}),
+		RangeStart:   1,
+		RangeEnd:     100,
+	}
+
+	rr := serve(admin, "POST", pool)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	denials := []Denial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal([]Denial{{
+		ID: pool.ID, Type: "VLANPool", Action: auth.ActionCreate,
+		Reason: "only network-admins may modify VLAN pools in prod",
+	}}, denials)
+
+	assert.Equal(http.StatusOK, serve(netAdmin, "POST", pool).Code)
+
+	// Policy resources are loaded into the engine, which compiles them
+	broken := auth.NewPolicy("broken", "not rego", zebra.Labels{"system.group": "security"})
+	assert.Equal(http.StatusBadRequest, serve(admin, "POST", broken).Code)
+	assert.Nil(findResource(api.Store, broken.ID))
+
+	policy := auth.NewPolicy("new", "package zebra", zebra.Labels{"system.group": "security"})
+	assert.Equal(http.StatusOK, serve(admin, "POST", policy).Code)
+	assert.True(opa.has(resourcePolicyPrefix + policy.ID))
+
+	assert.Equal(http.StatusOK, serve(admin, "DELETE", policy).Code)
+	assert.False(opa.has(resourcePolicyPrefix + policy.ID))
+
+	// Without the engine, mutations fail closed
+	opa.setDown(true)
+	assert.Equal(http.StatusForbidden, serve(netAdmin, "POST", pool).Code)
+
+	engine.failure = FailOpen
+	assert.Equal(http.StatusOK, serve(netAdmin, "POST", pool).Code)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gojini.dev/web"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// ProblemType is the type of all problems, which carry no more than the
+// meaning of their status code, so that the title is the status text.
+const ProblemType = "about:blank"
+
+// Problem is an error response in the RFC 7807 problem details format.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// errorWriter holds back error responses without a body or with a plain text
+// one, as written by http.Error, so that they can be rewritten once complete.
+// Responses of other types are passed through.
+type errorWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func newErrorWriter(res http.ResponseWriter) *errorWriter {
+	return &errorWriter{ResponseWriter: res, code: 0, body: bytes.Buffer{}}
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	contentType := w.Header().Get("Content-Type")
+
+	if code >= http.StatusBadRequest && w.code == 0 &&
+		(contentType == "" || strings.HasPrefix(contentType, "text/plain")) {
+		w.code = code
+
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(data []byte) (int, error) {
+	if w.code != 0 {
+		return w.body.Write(data)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// message returns the error message held back.
+func (w *errorWriter) message() string {
+	return strings.TrimSuffix(w.body.String(), "\n")
+}
+
+// acceptsProblems tells if the Accept header of the request asks for
+// problem details.
+func acceptsProblems(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == ProblemContentType && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// problemAdapter returns the errors of the API as problem details to clients
+// that accept application/problem+json. The error message is the detail of
+// the problem, the request path its instance. Other clients get the errors as
+// they are.
+func problemAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if !acceptsProblems(req) {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			writer := newErrorWriter(res)
+			callNext(nextHandler, writer, req)
+
+			if writer.code == 0 {
+				return
+			}
+
+			problem := Problem{
+				Type:     ProblemType,
+				Title:    http.StatusText(writer.code),
+				Status:   writer.code,
+				Detail:   writer.message(),
+				Instance: req.URL.Path,
+			}
+
+			data, err := json.Marshal(problem)
+			if err != nil {
+				res.WriteHeader(writer.code)
+
+				return
+			}
+
+			res.Header().Set("Content-Type", ProblemContentType)
+			res.Header().Del("Content-Length")
+			res.Header().Del("X-Content-Type-Options")
+			res.WriteHeader(writer.code)
+			_, _ = res.Write(data)
+		})
+	}
+}
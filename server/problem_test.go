@@ -0,0 +1,105 @@
+package server //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsProblems(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	accepts := func(accept ...string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+
+		return acceptsProblems(req)
+	}
+
+	assert.False(accepts())
+	assert.False(accepts("application/json"))
+	assert.True(accepts("application/problem+json"))
+	assert.True(accepts("application/json, application/problem+json;q=0.5"))
+	assert.True(accepts("text/plain", "application/problem+json"))
+	assert.False(accepts("application/problem+json;q=0"))
+}
+
+func TestProblemAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c, err := newMessageCatalog(&LocaleConfig{
+		Dir:      "",
+		Messages: map[string]map[string]string{"de": {"bad request": "ungültige Anfrage"}},
+	})
+	assert.Nil(err)
+
+	handler := problemAdapter()(localizeAdapter(c)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/bad":
+			http.Error(res, "bad request", http.StatusBadRequest)
+		case "/empty":
+			res.WriteHeader(http.StatusForbidden)
+		case "/json":
+			res.Header().Set("Content-Type", "application/json")
+			res.WriteHeader(http.StatusConflict)
+			_, _ = res.Write([]byte(`{"conflict":true}`))
+		default:
+			_, _ = res.Write([]byte("ok"))
+		}
+	})))
+
+	serve := func(path string, accept string, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", lang)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	problem := func(rr *httptest.ResponseRecorder) Problem {
+		p := Problem{Type: "", Title: "", Status: 0, Detail: "", Instance: ""}
+		assert.Equal(ProblemContentType, rr.Header().Get("Content-Type"))
+		assert.Nil(json.Unmarshal(rr.Body.Bytes(), &p))
+
+		return p
+	}
+
+	rr := serve("/bad", ProblemContentType, "")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal(Problem{
+		Type: ProblemType, Title: "Bad Request", Status: http.StatusBadRequest, Detail: "bad request", Instance: "/bad",
+	}, problem(rr))
+
+	rr = serve("/bad", ProblemContentType, "de")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal("ungültige Anfrage", problem(rr).Detail)
+	assert.Equal("de", rr.Header().Get("Content-Language"))
+
+	rr = serve("/empty", ProblemContentType, "")
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.Equal(Problem{
+		Type: ProblemType, Title: "Forbidden", Status: http.StatusForbidden, Detail: "", Instance: "/empty",
+	}, problem(rr))
+
+	rr = serve("/json", ProblemContentType, "")
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Equal(`{"conflict":true}`, rr.Body.String())
+
+	rr = serve("/", ProblemContentType, "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("ok", rr.Body.String())
+
+	rr = serve("/bad", "application/json", "")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal("bad request\n", rr.Body.String())
+}
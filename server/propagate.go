@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// PropagateLabel opts a resource out of label propagation when set to
+// "false": it keeps its own values of the propagated labels.
+const PropagateLabel = "system.propagate"
+
+var (
+	ErrPropagationRule = errors.New("label propagation rules need labels, which can not be system labels, " +
+		"and known types")
+	ErrInheritDenied = errors.New("labels inherited from the parent are not allowed")
+)
+
+// PropagationRule copies the labels from the parents of the Parents types to
+// their children of the Children types, all types if none are given, such as
+// the site of a rack to the servers in it.
+type PropagationRule struct {
+	Labels   []string `json:"labels"`
+	Parents  []string `json:"parents,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// PropagationConfig is the label propagation rules of the server
+// configuration. Resources inherit the labels of the rules from their parent
+// when they are created or updated, and the children of a resource are
+// updated when its labels change.
+type PropagationConfig struct {
+	Rules []PropagationRule `json:"rules,omitempty"`
+}
+
+// propagationRules are the label propagation rules.
+type propagationRules []PropagationRule
+
+// newPropagationRules returns the rules of the configuration.
+func newPropagationRules(factory zebra.ResourceFactory, cfg *PropagationConfig) (propagationRules, error) {
+	rules := propagationRules{}
+
+	for _, r := range cfg.Rules {
+		if len(r.Labels) == 0 {
+			return nil, ErrPropagationRule
+		}
+
+		for _, k := range r.Labels {
+			if k == "" || strings.HasPrefix(k, SystemLabelPrefix) {
+				return nil, fmt.Errorf("%w: label %s", ErrPropagationRule, k)
+			}
+		}
+
+		for _, t := range append(append([]string{}, r.Parents...), r.Children...) {
+			if _, ok := factory.Type(t); !ok {
+				return nil, fmt.Errorf("%w: unknown type %s", ErrPropagationRule, t)
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// keys returns the labels the rules propagate from the parent to the child.
+func (p propagationRules) keys(parent zebra.Resource, child zebra.Resource) []string {
+	keys := []string{}
+
+	for _, r := range p {
+		if matchesType(r.Parents, parent.GetType()) && matchesType(r.Children, child.GetType()) {
+			keys = append(keys, r.Labels...)
+		}
+	}
+
+	return keys
+}
+
+func matchesType(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	for _, want := range types {
+		if want == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// propagate returns a copy of the child with the labels of the parent, and
+// whether any of them changed. Given the previous version of the parent, the
+// labels it no longer has are removed from the child, if the child got them
+// from it. Children that opted out are returned as they are.
+func (p propagationRules) propagate(decoder *zebra.Decoder, child zebra.Resource, parent zebra.Resource,
+	previous zebra.Resource,
+) (zebra.Resource, bool, error) {
+	labels := child.GetLabels()
+	if parent == nil || labels[PropagateLabel] == "false" {
+		return child, false, nil
+	}
+
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	from := parent.GetLabels()
+	changed := false
+
+	for _, k := range p.keys(parent, child) {
+		v, ok := from[k]
+		if ok {
+			changed = changed || labels[k] != v
+			labels[k] = v
+
+			continue
+		}
+
+		if previous == nil || !labels.HasKey(k) {
+			continue
+		}
+
+		if old, had := previous.GetLabels()[k]; had && labels[k] == old {
+			delete(labels, k)
+
+			changed = true
+		}
+	}
+
+	if !changed {
+		return child, false, nil
+	}
+
+	fields, err := resourceFields(child)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if fields["labels"], err = json.Marshal(labels); err != nil {
+		return nil, false, err
+	}
+
+	updated, err := decodeFields(decoder, fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return updated, true, nil
+}
+
+// inheritLabels replaces the resources of the request with copies carrying
+// the labels of their parents, which may come in the same request.
+func (api *ResourceAPI) inheritLabels(resMap *zebra.ResourceMap) error {
+	if len(api.propagation) == 0 {
+		return nil
+	}
+
+	view := &HookView{store: api.Store, request: resMap}
+	decoder := zebra.NewDecoder(api.factory)
+
+	for _, l := range resMap.Resources {
+		for i, r := range l.Resources {
+			parent := r.GetLabels()[zebra.ParentLabel]
+			if parent == "" {
+				continue
+			}
+
+			inherited, _, err := api.propagation.propagate(decoder, r, view.Find(parent), nil)
+			if err != nil {
+				return err
+			}
+
+			l.Resources[i] = inherited
+		}
+	}
+
+	return nil
+}
+
+// inherit returns a copy of the resource with the labels of its stored
+// parent.
+func (api *ResourceAPI) inherit(res zebra.Resource) (zebra.Resource, error) {
+	parent := res.GetLabels()[zebra.ParentLabel]
+	if len(api.propagation) == 0 || parent == "" {
+		return res, nil
+	}
+
+	inherited, _, err := api.propagation.propagate(zebra.NewDecoder(api.factory), res, findResource(api.Store, parent),
+		nil)
+
+	return inherited, err
+}
+
+// mayWrite tells if the user of the context may write the resource with its
+// labels, the stored version of it too if it is an update. Writes without a
+// user, those of the server itself, are trusted.
+func (api *ResourceAPI) mayWrite(ctx context.Context, res zebra.Resource) bool {
+	claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+	if !ok {
+		return true
+	}
+
+	stored := findResource(api.Store, res.GetID())
+	if stored == nil {
+		return claims.Allows(auth.ActionCreate, res.GetType(), res.GetLabels())
+	}
+
+	return claims.Allows(auth.ActionUpdate, stored.GetType(), stored.GetLabels()) &&
+		claims.Allows(auth.ActionUpdate, res.GetType(), res.GetLabels())
+}
+
+// syncChildren updates the children of the resource whose propagated labels
+// differ from it, given its previous version. The children are written as
+// any other resource, so that their own children are kept in sync in turn.
+// Protected children, and those the user may not update, keep their labels.
+func (api *ResourceAPI) syncChildren(ctx context.Context, res zebra.Resource, previous zebra.Resource) error {
+	if len(api.propagation) == 0 {
+		return nil
+	}
+
+	children, err := api.Store.QueryLabel(zebra.Query{
+		Key: zebra.ParentLabel, Op: zebra.MatchEqual, Values: []string{res.GetID()},
+	})
+	if err != nil {
+		return err
+	}
+
+	decoder := zebra.NewDecoder(api.factory)
+
+	return applyFunc(children, func(child zebra.Resource) error {
+		updated, changed, err := api.propagation.propagate(decoder, child, res, previous)
+		if err != nil || !changed {
+			return err
+		}
+
+		if isProtected(child) || !api.mayWrite(ctx, updated) {
+			logr.FromContextOrDiscard(ctx).Info("labels not propagated", "resource", child.GetID(), "from", res.GetID())
+
+			return nil
+		}
+
+		if err := api.create(ctx, updated); err != nil {
+			return err
+		}
+
+		api.recordAudit(ctx, "label.propagate", updated.GetID(), "from "+res.GetID())
+
+		return nil
+	})
+}
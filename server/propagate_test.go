@@ -0,0 +1,208 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagationRules(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	factory := store.DefaultFactory()
+
+	rules, err := newPropagationRules(factory, &PropagationConfig{Rules: []PropagationRule{
+		{Labels: []string{"site"}, Parents: []string{"Rack"}, Children: nil},
+		{Labels: []string{"owner"}, Parents: nil, Children: []string{"Server"}},
+	}})
+	assert.Nil(err)
+
+	rack := dc.NewRack("rack", "row", zebra.Labels{"site": "east", "owner": "ops"})
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"), nil)
+	lab := dc.NewLab("lab", nil)
+
+	assert.Equal([]string{"site", "owner"}, rules.keys(rack, server))
+	assert.Equal([]string{"site"}, rules.keys(rack, lab))
+	assert.Empty(rules.keys(lab, rack))
+
+	for _, bad := range []PropagationRule{
+		{Labels: nil, Parents: nil, Children: nil},
+		{Labels: []string{zebra.ParentLabel}, Parents: nil, Children: nil},
+		{Labels: []string{"site"}, Parents: []string{"Spaceship"}, Children: nil},
+		{Labels: []string{"site"}, Parents: nil, Children: []string{"Spaceship"}},
+	} {
+		_, err := newPropagationRules(factory, &PropagationConfig{Rules: []PropagationRule{bad}})
+		assert.ErrorIs(err, ErrPropagationRule)
+	}
+}
+
+func TestPropagateLabels(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	var err error
+
+	api.propagation, err = newPropagationRules(api.factory, &PropagationConfig{Rules: []PropagationRule{
+		{Labels: []string{"site"}, Parents: nil, Children: nil},
+	}})
+	assert.Nil(err)
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	post := func(resources ...zebra.Resource) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		rr := httptest.NewRecorder()
+		body := resMapJSON(assert, resources...)
+
+		handlePost()(rr, httptest.NewRequest("POST", "/api/v1/resources", strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr.Code
+	}
+
+	site := func(id string) string {
+		res := findResource(api.Store, id)
+		assert.NotNil(res)
+
+		return res.GetLabels()["site"]
+	}
+
+	// The rack and its servers may come in the same request
+	center := dc.NewDatacenter("address", "center", zebra.Labels{"system.group": "dc", "site": "east"})
+	rack := dc.NewRack("rack", "row", zebra.Labels{"system.group": "racks", zebra.ParentLabel: center.ID})
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers", zebra.ParentLabel: rack.ID, "site": "west"})
+	optOut := compute.NewServer([]string{"serial2", "model", "server2"}, net.ParseIP("10.1.0.2"),
+		zebra.Labels{"system.group": "servers", zebra.ParentLabel: rack.ID, "site": "west", PropagateLabel: "false"})
+
+	assert.Equal(http.StatusOK, post(center, rack, server, optOut))
+	assert.Equal("east", site(rack.ID))
+	assert.Equal("east", site(server.ID))
+	assert.Equal("west", site(optOut.ID))
+
+	// Changes of the parent reach the whole subtree
+	before := len(api.Audit.Entries())
+	center.Labels["site"] = "north"
+	assert.Equal(http.StatusOK, post(center))
+	assert.Equal("north", site(rack.ID))
+	assert.Equal("north", site(server.ID))
+	assert.Equal("west", site(optOut.ID))
+
+	propagated := 0
+
+	for _, e := range api.Audit.Entries()[before:] {
+		if e.Action == "label.propagate" {
+			propagated++
+		}
+	}
+
+	assert.Equal(2, propagated)
+
+	// Children can not drift from their parent
+	server.Labels["site"] = "south"
+	assert.Equal(http.StatusOK, post(server))
+	assert.Equal("north", site(server.ID))
+
+	// Labels removed from the parent are removed from the children
+	delete(center.Labels, "site")
+	assert.Equal(http.StatusOK, post(center))
+	assert.Equal("", site(rack.ID))
+	assert.False(findResource(api.Store, server.ID).GetLabels().HasKey("site"))
+	assert.Equal("west", site(optOut.ID))
+}
+
+func TestPropagateLabelsAuthz(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	var err error
+
+	api.propagation, err = newPropagationRules(api.factory, &PropagationConfig{Rules: []PropagationRule{
+		{Labels: []string{"team"}, Parents: nil, Children: nil},
+	}})
+	assert.Nil(err)
+
+	// The user may change the racks, and the servers of team a only
+	racks, err := auth.NewPriv("^Rack$", true, true, true, true)
+	assert.Nil(err)
+
+	servers, err := auth.NewPriv("^Server$", true, true, true, true)
+	assert.Nil(err)
+
+	user := auth.NewClaims("zebra", "a", &auth.Role{
+		Name: "team-a", Privileges: []*auth.Priv{racks, servers.WithSelector(zebra.Labels{"team": "a"})},
+	}, "a@zebra")
+
+	post := func(resources ...zebra.Resource) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, user)
+		rr := httptest.NewRecorder()
+		body := resMapJSON(assert, resources...)
+
+		handlePost()(rr, httptest.NewRequest("POST", "/api/v1/resources", strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	team := func(id string) string {
+		return findResource(api.Store, id).GetLabels()["team"]
+	}
+
+	rackA := dc.NewRack("a", "row", zebra.Labels{"system.group": "racks", "team": "a"})
+	rackB := dc.NewRack("b", "row", zebra.Labels{"system.group": "racks", "team": "b"})
+	assert.Equal(http.StatusOK, post(rackA, rackB).Code)
+
+	// A server of team a can not be put into team b through its parent
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers", zebra.ParentLabel: rackB.ID, "team": "a"})
+
+	rr := post(server)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	denials := []Denial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal([]Denial{{ID: server.ID, Type: "Server", Action: auth.ActionCreate, Reason: ""}}, denials)
+	assert.Nil(findResource(api.Store, server.ID))
+
+	ctx := context.WithValue(context.Background(), ClaimsCtxKey, user)
+	assert.ErrorIs(api.create(ctx, server), ErrInheritDenied)
+
+	// Children the user may not change, or that are protected, keep their
+	// labels when the parent changes
+	server.Labels[zebra.ParentLabel] = rackA.ID
+	assert.Equal(http.StatusOK, post(server).Code)
+
+	protected := compute.NewServer([]string{"serial2", "model", "server2"}, net.ParseIP("10.1.0.2"),
+		zebra.Labels{"system.group": "servers", zebra.ParentLabel: rackA.ID, ProtectedLabel: ProtectedValue})
+	assert.Nil(api.create(context.Background(), protected))
+	assert.Equal("a", team(protected.ID))
+
+	rackA.Labels["team"] = "c"
+	assert.Equal(http.StatusOK, post(rackA).Code)
+	assert.Equal("c", team(rackA.ID))
+	assert.Equal("a", team(server.ID))
+	assert.Equal("a", team(protected.ID))
+
+	// Without a user, the server propagates to all but protected children
+	rackA.Labels["team"] = "d"
+	assert.Nil(api.create(context.Background(), rackA))
+	assert.Equal("d", team(server.ID))
+	assert.Equal("a", team(protected.ID))
+}
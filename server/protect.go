@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// ProtectedLabel marks critical shared resources, such as core switches or
+// the host of the server itself. Only admins may set or remove it. Protected
+// resources are neither deleted nor have their labels changed or removed
+// unless forced by an admin, and with the approval gate enabled deletes also
+// wait for a second admin.
+const (
+	ProtectedLabel = "protected"
+	ProtectedValue = "true"
+)
+
+var ErrForceAdmin = errors.New("changing protected resources requires admin privileges")
+
+// isProtected returns true if the resource is protected.
+func isProtected(res zebra.Resource) bool {
+	return res.GetLabels().MatchEqual(ProtectedLabel, ProtectedValue)
+}
+
+// isForced returns true if the request overrides the protection of the
+// resources it changes.
+func isForced(req *http.Request) bool {
+	force, err := strconv.ParseBool(req.URL.Query().Get("force"))
+
+	return err == nil && force
+}
+
+// relabels returns true if the labels of the stored resource are changed or
+// removed by the update. Adding labels or changing the protection is not a
+// destructive change.
+func relabels(old zebra.Resource, update zebra.Resource) bool {
+	labels := update.GetLabels()
+
+	for key, value := range old.GetLabels() {
+		if key != ProtectedLabel && (!labels.HasKey(key) || labels[key] != value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// protectionDenials returns the mutations in resMap that protect or unprotect
+// resources, which only admins may do, and the mutations which change
+// protected resources, which must be forced.
+func protectionDenials(s zebra.Store, method string, resMap *zebra.ResourceMap) ([]Denial, []Denial) {
+	stored := map[string]zebra.Resource{}
+
+	_ = applyFunc(existingResources(s, resMap), func(r zebra.Resource) error {
+		stored[r.GetID()] = r
+
+		return nil
+	})
+
+	flags := []Denial{}
+	changes := []Denial{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		old, exists := stored[r.GetID()]
+
+		switch {
+		case method == http.MethodDelete:
+			if exists && isProtected(old) {
+				changes = append(changes, Denial{ID: r.GetID(), Type: r.GetType(), Action: auth.ActionDelete})
+			}
+		case !exists:
+			if isProtected(r) {
+				flags = append(flags, Denial{ID: r.GetID(), Type: r.GetType(), Action: auth.ActionCreate})
+			}
+		default:
+			if isProtected(old) != isProtected(r) {
+				flags = append(flags, Denial{ID: r.GetID(), Type: r.GetType(), Action: auth.ActionUpdate})
+			}
+
+			if isProtected(old) && relabels(old, r) {
+				changes = append(changes, Denial{ID: r.GetID(), Type: r.GetType(), Action: auth.ActionUpdate})
+			}
+		}
+
+		return nil
+	})
+
+	return flags, changes
+}
+
+// guardProtected checks the mutation of resMap, a delete or an update as the
+// method says, against the protection of the stored resources. It writes the error response and returns false if the
+// mutation may not go ahead, forced changes of protected resources are
+// recorded in the audit log. Handlers are only reached through the auth
+// adapter, without claims in the context the user is trusted as an admin.
+func (api *ResourceAPI) guardProtected(res http.ResponseWriter, req *http.Request, method string,
+	resMap *zebra.ResourceMap,
+) bool {
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+
+	claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+	admin := !ok || claims.Write(AdminKey)
+
+	flags, changes := protectionDenials(api.Store, method, resMap)
+
+	switch {
+	case len(flags) != 0 && !admin:
+		log.Info("resource protection not changed, not an admin", "denied", len(flags))
+		writeJSONCode(ctx, res, http.StatusForbidden, flags)
+
+		return false
+	case len(changes) == 0:
+		return true
+	case !isForced(req):
+		log.Info("protected resources not changed, not forced", "denied", len(changes))
+		writeJSONCode(ctx, res, http.StatusConflict, changes)
+
+		return false
+	case !admin:
+		http.Error(res, ErrForceAdmin.Error(), http.StatusForbidden)
+
+		return false
+	}
+
+	if !isDryRun(req) {
+		for _, d := range changes {
+			api.recordAudit(ctx, "resource.force", d.ID, string(d.Action))
+		}
+	}
+
+	log.Info("protection of resources overridden", "resources", len(changes))
+
+	return true
+}
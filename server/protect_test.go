@@ -0,0 +1,88 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtectedResources(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, method, url, body string) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr.Code
+	}
+
+	core := dc.NewLab("core", zebra.Labels{"system.group": "labs", "role": "core", ProtectedLabel: ProtectedValue})
+	body := resMapJSON(assert, core)
+
+	// Only admins protect resources
+	assert.Equal(http.StatusForbidden, serve(user, handlePost(), "POST", "/api/v1/resources", body))
+	assert.Equal(http.StatusOK, serve(admin, handlePost(), "POST", "/api/v1/resources", body))
+
+	// Adding labels is fine, changing or removing them must be forced
+	added := dc.NewLab("core", zebra.Labels{
+		"system.group": "labs", "role": "core", "rack": "r1", ProtectedLabel: ProtectedValue,
+	})
+	added.ID = core.ID
+	assert.Equal(http.StatusOK, serve(user, handlePost(), "POST", "/api/v1/resources", resMapJSON(assert, added)))
+
+	changed := dc.NewLab("core", zebra.Labels{"system.group": "labs", "role": "edge", ProtectedLabel: ProtectedValue})
+	changed.ID = core.ID
+	body = resMapJSON(assert, changed)
+	assert.Equal(http.StatusConflict, serve(admin, handlePost(), "POST", "/api/v1/resources", body))
+	assert.Equal(http.StatusForbidden, serve(user, handlePost(), "POST", "/api/v1/resources?force=true", body))
+
+	before := len(api.Audit.Entries())
+	assert.Equal(http.StatusOK, serve(admin, handlePost(), "POST", "/api/v1/resources?dryRun=true&force=true", body))
+	assert.Equal(before, len(api.Audit.Entries()))
+	assert.Equal(http.StatusOK, serve(admin, handlePost(), "POST", "/api/v1/resources?force=true", body))
+
+	entries := api.Audit.Entries()
+	assert.Equal("resource.force", entries[before].Action)
+	assert.Equal(core.ID, entries[before].Resource)
+
+	// Labels of protected resources are only renamed if forced
+	rename := `{"from": "role", "to": "function"}`
+	assert.Equal(http.StatusConflict, serve(admin, handleRenameLabel(), "POST", "/api/v1/labels/rename", rename))
+	assert.Equal(http.StatusOK, serve(admin, handleRenameLabel(), "POST", "/api/v1/labels/rename?force=true", rename))
+	assert.True(findResource(api.Store, core.ID).GetLabels().HasKey("function"))
+
+	// Protected resources are only deleted if forced
+	body = resMapJSON(assert, core)
+	assert.Equal(http.StatusConflict, serve(admin, handleDelete(), "DELETE", "/api/v1/resources", body))
+	assert.Equal(http.StatusConflict, serve(admin, handleDeleteV2(), "DELETE", "/api/v2/resources", body))
+	assert.Equal(http.StatusForbidden, serve(user, handleDelete(), "DELETE", "/api/v1/resources?force=true", body))
+	assert.NotNil(findResource(api.Store, core.ID))
+
+	// Unprotecting takes an admin, and no force
+	unprotected := dc.NewLab("core", zebra.Labels{"system.group": "labs", "function": "edge"})
+	unprotected.ID = core.ID
+	body = resMapJSON(assert, unprotected)
+	assert.Equal(http.StatusForbidden, serve(user, handlePost(), "POST", "/api/v1/resources", body))
+	assert.Equal(http.StatusOK, serve(admin, handlePost(), "POST", "/api/v1/resources", body))
+	assert.Equal(http.StatusOK, serve(user, handleDelete(), "DELETE", "/api/v1/resources", body))
+	assert.Nil(findResource(api.Store, core.ID))
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/notify"
+)
+
+// Kinds of provisioning hooks.
+const (
+	ProvisionScript  = "script"
+	ProvisionHTTP    = "http"
+	ProvisionAnsible = "ansible"
+)
+
+// DefaultProvisionTimeout is how long a provisioning hook may run if no
+// timeout is configured. Re-imaging a server takes a while.
+const DefaultProvisionTimeout = 30 * time.Minute
+
+// AnsibleHostLabel overrides the name of a resource as its host in the
+// Ansible inventory.
+const AnsibleHostLabel = "ansible.host"
+
+// provisionOutputLimit bounds the output of a run kept on the lease.
+const provisionOutputLimit = 4096
+
+var (
+	ErrProvisionName  = errors.New("provisioning hooks need a unique name")
+	ErrProvisionKind  = errors.New("provisioning hook kind must be script, http or ansible")
+	ErrProvisionEvent = errors.New("provisioning hook events must be grant or release")
+	ErrProvisionHook  = errors.New("provisioning hook is missing its command, url or playbook")
+)
+
+var provisionRuns = metrics.Default.Counter("zebra_provision_runs_total",
+	"Provisioning hook runs, by hook, event and result.", "hook", "event", "result")
+
+// ProvisionHookConfig is a provisioning hook of the server configuration,
+// run for each resource of the given types, or of all types if none are
+// given, when a lease holding it is granted or released.
+//
+// A script hook runs the command with the lease and the resource in the
+// environment and the resource as JSON on its standard input. An http hook
+// posts a ProvisionRequest to the URL, signed with the secret if one is
+// set. An ansible hook runs the playbook against the resource, with the
+// lease and the resource as extra variables; Command replaces the
+// ansible-playbook command and Inventory the inventory of just the host.
+type ProvisionHookConfig struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Types     []string `json:"types,omitempty"`
+	Events    []string `json:"events,omitempty"`
+	Command   []string `json:"command,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+	Playbook  string   `json:"playbook,omitempty"`
+	Inventory string   `json:"inventory,omitempty"`
+	Timeout   string   `json:"timeout,omitempty"`
+}
+
+// ProvisioningConfig is the provisioning hooks of the server configuration.
+type ProvisioningConfig struct {
+	Hooks []ProvisionHookConfig `json:"hooks,omitempty"`
+}
+
+// ProvisionRequest is what http hooks are posted, and what ansible hooks
+// get as extra variables.
+type ProvisionRequest struct {
+	Event    string         `json:"event"`
+	Lease    string         `json:"lease"`
+	Owner    string         `json:"owner"`
+	Resource zebra.Resource `json:"resource"`
+}
+
+type provisionHook struct {
+	ProvisionHookConfig
+	timeout time.Duration
+	client  *http.Client
+}
+
+// provisioner runs the provisioning hooks of leases in the background and
+// records their runs on the leases.
+type provisioner struct {
+	hooks   []provisionHook
+	lock    sync.Mutex
+	running sync.WaitGroup
+}
+
+// newProvisioner returns a provisioner of the configured hooks, or nil if
+// there are none.
+func newProvisioner(cfg *ProvisioningConfig) (*provisioner, error) {
+	if len(cfg.Hooks) == 0 {
+		return nil, nil
+	}
+
+	names := map[string]bool{}
+	hooks := make([]provisionHook, 0, len(cfg.Hooks))
+
+	for _, hook := range cfg.Hooks {
+		if hook.Name == "" || names[hook.Name] {
+			return nil, ErrProvisionName
+		}
+
+		names[hook.Name] = true
+
+		if err := validProvisionHook(hook); err != nil {
+			return nil, fmt.Errorf("provisioning hook %s: %w", hook.Name, err)
+		}
+
+		timeout := DefaultProvisionTimeout
+
+		if hook.Timeout != "" {
+			d, err := time.ParseDuration(hook.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("provisioning hook %s: invalid timeout %s", hook.Name, hook.Timeout)
+			}
+
+			timeout = d
+		}
+
+		hooks = append(hooks, provisionHook{
+			ProvisionHookConfig: hook,
+			timeout:             timeout,
+			client:              &http.Client{Timeout: timeout},
+		})
+	}
+
+	return &provisioner{hooks: hooks, lock: sync.Mutex{}, running: sync.WaitGroup{}}, nil
+}
+
+func validProvisionHook(hook ProvisionHookConfig) error {
+	for _, e := range hook.Events {
+		if e != lease.ProvisionGrant && e != lease.ProvisionRelease {
+			return ErrProvisionEvent
+		}
+	}
+
+	switch hook.Kind {
+	case ProvisionScript:
+		if len(hook.Command) == 0 {
+			return ErrProvisionHook
+		}
+	case ProvisionHTTP:
+		u, err := url.Parse(hook.URL)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return ErrWebhookURL
+		}
+	case ProvisionAnsible:
+		if hook.Playbook == "" {
+			return ErrProvisionHook
+		}
+	default:
+		return ErrProvisionKind
+	}
+
+	return nil
+}
+
+func (h *provisionHook) matches(event string, res zebra.Resource) bool {
+	return (len(h.Types) == 0 || zebra.IsIn(res.GetType(), h.Types)) &&
+		(len(h.Events) == 0 || zebra.IsIn(event, h.Events))
+}
+
+// provisionJob is a run of a hook for a resource of a lease.
+type provisionJob struct {
+	hook *provisionHook
+	res  zebra.Resource
+}
+
+// provisionJobs returns the runs of the hooks of the event for the resources
+// the lease holds, and records them on the lease as pending.
+func (api *ResourceAPI) provisionJobs(l *lease.Lease, event string) []provisionJob {
+	jobs := []provisionJob{}
+
+	if api.provisioner == nil {
+		return jobs
+	}
+
+	for _, req := range l.RequestList() {
+		for _, held := range req.Resources {
+			res := findResource(api.Store, held.GetID())
+			if res == nil {
+				continue
+			}
+
+			for i := range api.provisioner.hooks {
+				hook := &api.provisioner.hooks[i]
+				if !hook.matches(event, res) {
+					continue
+				}
+
+				jobs = append(jobs, provisionJob{hook: hook, res: res})
+				l.SetProvision(lease.ProvisionRun{
+					Hook: hook.Name, Event: event, Resource: res.GetID(), State: lease.ProvisionPending,
+					Started: time.Time{}, Finished: time.Time{}, Output: "", Error: "",
+				})
+			}
+		}
+	}
+
+	return jobs
+}
+
+// provision runs the jobs of the event one after the other in the
+// background, so that a slow re-image does not hold up the grant or the
+// release of the lease.
+func (api *ResourceAPI) provision(l *lease.Lease, event string, jobs []provisionJob) {
+	if len(jobs) == 0 {
+		return
+	}
+
+	leaseID, owner := l.ID, l.Owner()
+	api.provisioner.running.Add(1)
+
+	go func() {
+		defer api.provisioner.running.Done()
+
+		for _, j := range jobs {
+			api.runProvision(j.hook, &ProvisionRequest{Event: event, Lease: leaseID, Owner: owner, Resource: j.res})
+		}
+	}()
+}
+
+// runProvision runs the hook for the request and records the run on the
+// lease.
+func (api *ResourceAPI) runProvision(hook *provisionHook, req *ProvisionRequest) {
+	run := lease.ProvisionRun{
+		Hook: hook.Name, Event: req.Event, Resource: req.Resource.GetID(), State: lease.ProvisionRunning,
+		Started: time.Now(), Finished: time.Time{}, Output: "", Error: "",
+	}
+	api.recordProvision(req.Lease, run)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+
+	output, err := hook.run(ctx, req)
+
+	run.Finished = time.Now()
+	run.Output = truncateOutput(output)
+	run.State = lease.ProvisionSucceeded
+
+	if err != nil {
+		run.State = lease.ProvisionFailed
+		run.Error = err.Error()
+
+		_ = api.Inbox.Notify(notify.NewNotification(req.Owner, "provisioning failed",
+			fmt.Sprintf("provisioning hook %s failed on %s of lease %s: %s", hook.Name, run.Resource, req.Lease,
+				run.Error), req.Lease))
+	}
+
+	provisionRuns.Inc(hook.Name, req.Event, run.State)
+	api.recordProvision(req.Lease, run)
+	api.recordSystemAudit("lease.provision", req.Lease,
+		fmt.Sprintf("%s %s of %s: %s", hook.Name, req.Event, run.Resource, run.State))
+}
+
+// recordProvision records the run on the lease, if it still exists.
+func (api *ResourceAPI) recordProvision(leaseID string, run lease.ProvisionRun) {
+	api.provisioner.lock.Lock()
+	defer api.provisioner.lock.Unlock()
+
+	stored, ok := findResource(api.Store, leaseID).(*lease.Lease)
+	if !ok {
+		return
+	}
+
+	l, err := copyLease(api.factory, stored)
+	if err != nil {
+		return
+	}
+
+	l.SetProvision(run)
+	_ = api.create(context.Background(), l)
+}
+
+// run runs the hook and returns its output.
+func (h *provisionHook) run(ctx context.Context, req *ProvisionRequest) (string, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	switch h.Kind {
+	case ProvisionHTTP:
+		return h.post(ctx, payload)
+	case ProvisionAnsible:
+		command := h.Command
+		if len(command) == 0 {
+			command = []string{"ansible-playbook"}
+		}
+
+		host := ansibleHost(req.Resource)
+		inventory := host + ","
+		args := append([]string{}, command[1:]...)
+
+		if h.Inventory != "" {
+			inventory = h.Inventory
+			args = append(args, "--limit", host)
+		}
+
+		args = append(args, "-i", inventory, "-e", string(payload), h.Playbook)
+
+		return h.exec(ctx, command[0], args, req, nil)
+	}
+
+	return h.exec(ctx, h.Command[0], h.Command[1:], req, payload)
+}
+
+// exec runs the command with the request in its environment and returns
+// its combined output.
+func (h *provisionHook) exec(ctx context.Context, name string, args []string, req *ProvisionRequest,
+	stdin []byte,
+) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...) //nolint:gosec
+	cmd.Env = append(os.Environ(),
+		"ZEBRA_EVENT="+req.Event,
+		"ZEBRA_LEASE="+req.Lease,
+		"ZEBRA_OWNER="+req.Owner,
+		"ZEBRA_RESOURCE="+req.Resource.GetID(),
+		"ZEBRA_RESOURCE_TYPE="+req.Resource.GetType(),
+	)
+
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	out, err := cmd.CombinedOutput()
+
+	return string(out), err
+}
+
+// post posts the request to the hook, any response other than 2xx fails.
+func (h *provisionHook) post(ctx context.Context, payload []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(h.Secret, payload))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, provisionOutputLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return string(body), fmt.Errorf("%w: %s", ErrWebhookStatus, resp.Status)
+	}
+
+	return string(body), nil
+}
+
+// ansibleHost returns the host of the resource in the Ansible inventory:
+// its ansible.host label, or else its name, or else its ID.
+func ansibleHost(res zebra.Resource) string {
+	if host := res.GetLabels()[AnsibleHostLabel]; host != "" {
+		return host
+	}
+
+	if fields, err := resourceFields(res); err == nil {
+		name := ""
+		if json.Unmarshal(fields["name"], &name) == nil && name != "" {
+			return name
+		}
+	}
+
+	return res.GetID()
+}
+
+// truncateOutput keeps the end of the output, where errors usually are.
+func truncateOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > provisionOutputLimit {
+		output = "..." + output[len(output)-provisionOutputLimit:]
+	}
+
+	return output
+}
@@ -0,0 +1,174 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProvisioner(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p, err := newProvisioner(&ProvisioningConfig{Hooks: nil})
+	assert.Nil(err)
+	assert.Nil(p)
+
+	script := ProvisionHookConfig{Name: "reimage", Kind: ProvisionScript, Command: []string{"true"}}
+	p, err = newProvisioner(&ProvisioningConfig{Hooks: []ProvisionHookConfig{script}})
+	assert.Nil(err)
+	assert.Equal(DefaultProvisionTimeout, p.hooks[0].timeout)
+
+	for _, hook := range []struct {
+		cfg ProvisionHookConfig
+		err error
+	}{
+		{ProvisionHookConfig{Name: "", Kind: ProvisionScript, Command: []string{"true"}}, ErrProvisionName},
+		{ProvisionHookConfig{Name: "a", Kind: "puppet"}, ErrProvisionKind},
+		{ProvisionHookConfig{Name: "a", Kind: ProvisionScript}, ErrProvisionHook},
+		{ProvisionHookConfig{Name: "a", Kind: ProvisionAnsible}, ErrProvisionHook},
+		{ProvisionHookConfig{Name: "a", Kind: ProvisionHTTP, URL: "/reset"}, ErrWebhookURL},
+		{ProvisionHookConfig{Name: "a", Kind: ProvisionScript, Command: []string{"true"}, Events: []string{"expire"}},
+			ErrProvisionEvent},
+	} {
+		_, err := newProvisioner(&ProvisioningConfig{Hooks: []ProvisionHookConfig{hook.cfg}})
+		assert.ErrorIs(err, hook.err)
+	}
+
+	_, err = newProvisioner(&ProvisioningConfig{Hooks: []ProvisionHookConfig{script, script}})
+	assert.ErrorIs(err, ErrProvisionName)
+
+	script.Timeout = "forever"
+	_, err = newProvisioner(&ProvisioningConfig{Hooks: []ProvisionHookConfig{script}})
+	assert.NotNil(err)
+}
+
+func TestProvisionLease(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_provision_lease"
+	out := t.TempDir()
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	lock := sync.Mutex{}
+	posted := []ProvisionRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		v := struct {
+			Event    string          `json:"event"`
+			Lease    string          `json:"lease"`
+			Resource json.RawMessage `json:"resource"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&v)
+
+		lock.Lock()
+		posted = append(posted, ProvisionRequest{Event: v.Event, Lease: v.Lease, Owner: "", Resource: nil})
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	defer srv.Close()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	var err error
+
+	api.provisioner, err = newProvisioner(&ProvisioningConfig{Hooks: []ProvisionHookConfig{
+		{
+			Name: "reimage", Kind: ProvisionScript, Types: []string{"Lab"}, Events: []string{lease.ProvisionGrant},
+			Command: []string{"sh", "-c", `echo "$ZEBRA_EVENT $ZEBRA_OWNER" > ` + path.Join(out, "$ZEBRA_RESOURCE")},
+		},
+		{
+			Name: "reset", Kind: ProvisionHTTP, Events: []string{lease.ProvisionRelease}, URL: srv.URL,
+		},
+		{
+			Name: "switches", Kind: ProvisionScript, Types: []string{"Switch"}, Command: []string{"false"},
+		},
+		{
+			Name: "playbook", Kind: ProvisionAnsible, Types: []string{"Lab"}, Events: []string{lease.ProvisionRelease},
+			Command: []string{"echo"}, Playbook: "wipe.yml",
+		},
+	}})
+	assert.Nil(err)
+
+	ctx := context.Background()
+	a := dc.NewLab("a", zebra.Labels{"system.group": "labs", AnsibleHostLabel: "lab-a.example.com"})
+	l := pendingLease("alice@zebra", lease.Normal, 1)
+
+	for _, r := range []zebra.Resource{a, l} {
+		assert.Nil(api.create(ctx, r))
+	}
+
+	_, err = allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+	api.provisioner.running.Wait()
+
+	granted, ok := findResource(api.Store, l.ID).(*lease.Lease)
+	assert.True(ok)
+
+	runs := granted.ProvisionRuns(lease.ProvisionGrant)
+	assert.Equal(1, len(runs))
+	assert.Equal("reimage", runs[0].Hook)
+	assert.Equal(a.ID, runs[0].Resource)
+	assert.Equal(lease.ProvisionSucceeded, runs[0].State)
+	assert.False(runs[0].Finished.Before(runs[0].Started))
+
+	written, err := os.ReadFile(path.Join(out, a.ID))
+	assert.Nil(err)
+	assert.Equal("grant alice@zebra\n", string(written))
+
+	// Releasing the lease resets the lab
+	_, err = endLease(ctx, api, granted)
+	assert.Nil(err)
+	api.provisioner.running.Wait()
+
+	released, _ := findResource(api.Store, l.ID).(*lease.Lease)
+	runs = released.ProvisionRuns(lease.ProvisionRelease)
+	assert.Equal(2, len(runs))
+
+	for _, run := range runs {
+		assert.Equal(lease.ProvisionSucceeded, run.State, run.Hook)
+	}
+
+	assert.True(strings.Contains(runs[1].Output, "-i lab-a.example.com, -e"))
+	assert.True(strings.HasSuffix(runs[1].Output, "wipe.yml"))
+	assert.Equal([]ProvisionRequest{{Event: lease.ProvisionRelease, Lease: l.ID, Owner: "", Resource: nil}}, posted)
+	assert.Equal(4, len(api.Audit.QueryActorType("system")))
+
+	// Failed runs are recorded and the owner is told
+	api.provisioner.hooks[1].URL = srv.URL + "/gone"
+
+	_, err = endLease(ctx, api, released)
+	assert.Nil(err)
+	api.provisioner.running.Wait()
+
+	released, _ = findResource(api.Store, l.ID).(*lease.Lease)
+	runs = released.ProvisionRuns(lease.ProvisionRelease)
+	assert.Equal(lease.ProvisionFailed, runs[0].State)
+	assert.Contains(runs[0].Error, "404")
+
+	inbox := api.Inbox.List("alice@zebra")
+	assert.Equal("provisioning failed", inbox[len(inbox)-1].Subject)
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+)
+
+// Behaviors of a quota on overflow.
+const (
+	OverflowReject       = "reject"
+	OverflowEvictExpired = "evict-expired"
+)
+
+var (
+	ErrQuota         = errors.New("quotas need a known type, a positive max and an overflow of reject or evict-expired")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// QuotaConfig caps the number of resources of a type, in all namespaces or
+// in one, so that runaway automation can not fill up the store.
+type QuotaConfig struct {
+	Quotas []Quota `json:"quotas,omitempty"`
+}
+
+// Quota is the max number of resources of a type, of the namespace if one is
+// given. Creating resources beyond it, or moving them into its namespace, is
+// rejected, or with evict-expired, the expired resources are deleted to make
+// room, oldest first. Resources are expired once older than ExpireAfter, such
+// as "720h", or once expired by their own terms, as leases are. Protected
+// resources are never evicted.
+type Quota struct {
+	Type        string `json:"type"`
+	Namespace   string `json:"namespace,omitempty"`
+	Max         int    `json:"max"`
+	Overflow    string `json:"overflow,omitempty"`
+	ExpireAfter string `json:"expireAfter,omitempty"`
+}
+
+// QuotaUsage is a quota and the number of resources it counts.
+type QuotaUsage struct {
+	Quota
+	Used int `json:"used"`
+}
+
+// QuotaViolation is a quota a request would exceed.
+type QuotaViolation struct {
+	Quota
+	Used      int `json:"used"`
+	Requested int `json:"requested"`
+}
+
+// quota is a parsed Quota. Its lock serializes the writes of the resources
+// it counts.
+type quota struct {
+	Quota
+	expireAfter time.Duration
+	lock        *sync.Mutex
+}
+
+type quotas []quota
+
+func newQuotas(factory zebra.ResourceFactory, cfg *QuotaConfig) (quotas, error) {
+	q := make(quotas, 0, len(cfg.Quotas))
+
+	for _, c := range cfg.Quotas {
+		if _, ok := factory.Type(c.Type); !ok || c.Max <= 0 {
+			return nil, ErrQuota
+		}
+
+		if c.Overflow == "" {
+			c.Overflow = OverflowReject
+		}
+
+		if c.Overflow != OverflowReject && c.Overflow != OverflowEvictExpired {
+			return nil, ErrQuota
+		}
+
+		expireAfter := time.Duration(0)
+
+		if c.ExpireAfter != "" {
+			d, err := time.ParseDuration(c.ExpireAfter)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("quota of %s: %w", c.Type, ErrQuota)
+			}
+
+			expireAfter = d
+		}
+
+		q = append(q, quota{Quota: c, expireAfter: expireAfter, lock: &sync.Mutex{}})
+	}
+
+	return q, nil
+}
+
+// counts tells if the quota counts the resource.
+func (q quota) counts(res zebra.Resource) bool {
+	return res.GetType() == q.Type && (q.Namespace == "" || res.GetLabels()["system.group"] == q.Namespace)
+}
+
+// admits tells if writing the resource adds one to the resources the quota
+// counts: the resource is new, or its stored version is not counted, such as
+// one moved into the namespace of the quota.
+func (q quota) admits(res zebra.Resource, stored zebra.Resource) bool {
+	return q.counts(res) && (stored == nil || !q.counts(stored))
+}
+
+// expired tells if the resource may be evicted to make room.
+func (q quota) expired(res zebra.Resource, now time.Time) bool {
+	if isProtected(res) {
+		return false
+	}
+
+	if e, ok := res.(interface{ IsExpired() bool }); ok && e.IsExpired() {
+		return true
+	}
+
+	status := res.GetStatus()
+
+	return q.expireAfter > 0 && status != nil && !status.CreatedTime.IsZero() &&
+		now.Sub(status.CreatedTime) > q.expireAfter
+}
+
+// counted returns the resources of the store the quota counts.
+func (q quota) counted(s zebra.Store) []zebra.Resource {
+	counted := []zebra.Resource{}
+
+	if l, ok := s.QueryType([]string{q.Type}).Resources[q.Type]; ok {
+		for _, r := range l.Resources {
+			if q.counts(r) {
+				counted = append(counted, r)
+			}
+		}
+	}
+
+	return counted
+}
+
+// quotaUsage returns the quotas and their use.
+func (api *ResourceAPI) quotaUsage() []QuotaUsage {
+	usage := make([]QuotaUsage, 0, len(api.quotas))
+
+	for _, q := range api.quotas {
+		usage = append(usage, QuotaUsage{Quota: q.Quota, Used: len(q.counted(api.Store))})
+	}
+
+	return usage
+}
+
+// checkQuotas returns the quotas the resources of resMap exceed, and the
+// expired resources to evict to stay within the others. Updates are counted
+// by the quotas they move resources into.
+func (api *ResourceAPI) checkQuotas(resMap *zebra.ResourceMap, now time.Time) ([]QuotaViolation, []zebra.Resource) {
+	violations := []QuotaViolation{}
+	evictions := []zebra.Resource{}
+
+	for _, q := range api.quotas {
+		requested := 0
+
+		_ = applyFunc(resMap, func(r zebra.Resource) error {
+			if q.admits(r, findResource(api.Store, r.GetID())) {
+				requested++
+			}
+
+			return nil
+		})
+
+		if requested == 0 {
+			continue
+		}
+
+		counted := q.counted(api.Store)
+		over := len(counted) + requested - q.Max
+
+		if over <= 0 {
+			continue
+		}
+
+		expired := []zebra.Resource{}
+
+		if q.Overflow == OverflowEvictExpired {
+			for _, r := range counted {
+				if q.expired(r, now) {
+					expired = append(expired, r)
+				}
+			}
+		}
+
+		if len(expired) < over {
+			violations = append(violations, QuotaViolation{Quota: q.Quota, Used: len(counted), Requested: requested})
+
+			continue
+		}
+
+		sort.SliceStable(expired, func(i, j int) bool { return createdTime(expired[i]).Before(createdTime(expired[j])) })
+		evictions = append(evictions, expired[:over]...)
+	}
+
+	return violations, evictions
+}
+
+// admitQuotas locks the quotas that count the resource and returns an error
+// if the write adds the resource to any of them that is full. The locks are
+// held until the returned function is called, once the resource is written,
+// so that concurrent writes can not exceed a quota. Every write of the server goes
+// through it, requests to the resources API check the quotas before to evict
+// expired resources and report the violations.
+func (api *ResourceAPI) admitQuotas(res zebra.Resource) (func(), error) {
+	locked := []*sync.Mutex{}
+	release := func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].Unlock()
+		}
+	}
+
+	for _, q := range api.quotas {
+		if q.counts(res) {
+			q.lock.Lock()
+			locked = append(locked, q.lock)
+		}
+	}
+
+	if len(locked) == 0 {
+		return release, nil
+	}
+
+	stored := findResource(api.Store, res.GetID())
+
+	for _, q := range api.quotas {
+		if q.admits(res, stored) && len(q.counted(api.Store)) >= q.Max {
+			release()
+
+			return nil, fmt.Errorf("%w: %d of %s in %q", ErrQuotaExceeded, q.Max, q.Type, q.Namespace)
+		}
+	}
+
+	return release, nil
+}
+
+func createdTime(res zebra.Resource) time.Time {
+	if status := res.GetStatus(); status != nil {
+		return status.CreatedTime
+	}
+
+	return time.Time{}
+}
+
+// guardQuotas refuses requests that would exceed a quota, it writes the
+// quotas exceeded and returns false if there are any. Otherwise it returns
+// the expired resources to evict before the resources are created.
+func (api *ResourceAPI) guardQuotas(res http.ResponseWriter, req *http.Request,
+	resMap *zebra.ResourceMap,
+) ([]zebra.Resource, bool) {
+	violations, evictions := api.checkQuotas(resMap, time.Now())
+	if len(violations) != 0 {
+		writeJSONCode(req.Context(), res, http.StatusConflict, violations)
+
+		return nil, false
+	}
+
+	return evictions, true
+}
+
+// evict deletes the resources to make room for new ones.
+func (api *ResourceAPI) evict(ctx context.Context, evictions []zebra.Resource) error {
+	for _, r := range evictions {
+		if err := api.delete(ctx, r); err != nil {
+			return err
+		}
+
+		api.recordAudit(ctx, "quota.evict", r.GetID(), r.GetType())
+	}
+
+	return nil
+}
+
+// handleQuotas returns the quotas and their use.
+func handleQuotas() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.quotaUsage())
+	}
+}
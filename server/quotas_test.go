@@ -0,0 +1,184 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewQuotas(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	factory := store.DefaultFactory()
+
+	q, err := newQuotas(factory, &QuotaConfig{Quotas: nil})
+	assert.Nil(err)
+	assert.Empty(q)
+
+	q, err = newQuotas(factory, &QuotaConfig{Quotas: []Quota{
+		{Type: "VM", Namespace: "", Max: 10, Overflow: "", ExpireAfter: ""},
+		{Type: "Lab", Namespace: "labs", Max: 2, Overflow: OverflowEvictExpired, ExpireAfter: "24h"},
+	}})
+	assert.Nil(err)
+	assert.Equal(OverflowReject, q[0].Overflow)
+	assert.Equal(24*time.Hour, q[1].expireAfter)
+
+	for _, bad := range []Quota{
+		{Type: "Toaster", Namespace: "", Max: 10, Overflow: "", ExpireAfter: ""},
+		{Type: "VM", Namespace: "", Max: 0, Overflow: "", ExpireAfter: ""},
+		{Type: "VM", Namespace: "", Max: 10, Overflow: "drop", ExpireAfter: ""},
+		{Type: "VM", Namespace: "", Max: 10, Overflow: OverflowEvictExpired, ExpireAfter: "soon"},
+	} {
+		_, err := newQuotas(factory, &QuotaConfig{Quotas: []Quota{bad}})
+		assert.ErrorIs(err, ErrQuota)
+	}
+}
+
+func TestQuotas(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	q, err := newQuotas(api.factory, &QuotaConfig{Quotas: []Quota{
+		{Type: "Lab", Namespace: "labs", Max: 2, Overflow: OverflowReject, ExpireAfter: ""},
+		{Type: "Lab", Namespace: "scratch", Max: 2, Overflow: OverflowEvictExpired, ExpireAfter: "24h"},
+	}})
+	assert.Nil(err)
+
+	api.quotas = q
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	post := func(url string, labs ...zebra.Resource) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, admin)
+		rr := httptest.NewRecorder()
+
+		req := httptest.NewRequest("POST", url, strings.NewReader(resMapJSON(assert, labs...)))
+		handlePost()(rr, req.WithContext(ctx), nil)
+
+		return rr
+	}
+
+	lab := func(name string, group string, age time.Duration) *dc.Lab {
+		l := dc.NewLab(name, zebra.Labels{"system.group": group})
+		l.Status.CreatedTime = time.Now().Add(-age)
+
+		return l
+	}
+
+	a, b := lab("a", "labs", 0), lab("b", "labs", 0)
+	assert.Equal(http.StatusOK, post("/api/v1/resources", a, b).Code)
+
+	// Updates in the namespace are not counted, new resources over the quota
+	// are rejected
+	assert.Equal(http.StatusOK, post("/api/v1/resources", a).Code)
+
+	rr := post("/api/v1/resources", lab("c", "labs", 0))
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	violations := []QuotaViolation{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &violations))
+	assert.Len(violations, 1)
+	assert.Equal("labs", violations[0].Namespace)
+	assert.Equal(2, violations[0].Used)
+	assert.Equal(1, violations[0].Requested)
+
+	// Nor can a resource be moved in from another namespace
+	moved := lab("moved", "other", 0)
+	assert.Equal(http.StatusOK, post("/api/v1/resources", moved).Code)
+
+	moved.Labels.Add("system.group", "labs")
+	assert.Equal(http.StatusConflict, post("/api/v1/resources", moved).Code)
+	assert.ErrorIs(api.create(context.Background(), moved), ErrQuotaExceeded)
+	assert.Equal("other", findResource(api.Store, moved.ID).GetLabels()["system.group"])
+
+	// Other namespaces have their own quota
+	old := lab("old", "scratch", 72*time.Hour)
+	older := lab("older", "scratch", 96*time.Hour)
+	assert.Equal(http.StatusOK, post("/api/v1/resources", old, older).Code)
+
+	// Dry runs evict nothing
+	assert.Equal(http.StatusOK, post("/api/v1/resources?dryRun=true", lab("d", "scratch", 0)).Code)
+	assert.NotNil(findResource(api.Store, older.ID))
+
+	d := lab("d", "scratch", 0)
+	assert.Equal(http.StatusOK, post("/api/v1/resources", d).Code)
+	assert.Nil(findResource(api.Store, older.ID))
+	assert.NotNil(findResource(api.Store, old.ID))
+	assert.NotNil(findResource(api.Store, d.ID))
+
+	// Only expired resources are evicted
+	assert.Equal(http.StatusOK, post("/api/v1/resources", lab("e", "scratch", 0)).Code)
+	assert.Equal(http.StatusConflict, post("/api/v1/resources", lab("f", "scratch", 0)).Code)
+
+	rr = httptest.NewRecorder()
+	handleQuotas()(rr, httptest.NewRequest("GET", "/api/v1/quotas", nil).WithContext(
+		context.WithValue(context.Background(), ResourcesCtxKey, api)), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	usage := []QuotaUsage{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &usage))
+	assert.Len(usage, 2)
+	assert.Equal(2, usage[0].Used)
+	assert.Equal(2, usage[1].Used)
+}
+
+// TestQuotasConcurrentCreates creates resources concurrently outside of the
+// resources API, the quota holds for every write.
+func TestQuotasConcurrentCreates(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	q, err := newQuotas(api.factory, &QuotaConfig{Quotas: []Quota{
+		{Type: "Lab", Namespace: "", Max: 3, Overflow: OverflowReject, ExpireAfter: ""},
+	}})
+	assert.Nil(err)
+
+	api.quotas = q
+
+	created := int32(0)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			err := api.create(context.Background(), dc.NewLab(fmt.Sprint("lab", i), zebra.Labels{"system.group": "labs"}))
+			if err == nil {
+				atomic.AddInt32(&created, 1)
+			} else {
+				assert.ErrorIs(err, ErrQuotaExceeded)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(int32(3), created)
+	assert.Len(api.Store.QueryType([]string{"Lab"}).Resources["Lab"].Resources, 3)
+
+	// Updates are not counted
+	update := dc.NewLab("renamed", zebra.Labels{"system.group": "labs"})
+	update.ID = api.Store.QueryType([]string{"Lab"}).Resources["Lab"].Resources[0].GetID()
+	assert.Nil(api.create(context.Background(), update))
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/scheduler"
+	"gojini.dev/web"
+)
+
+// ReadOnlyHeader is the reason of the read-only mode, returned with the
+// mutations rejected in it.
+const ReadOnlyHeader = "Zebra-Read-Only"
+
+// ReadOnlySkipped is the result of the jobs that would change the store while
+// the server is read-only.
+const ReadOnlySkipped = "skipped, server is read-only"
+
+var requestsReadOnly = metrics.Default.Counter("zebra_http_requests_read_only_total",
+	"Mutations rejected in read-only mode, by method.", "method")
+
+// readOnlyQueries are the routes of the APIs, relative to /api/<version>,
+// that take a query in the body of a POST without changing anything.
+var readOnlyQueries = map[string]bool{ //nolint:gochecknoglobals
+	"/query/batch":         true,
+	"/diff":                true,
+	"/policy/simulate":     true,
+	"/leases/simulate":     true,
+	"/find-free":           true,
+	"/grafana/search":      true,
+	"/grafana/query":       true,
+	"/grafana/annotations": true,
+}
+
+// ReadOnlyConfig starts the server in read-only mode, for migrations,
+// restores and incident freezes. Admins turn the mode on and off at runtime.
+type ReadOnlyConfig struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+// ReadOnlyStatus is the read-only mode of the server, and the reason, time
+// and user of it being turned on.
+type ReadOnlyStatus struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	By      string     `json:"by,omitempty"`
+}
+
+// readOnlyMode rejects all mutations while it is on, queries and watches are
+// still served.
+type readOnlyMode struct {
+	lock   sync.RWMutex
+	status ReadOnlyStatus
+}
+
+func newReadOnlyMode(cfg *ReadOnlyConfig) *readOnlyMode {
+	m := &readOnlyMode{
+		lock:   sync.RWMutex{},
+		status: ReadOnlyStatus{Enabled: false, Reason: "", Since: nil, By: ""},
+	}
+
+	if cfg.Enabled {
+		m.set(true, cfg.Reason, "config", time.Now())
+	}
+
+	return m
+}
+
+func (m *readOnlyMode) Status() ReadOnlyStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.status
+}
+
+func (m *readOnlyMode) enabled() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.status.Enabled
+}
+
+func (m *readOnlyMode) set(enabled bool, reason string, by string, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !enabled {
+		m.status = ReadOnlyStatus{Enabled: false, Reason: "", Since: nil, By: ""}
+
+		return
+	}
+
+	m.status = ReadOnlyStatus{Enabled: true, Reason: reason, Since: &now, By: by}
+}
+
+// mutates returns true if the request may change the server state. Logins,
+// sessions and the administration of the server, which turns the read-only
+// mode off, are not counted as changes.
+func mutates(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	p := req.URL.Path
+	if p == "/login" || p == "/logout" || p == "/refresh" || strings.HasPrefix(p, "/admin/") {
+		return false
+	}
+
+	for _, version := range []string{APIv1, APIv2} {
+		prefix := "/api/" + version
+
+		if strings.HasPrefix(p, prefix+"/") && readOnlyQueries[strings.TrimPrefix(p, prefix)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readOnlyAdapter rejects the mutations with 503 while the server is
+// read-only.
+func readOnlyAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			api, ok := req.Context().Value(ResourcesCtxKey).(*ResourceAPI)
+			if !ok || !api.readOnly.enabled() || !mutates(req) {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			requestsReadOnly.Inc(req.Method)
+
+			status := api.readOnly.Status()
+			msg := "server is read-only"
+
+			if status.Reason != "" {
+				res.Header().Set(ReadOnlyHeader, status.Reason)
+				msg += ": " + status.Reason
+			}
+
+			http.Error(res, msg, http.StatusServiceUnavailable)
+		})
+	}
+}
+
+// unlessReadOnly skips the runs of the task while the server is read-only.
+func unlessReadOnly(api *ResourceAPI, task scheduler.Task) scheduler.Task {
+	return func(ctx context.Context) (string, error) {
+		if api.readOnly.enabled() {
+			return ReadOnlySkipped, nil
+		}
+
+		return task(ctx)
+	}
+}
+
+func readOnlyContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	switch {
+	case !apiOK || !claimsOK:
+		res.WriteHeader(http.StatusInternalServerError)
+	case !claims.Write(AdminKey):
+		res.WriteHeader(http.StatusForbidden)
+	default:
+		return api, claims, true
+	}
+
+	return nil, nil, false
+}
+
+// handleReadOnly returns the read-only mode of the server, admins only.
+func handleReadOnly() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, _, ok := readOnlyContext(res, req)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.readOnly.Status())
+	}
+}
+
+// handleSetReadOnly turns the read-only mode on with the reason of the
+// request, admins only.
+func handleSetReadOnly() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, claims, ok := readOnlyContext(res, req)
+		if !ok {
+			return
+		}
+
+		body := &struct {
+			Reason string `json:"reason"`
+		}{Reason: ""}
+
+		if req.ContentLength != 0 {
+			if err := readJSON(ctx, req, body); err != nil {
+				res.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+		}
+
+		api.readOnly.set(true, body.Reason, claims.Email, time.Now())
+		api.recordAudit(ctx, "readonly.enable", "", body.Reason)
+
+		writeJSON(ctx, res, api.readOnly.Status())
+	}
+}
+
+// handleClearReadOnly turns the read-only mode off, admins only.
+func handleClearReadOnly() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, claims, ok := readOnlyContext(res, req)
+		if !ok {
+			return
+		}
+
+		api.readOnly.set(false, "", claims.Email, time.Now())
+		api.recordAudit(ctx, "readonly.disable", "", "")
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,116 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutates(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	mutation := func(method string, path string) bool {
+		return mutates(httptest.NewRequest(method, path, nil))
+	}
+
+	assert.False(mutation(http.MethodGet, "/api/v1/resources"))
+	assert.False(mutation(http.MethodHead, "/api/v1/events"))
+	assert.False(mutation(http.MethodPost, "/api/v1/query/batch"))
+	assert.False(mutation(http.MethodPost, "/api/v2/grafana/query"))
+	assert.False(mutation(http.MethodPost, "/api/v1/find-free"))
+	assert.False(mutation(http.MethodPost, "/login"))
+	assert.False(mutation(http.MethodDelete, "/admin/read-only"))
+	assert.True(mutation(http.MethodPost, "/api/v1/resources"))
+	assert.True(mutation(http.MethodDelete, "/api/v2/resources"))
+	assert.True(mutation(http.MethodPost, "/api/v1/query/batch/x"))
+	assert.True(mutation(http.MethodPost, "/register"))
+}
+
+func TestReadOnly(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+	assert.False(api.readOnly.Status().Enabled)
+
+	serve := func(admin bool, h httprouter.Handle, method string, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "admin@zebra", admin))
+
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(method, "/admin/read-only", strings.NewReader(body)).WithContext(ctx), nil)
+
+		return rr
+	}
+
+	assert.Equal(http.StatusForbidden, serve(false, handleReadOnly(), http.MethodGet, "").Code)
+	assert.Equal(http.StatusForbidden, serve(false, handleSetReadOnly(), http.MethodPost, "").Code)
+	assert.Equal(http.StatusForbidden, serve(false, handleClearReadOnly(), http.MethodDelete, "").Code)
+	assert.Equal(http.StatusBadRequest, serve(true, handleSetReadOnly(), http.MethodPost, `{`).Code)
+
+	rr := serve(true, handleSetReadOnly(), http.MethodPost, `{"reason":"restore"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	status := new(ReadOnlyStatus)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), status))
+	assert.True(status.Enabled)
+	assert.Equal("restore", status.Reason)
+	assert.Equal("admin@zebra", status.By)
+	assert.NotNil(status.Since)
+
+	// Mutations are rejected, queries are served
+	handler := readOnlyAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil).WithContext(ctx))
+
+		return rr
+	}
+
+	rr = request(http.MethodPost, "/api/v1/resources")
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.Equal("restore", rr.Header().Get(ReadOnlyHeader))
+	assert.Contains(rr.Body.String(), "server is read-only: restore")
+	assert.Equal(http.StatusOK, request(http.MethodGet, "/api/v1/resources").Code)
+
+	// Jobs that change the store are skipped
+	ran := false
+	task := unlessReadOnly(api, func(ctx context.Context) (string, error) {
+		ran = true
+
+		return "ran", nil
+	})
+
+	result, err := task(context.Background())
+	assert.Nil(err)
+	assert.Equal(ReadOnlySkipped, result)
+	assert.False(ran)
+
+	assert.Equal(http.StatusOK, serve(true, handleReadOnly(), http.MethodGet, "").Code)
+	assert.Equal(http.StatusNoContent, serve(true, handleClearReadOnly(), http.MethodDelete, "").Code)
+	assert.False(api.readOnly.Status().Enabled)
+	assert.Equal(http.StatusOK, request(http.MethodPost, "/api/v1/resources").Code)
+
+	result, err = task(context.Background())
+	assert.Nil(err)
+	assert.Equal("ran", result)
+
+	// The mode may be turned on from the start
+	mode := newReadOnlyMode(&ReadOnlyConfig{Enabled: true, Reason: "migration"})
+	assert.Equal("config", mode.Status().By)
+	assert.WithinDuration(time.Now(), *mode.Status().Since, time.Minute)
+}
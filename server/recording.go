@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"gojini.dev/web"
+	"gopkg.in/yaml.v3"
+)
+
+// RecordHeader asks for the request of an admin to be recorded, whether or
+// not a recording window is open.
+const RecordHeader = "X-Zebra-Record"
+
+// Limits of recording.
+const (
+	DefaultRecordingWindow = 10 * time.Minute
+	MaxRecordingWindow     = time.Hour
+	MaxRecordings          = 200
+	MaxRecordedBody        = 64 << 10
+)
+
+// Redacted replaces the secrets of recorded requests and responses.
+const Redacted = "REDACTED"
+
+var ErrRecordingWindow = errors.New("recording windows are durations of up to 1h")
+
+// Exchange is a recorded request and its response. Credentials are redacted
+// from the headers and the JSON bodies, bodies are cut at 64KiB.
+type Exchange struct {
+	ID             uint64      `json:"id"`
+	Time           time.Time   `json:"time"`
+	Duration       string      `json:"duration"`
+	User           string      `json:"user"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader"`
+	RequestBody    string      `json:"requestBody,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"responseHeader"`
+	ResponseBody   string      `json:"responseBody,omitempty"`
+}
+
+// RecordingStatus is the recording window, if open, and the exchanges
+// recorded, oldest first.
+type RecordingStatus struct {
+	Recording bool       `json:"recording"`
+	Until     *time.Time `json:"until,omitempty"`
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// recorder keeps the last exchanges recorded in memory. Recordings are for
+// debugging only, they are lost on restart.
+type recorder struct {
+	lock      sync.Mutex
+	until     time.Time
+	seq       uint64
+	exchanges []Exchange
+}
+
+func newRecorder() *recorder {
+	return &recorder{lock: sync.Mutex{}, until: time.Time{}, seq: 0, exchanges: []Exchange{}}
+}
+
+// start opens the recording window for the duration from now.
+func (r *recorder) start(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.until = time.Now().Add(d)
+}
+
+// stop closes the recording window and drops the exchanges recorded.
+func (r *recorder) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.until = time.Time{}
+	r.exchanges = []Exchange{}
+}
+
+func (r *recorder) active(now time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return now.Before(r.until)
+}
+
+func (r *recorder) add(e Exchange) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	e.ID = r.seq
+
+	r.exchanges = append(r.exchanges, e)
+	if len(r.exchanges) > MaxRecordings {
+		r.exchanges = r.exchanges[len(r.exchanges)-MaxRecordings:]
+	}
+}
+
+func (r *recorder) status(now time.Time) RecordingStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := RecordingStatus{Recording: false, Until: nil, Exchanges: append([]Exchange{}, r.exchanges...)}
+
+	if now.Before(r.until) {
+		until := r.until
+		status.Recording = true
+		status.Until = &until
+	}
+
+	return status
+}
+
+// sensitive tells if a header or a JSON field holds credentials.
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, s := range []string{"authorization", "cookie", "password", "secret", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	// authKey, apiKey, privateKey but not the key of a key-value pair
+	return len(name) > len("key") && strings.HasSuffix(name, "key")
+}
+
+func sanitizeHeader(header http.Header) http.Header {
+	clean := http.Header{}
+
+	for name, values := range header {
+		if sensitive(name) {
+			clean[name] = []string{Redacted}
+		} else {
+			clean[name] = append([]string{}, values...)
+		}
+	}
+
+	return clean
+}
+
+func sanitizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if sensitive(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = sanitizeJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = sanitizeJSON(item)
+		}
+	}
+
+	return value
+}
+
+// sanitizeBody returns the body to record. JSON and YAML bodies are recorded
+// with the credentials redacted, anything else, text included, only by its
+// size: there is no telling where credentials are in it.
+func sanitizeBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var value interface{}
+
+	switch {
+	case json.Unmarshal(body, &value) == nil:
+		clean, err := json.Marshal(sanitizeJSON(value))
+		if err != nil {
+			return bodySize(contentType, body)
+		}
+
+		body = clean
+	case isYAML(contentType) && yaml.Unmarshal(body, &value) == nil:
+		clean, err := yaml.Marshal(sanitizeJSON(value))
+		if err != nil {
+			return bodySize(contentType, body)
+		}
+
+		body = clean
+	default:
+		return bodySize(contentType, body)
+	}
+
+	if len(body) > MaxRecordedBody {
+		return string(body[:MaxRecordedBody]) + "..."
+	}
+
+	return string(body)
+}
+
+// bodySize returns what is recorded of a body that is not recorded itself.
+func bodySize(contentType string, body []byte) string {
+	if contentType == "" {
+		return "<" + strconv.Itoa(len(body)) + " bytes>"
+	}
+
+	return "<" + strconv.Itoa(len(body)) + " bytes of " + contentType + ">"
+}
+
+// recordWriter keeps a copy of the status and the start of the body of a
+// response.
+type recordWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	if room := MaxRecordedBody + 1 - w.body.Len(); room > 0 {
+		if room > len(data) {
+			room = len(data)
+		}
+
+		w.body.Write(data[:room])
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// recordAdapter records the requests and responses of the recording window
+// opened by an admin, and those of admins that ask for it with the
+// X-Zebra-Record header, so that the exchanges of misbehaving clients can be
+// inspected. Requests are recorded once authenticated.
+func recordAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+			claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+			if !apiOK || !claimsOK || strings.HasPrefix(req.URL.Path, "/admin/recording") {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			start := time.Now()
+			asked := req.Header.Get(RecordHeader) != "" && claims.Write(AdminKey)
+
+			if !asked && !api.recordings.active(start) {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				res.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			// The handler reads the body again
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			writer := &recordWriter{ResponseWriter: res, code: 0, body: bytes.Buffer{}}
+			callNext(nextHandler, writer, req)
+
+			api.recordings.add(Exchange{
+				ID:             0,
+				Time:           start,
+				Duration:       time.Since(start).String(),
+				User:           claims.Email,
+				Method:         req.Method,
+				URL:            req.URL.String(),
+				RequestHeader:  sanitizeHeader(req.Header),
+				RequestBody:    sanitizeBody(req.Header.Get("Content-Type"), body),
+				Status:         writer.code,
+				ResponseHeader: sanitizeHeader(res.Header()),
+				ResponseBody:   sanitizeBody(res.Header().Get("Content-Type"), writer.body.Bytes()),
+			})
+		})
+	}
+}
+
+func recordingContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	switch {
+	case !apiOK || !claimsOK:
+		res.WriteHeader(http.StatusInternalServerError)
+	case !claims.Write(AdminKey):
+		res.WriteHeader(http.StatusForbidden)
+	default:
+		return api, true
+	}
+
+	return nil, false
+}
+
+// handleRecordings returns the recording window and the exchanges recorded,
+// admins only.
+func handleRecordings() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, ok := recordingContext(res, req)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.recordings.status(time.Now()))
+	}
+}
+
+// handleStartRecording opens a recording window of the duration of the
+// request, 10m if none is given, admins only. Opening the window again
+// extends or shortens it.
+func handleStartRecording() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := recordingContext(res, req)
+		if !ok {
+			return
+		}
+
+		window := &struct {
+			Duration string `json:"duration"`
+		}{Duration: ""}
+
+		if err := readJSON(ctx, req, window); err != nil && !errors.Is(err, ErrEmptyBody) {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		d := DefaultRecordingWindow
+
+		if window.Duration != "" {
+			parsed, err := time.ParseDuration(window.Duration)
+			if err != nil || parsed <= 0 || parsed > MaxRecordingWindow {
+				http.Error(res, ErrRecordingWindow.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			d = parsed
+		}
+
+		api.recordings.start(d)
+		api.recordAudit(ctx, "recording.start", "", d.String())
+
+		writeJSON(ctx, res, api.recordings.status(time.Now()))
+	}
+}
+
+// handleStopRecording closes the recording window and drops the exchanges
+// recorded, admins only.
+func handleStopRecording() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := recordingContext(res, req)
+		if !ok {
+			return
+		}
+
+		api.recordings.stop()
+		api.recordAudit(ctx, "recording.stop", "", "")
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
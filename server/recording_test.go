@@ -0,0 +1,155 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	header := sanitizeHeader(http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"jwt=abc"},
+		"X-Api-Key":     {"abc"},
+		"Accept":        {MediaJSON},
+	})
+	assert.Equal(http.Header{
+		"Authorization": {Redacted},
+		"Cookie":        {Redacted},
+		"X-Api-Key":     {Redacted},
+		"Accept":        {MediaJSON},
+	}, header)
+
+	body := sanitizeBody(MediaJSON, []byte(`{"email":"a@zebra","password":"secret","key":"k",`+
+		`"users":[{"authKey":"abc","name":"a"}]}`))
+	assert.JSONEq(`{"email":"a@zebra","password":"REDACTED","key":"k",`+
+		`"users":[{"authKey":"REDACTED","name":"a"}]}`, body)
+
+	assert.Equal("", sanitizeBody(MediaJSON, nil))
+	assert.Equal("email: a@zebra\npassword: REDACTED\n",
+		sanitizeBody(MediaYAML, []byte("email: a@zebra\npassword: secret\n")))
+	assert.Equal("<21 bytes of application/yaml>", sanitizeBody(MediaYAML, []byte("password: secret\n- a\n")))
+	assert.Equal("<10 bytes of text/plain; charset=utf-8>",
+		sanitizeBody("text/plain; charset=utf-8", []byte("token: t1\n")))
+	assert.Equal("<3 bytes>", sanitizeBody("", []byte("abc")))
+	assert.Equal("<3 bytes of image/png>", sanitizeBody("image/png", []byte{1, 2, 3}))
+	assert.Len(sanitizeBody(MediaJSON, []byte(`"`+strings.Repeat("a", MaxRecordedBody+10)+`"`)), MaxRecordedBody+3)
+}
+
+func TestRecording(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+
+	withContext := func(req *http.Request, admin bool) *http.Request {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "user@zebra", admin))
+
+		return req.WithContext(ctx)
+	}
+
+	handler := recordAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Set-Cookie", "jwt=abc")
+		writeJSON(req.Context(), res, map[string]string{"token": "abc", "name": "lab"})
+	}))
+
+	call := func(admin bool, record bool) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/resources?x=1", strings.NewReader(`{"password":"p"}`))
+		req.Header.Set("Content-Type", MediaJSON)
+
+		if record {
+			req.Header.Set(RecordHeader, "1")
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withContext(req, admin))
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Contains(rr.Body.String(), `"token":"abc"`)
+	}
+
+	status := func(admin bool) (int, RecordingStatus) {
+		rr := httptest.NewRecorder()
+		handleRecordings()(rr, withContext(httptest.NewRequest(http.MethodGet, "/admin/recording", nil), admin), nil)
+
+		s := RecordingStatus{Recording: false, Until: nil, Exchanges: nil}
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), &s))
+		}
+
+		return rr.Code, s
+	}
+
+	start := func(admin bool, body string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/recording", strings.NewReader(body))
+		handleStartRecording()(rr, withContext(req, admin), nil)
+
+		return rr.Code
+	}
+
+	code, _ := status(false)
+	assert.Equal(http.StatusForbidden, code)
+	assert.Equal(http.StatusForbidden, start(false, ""))
+
+	// Nothing is recorded unless asked for by an admin
+	call(false, false)
+	call(false, true)
+	call(true, false)
+
+	code, s := status(true)
+	assert.Equal(http.StatusOK, code)
+	assert.False(s.Recording)
+	assert.Empty(s.Exchanges)
+
+	call(true, true)
+
+	_, s = status(true)
+	assert.Len(s.Exchanges, 1)
+
+	e := s.Exchanges[0]
+	assert.Equal(uint64(1), e.ID)
+	assert.Equal("user@zebra", e.User)
+	assert.Equal(http.MethodPost, e.Method)
+	assert.Equal("/api/v1/resources?x=1", e.URL)
+	assert.Equal(http.StatusOK, e.Status)
+	assert.JSONEq(`{"password":"REDACTED"}`, e.RequestBody)
+	assert.JSONEq(`{"token":"REDACTED","name":"lab"}`, e.ResponseBody)
+	assert.Equal([]string{Redacted}, e.ResponseHeader["Set-Cookie"])
+
+	// A window records the requests of all users
+	assert.Equal(http.StatusBadRequest, start(true, `{"duration":"2h"}`))
+	assert.Equal(http.StatusBadRequest, start(true, `{"duration":"soon"}`))
+	assert.Equal(http.StatusOK, start(true, `{"duration":"1m"}`))
+
+	call(false, false)
+
+	_, s = status(true)
+	assert.True(s.Recording)
+	assert.NotNil(s.Until)
+	assert.Len(s.Exchanges, 2)
+
+	rr := httptest.NewRecorder()
+	handleStopRecording()(rr, withContext(httptest.NewRequest(http.MethodDelete, "/admin/recording", nil), true), nil)
+	assert.Equal(http.StatusNoContent, rr.Code)
+
+	call(false, false)
+
+	_, s = status(true)
+	assert.False(s.Recording)
+	assert.Empty(s.Exchanges)
+
+	assert.Equal(http.StatusOK, start(true, ""))
+
+	_, s = status(true)
+	assert.True(s.Recording)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+)
+
+// DeleteConfig sets the delete policies of the references between resource
+// types, such as {"default": "orphan", "types": {"Rack": {"Server": "block",
+// "*": "cascade"}}}: a rack is not deleted while servers are in it, and the
+// other resources in it are deleted with it. Without a policy, the resources
+// referring to a deleted one are orphaned.
+type DeleteConfig struct {
+	Default string                       `json:"default,omitempty"`
+	Types   map[string]map[string]string `json:"types,omitempty"`
+}
+
+// newDeletePolicy returns the delete policy of the configuration.
+func newDeletePolicy(cfg *DeleteConfig) (*zebra.DeletePolicy, error) {
+	policy := zebra.NewDeletePolicy()
+
+	if cfg.Default != "" {
+		policy.Default = cfg.Default
+	}
+
+	for t, referrers := range cfg.Types {
+		policy.Types[t] = referrers
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// deletePlanner is implemented by stores that plan deletes under the delete
+// policy of the references between resources.
+type deletePlanner interface {
+	PlanDelete(res zebra.Resource, gone []string) (*store.DeletePlan, error)
+}
+
+// planDelete returns the plan of deleting the resource, the resources of the
+// gone IDs taken as deleted. Stores without delete policies delete the
+// resource alone.
+func (api *ResourceAPI) planDelete(res zebra.Resource, gone []string) (*store.DeletePlan, error) {
+	if planner, ok := api.Store.(deletePlanner); ok {
+		return planner.PlanDelete(res, gone)
+	}
+
+	return &store.DeletePlan{
+		Resource: res,
+		Cascaded: []zebra.Resource{},
+		Orphaned: []zebra.Resource{},
+		Policies: map[string]string{},
+		Blocked:  []string{},
+	}, nil
+}
+
+// planDeletes plans the deletes of the resources of the request in delete
+// order, each taking the resources planned before it as deleted. The plans
+// of the stored resources are returned in that order, those blocked by the
+// delete policy with the resources that blocked them. Resources that are not
+// stored, or that are deleted with one planned before them, have no plan.
+func (api *ResourceAPI) planDeletes(resMap *zebra.ResourceMap) ([]*store.DeletePlan, error) {
+	plans := []*store.DeletePlan{}
+	gone := []string{}
+
+	for _, r := range deleteOrder(api.Store, resMap) {
+		stored := findResource(api.Store, r.GetID())
+		if stored == nil || zebra.IsIn(r.GetID(), gone) {
+			continue
+		}
+
+		plan, err := api.planDelete(stored, gone)
+		if err != nil && !errors.Is(err, zebra.ErrReferenced) {
+			return nil, err
+		}
+
+		plans = append(plans, plan)
+
+		if err == nil {
+			gone = append(append(gone, stored.GetID()), planIDs(plan.Cascaded)...)
+		}
+	}
+
+	return plans, nil
+}
+
+// blockedDelete returns zebra.ErrReferenced with the resources blocking the
+// first of the plans that is blocked, nil if none is.
+func blockedDelete(plans []*store.DeletePlan) error {
+	for _, plan := range plans {
+		if len(plan.Blocked) != 0 {
+			return fmt.Errorf("%w: %s", zebra.ErrReferenced, strings.Join(plan.Blocked, ", "))
+		}
+	}
+
+	return nil
+}
+
+// plannedChanges returns the resources the plans, those not blocked, delete
+// with the requested ones and the orphans they write.
+func plannedChanges(factory zebra.ResourceFactory, plans []*store.DeletePlan) (*zebra.ResourceMap,
+	*zebra.ResourceMap,
+) {
+	cascaded := zebra.NewResourceMap(factory)
+	orphaned := zebra.NewResourceMap(factory)
+
+	for _, plan := range plans {
+		if len(plan.Blocked) != 0 {
+			continue
+		}
+
+		for _, r := range plan.Cascaded {
+			cascaded.Add(r, r.GetType())
+		}
+
+		for _, r := range plan.Orphaned {
+			orphaned.Add(r, r.GetType())
+		}
+	}
+
+	return cascaded, orphaned
+}
+
+// plannedDeletes returns the resources the plans, those not blocked, delete.
+func plannedDeletes(factory zebra.ResourceFactory, plans []*store.DeletePlan) *zebra.ResourceMap {
+	deleted, _ := plannedChanges(factory, plans)
+
+	for _, plan := range plans {
+		if len(plan.Blocked) == 0 {
+			deleted.Add(plan.Resource, plan.Resource.GetType())
+		}
+	}
+
+	return deleted
+}
+
+// guardPlans checks the resources the plans delete or orphan besides the
+// requested ones as if they were requested too: against the privileges of
+// the user and the protection of the stored resources. It writes the error
+// response and returns false if the delete may not go ahead. Handlers are
+// only reached through the auth adapter, without claims in the context the
+// user is trusted.
+func (api *ResourceAPI) guardPlans(res http.ResponseWriter, req *http.Request, plans []*store.DeletePlan) bool {
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+	cascaded, orphaned := plannedChanges(api.factory, plans)
+
+	if claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims); ok {
+		denials := mutationDenials(claims, api.Store, http.MethodDelete, cascaded)
+		denials = append(denials, mutationDenials(claims, api.Store, http.MethodPost, orphaned)...)
+
+		if len(denials) != 0 {
+			log.Info("resources referring to deleted ones not authorized", "user", claims.Email, "denied", len(denials))
+			writeJSONCode(ctx, res, http.StatusForbidden, denials)
+
+			return false
+		}
+	}
+
+	return api.guardProtected(res, req, http.MethodDelete, cascaded) &&
+		api.guardProtected(res, req, http.MethodPost, orphaned)
+}
+
+// carryOut deletes the resources of the plan: the resources cascaded first,
+// children before their parents, then the resource, and the orphaned ones
+// are updated last. Each change is recorded in the audit log as it is made.
+func (api *ResourceAPI) carryOut(ctx context.Context, plan *store.DeletePlan) error {
+	for _, r := range plan.Cascaded {
+		if err := api.delete(ctx, r); err != nil {
+			return err
+		}
+
+		api.recordAudit(ctx, "resource.delete", r.GetID(), r.GetType())
+	}
+
+	if err := api.delete(ctx, plan.Resource); err != nil {
+		return err
+	}
+
+	api.recordAudit(ctx, "resource.delete", plan.Resource.GetID(), plan.Resource.GetType())
+
+	for _, r := range plan.Orphaned {
+		if err := api.create(ctx, r); err != nil {
+			return err
+		}
+
+		api.recordAudit(ctx, "resource.orphan", r.GetID(), r.GetLabels()[zebra.OrphanLabel])
+	}
+
+	return nil
+}
+
+// deleteOrder returns the resources of the request in the order they are
+// deleted in, those referring to others before them, as the stored resources
+// refer to each other. Resources referring to each other in a cycle keep the
+// order of the request.
+func deleteOrder(s zebra.Store, resMap *zebra.ResourceMap) []zebra.Resource {
+	requested := []zebra.Resource{}
+	byID := map[string]zebra.Resource{}
+
+	_ = applyFunc(resMap, func(r zebra.Resource) error {
+		requested = append(requested, r)
+		byID[r.GetID()] = r
+
+		return nil
+	})
+
+	stored := make([]zebra.Resource, 0, len(requested))
+
+	for _, r := range requested {
+		if found := findResource(s, r.GetID()); found != nil {
+			stored = append(stored, found)
+		} else {
+			stored = append(stored, r)
+		}
+	}
+
+	sorted, err := zebra.SortByReferences(stored)
+	if err != nil {
+		return requested
+	}
+
+	ordered := make([]zebra.Resource, 0, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		ordered = append(ordered, byID[sorted[i].GetID()])
+	}
+
+	return ordered
+}
+
+// planIDs returns the IDs of the resources of a plan.
+func planIDs(resources []zebra.Resource) []string {
+	ids := make([]string, 0, len(resources))
+	for _, r := range resources {
+		ids = append(ids, r.GetID())
+	}
+
+	return ids
+}
@@ -0,0 +1,228 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDeletePolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	policy, err := newDeletePolicy(&DeleteConfig{Default: "", Types: nil})
+	assert.Nil(err)
+	assert.Equal(zebra.DeleteOrphan, policy.Default)
+
+	policy, err = newDeletePolicy(&DeleteConfig{
+		Default: zebra.DeleteBlock,
+		Types:   map[string]map[string]string{"Rack": {"Server": zebra.DeleteCascade}},
+	})
+	assert.Nil(err)
+	assert.Equal(zebra.DeleteCascade, policy.Policy("Rack", "Server"))
+	assert.Equal(zebra.DeleteBlock, policy.Policy("Rack", "Switch"))
+
+	_, err = newDeletePolicy(&DeleteConfig{Default: "keep", Types: nil})
+	assert.ErrorIs(err, zebra.ErrDeletePolicy)
+
+	_, err = newDeletePolicy(&DeleteConfig{Default: "", Types: map[string]map[string]string{"Rack": {"*": "keep"}}})
+	assert.ErrorIs(err, zebra.ErrDeletePolicy)
+}
+
+func TestDeletePolicies(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	policy, err := newDeletePolicy(&DeleteConfig{
+		Default: "",
+		Types:   map[string]map[string]string{"Rack": {"Server": zebra.DeleteBlock, "*": zebra.DeleteCascade}},
+	})
+	assert.Nil(err)
+
+	api.deletes = policy
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	labels := func(parent string) zebra.Labels {
+		return zebra.Labels{"system.group": "labs", zebra.ParentLabel: parent}
+	}
+
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	pool := network.NewVlanPool(1, 10, labels(lab.ID))
+	rack := dc.NewRack("rack", "A01", labels(lab.ID))
+	srv := compute.NewServer([]string{"SN1", "model", "srv"}, net.IPv4(10, 0, 0, 1), labels(rack.ID))
+	srv.Credentials.ID = srv.ID
+	sw := network.NewSwitch([]string{"SN2", "model", "sw"}, 48, net.IPv4(10, 0, 0, 2), labels(rack.ID))
+	sw.Credentials.ID = sw.ID
+
+	for _, res := range []zebra.Resource{lab, pool, rack, srv, sw} {
+		assert.Nil(api.Store.Create(res))
+	}
+
+	body := func(resources ...zebra.Resource) string {
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+		for _, res := range resources {
+			resMap.Add(res, res.GetType())
+		}
+
+		data, err := json.Marshal(resMap)
+		assert.Nil(err)
+
+		return string(data)
+	}
+
+	deleteV2 := func(url string, resources ...zebra.Resource) (int, *DeleteResponse) {
+		rr := httptest.NewRecorder()
+		handleDeleteV2()(rr, createRequest(assert, http.MethodDelete, url, body(resources...), api), nil)
+
+		resp := new(DeleteResponse)
+		assert.Nil(json.Unmarshal(rr.Body.Bytes(), resp))
+
+		return rr.Code, resp
+	}
+
+	// The server blocks deleting the rack
+	code, resp := deleteV2("/api/v2/resources", rack)
+	assert.Equal(http.StatusConflict, code)
+	assert.Equal([]string{srv.ID}, resp.Blocked[rack.ID])
+	assert.Empty(resp.Deleted)
+
+	rr := httptest.NewRecorder()
+	handleDelete()(rr, createRequest(assert, http.MethodDelete, "/api/v1/resources", body(rack), api), nil)
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.NotNil(findResource(api.Store, rack.ID))
+
+	// Deleted along with the server, the rack cascades to the switch
+	code, resp = deleteV2("/api/v2/resources?dryRun=true", rack, srv)
+	assert.Equal(http.StatusOK, code)
+	assert.ElementsMatch([]string{rack.ID, srv.ID}, resp.Deleted)
+	assert.Equal([]string{sw.ID}, resp.Cascaded)
+	assert.NotNil(findResource(api.Store, sw.ID))
+
+	code, resp = deleteV2("/api/v2/resources", rack, srv)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{srv.ID, rack.ID}, resp.Deleted)
+	assert.Equal([]string{sw.ID}, resp.Cascaded)
+	assert.Equal(zebra.DeleteCascade, resp.Policies[sw.ID])
+	assert.Nil(findResource(api.Store, sw.ID))
+
+	// The pool outlives the lab, orphaned
+	code, resp = deleteV2("/api/v2/resources", lab)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{pool.ID}, resp.Orphaned)
+	assert.Equal(zebra.DeleteOrphan, resp.Policies[pool.ID])
+
+	orphan := findResource(api.Store, pool.ID)
+	assert.NotNil(orphan)
+	assert.Equal(lab.ID, orphan.GetLabels()[zebra.OrphanLabel])
+	assert.False(orphan.GetLabels().HasKey(zebra.ParentLabel))
+}
+
+func TestDeletePlanGuards(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	policy, err := newDeletePolicy(&DeleteConfig{
+		Default: "",
+		Types:   map[string]map[string]string{"Rack": {"Server": zebra.DeleteBlock, "*": zebra.DeleteCascade}},
+	})
+	assert.Nil(err)
+
+	api.deletes = policy
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	labels := func(parent string) zebra.Labels {
+		return zebra.Labels{"system.group": "labs", zebra.ParentLabel: parent}
+	}
+
+	core := dc.NewRack("core", "A01", zebra.Labels{"system.group": "labs"})
+	edge := dc.NewRack("edge", "A02", zebra.Labels{"system.group": "labs"})
+	full := dc.NewRack("full", "A03", zebra.Labels{"system.group": "labs"})
+	coreSw := network.NewSwitch([]string{"SN1", "model", "core"}, 48, net.IPv4(10, 0, 0, 1), labels(core.ID))
+	coreSw.Credentials.ID = coreSw.ID
+	coreSw.Labels.Add(ProtectedLabel, ProtectedValue)
+	edgeSw := network.NewSwitch([]string{"SN2", "model", "edge"}, 48, net.IPv4(10, 0, 0, 2), labels(edge.ID))
+	edgeSw.Credentials.ID = edgeSw.ID
+	srv := compute.NewServer([]string{"SN3", "model", "srv"}, net.IPv4(10, 0, 0, 3), labels(full.ID))
+	srv.Credentials.ID = srv.ID
+
+	for _, res := range []zebra.Resource{core, edge, full, coreSw, edgeSw, srv} {
+		assert.Nil(api.Store.Create(res))
+	}
+
+	racks, err := auth.NewPriv("^Rack$", true, true, true, true)
+	assert.Nil(err)
+
+	rackAdmin := auth.NewClaims("zebra", "racks", &auth.Role{Name: "racks", Privileges: []*auth.Priv{racks}},
+		"racks@zebra")
+
+	del := func(claims *auth.Claims, url string, resources ...zebra.Resource) *httptest.ResponseRecorder {
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+		for _, res := range resources {
+			resMap.Add(res, res.GetType())
+		}
+
+		data, err := json.Marshal(resMap)
+		assert.Nil(err)
+
+		req := createRequest(assert, http.MethodDelete, url, string(data), api)
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), ClaimsCtxKey, claims))
+		}
+
+		rr := httptest.NewRecorder()
+		handleDelete()(rr, req, nil)
+
+		return rr
+	}
+
+	// The protected switch cascaded from its rack must be forced
+	assert.Equal(http.StatusConflict, del(nil, "/api/v1/resources", core).Code)
+	assert.Equal(http.StatusConflict, del(nil, "/api/v1/resources?dryRun=true", core).Code)
+	assert.NotNil(findResource(api.Store, coreSw.ID))
+
+	// Cascaded deletes need the privileges of the user
+	rr := del(rackAdmin, "/api/v1/resources", edge)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	denials := []Denial{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &denials))
+	assert.Equal([]Denial{{ID: edgeSw.ID, Type: "Switch", Action: auth.ActionDelete, Reason: ""}}, denials)
+	assert.NotNil(findResource(api.Store, edge.ID))
+
+	// A dry run is blocked like the delete, which deletes none of the request
+	assert.Equal(http.StatusConflict, del(nil, "/api/v1/resources?dryRun=true", edge, full).Code)
+	assert.Equal(http.StatusConflict, del(nil, "/api/v1/resources", edge, full).Code)
+	assert.NotNil(findResource(api.Store, edge.ID))
+	assert.NotNil(findResource(api.Store, edgeSw.ID))
+
+	rr = del(nil, "/api/v1/resources?dryRun=true", edge)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), edgeSw.ID)
+
+	assert.Equal(http.StatusOK, del(nil, "/api/v1/resources", edge).Code)
+	assert.Nil(findResource(api.Store, edge.ID))
+	assert.Nil(findResource(api.Store, edgeSw.ID))
+
+	// Every delete is audited
+	for _, id := range []string{edge.ID, edgeSw.ID} {
+		entries := api.Audit.Query(id)
+		assert.Equal(1, len(entries))
+		assert.Equal("resource.delete", entries[0].Action)
+	}
+
+	assert.Equal(http.StatusOK, del(nil, "/api/v1/resources?force=true", core).Code)
+	assert.Nil(findResource(api.Store, coreSw.ID))
+}
@@ -1,7 +1,8 @@
-package main
+package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/project-safari/zebra/auth"
@@ -18,10 +19,15 @@ func refreshAdapter() web.Adapter {
 				return
 			}
 
+			if refuseImpersonated(res, req) {
+				return
+			}
+
 			ctx := req.Context()
 			log := logr.FromContextOrDiscard(ctx)
+			api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
 			jwtClaims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
-			if !ok {
+			if !ok || !apiOK {
 				log.Error(nil, "claims not in context")
 				res.WriteHeader(http.StatusInternalServerError)
 
@@ -36,8 +42,17 @@ func refreshAdapter() web.Adapter {
 				return
 			}
 
-			// Create a new token and cookie
+			// Create a new token and cookie of the same session
 			claims := auth.NewClaims("zebra", jwtClaims.Subject, jwtClaims.Role, jwtClaims.Email)
+			claims.Id = jwtClaims.Id
+
+			if err := api.sessions.refresh(claims, time.Now()); err != nil {
+				log.Error(err, "session not refreshed", "user", jwtClaims.Email)
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
 			respondWithClaims(ctx, res, claims, authKey)
 
 			log.Info("refresh succeeded", "user", jwtClaims.Subject)
@@ -1,4 +1,4 @@
-package main //nolint:testpackage
+package server //nolint:testpackage
 
 import (
 	"context"
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
 	"github.com/stretchr/testify/assert"
 	"gojini.dev/web"
 )
@@ -45,6 +46,7 @@ func TestRefresh(t *testing.T) {
 func makeRefreshRequest(assert *assert.Assertions, claims *auth.Claims, authKey string) *http.Request {
 	ctx := context.WithValue(context.Background(), ClaimsCtxKey, claims)
 	ctx = context.WithValue(ctx, AuthCtxKey, authKey)
+	ctx = context.WithValue(ctx, ResourcesCtxKey, NewResourceAPI(store.DefaultFactory()))
 
 	req, err := http.NewRequestWithContext(ctx, "POST", "/refresh", nil)
 	assert.Nil(err)
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+)
+
+// SystemLabelPrefix is the prefix of the label keys the server relies on,
+// such as the namespace label, which can not be renamed.
+const SystemLabelPrefix = "system."
+
+var (
+	ErrRenameRequest  = errors.New("label rename requires distinct from and to keys")
+	ErrRenameSystem   = errors.New("system labels can not be renamed")
+	ErrRenameConflict = errors.New("resources already have the new label key with another value")
+	ErrRenameProtect  = errors.New("protected resources are only relabeled if forced")
+	ErrRelabelAdmin   = errors.New("relabeling requires admin privileges")
+	ErrAliasNotFound  = errors.New("label alias not found")
+)
+
+// RenameRequest asks to rename the label key From to To on every resource.
+// With Alias set, queries for From are answered as queries for To from then
+// on, so that clients can move to the new key at their own pace.
+type RenameRequest struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Alias bool   `json:"alias,omitempty"`
+}
+
+func (r *RenameRequest) Validate() error {
+	if r.From == "" || r.To == "" || r.From == r.To {
+		return ErrRenameRequest
+	}
+
+	if strings.HasPrefix(r.From, SystemLabelPrefix) || strings.HasPrefix(r.To, SystemLabelPrefix) {
+		return ErrRenameSystem
+	}
+
+	return nil
+}
+
+// RenameResult lists the resources whose label was renamed, or the ones in
+// conflict, or protected, if the rename was refused.
+type RenameResult struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Alias     bool     `json:"alias"`
+	Renamed   []string `json:"renamed"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// labelAliases maps old label keys to the keys they were renamed to. If a
+// path is given, the aliases are written to that file on every change.
+type labelAliases struct {
+	lock    sync.RWMutex
+	path    string
+	aliases map[string]string
+}
+
+func newLabelAliases(path string) *labelAliases {
+	return &labelAliases{
+		lock:    sync.RWMutex{},
+		path:    path,
+		aliases: make(map[string]string),
+	}
+}
+
+// load reads the aliases from the backing file, if any.
+func (a *labelAliases) load() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	aliases := make(map[string]string)
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return err
+	}
+
+	a.aliases = aliases
+
+	return nil
+}
+
+// resolve returns the key queries for the given key are answered with.
+func (a *labelAliases) resolve(key string) string {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if to, ok := a.aliases[key]; ok {
+		return to
+	}
+
+	return key
+}
+
+// add makes from an alias of to. Aliases of from become aliases of to, and
+// to stops being an alias as it is a key in use again.
+func (a *labelAliases) add(from, to string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for k, v := range a.aliases {
+		if v == from {
+			a.aliases[k] = to
+		}
+	}
+
+	delete(a.aliases, to)
+	a.aliases[from] = to
+
+	return a.save()
+}
+
+// remove drops the alias of the key, if there is one.
+func (a *labelAliases) remove(from string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.aliases[from]; !ok {
+		return ErrAliasNotFound
+	}
+
+	delete(a.aliases, from)
+
+	return a.save()
+}
+
+// drop removes the alias of a key that is in use again.
+func (a *labelAliases) drop(key string) error {
+	if err := a.remove(key); err != nil && !errors.Is(err, ErrAliasNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (a *labelAliases) list() map[string]string {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	aliases := make(map[string]string, len(a.aliases))
+	for k, v := range a.aliases {
+		aliases[k] = v
+	}
+
+	return aliases
+}
+
+// save writes the aliases to the backing file. Must be called with the lock
+// held.
+func (a *labelAliases) save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(a.aliases)
+	if err != nil {
+		return err
+	}
+
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.path)
+}
+
+// resolveAliases returns the label queries with aliased keys replaced by the
+// keys they were renamed to.
+func (api *ResourceAPI) resolveAliases(queries []zebra.Query) []zebra.Query {
+	resolved := make([]zebra.Query, 0, len(queries))
+
+	for _, q := range queries {
+		q.Key = api.aliases.resolve(q.Key)
+		resolved = append(resolved, q)
+	}
+
+	return resolved
+}
+
+// renameLabel renames the label key on every resource that has it. Either
+// all resources are renamed or, if one fails, the renamed ones are restored.
+// Resources with both keys keep the new one if both values agree, if any
+// of them disagree nothing is renamed and their IDs are returned. The same
+// goes for protected resources, unless the rename is forced.
+func (api *ResourceAPI) renameLabel(ctx context.Context, from, to string, force bool) ([]string, []string, error) {
+	originals := []zebra.Resource{}
+	conflicts := []string{}
+	protected := []string{}
+
+	_ = applyFunc(api.Store.Query(), func(r zebra.Resource) error {
+		labels := r.GetLabels()
+		if !labels.HasKey(from) {
+			return nil
+		}
+
+		if labels.HasKey(to) && labels[to] != labels[from] {
+			conflicts = append(conflicts, r.GetID())
+		}
+
+		if isProtected(r) {
+			protected = append(protected, r.GetID())
+		}
+
+		originals = append(originals, r)
+
+		return nil
+	})
+
+	if len(conflicts) != 0 {
+		sort.Strings(conflicts)
+
+		return nil, conflicts, ErrRenameConflict
+	}
+
+	sort.Strings(protected)
+
+	if len(protected) != 0 && !force {
+		return nil, protected, ErrRenameProtect
+	}
+
+	// Resources only hand out copies of their labels, the renamed resources
+	// are decoded from their JSON with the key renamed
+	decoder := zebra.NewDecoder(api.factory)
+	renamed := make([]zebra.Resource, 0, len(originals))
+
+	for _, r := range originals {
+		c, err := relabeled(decoder, r, from, to)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		renamed = append(renamed, c)
+	}
+
+	ids := make([]string, 0, len(renamed))
+
+	for i, r := range renamed {
+		if err := api.create(ctx, r); err != nil {
+			for _, orig := range originals[:i] {
+				_ = api.create(ctx, orig)
+			}
+
+			return nil, nil, err
+		}
+
+		ids = append(ids, r.GetID())
+	}
+
+	for _, id := range protected {
+		api.recordAudit(ctx, "resource.force", id, "label.rename")
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil, nil
+}
+
+// relabeled returns a copy of the resource with the label key renamed.
+func relabeled(decoder *zebra.Decoder, res zebra.Resource, from, to string) (zebra.Resource, error) {
+	fields, err := resourceFields(res)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := res.GetLabels()
+	labels[to] = labels[from]
+	delete(labels, from)
+
+	if fields["labels"], err = json.Marshal(labels); err != nil {
+		return nil, err
+	}
+
+	return decodeFields(decoder, fields)
+}
+
+// resourceFields returns the top level JSON fields of the resource, changes
+// to resources are made to their fields and decoded into a copy.
+func resourceFields(res zebra.Resource) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func decodeFields(decoder *zebra.Decoder, fields map[string]json.RawMessage) (zebra.Resource, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.Decode(data)
+}
+
+// handleRenameLabel renames a label key across the store and optionally
+// keeps the old key as an alias of the new one.
+func handleRenameLabel() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := relabelContext(res, req)
+		if !ok {
+			return
+		}
+
+		rr := new(RenameRequest)
+		if err := readJSON(ctx, req, rr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := rr.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		result := RenameResult{From: rr.From, To: rr.To, Alias: rr.Alias, Renamed: nil, Conflicts: nil}
+
+		renamed, conflicts, err := api.renameLabel(ctx, rr.From, rr.To, isForced(req))
+		if errors.Is(err, ErrRenameConflict) || errors.Is(err, ErrRenameProtect) {
+			result.Renamed = []string{}
+			result.Conflicts = conflicts
+
+			res.WriteHeader(http.StatusConflict)
+			writeJSON(ctx, res, result)
+
+			return
+		} else if err != nil {
+			log.Error(err, "label could not be renamed", "from", rr.From, "to", rr.To)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		result.Renamed = renamed
+
+		if err := api.updateAliases(rr); err != nil {
+			log.Error(err, "label alias could not be saved", "from", rr.From, "to", rr.To)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "label.rename", "", fmt.Sprintf("%s -> %s (%d resources)", rr.From, rr.To, len(renamed)))
+		log.Info("label renamed", "from", rr.From, "to", rr.To, "resources", len(renamed), "user", claims.Email)
+
+		writeJSON(ctx, res, result)
+	}
+}
+
+func (api *ResourceAPI) updateAliases(rr *RenameRequest) error {
+	if rr.Alias {
+		return api.aliases.add(rr.From, rr.To)
+	}
+
+	return api.aliases.drop(rr.To)
+}
+
+func handleLabelAliases() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.aliases.list())
+	}
+}
+
+// handleDeleteLabelAlias ends the transition of a renamed key, queries for
+// the old key no longer match the new one.
+func handleDeleteLabelAlias() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, _, ok := relabelContext(res, req)
+		if !ok {
+			return
+		}
+
+		key := params.ByName("key")
+
+		err := api.aliases.remove(key)
+
+		switch {
+		case errors.Is(err, ErrAliasNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+
+			return
+		case err != nil:
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "label.alias.delete", "", key)
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func relabelContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	if !claims.Write(AdminKey) {
+		http.Error(res, ErrRelabelAdmin.Error(), http.StatusForbidden)
+
+		return nil, nil, false
+	}
+
+	return api, claims, true
+}
@@ -0,0 +1,118 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func relabelRequest(h httprouter.Handle, api *ResourceAPI, claims *auth.Claims,
+	body string, key string,
+) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	rr := httptest.NewRecorder()
+
+	h(rr, httptest.NewRequest("POST", "/", bytes.NewBufferString(body)).WithContext(ctx),
+		httprouter.Params{{Key: "key", Value: key}})
+
+	return rr
+}
+
+func TestRenameLabel(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	prod := dc.NewLab("prod", zebra.Labels{"system.group": "labs", "env": "prod"})
+	dev := dc.NewLab("dev", zebra.Labels{"system.group": "labs", "env": "dev", "environment": "staging"})
+	other := dc.NewLab("other", zebra.Labels{"system.group": "labs", "color": "red"})
+
+	for _, lab := range []*dc.Lab{prod, dev, other} {
+		assert.Nil(api.Store.Create(lab))
+	}
+
+	for _, body := range []string{`{"from":"env"}`, `{"from":"env","to":"env"}`, `{"from":"system.group","to":"g"}`} {
+		assert.Equal(http.StatusBadRequest, relabelRequest(handleRenameLabel(), api, admin, body, "").Code)
+	}
+
+	body := `{"from":"env","to":"environment","alias":true}`
+	assert.Equal(http.StatusForbidden, relabelRequest(handleRenameLabel(), api, user, body, "").Code)
+
+	// Nothing is renamed while a resource would lose a value
+	rr := relabelRequest(handleRenameLabel(), api, admin, body, "")
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	result := RenameResult{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal([]string{dev.ID}, result.Conflicts)
+
+	resMap, err := api.Store.QueryLabel(zebra.Query{Key: "env", Op: zebra.MatchIn, Values: []string{"prod", "dev"}})
+	assert.Nil(err)
+	assert.Equal(2, len(resMap.Resources["Lab"].Resources))
+
+	fixed := dc.NewLab("dev", zebra.Labels{"system.group": "labs", "env": "dev", "environment": "dev"})
+	fixed.ID = dev.ID
+	assert.Nil(api.Store.Create(fixed))
+
+	latest := api.Events.Latest()
+
+	rr = relabelRequest(handleRenameLabel(), api, admin, body, "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.ElementsMatch([]string{prod.ID, dev.ID}, result.Renamed)
+	assert.Equal(latest+2, api.Events.Latest())
+	assert.Equal("label.rename", api.Audit.Entries()[0].Action)
+
+	// The index is updated and queries for the old key use the new one
+	envQuery := zebra.Query{Key: "env", Op: zebra.MatchEqual, Values: []string{"prod"}}
+
+	resMap, err = api.Store.QueryLabel(envQuery)
+	assert.Nil(err)
+	assert.Equal(0, count(resMap))
+
+	resMap = api.query(&QueryRequest{Labels: []zebra.Query{envQuery}})
+	assert.Equal(1, len(resMap.Resources["Lab"].Resources))
+	assert.Equal("prod", resMap.Resources["Lab"].Resources[0].GetLabels()["environment"])
+	assert.False(resMap.Resources["Lab"].Resources[0].GetLabels().HasKey("env"))
+
+	// The aliases survive a restart
+	restarted := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(restarted.Initialize(root))
+	assert.Equal(map[string]string{"env": "environment"}, restarted.aliases.list())
+
+	rr = relabelRequest(handleLabelAliases(), api, user, "", "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.JSONEq(`{"env":"environment"}`, rr.Body.String())
+
+	// Renaming again moves the alias along
+	body = `{"from":"environment","to":"stage","alias":true}`
+	assert.Equal(http.StatusOK, relabelRequest(handleRenameLabel(), api, admin, body, "").Code)
+	assert.Equal(map[string]string{"env": "stage", "environment": "stage"}, api.aliases.list())
+
+	assert.Equal(http.StatusForbidden, relabelRequest(handleDeleteLabelAlias(), api, user, "", "env").Code)
+	assert.Equal(http.StatusOK, relabelRequest(handleDeleteLabelAlias(), api, admin, "", "env").Code)
+	assert.Equal(http.StatusNotFound, relabelRequest(handleDeleteLabelAlias(), api, admin, "", "env").Code)
+
+	resMap = api.query(&QueryRequest{Labels: []zebra.Query{envQuery}})
+	assert.Equal(0, count(resMap))
+
+	entries := api.Audit.Entries()
+	assert.Equal("label.alias.delete", entries[len(entries)-1].Action)
+}
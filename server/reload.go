@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/rs/zerolog"
+	"gojini.dev/config"
+	"gojini.dev/web"
+)
+
+// AdminKey is the privilege key required for server administration.
+const AdminKey = "system.admin"
+
+// DefaultLogLevel is the log level if none is configured.
+const DefaultLogLevel = "debug"
+
+var (
+	ErrNoAuthKey    = errors.New("authKey missing in server configuration")
+	ErrNoConfigFile = errors.New("server has no configuration file to reload")
+)
+
+var configReloads = metrics.Default.Counter("zebra_config_reloads_total",
+	"Configuration reloads, by result.", "result")
+
+// LogConfig is the logging configuration of the server.
+type LogConfig struct {
+	Level string `json:"level"`
+}
+
+// RuntimeConfig is the part of the server configuration that can be changed
+// without a restart: the log level, the auth key, the request timeouts and
+// the translations of error messages.
+type RuntimeConfig struct {
+	LogLevel zerolog.Level
+	AuthKey  string
+	Timeouts *routeTimeouts
+	Messages *messageCatalog
+}
+
+// loadRuntimeConfig reads and validates the runtime configuration.
+func loadRuntimeConfig(cfgStore *config.Store) (*RuntimeConfig, error) {
+	logCfg := &LogConfig{Level: DefaultLogLevel}
+	if e := cfgStore.Get("log", logCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		return nil, e
+	}
+
+	level, err := zerolog.ParseLevel(logCfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	// The auth key may be kept in a secret backend instead
+	secretsCfg := &SecretsConfig{AuthKey: nil, CredentialsKey: nil, RetiredCredentialsKeys: nil, RefreshInterval: ""}
+	if e := cfgStore.Get("secrets", secretsCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		return nil, e
+	}
+
+	authKey := ""
+	if e := cfgStore.Get("authKey", &authKey); (e != nil || authKey == "") && secretsCfg.AuthKey == nil {
+		return nil, ErrNoAuthKey
+	}
+
+	timeoutCfg := new(Timeouts)
+	if e := cfgStore.Get("timeouts", timeoutCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		return nil, e
+	}
+
+	timeouts, err := newRouteTimeouts(timeoutCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	localeCfg := &LocaleConfig{Dir: "", Messages: nil}
+	if e := cfgStore.Get("locales", localeCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		return nil, e
+	}
+
+	messages, err := newMessageCatalog(localeCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RuntimeConfig{LogLevel: level, AuthKey: authKey, Timeouts: timeouts, Messages: messages}, nil
+}
+
+// Reloader holds the runtime configuration. A reload reads the config file
+// again and swaps in the new configuration only if all of it is valid, so
+// requests always see a complete configuration, old or new.
+type Reloader struct {
+	lock    sync.Mutex
+	file    string
+	current atomic.Value
+}
+
+// NewReloader returns a reloader with the runtime configuration read from
+// the config file.
+func NewReloader(ctx context.Context, cfgFile string) (*Reloader, error) {
+	r := &Reloader{lock: sync.Mutex{}, file: cfgFile, current: atomic.Value{}}
+
+	if err := r.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// newStaticReloader returns a reloader of the runtime configuration of an
+// embedded server, which has no configuration file to reload.
+func newStaticReloader(cfg *RuntimeConfig) *Reloader {
+	r := &Reloader{lock: sync.Mutex{}, file: "", current: atomic.Value{}}
+	r.current.Store(cfg)
+
+	return r
+}
+
+// Config returns the current runtime configuration.
+func (r *Reloader) Config() *RuntimeConfig {
+	cfg, _ := r.current.Load().(*RuntimeConfig)
+
+	return cfg
+}
+
+// Reload reads the config file and applies its runtime configuration.
+func (r *Reloader) Reload(ctx context.Context) error {
+	if r.file == "" {
+		return ErrNoConfigFile
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cfg, err := r.load(ctx)
+	if err != nil {
+		configReloads.Inc("error")
+
+		return fmt.Errorf("reload %s: %w", r.file, err)
+	}
+
+	zerolog.SetGlobalLevel(cfg.LogLevel)
+	r.current.Store(cfg)
+	configReloads.Inc("ok")
+
+	return nil
+}
+
+func (r *Reloader) load(ctx context.Context) (*RuntimeConfig, error) {
+	cfgStore := config.New()
+	if err := cfgStore.LoadFromFile(ctx, r.file); err != nil {
+		return nil, err
+	}
+
+	return loadRuntimeConfig(cfgStore)
+}
+
+func (r *Reloader) timeout(req *http.Request) time.Duration {
+	return r.Config().Timeouts.timeout(req)
+}
+
+// watchSignals reloads the configuration on SIGHUP until the context is done.
+func (r *Reloader) watchSignals(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx)
+	hup := make(chan os.Signal, 1)
+
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := r.Reload(ctx); err != nil {
+					log.Error(err, "configuration reload failed, keeping the current configuration")
+				} else {
+					log.Info("configuration reloaded")
+				}
+			}
+		}
+	}()
+}
+
+// runtimeAdapter puts the current runtime configuration in the request
+// context, replacing the auth key set by setup with the reloaded one, or the
+// current one of the secret backend.
+func runtimeAdapter(reloader *Reloader) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			authKey := reloader.Config().AuthKey
+
+			// Keys of a secret backend are rotated by refreshing them
+			if api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI); ok && api.authKeys != nil {
+				authKey = api.authKeys.Current()
+			}
+
+			ctx = context.WithValue(ctx, AuthCtxKey, authKey)
+			ctx = context.WithValue(ctx, ReloaderCtxKey, reloader)
+
+			callNext(nextHandler, res, req.Clone(ctx))
+		})
+	}
+}
+
+// handleReload reloads the server configuration, admins only.
+func handleReload() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		reloader, ok := ctx.Value(ReloaderCtxKey).(*Reloader)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok || !claims.Write(AdminKey) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		if err := reloader.Reload(ctx); err != nil {
+			log.Error(err, "configuration reload failed, keeping the current configuration")
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		log.Info("configuration reloaded", "user", claims.Email)
+
+		cfg := reloader.Config()
+		writeJSON(ctx, res, map[string]interface{}{
+			"logLevel":       cfg.LogLevel.String(),
+			"defaultTimeout": cfg.Timeouts.def.String(),
+		})
+	}
+}
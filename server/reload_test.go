@@ -0,0 +1,206 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"gojini.dev/config"
+)
+
+const testRuntimeCfg = `{
+	"authKey": "abracadabra",
+	"log": {"level": "info"},
+	"timeouts": {"default": "10s", "routes": {"/api/v1/types": "1s"}}
+}`
+
+func writeConfig(assert *assert.Assertions, file string, cfg string) {
+	assert.Nil(os.WriteFile(file, []byte(cfg), ReadWriteOnly))
+}
+
+func TestLoadRuntimeConfig(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	load := func(cfg string) (*RuntimeConfig, error) {
+		cfgStore := config.New()
+		assert.Nil(cfgStore.LoadFromStr(context.Background(), cfg))
+
+		return loadRuntimeConfig(cfgStore)
+	}
+
+	cfg, err := load(testRuntimeCfg)
+	assert.Nil(err)
+	assert.Equal(zerolog.InfoLevel, cfg.LogLevel)
+	assert.Equal("abracadabra", cfg.AuthKey)
+	assert.Equal(10*time.Second, cfg.Timeouts.def)
+
+	cfg, err = load(`{"authKey": "abracadabra"}`)
+	assert.Nil(err)
+	assert.Equal(zerolog.DebugLevel, cfg.LogLevel)
+	assert.Equal(DefaultRequestTimeout, cfg.Timeouts.def)
+
+	_, err = load(`{"authKey": "abracadabra", "log": {"level": "loud"}}`)
+	assert.NotNil(err)
+
+	_, err = load(`{"authKey": "", "log": {"level": "info"}}`)
+	assert.ErrorIs(err, ErrNoAuthKey)
+
+	_, err = load(`{"log": {"level": "info"}}`)
+	assert.ErrorIs(err, ErrNoAuthKey)
+
+	_, err = load(`{"authKey": "abracadabra", "timeouts": {"default": "soon"}}`)
+	assert.NotNil(err)
+
+	cfg, err = load(`{"authKey": "abracadabra", "locales": {"messages": {"de": {"forbidden": "verboten"}}}}`)
+	assert.Nil(err)
+
+	msg, _ := cfg.Messages.translate("de", "forbidden")
+	assert.Equal("verboten", msg)
+
+	_, err = load(`{"authKey": "abracadabra", "locales": {"messages": {"de, fr": {"forbidden": "verboten"}}}}`)
+	assert.ErrorIs(err, ErrLocale)
+}
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := "test_reloader.json"
+	t.Cleanup(func() { os.Remove(file) })
+
+	_, err := NewReloader(context.Background(), file)
+	assert.NotNil(err)
+
+	writeConfig(assert, file, testRuntimeCfg)
+
+	reloader, err := NewReloader(context.Background(), file)
+	assert.Nil(err)
+	assert.Equal("abracadabra", reloader.Config().AuthKey)
+	assert.Equal(time.Second, reloader.timeout(httptest.NewRequest("GET", "/api/v1/types", nil)))
+
+	writeConfig(assert, file, `{"authKey": "opensesame", "log": {"level": "warn"}}`)
+	assert.Nil(reloader.Reload(context.Background()))
+	assert.Equal("opensesame", reloader.Config().AuthKey)
+	assert.Equal(zerolog.WarnLevel, reloader.Config().LogLevel)
+	assert.Equal(DefaultRequestTimeout, reloader.timeout(httptest.NewRequest("GET", "/api/v1/types", nil)))
+
+	// An invalid configuration is not applied
+	failed := configReloads.Value("error")
+
+	writeConfig(assert, file, `{"authKey": "sesame", "log": {"level": "loud"}}`)
+	assert.NotNil(reloader.Reload(context.Background()))
+	assert.Equal("opensesame", reloader.Config().AuthKey)
+	assert.Equal(failed+1, configReloads.Value("error"))
+}
+
+func TestReloadSignal(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := "test_reload_signal.json"
+	t.Cleanup(func() { os.Remove(file) })
+
+	writeConfig(assert, file, testRuntimeCfg)
+
+	reloader, err := NewReloader(context.Background(), file)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reloader.watchSignals(ctx)
+
+	writeConfig(assert, file, `{"authKey": "opensesame"}`)
+	assert.Nil(syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	assert.Eventually(func() bool {
+		return reloader.Config().AuthKey == "opensesame"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandleReload(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := "test_handle_reload.json"
+	t.Cleanup(func() { os.Remove(file) })
+
+	writeConfig(assert, file, testRuntimeCfg)
+
+	reloader, err := NewReloader(context.Background(), file)
+	assert.Nil(err)
+
+	adminPriv, err := auth.NewPriv("", true, true, true, true)
+	assert.Nil(err)
+
+	userPriv, err := auth.NewPriv("Lab", true, true, true, true)
+	assert.Nil(err)
+
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{adminPriv}}, "admin@zebra")
+	user := auth.NewClaims("zebra", "user", &auth.Role{Name: "user", Privileges: []*auth.Priv{userPriv}}, "user@zebra")
+
+	reload := func(claims *auth.Claims, r *Reloader) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ClaimsCtxKey, claims)
+		if r != nil {
+			ctx = context.WithValue(ctx, ReloaderCtxKey, r)
+		}
+
+		req := httptest.NewRequest("POST", "/admin/reload", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handleReload()(rr, req, httprouter.Params{})
+
+		return rr
+	}
+
+	assert.Equal(http.StatusInternalServerError, reload(admin, nil).Code)
+	assert.Equal(http.StatusForbidden, reload(user, reloader).Code)
+
+	writeConfig(assert, file, `{"authKey": "opensesame", "log": {"level": "error"}}`)
+
+	rr := reload(admin, reloader)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), `"logLevel":"error"`)
+	assert.Equal("opensesame", reloader.Config().AuthKey)
+
+	writeConfig(assert, file, `{"log": {"level": "error"}}`)
+
+	rr = reload(admin, reloader)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Contains(rr.Body.String(), ErrNoAuthKey.Error())
+}
+
+func TestRuntimeAdapter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := "test_runtime_adapter.json"
+	t.Cleanup(func() { os.Remove(file) })
+
+	writeConfig(assert, file, testRuntimeCfg)
+
+	reloader, err := NewReloader(context.Background(), file)
+	assert.Nil(err)
+
+	var authKey string
+
+	handler := runtimeAdapter(reloader)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		authKey, _ = req.Context().Value(AuthCtxKey).(string)
+		_, ok := req.Context().Value(ReloaderCtxKey).(*Reloader)
+		assert.True(ok)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal("abracadabra", authKey)
+
+	testForward(assert, runtimeAdapter(reloader))
+}
@@ -0,0 +1,571 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/history"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/scheduler"
+	"github.com/project-safari/zebra/xlsx"
+)
+
+// Report formats.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatJSON = "json"
+)
+
+// DefaultExpiringWithin is how far ahead the expiring leases report looks if
+// the request does not say, DefaultWarrantyWithin the expiring warranties
+// report.
+const (
+	DefaultExpiringWithin = 72 * time.Hour
+	DefaultWarrantyWithin = 90 * day
+)
+
+const day = 24 * time.Hour
+
+const unowned = "unowned"
+
+var (
+	ErrReportNotFound = errors.New("report not found")
+	ErrReportFormat   = errors.New("report format must be csv, xlsx or json")
+	ErrReportWithin   = errors.New("report window must be a positive duration, such as 72h or 30d")
+)
+
+// Report is a table generated from the resources of the store.
+type Report struct {
+	Name      string     `json:"name"`
+	Generated time.Time  `json:"generated"`
+	Columns   []string   `json:"columns"`
+	Rows      [][]string `json:"rows"`
+}
+
+// ReportInfo describes a report that can be generated.
+type ReportInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// reportOptions are the query parameters of a report. Reports over time
+// read the history, limited to the resources that readable returns true for
+// if it is set.
+type reportOptions struct {
+	format   string
+	within   time.Duration
+	history  *history.History
+	readable func(zebra.Resource) bool
+}
+
+type reportBuilder func(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string
+
+// reportKind is a report that can be generated. Reports which look ahead,
+// or back, do so for the window of the request, or their own by default.
+type reportKind struct {
+	description string
+	columns     []string
+	within      time.Duration
+	build       reportBuilder
+}
+
+// deviceTypes are the resource types that can be unreachable.
+var deviceTypes = map[string]bool{"Server": true, "ESX": true, "VCenter": true, "VM": true, "Switch": true}
+
+// unleasableTypes are resource types with a status that are not leased.
+var unleasableTypes = map[string]bool{"Lease": true, "User": true, "ServiceAccount": true}
+
+var reports = map[string]reportKind{
+	"inventory-by-owner": {
+		description: "number of resources of each type by owner",
+		columns:     []string{"Owner", "Type", "Count"},
+		within:      0,
+		build:       inventoryByOwner,
+	},
+	"lease-utilization": {
+		description: "leased, free and setup resources of each type",
+		columns:     []string{"Type", "Total", "Leased", "Free", "Setup", "Utilization"},
+		within:      0,
+		build:       leaseUtilization,
+	},
+	"expiring-leases": {
+		description: "active leases expiring within the window, 72h unless ?within is given",
+		columns:     []string{"Lease", "Owner", "Activated", "Expires", "Remaining", "Resources"},
+		within:      DefaultExpiringWithin,
+		build:       expiringLeases,
+	},
+	"unreachable-devices": {
+		description: "devices that are inactive or have a critical fault",
+		columns:     []string{"ID", "Type", "Group", "Owner", "State", "Fault"},
+		within:      0,
+		build:       unreachableDevices,
+	},
+	"expiring-warranties": {
+		description: "hardware whose warranty ends within the window, 90d unless ?within is given",
+		columns:     []string{"ID", "Type", "Group", "Owner", "Vendor", "Cost Center", "Warranty End", "Days Left"},
+		within:      DefaultWarrantyWithin,
+		build:       expiringWarranties,
+	},
+	"chargeback": {
+		description: "device hours leased by team, cost center and type per month, over the last 90d unless ?within is given",
+		columns:     []string{"Month", "Team", "Cost Center", "Type", "Leases", "Device Hours"},
+		within:      DefaultChargebackWithin,
+		build:       chargeback,
+	},
+}
+
+func parseReportOptions(format string, within string) (reportOptions, error) {
+	opts := reportOptions{format: FormatCSV, within: 0, history: nil, readable: nil}
+
+	switch format {
+	case "":
+	case FormatCSV, FormatXLSX, FormatJSON:
+		opts.format = format
+	default:
+		return opts, ErrReportFormat
+	}
+
+	if within != "" {
+		d, err := parseWithin(within)
+		if err != nil || d <= 0 {
+			return opts, ErrReportWithin
+		}
+
+		opts.within = d
+	}
+
+	return opts, nil
+}
+
+// parseWithin parses a duration which may also be a number of days, such
+// as 30d.
+func parseWithin(within string) (time.Duration, error) {
+	if days := strings.TrimSuffix(within, "d"); days != within {
+		n, err := strconv.Atoi(days)
+
+		return time.Duration(n) * day, err
+	}
+
+	return time.ParseDuration(within)
+}
+
+// generateReport builds the named report from the resources.
+func generateReport(name string, resources *zebra.ResourceMap, opts reportOptions, now time.Time) (*Report, error) {
+	kind, ok := reports[name]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+
+	if opts.within == 0 {
+		opts.within = kind.within
+	}
+
+	return &Report{
+		Name:      name,
+		Generated: now,
+		Columns:   kind.columns,
+		Rows:      kind.build(resources, opts, now),
+	}, nil
+}
+
+func inventoryByOwner(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	type key struct{ owner, typ string }
+
+	counts := map[key]int{}
+
+	_ = applyFunc(resources, func(r zebra.Resource) error {
+		owner := resourceOwner(r)
+		if owner == "" {
+			owner = unowned
+		}
+
+		counts[key{owner, r.GetType()}]++
+
+		return nil
+	})
+
+	keys := make([]key, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].owner != keys[j].owner {
+			return keys[i].owner < keys[j].owner
+		}
+
+		return keys[i].typ < keys[j].typ
+	})
+
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, []string{k.owner, k.typ, strconv.Itoa(counts[k])})
+	}
+
+	return rows
+}
+
+// leaseCount counts the resources of a type by lease state.
+type leaseCount struct {
+	total  int
+	leased int
+	free   int
+	setup  int
+}
+
+// utilization returns the percentage of leased resources, to one decimal.
+func (c *leaseCount) utilization() float64 {
+	if c.total == 0 {
+		return 0
+	}
+
+	return math.Round(float64(c.leased)*1000/float64(c.total)) / 10 //nolint:gomnd
+}
+
+// leaseCounts returns the lease counts of the leasable resource types.
+func leaseCounts(resources *zebra.ResourceMap) map[string]*leaseCount {
+	counts := map[string]*leaseCount{}
+
+	for typ, list := range resources.Resources {
+		if unleasableTypes[typ] {
+			continue
+		}
+
+		for _, r := range list.Resources {
+			status := r.GetStatus()
+			if status == nil {
+				continue
+			}
+
+			c, ok := counts[typ]
+			if !ok {
+				c = new(leaseCount)
+				counts[typ] = c
+			}
+
+			c.total++
+
+			switch status.Lease {
+			case zebra.Leased:
+				c.leased++
+			case zebra.Free:
+				c.free++
+			case zebra.Setup:
+				c.setup++
+			}
+		}
+	}
+
+	return counts
+}
+
+func leaseUtilization(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	counts := leaseCounts(resources)
+	rows := [][]string{}
+
+	for _, typ := range sortedTypes(resources) {
+		c, ok := counts[typ]
+		if !ok {
+			continue
+		}
+
+		rows = append(rows, []string{
+			typ, strconv.Itoa(c.total), strconv.Itoa(c.leased), strconv.Itoa(c.free), strconv.Itoa(c.setup),
+			strconv.FormatFloat(c.utilization(), 'f', -1, 64),
+		})
+	}
+
+	return rows
+}
+
+func expiringLeases(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	type expiring struct {
+		l       *lease.Lease
+		expires time.Time
+	}
+
+	leases := []expiring{}
+
+	if list, ok := resources.Resources["Lease"]; ok {
+		for _, r := range list.Resources {
+			l, ok := r.(*lease.Lease)
+			if !ok || l.Status.State != zebra.Active {
+				continue
+			}
+
+			expires := l.Expiry()
+			if expires.After(now) && !expires.After(now.Add(opts.within)) {
+				leases = append(leases, expiring{l, expires})
+			}
+		}
+	}
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].expires.Before(leases[j].expires) })
+
+	rows := make([][]string, 0, len(leases))
+
+	for _, e := range leases {
+		held := 0
+		for _, req := range e.l.RequestList() {
+			held += len(req.Resources)
+		}
+
+		rows = append(rows, []string{
+			e.l.ID, e.l.Owner(),
+			e.l.ActivationTime.UTC().Format(time.RFC3339), e.expires.UTC().Format(time.RFC3339),
+			e.expires.Sub(now).Round(time.Minute).String(), strconv.Itoa(held),
+		})
+	}
+
+	return rows
+}
+
+func unreachableDevices(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	rows := [][]string{}
+
+	for _, typ := range sortedTypes(resources) {
+		if !deviceTypes[typ] {
+			continue
+		}
+
+		for _, r := range resources.Resources[typ].Resources {
+			status := r.GetStatus()
+			if status == nil || (status.State != zebra.Inactive && status.Fault != zebra.Critical) {
+				continue
+			}
+
+			rows = append(rows, []string{
+				r.GetID(), typ, r.GetLabels()["system.group"], status.UsedBy,
+				status.State.String(), status.Fault.String(),
+			})
+		}
+	}
+
+	return rows
+}
+
+// expiringAsset is hardware whose warranty ends within a window.
+type expiringAsset struct {
+	res   zebra.Resource
+	asset *zebra.Asset
+	ends  time.Time
+}
+
+// expiringAssets returns the resources whose warranty ends after now but
+// within the window, the soonest first.
+func expiringAssets(resources *zebra.ResourceMap, within time.Duration, now time.Time) []expiringAsset {
+	expiring := []expiringAsset{}
+
+	_ = applyFunc(resources, func(r zebra.Resource) error {
+		holder, ok := r.(zebra.AssetHolder)
+		if !ok || holder.GetAsset() == nil {
+			return nil
+		}
+
+		ends, ok := holder.GetAsset().Warranty()
+		if ok && ends.After(now) && !ends.After(now.Add(within)) {
+			expiring = append(expiring, expiringAsset{r, holder.GetAsset(), ends})
+		}
+
+		return nil
+	})
+
+	sort.Slice(expiring, func(i, j int) bool {
+		if !expiring[i].ends.Equal(expiring[j].ends) {
+			return expiring[i].ends.Before(expiring[j].ends)
+		}
+
+		return expiring[i].res.GetID() < expiring[j].res.GetID()
+	})
+
+	return expiring
+}
+
+// daysLeft returns the number of started days until the time.
+func daysLeft(until time.Time, now time.Time) int {
+	return int(math.Ceil(until.Sub(now).Hours() / 24)) //nolint:gomnd
+}
+
+func expiringWarranties(resources *zebra.ResourceMap, opts reportOptions, now time.Time) [][]string {
+	expiring := expiringAssets(resources, opts.within, now)
+	rows := make([][]string, 0, len(expiring))
+
+	for _, e := range expiring {
+		rows = append(rows, []string{
+			e.res.GetID(), e.res.GetType(), e.res.GetLabels()["system.group"], resourceOwner(e.res),
+			e.asset.Vendor, e.asset.CostCenter, e.asset.WarrantyEnd, strconv.Itoa(daysLeft(e.ends, now)),
+		})
+	}
+
+	return rows
+}
+
+func sortedTypes(resources *zebra.ResourceMap) []string {
+	types := make([]string, 0, len(resources.Resources))
+	for typ := range resources.Resources {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// writeReport renders the report in the format.
+func writeReport(w io.Writer, report *Report, format string) error {
+	switch format {
+	case FormatXLSX:
+		return xlsx.Write(w, xlsx.Sheet{Name: report.Name, Rows: append([][]string{report.Columns}, report.Rows...)})
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(report)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(report.Columns); err != nil {
+		return err
+	}
+
+	if err := cw.WriteAll(report.Rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+// reportFile returns the file name of a report generated at the time.
+func reportFile(report *Report, format string) string {
+	return report.Name + "-" + report.Generated.UTC().Format("20060102T150405Z") + "." + format
+}
+
+func reportContentType(format string) string {
+	switch format {
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	}
+
+	return "text/csv; charset=utf-8"
+}
+
+// reportTask returns a task that writes the report of the "report" argument
+// in the "format" argument, csv by default, to the "dir" argument.
+func reportTask(api *ResourceAPI, args map[string]string) (scheduler.Task, error) {
+	name, dir := args["report"], args["dir"]
+	if _, ok := reports[name]; !ok || dir == "" {
+		return nil, ErrJobArg
+	}
+
+	opts, err := parseReportOptions(args["format"], args["within"])
+	if err != nil {
+		return nil, err
+	}
+
+	opts.history = api.History
+
+	return func(ctx context.Context) (string, error) {
+		report, err := generateReport(name, api.view(), opts, time.Now())
+		if err != nil {
+			return "", err
+		}
+
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return "", err
+		}
+
+		file, err := os.Create(path.Join(dir, reportFile(report, opts.format)))
+		if err != nil {
+			return "", err
+		}
+
+		defer file.Close()
+
+		if err := writeReport(file, report, opts.format); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("wrote %s with %d rows", path.Base(file.Name()), len(report.Rows)), nil
+	}, nil
+}
+
+// handleReports lists the reports that can be generated.
+func handleReports() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		infos := make([]ReportInfo, 0, len(reports))
+		for name, kind := range reports {
+			infos = append(infos, ReportInfo{Name: name, Description: kind.description})
+		}
+
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+		writeJSON(req.Context(), res, infos)
+	}
+}
+
+// handleReport generates a report from the resources the user may read and
+// returns it as a download.
+func handleReport() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		query := req.URL.Query()
+
+		opts, err := parseReportOptions(query.Get("format"), query.Get("within"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		opts.history = api.History
+
+		if claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims); ok {
+			opts.readable = func(r zebra.Resource) bool {
+				return claims.Allows(auth.ActionRead, r.GetType(), r.GetLabels())
+			}
+		}
+
+		report, err := generateReport(params.ByName("name"), readableResources(ctx, api.view()), opts, time.Now())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		res.Header().Set("Content-Type", reportContentType(opts.format))
+		res.Header().Set("Content-Disposition", `attachment; filename="`+reportFile(report, opts.format)+`"`)
+
+		if err := writeReport(res, report, opts.format); err != nil {
+			log.Error(err, "failed to write report", "report", report.Name)
+
+			return
+		}
+
+		log.Info("report generated", "report", report.Name, "format", opts.format, "rows", len(report.Rows))
+	}
+}
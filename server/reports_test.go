@@ -0,0 +1,184 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func reportResources(assert *assert.Assertions, now time.Time) *zebra.ResourceMap {
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+
+	for i, owner := range []string{"alice@zebra", "alice@zebra", "bob@zebra", ""} {
+		lab := makeOwnedLab(owner)
+		if owner != "" {
+			lab.Status.Lease = zebra.Leased
+		}
+
+		if i == 3 {
+			lab.Status.Lease = zebra.Setup
+		}
+
+		resources.Add(lab, "Lab")
+	}
+
+	up := compute.NewVCenter("vc1", net.ParseIP("10.0.0.1"), zebra.Labels{"system.group": "labs"})
+	down := compute.NewVCenter("vc2", net.ParseIP("10.0.0.2"), zebra.Labels{"system.group": "labs"})
+	down.Status.State = zebra.Inactive
+	broken := compute.NewVCenter("vc3", net.ParseIP("10.0.0.3"), zebra.Labels{"system.group": "labs"})
+	broken.Status.Fault = zebra.Critical
+
+	resources.Add(up, "VCenter")
+	resources.Add(down, "VCenter")
+	resources.Add(broken, "VCenter")
+
+	for _, dur := range []time.Duration{48 * time.Hour, time.Hour, 200 * time.Hour, 0} {
+		req := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "lab", Count: 1, Filters: nil, Resources: nil}
+		assert.Nil(req.Assign(makeOwnedLab("alice@zebra")))
+
+		l := lease.NewLease("alice@zebra", dur, []*lease.ResourceReq{req})
+		assert.Nil(l.Activate())
+		l.ActivationTime = now
+		resources.Add(l, "Lease")
+	}
+
+	return resources
+}
+
+func TestGenerateReports(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	resources := reportResources(assert, now)
+	opts, err := parseReportOptions("", "")
+	assert.Nil(err)
+
+	report, err := generateReport("inventory-by-owner", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal([][]string{
+		{"alice@zebra", "Lab", "2"}, {"alice@zebra", "Lease", "4"}, {"bob@zebra", "Lab", "1"},
+		{"unowned", "Lab", "1"}, {"unowned", "VCenter", "3"},
+	}, report.Rows)
+
+	report, err = generateReport("lease-utilization", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal([][]string{
+		{"Lab", "4", "3", "0", "1", "75"},
+		{"VCenter", "3", "0", "3", "0", "0"},
+	}, report.Rows)
+
+	report, err = generateReport("expiring-leases", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal(2, len(report.Rows))
+	assert.Equal("1h0m0s", report.Rows[0][4])
+	assert.Equal("2022-06-03T12:00:00Z", report.Rows[1][3])
+	assert.Equal("1", report.Rows[1][5])
+
+	opts.within = 300 * time.Hour
+	report, err = generateReport("expiring-leases", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal(3, len(report.Rows))
+
+	report, err = generateReport("unreachable-devices", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal(2, len(report.Rows))
+
+	states := map[string]bool{}
+	for _, row := range report.Rows {
+		states[row[4]+"/"+row[5]] = true
+	}
+
+	assert.Equal(map[string]bool{"inactive/none": true, "active/critical": true}, states)
+
+	_, err = generateReport("gossip", resources, opts, now)
+	assert.ErrorIs(err, ErrReportNotFound)
+
+	_, err = parseReportOptions("pdf", "")
+	assert.ErrorIs(err, ErrReportFormat)
+
+	_, err = parseReportOptions("csv", "-1h")
+	assert.ErrorIs(err, ErrReportWithin)
+}
+
+func TestHandleReport(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "reports_testhandle"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+	assert.Nil(api.Store.Create(makeOwnedLab("alice@zebra")))
+	assert.Nil(api.Store.Create(dc.NewLab("hidden", zebra.Labels{"system.group": "secret"})))
+
+	// The user may only read the labs group
+	reader := makeClaims(assert, "user@zebra", false)
+	reader.Role.Privileges[0] = reader.Role.Privileges[0].WithSelector(zebra.Labels{"system.group": "labs"})
+
+	get := func(url string, name string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, reader)
+		rr := httptest.NewRecorder()
+
+		handleReport()(rr, httptest.NewRequest("GET", url, nil).WithContext(ctx),
+			httprouter.Params{{Key: "name", Value: name}})
+
+		return rr
+	}
+
+	rr := get("/api/v1/reports/inventory-by-owner", "inventory-by-owner")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(rr.Header().Get("Content-Disposition"), `filename="inventory-by-owner-`)
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.Nil(err)
+	assert.Equal([][]string{{"Owner", "Type", "Count"}, {"alice@zebra", "Lab", "1"}}, records)
+
+	rr = get("/api/v1/reports/inventory-by-owner?format=xlsx", "inventory-by-owner")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.True(bytes.HasPrefix(rr.Body.Bytes(), []byte("PK")))
+
+	rr = get("/api/v1/reports/inventory-by-owner?format=json", "inventory-by-owner")
+	assert.Contains(rr.Body.String(), `"columns":["Owner","Type","Count"]`)
+
+	assert.Equal(http.StatusBadRequest, get("/api/v1/reports/inventory-by-owner?format=pdf", "inventory-by-owner").Code)
+	assert.Equal(http.StatusNotFound, get("/api/v1/reports/gossip", "gossip").Code)
+
+	rr = httptest.NewRecorder()
+	handleReports()(rr, httptest.NewRequest("GET", "/api/v1/reports", nil), nil)
+	assert.Contains(rr.Body.String(), "unreachable-devices")
+
+	// Reports can be scheduled
+	_, err = reportTask(api, map[string]string{"report": "gossip", "dir": root})
+	assert.ErrorIs(err, ErrJobArg)
+
+	task, err := reportTask(api, map[string]string{"report": "lease-utilization", "dir": root, "format": "xlsx"})
+	assert.Nil(err)
+
+	result, err := task(context.Background())
+	assert.Nil(err)
+	assert.Contains(result, "with 1 rows")
+
+	files, err := filepath.Glob(filepath.Join(root, "lease-utilization-*.xlsx"))
+	assert.Nil(err)
+	assert.Equal(1, len(files))
+}
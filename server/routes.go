@@ -0,0 +1,213 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// API versions served by the router. The version serving a request is
+// returned in the APIVersionHeader of the response.
+const (
+	APIv1            = "v1"
+	APIv2            = "v2"
+	APIVersionHeader = "Zebra-API-Version"
+)
+
+// apiRoute is a route of a versioned API, relative to /api/<version>.
+type apiRoute struct {
+	method string
+	path   string
+	handle httprouter.Handle
+}
+
+// v1Routes are the routes of /api/v1. This API is stable: automation depends
+// on its request and response formats, so they must not change.
+func v1Routes() []apiRoute {
+	return []apiRoute{
+		{http.MethodGet, "/types", handleTypes()},
+		{http.MethodGet, "/types/:type/schema", handleTypeSchema()},
+		{http.MethodGet, "/schemas", handleSchemas()},
+		{http.MethodGet, "/openapi.json", handleOpenAPI()},
+		{http.MethodGet, "/labels", handleLabels()},
+		{http.MethodPost, "/labels/rename", handleRenameLabel()},
+		{http.MethodGet, "/labels/aliases", handleLabelAliases()},
+		{http.MethodDelete, "/labels/aliases/:key", handleDeleteLabelAlias()},
+		{http.MethodGet, "/index", handleIndex()},
+		{http.MethodGet, "/suggest", handleSuggest()},
+		{http.MethodPost, "/index/compact", handleCompactIndex()},
+		{http.MethodGet, "/resources", handleQuery()},
+		{http.MethodPost, "/resources", handlePost()},
+		{http.MethodDelete, "/resources", handleDelete()},
+		{http.MethodPost, "/query/batch", handleQueryBatch()},
+		{http.MethodPost, "/merge", handleMerge()},
+		{http.MethodGet, "/federation/sites", handleFederationSites()},
+		{http.MethodGet, "/federation/resources", handleFederatedQuery()},
+		{http.MethodPost, "/federation/resources", handleFederatedWrite()},
+		{http.MethodDelete, "/federation/resources", handleFederatedWrite()},
+		{http.MethodGet, "/sync/export", handleSyncExport()},
+		{http.MethodPost, "/sync/import", handleSyncImport()},
+		{http.MethodGet, "/names", handleNames()},
+		{http.MethodPost, "/names", handleReserveName()},
+		{http.MethodPost, "/names/bind", handleBindNames()},
+		{http.MethodPost, "/names/release", handleUnbindNames()},
+		{http.MethodDelete, "/names/:kind/:name", handleReleaseName()},
+		{http.MethodGet, "/locks", handleLocks()},
+		{http.MethodGet, "/locks/:name", handleLock()},
+		{http.MethodPost, "/locks/:name/acquire", handleAcquireLock()},
+		{http.MethodPost, "/locks/:name/renew", handleRenewLock()},
+		{http.MethodPost, "/locks/:name/release", handleReleaseLock()},
+		{http.MethodGet, "/kv/:namespace", handleKVList()},
+		{http.MethodGet, "/kv/:namespace/:key", handleKVGet()},
+		{http.MethodPost, "/kv/:namespace/:key", handleKVPut()},
+		{http.MethodDelete, "/kv/:namespace/:key", handleKVDelete()},
+		{http.MethodPost, "/resources/:id/transfer", handleTransfer()},
+		{http.MethodPost, "/resources/:id/archive", handleArchive()},
+		{http.MethodPost, "/resources/:id/restore", handleRestore()},
+		{http.MethodGet, "/resources/:id/lifecycle", handleTransitions()},
+		{http.MethodPost, "/resources/:id/lifecycle", handleTransition()},
+		{http.MethodGet, "/resources/:id/history", handleHistory()},
+		{http.MethodGet, "/resources/:id/diff", handleVersionDiff()},
+		{http.MethodGet, "/resources/:id/logs", handleLogs()},
+		{http.MethodGet, "/resources/:id/attachments", handleAttachments()},
+		{http.MethodPost, "/resources/:id/attachments", handleAttach()},
+		{http.MethodGet, "/resources/:id/attachments/:digest", handleAttachment()},
+		{http.MethodDelete, "/resources/:id/attachments/:digest", handleDeleteAttachment()},
+		{http.MethodGet, "/resources/:id/comments", handleComments()},
+		{http.MethodPost, "/resources/:id/comments", handleComment()},
+		{http.MethodDelete, "/resources/:id/comments/:comment", handleDeleteComment()},
+		{http.MethodGet, "/resources/:id/activity", handleActivity()},
+		{http.MethodGet, "/stars", handleStars()},
+		{http.MethodPost, "/stars/:id", handleStar()},
+		{http.MethodDelete, "/stars/:id", handleUnstar()},
+		{http.MethodGet, "/views", handleViews()},
+		{http.MethodGet, "/views/:name", handleView()},
+		{http.MethodPost, "/views/:name", handleSaveView()},
+		{http.MethodDelete, "/views/:name", handleDeleteView()},
+		{http.MethodGet, "/views/:name/resources", handleViewResources()},
+		{http.MethodPost, "/diff", handleDiff()},
+		{http.MethodGet, "/duplicates", handleDuplicates()},
+		{http.MethodGet, "/quotas", handleQuotas()},
+		{http.MethodGet, "/utilization", handleUtilization()},
+		{http.MethodGet, "/notifications", handleNotifications()},
+		{http.MethodGet, "/events", handleEvents()},
+		{http.MethodPost, "/policy/simulate", handleSimulate()},
+		{http.MethodPost, "/leases/simulate", handleSimulateLeases()},
+		{http.MethodPost, "/find-free", handleFindFree()},
+		{http.MethodPost, "/leases", handleRequestLease()},
+		{http.MethodGet, "/leases/queue", handleLeaseQueue()},
+		{http.MethodGet, "/leases/queue/:id", handleQueuedLease()},
+		{http.MethodDelete, "/leases/queue/:id", handleLeaveQueue()},
+		{http.MethodPost, "/leases/release/:id", handleReleaseLease()},
+		{http.MethodPost, "/leases/extend/:id", handleExtendLease()},
+		{http.MethodGet, "/leases/extensions", handleLeaseExtensions()},
+		{http.MethodPost, "/leases/extensions/:id/approve", handleApproveExtension()},
+		{http.MethodPost, "/leases/extensions/:id/reject", handleRejectExtension()},
+		{http.MethodPost, "/agents/register", handleAgentRegister()},
+		{http.MethodGet, "/serviceaccounts", handleServiceAccounts()},
+		{http.MethodPost, "/serviceaccounts", handleCreateServiceAccount()},
+		{http.MethodDelete, "/serviceaccounts/:id", handleDeleteServiceAccount()},
+		{http.MethodPost, "/serviceaccounts/:id/tokens", handleIssueServiceToken()},
+		{http.MethodDelete, "/serviceaccounts/:id/tokens/:token", handleRevokeServiceToken()},
+		{http.MethodGet, "/sessions", handleSessions()},
+		{http.MethodDelete, "/sessions", handleRevokeSessions()},
+		{http.MethodDelete, "/sessions/:id", handleRevokeSession()},
+		{http.MethodGet, "/users", handleUsers()},
+		{http.MethodGet, "/users/:email", handleUser()},
+		{http.MethodGet, "/groups", handleGroups()},
+		{http.MethodGet, "/groups/:name", handleGroup()},
+		{http.MethodGet, "/approvals", handleApprovals()},
+		{http.MethodPost, "/approvals/:id/approve", handleApprove()},
+		{http.MethodPost, "/approvals/:id/reject", handleReject()},
+		{http.MethodPost, "/wipe", handleWipe()},
+		{http.MethodGet, "/outbox", handleOutbox()},
+		{http.MethodGet, "/outbox/:id", handleDelivery()},
+		{http.MethodPost, "/outbox/:id/requeue", handleRequeue()},
+		{http.MethodGet, "/siem/outbox", handleSIEMOutbox()},
+		{http.MethodPost, "/siem/outbox/:id/requeue", handleSIEMRequeue()},
+		{http.MethodGet, "/jobs", handleJobs()},
+		{http.MethodGet, "/jobs/:name", handleJob()},
+		{http.MethodPost, "/jobs/:name/run", handleRunJob()},
+		{http.MethodGet, "/reports", handleReports()},
+		{http.MethodGet, "/reports/:name", handleReport()},
+		{http.MethodGet, "/export", handleExport()},
+		{http.MethodGet, "/grafana", handleGrafana()},
+		{http.MethodPost, "/grafana/search", handleGrafanaSearch()},
+		{http.MethodPost, "/grafana/query", handleGrafanaQuery()},
+		{http.MethodPost, "/grafana/annotations", handleGrafanaAnnotations()},
+	}
+}
+
+// v2Routes are the routes of /api/v2. Routes whose formats did not change
+// are served by the v1 handlers, only the routes listed here differ.
+func v2Routes() []apiRoute {
+	return overrideRoutes(v1Routes(), []apiRoute{
+		{http.MethodGet, "/resources", handleQueryV2()},
+		{http.MethodDelete, "/resources", handleDeleteV2()},
+	})
+}
+
+// overrideRoutes returns the base routes with the routes of the same method
+// and path replaced by the overrides.
+func overrideRoutes(base []apiRoute, overrides []apiRoute) []apiRoute {
+	routes := make([]apiRoute, 0, len(base))
+
+	for _, route := range base {
+		for _, o := range overrides {
+			if o.method == route.method && o.path == route.path {
+				route = o
+
+				break
+			}
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// versioned sets the API version header of the response.
+func versioned(version string, handle httprouter.Handle) httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		res.Header().Set(APIVersionHeader, version)
+		handle(res, req, params)
+	}
+}
+
+// routeHandler returns a http handler that handles all routes under the
+// /api/v1, /api/v2 and /admin endpoints. It is expected that this handler is
+// the final handler and requires the request context to be set with log,
+// store, auth etc.
+func routeHandler() http.Handler {
+	router := httprouter.New()
+
+	apis := map[string][]apiRoute{
+		APIv1: v1Routes(),
+		APIv2: v2Routes(),
+	}
+
+	for version, routes := range apis {
+		for _, route := range routes {
+			router.Handle(route.method, "/api/"+version+route.path, versioned(version, route.handle))
+		}
+	}
+
+	router.POST("/admin/reload", handleReload())
+	router.GET("/admin/keys", handleKeys())
+	router.POST("/admin/keys/rotate", handleRotateKey())
+	router.GET("/admin/keys/credentials", handleCredentialsKey())
+	router.POST("/admin/keys/credentials/rotate", handleRotateCredentialsKey())
+	router.GET("/admin/audit/verify", handleVerifyAudit())
+	router.GET("/admin/recording", handleRecordings())
+	router.POST("/admin/recording", handleStartRecording())
+	router.DELETE("/admin/recording", handleStopRecording())
+	router.GET("/admin/faults", handleFaults())
+	router.POST("/admin/faults", handleSetFaults())
+	router.DELETE("/admin/faults", handleClearFaults())
+	router.GET("/admin/read-only", handleReadOnly())
+	router.POST("/admin/read-only", handleSetReadOnly())
+	router.DELETE("/admin/read-only", handleClearReadOnly())
+
+	return router
+}
@@ -0,0 +1,66 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	router := routeHandler()
+	assert.NotNil(router)
+
+	r, ok := routeHandler().(*httprouter.Router)
+	assert.NotNil(r)
+	assert.True(ok)
+}
+
+func TestAPIVersions(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "routes_testversions"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := newV2API(assert, root, 1)
+	router := routeHandler()
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		router.ServeHTTP(rr, httptest.NewRequest(method, url, strings.NewReader(body)).WithContext(ctx))
+
+		return rr
+	}
+
+	// v2 serves the v1 handlers of routes that did not change
+	for _, version := range []string{APIv1, APIv2} {
+		rr := serve("GET", "/api/"+version+"/types", "{}")
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal(version, rr.Header().Get(APIVersionHeader))
+	}
+
+	// v1 query formats are unchanged, v2 returns pages
+	rr := serve("GET", "/api/v1/resources", "")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = serve("GET", "/api/v2/resources", "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), `"total":1`)
+
+	assert.Equal(http.StatusNotFound, serve("GET", "/api/v3/types", "{}").Code)
+
+	// Both versions have the same routes
+	assert.Equal(len(v1Routes()), len(v2Routes()))
+}
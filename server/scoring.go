@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+// Scoring policies rank the free resources matching a lease request, the
+// best ranked are allocated first. A policy is one of:
+//
+//	least-recently-used    resources whose last lease ended first, never leased ones first of all
+//	lowest:<field>         the lowest value of the field or label, such as lowest:firmware
+//	prefer:<label>=<value> resources with the label value, such as prefer:site=east
+//
+// Values are compared in natural order, so that 1.10 comes after 1.9, and
+// resources without a value come last.
+const (
+	ScoreLeastRecentlyUsed = "least-recently-used"
+	ScoreLowest            = "lowest"
+	ScorePrefer            = "prefer"
+)
+
+var ErrScorePolicy = errors.New("invalid scoring policy")
+
+// AllocationConfig sets the scoring policies of resource types, in order of
+// precedence, such as {"Server": ["prefer:site=east", "least-recently-used"]}.
+// The policies of AllTypes apply to the types not listed.
+type AllocationConfig struct {
+	Scoring map[string][]string `json:"scoring,omitempty"`
+}
+
+type scorePolicy struct {
+	name string
+	key  func(a *allocator, res zebra.Resource) string
+}
+
+// scoringPolicy holds the scoring policies by resource type.
+type scoringPolicy map[string][]scorePolicy
+
+func newScoringPolicy(cfg *AllocationConfig) (scoringPolicy, error) {
+	s := scoringPolicy{}
+
+	for t, names := range cfg.Scoring {
+		for _, name := range names {
+			p, err := parseScorePolicy(name)
+			if err != nil {
+				return nil, err
+			}
+
+			s[t] = append(s[t], p)
+		}
+	}
+
+	return s, nil
+}
+
+func parseScorePolicy(name string) (scorePolicy, error) {
+	kind, arg, _ := strings.Cut(name, ":")
+
+	switch {
+	case name == ScoreLeastRecentlyUsed:
+		return scorePolicy{name: name, key: func(a *allocator, res zebra.Resource) string {
+			return a.lastUsed[res.GetID()].UTC().Format(time.RFC3339Nano)
+		}}, nil
+	case kind == ScoreLowest && arg != "":
+		return scorePolicy{name: name, key: func(_ *allocator, res zebra.Resource) string {
+			if v, ok := fieldValue(res, arg); ok && v != "" {
+				return v
+			}
+
+			return res.GetLabels()[arg]
+		}}, nil
+	case kind == ScorePrefer && strings.Contains(arg, "="):
+		key, value, _ := strings.Cut(arg, "=")
+
+		return scorePolicy{name: name, key: func(_ *allocator, res zebra.Resource) string {
+			if res.GetLabels()[key] == value {
+				return "0"
+			}
+
+			return "1"
+		}}, nil
+	}
+
+	return scorePolicy{name: "", key: nil}, fmt.Errorf("%w: %s", ErrScorePolicy, name)
+}
+
+// forType returns the scoring policies of the resource type.
+func (s scoringPolicy) forType(t string) []scorePolicy {
+	if policies, ok := s[t]; ok {
+		return policies
+	}
+
+	return s[AllTypes]
+}
+
+// rank orders the free resources of the type by the scoring policies of the
+// type and returns the names of the policies and the scores, by ID.
+func (a *allocator) rank(resType string, free []zebra.Resource) ([]string, map[string][]string) {
+	policies := a.scoring.forType(resType)
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(policies))
+	for _, p := range policies {
+		names = append(names, p.name)
+	}
+
+	scores := make(map[string][]string, len(free))
+
+	for _, res := range free {
+		keys := make([]string, 0, len(policies))
+		for _, p := range policies {
+			keys = append(keys, p.key(a, res))
+		}
+
+		scores[res.GetID()] = keys
+	}
+
+	sort.SliceStable(free, func(i, j int) bool {
+		ki, kj := scores[free[i].GetID()], scores[free[j].GetID()]
+
+		for k := range ki {
+			if ki[k] != kj[k] {
+				return scoreLess(ki[k], kj[k])
+			}
+		}
+
+		return false
+	})
+
+	return names, scores
+}
+
+// scoreLess compares scores in natural order, empty scores last.
+func scoreLess(a, b string) bool {
+	if a == "" || b == "" {
+		return b == "" && a != ""
+	}
+
+	for a != "" && b != "" {
+		ra, rb := leadingRun(a), leadingRun(b)
+		a, b = a[len(ra):], b[len(rb):]
+
+		if ra == rb {
+			continue
+		}
+
+		if isDigits(ra) && isDigits(rb) {
+			na, nb := strings.TrimLeft(ra, "0"), strings.TrimLeft(rb, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+
+			if na != nb {
+				return na < nb
+			}
+
+			continue
+		}
+
+		return ra < rb
+	}
+
+	return len(a) < len(b)
+}
+
+// leadingRun returns the leading run of digits or of other characters.
+func leadingRun(s string) string {
+	digits := isDigit(s[0])
+
+	for i := 1; i < len(s); i++ {
+		if isDigit(s[i]) != digits {
+			return s[:i]
+		}
+	}
+
+	return s
+}
+
+func isDigits(s string) bool {
+	return s != "" && isDigit(s[0])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
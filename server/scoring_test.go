@@ -0,0 +1,102 @@
+package server //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScoringPolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := newScoringPolicy(&AllocationConfig{Scoring: nil})
+	assert.Nil(err)
+	assert.Empty(s.forType("Lab"))
+
+	s, err = newScoringPolicy(&AllocationConfig{Scoring: map[string][]string{
+		"Lab":    {"prefer:site=east", "lowest:firmware"},
+		AllTypes: {ScoreLeastRecentlyUsed},
+	}})
+	assert.Nil(err)
+	assert.Equal(2, len(s.forType("Lab")))
+	assert.Equal(ScoreLeastRecentlyUsed, s.forType("Server")[0].name)
+
+	for _, name := range []string{"random", "lowest", "lowest:", "prefer:site"} {
+		_, err := newScoringPolicy(&AllocationConfig{Scoring: map[string][]string{"Lab": {name}}})
+		assert.ErrorIs(err, ErrScorePolicy)
+	}
+}
+
+func TestScoreLess(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.True(scoreLess("1.9", "1.10"))
+	assert.False(scoreLess("1.10", "1.9"))
+	assert.True(scoreLess("2", "10"))
+	assert.True(scoreLess("v1", "v1.0"))
+	assert.True(scoreLess("abc", "abd"))
+	assert.True(scoreLess("1", ""))
+	assert.False(scoreLess("", "1"))
+	assert.False(scoreLess("", ""))
+	assert.False(scoreLess("1.2", "1.2"))
+}
+
+func TestRank(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	ids := map[string]string{}
+	labs := map[string]*dc.Lab{}
+
+	for _, l := range []struct{ name, site, firmware string }{
+		{"a", "west", "1.2"}, {"b", "east", "1.10"}, {"c", "east", "1.9"}, {"d", "east", ""},
+	} {
+		lab := dc.NewLab(l.name, zebra.Labels{"system.group": "labs", "site": l.site, "firmware": l.firmware})
+		ids[l.name] = lab.ID
+		labs[l.name] = lab
+		resources.Add(lab, "Lab")
+	}
+
+	s, err := newScoringPolicy(&AllocationConfig{Scoring: map[string][]string{
+		"Lab": {"prefer:site=east", "lowest:firmware"},
+	}})
+	assert.Nil(err)
+
+	req := AllocationRequest{Type: "Lab", Group: "labs", Name: "", Count: 2, Filters: nil}
+
+	alloc := newAllocator(resources, time.Now(), s).allocate("lease", req)
+	assert.Equal([]string{ids["c"], ids["b"]}, alloc.Allocated)
+	assert.Equal([]string{"prefer:site=east", "lowest:firmware"}, alloc.Scoring)
+	assert.Equal(map[string][]string{ids["c"]: {"0", "1.9"}, ids["b"]: {"0", "1.10"}}, alloc.Scores)
+
+	// Without scoring nothing is recorded
+	alloc = newAllocator(resources, time.Now(), nil).allocate("lease", req)
+	assert.Equal(2, len(alloc.Allocated))
+	assert.Nil(alloc.Scoring)
+	assert.Nil(alloc.Scores)
+
+	// The labs leased least recently come first, never leased ones first of
+	// all
+	lru, err := newScoringPolicy(&AllocationConfig{Scoring: map[string][]string{AllTypes: {ScoreLeastRecentlyUsed}}})
+	assert.Nil(err)
+
+	for i, name := range []string{"b", "a", "c"} {
+		r := &lease.ResourceReq{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}
+		assert.Nil(r.Assign(labs[name]))
+
+		l := lease.NewLease("user@zebra", time.Hour, []*lease.ResourceReq{r})
+		l.ActivationTime = time.Now().Add(-time.Duration(10-i) * time.Hour)
+		resources.Add(l, "Lease")
+	}
+
+	alloc = newAllocator(resources, time.Now(), lru).allocate("lease", req)
+	assert.Equal([]string{ids["d"], ids["b"]}, alloc.Allocated)
+}
@@ -0,0 +1,275 @@
+// Package server is the zebra server. The zebra-server command runs it from
+// a configuration file, other Go programs embed it with New: the server
+// serves the zebra API from a mux of the program, and the program may read
+// and change the resources through the Store of its API directly.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/attachment"
+	"github.com/project-safari/zebra/store"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"gojini.dev/config"
+	"gojini.dev/web"
+)
+
+const version = "unknown"
+
+// Execute runs the zebra-server command with the arguments of the process.
+func Execute() error {
+	err := NewRootCmd(filepath.Base(os.Args[0])).Execute()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return err
+}
+
+// NewRootCmd returns the zebra-server command.
+func NewRootCmd(name string) *cobra.Command {
+	rootCmd := new(cobra.Command)
+
+	rootCmd.Use = name
+	rootCmd.Short = "zebra server"
+	rootCmd.Version = version + "\n"
+	rootCmd.RunE = run
+	rootCmd.SilenceUsage = true
+	rootCmd.SetVersionTemplate(version + "\n")
+	rootCmd.PersistentFlags().StringP("config", "c", cwd("server.json"),
+		"config file (default: $PWD/server.json)")
+	rootCmd.Flags().Bool("demo", false,
+		"run a demo server of a sample lab in memory, without configuration or authentication")
+	rootCmd.Flags().String("demo-address", DemoAddress, "address of the demo server")
+
+	rootCmd.AddCommand(NewInitCmd())
+	rootCmd.AddCommand(NewVerifyAuditCmd())
+	rootCmd.AddCommand(NewMigrateCmd())
+	rootCmd.AddCommand(NewMigrateSchemaCmd())
+	rootCmd.AddCommand(NewOpenAPICmd())
+
+	return rootCmd
+}
+
+func cwd(f string) string {
+	s, _ := os.Getwd()
+
+	return path.Join(s, f)
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	if demo, _ := cmd.Flags().GetBool("demo"); demo {
+		return runDemo(cmd)
+	}
+
+	// Load server configuration
+	cfgFile := cmd.Flag("config").Value.String()
+
+	cfgStore := config.New()
+	if err := cfgStore.LoadFromFile(context.Background(), cfgFile); err != nil {
+		return err
+	}
+
+	return startServer(cfgFile, cfgStore)
+}
+
+func startServer(cfgFile string, cfgStore *config.Store) error {
+	appCtx := setupLogger(cfgStore)
+	log := logr.FromContextOrDiscard(appCtx)
+
+	serverCfg := new(web.Config)
+	if e := cfgStore.Get("server", serverCfg); e != nil {
+		return e
+	}
+
+	loadCfg := &LoadConfig{MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 0, RetryAfter: ""}
+	if e := cfgStore.Get("load", loadCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		return e
+	}
+
+	shedder, err := newLoadShedder(loadCfg)
+	if err != nil {
+		return err
+	}
+
+	reloader, err := NewReloader(appCtx, cfgFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.watchSignals(appCtx)
+
+	setup := setupAdapter(appCtx, cfgStore)
+
+	log.Info("setup completed")
+
+	webServer := web.NewServer(serverCfg, newHandler(setup, authAdapter(), reloader, shedder))
+
+	log.Info("starting zebra server")
+
+	return webServer.Start(appCtx)
+}
+
+// newHandler returns the handler of all requests, setup puts the API in
+// their context and auth authenticates them.
+func newHandler(setup web.Adapter, auth web.Adapter, reloader *Reloader, shedder *loadShedder) http.Handler {
+	shed := shedAdapter(shedder)
+	authLimit := authLimitAdapter()
+	login := loginAdapter()
+	register := registerAdapter()
+	refresh := refreshAdapter()
+	logout := logoutAdapter()
+	record := recordAdapter()
+	authz := authzAdapter()
+	routes := routeHandler()
+	router, _ := routes.(*httprouter.Router)
+	recovery := recoverAdapter(router)
+	runtimeCfg := runtimeAdapter(reloader)
+	problem := problemAdapter()
+	localize := localizeAdapter(reloader)
+	timeout := timeoutAdapter(router, reloader)
+	format := formatAdapter()
+	serveMetrics := metricsAdapter()
+	health := healthAdapter()
+	instrument := instrumentAdapter(router)
+	readOnly := readOnlyAdapter()
+
+	// The order of wrap matters, routes is the final handler that is being
+	// wrapped. setup, login and register are unauthenticated APIs that serve
+	// as a way to bootstrap authentication. auth, refresh, logout and all endpoints
+	// registered by routes must be authenticated either via a jwt in the cookie
+	// or via a rsa key token in the header, authz then checks that the user
+	// may change the resources of the request. recovery and timeout guard all
+	// requests after setup has put the logger in the request context, and
+	// runtimeCfg adds the configuration that can be reloaded. localize
+	// translates the messages of all error responses, which problem then
+	// returns as problem details to the clients asking for them. format turns
+	// YAML request bodies into JSON before authz reads them. metrics and
+	// health are served without authentication. instrument counts and times
+	// all other requests, and shed turns them away while the server is
+	// overloaded. readOnly rejects all changes, registrations included,
+	// while the server is read-only. authLimit throttles login and register
+	// requests of each client address. record records the authenticated
+	// requests while an admin debugs a client.
+	return web.Wrap(routes, setup, runtimeCfg, problem, localize, recovery, timeout, format, serveMetrics,
+		health, instrument, shed, readOnly, authLimit, login, register, auth, record, refresh, logout, authz)
+}
+
+func callNext(nextHandler http.Handler, res http.ResponseWriter, req *http.Request) {
+	if nextHandler != nil && req != nil {
+		nextHandler.ServeHTTP(res, req)
+	}
+}
+
+// Options are the options of an embedded server.
+type Options struct {
+	// Root is the storage root of the resources
+	Root string
+	// Format is the store backend, store.FormatFiles by default
+	Format string
+	// LazyIndex defers indexing the labels of resources until they are
+	// first queried by label
+	LazyIndex bool
+	// AuthKey signs the tokens of the users logged in, a random key is used
+	// if NoAuth is set
+	AuthKey string
+	// NoAuth serves all requests as the admin user DemoUser, without
+	// authentication
+	NoAuth bool
+}
+
+// Server is an embedded zebra server. It serves the same routes, with the
+// same authentication, as zebra-server does, from the root of the mux it is
+// mounted on. The configuration of an embedded server is its options, there
+// is no configuration file to reload.
+type Server struct {
+	// API serves the resources, API.Store reads and changes them directly
+	API     *ResourceAPI
+	handler http.Handler
+}
+
+// New returns an embedded server with the resources of the storage root of
+// the options. Requests are logged with the logger of the context.
+func New(ctx context.Context, opts Options) (*Server, error) {
+	authenticate := authAdapter()
+
+	if opts.NoAuth {
+		authenticate = noAuthAdapter()
+
+		if opts.AuthKey == "" {
+			key, err := newDemoAuthKey()
+			if err != nil {
+				return nil, err
+			}
+
+			opts.AuthKey = key
+		}
+	}
+
+	if opts.AuthKey == "" {
+		return nil, ErrNoAuthKey
+	}
+
+	if opts.Format == "" {
+		opts.Format = store.FormatFiles
+	}
+
+	api := NewResourceAPI(store.DefaultFactory())
+	api.format = opts.Format
+	api.lazyIndex = opts.LazyIndex
+
+	if err := api.Initialize(opts.Root); err != nil {
+		return nil, err
+	}
+
+	api.attachments = attachment.NewStore(path.Join(opts.Root, "attachments"), 0, 0)
+	if err := api.attachments.Initialize(); err != nil {
+		return nil, err
+	}
+
+	if opts.NoAuth && findUser(api.Store, DemoEmail) == nil {
+		user, err := newDemoUser()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := api.Store.Create(user); err != nil {
+			return nil, err
+		}
+	}
+
+	timeouts, err := newRouteTimeouts(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Embedding programs limit the load they take on themselves
+	shedder, err := newLoadShedder(&LoadConfig{
+		MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 0, RetryAfter: "",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := newStaticReloader(&RuntimeConfig{
+		LogLevel: zerolog.GlobalLevel(), AuthKey: opts.AuthKey, Timeouts: timeouts, Messages: nil,
+	})
+
+	return &Server{
+		API:     api,
+		handler: newHandler(contextAdapter(ctx, api, opts.AuthKey), authenticate, reloader, shedder),
+	}, nil
+}
+
+func (s *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(res, req)
+}
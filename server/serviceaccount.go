@@ -0,0 +1,495 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/metrics"
+)
+
+// BearerPrefix is the prefix of service account tokens in the Authorization
+// header.
+const BearerPrefix = "Bearer "
+
+var (
+	ErrServiceAccountRequest = errors.New("service account name, namespace and role are required")
+	ErrServiceAccountExists  = errors.New("service account already exists in the namespace")
+	ErrServiceAccountMissing = errors.New("service account not found")
+	ErrServiceTokenMissing   = errors.New("service account token not found")
+	ErrServiceAccountRole    = errors.New("service account role exceeds the privileges of the creator")
+)
+
+var serviceAccountThrottles = metrics.Default.Counter("zebra_serviceaccount_throttled_total",
+	"Service account requests rejected by the rate limit or quota, by namespace.", "namespace", "reason")
+
+// ServiceAccountRequest creates a service account. The role is confined to
+// the namespace, TokenTTL is the lifetime of the first token, such as "720h",
+// empty for a token that does not expire.
+type ServiceAccountRequest struct {
+	Name      string     `json:"name"`
+	Namespace string     `json:"namespace"`
+	Role      *auth.Role `json:"role"`
+	Quota     int        `json:"quota"`
+	RateLimit int        `json:"rateLimit"`
+	TokenTTL  string     `json:"tokenTTL,omitempty"`
+}
+
+// TokenRequest issues another token of a service account.
+type TokenRequest struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ServiceAccountResponse returns a service account and, when one was issued,
+// the token. The token is not shown again.
+type ServiceAccountResponse struct {
+	Account *auth.ServiceAccount `json:"account"`
+	TokenID string               `json:"tokenId,omitempty"`
+	Token   string               `json:"token,omitempty"`
+}
+
+// accountUsage is the rate limit bucket and the quota used today by one
+// service account.
+type accountUsage struct {
+	tokens   float64
+	refilled time.Time
+	day      string
+	changes  int
+}
+
+// accountLimits enforces the rate limits and quotas of service accounts.
+// Usage is kept in memory, a restart resets it.
+type accountLimits struct {
+	lock  sync.Mutex
+	usage map[string]*accountUsage
+}
+
+func newAccountLimits() *accountLimits {
+	return &accountLimits{lock: sync.Mutex{}, usage: map[string]*accountUsage{}}
+}
+
+func (l *accountLimits) get(sa *auth.ServiceAccount, now time.Time) *accountUsage {
+	u, ok := l.usage[sa.ID]
+	if !ok {
+		u = &accountUsage{tokens: float64(sa.RateLimit), refilled: now, day: "", changes: 0}
+		l.usage[sa.ID] = u
+	}
+
+	if day := now.Format("2006-01-02"); u.day != day {
+		u.day = day
+		u.changes = 0
+	}
+
+	return u
+}
+
+// allowRequest takes a request from the per minute rate limit of the account.
+func (l *accountLimits) allowRequest(sa *auth.ServiceAccount, now time.Time) bool {
+	if sa.RateLimit == 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	u := l.get(sa, now)
+	limit := float64(sa.RateLimit)
+	u.tokens = math.Min(limit, u.tokens+now.Sub(u.refilled).Minutes()*limit)
+	u.refilled = now
+
+	if u.tokens < 1 {
+		return false
+	}
+
+	u.tokens--
+
+	return true
+}
+
+// chargeQuota takes n resource changes from the daily quota of the account,
+// it takes none if there are not enough left.
+func (l *accountLimits) chargeQuota(sa *auth.ServiceAccount, n int, now time.Time) bool {
+	if sa.Quota == 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	u := l.get(sa, now)
+	if u.changes+n > sa.Quota {
+		return false
+	}
+
+	u.changes += n
+
+	return true
+}
+
+func (l *accountLimits) forget(id string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.usage, id)
+}
+
+func findServiceAccount(s zebra.Store, id string) *auth.ServiceAccount {
+	var sa *auth.ServiceAccount
+
+	_ = applyFunc(s.QueryUUID([]string{id}), func(r zebra.Resource) error {
+		sa, _ = r.(*auth.ServiceAccount)
+
+		return nil
+	})
+
+	return sa
+}
+
+// serviceToken authenticates requests with a service account token in the
+// Authorization header and applies the rate limit of the account.
+func serviceToken(res http.ResponseWriter, req *http.Request) *http.Request {
+	ctx := req.Context()
+	log := logr.FromContextOrDiscard(ctx)
+	api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+	if !ok {
+		log.Error(nil, "resources not in context")
+
+		return nil
+	}
+
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, BearerPrefix) {
+		return nil
+	}
+
+	token := strings.TrimPrefix(header, BearerPrefix)
+	now := time.Now()
+
+	accountID, _, _, err := auth.ParseServiceToken(token)
+	if err != nil {
+		log.Error(err, "bad service account token")
+		res.WriteHeader(http.StatusUnauthorized)
+
+		return nil
+	}
+
+	sa := findServiceAccount(api.Store, accountID)
+	if sa == nil {
+		log.Error(nil, "service account not found", "account", accountID)
+		res.WriteHeader(http.StatusUnauthorized)
+
+		return nil
+	}
+
+	if err := sa.Authenticate(token, now); err != nil {
+		log.Error(err, "service account token invalid", "account", sa.Principal())
+		res.WriteHeader(http.StatusUnauthorized)
+
+		return nil
+	}
+
+	if !api.limits.allowRequest(sa, now) {
+		serviceAccountThrottles.Inc(sa.Namespace(), "rate")
+		log.Info("service account rate limited", "account", sa.Principal())
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Minute.Seconds()/float64(sa.RateLimit)))))
+		res.WriteHeader(http.StatusTooManyRequests)
+
+		return nil
+	}
+
+	ctx = context.WithValue(ctx, ClaimsCtxKey, sa.Claims())
+	ctx = context.WithValue(ctx, ServiceAccountCtxKey, sa)
+
+	return req.Clone(ctx)
+}
+
+func namespaceLabels(namespace string) zebra.Labels {
+	return zebra.Labels{"system.group": namespace}
+}
+
+// roleWithin returns true if the claims allow every action the role grants,
+// on every resource type and privilege key, wherever the role grants it. A
+// role can not be handed on with more than the privileges of its creator.
+func roleWithin(role *auth.Role, claims *auth.Claims, factory zebra.ResourceFactory) bool {
+	keys := []string{AdminKey, KVKey}
+	for _, t := range factory.Types() {
+		keys = append(keys, t.Name)
+	}
+
+	actions := []auth.Action{auth.ActionCreate, auth.ActionRead, auth.ActionUpdate, auth.ActionDelete}
+
+	for _, priv := range role.Privileges {
+		for _, key := range keys {
+			for _, action := range actions {
+				if priv.Allows(action, key, priv.Selector()) && !claims.Allows(action, key, priv.Selector()) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// serviceAccountFromParams returns the service account of the id parameter if
+// the user is allowed the action on it, else it writes the error response.
+func serviceAccountFromParams(res http.ResponseWriter, req *http.Request, params httprouter.Params,
+	action auth.Action,
+) (*ResourceAPI, *auth.ServiceAccount) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil
+	}
+
+	sa := findServiceAccount(api.Store, params.ByName("id"))
+	if sa == nil {
+		http.Error(res, ErrServiceAccountMissing.Error(), http.StatusNotFound)
+
+		return nil, nil
+	}
+
+	if !claims.Allows(action, sa.GetType(), sa.GetLabels()) {
+		res.WriteHeader(http.StatusForbidden)
+
+		return nil, nil
+	}
+
+	return api, sa
+}
+
+// handleServiceAccounts lists the service accounts of the namespaces the user
+// may read, without their token hashes.
+func handleServiceAccounts() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		accounts := []*auth.ServiceAccount{}
+
+		_ = applyFunc(api.Store.QueryType([]string{"ServiceAccount"}), func(r zebra.Resource) error {
+			sa, ok := r.(*auth.ServiceAccount)
+			if ok && claims.Allows(auth.ActionRead, sa.GetType(), sa.GetLabels()) {
+				accounts = append(accounts, sa.Redacted())
+			}
+
+			return nil
+		})
+
+		writeJSON(ctx, res, accounts)
+	}
+}
+
+// handleCreateServiceAccount creates a service account and its first token.
+// Namespace admins, users allowed to create service accounts in the
+// namespace, manage the service accounts of the namespace.
+func handleCreateServiceAccount() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		saReq := new(ServiceAccountRequest)
+		if err := readJSON(ctx, req, saReq); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if saReq.Name == "" || saReq.Namespace == "" || saReq.Role == nil {
+			http.Error(res, ErrServiceAccountRequest.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionCreate, "ServiceAccount", namespaceLabels(saReq.Namespace)) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		ttl, err := parseTTL(saReq.TokenTTL)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		exists := false
+
+		_ = applyFunc(api.Store.QueryType([]string{"ServiceAccount"}), func(r zebra.Resource) error {
+			sa, ok := r.(*auth.ServiceAccount)
+			exists = exists || (ok && sa.Name == saReq.Name && sa.Namespace() == saReq.Namespace)
+
+			return nil
+		})
+
+		if exists {
+			http.Error(res, ErrServiceAccountExists.Error(), http.StatusConflict)
+
+			return
+		}
+
+		sa := auth.NewServiceAccount(saReq.Name, saReq.Namespace, saReq.Role, claims.Email)
+		if !roleWithin(sa.Role, claims, api.factory) {
+			http.Error(res, ErrServiceAccountRole.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		sa.Quota = saReq.Quota
+		sa.RateLimit = saReq.RateLimit
+
+		token, issued, err := sa.IssueToken(ttl)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if err := sa.Validate(ctx); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := api.create(ctx, sa); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "serviceaccount.create", sa.ID, sa.Principal())
+		log.Info("service account created", "account", sa.Principal(), "user", claims.Email)
+
+		writeJSONCode(ctx, res, http.StatusCreated,
+			&ServiceAccountResponse{Account: sa.Redacted(), TokenID: issued.ID, Token: token})
+	}
+}
+
+// handleIssueServiceToken issues another token of a service account, so that
+// tokens can be rotated without downtime.
+func handleIssueServiceToken() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, sa := serviceAccountFromParams(res, req, params, auth.ActionUpdate)
+		if sa == nil {
+			return
+		}
+
+		tokenReq := new(TokenRequest)
+		if err := readJSON(ctx, req, tokenReq); err != nil && !errors.Is(err, ErrEmptyBody) {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		ttl, err := parseTTL(tokenReq.TTL)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		token, issued, err := sa.IssueToken(ttl)
+		if err != nil || api.create(ctx, sa) != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "serviceaccount.token.issue", sa.ID, issued.ID)
+
+		writeJSONCode(ctx, res, http.StatusCreated,
+			&ServiceAccountResponse{Account: sa.Redacted(), TokenID: issued.ID, Token: token})
+	}
+}
+
+// handleRevokeServiceToken revokes a token of a service account.
+func handleRevokeServiceToken() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, sa := serviceAccountFromParams(res, req, params, auth.ActionUpdate)
+		if sa == nil {
+			return
+		}
+
+		if !sa.RevokeToken(params.ByName("token")) {
+			http.Error(res, ErrServiceTokenMissing.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		if err := api.create(ctx, sa); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "serviceaccount.token.revoke", sa.ID, params.ByName("token"))
+
+		writeJSON(ctx, res, &ServiceAccountResponse{Account: sa.Redacted(), TokenID: "", Token: ""})
+	}
+}
+
+// handleDeleteServiceAccount deletes a service account and with it all of its
+// tokens.
+func handleDeleteServiceAccount() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, sa := serviceAccountFromParams(res, req, params, auth.ActionDelete)
+		if sa == nil {
+			return
+		}
+
+		if err := api.delete(ctx, sa); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.limits.forget(sa.ID)
+		api.recordAudit(ctx, "serviceaccount.delete", sa.ID, sa.Principal())
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(ttl)
+}
@@ -0,0 +1,235 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+	"gojini.dev/web"
+)
+
+// namespaceAdmin may manage service accounts and labs in the ci namespace.
+func namespaceAdmin(assert *assert.Assertions) *auth.Claims {
+	accounts, err := auth.NewPriv("^(ServiceAccount|Lab)$", true, true, true, true)
+	assert.Nil(err)
+
+	role := &auth.Role{Name: "ci-admin", Privileges: []*auth.Priv{accounts.WithSelector(namespaceLabels("ci"))}}
+
+	return auth.NewClaims("zebra", "ci-admin", role, "ci-admin@zebra")
+}
+
+func TestAccountLimits(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	limits := newAccountLimits()
+	sa := auth.NewServiceAccount("deploy", "ci", DefaultRole(), "a@zebra")
+	now := time.Date(2022, 6, 1, 23, 59, 0, 0, time.UTC)
+
+	// Unlimited
+	for i := 0; i < 100; i++ {
+		assert.True(limits.allowRequest(sa, now))
+		assert.True(limits.chargeQuota(sa, 10, now))
+	}
+
+	sa = auth.NewServiceAccount("lint", "ci", DefaultRole(), "a@zebra")
+	sa.RateLimit = 2
+	sa.Quota = 5
+
+	assert.True(limits.allowRequest(sa, now))
+	assert.True(limits.allowRequest(sa, now))
+	assert.False(limits.allowRequest(sa, now))
+	assert.True(limits.allowRequest(sa, now.Add(30*time.Second)))
+	assert.False(limits.allowRequest(sa, now.Add(30*time.Second)))
+
+	assert.True(limits.chargeQuota(sa, 4, now))
+	assert.False(limits.chargeQuota(sa, 2, now))
+	assert.True(limits.chargeQuota(sa, 1, now))
+	assert.False(limits.chargeQuota(sa, 1, now))
+
+	// The quota is daily
+	assert.True(limits.chargeQuota(sa, 5, now.Add(time.Minute)))
+
+	limits.forget(sa.ID)
+	assert.True(limits.chargeQuota(sa, 5, now.Add(time.Minute)))
+}
+
+func TestServiceAccountHandlers(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "serviceaccount_testhandlers"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	admin := namespaceAdmin(assert)
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	serve := func(claims *auth.Claims, h httprouter.Handle, method, body string,
+		params httprouter.Params,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest(method, "/api/v1/serviceaccounts", strings.NewReader(body)).WithContext(ctx), params)
+
+		return rr
+	}
+
+	body := `{"name": "deploy", "namespace": "ci", "role": {"name": "ci", "privileges": ["^Lab$:c,r,u,d"]},
+		"quota": 100, "rateLimit": 60, "tokenTTL": "720h"}`
+
+	rr := serve(admin, handleCreateServiceAccount(), "POST", body, nil)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	created := new(ServiceAccountResponse)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), created))
+	assert.NotEmpty(created.Token)
+	assert.Empty(created.Account.Tokens[0].Hash)
+	assert.Equal("ci-admin@zebra", created.Account.CreatedBy)
+
+	sa := findServiceAccount(api.Store, created.Account.ID)
+	assert.NotNil(sa)
+	assert.Nil(sa.Authenticate(created.Token, time.Now()))
+
+	assert.Equal(http.StatusConflict, serve(admin, handleCreateServiceAccount(), "POST", body, nil).Code)
+	assert.Equal(http.StatusForbidden, serve(user, handleCreateServiceAccount(), "POST", body, nil).Code)
+	assert.Equal(http.StatusForbidden, serve(admin, handleCreateServiceAccount(), "POST",
+		strings.Replace(body, `"ci"`, `"prod"`, 1), nil).Code)
+	assert.Equal(http.StatusBadRequest, serve(admin, handleCreateServiceAccount(), "POST",
+		`{"name": "deploy", "namespace": "ci"}`, nil).Code)
+
+	other := strings.Replace(body, `"deploy"`, `"build"`, 1)
+	assert.Equal(http.StatusBadRequest, serve(admin, handleCreateServiceAccount(), "POST",
+		strings.Replace(other, "720h", "month", 1), nil).Code)
+	assert.Equal(http.StatusBadRequest, serve(admin, handleCreateServiceAccount(), "POST",
+		strings.Replace(other, `"quota": 100`, `"quota": -1`, 1), nil).Code)
+
+	// The role can not grant more than the creator has
+	assert.Equal(http.StatusForbidden, serve(admin, handleCreateServiceAccount(), "POST",
+		strings.Replace(other, `^Lab$:c,r,u,d`, `system.admin:c,r,u,d`, 1), nil).Code)
+	assert.Equal(http.StatusForbidden, serve(admin, handleCreateServiceAccount(), "POST",
+		strings.Replace(other, `^Lab$:c,r,u,d`, `.*:r`, 1), nil).Code)
+
+	// Anyone who may read the namespace sees the accounts
+	rr = serve(user, handleServiceAccounts(), "GET", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Contains(rr.Body.String(), "deploy")
+	assert.NotContains(rr.Body.String(), sa.Tokens[0].Hash)
+
+	// Rotate the token
+	id := httprouter.Params{{Key: "id", Value: sa.ID}}
+
+	assert.Equal(http.StatusForbidden, serve(user, handleIssueServiceToken(), "POST", "", id).Code)
+
+	rr = serve(admin, handleIssueServiceToken(), "POST", "", id)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	rotated := new(ServiceAccountResponse)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), rotated))
+	assert.Equal(2, len(rotated.Account.Tokens))
+
+	revoke := httprouter.Params{{Key: "id", Value: sa.ID}, {Key: "token", Value: created.TokenID}}
+	assert.Equal(http.StatusOK, serve(admin, handleRevokeServiceToken(), "DELETE", "", revoke).Code)
+	assert.Equal(http.StatusNotFound, serve(admin, handleRevokeServiceToken(), "DELETE", "", revoke).Code)
+
+	sa = findServiceAccount(api.Store, sa.ID)
+	assert.NotNil(sa.Authenticate(created.Token, time.Now()))
+	assert.Nil(sa.Authenticate(rotated.Token, time.Now()))
+
+	assert.Equal(http.StatusForbidden, serve(user, handleDeleteServiceAccount(), "DELETE", "", id).Code)
+	assert.Equal(http.StatusOK, serve(admin, handleDeleteServiceAccount(), "DELETE", "", id).Code)
+	assert.Equal(http.StatusNotFound, serve(admin, handleDeleteServiceAccount(), "DELETE", "", id).Code)
+	assert.Nil(findServiceAccount(api.Store, sa.ID))
+
+	// Management is audited
+	actions := []string{}
+	for _, e := range api.Audit.Query(sa.ID) {
+		actions = append(actions, e.Action)
+	}
+
+	assert.Equal([]string{
+		"serviceaccount.create", "serviceaccount.token.issue",
+		"serviceaccount.token.revoke", "serviceaccount.delete",
+	}, actions)
+
+	rr = httptest.NewRecorder()
+	handleServiceAccounts()(rr, httptest.NewRequest("GET", "/api/v1/serviceaccounts", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func TestServiceTokenAuth(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "serviceaccount_testauth"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	crud, err := auth.NewPriv("^Lab$", true, true, true, true)
+	assert.Nil(err)
+
+	sa := auth.NewServiceAccount("deploy", "ci", &auth.Role{Name: "ci", Privileges: []*auth.Priv{crud}}, "a@zebra")
+	sa.Quota = 2
+	sa.RateLimit = 4
+
+	token, _, err := sa.IssueToken(0)
+	assert.Nil(err)
+	assert.Nil(api.Store.Create(sa))
+
+	handler := web.Wrap(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		handlePost()(res, req, nil)
+	}), authAdapter(), authzAdapter())
+
+	post := func(token string, labs ...zebra.Resource) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, AuthCtxKey, "abracadabra")
+		req := httptest.NewRequest("POST", "/api/v1/resources", strings.NewReader(resMapJSON(assert, labs...)))
+		req.Header.Set("Authorization", BearerPrefix+token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		return rr.Code
+	}
+
+	ciLab := func(name string) zebra.Resource {
+		return dc.NewLab(name, namespaceLabels("ci"))
+	}
+
+	assert.Equal(http.StatusOK, post(token, ciLab("lab1"), ciLab("lab2")))
+
+	// Changes are attributed to the service account
+	entries := api.Audit.QueryActorType(audit.ActorService)
+	assert.Equal(2, len(entries))
+	assert.Equal(sa.Principal(), entries[0].Actor)
+	assert.Empty(api.Audit.QueryActorType(audit.ActorUser))
+
+	// Out of the namespace, out of quota, and out of requests
+	assert.Equal(http.StatusForbidden, post(token, siteLab("lab3", "west")))
+	assert.Equal(http.StatusTooManyRequests, post(token, ciLab("lab3")))
+	assert.Equal(http.StatusTooManyRequests, post(token, ciLab("lab3")))
+
+	assert.Equal(http.StatusUnauthorized, post(token+"x", ciLab("lab3")))
+	assert.Equal(http.StatusUnauthorized, post("junk", ciLab("lab3")))
+	assert.Equal(http.StatusUnauthorized, post("a.b.c", ciLab("lab3")))
+}
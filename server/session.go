@@ -0,0 +1,397 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"gojini.dev/web"
+)
+
+var (
+	ErrSessionRevoked  = errors.New("session was revoked")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session is a login of a user. The jwts of the login and of its refreshes
+// carry the session ID, revoking the session rejects all of them.
+type Session struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"lastSeen"`
+	Expires  time.Time `json:"expires"`
+}
+
+// sessionList keeps the sessions of users and the revocation list checked
+// for every jwt. Revoked sessions are kept until their jwts expire. Users
+// whose sessions were all revoked have the jwts issued before that rejected,
+// as are the jwts of sessions the server did not know about. If a path is
+// given, the list is written to that file on every change so that revoked
+// jwts stay rejected after a restart.
+type sessionList struct {
+	lock      sync.Mutex
+	path      string
+	Sessions  map[string]*Session  `json:"sessions"`
+	Revoked   map[string]time.Time `json:"revoked"`
+	NotBefore map[string]time.Time `json:"notBefore"`
+}
+
+func newSessionList(path string) *sessionList {
+	return &sessionList{
+		lock:      sync.Mutex{},
+		path:      path,
+		Sessions:  map[string]*Session{},
+		Revoked:   map[string]time.Time{},
+		NotBefore: map[string]time.Time{},
+	}
+}
+
+// load reads the sessions from the backing file, if any.
+func (s *sessionList) load() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, s)
+}
+
+func (s *sessionList) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// start adds a new session of the user and sets its ID in the claims.
+func (s *sessionList) start(claims *auth.Claims, now time.Time) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(now)
+
+	session := &Session{
+		ID:       uuid.New().String(),
+		Email:    claims.Email,
+		Created:  now,
+		LastSeen: now,
+		Expires:  time.Unix(claims.ExpiresAt, 0),
+	}
+
+	s.Sessions[session.ID] = session
+	claims.Id = session.ID
+
+	return session, s.save()
+}
+
+// refresh moves the expiry of the session to the one of the refreshed
+// claims.
+func (s *sessionList) refresh(claims *auth.Claims, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if session, ok := s.Sessions[claims.Id]; ok {
+		session.LastSeen = now
+		session.Expires = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	return s.save()
+}
+
+// check returns ErrSessionRevoked if the jwt of the claims was revoked.
+func (s *sessionList) check(claims *auth.Claims, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.Revoked[claims.Id]; ok && claims.Id != "" {
+		return ErrSessionRevoked
+	}
+
+	if before, ok := s.NotBefore[claims.Email]; ok && claims.IssuedAt < before.Unix() {
+		return ErrSessionRevoked
+	}
+
+	if session, ok := s.Sessions[claims.Id]; ok {
+		session.LastSeen = now
+	}
+
+	return nil
+}
+
+// revoke revokes the session with the ID.
+func (s *sessionList) revoke(id string, now time.Time) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.Sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	delete(s.Sessions, id)
+	s.Revoked[id] = session.Expires
+	s.prune(now)
+
+	return session, s.save()
+}
+
+// revokeAll revokes all sessions of the user and returns them.
+func (s *sessionList) revokeAll(email string, now time.Time) ([]*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	revoked := []*Session{}
+
+	for id, session := range s.Sessions {
+		if session.Email == email {
+			delete(s.Sessions, id)
+			s.Revoked[id] = session.Expires
+			revoked = append(revoked, session)
+		}
+	}
+
+	// Tokens issued in the same second are rejected as well
+	s.NotBefore[email] = now.Add(time.Second)
+	s.prune(now)
+
+	return revoked, s.save()
+}
+
+// list returns the sessions of the user, of all users for "", by creation.
+func (s *sessionList) list(email string, now time.Time) []*Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(now)
+
+	sessions := []*Session{}
+
+	for _, session := range s.Sessions {
+		if email == "" || session.Email == email {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.Before(sessions[j].Created) })
+
+	return sessions
+}
+
+// prune drops expired sessions and the revocations of expired jwts.
+func (s *sessionList) prune(now time.Time) {
+	for id, session := range s.Sessions {
+		if now.After(session.Expires) {
+			delete(s.Sessions, id)
+		}
+	}
+
+	for id, expires := range s.Revoked {
+		if now.After(expires) {
+			delete(s.Revoked, id)
+		}
+	}
+
+	for email, before := range s.NotBefore {
+		if now.After(before.Add(auth.TokenDuration)) {
+			delete(s.NotBefore, email)
+		}
+	}
+}
+
+// logoutAdapter serves /logout, it revokes the session of the request and
+// clears the jwt cookie.
+func logoutAdapter() web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/logout" {
+				// This is not a logout request just forward it
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			if refuseImpersonated(res, req) {
+				return
+			}
+
+			ctx := req.Context()
+			log := logr.FromContextOrDiscard(ctx)
+			api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+			claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+			if !apiOK || !claimsOK {
+				res.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			// Users authenticated by keys have no session to revoke
+			if claims.Id != "" {
+				if _, err := api.sessions.revoke(claims.Id, time.Now()); err != nil &&
+					!errors.Is(err, ErrSessionNotFound) {
+					log.Error(err, "logout failed", "user", claims.Email)
+					res.WriteHeader(http.StatusInternalServerError)
+
+					return
+				}
+
+				api.recordAudit(ctx, "session.logout", claims.Id, "")
+			}
+
+			cookie := makeCookie("")
+			cookie.MaxAge = -1
+			http.SetCookie(res, cookie)
+			res.WriteHeader(http.StatusNoContent)
+
+			log.Info("logout succeeded", "user", claims.Email)
+		})
+	}
+}
+
+// handleSessions lists the sessions of the user, admins see those of all
+// users, or of the user query parameter.
+func handleSessions() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		email := req.URL.Query().Get("user")
+
+		switch {
+		case claims.Write(AdminKey):
+		case email == "" || email == claims.Email:
+			email = claims.Email
+		default:
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.sessions.list(email, time.Now()))
+	}
+}
+
+// handleRevokeSession revokes a session of the user, admins may revoke the
+// sessions of any user.
+func handleRevokeSession() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		id := params.ByName("id")
+		now := time.Now()
+
+		if !claims.Write(AdminKey) && !ownsSession(api.sessions.list(claims.Email, now), id) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		session, err := api.sessions.revoke(id, now)
+		if errors.Is(err, ErrSessionNotFound) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		} else if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "session.revoke", id, session.Email)
+		writeJSON(ctx, res, session)
+	}
+}
+
+// handleRevokeSessions revokes all sessions of the user query parameter, so
+// that a compromised account has to log in again. Only admins may do so.
+func handleRevokeSessions() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if !claims.Write(AdminKey) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		email := req.URL.Query().Get("user")
+		if email == "" {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		revoked, err := api.sessions.revokeAll(email, time.Now())
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "session.revoke.all", email, "")
+		writeJSON(ctx, res, revoked)
+	}
+}
+
+func ownsSession(sessions []*Session, id string) bool {
+	for _, s := range sessions {
+		if s.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
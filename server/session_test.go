@@ -0,0 +1,149 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionList(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "session_list_test"
+	assert.Nil(os.MkdirAll(root, 0o755))
+
+	defer func() { os.RemoveAll(root) }()
+
+	now := time.Now()
+	sessions := newSessionList(path.Join(root, "sessions.json"))
+
+	claims := auth.NewClaims("zebra", "user", nil, "user@zebra")
+	session, err := sessions.start(claims, now)
+	assert.Nil(err)
+	assert.Equal(session.ID, claims.Id)
+	assert.Nil(sessions.check(claims, now))
+
+	other := auth.NewClaims("zebra", "user", nil, "user@zebra")
+	_, err = sessions.start(other, now)
+	assert.Nil(err)
+	assert.Equal(2, len(sessions.list("user@zebra", now)))
+
+	// Revocations are kept across restarts
+	_, err = sessions.revoke(claims.Id, now)
+	assert.Nil(err)
+
+	_, err = sessions.revoke(claims.Id, now)
+	assert.ErrorIs(err, ErrSessionNotFound)
+
+	sessions = newSessionList(path.Join(root, "sessions.json"))
+	assert.Nil(sessions.load())
+	assert.ErrorIs(sessions.check(claims, now), ErrSessionRevoked)
+	assert.Nil(sessions.check(other, now))
+
+	// Revoking all sessions of a user also rejects jwts the list did not know
+	legacy := auth.NewClaims("zebra", "user", nil, "user@zebra")
+	revoked, err := sessions.revokeAll("user@zebra", now)
+	assert.Nil(err)
+	assert.Equal(1, len(revoked))
+	assert.ErrorIs(sessions.check(other, now), ErrSessionRevoked)
+	assert.ErrorIs(sessions.check(legacy, now), ErrSessionRevoked)
+	assert.Empty(sessions.list("", now))
+
+	// Expired revocations are pruned
+	sessions.prune(now.Add(2 * auth.TokenDuration))
+	assert.Empty(sessions.Revoked)
+	assert.Empty(sessions.NotBefore)
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "session_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	user := makeUser(assert)
+	api := NewResourceAPI(store.DefaultFactory())
+	api.Store = makeQueryStore(root, assert, user)
+
+	login := func() *http.Cookie {
+		rr := httptest.NewRecorder()
+		loginAdapter()(nil).ServeHTTP(rr, makeLoginRequest(assert, "jini", jiniWords, user.Email, api))
+		assert.Equal(http.StatusOK, rr.Code)
+
+		return rr.Result().Cookies()[0]
+	}
+
+	authenticated := func(cookie *http.Cookie, path string) int {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, AuthCtxKey, authKey)
+		req := httptest.NewRequest("POST", path, nil).WithContext(ctx)
+		req.AddCookie(cookie)
+
+		rr := httptest.NewRecorder()
+		handler := logoutAdapter()(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(http.StatusOK)
+		}))
+		authAdapter()(handler).ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// Logging out revokes the jwt
+	first := login()
+	second := login()
+	assert.Equal(http.StatusOK, authenticated(first, "/api/v1/resources"))
+	assert.Equal(http.StatusNoContent, authenticated(first, "/logout"))
+	assert.Equal(http.StatusUnauthorized, authenticated(first, "/api/v1/resources"))
+	assert.Equal(http.StatusOK, authenticated(second, "/api/v1/resources"))
+
+	serve := func(email string, admin bool, h httprouter.Handle, path string, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, email, admin))
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("GET", path, nil).WithContext(ctx), httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	rr := serve(user.Email, false, handleSessions(), "/api/v1/sessions", "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	sessions := []*Session{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &sessions))
+	assert.Equal(1, len(sessions))
+
+	assert.Equal(http.StatusForbidden, serve("other@zebra", false, handleSessions(),
+		"/api/v1/sessions?user="+user.Email, "").Code)
+	assert.Equal(http.StatusNotFound, serve("other@zebra", false, handleRevokeSession(),
+		"/api/v1/sessions/"+sessions[0].ID, sessions[0].ID).Code)
+	assert.Equal(http.StatusForbidden, serve("other@zebra", false, handleRevokeSessions(),
+		"/api/v1/sessions?user="+user.Email, "").Code)
+
+	// Admins kill all sessions of a compromised account
+	third := login()
+	rr = serve("admin@zebra", true, handleRevokeSessions(), "/api/v1/sessions?user="+user.Email, "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &sessions))
+	assert.Equal(2, len(sessions))
+	assert.Equal(http.StatusUnauthorized, authenticated(second, "/api/v1/resources"))
+	assert.Equal(http.StatusUnauthorized, authenticated(third, "/api/v1/resources"))
+
+	entries := api.Audit.Query(user.Email)
+	assert.Equal(1, len(entries))
+	assert.Equal("session.revoke.all", entries[0].Action)
+	assert.Equal("admin@zebra", entries[0].Actor)
+}
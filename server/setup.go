@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zerologr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/attachment"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/faults"
+	"github.com/project-safari/zebra/store"
+	"github.com/rs/zerolog"
+	"gojini.dev/config"
+	"gojini.dev/web"
+)
+
+func setupLogger(cfgStore *config.Store) context.Context {
+	ctx := context.Background()
+	// The level is set globally from the configuration, so that it can be
+	// changed on reload
+	zl := zerolog.New(os.Stderr).Level(zerolog.TraceLevel)
+	logger := zerologr.New(&zl)
+
+	return logr.NewContext(ctx, logger.WithName("zebra"))
+}
+
+func setupAdapter(ctx context.Context, cfgStore *config.Store) web.Adapter {
+	log := logr.FromContextOrDiscard(ctx)
+
+	storeCfg := struct {
+		Root      string `json:"rootDir"`
+		Format    string `json:"format"`
+		LazyIndex bool   `json:"lazyIndex"`
+	}{Root: "", Format: store.FormatFiles, LazyIndex: false}
+
+	if e := cfgStore.Get("store", &storeCfg); e != nil {
+		panic(e)
+	}
+
+	secretsCfg := &SecretsConfig{AuthKey: nil, CredentialsKey: nil, RetiredCredentialsKeys: nil, RefreshInterval: ""}
+	if e := cfgStore.Get("secrets", secretsCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	keyRefresh, err := secretsCfg.refreshInterval()
+	if err != nil {
+		panic(err)
+	}
+
+	authKeys, err := newAuthKeyring(ctx, secretsCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	credentials, err := newCredentialsCipher(ctx, secretsCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	authKey := "key"
+
+	// The auth key of a secret backend replaces the one of the configuration
+	if authKeys != nil {
+		authKey = authKeys.Current()
+	} else if e := cfgStore.Get("authKey", &authKey); e != nil {
+		panic(e)
+	}
+
+	factory := store.DefaultFactory()
+
+	resAPI := NewResourceAPI(factory)
+	resAPI.format = storeCfg.Format
+	resAPI.authKeys = authKeys
+	resAPI.credentials = credentials
+	resAPI.lazyIndex = storeCfg.LazyIndex
+
+	lifecycleCfg := &LifecycleConfig{Types: nil}
+	if e := cfgStore.Get("lifecycle", lifecycleCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	lifecycle, err := newLifecyclePolicy(lifecycleCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	resAPI.lifecycle = lifecycle
+
+	deleteCfg := &DeleteConfig{Default: "", Types: nil}
+	if e := cfgStore.Get("deletes", deleteCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.deletes, err = newDeletePolicy(deleteCfg); err != nil {
+		panic(err)
+	}
+
+	if e := resAPI.Initialize(storeCfg.Root); e != nil {
+		panic(e)
+	}
+
+	auditCfg := &AuditConfig{SigningKey: "", CheckpointEvery: 0, Unchained: 0}
+	if e := cfgStore.Get("audit", auditCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	auditKey, checkpointEvery, err := newAuditKey(auditCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	resAPI.auditLegacy = auditCfg.Unchained
+
+	if auditKey != nil {
+		resAPI.auditKey = auditKey
+		resAPI.Audit.SetSigner(auditKey, checkpointEvery)
+	}
+
+	attachmentCfg := &AttachmentConfig{MaxSize: 0, MaxCount: 0}
+	if e := cfgStore.Get("attachments", attachmentCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	resAPI.attachments = attachment.NewStore(path.Join(storeCfg.Root, "attachments"),
+		attachmentCfg.MaxSize, attachmentCfg.MaxCount)
+	if e := resAPI.attachments.Initialize(); e != nil {
+		panic(e)
+	}
+
+	leaseCfg := &LeaseConfig{GracePeriod: "", NoPreemption: nil, ExtensionCeiling: ""}
+	if e := cfgStore.Get("leases", leaseCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.leasePolicy, err = newLeasePolicy(leaseCfg); err != nil {
+		panic(err)
+	}
+
+	allocationCfg := &AllocationConfig{Scoring: nil}
+	if e := cfgStore.Get("allocation", allocationCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.scoring, err = newScoringPolicy(allocationCfg); err != nil {
+		panic(err)
+	}
+
+	eventCfg := &EventConfig{Retention: 0, MaxAge: ""}
+	if e := cfgStore.Get("events", eventCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if e := configureEvents(resAPI.Events, eventCfg); e != nil {
+		panic(e)
+	}
+
+	approvalCfg := &ApprovalConfig{Enabled: false, TTL: ""}
+	if e := cfgStore.Get("approvals", approvalCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	approvals, err := newApprovalGate(approvalCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	resAPI.approvals = approvals
+
+	duplicateCfg := &DuplicateConfig{Rules: nil}
+	if e := cfgStore.Get("duplicates", duplicateCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.duplicates, err = newDuplicateRules(factory, duplicateCfg); err != nil {
+		panic(err)
+	}
+
+	propagationCfg := &PropagationConfig{Rules: nil}
+	if e := cfgStore.Get("propagation", propagationCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.propagation, err = newPropagationRules(factory, propagationCfg); err != nil {
+		panic(err)
+	}
+
+	quotaCfg := &QuotaConfig{Quotas: nil}
+	if e := cfgStore.Get("quotas", quotaCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.quotas, err = newQuotas(factory, quotaCfg); err != nil {
+		panic(err)
+	}
+
+	loginCfg := &LoginConfig{MaxFailures: 0, Lockout: "", MaxLockout: "", RateLimit: 0}
+	if e := cfgStore.Get("login", loginCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.logins, err = newLoginGuard(loginCfg); err != nil {
+		panic(err)
+	}
+
+	directoryCfg := &DirectoryConfig{DisableLocal: false, LDAP: nil}
+	if e := cfgStore.Get("directory", directoryCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.directory, err = newDirectory(directoryCfg); err != nil {
+		panic(err)
+	}
+
+	validationCfg := &ValidationConfig{Hooks: nil}
+	if e := cfgStore.Get("validation", validationCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if err := resAPI.registerHooks(validationCfg); err != nil {
+		panic(err)
+	}
+
+	admissionCfg := &AdmissionConfig{Webhooks: nil}
+	if e := cfgStore.Get("admission", admissionCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.admission, err = newAdmissionController(admissionCfg); err != nil {
+		panic(err)
+	}
+
+	policyCfg := &PolicyConfig{URL: "", Package: "", Modules: nil, Timeout: "", FailurePolicy: ""}
+	if e := cfgStore.Get("policy", policyCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.policy, err = newPolicyEngine(policyCfg); err != nil {
+		panic(err)
+	}
+
+	// The policy engine may come up later, until then the failure policy
+	// decides on mutations
+	if resAPI.policy != nil {
+		if e := resAPI.policy.load(ctx, resAPI.Store); e != nil {
+			log.Error(e, "policies could not be loaded into the policy engine")
+		}
+	}
+
+	outboxCfg := &OutboxConfig{Webhooks: nil, MaxAttempts: 0, Backoff: "", MaxBackoff: ""}
+	if e := cfgStore.Get("outbox", outboxCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	resAPI.webhooks, err = newWebhookDispatcher(outboxCfg, path.Join(storeCfg.Root, "outbox.json"), resAPI.Events)
+	if err != nil {
+		panic(err)
+	}
+
+	if resAPI.webhooks != nil {
+		go resAPI.webhooks.run(ctx)
+	}
+
+	siemCfg := &SIEMConfig{Exporters: nil, MaxAttempts: 0, Backoff: "", MaxBackoff: ""}
+	if e := cfgStore.Get("siem", siemCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.siem, err = newSIEMExporter(siemCfg, storeCfg.Root, resAPI.Audit, resAPI.Events); err != nil {
+		panic(err)
+	}
+
+	if resAPI.siem != nil {
+		go resAPI.siem.run(ctx)
+	}
+
+	trapCfg := &TrapConfig{Listen: "", Community: "", MACLabel: "", Rules: nil}
+	if e := cfgStore.Get("snmp", trapCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	traps, err := newTrapReceiver(resAPI, trapCfg)
+	if err != nil {
+		panic(err)
+	}
+
+	if traps != nil {
+		conn, err := net.ListenPacket("udp", traps.listen)
+		if err != nil {
+			panic(err)
+		}
+
+		go traps.run(ctx, conn)
+	}
+
+	syslogCfg := &SyslogConfig{Listen: "", Buffer: 0, HostnameLabel: ""}
+	if e := cfgStore.Get("syslog", syslogCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.syslog, err = newSyslogReceiver(resAPI, syslogCfg); err != nil {
+		panic(err)
+	}
+
+	if resAPI.syslog != nil {
+		conn, err := net.ListenPacket("udp", resAPI.syslog.listen)
+		if err != nil {
+			panic(err)
+		}
+
+		go resAPI.syslog.run(ctx, conn)
+	}
+
+	heartbeatCfg := &HeartbeatConfig{StaleAfter: "", OfflineAfter: "", Interval: ""}
+	if e := cfgStore.Get("heartbeats", heartbeatCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if e := resAPI.heartbeats.configure(heartbeatCfg); e != nil {
+		panic(e)
+	}
+
+	go resAPI.runHeartbeats(ctx)
+
+	driftCfg := &DriftConfig{Rules: nil, Notify: nil}
+	if e := cfgStore.Get("drift", driftCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if e := resAPI.drift.configure(driftCfg); e != nil {
+		panic(e)
+	}
+
+	syncCfg := &SyncConfig{Site: "", Resolution: ""}
+	if e := cfgStore.Get("sync", syncCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if e := resAPI.sync.configure(syncCfg); e != nil {
+		panic(e)
+	}
+
+	provisioningCfg := &ProvisioningConfig{Hooks: nil}
+	if e := cfgStore.Get("provisioning", provisioningCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.provisioner, err = newProvisioner(provisioningCfg); err != nil {
+		panic(err)
+	}
+
+	cloudCfg := &CloudConfig{Accounts: nil}
+	if e := cfgStore.Get("cloud", cloudCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.cloud, err = newCloudAccounts(cloudCfg); err != nil {
+		panic(err)
+	}
+
+	namingCfg := &NamingConfig{Rules: nil, Site: "", Registry: nil}
+	if e := cfgStore.Get("naming", namingCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.naming, err = newNaming(factory, namingCfg); err != nil {
+		panic(err)
+	}
+
+	federationCfg := &FederationConfig{Sites: nil, Timeout: "", CheckInterval: ""}
+	if e := cfgStore.Get("federation", federationCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.federation, err = newFederation(federationCfg); err != nil {
+		panic(err)
+	}
+
+	if resAPI.federation != nil {
+		go resAPI.federation.run(ctx)
+	}
+
+	faultCfg := &FaultConfig{
+		Enabled: false,
+		Faults:  faults.Config{Latency: "", Jitter: "", WriteErrors: 0, DroppedEvents: 0},
+	}
+	if e := cfgStore.Get("faults", faultCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.faults, err = newFaultInjector(faultCfg); err != nil {
+		panic(err)
+	}
+
+	readOnlyCfg := &ReadOnlyConfig{Enabled: false, Reason: ""}
+	if e := cfgStore.Get("readOnly", readOnlyCfg); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	resAPI.readOnly = newReadOnlyMode(readOnlyCfg)
+
+	jobCfgs := []JobConfig{}
+	if e := cfgStore.Get("jobs", &jobCfgs); e != nil && !errors.Is(e, config.ErrNotFound) {
+		panic(e)
+	}
+
+	if resAPI.jobs, err = newScheduler(resAPI, jobCfgs); err != nil {
+		panic(err)
+	}
+
+	resAPI.jobs.Start(ctx)
+
+	if authKeys != nil || credentials != nil {
+		go resAPI.watchKeys(ctx, keyRefresh)
+	}
+
+	log.Info("zebra store initialized")
+
+	if e := initAdminUser(log, resAPI.Store, cfgStore); e != nil {
+		panic(e)
+	}
+
+	return contextAdapter(ctx, resAPI, authKey)
+}
+
+// contextAdapter puts the logger of the context, the auth key and the API in
+// the context of the requests.
+func contextAdapter(ctx context.Context, resAPI *ResourceAPI, authKey string) web.Adapter {
+	log := logr.FromContextOrDiscard(ctx)
+
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if nextHandler == nil {
+				panic("setup MUST have a next handler")
+			}
+
+			// Create a new request with logger in its context.
+			reqCtx := logr.NewContext(req.Context(), log)
+			reqCtx = context.WithValue(reqCtx, AuthCtxKey, authKey)
+			reqCtx = context.WithValue(reqCtx, ResourcesCtxKey, resAPI)
+
+			newReq := req.Clone(reqCtx)
+
+			// Call the next handler in the chain with the request with logger
+			nextHandler.ServeHTTP(res, newReq)
+		})
+	}
+}
+
+func initAdminUser(log logr.Logger, store zebra.Store, cfgStore *config.Store) error {
+	user := new(auth.User)
+
+	if err := cfgStore.Get("admin", user); err != nil {
+		return err
+	}
+
+	if findUser(store, user.Email) == nil {
+		log.Info("creating admin user")
+
+		return store.Create(user)
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra/metrics"
+	"gojini.dev/web"
+)
+
+// Defaults of load shedding.
+const (
+	DefaultQueueTimeout = time.Second
+	DefaultRetryAfter   = time.Second
+	HeapSampleInterval  = 100 * time.Millisecond
+)
+
+// Reasons for shedding a request.
+const (
+	ShedMemory = "memory"
+	ShedQueue  = "queue"
+)
+
+var ErrLoadConfig = errors.New("load limits must not be negative and their durations valid")
+
+var (
+	requestsInFlight = metrics.Default.Gauge("zebra_http_requests_in_flight",
+		"Requests being served.")
+	requestsQueued = metrics.Default.Gauge("zebra_http_requests_queued",
+		"Requests waiting for a slot to be served in.")
+	requestsShed = metrics.Default.Counter("zebra_http_requests_shed_total",
+		"Requests answered with 503 to protect the server, by reason.", "reason")
+)
+
+// LoadConfig limits the load the server takes on. MaxInFlight requests are
+// served at once, up to MaxQueue more wait for up to QueueTimeout to be
+// served. Requests are shed while the heap is over MaxHeapMB, so that query
+// storms can not drive the server out of memory. Shed requests are answered
+// with 503 and a Retry-After of RetryAfter. Zero limits are no limits.
+type LoadConfig struct {
+	MaxInFlight  int    `json:"maxInFlight"`
+	MaxQueue     int    `json:"maxQueue"`
+	QueueTimeout string `json:"queueTimeout"`
+	MaxHeapMB    int    `json:"maxHeapMB"`
+	RetryAfter   string `json:"retryAfter"`
+}
+
+// loadShedder enforces the LoadConfig.
+type loadShedder struct {
+	slots        chan struct{}
+	maxQueue     int
+	queueTimeout time.Duration
+	maxHeap      uint64
+	retryAfter   time.Duration
+
+	lock    sync.Mutex
+	queued  int
+	sampled time.Time
+	heap    uint64
+	// heapSize reads the size of the heap, it is replaced in tests
+	heapSize func() uint64
+}
+
+func parseLoadDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, ErrLoadConfig
+	}
+
+	return d, nil
+}
+
+func newLoadShedder(cfg *LoadConfig) (*loadShedder, error) {
+	if cfg.MaxInFlight < 0 || cfg.MaxQueue < 0 || cfg.MaxHeapMB < 0 {
+		return nil, ErrLoadConfig
+	}
+
+	queueTimeout, err := parseLoadDuration(cfg.QueueTimeout, DefaultQueueTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := parseLoadDuration(cfg.RetryAfter, DefaultRetryAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &loadShedder{
+		slots:        nil,
+		maxQueue:     cfg.MaxQueue,
+		queueTimeout: queueTimeout,
+		maxHeap:      uint64(cfg.MaxHeapMB) << 20, //nolint:gomnd
+		retryAfter:   retry,
+		lock:         sync.Mutex{},
+		queued:       0,
+		sampled:      time.Time{},
+		heap:         0,
+		heapSize:     heapSize,
+	}
+
+	if cfg.MaxInFlight > 0 {
+		s.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	return s, nil
+}
+
+// heapSize returns the bytes of the live and not yet collected objects.
+func heapSize() uint64 {
+	stats := new(runtime.MemStats)
+	runtime.ReadMemStats(stats)
+
+	return stats.HeapAlloc
+}
+
+// overHeap tells if the heap is over the limit. Reading the size of the heap
+// stops the world, so it is read at most once per HeapSampleInterval.
+func (s *loadShedder) overHeap(now time.Time) bool {
+	if s.maxHeap == 0 {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if now.Sub(s.sampled) >= HeapSampleInterval {
+		s.heap = s.heapSize()
+		s.sampled = now
+	}
+
+	return s.heap > s.maxHeap
+}
+
+// enqueue takes a place in the queue, if there is one left.
+func (s *loadShedder) enqueue() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.queued >= s.maxQueue {
+		return false
+	}
+
+	s.queued++
+	requestsQueued.Set(float64(s.queued))
+
+	return true
+}
+
+func (s *loadShedder) dequeue() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queued--
+	requestsQueued.Set(float64(s.queued))
+}
+
+// acquire takes a slot to serve a request in, waiting in the queue for one
+// to be released if all are taken.
+func (s *loadShedder) acquire(req *http.Request) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if !s.enqueue() {
+		return false
+	}
+
+	defer s.dequeue()
+
+	timer := time.NewTimer(s.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func (s *loadShedder) release() {
+	<-s.slots
+}
+
+func (s *loadShedder) shed(res http.ResponseWriter, req *http.Request, reason string) {
+	requestsShed.Inc(reason)
+	logr.FromContextOrDiscard(req.Context()).Info("request shed", "reason", reason,
+		"method", req.Method, "path", req.URL.Path)
+
+	retryAfter(res, s.retryAfter)
+	http.Error(res, "server overloaded, retry later", http.StatusServiceUnavailable)
+}
+
+// shedAdapter sheds requests beyond the load limits with 503 before any work
+// is done for them.
+func shedAdapter(s *loadShedder) web.Adapter {
+	return func(nextHandler http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if s.overHeap(time.Now()) {
+				s.shed(res, req, ShedMemory)
+
+				return
+			}
+
+			if s.slots == nil {
+				callNext(nextHandler, res, req)
+
+				return
+			}
+
+			if !s.acquire(req) {
+				s.shed(res, req, ShedQueue)
+
+				return
+			}
+
+			defer s.release()
+
+			requestsInFlight.Add(1)
+			defer requestsInFlight.Add(-1)
+
+			callNext(nextHandler, res, req)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoadShedder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := newLoadShedder(&LoadConfig{MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 0, RetryAfter: ""})
+	assert.Nil(err)
+	assert.Nil(s.slots)
+	assert.Equal(DefaultQueueTimeout, s.queueTimeout)
+	assert.Equal(DefaultRetryAfter, s.retryAfter)
+	assert.False(s.overHeap(time.Now()))
+
+	s, err = newLoadShedder(&LoadConfig{MaxInFlight: 2, MaxQueue: 1, QueueTimeout: "5s", MaxHeapMB: 1, RetryAfter: "3s"})
+	assert.Nil(err)
+	assert.Equal(2, cap(s.slots))
+	assert.Equal(5*time.Second, s.queueTimeout)
+	assert.Equal(uint64(1<<20), s.maxHeap)
+
+	for _, cfg := range []LoadConfig{
+		{MaxInFlight: -1, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 0, RetryAfter: ""},
+		{MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "soon", MaxHeapMB: 0, RetryAfter: ""},
+		{MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 0, RetryAfter: "-1s"},
+	} {
+		cfg := cfg
+		_, err = newLoadShedder(&cfg)
+		assert.ErrorIs(err, ErrLoadConfig)
+	}
+}
+
+func TestShedMemory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := newLoadShedder(&LoadConfig{MaxInFlight: 0, MaxQueue: 0, QueueTimeout: "", MaxHeapMB: 1, RetryAfter: "2s"})
+	assert.Nil(err)
+
+	heap := uint64(2 << 20)
+	s.heapSize = func() uint64 { return heap }
+
+	handler := shedAdapter(s)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/resources", nil))
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.Equal("2", rr.Header().Get("Retry-After"))
+
+	// The heap is sampled again once the interval has passed
+	heap = 1 << 10
+	assert.True(s.overHeap(time.Now()))
+	assert.False(s.overHeap(time.Now().Add(HeapSampleInterval)))
+}
+
+func TestShedQueue(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := newLoadShedder(&LoadConfig{
+		MaxInFlight: 1, MaxQueue: 1, QueueTimeout: "50ms", MaxHeapMB: 0, RetryAfter: "",
+	})
+	assert.Nil(err)
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	handler := shedAdapter(s)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-done
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(ctx context.Context, path string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+
+		return rr.Code
+	}
+
+	codes := make(chan int)
+
+	go func() { codes <- serve(context.Background(), "/slow") }()
+
+	<-started
+
+	// The queued request is served once the slot is released
+	go func() { codes <- serve(context.Background(), "/") }()
+
+	assert.Eventually(func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		return s.queued == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full
+	assert.Equal(http.StatusServiceUnavailable, serve(context.Background(), "/"))
+
+	close(done)
+	assert.Equal(http.StatusOK, <-codes)
+	assert.Equal(http.StatusOK, <-codes)
+
+	// Requests wait in the queue for the timeout at most
+	done = make(chan struct{})
+
+	go func() { codes <- serve(context.Background(), "/slow") }()
+
+	<-started
+	assert.Equal(http.StatusServiceUnavailable, serve(context.Background(), "/"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(http.StatusServiceUnavailable, serve(ctx, "/"))
+
+	close(done)
+	assert.Equal(http.StatusOK, <-codes)
+}
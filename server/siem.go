@@ -0,0 +1,556 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/outbox"
+	"github.com/project-safari/zebra/siem"
+)
+
+const DefaultSIEMTimeout = 10 * time.Second
+
+var (
+	ErrSIEMName   = errors.New("siem exporter name must be set and unique")
+	ErrSIEMURL    = errors.New("siem url must be an udp, tcp, http or https url")
+	ErrSIEMFormat = errors.New("siem format must be cef, leef or json")
+	ErrSIEMFilter = errors.New("invalid siem filter")
+	ErrSIEMStatus = errors.New("siem responded with an error status")
+)
+
+var siemDeliveries = metrics.Default.Counter("zebra_siem_deliveries_total",
+	"SIEM delivery attempts, by exporter and result.", "exporter", "result")
+
+// SIEMExporter ships audit entries and events to a SIEM. The URL is either a
+// syslog receiver, such as "udp://siem:514" or "tcp://siem:601", or an http
+// collector the messages are posted to. Messages are CEF unless the format
+// is leef or json.
+//
+// All audit entries are shipped unless Actions is set, events only if Events
+// is set. Actions and Events are glob patterns such as "auth.*", Types limits
+// the events to those of resources of the types. Records below MinSeverity,
+// from 0 to 10, are not shipped.
+type SIEMExporter struct {
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	Format      string   `json:"format,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	Actions     []string `json:"actions,omitempty"`
+	Events      []string `json:"events,omitempty"`
+	Types       []string `json:"types,omitempty"`
+	MinSeverity int      `json:"minSeverity,omitempty"`
+}
+
+// SIEMConfig is the SIEM export of the server configuration. Records are
+// delivered through an outbox like webhook events, with the same retries.
+type SIEMConfig struct {
+	Exporters   []SIEMExporter `json:"exporters"`
+	MaxAttempts int            `json:"maxAttempts,omitempty"`
+	Backoff     string         `json:"backoff,omitempty"`
+	MaxBackoff  string         `json:"maxBackoff,omitempty"`
+}
+
+// siemTarget is a validated exporter.
+type siemTarget struct {
+	SIEMExporter
+	url *url.URL
+}
+
+func (t *siemTarget) matchesAudit(e audit.Entry, severity int) bool {
+	return severity >= t.MinSeverity && (len(t.Actions) == 0 || matchAny(t.Actions, e.Action))
+}
+
+func (t *siemTarget) matchesEvent(e events.Event, severity int) bool {
+	return severity >= t.MinSeverity && matchAny(t.Events, e.Type) && (len(t.Types) == 0 || zebra.IsIn(e.Kind, t.Types))
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// auditSeverity rates the audit entry for security operations, failed
+// authentication and a tampered audit log being the most severe.
+func auditSeverity(e audit.Entry) int {
+	switch {
+	case e.Action == "audit.verify" && e.Detail != "valid":
+		return 9 //nolint:gomnd
+	case e.Action == "auth.lockout":
+		return 8 //nolint:gomnd
+	case strings.HasPrefix(e.Action, "auth."):
+		return 6 //nolint:gomnd
+	case e.Impersonator != "", strings.HasPrefix(e.Action, "session."), strings.HasPrefix(e.Action, "secrets."):
+		return 5 //nolint:gomnd
+	case e.Action == audit.ActionCheckpoint:
+		return 1
+	}
+
+	return 3 //nolint:gomnd
+}
+
+// eventSeverity rates the resource event, deletes being more severe than
+// other changes.
+func eventSeverity(e events.Event) int {
+	if e.Type == events.Deleted || e.Type == events.HostOffline {
+		return 4 //nolint:gomnd
+	}
+
+	return 2 //nolint:gomnd
+}
+
+func auditRecord(e audit.Entry) siem.Record {
+	return siem.Record{
+		Time:         e.Time,
+		Class:        siem.ClassAudit,
+		Name:         e.Action,
+		Severity:     auditSeverity(e),
+		Actor:        e.Actor,
+		ActorType:    e.ActorType,
+		Impersonator: e.Impersonator,
+		Resource:     e.Resource,
+		Kind:         "",
+		Detail:       e.Detail,
+	}
+}
+
+func eventRecord(e events.Event) siem.Record {
+	return siem.Record{
+		Time:         e.Time,
+		Class:        siem.ClassEvent,
+		Name:         e.Type,
+		Severity:     eventSeverity(e),
+		Actor:        e.Actor,
+		ActorType:    "",
+		Impersonator: "",
+		Resource:     e.Resource,
+		Kind:         e.Kind,
+		Detail:       "",
+	}
+}
+
+// siemExporter enqueues audit entries and events in outboxes, one for each
+// source as an outbox follows a single cursor, and delivers them to the
+// SIEMs.
+type siemExporter struct {
+	audit    *audit.Log
+	events   *events.Log
+	auditBox *outbox.Outbox
+	eventBox *outbox.Outbox
+	targets  map[string]*siemTarget
+	client   *http.Client
+	timeout  time.Duration
+	hostname string
+}
+
+// newSIEMExporter returns an exporter for the configured SIEMs with its
+// outboxes stored in the root directory, or nil if there are no exporters.
+func newSIEMExporter(cfg *SIEMConfig, root string, auditLog *audit.Log, eventLog *events.Log,
+) (*siemExporter, error) {
+	if len(cfg.Exporters) == 0 {
+		return nil, nil
+	}
+
+	targets, err := siemTargets(cfg.Exporters)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes := make([]*outbox.Outbox, 2) //nolint:gomnd
+
+	for i, name := range []string{"siem-audit.json", "siem-events.json"} {
+		file := ""
+		if root != "" {
+			file = path.Join(root, name)
+		}
+
+		if boxes[i], err = newOutbox(file, cfg.MaxAttempts, cfg.Backoff, cfg.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+
+	// New outboxes start with the records to come, not the recorded ones
+	cursors := []uint64{uint64(len(auditLog.Entries())), eventLog.Latest()}
+
+	for i, box := range boxes {
+		if box.Cursor() == 0 {
+			if _, err := box.Enqueue(cursors[i], nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &siemExporter{
+		audit:    auditLog,
+		events:   eventLog,
+		auditBox: boxes[0],
+		eventBox: boxes[1],
+		targets:  targets,
+		client:   &http.Client{Timeout: DefaultSIEMTimeout},
+		timeout:  DefaultSIEMTimeout,
+		hostname: hostname,
+	}, nil
+}
+
+func siemTargets(exporters []SIEMExporter) (map[string]*siemTarget, error) {
+	targets := make(map[string]*siemTarget, len(exporters))
+
+	for _, exporter := range exporters {
+		if _, ok := targets[exporter.Name]; ok || exporter.Name == "" {
+			return nil, ErrSIEMName
+		}
+
+		u, err := url.Parse(exporter.URL)
+		if err != nil || u.Host == "" || !zebra.IsIn(u.Scheme, []string{"udp", "tcp", "http", "https"}) {
+			return nil, fmt.Errorf("siem %s: %w", exporter.Name, ErrSIEMURL)
+		}
+
+		if exporter.Format == "" {
+			exporter.Format = siem.FormatCEF
+		}
+
+		if !siem.ValidFormat(exporter.Format) {
+			return nil, fmt.Errorf("siem %s: %w", exporter.Name, ErrSIEMFormat)
+		}
+
+		for _, pattern := range append(append([]string{}, exporter.Actions...), exporter.Events...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("siem %s: %w: %s", exporter.Name, ErrSIEMFilter, pattern)
+			}
+		}
+
+		if exporter.MinSeverity < 0 || exporter.MinSeverity > siem.MaxSeverity {
+			return nil, fmt.Errorf("siem %s: %w: minSeverity", exporter.Name, ErrSIEMFilter)
+		}
+
+		targets[exporter.Name] = &siemTarget{SIEMExporter: exporter, url: u}
+	}
+
+	return targets, nil
+}
+
+// deliveries returns a delivery of the record to each target it matches, the
+// key of the record is its source and position.
+func (x *siemExporter) deliveries(key string, r siem.Record, matches func(*siemTarget) bool, now time.Time,
+) ([]outbox.Delivery, error) {
+	deliveries := []outbox.Delivery{}
+
+	for name, target := range x.targets {
+		if !matches(target) {
+			continue
+		}
+
+		payload, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, outbox.NewDelivery(name+"/"+key, name, payload, now))
+	}
+
+	return deliveries, nil
+}
+
+// enqueue adds the deliveries of the audit entries and events since the
+// cursors of the outboxes and returns the number of deliveries added.
+func (x *siemExporter) enqueue(now time.Time) (int, error) {
+	added, err := x.enqueueAudit(now)
+	if err != nil {
+		return added, err
+	}
+
+	enqueued, err := x.enqueueEvents(now)
+
+	return added + enqueued, err
+}
+
+func (x *siemExporter) enqueueAudit(now time.Time) (int, error) {
+	added := 0
+
+	for {
+		start := x.auditBox.Cursor()
+
+		page, cursor := x.audit.Since(start, outboxPageSize)
+		if len(page) == 0 {
+			return added, nil
+		}
+
+		deliveries := []outbox.Delivery{}
+
+		for i, e := range page {
+			e := e
+			r := auditRecord(e)
+			key := "audit/" + strconv.FormatUint(start+uint64(i)+1, 10)
+
+			matched, err := x.deliveries(key, r, func(t *siemTarget) bool { return t.matchesAudit(e, r.Severity) }, now)
+			if err != nil {
+				return added, err
+			}
+
+			deliveries = append(deliveries, matched...)
+		}
+
+		enqueued, err := x.auditBox.Enqueue(cursor, deliveries)
+		added += len(enqueued)
+
+		if err != nil {
+			return added, err
+		}
+	}
+}
+
+func (x *siemExporter) enqueueEvents(now time.Time) (int, error) {
+	added := 0
+
+	for {
+		page, cursor, err := x.events.Since(x.eventBox.Cursor(), outboxPageSize)
+		if errors.Is(err, events.ErrCursorExpired) {
+			page, cursor, err = x.events.Since(0, outboxPageSize)
+		}
+
+		if err != nil {
+			return added, err
+		}
+
+		if len(page) == 0 {
+			return added, nil
+		}
+
+		deliveries := []outbox.Delivery{}
+
+		for _, e := range page {
+			e := e
+			r := eventRecord(e)
+			key := "event/" + strconv.FormatUint(e.Seq, 10)
+
+			matched, err := x.deliveries(key, r, func(t *siemTarget) bool { return t.matchesEvent(e, r.Severity) }, now)
+			if err != nil {
+				return added, err
+			}
+
+			deliveries = append(deliveries, matched...)
+		}
+
+		enqueued, err := x.eventBox.Enqueue(cursor, deliveries)
+		added += len(enqueued)
+
+		if err != nil {
+			return added, err
+		}
+	}
+}
+
+// send delivers the record to its SIEM. Syslog over udp can not be
+// acknowledged, a record is delivered once it was sent. Over tcp and http a
+// record is delivered once the SIEM accepted it, so it may be received twice
+// if the acknowledgement is lost.
+func (x *siemExporter) send(ctx context.Context, delivery outbox.Delivery) error {
+	target, ok := x.targets[delivery.Target]
+	if !ok {
+		return ErrUnknownTarget
+	}
+
+	r := siem.Record{}
+	if err := json.Unmarshal(delivery.Payload, &r); err != nil {
+		return err
+	}
+
+	msg, err := siem.Format(target.Format, version, r)
+	if err != nil {
+		return err
+	}
+
+	if target.url.Scheme == "udp" || target.url.Scheme == "tcp" {
+		return x.sendSyslog(ctx, target, siem.Syslog(x.hostname, r, msg))
+	}
+
+	return x.post(ctx, target, delivery.ID, msg)
+}
+
+func (x *siemExporter) sendSyslog(ctx context.Context, target *siemTarget, msg []byte) error {
+	dialer := &net.Dialer{Timeout: x.timeout}
+
+	conn, err := dialer.DialContext(ctx, target.url.Scheme, target.url.Host)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(x.timeout)); err != nil {
+		return err
+	}
+
+	// Messages over tcp are framed by their length, as of RFC 6587
+	if target.url.Scheme == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	_, err = conn.Write(msg)
+
+	return err
+}
+
+func (x *siemExporter) post(ctx context.Context, target *siemTarget, id string, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+
+	if target.Format == siem.FormatJSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+
+	req.Header.Set(DeliveryHeader, id)
+
+	if target.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(target.Secret, msg))
+	}
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrSIEMStatus, resp.Status)
+	}
+
+	return nil
+}
+
+// dispatch attempts the due deliveries of both outboxes.
+func (x *siemExporter) dispatch(ctx context.Context, now time.Time) {
+	dispatchOutbox(ctx, x.auditBox, now, x.send, siemDeliveries)
+	dispatchOutbox(ctx, x.eventBox, now, x.send, siemDeliveries)
+}
+
+// run enqueues and dispatches deliveries as events are appended, and audit
+// entries and retries are due, until the context is done.
+func (x *siemExporter) run(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx)
+	ticker := time.NewTicker(outboxPollInterval)
+
+	defer ticker.Stop()
+
+	for {
+		changed := x.events.Changed()
+
+		if _, err := x.enqueue(time.Now()); err != nil {
+			log.Error(err, "failed to enqueue siem deliveries")
+		}
+
+		x.dispatch(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// find returns the outbox holding the delivery with the ID.
+func (x *siemExporter) find(id string) (*outbox.Outbox, error) {
+	for _, box := range []*outbox.Outbox{x.auditBox, x.eventBox} {
+		if _, err := box.Get(id); err == nil {
+			return box, nil
+		}
+	}
+
+	return nil, outbox.ErrNotFound
+}
+
+func siemContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	switch {
+	case !apiOK || !claimsOK:
+		res.WriteHeader(http.StatusInternalServerError)
+	case api.siem == nil:
+		res.WriteHeader(http.StatusNotFound)
+	case !claims.Write(AdminKey):
+		res.WriteHeader(http.StatusForbidden)
+	default:
+		return api, true
+	}
+
+	return nil, false
+}
+
+// handleSIEMOutbox lists the SIEM deliveries, optionally only those with the
+// status given in the query, admins only.
+func handleSIEMOutbox() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, ok := siemContext(res, req)
+		if !ok {
+			return
+		}
+
+		status := outbox.Status(req.URL.Query().Get("status"))
+		deliveries := append(api.siem.auditBox.List(status), api.siem.eventBox.List(status)...)
+
+		writeJSON(req.Context(), res, deliveries)
+	}
+}
+
+// handleSIEMRequeue makes a failed or dead-lettered SIEM delivery due again.
+func handleSIEMRequeue() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := siemContext(res, req)
+		if !ok {
+			return
+		}
+
+		box, err := api.siem.find(params.ByName("id"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		delivery, err := box.Requeue(params.ByName("id"), time.Now())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		}
+
+		api.recordAudit(ctx, "siem.requeue", delivery.ID, delivery.Target)
+
+		writeJSON(ctx, res, delivery)
+	}
+}
@@ -0,0 +1,194 @@
+package server //nolint:testpackage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/audit"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/outbox"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSIEMExporter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	auditLog := audit.NewLog("")
+	eventLog := events.NewLog("", 0, 0)
+
+	x, err := newSIEMExporter(&SIEMConfig{Exporters: nil}, "", auditLog, eventLog)
+	assert.Nil(err)
+	assert.Nil(x)
+
+	for _, exporters := range [][]SIEMExporter{
+		{{Name: "", URL: "udp://siem:514"}},
+		{{Name: "a", URL: "udp://siem:514"}, {Name: "a", URL: "udp://siem:514"}},
+		{{Name: "a", URL: "siem:514"}},
+		{{Name: "a", URL: "ftp://siem"}},
+		{{Name: "a", URL: "udp://siem:514", Format: "xml"}},
+		{{Name: "a", URL: "udp://siem:514", Actions: []string{"auth.["}}},
+		{{Name: "a", URL: "udp://siem:514", MinSeverity: 11}},
+	} {
+		_, err := newSIEMExporter(&SIEMConfig{Exporters: exporters}, "", auditLog, eventLog)
+		assert.NotNil(err)
+	}
+
+	_, err = newSIEMExporter(&SIEMConfig{
+		Exporters: []SIEMExporter{{Name: "a", URL: "udp://siem:514"}}, Backoff: "soon",
+	}, "", auditLog, eventLog)
+	assert.NotNil(err)
+}
+
+// collector is an http SIEM collector.
+type collector struct {
+	lock     sync.Mutex
+	fail     bool
+	messages []string
+}
+
+func (c *collector) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.fail {
+		res.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	c.messages = append(c.messages, string(body))
+}
+
+func (c *collector) received() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string{}, c.messages...)
+}
+
+func TestSIEMExporter(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	coll := &collector{lock: sync.Mutex{}, fail: true, messages: nil}
+	server := httptest.NewServer(coll)
+
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	defer listener.Close()
+
+	syslogs := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+			syslogs <- line
+		}
+	}()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	lab := makeOwnedLab("user@zebra")
+
+	// Entries before the exporter existed are not shipped
+	api.recordSystemAudit("resource.apply", lab.ID, "Lab")
+
+	cfg := &SIEMConfig{
+		Exporters: []SIEMExporter{
+			{Name: "auth", URL: "tcp://" + listener.Addr().String(), Actions: []string{"auth.*"}},
+			{Name: "all", URL: server.URL, Format: "json", Events: []string{"*"}, MinSeverity: 3},
+		},
+		MaxAttempts: 2, Backoff: "1ms", MaxBackoff: "1ms",
+	}
+
+	x, err := newSIEMExporter(cfg, "", api.Audit, api.Events)
+	assert.Nil(err)
+
+	api.recordSystemAudit("auth.lockout", "user@zebra", "5 failed logins")
+	api.recordSystemAudit("resource.apply", lab.ID, "Lab")
+
+	for _, eventType := range []string{events.Updated, events.Deleted} {
+		e, err := events.NewEvent(eventType, lab, "user@zebra")
+		assert.Nil(err)
+
+		_, err = api.Events.Append(e)
+		assert.Nil(err)
+	}
+
+	// The lockout goes to both, the apply to all, only the delete is severe
+	// enough to be shipped of the events
+	added, err := x.enqueue(time.Now())
+	assert.Nil(err)
+	assert.Equal(4, added)
+
+	added, err = x.enqueue(time.Now())
+	assert.Nil(err)
+	assert.Equal(0, added)
+
+	ctx := context.Background()
+	x.dispatch(ctx, time.Now())
+
+	msg := <-syslogs
+	assert.Regexp(`^[0-9]+ <107>1 `, msg)
+	assert.Contains(msg, "CEF:0|project-safari|zebra|")
+	assert.Contains(msg, "|auth.lockout|audit auth.lockout|8|")
+
+	time.Sleep(2 * time.Millisecond)
+	x.dispatch(ctx, time.Now())
+
+	dead := append(x.auditBox.List(outbox.Dead), x.eventBox.List(outbox.Dead)...)
+	assert.Equal(3, len(dead))
+
+	// Dead deliveries are requeued once the collector is back
+	coll.lock.Lock()
+	coll.fail = false
+	coll.lock.Unlock()
+
+	for _, d := range dead {
+		box, err := x.find(d.ID)
+		assert.Nil(err)
+
+		_, err = box.Requeue(d.ID, time.Now())
+		assert.Nil(err)
+	}
+
+	x.dispatch(ctx, time.Now())
+
+	received := coll.received()
+	assert.Equal(3, len(received))
+
+	names := []string{}
+
+	for _, m := range received {
+		r := struct {
+			Name string `json:"name"`
+		}{}
+		assert.Nil(json.Unmarshal([]byte(m), &r))
+
+		names = append(names, r.Name)
+	}
+
+	assert.ElementsMatch([]string{"auth.lockout", "resource.apply", events.Deleted}, names)
+
+	_, err = x.find("missing")
+	assert.ErrorIs(err, outbox.ErrNotFound)
+}
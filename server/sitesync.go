@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/sitesync"
+)
+
+// SyncCtxKey marks the changes made by a sync import, so that they are
+// recorded as changes of the site they were imported from.
+const SyncCtxKey = CtxKey("sync")
+
+var (
+	ErrSyncAdmin    = errors.New("site sync requires admin privileges")
+	ErrSyncDisabled = errors.New("site sync is not configured")
+	ErrSyncSince    = errors.New("since must be an RFC 3339 time")
+	ErrSyncBundle   = errors.New("sync bundle is of this site")
+	ErrSyncID       = errors.New("sync change does not match the ID of its resource")
+)
+
+// SyncConfig is the sync section of the server configuration: the name of
+// the site, unique among the servers that sync, and how conflicting changes
+// are resolved, lww or vector. Changes are only tracked once the site is set.
+type SyncConfig struct {
+	Site       string `json:"site"`
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// siteSync tracks the changes of the resources of the site for export, and
+// imports the changes of other sites.
+type siteSync struct {
+	state      *sitesync.State
+	site       string
+	resolution string
+}
+
+func newSiteSync(path string) *siteSync {
+	return &siteSync{state: sitesync.NewState(path), site: "", resolution: sitesync.LastWriterWins}
+}
+
+func (s *siteSync) load() error {
+	return s.state.Initialize()
+}
+
+// configure sets the site and the resolution of the configuration.
+func (s *siteSync) configure(cfg *SyncConfig) error {
+	switch cfg.Resolution {
+	case "":
+		cfg.Resolution = sitesync.LastWriterWins
+	case sitesync.LastWriterWins, sitesync.VectorClocks:
+	default:
+		return sitesync.ErrResolution
+	}
+
+	s.site, s.resolution = cfg.Site, cfg.Resolution
+
+	return nil
+}
+
+// track records the change of the resource by this site, unless sync is not
+// configured or the change is imported from another site.
+func (api *ResourceAPI) track(ctx context.Context, res zebra.Resource, deleted bool) error {
+	if api.sync.site == "" || ctx.Value(SyncCtxKey) != nil {
+		return nil
+	}
+
+	_, err := api.sync.state.Changed(api.sync.site, res.GetID(), res.GetType(), deleted, time.Now())
+
+	return err
+}
+
+// exportChanges returns the bundle of the changes since the time. The first
+// export, since the zero time, also holds the resources that were not
+// changed since sync was configured.
+func (api *ResourceAPI) exportChanges(since time.Time) (*sitesync.Bundle, error) {
+	bundle := &sitesync.Bundle{Site: api.sync.site, Since: since, Exported: time.Now(), Changes: []sitesync.Change{}}
+	tracked := map[string]bool{}
+
+	for _, e := range api.sync.state.Since(since) {
+		tracked[e.ID] = true
+		change := sitesync.Change{Entry: e, Data: nil}
+
+		if !e.Deleted {
+			res := findResource(api.Store, e.ID)
+			if res == nil {
+				continue
+			}
+
+			data, err := json.Marshal(res)
+			if err != nil {
+				return nil, err
+			}
+
+			change.Data = data
+		}
+
+		bundle.Changes = append(bundle.Changes, change)
+	}
+
+	if !since.IsZero() {
+		return bundle, nil
+	}
+
+	err := applyFunc(api.Store.Query(), func(res zebra.Resource) error {
+		if _, ok := api.sync.state.Get(res.GetID()); ok || tracked[res.GetID()] {
+			return nil
+		}
+
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		bundle.Changes = append(bundle.Changes, sitesync.Change{
+			Entry: sitesync.Entry{
+				ID: res.GetID(), Type: res.GetType(), Site: api.sync.site, Modified: time.Time{},
+				Deleted: false, Clock: nil,
+			},
+			Data: data,
+		})
+
+		return nil
+	})
+
+	return bundle, err
+}
+
+// importChanges applies the changes of the bundle that win over the local
+// changes of their resources and reports the conflicts. A dry run only
+// reports what would be applied.
+func (api *ResourceAPI) importChanges(ctx context.Context, bundle *sitesync.Bundle, dryRun bool) *sitesync.Report {
+	report := sitesync.NewReport(bundle, api.sync.resolution, dryRun)
+	decoder := zebra.NewDecoder(api.factory)
+	ctx = context.WithValue(ctx, SyncCtxKey, bundle.Site)
+
+	for _, change := range bundle.Changes {
+		local, found := api.sync.state.Get(change.ID)
+		decision := sitesync.Decide(api.sync.resolution, local, found, change, bundle.Since)
+
+		if decision.Conflict != nil {
+			report.Conflicts = append(report.Conflicts, *decision.Conflict)
+		}
+
+		if !decision.Apply {
+			report.Skipped = append(report.Skipped, change.ID)
+
+			continue
+		}
+
+		if !dryRun {
+			if err := api.applyChange(ctx, decoder, change); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", change.ID, err))
+
+				continue
+			}
+		}
+
+		report.Applied = append(report.Applied, change.ID)
+	}
+
+	return report
+}
+
+// applyChange makes the change to the store and records it as the last
+// change of the resource.
+func (api *ResourceAPI) applyChange(ctx context.Context, decoder *zebra.Decoder, change sitesync.Change) error {
+	if change.Deleted {
+		if res := findResource(api.Store, change.ID); res != nil {
+			if err := api.delete(ctx, res); err != nil {
+				return err
+			}
+		}
+	} else {
+		res, err := decoder.Decode(change.Data)
+		if err != nil {
+			return err
+		}
+
+		if res.GetID() != change.ID {
+			return ErrSyncID
+		}
+
+		if err := res.Validate(ctx); err != nil {
+			return err
+		}
+
+		if err := api.create(ctx, res); err != nil {
+			return err
+		}
+	}
+
+	return api.sync.state.Imported(change.Entry)
+}
+
+// syncContext returns the api and checks that the user is an admin and
+// sync is configured.
+func syncContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	if !claims.Write(AdminKey) {
+		http.Error(res, ErrSyncAdmin.Error(), http.StatusForbidden)
+
+		return nil, false
+	}
+
+	if api.sync.site == "" {
+		http.Error(res, ErrSyncDisabled.Error(), http.StatusNotFound)
+
+		return nil, false
+	}
+
+	return api, true
+}
+
+// handleSyncExport exports the changes of the site since the time given as
+// the since parameter, all of its resources if there is none.
+func handleSyncExport() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := syncContext(res, req)
+		if !ok {
+			return
+		}
+
+		since := time.Time{}
+
+		if s := req.URL.Query().Get("since"); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				http.Error(res, ErrSyncSince.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			since = t
+		}
+
+		bundle, err := api.exportChanges(since)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "sync.export", api.sync.site, fmt.Sprintf("%d changes", len(bundle.Changes)))
+		writeJSON(ctx, res, bundle)
+	}
+}
+
+// handleSyncImport imports the bundle of another site and returns the
+// report of the changes applied, skipped and in conflict.
+func handleSyncImport() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, ok := syncContext(res, req)
+		if !ok {
+			return
+		}
+
+		bundle := new(sitesync.Bundle)
+		if err := readJSON(ctx, req, bundle); err != nil || bundle.Site == "" {
+			http.Error(res, sitesync.ErrSite.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if bundle.Site == api.sync.site {
+			http.Error(res, ErrSyncBundle.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		report := api.importChanges(ctx, bundle, isDryRun(req))
+
+		log.Info("sync bundle imported", "site", bundle.Site, "applied", len(report.Applied),
+			"conflicts", len(report.Conflicts), "dryRun", report.DryRun)
+
+		if !report.DryRun {
+			api.recordAudit(ctx, "sync.import", bundle.Site, fmt.Sprintf("%d applied, %d skipped, %d conflicts",
+				len(report.Applied), len(report.Skipped), len(report.Conflicts)))
+		}
+
+		writeJSON(ctx, res, report)
+	}
+}
@@ -0,0 +1,155 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/sitesync"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func syncRequest(api *ResourceAPI, claims *auth.Claims, target string, body interface{},
+) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	rr := httptest.NewRecorder()
+
+	if body == nil {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		handleSyncExport()(rr, req.WithContext(ctx), nil)
+
+		return rr
+	}
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	handleSyncImport()(rr, req.WithContext(ctx), nil)
+
+	return rr
+}
+
+func TestSyncConfig(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := newSiteSync("")
+	assert.Nil(s.configure(&SyncConfig{Site: "lab", Resolution: ""}))
+	assert.Equal(sitesync.LastWriterWins, s.resolution)
+	assert.Nil(s.configure(&SyncConfig{Site: "lab", Resolution: sitesync.VectorClocks}))
+	assert.Equal(sitesync.VectorClocks, s.resolution)
+	assert.ErrorIs(s.configure(&SyncConfig{Site: "lab", Resolution: "first"}), sitesync.ErrResolution)
+}
+
+func TestSiteSync(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	roots := []string{"test_sync_central", "test_sync_lab"}
+
+	t.Cleanup(func() {
+		for _, root := range roots {
+			os.RemoveAll(root)
+		}
+	})
+
+	central := NewResourceAPI(store.DefaultFactory())
+	lab := NewResourceAPI(store.DefaultFactory())
+
+	for i, api := range []*ResourceAPI{central, lab} {
+		assert.Nil(api.Initialize(roots[i]))
+	}
+
+	ctx := context.Background()
+	all, _ := auth.NewPriv("", true, true, true, true)
+	admin := auth.NewClaims("zebra", "admin", &auth.Role{Name: "admin", Privileges: []*auth.Priv{all}}, "admin@zebra")
+	user := auth.NewClaims("zebra", "user", DefaultRole(), "user@zebra")
+
+	// Resources that predate sync are part of the first export
+	shared := dc.NewLab("shared", zebra.Labels{"system.group": "labs"})
+	assert.Nil(central.create(ctx, shared))
+	assert.Equal(http.StatusNotFound, syncRequest(central, admin, "/api/v1/sync/export", nil).Code)
+
+	assert.Nil(central.sync.configure(&SyncConfig{Site: "central", Resolution: ""}))
+	assert.Nil(lab.sync.configure(&SyncConfig{Site: "lab", Resolution: ""}))
+	assert.Equal(http.StatusForbidden, syncRequest(central, user, "/api/v1/sync/export", nil).Code)
+
+	rr := syncRequest(central, admin, "/api/v1/sync/export", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	bundle := new(sitesync.Bundle)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), bundle))
+	assert.Equal(1, len(bundle.Changes))
+
+	rr = syncRequest(lab, admin, "/api/v1/sync/import", bundle)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotNil(findResource(lab.Store, shared.ID))
+
+	synced := time.Now()
+
+	// While disconnected, both change the shared lab and the lab adds one
+	time.Sleep(10 * time.Millisecond)
+
+	shared.Labels["owner"] = "central"
+	assert.Nil(central.create(ctx, shared))
+
+	labShared, _ := findResource(lab.Store, shared.ID).(*dc.Lab)
+	labShared.Labels["owner"] = "lab"
+	assert.Nil(lab.create(ctx, labShared))
+
+	added := dc.NewLab("added", zebra.Labels{"system.group": "labs"})
+	assert.Nil(lab.create(ctx, added))
+
+	rr = syncRequest(lab, admin, "/api/v1/sync/export?since="+synced.Format(time.RFC3339Nano), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	bundle = new(sitesync.Bundle)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), bundle))
+	assert.Equal(2, len(bundle.Changes))
+
+	// A dry run changes nothing
+	rr = syncRequest(central, admin, "/api/v1/sync/import?dryRun=true", bundle)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(findResource(central.Store, added.ID))
+
+	rr = syncRequest(central, admin, "/api/v1/sync/import", bundle)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	report := new(sitesync.Report)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), report))
+	assert.Equal(2, len(report.Applied))
+	assert.Equal(1, len(report.Conflicts))
+	assert.Equal(sitesync.Remote, report.Conflicts[0].Winner)
+	assert.Equal(shared.ID, report.Conflicts[0].ID)
+
+	assert.NotNil(findResource(central.Store, added.ID))
+	assert.Equal("lab", findResource(central.Store, shared.ID).GetLabels()["owner"])
+
+	// Imported changes are not sent back as changes of the importing site
+	rr = syncRequest(central, admin, "/api/v1/sync/export?since="+synced.Format(time.RFC3339Nano), nil)
+	bundle = new(sitesync.Bundle)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), bundle))
+
+	for _, change := range bundle.Changes {
+		assert.Equal("lab", change.Site)
+	}
+
+	rr = syncRequest(lab, admin, "/api/v1/sync/import", bundle)
+	report = new(sitesync.Report)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), report))
+	assert.Equal(0, len(report.Applied))
+	assert.Equal(0, len(report.Conflicts))
+
+	// Bundles of the site itself are refused
+	bundle.Site = "lab"
+	assert.Equal(http.StatusBadRequest, syncRequest(lab, admin, "/api/v1/sync/import", bundle).Code)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/suggest"
+)
+
+// Limits of the suggestions of a request.
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 50
+)
+
+// Kinds of suggestions: resource names, label keys and key=value pairs.
+const (
+	SuggestName  = "name"
+	SuggestLabel = "label"
+)
+
+var ErrSuggestRequest = errors.New("suggestions need a prefix, a limit of up to 50 and kinds name or label")
+
+// Suggestion is a completion of the prefix of a suggest request. Name
+// suggestions are of a resource, label suggestions count the resources of
+// the requested types with the label.
+type Suggestion struct {
+	Kind  string `json:"kind"`
+	Text  string `json:"text"`
+	Type  string `json:"type,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+// indexedResource is what the suggest index holds of a resource.
+type indexedResource struct {
+	Type   string       `json:"type"`
+	Name   string       `json:"name"`
+	Labels zebra.Labels `json:"labels"`
+}
+
+// suggestIndex keeps the names and labels of the resources in tries. It is
+// built from the store on first use, and then catches up with the changes
+// from the events before every lookup, so that lookups never scan the store.
+type suggestIndex struct {
+	lock      sync.Mutex
+	built     bool
+	cursor    uint64
+	names     *suggest.Trie
+	labels    *suggest.Trie
+	resources map[string]indexedResource
+}
+
+func newSuggestIndex() *suggestIndex {
+	return &suggestIndex{
+		lock:      sync.Mutex{},
+		built:     false,
+		cursor:    0,
+		names:     suggest.NewTrie(),
+		labels:    suggest.NewTrie(),
+		resources: map[string]indexedResource{},
+	}
+}
+
+// labelTerms returns the terms of the labels: the keys and the key=value
+// pairs.
+func labelTerms(labels zebra.Labels) []string {
+	terms := make([]string, 0, 2*len(labels))
+
+	for k, v := range labels {
+		terms = append(terms, k, k+"="+v)
+	}
+
+	return terms
+}
+
+func (idx *suggestIndex) add(id string, r indexedResource) {
+	idx.remove(id)
+
+	idx.resources[id] = r
+	idx.names.Add(r.Name, id)
+
+	for _, term := range labelTerms(r.Labels) {
+		idx.labels.Add(term, id)
+	}
+}
+
+func (idx *suggestIndex) remove(id string) {
+	r, ok := idx.resources[id]
+	if !ok {
+		return
+	}
+
+	delete(idx.resources, id)
+	idx.names.Remove(r.Name, id)
+
+	for _, term := range labelTerms(r.Labels) {
+		idx.labels.Remove(term, id)
+	}
+}
+
+// refresh builds the index or applies the events since it was last
+// refreshed. The index is built again if the events were dropped.
+func (idx *suggestIndex) refresh(api *ResourceAPI) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if idx.built {
+		changes, cursor, err := api.Events.Since(idx.cursor, 0)
+		if err == nil {
+			idx.apply(changes)
+			idx.cursor = cursor
+
+			return
+		}
+	}
+
+	// Changes made while the store is read are applied again
+	idx.cursor = api.Events.Latest()
+	idx.names = suggest.NewTrie()
+	idx.labels = suggest.NewTrie()
+	idx.resources = map[string]indexedResource{}
+	idx.built = true
+
+	_ = applyFunc(api.view(), func(r zebra.Resource) error {
+		if data, err := json.Marshal(r); err == nil {
+			idx.index(r.GetID(), r.GetType(), data)
+		}
+
+		return nil
+	})
+}
+
+// index adds the resource of the JSON data. Resources are read from their
+// JSON, as they appear in the events, since names are fields of the types.
+func (idx *suggestIndex) index(id string, resType string, data json.RawMessage) {
+	r := indexedResource{Type: resType, Name: "", Labels: nil}
+	if len(data) == 0 || json.Unmarshal(data, &r) != nil {
+		return
+	}
+
+	idx.add(id, r)
+}
+
+func (idx *suggestIndex) apply(changes []events.Event) {
+	for _, e := range changes {
+		if e.Type == events.Deleted || e.Type == events.Archived {
+			idx.remove(e.Resource)
+
+			continue
+		}
+
+		idx.index(e.Resource, e.Kind, e.Data)
+	}
+}
+
+// suggestRequest is the query of a suggest request.
+type suggestRequest struct {
+	prefix string
+	types  map[string]bool
+	names  bool
+	labels bool
+	limit  int
+}
+
+func parseSuggestRequest(req *http.Request) (suggestRequest, error) {
+	query := req.URL.Query()
+	sr := suggestRequest{
+		prefix: query.Get("prefix"), types: map[string]bool{}, names: true, labels: true, limit: DefaultSuggestLimit,
+	}
+
+	if sr.prefix == "" {
+		return sr, ErrSuggestRequest
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > MaxSuggestLimit {
+			return sr, ErrSuggestRequest
+		}
+
+		sr.limit = limit
+	}
+
+	for _, t := range strings.FieldsFunc(query.Get("types"), func(r rune) bool { return r == ',' }) {
+		sr.types[t] = true
+	}
+
+	if kinds := query.Get("kinds"); kinds != "" {
+		sr.names, sr.labels = false, false
+
+		for _, k := range strings.Split(kinds, ",") {
+			switch k {
+			case SuggestName:
+				sr.names = true
+			case SuggestLabel:
+				sr.labels = true
+			default:
+				return sr, ErrSuggestRequest
+			}
+		}
+	}
+
+	return sr, nil
+}
+
+// lookup returns the suggestions of the request the claims may see, names
+// first. Only resources the claims may read are suggested, labels are
+// suggested as the labels endpoint lists them.
+func (idx *suggestIndex) lookup(claims *auth.Claims, sr suggestRequest) []Suggestion {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	found := []Suggestion{}
+	scoped := func(r indexedResource) bool { return len(sr.types) == 0 || sr.types[r.Type] }
+
+	if sr.names {
+		idx.names.Walk(sr.prefix, func(term string, ids []string) bool {
+			for _, id := range ids {
+				r := idx.resources[id]
+				if scoped(r) && claims.Allows(auth.ActionRead, r.Type, r.Labels) {
+					found = append(found, Suggestion{Kind: SuggestName, Text: r.Name, Type: r.Type, ID: id, Count: 0})
+				}
+
+				if len(found) == sr.limit {
+					return false
+				}
+			}
+
+			return true
+		})
+	}
+
+	if sr.labels && len(found) < sr.limit {
+		idx.labels.Walk(sr.prefix, func(term string, ids []string) bool {
+			count := 0
+
+			for _, id := range ids {
+				if scoped(idx.resources[id]) {
+					count++
+				}
+			}
+
+			if count != 0 {
+				found = append(found, Suggestion{Kind: SuggestLabel, Text: term, Type: "", ID: "", Count: count})
+			}
+
+			return len(found) < sr.limit
+		})
+	}
+
+	return found
+}
+
+// handleSuggest returns completions of the prefix query parameter for a
+// search box: names of resources and labels, closest first. The types
+// parameter, a comma separated list, scopes the suggestions to resources of
+// those types, the kinds parameter to names or labels.
+func handleSuggest() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		sr, err := parseSuggestRequest(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		api.suggestions.refresh(api)
+
+		writeJSON(ctx, res, api.suggestions.lookup(claims, sr))
+	}
+}
@@ -0,0 +1,91 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggest(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	admin := makeClaims(assert, "admin@zebra", true)
+	ctx := context.WithValue(context.Background(), ClaimsCtxKey, admin)
+
+	rack1 := dc.NewRack("rack-1", "r1", zebra.Labels{"system.group": "g", "rack": "a"})
+	rack2 := dc.NewRack("Rack-2", "r2", zebra.Labels{"system.group": "g"})
+	lab := dc.NewLab("rack-lab", zebra.Labels{"system.group": "g"})
+
+	assert.Nil(api.create(ctx, rack1))
+	assert.Nil(api.Store.Create(rack2))
+
+	suggest := func(claims *auth.Claims, query string) (int, []Suggestion) {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest("GET", "/api/v1/suggest?"+query, nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handleSuggest()(rr, req, nil)
+
+		found := []Suggestion{}
+		if rr.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(rr.Body.Bytes(), &found))
+		}
+
+		return rr.Code, found
+	}
+
+	texts := func(found []Suggestion) []string {
+		t := []string{}
+		for _, s := range found {
+			t = append(t, s.Kind+":"+s.Text)
+		}
+
+		return t
+	}
+
+	for _, bad := range []string{"", "prefix=r&limit=0", "prefix=r&limit=51", "prefix=r&kinds=vm"} {
+		code, _ := suggest(admin, bad)
+		assert.Equal(http.StatusBadRequest, code, bad)
+	}
+
+	// The index is built from the store
+	code, found := suggest(admin, "prefix=RA")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{"name:rack-1", "name:Rack-2", "label:rack", "label:rack=a"}, texts(found))
+	assert.Equal(rack1.ID, found[0].ID)
+	assert.Equal("Rack", found[0].Type)
+	assert.Equal(1, found[2].Count)
+
+	// and then follows the events
+	assert.Nil(api.create(ctx, lab))
+	assert.Nil(api.delete(ctx, rack1))
+
+	_, found = suggest(admin, "prefix=rack&limit=2")
+	assert.Equal([]string{"name:Rack-2", "name:rack-lab"}, texts(found))
+
+	_, found = suggest(admin, "prefix=rack&types=Lab&kinds=name,label")
+	assert.Equal([]string{"name:rack-lab"}, texts(found))
+
+	_, found = suggest(admin, "prefix=system.group=&kinds=label")
+	assert.Equal([]string{"label:system.group=g"}, texts(found))
+	assert.Equal(2, found[0].Count)
+
+	// Only readable resources are suggested
+	reader := auth.NewClaims("zebra", "reader@zebra", &auth.Role{Name: "none", Privileges: nil}, "reader@zebra")
+
+	_, found = suggest(reader, "prefix=rack&kinds=name")
+	assert.Empty(found)
+}
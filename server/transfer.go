@@ -0,0 +1,355 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/notify"
+)
+
+// Transfer actions, a transfer is offered by the current owner of a resource
+// and then accepted (or declined) by the new owner. The offer can be
+// cancelled by the current owner until it is accepted.
+const (
+	TransferOffer   = "offer"
+	TransferAccept  = "accept"
+	TransferDecline = "decline"
+	TransferCancel  = "cancel"
+)
+
+var (
+	ErrTransferAction = errors.New("invalid transfer action")
+	ErrTransferTo     = errors.New("transfer recipient is invalid")
+	ErrNoTransfer     = errors.New("no pending transfer for resource")
+	ErrNotOwner       = errors.New("user is not allowed to transfer the resource")
+	ErrTransferStale  = errors.New("resource changed hands since the transfer was offered")
+)
+
+type TransferRequest struct {
+	Action string `json:"action"`
+	To     string `json:"to,omitempty"`
+}
+
+// Transfer is a pending ownership handoff of a resource.
+type Transfer struct {
+	ResourceID string    `json:"resourceId"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Offered    time.Time `json:"offered"`
+}
+
+func (tr *TransferRequest) Validate(ctx context.Context) error {
+	switch tr.Action {
+	case TransferOffer:
+		if tr.To == "" {
+			return ErrTransferTo
+		}
+	case TransferAccept, TransferDecline, TransferCancel:
+	default:
+		return ErrTransferAction
+	}
+
+	return nil
+}
+
+// transferList keeps track of pending transfers, at most one per resource.
+type transferList struct {
+	lock      sync.Mutex
+	transfers map[string]*Transfer
+}
+
+func newTransferList() *transferList {
+	return &transferList{
+		lock:      sync.Mutex{},
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+func (t *transferList) offer(transfer *Transfer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.transfers[transfer.ResourceID] = transfer
+}
+
+func (t *transferList) get(resID string) *Transfer {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.transfers[resID]
+}
+
+func (t *transferList) remove(resID string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.transfers, resID)
+}
+
+// findResource returns the resource with the given ID, or nil if it does not
+// exist in the store.
+func findResource(store zebra.Store, resID string) zebra.Resource {
+	resMap := store.QueryUUID([]string{resID})
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			return res
+		}
+	}
+
+	return nil
+}
+
+// copyResource returns a copy of the resource, stored resources are shared
+// with the readers and are changed through copies only.
+func copyResource(factory zebra.ResourceFactory, res zebra.Resource) (zebra.Resource, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+
+	return zebra.NewDecoder(factory).Decode(data)
+}
+
+// resourceOwner returns the user currently holding the resource.
+func resourceOwner(res zebra.Resource) string {
+	if status := res.GetStatus(); status != nil {
+		return status.UsedBy
+	}
+
+	return ""
+}
+
+func handleTransfer() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		tr := new(TransferRequest)
+		if err := readJSON(ctx, req, tr); err != nil || tr.Validate(ctx) != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			log.Info("transfer failed, invalid request")
+
+			return
+		}
+
+		resource := findResource(api.Store, params.ByName("id"))
+		if resource == nil || resource.GetStatus() == nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		var (
+			status int
+			err    error
+			result interface{}
+		)
+
+		if isDryRun(req) {
+			result, err := dryRunTransfer(api, claims, resource, tr)
+			if err != nil {
+				res.WriteHeader(http.StatusConflict)
+
+				return
+			}
+
+			writeJSON(ctx, res, result)
+
+			return
+		}
+
+		switch tr.Action {
+		case TransferOffer:
+			result, err = offerTransfer(ctx, api, claims, resource, tr.To)
+			status = http.StatusForbidden
+		case TransferAccept:
+			result, err = acceptTransfer(ctx, api, claims, resource)
+			status = http.StatusConflict
+		default:
+			result, err = cancelTransfer(ctx, api, claims, resource, tr.Action)
+			status = http.StatusConflict
+		}
+
+		if err != nil {
+			res.WriteHeader(status)
+			log.Info("transfer failed", "resource", resource.GetID(), "action", tr.Action, "error", err.Error())
+
+			return
+		}
+
+		log.Info("transfer succeeded", "resource", resource.GetID(), "action", tr.Action)
+
+		writeJSON(ctx, res, result)
+	}
+}
+
+func offerTransfer(ctx context.Context, api *ResourceAPI, claims *auth.Claims, res zebra.Resource,
+	to string,
+) (*Transfer, error) {
+	from := resourceOwner(res)
+
+	// Only the current owner may give away a resource, unless the user has
+	// full privileges on this type of resource.
+	if claims.Email != from && !claims.Write(res.GetType()) {
+		return nil, ErrNotOwner
+	}
+
+	if to == from {
+		return nil, ErrTransferTo
+	}
+
+	transfer := &Transfer{
+		ResourceID: res.GetID(),
+		From:       from,
+		To:         to,
+		Offered:    time.Now(),
+	}
+
+	api.transfers.offer(transfer)
+
+	recordTransfer(ctx, api, TransferOffer, transfer)
+	_ = api.Inbox.Notify(notify.NewNotification(to, "transfer offered",
+		from+" offered to transfer "+res.GetID()+" to you", res.GetID()))
+
+	return transfer, nil
+}
+
+func acceptTransfer(ctx context.Context, api *ResourceAPI, claims *auth.Claims,
+	res zebra.Resource,
+) (zebra.Resource, error) {
+	transfer := api.transfers.get(res.GetID())
+	if transfer == nil || transfer.To != claims.Email {
+		return nil, ErrNoTransfer
+	}
+
+	// The offer is void if the resource changed hands since
+	if resourceOwner(res) != transfer.From {
+		api.transfers.remove(res.GetID())
+
+		return nil, ErrTransferStale
+	}
+
+	// The stored resource is shared with the readers, a copy changes hands
+	res, err := copyResource(api.factory, res)
+	if err != nil {
+		return nil, err
+	}
+
+	res.GetStatus().UsedBy = transfer.To
+
+	if err := api.create(ctx, res); err != nil {
+		return nil, err
+	}
+
+	api.transfers.remove(res.GetID())
+
+	recordTransfer(ctx, api, TransferAccept, transfer)
+	_ = api.Inbox.Notify(notify.NewNotification(transfer.From, "transfer accepted",
+		transfer.To+" accepted the transfer of "+res.GetID(), res.GetID()))
+
+	return res, nil
+}
+
+func cancelTransfer(ctx context.Context, api *ResourceAPI, claims *auth.Claims, res zebra.Resource,
+	action string,
+) (*Transfer, error) {
+	transfer := api.transfers.get(res.GetID())
+	if transfer == nil {
+		return nil, ErrNoTransfer
+	}
+
+	// The sender can cancel and the recipient can decline an offer.
+	if (action == TransferCancel && transfer.From != claims.Email) ||
+		(action == TransferDecline && transfer.To != claims.Email) {
+		return nil, ErrNoTransfer
+	}
+
+	api.transfers.remove(res.GetID())
+
+	recordTransfer(ctx, api, action, transfer)
+
+	notifyUser, verb := transfer.To, "cancelled"
+	if action == TransferDecline {
+		notifyUser, verb = transfer.From, "declined"
+	}
+
+	_ = api.Inbox.Notify(notify.NewNotification(notifyUser, "transfer "+verb,
+		claims.Email+" "+verb+" the transfer of "+res.GetID(), res.GetID()))
+
+	return transfer, nil
+}
+
+// dryRunTransfer checks if the transfer action would be allowed and returns
+// the transfer that would result from it, without changing any state.
+func dryRunTransfer(api *ResourceAPI, claims *auth.Claims, res zebra.Resource, tr *TransferRequest) (*Transfer, error) {
+	if tr.Action == TransferOffer {
+		from := resourceOwner(res)
+		if (claims.Email != from && !claims.Write(res.GetType())) || tr.To == from {
+			return nil, ErrNotOwner
+		}
+
+		return &Transfer{ResourceID: res.GetID(), From: from, To: tr.To, Offered: time.Now()}, nil
+	}
+
+	transfer := api.transfers.get(res.GetID())
+
+	switch {
+	case transfer == nil:
+		return nil, ErrNoTransfer
+	case (tr.Action == TransferAccept || tr.Action == TransferDecline) && transfer.To != claims.Email:
+		return nil, ErrNoTransfer
+	case tr.Action == TransferCancel && transfer.From != claims.Email:
+		return nil, ErrNoTransfer
+	}
+
+	return transfer, nil
+}
+
+func recordTransfer(ctx context.Context, api *ResourceAPI, action string, transfer *Transfer) {
+	api.recordAudit(ctx, "transfer."+action, transfer.ResourceID, transfer.From+" -> "+transfer.To)
+}
+
+func handleNotifications() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		writeJSON(ctx, res, api.Inbox.List(claims.Email))
+	}
+}
@@ -0,0 +1,250 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeClaims(assert *assert.Assertions, email string, admin bool) *auth.Claims {
+	priv, err := auth.NewPriv("", admin, true, admin, admin)
+	assert.Nil(err)
+
+	return auth.NewClaims("zebra", email, &auth.Role{Name: "user", Privileges: []*auth.Priv{priv}}, email)
+}
+
+func makeOwnedLab(owner string) *dc.Lab {
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	lab.Status.UsedBy = owner
+
+	return lab
+}
+
+func transferRequest(assert *assert.Assertions, api *ResourceAPI, claims *auth.Claims,
+	resID string, body string,
+) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	if claims != nil {
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+	}
+
+	// resID may carry query parameters for the request
+	resID, query, _ := strings.Cut(resID, "?")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "/api/v1/resources/"+resID+"/transfer?"+query, nil)
+	assert.Nil(err)
+
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+
+	rr := httptest.NewRecorder()
+	handleTransfer()(rr, req, httprouter.Params{{Key: "id", Value: resID}})
+
+	return rr
+}
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_transfer"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.Store.Create(lab))
+
+	alice := makeClaims(assert, "alice@zebra", false)
+	bob := makeClaims(assert, "bob@zebra", false)
+
+	// Bob does not own the lab
+	rr := transferRequest(assert, api, bob, lab.ID, `{"action":"offer","to":"carol@zebra"}`)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	// Nothing to accept yet
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"accept"}`)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	// Dry run does not create an offer
+	rr = transferRequest(assert, api, alice, lab.ID+"?dryRun=true", `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(api.transfers.get(lab.ID))
+	assert.Empty(api.Inbox.List("bob@zebra"))
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(1, len(api.Inbox.List("bob@zebra")))
+
+	rr = transferRequest(assert, api, bob, lab.ID+"?dryRun=true", `{"action":"accept"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("alice@zebra", resourceOwner(findResource(api.Store, lab.ID)))
+
+	rr = transferRequest(assert, api, alice, lab.ID+"?dryRun=true", `{"action":"accept"}`)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	// Only bob can accept
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"accept"}`)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"accept"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("bob@zebra", resourceOwner(findResource(api.Store, lab.ID)))
+	assert.Equal(1, len(api.Inbox.List("alice@zebra")))
+	assert.Equal(2, len(api.Audit.Query(lab.ID)))
+}
+
+func TestStaleTransfer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.Store.Create(lab))
+
+	alice := makeClaims(assert, "alice@zebra", false)
+	bob := makeClaims(assert, "bob@zebra", false)
+	carol := makeClaims(assert, "carol@zebra", false)
+
+	rr := transferRequest(assert, api, alice, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	// The lab changed hands after the offer, which can not be accepted then
+	stored := findResource(api.Store, lab.ID)
+	moved := makeOwnedLab("carol@zebra")
+	moved.ID = lab.ID
+	assert.Nil(api.Store.Create(moved))
+
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"accept"}`)
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Equal("carol@zebra", resourceOwner(findResource(api.Store, lab.ID)))
+	assert.Nil(api.transfers.get(lab.ID))
+
+	// The accepted copy changes hands, not the resource the readers hold
+	rr = transferRequest(assert, api, carol, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	held := findResource(api.Store, lab.ID)
+
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"accept"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("carol@zebra", resourceOwner(held))
+	assert.Equal("alice@zebra", resourceOwner(stored))
+	assert.Equal("bob@zebra", resourceOwner(findResource(api.Store, lab.ID)))
+}
+
+func TestCancelTransfer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_cancel_transfer"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.Store.Create(lab))
+
+	alice := makeClaims(assert, "alice@zebra", false)
+	bob := makeClaims(assert, "bob@zebra", false)
+	admin := makeClaims(assert, "admin@zebra", true)
+
+	// Admin can offer on behalf of the owner
+	rr := transferRequest(assert, api, admin, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	// Bob cannot cancel, only decline
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"cancel"}`)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	rr = transferRequest(assert, api, bob, lab.ID, `{"action":"decline"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(api.transfers.get(lab.ID))
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"cancel"}`)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("alice@zebra", resourceOwner(findResource(api.Store, lab.ID)))
+}
+
+func TestBadTransfer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "test_bad_transfer"
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	lab := makeOwnedLab("alice@zebra")
+	assert.Nil(api.Store.Create(lab))
+
+	alice := makeClaims(assert, "alice@zebra", false)
+
+	rr := transferRequest(assert, api, nil, lab.ID, `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusUnauthorized, rr.Code)
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"steal"}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"offer"}`)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = transferRequest(assert, api, alice, lab.ID, `{"action":"offer","to":"alice@zebra"}`)
+	assert.Equal(http.StatusForbidden, rr.Code)
+
+	rr = transferRequest(assert, api, alice, "doesnotexist", `{"action":"offer","to":"bob@zebra"}`)
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
+
+func TestNotifications(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	h := handleNotifications()
+
+	req, err := http.NewRequestWithContext(context.Background(), "GET", "/api/v1/notifications", nil)
+	assert.Nil(err)
+
+	rr := httptest.NewRecorder()
+	h(rr, req, nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+
+	ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+	req, err = http.NewRequestWithContext(ctx, "GET", "/api/v1/notifications", nil)
+	assert.Nil(err)
+
+	rr = httptest.NewRecorder()
+	h(rr, req, nil)
+	assert.Equal(http.StatusUnauthorized, rr.Code)
+
+	ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "alice@zebra", false))
+	req, err = http.NewRequestWithContext(ctx, "GET", "/api/v1/notifications", nil)
+	assert.Nil(err)
+
+	rr = httptest.NewRecorder()
+	h(rr, req, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+}
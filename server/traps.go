@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/snmp"
+)
+
+// DefaultMACLabel is the label holding the MAC address of a resource, by
+// which traps are matched to resources next to their IP addresses.
+const DefaultMACLabel = "mac"
+
+// maxTrapSize is the largest trap message read, UDP datagrams are smaller.
+const maxTrapSize = 65535
+
+var (
+	ErrTrapRule      = errors.New("trap rules need a trap and a fault, state or labels to set")
+	ErrTrapCommunity = errors.New("trap community does not match")
+)
+
+var snmpTraps = metrics.Default.Counter("zebra_snmp_traps_total",
+	"SNMP traps received, by trap and result.", "trap", "result")
+
+// TrapRule sets the fault, state and labels of the resources a trap comes
+// from. The trap is a standard trap name such as linkDown or an OID.
+type TrapRule struct {
+	Trap   string            `json:"trap"`
+	Fault  string            `json:"fault,omitempty"`
+	State  string            `json:"state,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// TrapConfig is the SNMP trap listener of the server configuration. Traps
+// are received on the UDP address Listen, such as ":162", and only accepted
+// with the community if one is set. Without rules, link traps set the link
+// label and the fault of the resources.
+type TrapConfig struct {
+	Listen    string     `json:"listen"`
+	Community string     `json:"community,omitempty"`
+	MACLabel  string     `json:"macLabel,omitempty"`
+	Rules     []TrapRule `json:"rules,omitempty"`
+}
+
+func defaultTrapRules() []TrapRule {
+	return []TrapRule{
+		{Trap: "linkDown", Fault: "major", State: "", Labels: map[string]string{"link": "down"}},
+		{Trap: "linkUp", Fault: "none", State: "", Labels: map[string]string{"link": "up"}},
+	}
+}
+
+// trapAction is a parsed trap rule.
+type trapAction struct {
+	fault  *zebra.Fault
+	state  *zebra.State
+	labels map[string]string
+}
+
+// trapReceiver updates the resources that traps come from by the rules.
+type trapReceiver struct {
+	api       *ResourceAPI
+	listen    string
+	community string
+	macLabel  string
+	actions   map[string]trapAction
+}
+
+// newTrapReceiver returns a receiver for the configuration, or nil if traps
+// are not listened for.
+func newTrapReceiver(api *ResourceAPI, cfg *TrapConfig) (*trapReceiver, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = defaultTrapRules()
+	}
+
+	actions := make(map[string]trapAction, len(rules))
+
+	for _, rule := range rules {
+		action, err := newTrapAction(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		actions[snmp.OID(rule.Trap)] = action
+	}
+
+	macLabel := cfg.MACLabel
+	if macLabel == "" {
+		macLabel = DefaultMACLabel
+	}
+
+	return &trapReceiver{
+		api:       api,
+		listen:    cfg.Listen,
+		community: cfg.Community,
+		macLabel:  macLabel,
+		actions:   actions,
+	}, nil
+}
+
+func newTrapAction(rule TrapRule) (trapAction, error) {
+	action := trapAction{fault: nil, state: nil, labels: rule.Labels}
+
+	if rule.Trap == "" || (rule.Fault == "" && rule.State == "" && len(rule.Labels) == 0) {
+		return action, ErrTrapRule
+	}
+
+	for k := range rule.Labels {
+		if strings.HasPrefix(k, SystemLabelPrefix) {
+			return action, fmt.Errorf("%w: %s is a system label", ErrTrapRule, k)
+		}
+	}
+
+	if rule.Fault != "" {
+		action.fault = new(zebra.Fault)
+		if err := action.fault.UnmarshalText([]byte(rule.Fault)); err != nil {
+			return action, err
+		}
+	}
+
+	if rule.State != "" {
+		action.state = new(zebra.State)
+		if err := action.state.UnmarshalText([]byte(rule.State)); err != nil {
+			return action, err
+		}
+	}
+
+	return action, nil
+}
+
+// run receives traps until the context is done.
+func (r *trapReceiver) run(ctx context.Context, conn net.PacketConn) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxTrapSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error(err, "trap listener failed")
+			}
+
+			return
+		}
+
+		var src net.IP
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			src = udp.IP
+		}
+
+		if _, err := r.receive(ctx, buf[:n], src); err != nil {
+			log.Info("trap dropped", "from", addr.String(), "error", err.Error())
+		}
+	}
+}
+
+// receive applies the rule of the trap to the resources it comes from, by
+// the agent address of the trap or else the source address, and by the MAC
+// addresses in its variables. It returns the IDs of the updated resources.
+// Traps are dropped while the server is read-only.
+func (r *trapReceiver) receive(ctx context.Context, data []byte, src net.IP) ([]string, error) {
+	trap, err := snmp.Parse(data)
+	if err != nil {
+		snmpTraps.Inc("", "invalid")
+
+		return nil, err
+	}
+
+	name := snmp.Name(trap.OID)
+
+	if r.community != "" && trap.Community != r.community {
+		snmpTraps.Inc(name, "invalid")
+
+		return nil, ErrTrapCommunity
+	}
+
+	action, ok := r.actions[trap.OID]
+	if !ok {
+		snmpTraps.Inc(name, "ignored")
+
+		return nil, nil
+	}
+
+	if r.api.readOnly.enabled() {
+		snmpTraps.Inc(name, "read-only")
+
+		return nil, nil
+	}
+
+	agent := trap.Agent
+	if agent == nil {
+		agent = src
+	}
+
+	resources := r.sources(agent, trap.MACs())
+	if len(resources) == 0 {
+		snmpTraps.Inc(name, "unmatched")
+
+		return nil, nil
+	}
+
+	decoder := zebra.NewDecoder(r.api.factory)
+	ids := make([]string, 0, len(resources))
+
+	for _, res := range resources {
+		updated, err := patchLabels(decoder, res, action.labels)
+		if err != nil {
+			return ids, err
+		}
+
+		if status := updated.GetStatus(); status != nil {
+			if action.fault != nil {
+				status.Fault = *action.fault
+			}
+
+			if action.state != nil {
+				status.State = *action.state
+			}
+		}
+
+		if err := r.api.create(ctx, updated); err != nil {
+			return ids, err
+		}
+
+		r.api.recordSystemAudit("snmp.trap", res.GetID(), fmt.Sprintf("%s from %s", name, agent))
+		ids = append(ids, res.GetID())
+	}
+
+	snmpTraps.Inc(name, "matched")
+
+	return ids, nil
+}
+
+// sources returns the resources with the IP address, or with one of the
+// MAC addresses in the MAC label.
+func (r *trapReceiver) sources(ip net.IP, macs []string) []zebra.Resource {
+	found := []zebra.Resource{}
+
+	_ = applyFunc(r.api.view(), func(res zebra.Resource) error {
+		if mac := res.GetLabels()[r.macLabel]; mac != "" {
+			for _, m := range macs {
+				if strings.EqualFold(mac, m) {
+					found = append(found, res)
+
+					return nil
+				}
+			}
+		}
+
+		if ip == nil {
+			return nil
+		}
+
+		for _, resIP := range resourceIPs(res) {
+			if resIP.Equal(ip) {
+				found = append(found, res)
+
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return found
+}
@@ -0,0 +1,162 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/snmp"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTrapReceiver(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+
+	r, err := newTrapReceiver(api, &TrapConfig{Listen: "", Community: "", MACLabel: "", Rules: nil})
+	assert.Nil(err)
+	assert.Nil(r)
+
+	r, err = newTrapReceiver(api, &TrapConfig{Listen: ":0", Community: "", MACLabel: "", Rules: nil})
+	assert.Nil(err)
+	assert.Equal(DefaultMACLabel, r.macLabel)
+	assert.Contains(r.actions, snmp.OIDLinkDown)
+
+	for _, rule := range []TrapRule{
+		{Trap: "", Fault: "major"},
+		{Trap: "linkDown"},
+		{Trap: "linkDown", Fault: "dire"},
+		{Trap: "linkDown", State: "asleep"},
+		{Trap: "linkDown", Labels: map[string]string{"system.group": "down"}},
+	} {
+		_, err := newTrapReceiver(api, &TrapConfig{Listen: ":0", Rules: []TrapRule{rule}})
+		assert.NotNil(err)
+	}
+}
+
+func TestTrapReceiver(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	psu := "1.3.6.1.4.1.9.9.117.2.0.2"
+
+	r, err := newTrapReceiver(api, &TrapConfig{
+		Listen: ":0", Community: "lab", MACLabel: "",
+		Rules: append(defaultTrapRules(), TrapRule{Trap: psu, Fault: "critical", State: "inactive", Labels: nil}),
+	})
+	assert.Nil(err)
+
+	server := compute.NewServer([]string{"serial", "model", "server"}, net.ParseIP("10.1.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs", "mac": "00:1B:21:3A:4F:10"})
+
+	trap := func(oid string, community string, variables ...snmp.Variable) []byte {
+		data, err := (&snmp.Trap{
+			Version: snmp.Version2c, Community: community, OID: oid, Agent: nil, Variables: variables,
+		}).Marshal()
+		assert.Nil(err)
+
+		return data
+	}
+
+	ctx := context.Background()
+
+	assert.Nil(api.create(ctx, server))
+	assert.Nil(api.create(ctx, lab))
+
+	ids, err := r.receive(ctx, trap(snmp.OIDLinkDown, "lab"), net.ParseIP("10.1.0.1"))
+	assert.Nil(err)
+	assert.Equal([]string{server.ID}, ids)
+
+	updated := findResource(api.Store, server.ID)
+	assert.Equal("down", updated.GetLabels()["link"])
+	assert.Equal(zebra.Major, updated.GetStatus().Fault)
+	latest, _, err := api.Events.Since(api.Events.Latest()-1, 1)
+	assert.Nil(err)
+	assert.Equal(events.Updated, latest[0].Type)
+	assert.Equal(server.ID, latest[0].Resource)
+
+	entries := api.Audit.Entries()
+	assert.Equal("snmp.trap", entries[len(entries)-1].Action)
+
+	// The agent address of the trap wins over the source of the datagram
+	agent := snmp.Variable{OID: snmp.OIDSnmpTrapAddress, Value: net.ParseIP("10.1.0.1")}
+	ids, err = r.receive(ctx, trap(snmp.OIDLinkUp, "lab", agent), net.ParseIP("10.9.9.9"))
+	assert.Nil(err)
+	assert.Equal([]string{server.ID}, ids)
+	assert.Equal(zebra.None, findResource(api.Store, server.ID).GetStatus().Fault)
+
+	// Resources are matched by the MAC addresses in the trap too
+	mac, err := net.ParseMAC("00:1b:21:3a:4f:10")
+	assert.Nil(err)
+
+	ids, err = r.receive(ctx, trap(psu, "lab", snmp.Variable{OID: "1.3.6.1.2.1.2.2.1.6.1", Value: []byte(mac)}), nil)
+	assert.Nil(err)
+	assert.Equal([]string{lab.ID}, ids)
+	assert.Equal(zebra.Critical, findResource(api.Store, lab.ID).GetStatus().Fault)
+	assert.Equal(zebra.Inactive, findResource(api.Store, lab.ID).GetStatus().State)
+
+	// Traps are dropped while the server is read-only
+	api.readOnly.set(true, "migration", "admin@zebra", time.Now())
+
+	ids, err = r.receive(ctx, trap(snmp.OIDLinkDown, "lab"), net.ParseIP("10.1.0.1"))
+	assert.Nil(err)
+	assert.Empty(ids)
+	assert.Equal("up", findResource(api.Store, server.ID).GetLabels()["link"])
+
+	api.readOnly.set(false, "", "admin@zebra", time.Now())
+
+	// Other traps and devices are ignored, other communities rejected
+	ids, err = r.receive(ctx, trap(snmp.OIDColdStart, "lab"), net.ParseIP("10.1.0.1"))
+	assert.Nil(err)
+	assert.Empty(ids)
+
+	ids, err = r.receive(ctx, trap(snmp.OIDLinkDown, "lab"), net.ParseIP("10.1.0.2"))
+	assert.Nil(err)
+	assert.Empty(ids)
+
+	_, err = r.receive(ctx, trap(snmp.OIDLinkDown, "public"), net.ParseIP("10.1.0.1"))
+	assert.ErrorIs(err, ErrTrapCommunity)
+
+	_, err = r.receive(ctx, []byte("trap"), net.ParseIP("10.1.0.1"))
+	assert.NotNil(err)
+
+	// The listener receives traps until it is stopped
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	local := compute.NewServer([]string{"serial", "model", "local"}, net.ParseIP("127.0.0.1"),
+		zebra.Labels{"system.group": "servers"})
+	assert.Nil(api.Store.Create(local))
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		r.run(runCtx, conn)
+		close(done)
+	}()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	assert.Nil(err)
+
+	_, err = sender.Write(trap(snmp.OIDLinkDown, "lab"))
+	assert.Nil(err)
+
+	assert.Eventually(func() bool { return findResource(api.Store, local.ID).GetLabels()["link"] == "down" },
+		5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
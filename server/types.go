@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/jsonschema"
+	"github.com/project-safari/zebra/store"
+)
+
+// SchemaBundle is the title of the bundle of the schemas of all types.
+const SchemaBundle = "Zebra"
+
+func handleTypes() httprouter.Handle {
+	allTypes := store.DefaultFactory()
+
+	// Types are built in, they do not change while the server runs
+	modified := time.Now()
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		typeReq := &struct {
+			Types []string `json:"types"`
+		}{Types: []string{}}
+
+		if err := readJSON(ctx, req, typeReq); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		typeRes := &struct {
+			Types []zebra.Type `json:"types"`
+		}{Types: []zebra.Type{}}
+
+		if len(typeReq.Types) == 0 {
+			// return all types, in order so that the response is stable
+			typeRes.Types = allTypes.Types()
+			sort.Slice(typeRes.Types, func(i, j int) bool { return typeRes.Types[i].Name < typeRes.Types[j].Name })
+		} else {
+			for _, t := range typeReq.Types {
+				if aType, ok := allTypes.Type(t); ok {
+					typeRes.Types = append(typeRes.Types, aType)
+				}
+			}
+		}
+
+		writeCachedJSON(ctx, res, req, modified, typeRes)
+	}
+}
+
+// typeSchema returns the JSON Schema of the resources of the type.
+func typeSchema(t zebra.Type) *jsonschema.Schema {
+	s := jsonschema.For(t.Name, t.New())
+	s.Description = t.Description
+
+	return s
+}
+
+// handleTypeSchema returns the JSON Schema of a type.
+func handleTypeSchema() httprouter.Handle {
+	allTypes := store.DefaultFactory()
+	modified := time.Now()
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		t, ok := allTypes.Type(params.ByName("type"))
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeCachedJSON(req.Context(), res, req, modified, typeSchema(t))
+	}
+}
+
+// handleSchemas returns the JSON Schemas of all types in one bundle, so that
+// clients and validators can be generated for all of them at once.
+func handleSchemas() httprouter.Handle {
+	allTypes := store.DefaultFactory()
+	modified := time.Now()
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		types := allTypes.Types()
+		sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+		schemas := make([]*jsonschema.Schema, 0, len(types))
+		for _, t := range types {
+			schemas = append(schemas, typeSchema(t))
+		}
+
+		writeCachedJSON(req.Context(), res, req, modified, jsonschema.Bundle(SchemaBundle, schemas...))
+	}
+}
@@ -0,0 +1,134 @@
+package server //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/jsonschema"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTypeRequest(assert *assert.Assertions, types ...string) *http.Request {
+	req, err := http.NewRequest("GET", "/api/v1/types", nil)
+	assert.Nil(err)
+	assert.NotNil(req)
+
+	v := map[string][]string{"types": types}
+	b, e := json.Marshal(v)
+	assert.Nil(e)
+
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(b))
+
+	return req
+}
+
+func TestBadReq(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := handleTypes()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil)
+	})
+
+	req, err := http.NewRequest("GET", "/api/v1/types", nil)
+	assert.Nil(err)
+	assert.NotNil(req)
+
+	v := "{....}" // bad json
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(v))
+
+	handler.ServeHTTP(rr, req)
+	assert.Equal(rr.Code, http.StatusBadRequest)
+}
+
+func TestDefaultFactory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := handleTypes()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil)
+	})
+
+	req := makeTypeRequest(assert)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(rr.Code, http.StatusOK)
+}
+
+func TestTypes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	h := handleTypes()
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil)
+	})
+
+	req := makeTypeRequest(assert, "Server", "Switch")
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(rr.Code, http.StatusOK)
+}
+
+func TestTypeSchema(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	get := func(name string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/types/"+name+"/schema", nil)
+		handleTypeSchema()(rr, req, httprouter.Params{{Key: "type", Value: name}})
+
+		return rr
+	}
+
+	rr := get("Lab")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.NotEmpty(rr.Header().Get("ETag"))
+
+	schema := new(jsonschema.Schema)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), schema))
+	assert.Equal(jsonschema.Draft, schema.Schema)
+	assert.Equal("Lab", schema.Title)
+	assert.NotEmpty(schema.Description)
+	assert.Equal("object", schema.Type)
+	assert.Contains(schema.Required, "id")
+	assert.Contains(schema.Required, "name")
+	assert.Equal([]string{"leased", "free", "setup"}, schema.Properties["status"].Properties["lease"].Enum)
+
+	assert.Equal(http.StatusNotFound, get("Gossip").Code)
+}
+
+func TestSchemas(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rr := httptest.NewRecorder()
+	handleSchemas()(rr, httptest.NewRequest("GET", "/api/v1/schemas", nil), nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	bundle := new(jsonschema.Schema)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), bundle))
+	assert.Equal(SchemaBundle, bundle.Title)
+	assert.Len(bundle.AnyOf, len(bundle.Defs))
+
+	refs := []string{}
+	for _, ref := range bundle.AnyOf {
+		refs = append(refs, ref.Ref)
+	}
+
+	assert.Contains(refs, "#/$defs/Lab")
+	assert.Equal("Lab", bundle.Defs["Lab"].Title)
+	assert.Empty(bundle.Defs["Lab"].Schema)
+	assert.Equal("date-time", bundle.Defs["Lease"].Properties["activationTime"].Format)
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/timeseries"
+)
+
+// Defaults of the utilization range queries: the last week, in hourly
+// points.
+const (
+	DefaultUtilizationRange = 7 * 24 * time.Hour
+	DefaultUtilizationStep  = time.Hour
+)
+
+// Counts recorded for each type and pool by the utilization snapshots.
+const (
+	utilizationLeased = "leased"
+	utilizationFree   = "free"
+	utilizationTotal  = "total"
+)
+
+var ErrUtilizationRange = errors.New("utilization ranges need RFC 3339 from and to times and a positive step")
+
+// UtilizationPoint is the average of the counts of the resources of a type in
+// a pool over a step starting at Time.
+type UtilizationPoint struct {
+	Time   time.Time `json:"time"`
+	Leased float64   `json:"leased"`
+	Free   float64   `json:"free"`
+	Total  float64   `json:"total"`
+}
+
+// UtilizationSeries is the utilization history of the resources of a type in
+// a pool, their system.group.
+type UtilizationSeries struct {
+	Type   string             `json:"type"`
+	Pool   string             `json:"pool"`
+	Step   string             `json:"step"`
+	Points []UtilizationPoint `json:"points"`
+}
+
+// utilizationKey returns the name of the series of the count of the
+// resources of the type in the pool.
+func utilizationKey(typ string, pool string, count string) string {
+	return typ + "/" + pool + "/" + count
+}
+
+// utilizationSamples returns the leased, free and total counts of the leasable
+// resources, by type and pool, and the number of types and pools counted.
+func utilizationSamples(resources *zebra.ResourceMap) (map[string]float64, int) {
+	samples := map[string]float64{}
+	pools := 0
+
+	for typ, list := range resources.Resources {
+		if unleasableTypes[typ] {
+			continue
+		}
+
+		for _, r := range list.Resources {
+			status := r.GetStatus()
+			if status == nil {
+				continue
+			}
+
+			pool := r.GetLabels()["system.group"]
+			leased, free := utilizationKey(typ, pool, utilizationLeased), utilizationKey(typ, pool, utilizationFree)
+
+			if _, ok := samples[leased]; !ok {
+				samples[leased], samples[free] = 0, 0
+				pools++
+			}
+
+			samples[utilizationKey(typ, pool, utilizationTotal)]++
+
+			switch status.Lease {
+			case zebra.Leased:
+				samples[leased]++
+			case zebra.Free:
+				samples[free]++
+			}
+		}
+	}
+
+	return samples, pools
+}
+
+// recordUtilization records a snapshot of the utilization of the store.
+func recordUtilization(api *ResourceAPI, now time.Time) (string, error) {
+	samples, pools := utilizationSamples(api.view())
+	if err := api.utilization.Record(now, samples); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("recorded the utilization of %d types and pools", pools), nil
+}
+
+// utilizationHistory returns the utilization series of the type and pool, all
+// of them if not given, in the range, the pools the readable function allows
+// only.
+func utilizationHistory(db *timeseries.DB, typ string, pool string, from time.Time, to time.Time,
+	step time.Duration, readable func(typ string, pool string) bool,
+) ([]UtilizationSeries, error) {
+	history := []UtilizationSeries{}
+
+	for _, name := range db.Names() {
+		prefix := strings.TrimSuffix(name, "/"+utilizationTotal)
+		t, p, ok := strings.Cut(prefix, "/")
+
+		if prefix == name || !ok || (typ != "" && t != typ) || (pool != "" && p != pool) || !readable(t, p) {
+			continue
+		}
+
+		points := map[time.Time]*UtilizationPoint{}
+		s := UtilizationSeries{Type: t, Pool: p, Step: "", Points: []UtilizationPoint{}}
+
+		for _, count := range []string{utilizationLeased, utilizationFree, utilizationTotal} {
+			values, actual, err := db.Query(utilizationKey(t, p, count), from, to, step)
+			if err != nil {
+				return nil, err
+			}
+
+			s.Step = actual.String()
+
+			for _, v := range values {
+				point, ok := points[v.Time]
+				if !ok {
+					point = &UtilizationPoint{Time: v.Time, Leased: 0, Free: 0, Total: 0}
+					points[v.Time] = point
+				}
+
+				switch count {
+				case utilizationLeased:
+					point.Leased = v.Value
+				case utilizationFree:
+					point.Free = v.Value
+				default:
+					point.Total = v.Value
+				}
+			}
+		}
+
+		for _, point := range points {
+			s.Points = append(s.Points, *point)
+		}
+
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+
+		history = append(history, s)
+	}
+
+	return history, nil
+}
+
+// parseUtilizationRange returns the range and step of the query parameters,
+// the last week in hourly steps by default.
+func parseUtilizationRange(req *http.Request, now time.Time) (time.Time, time.Time, time.Duration, error) {
+	query := req.URL.Query()
+	to, step := now, DefaultUtilizationStep
+
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return to, to, step, ErrUtilizationRange
+		}
+
+		to = t
+	}
+
+	from := to.Add(-DefaultUtilizationRange)
+
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil || !to.After(t) {
+			return from, to, step, ErrUtilizationRange
+		}
+
+		from = t
+	}
+
+	if s := query.Get("step"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return from, to, step, ErrUtilizationRange
+		}
+
+		step = d
+	}
+
+	return from, to, step, nil
+}
+
+// handleUtilization returns the utilization history of the type and pool
+// query parameters, of all the types and pools the user may read if not
+// given, from the snapshots the utilization job records.
+func handleUtilization() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		from, to, step, err := parseUtilizationRange(req, time.Now())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		query := req.URL.Query()
+
+		history, err := utilizationHistory(api.utilization, query.Get("type"), query.Get("pool"), from, to, step,
+			func(typ string, pool string) bool {
+				return claims.Allows(auth.ActionRead, typ, zebra.Labels{"system.group": pool})
+			})
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		writeJSON(ctx, res, history)
+	}
+}
@@ -0,0 +1,103 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUtilizationHistory(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	a := dc.NewLab("a", zebra.Labels{"system.group": "labs"})
+	b := dc.NewLab("b", zebra.Labels{"system.group": "labs"})
+	c := dc.NewLab("c", zebra.Labels{"system.group": "dev"})
+
+	for _, lab := range []*dc.Lab{a, b, c} {
+		lab.Status.Lease = zebra.Free
+		assert.Nil(api.Store.Create(lab))
+	}
+
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	result, err := recordUtilization(api, start)
+	assert.Nil(err)
+	assert.Equal("recorded the utilization of 2 types and pools", result)
+
+	a.Status.Lease = zebra.Leased
+	assert.Nil(api.Store.Create(a))
+
+	_, err = recordUtilization(api, start.Add(time.Hour))
+	assert.Nil(err)
+
+	all := func(string, string) bool { return true }
+
+	history, err := utilizationHistory(api.utilization, "", "", start, start.Add(2*time.Hour), time.Hour, all)
+	assert.Nil(err)
+	assert.Len(history, 2)
+	assert.Equal("dev", history[0].Pool)
+	assert.Equal("labs", history[1].Pool)
+	assert.Equal("1h0m0s", history[1].Step)
+	assert.Equal([]UtilizationPoint{
+		{Time: start, Leased: 0, Free: 2, Total: 2},
+		{Time: start.Add(time.Hour), Leased: 1, Free: 1, Total: 2},
+	}, history[1].Points)
+
+	history, err = utilizationHistory(api.utilization, "Lab", "labs", start, start.Add(2*time.Hour), 2*time.Hour, all)
+	assert.Nil(err)
+	assert.Len(history, 1)
+	assert.Equal([]UtilizationPoint{{Time: start, Leased: 0.5, Free: 1.5, Total: 2}}, history[0].Points)
+
+	history, err = utilizationHistory(api.utilization, "", "", start, start.Add(time.Hour), time.Hour,
+		func(typ string, pool string) bool { return pool == "dev" })
+	assert.Nil(err)
+	assert.Len(history, 1)
+	assert.Equal("dev", history[0].Pool)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, makeClaims(assert, "user@zebra", false))
+		rr := httptest.NewRecorder()
+
+		handleUtilization()(rr, httptest.NewRequest("GET", "/api/v1/utilization?"+query, nil).WithContext(ctx),
+			httprouter.Params{})
+
+		return rr
+	}
+
+	rr := get("type=Lab&pool=labs&from=2022-06-01T00:00:00Z&to=2022-06-02T00:00:00Z&step=1h")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	history = []UtilizationSeries{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &history))
+	assert.Len(history, 1)
+	assert.Len(history[0].Points, 2)
+
+	assert.Equal(http.StatusOK, get("").Code)
+	assert.Equal(http.StatusBadRequest, get("from=yesterday").Code)
+	assert.Equal(http.StatusBadRequest, get("from=2022-06-02T00:00:00Z&to=2022-06-01T00:00:00Z").Code)
+	assert.Equal(http.StatusBadRequest, get("step=-1h").Code)
+
+	// The snapshots are recorded by a job
+	sched, err := newScheduler(api, []JobConfig{
+		{Name: "utilization", Schedule: "*/5 * * * *", Task: TaskUtilization, Args: nil},
+	})
+	assert.Nil(err)
+
+	status, err := sched.Run(context.Background(), "utilization")
+	assert.Nil(err)
+	assert.Equal("recorded the utilization of 2 types and pools", status.LastResult)
+}
@@ -0,0 +1,470 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/kv"
+	"github.com/project-safari/zebra/store"
+)
+
+// MineView is the view of the resources a user starred, unless the user
+// saved a view of that name.
+const MineView = "mine"
+
+// Limits of the stars and views of a user.
+const (
+	MaxStars = 1000
+	MaxViews = 50
+)
+
+var (
+	ErrViewName     = errors.New("view names must be 1 to 128 letters, digits, '.', '-' or '_'")
+	ErrViewNotFound = errors.New("view not found")
+	ErrTooManyStars = errors.New("users can star up to 1000 resources")
+	ErrTooManyViews = errors.New("users can save up to 50 views")
+)
+
+// View is a filtered view of the resources saved by a user: the resources of
+// the types, all types if there are none, that match the label queries and,
+// if Starred is set, that the user starred. Columns and Sort tell clients how
+// to show the resources, they are JSONPath expressions of the resources such
+// as ".name" or ".labels['system.group']"; a sort starting with "-" sorts in
+// descending order.
+type View struct {
+	Name    string        `json:"name"`
+	Types   []string      `json:"types,omitempty"`
+	Labels  []zebra.Query `json:"labels,omitempty"`
+	Starred bool          `json:"starred,omitempty"`
+	Columns []string      `json:"columns,omitempty"`
+	Sort    string        `json:"sort,omitempty"`
+}
+
+// mineView returns the built in view of the starred resources.
+func mineView() View {
+	return View{Name: MineView, Types: nil, Labels: nil, Starred: true, Columns: nil, Sort: ""}
+}
+
+// userViews are the stars and the saved views of a user.
+type userViews struct {
+	Stars []string        `json:"stars"`
+	Views map[string]View `json:"views"`
+}
+
+// viewList keeps the stars and views of the users by email. If a path is
+// given, they are written to that file on every change.
+type viewList struct {
+	lock  sync.RWMutex
+	path  string
+	Users map[string]*userViews `json:"users"`
+}
+
+func newViewList(path string) *viewList {
+	return &viewList{lock: sync.RWMutex{}, path: path, Users: map[string]*userViews{}}
+}
+
+// load reads the stars and views from the backing file, if any.
+func (l *viewList) load() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, l)
+}
+
+func (l *viewList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, ReadWriteOnly); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.path)
+}
+
+// stars returns the IDs of the resources the user starred, in the order
+// they were starred.
+func (l *viewList) stars(user string) []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if u, ok := l.Users[user]; ok {
+		return append([]string{}, u.Stars...)
+	}
+
+	return []string{}
+}
+
+// views returns the views of the user by name, with the built in ones.
+func (l *viewList) views(user string) []View {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	views := []View{}
+	mine := false
+
+	if u, ok := l.Users[user]; ok {
+		for _, v := range u.Views {
+			views = append(views, v)
+			mine = mine || v.Name == MineView
+		}
+	}
+
+	if !mine {
+		views = append(views, mineView())
+	}
+
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+
+	return views
+}
+
+// view returns the view of the user with the name.
+func (l *viewList) view(user string, name string) (View, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if u, ok := l.Users[user]; ok {
+		if v, ok := u.Views[name]; ok {
+			return v, nil
+		}
+	}
+
+	if name == MineView {
+		return mineView(), nil
+	}
+
+	return View{}, ErrViewNotFound
+}
+
+// change applies the change to the stars and views of the user and saves
+// them, the user is left unchanged if the change fails.
+func (l *viewList) change(user string, change func(u *userViews) error) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	prev, ok := l.Users[user]
+	u := &userViews{Stars: []string{}, Views: map[string]View{}}
+
+	if ok {
+		u.Stars = append(u.Stars, prev.Stars...)
+		for name, v := range prev.Views {
+			u.Views[name] = v
+		}
+	}
+
+	if err := change(u); err != nil {
+		return err
+	}
+
+	l.Users[user] = u
+
+	if err := l.save(); err != nil {
+		if ok {
+			l.Users[user] = prev
+		} else {
+			delete(l.Users, user)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// star adds the resource to the stars of the user, starring a resource
+// twice changes nothing.
+func (l *viewList) star(user string, id string) error {
+	return l.change(user, func(u *userViews) error {
+		for _, s := range u.Stars {
+			if s == id {
+				return nil
+			}
+		}
+
+		if len(u.Stars) >= MaxStars {
+			return ErrTooManyStars
+		}
+
+		u.Stars = append(u.Stars, id)
+
+		return nil
+	})
+}
+
+// unstar removes the resource from the stars of the user.
+func (l *viewList) unstar(user string, id string) error {
+	return l.change(user, func(u *userViews) error {
+		stars := []string{}
+
+		for _, s := range u.Stars {
+			if s != id {
+				stars = append(stars, s)
+			}
+		}
+
+		u.Stars = stars
+
+		return nil
+	})
+}
+
+// saveView adds or replaces the view of the user.
+func (l *viewList) saveView(user string, v View) error {
+	return l.change(user, func(u *userViews) error {
+		if _, ok := u.Views[v.Name]; !ok && len(u.Views) >= MaxViews {
+			return ErrTooManyViews
+		}
+
+		u.Views[v.Name] = v
+
+		return nil
+	})
+}
+
+// deleteView removes the view of the user.
+func (l *viewList) deleteView(user string, name string) error {
+	return l.change(user, func(u *userViews) error {
+		if _, ok := u.Views[name]; !ok {
+			return ErrViewNotFound
+		}
+
+		delete(u.Views, name)
+
+		return nil
+	})
+}
+
+// viewResources returns the resources of the view the claims may read.
+func (api *ResourceAPI) viewResources(claims *auth.Claims, v View) *zebra.ResourceMap {
+	qr := &QueryRequest{IDs: nil, Types: v.Types, Labels: v.Labels, Properties: nil, Lifecycle: nil, Heartbeat: nil}
+	found := zebra.NewResourceMap(store.DefaultFactory())
+	stars := map[string]bool{}
+
+	for _, id := range api.views.stars(claims.Email) {
+		stars[id] = true
+	}
+
+	// Starred resources of all types are looked up by their IDs
+	if v.Starred && len(v.Types) == 0 {
+		if len(stars) == 0 {
+			return found
+		}
+
+		qr.IDs = api.views.stars(claims.Email)
+	}
+
+	resources := api.query(qr)
+
+	_ = applyFunc(resources, func(r zebra.Resource) error {
+		if (!v.Starred || stars[r.GetID()]) && claims.Allows(auth.ActionRead, r.GetType(), r.GetLabels()) {
+			found.Add(r, r.GetType())
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// viewContext returns the API and the claims of the request, or writes the
+// error response.
+func viewContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	return api, claims, true
+}
+
+// writeViewChange writes the response of a change of the stars or views.
+func writeViewChange(res http.ResponseWriter, req *http.Request, err error, value interface{}) {
+	ctx := req.Context()
+
+	switch {
+	case errors.Is(err, ErrViewNotFound):
+		res.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ErrTooManyStars), errors.Is(err, ErrTooManyViews):
+		http.Error(res, err.Error(), http.StatusConflict)
+	case err != nil:
+		logr.FromContextOrDiscard(ctx).Error(err, "views could not be saved")
+		res.WriteHeader(http.StatusInternalServerError)
+	default:
+		writeJSON(ctx, res, value)
+	}
+}
+
+// handleStars returns the IDs of the resources the user starred.
+func handleStars() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.views.stars(claims.Email))
+	}
+}
+
+// handleStar stars a resource the user may read.
+func handleStar() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		resource := findResource(api.Store, params.ByName("id"))
+		if resource == nil || !claims.Allows(auth.ActionRead, resource.GetType(), resource.GetLabels()) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		err := api.views.star(claims.Email, resource.GetID())
+		writeViewChange(res, req, err, api.views.stars(claims.Email))
+	}
+}
+
+// handleUnstar removes a resource from the stars of the user.
+func handleUnstar() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		err := api.views.unstar(claims.Email, params.ByName("id"))
+		writeViewChange(res, req, err, api.views.stars(claims.Email))
+	}
+}
+
+// handleViews lists the views of the user.
+func handleViews() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.Context(), res, api.views.views(claims.Email))
+	}
+}
+
+// handleView returns a view of the user.
+func handleView() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		v, err := api.views.view(claims.Email, params.ByName("name"))
+		if err != nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(req.Context(), res, v)
+	}
+}
+
+// handleSaveView saves the view in the body under the name of the route.
+func handleSaveView() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		v := View{Name: "", Types: nil, Labels: nil, Starred: false, Columns: nil, Sort: ""}
+		if err := readJSON(ctx, req, &v); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		v.Name = params.ByName("name")
+		if kv.ValidName(v.Name) != nil {
+			http.Error(res, ErrViewName.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := validateQueries(v.Labels); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		writeViewChange(res, req, api.views.saveView(claims.Email, v), v)
+	}
+}
+
+// handleDeleteView removes a saved view of the user.
+func handleDeleteView() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		name := params.ByName("name")
+		err := api.views.deleteView(claims.Email, name)
+		writeViewChange(res, req, err, map[string]string{"name": name})
+	}
+}
+
+// handleViewResources returns the resources of a view of the user.
+func handleViewResources() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, claims, ok := viewContext(res, req)
+		if !ok {
+			return
+		}
+
+		v, err := api.views.view(claims.Email, params.ByName("name"))
+		if err != nil {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(req.Context(), res, api.viewResources(claims, v))
+	}
+}
@@ -0,0 +1,113 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViews(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	user := makeClaims(assert, "user@zebra", false)
+	other := makeClaims(assert, "other@zebra", false)
+
+	serve := func(h httprouter.Handle, claims *auth.Claims, method string, body string, params httprouter.Params,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		req := httptest.NewRequest(method, "/", strings.NewReader(body)).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		h(rr, req, params)
+
+		return rr
+	}
+
+	lab := dc.NewLab("lab1", zebra.Labels{"system.group": "g", "color": "red"})
+	rack := dc.NewRack("rack1", "r1", zebra.Labels{"system.group": "g", "color": "blue"})
+	assert.Nil(api.Store.Create(lab))
+	assert.Nil(api.Store.Create(rack))
+
+	resources := func(claims *auth.Claims, name string) *zebra.ResourceMap {
+		rr := serve(handleViewResources(), claims, "GET", "", httprouter.Params{{Key: "name", Value: name}})
+		assert.Equal(http.StatusOK, rr.Code)
+
+		resMap := zebra.NewResourceMap(store.DefaultFactory())
+		assert.Nil(json.Unmarshal(rr.Body.Bytes(), resMap))
+
+		return resMap
+	}
+
+	// Users start with no stars, the built in view shows nothing
+	assert.Empty(resources(user, MineView).Resources)
+
+	assert.Equal(http.StatusNotFound,
+		serve(handleStar(), user, "POST", "", httprouter.Params{{Key: "id", Value: "missing"}}).Code)
+	assert.Equal(http.StatusOK, serve(handleStar(), user, "POST", "", httprouter.Params{{Key: "id", Value: lab.ID}}).Code)
+	assert.Equal(http.StatusOK, serve(handleStar(), user, "POST", "", httprouter.Params{{Key: "id", Value: lab.ID}}).Code)
+
+	rr := serve(handleStars(), user, "GET", "", nil)
+	assert.JSONEq(`["`+lab.ID+`"]`, rr.Body.String())
+
+	mine := resources(user, MineView)
+	assert.Equal(1, len(mine.Resources["Lab"].Resources))
+	assert.Nil(mine.Resources["Rack"])
+
+	// Stars are per user
+	assert.Empty(resources(other, MineView).Resources)
+
+	byName := httprouter.Params{{Key: "name", Value: "blue"}}
+
+	assert.Equal(http.StatusBadRequest, serve(handleSaveView(), user, "POST", `{"types":`, byName).Code)
+	assert.Equal(http.StatusBadRequest, serve(handleSaveView(), user, "POST", `{}`,
+		httprouter.Params{{Key: "name", Value: "a:b"}}).Code)
+
+	rr = serve(handleSaveView(), user, "POST",
+		`{"labels":[{"key":"color","op":"==","values":["blue"]}],"columns":[".name"]}`, byName)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	blue := resources(user, "blue")
+	assert.Equal(1, len(blue.Resources["Rack"].Resources))
+	assert.Nil(blue.Resources["Lab"])
+
+	rr = serve(handleViews(), user, "GET", "", nil)
+	views := []View{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &views))
+	assert.Equal([]string{"blue", MineView}, []string{views[0].Name, views[1].Name})
+
+	rr = serve(handleView(), user, "GET", "", byName)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(http.StatusNotFound, serve(handleView(), other, "GET", "", byName).Code)
+
+	// Stars and views are kept on disk
+	loaded := newViewList(path.Join(root, "views.json"))
+	assert.Nil(loaded.load())
+	assert.Equal([]string{lab.ID}, loaded.stars("user@zebra"))
+
+	v, err := loaded.view("user@zebra", "blue")
+	assert.Nil(err)
+	assert.Equal([]string{".name"}, v.Columns)
+
+	assert.Equal(http.StatusOK, serve(handleDeleteView(), user, "DELETE", "", byName).Code)
+	assert.Equal(http.StatusNotFound, serve(handleDeleteView(), user, "DELETE", "", byName).Code)
+	assert.Equal(http.StatusOK,
+		serve(handleUnstar(), user, "DELETE", "", httprouter.Params{{Key: "id", Value: lab.ID}}).Code)
+	assert.Empty(resources(user, MineView).Resources)
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/lease"
+)
+
+var (
+	ErrLeaseRequest  = errors.New("invalid lease request")
+	ErrNotQueued     = errors.New("lease is not waiting for resources")
+	ErrLeaseInactive = errors.New("lease is not active")
+)
+
+// LeaseRequest asks for resources for the duration. Leases are satisfied in
+// the order of the queue, if the request can not be satisfied right away it
+// is refused unless the caller waits for the resources, then the lease joins
+// the queue and is activated by the lease allocator once they free up. The
+// requests are satisfied all together or not at all, in the same place if
+// there are placement scopes, such as the same rack, and spread across the
+// places of the spread scopes. Leases are created in the namespace, the
+// default lease namespace if none is given, under its lease policy: leases
+// that do not ask for a duration get the default of the policy.
+type LeaseRequest struct {
+	Namespace string               `json:"namespace,omitempty"`
+	Duration  string               `json:"duration,omitempty"`
+	Priority  lease.Priority       `json:"priority,omitempty"`
+	Request   []*lease.ResourceReq `json:"request"`
+	Placement []string             `json:"placement,omitempty"`
+	Spread    []lease.Spread       `json:"spread,omitempty"`
+	Wait      bool                 `json:"wait,omitempty"`
+}
+
+// namespace returns the namespace of the requested lease.
+func (r *LeaseRequest) namespace() string {
+	if r.Namespace == "" {
+		return lease.DefaultNamespace
+	}
+
+	return r.Namespace
+}
+
+// lease returns the pending lease of the owner for the request, under the
+// lease policy of its namespace.
+func (r *LeaseRequest) lease(ctx context.Context, owner string, policy *lease.Policy) (*lease.Lease, error) {
+	d := policy.Default()
+
+	if r.Duration != "" {
+		parsed, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			return nil, ErrLeaseRequest
+		}
+
+		d = parsed
+	}
+
+	if d <= 0 || len(r.Request) == 0 {
+		return nil, ErrLeaseRequest
+	}
+
+	for _, req := range r.Request {
+		if req == nil || req.Type == "" || req.Count <= 0 || len(req.Resources) != 0 {
+			return nil, ErrLeaseRequest
+		}
+
+		if err := validateQueries(req.Filters); err != nil {
+			return nil, err
+		}
+	}
+
+	l := lease.NewLease(owner, d, r.Request)
+	l.Labels.Add("system.group", r.namespace())
+	l.Priority = r.Priority
+	l.Placement = r.Placement
+	l.Spread = r.Spread
+
+	if err := l.Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := policy.Check(l); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// dryRunLease returns the allocations of the lease if it were queued: the
+// leases ahead of it in the queue are satisfied first.
+func dryRunLease(resources *zebra.ResourceMap, l *lease.Lease, now time.Time, scoring scoringPolicy,
+) ([]Allocation, int) {
+	a := newAllocator(resources, now, scoring)
+
+	for _, p := range pendingLeases(resources) {
+		if !queuedBefore(p, l) {
+			break
+		}
+
+		if _, missing := reserve(a, p); missing != 0 {
+			a.release(p.ID)
+		}
+	}
+
+	return reserve(a, l)
+}
+
+// queuedLease returns the lease with the ID and its place in the queue, if
+// it waits for resources.
+func queuedLease(resources *zebra.ResourceMap, id string) (QueuedLease, bool) {
+	for i, l := range pendingLeases(resources) {
+		if l.ID == id {
+			return newQueuedLease(i+1, l), true
+		}
+	}
+
+	return QueuedLease{Position: 0, ID: "", Owner: "", Priority: lease.Normal, Created: time.Time{}}, false
+}
+
+// handleRequestLease creates a lease for the user. The lease is activated
+// if the resources are available, after the leases ahead of it in the queue
+// had theirs. Otherwise the allocations with what blocks them are returned,
+// or, if the user waits, the place of the lease in the queue.
+func handleRequestLease() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, ok := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+
+		if !ok {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		claims, ok := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		lr := new(LeaseRequest)
+		if err := readJSON(ctx, req, lr); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		l, err := lr.lease(ctx, claims.Email, namespacePolicy(api.view(), lr.namespace()))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if !claims.Allows(auth.ActionCreate, l.GetType(), l.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		now := time.Now()
+
+		if allocs, missing := dryRunLease(api.view(), l, now, api.scoring); missing != 0 && !lr.Wait {
+			writeJSONCode(ctx, res, http.StatusConflict, allocs)
+
+			return
+		}
+
+		if err := api.create(ctx, l); err != nil {
+			log.Error(err, "lease could not be created")
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.request", l.ID, l.Priority.String())
+
+		if _, err := allocateLeases(ctx, api, now); err != nil {
+			log.Error(err, "leases could not be allocated")
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if active, ok := findResource(api.Store, l.ID).(*lease.Lease); ok && active.Status.State == zebra.Active {
+			writeJSONCode(ctx, res, http.StatusCreated, active)
+
+			return
+		}
+
+		queued, ok := queuedLease(api.view(), l.ID)
+		if !ok || !lr.Wait {
+			// The queue changed since the dry run, a lease that does not
+			// wait leaves it
+			if err := api.delete(ctx, l); err != nil {
+				log.Error(err, "lease could not be deleted", "lease", l.ID)
+			}
+
+			allocs, _ := dryRunLease(api.view(), l, now, api.scoring)
+			writeJSONCode(ctx, res, http.StatusConflict, allocs)
+
+			return
+		}
+
+		writeJSONCode(ctx, res, http.StatusAccepted, &queued)
+	}
+}
+
+// handleReleaseLease ends an active lease before it expires, all of its
+// resources are freed together and go to the leases waiting for them.
+func handleReleaseLease() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+		api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+		claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+		if !apiOK || !claimsOK {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		l, ok := findResource(api.Store, params.ByName("id")).(*lease.Lease)
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if l.Owner() != claims.Email && !claims.Allows(auth.ActionUpdate, l.GetType(), l.GetLabels()) {
+			res.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		if l.Status.State != zebra.Active {
+			http.Error(res, ErrLeaseInactive.Error(), http.StatusConflict)
+
+			return
+		}
+
+		ended, err := endLease(ctx, api, l)
+		if err != nil {
+			log.Error(err, "lease could not be released", "lease", l.ID)
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.release", l.ID, "")
+
+		if _, err := allocateLeases(ctx, api, time.Now()); err != nil {
+			log.Error(err, "leases could not be allocated")
+		}
+
+		writeJSON(ctx, res, ended)
+	}
+}
+
+// handleQueuedLease returns the place of a waiting lease in the queue.
+func handleQueuedLease() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, l, ok := queuedLeaseContext(res, req, params, auth.ActionRead)
+
+		if !ok {
+			return
+		}
+
+		queued, _ := queuedLease(api.view(), l.ID)
+		writeJSON(ctx, res, &queued)
+	}
+}
+
+// handleLeaveQueue deletes a lease that waits for resources.
+func handleLeaveQueue() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		api, l, ok := queuedLeaseContext(res, req, params, auth.ActionDelete)
+
+		if !ok {
+			return
+		}
+
+		if err := api.delete(ctx, l); err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		api.recordAudit(ctx, "lease.withdraw", l.ID, "")
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// queuedLeaseContext returns the waiting lease of the request, if the user
+// owns it or may act on it.
+func queuedLeaseContext(res http.ResponseWriter, req *http.Request, params httprouter.Params,
+	action auth.Action,
+) (*ResourceAPI, *lease.Lease, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	l, ok := findResource(api.Store, params.ByName("id")).(*lease.Lease)
+	if !ok {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, false
+	}
+
+	if l.Owner() != claims.Email && !claims.Allows(action, l.GetType(), l.GetLabels()) {
+		res.WriteHeader(http.StatusForbidden)
+
+		return nil, nil, false
+	}
+
+	if _, queued := queuedLease(api.view(), l.ID); !queued {
+		http.Error(res, ErrNotQueued.Error(), http.StatusConflict)
+
+		return nil, nil, false
+	}
+
+	return api, l, true
+}
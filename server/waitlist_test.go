@@ -0,0 +1,198 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/lease"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseRequest(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	req := func() []*lease.ResourceReq {
+		return []*lease.ResourceReq{{Type: "Lab", Group: "labs", Name: "", Count: 1, Filters: nil, Resources: nil}}
+	}
+
+	for _, lr := range []*LeaseRequest{
+		{Duration: "soon", Priority: lease.Normal, Request: req(), Wait: false},
+		{Duration: "-1h", Priority: lease.Normal, Request: req(), Wait: false},
+		{Duration: "1h", Priority: lease.Normal, Request: nil, Wait: false},
+		{Duration: "1h", Priority: lease.Normal, Request: []*lease.ResourceReq{{Type: "Lab", Count: 0}}, Wait: false},
+		{Duration: "1h", Priority: lease.Normal, Request: []*lease.ResourceReq{{Type: "", Count: 1}}, Wait: false},
+	} {
+		_, err := lr.lease(ctx, "user@zebra", nil)
+		assert.ErrorIs(err, ErrLeaseRequest)
+	}
+
+	_, err := (&LeaseRequest{Duration: "1d", Priority: lease.Normal, Request: req(), Wait: false}).lease(ctx, "u", nil)
+	assert.NotNil(err)
+
+	l, err := (&LeaseRequest{Duration: "1h", Priority: lease.High, Request: req(), Wait: true}).lease(ctx, "u", nil)
+	assert.Nil(err)
+	assert.Equal("u", l.Owner())
+	assert.Equal(lease.High, l.Priority)
+	assert.Equal(zebra.Inactive, l.Status.State)
+}
+
+func TestWaitlist(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "waitlist_test"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+	lab := dc.NewLab("a", zebra.Labels{"system.group": "labs"})
+	assert.Nil(api.create(context.Background(), lab))
+
+	alice := makeClaims(assert, "alice@zebra", true)
+	bob := makeClaims(assert, "bob@zebra", true)
+	carol := makeClaims(assert, "carol@zebra", false)
+
+	serve := func(h httprouter.Handle, claims *auth.Claims, method string, id string, body string,
+	) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/api/v1/leases", strings.NewReader(body)).WithContext(ctx)
+
+		h(rr, req, httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	body := `{"duration":"1h","request":[{"type":"Lab","group":"labs","count":1}]}`
+
+	// Alice gets the lab right away
+	rr := serve(handleRequestLease(), alice, "POST", "", body)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	held := new(lease.Lease)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), held))
+	assert.Equal(zebra.Active, held.Status.State)
+
+	// Bob does not wait for it
+	rr = serve(handleRequestLease(), bob, "POST", "", body)
+	assert.Equal(http.StatusConflict, rr.Code)
+
+	allocs := []Allocation{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &allocs))
+	assert.Equal(1, allocs[0].Missing)
+	assert.Equal([]Blocker{{Reason: BlockedLeased, Count: 1, Leases: []string{held.ID}}}, allocs[0].Blockers)
+	assert.Empty(pendingLeases(api.view()))
+
+	// Then he does, and carol after him
+	rr = serve(handleRequestLease(), bob, "POST", "", strings.Replace(body, "{", `{"wait":true,`, 1))
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	queued := QueuedLease{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &queued))
+	assert.Equal(1, queued.Position)
+	assert.Equal("bob@zebra", queued.Owner)
+
+	waiting := pendingLease("carol@zebra", lease.Normal, 1)
+	assert.Nil(api.create(context.Background(), waiting))
+
+	rr = serve(handleQueuedLease(), carol, "GET", waiting.ID, "")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &queued))
+	assert.Equal(2, queued.Position)
+
+	assert.Equal(http.StatusConflict, serve(handleQueuedLease(), alice, "GET", held.ID, "").Code)
+	assert.Equal(http.StatusNotFound, serve(handleQueuedLease(), alice, "GET", "x", "").Code)
+
+	// The lab goes to bob once alice's lease expires
+	result, err := reapLeases(context.Background(), api, time.Now().Add(2*time.Hour))
+	assert.Nil(err)
+	assert.Equal("reaped 1 leases, renewed 0 leases", result)
+
+	result, err = allocateLeases(context.Background(), api, time.Now())
+	assert.Nil(err)
+	assert.Equal("activated 1 leases, preempted 0 leases, ended 0 preempted leases", result)
+
+	inbox := api.Inbox.List("bob@zebra")
+	assert.Equal(1, len(inbox))
+	assert.Equal("lease activated", inbox[0].Subject)
+	assert.Equal("bob@zebra", findResource(api.Store, lab.ID).GetStatus().UsedBy)
+
+	// Carol is now first, only she and admins can take her lease off the queue
+	assert.Equal(http.StatusOK, serve(handleQueuedLease(), carol, "GET", waiting.ID, "").Code)
+	assert.Equal(http.StatusForbidden, serve(handleLeaveQueue(), makeClaims(assert, "dave@zebra", false),
+		"DELETE", waiting.ID, "").Code)
+	assert.Equal(http.StatusOK, serve(handleLeaveQueue(), carol, "DELETE", waiting.ID, "").Code)
+	assert.Equal(http.StatusNotFound, serve(handleLeaveQueue(), carol, "DELETE", waiting.ID, "").Code)
+	assert.Empty(pendingLeases(api.view()))
+
+	entries := api.Audit.Entries()
+	assert.Equal("lease.withdraw", entries[len(entries)-1].Action)
+
+	// Malformed requests
+	assert.Equal(http.StatusBadRequest, serve(handleRequestLease(), bob, "POST", "", "{").Code)
+	assert.Equal(http.StatusBadRequest, serve(handleRequestLease(), bob, "POST", "", `{"duration":"1h"}`).Code)
+	assert.Equal(http.StatusForbidden, serve(handleRequestLease(), carol, "POST", "", body).Code)
+}
+
+func TestCancelPreemption(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "waitlist_testcancel"
+
+	defer func() { os.RemoveAll(root) }()
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(root))
+
+	ctx := context.Background()
+	lab := makeOwnedLab("alice@zebra")
+	lab.Status.Lease = zebra.Leased
+
+	held := pendingLease("alice@zebra", lease.Low, 1)
+	assert.Nil(held.Request[0].Assign(lab))
+	assert.Nil(held.Activate())
+
+	high := pendingLease("bob@zebra", lease.High, 1)
+
+	for _, r := range []zebra.Resource{lab, held, high} {
+		assert.Nil(api.create(ctx, r))
+	}
+
+	_, err := allocateLeases(ctx, api, time.Now())
+	assert.Nil(err)
+
+	preempted, ok := findResource(api.Store, held.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.NotNil(preempted.Preemption)
+	assert.Nil(held.Preemption)
+
+	// Bob leaves the queue, alice keeps her lease
+	assert.Nil(api.delete(ctx, high))
+
+	_, err = allocateLeases(ctx, api, time.Now().Add(DefaultGracePeriod+time.Minute))
+	assert.Nil(err)
+
+	kept, ok := findResource(api.Store, held.ID).(*lease.Lease)
+	assert.True(ok)
+	assert.Equal(zebra.Active, kept.Status.State)
+	assert.Nil(kept.Preemption)
+
+	inbox := api.Inbox.List("alice@zebra")
+	assert.Equal("lease preemption cancelled", inbox[len(inbox)-1].Subject)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/project-safari/zebra/notify"
+	"github.com/project-safari/zebra/scheduler"
+)
+
+// warrantyNotices remembers the warranties that were notified, so that each
+// warranty end is notified once however often the task runs.
+type warrantyNotices struct {
+	lock     sync.Mutex
+	notified map[string]bool
+}
+
+// warrantyTask returns a task that notifies the owners of the hardware whose
+// warranty ends within the "within" argument, 90d by default, and the user
+// of the "notify" argument, if given, of all of them.
+func warrantyTask(api *ResourceAPI, args map[string]string) (scheduler.Task, error) {
+	within := DefaultWarrantyWithin
+
+	if args["within"] != "" {
+		d, err := parseWithin(args["within"])
+		if err != nil || d <= 0 {
+			return nil, ErrJobArg
+		}
+
+		within = d
+	}
+
+	notices := &warrantyNotices{lock: sync.Mutex{}, notified: map[string]bool{}}
+
+	return func(ctx context.Context) (string, error) {
+		return notices.notify(api, within, args["notify"], time.Now()), nil
+	}, nil
+}
+
+// notify sends the notifications of the warranties ending within the window
+// that were not notified yet and returns the task result.
+func (w *warrantyNotices) notify(api *ResourceAPI, within time.Duration, to string, now time.Time) string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	sent := 0
+
+	for _, e := range expiringAssets(api.view(), within, now) {
+		key := e.res.GetID() + "/" + e.asset.WarrantyEnd
+		if w.notified[key] {
+			continue
+		}
+
+		w.notified[key] = true
+		message := fmt.Sprintf("the warranty of %s %s ends on %s, in %d days",
+			e.res.GetType(), e.res.GetID(), e.asset.WarrantyEnd, daysLeft(e.ends, now))
+
+		for _, user := range []string{resourceOwner(e.res), to} {
+			if user != "" {
+				_ = api.Inbox.Notify(notify.NewNotification(user, "warranty expiring", message, e.res.GetID()))
+				sent++
+			}
+		}
+	}
+
+	return fmt.Sprintf("sent %d warranty notifications", sent)
+}
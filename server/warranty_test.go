@@ -0,0 +1,91 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiringWarranties(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	assert.Nil(api.Initialize(t.TempDir()))
+
+	now := time.Now().UTC()
+	labels := zebra.Labels{"system.group": "labs"}
+	warranty := func(days int) *zebra.Asset {
+		return &zebra.Asset{
+			PurchaseDate: "", CostCenter: "cc-1", Vendor: "acme",
+			WarrantyEnd: now.AddDate(0, 0, days).Format(zebra.DateFormat),
+		}
+	}
+
+	soon := compute.NewServer([]string{"s1", "m", "soon"}, net.ParseIP("10.0.0.1"), labels)
+	soon.Asset = warranty(10)
+	soon.Status.UsedBy = "alice@zebra"
+
+	later := network.NewSwitch([]string{"s2", "m", "later"}, 48, net.ParseIP("10.0.0.2"), labels)
+	later.Asset = warranty(60)
+
+	expired := compute.NewServer([]string{"s3", "m", "expired"}, net.ParseIP("10.0.0.3"), labels)
+	expired.Asset = warranty(-1)
+
+	none := compute.NewServer([]string{"s4", "m", "none"}, net.ParseIP("10.0.0.4"), labels)
+
+	resources := zebra.NewResourceMap(store.DefaultFactory())
+	for _, r := range []zebra.Resource{soon, later, expired, none} {
+		resources.Add(r, r.GetType())
+		assert.Nil(api.Store.Create(r))
+	}
+
+	opts, err := parseReportOptions("", "")
+	assert.Nil(err)
+
+	report, err := generateReport("expiring-warranties", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal(2, len(report.Rows))
+	assert.Equal([]string{
+		soon.ID, "Server", "labs", "alice@zebra", "acme", "cc-1", soon.Asset.WarrantyEnd, "11",
+	}, report.Rows[0])
+	assert.Equal(later.ID, report.Rows[1][0])
+
+	opts, err = parseReportOptions("", "30d")
+	assert.Nil(err)
+	assert.Equal(30*24*time.Hour, opts.within)
+
+	report, err = generateReport("expiring-warranties", resources, opts, now)
+	assert.Nil(err)
+	assert.Equal(1, len(report.Rows))
+
+	_, err = parseReportOptions("", "xd")
+	assert.ErrorIs(err, ErrReportWithin)
+
+	// The owners and the configured user are notified once
+	_, err = warrantyTask(api, map[string]string{"within": "-3d"})
+	assert.ErrorIs(err, ErrJobArg)
+
+	task, err := newTask(api, JobConfig{
+		Name: "warranties", Schedule: "@daily", Task: TaskWarrantyExpiry,
+		Args: map[string]string{"within": "30d", "notify": "assets@zebra"},
+	})
+	assert.Nil(err)
+
+	result, err := task(context.Background())
+	assert.Nil(err)
+	assert.Equal("sent 2 warranty notifications", result)
+	assert.Equal(1, len(api.Inbox.List("alice@zebra")))
+	assert.Equal(soon.ID, api.Inbox.List("assets@zebra")[0].Resource)
+
+	result, err = task(context.Background())
+	assert.Nil(err)
+	assert.Equal("sent 0 warranty notifications", result)
+}
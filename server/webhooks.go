@@ -0,0 +1,397 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/metrics"
+	"github.com/project-safari/zebra/outbox"
+)
+
+// Headers sent with every webhook delivery. The delivery header is the same
+// for every attempt of a delivery, receivers use it to discard duplicates.
+// The signature header is the hex encoded HMAC-SHA256 of the body, keyed
+// with the secret of the webhook, if the webhook has one.
+const (
+	DeliveryHeader  = "Zebra-Delivery"
+	SignatureHeader = "Zebra-Signature"
+)
+
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+	outboxPollInterval    = time.Second
+	outboxPageSize        = 500
+)
+
+var (
+	ErrWebhookName   = errors.New("webhook name must be set and unique")
+	ErrWebhookURL    = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookStatus = errors.New("webhook responded with an error status")
+	ErrUnknownTarget = errors.New("delivery target is not a configured webhook")
+	ErrOutboxAdmin   = errors.New("the outbox requires admin privileges")
+)
+
+var webhookDeliveries = metrics.Default.Counter("zebra_webhook_deliveries_total",
+	"Webhook delivery attempts, by webhook and result.", "webhook", "result")
+
+// WebhookConfig is a webhook of the server configuration. Events of the
+// given event types for resources of the given types are posted to the URL,
+// empty lists match everything.
+type WebhookConfig struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Types  []string `json:"types,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// OutboxConfig is the outbox of the server configuration. Backoff and
+// MaxBackoff are durations such as "1s".
+type OutboxConfig struct {
+	Webhooks    []WebhookConfig `json:"webhooks"`
+	MaxAttempts int             `json:"maxAttempts,omitempty"`
+	Backoff     string          `json:"backoff,omitempty"`
+	MaxBackoff  string          `json:"maxBackoff,omitempty"`
+}
+
+func (w *WebhookConfig) matches(e events.Event) bool {
+	return (len(w.Types) == 0 || zebra.IsIn(e.Kind, w.Types)) && (len(w.Events) == 0 || zebra.IsIn(e.Type, w.Events))
+}
+
+// webhookDispatcher enqueues the resource events in the outbox and delivers
+// them to the webhooks.
+type webhookDispatcher struct {
+	outbox *outbox.Outbox
+	events *events.Log
+	hooks  map[string]WebhookConfig
+	client *http.Client
+}
+
+// newWebhookDispatcher returns a dispatcher for the configured webhooks with
+// the outbox stored at path, or nil if there are no webhooks.
+func newWebhookDispatcher(cfg *OutboxConfig, path string, log *events.Log) (*webhookDispatcher, error) {
+	if len(cfg.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	hooks := make(map[string]WebhookConfig, len(cfg.Webhooks))
+
+	for _, hook := range cfg.Webhooks {
+		if _, ok := hooks[hook.Name]; ok || hook.Name == "" {
+			return nil, ErrWebhookName
+		}
+
+		u, err := url.Parse(hook.URL)
+		if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("webhook %s: %w", hook.Name, ErrWebhookURL)
+		}
+
+		hooks[hook.Name] = hook
+	}
+
+	box, err := newOutbox(path, cfg.MaxAttempts, cfg.Backoff, cfg.MaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	// A new outbox starts with the events to come, not the retained ones
+	if box.Cursor() == 0 {
+		if _, err := box.Enqueue(log.Latest(), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &webhookDispatcher{
+		outbox: box,
+		events: log,
+		hooks:  hooks,
+		client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}, nil
+}
+
+// newOutbox returns the initialized outbox at path, backoff and maxBackoff
+// are durations such as "1s" or empty for the defaults.
+func newOutbox(path string, maxAttempts int, backoff string, maxBackoff string) (*outbox.Outbox, error) {
+	durations := make([]time.Duration, 2) //nolint:gomnd
+
+	for i, s := range []string{backoff, maxBackoff} {
+		if s == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+
+		durations[i] = d
+	}
+
+	box := outbox.NewOutbox(path, maxAttempts, durations[0], durations[1])
+	if err := box.Initialize(); err != nil {
+		return nil, err
+	}
+
+	return box, nil
+}
+
+// enqueue adds a delivery to the outbox for each webhook matching each event
+// since the outbox cursor and returns the number of deliveries added.
+func (d *webhookDispatcher) enqueue(now time.Time) (int, error) {
+	added := 0
+
+	for {
+		page, cursor, err := d.events.Since(d.outbox.Cursor(), outboxPageSize)
+		if errors.Is(err, events.ErrCursorExpired) {
+			// The events were dropped before they could be enqueued, all
+			// that can be done is to continue with the oldest retained
+			page, cursor, err = d.events.Since(0, outboxPageSize)
+		}
+
+		if err != nil {
+			return added, err
+		}
+
+		if len(page) == 0 {
+			return added, nil
+		}
+
+		deliveries := []outbox.Delivery{}
+
+		for _, e := range page {
+			for _, hook := range d.hooks {
+				if hook.matches(e) {
+					payload, err := json.Marshal(e)
+					if err != nil {
+						return added, err
+					}
+
+					key := fmt.Sprintf("%s/%d", hook.Name, e.Seq)
+					deliveries = append(deliveries, outbox.NewDelivery(key, hook.Name, payload, now))
+				}
+			}
+		}
+
+		enqueued, err := d.outbox.Enqueue(cursor, deliveries)
+		added += len(enqueued)
+
+		if err != nil {
+			return added, err
+		}
+	}
+}
+
+// send posts the delivery to its webhook, any response other than 2xx is a
+// failed attempt.
+func (d *webhookDispatcher) send(ctx context.Context, delivery outbox.Delivery) error {
+	hook, ok := d.hooks[delivery.Target]
+	if !ok {
+		return ErrUnknownTarget
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(hook.Secret, delivery.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrWebhookStatus, resp.Status)
+	}
+
+	return nil
+}
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// dispatch attempts the due deliveries and records the outcome of each in
+// the outbox.
+func (d *webhookDispatcher) dispatch(ctx context.Context, now time.Time) {
+	dispatchOutbox(ctx, d.outbox, now, d.send, webhookDeliveries)
+}
+
+// dispatchOutbox sends the due deliveries of the outbox and records the
+// outcome of each in the outbox and in the counter, by target and result.
+func dispatchOutbox(ctx context.Context, box *outbox.Outbox, now time.Time,
+	send func(context.Context, outbox.Delivery) error, counter *metrics.Counter,
+) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for _, delivery := range box.Due(now) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := send(ctx, delivery); err != nil {
+			failed, e := box.Fail(delivery.ID, err, time.Now())
+			if e != nil {
+				log.Error(e, "failed to record delivery attempt", "delivery", delivery.ID)
+			}
+
+			result := "failed"
+			if failed.Status == outbox.Dead {
+				result = "dead"
+
+				log.Error(err, "delivery dead-lettered", "delivery", delivery.ID, "target", delivery.Target)
+			}
+
+			counter.Inc(delivery.Target, result)
+
+			continue
+		}
+
+		if _, err := box.Ack(delivery.ID, time.Now()); err != nil {
+			log.Error(err, "failed to record delivery", "delivery", delivery.ID)
+		}
+
+		counter.Inc(delivery.Target, "delivered")
+	}
+}
+
+// run enqueues and dispatches deliveries as events are appended and retries
+// are due, until the context is done.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx)
+	ticker := time.NewTicker(outboxPollInterval)
+
+	defer ticker.Stop()
+
+	for {
+		changed := d.events.Changed()
+
+		if _, err := d.enqueue(time.Now()); err != nil {
+			log.Error(err, "failed to enqueue webhook deliveries")
+		}
+
+		d.dispatch(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+func outboxContext(res http.ResponseWriter, req *http.Request) (*ResourceAPI, *auth.Claims, bool) {
+	ctx := req.Context()
+	api, apiOK := ctx.Value(ResourcesCtxKey).(*ResourceAPI)
+	claims, claimsOK := ctx.Value(ClaimsCtxKey).(*auth.Claims)
+
+	if !apiOK || !claimsOK {
+		res.WriteHeader(http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	if api.webhooks == nil {
+		res.WriteHeader(http.StatusNotFound)
+
+		return nil, nil, false
+	}
+
+	if !claims.Write(AdminKey) {
+		http.Error(res, ErrOutboxAdmin.Error(), http.StatusForbidden)
+
+		return nil, nil, false
+	}
+
+	return api, claims, true
+}
+
+// handleOutbox lists the webhook deliveries, optionally only those with the
+// status given in the query.
+func handleOutbox() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		api, _, ok := outboxContext(res, req)
+		if !ok {
+			return
+		}
+
+		status := outbox.Status(req.URL.Query().Get("status"))
+
+		writeJSON(req.Context(), res, api.webhooks.outbox.List(status))
+	}
+}
+
+func handleDelivery() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		api, _, ok := outboxContext(res, req)
+		if !ok {
+			return
+		}
+
+		delivery, err := api.webhooks.outbox.Get(params.ByName("id"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(req.Context(), res, delivery)
+	}
+}
+
+// handleRequeue makes a failed or dead-lettered delivery due again.
+func handleRequeue() httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := req.Context()
+		log := logr.FromContextOrDiscard(ctx)
+
+		api, claims, ok := outboxContext(res, req)
+		if !ok {
+			return
+		}
+
+		delivery, err := api.webhooks.outbox.Requeue(params.ByName("id"), time.Now())
+
+		switch {
+		case errors.Is(err, outbox.ErrNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+
+			return
+		case err != nil:
+			http.Error(res, err.Error(), http.StatusConflict)
+
+			return
+		}
+
+		api.recordAudit(ctx, "outbox.requeue", delivery.ID, delivery.Target)
+		log.Info("delivery requeued", "delivery", delivery.ID, "user", claims.Email)
+
+		writeJSON(ctx, res, delivery)
+	}
+}
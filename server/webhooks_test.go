@@ -0,0 +1,245 @@
+package server //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/events"
+	"github.com/project-safari/zebra/outbox"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWebhookDispatcher(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	log := events.NewLog("", 0, 0)
+
+	d, err := newWebhookDispatcher(&OutboxConfig{Webhooks: nil}, "", log)
+	assert.Nil(err)
+	assert.Nil(d)
+
+	for _, hooks := range [][]WebhookConfig{
+		{{Name: "", URL: "http://localhost"}},
+		{{Name: "a", URL: "http://localhost"}, {Name: "a", URL: "http://localhost"}},
+		{{Name: "a", URL: "localhost"}},
+		{{Name: "a", URL: "ftp://localhost"}},
+	} {
+		_, err := newWebhookDispatcher(&OutboxConfig{Webhooks: hooks}, "", log)
+		assert.NotNil(err)
+	}
+
+	_, err = newWebhookDispatcher(&OutboxConfig{
+		Webhooks: []WebhookConfig{{Name: "a", URL: "http://localhost"}}, Backoff: "soon",
+	}, "", log)
+	assert.NotNil(err)
+}
+
+type receiver struct {
+	lock       sync.Mutex
+	fail       bool
+	deliveries []string
+	signed     int
+}
+
+func (r *receiver) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.fail {
+		res.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	r.deliveries = append(r.deliveries, req.Header.Get(DeliveryHeader))
+
+	if signature := req.Header.Get(SignatureHeader); signature != "" {
+		body, _ := io.ReadAll(req.Body)
+		if signature == sign("s3cret", body) {
+			r.signed++
+		}
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := "webhooks_testdispatcher"
+	assert.Nil(os.MkdirAll(root, os.ModePerm))
+
+	defer func() { os.RemoveAll(root) }()
+
+	recv := &receiver{lock: sync.Mutex{}, fail: true, deliveries: nil, signed: 0}
+	server := httptest.NewServer(recv)
+
+	defer server.Close()
+
+	log := events.NewLog("", 0, 0)
+	lab := makeOwnedLab("user@zebra")
+
+	// Events before the outbox existed are not delivered
+	e, err := events.NewEvent(events.Created, lab, "user@zebra")
+	assert.Nil(err)
+
+	_, err = log.Append(e)
+	assert.Nil(err)
+
+	cfg := &OutboxConfig{
+		Webhooks: []WebhookConfig{
+			{Name: "labs", URL: server.URL, Secret: "s3cret", Types: []string{"Lab"}},
+			{Name: "deletes", URL: server.URL, Events: []string{events.Deleted}},
+		},
+		MaxAttempts: 2, Backoff: "1ms", MaxBackoff: "1ms",
+	}
+
+	d, err := newWebhookDispatcher(cfg, path.Join(root, "outbox.json"), log)
+	assert.Nil(err)
+
+	for _, eventType := range []string{events.Updated, events.Deleted} {
+		e, err := events.NewEvent(eventType, lab, "user@zebra")
+		assert.Nil(err)
+
+		_, err = log.Append(e)
+		assert.Nil(err)
+	}
+
+	added, err := d.enqueue(time.Now())
+	assert.Nil(err)
+	assert.Equal(3, added)
+
+	added, err = d.enqueue(time.Now())
+	assert.Nil(err)
+	assert.Equal(0, added)
+
+	ctx := context.Background()
+
+	d.dispatch(ctx, time.Now())
+	assert.Equal(3, len(d.outbox.List(outbox.Pending)))
+
+	time.Sleep(2 * time.Millisecond)
+	d.dispatch(ctx, time.Now())
+
+	dead := d.outbox.List(outbox.Dead)
+	assert.Equal(3, len(dead))
+	assert.Contains(dead[0].LastError, "503")
+
+	// Requeued deliveries are delivered once the receiver is back
+	recv.fail = false
+
+	for _, delivery := range dead {
+		_, err := d.outbox.Requeue(delivery.ID, time.Now())
+		assert.Nil(err)
+	}
+
+	d.dispatch(ctx, time.Now())
+	assert.Equal(3, len(d.outbox.List(outbox.Delivered)))
+	assert.ElementsMatch([]string{dead[0].ID, dead[1].ID, dead[2].ID}, recv.deliveries)
+
+	assert.Equal(2, recv.signed)
+
+	// The outbox continues where it left off after a restart
+	restarted, err := newWebhookDispatcher(cfg, path.Join(root, "outbox.json"), log)
+	assert.Nil(err)
+	assert.Equal(uint64(3), restarted.outbox.Cursor())
+	assert.Equal(3, len(restarted.outbox.List(outbox.Delivered)))
+
+	// The dispatcher delivers new events as they are appended
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		restarted.run(runCtx)
+		close(done)
+	}()
+
+	e, err = events.NewEvent(events.Deleted, lab, "user@zebra")
+	assert.Nil(err)
+
+	_, err = log.Append(e)
+	assert.Nil(err)
+
+	assert.Eventually(func() bool { return len(restarted.outbox.List(outbox.Delivered)) == 5 },
+		5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestOutboxHandlers(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	api := NewResourceAPI(store.DefaultFactory())
+	admin := makeClaims(assert, "admin@zebra", true)
+	user := makeClaims(assert, "user@zebra", false)
+
+	serve := func(h httprouter.Handle, claims *auth.Claims, url string, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), ResourcesCtxKey, api)
+		ctx = context.WithValue(ctx, ClaimsCtxKey, claims)
+		rr := httptest.NewRecorder()
+
+		h(rr, httptest.NewRequest("GET", url, nil).WithContext(ctx), httprouter.Params{{Key: "id", Value: id}})
+
+		return rr
+	}
+
+	// Without webhooks there is no outbox
+	assert.Equal(http.StatusNotFound, serve(handleOutbox(), admin, "/api/v1/outbox", "").Code)
+
+	d, err := newWebhookDispatcher(&OutboxConfig{
+		Webhooks: []WebhookConfig{{Name: "hook", URL: "http://localhost"}}, MaxAttempts: 1,
+	}, "", api.Events)
+	assert.Nil(err)
+
+	api.webhooks = d
+
+	added, err := d.outbox.Enqueue(1, []outbox.Delivery{
+		outbox.NewDelivery("hook/1", "hook", nil, time.Now()),
+		outbox.NewDelivery("hook/2", "hook", nil, time.Now()),
+	})
+	assert.Nil(err)
+
+	_, err = d.outbox.Fail(added[0].ID, ErrWebhookStatus, time.Now())
+	assert.Nil(err)
+
+	_, err = d.outbox.Ack(added[1].ID, time.Now())
+	assert.Nil(err)
+
+	assert.Equal(http.StatusForbidden, serve(handleOutbox(), user, "/api/v1/outbox", "").Code)
+
+	rr := serve(handleOutbox(), admin, "/api/v1/outbox?status=dead", "")
+	assert.Equal(http.StatusOK, rr.Code)
+
+	list := []outbox.Delivery{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Equal(1, len(list))
+	assert.Equal(added[0].ID, list[0].ID)
+
+	assert.Equal(http.StatusOK, serve(handleDelivery(), admin, "/", added[1].ID).Code)
+	assert.Equal(http.StatusNotFound, serve(handleDelivery(), admin, "/", "x").Code)
+
+	assert.Equal(http.StatusOK, serve(handleRequeue(), admin, "/", added[0].ID).Code)
+	assert.Equal(1, len(d.outbox.Due(time.Now())))
+	assert.Equal(http.StatusConflict, serve(handleRequeue(), admin, "/", added[1].ID).Code)
+	assert.Equal(http.StatusNotFound, serve(handleRequeue(), admin, "/", "x").Code)
+
+	entries := api.Audit.Entries()
+	assert.Equal("outbox.requeue", entries[len(entries)-1].Action)
+
+	rr = httptest.NewRecorder()
+	handleOutbox()(rr, httptest.NewRequest("GET", "/api/v1/outbox", nil), nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
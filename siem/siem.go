@@ -0,0 +1,212 @@
+// Package siem formats security records for SIEMs as ArcSight CEF or IBM
+// QRadar LEEF messages, and frames them as RFC 5424 syslog messages.
+package siem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Vendor  = "project-safari"
+	Product = "zebra"
+)
+
+// Formats of the messages.
+const (
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+	FormatJSON = "json"
+)
+
+// Classes of the records.
+const (
+	ClassAudit = "audit"
+	ClassEvent = "event"
+)
+
+// MaxSeverity is the severity of the most severe records, as in CEF.
+const MaxSeverity = 10
+
+// facilityAudit is the log audit facility of RFC 5424.
+const facilityAudit = 13
+
+var ErrFormat = errors.New("unknown SIEM format")
+
+// Record is an audit entry or event to be sent to a SIEM. Severity is from 0
+// to MaxSeverity.
+type Record struct {
+	Time         time.Time `json:"time"`
+	Class        string    `json:"class"`
+	Name         string    `json:"name"`
+	Severity     int       `json:"severity"`
+	Actor        string    `json:"actor,omitempty"`
+	ActorType    string    `json:"actorType,omitempty"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	Resource     string    `json:"resource,omitempty"`
+	Kind         string    `json:"kind,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+// ValidFormat returns true if the format is known.
+func ValidFormat(format string) bool {
+	return format == FormatCEF || format == FormatLEEF || format == FormatJSON
+}
+
+// Format returns the record as a message of the format, version is the
+// version of zebra reported in the message.
+func Format(format string, version string, r Record) ([]byte, error) {
+	switch format {
+	case FormatCEF:
+		return []byte(CEF(version, r)), nil
+	case FormatLEEF:
+		return []byte(LEEF(version, r)), nil
+	case FormatJSON:
+		return json.Marshal(r)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrFormat, format)
+}
+
+// CEF returns the record as a CEF message.
+func CEF(version string, r Record) string {
+	header := []string{
+		"CEF:0", cefHeader(Vendor), cefHeader(Product), cefHeader(version),
+		cefHeader(r.Name), cefHeader(r.Class + " " + r.Name), strconv.Itoa(r.Severity),
+	}
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(r.Time.UnixMilli(), 10),
+		"cat=" + cefValue(r.Class),
+		"act=" + cefValue(r.Name),
+	}
+
+	ext = appendValue(ext, "suser", r.Actor, cefValue)
+
+	// Fields CEF has no key for are custom strings, labeled with their name
+	for i, cs := range [][2]string{
+		{"actorType", r.ActorType},
+		{"impersonator", r.Impersonator},
+		{"resource", r.Resource},
+		{"kind", r.Kind},
+	} {
+		if cs[1] != "" {
+			ext = append(ext, fmt.Sprintf("cs%dLabel=%s", i+1, cs[0]), fmt.Sprintf("cs%d=%s", i+1, cefValue(cs[1])))
+		}
+	}
+
+	ext = appendValue(ext, "msg", r.Detail, cefValue)
+
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
+
+// LEEF returns the record as a LEEF 2.0 message with tab delimited
+// attributes.
+func LEEF(version string, r Record) string {
+	header := []string{
+		"LEEF:2.0", leefHeader(Vendor), leefHeader(Product), leefHeader(version), leefHeader(r.Name),
+	}
+
+	attrs := []string{
+		"devTime=" + strconv.FormatInt(r.Time.UnixMilli(), 10),
+		"devTimeFormat=epoch",
+		"cat=" + leefValue(r.Class),
+		"sev=" + strconv.Itoa(r.Severity),
+	}
+
+	for _, kv := range [][2]string{
+		{"usrName", r.Actor},
+		{"actorType", r.ActorType},
+		{"impersonator", r.Impersonator},
+		{"resource", r.Resource},
+		{"kind", r.Kind},
+		{"msg", r.Detail},
+	} {
+		attrs = appendValue(attrs, kv[0], kv[1], leefValue)
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(attrs, "\t")
+}
+
+// Syslog returns the message framed as an RFC 5424 syslog message of the
+// log audit facility from the host.
+func Syslog(hostname string, r Record, msg []byte) []byte {
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	pri := facilityAudit*8 + SyslogSeverity(r.Severity) //nolint:gomnd
+	header := fmt.Sprintf("<%d>1 %s %s %s - %s - ", pri, r.Time.UTC().Format(time.RFC3339Nano), hostname,
+		Product, msgID(r.Name))
+
+	return append([]byte(header), msg...)
+}
+
+// SyslogSeverity returns the syslog severity, from 0 for emergency to 7 for
+// debug, of the record severity.
+func SyslogSeverity(severity int) int {
+	switch {
+	case severity >= 9: //nolint:gomnd
+		return 2 // critical
+	case severity >= 7: //nolint:gomnd
+		return 3 // error
+	case severity >= 4: //nolint:gomnd
+		return 4 // warning
+	case severity >= 2: //nolint:gomnd
+		return 5 // notice
+	}
+
+	return 6 //nolint:gomnd // info
+}
+
+// msgID returns the name as a syslog message ID, which is at most 32
+// printable characters without spaces.
+func msgID(name string) string {
+	const maxMsgID = 32
+
+	id := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+
+		return r
+	}, name)
+
+	if len(id) > maxMsgID {
+		id = id[:maxMsgID]
+	}
+
+	if id == "" {
+		return "-"
+	}
+
+	return id
+}
+
+func appendValue(list []string, key string, value string, escape func(string) string) []string {
+	if value == "" {
+		return list
+	}
+
+	return append(list, key+"="+escape(value))
+}
+
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+func leefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+func leefValue(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
@@ -0,0 +1,77 @@
+package siem_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/siem"
+	"github.com/stretchr/testify/assert"
+)
+
+func record() siem.Record {
+	return siem.Record{
+		Time:         time.UnixMilli(1700000000000),
+		Class:        siem.ClassAudit,
+		Name:         "auth.login",
+		Severity:     6,
+		Actor:        "a@zebra",
+		ActorType:    "",
+		Impersonator: "",
+		Resource:     "",
+		Kind:         "",
+		Detail:       "bad password|a=b\nnext",
+	}
+}
+
+func TestCEF(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	r := record()
+	assert.Equal(`CEF:0|project-safari|zebra|1.0|auth.login|audit auth.login|6|`+
+		`rt=1700000000000 cat=audit act=auth.login suser=a@zebra msg=bad password|a\=b\nnext`, siem.CEF("1.0", r))
+
+	r.Name = "a|b"
+	r.Resource = `r\1`
+	msg := siem.CEF("1.0", r)
+	assert.Contains(msg, `|a\|b|audit a\|b|`)
+	assert.Contains(msg, `cs3Label=resource cs3=r\\1`)
+	assert.NotContains(msg, "cs1Label")
+}
+
+func TestLEEF(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	msg := siem.LEEF("1.0", record())
+	assert.True(strings.HasPrefix(msg, "LEEF:2.0|project-safari|zebra|1.0|auth.login|devTime=1700000000000\t"))
+	assert.Contains(msg, "\tusrName=a@zebra\t")
+	assert.True(strings.HasSuffix(msg, "\tmsg=bad password|a=b next"))
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	_, err := siem.Format("xml", "1.0", record())
+	assert.ErrorIs(err, siem.ErrFormat)
+	assert.False(siem.ValidFormat("xml"))
+
+	data, err := siem.Format(siem.FormatJSON, "1.0", record())
+	assert.Nil(err)
+
+	r := siem.Record{}
+	assert.Nil(json.Unmarshal(data, &r))
+	assert.Equal("auth.login", r.Name)
+
+	// Log audit facility with the warning severity
+	msg := string(siem.Syslog("zebra-1", record(), []byte("CEF:0|x")))
+	assert.Equal("<108>1 2023-11-14T22:13:20Z zebra-1 zebra - auth.login - CEF:0|x", msg)
+
+	r.Name = ""
+	r.Severity = 10
+	assert.True(strings.HasPrefix(string(siem.Syslog("", r, nil)), "<106>1 2023-11-14T22:13:20Z - zebra - - - "))
+	assert.Equal(6, siem.SyslogSeverity(0))
+}
@@ -0,0 +1,374 @@
+// Package sitesync merges the changes of zebra servers that were
+// disconnected from each other, such as an air-gapped lab and the central
+// server. Each server tracks when and where its resources were last changed,
+// exports the changes since the last sync as a bundle and imports the
+// bundles of the other servers, resolving conflicting changes by last
+// writer wins or by vector clocks.
+package sitesync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RWRR = os.FileMode(0o644)
+
+	// maxLineSize limits the size of a single stored entry.
+	maxLineSize = 1 << 20
+)
+
+// Resolutions of conflicting changes.
+const (
+	LastWriterWins = "lww"
+	VectorClocks   = "vector"
+)
+
+// Winners of a conflict.
+const (
+	Local  = "local"
+	Remote = "remote"
+)
+
+var (
+	ErrResolution = errors.New("sync resolution must be lww or vector")
+	ErrSite       = errors.New("sync site must be set")
+)
+
+// Clock is a vector clock: the number of changes each site made to a
+// resource.
+type Clock map[string]uint64
+
+// Order is how two clocks relate.
+type Order int
+
+const (
+	Equal Order = iota
+	Before
+	After
+	Concurrent
+)
+
+// Tick returns a copy of the clock with the change of the site counted.
+func (c Clock) Tick(site string) Clock {
+	ticked := c.Merge(nil)
+	ticked[site]++
+
+	return ticked
+}
+
+// Merge returns the clock that has seen the changes of both clocks.
+func (c Clock) Merge(other Clock) Clock {
+	merged := Clock{}
+
+	for site, n := range c {
+		merged[site] = n
+	}
+
+	for site, n := range other {
+		if n > merged[site] {
+			merged[site] = n
+		}
+	}
+
+	return merged
+}
+
+// Compare returns whether the clock is equal to, before, after or
+// concurrent with the other clock.
+func (c Clock) Compare(other Clock) Order {
+	before, after := false, false
+
+	for site, n := range c.Merge(other) {
+		switch {
+		case c[site] < n:
+			before = true
+		case other[site] < n:
+			after = true
+		}
+	}
+
+	switch {
+	case before && after:
+		return Concurrent
+	case before:
+		return Before
+	case after:
+		return After
+	}
+
+	return Equal
+}
+
+// Entry is the last change of a resource: the site that made it, when and
+// the clock of the resource after it.
+type Entry struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Site     string    `json:"site"`
+	Modified time.Time `json:"modified"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Clock    Clock     `json:"clock,omitempty"`
+}
+
+// newer returns whether the entry wins over the other by last writer wins.
+// Changes made at the same time are ordered by site, so that all sites pick
+// the same winner.
+func (e Entry) newer(other Entry) bool {
+	if e.Modified.Equal(other.Modified) {
+		return e.Site > other.Site
+	}
+
+	return e.Modified.After(other.Modified)
+}
+
+// Change is an exported change, with the resource as it is after the change
+// unless it was deleted.
+type Change struct {
+	Entry
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Bundle is the changes a site made since the time of its last sync.
+type Bundle struct {
+	Site     string    `json:"site"`
+	Since    time.Time `json:"since"`
+	Exported time.Time `json:"exported"`
+	Changes  []Change  `json:"changes"`
+}
+
+// Conflict is a resource changed by both sites since the last sync, and
+// which of the changes was kept.
+type Conflict struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	LocalSite      string    `json:"localSite"`
+	LocalModified  time.Time `json:"localModified"`
+	LocalDeleted   bool      `json:"localDeleted,omitempty"`
+	RemoteSite     string    `json:"remoteSite"`
+	RemoteModified time.Time `json:"remoteModified"`
+	RemoteDeleted  bool      `json:"remoteDeleted,omitempty"`
+	Winner         string    `json:"winner"`
+}
+
+// Report is the outcome of importing a bundle.
+type Report struct {
+	Site       string     `json:"site"`
+	Resolution string     `json:"resolution"`
+	DryRun     bool       `json:"dryRun,omitempty"`
+	Applied    []string   `json:"applied"`
+	Skipped    []string   `json:"skipped"`
+	Conflicts  []Conflict `json:"conflicts"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+// NewReport returns an empty report of the import of the bundle.
+func NewReport(b *Bundle, resolution string, dryRun bool) *Report {
+	return &Report{
+		Site:       b.Site,
+		Resolution: resolution,
+		DryRun:     dryRun,
+		Applied:    []string{},
+		Skipped:    []string{},
+		Conflicts:  []Conflict{},
+		Errors:     nil,
+	}
+}
+
+// Decision is whether a remote change is applied, and the conflict it is
+// part of, if any.
+type Decision struct {
+	Apply    bool
+	Conflict *Conflict
+}
+
+// Decide decides whether the remote change of the bundle wins over the last
+// local change of the resource, if any.
+//
+// By last writer wins the later change wins, and the changes conflict if the
+// resource was changed locally since the bundle's last sync. By vector
+// clocks the remote change wins if it has seen the local change, and the
+// changes conflict if neither has seen the other; the later change wins the
+// conflict.
+func Decide(resolution string, local Entry, found bool, remote Change, since time.Time) Decision {
+	if !found {
+		return Decision{Apply: true, Conflict: nil}
+	}
+
+	var apply, conflict bool
+
+	switch resolution {
+	case VectorClocks:
+		switch remote.Clock.Compare(local.Clock) {
+		case After:
+			apply = true
+		case Concurrent:
+			apply, conflict = remote.newer(local), true
+		case Equal, Before:
+		}
+	default:
+		apply = remote.newer(local)
+		conflict = local.Site != remote.Site && local.Modified.After(since) &&
+			!(local.Deleted && remote.Deleted) && !local.Modified.Equal(remote.Modified)
+	}
+
+	if !conflict {
+		return Decision{Apply: apply, Conflict: nil}
+	}
+
+	winner := Local
+	if apply {
+		winner = Remote
+	}
+
+	return Decision{Apply: apply, Conflict: &Conflict{
+		ID:             remote.ID,
+		Type:           remote.Type,
+		LocalSite:      local.Site,
+		LocalModified:  local.Modified,
+		LocalDeleted:   local.Deleted,
+		RemoteSite:     remote.Site,
+		RemoteModified: remote.Modified,
+		RemoteDeleted:  remote.Deleted,
+		Winner:         winner,
+	}}
+}
+
+// State is the last change of each resource of a site. It is thread safe,
+// and if a path is given the changes are appended to that file as one JSON
+// object per line.
+type State struct {
+	lock    sync.RWMutex
+	path    string
+	entries map[string]Entry
+}
+
+// NewState returns the state backed by the file at path. An empty path
+// results in an in-memory state.
+func NewState(path string) *State {
+	return &State{lock: sync.RWMutex{}, path: path, entries: map[string]Entry{}}
+}
+
+// Initialize loads the entries from the backing file, if any. The last
+// entry of a resource wins.
+func (s *State) Initialize() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+
+		s.entries[e.ID] = e
+	}
+
+	return scanner.Err()
+}
+
+// Get returns the last change of the resource.
+func (s *State) Get(id string) (Entry, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	e, ok := s.entries[id]
+
+	return e, ok
+}
+
+// Changed records the change of the resource made by the site at the time,
+// and returns its entry.
+func (s *State) Changed(site string, id string, resType string, deleted bool, now time.Time) (Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := Entry{
+		ID:       id,
+		Type:     resType,
+		Site:     site,
+		Modified: now,
+		Deleted:  deleted,
+		Clock:    s.entries[id].Clock.Tick(site),
+	}
+
+	return e, s.set(e)
+}
+
+// Imported records the remote change as the last change of the resource,
+// with the clocks of both changes merged.
+func (s *State) Imported(remote Entry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	remote.Clock = s.entries[remote.ID].Clock.Merge(remote.Clock)
+
+	return s.set(remote)
+}
+
+// Since returns the entries changed after the time, sorted by ID.
+func (s *State) Since(since time.Time) []Entry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entries := []Entry{}
+
+	for _, e := range s.entries {
+		if e.Modified.After(since) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	return entries
+}
+
+// set keeps the entry and appends it to the backing file. Must be called
+// with the write lock held.
+func (s *State) set(e Entry) error {
+	s.entries[e.ID] = e
+
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, RWRR)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,118 @@
+package sitesync_test
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/sitesync"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	a := sitesync.Clock{}.Tick("lab")
+	b := a.Tick("central")
+
+	assert.Equal(sitesync.Clock{"lab": 1}, a)
+	assert.Equal(sitesync.Clock{"lab": 1, "central": 1}, b)
+	assert.Equal(sitesync.Equal, a.Compare(sitesync.Clock{"lab": 1}))
+	assert.Equal(sitesync.Before, a.Compare(b))
+	assert.Equal(sitesync.After, b.Compare(a))
+
+	c := a.Tick("lab")
+	assert.Equal(sitesync.Concurrent, b.Compare(c))
+	assert.Equal(sitesync.Clock{"lab": 2, "central": 1}, b.Merge(c))
+	assert.Equal(sitesync.Before, sitesync.Clock(nil).Compare(a))
+}
+
+func TestDecide(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	synced := time.Now().Add(-time.Hour)
+	local := sitesync.Entry{
+		ID: "a", Type: "Lab", Site: "central", Modified: synced.Add(time.Minute), Deleted: false,
+		Clock: sitesync.Clock{"central": 2, "lab": 1},
+	}
+	remote := sitesync.Change{Entry: sitesync.Entry{
+		ID: "a", Type: "Lab", Site: "lab", Modified: synced.Add(2 * time.Minute), Deleted: false,
+		Clock: sitesync.Clock{"central": 1, "lab": 2},
+	}, Data: nil}
+
+	d := sitesync.Decide(sitesync.LastWriterWins, local, false, remote, synced)
+	assert.True(d.Apply)
+	assert.Nil(d.Conflict)
+
+	// Both changed since the last sync, the later change wins
+	d = sitesync.Decide(sitesync.LastWriterWins, local, true, remote, synced)
+	assert.True(d.Apply)
+	assert.Equal(sitesync.Remote, d.Conflict.Winner)
+
+	d = sitesync.Decide(sitesync.VectorClocks, local, true, remote, synced)
+	assert.True(d.Apply)
+	assert.Equal(sitesync.Remote, d.Conflict.Winner)
+
+	// The local change was synced before
+	d = sitesync.Decide(sitesync.LastWriterWins, local, true, remote, synced.Add(time.Hour))
+	assert.True(d.Apply)
+	assert.Nil(d.Conflict)
+
+	// The remote change has seen the local change
+	remote.Clock = sitesync.Clock{"central": 2, "lab": 2}
+	remote.Modified = synced
+
+	d = sitesync.Decide(sitesync.VectorClocks, local, true, remote, synced)
+	assert.True(d.Apply)
+	assert.Nil(d.Conflict)
+
+	d = sitesync.Decide(sitesync.LastWriterWins, local, true, remote, synced)
+	assert.False(d.Apply)
+	assert.Equal(sitesync.Local, d.Conflict.Winner)
+
+	// The remote change is already known
+	d = sitesync.Decide(sitesync.VectorClocks, local, true, sitesync.Change{Entry: local, Data: nil}, synced)
+	assert.False(d.Apply)
+	assert.Nil(d.Conflict)
+}
+
+func TestState(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := path.Join(t.TempDir(), "sync.log")
+	state := sitesync.NewState(file)
+	assert.Nil(state.Initialize())
+
+	now := time.Now()
+	e, err := state.Changed("central", "a", "Lab", false, now)
+	assert.Nil(err)
+	assert.Equal(sitesync.Clock{"central": 1}, e.Clock)
+
+	_, err = state.Changed("central", "b", "Lab", false, now.Add(time.Minute))
+	assert.Nil(err)
+
+	remote := sitesync.Entry{
+		ID: "a", Type: "Lab", Site: "lab", Modified: now.Add(time.Hour), Deleted: true,
+		Clock: sitesync.Clock{"lab": 3},
+	}
+	assert.Nil(state.Imported(remote))
+
+	loaded := sitesync.NewState(file)
+	assert.Nil(loaded.Initialize())
+
+	a, ok := loaded.Get("a")
+	assert.True(ok)
+	assert.True(a.Deleted)
+	assert.Equal("lab", a.Site)
+	assert.Equal(sitesync.Clock{"central": 1, "lab": 3}, a.Clock)
+
+	entries := loaded.Since(now.Add(time.Second))
+	assert.Equal(2, len(entries))
+	assert.Equal("a", entries[0].ID)
+
+	_, ok = loaded.Get("c")
+	assert.False(ok)
+}
@@ -0,0 +1,611 @@
+// Package snmp decodes SNMP traps, the notifications network devices send
+// when something happens to them, such as a link going down.
+//
+// Only what trap receivers need is implemented: SNMPv1 and SNMPv2c trap
+// messages are decoded, and SNMPv2c traps encoded. SNMPv1 traps are
+// translated to SNMPv2 trap OIDs as described in RFC 3584, so receivers
+// handle both versions the same way.
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Versions of SNMP messages, as encoded.
+const (
+	Version1  = 0
+	Version2c = 1
+)
+
+// OIDs of the standard traps and of the variables of SNMPv2 traps.
+const (
+	OIDColdStart       = "1.3.6.1.6.3.1.1.5.1"
+	OIDWarmStart       = "1.3.6.1.6.3.1.1.5.2"
+	OIDLinkDown        = "1.3.6.1.6.3.1.1.5.3"
+	OIDLinkUp          = "1.3.6.1.6.3.1.1.5.4"
+	OIDAuthFailure     = "1.3.6.1.6.3.1.1.5.5"
+	OIDSysUpTime       = "1.3.6.1.2.1.1.3.0"
+	OIDSnmpTrapOID     = "1.3.6.1.6.3.1.1.4.1.0"
+	OIDSnmpTrapAddress = "1.3.6.1.6.3.18.1.3.0"
+)
+
+const (
+	// SNMPv1 generic traps 0 to 5 are the standard traps, by RFC 3584 the
+	// trap OIDs of the prefix followed by the generic trap plus one
+	genericTrapsPrefix = "1.3.6.1.6.3.1.1.5."
+	maxGenericTrap     = 5
+	enterpriseSpecific = 6
+
+	// SNMPv2 traps start with sysUpTime and snmpTrapOID
+	trapOIDVariable    = 1
+	minTrapV2Variables = 2
+
+	macLength           = 6
+	ipAddressLength     = 4
+	maxLengthBytes      = 4
+	highBit             = 0x80
+	lowBits             = 0x7f
+	bitsPerByte         = 8
+	bitsPerOIDByte      = 7
+	firstArcMultiplier  = 40
+	maxFirstArc         = 2
+	defaultTrapCapacity = 8
+)
+
+// BER tags of the types used in traps.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagIPAddress   = 0x40
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagOpaque      = 0x44
+	tagCounter64   = 0x46
+	tagTrapV1      = 0xa4
+	tagTrapV2      = 0xa7
+)
+
+var (
+	ErrMalformed = errors.New("malformed snmp message")
+	ErrNotTrap   = errors.New("snmp message is not a trap")
+	ErrVersion   = errors.New("unsupported snmp version")
+)
+
+// names of the standard traps, by OID.
+var names = map[string]string{ //nolint:gochecknoglobals
+	OIDColdStart:   "coldStart",
+	OIDWarmStart:   "warmStart",
+	OIDLinkDown:    "linkDown",
+	OIDLinkUp:      "linkUp",
+	OIDAuthFailure: "authenticationFailure",
+}
+
+// Name returns the name of a standard trap OID, or the OID itself.
+func Name(oid string) string {
+	if name, ok := names[oid]; ok {
+		return name
+	}
+
+	return oid
+}
+
+// OID returns the OID of a standard trap name, or the name itself.
+func OID(name string) string {
+	for oid, n := range names {
+		if n == name {
+			return oid
+		}
+	}
+
+	return name
+}
+
+// Variable is a variable binding of a trap. Values are int64 for integers,
+// uint64 for counters, gauges and time ticks, []byte for octet strings,
+// string for OIDs, net.IP for IP addresses and nil for nulls.
+type Variable struct {
+	OID   string
+	Value interface{}
+}
+
+// Trap is a decoded trap. OID identifies the trap, Agent is the address of
+// the device that sent it if the message says so, SNMPv2c messages only do
+// with the snmpTrapAddress variable.
+type Trap struct {
+	Version   int
+	Community string
+	OID       string
+	Agent     net.IP
+	Variables []Variable
+}
+
+// Value returns the value of the variable with the OID, or nil.
+func (t *Trap) Value(oid string) interface{} {
+	for _, v := range t.Variables {
+		if v.OID == oid {
+			return v.Value
+		}
+	}
+
+	return nil
+}
+
+// MACs returns the octet string values that are MAC addresses, formatted
+// like net.HardwareAddr.
+func (t *Trap) MACs() []string {
+	macs := []string{}
+
+	for _, v := range t.Variables {
+		if b, ok := v.Value.([]byte); ok && len(b) == macLength {
+			macs = append(macs, net.HardwareAddr(b).String())
+		}
+	}
+
+	return macs
+}
+
+// Parse decodes a trap message.
+func Parse(data []byte) (*Trap, error) {
+	tag, msg, _, err := next(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != tagSequence {
+		return nil, ErrMalformed
+	}
+
+	version, msg, err := readInt(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != Version1 && version != Version2c {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, version)
+	}
+
+	tag, community, msg, err := next(msg)
+	if err != nil || tag != tagOctetString {
+		return nil, ErrMalformed
+	}
+
+	tag, pdu, _, err := next(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	trap := &Trap{Version: int(version), Community: string(community), OID: "", Agent: nil, Variables: nil}
+
+	switch {
+	case tag == tagTrapV1 && version == Version1:
+		err = trap.parseV1(pdu)
+	case tag == tagTrapV2 && version == Version2c:
+		err = trap.parseV2(pdu)
+	default:
+		err = ErrNotTrap
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trap, nil
+}
+
+func (t *Trap) parseV1(pdu []byte) error {
+	tag, enterprise, pdu, err := next(pdu)
+	if err != nil || tag != tagOID {
+		return ErrMalformed
+	}
+
+	tag, agent, pdu, err := next(pdu)
+	if err != nil || tag != tagIPAddress || len(agent) != ipAddressLength {
+		return ErrMalformed
+	}
+
+	generic, pdu, err := readInt(pdu)
+	if err != nil {
+		return err
+	}
+
+	specific, pdu, err := readInt(pdu)
+	if err != nil {
+		return err
+	}
+
+	// The time stamp
+	if _, _, pdu, err = next(pdu); err != nil {
+		return err
+	}
+
+	oid, err := decodeOID(enterprise)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case generic == enterpriseSpecific:
+		t.OID = oid + ".0." + strconv.FormatInt(specific, 10)
+	case generic >= 0 && generic <= maxGenericTrap:
+		t.OID = genericTrapsPrefix + strconv.FormatInt(generic+1, 10)
+	default:
+		return ErrMalformed
+	}
+
+	t.Agent = net.IP(append([]byte{}, agent...))
+	t.Variables, err = parseVariables(pdu)
+
+	return err
+}
+
+func (t *Trap) parseV2(pdu []byte) error {
+	// The request ID, error status and error index
+	for i := 0; i < 3; i++ {
+		var err error
+
+		if _, pdu, err = readInt(pdu); err != nil {
+			return err
+		}
+	}
+
+	variables, err := parseVariables(pdu)
+	if err != nil {
+		return err
+	}
+
+	if len(variables) < minTrapV2Variables || variables[trapOIDVariable].OID != OIDSnmpTrapOID {
+		return ErrMalformed
+	}
+
+	oid, ok := variables[trapOIDVariable].Value.(string)
+	if !ok {
+		return ErrMalformed
+	}
+
+	t.OID = oid
+	t.Variables = variables
+
+	if agent, ok := t.Value(OIDSnmpTrapAddress).(net.IP); ok {
+		t.Agent = agent
+	}
+
+	return nil
+}
+
+func parseVariables(data []byte) ([]Variable, error) {
+	tag, list, _, err := next(data)
+	if err != nil || tag != tagSequence {
+		return nil, ErrMalformed
+	}
+
+	variables := make([]Variable, 0, defaultTrapCapacity)
+
+	for len(list) != 0 {
+		tag, binding, rest, err := next(list)
+		if err != nil || tag != tagSequence {
+			return nil, ErrMalformed
+		}
+
+		list = rest
+
+		tag, name, binding, err := next(binding)
+		if err != nil || tag != tagOID {
+			return nil, ErrMalformed
+		}
+
+		oid, err := decodeOID(name)
+		if err != nil {
+			return nil, err
+		}
+
+		tag, content, _, err := next(binding)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := decodeValue(tag, content)
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, Variable{OID: oid, Value: value})
+	}
+
+	return variables, nil
+}
+
+func decodeValue(tag byte, content []byte) (interface{}, error) {
+	switch tag {
+	case tagInteger:
+		return decodeInt(content)
+	case tagOctetString, tagOpaque:
+		return append([]byte{}, content...), nil
+	case tagNull:
+		return nil, nil
+	case tagOID:
+		return decodeOID(content)
+	case tagIPAddress:
+		if len(content) != ipAddressLength {
+			return nil, ErrMalformed
+		}
+
+		return net.IP(append([]byte{}, content...)), nil
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return decodeUint(content)
+	default:
+		// Values of other types, such as noSuchObject, are kept as bytes
+		return append([]byte{}, content...), nil
+	}
+}
+
+// next splits the first TLV off the data, returning its tag, content and
+// the rest of the data.
+func next(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 { //nolint:gomnd
+		return 0, nil, nil, ErrMalformed
+	}
+
+	tag, length, data := data[0], int(data[1]), data[2:]
+
+	if length&highBit != 0 {
+		n := length & lowBits
+		if n == 0 || n > maxLengthBytes || len(data) < n {
+			return 0, nil, nil, ErrMalformed
+		}
+
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<bitsPerByte | int(b)
+		}
+
+		data = data[n:]
+	}
+
+	if length < 0 || length > len(data) {
+		return 0, nil, nil, ErrMalformed
+	}
+
+	return tag, data[:length], data[length:], nil
+}
+
+func readInt(data []byte) (int64, []byte, error) {
+	tag, content, rest, err := next(data)
+	if err != nil || tag != tagInteger {
+		return 0, nil, ErrMalformed
+	}
+
+	v, err := decodeInt(content)
+
+	return v, rest, err
+}
+
+func decodeInt(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > bitsPerByte {
+		return 0, ErrMalformed
+	}
+
+	var v int64
+	if content[0]&highBit != 0 {
+		v = -1
+	}
+
+	for _, b := range content {
+		v = v<<bitsPerByte | int64(b)
+	}
+
+	return v, nil
+}
+
+func decodeUint(content []byte) (uint64, error) {
+	// Unsigned values may have a leading zero byte
+	if len(content) == 0 || len(content) > bitsPerByte+1 {
+		return 0, ErrMalformed
+	}
+
+	var v uint64
+	for _, b := range content {
+		v = v<<bitsPerByte | uint64(b)
+	}
+
+	return v, nil
+}
+
+func decodeOID(content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", ErrMalformed
+	}
+
+	arcs := []uint64{}
+
+	var arc uint64
+
+	for i, b := range content {
+		arc = arc<<bitsPerOIDByte | uint64(b&lowBits)
+
+		if b&highBit != 0 {
+			if i == len(content)-1 {
+				return "", ErrMalformed
+			}
+
+			continue
+		}
+
+		arcs = append(arcs, arc)
+		arc = 0
+	}
+
+	// The first two arcs are encoded in one
+	first := arcs[0] / firstArcMultiplier
+	if first > maxFirstArc {
+		first = maxFirstArc
+	}
+
+	parts := []string{strconv.FormatUint(first, 10), strconv.FormatUint(arcs[0]-first*firstArcMultiplier, 10)}
+	for _, a := range arcs[1:] {
+		parts = append(parts, strconv.FormatUint(a, 10))
+	}
+
+	return strings.Join(parts, "."), nil
+}
+
+// Marshal encodes the trap as an SNMPv2c trap message, with the variables
+// following sysUpTime and snmpTrapOID. Supported values are those Parse
+// returns, unsigned values are encoded as time ticks.
+func (t *Trap) Marshal() ([]byte, error) {
+	variables := []Variable{{OID: OIDSysUpTime, Value: uint64(0)}, {OID: OIDSnmpTrapOID, Value: t.OID}}
+
+	for _, v := range t.Variables {
+		if v.OID != OIDSysUpTime && v.OID != OIDSnmpTrapOID {
+			variables = append(variables, v)
+		}
+	}
+
+	list := new(bytes.Buffer)
+
+	for _, v := range variables {
+		name, err := encodeOID(v.OID)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := encodeValue(v.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		list.Write(tlv(tagSequence, append(tlv(tagOID, name), value...)))
+	}
+
+	pdu := append(tlv(tagInteger, encodeInt(0)), tlv(tagInteger, encodeInt(0))...)
+	pdu = append(pdu, tlv(tagInteger, encodeInt(0))...)
+	pdu = append(pdu, tlv(tagSequence, list.Bytes())...)
+
+	msg := append(tlv(tagInteger, encodeInt(Version2c)), tlv(tagOctetString, []byte(t.Community))...)
+	msg = append(msg, tlv(tagTrapV2, pdu)...)
+
+	return tlv(tagSequence, msg), nil
+}
+
+func encodeValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return tlv(tagNull, nil), nil
+	case int64:
+		return tlv(tagInteger, encodeInt(v)), nil
+	case int:
+		return tlv(tagInteger, encodeInt(int64(v))), nil
+	case uint64:
+		return tlv(tagTimeTicks, encodeUint(v)), nil
+	case []byte:
+		return tlv(tagOctetString, v), nil
+	case string:
+		oid, err := encodeOID(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return tlv(tagOID, oid), nil
+	case net.IP:
+		ip := v.To4()
+		if ip == nil {
+			return nil, ErrMalformed
+		}
+
+		return tlv(tagIPAddress, ip), nil
+	default:
+		return nil, fmt.Errorf("%w: value of type %T", ErrMalformed, value)
+	}
+}
+
+func tlv(tag byte, content []byte) []byte {
+	out := []byte{tag}
+
+	if len(content) < highBit {
+		out = append(out, byte(len(content)))
+	} else {
+		length := []byte{}
+		for n := len(content); n > 0; n >>= bitsPerByte {
+			length = append([]byte{byte(n)}, length...)
+		}
+
+		out = append(out, highBit|byte(len(length)))
+		out = append(out, length...)
+	}
+
+	return append(out, content...)
+}
+
+func encodeInt(v int64) []byte {
+	out := []byte{byte(v)}
+
+	for v >>= bitsPerByte; v != 0 && v != -1; v >>= bitsPerByte {
+		out = append([]byte{byte(v)}, out...)
+	}
+
+	// The sign bit must match the sign
+	if (v == 0) != (out[0]&highBit == 0) {
+		out = append([]byte{byte(v)}, out...)
+	}
+
+	return out
+}
+
+func encodeUint(v uint64) []byte {
+	out := []byte{byte(v)}
+
+	for v >>= bitsPerByte; v != 0; v >>= bitsPerByte {
+		out = append([]byte{byte(v)}, out...)
+	}
+
+	if out[0]&highBit != 0 {
+		out = append([]byte{0}, out...)
+	}
+
+	return out
+}
+
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 { //nolint:gomnd
+		return nil, fmt.Errorf("%w: oid %s", ErrMalformed, oid)
+	}
+
+	arcs := make([]uint64, 0, len(parts))
+
+	for _, p := range parts {
+		arc, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: oid %s", ErrMalformed, oid)
+		}
+
+		arcs = append(arcs, arc)
+	}
+
+	if arcs[0] > maxFirstArc {
+		return nil, fmt.Errorf("%w: oid %s", ErrMalformed, oid)
+	}
+
+	arcs = append([]uint64{arcs[0]*firstArcMultiplier + arcs[1]}, arcs[2:]...)
+	out := []byte{}
+
+	for _, arc := range arcs {
+		enc := []byte{byte(arc & lowBits)}
+
+		for arc >>= bitsPerOIDByte; arc != 0; arc >>= bitsPerOIDByte {
+			enc = append([]byte{byte(arc&lowBits) | highBit}, enc...)
+		}
+
+		out = append(out, enc...)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package snmp_test
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/project-safari/zebra/snmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal("linkDown", snmp.Name(snmp.OIDLinkDown))
+	assert.Equal("1.3.6.1.4.1.9.0.1", snmp.Name("1.3.6.1.4.1.9.0.1"))
+	assert.Equal(snmp.OIDLinkUp, snmp.OID("linkUp"))
+	assert.Equal("1.3.6.1.4.1.9.0.1", snmp.OID("1.3.6.1.4.1.9.0.1"))
+}
+
+func TestParseV1(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// A linkDown trap of interface 3 from 10.0.0.5
+	data, err := hex.DecodeString("303702010004067075626c6963a42a06062b060104010940040a00000502010202010043010030" +
+		"11300f060a2b060102010202010103020103")
+	assert.Nil(err)
+
+	trap, err := snmp.Parse(data)
+	assert.Nil(err)
+	assert.Equal(snmp.Version1, trap.Version)
+	assert.Equal("public", trap.Community)
+	assert.Equal(snmp.OIDLinkDown, trap.OID)
+	assert.Equal("10.0.0.5", trap.Agent.String())
+	assert.Equal(int64(3), trap.Value("1.3.6.1.2.1.2.2.1.1.3"))
+
+	// Enterprise specific trap 7 of Cisco
+	data, err = hex.DecodeString("302602010004067075626c6963a41906062b060104010940040a000005020106020107430100" +
+		"3000")
+	assert.Nil(err)
+
+	trap, err = snmp.Parse(data)
+	assert.Nil(err)
+	assert.Equal("1.3.6.1.4.1.9.0.7", trap.OID)
+	assert.Empty(trap.Variables)
+}
+
+func TestParseV2c(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	mac, err := net.ParseMAC("00:1b:21:3a:4f:10")
+	assert.Nil(err)
+
+	sent := &snmp.Trap{
+		Version:   snmp.Version2c,
+		Community: "lab",
+		OID:       "1.3.6.1.4.1.9.9.117.2.0.2",
+		Agent:     nil,
+		Variables: []snmp.Variable{
+			{OID: snmp.OIDSnmpTrapAddress, Value: net.ParseIP("10.1.2.3")},
+			{OID: "1.3.6.1.2.1.2.2.1.6.1", Value: []byte(mac)},
+			{OID: "1.3.6.1.2.1.2.2.1.2.1", Value: []byte("psu1")},
+			{OID: "1.3.6.1.4.1.9.1", Value: int64(-300)},
+			{OID: "1.3.6.1.4.1.9.2", Value: uint64(1 << 40)},
+			{OID: "1.3.6.1.4.1.9.3", Value: nil},
+		},
+	}
+
+	data, err := sent.Marshal()
+	assert.Nil(err)
+
+	trap, err := snmp.Parse(data)
+	assert.Nil(err)
+	assert.Equal(snmp.Version2c, trap.Version)
+	assert.Equal("lab", trap.Community)
+	assert.Equal(sent.OID, trap.OID)
+	assert.Equal("10.1.2.3", trap.Agent.String())
+	assert.Equal([]string{"00:1b:21:3a:4f:10"}, trap.MACs())
+	assert.Equal([]byte("psu1"), trap.Value("1.3.6.1.2.1.2.2.1.2.1"))
+	assert.Equal(int64(-300), trap.Value("1.3.6.1.4.1.9.1"))
+	assert.Equal(uint64(1<<40), trap.Value("1.3.6.1.4.1.9.2"))
+	assert.Equal(8, len(trap.Variables))
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for _, msg := range []string{
+		"",
+		"3003020100",
+		"30030201",
+		"300b020103040170a0020100",
+		"300c020101040170a00500000000",
+		"3084ffffffff",
+	} {
+		data, err := hex.DecodeString(msg)
+		assert.Nil(err)
+
+		_, err = snmp.Parse(data)
+		assert.NotNil(err, msg)
+	}
+
+	// A get request is not a trap
+	data, err := hex.DecodeString("3010020101040170a009020100020100020100")
+	assert.Nil(err)
+
+	_, err = snmp.Parse(data)
+	assert.NotNil(err)
+
+	_, err = (&snmp.Trap{Version: snmp.Version2c, Community: "", OID: "x", Agent: nil, Variables: nil}).Marshal()
+	assert.ErrorIs(err, snmp.ErrMalformed)
+}
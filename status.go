@@ -12,6 +12,7 @@ type Status struct {
 	Lease       Lease     `json:"lease"`
 	UsedBy      string    `json:"usedBy"`
 	State       State     `json:"state"`
+	Lifecycle   Lifecycle `json:"lifecycle,omitempty"`
 	CreatedTime time.Time `json:"createdTime"`
 }
 
@@ -63,6 +64,11 @@ func (f *Fault) MarshalText() ([]byte, error) {
 	return []byte(f.String()), nil
 }
 
+// EnumValues returns the names of the faults, for the JSON schemas of types.
+func (f Fault) EnumValues() []string {
+	return []string{"none", "minor", "major", "critical"}
+}
+
 func (f *Fault) UnmarshalText(data []byte) error {
 	fmap := map[string]Fault{
 		"none":     None,
@@ -96,6 +102,12 @@ func (l *Lease) MarshalText() ([]byte, error) {
 	return []byte(l.String()), nil
 }
 
+// EnumValues returns the names of the lease states, for the JSON schemas of
+// types.
+func (l Lease) EnumValues() []string {
+	return []string{"leased", "free", "setup"}
+}
+
 func (l *Lease) UnmarshalText(data []byte) error {
 	lmap := map[string]Lease{
 		"leased": Leased,
@@ -128,6 +140,11 @@ func (s *State) MarshalText() ([]byte, error) {
 	return []byte(s.String()), nil
 }
 
+// EnumValues returns the names of the states, for the JSON schemas of types.
+func (s State) EnumValues() []string {
+	return []string{"active", "inactive"}
+}
+
 func (s *State) UnmarshalText(data []byte) error {
 	smap := map[string]State{
 		"active":   Active,
@@ -157,6 +174,10 @@ func (s *Status) Validate(ctx context.Context) error {
 		return ErrState
 	}
 
+	if err := s.Lifecycle.Validate(); err != nil {
+		return err
+	}
+
 	if !s.CreatedTime.Before(time.Now()) {
 		return ErrCreatedTime
 	}
@@ -172,6 +193,7 @@ func DefaultStatus() *Status {
 		Lease:       Free,
 		UsedBy:      "",
 		State:       Active,
+		Lifecycle:   "",
 		CreatedTime: time.Now(),
 	}
 }
@@ -12,6 +12,10 @@ const (
 	MatchNotEqual
 	MatchIn
 	MatchNotIn
+	// MatchUnder matches the resources under the parents of the values,
+	// transitively, such as the servers in the racks of the rooms of a site.
+	// It only applies to the ParentLabel.
+	MatchUnder
 )
 
 // Command struct for label queries.
@@ -47,7 +51,11 @@ func (q *Query) Validate() error {
 		return ErrInvalidQuery
 	}
 
-	if q.Op > MatchNotIn {
+	if q.Op > MatchUnder {
+		return ErrInvalidQuery
+	}
+
+	if q.Op == MatchUnder && (q.Key != ParentLabel || len(q.Values) == 0) {
 		return ErrInvalidQuery
 	}
 
@@ -60,6 +68,7 @@ func (o *Operator) MarshalText() ([]byte, error) {
 		MatchNotEqual: "!=",
 		MatchIn:       "in",
 		MatchNotIn:    "notin",
+		MatchUnder:    "under",
 	}
 
 	opVal, ok := opMap[*o]
@@ -76,6 +85,7 @@ func (o *Operator) UnmarshalText(data []byte) error {
 		"!=":    MatchNotEqual,
 		"in":    MatchIn,
 		"notin": MatchNotIn,
+		"under": MatchUnder,
 	}
 
 	op, ok := opMap[string(data)]
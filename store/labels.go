@@ -0,0 +1,173 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/labelstore"
+)
+
+// labelShard is a label store of the resources of a shard.
+type labelShard struct {
+	lock sync.RWMutex
+	ls   *labelstore.LabelStore
+}
+
+// labelIndex is the label index of the store, sharded by the hash of the
+// resource ID as the change locks are. Changes to resources of different
+// shards update the index concurrently, queries read the shards in turn and
+// merge their results, so a query may see a change to one shard and not a
+// concurrent change to another.
+type labelIndex struct {
+	factory zebra.ResourceFactory
+	shards  [shardCount]labelShard
+}
+
+func newLabelIndex(resources *zebra.ResourceMap) *labelIndex {
+	x := &labelIndex{factory: resources.GetFactory(), shards: [shardCount]labelShard{}}
+	parts := [shardCount]*zebra.ResourceMap{}
+
+	for i := range parts {
+		parts[i] = zebra.NewResourceMap(resources.GetFactory())
+	}
+
+	for t, l := range resources.Resources {
+		for _, res := range l.Resources {
+			parts[shardOf(res.GetID())%shardCount].Add(res, t)
+		}
+	}
+
+	for i := range x.shards {
+		x.shards[i].ls = labelstore.NewLabelStore(parts[i])
+	}
+
+	return x
+}
+
+func (x *labelIndex) shard(id string) *labelShard {
+	return &x.shards[shardOf(id)%shardCount]
+}
+
+func (x *labelIndex) create(res zebra.Resource) error {
+	s := x.shard(res.GetID())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.ls.Create(res)
+}
+
+func (x *labelIndex) delete(res zebra.Resource) error {
+	s := x.shard(res.GetID())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.ls.Delete(res)
+}
+
+func (x *labelIndex) clear() error {
+	for i := range x.shards {
+		if err := x.each(i, true, func(ls *labelstore.LabelStore) error { return ls.Clear() }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query returns copies of the lists of the resources matching the query.
+func (x *labelIndex) query(query zebra.Query) *zebra.ResourceMap {
+	retMap := zebra.NewResourceMap(x.factory)
+
+	for i := range x.shards {
+		_ = x.each(i, false, func(ls *labelstore.LabelStore) error {
+			for t, l := range ls.Query(query).Resources {
+				for _, res := range l.Resources {
+					retMap.Add(res, t)
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return retMap
+}
+
+func (x *labelIndex) cardinality(query zebra.Query) int {
+	count := 0
+
+	for i := range x.shards {
+		_ = x.each(i, false, func(ls *labelstore.LabelStore) error {
+			count += ls.Cardinality(query)
+
+			return nil
+		})
+	}
+
+	return count
+}
+
+// stats returns the size of the index. Keys and values are counted once,
+// however many shards index them.
+func (x *labelIndex) stats() labelstore.Stats {
+	stats := labelstore.Stats{Keys: 0, Values: 0, Entries: 0, Slots: 0}
+	values := map[string]map[string]bool{}
+
+	for i := range x.shards {
+		_ = x.each(i, false, func(ls *labelstore.LabelStore) error {
+			s := ls.Stats()
+			stats.Entries += s.Entries
+			stats.Slots += s.Slots
+
+			for k, vals := range ls.Values() {
+				if values[k] == nil {
+					values[k] = map[string]bool{}
+				}
+
+				for _, v := range vals {
+					values[k][v] = true
+				}
+			}
+
+			return nil
+		})
+	}
+
+	stats.Keys = len(values)
+
+	for _, vals := range values {
+		stats.Values += len(vals)
+	}
+
+	return stats
+}
+
+func (x *labelIndex) compact() int {
+	collected := 0
+
+	for i := range x.shards {
+		_ = x.each(i, true, func(ls *labelstore.LabelStore) error {
+			collected += ls.Compact()
+
+			return nil
+		})
+	}
+
+	return collected
+}
+
+// each calls f with the label store of the i-th shard locked, for writing
+// if write is set.
+func (x *labelIndex) each(i int, write bool, f func(ls *labelstore.LabelStore) error) error {
+	s := &x.shards[i]
+
+	if write {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+	} else {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+	}
+
+	return f(s.ls)
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/project-safari/zebra"
+)
+
+// refIndex holds the references of each resource and, in reverse, the
+// resources referring to each resource.
+type refIndex struct {
+	refs      map[string][]string
+	referrers map[string]map[string]struct{}
+}
+
+func newRefIndex(resources []zebra.Resource) *refIndex {
+	x := &refIndex{refs: map[string][]string{}, referrers: map[string]map[string]struct{}{}}
+
+	for _, res := range resources {
+		x.put(res)
+	}
+
+	return x
+}
+
+// put indexes the references of the resource, replacing those of the
+// version it replaces.
+func (x *refIndex) put(res zebra.Resource) {
+	id := res.GetID()
+	x.remove(id)
+
+	refs := zebra.References(res)
+	if len(refs) == 0 {
+		return
+	}
+
+	x.refs[id] = refs
+
+	for _, ref := range refs {
+		if x.referrers[ref] == nil {
+			x.referrers[ref] = map[string]struct{}{}
+		}
+
+		x.referrers[ref][id] = struct{}{}
+	}
+}
+
+func (x *refIndex) remove(id string) {
+	for _, ref := range x.refs[id] {
+		delete(x.referrers[ref], id)
+
+		if len(x.referrers[ref]) == 0 {
+			delete(x.referrers, ref)
+		}
+	}
+
+	delete(x.refs, id)
+}
+
+// referring returns the IDs of the resources referring to the resource, in
+// order.
+func (x *refIndex) referring(id string) []string {
+	ids := make([]string, 0, len(x.referrers[id]))
+	for ref := range x.referrers[id] {
+		ids = append(ids, ref)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// indexRefs indexes the references of the changed resource, if the index
+// was built.
+func (rs *ResourceStore) indexRefs(res zebra.Resource, deleted bool) {
+	rs.refLock.Lock()
+	defer rs.refLock.Unlock()
+
+	switch {
+	case rs.refs == nil:
+		return
+	case deleted:
+		rs.refs.remove(res.GetID())
+	default:
+		rs.refs.put(res)
+	}
+}
+
+// resetRefs drops the reference index, it is built again when next needed.
+func (rs *ResourceStore) resetRefs() {
+	rs.refLock.Lock()
+	defer rs.refLock.Unlock()
+
+	rs.refs = nil
+}
+
+// referring returns the IDs of the resources referring to the resource. The
+// reference index is built on first use and kept up to date by the changes
+// after that.
+func (rs *ResourceStore) referring(id string) []string {
+	rs.refLock.Lock()
+	defer rs.refLock.Unlock()
+
+	if rs.refs == nil {
+		all := []zebra.Resource{}
+		for _, l := range rs.current().resources(rs.Factory, nil).Resources {
+			all = append(all, l.Resources...)
+		}
+
+		rs.refs = newRefIndex(all)
+	}
+
+	return rs.refs.referring(id)
+}
+
+// DeletePlan is what deleting a resource under the delete policy of the store
+// takes: the resources deleted with it, children before their parents, and
+// the resources orphaned, labeled as such. Policies holds the policy applied
+// to each resource that referred to a deleted one, by ID. A delete blocked by
+// the policy lists the resources that blocked it.
+type DeletePlan struct {
+	Resource zebra.Resource
+	Cascaded []zebra.Resource
+	Orphaned []zebra.Resource
+	Policies map[string]string
+	Blocked  []string
+}
+
+// PlanDelete returns the plan of deleting the resource under the delete
+// policy of the store, ErrReferenced if the policy blocks it. The resources
+// of the gone IDs are taken as deleted, for plans of several deletes. The
+// store is not changed, the plan is carried out by deleting the cascaded
+// resources in order, then the resource, and by writing the orphaned ones.
+func (rs *ResourceStore) PlanDelete(res zebra.Resource, gone []string) (*DeletePlan, error) {
+	policy := rs.Deletes
+	if policy == nil {
+		policy = zebra.NewDeletePolicy()
+	}
+
+	plan := &DeletePlan{
+		Resource: res,
+		Cascaded: []zebra.Resource{},
+		Orphaned: []zebra.Resource{},
+		Policies: map[string]string{},
+		Blocked:  []string{},
+	}
+
+	v := rs.current()
+	orphans := map[string]string{}
+	queue := []zebra.Resource{res}
+	deleted := map[string]bool{res.GetID(): true}
+
+	for _, id := range gone {
+		deleted[id] = true
+	}
+
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, id := range rs.referring(cur.GetID()) {
+			referrer := v.find(id)
+			if referrer == nil || deleted[id] {
+				continue
+			}
+
+			p := policy.Policy(cur.GetType(), referrer.GetType())
+
+			// Cascading wins over orphaning, the referrer is deleted anyway
+			if plan.Policies[id] == "" || p == zebra.DeleteCascade {
+				plan.Policies[id] = p
+			}
+
+			switch p {
+			case zebra.DeleteBlock:
+				plan.Blocked = append(plan.Blocked, id)
+			case zebra.DeleteCascade:
+				deleted[id] = true
+				delete(orphans, id)
+
+				plan.Cascaded = append(plan.Cascaded, referrer)
+				queue = append(queue, referrer)
+			default:
+				orphans[id] = cur.GetID()
+			}
+		}
+	}
+
+	if len(plan.Blocked) != 0 {
+		sort.Strings(plan.Blocked)
+
+		return plan, fmt.Errorf("%w: %s", zebra.ErrReferenced, strings.Join(plan.Blocked, ", "))
+	}
+
+	// Children are deleted before their parents
+	for i, j := 0, len(plan.Cascaded)-1; i < j; i, j = i+1, j-1 {
+		plan.Cascaded[i], plan.Cascaded[j] = plan.Cascaded[j], plan.Cascaded[i]
+	}
+
+	ids := make([]string, 0, len(orphans))
+	for id := range orphans {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		orphan, err := rs.orphaned(v.find(id), orphans[id], deleted)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Orphaned = append(plan.Orphaned, orphan)
+	}
+
+	return plan, nil
+}
+
+// orphaned returns a copy of the resource labeled as an orphan of the deleted
+// resource, without its parent if that is deleted too.
+func (rs *ResourceStore) orphaned(res zebra.Resource, of string, deleted map[string]bool) (zebra.Resource, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	// Resources only hand out copies of their labels
+	labels := res.GetLabels()
+	if labels == nil {
+		labels = zebra.Labels{}
+	}
+
+	if deleted[labels[zebra.ParentLabel]] {
+		delete(labels, zebra.ParentLabel)
+	}
+
+	labels[zebra.OrphanLabel] = of
+
+	if fields["labels"], err = json.Marshal(labels); err != nil {
+		return nil, err
+	}
+
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+
+	return zebra.NewDecoder(rs.Factory).Decode(data)
+}
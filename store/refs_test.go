@@ -0,0 +1,89 @@
+package store_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/dc"
+	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanDelete(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), store.DefaultFactory())
+	rs.Format = store.FormatMemory
+	assert.Nil(rs.Initialize())
+
+	labels := func(parent string) zebra.Labels {
+		return zebra.Labels{"system.group": "labs", zebra.ParentLabel: parent}
+	}
+
+	lab := dc.NewLab("lab", zebra.Labels{"system.group": "labs"})
+	rack := dc.NewRack("rack", "A01", labels(lab.ID))
+	pool := network.NewVlanPool(1, 10, labels(lab.ID))
+	srv := compute.NewServer([]string{"SN1", "model", "srv"}, net.IPv4(10, 0, 0, 1), labels(rack.ID))
+	srv.Credentials.ID = srv.ID
+	sw := network.NewSwitch([]string{"SN2", "model", "sw"}, 48, net.IPv4(10, 0, 0, 2), labels(rack.ID))
+	sw.Credentials.ID = sw.ID
+
+	for _, res := range []zebra.Resource{lab, rack, pool, srv, sw} {
+		assert.Nil(rs.Create(res))
+	}
+
+	// By default the referring resources are orphaned
+	plan, err := rs.PlanDelete(rack, nil)
+	assert.Nil(err)
+	assert.Empty(plan.Cascaded)
+	assert.Len(plan.Orphaned, 2)
+	assert.Equal(zebra.DeleteOrphan, plan.Policies[srv.ID])
+
+	for _, orphan := range plan.Orphaned {
+		assert.Equal(rack.ID, orphan.GetLabels()[zebra.OrphanLabel])
+		assert.False(orphan.GetLabels().HasKey(zebra.ParentLabel))
+	}
+
+	// The stored resources are left as they are
+	assert.Equal(rack.ID, srv.GetLabels()[zebra.ParentLabel])
+
+	rs.Deletes.Types["Rack"] = map[string]string{"Server": zebra.DeleteBlock, zebra.AnyType: zebra.DeleteCascade}
+	rs.Deletes.Types["Lab"] = map[string]string{zebra.AnyType: zebra.DeleteCascade}
+
+	plan, err = rs.PlanDelete(lab, nil)
+	assert.ErrorIs(err, zebra.ErrReferenced)
+	assert.Equal([]string{srv.ID}, plan.Blocked)
+
+	// Deleting the server lifts the block, the rest of the lab cascades
+	// children first
+	assert.Nil(rs.Delete(srv))
+
+	plan, err = rs.PlanDelete(lab, nil)
+	assert.Nil(err)
+	assert.Len(plan.Cascaded, 3)
+	assert.Equal(sw.ID, plan.Cascaded[0].GetID())
+	assert.Equal(zebra.DeleteCascade, plan.Policies[pool.ID])
+
+	// The index follows the changes to the references
+	srv2 := compute.NewServer([]string{"SN3", "model", "srv2"}, net.IPv4(10, 0, 0, 3), labels(lab.ID))
+	srv2.Credentials.ID = srv2.ID
+	assert.Nil(rs.Create(srv2))
+
+	rs.Deletes.Types["Lab"]["Server"] = zebra.DeleteOrphan
+
+	plan, err = rs.PlanDelete(lab, nil)
+	assert.Nil(err)
+	assert.Len(plan.Orphaned, 1)
+	assert.Equal(srv2.ID, plan.Orphaned[0].GetID())
+
+	srv2.Labels[zebra.ParentLabel] = rack.ID
+	assert.Nil(rs.Create(srv2))
+
+	plan, err = rs.PlanDelete(rack, nil)
+	assert.ErrorIs(err, zebra.ErrReferenced)
+	assert.Equal([]string{srv2.ID}, plan.Blocked)
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/project-safari/zebra"
+)
+
+// Sealer encrypts the credentials of resources before they are written to
+// the backend and decrypts them as they are loaded, so that credentials are
+// only kept in the clear in memory. Open tells whether the value should be
+// sealed again, as it was not sealed with the current key.
+type Sealer interface {
+	Seal(value string) (string, error)
+	Open(value string) (string, bool, error)
+}
+
+var credentialsType = reflect.TypeOf(zebra.Credentials{}) //nolint:exhaustruct
+
+// credentialFields returns the indices of the credentials fields of the
+// resource, none if it is not a struct.
+func credentialFields(res zebra.Resource) []int {
+	v := reflect.ValueOf(res)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := []int{}
+
+	for i := 0; i < v.Elem().NumField(); i++ {
+		if v.Elem().Field(i).Type() == credentialsType {
+			fields = append(fields, i)
+		}
+	}
+
+	return fields
+}
+
+// sealed returns a copy of the resource with its credentials sealed, to be
+// written to the backend. Resources without credentials, or of a store
+// without a sealer, are returned as they are.
+func (rs *ResourceStore) sealed(res zebra.Resource) (zebra.Resource, error) {
+	fields := credentialFields(res)
+	if rs.Sealer == nil || len(fields) == 0 {
+		return res, nil
+	}
+
+	v := reflect.ValueOf(res).Elem()
+	copied := reflect.New(v.Type())
+	copied.Elem().Set(v)
+
+	for _, i := range fields {
+		creds, _ := copied.Elem().Field(i).Addr().Interface().(*zebra.Credentials)
+		if creds.Keys == nil {
+			continue
+		}
+
+		keys := make(map[string]string, len(creds.Keys))
+
+		for name, value := range creds.Keys {
+			sealed, err := rs.Sealer.Seal(value)
+			if err != nil {
+				return nil, fmt.Errorf("credentials of %s: %w", res.GetID(), err)
+			}
+
+			keys[name] = sealed
+		}
+
+		creds.Keys = keys
+	}
+
+	sealed, _ := copied.Interface().(zebra.Resource)
+
+	return sealed, nil
+}
+
+// open decrypts the credentials of the loaded resources in place and returns
+// the resources to seal again.
+func (rs *ResourceStore) open(resources *zebra.ResourceMap) ([]zebra.Resource, error) {
+	stale := []zebra.Resource{}
+
+	if rs.Sealer == nil {
+		return stale, nil
+	}
+
+	for _, l := range resources.Resources {
+		for _, res := range l.Resources {
+			reseal, err := rs.openResource(res)
+			if err != nil {
+				return nil, err
+			}
+
+			if reseal {
+				stale = append(stale, res)
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+func (rs *ResourceStore) openResource(res zebra.Resource) (bool, error) {
+	reseal := false
+
+	for _, i := range credentialFields(res) {
+		creds, _ := reflect.ValueOf(res).Elem().Field(i).Addr().Interface().(*zebra.Credentials)
+
+		for name, value := range creds.Keys {
+			opened, stale, err := rs.Sealer.Open(value)
+			if err != nil {
+				return false, fmt.Errorf("credentials of %s: %w", res.GetID(), err)
+			}
+
+			creds.Keys[name] = opened
+			reseal = reseal || stale
+		}
+	}
+
+	return reseal, nil
+}
+
+// Reseal writes the resources with credentials back to the backend, sealed
+// with the current key of the sealer, and returns the number written. It is
+// called once the key is rotated, so that the previous key can be retired.
+func (rs *ResourceStore) Reseal() (int, error) {
+	if rs.Sealer == nil {
+		return 0, nil
+	}
+
+	rs.waitWarm()
+
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	written := 0
+
+	for _, l := range rs.current().resources(rs.Factory, nil).Resources {
+		for _, res := range l.Resources {
+			if len(credentialFields(res)) == 0 {
+				continue
+			}
+
+			if err := rs.rewrite(res); err != nil {
+				return written, err
+			}
+
+			written++
+		}
+	}
+
+	return written, nil
+}
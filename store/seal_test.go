@@ -0,0 +1,123 @@
+package store_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/secrets"
+	"github.com/project-safari/zebra/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotatingKey is a credentials key rotated by the test.
+type rotatingKey struct {
+	key string
+}
+
+func (k *rotatingKey) Fetch(ctx context.Context) (*secrets.Secret, error) {
+	return &secrets.Secret{Value: k.key, Version: k.key}, nil
+}
+
+// stored returns the contents of the files of the store.
+func stored(assert *assert.Assertions, root string) string {
+	contents := ""
+
+	assert.Nil(filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			data, readErr := os.ReadFile(path)
+			contents += string(data)
+
+			return readErr
+		}
+
+		return err
+	}))
+
+	return contents
+}
+
+func TestSealCredentials(t *testing.T) { //nolint:funlen
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	password := "Zebra!Passw0rd"
+
+	srv := compute.NewServer([]string{"SN1", "model", "srv"}, net.IPv4(10, 0, 0, 1),
+		zebra.Labels{"system.group": "servers"})
+	srv.Credentials.ID = srv.ID
+	srv.Credentials.Keys = map[string]string{"password": password}
+
+	// Credentials stored before sealing was set up
+	rs := store.NewResourceStore(root, store.DefaultFactory())
+	assert.Nil(rs.Initialize())
+	assert.Nil(rs.Create(srv))
+	assert.Contains(stored(assert, root), password)
+
+	key := &rotatingKey{key: "one"}
+	keyring, err := secrets.NewKeyring(context.Background(), key, time.Hour, time.Now())
+	assert.Nil(err)
+
+	// They are sealed on initialization
+	rs = store.NewResourceStore(root, store.DefaultFactory())
+	rs.Sealer = secrets.NewCipher(keyring, nil)
+	assert.Nil(rs.Initialize())
+	assert.NotContains(stored(assert, root), password)
+
+	loaded, ok := rs.QueryUUID([]string{srv.ID}).Resources["Server"].Resources[0].(*compute.Server)
+	assert.True(ok)
+	assert.Equal(password, loaded.Credentials.Keys["password"])
+
+	// Writes seal the stored copy only
+	srv.Credentials.Keys["password"] = "Zebra!Passw0rd2"
+	assert.Nil(rs.Create(srv))
+	assert.Equal("Zebra!Passw0rd2", srv.Credentials.Keys["password"])
+	assert.NotContains(stored(assert, root), "Zebra!Passw0rd2")
+
+	// After a rotation the credentials are sealed with the new key
+	key.key = "two"
+
+	rotated, err := keyring.Refresh(context.Background(), time.Now())
+	assert.Nil(err)
+	assert.True(rotated)
+
+	resealed, err := rs.Reseal()
+	assert.Nil(err)
+	assert.Equal(1, resealed)
+
+	next, err := secrets.NewKeyring(context.Background(), key, time.Hour, time.Now())
+	assert.Nil(err)
+
+	rs = store.NewResourceStore(root, store.DefaultFactory())
+	rs.Sealer = secrets.NewCipher(next, nil)
+	assert.Nil(rs.Initialize())
+
+	loaded, ok = rs.QueryUUID([]string{srv.ID}).Resources["Server"].Resources[0].(*compute.Server)
+	assert.True(ok)
+	assert.Equal("Zebra!Passw0rd2", loaded.Credentials.Keys["password"])
+
+	// Credentials of a key the store does not have are not loaded
+	key.key = "three"
+	other, err := secrets.NewKeyring(context.Background(), key, time.Hour, time.Now())
+	assert.Nil(err)
+
+	rs = store.NewResourceStore(root, store.DefaultFactory())
+	rs.Sealer = secrets.NewCipher(other, nil)
+	assert.ErrorIs(rs.Initialize(), secrets.ErrSealed)
+
+	// Unless the key is retired, they are sealed with the current key then
+	rs = store.NewResourceStore(root, store.DefaultFactory())
+	rs.Sealer = secrets.NewCipher(other, []string{"two"})
+	assert.Nil(rs.Initialize())
+	assert.NotContains(stored(assert, root), "Zebra!Passw0rd2")
+
+	rs = store.NewResourceStore(root, store.DefaultFactory())
+	rs.Sealer = secrets.NewCipher(other, nil)
+	assert.Nil(rs.Initialize())
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"time"
+
+	"github.com/project-safari/zebra"
+)
+
+// Snapshot is a view of the resources of a store at a point in time. Later
+// changes to the store do not show in the snapshot, so long reads such as
+// exports, backups and reports iterate over it without holding the store
+// lock. The resources are shared with the store and must not be modified.
+type Snapshot struct {
+	view    *view
+	taken   time.Time
+	factory zebra.ResourceFactory
+}
+
+// Version counts the changes to the store before the snapshot was taken.
+func (s *Snapshot) Version() uint64 {
+	return s.view.version
+}
+
+// Taken returns the time the snapshot was taken.
+func (s *Snapshot) Taken() time.Time {
+	return s.taken
+}
+
+// Len returns the number of resources in the snapshot.
+func (s *Snapshot) Len() int {
+	return s.view.count(nil)
+}
+
+// Resources returns all resources of the snapshot, by type.
+func (s *Snapshot) Resources() *zebra.ResourceMap {
+	return s.view.resources(s.factory, nil)
+}
+
+// Query returns all resources of the snapshot, like Resources, so that a
+// snapshot is queried like the store.
+func (s *Snapshot) Query() *zebra.ResourceMap {
+	return s.Resources()
+}
+
+// QueryUUID returns the resources of the snapshot with matching IDs.
+func (s *Snapshot) QueryUUID(uuids []string) *zebra.ResourceMap {
+	resMap := zebra.NewResourceMap(s.factory)
+
+	for _, id := range uuids {
+		if res := s.view.find(id); res != nil {
+			resMap.Add(res, res.GetType())
+		}
+	}
+
+	return resMap
+}
+
+// QueryType returns the resources of the snapshot with matching types.
+func (s *Snapshot) QueryType(types []string) *zebra.ResourceMap {
+	return s.view.resources(s.factory, types)
+}
+
+// QueryLabel returns the resources of the snapshot matching the label query.
+func (s *Snapshot) QueryLabel(query zebra.Query) (*zebra.ResourceMap, error) {
+	return FilterLabel(query, s.view.resources(s.factory, nil))
+}
+
+// Snapshot returns a snapshot of the resources. The snapshot shares the
+// immutable view of the resources the store queries, so taking one copies
+// nothing, and it is shared by readers until the store changes.
+func (rs *ResourceStore) Snapshot() *Snapshot {
+	v := rs.current()
+
+	rs.snapLock.Lock()
+	defer rs.snapLock.Unlock()
+
+	if rs.snap == nil || rs.snap.view != v {
+		rs.snap = &Snapshot{view: v, taken: time.Now(), factory: rs.Factory}
+	}
+
+	return rs.snap
+}
@@ -2,44 +2,140 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/filestore"
-	"github.com/project-safari/zebra/idstore"
 	"github.com/project-safari/zebra/labelstore"
-	"github.com/project-safari/zebra/typestore"
+	"github.com/project-safari/zebra/memstore"
+	"github.com/project-safari/zebra/segmentstore"
 )
 
+// Storage formats of the resource store. FormatFiles stores each resource in
+// a file of its own, FormatSegments packs the resources into segment files
+// and migrates a store of the files format on initialization. FormatMemory
+// keeps the resources in memory only, they are lost when the process exits.
+const (
+	FormatFiles    = "files"
+	FormatSegments = "segments"
+	FormatMemory   = "memory"
+)
+
+var ErrFormat = errors.New("unknown store format")
+
+// backend persists the resources of the store.
+type backend interface {
+	Initialize() error
+	Wipe() error
+	Clear() error
+	Load() (*zebra.ResourceMap, error)
+	Create(res zebra.Resource) error
+	Delete(res zebra.Resource) error
+}
+
+// compactor is implemented by backends that can reclaim the space of
+// deleted and replaced resources.
+type compactor interface {
+	Compact() (int64, error)
+}
+
+// shardCount is the number of locks that changes are sharded over by the
+// hash of the resource ID.
+const shardCount = 64
+
+// ResourceStore keeps the resources in the backend of its Format and
+// indexes them by ID, type and label. With LazyIndex set, Initialize returns
+// before the label index is built: label queries scan the resources and
+// changes wait until the index is warm.
+//
+// Changes to resources with different IDs are written to the backend
+// concurrently, only changes to the same ID are serialized. Queries by ID
+// and type read an immutable view of the resources without locking. The label
+// index is sharded by resource ID as well, label queries only wait for
+// changes to the shard they are reading.
+//
+// With a Sealer set, the credentials of resources are sealed in the backend.
 type ResourceStore struct {
 	lock        sync.RWMutex
 	StorageRoot string
 	Factory     zebra.ResourceFactory
-	fs          *filestore.FileStore
-	ids         *idstore.IDStore
-	ls          *labelstore.LabelStore
-	ts          *typestore.TypeStore
+	Format      string
+	LazyIndex   bool
+	Lifecycle   *zebra.LifecyclePolicy
+	Deletes     *zebra.DeletePolicy
+	Sealer      Sealer
+	fs          backend
+	shards      [shardCount]sync.Mutex
+	viewLock    sync.Mutex
+	view        atomic.Value
+	indexLock   sync.RWMutex
+	labels      *labelIndex
+	warm        chan struct{}
+	snapLock    sync.Mutex
+	snap        *Snapshot
+	refLock     sync.Mutex
+	refs        *refIndex
+	treeLock    sync.Mutex
+	tree        *treeIndex
 }
 
 func NewResourceStore(root string, factory zebra.ResourceFactory) *ResourceStore {
-	return &ResourceStore{
+	rs := &ResourceStore{
 		lock:        sync.RWMutex{},
 		StorageRoot: root,
 		Factory:     factory,
+		Format:      FormatFiles,
+		LazyIndex:   false,
+		Lifecycle:   zebra.NewLifecyclePolicy(),
+		Deletes:     zebra.NewDeletePolicy(),
+		Sealer:      nil,
 		fs:          nil,
-		ids:         nil,
-		ls:          nil,
-		ts:          nil,
+		shards:      [shardCount]sync.Mutex{},
+		viewLock:    sync.Mutex{},
+		view:        atomic.Value{},
+		indexLock:   sync.RWMutex{},
+		labels:      nil,
+		warm:        closed(),
+		snapLock:    sync.Mutex{},
+		snap:        nil,
+		refLock:     sync.Mutex{},
+		refs:        nil,
+		treeLock:    sync.Mutex{},
+		tree:        nil,
 	}
+
+	rs.view.Store(newView(nil, 0))
+
+	return rs
+}
+
+func closed() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+
+	return c
 }
 
 func (rs *ResourceStore) Initialize() error {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
 
-	rs.fs = filestore.NewFileStore(rs.StorageRoot, rs.Factory)
+	switch rs.Format {
+	case FormatFiles, "":
+		rs.fs = filestore.NewFileStore(rs.StorageRoot, rs.Factory)
+	case FormatSegments:
+		rs.fs = segmentstore.NewSegmentStore(rs.StorageRoot, rs.Factory, segmentstore.DefaultSegmentSize)
+	case FormatMemory:
+		rs.fs = memstore.NewMemStore(rs.Factory)
+	default:
+		return ErrFormat
+	}
+
 	if err := rs.fs.Initialize(); err != nil {
 		return err
 	}
@@ -49,26 +145,110 @@ func (rs *ResourceStore) Initialize() error {
 		return err
 	}
 
-	rs.ids = idstore.NewIDStore(resources)
-	rs.ls = labelstore.NewLabelStore(resources)
-	rs.ts = typestore.NewTypeStore(resources)
+	stale, err := rs.open(resources)
+	if err != nil {
+		return err
+	}
+
+	// Credentials stored in the clear or with a retired key are sealed again
+	for _, res := range stale {
+		if err := rs.rewrite(res); err != nil {
+			return err
+		}
+	}
+
+	rs.publish(func(v *view) *view { return newView(resources, v.version+1) })
+	rs.resetRefs()
+	rs.resetTree()
+
+	rs.indexLock.Lock()
+	defer rs.indexLock.Unlock()
+
+	rs.labels = nil
+	rs.warm = make(chan struct{})
+
+	if rs.LazyIndex {
+		go rs.indexLabels(resources, rs.warm)
+	} else {
+		rs.labels = newLabelIndex(resources)
+		close(rs.warm)
+	}
 
 	return nil
 }
 
+func (rs *ResourceStore) indexLabels(resources *zebra.ResourceMap, warm chan struct{}) {
+	labels := newLabelIndex(resources)
+
+	rs.indexLock.Lock()
+	defer rs.indexLock.Unlock()
+
+	rs.labels = labels
+	close(warm)
+}
+
+// current returns the current view of the resources.
+func (rs *ResourceStore) current() *view {
+	v, _ := rs.view.Load().(*view)
+
+	return v
+}
+
+// publish replaces the current view with the one returned by change.
+func (rs *ResourceStore) publish(change func(v *view) *view) {
+	rs.viewLock.Lock()
+	defer rs.viewLock.Unlock()
+
+	rs.view.Store(change(rs.current()))
+}
+
+// shard returns the lock of the changes to the resource ID.
+func (rs *ResourceStore) shard(id string) *sync.Mutex {
+	return &rs.shards[shardOf(id)%shardCount]
+}
+
+// Warming returns true while the label index is being built.
+func (rs *ResourceStore) Warming() bool {
+	select {
+	case <-rs.waitChan():
+		return false
+	default:
+		return true
+	}
+}
+
+func (rs *ResourceStore) waitChan() chan struct{} {
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
+
+	return rs.warm
+}
+
+// waitWarm blocks changes until the label index is warm.
+func (rs *ResourceStore) waitWarm() {
+	<-rs.waitChan()
+}
+
 func (rs *ResourceStore) Wipe() error {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
 
 	rs.fs = nil
-	rs.ids = nil
-	rs.ls = nil
-	rs.ts = nil
+	rs.publish(func(v *view) *view { return newView(nil, v.version+1) })
+	rs.resetRefs()
+	rs.resetTree()
+
+	rs.indexLock.Lock()
+	defer rs.indexLock.Unlock()
+
+	rs.labels = nil
 
 	return nil
 }
 
 func (rs *ResourceStore) Clear() error {
+	rs.waitWarm()
+
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
 
@@ -76,132 +256,213 @@ func (rs *ResourceStore) Clear() error {
 		return err
 	}
 
-	if err := rs.ids.Clear(); err != nil {
-		return err
-	}
+	rs.publish(func(v *view) *view { return newView(nil, v.version+1) })
+	rs.resetRefs()
+	rs.resetTree()
 
-	if err := rs.ls.Clear(); err != nil {
-		return err
-	}
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
 
-	if err := rs.ts.Clear(); err != nil {
-		return err
+	return rs.labels.clear()
+}
+
+// Compact reclaims the space of deleted and replaced resources, if the
+// format of the store supports it, and returns the number of bytes
+// reclaimed.
+func (rs *ResourceStore) Compact() (int64, error) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	if c, ok := rs.fs.(compactor); ok {
+		return c.Compact()
 	}
 
-	return nil
+	return 0, nil
 }
 
-// Return ResourceMap with resource type as key and list of resources as val.
-func (rs *ResourceStore) Load() (*zebra.ResourceMap, error) {
+// MigrateSchemas writes the resources of the given types, or of all types if
+// none are given, back to the backend, so that resources stored with older
+// schema versions of their type are stored migrated. Resources are migrated
+// as they are loaded, this only saves migrating them on the next load. Only
+// types with migrations are written, and the number of resources written is
+// returned by type.
+func (rs *ResourceStore) MigrateSchemas(types []string) (map[string]int, error) {
+	rs.waitWarm()
+
 	rs.lock.RLock()
 	defer rs.lock.RUnlock()
 
-	return rs.ts.Load()
-}
+	written := map[string]int{}
 
-func (rs *ResourceStore) Create(res zebra.Resource) error {
-	if res == nil || res.Validate(context.Background()) != nil {
-		return zebra.ErrInvalidResource
+	if len(types) == 0 {
+		for _, t := range rs.Factory.Types() {
+			types = append(types, t.Name)
+		}
 	}
 
-	rs.lock.Lock()
-	defer rs.lock.Unlock()
+	for _, name := range types {
+		t, ok := rs.Factory.Type(name)
+		if !ok {
+			return written, fmt.Errorf("%w: %s", zebra.ErrUnknownType, name)
+		}
 
-	err := rs.fs.Create(res)
-	if err != nil {
-		return err
-	}
+		if t.SchemaVersion() == 0 {
+			continue
+		}
 
-	err = rs.ids.Create(res)
-	if err != nil {
-		return err
-	}
+		list, ok := rs.current().resources(rs.Factory, []string{name}).Resources[name]
+		if !ok {
+			continue
+		}
 
-	err = rs.ls.Create(res)
-	if err != nil {
-		return err
+		for _, res := range list.Resources {
+			if err := rs.rewrite(res); err != nil {
+				return written, err
+			}
+
+			written[name]++
+		}
 	}
 
-	err = rs.ts.Create(res)
+	return written, nil
+}
+
+func (rs *ResourceStore) rewrite(res zebra.Resource) error {
+	shard := rs.shard(res.GetID())
+	shard.Lock()
+	defer shard.Unlock()
+
+	zebra.StampSchemaVersion(rs.Factory, res)
+
+	sealed, err := rs.sealed(res)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return rs.fs.Create(sealed)
 }
 
-func (rs *ResourceStore) Delete(res zebra.Resource) error {
+// Return ResourceMap with resource type as key and list of resources as val.
+func (rs *ResourceStore) Load() (*zebra.ResourceMap, error) {
+	return rs.current().resources(rs.Factory, nil), nil
+}
+
+func (rs *ResourceStore) Create(res zebra.Resource) error {
 	if res == nil || res.Validate(context.Background()) != nil {
 		return zebra.ErrInvalidResource
 	}
 
-	rs.lock.Lock()
-	defer rs.lock.Unlock()
+	rs.waitWarm()
 
-	err := rs.fs.Delete(res)
-	if err != nil {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	shard := rs.shard(res.GetID())
+	shard.Lock()
+	defer shard.Unlock()
+
+	if err := rs.checkLifecycle(res); err != nil {
 		return err
 	}
 
-	err = rs.ids.Delete(res)
+	zebra.StampSchemaVersion(rs.Factory, res)
+
+	sealed, err := rs.sealed(res)
 	if err != nil {
 		return err
 	}
 
-	err = rs.ls.Delete(res)
-	if err != nil {
+	if err := rs.fs.Create(sealed); err != nil {
 		return err
 	}
 
-	err = rs.ts.Delete(res)
-	if err != nil {
-		return err
+	rs.publish(func(v *view) *view { return v.with(res) })
+	rs.indexRefs(res, false)
+	rs.indexTree(res, false)
+
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
+
+	return rs.labels.create(res)
+}
+
+// checkLifecycle returns an error if the change moves the resource to a
+// lifecycle stage the policy does not allow. A change which leaves out the
+// lifecycle keeps the stage of the stored resource. Must be called with the
+// shard of the resource locked.
+func (rs *ResourceStore) checkLifecycle(res zebra.Resource) error {
+	status := res.GetStatus()
+	old := rs.current().find(res.GetID())
+
+	if rs.Lifecycle == nil || status == nil || old == nil || old.GetStatus() == nil {
+		return nil
+	}
+
+	from := old.GetStatus().Lifecycle
+
+	if status.Lifecycle == "" {
+		status.Lifecycle = from
+
+		return nil
+	}
+
+	if !rs.Lifecycle.Allows(res.GetType(), from, status.Lifecycle) {
+		return fmt.Errorf("%w: %s to %s", zebra.ErrLifecycleTransition, from, status.Lifecycle)
 	}
 
 	return nil
 }
 
-// Return all resources in a ResourceMap.
-func (rs *ResourceStore) Query() *zebra.ResourceMap {
+func (rs *ResourceStore) Delete(res zebra.Resource) error {
+	if res == nil || res.Validate(context.Background()) != nil {
+		return zebra.ErrInvalidResource
+	}
+
+	rs.waitWarm()
+
 	rs.lock.RLock()
 	defer rs.lock.RUnlock()
 
-	resMap, err := rs.ts.Load()
-	if err != nil {
-		return nil
+	shard := rs.shard(res.GetID())
+	shard.Lock()
+	defer shard.Unlock()
+
+	if err := rs.fs.Delete(res); err != nil {
+		return err
 	}
 
-	retMap := zebra.NewResourceMap(resMap.GetFactory())
+	rs.publish(func(v *view) *view { return v.without(res.GetID()) })
+	rs.indexRefs(res, true)
+	rs.indexTree(res, true)
 
-	zebra.CopyResourceMap(retMap, resMap)
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
 
-	return retMap
+	return rs.labels.delete(res)
+}
+
+// Return all resources in a ResourceMap.
+func (rs *ResourceStore) Query() *zebra.ResourceMap {
+	return rs.current().resources(rs.Factory, nil)
 }
 
 // Return resources with matching UUIDs.
 func (rs *ResourceStore) QueryUUID(uuids []string) *zebra.ResourceMap {
-	rs.lock.RLock()
-	defer rs.lock.RUnlock()
-
-	resMap := rs.ids.Query(uuids)
-	retMap := zebra.NewResourceMap(resMap.GetFactory())
+	v := rs.current()
+	retMap := zebra.NewResourceMap(rs.Factory)
 
-	zebra.CopyResourceMap(retMap, resMap)
+	for _, id := range uuids {
+		if res := v.find(id); res != nil {
+			retMap.Add(res, res.GetType())
+		}
+	}
 
 	return retMap
 }
 
 // Return resources with matching types.
 func (rs *ResourceStore) QueryType(types []string) *zebra.ResourceMap {
-	rs.lock.RLock()
-	defer rs.lock.RUnlock()
-
-	resMap := rs.ts.Query(types)
-	retMap := zebra.NewResourceMap(resMap.GetFactory())
-
-	zebra.CopyResourceMap(retMap, resMap)
-
-	return retMap
+	return rs.current().resources(rs.Factory, types)
 }
 
 // Return resources with matching label.
@@ -210,27 +471,83 @@ func (rs *ResourceStore) QueryLabel(query zebra.Query) (*zebra.ResourceMap, erro
 		return nil, err
 	}
 
-	rs.lock.RLock()
-	defer rs.lock.RUnlock()
+	// Subtrees are looked up in the tree index, not the label index
+	if query.Op == zebra.MatchUnder {
+		return rs.queryUnder(query), nil
+	}
 
-	resMap := rs.ls.Query(query)
-	retMap := zebra.NewResourceMap(resMap.GetFactory())
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
 
-	zebra.CopyResourceMap(retMap, resMap)
+	// Scan the resources while the label index is not warm
+	if rs.labels == nil {
+		return FilterLabel(query, rs.current().resources(rs.Factory, nil))
+	}
 
-	return retMap, nil
+	return rs.labels.query(query), nil
+}
+
+// LabelCardinality returns the number of resources matching the label query
+// according to the label index, false while the index is not warm.
+func (rs *ResourceStore) LabelCardinality(query zebra.Query) (int, bool) {
+	if query.Op == zebra.MatchUnder {
+		return len(rs.under(query.Values)), true
+	}
+
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
+
+	if rs.labels == nil {
+		return 0, false
+	}
+
+	return rs.labels.cardinality(query), true
+}
+
+// TypeCardinality returns the number of resources of the given types.
+func (rs *ResourceStore) TypeCardinality(types []string) int {
+	if len(types) == 0 {
+		return 0
+	}
+
+	return rs.current().count(types)
+}
+
+// IndexStats returns the size of the label index, false while the index is
+// not warm.
+func (rs *ResourceStore) IndexStats() (labelstore.Stats, bool) {
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
+
+	if rs.labels == nil {
+		return labelstore.Stats{Keys: 0, Values: 0, Entries: 0, Slots: 0}, false
+	}
+
+	return rs.labels.stats(), true
+}
+
+// CompactIndex collects all buckets of the label index and returns the
+// number collected, once the index is warm.
+func (rs *ResourceStore) CompactIndex() int {
+	rs.waitWarm()
+
+	rs.indexLock.RLock()
+	defer rs.indexLock.RUnlock()
+
+	if rs.labels == nil {
+		return 0
+	}
+
+	return rs.labels.compact()
 }
 
 // Return resources which match given property/value(s).
 // Naive search implementation, >= O(n) for n resources.
 func (rs *ResourceStore) QueryProperty(query zebra.Query) (*zebra.ResourceMap, error) {
-	if err := query.Validate(); err != nil {
+	if err := validProperty(query); err != nil {
 		return nil, err
 	}
 
-	rs.lock.RLock()
-	defer rs.lock.RUnlock()
-
 	if query.Op == zebra.MatchEqual || query.Op == zebra.MatchIn {
 		return rs.propertyMatch(query, true)
 	}
@@ -239,11 +556,7 @@ func (rs *ResourceStore) QueryProperty(query zebra.Query) (*zebra.ResourceMap, e
 }
 
 func (rs *ResourceStore) propertyMatch(query zebra.Query, inVals bool) (*zebra.ResourceMap, error) {
-	resMap, err := rs.ts.Load()
-	if err != nil {
-		return nil, err
-	}
-
+	resMap := rs.current().resources(rs.Factory, nil)
 	retMap := zebra.NewResourceMap(rs.Factory)
 
 	for t, l := range resMap.Resources {
@@ -297,12 +610,43 @@ func FilterType(types []string, resMap *zebra.ResourceMap) (*zebra.ResourceMap,
 	return retMap, nil
 }
 
-// Filter given map by label name and val.
+// FilterLifecycle returns the resources of the map in one of the lifecycle
+// stages.
+func FilterLifecycle(stages []zebra.Lifecycle, resMap *zebra.ResourceMap) *zebra.ResourceMap {
+	retMap := zebra.NewResourceMap(resMap.GetFactory())
+
+	for t, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			status := res.GetStatus()
+			if status == nil {
+				continue
+			}
+
+			for _, stage := range stages {
+				if status.Lifecycle == stage {
+					retMap.Add(res, t)
+
+					break
+				}
+			}
+		}
+	}
+
+	return retMap
+}
+
+// Filter given map by label name and val. Subtree queries only find the
+// resources under parents that are in the map, with all the resources in
+// between.
 func FilterLabel(query zebra.Query, resMap *zebra.ResourceMap) (*zebra.ResourceMap, error) {
 	if err := query.Validate(); err != nil {
 		return resMap, err
 	}
 
+	if query.Op == zebra.MatchUnder {
+		return filterUnder(query, resMap), nil
+	}
+
 	retMap := zebra.NewResourceMap(resMap.GetFactory())
 
 	inVals := false
@@ -327,7 +671,7 @@ func FilterLabel(query zebra.Query, resMap *zebra.ResourceMap) (*zebra.ResourceM
 
 // Filter given map by property name (case insensitive) and val.
 func FilterProperty(query zebra.Query, resMap *zebra.ResourceMap) (*zebra.ResourceMap, error) {
-	if err := query.Validate(); err != nil {
+	if err := validProperty(query); err != nil {
 		return resMap, err
 	}
 
@@ -362,3 +706,12 @@ func FieldByName(v reflect.Value, field string) reflect.Value {
 			return strings.ToLower(found) == field
 		})
 }
+
+// validProperty validates a property query, properties have no subtrees.
+func validProperty(query zebra.Query) error {
+	if query.Op == zebra.MatchUnder {
+		return zebra.ErrInvalidQuery
+	}
+
+	return query.Validate()
+}
@@ -1,15 +1,22 @@
 package store_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/cmd/herd/pkg"
 	"github.com/project-safari/zebra/dc"
 	"github.com/project-safari/zebra/network"
 	"github.com/project-safari/zebra/store"
+	"github.com/project-safari/zebra/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -508,3 +515,389 @@ func TestQueryProperty(t *testing.T) {
 	assert.Nil(resMap)
 	assert.NotNil(err)
 }
+
+func newConformanceStore(t *testing.T) zebra.Store {
+	t.Helper()
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	assert.Nil(t, rs.Initialize())
+
+	return rs
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, storetest.Suite{
+		New: newConformanceStore,
+		Reopen: func(t *testing.T, s zebra.Store) zebra.Store {
+			t.Helper()
+
+			rs := store.NewResourceStore(s.(*store.ResourceStore).StorageRoot, storetest.Factory())
+			assert.Nil(t, rs.Initialize())
+
+			return rs
+		},
+	})
+}
+
+func FuzzStore(f *testing.F) {
+	storetest.Fuzz(f, newConformanceStore)
+}
+
+func TestConformanceSegments(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(root string) *store.ResourceStore {
+		rs := store.NewResourceStore(root, storetest.Factory())
+		rs.Format = store.FormatSegments
+		assert.Nil(t, rs.Initialize())
+
+		return rs
+	}
+
+	storetest.Run(t, storetest.Suite{
+		New: func(t *testing.T) zebra.Store {
+			t.Helper()
+
+			return newStore(t.TempDir())
+		},
+		Reopen: func(t *testing.T, s zebra.Store) zebra.Store {
+			t.Helper()
+
+			return newStore(s.(*store.ResourceStore).StorageRoot)
+		},
+	})
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	rs.Format = "tape"
+	assert.ErrorIs(rs.Initialize(), store.ErrFormat)
+
+	// Only segments can be compacted
+	rs.Format = store.FormatFiles
+	assert.Nil(rs.Initialize())
+
+	reclaimed, err := rs.Compact()
+	assert.Nil(err)
+	assert.Equal(int64(0), reclaimed)
+}
+
+func TestLazyIndex(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+
+	rs := store.NewResourceStore(root, storetest.Factory())
+	assert.Nil(rs.Initialize())
+	assert.False(rs.Warming())
+
+	red := storetest.NewLab("red", zebra.Labels{"color": "red"})
+	assert.Nil(rs.Create(red))
+	assert.Nil(rs.Create(storetest.NewLab("blue", zebra.Labels{"color": "blue"})))
+
+	lazy := store.NewResourceStore(root, storetest.Factory())
+	lazy.LazyIndex = true
+	assert.Nil(lazy.Initialize())
+
+	// Label queries are answered whether or not the index is warm
+	query := zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}}
+
+	resMap, err := lazy.QueryLabel(query)
+	assert.Nil(err)
+	assert.Equal(1, len(resMap.Resources["Lab"].Resources))
+
+	// Changes wait for the index
+	assert.Nil(lazy.Delete(red))
+	assert.False(lazy.Warming())
+
+	resMap, err = lazy.QueryLabel(query)
+	assert.Nil(err)
+	assert.Empty(resMap.Resources)
+}
+
+func TestCardinality(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	assert.Nil(rs.Initialize())
+
+	assert.Nil(rs.Create(storetest.NewLab("red", zebra.Labels{"color": "red"})))
+	assert.Nil(rs.Create(storetest.NewLab("blue", zebra.Labels{"color": "blue"})))
+	assert.Nil(rs.Create(storetest.NewRack("rack", "row")))
+
+	count, ok := rs.LabelCardinality(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}})
+	assert.True(ok)
+	assert.Equal(1, count)
+
+	assert.Equal(2, rs.TypeCardinality([]string{"Lab"}))
+	assert.Equal(3, rs.TypeCardinality([]string{"Lab", "Rack"}))
+	assert.Equal(0, rs.TypeCardinality(nil))
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	assert.Nil(rs.Initialize())
+
+	red := storetest.NewLab("red", zebra.Labels{"color": "red"})
+	assert.Nil(rs.Create(red))
+
+	snap := rs.Snapshot()
+	assert.Equal(1, snap.Len())
+	assert.Same(snap, rs.Snapshot())
+
+	// Changes after the snapshot was taken do not show in it
+	assert.Nil(rs.Create(storetest.NewLab("blue", zebra.Labels{"color": "blue"})))
+	assert.Nil(rs.Delete(red))
+
+	assert.Equal(1, snap.Len())
+	assert.Equal(1, len(snap.QueryUUID([]string{red.ID}).Resources["Lab"].Resources))
+	assert.Equal(1, len(snap.QueryType([]string{"Lab"}).Resources["Lab"].Resources))
+
+	resMap, err := snap.QueryLabel(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"blue"}})
+	assert.Nil(err)
+	assert.Empty(resMap.Resources)
+
+	latest := rs.Snapshot()
+	assert.Less(snap.Version(), latest.Version())
+	assert.False(latest.Taken().Before(snap.Taken()))
+	assert.Equal(1, latest.Len())
+	assert.Equal("blue", latest.Resources().Resources["Lab"].Resources[0].GetLabels()["color"])
+
+	// Readers iterate over snapshots while the store changes
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 50; i++ {
+			assert.Nil(rs.Create(storetest.NewLab("lab", zebra.Labels{"color": "green"})))
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		s := rs.Snapshot()
+		assert.Equal(s.Len(), len(s.QueryType([]string{"Lab"}).Resources["Lab"].Resources))
+	}
+
+	<-done
+	assert.Equal(51, rs.Snapshot().Len())
+}
+
+func TestReplaceType(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	assert.Nil(rs.Initialize())
+
+	lab := storetest.NewLab("lab", zebra.Labels{"color": "red"})
+	rack := storetest.NewRack("rack", "row")
+	rack.ID = lab.ID
+
+	assert.Nil(rs.Create(lab))
+	assert.Nil(rs.Create(rack))
+
+	// An ID names one resource, whatever its type
+	resMap := rs.QueryUUID([]string{lab.ID})
+	assert.Equal(1, len(resMap.Resources))
+	assert.Equal(1, len(resMap.Resources["Rack"].Resources))
+	assert.Empty(rs.QueryType([]string{"Lab"}).Resources)
+	assert.Equal(0, rs.TypeCardinality([]string{"Lab"}))
+	assert.Equal(1, rs.TypeCardinality([]string{"Lab", "Rack"}))
+
+	assert.Nil(rs.Delete(rack))
+	assert.Empty(rs.Query().Resources)
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	rs := store.NewResourceStore(t.TempDir(), storetest.Factory())
+	assert.Nil(rs.Initialize())
+
+	lab := storetest.NewLab("lab", nil)
+	lab.Status.Lifecycle = zebra.LifecycleActive
+	assert.Nil(rs.Create(lab))
+
+	next := func(l zebra.Lifecycle) *dc.Lab {
+		c := storetest.NewLab("lab", nil)
+		c.ID = lab.ID
+		c.Status.Lifecycle = l
+
+		return c
+	}
+
+	assert.ErrorIs(rs.Create(next(zebra.LifecyclePlanned)), zebra.ErrLifecycleTransition)
+	assert.Nil(rs.Create(next(zebra.LifecycleMaintenance)))
+
+	// Changes which leave out the lifecycle keep the stage
+	kept := next("")
+	assert.Nil(rs.Create(kept))
+	assert.Equal(zebra.LifecycleMaintenance, kept.Status.Lifecycle)
+
+	resMap := store.FilterLifecycle([]zebra.Lifecycle{zebra.LifecycleMaintenance}, rs.Query())
+	assert.Equal(1, len(resMap.Resources["Lab"].Resources))
+	assert.Empty(store.FilterLifecycle([]zebra.Lifecycle{zebra.LifecycleActive}, rs.Query()).Resources)
+
+	// Types follow their own transitions, if any
+	rs.Lifecycle.Types["Lab"] = zebra.Transitions{zebra.LifecycleMaintenance: {zebra.LifecyclePlanned}}
+	assert.Nil(rs.Create(next(zebra.LifecyclePlanned)))
+
+	rs.Lifecycle = nil
+	assert.Nil(rs.Create(next(zebra.LifecycleDecommissioned)))
+}
+
+var errMigrated = errors.New("resource migrated twice")
+
+func TestMigrateSchemas(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	rs := store.NewResourceStore(root, storetest.Factory())
+	assert.Nil(rs.Initialize())
+
+	lab := storetest.NewLab("lab", nil)
+	assert.Nil(rs.Create(lab))
+
+	// The next version of the Lab type renames the lab on load
+	labType := dc.LabType()
+	labType.Migrations = []zebra.Migration{func(fields map[string]json.RawMessage) error {
+		fields["name"] = json.RawMessage(`"migrated"`)
+
+		return nil
+	}}
+
+	rs = store.NewResourceStore(root, storetest.Factory().Add(labType))
+	assert.Nil(rs.Initialize())
+	assert.Equal("migrated", rs.QueryUUID([]string{lab.ID}).Resources["Lab"].Resources[0].(*dc.Lab).Name)
+
+	_, err := rs.MigrateSchemas([]string{"Spaceship"})
+	assert.ErrorIs(err, zebra.ErrUnknownType)
+
+	written, err := rs.MigrateSchemas(nil)
+	assert.Nil(err)
+	assert.Equal(map[string]int{"Lab": 1}, written)
+
+	// Rewritten resources are not migrated again
+	labType.Migrations[0] = func(map[string]json.RawMessage) error { return errMigrated }
+	rs = store.NewResourceStore(root, storetest.Factory().Add(labType))
+	assert.Nil(rs.Initialize())
+	assert.NotNil(rs.QueryUUID([]string{lab.ID}).Resources["Lab"])
+}
+
+// BenchmarkMixedLoad queries the store while every tenth operation writes to
+// it and reports the 99th percentile latency of the queries.
+func BenchmarkMixedLoad(b *testing.B) {
+	rs := store.NewResourceStore(b.TempDir(), storetest.Factory())
+	if err := rs.Initialize(); err != nil {
+		b.Fatal(err)
+	}
+
+	ids := make([]string, 0, 1000)
+
+	for i := 0; i < 1000; i++ {
+		lab := storetest.NewLab(fmt.Sprint(i), zebra.Labels{"color": fmt.Sprint(i % 100)})
+		if err := rs.Create(lab); err != nil {
+			b.Fatal(err)
+		}
+
+		ids = append(ids, lab.ID)
+	}
+
+	lock := sync.Mutex{}
+	latencies := []time.Duration{}
+	ops := int64(0)
+	query := zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"1"}}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		local := []time.Duration{}
+
+		for pb.Next() {
+			op := atomic.AddInt64(&ops, 1)
+			start := time.Now()
+
+			switch op % 10 {
+			case 0:
+				_ = rs.Create(storetest.NewLab("new", zebra.Labels{"color": "new"}))
+
+				continue
+			case 1, 2, 3:
+				_, _ = rs.QueryLabel(query)
+			default:
+				_ = rs.QueryUUID(ids[op%int64(len(ids)):][:1])
+			}
+
+			local = append(local, time.Since(start))
+		}
+
+		lock.Lock()
+		latencies = append(latencies, local...)
+		lock.Unlock()
+	})
+
+	b.StopTimer()
+
+	if len(latencies) != 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns/query")
+	}
+}
+
+// BenchmarkLabelWrites relabels resources in parallel while every fourth
+// operation queries by label, so that it measures the contention of the
+// label index rather than that of the backend.
+func BenchmarkLabelWrites(b *testing.B) {
+	rs := store.NewResourceStore(b.TempDir(), storetest.Factory())
+	rs.Format = store.FormatMemory
+
+	if err := rs.Initialize(); err != nil {
+		b.Fatal(err)
+	}
+
+	labs := make([]zebra.Resource, 0, 1000)
+
+	for i := 0; i < 1000; i++ {
+		lab := storetest.NewLab(fmt.Sprint(i), zebra.Labels{"color": fmt.Sprint(i % 100)})
+		if err := rs.Create(lab); err != nil {
+			b.Fatal(err)
+		}
+
+		labs = append(labs, lab)
+	}
+
+	ops := int64(0)
+	query := zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"1"}}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			op := atomic.AddInt64(&ops, 1)
+
+			if op%4 == 0 {
+				_, _ = rs.QueryLabel(query)
+
+				continue
+			}
+
+			lab := storetest.NewLab("relabeled", zebra.Labels{"color": fmt.Sprint(op % 100)})
+			lab.ID = labs[op%int64(len(labs))].GetID()
+
+			_ = rs.Create(lab)
+		}
+	})
+}
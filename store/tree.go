@@ -0,0 +1,193 @@
+package store
+
+import (
+	"github.com/project-safari/zebra"
+)
+
+// treeIndex holds the closure of the parent relation: the ancestors of each
+// resource, nearest first, and, in reverse, the descendants of each resource.
+// Resources are indexed by the IDs of their parents, whether these are stored
+// or not, so that a parent created after its children takes them in.
+type treeIndex struct {
+	parent      map[string]string
+	ancestors   map[string][]string
+	descendants map[string]map[string]struct{}
+}
+
+func newTreeIndex(resources []zebra.Resource) *treeIndex {
+	x := &treeIndex{
+		parent:      map[string]string{},
+		ancestors:   map[string][]string{},
+		descendants: map[string]map[string]struct{}{},
+	}
+
+	for _, res := range resources {
+		x.put(res)
+	}
+
+	return x
+}
+
+// put indexes the resource under its parent.
+func (x *treeIndex) put(res zebra.Resource) {
+	x.move(res.GetID(), res.GetLabels()[zebra.ParentLabel])
+}
+
+// remove takes the resource out of the tree, its descendants are no longer
+// under its ancestors.
+func (x *treeIndex) remove(id string) {
+	x.move(id, "")
+}
+
+// move moves the resource and its descendants under the parent, or to the
+// root if the parent is empty. A parent that would close a cycle is ignored.
+func (x *treeIndex) move(id string, parent string) {
+	if x.parent[id] == parent {
+		return
+	}
+
+	if _, cycle := x.descendants[id][parent]; cycle || parent == id {
+		parent = ""
+	}
+
+	subtree := make([]string, 0, len(x.descendants[id])+1)
+	subtree = append(subtree, id)
+
+	for d := range x.descendants[id] {
+		subtree = append(subtree, d)
+	}
+
+	// The old ancestors of the resource end the ancestors of the subtree
+	old := x.ancestors[id]
+
+	for _, n := range subtree {
+		kept := x.ancestors[n][:len(x.ancestors[n])-len(old)]
+		x.ancestors[n] = kept
+
+		for _, a := range old {
+			delete(x.descendants[a], n)
+
+			if len(x.descendants[a]) == 0 {
+				delete(x.descendants, a)
+			}
+		}
+	}
+
+	delete(x.parent, id)
+
+	if parent != "" {
+		x.parent[id] = parent
+
+		added := append([]string{parent}, x.ancestors[parent]...)
+
+		for _, n := range subtree {
+			x.ancestors[n] = append(append([]string{}, x.ancestors[n]...), added...)
+
+			for _, a := range added {
+				if x.descendants[a] == nil {
+					x.descendants[a] = map[string]struct{}{}
+				}
+
+				x.descendants[a][n] = struct{}{}
+			}
+		}
+	}
+
+	for _, n := range subtree {
+		if len(x.ancestors[n]) == 0 {
+			delete(x.ancestors, n)
+		}
+	}
+}
+
+// under returns the IDs of the descendants of the resources of the IDs.
+func (x *treeIndex) under(ids []string) map[string]struct{} {
+	found := map[string]struct{}{}
+
+	for _, id := range ids {
+		for d := range x.descendants[id] {
+			found[d] = struct{}{}
+		}
+	}
+
+	return found
+}
+
+// indexTree indexes the parent of the changed resource, if the index was
+// built.
+func (rs *ResourceStore) indexTree(res zebra.Resource, deleted bool) {
+	rs.treeLock.Lock()
+	defer rs.treeLock.Unlock()
+
+	switch {
+	case rs.tree == nil:
+		return
+	case deleted:
+		rs.tree.remove(res.GetID())
+	default:
+		rs.tree.put(res)
+	}
+}
+
+// resetTree drops the tree index, it is built again when next needed.
+func (rs *ResourceStore) resetTree() {
+	rs.treeLock.Lock()
+	defer rs.treeLock.Unlock()
+
+	rs.tree = nil
+}
+
+// under returns the IDs of the resources under the resources of the IDs,
+// transitively. The tree index is built on first use and kept up to date by
+// the changes after that, a subtree is looked up without walking it.
+func (rs *ResourceStore) under(ids []string) map[string]struct{} {
+	rs.treeLock.Lock()
+	defer rs.treeLock.Unlock()
+
+	if rs.tree == nil {
+		all := []zebra.Resource{}
+		for _, l := range rs.current().resources(rs.Factory, nil).Resources {
+			all = append(all, l.Resources...)
+		}
+
+		rs.tree = newTreeIndex(all)
+	}
+
+	return rs.tree.under(ids)
+}
+
+// queryUnder returns the resources under the parents of the subtree query.
+func (rs *ResourceStore) queryUnder(query zebra.Query) *zebra.ResourceMap {
+	v := rs.current()
+	retMap := zebra.NewResourceMap(rs.Factory)
+
+	for id := range rs.under(query.Values) {
+		if res := v.find(id); res != nil {
+			retMap.Add(res, res.GetType())
+		}
+	}
+
+	return retMap
+}
+
+// filterUnder returns the resources of the map under the parents of the
+// subtree query, with the tree of the resources of the map alone.
+func filterUnder(query zebra.Query, resMap *zebra.ResourceMap) *zebra.ResourceMap {
+	all := []zebra.Resource{}
+	for _, l := range resMap.Resources {
+		all = append(all, l.Resources...)
+	}
+
+	under := newTreeIndex(all).under(query.Values)
+	retMap := zebra.NewResourceMap(resMap.GetFactory())
+
+	for t, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			if _, ok := under[res.GetID()]; ok {
+				retMap.Add(res, t)
+			}
+		}
+	}
+
+	return retMap
+}
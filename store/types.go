@@ -3,7 +3,9 @@ package store
 import (
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/auth"
+	"github.com/project-safari/zebra/cloud"
 	"github.com/project-safari/zebra/compute"
+	"github.com/project-safari/zebra/controller"
 	"github.com/project-safari/zebra/dc"
 	"github.com/project-safari/zebra/lease"
 	"github.com/project-safari/zebra/network"
@@ -29,11 +31,21 @@ func DefaultFactory() zebra.ResourceFactory {
 	factory.Add(compute.VCenterType())
 	factory.Add(compute.VMType())
 
+	// cloud resources
+	factory.Add(cloud.InstanceType())
+	factory.Add(cloud.NetworkType())
+
 	// zebra server resources
 	factory.Add(auth.UserType())
+	factory.Add(auth.ServiceAccountType())
+	factory.Add(auth.PolicyType())
 
-	// zebra lease resource
+	// zebra lease resources
 	factory.Add(lease.Type())
+	factory.Add(lease.PolicyType())
+
+	// zebra controller resources
+	factory.Add(controller.LeaderType())
 
 	// Need to add all the known types here
 	return factory
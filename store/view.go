@@ -0,0 +1,189 @@
+package store
+
+import (
+	"hash/fnv"
+
+	"github.com/project-safari/zebra"
+)
+
+// viewShards is the number of shards the resources of a type are spread
+// over in a view, by the hash of their ID.
+const viewShards = 64
+
+// view is an immutable state of the resources, by type and ID. A change
+// publishes a new view in which only the shard of the changed resource is
+// copied, so reads load the current view and never wait for changes.
+type view struct {
+	version uint64
+	types   map[string]*typeView
+}
+
+// typeView holds the resources of a type, sharded by ID. The shards are
+// small enough to be scanned and copied whole.
+type typeView struct {
+	count  int
+	shards [viewShards][]zebra.Resource
+}
+
+func shardOf(id string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+
+	return int(h.Sum32() % viewShards)
+}
+
+func newView(resources *zebra.ResourceMap, version uint64) *view {
+	v := &view{version: version, types: make(map[string]*typeView)}
+
+	if resources == nil {
+		return v
+	}
+
+	for t, l := range resources.Resources {
+		tv := new(typeView)
+
+		for _, res := range l.Resources {
+			i := shardOf(res.GetID())
+			tv.shards[i] = put(tv.shards[i], res)
+		}
+
+		for _, shard := range tv.shards {
+			tv.count += len(shard)
+		}
+
+		if tv.count != 0 {
+			v.types[t] = tv
+		}
+	}
+
+	return v
+}
+
+// change returns a view in which the shard of the ID in the type is replaced
+// by a copy that the update is applied to.
+func (v *view) change(resType string, id string, update func(shard []zebra.Resource) []zebra.Resource) *view {
+	next := &view{version: v.version + 1, types: make(map[string]*typeView, len(v.types))}
+
+	for t, tv := range v.types {
+		next.types[t] = tv
+	}
+
+	tv := new(typeView)
+	if old := v.types[resType]; old != nil {
+		*tv = *old
+	}
+
+	i := shardOf(id)
+	shard := append(make([]zebra.Resource, 0, len(tv.shards[i])+1), tv.shards[i]...)
+
+	tv.count -= len(shard)
+	shard = update(shard)
+	tv.count += len(shard)
+	tv.shards[i] = shard
+
+	if tv.count == 0 {
+		delete(next.types, resType)
+	} else {
+		next.types[resType] = tv
+	}
+
+	return next
+}
+
+// with returns a view with the resource added, or replacing the resource of
+// the same ID.
+func (v *view) with(res zebra.Resource) *view {
+	id := res.GetID()
+	next := v
+
+	// An ID is unique across types
+	if old := v.find(id); old != nil && old.GetType() != res.GetType() {
+		next = v.without(id)
+	}
+
+	return next.change(res.GetType(), id, func(shard []zebra.Resource) []zebra.Resource { return put(shard, res) })
+}
+
+// without returns a view without the resource of the given ID.
+func (v *view) without(id string) *view {
+	old := v.find(id)
+	if old == nil {
+		return &view{version: v.version + 1, types: v.types}
+	}
+
+	return v.change(old.GetType(), id, func(shard []zebra.Resource) []zebra.Resource { return remove(shard, id) })
+}
+
+// put adds the resource to the shard or replaces the one with its ID.
+func put(shard []zebra.Resource, res zebra.Resource) []zebra.Resource {
+	for i, r := range shard {
+		if r.GetID() == res.GetID() {
+			shard[i] = res
+
+			return shard
+		}
+	}
+
+	return append(shard, res)
+}
+
+// remove removes the resource with the ID from the shard.
+func remove(shard []zebra.Resource, id string) []zebra.Resource {
+	for i, r := range shard {
+		if r.GetID() == id {
+			return append(shard[:i], shard[i+1:]...)
+		}
+	}
+
+	return shard
+}
+
+// find returns the resource with the given ID, or nil.
+func (v *view) find(id string) zebra.Resource {
+	i := shardOf(id)
+
+	for _, tv := range v.types {
+		for _, res := range tv.shards[i] {
+			if res.GetID() == id {
+				return res
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *view) count(types []string) int {
+	n := 0
+
+	for t, tv := range v.types {
+		if types == nil || zebra.IsIn(t, types) {
+			n += tv.count
+		}
+	}
+
+	return n
+}
+
+// resources returns the resources of the given types, all if types is nil,
+// in a new resource map.
+func (v *view) resources(factory zebra.ResourceFactory, types []string) *zebra.ResourceMap {
+	resMap := zebra.NewResourceMap(factory)
+
+	for t, tv := range v.types {
+		if types != nil && !zebra.IsIn(t, types) {
+			continue
+		}
+
+		l := zebra.NewResourceList(factory)
+		l.Resources = make([]zebra.Resource, 0, tv.count)
+
+		for _, shard := range tv.shards {
+			l.Resources = append(l.Resources, shard...)
+		}
+
+		resMap.Resources[t] = l
+	}
+
+	return resMap
+}
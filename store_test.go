@@ -23,6 +23,16 @@ func TestValidateStore(t *testing.T) {
 	q.Key = "key"
 	q.Values = []string{"value"}
 	assert.Nil(q.Validate())
+
+	// Subtree queries only apply to the parent label
+	q.Op = zebra.MatchUnder
+	assert.NotNil(q.Validate())
+
+	q.Key = zebra.ParentLabel
+	assert.Nil(q.Validate())
+
+	q.Values = nil
+	assert.NotNil(q.Validate())
 }
 
 func TestMarshalQuery(t *testing.T) {
@@ -0,0 +1,558 @@
+// Package storetest provides a conformance suite for zebra stores, so that
+// every store backend can be verified against the same semantics.
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/project-safari/zebra"
+	"github.com/project-safari/zebra/dc"
+	"github.com/stretchr/testify/assert"
+)
+
+// Concurrency is the number of goroutines used by the concurrency tests.
+const Concurrency = 16
+
+// Group is the system.group label value of the test resources.
+const Group = "storetest"
+
+// BasicStore is the part of the store interface implemented by every store,
+// including the sub-stores indexing the resources of a zebra.Store.
+type BasicStore interface {
+	Initialize() error
+	Wipe() error
+	Clear() error
+	Load() (*zebra.ResourceMap, error)
+	Create(res zebra.Resource) error
+	Delete(res zebra.Resource) error
+}
+
+// Suite describes the store under test.
+type Suite struct {
+	// New returns a new, initialized and empty store.
+	New func(t *testing.T) zebra.Store
+
+	// Reopen, if set, returns a new instance of the store reading the same
+	// storage as the given store, to verify that resources persist.
+	Reopen func(t *testing.T, s zebra.Store) zebra.Store
+}
+
+// Factory returns the resource factory the store under test must be created
+// with, it holds the types of the test resources.
+func Factory() zebra.ResourceFactory {
+	return zebra.Factory().Add(dc.LabType()).Add(dc.RackType())
+}
+
+// NewLab returns a valid test resource with the given labels.
+func NewLab(name string, labels zebra.Labels) *dc.Lab {
+	all := zebra.Labels{"system.group": Group}
+	for k, v := range labels {
+		all.Add(k, v)
+	}
+
+	return dc.NewLab(name, all)
+}
+
+// NewRack returns a valid test resource of a second type.
+func NewRack(name string, row string) *dc.Rack {
+	return dc.NewRack(name, row, zebra.Labels{"system.group": Group})
+}
+
+// Run runs the conformance suite against the store.
+func Run(t *testing.T, suite Suite) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, s zebra.Store)
+	}{
+		{"Create", testCreate},
+		{"CreateInvalid", testCreateInvalid},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Clear", testClear},
+		{"QueryUUID", testQueryUUID},
+		{"QueryType", testQueryType},
+		{"QueryLabel", testQueryLabel},
+		{"QueryProperty", testQueryProperty},
+		{"QueryUnder", testQueryUnder},
+		{"QueryInvalid", testQueryInvalid},
+		{"QueryCopy", testQueryCopy},
+		{"Concurrency", testConcurrency},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, suite.New(t))
+		})
+	}
+
+	if suite.Reopen != nil {
+		t.Run("Persist", func(t *testing.T) {
+			testPersist(t, suite)
+		})
+	}
+}
+
+// RunBasic runs the part of the conformance suite that applies to a
+// BasicStore. The store must be initialized and empty, and Load may group
+// the resources by any key.
+func RunBasic(t *testing.T, newStore func(t *testing.T) BasicStore) {
+	t.Helper()
+
+	t.Run("CreateUpdateDelete", func(t *testing.T) {
+		assert := assert.New(t)
+		s := newStore(t)
+
+		lab := NewLab("lab", zebra.Labels{"color": "red"})
+		assert.Nil(s.Create(lab))
+		assert.Nil(s.Create(NewRack("rack", "row")))
+		assert.Equal(2, countIDs(t, s))
+
+		updated := NewLab("lab2", zebra.Labels{"color": "blue"})
+		updated.ID = lab.ID
+		assert.Nil(s.Create(updated))
+		assert.Equal(2, countIDs(t, s))
+
+		assert.Nil(s.Delete(updated))
+		assert.Equal(1, countIDs(t, s))
+
+		// Deleting a resource that is not in the store is not an error
+		assert.Nil(s.Delete(updated))
+		assert.Equal(1, countIDs(t, s))
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		assert := assert.New(t)
+		s := newStore(t)
+
+		assert.Nil(s.Create(NewLab("lab", nil)))
+		assert.Nil(s.Clear())
+		assert.Equal(0, countIDs(t, s))
+
+		assert.Nil(s.Create(NewLab("lab", nil)))
+		assert.Equal(1, countIDs(t, s))
+	})
+}
+
+// countIDs returns the number of unique resources loaded from the store.
+func countIDs(t *testing.T, s BasicStore) int {
+	t.Helper()
+
+	resMap, err := s.Load()
+	assert.Nil(t, err)
+
+	ids := map[string]bool{}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			ids[res.GetID()] = true
+		}
+	}
+
+	return len(ids)
+}
+
+// ids returns the ids of the resources in the map.
+func ids(resMap *zebra.ResourceMap) []string {
+	ret := []string{}
+
+	for _, l := range resMap.Resources {
+		for _, res := range l.Resources {
+			ret = append(ret, res.GetID())
+		}
+	}
+
+	return ret
+}
+
+func testCreate(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", nil)
+	assert.Nil(s.Create(lab))
+
+	found := s.QueryUUID([]string{lab.ID})
+	assert.Len(found.Resources["Lab"].Resources, 1)
+	assert.Equal("lab", found.Resources["Lab"].Resources[0].(*dc.Lab).Name)
+	assert.Equal([]string{lab.ID}, ids(s.Query()))
+
+	loaded, err := s.Load()
+	assert.Nil(err)
+	assert.Equal([]string{lab.ID}, ids(loaded))
+}
+
+func testCreateInvalid(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	assert.ErrorIs(s.Create(nil), zebra.ErrInvalidResource)
+	assert.ErrorIs(s.Delete(nil), zebra.ErrInvalidResource)
+
+	noGroup := dc.NewLab("lab", nil)
+	assert.ErrorIs(s.Create(noGroup), zebra.ErrInvalidResource)
+
+	noName := NewLab("", nil)
+	assert.ErrorIs(s.Create(noName), zebra.ErrInvalidResource)
+
+	assert.Empty(ids(s.Query()))
+}
+
+func testUpdate(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", zebra.Labels{"color": "red"})
+	assert.Nil(s.Create(lab))
+
+	updated := NewLab("lab2", zebra.Labels{"color": "blue"})
+	updated.ID = lab.ID
+	assert.Nil(s.Create(updated))
+
+	assert.Equal([]string{lab.ID}, ids(s.Query()))
+
+	found := s.QueryUUID([]string{lab.ID})
+	assert.Equal("lab2", found.Resources["Lab"].Resources[0].(*dc.Lab).Name)
+
+	red, err := s.QueryLabel(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}})
+	assert.Nil(err)
+	assert.Empty(ids(red))
+
+	blue, err := s.QueryLabel(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"blue"}})
+	assert.Nil(err)
+	assert.Equal([]string{lab.ID}, ids(blue))
+
+	named, err := s.QueryProperty(zebra.Query{Key: "Name", Op: zebra.MatchEqual, Values: []string{"lab"}})
+	assert.Nil(err)
+	assert.Empty(ids(named))
+}
+
+func testDelete(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", zebra.Labels{"color": "red"})
+	rack := NewRack("rack", "row")
+
+	assert.Nil(s.Create(lab))
+	assert.Nil(s.Create(rack))
+	assert.Nil(s.Delete(lab))
+
+	assert.Equal([]string{rack.ID}, ids(s.Query()))
+	assert.Empty(ids(s.QueryUUID([]string{lab.ID})))
+	assert.Empty(ids(s.QueryType([]string{"Lab"})))
+
+	red, err := s.QueryLabel(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}})
+	assert.Nil(err)
+	assert.Empty(ids(red))
+
+	// Deleting a resource that is not in the store is not an error
+	assert.Nil(s.Delete(lab))
+}
+
+func testClear(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(s.Create(NewLab(fmt.Sprintf("lab%d", i), nil)))
+	}
+
+	assert.Len(ids(s.Query()), 3)
+	assert.Nil(s.Clear())
+	assert.Empty(ids(s.Query()))
+
+	lab := NewLab("lab", nil)
+	assert.Nil(s.Create(lab))
+	assert.Equal([]string{lab.ID}, ids(s.Query()))
+}
+
+func testQueryUUID(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", nil)
+	rack := NewRack("rack", "row")
+
+	assert.Nil(s.Create(lab))
+	assert.Nil(s.Create(rack))
+
+	assert.ElementsMatch([]string{lab.ID, rack.ID}, ids(s.QueryUUID([]string{lab.ID, rack.ID})))
+	assert.Equal([]string{rack.ID}, ids(s.QueryUUID([]string{rack.ID, "missing"})))
+	assert.Empty(ids(s.QueryUUID(nil)))
+}
+
+func testQueryType(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", nil)
+	rack := NewRack("rack", "row")
+
+	assert.Nil(s.Create(lab))
+	assert.Nil(s.Create(rack))
+
+	assert.Equal([]string{lab.ID}, ids(s.QueryType([]string{"Lab"})))
+	assert.ElementsMatch([]string{lab.ID, rack.ID}, ids(s.QueryType([]string{"Lab", "Rack"})))
+	assert.Empty(ids(s.QueryType([]string{"Server"})))
+}
+
+func testQueryLabel(t *testing.T, s zebra.Store) {
+	red := NewLab("red", zebra.Labels{"color": "red"})
+	blue := NewLab("blue", zebra.Labels{"color": "blue"})
+	green := NewLab("green", zebra.Labels{"color": "green"})
+
+	for _, res := range []zebra.Resource{red, blue, green} {
+		assert.Nil(t, s.Create(res))
+	}
+
+	tests := []struct {
+		name     string
+		query    zebra.Query
+		expected []string
+	}{
+		{"Equal", zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}}, []string{red.ID}},
+		{"NotEqual", zebra.Query{Key: "color", Op: zebra.MatchNotEqual, Values: []string{"red"}}, []string{blue.ID, green.ID}},
+		{"In", zebra.Query{Key: "color", Op: zebra.MatchIn, Values: []string{"red", "blue"}}, []string{red.ID, blue.ID}},
+		{"NotIn", zebra.Query{Key: "color", Op: zebra.MatchNotIn, Values: []string{"red", "blue"}}, []string{green.ID}},
+		{"NoMatch", zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"pink"}}, []string{}},
+		{"MissingKey", zebra.Query{Key: "shape", Op: zebra.MatchIn, Values: []string{"round"}}, []string{}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			resMap, err := s.QueryLabel(test.query)
+			assert.Nil(err)
+			assert.ElementsMatch(test.expected, ids(resMap))
+		})
+	}
+}
+
+func testQueryProperty(t *testing.T, s zebra.Store) {
+	lab := NewLab("lab", nil)
+	rack1 := NewRack("rack1", "row1")
+	rack2 := NewRack("rack2", "row2")
+
+	for _, res := range []zebra.Resource{lab, rack1, rack2} {
+		assert.Nil(t, s.Create(res))
+	}
+
+	tests := []struct {
+		name     string
+		query    zebra.Query
+		expected []string
+	}{
+		{"Equal", zebra.Query{Key: "Name", Op: zebra.MatchEqual, Values: []string{"lab"}}, []string{lab.ID}},
+		{"In", zebra.Query{Key: "Row", Op: zebra.MatchIn, Values: []string{"row1", "row2"}}, []string{rack1.ID, rack2.ID}},
+		{"NotEqual", zebra.Query{Key: "Name", Op: zebra.MatchNotEqual, Values: []string{"lab"}}, []string{rack1.ID, rack2.ID}},
+		{"NotIn", zebra.Query{Key: "Row", Op: zebra.MatchNotIn, Values: []string{"row1"}}, []string{lab.ID, rack2.ID}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			resMap, err := s.QueryProperty(test.query)
+			assert.Nil(err)
+			assert.ElementsMatch(test.expected, ids(resMap))
+		})
+	}
+}
+
+// testQueryUnder verifies that subtree queries find the resources under the
+// parents transitively, and follow the changes to the parents.
+func testQueryUnder(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	under := func(parent string) zebra.Labels {
+		return zebra.Labels{zebra.ParentLabel: parent}
+	}
+
+	// A room created after its racks takes them in
+	site := NewLab("west", nil)
+	room := NewLab("room", under(site.ID))
+	rack1 := NewLab("rack1", under(room.ID))
+	rack2 := NewLab("rack2", under(room.ID))
+	east := NewLab("east", nil)
+
+	for _, res := range []zebra.Resource{site, rack1, rack2, room, east} {
+		assert.Nil(s.Create(res))
+	}
+
+	query := func(parents ...string) []string {
+		resMap, err := s.QueryLabel(zebra.Query{Key: zebra.ParentLabel, Op: zebra.MatchUnder, Values: parents})
+		assert.Nil(err)
+
+		return ids(resMap)
+	}
+
+	assert.ElementsMatch([]string{room.ID, rack1.ID, rack2.ID}, query(site.ID))
+	assert.ElementsMatch([]string{rack1.ID, rack2.ID}, query(room.ID))
+	assert.Empty(query(east.ID))
+	assert.Empty(query(rack1.ID, "unknown"))
+
+	// Moving the room moves the racks with it
+	room.Labels[zebra.ParentLabel] = east.ID
+	assert.Nil(s.Create(room))
+	assert.Empty(query(site.ID))
+	assert.ElementsMatch([]string{room.ID, rack1.ID, rack2.ID}, query(east.ID))
+	assert.ElementsMatch([]string{room.ID, rack1.ID, rack2.ID}, query(site.ID, east.ID))
+
+	// Without the room, the racks are no longer under the site
+	assert.Nil(s.Delete(room))
+	assert.Empty(query(east.ID))
+	assert.ElementsMatch([]string{rack1.ID, rack2.ID}, query(room.ID))
+}
+
+func testQueryInvalid(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	invalid := []zebra.Query{
+		{Key: "color", Op: zebra.MatchEqual, Values: []string{"red", "blue"}},
+		{Key: "color", Op: zebra.MatchNotEqual, Values: nil},
+		{Key: "color", Op: zebra.MatchUnder + 1, Values: []string{"red"}},
+		{Key: "color", Op: zebra.MatchUnder, Values: []string{"red"}},
+		{Key: zebra.ParentLabel, Op: zebra.MatchUnder, Values: nil},
+	}
+
+	for _, q := range invalid {
+		_, err := s.QueryLabel(q)
+		assert.ErrorIs(err, zebra.ErrInvalidQuery)
+
+		_, err = s.QueryProperty(q)
+		assert.ErrorIs(err, zebra.ErrInvalidQuery)
+	}
+}
+
+// testQueryCopy verifies that changing a query result does not change the
+// store.
+func testQueryCopy(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	lab := NewLab("lab", nil)
+	assert.Nil(s.Create(lab))
+
+	resMap := s.Query()
+	resMap.Resources["Lab"].Resources = nil
+	delete(resMap.Resources, "Lab")
+
+	assert.Equal([]string{lab.ID}, ids(s.Query()))
+	assert.Equal([]string{lab.ID}, ids(s.QueryType([]string{"Lab"})))
+}
+
+func testConcurrency(t *testing.T, s zebra.Store) {
+	assert := assert.New(t)
+
+	errs := make(chan error, Concurrency)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < Concurrency; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			lab := NewLab(fmt.Sprintf("lab%d", i), zebra.Labels{"worker": fmt.Sprint(i)})
+			if err := s.Create(lab); err != nil {
+				errs <- err
+
+				return
+			}
+
+			_ = s.Query()
+			_ = s.QueryUUID([]string{lab.ID})
+
+			if _, err := s.QueryLabel(zebra.Query{
+				Key: "worker", Op: zebra.MatchEqual, Values: []string{fmt.Sprint(i)},
+			}); err != nil {
+				errs <- err
+
+				return
+			}
+
+			// Odd workers remove their resource again
+			if i%2 == 1 {
+				errs <- s.Delete(lab)
+
+				return
+			}
+
+			errs <- nil
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(err)
+	}
+
+	assert.Len(ids(s.Query()), Concurrency/2)
+}
+
+func testPersist(t *testing.T, suite Suite) {
+	assert := assert.New(t)
+	s := suite.New(t)
+
+	lab := NewLab("lab", zebra.Labels{"color": "red"})
+	rack := NewRack("rack", "row")
+	deleted := NewLab("deleted", nil)
+
+	for _, res := range []zebra.Resource{lab, rack, deleted} {
+		assert.Nil(s.Create(res))
+	}
+
+	assert.Nil(s.Delete(deleted))
+
+	reopened := suite.Reopen(t, s)
+	assert.ElementsMatch([]string{lab.ID, rack.ID}, ids(reopened.Query()))
+
+	red, err := reopened.QueryLabel(zebra.Query{Key: "color", Op: zebra.MatchEqual, Values: []string{"red"}})
+	assert.Nil(err)
+	assert.Equal([]string{lab.ID}, ids(red))
+}
+
+// Fuzz fuzzes the store with resources of arbitrary name and label, each
+// resource must be found by id, label and property after it is created and
+// be gone after it is deleted.
+func Fuzz(f *testing.F, newStore func(t *testing.T) zebra.Store) {
+	f.Helper()
+
+	f.Add("lab", "color", "red")
+	f.Add("", "color", "red")
+	f.Add("lab", "", "")
+	f.Add("lab", "system.group", "other")
+	f.Add("l a b", "key with spaces", "value\nwith\nlines")
+	f.Add("ラボ", "ключ", "値")
+
+	f.Fuzz(func(t *testing.T, name string, key string, value string) {
+		assert := assert.New(t)
+		s := newStore(t)
+
+		lab := NewLab(name, zebra.Labels{key: value})
+		if err := s.Create(lab); err != nil {
+			assert.ErrorIs(err, zebra.ErrInvalidResource)
+			assert.Empty(ids(s.Query()))
+
+			return
+		}
+
+		assert.Equal([]string{lab.ID}, ids(s.QueryUUID([]string{lab.ID})))
+
+		labeled, err := s.QueryLabel(zebra.Query{Key: key, Op: zebra.MatchEqual, Values: []string{value}})
+		assert.Nil(err)
+		assert.Equal([]string{lab.ID}, ids(labeled))
+
+		named, err := s.QueryProperty(zebra.Query{Key: "Name", Op: zebra.MatchEqual, Values: []string{name}})
+		assert.Nil(err)
+		assert.Equal([]string{lab.ID}, ids(named))
+
+		assert.Nil(s.Delete(lab))
+		assert.Empty(ids(s.Query()))
+	})
+}
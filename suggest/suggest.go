@@ -0,0 +1,173 @@
+// Package suggest finds the terms starting with a prefix, such as the names
+// and labels of resources as a user types them into a search box, without
+// scanning all the terms.
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// node is a node of the trie, with the term ending at it if it has IDs.
+type node struct {
+	children map[byte]*node
+	term     string
+	ids      map[string]struct{}
+}
+
+func newNode() *node {
+	return &node{children: map[byte]*node{}, term: "", ids: nil}
+}
+
+// Trie is a thread safe prefix tree of terms, each with the IDs of the things
+// it refers to. Terms are matched regardless of case and returned as first
+// added.
+type Trie struct {
+	lock  sync.RWMutex
+	root  *node
+	terms int
+}
+
+// NewTrie returns an empty trie.
+func NewTrie() *Trie {
+	return &Trie{lock: sync.RWMutex{}, root: newNode(), terms: 0}
+}
+
+// Add adds the ID to the term.
+func (t *Trie) Add(term string, id string) {
+	if term == "" {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	n := t.root
+	key := strings.ToLower(term)
+
+	for i := 0; i < len(key); i++ {
+		child, ok := n.children[key[i]]
+		if !ok {
+			child = newNode()
+			n.children[key[i]] = child
+		}
+
+		n = child
+	}
+
+	if n.ids == nil {
+		n.ids = map[string]struct{}{}
+		n.term = term
+		t.terms++
+	}
+
+	n.ids[id] = struct{}{}
+}
+
+// Remove removes the ID from the term, and the term once it has no IDs left.
+func (t *Trie) Remove(term string, id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := strings.ToLower(term)
+	path := make([]*node, 0, len(key)+1)
+	n := t.root
+
+	for i := 0; i < len(key); i++ {
+		path = append(path, n)
+
+		if n = n.children[key[i]]; n == nil {
+			return
+		}
+	}
+
+	if _, ok := n.ids[id]; !ok {
+		return
+	}
+
+	delete(n.ids, id)
+
+	if len(n.ids) != 0 {
+		return
+	}
+
+	n.ids = nil
+	n.term = ""
+	t.terms--
+
+	// Nodes that lead to no term are pruned
+	for i := len(path) - 1; i >= 0 && n.ids == nil && len(n.children) == 0; i-- {
+		delete(path[i].children, key[i])
+		n = path[i]
+	}
+}
+
+// Len returns the number of terms.
+func (t *Trie) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.terms
+}
+
+// Walk calls fn with the terms starting with the prefix, shortest first and
+// in byte order for terms of the same length, and the IDs of each term in
+// order, until fn returns false.
+func (t *Trie) Walk(prefix string, fn func(term string, ids []string) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	n := t.root
+	key := strings.ToLower(prefix)
+
+	for i := 0; i < len(key) && n != nil; i++ {
+		n = n.children[key[i]]
+	}
+
+	if n == nil {
+		return
+	}
+
+	// Breadth first, so that the closest completions come first
+	for level := []*node{n}; len(level) != 0; {
+		next := []*node{}
+
+		for _, cur := range level {
+			if cur.ids != nil && !fn(cur.term, sortedIDs(cur.ids)) {
+				return
+			}
+
+			next = append(next, sortedChildren(cur)...)
+		}
+
+		level = next
+	}
+}
+
+func sortedIDs(ids map[string]struct{}) []string {
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func sortedChildren(n *node) []*node {
+	keys := make([]int, 0, len(n.children))
+	for k := range n.children {
+		keys = append(keys, int(k))
+	}
+
+	sort.Ints(keys)
+
+	children := make([]*node, 0, len(keys))
+	for _, k := range keys {
+		children = append(children, n.children[byte(k)])
+	}
+
+	return children
+}
@@ -0,0 +1,55 @@
+package suggest_test
+
+import (
+	"testing"
+
+	"github.com/project-safari/zebra/suggest"
+	"github.com/stretchr/testify/assert"
+)
+
+func walk(t *suggest.Trie, prefix string, limit int) []string {
+	terms := []string{}
+
+	t.Walk(prefix, func(term string, ids []string) bool {
+		terms = append(terms, term)
+
+		return len(terms) < limit
+	})
+
+	return terms
+}
+
+func TestTrie(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	trie := suggest.NewTrie()
+	trie.Add("", "x")
+	trie.Add("rack-10", "r10")
+	trie.Add("rack-1", "r1")
+	trie.Add("Rack-2", "r2")
+	trie.Add("rack-2", "r2b")
+	trie.Add("server", "s1")
+
+	assert.Equal(4, trie.Len())
+	assert.Equal([]string{"rack-1", "Rack-2", "rack-10"}, walk(trie, "RA", 10))
+	assert.Equal([]string{"rack-1", "Rack-2"}, walk(trie, "rack", 2))
+	assert.Empty(walk(trie, "vm", 10))
+	assert.Equal(4, len(walk(trie, "", 10)))
+
+	trie.Walk("rack-2", func(term string, ids []string) bool {
+		assert.Equal([]string{"r2", "r2b"}, ids)
+
+		return true
+	})
+
+	trie.Remove("rack-2", "r2")
+	trie.Remove("rack-2", "missing")
+	trie.Remove("rack-3", "r3")
+	assert.Equal([]string{"Rack-2"}, walk(trie, "rack-2", 10))
+
+	trie.Remove("rack-2", "r2b")
+	trie.Remove("rack-10", "r10")
+	assert.Equal(2, trie.Len())
+	assert.Equal([]string{"rack-1"}, walk(trie, "rack", 10))
+}
@@ -0,0 +1,200 @@
+// Package syslog parses the syslog messages devices send, in the format of
+// RFC 5424 or the older BSD format of RFC 3164.
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPriority  = 191
+	severities   = 8
+	nilValue     = "-"
+	bsdStampLen  = len(time.Stamp)
+	version5424  = "1 "
+	byteOrderBOM = "\xef\xbb\xbf"
+)
+
+// Severities of syslog messages, from the most severe.
+const (
+	Emergency = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+var ErrMalformed = errors.New("malformed syslog message")
+
+// severityNames are the names of the severities, by value.
+var severityNames = []string{ //nolint:gochecknoglobals
+	"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug",
+}
+
+// Message is a parsed syslog message. Fields the message does not have are
+// empty, the time is the time of receipt if the message has none.
+type Message struct {
+	Facility int       `json:"facility"`
+	Severity int       `json:"severity"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	App      string    `json:"app,omitempty"`
+	Text     string    `json:"text"`
+}
+
+// SeverityName returns the name of the severity of the message, such as
+// "error".
+func (m *Message) SeverityName() string {
+	return severityNames[m.Severity]
+}
+
+// Severity returns the severity with the given name.
+func Severity(name string) (int, bool) {
+	for i, n := range severityNames {
+		if n == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Parse parses a syslog message received at the given time.
+func Parse(data []byte, received time.Time) (*Message, error) {
+	line := string(bytes.TrimRight(data, "\r\n\x00"))
+
+	end := strings.IndexByte(line, '>')
+	if !strings.HasPrefix(line, "<") || end < 2 || end > 4 { //nolint:gomnd
+		return nil, ErrMalformed
+	}
+
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > maxPriority {
+		return nil, ErrMalformed
+	}
+
+	m := &Message{Facility: pri / severities, Severity: pri % severities, Time: received, Hostname: "", App: "", Text: ""}
+	line = line[end+1:]
+
+	if strings.HasPrefix(line, version5424) {
+		err = m.parse5424(line[len(version5424):])
+	} else {
+		m.parse3164(line)
+	}
+
+	return m, err
+}
+
+// parse5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG].
+func (m *Message) parse5424(line string) error {
+	fields := strings.SplitN(line, " ", 6) //nolint:gomnd
+	if len(fields) < 6 {                   //nolint:gomnd
+		return ErrMalformed
+	}
+
+	if fields[0] != nilValue {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return ErrMalformed
+		}
+
+		m.Time = t
+	}
+
+	m.Hostname = value(fields[1])
+	m.App = value(fields[2])
+
+	rest, err := skipStructuredData(fields[5])
+	if err != nil {
+		return err
+	}
+
+	m.Text = strings.TrimPrefix(strings.TrimPrefix(rest, " "), byteOrderBOM)
+
+	return nil
+}
+
+// skipStructuredData returns what follows the structured data elements.
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, nilValue) {
+		return s[1:], nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		quoted, escaped, end := false, false, -1
+
+		for i, c := range s {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				quoted = !quoted
+			case c == ']' && !quoted:
+				end = i
+			}
+
+			if end >= 0 {
+				break
+			}
+		}
+
+		if end < 0 {
+			return "", ErrMalformed
+		}
+
+		s = s[end+1:]
+	}
+
+	return s, nil
+}
+
+// parse3164 parses the BSD format, TIMESTAMP HOSTNAME TAG: MSG, where every
+// part may be missing.
+func (m *Message) parse3164(line string) {
+	if len(line) > bsdStampLen && line[bsdStampLen] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, line[:bsdStampLen], time.Local); err == nil {
+			// The year is left out, the message is from the last year
+			t = t.AddDate(m.Time.Year(), 0, 0)
+			if t.After(m.Time.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+
+			m.Time = t
+			line = line[bsdStampLen+1:]
+
+			if host, rest, ok := strings.Cut(line, " "); ok && !isTag(host) {
+				m.Hostname = host
+				line = rest
+			}
+		}
+	}
+
+	if tag, rest, ok := strings.Cut(line, " "); ok && isTag(tag) {
+		m.App, _, _ = strings.Cut(strings.TrimSuffix(tag, ":"), "[")
+		line = rest
+	}
+
+	m.Text = line
+}
+
+// isTag returns true for a tag such as "sshd[42]:".
+func isTag(s string) bool {
+	return strings.HasSuffix(s, ":") && len(s) > 1
+}
+
+func value(s string) string {
+	if s == nilValue {
+		return ""
+	}
+
+	return s
+}
@@ -0,0 +1,93 @@
+package syslog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/syslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse5424(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	m, err := syslog.Parse([]byte(`<165>1 2022-05-31T22:14:15.003Z sw1.lab.example evntslog - ID47 `+
+		`[exampleSDID@32473 iut="3" eventID="1011" note="a \"]\" in a value"][origin ip="10.0.0.5"] `+
+		"\xef\xbb\xbfAn application event\n"), now)
+	assert.Nil(err)
+	assert.Equal(20, m.Facility)
+	assert.Equal(syslog.Notice, m.Severity)
+	assert.Equal("notice", m.SeverityName())
+	assert.Equal(time.Date(2022, time.May, 31, 22, 14, 15, 3000000, time.UTC), m.Time.UTC())
+	assert.Equal("sw1.lab.example", m.Hostname)
+	assert.Equal("evntslog", m.App)
+	assert.Equal("An application event", m.Text)
+
+	m, err = syslog.Parse([]byte("<34>1 - - - - - -"), now)
+	assert.Nil(err)
+	assert.Equal(syslog.Critical, m.Severity)
+	assert.Equal(now, m.Time)
+	assert.Empty(m.Hostname)
+	assert.Empty(m.Text)
+
+	for _, data := range []string{
+		"<34>1 yesterday host app - - - hello",
+		"<34>1 - host app - - [open hello",
+		"<34>1 - host app",
+	} {
+		_, err := syslog.Parse([]byte(data), now)
+		assert.ErrorIs(err, syslog.ErrMalformed, data)
+	}
+}
+
+func TestParse3164(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	now := time.Date(2022, time.January, 1, 0, 0, 10, 0, time.Local)
+
+	m, err := syslog.Parse([]byte("<187>Dec 31 23:59:58 sw1 %LINK-3-UPDOWN: Interface Gi1/0/3, changed state to down"),
+		now)
+	assert.Nil(err)
+	assert.Equal(23, m.Facility)
+	assert.Equal(syslog.Error, m.Severity)
+	assert.Equal(time.Date(2021, time.December, 31, 23, 59, 58, 0, time.Local), m.Time)
+	assert.Equal("sw1", m.Hostname)
+	assert.Equal("%LINK-3-UPDOWN", m.App)
+	assert.Equal("Interface Gi1/0/3, changed state to down", m.Text)
+
+	m, err = syslog.Parse([]byte("<13>Jan  1 00:00:05 10.0.0.5 sshd[42]: session opened"), now)
+	assert.Nil(err)
+	assert.Equal(2022, m.Time.Year())
+	assert.Equal("10.0.0.5", m.Hostname)
+	assert.Equal("sshd", m.App)
+	assert.Equal("session opened", m.Text)
+
+	// Neither timestamp nor hostname
+	m, err = syslog.Parse([]byte("<13>kernel: out of memory"), now)
+	assert.Nil(err)
+	assert.Equal(now, m.Time)
+	assert.Empty(m.Hostname)
+	assert.Equal("kernel", m.App)
+	assert.Equal("out of memory", m.Text)
+
+	for _, data := range []string{"", "hello", "<>hello", "<192>hello", "<1x>hello", "<12345>hello"} {
+		_, err := syslog.Parse([]byte(data), now)
+		assert.ErrorIs(err, syslog.ErrMalformed, data)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	severity, ok := syslog.Severity("warning")
+	assert.True(ok)
+	assert.Equal(syslog.Warning, severity)
+
+	_, ok = syslog.Severity("loud")
+	assert.False(ok)
+}
@@ -0,0 +1,272 @@
+// Package timeseries keeps values sampled over time, such as the leased and
+// free resources of each type, compactly enough to chart months of them
+// without an external time-series database. Every series is kept in tiers of
+// fixed-size buckets, each a sum and a count of the samples that fell in it:
+// recent samples at a fine resolution, older ones averaged into hourly and
+// daily buckets. A tier only keeps its last buckets, so that the size of a
+// series is bounded however long it is sampled.
+package timeseries
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const RW = os.FileMode(0o600)
+
+var ErrRange = errors.New("time range must end after it starts, with a positive step")
+
+// Tier is a resolution of the series: samples are averaged into buckets of
+// Step, the last Buckets of which are kept.
+type Tier struct {
+	Step    time.Duration `json:"step"`
+	Buckets int           `json:"buckets"`
+}
+
+// DefaultTiers keep 5 minute buckets for a day, hourly ones for 30 days and
+// daily ones for 3 years.
+func DefaultTiers() []Tier {
+	return []Tier{
+		{Step: 5 * time.Minute, Buckets: 288}, //nolint:gomnd
+		{Step: time.Hour, Buckets: 720},       //nolint:gomnd
+		{Step: 24 * time.Hour, Buckets: 1096}, //nolint:gomnd
+	}
+}
+
+// Point is the average of the samples of a bucket starting at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// buckets is a tier of a series. First is the number of the first bucket,
+// counted in steps since the epoch, the sums and counts of the following
+// ones are kept in order. Empty buckets are counted 0. Dropped tells whether
+// buckets were dropped, whether older samples are only in coarser tiers.
+type buckets struct {
+	First   int64     `json:"first"`
+	Sums    []float64 `json:"sums"`
+	Counts  []uint32  `json:"counts"`
+	Dropped bool      `json:"dropped,omitempty"`
+}
+
+// add adds the sample to its bucket, dropping the buckets beyond the limit.
+// Samples older than the first bucket are dropped.
+func (b *buckets) add(n int64, value float64, limit int) {
+	if len(b.Sums) != 0 && n-b.First >= 2*int64(limit) {
+		b.Sums, b.Counts, b.Dropped = []float64{}, []uint32{}, true
+	}
+
+	if len(b.Sums) == 0 {
+		b.First = n
+	}
+
+	if n < b.First {
+		return
+	}
+
+	for int64(len(b.Sums)) <= n-b.First {
+		b.Sums = append(b.Sums, 0)
+		b.Counts = append(b.Counts, 0)
+	}
+
+	b.Sums[n-b.First] += value
+	b.Counts[n-b.First]++
+
+	if over := len(b.Sums) - limit; over > 0 {
+		b.First += int64(over)
+		b.Sums = append([]float64{}, b.Sums[over:]...)
+		b.Counts = append([]uint32{}, b.Counts[over:]...)
+		b.Dropped = true
+	}
+}
+
+// reaches returns true if the buckets hold the samples since the time, if
+// any were taken.
+func (b *buckets) reaches(since time.Time, step time.Duration) bool {
+	return !b.Dropped || since.UnixNano() >= b.First*int64(step)
+}
+
+// DB is a thread safe set of series by name. If a path is given, the series
+// are written to that file on every sample.
+type DB struct {
+	lock   sync.RWMutex
+	path   string
+	tiers  []Tier
+	series map[string][]*buckets
+}
+
+// NewDB returns a DB of series of the tiers, finest first, backed by the file
+// at path. An empty path results in an in-memory DB.
+func NewDB(path string, tiers []Tier) *DB {
+	sorted := append([]Tier{}, tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Step < sorted[j].Step })
+
+	return &DB{
+		lock:   sync.RWMutex{},
+		path:   path,
+		tiers:  sorted,
+		series: map[string][]*buckets{},
+	}
+}
+
+// Initialize loads the series from the backing file, if any. Series of other
+// tiers than those of the DB are dropped.
+func (db *DB) Initialize() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(db.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	loaded := map[string][]*buckets{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+
+	for name, tiers := range loaded {
+		if len(tiers) == len(db.tiers) {
+			db.series[name] = tiers
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(db.series)
+	if err != nil {
+		return err
+	}
+
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, data, RW); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, db.path)
+}
+
+// Record adds the samples taken at the time, by series name, in one write.
+func (db *DB) Record(at time.Time, samples map[string]float64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for name, value := range samples {
+		tiers, ok := db.series[name]
+		if !ok {
+			tiers = make([]*buckets, 0, len(db.tiers))
+			for range db.tiers {
+				tiers = append(tiers, &buckets{First: 0, Sums: []float64{}, Counts: []uint32{}, Dropped: false})
+			}
+
+			db.series[name] = tiers
+		}
+
+		for i, t := range db.tiers {
+			tiers[i].add(bucket(at, t.Step), value, t.Buckets)
+		}
+	}
+
+	return db.save()
+}
+
+// Names returns the names of the series, in order.
+func (db *DB) Names() []string {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	names := make([]string, 0, len(db.series))
+	for name := range db.series {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Query returns the points of the series in the range, from included, in
+// buckets of at least the step. The finest tier that holds the samples since
+// the start of the range is read, the coarsest one if none does, and its
+// buckets are averaged into buckets of the step if that is coarser. The step
+// of the points is returned with them, empty buckets have no point.
+func (db *DB) Query(name string, from time.Time, to time.Time, step time.Duration,
+) ([]Point, time.Duration, error) {
+	if !to.After(from) || step <= 0 {
+		return nil, 0, ErrRange
+	}
+
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	points := []Point{}
+
+	tiers, ok := db.series[name]
+	if !ok || len(tiers) == 0 {
+		return points, step, nil
+	}
+
+	i := len(tiers) - 1
+
+	for j, b := range tiers {
+		if b.reaches(from, db.tiers[j].Step) {
+			i = j
+
+			break
+		}
+	}
+
+	b, tierStep := tiers[i], db.tiers[i].Step
+	if step < tierStep {
+		step = tierStep
+	}
+
+	sums, counts := map[int64]float64{}, map[int64]uint32{}
+	keys := []int64{}
+
+	for j, count := range b.Counts {
+		start := time.Unix(0, (b.First+int64(j))*int64(tierStep))
+		if count == 0 || start.Before(from) || !start.Before(to) {
+			continue
+		}
+
+		k := bucket(start, step)
+		if _, ok := counts[k]; !ok {
+			keys = append(keys, k)
+		}
+
+		sums[k] += b.Sums[j]
+		counts[k] += count
+	}
+
+	for _, k := range keys {
+		points = append(points, Point{
+			Time:  time.Unix(0, k*int64(step)).UTC(),
+			Value: sums[k] / float64(counts[k]),
+		})
+	}
+
+	return points, step, nil
+}
+
+// bucket returns the number of the bucket of the step the time falls in.
+func bucket(at time.Time, step time.Duration) int64 {
+	return at.UnixNano() / int64(step)
+}
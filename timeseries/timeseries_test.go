@@ -0,0 +1,115 @@
+package timeseries_test
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/project-safari/zebra/timeseries"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordQuery(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tiers := []timeseries.Tier{{Step: time.Hour, Buckets: 4}, {Step: time.Minute, Buckets: 10}}
+	db := timeseries.NewDB("", tiers)
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// Two samples a minute for 30 minutes
+	for i := 0; i < 60; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		assert.Nil(db.Record(at, map[string]float64{"leased": float64(i % 2), "free": 1}))
+	}
+
+	assert.Equal([]string{"free", "leased"}, db.Names())
+
+	// The last 10 minutes are in minute buckets
+	points, step, err := db.Query("leased", start.Add(20*time.Minute), start.Add(time.Hour), time.Second)
+	assert.Nil(err)
+	assert.Equal(time.Minute, step)
+	assert.Len(points, 10)
+	assert.Equal(start.Add(20*time.Minute), points[0].Time)
+	assert.Equal(0.5, points[0].Value)
+
+	// Wider steps average the buckets
+	points, step, err = db.Query("leased", start.Add(20*time.Minute), start.Add(time.Hour), 5*time.Minute)
+	assert.Nil(err)
+	assert.Equal(5*time.Minute, step)
+	assert.Len(points, 2)
+
+	// Earlier samples are only in the hourly buckets
+	points, step, err = db.Query("free", start, start.Add(time.Hour), time.Minute)
+	assert.Nil(err)
+	assert.Equal(time.Hour, step)
+	assert.Equal([]timeseries.Point{{Time: start, Value: 1}}, points)
+
+	points, _, err = db.Query("unknown", start, start.Add(time.Hour), time.Minute)
+	assert.Nil(err)
+	assert.Empty(points)
+
+	_, _, err = db.Query("free", start, start, time.Minute)
+	assert.ErrorIs(err, timeseries.ErrRange)
+
+	_, _, err = db.Query("free", start, start.Add(time.Hour), 0)
+	assert.ErrorIs(err, timeseries.ErrRange)
+}
+
+func TestBoundedSize(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	db := timeseries.NewDB("", []timeseries.Tier{{Step: time.Hour, Buckets: 24}})
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// A month of samples keeps the last day, a long gap starts over
+	for i := 0; i < 30*24; i++ {
+		assert.Nil(db.Record(start.Add(time.Duration(i)*time.Hour), map[string]float64{"s": float64(i)}))
+	}
+
+	end := start.Add(30 * 24 * time.Hour)
+
+	points, _, err := db.Query("s", start, end, time.Hour)
+	assert.Nil(err)
+	assert.Len(points, 24)
+	assert.Equal(float64(30*24-1), points[23].Value)
+
+	assert.Nil(db.Record(end.Add(365*24*time.Hour), map[string]float64{"s": 1}))
+
+	points, _, err = db.Query("s", start, end.Add(366*24*time.Hour), time.Hour)
+	assert.Nil(err)
+	assert.Len(points, 1)
+
+	// Samples older than the kept buckets are dropped
+	assert.Nil(db.Record(start, map[string]float64{"s": 1}))
+
+	points, _, err = db.Query("s", start, end.Add(366*24*time.Hour), time.Hour)
+	assert.Nil(err)
+	assert.Len(points, 1)
+}
+
+func TestPersist(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	file := path.Join(t.TempDir(), "utilization.json")
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	db := timeseries.NewDB(file, timeseries.DefaultTiers())
+	assert.Nil(db.Initialize())
+	assert.Nil(db.Record(start, map[string]float64{"s": 2}))
+
+	reopened := timeseries.NewDB(file, timeseries.DefaultTiers())
+	assert.Nil(reopened.Initialize())
+
+	points, step, err := reopened.Query("s", start, start.Add(time.Hour), time.Minute)
+	assert.Nil(err)
+	assert.Equal(5*time.Minute, step)
+	assert.Equal([]timeseries.Point{{Time: start, Value: 2}}, points)
+
+	// Series of other tiers are dropped
+	other := timeseries.NewDB(file, []timeseries.Tier{{Step: time.Hour, Buckets: 1}})
+	assert.Nil(other.Initialize())
+	assert.Empty(other.Names())
+}
@@ -92,6 +92,19 @@ func (ts *TypeStore) Query(types []string) *zebra.ResourceMap {
 
 // Find given resource in TypeStore. If not found, return nil and error.
 // If found, return resource and nil.
+// Cardinality returns the number of resources of the given types.
+func (ts *TypeStore) Cardinality(types []string) int {
+	count := 0
+
+	for t, l := range ts.resources.Resources {
+		if zebra.IsIn(t, types) {
+			count += len(l.Resources)
+		}
+	}
+
+	return count
+}
+
 func (ts *TypeStore) find(resID string, resType string) (zebra.Resource, error) {
 	resMap := ts.resources.Resources[resType]
 	if resMap == nil {
@@ -5,6 +5,7 @@ import (
 
 	"github.com/project-safari/zebra"
 	"github.com/project-safari/zebra/network"
+	"github.com/project-safari/zebra/storetest"
 	"github.com/project-safari/zebra/typestore"
 	"github.com/stretchr/testify/assert"
 )
@@ -182,3 +183,16 @@ func getVLAN() *network.VLANPool {
 		RangeEnd:     1,
 	}
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.RunBasic(t, func(t *testing.T) storetest.BasicStore {
+		t.Helper()
+
+		s := typestore.NewTypeStore(zebra.NewResourceMap(storetest.Factory()))
+		assert.Nil(t, s.Initialize())
+
+		return s
+	})
+}
@@ -0,0 +1,217 @@
+// Package xlsx writes simple spreadsheets in the Office Open XML format read
+// by Excel, LibreOffice and Google Sheets.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxSheetName is the maximum length of a sheet name.
+const MaxSheetName = 31
+
+var (
+	ErrNoSheets  = errors.New("workbook has no sheets")
+	ErrSheetName = errors.New("invalid sheet name")
+)
+
+// Sheet is a named table of cells, the first row is usually the header.
+// Cells that hold a number are written as numbers, all others as text.
+type Sheet struct {
+	Name string
+	Rows [][]string
+}
+
+func (s *Sheet) validate() error {
+	if s.Name == "" || len(s.Name) > MaxSheetName || strings.ContainsAny(s.Name, `[]:*?/\`) {
+		return fmt.Errorf("%w: %q", ErrSheetName, s.Name)
+	}
+
+	return nil
+}
+
+// part is a file of the workbook package.
+type part struct {
+	name  string
+	write func(io.Writer) error
+}
+
+// Write writes a workbook with the sheets to w.
+func Write(w io.Writer, sheets ...Sheet) error {
+	if len(sheets) == 0 {
+		return ErrNoSheets
+	}
+
+	for i := range sheets {
+		if err := sheets[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	zw := zip.NewWriter(w)
+
+	parts := []part{
+		{"[Content_Types].xml", func(w io.Writer) error { return writeContentTypes(w, len(sheets)) }},
+		{"_rels/.rels", writeRootRels},
+		{"xl/workbook.xml", func(w io.Writer) error { return writeWorkbook(w, sheets) }},
+		{"xl/_rels/workbook.xml.rels", func(w io.Writer) error { return writeWorkbookRels(w, len(sheets)) }},
+	}
+
+	for i := range sheets {
+		sheet := sheets[i]
+		parts = append(parts, part{
+			name:  fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1),
+			write: func(w io.Writer) error { return writeSheet(w, sheet) },
+		})
+	}
+
+	for _, p := range parts {
+		fw, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+
+		if err := p.write(fw); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeContentTypes(w io.Writer, sheets int) error {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ` +
+		`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+
+	b.WriteString(`</Types>`)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func writeRootRels(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" `+
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" `+
+		`Target="xl/workbook.xml"/></Relationships>`)
+
+	return err
+}
+
+func writeWorkbook(w io.Writer, sheets []Sheet) error {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	for i, s := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(s.Name), i+1, i+1)
+	}
+
+	b.WriteString(`</sheets></workbook>`)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func writeWorkbookRels(w io.Writer, sheets int) error {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+
+	b.WriteString(`</Relationships>`)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func writeSheet(w io.Writer, sheet Sheet) error {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	for r, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+
+		for c, value := range row {
+			ref := ColumnName(c) + strconv.Itoa(r+1)
+
+			if isNumber(value) {
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, value)
+			} else {
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(value))
+			}
+		}
+
+		b.WriteString(`</row>`)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// ColumnName returns the name of the zero based column index, A to Z, then
+// AA and so on.
+func ColumnName(index int) string {
+	name := ""
+
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name //nolint:gomnd
+		index = index/26 - 1                     //nolint:gomnd
+	}
+
+	return name
+}
+
+// isNumber returns true if the value is written the way a number is
+// formatted, so that identifiers such as "007" stay text.
+func isNumber(value string) bool {
+	f, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64) == value
+}
+
+func escape(s string) string {
+	var b strings.Builder
+
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package xlsx_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/project-safari/zebra/xlsx"
+	"github.com/stretchr/testify/assert"
+)
+
+func readPart(assert *assert.Assertions, data []byte, name string) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.Nil(err)
+
+	f, err := zr.Open(name)
+	if !assert.Nil(err) {
+		return ""
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	assert.Nil(err)
+
+	return string(content)
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	err := xlsx.Write(buf,
+		xlsx.Sheet{Name: "Owners", Rows: [][]string{{"Owner", "Count"}, {"a&b@zebra", "12"}, {"007", "1.5"}}},
+		xlsx.Sheet{Name: "Empty", Rows: nil},
+	)
+	assert.Nil(err)
+
+	data := buf.Bytes()
+
+	assert.Contains(readPart(assert, data, "[Content_Types].xml"), "/xl/worksheets/sheet2.xml")
+	assert.Contains(readPart(assert, data, "xl/workbook.xml"), `<sheet name="Owners" sheetId="1" r:id="rId1"/>`)
+	assert.Contains(readPart(assert, data, "xl/_rels/workbook.xml.rels"), `Target="worksheets/sheet2.xml"`)
+
+	sheet := readPart(assert, data, "xl/worksheets/sheet1.xml")
+	assert.Contains(sheet, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">a&amp;b@zebra</t></is></c>`)
+	assert.Contains(sheet, `<c r="B2"><v>12</v></c>`)
+	assert.Contains(sheet, `<c r="A3" t="inlineStr"><is><t xml:space="preserve">007</t></is></c>`)
+	assert.Contains(sheet, `<c r="B3"><v>1.5</v></c>`)
+
+	assert.ErrorIs(xlsx.Write(buf), xlsx.ErrNoSheets)
+	assert.ErrorIs(xlsx.Write(buf, xlsx.Sheet{Name: "a/b", Rows: nil}), xlsx.ErrSheetName)
+	assert.ErrorIs(xlsx.Write(buf, xlsx.Sheet{Name: "", Rows: nil}), xlsx.ErrSheetName)
+}
+
+func TestColumnName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal("A", xlsx.ColumnName(0))
+	assert.Equal("Z", xlsx.ColumnName(25))
+	assert.Equal("AA", xlsx.ColumnName(26))
+	assert.Equal("AZ", xlsx.ColumnName(51))
+	assert.Equal("BA", xlsx.ColumnName(52))
+	assert.Equal("ZZ", xlsx.ColumnName(701))
+	assert.Equal("AAA", xlsx.ColumnName(702))
+}